@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Archive layout:
+//
+//	"ZBAK" | version (1 byte) | nonce prefix (8 bytes)
+//	then a sequence of chunks: flag (1 byte) | sealed length (uint32) | sealed data
+//
+// Every chunk is sealed with AES-256-GCM using nonce = prefix || chunk counter.
+// The flag byte marks the last chunk and is authenticated as additional data,
+// so truncating the file or reordering chunks fails decryption.
+const (
+	archiveMagic   = "ZBAK"
+	archiveVersion = 1
+	chunkSize      = 1 << 20
+	prefixSize     = 8
+)
+
+var (
+	ErrNotArchive     = errors.New("not a backup archive")
+	ErrArchiveVersion = errors.New("unsupported backup archive version")
+	ErrTruncated      = errors.New("backup archive is truncated")
+	ErrIntegrity      = errors.New("backup archive failed integrity check")
+)
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("backup key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, counter uint32) []byte {
+	nonce := make([]byte, prefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], counter)
+	return nonce
+}
+
+type archiveWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+func newArchiveWriter(w io.Writer, key []byte) (*archiveWriter, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, prefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce prefix: %w", err)
+	}
+
+	header := append([]byte(archiveMagic), archiveVersion)
+	header = append(header, prefix...)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &archiveWriter{
+		w:      w,
+		gcm:    gcm,
+		prefix: prefix,
+		buf:    make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (a *archiveWriter) Write(p []byte) (int, error) {
+	if a.closed {
+		return 0, errors.New("write to closed archive")
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		space := chunkSize - len(a.buf)
+		if space > len(p) {
+			space = len(p)
+		}
+		a.buf = append(a.buf, p[:space]...)
+		p = p[space:]
+
+		if len(a.buf) == chunkSize {
+			if err := a.flush(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close seals whatever is buffered as the final chunk. It does not close the
+// underlying writer.
+func (a *archiveWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	return a.flush(true)
+}
+
+func (a *archiveWriter) flush(final bool) error {
+	if a.counter == ^uint32(0) {
+		return errors.New("backup archive too large")
+	}
+
+	flag := []byte{0}
+	if final {
+		flag[0] = 1
+	}
+
+	sealed := a.gcm.Seal(nil, chunkNonce(a.prefix, a.counter), a.buf, flag)
+
+	var hdr [5]byte
+	hdr[0] = flag[0]
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(sealed)))
+	if _, err := a.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := a.w.Write(sealed); err != nil {
+		return err
+	}
+
+	a.counter++
+	a.buf = a.buf[:0]
+	return nil
+}
+
+type archiveReader struct {
+	r       io.Reader
+	gcm     cipher.AEAD
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	done    bool
+}
+
+func newArchiveReader(r io.Reader, key []byte) (*archiveReader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(archiveMagic)+1+prefixSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrNotArchive
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, ErrNotArchive
+	}
+	if header[len(archiveMagic)] != archiveVersion {
+		return nil, ErrArchiveVersion
+	}
+
+	return &archiveReader{
+		r:      r,
+		gcm:    gcm,
+		prefix: header[len(archiveMagic)+1:],
+	}, nil
+}
+
+func (a *archiveReader) Read(p []byte) (int, error) {
+	for len(a.buf) == 0 {
+		if a.done {
+			return 0, io.EOF
+		}
+		if err := a.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, a.buf)
+	a.buf = a.buf[n:]
+	return n, nil
+}
+
+func (a *archiveReader) next() error {
+	var hdr [5]byte
+	if _, err := io.ReadFull(a.r, hdr[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	size := binary.BigEndian.Uint32(hdr[1:])
+	if size > chunkSize+uint32(a.gcm.Overhead()) || hdr[0] > 1 {
+		return ErrIntegrity
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(a.r, sealed); err != nil {
+		return ErrTruncated
+	}
+
+	plain, err := a.gcm.Open(nil, chunkNonce(a.prefix, a.counter), sealed, hdr[:1])
+	if err != nil {
+		return ErrIntegrity
+	}
+
+	a.counter++
+	a.buf = plain
+	a.done = hdr[0] == 1
+	return nil
+}
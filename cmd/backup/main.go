@@ -0,0 +1,521 @@
+// Command backup creates, verifies and restores encrypted Zentra backups.
+//
+//	backup create  -out zentra.zbak [-include-key]
+//	backup verify  -in zentra.zbak
+//	backup restore -in zentra.zbak [-skip-db] [-force] [-export-key key.hex]
+//
+// An archive holds a pg_dump of the database, a manifest of every object in
+// the MinIO buckets and metadata about the message encryption key, all sealed
+// with BACKUP_ENCRYPTION_KEY. Object data itself is not copied; use the bucket
+// manifests to check (or mirror) the storage side.
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/zentra/server/config"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	manifestName = "manifest.json"
+	databaseName = "database.dump"
+	keyName      = "encryption.key"
+	bucketPrefix = "buckets/"
+)
+
+type manifest struct {
+	Version   int               `json:"version"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Key       keyMetadata       `json:"key"`
+	Buckets   []string          `json:"buckets"`
+	Files     map[string]string `json:"files"` // entry name -> sha256
+}
+
+type keyMetadata struct {
+	Algorithm   string `json:"algorithm"`
+	Fingerprint string `json:"fingerprint"`
+	Included    bool   `json:"included"`
+}
+
+type objectEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	ContentType  string    `json:"contentType,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
+	backupKey, err := hex.DecodeString(cfg.Backup.Key)
+	if err != nil || len(backupKey) != 32 {
+		log.Fatal().Msg("BACKUP_ENCRYPTION_KEY must be set to 64 hex characters")
+	}
+
+	ctx := context.Background()
+	args := os.Args[2:]
+
+	switch os.Args[1] {
+	case "create":
+		err = runCreate(ctx, cfg, backupKey, args)
+	case "verify":
+		err = runVerify(backupKey, args)
+	case "restore":
+		err = runRestore(ctx, cfg, backupKey, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("Backup command failed")
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: backup <create|verify|restore> [flags]")
+}
+
+func runCreate(ctx context.Context, cfg *config.Config, backupKey []byte, args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	out := fs.String("out", fmt.Sprintf("zentra-%s.zbak", time.Now().UTC().Format("20060102-150405")), "archive path")
+	includeKey := fs.Bool("include-key", false, "store the message encryption key inside the archive")
+	pgDump := fs.String("pg-dump", "pg_dump", "pg_dump binary")
+	fs.Parse(args)
+
+	encKey, err := currentEncryptionKey(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	workDir, err := os.MkdirTemp("", "zentra-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	dumpPath := filepath.Join(workDir, databaseName)
+	log.Info().Msg("Dumping database")
+	cmd := exec.CommandContext(ctx, *pgDump, "--format=custom", "--no-owner", "--file="+dumpPath, "--dbname="+cfg.Database.URL)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_dump failed: %w", err)
+	}
+
+	mc, err := newMinioClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temp file next to the target so a failed run never leaves a
+	// half-written archive under the real name.
+	tmpPath := *out + ".partial"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer f.Close()
+
+	aw, err := newArchiveWriter(f, backupKey)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(aw)
+
+	m := &manifest{
+		Version:   archiveVersion,
+		CreatedAt: time.Now().UTC(),
+		Key: keyMetadata{
+			Algorithm:   "AES-256-GCM",
+			Fingerprint: encryption.KeyFingerprint(encKey),
+			Included:    *includeKey,
+		},
+		Files: make(map[string]string),
+	}
+
+	if m.Files[databaseName], err = addFile(tw, databaseName, dumpPath); err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets(cfg) {
+		log.Info().Str("bucket", bucket).Msg("Listing bucket objects")
+		objects, err := listBucket(ctx, mc, bucket)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(objects)
+		if err != nil {
+			return err
+		}
+		name := bucketPrefix + bucket + ".json"
+		if m.Files[name], err = addBytes(tw, name, data); err != nil {
+			return err
+		}
+		m.Buckets = append(m.Buckets, bucket)
+	}
+
+	if *includeKey {
+		if m.Files[keyName], err = addBytes(tw, keyName, []byte(hex.EncodeToString(encKey))); err != nil {
+			return err
+		}
+	}
+
+	// Manifest goes last so it can carry the checksums of everything before it.
+	manifestData, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := addBytes(tw, manifestName, manifestData); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, *out); err != nil {
+		return err
+	}
+
+	log.Info().Str("archive", *out).Str("keyFingerprint", m.Key.Fingerprint).Bool("keyIncluded", *includeKey).Msg("Backup complete")
+	return nil
+}
+
+func runVerify(backupKey []byte, args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	in := fs.String("in", "", "archive path")
+	fs.Parse(args)
+
+	if *in == "" {
+		return errors.New("-in is required")
+	}
+
+	workDir, err := os.MkdirTemp("", "zentra-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	m, err := extractArchive(*in, backupKey, workDir)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Time("createdAt", m.CreatedAt).Int("files", len(m.Files)).Str("keyFingerprint", m.Key.Fingerprint).Msg("Archive is intact")
+	return nil
+}
+
+func runRestore(ctx context.Context, cfg *config.Config, backupKey []byte, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	in := fs.String("in", "", "archive path")
+	skipDB := fs.Bool("skip-db", false, "do not restore the database dump")
+	force := fs.Bool("force", false, "restore even if ENCRYPTION_KEY does not match the archive")
+	exportKey := fs.String("export-key", "", "write the archived encryption key to this file (requires -include-key at backup time)")
+	pgRestore := fs.String("pg-restore", "pg_restore", "pg_restore binary")
+	fs.Parse(args)
+
+	if *in == "" {
+		return errors.New("-in is required")
+	}
+
+	workDir, err := os.MkdirTemp("", "zentra-restore-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	m, err := extractArchive(*in, backupKey, workDir)
+	if err != nil {
+		return err
+	}
+
+	if *exportKey != "" {
+		if !m.Key.Included {
+			return errors.New("archive does not contain the encryption key")
+		}
+		data, err := os.ReadFile(filepath.Join(workDir, keyName))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*exportKey, data, 0o600); err != nil {
+			return err
+		}
+		log.Info().Str("path", *exportKey).Msg("Exported encryption key, set it as ENCRYPTION_KEY before starting the gateway")
+	}
+
+	// Restoring ciphertext under a different key leaves every message unreadable,
+	// so refuse unless explicitly told otherwise.
+	encKey, err := currentEncryptionKey(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if fp := encryption.KeyFingerprint(encKey); fp != m.Key.Fingerprint {
+		if !*force {
+			return fmt.Errorf("ENCRYPTION_KEY fingerprint %s does not match archive %s (use -force to restore anyway)", fp, m.Key.Fingerprint)
+		}
+		log.Warn().Str("configured", fp).Str("archive", m.Key.Fingerprint).Msg("Encryption key mismatch, continuing because of -force")
+	}
+
+	if !*skipDB {
+		log.Info().Msg("Restoring database")
+		cmd := exec.CommandContext(ctx, *pgRestore, "--clean", "--if-exists", "--no-owner", "--dbname="+cfg.Database.URL, filepath.Join(workDir, databaseName))
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("pg_restore failed: %w", err)
+		}
+	}
+
+	mc, err := newMinioClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range m.Buckets {
+		data, err := os.ReadFile(filepath.Join(workDir, bucketPrefix+bucket+".json"))
+		if err != nil {
+			return err
+		}
+		var expected []objectEntry
+		if err := json.Unmarshal(data, &expected); err != nil {
+			return err
+		}
+
+		current, err := listBucket(ctx, mc, bucket)
+		if err != nil {
+			return err
+		}
+		present := make(map[string]string, len(current))
+		for _, obj := range current {
+			present[obj.Key] = obj.ETag
+		}
+
+		missing, changed := 0, 0
+		for _, obj := range expected {
+			etag, ok := present[obj.Key]
+			switch {
+			case !ok:
+				missing++
+			case etag != obj.ETag:
+				changed++
+			}
+		}
+
+		event := log.Info()
+		if missing > 0 || changed > 0 {
+			event = log.Warn()
+		}
+		event.Str("bucket", bucket).Int("expected", len(expected)).Int("missing", missing).Int("changed", changed).Msg("Checked bucket against manifest")
+	}
+
+	log.Info().Time("createdAt", m.CreatedAt).Msg("Restore complete")
+	return nil
+}
+
+// extractArchive decrypts the archive into dir and checks every entry against
+// the manifest checksums.
+func extractArchive(path string, backupKey []byte, dir string) (*manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ar, err := newArchiveReader(f, backupKey)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(ar)
+
+	sums := make(map[string]string)
+	var m *manifest
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !validEntryName(hdr.Name) {
+			return nil, fmt.Errorf("unexpected archive entry %q", hdr.Name)
+		}
+
+		if hdr.Name == manifestName {
+			m = &manifest{}
+			if err := json.NewDecoder(tr).Decode(m); err != nil {
+				return nil, fmt.Errorf("invalid manifest: %w", err)
+			}
+			continue
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			return nil, err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(out, h), tr)
+		out.Close()
+		if err != nil {
+			return nil, err
+		}
+		sums[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	// Drain to the final chunk so a cut-off archive is reported even if the
+	// tar stream happened to end cleanly.
+	if _, err := io.Copy(io.Discard, ar); err != nil {
+		return nil, err
+	}
+
+	if m == nil {
+		return nil, errors.New("archive has no manifest")
+	}
+	if len(sums) != len(m.Files) {
+		return nil, ErrIntegrity
+	}
+	for name, sum := range m.Files {
+		if sums[name] != sum {
+			return nil, fmt.Errorf("%w: checksum mismatch for %s", ErrIntegrity, name)
+		}
+	}
+
+	return m, nil
+}
+
+func validEntryName(name string) bool {
+	switch name {
+	case manifestName, databaseName, keyName:
+		return true
+	}
+	if !strings.HasPrefix(name, bucketPrefix) || !strings.HasSuffix(name, ".json") {
+		return false
+	}
+	bucket := strings.TrimSuffix(strings.TrimPrefix(name, bucketPrefix), ".json")
+	return bucket != "" && !strings.ContainsAny(bucket, `/\`) && bucket != "." && bucket != ".."
+}
+
+func addFile(tw *tar.Writer, name, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func addBytes(tw *tar.Writer, name string, data []byte) (string, error) {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), ModTime: time.Now()}); err != nil {
+		return "", err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func buckets(cfg *config.Config) []string {
+	return []string{cfg.Storage.BucketAttachments, cfg.Storage.BucketAvatars, cfg.Storage.BucketCommunity}
+}
+
+// newMinioClient skips storage.ConnectMinIO on purpose, that one creates
+// buckets and rewrites policies which a backup should never do.
+func newMinioClient(cfg *config.Config) (*minio.Client, error) {
+	return minio.New(cfg.Storage.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.Storage.AccessKey, cfg.Storage.SecretKey, ""),
+		Secure: cfg.Storage.UseSSL,
+	})
+}
+
+func listBucket(ctx context.Context, mc *minio.Client, bucket string) ([]objectEntry, error) {
+	objects := make([]objectEntry, 0)
+	for obj := range mc.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("failed to list bucket %s: %w", bucket, obj.Err)
+		}
+		objects = append(objects, objectEntry{
+			Key:          obj.Key,
+			Size:         obj.Size,
+			ETag:         obj.ETag,
+			ContentType:  obj.ContentType,
+			LastModified: obj.LastModified,
+		})
+	}
+	return objects, nil
+}
+
+// currentEncryptionKey returns the master key new content is encrypted
+// with, from whichever ENCRYPTION_KEY_PROVIDER is configured
+func currentEncryptionKey(ctx context.Context, cfg *config.Config) ([]byte, error) {
+	provider, err := encryption.NewKeyProvider(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key provider: %w", err)
+	}
+	keys, err := provider.Keys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load encryption key: %w", err)
+	}
+	if len(keys) == 0 {
+		return nil, encryption.ErrNoKeys
+	}
+	return keys[0], nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/hex"
+	"errors"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,22 +19,57 @@ import (
 
 	"github.com/zentra/server/config"
 	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/antispam"
+	"github.com/zentra/server/internal/services/apitoken"
 	"github.com/zentra/server/internal/services/auth"
+	"github.com/zentra/server/internal/services/automod"
+	"github.com/zentra/server/internal/services/broadcast"
+	"github.com/zentra/server/internal/services/calls"
+	"github.com/zentra/server/internal/services/camo"
 	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/channelitem"
 	"github.com/zentra/server/internal/services/channeltype"
 	"github.com/zentra/server/internal/services/community"
 	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/email"
 	"github.com/zentra/server/internal/services/emoji"
+	"github.com/zentra/server/internal/services/encryptionaudit"
+	"github.com/zentra/server/internal/services/eventhook"
+	"github.com/zentra/server/internal/services/exporter"
+	"github.com/zentra/server/internal/services/feeds"
+	"github.com/zentra/server/internal/services/gifsearch"
+	"github.com/zentra/server/internal/services/githooks"
 	"github.com/zentra/server/internal/services/githubstats"
+	"github.com/zentra/server/internal/services/idempotency"
+	"github.com/zentra/server/internal/services/importer"
+	"github.com/zentra/server/internal/services/leveling"
+	"github.com/zentra/server/internal/services/lobby"
+	"github.com/zentra/server/internal/services/mailtemplate"
+	"github.com/zentra/server/internal/services/maintenance"
 	"github.com/zentra/server/internal/services/media"
 	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
 	"github.com/zentra/server/internal/services/notification"
+	"github.com/zentra/server/internal/services/oauth"
+	"github.com/zentra/server/internal/services/permcache"
 	"github.com/zentra/server/internal/services/plugin"
+	"github.com/zentra/server/internal/services/portability"
+	"github.com/zentra/server/internal/services/presence"
+	"github.com/zentra/server/internal/services/pushgateway"
+	"github.com/zentra/server/internal/services/quicksearch"
+	"github.com/zentra/server/internal/services/recency"
+	"github.com/zentra/server/internal/services/soundboard"
+	"github.com/zentra/server/internal/services/starboard"
+	"github.com/zentra/server/internal/services/storagestats"
 	"github.com/zentra/server/internal/services/user"
 	"github.com/zentra/server/internal/services/voice"
+	"github.com/zentra/server/internal/services/watchtogether"
 	"github.com/zentra/server/internal/services/webhook"
 	"github.com/zentra/server/internal/services/websocket"
+	"github.com/zentra/server/internal/utils"
 	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+	"github.com/zentra/server/pkg/metrics"
 	"github.com/zentra/server/pkg/storage"
 )
 
@@ -73,11 +110,41 @@ func main() {
 	}
 	log.Info().Msg("Connected to MinIO")
 
-	// Decode encryption key
-	encKey, err := hex.DecodeString(cfg.Encryption.Key)
+	// Content is envelope encrypted under the current master key and readable
+	// under any of the keys it replaced. Keys from Vault, KMS or an age file are
+	// refreshed in the background so a rotation needs no restart.
+	keyProvider, err := encryption.NewKeyProvider(cfg)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to decode encryption key (must be hex)")
+		log.Fatal().Err(err).Msg("Invalid encryption key provider")
 	}
+	keys, err := encryption.LoadKeyring(context.Background(), keyProvider)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid encryption keys")
+	}
+	log.Info().Str("provider", keyProvider.Name()).Str("keyVersion", keys.CurrentID()).Msg("Loaded encryption keys")
+	if cfg.Encryption.Provider != "env" {
+		go keys.Watch(context.Background(), keyProvider, cfg.Encryption.RefreshInterval)
+	}
+
+	// Channel messages are stored with keyed word tokens so they can be searched
+	searchKey, err := hex.DecodeString(cfg.Search.TokenKey)
+	if err != nil || len(searchKey) != 32 {
+		log.Fatal().Msg("SEARCH_TOKEN_KEY must be 64 hex characters")
+	}
+	searchIndex := messaging.NewSearchIndex(searchKey)
+
+	// The newest page of each active channel is served from Redis, decrypted
+	historyCache := messaging.NewHistoryCache(redisClient, 0)
+
+	// Email templates are shared by verification mail and notification digests
+	mailTemplateService := mailtemplate.NewService(db, mailtemplate.Branding{
+		Name:           cfg.Email.BrandName,
+		LogoURL:        cfg.Email.BrandLogoURL,
+		Color:          cfg.Email.BrandColor,
+		SupportAddress: cfg.Email.BrandSupportAddress,
+		Footer:         cfg.Email.BrandFooter,
+		AppURL:         cfg.Email.AppURL,
+	}, cfg.Email.DefaultLocale)
 
 	// Initialize services
 	authService := auth.NewService(
@@ -101,12 +168,23 @@ func main() {
 			VerificationURL:      cfg.Email.VerificationURL,
 			VerificationTokenTTL: cfg.Email.VerificationTokenTTL,
 		},
+		mailTemplateService,
 	)
 	userService := user.NewService(db, redisClient)
-	if err := userService.MarkAllUsersOffline(context.Background()); err != nil {
+
+	// Presence lives in Redis; only reset users nobody holds a live connection for,
+	// so restarting one instance doesn't knock everyone else's users offline.
+	presenceService := presence.NewService(redisClient, userService)
+	if err := presenceService.Reconcile(context.Background()); err != nil {
 		log.Warn().Err(err).Msg("Failed to reset stale presence states on startup")
 	}
-	communityService := community.NewService(db, redisClient, encKey)
+	go presenceService.Run(context.Background())
+	permissionCache := permcache.New(redisClient, permcache.DefaultLocalSize)
+	go permissionCache.Run(context.Background())
+	communityService := community.NewService(db, redisClient, keys)
+	communityService.SetPermissionCache(permissionCache)
+	communityService.SetSearchIndex(searchIndex)
+	communityService.SetHistoryCache(historyCache)
 
 	// Set up the channel type registry and load definitions from the DB
 	channelTypeRegistry := channeltype.NewRegistry(db)
@@ -116,26 +194,293 @@ func main() {
 	log.Info().Int("types", len(channelTypeRegistry.All())).Msg("Channel type registry loaded")
 
 	channelService := channel.NewService(db, communityService, channelTypeRegistry)
-	messageService := message.NewService(db, redisClient, encKey, channelService)
-	dmService := dm.NewService(db, redisClient, encKey, userService)
-	mediaService := media.NewService(db, minioClient, [3]string{cfg.Storage.BucketAttachments, cfg.Storage.BucketAvatars, cfg.Storage.BucketCommunity}, cfg.Storage.CDNBaseURL, communityService)
-	emojiService := emoji.NewService(db, minioClient, cfg.Storage.BucketCommunity, cfg.Storage.CDNBaseURL, communityService)
+	channelService.SetPermissionCache(permissionCache)
+	automodService := automod.NewService(db, communityService)
+	antispamService := antispam.NewService(db, redisClient, communityService)
+	communityService.SetJoinGuard(antispamService)
+	messageService := message.NewService(db, redisClient, keys, channelService, presenceService, automodService, antispamService)
+	messageService.SetSearchIndex(searchIndex)
+	messageService.SetHistoryCache(historyCache)
+	dmService := dm.NewService(db, redisClient, keys, userService)
+	mediaService := media.NewService(db, minioClient, [3]string{cfg.Storage.BucketAttachments, cfg.Storage.BucketAvatars, cfg.Storage.BucketCommunity}, cfg.Storage.CDNBaseURL, cfg.Storage.MaxUploadSize, communityService)
+	if cfg.Storage.FFmpegPath != "" {
+		ffmpeg := media.NewFFmpegTranscoder(cfg.Storage.FFmpegPath, cfg.Storage.FFprobePath)
+		mediaService.SetTranscoder(ffmpeg, cfg.Storage.MaxTranscodeSize)
+		// The image proxy's WebP and AVIF output
+		mediaService.SetImageEncoder(ffmpeg)
+	}
+	mediaService.SetChannelAccess(channelService)
+	mediaService.SetHistoryCache(historyCache)
+	var urlSigner *messaging.URLSigner
+	if cfg.Storage.AttachmentURLSecret != "" {
+		urlSigner = messaging.NewURLSigner(cfg.Storage.AttachmentURLSecret, cfg.Storage.AttachmentURLTTL,
+			cfg.Storage.CDNBaseURL, cfg.Storage.BucketAttachments, cfg.Storage.FilesBaseURL)
+		mediaService.SetURLSigner(urlSigner)
+		messageService.SetURLSigner(urlSigner)
+		dmService.SetURLSigner(urlSigner)
+	}
+	// Link preview images are rewritten to the image proxy before any service
+	// hands previews out
+	var camoSigner *messaging.Camo
+	if cfg.Camo.Secret != "" {
+		camoSigner = messaging.NewCamo(cfg.Camo.Secret, cfg.Camo.BaseURL)
+		messageService.SetCamo(camoSigner)
+		dmService.SetCamo(camoSigner)
+	}
+	// Image renditions, metadata stripping and transcoding run in the background
+	go mediaService.StartProcessing(context.Background(), cfg.Storage.MediaWorkers, cfg.Storage.VideoWorkers)
+	emojiService := emoji.NewService(db, redisClient, minioClient, cfg.Storage.BucketCommunity, cfg.Storage.CDNBaseURL, communityService)
+	// Emoji usage is counted from message and reaction broadcast events
+	go emojiService.Run(context.Background(), cfg.Gateway.InstanceID)
 
 	// Initialize voice service
 	voiceService := voice.NewService(db, channelService, userService)
-	webhookService := webhook.NewService(db, redisClient, encKey, channelService, mediaService)
+	voiceService.SetICE(voice.ICEConfig{
+		STUNURLs:          cfg.Voice.STUNURLs,
+		TURNURLs:          cfg.Voice.TURNURLs,
+		TURNSecret:        cfg.Voice.TURNSecret,
+		TURNCredentialTTL: cfg.Voice.TURNCredentialTTL,
+	})
+	voiceService.SetRegions(cfg.Voice.Regions)
+	if cfg.Voice.LiveKitURL != "" {
+		if err := voiceService.SetSFU(voice.SFUConfig{
+			URL:       cfg.Voice.LiveKitURL,
+			APIURL:    cfg.Voice.LiveKitAPIURL,
+			APIKey:    cfg.Voice.LiveKitAPIKey,
+			APISecret: cfg.Voice.LiveKitAPISecret,
+			TokenTTL:  cfg.Voice.TokenTTL,
+		}); err != nil {
+			log.Fatal().Err(err).Msg("Invalid LiveKit configuration")
+		}
+	}
+	soundboardService := soundboard.NewService(db, mediaService, channelService, communityService, voiceService)
+	callService := calls.NewService(db, userService, voiceService)
+	webhookService := webhook.NewService(db, redisClient, messageService, channelService, mediaService)
 
 	// Initialize plugin service
-	pluginService := plugin.NewService(db, channelTypeRegistry)
+	pluginService := plugin.NewService(db, channelTypeRegistry, channelService, communityService, keys)
+	if cfg.Plugins.WasmEnabled {
+		err := pluginService.EnableSandbox(context.Background(), plugin.SandboxLimits{
+			MemoryMB:    cfg.Plugins.WasmMemoryMB,
+			Timeout:     cfg.Plugins.WasmTimeout,
+			Concurrency: cfg.Plugins.WasmConcurrency,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to start plugin sandbox")
+		}
+	}
+	pluginService.SetAllowUnsigned(cfg.Plugins.AllowUnsigned)
+	if err := pluginService.SetTrustedKeys(cfg.Plugins.TrustedKeys); err != nil {
+		log.Fatal().Err(err).Msg("Invalid PLUGIN_TRUSTED_KEYS")
+	}
+
+	// Starboard runs in-process and is driven by reaction broadcast events
+	starboardService := starboard.NewService(db, redisClient, keys, messageService)
+	if urlSigner != nil {
+		starboardService.SetURLSigner(urlSigner)
+	}
+	pluginService.RegisterConfigValidator(starboard.PluginSlug, starboard.ValidateConfig)
+	go starboardService.Run(context.Background(), cfg.Gateway.InstanceID)
+
+	// Leveling awards XP from message broadcast events
+	levelingService := leveling.NewService(db, redisClient)
+	levelingService.SetPermissionCache(permissionCache)
+	pluginService.RegisterConfigValidator(leveling.PluginSlug, leveling.ValidateConfig)
+	go levelingService.Run(context.Background(), cfg.Gateway.InstanceID)
+
+	// Feeds polls RSS/Atom feeds configured on the plugin and posts new entries
+	feedsService := feeds.NewService(db, messageService)
+	pluginService.RegisterConfigValidator(feeds.PluginSlug, feeds.ValidateConfig)
+	pluginService.RegisterConfigChannels(feeds.PluginSlug, feeds.ConfigChannels)
+
+	// Watch together channels keep shared playback state in the database so
+	// sessions outlive any one gateway
+	watchService := watchtogether.NewService(db, channelService, presenceService)
+
+	// Game lobbies in lobby channels, expired by the maintenance job
+	lobbyService := lobby.NewService(db, channelService)
+
+	// Cards, pages and media in channels of kanban, wiki and media board types
+	channelItemService := channelitem.NewService(db, channelService, channelTypeRegistry)
+
+	// Signed account bundles for moving between instances
+	var portabilityKey ed25519.PrivateKey
+	if cfg.Portability.SigningKey != "" {
+		seed, err := hex.DecodeString(cfg.Portability.SigningKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			log.Fatal().Msg("PORTABILITY_SIGNING_KEY must be 64 hex characters")
+		}
+		portabilityKey = ed25519.NewKeyFromSeed(seed)
+	}
+	portabilityService := portability.NewService(db, portability.Config{
+		InstanceURL:    cfg.Portability.InstanceURL,
+		SigningKey:     portabilityKey,
+		TrustedIssuers: cfg.Portability.TrustedIssuers,
+	}, keys, authService, userService, dmService)
+
+	// GitHub/GitLab webhook deliveries are routed to channels by the plugin config
+	gitHooksService := githooks.NewService(db, keys, messageService, communityService)
+	pluginService.RegisterConfigValidator(githooks.PluginSlug, githooks.ValidateConfig)
+	pluginService.RegisterConfigChannels(githooks.PluginSlug, githooks.ConfigChannels)
+
+	// Digests of missed mentions and DMs for offline users, and reply-by-email
+	emailService := email.NewService(db, email.Config{
+		SMTPHost:     cfg.Email.SMTPHost,
+		SMTPPort:     cfg.Email.SMTPPort,
+		SMTPUsername: cfg.Email.SMTPUsername,
+		SMTPPassword: cfg.Email.SMTPPassword,
+		FromAddress:  cfg.Email.FromAddress,
+		AppURL:       cfg.Email.AppURL,
+		ReplyDomain:  cfg.Email.ReplyDomain,
+		ReplySecret:  cfg.Email.ReplySecret,
+		InboundToken: cfg.Email.InboundToken,
+	}, mailTemplateService, presenceService, messageService, dmService)
+	if emailService.Enabled() {
+		mailTemplateService.SetSender(emailService)
+	}
+	go emailService.Run(context.Background())
 
 	// Initialize WebSocket hub
-	wsHub := websocket.NewHub(redisClient, channelService, userService, dmService, voiceService)
+	wsHub := websocket.NewHub(redisClient, channelService, userService, dmService, voiceService, presenceService)
+	wsHub.SetWatchService(watchService)
+	wsHub.SetCommunityService(communityService)
+	wsHub.SetInstanceID(cfg.Gateway.InstanceID)
+	wsHub.SetBackpressure(websocket.BackpressurePolicy(cfg.Gateway.Backpressure), cfg.Gateway.MaxSendBuffer)
+	wsHub.SetReplayBuffer(cfg.Gateway.ReplayBufferSize)
+	wsHub.SetHeartbeat(cfg.Gateway.HeartbeatInterval, cfg.Gateway.HeartbeatTimeout)
 	go wsHub.Run(context.Background())
 
 	// Initialize notification service (depends on wsHub)
-	notificationService := notification.NewService(db, wsHub)
+	notificationService := notification.NewService(db, wsHub, notification.Retention{
+		MaxAge:     cfg.Notifications.Retention,
+		MaxPerUser: cfg.Notifications.MaxPerUser,
+	})
 	messageService.SetNotificationService(notificationService)
 	dmService.SetNotificationService(notificationService)
+	callService.SetNotificationService(notificationService)
+	antispamService.SetNotificationService(notificationService)
+	if cfg.WebPush.VAPIDPrivateKey != "" {
+		if err := notificationService.SetWebPush(notification.WebPushConfig{
+			VAPIDPrivateKey: cfg.WebPush.VAPIDPrivateKey,
+			Subject:         cfg.WebPush.Subject,
+		}); err != nil {
+			log.Fatal().Err(err).Msg("Invalid Web Push configuration")
+		}
+	}
+
+	// Native mobile push via FCM and APNs shares the Web Push gating and preferences
+	pushGatewayService, err := pushgateway.NewService(db, pushgateway.Config{
+		FCMCredentialsFile: cfg.MobilePush.FCMCredentialsFile,
+		APNsKeyFile:        cfg.MobilePush.APNsKeyFile,
+		APNsKeyID:          cfg.MobilePush.APNsKeyID,
+		APNsTeamID:         cfg.MobilePush.APNsTeamID,
+		APNsTopic:          cfg.MobilePush.APNsTopic,
+	}, notificationService)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid mobile push configuration")
+	}
+	if pushGatewayService.Enabled() {
+		notificationService.SetMobilePush(pushGatewayService)
+	}
+	// Releases notifications held during users' quiet hours
+	go notificationService.Run(context.Background())
+	// Calls nobody answered in time become missed calls
+	go callService.Run(context.Background())
+
+	// Outgoing event hooks are fed by the community and message services
+	eventHookService := eventhook.NewService(db, communityService, keys)
+	eventHookService.SetChannelService(channelService)
+	communityService.SetEventDispatcher(eventHookService)
+	messageService.SetEventHookService(eventHookService)
+	eventHookService.Subscribe(pluginService)
+	pluginService.SetMessageService(messageService)
+	go eventHookService.Run(context.Background())
+	go pluginService.Run(context.Background())
+
+	// Community API tokens act on a single community as their own bot user
+	apiTokenService := apitoken.NewService(db, communityService, eventHookService, keys)
+	apiTokenService.SetChannelService(channelService)
+	apiTokenService.SetMessageService(messageService)
+	apiTokenService.SetCamo(camoSigner)
+	broadcastService := broadcast.NewService(db, communityService, dmService, keys)
+	oauthService := oauth.NewService(db)
+	encryptionAuditService := encryptionaudit.NewService(db, keys)
+	go broadcastService.Run(context.Background())
+
+	// Discord and Slack archive imports are uploaded to a private bucket and
+	// run in the background
+	importService := importer.NewService(db, minioClient, cfg.Storage.BucketImports, cfg.Storage.BucketAttachments, cfg.Storage.CDNBaseURL, keys, communityService)
+	importService.SetPermissionCache(permissionCache)
+	importService.SetSearchIndex(searchIndex)
+	importService.SetHistoryCache(historyCache)
+	if err := importService.EnsureBucket(context.Background()); err != nil {
+		log.Error().Err(err).Str("bucket", cfg.Storage.BucketImports).Msg("Failed to prepare import bucket")
+	}
+	go importService.Run(context.Background())
+
+	// Community exports are built in the background into a private bucket
+	exportService := exporter.NewService(db, minioClient, cfg.Storage.BucketExports,
+		[]string{cfg.Storage.BucketAttachments, cfg.Storage.BucketAvatars, cfg.Storage.BucketCommunity},
+		cfg.Storage.CDNBaseURL, keys, communityService)
+	if urlSigner != nil {
+		exportService.SetURLSigner(urlSigner)
+	}
+	if err := exportService.EnsureBucket(context.Background()); err != nil {
+		log.Error().Err(err).Str("bucket", cfg.Storage.BucketExports).Msg("Failed to prepare export bucket")
+	}
+	go exportService.Run(context.Background())
+
+	recencyService := recency.NewService(redisClient)
+	messageService.SetRecencyService(recencyService)
+	dmService.SetRecencyService(recencyService)
+	dmService.SetAutoModService(automodService)
+
+	// Services that post as bot users start once the message service is wired up
+	go feedsService.Run(context.Background())
+
+	quickSearchService := quicksearch.NewService(db, channelService, recencyService)
+
+	// Object storage health for readiness, and bucket usage for /metrics
+	storageStatsService := storagestats.NewService(db, redisClient, minioClient, []string{
+		cfg.Storage.BucketAttachments,
+		cfg.Storage.BucketAvatars,
+		cfg.Storage.BucketCommunity,
+		cfg.Storage.BucketImports,
+		cfg.Storage.BucketExports,
+	})
+	go storageStatsService.Run(context.Background())
+
+	// Idempotency-Key reservations for POSTs that clients may retry
+	idempotencyService := idempotency.NewService(db, keys)
+
+	// Periodic cleanup of expired invites, sessions and stale Redis state
+	maintenanceService := maintenance.NewService(db, redisClient, presenceService)
+	maintenanceService.SetPartitionPolicy(maintenance.PartitionPolicy{
+		Ahead:     cfg.Messages.PartitionsAhead,
+		Retention: cfg.Messages.PartitionRetention,
+	})
+	maintenanceService.SetAttachmentRemover(mediaService)
+	maintenanceService.Register("event_hook_deliveries", eventHookService.PruneDeliveries)
+	maintenanceService.Register("plugin_deliveries", pluginService.PruneDeliveries)
+	maintenanceService.Register("message_interactions", apiTokenService.PruneInteractions)
+	maintenanceService.Register("oauth_tokens", oauthService.PruneExpired)
+	maintenanceService.Register("moderation_alerts", antispamService.PruneAlerts)
+	maintenanceService.Register("expired_lockdowns", communityService.ExpireLockdowns)
+	maintenanceService.Register("import_jobs", importService.PruneAbandoned)
+	maintenanceService.Register("community_exports", exportService.ExpireArchives)
+	maintenanceService.Register("feed_entries", feedsService.PruneEntries)
+	maintenanceService.Register("git_deliveries", gitHooksService.PruneDeliveries)
+	maintenanceService.Register("email_replies", emailService.PruneReplies)
+	maintenanceService.Register("expired_lobbies", lobbyService.ExpireLobbies)
+	maintenanceService.Register("notifications", notificationService.PruneNotifications)
+	maintenanceService.Register("push_subscriptions", notificationService.PruneExpiredPushSubscriptions)
+	maintenanceService.Register("push_devices", pushGatewayService.PruneStaleDevices)
+	maintenanceService.Register("storage_usage_samples", storageStatsService.PruneSamples)
+	maintenanceService.Register("voice_qos_reports", voiceService.PruneQoSReports)
+	maintenanceService.Register("upload_intents", mediaService.PruneUploadIntents)
+	maintenanceService.Register("resumable_uploads", mediaService.PruneResumableUploads)
+	maintenanceService.Register("idempotency_keys", idempotencyService.PruneExpired)
+	go maintenanceService.Run(context.Background())
 
 	// Initialize handlers
 	authHandler := auth.NewHandler(authService)
@@ -144,32 +489,61 @@ func main() {
 	channelHandler := channel.NewHandler(channelService)
 	channelTypeHandler := channeltype.NewHandler(channelTypeRegistry)
 	messageHandler := message.NewHandler(messageService)
+	automodHandler := automod.NewHandler(automodService)
+	eventHookHandler := eventhook.NewHandler(eventHookService)
+	apiTokenHandler := apitoken.NewHandler(apiTokenService)
+	broadcastHandler := broadcast.NewHandler(broadcastService)
+	importHandler := importer.NewHandler(importService)
+	exportHandler := exporter.NewHandler(exportService)
+	encryptionAuditHandler := encryptionaudit.NewHandler(encryptionAuditService)
+	mailTemplateHandler := mailtemplate.NewHandler(mailTemplateService)
+	oauthHandler := oauth.NewHandler(oauthService, userService, communityService, messageService)
+	antispamHandler := antispam.NewHandler(antispamService)
 	dmHandler := dm.NewHandler(dmService)
+	callHandler := calls.NewHandler(callService)
 	mediaHandler := media.NewHandler(mediaService)
 	emojiHandler := emoji.NewHandler(emojiService)
 	wsHandler := websocket.NewHandler(wsHub, cfg.JWT.Secret)
 	voiceHandler := voice.NewHandler(voiceService)
+	soundboardHandler := soundboard.NewHandler(soundboardService)
+	watchHandler := watchtogether.NewHandler(watchService)
+	lobbyHandler := lobby.NewHandler(lobbyService)
+	levelingHandler := leveling.NewHandler(levelingService)
+	channelItemHandler := channelitem.NewHandler(channelItemService)
+	portabilityHandler := portability.NewHandler(portabilityService)
 	webhookHandler := webhook.NewHandler(webhookService)
+	gitHooksHandler := githooks.NewHandler(gitHooksService)
+	emailHandler := email.NewHandler(emailService)
 	notificationHandler := notification.NewHandler(notificationService)
+	pushGatewayHandler := pushgateway.NewHandler(pushGatewayService)
 	pluginHandler := plugin.NewHandler(pluginService)
 	githubStatsService := githubstats.NewService(cfg.GitHub.Token)
 	githubStatsHandler := githubstats.NewHandler(githubStatsService)
+	quickSearchHandler := quicksearch.NewHandler(quickSearchService)
+	gifSearchService := gifsearch.NewService(redisClient, cfg.GIF.Provider, cfg.GIF.APIKey, cfg.GIF.ContentFilter, cfg.GIF.CacheTTL, cfg.GIF.MediaBaseURL, cfg.GIF.MediaSecret)
+	gifSearchHandler := gifsearch.NewHandler(gifSearchService)
+	camoHandler := camo.NewHandler(camo.NewService(camoSigner, cfg.Camo.MaxSize))
+
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid TRUSTED_PROXIES")
+	}
 
 	// Create router
 	r := chi.NewRouter()
 
 	// Global middleware
 	r.Use(chimiddleware.RequestID)
-	r.Use(chimiddleware.RealIP)
+	r.Use(middleware.RealIP(trustedProxies))
 	r.Use(middleware.LoggingMiddleware)
 	r.Use(chimiddleware.Recoverer)
 
 	// CORS
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   cfg.Server.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Request-ID", "Origin"},
-		ExposedHeaders:   []string{"Link", "X-Request-ID"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-Request-ID", "Origin", "Upload-Offset", "Upload-Length", "Upload-Metadata", "Tus-Resumable", "Idempotency-Key"},
+		ExposedHeaders:   []string{"Link", "X-Request-ID", "Location", "Upload-Offset", "Upload-Length", "Upload-Expires", "Tus-Resumable", "Tus-Version", "Tus-Max-Size", "Tus-Extension", "Idempotent-Replayed"},
 		AllowCredentials: true,
 		MaxAge:           300,
 		Debug:            cfg.Environment == "development",
@@ -185,38 +559,118 @@ func main() {
 		w.Write([]byte(`{"status":"ok","timestamp":"` + time.Now().Format(time.RFC3339) + `"}`))
 	})
 
-	// API routes
-	r.Route("/api/v1", func(r chi.Router) {
-		r.Use(chimiddleware.Timeout(60 * time.Second))
-
-		// Public routes
-		r.Mount("/auth", authHandler.Routes())
-		r.Mount("/communities", communityHandler.Routes(cfg.JWT.Secret))
-		r.Mount("/public/github", githubStatsHandler.Routes())
-		r.Mount("/webhooks", webhookHandler.Routes(cfg.JWT.Secret))
-
-		// Protected routes
-		r.Group(func(r chi.Router) {
-			r.Use(middleware.AuthMiddleware(cfg.JWT.Secret))
-
-			// Rate limiting for authenticated users
-			r.Use(middleware.RateLimitMiddleware(redisClient, cfg.Server.RateLimitRPS))
-
-			r.Mount("/users", userHandler.Routes())
-			r.Mount("/channels", channelHandler.Routes())
-			r.Mount("/channel-types", channelTypeHandler.Routes())
-			r.Mount("/messages", messageHandler.Routes())
-			r.Mount("/dms", dmHandler.Routes())
-			r.Mount("/media", mediaHandler.Routes())
-			r.Mount("/emojis", emojiHandler.Routes())
-			r.Mount("/notifications", notificationHandler.Routes())
-			r.Mount("/voice", voiceHandler.Routes())
-			r.Mount("/plugins", pluginHandler.Routes())
-		})
+	// Readiness: the instance can serve traffic only while its dependencies answer
+	r.Get("/ready", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		// Errors go to the log; the probe only learns which dependency is down
+		checks := map[string]string{}
+		ready := true
+		for name, check := range map[string]func(context.Context) error{
+			"postgres": db.Ping,
+			"storage":  storageStatsService.Ready,
+		} {
+			checks[name] = "ok"
+			if err := check(ctx); err != nil {
+				log.Warn().Err(err).Str("dependency", name).Msg("Readiness check failed")
+				checks[name] = "unavailable"
+				ready = false
+			}
+		}
+
+		// Every instance shares Redis, so taking them out of rotation for it
+		// would take the API down with it; they run on local fallbacks instead
+		body := map[string]any{"checks": checks}
+		checks["redis"] = "ok"
+		if err := redisClient.Ping(ctx).Err(); err != nil {
+			if !errors.Is(err, database.ErrRedisUnavailable) {
+				log.Warn().Err(err).Str("dependency", "redis").Msg("Readiness check failed")
+			}
+			checks["redis"] = "degraded"
+		}
+		if health := database.GetRedisHealth(); health.Degraded || health.QueuedBroadcasts > 0 {
+			checks["redis"] = "degraded"
+			redis := map[string]any{
+				"queuedBroadcasts":  health.QueuedBroadcasts,
+				"droppedBroadcasts": health.DroppedBroadcasts,
+			}
+			if health.Degraded {
+				redis["degradedSince"] = health.Since
+			}
+			body["redis"] = redis
+		}
+
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		body["ready"] = ready
+		utils.RespondJSON(w, status, body)
+	})
+
+	// Prometheus scrape endpoint
+	r.Handle("/metrics", metrics.Handler(cfg.Metrics.Token))
+
+	// API routes, described by the document served at /api/v1/openapi.json
+	apiSpec := mountAPI(r, cfg, redisClient, &apiHandlers{
+		auth:            authHandler,
+		user:            userHandler,
+		community:       communityHandler,
+		channel:         channelHandler,
+		channelType:     channelTypeHandler,
+		channelItem:     channelItemHandler,
+		message:         messageHandler,
+		automod:         automodHandler,
+		eventHook:       eventHookHandler,
+		apiToken:        apiTokenHandler,
+		broadcast:       broadcastHandler,
+		importer:        importHandler,
+		exporter:        exportHandler,
+		encryptionAudit: encryptionAuditHandler,
+		mailTemplate:    mailTemplateHandler,
+		oauth:           oauthHandler,
+		antispam:        antispamHandler,
+		dm:              dmHandler,
+		calls:           callHandler,
+		media:           mediaHandler,
+		emoji:           emojiHandler,
+		notification:    notificationHandler,
+		pushGateway:     pushGatewayHandler,
+		voice:           voiceHandler,
+		soundboard:      soundboardHandler,
+		watch:           watchHandler,
+		lobby:           lobbyHandler,
+		leveling:        levelingHandler,
+		plugin:          pluginHandler,
+		portability:     portabilityHandler,
+		webhook:         webhookHandler,
+		gitHooks:        gitHooksHandler,
+		email:           emailHandler,
+		githubStats:     githubStatsHandler,
+		quickSearch:     quickSearchHandler,
+		gifSearch:       gifSearchHandler,
+		apiTokens:       apiTokenService,
+		plugins:         pluginService,
+		oauthTokens:     oauthService,
+		idempotency:     idempotencyService,
 	})
 
 	// WebSocket endpoint (separate from API versioning)
-	r.Mount("/ws", wsHandler.Routes())
+	r.Mount("/ws", wsHandler.Routes(cfg.Admin.Token))
+
+	// Private attachments behind signed links, and resized attachment images
+	r.Mount("/files", mediaHandler.FileRoutes())
+	r.Mount("/media", mediaHandler.ProxyRoutes(cfg.JWT.Secret, redisClient, cfg.Server.RateLimitRPS))
+
+	// External images in link previews
+	r.Mount("/camo", camoHandler.Routes())
+
+	// The router tests keep the description in line with the routes; a
+	// mismatch here still serves the document, with what could be described
+	if err := apiSpec.Build(r); err != nil {
+		log.Error().Err(err).Msg("API description doesn't match the routes")
+	}
 
 	// Create HTTP server
 	server := &http.Server{
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/zentra/server/config"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/openapi"
+	"github.com/zentra/server/internal/services/antispam"
+	"github.com/zentra/server/internal/services/apitoken"
+	"github.com/zentra/server/internal/services/auth"
+	"github.com/zentra/server/internal/services/automod"
+	"github.com/zentra/server/internal/services/broadcast"
+	"github.com/zentra/server/internal/services/calls"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/channelitem"
+	"github.com/zentra/server/internal/services/channeltype"
+	"github.com/zentra/server/internal/services/community"
+	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/email"
+	"github.com/zentra/server/internal/services/emoji"
+	"github.com/zentra/server/internal/services/encryptionaudit"
+	"github.com/zentra/server/internal/services/eventhook"
+	"github.com/zentra/server/internal/services/exporter"
+	"github.com/zentra/server/internal/services/gifsearch"
+	"github.com/zentra/server/internal/services/githooks"
+	"github.com/zentra/server/internal/services/githubstats"
+	"github.com/zentra/server/internal/services/importer"
+	"github.com/zentra/server/internal/services/leveling"
+	"github.com/zentra/server/internal/services/lobby"
+	"github.com/zentra/server/internal/services/mailtemplate"
+	"github.com/zentra/server/internal/services/media"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/notification"
+	"github.com/zentra/server/internal/services/oauth"
+	"github.com/zentra/server/internal/services/plugin"
+	"github.com/zentra/server/internal/services/portability"
+	"github.com/zentra/server/internal/services/pushgateway"
+	"github.com/zentra/server/internal/services/quicksearch"
+	"github.com/zentra/server/internal/services/soundboard"
+	"github.com/zentra/server/internal/services/user"
+	"github.com/zentra/server/internal/services/voice"
+	"github.com/zentra/server/internal/services/watchtogether"
+	"github.com/zentra/server/internal/services/webhook"
+)
+
+// apiHandlers serve the routes under /api/v1
+type apiHandlers struct {
+	auth            *auth.Handler
+	user            *user.Handler
+	community       *community.Handler
+	channel         *channel.Handler
+	channelType     *channeltype.Handler
+	channelItem     *channelitem.Handler
+	message         *message.Handler
+	automod         *automod.Handler
+	eventHook       *eventhook.Handler
+	apiToken        *apitoken.Handler
+	broadcast       *broadcast.Handler
+	importer        *importer.Handler
+	exporter        *exporter.Handler
+	encryptionAudit *encryptionaudit.Handler
+	mailTemplate    *mailtemplate.Handler
+	oauth           *oauth.Handler
+	antispam        *antispam.Handler
+	dm              *dm.Handler
+	calls           *calls.Handler
+	media           *media.Handler
+	emoji           *emoji.Handler
+	notification    *notification.Handler
+	pushGateway     *pushgateway.Handler
+	voice           *voice.Handler
+	soundboard      *soundboard.Handler
+	watch           *watchtogether.Handler
+	lobby           *lobby.Handler
+	leveling        *leveling.Handler
+	plugin          *plugin.Handler
+	portability     *portability.Handler
+	webhook         *webhook.Handler
+	gitHooks        *githooks.Handler
+	email           *email.Handler
+	githubStats     *githubstats.Handler
+	quickSearch     *quicksearch.Handler
+	gifSearch       *gifsearch.Handler
+
+	// What the authenticating middlewares check credentials against
+	apiTokens   middleware.CommunityTokenAuthenticator
+	plugins     middleware.PluginTokenAuthenticator
+	oauthTokens middleware.OAuthTokenAuthenticator
+	idempotency middleware.IdempotencyStore
+}
+
+// mountAPI mounts the API routes under /api/v1 and returns the document
+// describing them, to be built once the router is complete
+func mountAPI(r chi.Router, cfg *config.Config, redisClient *redis.Client, h *apiHandlers) *openapi.Spec {
+	apiSpec := openapi.NewSpec(openapi.Info{Title: "Zentra API", Version: "1"}, "/api/v1")
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(chimiddleware.Timeout(60 * time.Second))
+		r.Get("/openapi.json", apiSpec.ServeHTTP)
+
+		// Public routes
+		r.Mount("/auth", h.auth.Routes())
+		r.Mount("/communities", h.community.Routes(cfg.JWT.Secret))
+		r.Mount("/public/github", h.githubStats.Routes())
+		r.Mount("/webhooks", h.webhook.Routes(cfg.JWT.Secret))
+		r.Mount("/integrations/git", h.gitHooks.Routes(cfg.JWT.Secret))
+		r.Mount("/integrations/livekit", h.voice.WebhookRoutes())
+		r.Mount("/email", h.email.Routes(cfg.JWT.Secret))
+		r.Mount("/oauth", h.oauth.Routes(cfg.JWT.Secret))
+		r.Mount("/portability", h.portability.Routes(cfg.JWT.Secret))
+		r.Mount("/gifs", h.gifSearch.Routes(cfg.JWT.Secret))
+
+		// Operator endpoints, authenticated with ADMIN_TOKEN
+		r.Route("/admin", func(r chi.Router) {
+			r.Use(middleware.AdminTokenMiddleware(cfg.Admin.Token))
+			r.Mount("/encryption", h.encryptionAudit.Routes())
+			r.Mount("/email-templates", h.mailTemplate.Routes())
+			r.Mount("/voice", h.voice.AdminRoutes())
+		})
+
+		// Automation authenticated with a community API token instead of a user session
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.CommunityTokenMiddleware(h.apiTokens))
+			r.Use(middleware.RateLimitMiddleware(redisClient, cfg.Server.RateLimitRPS))
+			r.Use(middleware.IdempotencyMiddleware(h.idempotency))
+			r.Mount("/automation", h.apiToken.AutomationRoutes())
+		})
+
+		// Plugins calling back in with their installation's API token
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.PluginTokenMiddleware(h.plugins))
+			r.Use(middleware.RateLimitMiddleware(redisClient, cfg.Server.RateLimitRPS))
+			r.Use(middleware.IdempotencyMiddleware(h.idempotency))
+			r.Mount("/plugin-api", h.plugin.APIRoutes())
+		})
+
+		// Third-party apps acting on behalf of a user with an OAuth2 access token
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.OAuthMiddleware(h.oauthTokens))
+			r.Use(middleware.RateLimitMiddleware(redisClient, cfg.Server.RateLimitRPS))
+			r.Use(middleware.IdempotencyMiddleware(h.idempotency))
+			r.Mount("/oauth-api", h.oauth.ResourceRoutes())
+		})
+
+		// Protected routes
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.AuthMiddleware(cfg.JWT.Secret))
+
+			// Rate limiting for authenticated users
+			r.Use(middleware.RateLimitMiddleware(redisClient, cfg.Server.RateLimitRPS))
+			r.Use(middleware.IdempotencyMiddleware(h.idempotency))
+
+			userRoutes := h.user.Routes()
+			userRoutes.Get("/me/quick-search", h.quickSearch.Search)
+			r.Mount("/users", userRoutes)
+			r.Mount("/channels", h.channel.Routes())
+			r.Mount("/channel-types", h.channelType.Routes())
+			r.Mount("/channel-items", h.channelItem.Routes())
+			r.Mount("/messages", h.message.Routes())
+			r.Mount("/automod", h.automod.Routes())
+			r.Mount("/event-hooks", h.eventHook.Routes())
+			r.Mount("/api-tokens", h.apiToken.Routes())
+			r.Mount("/interactions", h.apiToken.InteractionRoutes())
+			r.Mount("/broadcasts", h.broadcast.Routes())
+			r.Mount("/imports", h.importer.Routes())
+			r.Mount("/exports", h.exporter.Routes())
+			r.Mount("/antispam", h.antispam.Routes())
+			r.Mount("/dms", h.dm.Routes())
+			r.Mount("/calls", h.calls.Routes())
+			r.Mount("/media", h.media.Routes())
+			r.Mount("/emojis", h.emoji.Routes())
+			r.Mount("/notifications", h.notification.Routes())
+			r.Mount("/push/devices", h.pushGateway.Routes())
+			r.Mount("/voice", h.voice.Routes())
+			r.Mount("/soundboard", h.soundboard.Routes())
+			r.Mount("/watch", h.watch.Routes())
+			r.Mount("/lobbies", h.lobby.Routes())
+			r.Mount("/leveling", h.leveling.Routes())
+			r.Mount("/plugins", h.plugin.Routes())
+		})
+	})
+	return apiSpec
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/zentra/server/config"
+	"github.com/zentra/server/internal/services/antispam"
+	"github.com/zentra/server/internal/services/apitoken"
+	"github.com/zentra/server/internal/services/auth"
+	"github.com/zentra/server/internal/services/automod"
+	"github.com/zentra/server/internal/services/broadcast"
+	"github.com/zentra/server/internal/services/calls"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/channelitem"
+	"github.com/zentra/server/internal/services/channeltype"
+	"github.com/zentra/server/internal/services/community"
+	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/email"
+	"github.com/zentra/server/internal/services/emoji"
+	"github.com/zentra/server/internal/services/encryptionaudit"
+	"github.com/zentra/server/internal/services/eventhook"
+	"github.com/zentra/server/internal/services/exporter"
+	"github.com/zentra/server/internal/services/gifsearch"
+	"github.com/zentra/server/internal/services/githooks"
+	"github.com/zentra/server/internal/services/githubstats"
+	"github.com/zentra/server/internal/services/importer"
+	"github.com/zentra/server/internal/services/leveling"
+	"github.com/zentra/server/internal/services/lobby"
+	"github.com/zentra/server/internal/services/mailtemplate"
+	"github.com/zentra/server/internal/services/media"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/notification"
+	"github.com/zentra/server/internal/services/oauth"
+	"github.com/zentra/server/internal/services/plugin"
+	"github.com/zentra/server/internal/services/portability"
+	"github.com/zentra/server/internal/services/pushgateway"
+	"github.com/zentra/server/internal/services/quicksearch"
+	"github.com/zentra/server/internal/services/soundboard"
+	"github.com/zentra/server/internal/services/user"
+	"github.com/zentra/server/internal/services/voice"
+	"github.com/zentra/server/internal/services/watchtogether"
+	"github.com/zentra/server/internal/services/webhook"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/openapi.json from the routes")
+
+// TestAPIDescription builds the API description from the real router. It
+// fails on routes without a description, descriptions that don't fit their
+// route, and a document that differs from testdata/openapi.json, which
+// client generators read. Run with -update after changing the API on
+// purpose.
+func TestAPIDescription(t *testing.T) {
+	r := chi.NewRouter()
+	// The handlers' services are never called: routes are only walked
+	spec := mountAPI(r, &config.Config{}, nil, &apiHandlers{
+		auth:            &auth.Handler{},
+		user:            &user.Handler{},
+		community:       &community.Handler{},
+		channel:         &channel.Handler{},
+		channelType:     &channeltype.Handler{},
+		channelItem:     &channelitem.Handler{},
+		message:         &message.Handler{},
+		automod:         &automod.Handler{},
+		eventHook:       &eventhook.Handler{},
+		apiToken:        &apitoken.Handler{},
+		broadcast:       &broadcast.Handler{},
+		importer:        &importer.Handler{},
+		exporter:        &exporter.Handler{},
+		encryptionAudit: &encryptionaudit.Handler{},
+		mailTemplate:    &mailtemplate.Handler{},
+		oauth:           &oauth.Handler{},
+		antispam:        &antispam.Handler{},
+		dm:              &dm.Handler{},
+		calls:           &calls.Handler{},
+		media:           &media.Handler{},
+		emoji:           &emoji.Handler{},
+		notification:    &notification.Handler{},
+		pushGateway:     &pushgateway.Handler{},
+		voice:           &voice.Handler{},
+		soundboard:      &soundboard.Handler{},
+		watch:           &watchtogether.Handler{},
+		lobby:           &lobby.Handler{},
+		leveling:        &leveling.Handler{},
+		plugin:          &plugin.Handler{},
+		portability:     &portability.Handler{},
+		webhook:         &webhook.Handler{},
+		gitHooks:        &githooks.Handler{},
+		email:           &email.Handler{},
+		githubStats:     &githubstats.Handler{},
+		quickSearch:     &quicksearch.Handler{},
+		gifSearch:       &gifsearch.Handler{},
+	})
+	if err := spec.Build(r); err != nil {
+		t.Fatalf("API description doesn't match the routes:\n%v", err)
+	}
+
+	golden := filepath.Join("testdata", "openapi.json")
+	got := append(spec.JSON(), '\n')
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v; run go test ./cmd/gateway -run TestAPIDescription -update", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("the API description changed; check the change and run go test ./cmd/gateway -run TestAPIDescription -update")
+	}
+}
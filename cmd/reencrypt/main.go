@@ -0,0 +1,181 @@
+// Command reencrypt moves stored message content onto the current
+// ENCRYPTION_KEY and finds content no configured key can open.
+//
+//	reencrypt rotate [-kind channel|dm|broadcast] [-batch 500] [-dry-run]
+//	reencrypt sweep  [-kind channel|dm|broadcast] [-batch 500] [-dry-run]
+//	reencrypt repair [-limit 500] [-include-unrecoverable] [-dry-run]
+//
+// Content is envelope encrypted: each message has its own data key, wrapped
+// with ENCRYPTION_KEY. rotate is run after rotating the key, with the old key
+// in ENCRYPTION_PREVIOUS_KEYS. It re-wraps the data keys of content wrapped
+// with a previous key, without touching the content, and re-encrypts content
+// written before envelope encryption or in envelopes not bound to their row;
+// signing secrets and import credentials are moved along with it. Once it has finished the old key can be dropped.
+// sweep decrypts every row to check it. repair only re-checks failures
+// already recorded by the API (see GET /api/v1/admin/encryption/report).
+// Content no key can open is recorded as unrecoverable either way.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/zentra/server/config"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/encryptionaudit"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
+	keyProvider, err := encryption.NewKeyProvider(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid encryption key provider")
+	}
+	keys, err := encryption.LoadKeyring(context.Background(), keyProvider)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid encryption keys")
+	}
+
+	db, err := database.NewPostgresPool(cfg.Database.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to PostgreSQL")
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	service := encryptionaudit.NewService(db, keys)
+	args := os.Args[2:]
+
+	switch os.Args[1] {
+	case "rotate":
+		err = runRotate(ctx, service, args)
+	case "sweep":
+		err = runSweep(ctx, service, args)
+	case "repair":
+		err = runRepair(ctx, service, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatal().Err(err).Msg("Re-encrypt command failed")
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: reencrypt <rotate|sweep|repair> [flags]")
+}
+
+func runRotate(ctx context.Context, service *encryptionaudit.Service, args []string) error {
+	fs := flag.NewFlagSet("rotate", flag.ExitOnError)
+	kind := fs.String("kind", "", "only rotate channel, dm or broadcast content (default all, and secrets)")
+	batch := fs.Int("batch", encryptionaudit.DefaultSweepBatch, "rows per query")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	fs.Parse(args)
+
+	kinds := []string{messaging.ContentKindChannel, messaging.ContentKindDM, messaging.ContentKindBroadcast}
+	if *kind != "" {
+		kinds = []string{*kind}
+	}
+
+	for _, k := range kinds {
+		log.Info().Str("kind", k).Bool("dryRun", *dryRun).Msg("Rotating")
+		result, err := service.Rotate(ctx, k, *batch, *dryRun, func(r *models.DecryptionRepairResult) {
+			log.Info().Str("kind", k).Int("scanned", r.Scanned).Int("rewrapped", r.Rewrapped).Int("repaired", r.Repaired).Msg("Progress")
+		})
+		if err != nil {
+			return err
+		}
+		logResult(k, result)
+	}
+
+	if *kind == "" {
+		result, err := service.RotateSecrets(ctx, *dryRun)
+		if err != nil {
+			return err
+		}
+		logResult("secrets", result)
+	}
+	return nil
+}
+
+func runSweep(ctx context.Context, service *encryptionaudit.Service, args []string) error {
+	fs := flag.NewFlagSet("sweep", flag.ExitOnError)
+	kind := fs.String("kind", "", "only sweep channel, dm or broadcast content (default all)")
+	batch := fs.Int("batch", encryptionaudit.DefaultSweepBatch, "rows per query")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	fs.Parse(args)
+
+	kinds := []string{messaging.ContentKindChannel, messaging.ContentKindDM, messaging.ContentKindBroadcast}
+	if *kind != "" {
+		kinds = []string{*kind}
+	}
+
+	for _, k := range kinds {
+		log.Info().Str("kind", k).Bool("dryRun", *dryRun).Msg("Sweeping")
+		result, err := service.Sweep(ctx, k, *batch, *dryRun, func(r *models.DecryptionRepairResult) {
+			log.Info().Str("kind", k).Int("scanned", r.Scanned).Int("repaired", r.Repaired).Int("unrecoverable", r.Unrecoverable).Msg("Progress")
+		})
+		if err != nil {
+			return err
+		}
+		logResult(k, result)
+	}
+	return nil
+}
+
+func runRepair(ctx context.Context, service *encryptionaudit.Service, args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	limit := fs.Int("limit", encryptionaudit.DefaultRepairLimit, "failures to re-check")
+	includeUnrecoverable := fs.Bool("include-unrecoverable", false, "also retry failures marked unrecoverable")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing")
+	fs.Parse(args)
+
+	result, err := service.Repair(ctx, &encryptionaudit.RepairRequest{
+		DryRun:               *dryRun,
+		Limit:                *limit,
+		IncludeUnrecoverable: *includeUnrecoverable,
+	})
+	if err != nil {
+		return err
+	}
+	logResult("recorded", result)
+	return nil
+}
+
+func logResult(kind string, r *models.DecryptionRepairResult) {
+	log.Info().
+		Str("kind", kind).
+		Bool("dryRun", r.DryRun).
+		Int("scanned", r.Scanned).
+		Int("readable", r.Readable).
+		Int("rewrapped", r.Rewrapped).
+		Int("repaired", r.Repaired).
+		Int("unrecoverable", r.Unrecoverable).
+		Int("missing", r.Missing).
+		Msg("Done")
+}
@@ -0,0 +1,107 @@
+// Command searchindex stores search tokens for channel messages that don't
+// have them yet, so history written before messages were indexed can be
+// found with message search.
+//
+//	searchindex backfill [-channel id] [-batch 500] [-reindex] [-dry-run]
+//
+// backfill only reads messages without tokens, so it can be stopped and run
+// again. After changing SEARCH_TOKEN_KEY run it with -reindex: tokens made
+// with the old key no longer match any search.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+
+	"github.com/zentra/server/config"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/services/searchindex"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	if len(os.Args) < 2 || os.Args[1] != "backfill" {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
+	keyProvider, err := encryption.NewKeyProvider(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid encryption key provider")
+	}
+	keys, err := encryption.LoadKeyring(context.Background(), keyProvider)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid encryption keys")
+	}
+	searchKey, err := hex.DecodeString(cfg.Search.TokenKey)
+	if err != nil || len(searchKey) != 32 {
+		log.Fatal().Msg("SEARCH_TOKEN_KEY must be 64 hex characters")
+	}
+
+	db, err := database.NewPostgresPool(cfg.Database.URL)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to PostgreSQL")
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := runBackfill(ctx, searchindex.NewService(db, keys, messaging.NewSearchIndex(searchKey)), os.Args[2:]); err != nil {
+		log.Fatal().Err(err).Msg("Search index command failed")
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: searchindex backfill [flags]")
+}
+
+func runBackfill(ctx context.Context, service *searchindex.Service, args []string) error {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	channel := fs.String("channel", "", "only index this channel")
+	batch := fs.Int("batch", searchindex.DefaultBatch, "rows per query")
+	reindex := fs.Bool("reindex", false, "index messages that already have tokens too")
+	dryRun := fs.Bool("dry-run", false, "report what would be indexed without writing")
+	fs.Parse(args)
+
+	var channelID *uuid.UUID
+	if *channel != "" {
+		id, err := uuid.Parse(*channel)
+		if err != nil {
+			return fmt.Errorf("invalid channel id: %w", err)
+		}
+		channelID = &id
+	}
+
+	log.Info().Bool("reindex", *reindex).Bool("dryRun", *dryRun).Msg("Indexing messages")
+	result, err := service.Backfill(ctx, *batch, *reindex, channelID, *dryRun, func(r *searchindex.Result) {
+		log.Info().Int("scanned", r.Scanned).Int("indexed", r.Indexed).Int("unreadable", r.Unreadable).Msg("Progress")
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().
+		Bool("dryRun", result.DryRun).
+		Int("scanned", result.Scanned).
+		Int("indexed", result.Indexed).
+		Int("unreadable", result.Unreadable).
+		Msg("Done")
+	return nil
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"strconv"
 	"strings"
@@ -17,6 +18,9 @@ type Config struct {
 		AllowedOrigins []string
 		RateLimitRPS   int
 		RateLimitBurst int
+		// TrustedProxies are the addresses and CIDR ranges whose
+		// X-Forwarded-For and X-Real-IP headers are believed
+		TrustedProxies []string
 	}
 	Captcha struct {
 		Enabled   bool
@@ -32,6 +36,42 @@ type Config struct {
 		FromAddress          string
 		VerificationURL      string
 		VerificationTokenTTL time.Duration
+		// Notification digests and reply-by-email
+		AppURL       string
+		ReplyDomain  string
+		ReplySecret  string
+		InboundToken string
+		// Branding and locale for email templates
+		BrandName           string
+		BrandLogoURL        string
+		BrandColor          string
+		BrandSupportAddress string
+		BrandFooter         string
+		DefaultLocale       string
+	}
+	WebPush struct {
+		VAPIDPrivateKey string
+		Subject         string
+	}
+	Notifications struct {
+		// Zero disables either limit
+		Retention  time.Duration
+		MaxPerUser int
+	}
+	Messages struct {
+		// Months after the current one the messages table always has a
+		// partition for
+		PartitionsAhead int
+		// Month partitions this long past their end are moved out of the
+		// messages table; zero keeps every month
+		PartitionRetention time.Duration
+	}
+	MobilePush struct {
+		FCMCredentialsFile string
+		APNsKeyFile        string
+		APNsKeyID          string
+		APNsTeamID         string
+		APNsTopic          string
 	}
 	Database struct {
 		URL string
@@ -39,6 +79,51 @@ type Config struct {
 	Redis struct {
 		URL string
 	}
+	Gateway struct {
+		// Names the instance's consumer group on the realtime event stream
+		InstanceID string
+		// What happens to events for clients that read too slowly: grow,
+		// resync or disconnect
+		Backpressure  string
+		MaxSendBuffer int
+		// Bytes of recent events each session keeps for resuming, on top of
+		// the resume window's time limit
+		ReplayBufferSize int
+		// Connections are pinged every interval and closed after going
+		// silent for the timeout
+		HeartbeatInterval time.Duration
+		HeartbeatTimeout  time.Duration
+	}
+	Voice struct {
+		// LiveKit SFU for group voice; voice falls back to peer-to-peer
+		// signaling over the gateway while LiveKitURL is unset
+		LiveKitURL       string
+		LiveKitAPIURL    string
+		LiveKitAPIKey    string
+		LiveKitAPISecret string
+		TokenTTL         time.Duration
+		// ICE servers handed to clients for peer-to-peer voice. TURN
+		// credentials use coturn's REST API scheme with a shared secret.
+		STUNURLs          []string
+		TURNURLs          []string
+		TURNSecret        string
+		TURNCredentialTTL time.Duration
+		// Regions media servers run in, which clients name in quality
+		// reports; any lowercase name is taken while empty
+		Regions []string
+	}
+	Plugins struct {
+		// WASM sandbox for plugins that ship a module instead of an
+		// endpoint; their events fail while it's disabled
+		WasmEnabled     bool
+		WasmMemoryMB    int
+		WasmTimeout     time.Duration
+		WasmConcurrency int
+		// Lets communities install plugins their source didn't sign
+		AllowUnsigned bool
+		// Base64 Ed25519 keys a plugin must be signed with to be installed
+		TrustedKeys []string
+	}
 	Storage struct {
 		Endpoint          string
 		AccessKey         string
@@ -47,7 +132,23 @@ type Config struct {
 		BucketAttachments string
 		BucketAvatars     string
 		BucketCommunity   string
+		BucketImports     string
+		BucketExports     string
 		CDNBaseURL        string
+		// Largest file a resumable upload can assemble
+		MaxUploadSize int64
+		// Attachments processed at once per instance
+		MediaWorkers int
+		// Video transcoding is off without an ffmpeg binary
+		FFmpegPath       string
+		FFprobePath      string
+		VideoWorkers     int
+		MaxTranscodeSize int64
+		// Attachments are private and served through signed links to
+		// FilesBaseURL while AttachmentURLSecret is set
+		AttachmentURLSecret string
+		AttachmentURLTTL    time.Duration
+		FilesBaseURL        string
 	}
 	JWT struct {
 		Secret     string
@@ -55,7 +156,29 @@ type Config struct {
 		RefreshTTL time.Duration
 	}
 	Encryption struct {
-		Key string
+		Key          string
+		PreviousKeys []string
+		// env, vault, awskms or age
+		Provider        string
+		RefreshInterval time.Duration
+
+		VaultAddr      string
+		VaultToken     string
+		VaultNamespace string
+		VaultPath      string
+
+		KMSRegion          string
+		KMSAccessKeyID     string
+		KMSSecretAccessKey string
+		KMSSessionToken    string
+		KMSEndpoint        string
+		KMSCiphertexts     []string
+
+		AgeFile         string
+		AgeIdentityFile string
+	}
+	Search struct {
+		TokenKey string
 	}
 	Discord struct {
 		ImportToken string
@@ -63,6 +186,37 @@ type Config struct {
 	GitHub struct {
 		Token string
 	}
+	GIF struct {
+		// tenor or giphy; search is disabled while APIKey is unset
+		Provider      string
+		APIKey        string
+		ContentFilter string
+		CacheTTL      time.Duration
+		// Where result media links point, and the secret they're signed with
+		MediaBaseURL string
+		MediaSecret  string
+	}
+	// External images in link previews are loaded through the image proxy at
+	// BaseURL while Secret is set
+	Camo struct {
+		Secret  string
+		BaseURL string
+		MaxSize int64
+	}
+	Backup struct {
+		Key string
+	}
+	Metrics struct {
+		Token string
+	}
+	Admin struct {
+		Token string
+	}
+	Portability struct {
+		InstanceURL    string
+		SigningKey     string
+		TrustedIssuers []string
+	}
 }
 
 var AppConfig *Config
@@ -89,6 +243,7 @@ func Load() (*Config, error) {
 	})
 	cfg.Server.RateLimitRPS = getEnvInt("RATE_LIMIT_RPS", 50)
 	cfg.Server.RateLimitBurst = getEnvInt("RATE_LIMIT_BURST", 100)
+	cfg.Server.TrustedProxies = getEnvSlice("TRUSTED_PROXIES", nil)
 
 	// Captcha (Cloudflare Turnstile)
 	cfg.Captcha.SecretKey = strings.TrimSpace(getEnv("CAPTCHA_SECRET_KEY", ""))
@@ -105,6 +260,29 @@ func Load() (*Config, error) {
 	cfg.Email.VerificationURL = strings.TrimSpace(getEnv("EMAIL_VERIFICATION_URL", "http://localhost:5173/verify-email"))
 	cfg.Email.VerificationTokenTTL = getEnvDuration("EMAIL_VERIFICATION_TOKEN_TTL", 24*time.Hour)
 
+	// Notification digests; replies are only accepted when all three reply settings are set
+	cfg.Email.AppURL = strings.TrimRight(strings.TrimSpace(getEnv("EMAIL_APP_URL", "http://localhost:5173")), "/")
+	cfg.Email.ReplyDomain = strings.TrimSpace(getEnv("EMAIL_REPLY_DOMAIN", ""))
+	cfg.Email.ReplySecret = getEnv("EMAIL_REPLY_SECRET", "")
+	cfg.Email.InboundToken = getEnv("EMAIL_INBOUND_TOKEN", "")
+	cfg.Email.BrandName = strings.TrimSpace(getEnv("EMAIL_BRAND_NAME", "Zentra"))
+	cfg.Email.BrandLogoURL = strings.TrimSpace(getEnv("EMAIL_BRAND_LOGO_URL", ""))
+	cfg.Email.BrandColor = strings.TrimSpace(getEnv("EMAIL_BRAND_COLOR", "#5865f2"))
+	cfg.Email.BrandSupportAddress = strings.TrimSpace(getEnv("EMAIL_BRAND_SUPPORT_ADDRESS", ""))
+	cfg.Email.BrandFooter = strings.TrimSpace(getEnv("EMAIL_BRAND_FOOTER", ""))
+	cfg.Email.DefaultLocale = strings.TrimSpace(getEnv("EMAIL_DEFAULT_LOCALE", "en"))
+
+	// Web Push; generate a key pair with `npx web-push generate-vapid-keys` and use the private key
+	cfg.WebPush.VAPIDPrivateKey = strings.TrimSpace(getEnv("WEBPUSH_VAPID_PRIVATE_KEY", ""))
+	cfg.WebPush.Subject = strings.TrimSpace(getEnv("WEBPUSH_SUBJECT", ""))
+
+	// Native mobile push; FCM takes a service account JSON, APNs a .p8 auth key
+	cfg.MobilePush.FCMCredentialsFile = strings.TrimSpace(getEnv("FCM_CREDENTIALS_FILE", ""))
+	cfg.MobilePush.APNsKeyFile = strings.TrimSpace(getEnv("APNS_KEY_FILE", ""))
+	cfg.MobilePush.APNsKeyID = strings.TrimSpace(getEnv("APNS_KEY_ID", ""))
+	cfg.MobilePush.APNsTeamID = strings.TrimSpace(getEnv("APNS_TEAM_ID", ""))
+	cfg.MobilePush.APNsTopic = strings.TrimSpace(getEnv("APNS_TOPIC", ""))
+
 	// Database
 	postgresUser := getEnv("POSTGRES_USER", "zentra")
 	postgresPass := getEnv("POSTGRES_PASSWORD", "zentra_secure_password")
@@ -119,6 +297,42 @@ func Load() (*Config, error) {
 	redisPort := getEnv("REDIS_PORT", "6379")
 	cfg.Redis.URL = getEnv("REDIS_URL", "redis://"+redisHost+":"+redisPort)
 
+	// Gateway. Events published while an instance is down are replayed when it
+	// comes back under the same ID, so the ID has to survive restarts: set
+	// GATEWAY_INSTANCE_ID (e.g. a StatefulSet pod name), or the hostname is
+	// used, which then has to be fixed rather than a container ID.
+	hostname, _ := os.Hostname()
+	cfg.Gateway.InstanceID = strings.TrimSpace(getEnv("GATEWAY_INSTANCE_ID", hostname))
+	if cfg.Gateway.InstanceID == "" {
+		return nil, errors.New("GATEWAY_INSTANCE_ID is not set and the hostname is unknown")
+	}
+	cfg.Gateway.Backpressure = strings.ToLower(strings.TrimSpace(getEnv("GATEWAY_BACKPRESSURE", "resync")))
+	cfg.Gateway.MaxSendBuffer = getEnvInt("GATEWAY_MAX_SEND_BUFFER", 4096)
+	cfg.Gateway.ReplayBufferSize = getEnvInt("GATEWAY_REPLAY_BUFFER_SIZE", 4<<20)
+	cfg.Gateway.HeartbeatInterval = getEnvDuration("GATEWAY_HEARTBEAT_INTERVAL", 30*time.Second)
+	cfg.Gateway.HeartbeatTimeout = getEnvDuration("GATEWAY_HEARTBEAT_TIMEOUT", 90*time.Second)
+
+	// Voice SFU. LIVEKIT_URL is what clients connect to; the server API is
+	// reached at the same host over http(s) unless LIVEKIT_API_URL says otherwise.
+	cfg.Voice.LiveKitURL = strings.TrimSpace(getEnv("LIVEKIT_URL", ""))
+	cfg.Voice.LiveKitAPIURL = strings.TrimSpace(getEnv("LIVEKIT_API_URL", ""))
+	cfg.Voice.LiveKitAPIKey = strings.TrimSpace(getEnv("LIVEKIT_API_KEY", ""))
+	cfg.Voice.LiveKitAPISecret = getEnv("LIVEKIT_API_SECRET", "")
+	cfg.Voice.TokenTTL = getEnvDuration("LIVEKIT_TOKEN_TTL", 5*time.Minute)
+	cfg.Voice.STUNURLs = getEnvSlice("VOICE_STUN_URLS", []string{"stun:stun.l.google.com:19302"})
+	cfg.Voice.TURNURLs = getEnvSlice("VOICE_TURN_URLS", nil)
+	cfg.Voice.TURNSecret = getEnv("VOICE_TURN_SECRET", "")
+	cfg.Voice.TURNCredentialTTL = getEnvDuration("VOICE_TURN_CREDENTIAL_TTL", 12*time.Hour)
+	cfg.Voice.Regions = getEnvSlice("VOICE_REGIONS", nil)
+
+	// Plugin WASM sandbox. Limits apply to each event a module handles.
+	cfg.Plugins.WasmEnabled = getEnvBool("PLUGIN_WASM_ENABLED", true)
+	cfg.Plugins.WasmMemoryMB = getEnvInt("PLUGIN_WASM_MEMORY_MB", 32)
+	cfg.Plugins.WasmTimeout = getEnvDuration("PLUGIN_WASM_TIMEOUT", 2*time.Second)
+	cfg.Plugins.WasmConcurrency = getEnvInt("PLUGIN_WASM_CONCURRENCY", 4)
+	cfg.Plugins.AllowUnsigned = getEnvBool("PLUGIN_ALLOW_UNSIGNED", false)
+	cfg.Plugins.TrustedKeys = getEnvSlice("PLUGIN_TRUSTED_KEYS", nil)
+
 	// Storage
 	cfg.Storage.Endpoint = getEnv("MINIO_ENDPOINT", "localhost:9000")
 	cfg.Storage.AccessKey = getEnv("MINIO_ACCESS_KEY", "zentra_minio")
@@ -127,7 +341,26 @@ func Load() (*Config, error) {
 	cfg.Storage.BucketAttachments = getEnv("MINIO_BUCKET_ATTACHMENTS", "attachments")
 	cfg.Storage.BucketAvatars = getEnv("MINIO_BUCKET_AVATARS", "avatars")
 	cfg.Storage.BucketCommunity = getEnv("MINIO_BUCKET_COMMUNITY", "community-assets")
+	cfg.Storage.BucketImports = getEnv("MINIO_BUCKET_IMPORTS", "imports")
+	cfg.Storage.BucketExports = getEnv("MINIO_BUCKET_EXPORTS", "exports")
 	cfg.Storage.CDNBaseURL = getEnv("CDN_BASE_URL", "http://localhost:9000")
+	cfg.Storage.MaxUploadSize = getEnvInt64("MAX_UPLOAD_SIZE", 1<<30)
+	cfg.Storage.MediaWorkers = getEnvInt("MEDIA_WORKERS", 2)
+	cfg.Storage.FFmpegPath = getEnv("FFMPEG_PATH", "")
+	cfg.Storage.FFprobePath = getEnv("FFPROBE_PATH", "ffprobe")
+	cfg.Storage.VideoWorkers = getEnvInt("VIDEO_WORKERS", 1)
+	cfg.Storage.MaxTranscodeSize = getEnvInt64("MAX_TRANSCODE_SIZE", 500<<20)
+	cfg.Storage.AttachmentURLSecret = getEnv("ATTACHMENT_URL_SECRET", "")
+	cfg.Storage.AttachmentURLTTL = getEnvDuration("ATTACHMENT_URL_TTL", time.Hour)
+	cfg.Storage.FilesBaseURL = getEnv("FILES_BASE_URL", "http://localhost:8080/files")
+
+	// Notification retention, enforced by the maintenance job
+	cfg.Notifications.Retention = getEnvDuration("NOTIFICATION_RETENTION", 90*24*time.Hour)
+	cfg.Notifications.MaxPerUser = getEnvInt("NOTIFICATION_MAX_PER_USER", 1000)
+
+	// Monthly messages partitions, managed by the maintenance job
+	cfg.Messages.PartitionsAhead = getEnvInt("MESSAGE_PARTITIONS_AHEAD", 3)
+	cfg.Messages.PartitionRetention = getEnvDuration("MESSAGE_PARTITION_RETENTION", 0)
 
 	// JWT
 	cfg.JWT.Secret = getEnv("JWT_SECRET", "your-super-secret-jwt-key-change-in-production")
@@ -136,6 +369,34 @@ func Load() (*Config, error) {
 
 	// Encryption
 	cfg.Encryption.Key = getEnv("ENCRYPTION_KEY", "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef")
+	// Keys ENCRYPTION_KEY replaced, newest first. Only used to repair content
+	// written before a rotation (cmd/reencrypt and the admin repair endpoint).
+	cfg.Encryption.PreviousKeys = getEnvSlice("ENCRYPTION_PREVIOUS_KEYS", nil)
+	// Where the master keys come from. env uses the two variables above; the
+	// others fetch them at startup and again every ENCRYPTION_KEY_REFRESH.
+	cfg.Encryption.Provider = strings.ToLower(strings.TrimSpace(getEnv("ENCRYPTION_KEY_PROVIDER", "env")))
+	cfg.Encryption.RefreshInterval = getEnvDuration("ENCRYPTION_KEY_REFRESH", 5*time.Minute)
+	// Vault KV secret with "key" and "previous_keys" fields
+	cfg.Encryption.VaultAddr = getEnv("VAULT_ADDR", "")
+	cfg.Encryption.VaultToken = getEnv("VAULT_TOKEN", "")
+	cfg.Encryption.VaultNamespace = getEnv("VAULT_NAMESPACE", "")
+	cfg.Encryption.VaultPath = getEnv("ENCRYPTION_VAULT_PATH", "secret/data/zentra/encryption")
+	// KMS encrypted data keys, current first. Unset region and credentials
+	// fall back to the default AWS chain.
+	cfg.Encryption.KMSRegion = getEnv("AWS_REGION", "")
+	cfg.Encryption.KMSAccessKeyID = getEnv("AWS_ACCESS_KEY_ID", "")
+	cfg.Encryption.KMSSecretAccessKey = getEnv("AWS_SECRET_ACCESS_KEY", "")
+	cfg.Encryption.KMSSessionToken = getEnv("AWS_SESSION_TOKEN", "")
+	cfg.Encryption.KMSEndpoint = getEnv("AWS_KMS_ENDPOINT", "")
+	cfg.Encryption.KMSCiphertexts = getEnvSlice("ENCRYPTION_KMS_CIPHERTEXTS", nil)
+	// age encrypted file of hex keys, one per line, current first
+	cfg.Encryption.AgeFile = getEnv("ENCRYPTION_AGE_FILE", "")
+	cfg.Encryption.AgeIdentityFile = getEnv("ENCRYPTION_AGE_IDENTITY_FILE", "")
+
+	// Message search. Messages are indexed by an HMAC of each word under this
+	// key. Kept separate from ENCRYPTION_KEY so rotating that doesn't mean
+	// re-indexing every message.
+	cfg.Search.TokenKey = getEnv("SEARCH_TOKEN_KEY", "fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210")
 
 	// Discord import integration
 	cfg.Discord.ImportToken = strings.TrimSpace(getEnv("DISCORD_IMPORT_TOKEN", ""))
@@ -143,6 +404,42 @@ func Load() (*Config, error) {
 	// GitHub API integration
 	cfg.GitHub.Token = strings.TrimSpace(getEnv("GITHUB_TOKEN", ""))
 
+	// GIF search, proxied so clients never talk to the provider. The content
+	// filter is Tenor's contentfilter (off, low, medium, high) or GIPHY's rating
+	// (g, pg, pg-13, r).
+	cfg.GIF.Provider = strings.ToLower(strings.TrimSpace(getEnv("GIF_PROVIDER", "tenor")))
+	cfg.GIF.APIKey = strings.TrimSpace(getEnv("GIF_API_KEY", ""))
+	cfg.GIF.ContentFilter = strings.ToLower(strings.TrimSpace(getEnv("GIF_CONTENT_FILTER", "")))
+	cfg.GIF.CacheTTL = getEnvDuration("GIF_CACHE_TTL", 10*time.Minute)
+	cfg.GIF.MediaBaseURL = getEnv("GIF_MEDIA_BASE_URL", "http://localhost:8080/api/v1/gifs/media")
+	cfg.GIF.MediaSecret = strings.TrimSpace(getEnv("GIF_MEDIA_SECRET", ""))
+
+	// Image proxy for link preview images, so clients don't reveal their IP
+	// address to the sites messages link to
+	cfg.Camo.Secret = strings.TrimSpace(getEnv("CAMO_SECRET", ""))
+	cfg.Camo.BaseURL = getEnv("CAMO_BASE_URL", "http://localhost:8080/camo")
+	cfg.Camo.MaxSize = getEnvInt64("CAMO_MAX_SIZE", 10<<20)
+
+	// Backups (cmd/backup). Kept separate from ENCRYPTION_KEY so a leaked archive
+	// alone is not enough to read message content.
+	cfg.Backup.Key = strings.TrimSpace(getEnv("BACKUP_ENCRYPTION_KEY", ""))
+
+	// Metrics endpoint. Leave empty to serve /metrics without auth (e.g. when it
+	// is only reachable from the internal network).
+	cfg.Metrics.Token = strings.TrimSpace(getEnv("METRICS_TOKEN", ""))
+
+	// Operator endpoints under /api/v1/admin. They are disabled while unset.
+	cfg.Admin.Token = strings.TrimSpace(getEnv("ADMIN_TOKEN", ""))
+
+	// Account bundles for moving to another instance. InstanceURL is this API's
+	// public origin, which importing instances fetch the verification key from;
+	// export is disabled while the signing key (a hex Ed25519 seed) is unset.
+	// Import only accepts bundles from the listed instance origins, since a
+	// bundle decides which portable identity the account takes over.
+	cfg.Portability.InstanceURL = strings.TrimRight(strings.TrimSpace(getEnv("INSTANCE_URL", "http://localhost:8080")), "/")
+	cfg.Portability.SigningKey = strings.TrimSpace(getEnv("PORTABILITY_SIGNING_KEY", ""))
+	cfg.Portability.TrustedIssuers = getEnvSlice("PORTABILITY_TRUSTED_ISSUERS", nil)
+
 	AppConfig = cfg
 	return cfg, nil
 }
@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/zentra/server/internal/utils"
+)
+
+// AdminTokenMiddleware guards operator endpoints with a static bearer token.
+// An empty token rejects every request so the endpoints are off by default.
+func AdminTokenMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				utils.RespondError(w, http.StatusNotFound, "Not found")
+				return
+			}
+
+			authHeader := r.Header.Get("Authorization")
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_AUTH_HEADER", "Invalid authorization header format")
+				return
+			}
+
+			if subtle.ConstantTimeCompare([]byte(parts[1]), []byte(token)) != 1 {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid token")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
+)
+
+const CommunityTokenKey contextKey = "communityToken"
+
+// CommunityTokenScheme is the Authorization scheme for community API tokens,
+// e.g. "Authorization: Community zct_..."
+const CommunityTokenScheme = "Community"
+
+// CommunityTokenAuthenticator resolves a raw community API token
+type CommunityTokenAuthenticator interface {
+	AuthenticateToken(ctx context.Context, token string) (*models.CommunityAPIToken, error)
+}
+
+// CommunityTokenMiddleware authenticates requests made with a community API
+// token. The token's bot user is stored as the user ID so per-user middleware
+// such as rate limiting applies to each token separately.
+func CommunityTokenMiddleware(authenticator CommunityTokenAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "AUTH_HEADER_REQUIRED", "Authorization header required")
+				return
+			}
+
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || parts[0] != CommunityTokenScheme {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_AUTH_HEADER", "Invalid authorization header format")
+				return
+			}
+
+			token, err := authenticator.AuthenticateToken(r.Context(), parts[1])
+			if err != nil {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid token")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), CommunityTokenKey, token)
+			ctx = context.WithValue(ctx, UserIDKey, token.BotUserID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetCommunityToken extracts the community API token from context
+func GetCommunityToken(ctx context.Context) (*models.CommunityAPIToken, bool) {
+	token, ok := ctx.Value(CommunityTokenKey).(*models.CommunityAPIToken)
+	return token, ok
+}
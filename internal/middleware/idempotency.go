@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/idempotency"
+	"github.com/zentra/server/internal/utils"
+)
+
+const (
+	// IdempotencyKeyHeader lets a client retry a POST without it running twice
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on responses replayed for a retry
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+
+	// Requests with larger bodies can't use an Idempotency-Key
+	maxFingerprintBody = 1 << 20
+	// Larger responses aren't stored, and the key is released instead
+	maxStoredResponse = 1 << 20
+)
+
+const idempotencyStoreKey contextKey = "idempotencyStore"
+
+// IdempotencyStore keeps Idempotency-Key reservations and their responses
+type IdempotencyStore interface {
+	Begin(ctx context.Context, userID uuid.UUID, key string, fingerprint []byte) (*idempotency.Response, time.Time, error)
+	Complete(ctx context.Context, userID uuid.UUID, key string, locked time.Time, resp *idempotency.Response) error
+	Release(ctx context.Context, userID uuid.UUID, key string, locked time.Time) error
+}
+
+// IdempotencyMiddleware hands store to the routes behind it that opt in to
+// Idempotency-Key with Idempotent
+func IdempotencyMiddleware(store IdempotencyStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), idempotencyStoreKey, store)))
+		})
+	}
+}
+
+// Idempotent makes an authenticated POST with an Idempotency-Key header safe
+// to retry. The first request with a key runs and its response is stored;
+// later ones with the same key, method, path and body get that response back
+// instead of running again. A key reused for a different request is
+// rejected, as is a retry while the first request still runs. Server errors
+// release the key so the retry runs again.
+//
+// Responses are kept for a day, so only routes whose responses hold no
+// secrets opt in: sending messages, which is what clients retry.
+func Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		userID, ok := GetUserID(r.Context())
+		store, hasStore := r.Context().Value(idempotencyStoreKey).(IdempotencyStore)
+		if key == "" || r.Method != http.MethodPost || !ok || !hasStore {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			utils.RespondErrorWithCode(w, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY",
+				"Idempotency-Key must be 1 to 255 printable ASCII characters")
+			return
+		}
+
+		fingerprint, err := fingerprintRequest(r)
+		if errors.Is(err, errBodyTooLarge) {
+			utils.RespondErrorWithCode(w, http.StatusRequestEntityTooLarge, "IDEMPOTENCY_BODY_TOO_LARGE",
+				"Requests with an Idempotency-Key can have a body of at most 1MB")
+			return
+		}
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+
+		stored, locked, err := store.Begin(r.Context(), userID, key, fingerprint)
+		switch {
+		case errors.Is(err, idempotency.ErrInProgress):
+			w.Header().Set("Retry-After", "1")
+			utils.RespondErrorWithCode(w, http.StatusConflict, "IDEMPOTENCY_KEY_IN_USE", "A request with this Idempotency-Key is still in progress")
+			return
+		case errors.Is(err, idempotency.ErrKeyReused):
+			utils.RespondErrorWithCode(w, http.StatusUnprocessableEntity, "IDEMPOTENCY_KEY_REUSED", "This Idempotency-Key was already used for a different request")
+			return
+		case err != nil:
+			log.Error().Err(err).Msg("Failed to reserve idempotency key")
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to process request")
+			return
+		case stored != nil:
+			replayResponse(w, stored)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// Release the key even if the client went away or the handler
+			// panicked
+			if completed {
+				return
+			}
+			if err := store.Release(context.WithoutCancel(r.Context()), userID, key, locked); err != nil {
+				log.Error().Err(err).Msg("Failed to release idempotency key")
+			}
+		}()
+
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= http.StatusInternalServerError || rec.overflow {
+			return
+		}
+		err = store.Complete(context.WithoutCancel(r.Context()), userID, key, locked, &idempotency.Response{
+			StatusCode:  rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Location:    rec.Header().Get("Location"),
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to store idempotent response")
+			return
+		}
+		completed = true
+	})
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+var errBodyTooLarge = errors.New("request body too large to fingerprint")
+
+// fingerprintRequest hashes what makes a retry the same request: the method,
+// path and the whole body. The body is read up front and put back for the
+// handler.
+func fingerprintRequest(r *http.Request) ([]byte, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxFingerprintBody+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxFingerprintBody {
+			return nil, errBodyTooLarge
+		}
+		h.Write(body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return h.Sum(nil), nil
+}
+
+func replayResponse(w http.ResponseWriter, resp *idempotency.Response) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	if resp.Location != "" {
+		w.Header().Set("Location", resp.Location)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
+// responseRecorder passes a response through while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+	overflow    bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	if !r.overflow {
+		if r.body.Len()+len(p) > maxStoredResponse {
+			r.overflow = true
+			r.body.Reset()
+		} else {
+			r.body.Write(p)
+		}
+	}
+	return r.ResponseWriter.Write(p)
+}
@@ -43,6 +43,11 @@ func (lrw *loggingResponseWriter) Flush() {
 	}
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 // RequestIDMiddleware adds a unique request ID to each request
 func RequestIDMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -90,7 +95,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 			Int("status", lrw.statusCode).
 			Int("size", lrw.size).
 			Dur("duration", duration).
-			Str("ip", getClientIP(r)).
+			Str("ip", ClientIP(r)).
 			Str("userAgent", r.UserAgent()).
 			Msg("HTTP request")
 	})
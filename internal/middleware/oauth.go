@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
+)
+
+const OAuthTokenKey contextKey = "oauthToken"
+
+// OAuthTokenAuthenticator resolves a raw OAuth2 access token
+type OAuthTokenAuthenticator interface {
+	AuthenticateAccessToken(ctx context.Context, token string) (*models.OAuthToken, error)
+}
+
+// OAuthMiddleware authenticates requests a third-party app makes on behalf of
+// a user. The user becomes the request's user ID; handlers still need
+// RequireOAuthScope to limit what the app can do.
+func OAuthMiddleware(authenticator OAuthTokenAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer`)
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "AUTH_HEADER_REQUIRED", "Authorization header required")
+				return
+			}
+
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_AUTH_HEADER", "Invalid authorization header format")
+				return
+			}
+
+			token, err := authenticator.AuthenticateAccessToken(r.Context(), parts[1])
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid token")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), OAuthTokenKey, token)
+			ctx = context.WithValue(ctx, UserIDKey, token.UserID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireOAuthScope rejects requests whose OAuth token lacks scope
+func RequireOAuthScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := GetOAuthToken(r.Context())
+			if !ok || !token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+scope+`"`)
+				utils.RespondErrorWithCode(w, http.StatusForbidden, "MISSING_SCOPE", "Token is missing the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetOAuthToken extracts the OAuth2 access token from context
+func GetOAuthToken(ctx context.Context) (*models.OAuthToken, bool) {
+	token, ok := ctx.Value(OAuthTokenKey).(*models.OAuthToken)
+	return token, ok
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+// Security schemes and the parts of the API description that come from
+// middlewares rather than handlers
+func init() {
+	openapi.DescribeSecurity("session", openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+		Description: "Access token from /auth/login or /auth/refresh",
+	})
+	openapi.DescribeSecurity("communityToken", openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: "Authorization",
+		Description: "A community API token, sent as `" + CommunityTokenScheme + " <token>`",
+	})
+	openapi.DescribeSecurity("pluginToken", openapi.SecurityScheme{
+		Type: "apiKey", In: "header", Name: "Authorization",
+		Description: "A plugin installation token, sent as `" + PluginTokenScheme + " <token>`",
+	})
+	openapi.DescribeSecurity("oauth", openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer",
+		Description: "An OAuth2 access token issued to a third-party app",
+	})
+	openapi.DescribeSecurity("adminToken", openapi.SecurityScheme{
+		Type: "http", Scheme: "bearer",
+		Description: "The operator's ADMIN_TOKEN",
+	})
+
+	unauthorized := map[int]string{http.StatusUnauthorized: "Missing or invalid credentials"}
+	openapi.DescribeMiddleware(AuthMiddleware, openapi.Middleware{Security: "session", Responses: unauthorized})
+	openapi.DescribeMiddleware(OptionalAuthMiddleware, openapi.Middleware{Security: "session", Optional: true})
+	openapi.DescribeMiddleware(CommunityTokenMiddleware, openapi.Middleware{Security: "communityToken", Responses: unauthorized})
+	openapi.DescribeMiddleware(PluginTokenMiddleware, openapi.Middleware{Security: "pluginToken", Responses: unauthorized})
+	openapi.DescribeMiddleware(OAuthMiddleware, openapi.Middleware{Security: "oauth", Responses: unauthorized})
+	openapi.DescribeMiddleware(AdminTokenMiddleware, openapi.Middleware{Security: "adminToken", Responses: unauthorized})
+
+	rateLimited := map[int]string{http.StatusTooManyRequests: "Rate limited; wait for Retry-After seconds"}
+	openapi.DescribeMiddleware(RateLimitMiddleware, openapi.Middleware{Responses: rateLimited})
+	openapi.DescribeMiddleware(StrictRateLimitMiddleware, openapi.Middleware{Responses: rateLimited})
+
+	openapi.DescribeMiddleware(Idempotent, openapi.Middleware{
+		Methods: []string{http.MethodPost},
+		Headers: []openapi.Param{{
+			Name:        IdempotencyKeyHeader,
+			Description: "Makes the request safe to retry: retries with the same key get the first response back",
+		}},
+		Responses: map[int]string{
+			http.StatusConflict:              "A request with this Idempotency-Key is still in progress",
+			http.StatusUnprocessableEntity:   "The Idempotency-Key was already used for a different request",
+			http.StatusRequestEntityTooLarge: "The body is too large to use an Idempotency-Key with",
+		},
+	})
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
+)
+
+const PluginTokenKey contextKey = "pluginToken"
+
+// PluginTokenScheme is the Authorization scheme for plugin API tokens,
+// e.g. "Authorization: Plugin zpt_..."
+const PluginTokenScheme = "Plugin"
+
+// PluginTokenAuthenticator resolves a raw plugin API token
+type PluginTokenAuthenticator interface {
+	AuthenticateAPIToken(ctx context.Context, token string) (*models.PluginAPIToken, error)
+}
+
+// PluginTokenMiddleware authenticates requests made with a plugin API token.
+// The installation's bot user is stored as the user ID so per-user middleware
+// such as rate limiting applies to each installation separately.
+func PluginTokenMiddleware(authenticator PluginTokenAuthenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "AUTH_HEADER_REQUIRED", "Authorization header required")
+				return
+			}
+
+			parts := strings.SplitN(authHeader, " ", 2)
+			if len(parts) != 2 || parts[0] != PluginTokenScheme {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_AUTH_HEADER", "Invalid authorization header format")
+				return
+			}
+
+			token, err := authenticator.AuthenticateAPIToken(r.Context(), parts[1])
+			if err != nil {
+				utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid token")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), PluginTokenKey, token)
+			ctx = context.WithValue(ctx, UserIDKey, token.BotUserID)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// GetPluginToken extracts the plugin API token from context
+func GetPluginToken(ctx context.Context) (*models.PluginAPIToken, bool) {
+	token, ok := ctx.Value(PluginTokenKey).(*models.PluginAPIToken)
+	return token, ok
+}
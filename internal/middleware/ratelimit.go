@@ -22,7 +22,7 @@ func RateLimitMiddleware(redisClient *redis.Client, rps int) func(http.Handler)
 			if userID, ok := GetUserID(ctx); ok {
 				key = fmt.Sprintf("user:%s", userID.String())
 			} else {
-				key = fmt.Sprintf("ip:%s", getClientIP(r))
+				key = fmt.Sprintf("ip:%s", ClientIP(r))
 			}
 
 			// Rate limit window is 1 second
@@ -60,7 +60,7 @@ func StrictRateLimitMiddleware(rps int) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
-			key := fmt.Sprintf("strict:%s:%s", r.URL.Path, getClientIP(r))
+			key := fmt.Sprintf("strict:%s:%s", r.URL.Path, ClientIP(r))
 
 			count, err := database.IncrementRateLimit(ctx, key, time.Minute)
 			if err != nil {
@@ -79,34 +79,6 @@ func StrictRateLimitMiddleware(rps int) func(http.Handler) http.Handler {
 	}
 }
 
-// getClientIP extracts the client IP from the request
-func getClientIP(r *http.Request) string {
-	// Check common proxy headers
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		// Take the first IP in the chain
-		for i := 0; i < len(xff); i++ {
-			if xff[i] == ',' {
-				return xff[:i]
-			}
-		}
-		return xff
-	}
-
-	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
-		return xrip
-	}
-
-	// Fall back to RemoteAddr
-	ip := r.RemoteAddr
-	// Remove port if present
-	for i := len(ip) - 1; i >= 0; i-- {
-		if ip[i] == ':' {
-			return ip[:i]
-		}
-	}
-	return ip
-}
-
 // TimeoutMiddleware adds a timeout to request context
 func TimeoutMiddleware(timeout time.Duration) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -0,0 +1,150 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// VerificationLevel is what a user's account must satisfy to join or talk in a community.
+// Each level includes the requirements of the ones below it.
+type VerificationLevel int16
+
+const (
+	VerificationLevelNone   VerificationLevel = 0
+	VerificationLevelLow    VerificationLevel = 1 // verified email
+	VerificationLevelMedium VerificationLevel = 2 // account older than 5 minutes
+	VerificationLevelHigh   VerificationLevel = 3 // member for 10 minutes before sending messages
+)
+
+const (
+	VerificationMinAccountAge = 5 * time.Minute
+	VerificationMinMemberAge  = 10 * time.Minute
+)
+
+// SpamSettings configures the rate-based spam and raid protections of a community.
+type SpamSettings struct {
+	CommunityID            uuid.UUID         `json:"communityId" db:"community_id"`
+	VerificationLevel      VerificationLevel `json:"verificationLevel" db:"verification_level"`
+	BurstEnabled           bool              `json:"burstEnabled" db:"burst_enabled"`
+	BurstMessages          int               `json:"burstMessages" db:"burst_messages"`
+	BurstWindowSeconds     int               `json:"burstWindowSeconds" db:"burst_window_seconds"`
+	DuplicateEnabled       bool              `json:"duplicateEnabled" db:"duplicate_enabled"`
+	DuplicateThreshold     int               `json:"duplicateThreshold" db:"duplicate_threshold"`
+	DuplicateWindowSeconds int               `json:"duplicateWindowSeconds" db:"duplicate_window_seconds"`
+	JoinSpikeEnabled       bool              `json:"joinSpikeEnabled" db:"join_spike_enabled"`
+	JoinSpikeThreshold     int               `json:"joinSpikeThreshold" db:"join_spike_threshold"`
+	JoinSpikeWindowSeconds int               `json:"joinSpikeWindowSeconds" db:"join_spike_window_seconds"`
+	RaidAutoEnable         bool              `json:"raidAutoEnable" db:"raid_auto_enable"`
+	RaidVerificationLevel  VerificationLevel `json:"raidVerificationLevel" db:"raid_verification_level"`
+	RaidDurationMinutes    int               `json:"raidDurationMinutes" db:"raid_duration_minutes"`
+	RaidPauseInvites       bool              `json:"raidPauseInvites" db:"raid_pause_invites"`
+	RaidModeUntil          *time.Time        `json:"raidModeUntil,omitempty" db:"raid_mode_until"`
+	RaidModeActive         bool              `json:"raidModeActive" db:"-"`
+
+	// Join velocity limits reject joins over the limit instead of only alerting
+	JoinLimitEnabled           bool `json:"joinLimitEnabled" db:"join_limit_enabled"`
+	CommunityJoinLimit         int  `json:"communityJoinLimit" db:"community_join_limit"`
+	CommunityJoinWindowSeconds int  `json:"communityJoinWindowSeconds" db:"community_join_window_seconds"`
+	InviteJoinLimit            int  `json:"inviteJoinLimit" db:"invite_join_limit"`
+	InviteJoinWindowSeconds    int  `json:"inviteJoinWindowSeconds" db:"invite_join_window_seconds"`
+
+	// IP clustering flags several accounts joining from one address
+	IPClusterEnabled       bool `json:"ipClusterEnabled" db:"ip_cluster_enabled"`
+	IPClusterThreshold     int  `json:"ipClusterThreshold" db:"ip_cluster_threshold"`
+	IPClusterWindowSeconds int  `json:"ipClusterWindowSeconds" db:"ip_cluster_window_seconds"`
+
+	// Reaction limits; a raid is many new members reacting in one channel
+	ReactionBurstEnabled       bool `json:"reactionBurstEnabled" db:"reaction_burst_enabled"`
+	ReactionBurstLimit         int  `json:"reactionBurstLimit" db:"reaction_burst_limit"`
+	ReactionBurstWindowSeconds int  `json:"reactionBurstWindowSeconds" db:"reaction_burst_window_seconds"`
+	ReactionRaidEnabled        bool `json:"reactionRaidEnabled" db:"reaction_raid_enabled"`
+	ReactionRaidThreshold      int  `json:"reactionRaidThreshold" db:"reaction_raid_threshold"`
+	ReactionRaidWindowSeconds  int  `json:"reactionRaidWindowSeconds" db:"reaction_raid_window_seconds"`
+}
+
+// DefaultSpamSettings mirrors the column defaults for communities that never saved settings.
+func DefaultSpamSettings(communityID uuid.UUID) *SpamSettings {
+	return &SpamSettings{
+		CommunityID:            communityID,
+		VerificationLevel:      VerificationLevelNone,
+		BurstEnabled:           true,
+		BurstMessages:          5,
+		BurstWindowSeconds:     5,
+		DuplicateEnabled:       true,
+		DuplicateThreshold:     3,
+		DuplicateWindowSeconds: 60,
+		JoinSpikeEnabled:       true,
+		JoinSpikeThreshold:     10,
+		JoinSpikeWindowSeconds: 60,
+		RaidAutoEnable:         true,
+		RaidVerificationLevel:  VerificationLevelMedium,
+		RaidDurationMinutes:    30,
+		RaidPauseInvites:       true,
+
+		JoinLimitEnabled:           true,
+		CommunityJoinLimit:         30,
+		CommunityJoinWindowSeconds: 60,
+		InviteJoinLimit:            10,
+		InviteJoinWindowSeconds:    60,
+
+		IPClusterEnabled:       true,
+		IPClusterThreshold:     3,
+		IPClusterWindowSeconds: 3600,
+
+		ReactionBurstEnabled:       true,
+		ReactionBurstLimit:         10,
+		ReactionBurstWindowSeconds: 10,
+		ReactionRaidEnabled:        true,
+		ReactionRaidThreshold:      8,
+		ReactionRaidWindowSeconds:  60,
+	}
+}
+
+// EffectiveVerificationLevel is the configured level, raised to the raid level while raid mode is on.
+func (s *SpamSettings) EffectiveVerificationLevel() VerificationLevel {
+	if s.RaidModeActive && s.RaidVerificationLevel > s.VerificationLevel {
+		return s.RaidVerificationLevel
+	}
+	return s.VerificationLevel
+}
+
+// JoinAttempt describes a user about to join a community
+type JoinAttempt struct {
+	CommunityID uuid.UUID
+	UserID      uuid.UUID
+	InviteID    *uuid.UUID // nil for direct joins to open communities
+	IP          string
+}
+
+// JoinVerdict is the outcome of screening a join
+type JoinVerdict int
+
+const (
+	JoinAllowed JoinVerdict = iota
+	JoinBelowVerification
+	JoinRateLimited
+	JoinInvitesPaused
+)
+
+// Moderation alert states
+const (
+	ModerationAlertOpen     = "open"
+	ModerationAlertResolved = "resolved"
+)
+
+// ModerationAlert is an automated report waiting in a community's moderation queue
+type ModerationAlert struct {
+	ID          uuid.UUID      `json:"id" db:"id"`
+	CommunityID uuid.UUID      `json:"communityId" db:"community_id"`
+	Kind        string         `json:"kind" db:"kind"`
+	Title       string         `json:"title" db:"title"`
+	Body        string         `json:"body" db:"body"`
+	ChannelID   *uuid.UUID     `json:"channelId,omitempty" db:"channel_id"`
+	UserIDs     []uuid.UUID    `json:"userIds" db:"user_ids"`
+	Metadata    map[string]any `json:"metadata,omitempty" db:"metadata"`
+	Status      string         `json:"status" db:"status"`
+	ResolvedBy  *uuid.UUID     `json:"resolvedBy,omitempty" db:"resolved_by"`
+	ResolvedAt  *time.Time     `json:"resolvedAt,omitempty" db:"resolved_at"`
+	CreatedAt   time.Time      `json:"createdAt" db:"created_at"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes a community API token can be granted
+const (
+	// APITokenScopeSendMessages allows posting in the token's channels
+	APITokenScopeSendMessages int64 = 1 << iota
+	// APITokenScopeManageInvites allows listing, creating and deleting invites
+	APITokenScopeManageInvites
+)
+
+// APITokenScopeAll is every scope a token can hold
+const APITokenScopeAll = APITokenScopeSendMessages | APITokenScopeManageInvites
+
+// CommunityAPIToken lets automation act on one community without a user
+// account. Actions are attributed to the token's bot user.
+type CommunityAPIToken struct {
+	ID           uuid.UUID   `json:"id" db:"id"`
+	CommunityID  uuid.UUID   `json:"communityId" db:"community_id"`
+	BotUserID    uuid.UUID   `json:"botUserId" db:"bot_user_id"`
+	Name         string      `json:"name" db:"name"`
+	TokenPreview string      `json:"tokenPreview" db:"token_preview"`
+	Scopes       int64       `json:"scopes" db:"scopes"`
+	ChannelIDs   []uuid.UUID `json:"channelIds" db:"channel_ids"`
+	ExpiresAt    *time.Time  `json:"expiresAt,omitempty" db:"expires_at"`
+	LastUsedAt   *time.Time  `json:"lastUsedAt,omitempty" db:"last_used_at"`
+	CreatedBy    *uuid.UUID  `json:"createdBy,omitempty" db:"created_by"`
+	CreatedAt    time.Time   `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time   `json:"updatedAt" db:"updated_at"`
+}
+
+// HasScope reports whether the token was granted scope
+func (t *CommunityAPIToken) HasScope(scope int64) bool {
+	return t.Scopes&scope == scope
+}
+
+// CanPostIn reports whether the token may send messages to channelID
+func (t *CommunityAPIToken) CanPostIn(channelID uuid.UUID) bool {
+	if !t.HasScope(APITokenScopeSendMessages) {
+		return false
+	}
+	for _, id := range t.ChannelIDs {
+		if id == channelID {
+			return true
+		}
+	}
+	return false
+}
@@ -23,6 +23,8 @@ const (
 	AuditActionCommunityCreate = "community.create"
 	AuditActionCommunityUpdate = "community.update"
 	AuditActionCommunityDelete = "community.delete"
+	AuditActionCommunityImport = "community.import"
+	AuditActionCommunityExport = "community.export"
 	AuditActionChannelCreate   = "channel.create"
 	AuditActionChannelUpdate   = "channel.update"
 	AuditActionChannelDelete   = "channel.delete"
@@ -31,6 +33,18 @@ const (
 	AuditActionMemberKick      = "member.kick"
 	AuditActionMemberBan       = "member.ban"
 	AuditActionMemberUnban     = "member.unban"
+	AuditActionMemberWarn      = "member.warn"
+	AuditActionMemberTimeout   = "member.timeout"
+	AuditActionMemberUntimeout = "member.timeout_remove"
+	AuditActionQuarantineAdd   = "member.quarantine"
+	AuditActionQuarantineLift  = "member.quarantine_lift"
+	AuditActionLockdownEnable  = "community.lockdown_enable"
+	AuditActionLockdownDisable = "community.lockdown_disable"
+	AuditActionPartnerPropose  = "community.partner_propose"
+	AuditActionPartnerAccept   = "community.partner_accept"
+	AuditActionPartnerRemove   = "community.partner_remove"
+	AuditActionEditPolicy      = "community.edit_policy_update"
+	AuditActionCaseUpdate      = "moderation.case_update"
 	AuditActionRoleCreate      = "role.create"
 	AuditActionRoleUpdate      = "role.update"
 	AuditActionRoleDelete      = "role.delete"
@@ -39,6 +53,23 @@ const (
 	AuditActionMessageDelete   = "message.delete"
 	AuditActionMessagePin      = "message.pin"
 	AuditActionMessageUnpin    = "message.unpin"
+	AuditActionAutoModCreate   = "automod.rule_create"
+	AuditActionAutoModUpdate   = "automod.rule_update"
+	AuditActionAutoModDelete   = "automod.rule_delete"
+	AuditActionSpamSettings    = "antispam.settings_update"
+	AuditActionRaidModeEnable  = "antispam.raid_mode_enable"
+	AuditActionRaidModeDisable = "antispam.raid_mode_disable"
+	AuditActionEventHookCreate = "event_hook.create"
+	AuditActionEventHookUpdate = "event_hook.update"
+	AuditActionEventHookDelete = "event_hook.delete"
+	AuditActionAPITokenCreate  = "api_token.create"
+	AuditActionAPITokenUpdate  = "api_token.update"
+	AuditActionAPITokenRevoke  = "api_token.revoke"
+	AuditActionAPITokenMessage = "api_token.message_send"
+	AuditActionBroadcastSend   = "broadcast.send"
+	AuditActionBroadcastCancel = "broadcast.cancel"
+	AuditActionSoundCreate     = "soundboard.sound_create"
+	AuditActionSoundDelete     = "soundboard.sound_delete"
 )
 
 type AuditLogWithActor struct {
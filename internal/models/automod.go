@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AutoMod trigger types
+const (
+	AutoModTriggerKeyword     = "keyword"
+	AutoModTriggerRegex       = "regex"
+	AutoModTriggerInviteLink  = "invite_link"
+	AutoModTriggerMentionSpam = "mention_spam"
+)
+
+// AutoMod actions, from least to most severe
+const (
+	AutoModActionFlag   = "flag"   // let the message through, log it for moderators
+	AutoModActionDelete = "delete" // store the message already deleted so mods can review it
+	AutoModActionBlock  = "block"  // reject the message outright
+)
+
+// AutoModRule is a single per-community filter
+type AutoModRule struct {
+	ID               uuid.UUID   `json:"id" db:"id"`
+	CommunityID      uuid.UUID   `json:"communityId" db:"community_id"`
+	Name             string      `json:"name" db:"name"`
+	TriggerType      string      `json:"triggerType" db:"trigger_type"`
+	Action           string      `json:"action" db:"action"`
+	Keywords         []string    `json:"keywords" db:"keywords"`
+	Patterns         []string    `json:"patterns" db:"patterns"`
+	MentionLimit     int         `json:"mentionLimit" db:"mention_limit"`
+	ExemptRoleIDs    []uuid.UUID `json:"exemptRoleIds" db:"exempt_role_ids"`
+	ExemptChannelIDs []uuid.UUID `json:"exemptChannelIds" db:"exempt_channel_ids"`
+	Enabled          bool        `json:"enabled" db:"enabled"`
+	CreatedBy        uuid.UUID   `json:"createdBy" db:"created_by"`
+	CreatedAt        time.Time   `json:"createdAt" db:"created_at"`
+	UpdatedAt        time.Time   `json:"updatedAt" db:"updated_at"`
+}
+
+// AutoModLogEntry records a rule firing on a message
+type AutoModLogEntry struct {
+	ID          uuid.UUID  `json:"id" db:"id"`
+	CommunityID uuid.UUID  `json:"communityId" db:"community_id"`
+	RuleID      *uuid.UUID `json:"ruleId,omitempty" db:"rule_id"`
+	RuleName    string     `json:"ruleName" db:"rule_name"`
+	// ChannelID is nil for direct messages
+	ChannelID   *uuid.UUID `json:"channelId,omitempty" db:"channel_id"`
+	UserID      uuid.UUID  `json:"userId" db:"user_id"`
+	MessageID   *uuid.UUID `json:"messageId,omitempty" db:"message_id"`
+	TriggerType string     `json:"triggerType" db:"trigger_type"`
+	Action      string     `json:"action" db:"action"`
+	Matched     string     `json:"matched" db:"matched"`
+	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
+}
+
+type AutoModLogEntryWithUser struct {
+	AutoModLogEntry
+	User *PublicUser `json:"user,omitempty"`
+}
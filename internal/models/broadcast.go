@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Broadcast states
+const (
+	BroadcastSending   = "sending"
+	BroadcastCompleted = "completed"
+	BroadcastCancelled = "cancelled"
+)
+
+// Broadcast recipient states
+const (
+	BroadcastRecipientPending = "pending"
+	BroadcastRecipientSent    = "sent"
+	BroadcastRecipientFailed  = "failed"
+	BroadcastRecipientSkipped = "skipped" // left, opted out or blocked the sender before delivery
+)
+
+// Broadcast is a one-way announcement DM sent by a community to every member
+// who opted in
+type Broadcast struct {
+	ID          uuid.UUID      `json:"id" db:"id"`
+	CommunityID uuid.UUID      `json:"communityId" db:"community_id"`
+	AuthorID    *uuid.UUID     `json:"authorId,omitempty" db:"author_id"`
+	Content     string         `json:"content" db:"-"`
+	Status      string         `json:"status" db:"status"`
+	Stats       BroadcastStats `json:"stats" db:"-"`
+	CreatedAt   time.Time      `json:"createdAt" db:"created_at"`
+	CompletedAt *time.Time     `json:"completedAt,omitempty" db:"completed_at"`
+}
+
+// BroadcastStats counts a broadcast's recipients by delivery state
+type BroadcastStats struct {
+	Total   int `json:"total"`
+	Pending int `json:"pending"`
+	Sent    int `json:"sent"`
+	Failed  int `json:"failed"`
+	Skipped int `json:"skipped"`
+}
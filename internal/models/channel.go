@@ -20,19 +20,44 @@ const (
 
 // Capability flags for channel types - determines what features a channel supports
 const (
-	CapMessages  int64 = 1 << iota // 1 - basic text messaging
-	CapThreads                     // 2 - threaded replies
-	CapMedia                       // 4 - media-first content
-	CapVoice                       // 8 - real-time voice
-	CapVideo                       // 16 - real-time video
-	CapEmbeds                      // 32 - rich embeds and link previews
-	CapPins                        // 64 - message pinning
-	CapReactions                   // 128 - emoji reactions
-	CapSlowmode                    // 256 - rate limiting per user
-	CapReadOnly                    // 512 - only privileged users can post
-	CapTopics                      // 1024 - topic/thread-starter based
+	CapMessages   int64 = 1 << iota // 1 - basic text messaging
+	CapThreads                      // 2 - threaded replies
+	CapMedia                        // 4 - media-first content
+	CapVoice                        // 8 - real-time voice
+	CapVideo                        // 16 - real-time video
+	CapEmbeds                       // 32 - rich embeds and link previews
+	CapPins                         // 64 - message pinning
+	CapReactions                    // 128 - emoji reactions
+	CapSlowmode                     // 256 - rate limiting per user
+	CapReadOnly                     // 512 - only privileged users can post
+	CapTopics                       // 1024 - topic/thread-starter based
+	CapMediaBoard                   // 2048 - board of media items
+	CapKanban                       // 4096 - kanban board of cards in columns
+	CapWiki                         // 8192 - wiki pages addressed by slug
 )
 
+// CapItems are the capabilities whose channels hold structured items rather
+// than (or as well as) messages
+const CapItems = CapMediaBoard | CapKanban | CapWiki
+
+// ChannelCapabilityNames maps the capability names plugin manifests use to
+// their flags. Voice and video need server support plugins can't provide, so
+// they can't be declared.
+var ChannelCapabilityNames = map[string]int64{
+	"messages":   CapMessages,
+	"threads":    CapThreads,
+	"media":      CapMedia,
+	"embeds":     CapEmbeds,
+	"pins":       CapPins,
+	"reactions":  CapReactions,
+	"slowmode":   CapSlowmode,
+	"readOnly":   CapReadOnly,
+	"topics":     CapTopics,
+	"mediaBoard": CapMediaBoard,
+	"kanban":     CapKanban,
+	"wiki":       CapWiki,
+}
+
 // ChannelTypeDefinition describes a registered channel type
 type ChannelTypeDefinition struct {
 	ID              string          `json:"id" db:"id"`
@@ -53,15 +78,34 @@ func (d *ChannelTypeDefinition) HasCapability(cap int64) bool {
 
 // VoiceState represents a user's voice connection state in a voice channel
 type VoiceState struct {
-	ID              uuid.UUID `json:"id" db:"id"`
-	ChannelID       uuid.UUID `json:"channelId" db:"channel_id"`
-	UserID          uuid.UUID `json:"userId" db:"user_id"`
-	IsMuted         bool      `json:"isMuted" db:"is_muted"`
-	IsDeafened      bool      `json:"isDeafened" db:"is_deafened"`
-	IsSelfMuted     bool      `json:"isSelfMuted" db:"is_self_muted"`
-	IsSelfDeaf      bool      `json:"isSelfDeafened" db:"is_self_deafened"`
-	IsScreenSharing bool      `json:"isScreenSharing" db:"is_screen_sharing"`
-	JoinedAt        time.Time `json:"joinedAt" db:"joined_at"`
+	ID              uuid.UUID     `json:"id" db:"id"`
+	ChannelID       uuid.UUID     `json:"channelId" db:"channel_id"`
+	UserID          uuid.UUID     `json:"userId" db:"user_id"`
+	IsMuted         bool          `json:"isMuted" db:"is_muted"`
+	IsDeafened      bool          `json:"isDeafened" db:"is_deafened"`
+	IsSelfMuted     bool          `json:"isSelfMuted" db:"is_self_muted"`
+	IsSelfDeaf      bool          `json:"isSelfDeafened" db:"is_self_deafened"`
+	IsScreenSharing bool          `json:"isScreenSharing" db:"is_screen_sharing"`
+	IsVideo         bool          `json:"isVideo" db:"is_video"`
+	Streams         []VoiceStream `json:"streams" db:"streams"`
+	JoinedAt        time.Time     `json:"joinedAt" db:"joined_at"`
+}
+
+// Kinds of stream a voice participant can publish besides their microphone
+const (
+	VoiceStreamCamera = "camera"
+	VoiceStreamScreen = "screen"
+)
+
+// VoiceStream describes one of a participant's outgoing video streams, as
+// reported by their client, so others can size its tile before it arrives
+type VoiceStream struct {
+	Kind      string `json:"kind"`
+	TrackID   string `json:"trackId,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	FrameRate int    `json:"frameRate,omitempty"`
+	HasAudio  bool   `json:"hasAudio,omitempty"`
 }
 
 // VoiceStateWithUser includes user info for display
@@ -88,12 +132,18 @@ type Channel struct {
 	Position        int             `json:"position" db:"position"`
 	IsNSFW          bool            `json:"isNsfw" db:"is_nsfw"`
 	SlowmodeSeconds int             `json:"slowmodeSeconds" db:"slowmode_seconds"`
+	UserLimit       int             `json:"userLimit" db:"user_limit"` // voice channels; 0 for no limit
 	Metadata        json.RawMessage `json:"metadata" db:"metadata"`
 	LastMessageAt   *time.Time      `json:"lastMessageAt,omitempty" db:"last_message_at"`
+	ManagedByPlugin *uuid.UUID      `json:"managedByPlugin,omitempty" db:"managed_by_plugin"` // plugin that created the channel
+	ArchivedAt      *time.Time      `json:"archivedAt,omitempty" db:"archived_at"`
 	CreatedAt       time.Time       `json:"createdAt" db:"created_at"`
 	UpdatedAt       time.Time       `json:"updatedAt" db:"updated_at"`
 }
 
+// ArchivedRevokedPermissions are taken away from members in an archived channel
+const ArchivedRevokedPermissions = PermissionSendMessages | PermissionAddReactions | PermissionVoiceSpeak | PermissionVoiceConnect
+
 type ChannelPermission struct {
 	ID               uuid.UUID `json:"id" db:"id"`
 	ChannelID        uuid.UUID `json:"channelId" db:"channel_id"`
@@ -107,3 +157,35 @@ type ChannelWithCategory struct {
 	Channel
 	CategoryName *string `json:"categoryName,omitempty" db:"category_name"`
 }
+
+// ChannelReadState is one user's position in a channel and when they last used it
+type ChannelReadState struct {
+	ChannelID         uuid.UUID  `json:"channelId" db:"channel_id"`
+	CommunityID       uuid.UUID  `json:"communityId" db:"community_id"`
+	LastReadMessageID *uuid.UUID `json:"lastReadMessageId,omitempty" db:"last_read_message_id"`
+	LastReadAt        *time.Time `json:"lastReadAt,omitempty" db:"last_read_at"`
+	LastInteractionAt time.Time  `json:"lastInteractionAt" db:"last_interaction_at"`
+}
+
+// ChannelUnreadCount is how much a user hasn't read in one channel since their
+// last ack
+type ChannelUnreadCount struct {
+	ChannelID    uuid.UUID `json:"channelId"`
+	CommunityID  uuid.UUID `json:"communityId"`
+	UnreadCount  int       `json:"unreadCount"`
+	MentionCount int       `json:"mentionCount"`
+}
+
+// CommunityUnreadCount totals a user's unread counts across a community's channels
+type CommunityUnreadCount struct {
+	CommunityID  uuid.UUID `json:"communityId"`
+	UnreadCount  int       `json:"unreadCount"`
+	MentionCount int       `json:"mentionCount"`
+}
+
+// UnreadCounts are the badges for all of a user's communities. Channels and
+// communities with nothing unread are left out.
+type UnreadCounts struct {
+	Channels    []*ChannelUnreadCount   `json:"channels"`
+	Communities []*CommunityUnreadCount `json:"communities"`
+}
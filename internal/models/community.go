@@ -19,14 +19,23 @@ type Community struct {
 	CreatedAt   time.Time  `json:"createdAt" db:"created_at"`
 	UpdatedAt   time.Time  `json:"updatedAt" db:"updated_at"`
 	DeletedAt   *time.Time `json:"-" db:"deleted_at"`
+
+	// Partners is filled on the profile and discovery views only
+	Partners []*PartnerCommunity `json:"partners,omitempty" db:"-"`
 }
 
 type CommunityMember struct {
-	ID          uuid.UUID `json:"id" db:"id"`
-	CommunityID uuid.UUID `json:"communityId" db:"community_id"`
-	UserID      uuid.UUID `json:"userId" db:"user_id"`
-	Nickname    *string   `json:"nickname,omitempty" db:"nickname"`
-	JoinedAt    time.Time `json:"joinedAt" db:"joined_at"`
+	ID           uuid.UUID  `json:"id" db:"id"`
+	CommunityID  uuid.UUID  `json:"communityId" db:"community_id"`
+	UserID       uuid.UUID  `json:"userId" db:"user_id"`
+	Nickname     *string    `json:"nickname,omitempty" db:"nickname"`
+	JoinedAt     time.Time  `json:"joinedAt" db:"joined_at"`
+	TimeoutUntil *time.Time `json:"timeoutUntil,omitempty" db:"timeout_until"`
+}
+
+// TimedOut reports whether the member is currently serving a timeout
+func (m *CommunityMember) TimedOut() bool {
+	return m.TimeoutUntil != nil && m.TimeoutUntil.After(time.Now())
 }
 
 type CommunityMemberWithUser struct {
@@ -35,6 +44,45 @@ type CommunityMemberWithUser struct {
 	User  *PublicUser `json:"user,omitempty"`
 }
 
+// Partner link states
+const (
+	PartnerStatusPending  = "pending"
+	PartnerStatusAccepted = "accepted"
+	PartnerStatusDeclined = "declined"
+)
+
+// PartnerCommunity is the card shown for a linked community
+type PartnerCommunity struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	IconURL     *string   `json:"iconUrl,omitempty" db:"icon_url"`
+	MemberCount int       `json:"memberCount" db:"member_count"`
+	IsPublic    bool      `json:"isPublic" db:"is_public"`
+}
+
+// CommunityPartner is a partner link as seen from one of its two communities.
+// Outgoing is true when that community proposed it.
+type CommunityPartner struct {
+	ID         uuid.UUID         `json:"id" db:"id"`
+	Community  *PartnerCommunity `json:"community"`
+	Status     string            `json:"status" db:"status"`
+	Outgoing   bool              `json:"outgoing"`
+	ProposedBy *uuid.UUID        `json:"proposedBy,omitempty" db:"proposed_by"`
+	AcceptedBy *uuid.UUID        `json:"acceptedBy,omitempty" db:"accepted_by"`
+	CreatedAt  time.Time         `json:"createdAt" db:"created_at"`
+	AcceptedAt *time.Time        `json:"acceptedAt,omitempty" db:"accepted_at"`
+}
+
+// EditPolicy limits when authors may edit their messages. Members with
+// Manage Messages in the channel are exempt.
+type EditPolicy struct {
+	CommunityID       uuid.UUID  `json:"communityId" db:"community_id"`
+	EditWindowMinutes *int       `json:"editWindowMinutes,omitempty" db:"edit_window_minutes"` // nil never closes
+	LockPinned        bool       `json:"lockPinned" db:"lock_pinned"`
+	UpdatedBy         *uuid.UUID `json:"updatedBy,omitempty" db:"updated_by"`
+	UpdatedAt         *time.Time `json:"updatedAt,omitempty" db:"updated_at"`
+}
+
 type CommunityInvite struct {
 	ID          uuid.UUID  `json:"id" db:"id"`
 	CommunityID uuid.UUID  `json:"communityId" db:"community_id"`
@@ -101,11 +149,15 @@ const (
 	PermissionVoiceMuteOthers   int64 = 1 << 18
 	PermissionVoiceDeafenOthers int64 = 1 << 19
 	PermissionManageEmojis      int64 = 1 << 20
+	PermissionVoiceMoveMembers  int64 = 1 << 21
 
 	// Combined permission sets
 	PermissionAllText  int64 = PermissionViewChannels | PermissionSendMessages | PermissionAddReactions | PermissionAttachFiles | PermissionCreateInvites
 	PermissionAllVoice int64 = PermissionVoiceConnect | PermissionVoiceSpeak
 	PermissionAllAdmin int64 = PermissionAdministrator | PermissionManageCommunity | PermissionManageChannels | PermissionManageRoles | PermissionManageMessages | PermissionManageEmojis | PermissionPinMessages
+
+	// What the default role of a new community starts with
+	PermissionDefaultMember int64 = PermissionAllText | PermissionAllVoice
 )
 
 func HasPermission(userPermissions, required int64) bool {
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Component types
+const (
+	ComponentTypeButton = "button"
+	ComponentTypeSelect = "select"
+)
+
+// Button styles. Link buttons open URL and never create an interaction.
+const (
+	ButtonStylePrimary   = "primary"
+	ButtonStyleSecondary = "secondary"
+	ButtonStyleSuccess   = "success"
+	ButtonStyleDanger    = "danger"
+	ButtonStyleLink      = "link"
+)
+
+// Component limits
+const (
+	MaxComponentRows       = 5
+	MaxComponentsPerRow    = 5
+	MaxSelectOptions       = 25
+	InteractionTokenExpiry = 15 * time.Minute
+)
+
+// ComponentRow is one horizontal row of components under a bot message. A
+// select menu fills its row on its own.
+type ComponentRow struct {
+	Components []MessageComponent `json:"components" validate:"required,min=1,max=5,dive"`
+}
+
+// MessageComponent is a button or select menu attached to a bot message
+type MessageComponent struct {
+	Type     string `json:"type" validate:"required,oneof=button select"`
+	CustomID string `json:"customId,omitempty" validate:"omitempty,max=100"`
+	Disabled bool   `json:"disabled,omitempty"`
+
+	// Buttons
+	Label string `json:"label,omitempty" validate:"omitempty,max=80"`
+	Style string `json:"style,omitempty" validate:"omitempty,oneof=primary secondary success danger link"`
+	Emoji string `json:"emoji,omitempty" validate:"omitempty,max=64"`
+	URL   string `json:"url,omitempty" validate:"omitempty,url,max=512"`
+
+	// Select menus
+	Placeholder string         `json:"placeholder,omitempty" validate:"omitempty,max=150"`
+	Options     []SelectOption `json:"options,omitempty" validate:"omitempty,max=25,dive"`
+	MinValues   *int           `json:"minValues,omitempty" validate:"omitempty,min=0,max=25"`
+	MaxValues   *int           `json:"maxValues,omitempty" validate:"omitempty,min=1,max=25"`
+}
+
+// SelectOption is one choice of a select menu
+type SelectOption struct {
+	Label       string `json:"label" validate:"required,max=100"`
+	Value       string `json:"value" validate:"required,max=100"`
+	Description string `json:"description,omitempty" validate:"omitempty,max=100"`
+	Default     bool   `json:"default,omitempty"`
+}
+
+// MessageInteraction is a member's click on a component of a bot message,
+// waiting to be picked up and answered by the bot that sent the message
+type MessageInteraction struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	MessageID     uuid.UUID  `json:"messageId" db:"message_id"`
+	ChannelID     uuid.UUID  `json:"channelId" db:"channel_id"`
+	CommunityID   uuid.UUID  `json:"communityId" db:"community_id"`
+	APITokenID    uuid.UUID  `json:"-" db:"api_token_id"`
+	UserID        uuid.UUID  `json:"userId" db:"user_id"`
+	CustomID      string     `json:"customId" db:"custom_id"`
+	ComponentType string     `json:"componentType" db:"component_type"`
+	Values        []string   `json:"values" db:"selected_values"`
+	DeliveredAt   *time.Time `json:"deliveredAt,omitempty" db:"delivered_at"`
+	RespondedAt   *time.Time `json:"respondedAt,omitempty" db:"responded_at"`
+	ExpiresAt     time.Time  `json:"expiresAt" db:"expires_at"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+
+	// Token is set when the interaction is handed to the bot; the bot sends it
+	// back to respond
+	Token string      `json:"token,omitempty" db:"-"`
+	User  *PublicUser `json:"user,omitempty" db:"-"`
+}
@@ -13,8 +13,12 @@ type CustomEmoji struct {
 	ImageURL    string    `json:"imageUrl" db:"image_url"`
 	UploaderID  uuid.UUID `json:"uploaderId" db:"uploader_id"`
 	Animated    bool      `json:"animated" db:"animated"`
-	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
-	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+	// AllowExternal lets members use the emoji in other communities and DMs
+	AllowExternal bool `json:"allowExternal" db:"allow_external"`
+	// Other names the emoji can be typed as
+	Aliases   []string  `json:"aliases"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
 }
 
 // CustomEmojiWithCommunity includes the community name for cross-server usage
@@ -22,3 +26,11 @@ type CustomEmojiWithCommunity struct {
 	CustomEmoji
 	CommunityName string `json:"communityName" db:"community_name"`
 }
+
+// CustomEmojiUsage is how often an emoji has been used in messages and reactions
+type CustomEmojiUsage struct {
+	CustomEmoji
+	MessageCount  int64      `json:"messageCount" db:"message_count"`
+	ReactionCount int64      `json:"reactionCount" db:"reaction_count"`
+	LastUsedAt    *time.Time `json:"lastUsedAt,omitempty" db:"last_used_at"` // nil if never used
+}
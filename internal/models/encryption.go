@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DecryptionFailure is a piece of stored content that failed to decrypt
+type DecryptionFailure struct {
+	Kind              string     `json:"kind" db:"kind"`
+	ContentID         uuid.UUID  `json:"contentId" db:"content_id"`
+	ContainerID       uuid.UUID  `json:"containerId" db:"container_id"`
+	KeyVersion        string     `json:"keyVersion" db:"key_version"`
+	Error             string     `json:"error" db:"error"`
+	Occurrences       int        `json:"occurrences" db:"occurrences"`
+	Unrecoverable     bool       `json:"unrecoverable" db:"unrecoverable"`
+	ContentCreatedAt  *time.Time `json:"contentCreatedAt,omitempty" db:"-"` // nil once the content is deleted
+	FirstSeenAt       time.Time  `json:"firstSeenAt" db:"first_seen_at"`
+	LastSeenAt        time.Time  `json:"lastSeenAt" db:"last_seen_at"`
+	RepairAttemptedAt *time.Time `json:"repairAttemptedAt,omitempty" db:"repair_attempted_at"`
+}
+
+// DecryptionFailureRange groups failures in one channel, conversation or
+// community that were seen with the same key
+type DecryptionFailureRange struct {
+	Kind          string     `json:"kind"`
+	ContainerID   uuid.UUID  `json:"containerId"`
+	KeyVersion    string     `json:"keyVersion"`
+	Failures      int        `json:"failures"`
+	Unrecoverable int        `json:"unrecoverable"`
+	OldestContent *time.Time `json:"oldestContent,omitempty"`
+	NewestContent *time.Time `json:"newestContent,omitempty"`
+	FirstSeenAt   time.Time  `json:"firstSeenAt"`
+	LastSeenAt    time.Time  `json:"lastSeenAt"`
+}
+
+// DecryptionReport summarises recorded failures for operators
+type DecryptionReport struct {
+	CurrentKeyVersion   string                    `json:"currentKeyVersion"`
+	PreviousKeyVersions []string                  `json:"previousKeyVersions"`
+	TotalFailures       int                       `json:"totalFailures"`
+	Unrecoverable       int                       `json:"unrecoverable"`
+	Ranges              []*DecryptionFailureRange `json:"ranges"`
+}
+
+// DecryptionRepairResult is the outcome of one repair or re-encrypt pass
+type DecryptionRepairResult struct {
+	DryRun        bool `json:"dryRun"`
+	Scanned       int  `json:"scanned"`
+	Readable      int  `json:"readable"`      // envelope wrapped with the current key, nothing to do
+	Rewrapped     int  `json:"rewrapped"`     // data key re-wrapped from a previous key to the current one
+	Repaired      int  `json:"repaired"`      // written before envelope encryption or in an unbound envelope, re-encrypted into a bound one
+	Unrecoverable int  `json:"unrecoverable"` // no configured key can decrypt it
+	Missing       int  `json:"missing"`       // content was deleted since the failure was seen
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Community events that can be delivered to an event hook
+const (
+	EventHookMessageCreate  = "message.create"
+	EventHookMessageUpdate  = "message.update"
+	EventHookMessageDelete  = "message.delete"
+	EventHookReactionAdd    = "reaction.add"
+	EventHookReactionRemove = "reaction.remove"
+	EventHookMemberJoin     = "member.join"
+	EventHookMemberLeave    = "member.leave"
+	EventHookMemberKick     = "member.kick"
+	EventHookMemberBan      = "member.ban"
+	EventHookPing           = "ping" // sent on demand to test an endpoint, not subscribable
+)
+
+// EventHookTypes lists the event types a hook can subscribe to
+var EventHookTypes = []string{
+	EventHookMessageCreate, EventHookMessageUpdate, EventHookMessageDelete,
+	EventHookReactionAdd, EventHookReactionRemove,
+	EventHookMemberJoin, EventHookMemberLeave, EventHookMemberKick, EventHookMemberBan,
+}
+
+// Delivery states
+const (
+	EventDeliveryPending   = "pending"
+	EventDeliverySucceeded = "succeeded"
+	EventDeliveryFailed    = "failed"
+)
+
+// EventHook is an HTTPS endpoint that receives a community's events
+type EventHook struct {
+	ID                  uuid.UUID  `json:"id" db:"id"`
+	CommunityID         uuid.UUID  `json:"communityId" db:"community_id"`
+	URL                 string     `json:"url" db:"url"`
+	EventTypes          []string   `json:"eventTypes" db:"event_types"`
+	IsActive            bool       `json:"isActive" db:"is_active"`
+	ConsecutiveFailures int        `json:"consecutiveFailures" db:"consecutive_failures"`
+	DisabledReason      *string    `json:"disabledReason,omitempty" db:"disabled_reason"`
+	LastDeliveryAt      *time.Time `json:"lastDeliveryAt,omitempty" db:"last_delivery_at"`
+	CreatedBy           *uuid.UUID `json:"createdBy,omitempty" db:"created_by"`
+	CreatedAt           time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+// EventHookDelivery is one event sent (or being sent) to a hook
+type EventHookDelivery struct {
+	ID             uuid.UUID       `json:"id" db:"id"`
+	HookID         uuid.UUID       `json:"hookId" db:"hook_id"`
+	EventType      string          `json:"eventType" db:"event_type"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	ResponseStatus *int            `json:"responseStatus,omitempty" db:"response_status"`
+	LastError      *string         `json:"lastError,omitempty" db:"last_error"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt" db:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"createdAt" db:"created_at"`
+	CompletedAt    *time.Time      `json:"completedAt,omitempty" db:"completed_at"`
+}
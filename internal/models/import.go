@@ -0,0 +1,91 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImportSource is the platform an import archive was exported from
+type ImportSource string
+
+const (
+	ImportSourceDiscord    ImportSource = "discord"    // DiscordChatExporter JSON export
+	ImportSourceSlack      ImportSource = "slack"      // Slack workspace export
+	ImportSourcePeridotite ImportSource = "peridotite" // community export from a Peridotite instance
+)
+
+// ImportStatus is where an import job is in its lifecycle
+type ImportStatus string
+
+const (
+	ImportAwaitingUpload ImportStatus = "awaiting_upload"
+	ImportQueued         ImportStatus = "queued"
+	ImportRunning        ImportStatus = "running"
+	ImportCompleted      ImportStatus = "completed"
+	ImportFailed         ImportStatus = "failed"
+	ImportCancelled      ImportStatus = "cancelled"
+)
+
+// ImportJob imports an export archive into a new community.
+// Progress is checkpointed per batch of messages, so a job picks up where it
+// stopped after a restart or when resumed.
+type ImportJob struct {
+	ID                  uuid.UUID    `json:"id" db:"id"`
+	OwnerID             uuid.UUID    `json:"ownerId" db:"owner_id"`
+	Source              ImportSource `json:"source" db:"source"`
+	Status              ImportStatus `json:"status" db:"status"`
+	CommunityName       *string      `json:"communityName,omitempty" db:"community_name"`
+	CommunityID         *uuid.UUID   `json:"communityId,omitempty" db:"community_id"`
+	ArchiveSize         *int64       `json:"archiveSize,omitempty" db:"archive_size"`
+	TotalChannels       int          `json:"totalChannels" db:"total_channels"`
+	ChannelIndex        int          `json:"channelIndex" db:"channel_index"` // channels finished
+	MessageIndex        int          `json:"messageIndex" db:"message_index"` // messages done in the current channel
+	TotalMessages       int          `json:"totalMessages" db:"total_messages"`
+	ImportedMessages    int          `json:"importedMessages" db:"imported_messages"`
+	SkippedMessages     int          `json:"skippedMessages" db:"skipped_messages"`
+	ImportedAttachments int          `json:"importedAttachments" db:"imported_attachments"`
+	FailedAttachments   int          `json:"failedAttachments" db:"failed_attachments"`
+	Error               *string      `json:"error,omitempty" db:"error"`
+	CreatedAt           time.Time    `json:"createdAt" db:"created_at"`
+	UpdatedAt           time.Time    `json:"updatedAt" db:"updated_at"`
+	StartedAt           *time.Time   `json:"startedAt,omitempty" db:"started_at"`
+	FinishedAt          *time.Time   `json:"finishedAt,omitempty" db:"finished_at"`
+}
+
+// Finished reports whether the job has stopped for good or until resumed
+func (j *ImportJob) Finished() bool {
+	return j.Status == ImportCompleted || j.Status == ImportFailed || j.Status == ImportCancelled
+}
+
+// ExportStatus is where a community export is in its lifecycle
+type ExportStatus string
+
+const (
+	ExportQueued    ExportStatus = "queued"
+	ExportRunning   ExportStatus = "running"
+	ExportCompleted ExportStatus = "completed"
+	ExportFailed    ExportStatus = "failed"
+	ExportExpired   ExportStatus = "expired" // the archive has been deleted
+)
+
+// CommunityExport is an archive of a community's structure, emojis and
+// decrypted message history that another instance can import
+type CommunityExport struct {
+	ID               uuid.UUID    `json:"id" db:"id"`
+	CommunityID      uuid.UUID    `json:"communityId" db:"community_id"`
+	RequestedBy      uuid.UUID    `json:"requestedBy" db:"requested_by"`
+	Status           ExportStatus `json:"status" db:"status"`
+	IncludeMedia     bool         `json:"includeMedia" db:"include_media"`
+	ArchiveSize      *int64       `json:"archiveSize,omitempty" db:"archive_size"`
+	ChannelCount     int          `json:"channelCount" db:"channel_count"`
+	MessageCount     int          `json:"messageCount" db:"message_count"`
+	AttachmentCount  int          `json:"attachmentCount" db:"attachment_count"`
+	FailedMediaCount int          `json:"failedMediaCount" db:"failed_media_count"`
+	Error            *string      `json:"error,omitempty" db:"error"`
+	CreatedAt        time.Time    `json:"createdAt" db:"created_at"`
+	UpdatedAt        time.Time    `json:"updatedAt" db:"updated_at"`
+	StartedAt        *time.Time   `json:"startedAt,omitempty" db:"started_at"`
+	FinishedAt       *time.Time   `json:"finishedAt,omitempty" db:"finished_at"`
+	ExpiresAt        *time.Time   `json:"expiresAt,omitempty" db:"expires_at"`
+}
@@ -12,11 +12,14 @@ type Message struct {
 	AuthorID         uuid.UUID              `json:"authorId" db:"author_id"`
 	Content          *string                `json:"content,omitempty" db:"content"`
 	EncryptedContent []byte                 `json:"-" db:"encrypted_content"`
+	ContentWarning   *string                `json:"contentWarning,omitempty" db:"content_warning"` // clients collapse the content behind this label
 	ReplyToID        *uuid.UUID             `json:"replyToId,omitempty" db:"reply_to_id"`
 	IsEdited         bool                   `json:"isEdited" db:"is_edited"`
 	IsPinned         bool                   `json:"isPinned" db:"is_pinned"`
 	Reactions        map[string][]uuid.UUID `json:"reactions" db:"reactions"`
 	LinkPreviews     []LinkPreview          `json:"linkPreviews,omitempty" db:"link_previews"`
+	Components       []ComponentRow         `json:"components,omitempty" db:"components"`        // bot messages only
+	IsQuarantined    bool                   `json:"isQuarantined,omitempty" db:"is_quarantined"` // author was quarantined; only moderators see it set
 	CreatedAt        time.Time              `json:"createdAt" db:"created_at"`
 	UpdatedAt        time.Time              `json:"updatedAt" db:"updated_at"`
 	DeletedAt        *time.Time             `json:"-" db:"deleted_at"`
@@ -31,18 +34,50 @@ type MessageWithAuthor struct {
 }
 
 type MessageAttachment struct {
-	ID               uuid.UUID  `json:"id" db:"id"`
-	MessageID        *uuid.UUID `json:"messageId" db:"message_id"`
-	MessageCreatedAt *time.Time `json:"-" db:"message_created_at"`
-	UploaderID       uuid.UUID  `json:"uploaderId" db:"uploader_id"`
-	Filename         string     `json:"filename" db:"filename"`
-	FileURL          string     `json:"url" db:"file_url"`
-	FileSize         int64      `json:"size" db:"file_size"`
-	ContentType      *string    `json:"contentType,omitempty" db:"content_type"`
-	ThumbnailURL     *string    `json:"thumbnailUrl,omitempty" db:"thumbnail_url"`
-	Width            *int       `json:"width,omitempty" db:"width"`
-	Height           *int       `json:"height,omitempty" db:"height"`
-	CreatedAt        time.Time  `json:"createdAt" db:"created_at"`
+	ID               uuid.UUID         `json:"id" db:"id"`
+	MessageID        *uuid.UUID        `json:"messageId" db:"message_id"`
+	MessageCreatedAt *time.Time        `json:"-" db:"message_created_at"`
+	UploaderID       uuid.UUID         `json:"uploaderId" db:"uploader_id"`
+	Filename         string            `json:"filename" db:"filename"`
+	FileURL          string            `json:"url" db:"file_url"`
+	FileSize         int64             `json:"size" db:"file_size"`
+	ContentType      *string           `json:"contentType,omitempty" db:"content_type"`
+	ThumbnailURL     *string           `json:"thumbnailUrl,omitempty" db:"thumbnail_url"`
+	MediumURL        *string           `json:"mediumUrl,omitempty" db:"medium_url"` // only for images larger than the medium size
+	VideoURL         *string           `json:"videoUrl,omitempty" db:"video_url"`   // H.264/AAC MP4 rendition of a video
+	PosterURL        *string           `json:"posterUrl,omitempty" db:"poster_url"`
+	Width            *int              `json:"width,omitempty" db:"width"`
+	Height           *int              `json:"height,omitempty" db:"height"`
+	DurationSeconds  *float64          `json:"durationSeconds,omitempty" db:"duration_seconds"`
+	Blurhash         *string           `json:"blurhash,omitempty" db:"blurhash"`
+	ProcessingStatus *AttachmentStatus `json:"processingStatus,omitempty" db:"processing_status"`
+	IsSpoiler        bool              `json:"isSpoiler" db:"is_spoiler"`
+	Description      *string           `json:"description,omitempty" db:"description"`
+	CreatedAt        time.Time         `json:"createdAt" db:"created_at"`
+}
+
+// AttachmentStatus is how far background processing of an attachment has got.
+// Attachments with nothing to process have none.
+type AttachmentStatus string
+
+const (
+	AttachmentProcessing AttachmentStatus = "processing"
+	AttachmentReady      AttachmentStatus = "ready"
+	AttachmentFailed     AttachmentStatus = "failed" // the original is still served
+)
+
+// MaxAttachmentDescriptionLength caps attachment alt text.
+const MaxAttachmentDescriptionLength = 1024
+
+// MaxContentWarningLength caps a message's content warning label.
+const MaxContentWarningLength = 100
+
+// AttachmentOptions sets the spoiler flag / alt text of an uploaded attachment
+// when the message it belongs to is sent.
+type AttachmentOptions struct {
+	ID          uuid.UUID `json:"id" validate:"required"`
+	IsSpoiler   *bool     `json:"isSpoiler,omitempty"`
+	Description *string   `json:"description,omitempty" validate:"omitempty,max=1024"`
 }
 
 type MessageReaction struct {
@@ -95,6 +130,7 @@ type DirectMessage struct {
 	SenderID         uuid.UUID              `json:"senderId" db:"sender_id"`
 	EncryptedContent []byte                 `json:"encryptedContent" db:"encrypted_content"`
 	Nonce            []byte                 `json:"nonce" db:"nonce"`
+	ContentWarning   *string                `json:"contentWarning,omitempty" db:"content_warning"`
 	ReplyToID        *uuid.UUID             `json:"replyToId,omitempty" db:"reply_to_id"`
 	IsEdited         bool                   `json:"isEdited" db:"is_edited"`
 	Reactions        map[string][]uuid.UUID `json:"reactions" db:"reactions"`
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ModerationAction is the kind of action a moderation case records.
+type ModerationAction string
+
+const (
+	ModerationActionWarn    ModerationAction = "warn"
+	ModerationActionTimeout ModerationAction = "timeout"
+	ModerationActionKick    ModerationAction = "kick"
+	ModerationActionBan     ModerationAction = "ban"
+)
+
+// TimeoutRevokedPermissions are taken away from a member while they are timed out.
+const TimeoutRevokedPermissions = PermissionSendMessages | PermissionAddReactions | PermissionCreateInvites | PermissionVoiceSpeak
+
+// ModerationEvidence points at a message the case was opened over.
+type ModerationEvidence struct {
+	MessageID uuid.UUID `json:"messageId"`
+	ChannelID uuid.UUID `json:"channelId"`
+}
+
+// ModerationCase is one numbered entry in a community's moderation log.
+type ModerationCase struct {
+	ID              uuid.UUID            `json:"id" db:"id"`
+	CommunityID     uuid.UUID            `json:"communityId" db:"community_id"`
+	CaseNumber      int                  `json:"caseNumber" db:"case_number"`
+	Action          ModerationAction     `json:"action" db:"action"`
+	TargetID        uuid.UUID            `json:"targetId" db:"target_id"`
+	ModeratorID     *uuid.UUID           `json:"moderatorId,omitempty" db:"moderator_id"`
+	Reason          *string              `json:"reason,omitempty" db:"reason"`
+	Evidence        []ModerationEvidence `json:"evidence" db:"evidence"`
+	DurationSeconds *int                 `json:"durationSeconds,omitempty" db:"duration_seconds"`
+	ExpiresAt       *time.Time           `json:"expiresAt,omitempty" db:"expires_at"`
+	CreatedAt       time.Time            `json:"createdAt" db:"created_at"`
+	UpdatedAt       time.Time            `json:"updatedAt" db:"updated_at"`
+}
+
+type ModerationCaseWithUsers struct {
+	ModerationCase
+	Target    *PublicUser `json:"target,omitempty"`
+	Moderator *PublicUser `json:"moderator,omitempty"`
+}
+
+// Quarantine is a member's shadow-ban state in a community. Quarantined members
+// can keep talking, but only they and moderators see what they send.
+type Quarantine struct {
+	CommunityID   uuid.UUID  `json:"communityId"`
+	UserID        uuid.UUID  `json:"userId"`
+	QuarantinedAt time.Time  `json:"quarantinedAt"`
+	QuarantinedBy *uuid.UUID `json:"quarantinedBy,omitempty"`
+	Reason        *string    `json:"reason,omitempty"`
+}
+
+// ModerationHistory is a member's case record in one community, newest first.
+type ModerationHistory struct {
+	UserID        uuid.UUID                  `json:"userId"`
+	Counts        map[ModerationAction]int   `json:"counts"`
+	TimeoutUntil  *time.Time                 `json:"timeoutUntil,omitempty"`
+	QuarantinedAt *time.Time                 `json:"quarantinedAt,omitempty"`
+	Banned        bool                       `json:"banned"`
+	Cases         []*ModerationCaseWithUsers `json:"cases"`
+}
+
+// LockdownState is where a community is in the lockdown state machine.
+// Lockdowns only move inactive -> active -> inactive; changing the settings of
+// an active lockdown means lifting it first.
+type LockdownState string
+
+const (
+	LockdownInactive LockdownState = "inactive"
+	LockdownActive   LockdownState = "active"
+)
+
+// Lockdown is a community's panic mode: joins stopped, slowmode everywhere,
+// posting limited to higher roles and @everyone silenced. The last lockdown is
+// kept after it is lifted so moderators can see what was done.
+type Lockdown struct {
+	CommunityID     uuid.UUID         `json:"communityId"`
+	State           LockdownState     `json:"state"`
+	StopJoins       bool              `json:"stopJoins"`
+	SlowmodeSeconds int               `json:"slowmodeSeconds"`
+	MinRolePosition *int              `json:"minRolePosition,omitempty"` // nil leaves posting alone
+	SilenceEveryone bool              `json:"silenceEveryone"`
+	Reason          *string           `json:"reason,omitempty"`
+	Snapshot        *LockdownSnapshot `json:"snapshot,omitempty"`
+	EnabledBy       *uuid.UUID        `json:"enabledBy,omitempty"`
+	EnabledAt       *time.Time        `json:"enabledAt,omitempty"`
+	ExpiresAt       *time.Time        `json:"expiresAt,omitempty"`
+	DisabledBy      *uuid.UUID        `json:"disabledBy,omitempty"`
+	DisabledAt      *time.Time        `json:"disabledAt,omitempty"`
+}
+
+// LockdownSnapshot records the settings a lockdown changed and their values
+// before it, which is what lifting the lockdown puts back.
+type LockdownSnapshot struct {
+	Channels  []LockdownChannel  `json:"channels"`
+	Roles     []LockdownRole     `json:"roles"`
+	Overrides []LockdownOverride `json:"overrides"`
+}
+
+// LockdownChannel is a channel whose slowmode the lockdown raised.
+type LockdownChannel struct {
+	ChannelID       uuid.UUID `json:"channelId"`
+	SlowmodeSeconds int       `json:"slowmodeSeconds"`
+}
+
+// LockdownRole is a role the lockdown took permissions away from.
+// Permissions is the role's full set before the lockdown.
+type LockdownRole struct {
+	RoleID      uuid.UUID `json:"roleId"`
+	Permissions int64     `json:"permissions"`
+	Removed     int64     `json:"removed"`
+}
+
+// LockdownOverride is a channel overwrite whose allowed permissions the
+// lockdown cleared. AllowPermissions is its full allow set before the
+// lockdown.
+type LockdownOverride struct {
+	OverrideID       uuid.UUID `json:"overrideId"`
+	ChannelID        uuid.UUID `json:"channelId"`
+	RoleID           uuid.UUID `json:"roleId"`
+	AllowPermissions int64     `json:"allowPermissions"`
+	Removed          int64     `json:"removed"`
+}
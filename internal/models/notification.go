@@ -17,10 +17,66 @@ const (
 	NotificationTypeMentionHere     NotificationType = "mention_here"
 
 	// Interaction notifications
-	NotificationTypeReply     NotificationType = "reply"
-	NotificationTypeDMMessage NotificationType = "dm_message"
+	NotificationTypeReply      NotificationType = "reply"
+	NotificationTypeDMMessage  NotificationType = "dm_message"
+	NotificationTypeMissedCall NotificationType = "missed_call"
+
+	// Moderation notifications, sent to members who can moderate a community
+	NotificationTypeModAlert NotificationType = "mod_alert"
+)
+
+// NotificationCategory groups notification types that clients alert on the
+// same way.
+type NotificationCategory string
+
+const (
+	NotificationCategoryMention       NotificationCategory = "mention"
+	NotificationCategoryMassMention   NotificationCategory = "mass_mention"
+	NotificationCategoryReply         NotificationCategory = "reply"
+	NotificationCategoryDirectMessage NotificationCategory = "direct_message"
+	NotificationCategoryAnnouncement  NotificationCategory = "announcement"
+	NotificationCategoryModeration    NotificationCategory = "moderation"
+)
+
+// NotificationPriority tells clients how loudly to deliver a notification.
+// Silent notifications should not play a sound or vibrate.
+type NotificationPriority string
+
+const (
+	NotificationPriorityHigh   NotificationPriority = "high"
+	NotificationPriorityNormal NotificationPriority = "normal"
+	NotificationPriorityLow    NotificationPriority = "low"
+	NotificationPrioritySilent NotificationPriority = "silent"
+)
+
+// IsValid reports whether p is a known priority.
+func (p NotificationPriority) IsValid() bool {
+	switch p {
+	case NotificationPriorityHigh, NotificationPriorityNormal, NotificationPriorityLow, NotificationPrioritySilent:
+		return true
+	}
+	return false
+}
+
+// Notification route kinds
+const (
+	NotificationRouteMessage   = "message"
+	NotificationRouteDM        = "dm"
+	NotificationRouteChannel   = "channel"
+	NotificationRouteCommunity = "community"
 )
 
+// NotificationRoute is where a client should navigate when a notification is
+// opened. Path is a ready-made deep link built from the IDs.
+type NotificationRoute struct {
+	Kind           string     `json:"kind"`
+	Path           string     `json:"path"`
+	CommunityID    *uuid.UUID `json:"communityId,omitempty"`
+	ChannelID      *uuid.UUID `json:"channelId,omitempty"`
+	ConversationID *uuid.UUID `json:"conversationId,omitempty"`
+	MessageID      *uuid.UUID `json:"messageId,omitempty"`
+}
+
 // MentionType describes the kind of mention encoded in a message.
 type MentionType string
 
@@ -44,7 +100,16 @@ type Notification struct {
 	ActorID     *uuid.UUID       `json:"actorId,omitempty" db:"actor_id"`
 	Metadata    map[string]any   `json:"metadata,omitempty" db:"metadata"`
 	IsRead      bool             `json:"isRead" db:"is_read"`
-	CreatedAt   time.Time        `json:"createdAt" db:"created_at"`
+	// Count is how many events were merged into this notification. The
+	// title, body and IDs are from the latest one.
+	Count     int       `json:"count" db:"count"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
+
+	// Computed from the type and the recipient's preferences (not stored)
+	Category NotificationCategory `json:"category"`
+	Priority NotificationPriority `json:"priority"`
+	Route    *NotificationRoute   `json:"route,omitempty"`
 
 	// Joined fields (populated on read, not stored in DB column)
 	Actor *PublicUser `json:"actor,omitempty"`
@@ -63,3 +128,45 @@ type MessageMention struct {
 	MentionType      MentionType `json:"mentionType" db:"mention_type"`
 	CreatedAt        time.Time   `json:"createdAt" db:"created_at"`
 }
+
+// PushSubscription is a browser's Web Push endpoint for one user. The keys
+// are never returned to clients.
+type PushSubscription struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	UserID        uuid.UUID  `json:"-" db:"user_id"`
+	Endpoint      string     `json:"endpoint" db:"endpoint"`
+	P256DH        string     `json:"-" db:"p256dh"`
+	Auth          string     `json:"-" db:"auth"`
+	UserAgent     *string    `json:"userAgent,omitempty" db:"user_agent"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty" db:"expires_at"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty" db:"last_success_at"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+}
+
+// Push device platforms
+const (
+	PushPlatformFCM  = "fcm"
+	PushPlatformAPNs = "apns"
+)
+
+// PushDevice is a native app's FCM or APNs token for one user. The token is
+// never returned to clients.
+type PushDevice struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	UserID        uuid.UUID  `json:"-" db:"user_id"`
+	Platform      string     `json:"platform" db:"platform"`
+	Token         string     `json:"-" db:"token"`
+	Sandbox       bool       `json:"sandbox" db:"sandbox"`
+	DeviceName    *string    `json:"deviceName,omitempty" db:"device_name"`
+	AppVersion    *string    `json:"appVersion,omitempty" db:"app_version"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty" db:"last_success_at"`
+	CreatedAt     time.Time  `json:"createdAt" db:"created_at"`
+	UpdatedAt     time.Time  `json:"updatedAt" db:"updated_at"`
+}
+
+// PushPreferences controls which notifications are pushed while the user is offline
+type PushPreferences struct {
+	Enabled        bool `json:"enabled" db:"enabled"`
+	Mentions       bool `json:"mentions" db:"mentions"`
+	DirectMessages bool `json:"directMessages" db:"direct_messages"`
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scopes a third-party app can request
+const (
+	OAuthScopeIdentify        = "identify"
+	OAuthScopeCommunitiesRead = "communities.read"
+	OAuthScopeMessagesWrite   = "messages.write"
+)
+
+// OAuthScopeDescriptions is what the consent screen shows for each scope
+var OAuthScopeDescriptions = map[string]string{
+	OAuthScopeIdentify:        "Access your username, avatar and profile",
+	OAuthScopeCommunitiesRead: "See which communities you are in",
+	OAuthScopeMessagesWrite:   "Send messages as you in channels you can post in",
+}
+
+// OAuthApp is a third-party application registered by a user
+type OAuthApp struct {
+	ID                  uuid.UUID `json:"id" db:"id"` // also the client_id
+	OwnerID             uuid.UUID `json:"ownerId" db:"owner_id"`
+	Name                string    `json:"name" db:"name"`
+	Description         *string   `json:"description,omitempty" db:"description"`
+	IconURL             *string   `json:"iconUrl,omitempty" db:"icon_url"`
+	WebsiteURL          *string   `json:"websiteUrl,omitempty" db:"website_url"`
+	RedirectURIs        []string  `json:"redirectUris" db:"redirect_uris"`
+	IsPublic            bool      `json:"isPublic" db:"is_public"` // no client secret; PKCE only
+	ClientSecretPreview *string   `json:"clientSecretPreview,omitempty" db:"client_secret_preview"`
+	CreatedAt           time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt           time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// OAuthAuthorization is a user's consent to an app
+type OAuthAuthorization struct {
+	App       *OAuthApp `json:"app"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// OAuthToken is an access token an app holds for a user
+type OAuthToken struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	AppID     uuid.UUID `json:"appId" db:"app_id"`
+	UserID    uuid.UUID `json:"userId" db:"user_id"`
+	Scopes    []string  `json:"scopes" db:"scopes"`
+	ExpiresAt time.Time `json:"expiresAt" db:"access_expires_at"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// HasScope reports whether the token was granted scope
+func (t *OAuthToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -22,19 +23,85 @@ const (
 	PluginPermServerInfo                        // can read community metadata
 	PluginPermWebhooks                          // can create and use webhooks
 	PluginPermReactToMessages                   // can add reactions
+	PluginPermEmitEvents                        // can push custom realtime events to clients
 )
 
 // PluginManifest is the structured content inside the manifest JSONB column.
 // It declares everything the plugin provides: channel types, commands, hooks, etc.
 type PluginManifest struct {
-	ChannelTypes []string `json:"channelTypes,omitempty"`
-	Commands     []string `json:"commands,omitempty"`
-	Triggers     []string `json:"triggers,omitempty"`
-	Hooks        []string `json:"hooks,omitempty"`
+	ChannelTypes []PluginChannelType `json:"channelTypes,omitempty"`
+	Commands     []string            `json:"commands,omitempty"`
+	Triggers     []string            `json:"triggers,omitempty"`
+	Hooks        []string            `json:"hooks,omitempty"`
+	// Public https URL the plugin's subscribed hooks are delivered to
+	Endpoint string `json:"endpoint,omitempty"`
+	// WASM module run in the server's sandbox to handle the subscribed
+	// hooks, for plugins without hosting of their own. Takes precedence
+	// over Endpoint.
+	Wasm *PluginWasm `json:"wasm,omitempty"`
+	// Jobs the server fires at the plugin on a schedule, the same way as hooks
+	Schedules []PluginSchedule `json:"schedules,omitempty"`
 	// URL to the frontend bundle (JS) that registers custom components
 	FrontendBundle string `json:"frontendBundle,omitempty"`
 }
 
+// PluginChannelType is a channel type a plugin provides. Clients render its
+// channels by capability, so a type can be a kanban board or a wiki rather
+// than a message channel. A plain string in the manifest declares a message
+// channel type with that ID.
+type PluginChannelType struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Icon        string `json:"icon,omitempty"`
+	// Names from ChannelCapabilityNames; "messages" when empty
+	Capabilities    []string        `json:"capabilities,omitempty"`
+	DefaultMetadata json.RawMessage `json:"defaultMetadata,omitempty"`
+}
+
+func (t *PluginChannelType) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*t = PluginChannelType{ID: id}
+		return nil
+	}
+
+	type plain PluginChannelType
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// CapabilityFlags turns the declared capability names into flags, failing on
+// names plugins can't declare
+func (t *PluginChannelType) CapabilityFlags() (int64, error) {
+	if len(t.Capabilities) == 0 {
+		return CapMessages, nil
+	}
+
+	var caps int64
+	for _, name := range t.Capabilities {
+		flag, ok := ChannelCapabilityNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown channel capability %q", name)
+		}
+		caps |= flag
+	}
+	return caps, nil
+}
+
+// PluginWasm points at a plugin's WASM module. The module is fetched once and
+// must match the SHA-256 digest.
+type PluginWasm struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256"`
+}
+
+// PluginSchedule is a job a plugin wants run periodically. Cron is a standard
+// five-field expression in UTC, or a descriptor such as "@hourly".
+type PluginSchedule struct {
+	Name string `json:"name"`
+	Cron string `json:"cron"`
+}
+
 // Plugin represents a plugin available for installation
 type Plugin struct {
 	ID                   uuid.UUID       `json:"id" db:"id"`
@@ -51,8 +118,10 @@ type Plugin struct {
 	BuiltIn              bool            `json:"builtIn" db:"built_in"`
 	Source               string          `json:"source" db:"source"`
 	IsVerified           bool            `json:"isVerified" db:"is_verified"`
-	CreatedAt            time.Time       `json:"createdAt" db:"created_at"`
-	UpdatedAt            time.Time       `json:"updatedAt" db:"updated_at"`
+	// Public key of the source that signed the manifest; nil when unsigned
+	SignedBy  *string   `json:"signedBy,omitempty" db:"signed_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time `json:"updatedAt" db:"updated_at"`
 }
 
 // ParsedManifest returns the structured manifest from the raw JSON
@@ -75,6 +144,11 @@ type CommunityPlugin struct {
 	InstalledBy        uuid.UUID       `json:"installedBy" db:"installed_by"`
 	InstalledAt        time.Time       `json:"installedAt" db:"installed_at"`
 	UpdatedAt          time.Time       `json:"updatedAt" db:"updated_at"`
+	// Set once deliveries to the plugin's endpoint kept failing; enabling the
+	// plugin again resumes them
+	DeliveriesPaused bool `json:"deliveriesPaused" db:"deliveries_paused"`
+	// Only set when the signing secret is created or rotated; it is not shown again
+	SigningSecret string `json:"signingSecret,omitempty" db:"-"`
 	// Joined from plugins table
 	Plugin *Plugin `json:"plugin,omitempty"`
 }
@@ -84,6 +158,70 @@ func (cp *CommunityPlugin) HasPermission(perm int64) bool {
 	return cp.GrantedPermissions&perm != 0
 }
 
+// PluginDelivery is an event queued for, or sent to, a plugin's endpoint
+type PluginDelivery struct {
+	ID             uuid.UUID       `json:"id" db:"id"`
+	InstallationID uuid.UUID       `json:"installationId" db:"installation_id"`
+	EventType      string          `json:"eventType" db:"event_type"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	ResponseStatus *int            `json:"responseStatus,omitempty" db:"response_status"`
+	LastError      *string         `json:"lastError,omitempty" db:"last_error"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt" db:"next_attempt_at"`
+	CreatedAt      time.Time       `json:"createdAt" db:"created_at"`
+	CompletedAt    *time.Time      `json:"completedAt,omitempty" db:"completed_at"`
+}
+
+// PluginAPIToken is a plugin installation's token for calling the REST API.
+// Permissions are the installation's as they are now, not when it was issued.
+type PluginAPIToken struct {
+	InstallationID     uuid.UUID  `json:"installationId" db:"installation_id"`
+	CommunityID        uuid.UUID  `json:"communityId" db:"community_id"`
+	PluginID           uuid.UUID  `json:"pluginId" db:"plugin_id"`
+	BotUserID          uuid.UUID  `json:"botUserId" db:"bot_user_id"`
+	GrantedPermissions int64      `json:"grantedPermissions" db:"granted_permissions"`
+	TokenPreview       string     `json:"tokenPreview" db:"token_preview"`
+	CreatedAt          time.Time  `json:"createdAt" db:"created_at"`
+	LastUsedAt         *time.Time `json:"lastUsedAt,omitempty" db:"last_used_at"`
+}
+
+// HasPermission checks if the token's installation has a permission granted
+func (t *PluginAPIToken) HasPermission(perm int64) bool {
+	return t.GrantedPermissions&perm != 0
+}
+
+// PluginStorageEntry is one key of a plugin installation's storage. Value is
+// left out when keys are listed.
+type PluginStorageEntry struct {
+	Key       string          `json:"key" db:"key"`
+	Value     json.RawMessage `json:"value,omitempty" db:"value"`
+	Size      int             `json:"size" db:"size"`
+	CreatedAt time.Time       `json:"createdAt" db:"created_at"`
+	UpdatedAt time.Time       `json:"updatedAt" db:"updated_at"`
+}
+
+// PluginStorageUsage is how much of its storage quota an installation uses
+type PluginStorageUsage struct {
+	Keys       int   `json:"keys"`
+	Bytes      int64 `json:"bytes"`
+	MaxKeys    int   `json:"maxKeys"`
+	QuotaBytes int64 `json:"quotaBytes"`
+}
+
+// PluginJob is a scheduled job of one installation
+type PluginJob struct {
+	Name        string     `json:"name" db:"name"`
+	Cron        string     `json:"cron" db:"cron"`
+	NextRunAt   *time.Time `json:"nextRunAt,omitempty" db:"next_run_at"`
+	Invalid     bool       `json:"invalid" db:"invalid"`
+	Running     bool       `json:"running" db:"-"`
+	LastRunAt   *time.Time `json:"lastRunAt,omitempty" db:"last_run_at"`
+	Failures    int        `json:"failures" db:"failures"`
+	SkippedRuns int        `json:"skippedRuns" db:"skipped_runs"`
+	LastError   *string    `json:"lastError,omitempty" db:"last_error"`
+}
+
 // PluginSource is an apt-style source repository
 type PluginSource struct {
 	ID          uuid.UUID `json:"id" db:"id"`
@@ -91,8 +229,10 @@ type PluginSource struct {
 	Name        string    `json:"name" db:"name"`
 	URL         string    `json:"url" db:"url"`
 	Enabled     bool      `json:"enabled" db:"enabled"`
-	AddedBy     uuid.UUID `json:"addedBy" db:"added_by"`
-	CreatedAt   time.Time `json:"createdAt" db:"created_at"`
+	// Pinned when the source is added; manifests it serves must be signed with it
+	PublicKey *string   `json:"publicKey,omitempty" db:"public_key"`
+	AddedBy   uuid.UUID `json:"addedBy" db:"added_by"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
 }
 
 // PluginAuditEntry tracks plugin-related actions for accountability
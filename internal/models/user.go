@@ -17,6 +17,14 @@ const (
 	UserStatusOffline   UserStatus = "offline"
 )
 
+// Public is the status other users see: invisible users appear offline
+func (s UserStatus) Public() UserStatus {
+	if s == UserStatusInvisible {
+		return UserStatusOffline
+	}
+	return s
+}
+
 type User struct {
 	ID               uuid.UUID  `json:"id" db:"id"`
 	Username         string     `json:"username" db:"username"`
@@ -54,9 +62,78 @@ type UserSettings struct {
 	SoundEnabled         bool            `json:"soundEnabled" db:"sound_enabled"`
 	CompactMode          bool            `json:"compactMode" db:"compact_mode"`
 	SettingsJSON         json.RawMessage `json:"settings" db:"settings_json"`
+	QuietHours           QuietHours      `json:"quietHours"`
 	UpdatedAt            time.Time       `json:"updatedAt" db:"updated_at"`
 }
 
+// QuietHours is a daily window in the user's timezone during which
+// notifications are stored but not delivered. Minutes count from midnight;
+// a window that ends before it starts runs past midnight.
+type QuietHours struct {
+	Enabled     bool   `json:"enabled" db:"quiet_hours_enabled"`
+	StartMinute int    `json:"startMinute" db:"quiet_hours_start"`
+	EndMinute   int    `json:"endMinute" db:"quiet_hours_end"`
+	Timezone    string `json:"timezone" db:"quiet_hours_timezone"`
+}
+
+// Until reports whether t falls within quiet hours and, if so, when they end
+func (q QuietHours) Until(t time.Time) (time.Time, bool) {
+	if !q.Enabled || q.StartMinute == q.EndMinute {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	endDay := local
+	switch {
+	case q.StartMinute < q.EndMinute:
+		if minute < q.StartMinute || minute >= q.EndMinute {
+			return time.Time{}, false
+		}
+	case minute >= q.StartMinute:
+		endDay = local.AddDate(0, 0, 1)
+	case minute >= q.EndMinute:
+		return time.Time{}, false
+	}
+
+	year, month, day := endDay.Date()
+	return time.Date(year, month, day, q.EndMinute/60, q.EndMinute%60, 0, 0, loc), true
+}
+
+// CommunityFolder groups communities in a user's sidebar
+type CommunityFolder struct {
+	ID           uuid.UUID   `json:"id" db:"id"`
+	UserID       uuid.UUID   `json:"userId" db:"user_id"`
+	Name         string      `json:"name" db:"name"`
+	Color        *string     `json:"color,omitempty" db:"color"`
+	Position     int         `json:"position" db:"position"`
+	CommunityIDs []uuid.UUID `json:"communityIds"`
+	CreatedAt    time.Time   `json:"createdAt" db:"created_at"`
+	UpdatedAt    time.Time   `json:"updatedAt" db:"updated_at"`
+}
+
+// Sidebar item types
+const (
+	SidebarItemCommunity = "community"
+	SidebarItemFolder    = "folder"
+)
+
+// SidebarItem is one top-level entry in the community sidebar
+type SidebarItem struct {
+	Type string    `json:"type" validate:"required,oneof=community folder"`
+	ID   uuid.UUID `json:"id" validate:"required"`
+}
+
+// SidebarLayout is the full ordered sidebar: top-level items plus folder contents
+type SidebarLayout struct {
+	Items   []SidebarItem      `json:"items"`
+	Folders []*CommunityFolder `json:"folders"`
+}
+
 type UserBlock struct {
 	BlockerID uuid.UUID `json:"blockerId" db:"blocker_id"`
 	BlockedID uuid.UUID `json:"blockedId" db:"blocked_id"`
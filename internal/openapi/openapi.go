@@ -0,0 +1,202 @@
+// Package openapi builds the OpenAPI 3.1 document of the HTTP API from the
+// router itself. Every mounted route ends up in the document with its path
+// parameters and the security, headers and responses of the middlewares in
+// front of it. Handlers describe the rest for themselves with Describe,
+// naming their request and response types, so the document follows the code
+// instead of being kept by hand.
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Shape is how a handler writes its response around the described type
+type Shape int
+
+const (
+	// Data is {"data": ...}, as written by utils.RespondSuccess and
+	// utils.RespondCreated
+	Data Shape = iota
+	// Raw is the response type as is, as written by utils.RespondJSON
+	Raw
+	// Page is a page of response items, as written by utils.RespondCursorPage
+	Page
+	// Binary is a file written as is, of ResponseType
+	Binary
+	// Empty is a response with headers only
+	Empty
+)
+
+// Param is a path, query or header parameter
+type Param struct {
+	Name        string
+	Description string
+	// Type is the JSON type: string (the default), integer, number or boolean
+	Type     string
+	Format   string
+	Enum     []string
+	Required bool
+}
+
+// Operation describes a handler
+type Operation struct {
+	// OperationID overrides the ID named after the handler
+	OperationID string
+	Summary     string
+	Description string
+	// Path overrides the parameters taken from the route pattern, which are
+	// plain strings otherwise
+	Path    []Param
+	Query   []Param
+	Headers []Param
+	// Body is a value of the request body type; nil when there is none
+	Body any
+	// BodyOptional is set for handlers that read it with BindOptionalJSON
+	BodyOptional bool
+	// BodyType is the media type of a body that isn't JSON, read as is
+	BodyType string
+	// Upload is the multipart/form-data field a file is uploaded in, for
+	// handlers that take one instead of a JSON body. Form are the other
+	// fields of the form; without Upload, they make a form-encoded body.
+	Upload string
+	Form   []Param
+	// Response is a value of the response type; nil for a bare envelope
+	Response any
+	// Shape is how Response is wrapped, Data by default
+	Shape Shape
+	// ResponseType is the media type of a Binary response,
+	// application/octet-stream by default
+	ResponseType string
+	// Status is the success status, 200 by default. 204 and redirects have
+	// no body.
+	Status     int
+	Deprecated bool
+}
+
+// Middleware describes what a middleware adds to the operations behind it
+type Middleware struct {
+	// Security is the name of the security scheme it authenticates with
+	Security string
+	// Optional lets requests through without credentials
+	Optional bool
+	// Headers are request headers it reads
+	Headers []Param
+	// Methods limits the middleware to requests with these methods
+	Methods []string
+	// Responses are the error responses it can write, by status
+	Responses map[int]string
+}
+
+// SecurityScheme is how a client authenticates
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Name         string `json:"name,omitempty"`
+	In           string `json:"in,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+}
+
+var (
+	registryMu  sync.RWMutex
+	operations  = map[string]Operation{}
+	middlewares = map[string]Middleware{}
+	securities  = map[string]SecurityScheme{}
+)
+
+// Describe documents a handler. handler is a method expression such as
+// (*Handler).GetUser or a plain handler function; the routes it serves are
+// found by walking the router. Meant to be called from init, next to the
+// handlers.
+func Describe(handler any, op Operation) {
+	name := funcName(handler)
+	if name == "" {
+		panic("openapi: Describe needs a function")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := operations[name]; ok {
+		panic("openapi: " + name + " described twice")
+	}
+	operations[name] = op
+}
+
+// DescribeMiddleware documents a middleware. constructor is either the
+// middleware itself or the function that returns it.
+func DescribeMiddleware(constructor any, m Middleware) {
+	name := funcName(constructor)
+	if name == "" {
+		panic("openapi: DescribeMiddleware needs a function")
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	middlewares[name] = m
+}
+
+// DescribeSecurity adds a security scheme that middlewares can refer to
+func DescribeSecurity(name string, scheme SecurityScheme) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	securities[name] = scheme
+}
+
+// funcName returns the name a function is registered under. Method values
+// such as h.GetUser carry a -fm suffix that their method expressions don't.
+func funcName(fn any) string {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+	f := runtime.FuncForPC(v.Pointer())
+	if f == nil {
+		return ""
+	}
+	return strings.TrimSuffix(f.Name(), "-fm")
+}
+
+// lookupMiddleware finds the description of mw, which is a described
+// middleware or a closure returned by a described constructor. The caller
+// holds registryMu.
+func lookupMiddleware(mw func(http.Handler) http.Handler) (Middleware, bool) {
+	name := funcName(mw)
+	if m, ok := middlewares[name]; ok {
+		return m, true
+	}
+	// Closures are named after the function they were made in, as in
+	// pkg.Constructor.func1, or pkg.Constructor.1 once inlined
+	for {
+		i := strings.LastIndex(name, ".")
+		if i < 0 || !closureSuffix.MatchString(name[i+1:]) {
+			return Middleware{}, false
+		}
+		name = name[:i]
+		if m, ok := middlewares[name]; ok {
+			return m, true
+		}
+	}
+}
+
+var closureSuffix = regexp.MustCompile(`^(func)?[0-9]+$`)
+
+// ID is a path parameter holding a UUID
+func ID(name string) Param {
+	return Param{Name: name, Format: "uuid"}
+}
+
+// CursorQuery is the query of an endpoint paged with utils.GetCursorParams
+var CursorQuery = []Param{
+	{Name: "cursor", Description: "nextCursor of the previous page; omit for the first page"},
+	{Name: "limit", Type: "integer", Description: "Items per page, up to 100 on most lists"},
+}
+
+// IDsQuery is the query of a batch endpoint read with utils.GetQueryIDs
+var IDsQuery = []Param{
+	{Name: "ids", Required: true, Description: "Comma-separated IDs, at most 100"},
+}
@@ -0,0 +1,323 @@
+package openapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// Schema is a JSON Schema as used by OpenAPI 3.1
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	uuidType       = reflect.TypeOf(uuid.UUID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textType       = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// schemas turns Go types into schemas, the way encoding/json would write
+// them. Named structs become components and are referred to by name.
+type schemas struct {
+	components map[string]*Schema
+	names      map[reflect.Type]string
+}
+
+func newSchemas() *schemas {
+	return &schemas{components: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+// of returns the schema of v's type, or nil for a nil v
+func (s *schemas) of(v any) *Schema {
+	if v == nil {
+		return nil
+	}
+	return s.schema(reflect.TypeOf(v))
+}
+
+func (s *schemas) schema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Pointer {
+		return nullable(s.schema(t.Elem()))
+	}
+
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case uuidType:
+		return &Schema{Type: "string", Format: "uuid"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	if t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType) {
+		// Custom JSON could be anything
+		return &Schema{}
+	}
+	if t.Implements(textType) || reflect.PointerTo(t).Implements(textType) {
+		return &Schema{Type: "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: s.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + s.component(t)}
+	}
+	// Interfaces, and anything else encoding/json can't say up front
+	return &Schema{}
+}
+
+// component registers the named struct t and returns its component name
+func (s *schemas) component(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+
+	// Types from different packages can share a name; the later one is
+	// qualified with its package
+	name := componentName(t.Name())
+	if _, taken := s.components[name]; taken {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = componentName(pkg) + name
+		for i := 2; ; i++ {
+			if _, taken := s.components[name]; !taken {
+				break
+			}
+			name = componentName(pkg) + componentName(t.Name()) + strconv.Itoa(i)
+		}
+	}
+
+	// Registered before its fields so recursive types refer to themselves
+	s.names[t] = name
+	s.components[name] = &Schema{}
+	*s.components[name] = *s.object(t)
+	return name
+}
+
+func componentName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// object builds the schema of a struct from its JSON fields
+func (s *schemas) object(t reflect.Type) *Schema {
+	obj := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	s.fields(t, obj)
+	if len(obj.Properties) == 0 {
+		obj.Properties = nil
+	}
+	return obj
+}
+
+func (s *schemas) fields(t reflect.Type, obj *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Untagged embedded structs are flattened, as encoding/json does;
+		// fields of the outer struct win
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.fields(ft, obj)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := obj.Properties[name]; ok {
+			continue
+		}
+
+		var prop *Schema
+		if hasOption(opts, "string") {
+			prop = &Schema{Type: "string"}
+		} else {
+			prop = s.schema(f.Type)
+		}
+		rules := f.Tag.Get("validate")
+		applyRules(prop, rules)
+		obj.Properties[name] = prop
+
+		if isRequired(f, opts, rules) {
+			obj.Required = append(obj.Required, name)
+		}
+	}
+}
+
+func hasOption(opts, option string) bool {
+	for opts != "" {
+		var o string
+		o, opts, _ = strings.Cut(opts, ",")
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequired reports whether a field is always present: it is validated as
+// required, or it is a plain value that is never omitted
+func isRequired(f reflect.StructField, opts, rules string) bool {
+	if hasOption(rules, "required") {
+		return true
+	}
+	if hasOption(opts, "omitempty") || hasOption(rules, "omitempty") {
+		return false
+	}
+	switch f.Type.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+		return false
+	}
+	return true
+}
+
+// applyRules copies validate tag rules that JSON Schema can express
+func applyRules(prop *Schema, rules string) {
+	target := prop
+	if len(prop.AnyOf) > 0 {
+		target = prop.AnyOf[0]
+	}
+	var kind string
+	switch t := target.Type.(type) {
+	case string:
+		kind = t
+	case []string:
+		kind = t[0]
+	}
+	if target.Ref != "" {
+		return
+	}
+
+	for rules != "" {
+		var rule string
+		rule, rules, _ = strings.Cut(rules, ",")
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "dive":
+			// What follows applies to the elements
+			return
+		case "min", "max", "len":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				continue
+			}
+			setBound(target, kind, name, n)
+		case "oneof":
+			for _, v := range strings.Fields(param) {
+				target.Enum = append(target.Enum, v)
+			}
+		case "email":
+			target.Format = "email"
+		case "url":
+			target.Format = "uri"
+		case "uuid", "uuid4":
+			target.Format = "uuid"
+		}
+	}
+}
+
+func setBound(s *Schema, kind, rule string, n int) {
+	f := float64(n)
+	switch kind {
+	case "string":
+		if rule != "max" {
+			s.MinLength = &n
+		}
+		if rule != "min" {
+			s.MaxLength = &n
+		}
+	case "array":
+		if rule != "max" {
+			s.MinItems = &n
+		}
+		if rule != "min" {
+			s.MaxItems = &n
+		}
+	case "integer", "number":
+		if rule != "max" {
+			s.Minimum = &f
+		}
+		if rule != "min" {
+			s.Maximum = &f
+		}
+	}
+}
+
+// nullable lets s be null too, for pointers
+func nullable(s *Schema) *Schema {
+	if t, ok := s.Type.(string); ok && s.Ref == "" {
+		s.Type = []string{t, "null"}
+		return s
+	}
+	if s.Ref == "" && s.Type == nil {
+		// Already anything, null included
+		return s
+	}
+	return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+}
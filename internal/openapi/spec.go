@@ -0,0 +1,524 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zentra/server/internal/utils"
+)
+
+// Document is an OpenAPI 3.1 document
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Tags       []Tag                 `json:"tags,omitempty"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// PathItem maps lowercase HTTP methods to their operations
+type PathItem map[string]*DocOperation
+
+type DocOperation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []DocParam            `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	// Undocumented marks routes whose handler has no Describe yet
+	Undocumented bool `json:"x-undocumented,omitempty"`
+}
+
+type DocParam struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Spec builds the document once the router is complete and serves it
+type Spec struct {
+	info   Info
+	prefix string
+
+	mu   sync.RWMutex
+	json []byte
+}
+
+// NewSpec returns a Spec for the routes under prefix, such as /api/v1. Paths
+// in the document are relative to it.
+func NewSpec(info Info, prefix string) *Spec {
+	return &Spec{info: info, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+func init() {
+	Describe((*Spec).ServeHTTP, Operation{
+		OperationID: "getOpenAPIDocument",
+		Summary:     "Get this OpenAPI document",
+		Response:    map[string]any{},
+		Shape:       Raw,
+	})
+}
+
+// ServeHTTP writes the document, or a 503 until Build has run
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	body := s.json
+	s.mu.RUnlock()
+	if body == nil {
+		utils.RespondError(w, http.StatusServiceUnavailable, "API description is not ready")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(body)
+}
+
+// Build walks routes and generates the document. It reports routes whose
+// handler isn't described, described handlers that aren't routed anywhere
+// and descriptions that don't fit their route, so descriptions can't drift
+// from the router. The document is served either way, with what could be
+// described.
+func (s *Spec) Build(routes chi.Routes) error {
+	doc, err := s.document(routes)
+	body, merr := json.MarshalIndent(doc, "", "  ")
+	if merr != nil {
+		return merr
+	}
+
+	s.mu.Lock()
+	s.json = body
+	s.mu.Unlock()
+	return err
+}
+
+// JSON returns the document Build generated, or nil before it has run
+func (s *Spec) JSON() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.json
+}
+
+type route struct {
+	method  string
+	pattern string
+	handler http.Handler
+	mws     []func(http.Handler) http.Handler
+}
+
+func (s *Spec) document(routes chi.Routes) (*Document, error) {
+	var found []route
+	walk(routes, "", nil, func(rt route) {
+		if rt.pattern == s.prefix || strings.HasPrefix(rt.pattern, s.prefix+"/") {
+			found = append(found, rt)
+		}
+	})
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].pattern != found[j].pattern {
+			return found[i].pattern < found[j].pattern
+		}
+		return found[i].method < found[j].method
+	})
+
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	doc := &Document{
+		OpenAPI:    "3.1.0",
+		Info:       s.info,
+		Servers:    []Server{{URL: s.prefix}},
+		Paths:      map[string]PathItem{},
+		Components: Components{SecuritySchemes: map[string]SecurityScheme{}},
+	}
+	for name, scheme := range securities {
+		doc.Components.SecuritySchemes[name] = scheme
+	}
+	gen := newSchemas()
+	errorSchema := gen.of(utils.ErrorResponse{})
+
+	routed := map[string]bool{}
+	operationIDs := map[string]int{}
+	tags := map[string]bool{}
+	var errs []error
+
+	for _, rt := range found {
+		path, pathParams := openAPIPath(strings.TrimPrefix(rt.pattern, s.prefix))
+		name := handlerName(rt.handler)
+		op, described := operations[name]
+		routed[name] = true
+		if !described {
+			errs = append(errs, fmt.Errorf("%s %s: %s isn't described", rt.method, path, name))
+		}
+
+		docOp := &DocOperation{
+			Summary:      op.Summary,
+			Description:  op.Description,
+			Deprecated:   op.Deprecated,
+			Undocumented: !described,
+			Responses:    map[string]Response{},
+			Security:     []map[string][]string{},
+		}
+
+		id := op.OperationID
+		if id == "" {
+			id = operationID(name, rt.method, path)
+		}
+		operationIDs[id]++
+		if n := operationIDs[id]; n > 1 {
+			id += strconv.Itoa(n)
+		}
+		docOp.OperationID = id
+
+		if tag := pathTag(path); tag != "" {
+			docOp.Tags = []string{tag}
+			tags[tag] = true
+		}
+
+		// Path parameters come from the pattern; descriptions may refine them
+		overrides := map[string]Param{}
+		for _, p := range op.Path {
+			overrides[p.Name] = p
+		}
+		for _, p := range pathParams {
+			param, ok := overrides[p]
+			if !ok {
+				param = Param{Name: p}
+			}
+			delete(overrides, p)
+			param.Required = true
+			docOp.Parameters = append(docOp.Parameters, docParam(param, "path"))
+		}
+		for p := range overrides {
+			errs = append(errs, fmt.Errorf("%s %s: %s describes path parameter %q the route doesn't have", rt.method, path, name, p))
+		}
+		for _, p := range op.Query {
+			docOp.Parameters = append(docOp.Parameters, docParam(p, "query"))
+		}
+		for _, p := range op.Headers {
+			docOp.Parameters = append(docOp.Parameters, docParam(p, "header"))
+		}
+
+		if body := requestBody(gen, op); body != nil {
+			if rt.method == http.MethodGet || rt.method == http.MethodHead {
+				errs = append(errs, fmt.Errorf("%s %s: %s describes a body on a %s", rt.method, path, name, rt.method))
+			}
+			docOp.RequestBody = body
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		docOp.Responses[strconv.Itoa(status)] = successResponse(gen, op, status)
+		docOp.Responses["default"] = Response{
+			Description: "Error",
+			Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+		}
+
+		s.applyMiddlewares(docOp, rt, errorSchema)
+
+		item := doc.Paths[path]
+		if item == nil {
+			item = PathItem{}
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(rt.method)] = docOp
+	}
+
+	for name := range operations {
+		if !routed[name] {
+			errs = append(errs, fmt.Errorf("%s is described but not routed under %s", name, s.prefix))
+		}
+	}
+	for tag := range tags {
+		doc.Tags = append(doc.Tags, Tag{Name: tag})
+	}
+	sort.Slice(doc.Tags, func(i, j int) bool { return doc.Tags[i].Name < doc.Tags[j].Name })
+	doc.Components.Schemas = gen.components
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+	return doc, errors.Join(errs...)
+}
+
+// applyMiddlewares adds the security, headers and responses of the
+// middlewares in front of a route. Without an authenticating middleware the
+// route is public.
+func (s *Spec) applyMiddlewares(op *DocOperation, rt route, errorSchema *Schema) {
+	optional := false
+	for _, mw := range rt.mws {
+		m, ok := lookupMiddleware(mw)
+		if !ok || len(m.Methods) > 0 && !containsString(m.Methods, rt.method) {
+			continue
+		}
+		if m.Security != "" {
+			op.Security = append(op.Security, map[string][]string{m.Security: {}})
+			optional = optional || m.Optional
+		}
+		for _, h := range m.Headers {
+			op.Parameters = append(op.Parameters, docParam(h, "header"))
+		}
+		for status, description := range m.Responses {
+			code := strconv.Itoa(status)
+			if _, ok := op.Responses[code]; ok {
+				continue
+			}
+			op.Responses[code] = Response{
+				Description: description,
+				Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+			}
+		}
+	}
+	if optional {
+		op.Security = append(op.Security, map[string][]string{})
+	}
+}
+
+// walk is chi.Walk, except that it keeps the middlewares of groups that
+// routers are mounted in. chi.Walk drops those, and with them the
+// authentication of most of the API.
+func walk(r chi.Routes, parent string, mws []func(http.Handler) http.Handler, fn func(route)) {
+	mws = append(mws[:len(mws):len(mws)], r.Middlewares()...)
+	for _, rt := range r.Routes() {
+		if rt.SubRoutes != nil {
+			sub := mws
+			for _, h := range rt.Handlers {
+				if chain, ok := h.(*chi.ChainHandler); ok {
+					sub = append(sub[:len(sub):len(sub)], chain.Middlewares...)
+				}
+				break
+			}
+			walk(rt.SubRoutes, parent+strings.TrimSuffix(rt.Pattern, "/*"), sub, fn)
+			continue
+		}
+		for method, h := range rt.Handlers {
+			if method == "*" {
+				continue
+			}
+			routeMws := mws
+			if chain, ok := h.(*chi.ChainHandler); ok {
+				h = chain.Endpoint
+				routeMws = append(routeMws[:len(routeMws):len(routeMws)], chain.Middlewares...)
+			}
+			fn(route{method, parent + rt.Pattern, h, routeMws})
+		}
+	}
+}
+
+// requestBody describes the JSON, file upload or raw body op reads, or
+// returns nil when it reads none
+func requestBody(gen *schemas, op Operation) *RequestBody {
+	switch {
+	case op.Upload != "" || len(op.Form) > 0:
+		form := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		mediaType := "application/x-www-form-urlencoded"
+		if op.Upload != "" {
+			form.Properties[op.Upload] = &Schema{Type: "string", Format: "binary"}
+			form.Required = []string{op.Upload}
+			mediaType = "multipart/form-data"
+		}
+		for _, p := range op.Form {
+			form.Properties[p.Name] = docParam(p, "").Schema
+			if p.Required {
+				form.Required = append(form.Required, p.Name)
+			}
+		}
+		return &RequestBody{Required: true, Content: map[string]MediaType{mediaType: {Schema: form}}}
+	case op.BodyType != "":
+		schema := gen.of(op.Body)
+		if schema == nil {
+			schema = &Schema{Type: "string", Format: "binary"}
+		}
+		return &RequestBody{Required: !op.BodyOptional, Content: map[string]MediaType{op.BodyType: {Schema: schema}}}
+	case op.Body != nil:
+		return &RequestBody{
+			Required: !op.BodyOptional,
+			Content:  map[string]MediaType{"application/json": {Schema: gen.of(op.Body)}},
+		}
+	}
+	return nil
+}
+
+func successResponse(gen *schemas, op Operation, status int) Response {
+	resp := Response{Description: http.StatusText(status)}
+	if op.Shape == Empty || status == http.StatusNoContent || status >= 300 && status < 400 {
+		return resp
+	}
+
+	var schema *Schema
+	switch op.Shape {
+	case Binary:
+		mediaType := op.ResponseType
+		if mediaType == "" {
+			mediaType = "application/octet-stream"
+		}
+		resp.Content = map[string]MediaType{mediaType: {Schema: &Schema{Type: "string", Format: "binary"}}}
+		return resp
+	case Raw:
+		schema = gen.of(op.Response)
+		if schema == nil {
+			schema = &Schema{}
+		}
+	case Page:
+		schema = &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"data":       {Type: "array", Items: orAny(gen.of(op.Response))},
+				"nextCursor": {Type: "string", Description: "Cursor of the next page; absent on the last page"},
+				"hasMore":    {Type: "boolean"},
+			},
+			Required: []string{"data", "hasMore"},
+		}
+	default:
+		schema = &Schema{
+			Type: "object",
+			Properties: map[string]*Schema{
+				"data":    orAny(gen.of(op.Response)),
+				"message": {Type: "string"},
+			},
+		}
+		if op.Response != nil {
+			schema.Properties = map[string]*Schema{"data": gen.of(op.Response)}
+			schema.Required = []string{"data"}
+		}
+	}
+	resp.Content = map[string]MediaType{"application/json": {Schema: schema}}
+	return resp
+}
+
+func orAny(s *Schema) *Schema {
+	if s == nil {
+		return &Schema{}
+	}
+	return s
+}
+
+func docParam(p Param, in string) DocParam {
+	typ := p.Type
+	if typ == "" {
+		typ = "string"
+	}
+	schema := &Schema{Type: typ, Format: p.Format}
+	for _, v := range p.Enum {
+		schema.Enum = append(schema.Enum, v)
+	}
+	return DocParam{Name: p.Name, In: in, Description: p.Description, Required: p.Required, Schema: schema}
+}
+
+var patternParam = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// openAPIPath turns a chi pattern into an OpenAPI path and its parameters.
+// Regexps on parameters are dropped, a trailing slash is trimmed and a
+// trailing wildcard becomes {path}.
+func openAPIPath(pattern string) (string, []string) {
+	if strings.HasSuffix(pattern, "/*") {
+		pattern = strings.TrimSuffix(pattern, "*") + "{path}"
+	}
+	if len(pattern) > 1 {
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if pattern == "" {
+		pattern = "/"
+	}
+
+	var params []string
+	path := patternParam.ReplaceAllStringFunc(pattern, func(m string) string {
+		name := patternParam.FindStringSubmatch(m)[1]
+		params = append(params, name)
+		return "{" + name + "}"
+	})
+	return path, params
+}
+
+// handlerName returns the name handlers are described under
+func handlerName(h http.Handler) string {
+	if f, ok := h.(http.HandlerFunc); ok {
+		return funcName(f)
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+var nonWord = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// operationID names an operation after its handler, as in userGetUser for
+// user.(*Handler).GetUser, or after its method and path when the handler is
+// an anonymous function
+func operationID(handler, method, path string) string {
+	short := handler[strings.LastIndex(handler, "/")+1:]
+	pkg, rest, _ := strings.Cut(short, ".")
+	if rest != "" && !strings.Contains(rest, "func") {
+		return pkg + rest[strings.LastIndex(rest, ".")+1:]
+	}
+
+	id := strings.ToLower(method)
+	for _, word := range nonWord.Split(path, -1) {
+		if word != "" {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+// pathTag groups operations by the first segment of their path
+func pathTag(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if strings.ContainsAny(segment, "{.") {
+		return ""
+	}
+	return segment
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package antispam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/notification"
+	"github.com/zentra/server/internal/utils"
+)
+
+var ErrAlertNotFound = errors.New("moderation alert not found")
+
+// resolvedAlertRetention is how long handled alerts stay in the queue history
+const resolvedAlertRetention = 90 * 24 * time.Hour
+
+const alertColumns = `id, community_id, kind, title, body, channel_id, user_ids, metadata, status, resolved_by, resolved_at, created_at`
+
+// enqueueAlert stores an alert in the community's moderation queue
+func (s *Service) enqueueAlert(ctx context.Context, userIDs []uuid.UUID, actx *notification.ModeratorAlertContext) (uuid.UUID, error) {
+	kind, _ := actx.Metadata["kind"].(string)
+	if kind == "" {
+		kind = "spam"
+	}
+	if userIDs == nil {
+		userIDs = []uuid.UUID{}
+	}
+	metadata, err := json.Marshal(actx.Metadata)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var id uuid.UUID
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO moderation_alerts (community_id, kind, title, body, channel_id, user_ids, metadata)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		actx.CommunityID, kind, actx.Title, actx.Body, actx.ChannelID, userIDs, metadata,
+	).Scan(&id)
+	return id, err
+}
+
+// ListAlerts returns a page of a community's moderation queue, newest first,
+// and the cursor of the next page or "" on the last one. status may be empty
+// to include resolved alerts.
+func (s *Service) ListAlerts(ctx context.Context, communityID, actorID uuid.UUID, status, cursor string, limit int) ([]*models.ModerationAlert, string, error) {
+	if err := s.requireModerator(ctx, communityID, actorID); err != nil {
+		return nil, "", err
+	}
+	before, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	query := `SELECT ` + alertColumns + ` FROM moderation_alerts
+		WHERE community_id = $1 AND ($2 = '' OR status = $2)`
+	args := []any{communityID, status, limit + 1}
+	if before != nil {
+		query += ` AND (created_at, id) < ($4, $5)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		ORDER BY created_at DESC, id DESC
+		LIMIT $3`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	alerts := []*models.ModerationAlert{}
+	for rows.Next() {
+		a, err := scanAlert(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		alerts = append(alerts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(alerts) > limit {
+		alerts = alerts[:limit]
+		last := alerts[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.CreatedAt, ID: last.ID})
+	}
+	return alerts, next, nil
+}
+
+// ResolveAlert takes an alert off the open queue
+func (s *Service) ResolveAlert(ctx context.Context, communityID, alertID, actorID uuid.UUID) (*models.ModerationAlert, error) {
+	if err := s.requireModerator(ctx, communityID, actorID); err != nil {
+		return nil, err
+	}
+
+	row := s.db.QueryRow(ctx,
+		`UPDATE moderation_alerts
+		SET status = $3,
+		    resolved_by = CASE WHEN status = $3 THEN resolved_by ELSE $4 END,
+		    resolved_at = CASE WHEN status = $3 THEN resolved_at ELSE NOW() END
+		WHERE id = $1 AND community_id = $2
+		RETURNING `+alertColumns,
+		alertID, communityID, models.ModerationAlertResolved, actorID,
+	)
+	a, err := scanAlert(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAlertNotFound
+	}
+	return a, err
+}
+
+// PruneAlerts deletes resolved alerts after resolvedAlertRetention
+func (s *Service) PruneAlerts(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM moderation_alerts WHERE status = $1 AND resolved_at < $2`,
+		models.ModerationAlertResolved, time.Now().Add(-resolvedAlertRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// requireModerator allows anyone notified about alerts to work the queue
+func (s *Service) requireModerator(ctx context.Context, communityID, userID uuid.UUID) error {
+	permissions, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil {
+		return ErrInsufficientPerms
+	}
+	if models.HasPermission(permissions, models.PermissionManageMessages) ||
+		models.HasPermission(permissions, models.PermissionKickMembers) ||
+		models.HasPermission(permissions, models.PermissionBanMembers) {
+		return nil
+	}
+	return ErrInsufficientPerms
+}
+
+func scanAlert(row pgx.Row) (*models.ModerationAlert, error) {
+	a := &models.ModerationAlert{}
+	var metadata []byte
+	err := row.Scan(
+		&a.ID, &a.CommunityID, &a.Kind, &a.Title, &a.Body, &a.ChannelID, &a.UserIDs, &metadata,
+		&a.Status, &a.ResolvedBy, &a.ResolvedAt, &a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(metadata) > 0 {
+		_ = json.Unmarshal(metadata, &a.Metadata)
+	}
+	return a, nil
+}
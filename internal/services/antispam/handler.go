@@ -0,0 +1,201 @@
+package antispam
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/communities/{communityId}", func(r chi.Router) {
+		r.Get("/settings", h.GetSettings)
+		r.Patch("/settings", h.UpdateSettings)
+		r.Put("/raid-mode", h.SetRaidMode)
+		r.Get("/alerts", h.ListAlerts)
+		r.Post("/alerts/{alertId}/resolve", h.ResolveAlert)
+	})
+
+	return r
+}
+
+// GetSettings returns the spam and raid protection settings of a community
+func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	settings, err := h.service.GetSettings(r.Context(), communityID, userID)
+	if err != nil {
+		switch err {
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to get spam settings")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, settings)
+}
+
+func (h *Handler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req UpdateSettingsRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	settings, err := h.service.UpdateSettings(r.Context(), communityID, userID, &req)
+	if err != nil {
+		switch err {
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to update spam settings")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, settings)
+}
+
+// SetRaidMode turns raid mode on or off by hand
+func (h *Handler) SetRaidMode(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req RaidModeRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	settings, err := h.service.SetRaidMode(r.Context(), communityID, userID, &req)
+	if err != nil {
+		switch err {
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to update raid mode")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, settings)
+}
+
+// ListAlerts returns the community's moderation queue. Pass status=open to
+// see only unresolved alerts.
+func (h *Handler) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != models.ModerationAlertOpen && status != models.ModerationAlertResolved {
+		utils.RespondError(w, http.StatusBadRequest, "status must be open or resolved")
+		return
+	}
+
+	page := utils.GetCursorParams(r, 50, 100)
+
+	alerts, next, err := h.service.ListAlerts(r.Context(), communityID, userID, status, page.Cursor, page.Limit)
+	if err != nil {
+		switch err {
+		case utils.ErrInvalidCursor:
+			utils.RespondInvalidCursor(w)
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to get moderation alerts")
+		}
+		return
+	}
+
+	utils.RespondCursorPage(w, alerts, next)
+}
+
+// ResolveAlert marks a moderation alert as handled
+func (h *Handler) ResolveAlert(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	alertID, err := uuid.Parse(chi.URLParam(r, "alertId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid alert ID")
+		return
+	}
+
+	alert, err := h.service.ResolveAlert(r.Context(), communityID, alertID, userID)
+	if err != nil {
+		switch err {
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		case ErrAlertNotFound:
+			utils.RespondError(w, http.StatusNotFound, "Alert not found")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to resolve moderation alert")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, alert)
+}
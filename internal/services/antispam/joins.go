@@ -0,0 +1,233 @@
+package antispam
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/notification"
+)
+
+// recentJoinsKept caps how many joiners a join spike alert lists
+const recentJoinsKept = 50
+
+// ScreenJoin decides whether a join may go ahead. Invites are paused while raid
+// mode is on (if configured), the verification level is enforced and the join
+// velocity limits are applied. The limits count attempts, so a flood keeps them
+// closed until the window ends. Redis errors fail open.
+func (s *Service) ScreenJoin(ctx context.Context, attempt *models.JoinAttempt) (models.JoinVerdict, error) {
+	settings, err := s.settings(ctx, attempt.CommunityID)
+	if err != nil {
+		return models.JoinAllowed, err
+	}
+
+	if attempt.InviteID != nil && settings.RaidModeActive && settings.RaidPauseInvites {
+		return models.JoinInvitesPaused, nil
+	}
+
+	ok, err := s.meetsVerification(ctx, attempt.CommunityID, attempt.UserID, settings.EffectiveVerificationLevel(), false)
+	if err != nil {
+		return models.JoinAllowed, err
+	}
+	if !ok {
+		return models.JoinBelowVerification, nil
+	}
+
+	if settings.JoinLimitEnabled && s.overJoinLimit(ctx, attempt, settings) {
+		return models.JoinRateLimited, nil
+	}
+	return models.JoinAllowed, nil
+}
+
+func (s *Service) overJoinLimit(ctx context.Context, attempt *models.JoinAttempt, settings *models.SpamSettings) bool {
+	communityID := attempt.CommunityID
+
+	window := time.Duration(settings.CommunityJoinWindowSeconds) * time.Second
+	count, err := s.hit(ctx, keyPrefix+"joinrate:"+communityID.String(), window)
+	if err != nil {
+		log.Warn().Err(err).Msg("Community join limit check failed")
+	} else if count > int64(settings.CommunityJoinLimit) {
+		s.alert(ctx, communityID, "join_limit", nil, notification.ModeratorAlertContext{
+			CommunityID: communityID,
+			Title:       "Joins are being rate limited",
+			Body: fmt.Sprintf("More than %d join attempts within %d seconds. Further joins are refused until the window ends.",
+				settings.CommunityJoinLimit, settings.CommunityJoinWindowSeconds),
+			Metadata: map[string]any{
+				"kind":          "join_limit",
+				"limit":         settings.CommunityJoinLimit,
+				"windowSeconds": settings.CommunityJoinWindowSeconds,
+			},
+		})
+		return true
+	}
+
+	if attempt.InviteID == nil {
+		return false
+	}
+
+	inviteID := *attempt.InviteID
+	window = time.Duration(settings.InviteJoinWindowSeconds) * time.Second
+	count, err = s.hit(ctx, keyPrefix+"invitejoins:"+inviteID.String(), window)
+	if err != nil {
+		log.Warn().Err(err).Msg("Invite join limit check failed")
+	} else if count > int64(settings.InviteJoinLimit) {
+		s.alert(ctx, communityID, "invite_limit:"+inviteID.String(), nil, notification.ModeratorAlertContext{
+			CommunityID: communityID,
+			Title:       "Invite is being rate limited",
+			Body: fmt.Sprintf("More than %d join attempts through one invite within %d seconds. Consider deleting the invite.",
+				settings.InviteJoinLimit, settings.InviteJoinWindowSeconds),
+			Metadata: map[string]any{
+				"kind":          "invite_limit",
+				"inviteId":      inviteID,
+				"limit":         settings.InviteJoinLimit,
+				"windowSeconds": settings.InviteJoinWindowSeconds,
+			},
+		})
+		return true
+	}
+	return false
+}
+
+// RecordJoin counts a completed join towards spike detection and IP
+// clustering. A spike switches raid mode on (if allowed) and alerts
+// moderators; a cluster of accounts from one address only alerts, since
+// households and campuses share addresses too.
+func (s *Service) RecordJoin(ctx context.Context, attempt *models.JoinAttempt) {
+	communityID := attempt.CommunityID
+	settings, err := s.settings(ctx, communityID)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", communityID.String()).Msg("Failed to load spam settings for join")
+		return
+	}
+
+	if settings.IPClusterEnabled && attempt.IP != "" {
+		s.checkIPCluster(ctx, attempt, settings)
+	}
+	if !settings.JoinSpikeEnabled {
+		return
+	}
+
+	window := time.Duration(settings.JoinSpikeWindowSeconds) * time.Second
+	joins, err := s.hit(ctx, keyPrefix+"joins:"+communityID.String(), window)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", communityID.String()).Msg("Failed to count join for spike detection")
+		return
+	}
+	recent := s.trackRecentJoin(ctx, communityID, attempt.UserID, window)
+	if joins < int64(settings.JoinSpikeThreshold) {
+		return
+	}
+
+	if settings.RaidAutoEnable && !settings.RaidModeActive {
+		until, started, err := s.startRaidMode(ctx, communityID, settings.RaidDurationMinutes)
+		if err != nil {
+			log.Error().Err(err).Str("communityId", communityID.String()).Msg("Failed to enable raid mode")
+		} else if started {
+			log.Warn().Str("communityId", communityID.String()).Int64("joins", joins).Msg("Join spike detected, raid mode enabled")
+			body := fmt.Sprintf("%d members joined within %d seconds. New joins now need verification level %d until %s.",
+				joins, settings.JoinSpikeWindowSeconds, settings.RaidVerificationLevel, until.UTC().Format(time.RFC3339))
+			if settings.RaidPauseInvites {
+				body += " Invites are paused."
+			}
+			s.alert(ctx, communityID, "raid", recent, notification.ModeratorAlertContext{
+				CommunityID: communityID,
+				Title:       "Raid mode enabled",
+				Body:        body,
+				Metadata: map[string]any{
+					"kind":          "raid_mode",
+					"joins":         joins,
+					"windowSeconds": settings.JoinSpikeWindowSeconds,
+					"until":         until,
+					"invitesPaused": settings.RaidPauseInvites,
+				},
+			})
+			return
+		}
+	}
+
+	s.alert(ctx, communityID, "joins", recent, notification.ModeratorAlertContext{
+		CommunityID: communityID,
+		Title:       "Unusual number of joins",
+		Body:        fmt.Sprintf("%d members joined within %d seconds.", joins, settings.JoinSpikeWindowSeconds),
+		Metadata: map[string]any{
+			"kind":          "join_spike",
+			"joins":         joins,
+			"windowSeconds": settings.JoinSpikeWindowSeconds,
+		},
+	})
+}
+
+// trackRecentJoin remembers who joined during the spike window so an alert can
+// name them, and returns the list
+func (s *Service) trackRecentJoin(ctx context.Context, communityID, userID uuid.UUID, window time.Duration) []uuid.UUID {
+	key := keyPrefix + "recentjoins:" + communityID.String()
+	pipe := s.redis.TxPipeline()
+	pipe.LPush(ctx, key, userID.String())
+	pipe.LTrim(ctx, key, 0, recentJoinsKept-1)
+	pipe.Expire(ctx, key, window)
+	members := pipe.LRange(ctx, key, 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to track recent join")
+		return []uuid.UUID{userID}
+	}
+	return parseUserIDs(members.Val())
+}
+
+// checkIPCluster flags several accounts joining from one address within the
+// cluster window. Addresses are hashed so they never reach Redis or moderators.
+func (s *Service) checkIPCluster(ctx context.Context, attempt *models.JoinAttempt, settings *models.SpamSettings) {
+	communityID := attempt.CommunityID
+	clusterID := ipFingerprint(attempt.IP)
+	key := keyPrefix + "joinip:" + communityID.String() + ":" + clusterID
+	window := time.Duration(settings.IPClusterWindowSeconds) * time.Second
+
+	pipe := s.redis.TxPipeline()
+	pipe.SAdd(ctx, key, attempt.UserID.String())
+	pipe.ExpireNX(ctx, key, window)
+	members := pipe.SMembers(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Warn().Err(err).Msg("IP cluster check failed")
+		return
+	}
+
+	userIDs := parseUserIDs(members.Val())
+	if len(userIDs) < settings.IPClusterThreshold {
+		return
+	}
+
+	log.Warn().Str("communityId", communityID.String()).Str("clusterId", clusterID).Int("accounts", len(userIDs)).
+		Msg("Several accounts joined from one address")
+	s.alert(ctx, communityID, "ip_cluster:"+clusterID, userIDs, notification.ModeratorAlertContext{
+		CommunityID: communityID,
+		ActorID:     &attempt.UserID,
+		Title:       "Possible alt accounts",
+		Body: fmt.Sprintf("%d accounts joined from the same network within %s.",
+			len(userIDs), window.String()),
+		Metadata: map[string]any{
+			"kind":          "ip_cluster",
+			"clusterId":     clusterID,
+			"accounts":      len(userIDs),
+			"windowSeconds": settings.IPClusterWindowSeconds,
+		},
+	})
+}
+
+func ipFingerprint(ip string) string {
+	sum := sha1.Sum([]byte("zentra-join-ip:" + ip))
+	return hex.EncodeToString(sum[:6])
+}
+
+func parseUserIDs(values []string) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(values))
+	for _, v := range values {
+		if id, err := uuid.Parse(v); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
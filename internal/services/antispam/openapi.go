@@ -0,0 +1,42 @@
+package antispam
+
+import (
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+
+	openapi.Describe((*Handler).GetSettings, openapi.Operation{
+		Summary:  "Get a community's spam settings",
+		Path:     communityID,
+		Response: models.SpamSettings{},
+	})
+	openapi.Describe((*Handler).UpdateSettings, openapi.Operation{
+		Summary:  "Update a community's spam settings",
+		Path:     communityID,
+		Body:     UpdateSettingsRequest{},
+		Response: models.SpamSettings{},
+	})
+	openapi.Describe((*Handler).SetRaidMode, openapi.Operation{
+		Summary:  "Turn raid mode on or off",
+		Path:     communityID,
+		Body:     RaidModeRequest{},
+		Response: models.SpamSettings{},
+	})
+	openapi.Describe((*Handler).ListAlerts, openapi.Operation{
+		Summary: "List a community's moderation alerts",
+		Path:    communityID,
+		Query: append([]openapi.Param{
+			{Name: "status", Enum: []string{models.ModerationAlertOpen, models.ModerationAlertResolved}, Description: "Only alerts with this status"},
+		}, openapi.CursorQuery...),
+		Response: []*models.ModerationAlert{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).ResolveAlert, openapi.Operation{
+		Summary:  "Resolve a moderation alert",
+		Path:     append(communityID, openapi.ID("alertId")),
+		Response: models.ModerationAlert{},
+	})
+}
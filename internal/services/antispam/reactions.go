@@ -0,0 +1,110 @@
+package antispam
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/services/notification"
+)
+
+// Members who joined within this long count towards mass-react raids.
+// Established members piling onto a popular message are not a raid.
+const reactionRaidMemberAge = 24 * time.Hour
+
+// CheckReaction enforces the community's reaction burst limit and watches for
+// mass-react raids: many new members reacting in one channel at once. Once a
+// raid is detected moderators are alerted and new members can't react in the
+// channel until the window passes. Moderators are exempt, and Redis errors
+// fail open.
+func (s *Service) CheckReaction(ctx context.Context, channelID, userID uuid.UUID) error {
+	var communityID uuid.UUID
+	var joinedAt *time.Time
+	err := s.db.QueryRow(ctx,
+		`SELECT c.community_id, cm.joined_at
+		FROM channels c
+		LEFT JOIN community_members cm ON cm.community_id = c.community_id AND cm.user_id = $2
+		WHERE c.id = $1`,
+		channelID, userID,
+	).Scan(&communityID, &joinedAt)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil {
+		return err
+	}
+	if models.HasPermission(permissions, models.PermissionManageMessages) {
+		return nil
+	}
+
+	settings, err := s.settings(ctx, communityID)
+	if err != nil {
+		return err
+	}
+
+	if settings.ReactionBurstEnabled {
+		window := time.Duration(settings.ReactionBurstWindowSeconds) * time.Second
+		count, ttl, err := messaging.HitWindow(ctx, s.redis, keyPrefix+"reactburst:"+communityID.String()+":"+userID.String(), window)
+		if err != nil {
+			log.Warn().Err(err).Msg("Reaction burst check failed")
+		} else if count > int64(settings.ReactionBurstLimit) {
+			return &messaging.RateLimitError{
+				Code:       "REACTION_RATE_LIMITED",
+				Message:    "You are reacting too quickly",
+				RetryAfter: ttl,
+			}
+		}
+	}
+
+	if settings.ReactionRaidEnabled && joinedAt != nil && time.Since(*joinedAt) < reactionRaidMemberAge {
+		return s.checkReactionRaid(ctx, communityID, channelID, userID, settings)
+	}
+	return nil
+}
+
+// checkReactionRaid records a new member's reaction in the channel and
+// rejects it once enough new members reacted within the raid window
+func (s *Service) checkReactionRaid(ctx context.Context, communityID, channelID, userID uuid.UUID, settings *models.SpamSettings) error {
+	key := keyPrefix + "reactraid:" + channelID.String()
+	window := time.Duration(settings.ReactionRaidWindowSeconds) * time.Second
+
+	pipe := s.redis.TxPipeline()
+	pipe.SAdd(ctx, key, userID.String())
+	pipe.ExpireNX(ctx, key, window)
+	members := pipe.SMembers(ctx, key)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Warn().Err(err).Msg("Reaction raid check failed")
+		return nil
+	}
+
+	userIDs := parseUserIDs(members.Val())
+	if len(userIDs) < settings.ReactionRaidThreshold {
+		return nil
+	}
+
+	s.alert(ctx, communityID, "reactraid:"+channelID.String(), userIDs, notification.ModeratorAlertContext{
+		CommunityID: communityID,
+		ChannelID:   &channelID,
+		Title:       "Possible reaction raid",
+		Body: fmt.Sprintf("%d new members reacted in this channel within %d seconds. New members can't react here until it calms down.",
+			len(userIDs), settings.ReactionRaidWindowSeconds),
+		Metadata: map[string]any{
+			"kind":          "reaction_raid",
+			"members":       len(userIDs),
+			"windowSeconds": settings.ReactionRaidWindowSeconds,
+		},
+	})
+
+	return &messaging.RateLimitError{
+		Code:       "REACTION_RAID",
+		Message:    "Reactions from new members are paused in this channel",
+		RetryAfter: ttl.Val(),
+	}
+}
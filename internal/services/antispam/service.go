@@ -0,0 +1,531 @@
+package antispam
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/notification"
+)
+
+var (
+	ErrInsufficientPerms   = errors.New("insufficient permissions")
+	ErrMessageBurst        = errors.New("you are sending messages too quickly")
+	ErrDuplicateMessage    = errors.New("you are sending the same message too often")
+	ErrVerificationPending = errors.New("your account does not meet this community's verification level yet")
+)
+
+// Counters live in Redis in fixed windows that start at the first hit:
+//
+//	spam:burst:<channelId>:<userId>             messages in the burst window
+//	spam:dup:<communityId>:<userId>:<hash>      copies of one message in the duplicate window
+//	spam:joins:<communityId>                    joins in the spike window
+//	spam:recentjoins:<communityId>              users who joined in the spike window, newest first
+//	spam:joinrate:<communityId>                 join attempts in the community join-limit window
+//	spam:invitejoins:<inviteId>                 join attempts through one invite in its window
+//	spam:joinip:<communityId>:<ipHash>          set of users who joined from one address
+//	spam:reactburst:<communityId>:<userId>      reactions in the reaction burst window
+//	spam:reactraid:<channelId>                  set of new members who reacted in the raid window
+//	spam:alerted:<communityId>:<kind>[:<id>]    set while a moderator alert is throttled
+const (
+	keyPrefix     = "spam:"
+	alertCooldown = 10 * time.Minute
+)
+
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+	LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte)
+}
+
+type Service struct {
+	db                  *pgxpool.Pool
+	redis               *redis.Client
+	communityService    CommunityServiceInterface
+	notificationService *notification.Service
+}
+
+func NewService(db *pgxpool.Pool, redis *redis.Client, communityService CommunityServiceInterface) *Service {
+	return &Service{
+		db:               db,
+		redis:            redis,
+		communityService: communityService,
+	}
+}
+
+// SetNotificationService wires up moderator alerts; the notification service is
+// created after the hub, which is created after this service in main.
+func (s *Service) SetNotificationService(ns *notification.Service) {
+	s.notificationService = ns
+}
+
+type UpdateSettingsRequest struct {
+	VerificationLevel      *models.VerificationLevel `json:"verificationLevel" validate:"omitempty,min=0,max=3"`
+	BurstEnabled           *bool                     `json:"burstEnabled"`
+	BurstMessages          *int                      `json:"burstMessages" validate:"omitempty,min=2,max=50"`
+	BurstWindowSeconds     *int                      `json:"burstWindowSeconds" validate:"omitempty,min=1,max=60"`
+	DuplicateEnabled       *bool                     `json:"duplicateEnabled"`
+	DuplicateThreshold     *int                      `json:"duplicateThreshold" validate:"omitempty,min=2,max=20"`
+	DuplicateWindowSeconds *int                      `json:"duplicateWindowSeconds" validate:"omitempty,min=5,max=3600"`
+	JoinSpikeEnabled       *bool                     `json:"joinSpikeEnabled"`
+	JoinSpikeThreshold     *int                      `json:"joinSpikeThreshold" validate:"omitempty,min=3,max=1000"`
+	JoinSpikeWindowSeconds *int                      `json:"joinSpikeWindowSeconds" validate:"omitempty,min=10,max=3600"`
+	RaidAutoEnable         *bool                     `json:"raidAutoEnable"`
+	RaidVerificationLevel  *models.VerificationLevel `json:"raidVerificationLevel" validate:"omitempty,min=0,max=3"`
+	RaidDurationMinutes    *int                      `json:"raidDurationMinutes" validate:"omitempty,min=5,max=1440"`
+	RaidPauseInvites       *bool                     `json:"raidPauseInvites"`
+
+	JoinLimitEnabled           *bool `json:"joinLimitEnabled"`
+	CommunityJoinLimit         *int  `json:"communityJoinLimit" validate:"omitempty,min=5,max=10000"`
+	CommunityJoinWindowSeconds *int  `json:"communityJoinWindowSeconds" validate:"omitempty,min=10,max=3600"`
+	InviteJoinLimit            *int  `json:"inviteJoinLimit" validate:"omitempty,min=1,max=10000"`
+	InviteJoinWindowSeconds    *int  `json:"inviteJoinWindowSeconds" validate:"omitempty,min=10,max=3600"`
+	IPClusterEnabled           *bool `json:"ipClusterEnabled"`
+	IPClusterThreshold         *int  `json:"ipClusterThreshold" validate:"omitempty,min=2,max=100"`
+	IPClusterWindowSeconds     *int  `json:"ipClusterWindowSeconds" validate:"omitempty,min=60,max=86400"`
+
+	ReactionBurstEnabled       *bool `json:"reactionBurstEnabled"`
+	ReactionBurstLimit         *int  `json:"reactionBurstLimit" validate:"omitempty,min=2,max=100"`
+	ReactionBurstWindowSeconds *int  `json:"reactionBurstWindowSeconds" validate:"omitempty,min=1,max=300"`
+	ReactionRaidEnabled        *bool `json:"reactionRaidEnabled"`
+	ReactionRaidThreshold      *int  `json:"reactionRaidThreshold" validate:"omitempty,min=3,max=1000"`
+	ReactionRaidWindowSeconds  *int  `json:"reactionRaidWindowSeconds" validate:"omitempty,min=10,max=3600"`
+}
+
+type RaidModeRequest struct {
+	Enabled         bool `json:"enabled"`
+	DurationMinutes *int `json:"durationMinutes" validate:"omitempty,min=5,max=1440"`
+}
+
+// GetSettings returns a community's spam settings, requiring Manage Community
+func (s *Service) GetSettings(ctx context.Context, communityID, actorID uuid.UUID) (*models.SpamSettings, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+	return s.settings(ctx, communityID)
+}
+
+// UpdateSettings changes the provided fields and keeps the rest
+func (s *Service) UpdateSettings(ctx context.Context, communityID, actorID uuid.UUID, req *UpdateSettingsRequest) (*models.SpamSettings, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.settings(ctx, communityID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.VerificationLevel != nil {
+		settings.VerificationLevel = *req.VerificationLevel
+	}
+	if req.BurstEnabled != nil {
+		settings.BurstEnabled = *req.BurstEnabled
+	}
+	if req.BurstMessages != nil {
+		settings.BurstMessages = *req.BurstMessages
+	}
+	if req.BurstWindowSeconds != nil {
+		settings.BurstWindowSeconds = *req.BurstWindowSeconds
+	}
+	if req.DuplicateEnabled != nil {
+		settings.DuplicateEnabled = *req.DuplicateEnabled
+	}
+	if req.DuplicateThreshold != nil {
+		settings.DuplicateThreshold = *req.DuplicateThreshold
+	}
+	if req.DuplicateWindowSeconds != nil {
+		settings.DuplicateWindowSeconds = *req.DuplicateWindowSeconds
+	}
+	if req.JoinSpikeEnabled != nil {
+		settings.JoinSpikeEnabled = *req.JoinSpikeEnabled
+	}
+	if req.JoinSpikeThreshold != nil {
+		settings.JoinSpikeThreshold = *req.JoinSpikeThreshold
+	}
+	if req.JoinSpikeWindowSeconds != nil {
+		settings.JoinSpikeWindowSeconds = *req.JoinSpikeWindowSeconds
+	}
+	if req.RaidAutoEnable != nil {
+		settings.RaidAutoEnable = *req.RaidAutoEnable
+	}
+	if req.RaidVerificationLevel != nil {
+		settings.RaidVerificationLevel = *req.RaidVerificationLevel
+	}
+	if req.RaidDurationMinutes != nil {
+		settings.RaidDurationMinutes = *req.RaidDurationMinutes
+	}
+	if req.RaidPauseInvites != nil {
+		settings.RaidPauseInvites = *req.RaidPauseInvites
+	}
+	if req.JoinLimitEnabled != nil {
+		settings.JoinLimitEnabled = *req.JoinLimitEnabled
+	}
+	if req.CommunityJoinLimit != nil {
+		settings.CommunityJoinLimit = *req.CommunityJoinLimit
+	}
+	if req.CommunityJoinWindowSeconds != nil {
+		settings.CommunityJoinWindowSeconds = *req.CommunityJoinWindowSeconds
+	}
+	if req.InviteJoinLimit != nil {
+		settings.InviteJoinLimit = *req.InviteJoinLimit
+	}
+	if req.InviteJoinWindowSeconds != nil {
+		settings.InviteJoinWindowSeconds = *req.InviteJoinWindowSeconds
+	}
+	if req.IPClusterEnabled != nil {
+		settings.IPClusterEnabled = *req.IPClusterEnabled
+	}
+	if req.IPClusterThreshold != nil {
+		settings.IPClusterThreshold = *req.IPClusterThreshold
+	}
+	if req.IPClusterWindowSeconds != nil {
+		settings.IPClusterWindowSeconds = *req.IPClusterWindowSeconds
+	}
+	if req.ReactionBurstEnabled != nil {
+		settings.ReactionBurstEnabled = *req.ReactionBurstEnabled
+	}
+	if req.ReactionBurstLimit != nil {
+		settings.ReactionBurstLimit = *req.ReactionBurstLimit
+	}
+	if req.ReactionBurstWindowSeconds != nil {
+		settings.ReactionBurstWindowSeconds = *req.ReactionBurstWindowSeconds
+	}
+	if req.ReactionRaidEnabled != nil {
+		settings.ReactionRaidEnabled = *req.ReactionRaidEnabled
+	}
+	if req.ReactionRaidThreshold != nil {
+		settings.ReactionRaidThreshold = *req.ReactionRaidThreshold
+	}
+	if req.ReactionRaidWindowSeconds != nil {
+		settings.ReactionRaidWindowSeconds = *req.ReactionRaidWindowSeconds
+	}
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO community_spam_settings (
+			community_id, verification_level,
+			burst_enabled, burst_messages, burst_window_seconds,
+			duplicate_enabled, duplicate_threshold, duplicate_window_seconds,
+			join_spike_enabled, join_spike_threshold, join_spike_window_seconds,
+			raid_auto_enable, raid_verification_level, raid_duration_minutes, raid_pause_invites,
+			join_limit_enabled, community_join_limit, community_join_window_seconds,
+			invite_join_limit, invite_join_window_seconds,
+			ip_cluster_enabled, ip_cluster_threshold, ip_cluster_window_seconds,
+			reaction_burst_enabled, reaction_burst_limit, reaction_burst_window_seconds,
+			reaction_raid_enabled, reaction_raid_threshold, reaction_raid_window_seconds
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23,
+			$24, $25, $26, $27, $28, $29)
+		ON CONFLICT (community_id) DO UPDATE SET
+			verification_level = EXCLUDED.verification_level,
+			burst_enabled = EXCLUDED.burst_enabled,
+			burst_messages = EXCLUDED.burst_messages,
+			burst_window_seconds = EXCLUDED.burst_window_seconds,
+			duplicate_enabled = EXCLUDED.duplicate_enabled,
+			duplicate_threshold = EXCLUDED.duplicate_threshold,
+			duplicate_window_seconds = EXCLUDED.duplicate_window_seconds,
+			join_spike_enabled = EXCLUDED.join_spike_enabled,
+			join_spike_threshold = EXCLUDED.join_spike_threshold,
+			join_spike_window_seconds = EXCLUDED.join_spike_window_seconds,
+			raid_auto_enable = EXCLUDED.raid_auto_enable,
+			raid_verification_level = EXCLUDED.raid_verification_level,
+			raid_duration_minutes = EXCLUDED.raid_duration_minutes,
+			raid_pause_invites = EXCLUDED.raid_pause_invites,
+			join_limit_enabled = EXCLUDED.join_limit_enabled,
+			community_join_limit = EXCLUDED.community_join_limit,
+			community_join_window_seconds = EXCLUDED.community_join_window_seconds,
+			invite_join_limit = EXCLUDED.invite_join_limit,
+			invite_join_window_seconds = EXCLUDED.invite_join_window_seconds,
+			ip_cluster_enabled = EXCLUDED.ip_cluster_enabled,
+			ip_cluster_threshold = EXCLUDED.ip_cluster_threshold,
+			ip_cluster_window_seconds = EXCLUDED.ip_cluster_window_seconds,
+			reaction_burst_enabled = EXCLUDED.reaction_burst_enabled,
+			reaction_burst_limit = EXCLUDED.reaction_burst_limit,
+			reaction_burst_window_seconds = EXCLUDED.reaction_burst_window_seconds,
+			reaction_raid_enabled = EXCLUDED.reaction_raid_enabled,
+			reaction_raid_threshold = EXCLUDED.reaction_raid_threshold,
+			reaction_raid_window_seconds = EXCLUDED.reaction_raid_window_seconds`,
+		communityID, settings.VerificationLevel,
+		settings.BurstEnabled, settings.BurstMessages, settings.BurstWindowSeconds,
+		settings.DuplicateEnabled, settings.DuplicateThreshold, settings.DuplicateWindowSeconds,
+		settings.JoinSpikeEnabled, settings.JoinSpikeThreshold, settings.JoinSpikeWindowSeconds,
+		settings.RaidAutoEnable, settings.RaidVerificationLevel, settings.RaidDurationMinutes, settings.RaidPauseInvites,
+		settings.JoinLimitEnabled, settings.CommunityJoinLimit, settings.CommunityJoinWindowSeconds,
+		settings.InviteJoinLimit, settings.InviteJoinWindowSeconds,
+		settings.IPClusterEnabled, settings.IPClusterThreshold, settings.IPClusterWindowSeconds,
+		settings.ReactionBurstEnabled, settings.ReactionBurstLimit, settings.ReactionBurstWindowSeconds,
+		settings.ReactionRaidEnabled, settings.ReactionRaidThreshold, settings.ReactionRaidWindowSeconds,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("save spam settings: %w", err)
+	}
+
+	details, _ := json.Marshal(req)
+	s.communityService.LogAudit(ctx, &communityID, actorID, models.AuditActionSpamSettings, "community", &communityID, details)
+
+	return s.settings(ctx, communityID)
+}
+
+// SetRaidMode lets moderators turn raid mode on early or end it
+func (s *Service) SetRaidMode(ctx context.Context, communityID, actorID uuid.UUID, req *RaidModeRequest) (*models.SpamSettings, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+
+	var until *time.Time
+	action := models.AuditActionRaidModeDisable
+	if req.Enabled {
+		settings, err := s.settings(ctx, communityID)
+		if err != nil {
+			return nil, err
+		}
+		duration := settings.RaidDurationMinutes
+		if req.DurationMinutes != nil {
+			duration = *req.DurationMinutes
+		}
+		t := time.Now().Add(time.Duration(duration) * time.Minute)
+		until = &t
+		action = models.AuditActionRaidModeEnable
+	}
+
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO community_spam_settings (community_id, raid_mode_until) VALUES ($1, $2)
+		ON CONFLICT (community_id) DO UPDATE SET raid_mode_until = EXCLUDED.raid_mode_until`,
+		communityID, until,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("set raid mode: %w", err)
+	}
+
+	s.communityService.LogAudit(ctx, &communityID, actorID, action, "community", &communityID, nil)
+
+	return s.settings(ctx, communityID)
+}
+
+// CheckMessage enforces the verification level, burst limit and duplicate detection
+// for a message about to be sent in channelID. Moderators are exempt. Redis errors
+// fail open so an outage never blocks chat.
+func (s *Service) CheckMessage(ctx context.Context, channelID, userID uuid.UUID, content string) error {
+	var communityID uuid.UUID
+	if err := s.db.QueryRow(ctx, `SELECT community_id FROM channels WHERE id = $1`, channelID).Scan(&communityID); err != nil {
+		return err
+	}
+
+	permissions, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil {
+		return err
+	}
+	if models.HasPermission(permissions, models.PermissionManageMessages) {
+		return nil
+	}
+
+	settings, err := s.settings(ctx, communityID)
+	if err != nil {
+		return err
+	}
+
+	ok, err := s.meetsVerification(ctx, communityID, userID, settings.EffectiveVerificationLevel(), true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrVerificationPending
+	}
+
+	if settings.BurstEnabled {
+		window := time.Duration(settings.BurstWindowSeconds) * time.Second
+		count, err := s.hit(ctx, keyPrefix+"burst:"+channelID.String()+":"+userID.String(), window)
+		if err != nil {
+			log.Warn().Err(err).Msg("Burst limit check failed")
+		} else if count > int64(settings.BurstMessages) {
+			s.alertUser(ctx, communityID, channelID, userID, "burst",
+				fmt.Sprintf("Sent more than %d messages in %d seconds.", settings.BurstMessages, settings.BurstWindowSeconds))
+			return ErrMessageBurst
+		}
+	}
+
+	if fingerprint := contentFingerprint(content); settings.DuplicateEnabled && fingerprint != "" {
+		window := time.Duration(settings.DuplicateWindowSeconds) * time.Second
+		count, err := s.hit(ctx, keyPrefix+"dup:"+communityID.String()+":"+userID.String()+":"+fingerprint, window)
+		if err != nil {
+			log.Warn().Err(err).Msg("Duplicate message check failed")
+		} else if count > int64(settings.DuplicateThreshold) {
+			s.alertUser(ctx, communityID, channelID, userID, "duplicate",
+				fmt.Sprintf("Sent the same message more than %d times in %d seconds.", settings.DuplicateThreshold, settings.DuplicateWindowSeconds))
+			return ErrDuplicateMessage
+		}
+	}
+
+	return nil
+}
+
+func (s *Service) settings(ctx context.Context, communityID uuid.UUID) (*models.SpamSettings, error) {
+	settings := &models.SpamSettings{}
+	err := s.db.QueryRow(ctx,
+		`SELECT community_id, verification_level,
+			burst_enabled, burst_messages, burst_window_seconds,
+			duplicate_enabled, duplicate_threshold, duplicate_window_seconds,
+			join_spike_enabled, join_spike_threshold, join_spike_window_seconds,
+			raid_auto_enable, raid_verification_level, raid_duration_minutes, raid_pause_invites, raid_mode_until,
+			join_limit_enabled, community_join_limit, community_join_window_seconds,
+			invite_join_limit, invite_join_window_seconds,
+			ip_cluster_enabled, ip_cluster_threshold, ip_cluster_window_seconds,
+			reaction_burst_enabled, reaction_burst_limit, reaction_burst_window_seconds,
+			reaction_raid_enabled, reaction_raid_threshold, reaction_raid_window_seconds
+		FROM community_spam_settings WHERE community_id = $1`,
+		communityID,
+	).Scan(
+		&settings.CommunityID, &settings.VerificationLevel,
+		&settings.BurstEnabled, &settings.BurstMessages, &settings.BurstWindowSeconds,
+		&settings.DuplicateEnabled, &settings.DuplicateThreshold, &settings.DuplicateWindowSeconds,
+		&settings.JoinSpikeEnabled, &settings.JoinSpikeThreshold, &settings.JoinSpikeWindowSeconds,
+		&settings.RaidAutoEnable, &settings.RaidVerificationLevel, &settings.RaidDurationMinutes, &settings.RaidPauseInvites, &settings.RaidModeUntil,
+		&settings.JoinLimitEnabled, &settings.CommunityJoinLimit, &settings.CommunityJoinWindowSeconds,
+		&settings.InviteJoinLimit, &settings.InviteJoinWindowSeconds,
+		&settings.IPClusterEnabled, &settings.IPClusterThreshold, &settings.IPClusterWindowSeconds,
+		&settings.ReactionBurstEnabled, &settings.ReactionBurstLimit, &settings.ReactionBurstWindowSeconds,
+		&settings.ReactionRaidEnabled, &settings.ReactionRaidThreshold, &settings.ReactionRaidWindowSeconds,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.DefaultSpamSettings(communityID), nil
+		}
+		return nil, err
+	}
+
+	settings.RaidModeActive = settings.RaidModeUntil != nil && settings.RaidModeUntil.After(time.Now())
+	return settings, nil
+}
+
+// startRaidMode turns raid mode on unless it already is. Only one caller wins when
+// several gateway instances see the spike at once; started tells the winner.
+func (s *Service) startRaidMode(ctx context.Context, communityID uuid.UUID, durationMinutes int) (until time.Time, started bool, err error) {
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO community_spam_settings (community_id, raid_mode_until)
+		VALUES ($1, NOW() + make_interval(mins => $2))
+		ON CONFLICT (community_id) DO UPDATE SET raid_mode_until = EXCLUDED.raid_mode_until
+		WHERE community_spam_settings.raid_mode_until IS NULL OR community_spam_settings.raid_mode_until <= NOW()
+		RETURNING raid_mode_until`,
+		communityID, durationMinutes,
+	).Scan(&until)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return until, true, nil
+}
+
+// meetsVerification checks each requirement up to level. Membership age only
+// applies to sending messages, as the user is not a member yet when joining.
+func (s *Service) meetsVerification(ctx context.Context, communityID, userID uuid.UUID, level models.VerificationLevel, checkMembership bool) (bool, error) {
+	if level <= models.VerificationLevelNone {
+		return true, nil
+	}
+
+	var emailVerified bool
+	var accountCreated time.Time
+	var joinedAt *time.Time
+	err := s.db.QueryRow(ctx,
+		`SELECT COALESCE(u.email_verified, FALSE), u.created_at, cm.joined_at
+		FROM users u
+		LEFT JOIN community_members cm ON cm.user_id = u.id AND cm.community_id = $2
+		WHERE u.id = $1`,
+		userID, communityID,
+	).Scan(&emailVerified, &accountCreated, &joinedAt)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if !emailVerified {
+		return false, nil
+	}
+	if level >= models.VerificationLevelMedium && now.Sub(accountCreated) < models.VerificationMinAccountAge {
+		return false, nil
+	}
+	if checkMembership && level >= models.VerificationLevelHigh && joinedAt != nil && now.Sub(*joinedAt) < models.VerificationMinMemberAge {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *Service) requirePermission(ctx context.Context, communityID, userID uuid.UUID, permission int64) error {
+	permissions, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(permissions, permission) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+// hit increments a fixed-window counter; the window starts with the first hit
+func (s *Service) hit(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := s.redis.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := s.redis.Expire(ctx, key, window).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// alertUser notifies moderators about one user tripping a message limit
+func (s *Service) alertUser(ctx context.Context, communityID, channelID, userID uuid.UUID, kind, body string) {
+	s.alert(ctx, communityID, kind+":"+userID.String(), []uuid.UUID{userID}, notification.ModeratorAlertContext{
+		CommunityID: communityID,
+		ChannelID:   &channelID,
+		ActorID:     &userID,
+		Title:       "Possible spam detected",
+		Body:        body,
+		Metadata:    map[string]any{"kind": kind},
+	})
+}
+
+// alert queues a moderation alert and notifies moderators, at most once per
+// alertCooldown per throttle key. userIDs are the accounts the alert is about.
+func (s *Service) alert(ctx context.Context, communityID uuid.UUID, throttle string, userIDs []uuid.UUID, actx notification.ModeratorAlertContext) {
+	key := keyPrefix + "alerted:" + communityID.String() + ":" + throttle
+	first, err := s.redis.SetNX(ctx, key, 1, alertCooldown).Result()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to throttle moderator alert")
+	} else if !first {
+		return
+	}
+
+	alertID, err := s.enqueueAlert(ctx, userIDs, &actx)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", communityID.String()).Msg("Failed to queue moderation alert")
+	} else {
+		if actx.Metadata == nil {
+			actx.Metadata = map[string]any{}
+		}
+		actx.Metadata["alertId"] = alertID
+	}
+
+	if s.notificationService != nil {
+		go s.notificationService.ProcessModeratorAlert(actx)
+	}
+}
+
+// contentFingerprint normalises case and whitespace so trivially varied copies still match
+func contentFingerprint(content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	if normalized == "" {
+		return ""
+	}
+	sum := sha1.Sum([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
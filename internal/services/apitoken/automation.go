@@ -0,0 +1,173 @@
+package apitoken
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/auth"
+	"github.com/zentra/server/pkg/database"
+)
+
+// The actions below are what a community API token can do. Each one checks
+// the token's scopes itself and is written to the audit log as its bot user.
+
+type PostMessageRequest struct {
+	Content    string                `json:"content" validate:"required_without=Components,max=4000"`
+	Components []models.ComponentRow `json:"components" validate:"omitempty,max=5,dive"`
+}
+
+type CreateInviteRequest struct {
+	MaxUses *int `json:"maxUses" validate:"omitempty,min=1,max=100"`
+	// ExpiresIn is the invite lifetime in seconds
+	ExpiresIn *int64 `json:"expiresIn" validate:"omitempty,min=60"`
+}
+
+// PostMessage sends a message as the token's bot user to one of its channels
+func (s *Service) PostMessage(ctx context.Context, token *models.CommunityAPIToken, channelID uuid.UUID, req *PostMessageRequest) (*message.MessageResponse, error) {
+	if !token.CanPostIn(channelID) {
+		return nil, ErrScopeNotGranted
+	}
+
+	if err := messaging.ValidateComponents(req.Components); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.messages.CreateBotMessage(ctx, &message.BotMessage{
+		ChannelID:   channelID,
+		CommunityID: token.CommunityID,
+		AuthorID:    token.BotUserID,
+		Content:     req.Content,
+		Components:  req.Components,
+	})
+	if err != nil {
+		// The channel was moved out of the community or archived since the token was made
+		if errors.Is(err, message.ErrChannelUnavailable) {
+			return nil, ErrScopeNotGranted
+		}
+		return nil, err
+	}
+
+	details, _ := json.Marshal(map[string]any{"apiTokenId": token.ID, "channelId": channelID})
+	s.communityService.LogAudit(ctx, &token.CommunityID, token.BotUserID, models.AuditActionAPITokenMessage, "message", &resp.ID, details)
+
+	return resp, nil
+}
+
+// ListInvites returns every invite of the token's community
+func (s *Service) ListInvites(ctx context.Context, token *models.CommunityAPIToken) ([]*models.CommunityInvite, error) {
+	if !token.HasScope(models.APITokenScopeManageInvites) {
+		return nil, ErrScopeNotGranted
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT id, community_id, code, created_by, max_uses, use_count, expires_at, created_at
+		 FROM community_invites WHERE community_id = $1
+		 ORDER BY created_at DESC`,
+		token.CommunityID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list invites: %w", err)
+	}
+	defer rows.Close()
+
+	invites := make([]*models.CommunityInvite, 0)
+	for rows.Next() {
+		invite := &models.CommunityInvite{}
+		if err := rows.Scan(
+			&invite.ID, &invite.CommunityID, &invite.Code, &invite.CreatedBy,
+			&invite.MaxUses, &invite.UseCount, &invite.ExpiresAt, &invite.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan invite: %w", err)
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// CreateInvite makes an invite to the token's community
+func (s *Service) CreateInvite(ctx context.Context, token *models.CommunityAPIToken, req *CreateInviteRequest) (*models.CommunityInvite, error) {
+	if !token.HasScope(models.APITokenScopeManageInvites) {
+		return nil, ErrScopeNotGranted
+	}
+
+	code, err := auth.GenerateInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	invite := &models.CommunityInvite{
+		ID:          uuid.New(),
+		CommunityID: token.CommunityID,
+		Code:        code,
+		CreatedBy:   token.BotUserID,
+		MaxUses:     req.MaxUses,
+		CreatedAt:   time.Now(),
+	}
+	if req.ExpiresIn != nil {
+		expires := invite.CreatedAt.Add(time.Duration(*req.ExpiresIn) * time.Second)
+		invite.ExpiresAt = &expires
+	}
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO community_invites (id, community_id, code, created_by, max_uses, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		invite.ID, invite.CommunityID, invite.Code, invite.CreatedBy, invite.MaxUses, invite.ExpiresAt, invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create invite: %w", err)
+	}
+
+	details, _ := json.Marshal(map[string]any{"apiTokenId": token.ID, "code": invite.Code})
+	s.communityService.LogAudit(ctx, &token.CommunityID, token.BotUserID, models.AuditActionInviteCreate, "invite", &invite.ID, details)
+	return invite, nil
+}
+
+// DeleteInvite removes an invite of the token's community
+func (s *Service) DeleteInvite(ctx context.Context, token *models.CommunityAPIToken, inviteID uuid.UUID) error {
+	if !token.HasScope(models.APITokenScopeManageInvites) {
+		return ErrScopeNotGranted
+	}
+
+	var code string
+	err := s.db.QueryRow(ctx,
+		`DELETE FROM community_invites WHERE id = $1 AND community_id = $2 RETURNING code`,
+		inviteID, token.CommunityID,
+	).Scan(&code)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrInviteNotFound
+		}
+		return fmt.Errorf("delete invite: %w", err)
+	}
+
+	details, _ := json.Marshal(map[string]any{"apiTokenId": token.ID, "code": code})
+	s.communityService.LogAudit(ctx, &token.CommunityID, token.BotUserID, models.AuditActionInviteDelete, "invite", &inviteID, details)
+	return nil
+}
+
+func (s *Service) broadcast(ctx context.Context, channelID string, eventType string, data any) {
+	payload, err := json.Marshal(map[string]any{
+		"channelId": channelID,
+		"event": map[string]any{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal api token broadcast event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Error().Err(err).Msg("Failed to publish api token broadcast")
+	}
+}
@@ -0,0 +1,385 @@
+package apitoken
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Routes are the token management endpoints used from community settings
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/communities/{communityId}", func(r chi.Router) {
+		r.Get("/tokens", h.ListTokens)
+		r.Post("/tokens", h.CreateToken)
+	})
+
+	r.Route("/tokens/{tokenId}", func(r chi.Router) {
+		r.Patch("/", h.UpdateToken)
+		r.Delete("/", h.RevokeToken)
+		r.Post("/rotate", h.RotateToken)
+	})
+
+	return r
+}
+
+// AutomationRoutes are the endpoints called with a community API token. They
+// must be mounted behind middleware.CommunityTokenMiddleware.
+func (h *Handler) AutomationRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/me", h.GetCurrentToken)
+	r.With(middleware.Idempotent).Post("/channels/{channelId}/messages", h.PostMessage)
+	r.Get("/invites", h.ListInvites)
+	r.Post("/invites", h.CreateInvite)
+	r.Delete("/invites/{inviteId}", h.DeleteInvite)
+	r.Post("/interactions/poll", h.PollInteractions)
+	r.Post("/interactions/respond", h.RespondInteraction)
+
+	return r
+}
+
+// InteractionRoutes are used by members to click components on bot messages
+func (h *Handler) InteractionRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Post("/", h.CreateInteraction)
+
+	return r
+}
+
+// ListTokens returns the active API tokens of a community
+func (h *Handler) ListTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	tokens, err := h.service.ListTokens(r.Context(), communityID, userID)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to get API tokens")
+		return
+	}
+
+	utils.RespondSuccess(w, tokens)
+}
+
+// CreateToken issues a token. The response holds the token itself, which is
+// not shown again.
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req CreateTokenRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	token, err := h.service.CreateToken(r.Context(), communityID, userID, &req)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to create API token")
+		return
+	}
+
+	utils.RespondCreated(w, token)
+}
+
+// UpdateToken changes the name, scopes or channels of a token
+func (h *Handler) UpdateToken(w http.ResponseWriter, r *http.Request) {
+	userID, tokenID, ok := h.tokenParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateTokenRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	token, err := h.service.UpdateToken(r.Context(), tokenID, userID, &req)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to update API token")
+		return
+	}
+
+	utils.RespondSuccess(w, token)
+}
+
+// RevokeToken permanently disables a token
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	userID, tokenID, ok := h.tokenParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeToken(r.Context(), tokenID, userID); err != nil {
+		h.respondTokenError(w, err, "Failed to revoke API token")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// RotateToken issues a new secret for a token
+func (h *Handler) RotateToken(w http.ResponseWriter, r *http.Request) {
+	userID, tokenID, ok := h.tokenParams(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.service.RotateToken(r.Context(), tokenID, userID)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to rotate API token")
+		return
+	}
+
+	utils.RespondSuccess(w, token)
+}
+
+// GetCurrentToken returns the token the request was made with
+func (h *Handler) GetCurrentToken(w http.ResponseWriter, r *http.Request) {
+	token, ok := requireToken(w, r)
+	if !ok {
+		return
+	}
+
+	utils.RespondSuccess(w, token)
+}
+
+// PostMessage sends a message as the token's bot user
+func (h *Handler) PostMessage(w http.ResponseWriter, r *http.Request) {
+	token, ok := requireToken(w, r)
+	if !ok {
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return
+	}
+
+	var req PostMessageRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	msg, err := h.service.PostMessage(r.Context(), token, channelID, &req)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to send message")
+		return
+	}
+
+	utils.RespondCreated(w, msg)
+}
+
+// ListInvites returns the invites of the token's community
+func (h *Handler) ListInvites(w http.ResponseWriter, r *http.Request) {
+	token, ok := requireToken(w, r)
+	if !ok {
+		return
+	}
+
+	invites, err := h.service.ListInvites(r.Context(), token)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to get invites")
+		return
+	}
+
+	utils.RespondSuccess(w, invites)
+}
+
+// CreateInvite makes an invite to the token's community
+func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	token, ok := requireToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateInviteRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	invite, err := h.service.CreateInvite(r.Context(), token, &req)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to create invite")
+		return
+	}
+
+	utils.RespondCreated(w, invite)
+}
+
+// DeleteInvite removes an invite of the token's community
+func (h *Handler) DeleteInvite(w http.ResponseWriter, r *http.Request) {
+	token, ok := requireToken(w, r)
+	if !ok {
+		return
+	}
+
+	inviteID, err := uuid.Parse(chi.URLParam(r, "inviteId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid invite ID")
+		return
+	}
+
+	if err := h.service.DeleteInvite(r.Context(), token, inviteID); err != nil {
+		h.respondTokenError(w, err, "Failed to delete invite")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// CreateInteraction records a click on a button or select menu of a bot message
+func (h *Handler) CreateInteraction(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateInteractionRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	interaction, err := h.service.CreateInteraction(r.Context(), userID, &req)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to send interaction")
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusAccepted, utils.SuccessResponse{Data: interaction})
+}
+
+// PollInteractions hands waiting interactions to the bot. Each is returned once.
+func (h *Handler) PollInteractions(w http.ResponseWriter, r *http.Request) {
+	token, ok := requireToken(w, r)
+	if !ok {
+		return
+	}
+
+	limit := utils.GetQueryInt(r, "limit", maxInteractionBatch)
+
+	interactions, err := h.service.PollInteractions(r.Context(), token, limit)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to get interactions")
+		return
+	}
+
+	utils.RespondSuccess(w, interactions)
+}
+
+// RespondInteraction answers an interaction with its interaction token
+func (h *Handler) RespondInteraction(w http.ResponseWriter, r *http.Request) {
+	token, ok := requireToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req InteractionResponseRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	msg, err := h.service.RespondInteraction(r.Context(), token, &req)
+	if err != nil {
+		h.respondTokenError(w, err, "Failed to respond to interaction")
+		return
+	}
+
+	utils.RespondSuccess(w, msg)
+}
+
+func (h *Handler) tokenParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	tokenID, err := uuid.Parse(chi.URLParam(r, "tokenId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid token ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, tokenID, true
+}
+
+func requireToken(w http.ResponseWriter, r *http.Request) (*models.CommunityAPIToken, bool) {
+	token, ok := middleware.GetCommunityToken(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+	return token, true
+}
+
+func (h *Handler) respondTokenError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrTokenNotFound):
+		utils.RespondError(w, http.StatusNotFound, "API token not found")
+	case errors.Is(err, ErrInviteNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Invite not found")
+	case errors.Is(err, ErrBotMessageNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Message not found")
+	case errors.Is(err, messaging.ErrComponentNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Component not found")
+	case errors.Is(err, ErrInvalidInteractionToken):
+		utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_INTERACTION_TOKEN", "Interaction token is invalid, expired or already used")
+	case errors.Is(err, ErrInteractionRateLimited):
+		utils.RespondErrorWithCode(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "You are interacting too quickly")
+	case errors.Is(err, ErrComponentDisabled):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, messaging.ErrInvalidComponents), errors.Is(err, messaging.ErrInvalidValues),
+		errors.Is(err, ErrEmptyResponse):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, message.ErrBlockedByAutoMod):
+		utils.RespondError(w, http.StatusForbidden, "Message blocked by AutoMod")
+	case errors.Is(err, message.ErrRemovedByAutoMod):
+		utils.RespondError(w, http.StatusForbidden, "Message removed by AutoMod")
+	case errors.Is(err, ErrScopeNotGranted):
+		utils.RespondErrorWithCode(w, http.StatusForbidden, "MISSING_SCOPE", "API token is not allowed to do this")
+	case errors.Is(err, ErrInvalidScopes), errors.Is(err, ErrInvalidChannels),
+		errors.Is(err, ErrChannelsRequired), errors.Is(err, ErrTooManyTokens):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
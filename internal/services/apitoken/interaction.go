@@ -0,0 +1,324 @@
+package apitoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Members click buttons and pick select options on bot messages. Each click
+// is validated against the message's components and queued for the bot that
+// sent it, which picks it up from its interaction inbox and answers with the
+// interaction token it was handed.
+
+const (
+	interactionTokenPrefix = "zit_"
+
+	// Members can interact this many times per window across all bots
+	interactionRateLimit  = 10
+	interactionRateWindow = 10 * time.Second
+
+	maxInteractionBatch = 50
+)
+
+// Interaction response types
+const (
+	InteractionResponseMessage = "message" // post a new message in the channel
+	InteractionResponseUpdate  = "update"  // edit the message that was clicked
+)
+
+type CreateInteractionRequest struct {
+	MessageID uuid.UUID `json:"messageId" validate:"required"`
+	CustomID  string    `json:"customId" validate:"required,max=100"`
+	Values    []string  `json:"values" validate:"max=25,dive,max=100"`
+}
+
+type InteractionResponseRequest struct {
+	Token      string                `json:"token" validate:"required"`
+	Type       string                `json:"type" validate:"required,oneof=message update"`
+	Content    string                `json:"content" validate:"max=4000"`
+	Components []models.ComponentRow `json:"components" validate:"omitempty,max=5,dive"`
+}
+
+const interactionColumns = `id, message_id, channel_id, community_id, api_token_id, user_id, custom_id,
+	component_type, selected_values, delivered_at, responded_at, expires_at, created_at`
+
+func scanInteraction(scanner interface{ Scan(dest ...any) error }) (*models.MessageInteraction, error) {
+	i := &models.MessageInteraction{}
+	err := scanner.Scan(
+		&i.ID, &i.MessageID, &i.ChannelID, &i.CommunityID, &i.APITokenID, &i.UserID, &i.CustomID,
+		&i.ComponentType, &i.Values, &i.DeliveredAt, &i.RespondedAt, &i.ExpiresAt, &i.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// botMessage is a message sent by an active API token, with its components
+type botMessage struct {
+	models.Message
+	CommunityID uuid.UUID
+	TokenID     uuid.UUID
+}
+
+// CreateInteraction records a member's click on a component of a bot message
+func (s *Service) CreateInteraction(ctx context.Context, userID uuid.UUID, req *CreateInteractionRequest) (*models.MessageInteraction, error) {
+	count, err := database.IncrementRateLimit(ctx, "interactions:"+userID.String(), interactionRateWindow)
+	if err == nil && count > interactionRateLimit {
+		return nil, ErrInteractionRateLimited
+	}
+
+	msg, err := s.getBotMessage(ctx, req.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if s.channels == nil || !s.channels.CanAccessChannel(ctx, msg.ChannelID, userID) {
+		return nil, ErrBotMessageNotFound
+	}
+
+	component, err := messaging.FindComponent(msg.Components, req.CustomID)
+	if err != nil {
+		return nil, err
+	}
+	if component.Disabled {
+		return nil, ErrComponentDisabled
+	}
+	if req.Values == nil {
+		req.Values = []string{}
+	}
+	if err := messaging.ValidateValues(component, req.Values); err != nil {
+		return nil, err
+	}
+
+	interaction := &models.MessageInteraction{
+		ID:            uuid.New(),
+		MessageID:     msg.ID,
+		ChannelID:     msg.ChannelID,
+		CommunityID:   msg.CommunityID,
+		APITokenID:    msg.TokenID,
+		UserID:        userID,
+		CustomID:      component.CustomID,
+		ComponentType: component.Type,
+		Values:        req.Values,
+		CreatedAt:     time.Now(),
+	}
+	interaction.ExpiresAt = interaction.CreatedAt.Add(models.InteractionTokenExpiry)
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO message_interactions (id, message_id, channel_id, community_id, api_token_id, user_id,
+		                                   custom_id, component_type, selected_values, expires_at, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		interaction.ID, interaction.MessageID, interaction.ChannelID, interaction.CommunityID, interaction.APITokenID,
+		interaction.UserID, interaction.CustomID, interaction.ComponentType, interaction.Values,
+		interaction.ExpiresAt, interaction.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create interaction: %w", err)
+	}
+
+	return interaction, nil
+}
+
+// PollInteractions hands the token's waiting interactions to the bot, oldest
+// first. Each one is handed out once, with the token needed to answer it.
+func (s *Service) PollInteractions(ctx context.Context, token *models.CommunityAPIToken, limit int) ([]*models.MessageInteraction, error) {
+	if limit <= 0 || limit > maxInteractionBatch {
+		limit = maxInteractionBatch
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT `+interactionColumns+` FROM message_interactions
+		 WHERE api_token_id = $1 AND delivered_at IS NULL AND expires_at > NOW()
+		 ORDER BY created_at
+		 LIMIT $2
+		 FOR UPDATE SKIP LOCKED`,
+		token.ID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("poll interactions: %w", err)
+	}
+
+	interactions := make([]*models.MessageInteraction, 0)
+	for rows.Next() {
+		i, err := scanInteraction(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan interaction: %w", err)
+		}
+		interactions = append(interactions, i)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, i := range interactions {
+		raw, err := generateInteractionToken()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx,
+			`UPDATE message_interactions SET delivered_at = $2, token_hash = $3 WHERE id = $1`,
+			i.ID, now, hashToken(raw),
+		); err != nil {
+			return nil, fmt.Errorf("deliver interaction: %w", err)
+		}
+		i.DeliveredAt = &now
+		i.Token = raw
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	for _, i := range interactions {
+		i.User = s.loadPublicUser(ctx, i.UserID)
+	}
+	return interactions, nil
+}
+
+// RespondInteraction answers an interaction, either with a new message in the
+// channel or by editing the message that was clicked. Each interaction can be
+// answered once, before it expires.
+func (s *Service) RespondInteraction(ctx context.Context, token *models.CommunityAPIToken, req *InteractionResponseRequest) (*message.MessageResponse, error) {
+	if !strings.HasPrefix(req.Token, interactionTokenPrefix) {
+		return nil, ErrInvalidInteractionToken
+	}
+	if strings.TrimSpace(req.Content) == "" && len(req.Components) == 0 {
+		return nil, ErrEmptyResponse
+	}
+	if err := messaging.ValidateComponents(req.Components); err != nil {
+		return nil, err
+	}
+
+	interaction, err := scanInteraction(s.db.QueryRow(ctx,
+		`UPDATE message_interactions SET responded_at = NOW()
+		 WHERE token_hash = $1 AND api_token_id = $2 AND responded_at IS NULL AND expires_at > NOW()
+		 RETURNING `+interactionColumns,
+		hashToken(req.Token), token.ID,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidInteractionToken
+		}
+		return nil, fmt.Errorf("respond to interaction: %w", err)
+	}
+
+	if req.Type == InteractionResponseUpdate {
+		return s.updateBotMessage(ctx, token, interaction.MessageID, req.Content, req.Components)
+	}
+	return s.PostMessage(ctx, token, interaction.ChannelID, &PostMessageRequest{
+		Content:    req.Content,
+		Components: req.Components,
+	})
+}
+
+// PruneInteractions deletes expired interactions
+func (s *Service) PruneInteractions(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM message_interactions WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// updateBotMessage replaces the content and components of a message the
+// token's bot sent. Empty content keeps the current text.
+func (s *Service) updateBotMessage(ctx context.Context, token *models.CommunityAPIToken, messageID uuid.UUID, content string, components []models.ComponentRow) (*message.MessageResponse, error) {
+	msg, err := s.getBotMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.TokenID != token.ID {
+		return nil, ErrBotMessageNotFound
+	}
+
+	// Components left out of the response are removed
+	if components == nil {
+		components = []models.ComponentRow{}
+	}
+	resp, err := s.messages.UpdateBotMessage(ctx, &message.BotMessageEdit{
+		MessageID:  messageID,
+		AuthorID:   token.BotUserID,
+		Content:    content,
+		Components: components,
+	})
+	if err != nil {
+		if errors.Is(err, message.ErrMessageNotFound) {
+			return nil, ErrBotMessageNotFound
+		}
+		return nil, fmt.Errorf("update api token message: %w", err)
+	}
+	return resp, nil
+}
+
+// getBotMessage loads a message along with the active token that sent it.
+// Messages from revoked or expired tokens are treated as missing since nobody
+// would answer their interactions.
+func (s *Service) getBotMessage(ctx context.Context, messageID uuid.UUID) (*botMessage, error) {
+	msg := &botMessage{}
+	var encContent, linkPreviewRaw, componentsRaw []byte
+	err := s.db.QueryRow(ctx,
+		`SELECT m.id, m.channel_id, c.community_id, t.id, m.author_id, m.encrypted_content, m.reply_to_id,
+		        m.link_previews, m.components, m.is_pinned, m.is_edited, m.reactions, m.created_at, m.updated_at
+		 FROM messages m
+		 JOIN channels c ON c.id = m.channel_id
+		 JOIN community_api_tokens t ON t.bot_user_id = m.author_id
+		      AND t.revoked_at IS NULL AND (t.expires_at IS NULL OR t.expires_at > NOW())
+		 WHERE m.id = $1 AND m.deleted_at IS NULL`,
+		messageID,
+	).Scan(
+		&msg.ID, &msg.ChannelID, &msg.CommunityID, &msg.TokenID, &msg.AuthorID, &encContent, &msg.ReplyToID,
+		&linkPreviewRaw, &componentsRaw, &msg.IsPinned, &msg.IsEdited, &msg.Reactions, &msg.CreatedAt, &msg.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBotMessageNotFound
+		}
+		return nil, fmt.Errorf("get api token message: %w", err)
+	}
+
+	ref := messaging.ContentRef{Kind: messaging.ContentKindChannel, ID: msg.ID, ContainerID: msg.ChannelID}
+	content, _ := messaging.DecryptOrPlaceholder(s.db, s.cipher, ref, encContent, nil)
+	msg.Content = &content
+	msg.LinkPreviews = s.camo.LinkPreviews(messaging.DecodeLinkPreviews(linkPreviewRaw))
+	msg.Components = messaging.DecodeComponents(componentsRaw)
+	return msg, nil
+}
+
+func (s *Service) loadPublicUser(ctx context.Context, userID uuid.UUID) *models.PublicUser {
+	var u models.PublicUser
+	err := s.db.QueryRow(ctx,
+		`SELECT id, username, display_name, avatar_url, bio, status, custom_status, created_at FROM users WHERE id = $1`,
+		userID,
+	).Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Bio, &u.Status, &u.CustomStatus, &u.CreatedAt)
+	if err != nil {
+		return nil
+	}
+	return &u
+}
+
+func generateInteractionToken() (string, error) {
+	raw, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	return interactionTokenPrefix + strings.TrimPrefix(raw, tokenPrefix), nil
+}
@@ -0,0 +1,93 @@
+package apitoken
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+	"github.com/zentra/server/internal/services/message"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	tokenID := []openapi.Param{openapi.ID("tokenId")}
+
+	openapi.Describe((*Handler).ListTokens, openapi.Operation{
+		Summary:  "List a community's API tokens",
+		Path:     communityID,
+		Response: []*models.CommunityAPIToken{},
+	})
+	openapi.Describe((*Handler).CreateToken, openapi.Operation{
+		Summary:     "Create an API token",
+		Description: "The secret is only returned here and when the token is rotated.",
+		Path:        communityID,
+		Body:        CreateTokenRequest{},
+		Response:    TokenWithSecret{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).UpdateToken, openapi.Operation{
+		Summary:  "Update an API token's name, scopes or channels",
+		Path:     tokenID,
+		Body:     UpdateTokenRequest{},
+		Response: models.CommunityAPIToken{},
+	})
+	openapi.Describe((*Handler).RevokeToken, openapi.Operation{
+		Summary: "Revoke an API token",
+		Path:    tokenID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RotateToken, openapi.Operation{
+		Summary:  "Replace an API token's secret",
+		Path:     tokenID,
+		Response: TokenWithSecret{},
+	})
+
+	openapi.Describe((*Handler).GetCurrentToken, openapi.Operation{
+		Summary:  "Get the API token the request is made with",
+		Response: models.CommunityAPIToken{},
+	})
+	openapi.Describe((*Handler).PostMessage, openapi.Operation{
+		Summary:  "Post a message as the token's bot user",
+		Path:     []openapi.Param{openapi.ID("channelId")},
+		Body:     PostMessageRequest{},
+		Response: message.MessageResponse{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).ListInvites, openapi.Operation{
+		Summary:  "List the token's community's invites",
+		Response: []*models.CommunityInvite{},
+	})
+	openapi.Describe((*Handler).CreateInvite, openapi.Operation{
+		Summary:      "Create an invite to the token's community",
+		Body:         CreateInviteRequest{},
+		BodyOptional: true,
+		Response:     models.CommunityInvite{},
+		Status:       http.StatusCreated,
+	})
+	openapi.Describe((*Handler).DeleteInvite, openapi.Operation{
+		Summary: "Delete an invite to the token's community",
+		Path:    []openapi.Param{openapi.ID("inviteId")},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).PollInteractions, openapi.Operation{
+		Summary:     "Claim pending interactions",
+		Description: "Returns interactions with the token's messages that no poll has claimed yet.",
+		Query: []openapi.Param{
+			{Name: "limit", Type: "integer", Description: "At most this many, 50 by default"},
+		},
+		Response: []*models.MessageInteraction{},
+	})
+	openapi.Describe((*Handler).RespondInteraction, openapi.Operation{
+		Summary:  "Answer an interaction",
+		Body:     InteractionResponseRequest{},
+		Response: message.MessageResponse{},
+	})
+
+	openapi.Describe((*Handler).CreateInteraction, openapi.Operation{
+		Summary:     "Click a button or pick from a select menu",
+		Description: "The interaction is queued for the message's token to poll.",
+		Body:        CreateInteractionRequest{},
+		Response:    models.MessageInteraction{},
+		Status:      http.StatusAccepted,
+	})
+}
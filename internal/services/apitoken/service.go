@@ -0,0 +1,458 @@
+package apitoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	MaxTokensPerCommunity = 25
+
+	// Tokens are shown once; the prefix makes leaked ones easy to spot
+	tokenPrefix = "zct_"
+
+	// last_used_at is only written this often per token
+	lastUsedResolution = time.Minute
+)
+
+var (
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrTokenNotFound     = errors.New("api token not found")
+	ErrInvalidToken      = errors.New("invalid api token")
+	ErrInvalidScopes     = errors.New("unknown api token scope")
+	ErrInvalidChannels   = errors.New("channels must belong to the community")
+	ErrChannelsRequired  = errors.New("sending messages requires at least one channel")
+	ErrTooManyTokens     = errors.New("community has too many api tokens")
+	ErrScopeNotGranted   = errors.New("api token does not have the required scope")
+	ErrInviteNotFound    = errors.New("invite not found")
+
+	ErrBotMessageNotFound      = errors.New("bot message not found")
+	ErrComponentDisabled       = errors.New("component is disabled")
+	ErrInvalidInteractionToken = errors.New("invalid or expired interaction token")
+	ErrEmptyResponse           = errors.New("response needs content or components")
+	ErrInteractionRateLimited  = errors.New("interacting too quickly")
+)
+
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+	LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte)
+}
+
+// EventDispatcher forwards automated messages to outgoing event hooks
+type EventDispatcher interface {
+	DispatchForChannel(ctx context.Context, channelID uuid.UUID, eventType string, data any)
+}
+
+// MessagePoster posts and edits messages as a token's bot user
+type MessagePoster interface {
+	CreateBotMessage(ctx context.Context, m *message.BotMessage) (*message.MessageResponse, error)
+	UpdateBotMessage(ctx context.Context, e *message.BotMessageEdit) (*message.MessageResponse, error)
+}
+
+// ChannelAccessChecker decides whether a member can see a bot message
+type ChannelAccessChecker interface {
+	CanAccessChannel(ctx context.Context, channelID, userID uuid.UUID) bool
+}
+
+type Service struct {
+	db               *pgxpool.Pool
+	communityService CommunityServiceInterface
+	events           EventDispatcher
+	channels         ChannelAccessChecker
+	messages         MessagePoster
+	cipher           messaging.ContentCipher
+	camo             *messaging.Camo
+}
+
+func NewService(db *pgxpool.Pool, communityService CommunityServiceInterface, events EventDispatcher, keys *encryption.Keyring) *Service {
+	return &Service{
+		db:               db,
+		communityService: communityService,
+		events:           events,
+		cipher:           messaging.NewChannelCipher(keys),
+	}
+}
+
+type CreateTokenRequest struct {
+	Name       string      `json:"name" validate:"required,min=1,max=80"`
+	Scopes     int64       `json:"scopes" validate:"required,gt=0"`
+	ChannelIDs []uuid.UUID `json:"channelIds" validate:"max=50"`
+	// ExpiresIn is the token lifetime in seconds; omit it for a token that doesn't expire
+	ExpiresIn *int64 `json:"expiresIn" validate:"omitempty,min=60"`
+}
+
+type UpdateTokenRequest struct {
+	Name       *string      `json:"name" validate:"omitempty,min=1,max=80"`
+	Scopes     *int64       `json:"scopes" validate:"omitempty,gt=0"`
+	ChannelIDs *[]uuid.UUID `json:"channelIds" validate:"omitempty,max=50"`
+}
+
+// TokenWithSecret is returned when a token is created or rotated. The secret
+// is not shown again.
+type TokenWithSecret struct {
+	*models.CommunityAPIToken
+	Token string `json:"token"`
+}
+
+const tokenColumns = `id, community_id, bot_user_id, name, token_preview, scopes, channel_ids,
+	expires_at, last_used_at, created_by, created_at, updated_at`
+
+func scanToken(scanner interface{ Scan(dest ...any) error }) (*models.CommunityAPIToken, error) {
+	t := &models.CommunityAPIToken{}
+	err := scanner.Scan(
+		&t.ID, &t.CommunityID, &t.BotUserID, &t.Name, &t.TokenPreview, &t.Scopes, &t.ChannelIDs,
+		&t.ExpiresAt, &t.LastUsedAt, &t.CreatedBy, &t.CreatedAt, &t.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetChannelService enables message component interactions
+func (s *Service) SetChannelService(cs ChannelAccessChecker) {
+	s.channels = cs
+}
+
+// SetMessageService posts token messages through the message service so
+// they get AutoMod, events and notifications like any other message
+func (s *Service) SetMessageService(ms MessagePoster) {
+	s.messages = ms
+}
+
+// SetCamo rewrites the preview images of a bot message returned after an
+// interaction response to image proxy URLs.
+func (s *Service) SetCamo(c *messaging.Camo) {
+	s.camo = c
+}
+
+// ListTokens returns the active tokens of a community
+func (s *Service) ListTokens(ctx context.Context, communityID, userID uuid.UUID) ([]*models.CommunityAPIToken, error) {
+	if err := s.requirePermission(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+tokenColumns+` FROM community_api_tokens
+		 WHERE community_id = $1 AND revoked_at IS NULL
+		 ORDER BY created_at ASC`,
+		communityID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list api tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := make([]*models.CommunityAPIToken, 0)
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan api token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// CreateToken issues a token along with the bot user its actions are
+// attributed to
+func (s *Service) CreateToken(ctx context.Context, communityID, userID uuid.UUID, req *CreateTokenRequest) (*TokenWithSecret, error) {
+	if err := s.requirePermission(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	channelIDs, err := s.validateGrant(ctx, communityID, req.Scopes, req.ChannelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = s.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM community_api_tokens WHERE community_id = $1 AND revoked_at IS NULL`,
+		communityID,
+	).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("count api tokens: %w", err)
+	}
+	if count >= MaxTokensPerCommunity {
+		return nil, ErrTooManyTokens
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != nil {
+		expires := time.Now().Add(time.Duration(*req.ExpiresIn) * time.Second)
+		expiresAt = &expires
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tokenID := uuid.New()
+	botUserID, err := messaging.CreateBotUser(ctx, tx, messaging.BotUserAPIToken, tokenID, name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := scanToken(tx.QueryRow(ctx,
+		`INSERT INTO community_api_tokens (id, community_id, bot_user_id, name, token_hash, token_preview,
+		                                   scopes, channel_ids, expires_at, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		 RETURNING `+tokenColumns,
+		tokenID, communityID, botUserID, name, hashToken(secret), tokenPreview(secret),
+		req.Scopes, channelIDs, expiresAt, userID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create api token: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.logAudit(ctx, token, userID, models.AuditActionAPITokenCreate)
+	return &TokenWithSecret{CommunityAPIToken: token, Token: secret}, nil
+}
+
+// UpdateToken changes the name, scopes or channels of a token
+func (s *Service) UpdateToken(ctx context.Context, tokenID, userID uuid.UUID, req *UpdateTokenRequest) (*models.CommunityAPIToken, error) {
+	existing, err := s.getManagedToken(ctx, tokenID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var name *string
+	if req.Name != nil {
+		trimmed := strings.TrimSpace(*req.Name)
+		name = &trimmed
+	}
+
+	scopes := existing.Scopes
+	if req.Scopes != nil {
+		scopes = *req.Scopes
+	}
+	channelIDs := existing.ChannelIDs
+	if req.ChannelIDs != nil {
+		channelIDs = *req.ChannelIDs
+	}
+	channelIDs, err = s.validateGrant(ctx, existing.CommunityID, scopes, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := scanToken(s.db.QueryRow(ctx,
+		`UPDATE community_api_tokens SET
+			name = COALESCE($2, name),
+			scopes = $3,
+			channel_ids = $4
+		 WHERE id = $1 AND revoked_at IS NULL
+		 RETURNING `+tokenColumns,
+		tokenID, name, scopes, channelIDs,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("update api token: %w", err)
+	}
+
+	// Keep the bot user's display name in step so messages show the new name
+	if name != nil {
+		if _, err := s.db.Exec(ctx, `UPDATE users SET display_name = $2 WHERE id = $1`, token.BotUserID, *name); err != nil {
+			return nil, fmt.Errorf("rename api token bot user: %w", err)
+		}
+	}
+
+	s.logAudit(ctx, token, userID, models.AuditActionAPITokenUpdate)
+	return token, nil
+}
+
+// RotateToken replaces the secret of a token; the old one stops working at once
+func (s *Service) RotateToken(ctx context.Context, tokenID, userID uuid.UUID) (*TokenWithSecret, error) {
+	if _, err := s.getManagedToken(ctx, tokenID, userID); err != nil {
+		return nil, err
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := scanToken(s.db.QueryRow(ctx,
+		`UPDATE community_api_tokens SET token_hash = $2, token_preview = $3
+		 WHERE id = $1 AND revoked_at IS NULL
+		 RETURNING `+tokenColumns,
+		tokenID, hashToken(secret), tokenPreview(secret),
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("rotate api token: %w", err)
+	}
+
+	details, _ := json.Marshal(map[string]any{"name": token.Name, "secretRotated": true})
+	s.communityService.LogAudit(ctx, &token.CommunityID, userID, models.AuditActionAPITokenUpdate, "api_token", &token.ID, details)
+	return &TokenWithSecret{CommunityAPIToken: token, Token: secret}, nil
+}
+
+// RevokeToken disables a token for good. The row and its bot user are kept so
+// messages and audit entries made with it still resolve.
+func (s *Service) RevokeToken(ctx context.Context, tokenID, userID uuid.UUID) error {
+	token, err := s.getManagedToken(ctx, tokenID, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE community_api_tokens SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`,
+		tokenID,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke api token: %w", err)
+	}
+
+	s.logAudit(ctx, token, userID, models.AuditActionAPITokenRevoke)
+	return nil
+}
+
+// AuthenticateToken resolves a raw token sent by automation. Revoked and
+// expired tokens are rejected.
+func (s *Service) AuthenticateToken(ctx context.Context, raw string) (*models.CommunityAPIToken, error) {
+	if !strings.HasPrefix(raw, tokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+
+	token, err := scanToken(s.db.QueryRow(ctx,
+		`SELECT `+tokenColumns+` FROM community_api_tokens
+		 WHERE token_hash = $1 AND revoked_at IS NULL
+		   AND (expires_at IS NULL OR expires_at > NOW())`,
+		hashToken(raw),
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("authenticate api token: %w", err)
+	}
+
+	_, _ = s.db.Exec(ctx,
+		`UPDATE community_api_tokens SET last_used_at = NOW()
+		 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - make_interval(secs => $2))`,
+		token.ID, lastUsedResolution.Seconds(),
+	)
+	return token, nil
+}
+
+func (s *Service) getManagedToken(ctx context.Context, tokenID, userID uuid.UUID) (*models.CommunityAPIToken, error) {
+	token, err := scanToken(s.db.QueryRow(ctx,
+		`SELECT `+tokenColumns+` FROM community_api_tokens WHERE id = $1 AND revoked_at IS NULL`,
+		tokenID,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTokenNotFound
+		}
+		return nil, fmt.Errorf("get api token: %w", err)
+	}
+	if err := s.requirePermission(ctx, token.CommunityID, userID); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// validateGrant checks scopes and returns the de-duplicated channel list
+func (s *Service) validateGrant(ctx context.Context, communityID uuid.UUID, scopes int64, channelIDs []uuid.UUID) ([]uuid.UUID, error) {
+	if scopes&^models.APITokenScopeAll != 0 {
+		return nil, ErrInvalidScopes
+	}
+
+	channelIDs = slices.Clone(channelIDs)
+	slices.SortFunc(channelIDs, func(a, b uuid.UUID) int { return strings.Compare(a.String(), b.String()) })
+	channelIDs = slices.Compact(channelIDs)
+	if channelIDs == nil {
+		channelIDs = []uuid.UUID{}
+	}
+
+	if scopes&models.APITokenScopeSendMessages != 0 && len(channelIDs) == 0 {
+		return nil, ErrChannelsRequired
+	}
+	if len(channelIDs) == 0 {
+		return channelIDs, nil
+	}
+
+	var count int
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM channels WHERE community_id = $1 AND id = ANY($2)`,
+		communityID, channelIDs,
+	).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("check api token channels: %w", err)
+	}
+	if count != len(channelIDs) {
+		return nil, ErrInvalidChannels
+	}
+	return channelIDs, nil
+}
+
+func (s *Service) requirePermission(ctx context.Context, communityID, userID uuid.UUID) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(perms, models.PermissionManageCommunity) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+func (s *Service) logAudit(ctx context.Context, token *models.CommunityAPIToken, actorID uuid.UUID, action string) {
+	details, _ := json.Marshal(map[string]any{
+		"name":       token.Name,
+		"scopes":     token.Scopes,
+		"channelIds": token.ChannelIDs,
+		"expiresAt":  token.ExpiresAt,
+	})
+	s.communityService.LogAudit(ctx, &token.CommunityID, actorID, action, "api_token", &token.ID, details)
+}
+
+func generateToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func tokenPreview(token string) string {
+	if len(token) <= 12 {
+		return token
+	}
+	return token[:12]
+}
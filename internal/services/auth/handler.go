@@ -1,9 +1,7 @@
 package auth
 
 import (
-	"net"
 	"net/http"
-	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/zentra/server/internal/middleware"
@@ -49,13 +47,7 @@ func (h *Handler) Routes() chi.Router {
 
 func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	var req RegisterRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -66,7 +58,7 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	resp, err := h.service.Register(r.Context(), &req, clientIPFromRequest(r))
+	resp, err := h.service.Register(r.Context(), &req, middleware.ClientIP(r))
 	if err != nil {
 		switch err {
 		case ErrUserExists:
@@ -88,13 +80,7 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -125,13 +111,7 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
 	var req VerifyEmailRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -152,13 +132,7 @@ func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
 	var req ResendVerificationRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -179,8 +153,7 @@ func (h *Handler) ResendVerification(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 	var req RefreshRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -200,13 +173,7 @@ func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) PortableAuth(w http.ResponseWriter, r *http.Request) {
 	var req PortableAuthRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -237,8 +204,7 @@ func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req RefreshRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -272,17 +238,8 @@ func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		CurrentPassword string `json:"currentPassword" validate:"required"`
-		NewPassword     string `json:"newPassword" validate:"required,strongpassword"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	var req ChangePasswordRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -322,11 +279,8 @@ func (h *Handler) Verify2FA(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Code string `json:"code" validate:"required,len=6"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req Verify2FARequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -350,12 +304,8 @@ func (h *Handler) Disable2FA(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Password string `json:"password" validate:"required"`
-		Code     string `json:"code" validate:"required,len=6"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req Disable2FARequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -373,28 +323,3 @@ func (h *Handler) Disable2FA(w http.ResponseWriter, r *http.Request) {
 
 	utils.RespondJSON(w, http.StatusOK, map[string]string{"message": "2FA disabled successfully"})
 }
-
-func clientIPFromRequest(r *http.Request) string {
-	forwardedFor := strings.TrimSpace(r.Header.Get("X-Forwarded-For"))
-	if forwardedFor != "" {
-		parts := strings.Split(forwardedFor, ",")
-		if len(parts) > 0 {
-			ip := strings.TrimSpace(parts[0])
-			if ip != "" {
-				return ip
-			}
-		}
-	}
-
-	realIP := strings.TrimSpace(r.Header.Get("X-Real-IP"))
-	if realIP != "" {
-		return realIP
-	}
-
-	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
-	if err == nil {
-		return host
-	}
-
-	return strings.TrimSpace(r.RemoteAddr)
-}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	message := map[string]string{}
+
+	openapi.Describe((*Handler).Register, openapi.Operation{
+		Summary:  "Create an account",
+		Body:     RegisterRequest{},
+		Response: RegisterResponse{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).VerifyEmail, openapi.Operation{
+		Summary:  "Verify an email address with the emailed token",
+		Body:     VerifyEmailRequest{},
+		Response: message,
+	})
+	openapi.Describe((*Handler).ResendVerification, openapi.Operation{
+		Summary:  "Send the verification email again",
+		Body:     ResendVerificationRequest{},
+		Response: message,
+	})
+	openapi.Describe((*Handler).Login, openapi.Operation{
+		Summary:     "Log in",
+		Description: "Accounts with 2FA need totpCode as well.",
+		Body:        LoginRequest{},
+		Response:    AuthResponse{},
+	})
+	openapi.Describe((*Handler).PortableAuth, openapi.Operation{
+		Summary:  "Log in or sign up with a portable profile",
+		Body:     PortableAuthRequest{},
+		Response: AuthResponse{},
+	})
+	openapi.Describe((*Handler).RefreshToken, openapi.Operation{
+		Summary:  "Exchange a refresh token for new tokens",
+		Body:     RefreshRequest{},
+		Response: AuthResponse{},
+	})
+
+	openapi.Describe((*Handler).Logout, openapi.Operation{
+		Summary: "Revoke a refresh token",
+		Body:    RefreshRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).LogoutAll, openapi.Operation{
+		Summary: "Revoke every refresh token of the current user",
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).ChangePassword, openapi.Operation{
+		Summary:  "Change the current user's password",
+		Body:     ChangePasswordRequest{},
+		Response: message,
+		Shape:    openapi.Raw,
+	})
+	openapi.Describe((*Handler).Enable2FA, openapi.Operation{
+		Summary:     "Start enabling 2FA",
+		Description: "Returns the TOTP secret; 2FA is on once a code is verified.",
+		Response:    Enable2FAResponse{},
+	})
+	openapi.Describe((*Handler).Verify2FA, openapi.Operation{
+		Summary:  "Finish enabling 2FA with a code",
+		Body:     Verify2FARequest{},
+		Response: message,
+		Shape:    openapi.Raw,
+	})
+	openapi.Describe((*Handler).Disable2FA, openapi.Operation{
+		Summary:  "Disable 2FA",
+		Body:     Disable2FARequest{},
+		Response: message,
+		Shape:    openapi.Raw,
+	})
+}
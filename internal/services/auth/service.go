@@ -12,10 +12,12 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/mailtemplate"
 	"github.com/zentra/server/pkg/auth"
 )
 
@@ -34,6 +36,8 @@ var (
 	ErrInvalidVerifyToken = errors.New("invalid email verification token")
 	ErrEmailNotConfigured = errors.New("email delivery is not configured")
 	ErrEmailSendFailed    = errors.New("failed to send verification email")
+	ErrIdentityInUse      = errors.New("portable identity is linked to another account")
+	ErrIdentityMismatch   = errors.New("account is linked to a different portable identity")
 )
 
 var portableUsernameRegex = regexp.MustCompile(`[^a-z0-9_]`)
@@ -46,6 +50,7 @@ type Service struct {
 	refreshTTL    time.Duration
 	captchaConfig CaptchaConfig
 	emailConfig   EmailConfig
+	templates     *mailtemplate.Service
 	httpClient    *http.Client
 }
 
@@ -57,6 +62,7 @@ func NewService(
 	refreshTTL time.Duration,
 	captchaConfig CaptchaConfig,
 	emailConfig EmailConfig,
+	templates *mailtemplate.Service,
 ) *Service {
 	return &Service{
 		db:            db,
@@ -66,6 +72,7 @@ func NewService(
 		refreshTTL:    refreshTTL,
 		captchaConfig: captchaConfig,
 		emailConfig:   emailConfig,
+		templates:     templates,
 		httpClient:    &http.Client{Timeout: 10 * time.Second},
 	}
 }
@@ -146,6 +153,27 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refreshToken" validate:"required"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,strongpassword"`
+}
+
+type Verify2FARequest struct {
+	Code string `json:"code" validate:"required,len=6"`
+}
+
+type Disable2FARequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code" validate:"required,len=6"`
+}
+
+// querier is what the portable profile helpers need, so they can run on the
+// pool or inside a caller's transaction
+type querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
 type portableProfileRecord struct {
 	IdentityID     string
 	ProfileVersion time.Time
@@ -319,7 +347,7 @@ func (s *Service) PortableAuth(ctx context.Context, req *PortableAuthRequest) (*
 		return nil, err
 	}
 
-	user, err := s.findUserByPortableIdentity(ctx, clientProfile.IdentityID)
+	user, err := s.findUserByPortableIdentity(ctx, s.db, clientProfile.IdentityID)
 	if err != nil {
 		return nil, err
 	}
@@ -604,7 +632,7 @@ func parsePortableProfile(req *PortableProfileRequest) (*portableProfileRecord,
 }
 
 func (s *Service) reconcilePortableProfile(ctx context.Context, user *models.User, clientReq *PortableProfileRequest) (*PortableProfileSync, error) {
-	serverProfile, err := s.getPortableProfileForUser(ctx, user.ID)
+	serverProfile, err := s.getPortableProfileForUser(ctx, s.db, user.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -641,7 +669,7 @@ func (s *Service) reconcilePortableProfile(ctx context.Context, user *models.Use
 
 	shouldApplyClient := serverProfile == nil || clientProfile.ProfileVersion.After(serverProfile.ProfileVersion)
 	if shouldApplyClient {
-		if err := s.applyPortableProfileToUser(ctx, user.ID, clientProfile); err != nil {
+		if err := s.applyPortableProfileToUser(ctx, s.db, user.ID, clientProfile); err != nil {
 			return nil, err
 		}
 
@@ -652,7 +680,7 @@ func (s *Service) reconcilePortableProfile(ctx context.Context, user *models.Use
 		user.Bio = clientProfile.Bio
 		user.CustomStatus = clientProfile.CustomStatus
 
-		if err := s.savePortableProfileForUser(ctx, user.ID, clientProfile); err != nil {
+		if err := s.savePortableProfileForUser(ctx, s.db, user.ID, clientProfile); err != nil {
 			return nil, err
 		}
 
@@ -672,9 +700,9 @@ func (s *Service) reconcilePortableProfile(ctx context.Context, user *models.Use
 	}, nil
 }
 
-func (s *Service) getPortableProfileForUser(ctx context.Context, userID uuid.UUID) (*portableProfileRecord, error) {
+func (s *Service) getPortableProfileForUser(ctx context.Context, q querier, userID uuid.UUID) (*portableProfileRecord, error) {
 	var raw json.RawMessage
-	err := s.db.QueryRow(ctx,
+	err := q.QueryRow(ctx,
 		`SELECT settings_json FROM user_settings WHERE user_id = $1`,
 		userID,
 	).Scan(&raw)
@@ -729,9 +757,9 @@ func (s *Service) getPortableProfileForUser(ctx context.Context, userID uuid.UUI
 	return profile, nil
 }
 
-func (s *Service) savePortableProfileForUser(ctx context.Context, userID uuid.UUID, profile *portableProfileRecord) error {
+func (s *Service) savePortableProfileForUser(ctx context.Context, q querier, userID uuid.UUID, profile *portableProfileRecord) error {
 	var raw json.RawMessage
-	err := s.db.QueryRow(ctx,
+	err := q.QueryRow(ctx,
 		`SELECT settings_json FROM user_settings WHERE user_id = $1`,
 		userID,
 	).Scan(&raw)
@@ -773,15 +801,15 @@ func (s *Service) savePortableProfileForUser(ctx context.Context, userID uuid.UU
 		return err
 	}
 
-	_, err = s.db.Exec(ctx,
+	_, err = q.Exec(ctx,
 		`UPDATE user_settings SET settings_json = $2::jsonb, updated_at = NOW() WHERE user_id = $1`,
 		userID, marshaled,
 	)
 	return err
 }
 
-func (s *Service) applyPortableProfileToUser(ctx context.Context, userID uuid.UUID, profile *portableProfileRecord) error {
-	_, err := s.db.Exec(ctx,
+func (s *Service) applyPortableProfileToUser(ctx context.Context, q querier, userID uuid.UUID, profile *portableProfileRecord) error {
+	_, err := q.Exec(ctx,
 		`UPDATE users SET display_name = $2, avatar_url = $3, bio = $4, custom_status = $5, updated_at = NOW() WHERE id = $1`,
 		userID, profile.DisplayName, profile.AvatarURL, profile.Bio, profile.CustomStatus,
 	)
@@ -806,9 +834,96 @@ func toPortableEnvelope(profile *portableProfileRecord, source string) *Portable
 	}
 }
 
-func (s *Service) findUserByPortableIdentity(ctx context.Context, identityID string) (*models.User, error) {
+// PortableIdentity returns the user's portable profile, minting an identity
+// from their current profile the first time so the account can move to
+// another instance
+func (s *Service) PortableIdentity(ctx context.Context, userID uuid.UUID) (*PortableProfileEnvelope, error) {
+	profile, err := s.getPortableProfileForUser(ctx, s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if profile != nil {
+		return toPortableEnvelope(profile, "instance"), nil
+	}
+
+	profile = &portableProfileRecord{
+		IdentityID:     uuid.NewString(),
+		ProfileVersion: time.Now().UTC().Truncate(time.Second),
+	}
+	err = s.db.QueryRow(ctx,
+		`SELECT username, display_name, avatar_url, bio, custom_status
+		FROM users WHERE id = $1 AND deleted_at IS NULL`,
+		userID,
+	).Scan(&profile.Username, &profile.DisplayName, &profile.AvatarURL, &profile.Bio, &profile.CustomStatus)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.ensureSettingsRow(ctx, s.db, userID); err != nil {
+		return nil, err
+	}
+	if err := s.savePortableProfileForUser(ctx, s.db, userID, profile); err != nil {
+		return nil, err
+	}
+	return toPortableEnvelope(profile, "instance"), nil
+}
+
+// LinkPortableIdentity adopts a portable profile carried over from another
+// instance, as part of tx. The identity can belong to only one account here,
+// and an account keeps the identity it already has.
+func (s *Service) LinkPortableIdentity(ctx context.Context, tx pgx.Tx, userID uuid.UUID, envelope *PortableProfileEnvelope) error {
+	// Two imports of the same identity must not both see it unclaimed
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('portable:' || $1))`, envelope.IdentityID); err != nil {
+		return err
+	}
+	existing, err := s.findUserByPortableIdentity(ctx, tx, envelope.IdentityID)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != userID {
+		return ErrIdentityInUse
+	}
+
+	current, err := s.getPortableProfileForUser(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	if current != nil && current.IdentityID != envelope.IdentityID {
+		return ErrIdentityMismatch
+	}
+
+	profile := &portableProfileRecord{
+		IdentityID:     envelope.IdentityID,
+		ProfileVersion: envelope.ProfileVersion,
+		Username:       envelope.Username,
+		DisplayName:    envelope.DisplayName,
+		AvatarURL:      envelope.AvatarURL,
+		Bio:            envelope.Bio,
+		CustomStatus:   envelope.CustomStatus,
+	}
+	if err := s.ensureSettingsRow(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := s.applyPortableProfileToUser(ctx, tx, userID, profile); err != nil {
+		return err
+	}
+	return s.savePortableProfileForUser(ctx, tx, userID, profile)
+}
+
+func (s *Service) ensureSettingsRow(ctx context.Context, q querier, userID uuid.UUID) error {
+	_, err := q.Exec(ctx,
+		`INSERT INTO user_settings (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING`,
+		userID,
+	)
+	return err
+}
+
+func (s *Service) findUserByPortableIdentity(ctx context.Context, q querier, identityID string) (*models.User, error) {
 	user := &models.User{}
-	err := s.db.QueryRow(ctx,
+	err := q.QueryRow(ctx,
 		`SELECT u.id, u.username, u.email, u.password_hash, u.display_name, u.avatar_url, u.bio,
 		u.status, u.custom_status, u.email_verified, u.two_factor_enabled, u.two_factor_secret,
 		u.created_at, u.updated_at, u.last_seen_at
@@ -880,7 +995,7 @@ func (s *Service) createPortableUser(ctx context.Context, profile *portableProfi
 		return nil, err
 	}
 
-	if err := s.savePortableProfileForUser(ctx, user.ID, profile); err != nil {
+	if err := s.savePortableProfileForUser(ctx, s.db, user.ID, profile); err != nil {
 		return nil, err
 	}
 
@@ -8,8 +8,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/mail"
-	"net/smtp"
 	"net/url"
 	"strings"
 	"time"
@@ -18,6 +16,7 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/redis/go-redis/v9"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/mailtemplate"
 )
 
 const (
@@ -125,7 +124,7 @@ func (s *Service) sendEmailVerification(ctx context.Context, user *models.User)
 		return err
 	}
 
-	if err := s.deliverVerificationEmail(user.Email, user.Username, verificationURL); err != nil {
+	if err := s.deliverVerificationEmail(ctx, user, verificationURL); err != nil {
 		return err
 	}
 
@@ -255,47 +254,39 @@ func (s *Service) buildVerificationURL(token, email string) (string, error) {
 	return parsedURL.String(), nil
 }
 
-func (s *Service) deliverVerificationEmail(toEmail, username, verificationURL string) error {
-	fromAddress := strings.TrimSpace(s.emailConfig.FromAddress)
-	parsedFrom, err := mail.ParseAddress(fromAddress)
-	if err != nil {
-		return ErrEmailNotConfigured
-	}
-
-	parsedTo, err := mail.ParseAddress(strings.TrimSpace(toEmail))
-	if err != nil {
-		return ErrEmailSendFailed
-	}
-
-	host := strings.TrimSpace(s.emailConfig.SMTPHost)
-	port := s.emailConfig.SMTPPort
-	if port <= 0 {
-		port = 587
-	}
-
-	subject := "Verify your email for Zentra"
+func (s *Service) deliverVerificationEmail(ctx context.Context, user *models.User, verificationURL string) error {
 	expiry := s.emailConfig.VerificationTokenTTL
 	if expiry <= 0 {
 		expiry = 24 * time.Hour
 	}
 
-	greeting := username
+	greeting := user.Username
 	if strings.TrimSpace(greeting) == "" {
 		greeting = "there"
 	}
 
-	plainBody := fmt.Sprintf("Hi %s,\r\n\r\nWelcome to Zentra. Verify the email address on this account by opening this link:\r\n%s\r\n\r\nThis link expires in %s.\r\n\r\nIf this was not requested, this message can be ignored.", greeting, verificationURL, expiry.String())
-	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s", fromAddress, parsedTo.Address, subject, plainBody)
-
-	var smtpAuth smtp.Auth
-	if strings.TrimSpace(s.emailConfig.SMTPUsername) != "" || s.emailConfig.SMTPPassword != "" {
-		smtpAuth = smtp.PlainAuth("", s.emailConfig.SMTPUsername, s.emailConfig.SMTPPassword, host)
+	rendered, err := s.templates.Render(ctx, mailtemplate.TemplateVerifyEmail, s.templates.UserLocale(ctx, user.ID), map[string]any{
+		"Username":        greeting,
+		"VerificationURL": verificationURL,
+		"ExpiresIn":       expiry.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrEmailSendFailed, err)
 	}
 
-	if err := smtp.SendMail(fmt.Sprintf("%s:%d", host, port), smtpAuth, parsedFrom.Address, []string{parsedTo.Address}, []byte(message)); err != nil {
+	err = mailtemplate.SendSMTP(mailtemplate.SMTPConfig{
+		Host:        s.emailConfig.SMTPHost,
+		Port:        s.emailConfig.SMTPPort,
+		Username:    s.emailConfig.SMTPUsername,
+		Password:    s.emailConfig.SMTPPassword,
+		FromAddress: s.emailConfig.FromAddress,
+	}, mailtemplate.Mail{To: user.Email, Message: rendered})
+	switch {
+	case errors.Is(err, mailtemplate.ErrSMTPNotConfigured):
+		return ErrEmailNotConfigured
+	case err != nil:
 		return fmt.Errorf("%w: %v", ErrEmailSendFailed, err)
 	}
-
 	return nil
 }
 
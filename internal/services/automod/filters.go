@@ -0,0 +1,127 @@
+package automod
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var (
+	userMentionRe = regexp.MustCompile(`<@&?([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})>`)
+	massMentionRe = regexp.MustCompile(`(?:^|\s)@(everyone|here)\b`)
+
+	// Invite links for other chat platforms plus our own /invite/<code> links.
+	// Group 1 is set for zentra links so invites to the same community can be allowed.
+	inviteLinkRe = regexp.MustCompile(`(?i)(?:https?://)?(?:www\.)?(?:` +
+		`discord(?:app)?\.com/invite/[a-z0-9-]+` +
+		`|discord\.gg/[a-z0-9-]+` +
+		`|t\.me/(?:joinchat/|\+)[a-z0-9_-]+` +
+		`|chat\.whatsapp\.com/[a-z0-9]+` +
+		`|guilded\.gg/i/[a-z0-9]+` +
+		`|[a-z0-9.-]+\.[a-z]{2,}(?::\d+)?/invite/([a-z0-9]{8})\b)`)
+)
+
+// regexCache holds compiled rule patterns; rules are re-read on every message
+// so compiling them each time would be the expensive part.
+var regexCache sync.Map
+
+func compileCached(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexCache.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexCache.Store(expr, re)
+	return re, nil
+}
+
+// keywordExpr turns a keyword into a case-insensitive regex. Keywords match whole
+// words; a leading or trailing * lets it match inside longer words.
+func keywordExpr(keyword string) string {
+	keyword = strings.ToLower(strings.TrimSpace(keyword))
+	prefix, suffix := `\b`, `\b`
+	if strings.HasPrefix(keyword, "*") {
+		prefix = ""
+		keyword = strings.TrimPrefix(keyword, "*")
+	}
+	if strings.HasSuffix(keyword, "*") {
+		suffix = ""
+		keyword = strings.TrimSuffix(keyword, "*")
+	}
+	return `(?i)` + prefix + regexp.QuoteMeta(keyword) + suffix
+}
+
+func matchKeywords(keywords []string, content string) string {
+	for _, keyword := range keywords {
+		if strings.Trim(keyword, "* ") == "" {
+			continue
+		}
+		re, err := compileCached(keywordExpr(keyword))
+		if err != nil {
+			continue
+		}
+		if m := re.FindString(content); m != "" {
+			return m
+		}
+	}
+	return ""
+}
+
+func matchPatterns(patterns []string, content string) string {
+	for _, pattern := range patterns {
+		re, err := compileCached(pattern)
+		if err != nil {
+			continue
+		}
+		if loc := re.FindStringIndex(content); loc != nil {
+			// empty matches still count, but log something readable
+			if loc[0] == loc[1] {
+				return pattern
+			}
+			return content[loc[0]:loc[1]]
+		}
+	}
+	return ""
+}
+
+// findInviteLinks returns every invite link in content, and separately the
+// zentra invite codes so the caller can allow ones for the current community.
+func findInviteLinks(content string) (links []string, codes []string) {
+	for _, m := range inviteLinkRe.FindAllStringSubmatch(content, -1) {
+		links = append(links, m[0])
+		codes = append(codes, m[1])
+	}
+	return links, codes
+}
+
+// countMentions counts distinct user/role mentions, with @everyone and @here counting once each
+func countMentions(content string) int {
+	seen := make(map[string]bool)
+	for _, m := range userMentionRe.FindAllString(content, -1) {
+		seen[m] = true
+	}
+	for _, m := range massMentionRe.FindAllStringSubmatch(content, -1) {
+		seen["@"+m[1]] = true
+	}
+	return len(seen)
+}
+
+func containsUUID(list []uuid.UUID, id uuid.UUID) bool {
+	for _, item := range list {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateMatch(s string) string {
+	if runes := []rune(s); len(runes) > maxMatchedLength {
+		return string(runes[:maxMatchedLength])
+	}
+	return s
+}
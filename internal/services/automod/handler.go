@@ -0,0 +1,185 @@
+package automod
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/communities/{communityId}", func(r chi.Router) {
+		r.Get("/rules", h.ListRules)
+		r.Post("/rules", h.CreateRule)
+		r.Get("/log", h.GetLog)
+	})
+
+	r.Patch("/rules/{ruleId}", h.UpdateRule)
+	r.Delete("/rules/{ruleId}", h.DeleteRule)
+
+	return r
+}
+
+// ListRules returns the AutoMod rules of a community
+func (h *Handler) ListRules(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	rules, err := h.service.ListRules(r.Context(), communityID, userID)
+	if err != nil {
+		switch err {
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to get automod rules")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, rules)
+}
+
+// CreateRule adds a rule to a community
+func (h *Handler) CreateRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req CreateRuleRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	rule, err := h.service.CreateRule(r.Context(), communityID, userID, &req)
+	if err != nil {
+		h.respondRuleError(w, err, "Failed to create automod rule")
+		return
+	}
+
+	utils.RespondCreated(w, rule)
+}
+
+// UpdateRule edits an existing rule
+func (h *Handler) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	ruleID, err := uuid.Parse(chi.URLParam(r, "ruleId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	var req UpdateRuleRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	rule, err := h.service.UpdateRule(r.Context(), ruleID, userID, &req)
+	if err != nil {
+		h.respondRuleError(w, err, "Failed to update automod rule")
+		return
+	}
+
+	utils.RespondSuccess(w, rule)
+}
+
+// DeleteRule removes a rule
+func (h *Handler) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	ruleID, err := uuid.Parse(chi.URLParam(r, "ruleId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid rule ID")
+		return
+	}
+
+	if err := h.service.DeleteRule(r.Context(), ruleID, userID); err != nil {
+		h.respondRuleError(w, err, "Failed to delete automod rule")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// GetLog returns what AutoMod has caught recently
+func (h *Handler) GetLog(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	page := utils.GetCursorParams(r, 50, 100)
+
+	entries, next, err := h.service.GetLog(r.Context(), communityID, userID, page.Cursor, page.Limit)
+	if err != nil {
+		switch err {
+		case utils.ErrInvalidCursor:
+			utils.RespondInvalidCursor(w)
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to get automod log")
+		}
+		return
+	}
+
+	utils.RespondCursorPage(w, entries, next)
+}
+
+func (h *Handler) respondRuleError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrRuleNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Rule not found")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrTooManyRules), errors.Is(err, ErrMissingTriggerData), errors.Is(err, ErrInvalidPattern):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
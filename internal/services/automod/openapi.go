@@ -0,0 +1,44 @@
+package automod
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	ruleID := []openapi.Param{openapi.ID("ruleId")}
+
+	openapi.Describe((*Handler).ListRules, openapi.Operation{
+		Summary:  "List a community's AutoMod rules",
+		Path:     communityID,
+		Response: []*models.AutoModRule{},
+	})
+	openapi.Describe((*Handler).CreateRule, openapi.Operation{
+		Summary:  "Create an AutoMod rule",
+		Path:     communityID,
+		Body:     CreateRuleRequest{},
+		Response: models.AutoModRule{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetLog, openapi.Operation{
+		Summary:  "List what AutoMod did in a community, newest first",
+		Path:     communityID,
+		Query:    openapi.CursorQuery,
+		Response: []*models.AutoModLogEntryWithUser{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).UpdateRule, openapi.Operation{
+		Summary:  "Update an AutoMod rule",
+		Path:     ruleID,
+		Body:     UpdateRuleRequest{},
+		Response: models.AutoModRule{},
+	})
+	openapi.Describe((*Handler).DeleteRule, openapi.Operation{
+		Summary: "Delete an AutoMod rule",
+		Path:    ruleID,
+		Status:  http.StatusNoContent,
+	})
+}
@@ -0,0 +1,618 @@
+package automod
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
+)
+
+var (
+	ErrRuleNotFound       = errors.New("automod rule not found")
+	ErrInsufficientPerms  = errors.New("insufficient permissions")
+	ErrTooManyRules       = errors.New("community has reached the automod rule limit")
+	ErrInvalidPattern     = errors.New("invalid regex pattern")
+	ErrMissingTriggerData = errors.New("rule is missing the keywords, patterns or mention limit its trigger needs")
+)
+
+const (
+	MaxRulesPerCommunity = 25
+	maxMatchedLength     = 256
+)
+
+// actionSeverity orders actions so the harshest matching rule wins
+var actionSeverity = map[string]int{
+	models.AutoModActionFlag:   1,
+	models.AutoModActionDelete: 2,
+	models.AutoModActionBlock:  3,
+}
+
+// CommunityServiceInterface is the subset of community.Service we depend on
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+	GetMemberRoleIDs(ctx context.Context, communityID, userID uuid.UUID) ([]uuid.UUID, error)
+	LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte)
+}
+
+type Service struct {
+	db               *pgxpool.Pool
+	communityService CommunityServiceInterface
+}
+
+func NewService(db *pgxpool.Pool, communityService CommunityServiceInterface) *Service {
+	return &Service{
+		db:               db,
+		communityService: communityService,
+	}
+}
+
+type CreateRuleRequest struct {
+	Name             string      `json:"name" validate:"required,min=1,max=100"`
+	TriggerType      string      `json:"triggerType" validate:"required,oneof=keyword regex invite_link mention_spam"`
+	Action           string      `json:"action" validate:"required,oneof=flag delete block"`
+	Keywords         []string    `json:"keywords" validate:"max=100,dive,min=1,max=64"`
+	Patterns         []string    `json:"patterns" validate:"max=10,dive,min=1,max=256"`
+	MentionLimit     int         `json:"mentionLimit" validate:"min=0,max=50"`
+	ExemptRoleIDs    []uuid.UUID `json:"exemptRoleIds" validate:"max=50"`
+	ExemptChannelIDs []uuid.UUID `json:"exemptChannelIds" validate:"max=100"`
+	Enabled          *bool       `json:"enabled"`
+}
+
+type UpdateRuleRequest struct {
+	Name             *string      `json:"name" validate:"omitempty,min=1,max=100"`
+	Action           *string      `json:"action" validate:"omitempty,oneof=flag delete block"`
+	Keywords         *[]string    `json:"keywords" validate:"omitempty,max=100,dive,min=1,max=64"`
+	Patterns         *[]string    `json:"patterns" validate:"omitempty,max=10,dive,min=1,max=256"`
+	MentionLimit     *int         `json:"mentionLimit" validate:"omitempty,min=0,max=50"`
+	ExemptRoleIDs    *[]uuid.UUID `json:"exemptRoleIds" validate:"omitempty,max=50"`
+	ExemptChannelIDs *[]uuid.UUID `json:"exemptChannelIds" validate:"omitempty,max=100"`
+	Enabled          *bool        `json:"enabled"`
+}
+
+// Match is one rule that fired on a message
+type Match struct {
+	Rule    *models.AutoModRule
+	Matched string
+}
+
+// Verdict is the outcome of running a community's rules over a message.
+// Action is the most severe action among the matching rules.
+type Verdict struct {
+	CommunityID uuid.UUID
+	ChannelID   uuid.UUID // uuid.Nil for direct messages
+	UserID      uuid.UUID
+	Action      string
+	Matches     []Match
+}
+
+const ruleColumns = `id, community_id, name, trigger_type, action, keywords, patterns, mention_limit,
+	exempt_role_ids, exempt_channel_ids, enabled, created_by, created_at, updated_at`
+
+func scanRule(scanner interface{ Scan(dest ...any) error }) (*models.AutoModRule, error) {
+	r := &models.AutoModRule{}
+	err := scanner.Scan(
+		&r.ID, &r.CommunityID, &r.Name, &r.TriggerType, &r.Action, &r.Keywords, &r.Patterns, &r.MentionLimit,
+		&r.ExemptRoleIDs, &r.ExemptChannelIDs, &r.Enabled, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ListRules returns every rule configured on a community
+func (s *Service) ListRules(ctx context.Context, communityID, userID uuid.UUID) ([]*models.AutoModRule, error) {
+	if err := s.requirePermission(ctx, communityID, userID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+	return s.getRules(ctx, communityID, false)
+}
+
+// CreateRule adds a new rule to a community
+func (s *Service) CreateRule(ctx context.Context, communityID, userID uuid.UUID, req *CreateRuleRequest) (*models.AutoModRule, error) {
+	if err := s.requirePermission(ctx, communityID, userID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+
+	keywords := cleanList(req.Keywords)
+	patterns := cleanList(req.Patterns)
+	if err := validateTrigger(req.TriggerType, keywords, patterns, req.MentionLimit); err != nil {
+		return nil, err
+	}
+
+	var count int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM automod_rules WHERE community_id = $1`, communityID).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("count automod rules: %w", err)
+	}
+	if count >= MaxRulesPerCommunity {
+		return nil, ErrTooManyRules
+	}
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	rule, err := scanRule(s.db.QueryRow(ctx,
+		`INSERT INTO automod_rules (community_id, name, trigger_type, action, keywords, patterns, mention_limit,
+		                            exempt_role_ids, exempt_channel_ids, enabled, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		 RETURNING `+ruleColumns,
+		communityID, strings.TrimSpace(req.Name), req.TriggerType, req.Action, keywords, patterns, req.MentionLimit,
+		uuidList(req.ExemptRoleIDs), uuidList(req.ExemptChannelIDs), enabled, userID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create automod rule: %w", err)
+	}
+
+	s.logAudit(ctx, rule, userID, models.AuditActionAutoModCreate)
+	return rule, nil
+}
+
+// UpdateRule changes an existing rule. The trigger type is fixed once created.
+func (s *Service) UpdateRule(ctx context.Context, ruleID, userID uuid.UUID, req *UpdateRuleRequest) (*models.AutoModRule, error) {
+	existing, err := s.getRule(ctx, ruleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requirePermission(ctx, existing.CommunityID, userID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+
+	keywords := existing.Keywords
+	if req.Keywords != nil {
+		keywords = cleanList(*req.Keywords)
+	}
+	patterns := existing.Patterns
+	if req.Patterns != nil {
+		patterns = cleanList(*req.Patterns)
+	}
+	mentionLimit := existing.MentionLimit
+	if req.MentionLimit != nil {
+		mentionLimit = *req.MentionLimit
+	}
+	if err := validateTrigger(existing.TriggerType, keywords, patterns, mentionLimit); err != nil {
+		return nil, err
+	}
+
+	var name *string
+	if req.Name != nil {
+		trimmed := strings.TrimSpace(*req.Name)
+		name = &trimmed
+	}
+	var exemptRoles, exemptChannels []uuid.UUID
+	if req.ExemptRoleIDs != nil {
+		exemptRoles = uuidList(*req.ExemptRoleIDs)
+	}
+	if req.ExemptChannelIDs != nil {
+		exemptChannels = uuidList(*req.ExemptChannelIDs)
+	}
+
+	rule, err := scanRule(s.db.QueryRow(ctx,
+		`UPDATE automod_rules SET
+			name = COALESCE($2, name),
+			action = COALESCE($3, action),
+			keywords = $4,
+			patterns = $5,
+			mention_limit = $6,
+			exempt_role_ids = COALESCE($7, exempt_role_ids),
+			exempt_channel_ids = COALESCE($8, exempt_channel_ids),
+			enabled = COALESCE($9, enabled)
+		 WHERE id = $1
+		 RETURNING `+ruleColumns,
+		ruleID, name, req.Action, keywords, patterns, mentionLimit, exemptRoles, exemptChannels, req.Enabled,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRuleNotFound
+		}
+		return nil, fmt.Errorf("update automod rule: %w", err)
+	}
+
+	s.logAudit(ctx, rule, userID, models.AuditActionAutoModUpdate)
+	return rule, nil
+}
+
+// DeleteRule removes a rule. Log entries keep the rule name.
+func (s *Service) DeleteRule(ctx context.Context, ruleID, userID uuid.UUID) error {
+	rule, err := s.getRule(ctx, ruleID)
+	if err != nil {
+		return err
+	}
+	if err := s.requirePermission(ctx, rule.CommunityID, userID, models.PermissionManageCommunity); err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM automod_rules WHERE id = $1`, ruleID); err != nil {
+		return fmt.Errorf("delete automod rule: %w", err)
+	}
+
+	s.logAudit(ctx, rule, userID, models.AuditActionAutoModDelete)
+	return nil
+}
+
+// GetLog returns a page of recent rule triggers for a community, newest first,
+// and the cursor of the next page or "" on the last one
+func (s *Service) GetLog(ctx context.Context, communityID, userID uuid.UUID, cursor string, limit int) ([]*models.AutoModLogEntryWithUser, string, error) {
+	if err := s.requirePermission(ctx, communityID, userID, models.PermissionViewAuditLog); err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	before, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	query := `SELECT l.id, l.community_id, l.rule_id, l.rule_name, l.channel_id, l.user_id, l.message_id,
+		        l.trigger_type, l.action, l.matched, l.created_at,
+		        u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
+		 FROM automod_log l
+		 JOIN users u ON u.id = l.user_id
+		 WHERE l.community_id = $1`
+	args := []any{communityID, limit + 1}
+	if before != nil {
+		query += ` AND (l.created_at, l.id) < ($3, $4)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		 ORDER BY l.created_at DESC, l.id DESC
+		 LIMIT $2`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("get automod log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]*models.AutoModLogEntryWithUser, 0)
+	for rows.Next() {
+		e := &models.AutoModLogEntryWithUser{}
+		u := &models.PublicUser{}
+		if err := rows.Scan(
+			&e.ID, &e.CommunityID, &e.RuleID, &e.RuleName, &e.ChannelID, &e.UserID, &e.MessageID,
+			&e.TriggerType, &e.Action, &e.Matched, &e.CreatedAt,
+			&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Bio, &u.Status, &u.CustomStatus, &u.CreatedAt,
+		); err != nil {
+			return nil, "", fmt.Errorf("scan automod log entry: %w", err)
+		}
+		e.User = u
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.CreatedAt, ID: last.ID})
+	}
+	return entries, next, nil
+}
+
+// Evaluate runs the community's enabled rules over a message about to be posted
+// in channelID. It returns nil when nothing matched. Administrators are exempt.
+func (s *Service) Evaluate(ctx context.Context, channelID, authorID uuid.UUID, content string) (*Verdict, error) {
+	return s.evaluate(ctx, channelID, authorID, content, true)
+}
+
+// EvaluateIntegration is Evaluate for bot users posting for an integration.
+// They aren't community members, so no role or administrator exemption applies.
+func (s *Service) EvaluateIntegration(ctx context.Context, channelID, authorID uuid.UUID, content string) (*Verdict, error) {
+	return s.evaluate(ctx, channelID, authorID, content, false)
+}
+
+func (s *Service) evaluate(ctx context.Context, channelID, authorID uuid.UUID, content string, member bool) (*Verdict, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, nil
+	}
+
+	var communityID uuid.UUID
+	err := s.db.QueryRow(ctx, `SELECT community_id FROM channels WHERE id = $1`, channelID).Scan(&communityID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.evaluateIn(ctx, communityID, channelID, authorID, content, member)
+}
+
+// EvaluateDM runs the rules of every community the author shares with one of
+// the recipients over a direct message. It returns a verdict for each
+// community with a matching rule. Channel exemptions don't apply.
+func (s *Service) EvaluateDM(ctx context.Context, authorID uuid.UUID, recipientIDs []uuid.UUID, content string) ([]*Verdict, error) {
+	if strings.TrimSpace(content) == "" || len(recipientIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT DISTINCT a.community_id
+		 FROM community_members a
+		 JOIN community_members b ON b.community_id = a.community_id
+		 WHERE a.user_id = $1 AND b.user_id = ANY($2)
+		   AND EXISTS (SELECT 1 FROM automod_rules r WHERE r.community_id = a.community_id AND r.enabled)`,
+		authorID, recipientIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	communityIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return nil, err
+	}
+
+	var verdicts []*Verdict
+	for _, communityID := range communityIDs {
+		verdict, err := s.evaluateIn(ctx, communityID, uuid.Nil, authorID, content, true)
+		if err != nil {
+			return nil, err
+		}
+		if verdict != nil {
+			verdicts = append(verdicts, verdict)
+		}
+	}
+	return verdicts, nil
+}
+
+func (s *Service) evaluateIn(ctx context.Context, communityID, channelID, authorID uuid.UUID, content string, member bool) (*Verdict, error) {
+	rules, err := s.getRules(ctx, communityID, true)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	if member {
+		perms, err := s.communityService.GetMemberPermissions(ctx, communityID, authorID)
+		if err != nil {
+			return nil, err
+		}
+		if perms&models.PermissionAdministrator != 0 {
+			return nil, nil
+		}
+	}
+
+	var roleIDs []uuid.UUID
+	roleIDsLoaded := !member
+
+	verdict := &Verdict{CommunityID: communityID, ChannelID: channelID, UserID: authorID}
+	for _, rule := range rules {
+		if containsUUID(rule.ExemptChannelIDs, channelID) {
+			continue
+		}
+		if len(rule.ExemptRoleIDs) > 0 {
+			if !roleIDsLoaded {
+				roleIDs, err = s.communityService.GetMemberRoleIDs(ctx, communityID, authorID)
+				if err != nil {
+					return nil, err
+				}
+				roleIDsLoaded = true
+			}
+			if hasExemptRole(rule.ExemptRoleIDs, roleIDs) {
+				continue
+			}
+		}
+
+		matched, err := s.matchRule(ctx, rule, communityID, content)
+		if err != nil {
+			return nil, err
+		}
+		if matched == "" {
+			continue
+		}
+
+		verdict.Matches = append(verdict.Matches, Match{Rule: rule, Matched: truncateMatch(matched)})
+		if actionSeverity[rule.Action] > actionSeverity[verdict.Action] {
+			verdict.Action = rule.Action
+		}
+	}
+
+	if len(verdict.Matches) == 0 {
+		return nil, nil
+	}
+	return verdict, nil
+}
+
+// LogVerdict writes one log entry per matching rule. messageID is nil for
+// blocked messages since they were never stored, and for direct messages.
+func (s *Service) LogVerdict(ctx context.Context, verdict *Verdict, messageID *uuid.UUID) {
+	var channelID *uuid.UUID
+	if verdict.ChannelID != uuid.Nil {
+		channelID = &verdict.ChannelID
+	}
+	for _, m := range verdict.Matches {
+		ruleID := m.Rule.ID
+		_, err := s.db.Exec(ctx,
+			`INSERT INTO automod_log (community_id, rule_id, rule_name, channel_id, user_id, message_id, trigger_type, action, matched)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			verdict.CommunityID, ruleID, m.Rule.Name, channelID, verdict.UserID, messageID,
+			m.Rule.TriggerType, m.Rule.Action, m.Matched,
+		)
+		if err != nil {
+			log.Error().Err(err).Str("ruleId", ruleID.String()).Msg("Failed to write automod log entry")
+		}
+	}
+}
+
+func (s *Service) matchRule(ctx context.Context, rule *models.AutoModRule, communityID uuid.UUID, content string) (string, error) {
+	switch rule.TriggerType {
+	case models.AutoModTriggerKeyword:
+		return matchKeywords(rule.Keywords, content), nil
+	case models.AutoModTriggerRegex:
+		return matchPatterns(rule.Patterns, content), nil
+	case models.AutoModTriggerMentionSpam:
+		if rule.MentionLimit > 0 {
+			if count := countMentions(content); count > rule.MentionLimit {
+				return fmt.Sprintf("%d mentions", count), nil
+			}
+		}
+		return "", nil
+	case models.AutoModTriggerInviteLink:
+		return s.matchInvites(ctx, communityID, content)
+	}
+	return "", nil
+}
+
+// matchInvites returns the first invite link that doesn't point back at this community
+func (s *Service) matchInvites(ctx context.Context, communityID uuid.UUID, content string) (string, error) {
+	links, codes := findInviteLinks(content)
+	if len(links) == 0 {
+		return "", nil
+	}
+
+	var ownCodes []string
+	for _, code := range codes {
+		if code != "" {
+			ownCodes = append(ownCodes, code)
+		}
+	}
+
+	allowed := make(map[string]bool)
+	if len(ownCodes) > 0 {
+		rows, err := s.db.Query(ctx,
+			`SELECT code FROM community_invites WHERE community_id = $1 AND code = ANY($2)`,
+			communityID, ownCodes,
+		)
+		if err != nil {
+			return "", err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var code string
+			if err := rows.Scan(&code); err != nil {
+				return "", err
+			}
+			allowed[code] = true
+		}
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+	}
+
+	for i, link := range links {
+		if codes[i] != "" && allowed[codes[i]] {
+			continue
+		}
+		return link, nil
+	}
+	return "", nil
+}
+
+func (s *Service) getRule(ctx context.Context, ruleID uuid.UUID) (*models.AutoModRule, error) {
+	rule, err := scanRule(s.db.QueryRow(ctx, `SELECT `+ruleColumns+` FROM automod_rules WHERE id = $1`, ruleID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRuleNotFound
+		}
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *Service) getRules(ctx context.Context, communityID uuid.UUID, enabledOnly bool) ([]*models.AutoModRule, error) {
+	query := `SELECT ` + ruleColumns + ` FROM automod_rules WHERE community_id = $1`
+	if enabledOnly {
+		query += ` AND enabled = TRUE`
+	}
+	query += ` ORDER BY created_at ASC`
+
+	rows, err := s.db.Query(ctx, query, communityID)
+	if err != nil {
+		return nil, fmt.Errorf("get automod rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := make([]*models.AutoModRule, 0)
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan automod rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *Service) requirePermission(ctx context.Context, communityID, userID uuid.UUID, permission int64) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(perms, permission) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+func (s *Service) logAudit(ctx context.Context, rule *models.AutoModRule, actorID uuid.UUID, action string) {
+	details, _ := json.Marshal(map[string]any{
+		"name":        rule.Name,
+		"triggerType": rule.TriggerType,
+		"action":      rule.Action,
+	})
+	s.communityService.LogAudit(ctx, &rule.CommunityID, actorID, action, "automod_rule", &rule.ID, details)
+}
+
+func validateTrigger(triggerType string, keywords, patterns []string, mentionLimit int) error {
+	switch triggerType {
+	case models.AutoModTriggerKeyword:
+		if len(keywords) == 0 {
+			return ErrMissingTriggerData
+		}
+	case models.AutoModTriggerRegex:
+		if len(patterns) == 0 {
+			return ErrMissingTriggerData
+		}
+		for _, p := range patterns {
+			if _, err := regexp.Compile(p); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidPattern, err)
+			}
+		}
+	case models.AutoModTriggerMentionSpam:
+		if mentionLimit < 1 {
+			return ErrMissingTriggerData
+		}
+	}
+	return nil
+}
+
+func hasExemptRole(exempt, roleIDs []uuid.UUID) bool {
+	for _, id := range roleIDs {
+		if containsUUID(exempt, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanList trims entries and drops blanks and duplicates
+func cleanList(values []string) []string {
+	out := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return out
+}
+
+func uuidList(ids []uuid.UUID) []uuid.UUID {
+	if ids == nil {
+		return []uuid.UUID{}
+	}
+	return ids
+}
@@ -0,0 +1,196 @@
+package broadcast
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/communities/{communityId}", func(r chi.Router) {
+		r.Get("/", h.ListBroadcasts)
+		r.Post("/", h.CreateBroadcast)
+		r.Get("/subscription", h.GetSubscription)
+		r.Put("/subscription", h.SetSubscription)
+	})
+
+	r.Route("/{broadcastId}", func(r chi.Router) {
+		r.Get("/", h.GetBroadcast)
+		r.Post("/cancel", h.CancelBroadcast)
+	})
+
+	return r
+}
+
+// ListBroadcasts returns a community's broadcasts with delivery stats
+func (h *Handler) ListBroadcasts(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := h.communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	page := utils.GetCursorParams(r, 20, 100)
+
+	broadcasts, next, err := h.service.ListBroadcasts(r.Context(), communityID, userID, page.Cursor, page.Limit)
+	if err != nil {
+		h.respondBroadcastError(w, err, "Failed to get broadcasts")
+		return
+	}
+
+	utils.RespondCursorPage(w, broadcasts, next)
+}
+
+// CreateBroadcast queues an announcement DM to every opted-in member
+func (h *Handler) CreateBroadcast(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := h.communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateBroadcastRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	broadcast, err := h.service.CreateBroadcast(r.Context(), communityID, userID, &req)
+	if err != nil {
+		h.respondBroadcastError(w, err, "Failed to send broadcast")
+		return
+	}
+
+	utils.RespondCreated(w, broadcast)
+}
+
+// GetSubscription returns whether the user receives the community's announcement DMs
+func (h *Handler) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := h.communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	sub, err := h.service.GetSubscription(r.Context(), communityID, userID)
+	if err != nil {
+		h.respondBroadcastError(w, err, "Failed to get subscription")
+		return
+	}
+
+	utils.RespondSuccess(w, sub)
+}
+
+// SetSubscription opts the user in to or out of the community's announcement DMs
+func (h *Handler) SetSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := h.communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req SubscriptionRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	sub, err := h.service.SetSubscription(r.Context(), communityID, userID, *req.Subscribed)
+	if err != nil {
+		h.respondBroadcastError(w, err, "Failed to update subscription")
+		return
+	}
+
+	utils.RespondSuccess(w, sub)
+}
+
+// GetBroadcast returns one broadcast with delivery stats
+func (h *Handler) GetBroadcast(w http.ResponseWriter, r *http.Request) {
+	userID, broadcastID, ok := h.broadcastParams(w, r)
+	if !ok {
+		return
+	}
+
+	broadcast, err := h.service.GetBroadcast(r.Context(), broadcastID, userID)
+	if err != nil {
+		h.respondBroadcastError(w, err, "Failed to get broadcast")
+		return
+	}
+
+	utils.RespondSuccess(w, broadcast)
+}
+
+// CancelBroadcast stops delivery to members who haven't received it yet
+func (h *Handler) CancelBroadcast(w http.ResponseWriter, r *http.Request) {
+	userID, broadcastID, ok := h.broadcastParams(w, r)
+	if !ok {
+		return
+	}
+
+	broadcast, err := h.service.CancelBroadcast(r.Context(), broadcastID, userID)
+	if err != nil {
+		h.respondBroadcastError(w, err, "Failed to cancel broadcast")
+		return
+	}
+
+	utils.RespondSuccess(w, broadcast)
+}
+
+func (h *Handler) communityParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, communityID, true
+}
+
+func (h *Handler) broadcastParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	broadcastID, err := uuid.Parse(chi.URLParam(r, "broadcastId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid broadcast ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, broadcastID, true
+}
+
+func (h *Handler) respondBroadcastError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidCursor):
+		utils.RespondInvalidCursor(w)
+	case errors.Is(err, ErrBroadcastNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Broadcast not found")
+	case errors.Is(err, ErrNotMember):
+		utils.RespondError(w, http.StatusNotFound, "Not a member of this community")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrBroadcastFinished):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrTooManyBroadcasts):
+		utils.RespondError(w, http.StatusTooManyRequests, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,49 @@
+package broadcast
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	broadcastID := []openapi.Param{openapi.ID("broadcastId")}
+
+	openapi.Describe((*Handler).ListBroadcasts, openapi.Operation{
+		Summary:  "List a community's broadcasts",
+		Path:     communityID,
+		Query:    openapi.CursorQuery,
+		Response: []*models.Broadcast{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).CreateBroadcast, openapi.Operation{
+		Summary:  "Send an announcement to the members who opted in, by DM",
+		Path:     communityID,
+		Body:     CreateBroadcastRequest{},
+		Response: models.Broadcast{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetSubscription, openapi.Operation{
+		Summary:  "Get whether the current user receives a community's broadcasts",
+		Path:     communityID,
+		Response: Subscription{},
+	})
+	openapi.Describe((*Handler).SetSubscription, openapi.Operation{
+		Summary:  "Subscribe to or unsubscribe from a community's broadcasts",
+		Path:     communityID,
+		Body:     SubscriptionRequest{},
+		Response: Subscription{},
+	})
+	openapi.Describe((*Handler).GetBroadcast, openapi.Operation{
+		Summary:  "Get a broadcast",
+		Path:     broadcastID,
+		Response: models.Broadcast{},
+	})
+	openapi.Describe((*Handler).CancelBroadcast, openapi.Operation{
+		Summary:  "Cancel a broadcast that hasn't finished sending",
+		Path:     broadcastID,
+		Response: models.Broadcast{},
+	})
+}
@@ -0,0 +1,625 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/auth"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	// Communities may start this many broadcasts per day
+	MaxBroadcastsPerDay = 3
+
+	// Each community's announcements go out at most this fast, across instances
+	sendRateLimit  = 20
+	sendRateWindow = time.Second
+
+	maxAttempts    = 3
+	retryDelay     = time.Minute
+	deliveryLease  = time.Minute
+	pollInterval   = 2 * time.Second
+	deliveryBatch  = 100
+	rateLimitDelay = time.Second
+)
+
+var (
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrBroadcastNotFound = errors.New("broadcast not found")
+	ErrBroadcastFinished = errors.New("broadcast has already finished")
+	ErrTooManyBroadcasts = errors.New("community has sent too many broadcasts today")
+	ErrNotMember         = errors.New("not a member of this community")
+)
+
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+	LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte)
+}
+
+// DMDeliverer posts announcements into members' DMs
+type DMDeliverer interface {
+	DeliverBroadcast(ctx context.Context, communityID, senderID, recipientID uuid.UUID, content string, previews []models.LinkPreview) (uuid.UUID, error)
+}
+
+type Service struct {
+	db               *pgxpool.Pool
+	communityService CommunityServiceInterface
+	dm               DMDeliverer
+	cipher           messaging.ContentCipher
+	wake             chan struct{}
+}
+
+func NewService(db *pgxpool.Pool, communityService CommunityServiceInterface, dm DMDeliverer, keys *encryption.Keyring) *Service {
+	return &Service{
+		db:               db,
+		communityService: communityService,
+		dm:               dm,
+		cipher:           messaging.NewDMCipher(keys),
+		wake:             make(chan struct{}, 1),
+	}
+}
+
+type CreateBroadcastRequest struct {
+	Content string `json:"content" validate:"required,max=4000"`
+}
+
+type SubscriptionRequest struct {
+	Subscribed *bool `json:"subscribed" validate:"required"`
+}
+
+// Subscription is a member's announcement DM preference for one community
+type Subscription struct {
+	CommunityID uuid.UUID `json:"communityId"`
+	Subscribed  bool      `json:"subscribed"`
+}
+
+// claimed is a recipient taken off the queue for delivery
+type claimed struct {
+	BroadcastID uuid.UUID
+	UserID      uuid.UUID
+	Attempts    int
+}
+
+const broadcastColumns = `b.id, b.community_id, b.author_id, b.encrypted_content, b.nonce, b.status, b.created_at, b.completed_at,
+	COUNT(r.user_id),
+	COUNT(r.user_id) FILTER (WHERE r.status = 'pending'),
+	COUNT(r.user_id) FILTER (WHERE r.status = 'sent'),
+	COUNT(r.user_id) FILTER (WHERE r.status = 'failed'),
+	COUNT(r.user_id) FILTER (WHERE r.status = 'skipped')`
+
+const broadcastFrom = `community_broadcasts b
+	LEFT JOIN community_broadcast_recipients r ON r.broadcast_id = b.id`
+
+func (s *Service) scanBroadcast(scanner interface{ Scan(dest ...any) error }) (*models.Broadcast, error) {
+	b := &models.Broadcast{}
+	var encContent, nonce []byte
+	err := scanner.Scan(
+		&b.ID, &b.CommunityID, &b.AuthorID, &encContent, &nonce, &b.Status, &b.CreatedAt, &b.CompletedAt,
+		&b.Stats.Total, &b.Stats.Pending, &b.Stats.Sent, &b.Stats.Failed, &b.Stats.Skipped,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := messaging.ContentRef{Kind: messaging.ContentKindBroadcast, ID: b.ID, ContainerID: b.CommunityID}
+	b.Content, _ = messaging.DecryptOrPlaceholder(s.db, s.cipher, ref, encContent, nonce)
+	return b, nil
+}
+
+// ListBroadcasts returns a page of a community's broadcasts with their
+// delivery stats, newest first, and the cursor of the next page or "" on the
+// last one
+func (s *Service) ListBroadcasts(ctx context.Context, communityID, userID uuid.UUID, cursor string, limit int) ([]*models.Broadcast, string, error) {
+	if err := s.requirePermission(ctx, communityID, userID); err != nil {
+		return nil, "", err
+	}
+	before, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	query := `SELECT ` + broadcastColumns + ` FROM ` + broadcastFrom + `
+		 WHERE b.community_id = $1`
+	args := []any{communityID, limit + 1}
+	if before != nil {
+		query += ` AND (b.created_at, b.id) < ($3, $4)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		 GROUP BY b.id
+		 ORDER BY b.created_at DESC, b.id DESC
+		 LIMIT $2`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("list broadcasts: %w", err)
+	}
+	defer rows.Close()
+
+	broadcasts := make([]*models.Broadcast, 0)
+	for rows.Next() {
+		b, err := s.scanBroadcast(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("scan broadcast: %w", err)
+		}
+		broadcasts = append(broadcasts, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(broadcasts) > limit {
+		broadcasts = broadcasts[:limit]
+		last := broadcasts[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.CreatedAt, ID: last.ID})
+	}
+	return broadcasts, next, nil
+}
+
+// GetBroadcast returns one broadcast with its delivery stats
+func (s *Service) GetBroadcast(ctx context.Context, broadcastID, userID uuid.UUID) (*models.Broadcast, error) {
+	b, err := s.getBroadcast(ctx, broadcastID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requirePermission(ctx, b.CommunityID, userID); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// CreateBroadcast queues an announcement for every member who opted in. The
+// recipient list is fixed when the broadcast is created; delivery happens in
+// the background.
+func (s *Service) CreateBroadcast(ctx context.Context, communityID, userID uuid.UUID, req *CreateBroadcastRequest) (*models.Broadcast, error) {
+	if err := s.requirePermission(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	var recent int
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM community_broadcasts WHERE community_id = $1 AND created_at > NOW() - INTERVAL '1 day'`,
+		communityID,
+	).Scan(&recent)
+	if err != nil {
+		return nil, fmt.Errorf("count recent broadcasts: %w", err)
+	}
+	if recent >= MaxBroadcastsPerDay {
+		return nil, ErrTooManyBroadcasts
+	}
+
+	content := strings.TrimSpace(req.Content)
+	broadcastID := uuid.New()
+	ref := messaging.ContentRef{Kind: messaging.ContentKindBroadcast, ID: broadcastID}
+	ciphertext, nonce, err := s.cipher.Encrypt(ref.Row(), content)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt broadcast: %w", err)
+	}
+	previews := messaging.BuildLinkPreviews(ctx, content)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	senderID, err := s.ensureSender(ctx, tx, communityID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO community_broadcasts (id, community_id, author_id, encrypted_content, nonce, link_previews)
+		 VALUES ($1, $2, $3, $4, $5, $6::jsonb)`,
+		broadcastID, communityID, userID, ciphertext, nonce, string(messaging.EncodeLinkPreviews(previews)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create broadcast: %w", err)
+	}
+
+	result, err := tx.Exec(ctx,
+		`INSERT INTO community_broadcast_recipients (broadcast_id, user_id)
+		 SELECT $1, user_id FROM community_members
+		 WHERE community_id = $2 AND announcement_dms AND user_id <> $3`,
+		broadcastID, communityID, senderID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("queue broadcast recipients: %w", err)
+	}
+
+	// Nobody opted in: there is nothing left to do
+	if result.RowsAffected() == 0 {
+		if _, err := tx.Exec(ctx,
+			`UPDATE community_broadcasts SET status = 'completed', completed_at = NOW() WHERE id = $1`,
+			broadcastID,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	details, _ := json.Marshal(map[string]any{"recipients": result.RowsAffected()})
+	s.communityService.LogAudit(ctx, &communityID, userID, models.AuditActionBroadcastSend, "broadcast", &broadcastID, details)
+
+	s.wakeUp()
+	return s.getBroadcast(ctx, broadcastID)
+}
+
+// CancelBroadcast stops delivery to recipients who haven't been sent the
+// announcement yet. They are counted as skipped.
+func (s *Service) CancelBroadcast(ctx context.Context, broadcastID, userID uuid.UUID) (*models.Broadcast, error) {
+	b, err := s.GetBroadcast(ctx, broadcastID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if b.Status != models.BroadcastSending {
+		return nil, ErrBroadcastFinished
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`UPDATE community_broadcasts SET status = 'cancelled', completed_at = NOW() WHERE id = $1 AND status = 'sending'`,
+		broadcastID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("cancel broadcast: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrBroadcastFinished
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE community_broadcast_recipients SET status = 'skipped', last_error = 'cancelled'
+		 WHERE broadcast_id = $1 AND status = 'pending'`,
+		broadcastID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("skip broadcast recipients: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.communityService.LogAudit(ctx, &b.CommunityID, userID, models.AuditActionBroadcastCancel, "broadcast", &broadcastID, nil)
+	return s.getBroadcast(ctx, broadcastID)
+}
+
+// GetSubscription returns whether userID receives announcement DMs from a
+// community
+func (s *Service) GetSubscription(ctx context.Context, communityID, userID uuid.UUID) (*Subscription, error) {
+	sub := &Subscription{CommunityID: communityID}
+	err := s.db.QueryRow(ctx,
+		`SELECT announcement_dms FROM community_members WHERE community_id = $1 AND user_id = $2`,
+		communityID, userID,
+	).Scan(&sub.Subscribed)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotMember
+		}
+		return nil, fmt.Errorf("get announcement subscription: %w", err)
+	}
+	return sub, nil
+}
+
+// SetSubscription opts userID in to or out of a community's announcement DMs.
+// Opting out also drops the member from broadcasts still being delivered.
+func (s *Service) SetSubscription(ctx context.Context, communityID, userID uuid.UUID, subscribed bool) (*Subscription, error) {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE community_members SET announcement_dms = $3 WHERE community_id = $1 AND user_id = $2`,
+		communityID, userID, subscribed,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("set announcement subscription: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrNotMember
+	}
+
+	if !subscribed {
+		_, err = s.db.Exec(ctx,
+			`UPDATE community_broadcast_recipients r SET status = 'skipped', last_error = 'opted out'
+			 FROM community_broadcasts b
+			 WHERE b.id = r.broadcast_id AND b.community_id = $1 AND r.user_id = $2 AND r.status = 'pending'`,
+			communityID, userID,
+		)
+		if err != nil {
+			log.Warn().Err(err).Str("communityId", communityID.String()).Msg("Failed to drop pending announcements after opt-out")
+		}
+	}
+
+	return &Subscription{CommunityID: communityID, Subscribed: subscribed}, nil
+}
+
+func (s *Service) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers queued announcements until ctx is cancelled. Every instance can
+// run it; recipients are claimed with SKIP LOCKED so each gets one copy.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Service) deliverDue(ctx context.Context) {
+	batch, err := s.claimDue(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to claim broadcast recipients")
+		return
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	broadcasts := make(map[uuid.UUID]*queuedBroadcast)
+	for _, c := range batch {
+		qb, ok := broadcasts[c.BroadcastID]
+		if !ok {
+			qb, err = s.loadQueued(ctx, c.BroadcastID)
+			if err != nil {
+				log.Error().Err(err).Str("broadcastId", c.BroadcastID.String()).Msg("Failed to load broadcast")
+				continue
+			}
+			broadcasts[c.BroadcastID] = qb
+		}
+		s.deliver(ctx, qb, c)
+	}
+
+	for id := range broadcasts {
+		s.completeIfDone(ctx, id)
+	}
+}
+
+// claimDue pushes the next attempt of due recipients past the lease so other
+// instances leave them alone while this one sends to them
+func (s *Service) claimDue(ctx context.Context) ([]claimed, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE community_broadcast_recipients r SET next_attempt_at = $1
+		 FROM (
+			SELECT rr.broadcast_id, rr.user_id FROM community_broadcast_recipients rr
+			JOIN community_broadcasts b ON b.id = rr.broadcast_id AND b.status = 'sending'
+			WHERE rr.status = 'pending' AND rr.next_attempt_at <= NOW()
+			ORDER BY rr.next_attempt_at
+			LIMIT $2
+			FOR UPDATE OF rr SKIP LOCKED) due
+		 WHERE r.broadcast_id = due.broadcast_id AND r.user_id = due.user_id
+		 RETURNING r.broadcast_id, r.user_id, r.attempts`,
+		time.Now().Add(deliveryLease), deliveryBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []claimed
+	for rows.Next() {
+		var c claimed
+		if err := rows.Scan(&c.BroadcastID, &c.UserID, &c.Attempts); err != nil {
+			return nil, err
+		}
+		batch = append(batch, c)
+	}
+	return batch, rows.Err()
+}
+
+// queuedBroadcast is what the worker needs to send one broadcast
+type queuedBroadcast struct {
+	CommunityID uuid.UUID
+	SenderID    uuid.UUID
+	Content     string
+	Previews    []models.LinkPreview
+}
+
+func (s *Service) loadQueued(ctx context.Context, broadcastID uuid.UUID) (*queuedBroadcast, error) {
+	qb := &queuedBroadcast{}
+	var encContent, nonce, previewsRaw []byte
+	var senderID *uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`SELECT b.community_id, c.broadcast_user_id, b.encrypted_content, b.nonce, b.link_previews
+		 FROM community_broadcasts b
+		 JOIN communities c ON c.id = b.community_id
+		 WHERE b.id = $1`,
+		broadcastID,
+	).Scan(&qb.CommunityID, &senderID, &encContent, &nonce, &previewsRaw)
+	if err != nil {
+		return nil, err
+	}
+	if senderID == nil {
+		return nil, errors.New("community has no broadcast sender")
+	}
+	qb.SenderID = *senderID
+
+	ref := messaging.ContentRef{Kind: messaging.ContentKindBroadcast, ID: broadcastID, ContainerID: qb.CommunityID}
+	if qb.Content, err = s.cipher.Decrypt(ref.Row(), encContent, nonce); err != nil {
+		messaging.ReportDecryptionFailure(s.db, s.cipher, ref, err)
+		return nil, fmt.Errorf("decrypt broadcast: %w", err)
+	}
+	qb.Previews = messaging.DecodeLinkPreviews(previewsRaw)
+	return qb, nil
+}
+
+func (s *Service) deliver(ctx context.Context, qb *queuedBroadcast, c claimed) {
+	// Over the community's send rate: put the recipient back without using an attempt
+	count, err := database.IncrementRateLimit(ctx, "broadcast:"+qb.CommunityID.String(), sendRateWindow)
+	if err == nil && count > sendRateLimit {
+		s.reschedule(ctx, c, c.Attempts, rateLimitDelay, nil)
+		return
+	}
+
+	// Members who left or opted out since the broadcast was queued are skipped
+	var subscribed bool
+	err = s.db.QueryRow(ctx,
+		`SELECT announcement_dms FROM community_members WHERE community_id = $1 AND user_id = $2`,
+		qb.CommunityID, c.UserID,
+	).Scan(&subscribed)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !subscribed) {
+		s.finish(ctx, c, models.BroadcastRecipientSkipped, "no longer subscribed")
+		return
+	}
+
+	if err == nil {
+		_, err = s.dm.DeliverBroadcast(ctx, qb.CommunityID, qb.SenderID, c.UserID, qb.Content, qb.Previews)
+	}
+	if err == nil {
+		s.finish(ctx, c, models.BroadcastRecipientSent, "")
+		return
+	}
+
+	if errors.Is(err, dm.ErrBlocked) {
+		s.finish(ctx, c, models.BroadcastRecipientSkipped, "recipient blocked the sender")
+		return
+	}
+
+	attempts := c.Attempts + 1
+	if attempts >= maxAttempts {
+		s.finish(ctx, c, models.BroadcastRecipientFailed, err.Error())
+		return
+	}
+	reason := err.Error()
+	s.reschedule(ctx, c, attempts, retryDelay, &reason)
+}
+
+func (s *Service) reschedule(ctx context.Context, c claimed, attempts int, delay time.Duration, lastError *string) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE community_broadcast_recipients SET attempts = $3, next_attempt_at = $4, last_error = COALESCE($5, last_error)
+		 WHERE broadcast_id = $1 AND user_id = $2 AND status = 'pending'`,
+		c.BroadcastID, c.UserID, attempts, time.Now().Add(delay), lastError,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("broadcastId", c.BroadcastID.String()).Msg("Failed to reschedule broadcast recipient")
+	}
+}
+
+// finish records how a delivery ended. Only a recipient still pending is
+// updated, so one that was skipped while the send was in flight, or already
+// finished by another instance after the lease ran out, keeps its status.
+func (s *Service) finish(ctx context.Context, c claimed, status, reason string) {
+	var lastError *string
+	if reason != "" {
+		lastError = &reason
+	}
+	_, err := s.db.Exec(ctx,
+		`UPDATE community_broadcast_recipients SET status = $3, attempts = attempts + 1, last_error = $4,
+		        sent_at = CASE WHEN $3 = 'sent' THEN NOW() END
+		 WHERE broadcast_id = $1 AND user_id = $2 AND status = 'pending'`,
+		c.BroadcastID, c.UserID, status, lastError,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("broadcastId", c.BroadcastID.String()).Msg("Failed to record broadcast delivery")
+	}
+}
+
+func (s *Service) completeIfDone(ctx context.Context, broadcastID uuid.UUID) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE community_broadcasts SET status = 'completed', completed_at = NOW()
+		 WHERE id = $1 AND status = 'sending'
+		   AND NOT EXISTS (SELECT 1 FROM community_broadcast_recipients WHERE broadcast_id = $1 AND status = 'pending')`,
+		broadcastID,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("broadcastId", broadcastID.String()).Msg("Failed to complete broadcast")
+	}
+}
+
+// ensureSender returns the community's broadcast bot user, creating it on
+// first use. It carries the community's name and icon.
+func (s *Service) ensureSender(ctx context.Context, tx pgx.Tx, communityID uuid.UUID) (uuid.UUID, error) {
+	var senderID *uuid.UUID
+	var name string
+	var iconURL *string
+	err := tx.QueryRow(ctx,
+		`SELECT broadcast_user_id, name, icon_url FROM communities WHERE id = $1 FOR UPDATE`,
+		communityID,
+	).Scan(&senderID, &name, &iconURL)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("load community: %w", err)
+	}
+	if senderID != nil {
+		return *senderID, nil
+	}
+
+	botUserID := uuid.New()
+	compactID := strings.ReplaceAll(communityID.String(), "-", "")
+	username := "cb" + compactID[:30]
+	email := fmt.Sprintf("%s@broadcast.zentra.local", username)
+
+	passwordHash, err := auth.HashPassword(uuid.NewString() + ":broadcast")
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO users (id, username, email, password_hash, display_name, avatar_url, status, email_verified, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, TRUE, NOW(), NOW())`,
+		botUserID, username, email, passwordHash, name, iconURL, models.UserStatusOffline,
+	)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("create broadcast sender: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE communities SET broadcast_user_id = $2 WHERE id = $1`, communityID, botUserID); err != nil {
+		return uuid.Nil, err
+	}
+	return botUserID, nil
+}
+
+func (s *Service) getBroadcast(ctx context.Context, broadcastID uuid.UUID) (*models.Broadcast, error) {
+	b, err := s.scanBroadcast(s.db.QueryRow(ctx,
+		`SELECT `+broadcastColumns+` FROM `+broadcastFrom+` WHERE b.id = $1 GROUP BY b.id`,
+		broadcastID,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrBroadcastNotFound
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *Service) requirePermission(ctx context.Context, communityID, userID uuid.UUID) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(perms, models.PermissionManageCommunity) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
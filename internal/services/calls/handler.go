@@ -0,0 +1,243 @@
+package calls
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	// A conversation's calls
+	r.Route("/conversations/{conversationId}", func(r chi.Router) {
+		r.Get("/", h.ListCalls)
+		r.Post("/", h.StartCall)
+		r.Get("/active", h.GetActiveCall)
+	})
+
+	r.Route("/{callId}", func(r chi.Router) {
+		r.Get("/", h.GetCall)
+		r.Post("/accept", h.AcceptCall)
+		r.Post("/decline", h.DeclineCall)
+		r.Post("/join", h.JoinCall)
+		r.Post("/leave", h.LeaveCall)
+	})
+
+	return r
+}
+
+// StartCallRequest is the optional body of POST
+// /calls/conversations/{conversationId}; calls are voice only by default
+type StartCallRequest struct {
+	Video bool `json:"video"`
+}
+
+func (h *Handler) StartCall(w http.ResponseWriter, r *http.Request) {
+	userID, conversationID, ok := conversationParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req StartCallRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	session, err := h.service.StartCall(r.Context(), conversationID, userID, req.Video)
+	if err != nil {
+		respondError(w, err, "Failed to start call")
+		return
+	}
+
+	utils.RespondCreated(w, session)
+}
+
+func (h *Handler) GetActiveCall(w http.ResponseWriter, r *http.Request) {
+	userID, conversationID, ok := conversationParams(w, r)
+	if !ok {
+		return
+	}
+
+	call, err := h.service.ActiveCall(r.Context(), conversationID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to fetch call")
+		return
+	}
+	if call == nil {
+		utils.RespondError(w, http.StatusNotFound, "No call in progress")
+		return
+	}
+
+	utils.RespondSuccess(w, call)
+}
+
+// ListCalls returns the conversation's call history. Use ?before= with the
+// createdAt of the oldest call seen to page back.
+func (h *Handler) ListCalls(w http.ResponseWriter, r *http.Request) {
+	userID, conversationID, ok := conversationParams(w, r)
+	if !ok {
+		return
+	}
+
+	var before *time.Time
+	if b := r.URL.Query().Get("before"); b != "" {
+		t, err := time.Parse(time.RFC3339Nano, b)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid before timestamp")
+			return
+		}
+		before = &t
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	calls, err := h.service.ListCalls(r.Context(), conversationID, userID, before, limit)
+	if err != nil {
+		respondError(w, err, "Failed to fetch calls")
+		return
+	}
+
+	utils.RespondSuccess(w, calls)
+}
+
+func (h *Handler) GetCall(w http.ResponseWriter, r *http.Request) {
+	userID, callID, ok := callParams(w, r)
+	if !ok {
+		return
+	}
+
+	call, err := h.service.GetCall(r.Context(), callID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to fetch call")
+		return
+	}
+
+	utils.RespondSuccess(w, call)
+}
+
+func (h *Handler) AcceptCall(w http.ResponseWriter, r *http.Request) {
+	userID, callID, ok := callParams(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := h.service.AcceptCall(r.Context(), callID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to accept call")
+		return
+	}
+
+	utils.RespondSuccess(w, session)
+}
+
+func (h *Handler) DeclineCall(w http.ResponseWriter, r *http.Request) {
+	userID, callID, ok := callParams(w, r)
+	if !ok {
+		return
+	}
+
+	call, err := h.service.DeclineCall(r.Context(), callID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to decline call")
+		return
+	}
+
+	utils.RespondSuccess(w, call)
+}
+
+func (h *Handler) JoinCall(w http.ResponseWriter, r *http.Request) {
+	userID, callID, ok := callParams(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := h.service.JoinCall(r.Context(), callID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to join call")
+		return
+	}
+
+	utils.RespondSuccess(w, session)
+}
+
+func (h *Handler) LeaveCall(w http.ResponseWriter, r *http.Request) {
+	userID, callID, ok := callParams(w, r)
+	if !ok {
+		return
+	}
+
+	call, err := h.service.LeaveCall(r.Context(), callID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to leave call")
+		return
+	}
+
+	utils.RespondSuccess(w, call)
+}
+
+func conversationParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	conversationID, err := uuid.Parse(chi.URLParam(r, "conversationId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid conversation ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, conversationID, true
+}
+
+func callParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	callID, err := uuid.Parse(chi.URLParam(r, "callId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid call ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, callID, true
+}
+
+func respondError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrCallNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Call not found")
+	case errors.Is(err, ErrNoAccess):
+		utils.RespondError(w, http.StatusForbidden, "Access denied")
+	case errors.Is(err, ErrCannotCall):
+		utils.RespondErrorWithCode(w, http.StatusForbidden, "CANNOT_CALL", err.Error())
+	case errors.Is(err, ErrCallInProgress):
+		utils.RespondErrorWithCode(w, http.StatusConflict, "CALL_IN_PROGRESS", err.Error())
+	case errors.Is(err, ErrCallOver):
+		utils.RespondErrorWithCode(w, http.StatusConflict, "CALL_ENDED", err.Error())
+	case errors.Is(err, ErrNotRinging):
+		utils.RespondErrorWithCode(w, http.StatusConflict, "CALL_NOT_RINGING", err.Error())
+	case errors.Is(err, ErrNotInCall):
+		utils.RespondErrorWithCode(w, http.StatusConflict, "NOT_IN_CALL", err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
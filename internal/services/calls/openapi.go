@@ -0,0 +1,61 @@
+package calls
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	conversationID := []openapi.Param{openapi.ID("conversationId")}
+	callID := []openapi.Param{openapi.ID("callId")}
+
+	openapi.Describe((*Handler).StartCall, openapi.Operation{
+		Summary:      "Ring the other members of a DM conversation",
+		Path:         conversationID,
+		Body:         StartCallRequest{},
+		BodyOptional: true,
+		Response:     Session{},
+		Status:       http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetActiveCall, openapi.Operation{
+		Summary:  "Get a conversation's call in progress",
+		Path:     conversationID,
+		Response: Call{},
+	})
+	openapi.Describe((*Handler).ListCalls, openapi.Operation{
+		Summary: "List a conversation's calls, newest first",
+		Path:    conversationID,
+		Query: []openapi.Param{
+			{Name: "before", Format: "date-time", Description: "createdAt of the oldest call seen, to page back"},
+			{Name: "limit", Type: "integer", Description: "At most this many, up to 50"},
+		},
+		Response: []*Call{},
+	})
+
+	openapi.Describe((*Handler).GetCall, openapi.Operation{
+		Summary:  "Get a call",
+		Path:     callID,
+		Response: Call{},
+	})
+	openapi.Describe((*Handler).AcceptCall, openapi.Operation{
+		Summary:  "Answer a call",
+		Path:     callID,
+		Response: Session{},
+	})
+	openapi.Describe((*Handler).DeclineCall, openapi.Operation{
+		Summary:  "Decline a call",
+		Path:     callID,
+		Response: Call{},
+	})
+	openapi.Describe((*Handler).JoinCall, openapi.Operation{
+		Summary:  "Join or rejoin a call in progress",
+		Path:     callID,
+		Response: Session{},
+	})
+	openapi.Describe((*Handler).LeaveCall, openapi.Operation{
+		Summary:  "Leave a call",
+		Path:     callID,
+		Response: Call{},
+	})
+}
@@ -0,0 +1,712 @@
+package calls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/notification"
+	"github.com/zentra/server/internal/services/user"
+	"github.com/zentra/server/internal/services/voice"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Calls in DM conversations. Whoever starts one joins it straight away and
+// the other participants' clients ring; each can accept or decline, and
+// anyone still ringing when RingTimeout runs out has missed it. Media goes
+// through the voice service's SFU in a room per call, or peer to peer with
+// VOICE_SIGNAL when there isn't one.
+
+// Events sent to each participant's own stream, so every client rings and
+// follows the call whatever it's subscribed to
+const (
+	EventTypeCallRing   = "CALL_RING"
+	EventTypeCallUpdate = "CALL_UPDATE"
+	EventTypeCallEnd    = "CALL_END"
+)
+
+// Call statuses
+const (
+	StatusRinging  = "ringing"
+	StatusActive   = "active"
+	StatusEnded    = "ended"
+	StatusMissed   = "missed"
+	StatusDeclined = "declined"
+)
+
+// Participant states
+const (
+	StateRinging  = "ringing"
+	StateJoined   = "joined"
+	StateLeft     = "left"
+	StateDeclined = "declined"
+	StateMissed   = "missed"
+)
+
+const (
+	// How long a call rings before those who haven't answered missed it
+	RingTimeout = 45 * time.Second
+
+	ringSweepInterval = 5 * time.Second
+)
+
+var (
+	ErrCallNotFound   = errors.New("call not found")
+	ErrNoAccess       = errors.New("not a participant in this conversation")
+	ErrCallInProgress = errors.New("a call is already going in this conversation")
+	ErrCallOver       = errors.New("call has ended")
+	ErrNotRinging     = errors.New("call isn't ringing for you")
+	ErrNotInCall      = errors.New("not in this call")
+	ErrCannotCall     = errors.New("you can't call this conversation")
+)
+
+// Call is a voice or video call in a DM conversation
+type Call struct {
+	ID             uuid.UUID     `json:"id"`
+	ConversationID uuid.UUID     `json:"conversationId"`
+	InitiatorID    *uuid.UUID    `json:"initiatorId,omitempty"`
+	IsVideo        bool          `json:"isVideo"`
+	Status         string        `json:"status"`
+	CreatedAt      time.Time     `json:"createdAt"`
+	AnsweredAt     *time.Time    `json:"answeredAt,omitempty"`
+	EndedAt        *time.Time    `json:"endedAt,omitempty"`
+	Participants   []Participant `json:"participants"`
+}
+
+// Participant is how one member of the conversation took part in a call
+type Participant struct {
+	UserID   uuid.UUID  `json:"userId"`
+	State    string     `json:"state"`
+	JoinedAt *time.Time `json:"joinedAt,omitempty"`
+	LeftAt   *time.Time `json:"leftAt,omitempty"`
+}
+
+// Session is what a client needs to connect to a call it's joined: room
+// credentials with an SFU, otherwise ICE servers to reach the others
+// directly
+type Session struct {
+	Call       *Call                 `json:"call"`
+	SFU        *voice.SFUCredentials `json:"sfu,omitempty"`
+	ICEServers *voice.ICEServers     `json:"iceServers"`
+}
+
+type Service struct {
+	db                  *pgxpool.Pool
+	userService         *user.Service
+	voiceService        *voice.Service
+	notificationService *notification.Service
+}
+
+func NewService(db *pgxpool.Pool, userService *user.Service, voiceService *voice.Service) *Service {
+	return &Service{db: db, userService: userService, voiceService: voiceService}
+}
+
+// SetNotificationService enables missed call notifications
+func (s *Service) SetNotificationService(ns *notification.Service) {
+	s.notificationService = ns
+}
+
+// StartCall rings everyone else in a conversation. The caller is joined from
+// the start; any other call they're in is left.
+func (s *Service) StartCall(ctx context.Context, conversationID, userID uuid.UUID, video bool) (*Session, error) {
+	members, err := s.callableMembers(ctx, conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.leaveOtherCalls(ctx, uuid.Nil, userID)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Two participants calling each other at once get one call between them
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "dm-call:"+conversationID.String()); err != nil {
+		return nil, err
+	}
+	var inProgress bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM dm_calls WHERE conversation_id = $1 AND status IN ('ringing', 'active'))`,
+		conversationID,
+	).Scan(&inProgress)
+	if err != nil {
+		return nil, err
+	}
+	if inProgress {
+		return nil, ErrCallInProgress
+	}
+
+	callID := uuid.New()
+	_, err = tx.Exec(ctx,
+		`INSERT INTO dm_calls (id, conversation_id, initiator_id, is_video) VALUES ($1, $2, $3, $4)`,
+		callID, conversationID, userID, video,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for _, memberID := range members {
+		state, joinedAt := StateRinging, (*time.Time)(nil)
+		if memberID == userID {
+			now := time.Now()
+			state, joinedAt = StateJoined, &now
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO dm_call_participants (call_id, user_id, state, joined_at) VALUES ($1, $2, $3, $4)`,
+			callID, memberID, state, joinedAt,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	call, err := s.GetCall(ctx, callID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := map[string]interface{}{"call": call}
+	if caller, err := s.userService.GetUserByID(ctx, userID); err == nil {
+		ring["caller"] = caller
+	}
+	for _, p := range call.Participants {
+		if p.State == StateRinging {
+			s.publish(ctx, p.UserID, EventTypeCallRing, ring)
+		}
+	}
+	s.publish(ctx, userID, EventTypeCallUpdate, map[string]interface{}{"call": call})
+
+	return s.session(ctx, call, userID)
+}
+
+// AcceptCall answers a call that's ringing for userID
+func (s *Service) AcceptCall(ctx context.Context, callID, userID uuid.UUID) (*Session, error) {
+	return s.join(ctx, callID, userID, false)
+}
+
+// JoinCall lets a participant who declined, missed or left come back while
+// the call is still going. Joining a call they're already in hands out new
+// credentials, e.g. for another of their clients.
+func (s *Service) JoinCall(ctx context.Context, callID, userID uuid.UUID) (*Session, error) {
+	return s.join(ctx, callID, userID, true)
+}
+
+// join puts userID in a call that's ringing for them or, when rejoin is set,
+// that they're otherwise out of. Anyone but the caller joining answers it.
+// Every join, rejoins and new credentials included, is checked like starting
+// the call: they must still be in the conversation, and no one in it may have
+// blocked them or been blocked by them since.
+func (s *Service) join(ctx context.Context, callID, userID uuid.UUID, rejoin bool) (*Session, error) {
+	call, err := s.GetCall(ctx, callID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.callableMembers(ctx, call.ConversationID, userID); err != nil {
+		return nil, err
+	}
+
+	s.leaveOtherCalls(ctx, callID, userID)
+
+	tag, err := s.db.Exec(ctx,
+		`UPDATE dm_call_participants SET state = 'joined', joined_at = NOW(), left_at = NULL
+		WHERE call_id = $1 AND user_id = $2 AND (state = 'ringing' OR ($3 AND state <> 'joined'))
+		  AND EXISTS (SELECT 1 FROM dm_calls WHERE id = $1 AND status IN ('ringing', 'active'))`,
+		callID, userID, rejoin,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		call, err := s.GetCall(ctx, callID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if call.EndedAt != nil {
+			return nil, ErrCallOver
+		}
+		if rejoin && call.participant(userID) == StateJoined {
+			return s.session(ctx, call, userID)
+		}
+		return nil, ErrNotRinging
+	}
+	if _, err := s.db.Exec(ctx,
+		`UPDATE dm_calls SET status = 'active', answered_at = COALESCE(answered_at, NOW())
+		WHERE id = $1 AND initiator_id IS DISTINCT FROM $2`,
+		callID, userID,
+	); err != nil {
+		return nil, err
+	}
+
+	call, err = s.GetCall(ctx, callID, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.publishCall(ctx, call)
+	return s.session(ctx, call, userID)
+}
+
+// participant is userID's state in the call, or "" if they aren't part of it
+func (c *Call) participant(userID uuid.UUID) string {
+	for _, p := range c.Participants {
+		if p.UserID == userID {
+			return p.State
+		}
+	}
+	return ""
+}
+
+// DeclineCall stops a call ringing for userID. The call ends if nobody else
+// is left to answer it.
+func (s *Service) DeclineCall(ctx context.Context, callID, userID uuid.UUID) (*Call, error) {
+	tag, err := s.db.Exec(ctx,
+		`UPDATE dm_call_participants SET state = 'declined'
+		WHERE call_id = $1 AND user_id = $2 AND state = 'ringing'`,
+		callID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, s.whyNot(ctx, callID, userID, ErrNotRinging)
+	}
+	return s.settle(ctx, callID)
+}
+
+// LeaveCall takes userID out of a call they're in. A caller who hangs up
+// before anyone answers cancels the call, and those being rung miss it.
+func (s *Service) LeaveCall(ctx context.Context, callID, userID uuid.UUID) (*Call, error) {
+	var status string
+	var initiatorID *uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`UPDATE dm_call_participants p SET state = 'left', left_at = NOW()
+		FROM dm_calls c
+		WHERE c.id = p.call_id AND p.call_id = $1 AND p.user_id = $2 AND p.state = 'joined'
+		RETURNING c.status, c.initiator_id`,
+		callID, userID,
+	).Scan(&status, &initiatorID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, s.whyNot(ctx, callID, userID, ErrNotInCall)
+		}
+		return nil, err
+	}
+	s.voiceService.LeaveCallRoom(ctx, callID, userID)
+
+	if status == StatusRinging && initiatorID != nil && *initiatorID == userID {
+		if err := s.missRinging(ctx, callID); err != nil {
+			return nil, err
+		}
+	}
+	return s.settle(ctx, callID)
+}
+
+// GetCall returns a call in a conversation userID is part of
+func (s *Service) GetCall(ctx context.Context, callID, userID uuid.UUID) (*Call, error) {
+	call, err := s.getCall(ctx, callID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.canAccess(ctx, call.ConversationID, userID) {
+		return nil, ErrCallNotFound
+	}
+	return call, nil
+}
+
+// ActiveCall returns the call ringing or going in a conversation, or nil
+func (s *Service) ActiveCall(ctx context.Context, conversationID, userID uuid.UUID) (*Call, error) {
+	if !s.canAccess(ctx, conversationID, userID) {
+		return nil, ErrNoAccess
+	}
+	var callID uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`SELECT id FROM dm_calls WHERE conversation_id = $1 AND status IN ('ringing', 'active')`,
+		conversationID,
+	).Scan(&callID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return s.getCall(ctx, callID)
+}
+
+// ListCalls returns a conversation's call history, newest first
+func (s *Service) ListCalls(ctx context.Context, conversationID, userID uuid.UUID, before *time.Time, limit int) ([]*Call, error) {
+	if !s.canAccess(ctx, conversationID, userID) {
+		return nil, ErrNoAccess
+	}
+	if limit <= 0 || limit > 50 {
+		limit = 50
+	}
+	rows, err := s.db.Query(ctx,
+		`SELECT id FROM dm_calls
+		WHERE conversation_id = $1 AND ($2::timestamptz IS NULL OR created_at < $2)
+		ORDER BY created_at DESC LIMIT $3`,
+		conversationID, before, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	calls := make([]*Call, 0, len(ids))
+	for _, id := range ids {
+		call, err := s.getCall(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// Run marks participants who didn't answer in time as having missed their
+// calls. Every instance can run it; each missed participant is claimed by
+// one.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(ringSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweepRinging(ctx)
+		}
+	}
+}
+
+func (s *Service) sweepRinging(ctx context.Context) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id FROM dm_calls c
+		WHERE status IN ('ringing', 'active') AND created_at < $1
+		  AND EXISTS (SELECT 1 FROM dm_call_participants p WHERE p.call_id = c.id AND p.state = 'ringing')`,
+		time.Now().Add(-RingTimeout),
+	)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to find unanswered calls")
+		return
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, callID := range ids {
+		if err := s.missRinging(ctx, callID); err != nil {
+			log.Warn().Err(err).Str("callId", callID.String()).Msg("Failed to mark call missed")
+			continue
+		}
+		if _, err := s.settle(ctx, callID); err != nil {
+			log.Warn().Err(err).Str("callId", callID.String()).Msg("Failed to settle unanswered call")
+		}
+	}
+}
+
+// missRinging marks everyone still ringing in a call as having missed it and
+// notifies them
+func (s *Service) missRinging(ctx context.Context, callID uuid.UUID) error {
+	rows, err := s.db.Query(ctx,
+		`UPDATE dm_call_participants SET state = 'missed'
+		WHERE call_id = $1 AND state = 'ringing'
+		RETURNING user_id`,
+		callID,
+	)
+	if err != nil {
+		return err
+	}
+	var missed []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		missed = append(missed, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missed) == 0 || s.notificationService == nil {
+		return nil
+	}
+
+	call, err := s.getCall(ctx, callID)
+	if err != nil || call.InitiatorID == nil {
+		return err
+	}
+	callerName := "Someone"
+	if caller, err := s.userService.GetUserByID(ctx, *call.InitiatorID); err == nil {
+		callerName = caller.Username
+		if caller.DisplayName != nil && *caller.DisplayName != "" {
+			callerName = *caller.DisplayName
+		}
+	}
+	for _, recipientID := range missed {
+		go s.notificationService.ProcessMissedCall(notification.MissedCallContext{
+			ConversationID: call.ConversationID,
+			CallID:         call.ID,
+			RecipientID:    recipientID,
+			CallerID:       *call.InitiatorID,
+			CallerName:     callerName,
+			IsVideo:        call.IsVideo,
+		})
+	}
+	return nil
+}
+
+// settle ends a call once there's nobody to talk to: nobody still ringing
+// and, once answered, fewer than two people joined. An unanswered call ends declined when
+// everyone declined and missed otherwise. Participants hear about the call
+// either way.
+func (s *Service) settle(ctx context.Context, callID uuid.UUID) (*Call, error) {
+	_, err := s.db.Exec(ctx,
+		`WITH counts AS (
+			SELECT COUNT(*) FILTER (WHERE state = 'joined') AS joined,
+				COUNT(*) FILTER (WHERE state = 'ringing') AS ringing,
+				COUNT(*) FILTER (WHERE state = 'missed') AS missed
+			FROM dm_call_participants WHERE call_id = $1
+		)
+		UPDATE dm_calls SET
+			status = CASE
+				WHEN answered_at IS NOT NULL THEN 'ended'
+				WHEN (SELECT missed FROM counts) > 0 THEN 'missed'
+				ELSE 'declined'
+			END,
+			ended_at = NOW()
+		WHERE id = $1 AND status IN ('ringing', 'active')
+		  AND (SELECT ringing FROM counts) = 0
+		  AND (status = 'ringing' OR (SELECT joined FROM counts) < 2)`,
+		callID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	call, err := s.getCall(ctx, callID)
+	if err != nil {
+		return nil, err
+	}
+	if call.EndedAt != nil {
+		// Whoever's still connected has nobody left to talk to
+		if _, err := s.db.Exec(ctx,
+			`UPDATE dm_call_participants SET state = 'left', left_at = NOW() WHERE call_id = $1 AND state = 'joined'`,
+			callID,
+		); err != nil {
+			return nil, err
+		}
+		s.voiceService.EndCallRoom(ctx, callID)
+		if call, err = s.getCall(ctx, callID); err != nil {
+			return nil, err
+		}
+	}
+	s.publishCall(ctx, call)
+	return call, nil
+}
+
+// leaveOtherCalls hangs userID up from any call but keepCallID, as a user
+// is only in one call at a time
+func (s *Service) leaveOtherCalls(ctx context.Context, keepCallID, userID uuid.UUID) {
+	rows, err := s.db.Query(ctx,
+		`SELECT call_id FROM dm_call_participants WHERE user_id = $1 AND state = 'joined' AND call_id <> $2`,
+		userID, keepCallID,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("userId", userID.String()).Msg("Failed to find user's other calls")
+		return
+	}
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, callID := range ids {
+		if _, err := s.LeaveCall(ctx, callID, userID); err != nil && !errors.Is(err, ErrNotInCall) {
+			log.Warn().Err(err).Str("callId", callID.String()).Msg("Failed to leave previous call")
+		}
+	}
+}
+
+// callableMembers returns everyone in a conversation userID can call,
+// including them. Community announcement conversations can't be called, and
+// nor can anyone who has blocked the caller or been blocked by them.
+func (s *Service) callableMembers(ctx context.Context, conversationID, userID uuid.UUID) ([]uuid.UUID, error) {
+	var isAnnouncement bool
+	err := s.db.QueryRow(ctx,
+		`SELECT community_id IS NOT NULL FROM dm_conversations WHERE id = $1`,
+		conversationID,
+	).Scan(&isAnnouncement)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoAccess
+		}
+		return nil, err
+	}
+	if !s.canAccess(ctx, conversationID, userID) {
+		return nil, ErrNoAccess
+	}
+	if isAnnouncement {
+		return nil, ErrCannotCall
+	}
+
+	members, err := s.members(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	for _, memberID := range members {
+		if memberID == userID {
+			continue
+		}
+		for _, pair := range [][2]uuid.UUID{{userID, memberID}, {memberID, userID}} {
+			blocked, err := s.userService.IsBlocked(ctx, pair[0], pair[1])
+			if err != nil {
+				return nil, err
+			}
+			if blocked {
+				return nil, ErrCannotCall
+			}
+		}
+	}
+	if len(members) < 2 {
+		return nil, ErrCannotCall
+	}
+	return members, nil
+}
+
+func (s *Service) members(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT user_id FROM dm_participants WHERE conversation_id = $1`,
+		conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		members = append(members, id)
+	}
+	return members, rows.Err()
+}
+
+func (s *Service) canAccess(ctx context.Context, conversationID, userID uuid.UUID) bool {
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM dm_participants WHERE conversation_id = $1 AND user_id = $2)`,
+		conversationID, userID,
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+// whyNot explains why userID couldn't change their part in a call: it isn't
+// theirs to see, it's over, or they weren't in the state needed
+func (s *Service) whyNot(ctx context.Context, callID, userID uuid.UUID, fallback error) error {
+	call, err := s.GetCall(ctx, callID, userID)
+	if err != nil {
+		return err
+	}
+	if call.EndedAt != nil {
+		return ErrCallOver
+	}
+	return fallback
+}
+
+func (s *Service) getCall(ctx context.Context, callID uuid.UUID) (*Call, error) {
+	var call Call
+	var participants []byte
+	err := s.db.QueryRow(ctx,
+		`SELECT c.id, c.conversation_id, c.initiator_id, c.is_video, c.status, c.created_at, c.answered_at, c.ended_at,
+			COALESCE((
+				SELECT json_agg(json_build_object(
+					'userId', p.user_id, 'state', p.state, 'joinedAt', p.joined_at, 'leftAt', p.left_at
+				) ORDER BY p.joined_at NULLS LAST, p.user_id)
+				FROM dm_call_participants p WHERE p.call_id = c.id
+			), '[]')
+		FROM dm_calls c WHERE c.id = $1`,
+		callID,
+	).Scan(&call.ID, &call.ConversationID, &call.InitiatorID, &call.IsVideo, &call.Status,
+		&call.CreatedAt, &call.AnsweredAt, &call.EndedAt, &participants)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCallNotFound
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(participants, &call.Participants); err != nil {
+		return nil, err
+	}
+	return &call, nil
+}
+
+func (s *Service) session(ctx context.Context, call *Call, userID uuid.UUID) (*Session, error) {
+	sfu, err := s.voiceService.CallCredentials(ctx, call.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{Call: call, SFU: sfu, ICEServers: s.voiceService.ICEServers(userID)}, nil
+}
+
+// publishCall tells every participant about a call's new state
+func (s *Service) publishCall(ctx context.Context, call *Call) {
+	eventType := EventTypeCallUpdate
+	if call.EndedAt != nil {
+		eventType = EventTypeCallEnd
+	}
+	for _, p := range call.Participants {
+		s.publish(ctx, p.UserID, eventType, map[string]interface{}{"call": call})
+	}
+}
+
+func (s *Service) publish(ctx context.Context, userID uuid.UUID, eventType string, data interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"channelId": database.UserStream(userID.String()),
+		"event": map[string]interface{}{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", eventType).Msg("Failed to marshal call event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Str("event", eventType).Msg("Failed to publish call event")
+	}
+}
@@ -0,0 +1,72 @@
+package camo
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Routes are loaded by <img> tags, which can't send a token; only URLs the
+// server signed are fetched
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/{sig}/{url}", h.Image)
+	return r
+}
+
+// Image streams an external image to the client
+func (h *Handler) Image(w http.ResponseWriter, r *http.Request) {
+	img, err := h.service.Fetch(r.Context(), chi.URLParam(r, "sig"), chi.URLParam(r, "url"), r.Header.Get("If-None-Match"))
+	if errors.Is(err, errUpstreamNotModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if err != nil {
+		respondCamoError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(img.Data)))
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	if img.ETag != "" {
+		w.Header().Set("ETag", img.ETag)
+	}
+	if img.LastModified != "" {
+		w.Header().Set("Last-Modified", img.LastModified)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(img.Data)
+}
+
+func respondCamoError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotConfigured):
+		utils.RespondError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, ErrInvalidSignature):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrBlockedURL):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrTooLarge):
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, ErrUnsupportedType):
+		utils.RespondError(w, http.StatusUnsupportedMediaType, err.Error())
+	case errors.Is(err, ErrUpstreamFailed):
+		utils.RespondError(w, http.StatusBadGateway, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch image")
+	}
+}
@@ -0,0 +1,170 @@
+package camo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+const (
+	fetchTimeout = 10 * time.Second
+	maxRedirects = 4
+	// Proxy links are signed for one URL and the image behind it rarely
+	// changes
+	cacheControl = "public, max-age=86400"
+)
+
+var (
+	ErrNotConfigured       = errors.New("image proxy is not configured")
+	ErrInvalidSignature    = errors.New("invalid image proxy link")
+	ErrBlockedURL          = errors.New("image URL is not allowed")
+	ErrTooLarge            = errors.New("image is too large")
+	ErrUnsupportedType     = errors.New("not a supported image")
+	ErrUpstreamFailed      = errors.New("image could not be fetched")
+	errUpstreamNotModified = errors.New("image not modified")
+)
+
+// Image types the proxy passes through. SVG is left out since it can carry
+// scripts.
+var allowedTypes = map[string]bool{
+	"image/png":                true,
+	"image/jpeg":               true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"image/avif":               true,
+	"image/bmp":                true,
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// Image is an external image fetched through the proxy
+type Image struct {
+	Data         []byte
+	ContentType  string
+	ETag         string
+	LastModified string
+}
+
+type Service struct {
+	camo    *messaging.Camo
+	client  *http.Client
+	maxSize int64
+}
+
+// NewService builds the image proxy. A nil camo leaves it disabled.
+func NewService(camo *messaging.Camo, maxSize int64) *Service {
+	return &Service{
+		camo:    camo,
+		maxSize: maxSize,
+		client: &http.Client{
+			Timeout:   fetchTimeout,
+			Transport: messaging.PublicTransport(),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				return checkURL(req.Context(), req.URL)
+			},
+		},
+	}
+}
+
+// Fetch loads the image a proxy link was signed for. The image must be one of
+// the allowed types both by its Content-Type and by its contents, and no
+// larger than the size limit. etag is the client's If-None-Match, passed on so
+// an unchanged image isn't downloaded again.
+func (s *Service) Fetch(ctx context.Context, sig, encodedURL, etag string) (*Image, error) {
+	if s.camo == nil {
+		return nil, ErrNotConfigured
+	}
+	rawURL, err := s.camo.Verify(sig, encodedURL)
+	if err != nil {
+		return nil, ErrInvalidSignature
+	}
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, ErrBlockedURL
+	}
+	if err := checkURL(ctx, target); err != nil {
+		return nil, ErrBlockedURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, ErrBlockedURL
+	}
+	req.Header.Set("User-Agent", "ZentraImageProxy/1.0")
+	req.Header.Set("Accept", "image/avif,image/webp,image/png,image/jpeg,image/gif,image/*;q=0.8")
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, ErrUpstreamFailed
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, errUpstreamNotModified
+	case resp.StatusCode != http.StatusOK:
+		return nil, ErrUpstreamFailed
+	}
+	if resp.ContentLength > s.maxSize {
+		return nil, ErrTooLarge
+	}
+	declared, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !allowedTypes[declared] {
+		return nil, ErrUnsupportedType
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.maxSize+1))
+	if err != nil {
+		return nil, ErrUpstreamFailed
+	}
+	if int64(len(data)) > s.maxSize {
+		return nil, ErrTooLarge
+	}
+	contentType, ok := imageType(declared, data)
+	if !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	return &Image{
+		Data:         data,
+		ContentType:  contentType,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// checkURL allows plain http and https URLs to public hosts
+func checkURL(ctx context.Context, u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return ErrBlockedURL
+	}
+	return messaging.ValidatePublicHost(ctx, u.Hostname())
+}
+
+// imageType returns the type an image is served as, taken from its contents
+// so a page or script labelled as an image is refused
+func imageType(declared string, data []byte) (string, bool) {
+	sniffed := http.DetectContentType(data)
+	if allowedTypes[sniffed] {
+		return sniffed, true
+	}
+	// The standard library doesn't recognize AVIF
+	if declared == "image/avif" && len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")) &&
+		(bytes.Equal(data[8:12], []byte("avif")) || bytes.Equal(data[8:12], []byte("avis"))) {
+		return declared, true
+	}
+	return "", false
+}
@@ -0,0 +1,65 @@
+package channel
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// channelCommunitiesSize is how many channels' communities each gateway keeps
+const channelCommunitiesSize = 50000
+
+// communityLRU maps channel IDs to their community, keeping the most recently
+// used ones
+type communityLRU struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // most recently used first
+	items map[uuid.UUID]*list.Element
+}
+
+type communityLRUEntry struct {
+	channelID   uuid.UUID
+	communityID uuid.UUID
+}
+
+func newCommunityLRU(size int) *communityLRU {
+	return &communityLRU{size: size, order: list.New(), items: make(map[uuid.UUID]*list.Element)}
+}
+
+func (l *communityLRU) get(channelID uuid.UUID) (uuid.UUID, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[channelID]
+	if !ok {
+		return uuid.Nil, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*communityLRUEntry).communityID, true
+}
+
+func (l *communityLRU) put(channelID, communityID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[channelID]; ok {
+		el.Value.(*communityLRUEntry).communityID = communityID
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[channelID] = l.order.PushFront(&communityLRUEntry{channelID: channelID, communityID: communityID})
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*communityLRUEntry).channelID)
+	}
+}
+
+func (l *communityLRU) remove(channelID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if el, ok := l.items[channelID]; ok {
+		l.order.Remove(el)
+		delete(l.items, channelID)
+	}
+}
@@ -0,0 +1,72 @@
+package channel
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Realtime events for channel and category changes. The websocket hub reacts
+// to CHANNEL_UPDATE and CHANNEL_DELETE by dropping subscriptions that are no
+// longer allowed.
+const (
+	EventChannelCreate           = "CHANNEL_CREATE"
+	EventChannelUpdate           = "CHANNEL_UPDATE"
+	EventChannelDelete           = "CHANNEL_DELETE"
+	EventChannelPositionsUpdate  = "CHANNEL_POSITIONS_UPDATE"
+	EventCategoryCreate          = "CATEGORY_CREATE"
+	EventCategoryUpdate          = "CATEGORY_UPDATE"
+	EventCategoryDelete          = "CATEGORY_DELETE"
+	EventCategoryPositionsUpdate = "CATEGORY_POSITIONS_UPDATE"
+)
+
+// DeleteEvent is the payload of CHANNEL_DELETE and CATEGORY_DELETE
+type DeleteEvent struct {
+	ID          uuid.UUID `json:"id"`
+	CommunityID uuid.UUID `json:"communityId"`
+}
+
+// PositionsEvent is the payload of the *_POSITIONS_UPDATE events, IDs in their new order
+type PositionsEvent struct {
+	CommunityID uuid.UUID   `json:"communityId"`
+	IDs         []uuid.UUID `json:"ids"`
+}
+
+// broadcast publishes an event to the community's members. Events about one
+// channel pass its ID and only reach the members who can see it. Publishing
+// happens after the write has committed, so a failure only costs clients a
+// refresh.
+func (s *Service) broadcast(ctx context.Context, eventType string, communityID uuid.UUID, channelID *uuid.UUID, data interface{}) {
+	stream := database.CommunityStream(communityID.String(), "")
+	if channelID != nil {
+		stream = database.CommunityStream(communityID.String(), channelID.String())
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"channelId": stream,
+		"event": map[string]interface{}{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", eventType).Msg("Failed to marshal channel event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Str("event", eventType).Msg("Failed to publish channel event")
+	}
+}
+
+// broadcastChannel sends the channel's current state to those who can see it
+func (s *Service) broadcastChannel(ctx context.Context, eventType string, channelID uuid.UUID) {
+	channel, err := s.GetChannel(ctx, channelID)
+	if err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("Failed to load channel for event")
+		return
+	}
+	s.broadcast(ctx, eventType, channel.CommunityID, &channel.ID, channel)
+}
@@ -38,11 +38,19 @@ func (h *Handler) Routes() chi.Router {
 		r.Put("/reorder", h.ReorderCategories)
 	})
 
+	// Read state and personal recency across all the user's channels
+	r.Get("/read-states", h.GetReadStates)
+	r.Get("/unread-counts", h.GetUnreadCounts)
+
+	// Several channels at once, for clients resolving IDs they came across
+	r.Get("/batch", h.GetChannels)
+
 	// Channel-specific routes
 	r.Route("/{id}", func(r chi.Router) {
 		r.Get("/", h.GetChannel)
 		r.Patch("/", h.UpdateChannel)
 		r.Delete("/", h.DeleteChannel)
+		r.Post("/ack", h.AckChannel)
 
 		// Permissions
 		r.Get("/permissions", h.GetChannelPermissions)
@@ -73,13 +81,7 @@ func (h *Handler) CreateChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateChannelRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -130,6 +132,30 @@ func (h *Handler) GetChannel(w http.ResponseWriter, r *http.Request) {
 	utils.RespondSuccess(w, channel)
 }
 
+// GetChannels returns several channels at once: GET /channels/batch?ids=a,b,c.
+// Channels that don't exist or that the user can't see are left out.
+func (h *Handler) GetChannels(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	ids, err := utils.GetQueryIDs(r, "ids", utils.MaxBatchIDs)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	channels, err := h.service.GetChannelsForUser(r.Context(), ids, userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get channels")
+		return
+	}
+
+	utils.RespondSuccess(w, channels)
+}
+
 func (h *Handler) GetCommunityChannels(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -149,20 +175,12 @@ func (h *Handler) GetCommunityChannels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	channels, err := h.service.GetCommunityChannels(r.Context(), communityID)
+	channels, err := h.service.GetVisibleChannels(r.Context(), communityID, userID)
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to get channels")
 		return
 	}
 
-	accessible := channels[:0]
-	for _, channel := range channels {
-		if h.service.CanAccessChannel(r.Context(), channel.ID, userID) {
-			accessible = append(accessible, channel)
-		}
-	}
-	channels = accessible
-
 	utils.RespondSuccess(w, channels)
 }
 
@@ -180,8 +198,7 @@ func (h *Handler) UpdateChannel(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateChannelRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -220,6 +237,8 @@ func (h *Handler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusNotFound, "Channel not found")
 		case ErrInsufficientPerms:
 			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		case ErrPluginManaged:
+			utils.RespondError(w, http.StatusForbidden, "Channel is managed by a plugin; remove it through the plugin or as an administrator")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to delete channel")
 		}
@@ -242,11 +261,8 @@ func (h *Handler) ReorderChannels(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		ChannelIDs []uuid.UUID `json:"channelIds" validate:"required,min=1"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req ReorderChannelsRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -277,13 +293,7 @@ func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateCategoryRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -341,11 +351,8 @@ func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Name string `json:"name" validate:"required,min=1,max=64"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req UpdateCategoryRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -406,11 +413,8 @@ func (h *Handler) ReorderCategories(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		CategoryIDs []uuid.UUID `json:"categoryIds" validate:"required,min=1"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req ReorderCategoriesRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -470,13 +474,7 @@ func (h *Handler) SetChannelPermission(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req SetChannelPermissionRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -529,3 +527,76 @@ func (h *Handler) DeleteChannelPermission(w http.ResponseWriter, r *http.Request
 
 	utils.RespondNoContent(w)
 }
+
+// AckChannel marks the channel as read up to a message and as recently used
+func (h *Handler) AckChannel(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return
+	}
+
+	// The body is optional; an empty ack marks the whole channel read
+	var req AckRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	state, err := h.service.AckChannel(r.Context(), channelID, userID, req.MessageID)
+	if err != nil {
+		switch err {
+		case ErrChannelNotFound:
+			utils.RespondError(w, http.StatusNotFound, "Channel not found")
+		case ErrMessageNotFound:
+			utils.RespondError(w, http.StatusNotFound, "Message not found")
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to acknowledge channel")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, state)
+}
+
+// GetReadStates lists the user's channel read states, most recently used first
+func (h *Handler) GetReadStates(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	states, err := h.service.GetReadStates(r.Context(), userID, utils.GetQueryInt(r, "limit", 100))
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get read states")
+		return
+	}
+
+	utils.RespondSuccess(w, states)
+}
+
+// GetUnreadCounts returns unread and mention badges for every visible channel
+// and community in one call
+func (h *Handler) GetUnreadCounts(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	counts, err := h.service.GetUnreadCounts(r.Context(), userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get unread counts")
+		return
+	}
+
+	utils.RespondSuccess(w, counts)
+}
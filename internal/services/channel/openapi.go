@@ -0,0 +1,122 @@
+package channel
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+	communityID := []openapi.Param{openapi.ID("communityId")}
+
+	openapi.Describe((*Handler).GetCommunityChannels, openapi.Operation{
+		Summary:  "List the channels of a community the current user can see",
+		Path:     communityID,
+		Response: []*models.ChannelWithCategory{},
+	})
+	openapi.Describe((*Handler).CreateChannel, openapi.Operation{
+		Summary:  "Create a channel",
+		Path:     communityID,
+		Body:     CreateChannelRequest{},
+		Response: models.Channel{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).ReorderChannels, openapi.Operation{
+		Summary: "Reorder the channels of a community",
+		Path:    communityID,
+		Body:    ReorderChannelsRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetCategories, openapi.Operation{
+		Summary:  "List the channel categories of a community",
+		Path:     communityID,
+		Response: []*models.ChannelCategory{},
+	})
+	openapi.Describe((*Handler).CreateCategory, openapi.Operation{
+		Summary:  "Create a channel category",
+		Path:     communityID,
+		Body:     CreateCategoryRequest{},
+		Response: models.ChannelCategory{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).ReorderCategories, openapi.Operation{
+		Summary: "Reorder the channel categories of a community",
+		Path:    communityID,
+		Body:    ReorderCategoriesRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UpdateCategory, openapi.Operation{
+		Summary:  "Rename a channel category",
+		Path:     id,
+		Body:     UpdateCategoryRequest{},
+		Response: models.ChannelCategory{},
+	})
+	openapi.Describe((*Handler).DeleteCategory, openapi.Operation{
+		Summary: "Delete a channel category",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetReadStates, openapi.Operation{
+		Summary:  "List the current user's read states",
+		Query:    []openapi.Param{{Name: "limit", Type: "integer", Description: "At most this many, 100 by default"}},
+		Response: []*models.ChannelReadState{},
+	})
+	openapi.Describe((*Handler).GetUnreadCounts, openapi.Operation{
+		Summary:  "Count the current user's unread messages and mentions",
+		Response: models.UnreadCounts{},
+	})
+	openapi.Describe((*Handler).GetChannels, openapi.Operation{
+		Summary:     "Get several channels",
+		Description: "Channels that don't exist or that the current user can't see are left out.",
+		Query:       openapi.IDsQuery,
+		Response:    []*models.Channel{},
+	})
+
+	openapi.Describe((*Handler).GetChannel, openapi.Operation{
+		Summary:  "Get a channel",
+		Path:     id,
+		Response: models.Channel{},
+	})
+	openapi.Describe((*Handler).UpdateChannel, openapi.Operation{
+		Summary:  "Update a channel",
+		Path:     id,
+		Body:     UpdateChannelRequest{},
+		Response: models.Channel{},
+	})
+	openapi.Describe((*Handler).DeleteChannel, openapi.Operation{
+		Summary: "Delete a channel",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).AckChannel, openapi.Operation{
+		Summary:      "Mark a channel read",
+		Description:  "Without a messageId everything is marked read.",
+		Path:         id,
+		Body:         AckRequest{},
+		BodyOptional: true,
+		Response:     models.ChannelReadState{},
+	})
+	openapi.Describe((*Handler).GetChannelPermissions, openapi.Operation{
+		Summary:  "List a channel's permission overwrites",
+		Path:     id,
+		Response: []*models.ChannelPermission{},
+	})
+	openapi.Describe((*Handler).SetChannelPermission, openapi.Operation{
+		Summary: "Set a permission overwrite on a channel",
+		Path:    id,
+		Body:    SetChannelPermissionRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).DeleteChannelPermission, openapi.Operation{
+		Summary: "Remove a permission overwrite from a channel",
+		Path: []openapi.Param{
+			openapi.ID("id"),
+			{Name: "targetType", Enum: []string{"role", "member"}},
+			openapi.ID("targetId"),
+		},
+		Status: http.StatusNoContent,
+	})
+}
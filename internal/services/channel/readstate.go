@@ -0,0 +1,251 @@
+package channel
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+)
+
+var ErrMessageNotFound = errors.New("message not found")
+
+type AckRequest struct {
+	// MessageID is the newest message the user has seen; omit it to mark everything read
+	MessageID *uuid.UUID `json:"messageId"`
+}
+
+// AckChannel records that the user viewed the channel up to a message. The read
+// position never moves backwards, but the interaction time always refreshes.
+func (s *Service) AckChannel(ctx context.Context, channelID, userID uuid.UUID, messageID *uuid.UUID) (*models.ChannelReadState, error) {
+	if _, err := s.GetChannel(ctx, channelID); err != nil {
+		return nil, err
+	}
+	if !s.CanAccessChannel(ctx, channelID, userID) {
+		return nil, ErrInsufficientPerms
+	}
+
+	readAt := time.Now()
+	if messageID != nil {
+		err := s.db.QueryRow(ctx,
+			`SELECT created_at FROM messages WHERE id = $1 AND channel_id = $2`,
+			*messageID, channelID,
+		).Scan(&readAt)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, ErrMessageNotFound
+			}
+			return nil, err
+		}
+	} else {
+		// Everything read: remember the newest message so clients can jump back to it
+		var latestID uuid.UUID
+		err := s.db.QueryRow(ctx,
+			`SELECT id FROM messages WHERE channel_id = $1 AND deleted_at IS NULL
+			ORDER BY created_at DESC, id DESC LIMIT 1`,
+			channelID,
+		).Scan(&latestID)
+		if err == nil {
+			messageID = &latestID
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	if err := s.upsertReadState(ctx, channelID, userID, messageID, &readAt); err != nil {
+		return nil, err
+	}
+	return s.getReadState(ctx, channelID, userID)
+}
+
+// RecordInteraction marks the channel as just used by userID, e.g. after sending a
+// message. Sending also counts as reading up to that message.
+func (s *Service) RecordInteraction(ctx context.Context, channelID, userID uuid.UUID, messageID *uuid.UUID) error {
+	var readAt *time.Time
+	if messageID != nil {
+		now := time.Now()
+		readAt = &now
+	}
+	return s.upsertReadState(ctx, channelID, userID, messageID, readAt)
+}
+
+// GetReadStates returns the user's channel read states, most recently used first
+func (s *Service) GetReadStates(ctx context.Context, userID uuid.UUID, limit int) ([]*models.ChannelReadState, error) {
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT rs.channel_id, c.community_id, rs.last_read_message_id, rs.last_read_at, rs.last_interaction_at
+		FROM channel_read_states rs
+		JOIN channels c ON c.id = rs.channel_id
+		JOIN community_members m ON m.community_id = c.community_id AND m.user_id = rs.user_id
+		WHERE rs.user_id = $1
+		ORDER BY rs.last_interaction_at DESC
+		LIMIT $2`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make([]*models.ChannelReadState, 0)
+	for rows.Next() {
+		state := &models.ChannelReadState{}
+		if err := rows.Scan(&state.ChannelID, &state.CommunityID, &state.LastReadMessageID, &state.LastReadAt, &state.LastInteractionAt); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// maxUnreadCount is where a channel's unread count stops counting; clients
+// show it as "99+" or similar, and capping keeps busy channels cheap
+const maxUnreadCount = 100
+
+// GetUnreadCounts returns unread message and mention counts for every channel
+// the user can see in every community they're in. A channel without a read
+// state counts from when the user joined the community; the user's own
+// messages never count.
+func (s *Service) GetUnreadCounts(ctx context.Context, userID uuid.UUID) (*models.UnreadCounts, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT community_id FROM community_members WHERE user_id = $1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var communityIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		communityIDs = append(communityIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	channelIDs := make([]uuid.UUID, 0)
+	for _, communityID := range communityIDs {
+		visible, _, err := s.VisibleChannels(ctx, communityID, userID)
+		if err != nil {
+			return nil, err
+		}
+		channelIDs = append(channelIDs, visible...)
+	}
+
+	counts := &models.UnreadCounts{
+		Channels:    make([]*models.ChannelUnreadCount, 0),
+		Communities: make([]*models.CommunityUnreadCount, 0),
+	}
+	if len(channelIDs) == 0 {
+		return counts, nil
+	}
+
+	// Mentions are stored once per message, so role mentions are matched
+	// against the user's roles here rather than per recipient
+	rows, err = s.db.Query(ctx,
+		`SELECT c.id, c.community_id, unread.count, mentions.count
+		FROM channels c
+		JOIN community_members cm ON cm.community_id = c.community_id AND cm.user_id = $1
+		LEFT JOIN channel_read_states rs ON rs.channel_id = c.id AND rs.user_id = $1
+		CROSS JOIN LATERAL (
+			SELECT COUNT(*) AS count FROM (
+				SELECT 1 FROM messages m
+				WHERE m.channel_id = c.id
+				AND m.created_at > COALESCE(rs.last_read_at, cm.joined_at)
+				AND m.deleted_at IS NULL
+				AND m.author_id <> $1
+				LIMIT $3
+			) capped
+		) unread
+		CROSS JOIN LATERAL (
+			SELECT COUNT(DISTINCT mm.message_id) AS count
+			FROM message_mentions mm
+			JOIN messages m ON m.id = mm.message_id AND m.created_at = mm.message_created_at
+			WHERE mm.channel_id = c.id
+			AND mm.message_created_at > COALESCE(rs.last_read_at, cm.joined_at)
+			AND mm.author_id <> $1
+			AND m.deleted_at IS NULL
+			AND (
+				mm.mentioned_user_id = $1
+				OR mm.mention_type IN ('everyone', 'here')
+				OR (mm.mention_type = 'role' AND mm.mentioned_role_id IN (
+					SELECT role_id FROM member_roles WHERE member_id = cm.id
+				))
+			)
+		) mentions
+		WHERE c.id = ANY($2)
+		ORDER BY c.community_id, c.position`,
+		userID, channelIDs, maxUnreadCount,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	communities := make(map[uuid.UUID]*models.CommunityUnreadCount)
+	for rows.Next() {
+		c := &models.ChannelUnreadCount{}
+		if err := rows.Scan(&c.ChannelID, &c.CommunityID, &c.UnreadCount, &c.MentionCount); err != nil {
+			return nil, err
+		}
+		if c.UnreadCount == 0 && c.MentionCount == 0 {
+			continue
+		}
+		counts.Channels = append(counts.Channels, c)
+
+		community, ok := communities[c.CommunityID]
+		if !ok {
+			community = &models.CommunityUnreadCount{CommunityID: c.CommunityID}
+			communities[c.CommunityID] = community
+			counts.Communities = append(counts.Communities, community)
+		}
+		community.UnreadCount += c.UnreadCount
+		community.MentionCount += c.MentionCount
+	}
+	return counts, rows.Err()
+}
+
+func (s *Service) getReadState(ctx context.Context, channelID, userID uuid.UUID) (*models.ChannelReadState, error) {
+	state := &models.ChannelReadState{}
+	err := s.db.QueryRow(ctx,
+		`SELECT rs.channel_id, c.community_id, rs.last_read_message_id, rs.last_read_at, rs.last_interaction_at
+		FROM channel_read_states rs
+		JOIN channels c ON c.id = rs.channel_id
+		WHERE rs.channel_id = $1 AND rs.user_id = $2`,
+		channelID, userID,
+	).Scan(&state.ChannelID, &state.CommunityID, &state.LastReadMessageID, &state.LastReadAt, &state.LastInteractionAt)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// upsertReadState refreshes last_interaction_at and, when readAt is set, moves the
+// read position forward (an older ack never rewinds it).
+func (s *Service) upsertReadState(ctx context.Context, channelID, userID uuid.UUID, messageID *uuid.UUID, readAt *time.Time) error {
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO channel_read_states (user_id, channel_id, last_read_message_id, last_read_at, last_interaction_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id, channel_id) DO UPDATE SET
+			last_interaction_at = NOW(),
+			last_read_message_id = CASE
+				WHEN EXCLUDED.last_read_at IS NOT NULL
+					AND (channel_read_states.last_read_at IS NULL OR EXCLUDED.last_read_at >= channel_read_states.last_read_at)
+				THEN EXCLUDED.last_read_message_id
+				ELSE channel_read_states.last_read_message_id
+			END,
+			last_read_at = GREATEST(channel_read_states.last_read_at, EXCLUDED.last_read_at)`,
+		userID, channelID, messageID, readAt,
+	)
+	return err
+}
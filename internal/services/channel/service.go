@@ -12,6 +12,7 @@ import (
 	"github.com/zentra/server/internal/models"
 	"github.com/zentra/server/internal/services/channeltype"
 	"github.com/zentra/server/internal/services/community"
+	"github.com/zentra/server/internal/services/permcache"
 	"github.com/zentra/server/pkg/database"
 )
 
@@ -20,12 +21,17 @@ var (
 	ErrCategoryNotFound   = errors.New("category not found")
 	ErrInsufficientPerms  = errors.New("insufficient permissions")
 	ErrInvalidChannelType = errors.New("invalid channel type")
+	ErrPluginManaged      = errors.New("channel is managed by a plugin")
 )
 
 type Service struct {
 	db               *pgxpool.Pool
 	communityService *community.Service
 	typeRegistry     *channeltype.Registry
+	permissions      *permcache.Cache
+	// channelCommunities maps channel IDs to their community. A channel never
+	// moves, so entries only go stale by the channel being deleted.
+	channelCommunities *communityLRU
 }
 
 func NewService(db *pgxpool.Pool, communityService *community.Service, typeRegistry *channeltype.Registry) *Service {
@@ -33,9 +39,17 @@ func NewService(db *pgxpool.Pool, communityService *community.Service, typeRegis
 		db:               db,
 		communityService: communityService,
 		typeRegistry:     typeRegistry,
+
+		channelCommunities: newCommunityLRU(channelCommunitiesSize),
 	}
 }
 
+// SetPermissionCache caches resolved channel permissions in c. Without one
+// every check is computed from the database.
+func (s *Service) SetPermissionCache(c *permcache.Cache) {
+	s.permissions = c
+}
+
 type CreateChannelRequest struct {
 	Name            string          `json:"name" validate:"required,channelname"`
 	Topic           *string         `json:"topic" validate:"omitempty,max=1024"`
@@ -43,15 +57,56 @@ type CreateChannelRequest struct {
 	CategoryID      *uuid.UUID      `json:"categoryId"`
 	IsNSFW          bool            `json:"isNsfw"`
 	SlowmodeSeconds int             `json:"slowmodeSeconds" validate:"min=0,max=21600"`
+	UserLimit       int             `json:"userLimit" validate:"min=0,max=99"`
 	Metadata        json.RawMessage `json:"metadata"`
 }
 
 func (s *Service) CreateChannel(ctx context.Context, communityID, userID uuid.UUID, req *CreateChannelRequest) (*models.Channel, error) {
-	// Check permissions
 	if err := s.requireChannelPermission(ctx, communityID, userID, models.PermissionManageChannels); err != nil {
 		return nil, err
 	}
+	return s.createChannel(ctx, communityID, userID, nil, req)
+}
+
+// CreateManagedChannel creates a channel on behalf of a plugin. The user still
+// needs ManageChannels; the channel is marked as managed by the plugin.
+func (s *Service) CreateManagedChannel(ctx context.Context, communityID, pluginID, userID uuid.UUID, req *CreateChannelRequest) (*models.Channel, error) {
+	if err := s.requireChannelPermission(ctx, communityID, userID, models.PermissionManageChannels); err != nil {
+		return nil, err
+	}
+	return s.createChannel(ctx, communityID, userID, &pluginID, req)
+}
+
+// SpawnManagedChannel creates a plugin-managed channel as part of a built-in
+// plugin feature any member can use, such as a game lobby's voice channel.
+// The user needs ManageChannels, or the plugin must be enabled in the
+// community with the manage channels grant and the user must be a member.
+func (s *Service) SpawnManagedChannel(ctx context.Context, communityID, pluginID, userID uuid.UUID, req *CreateChannelRequest) (*models.Channel, error) {
+	if err := s.requireChannelPermission(ctx, communityID, userID, models.PermissionManageChannels); err != nil {
+		if !s.pluginMayManageChannels(ctx, communityID, pluginID) || !s.communityService.IsMember(ctx, communityID, userID) {
+			return nil, err
+		}
+	}
+	return s.createChannel(ctx, communityID, userID, &pluginID, req)
+}
+
+// pluginMayManageChannels reports whether a plugin is enabled in a community
+// and was granted the manage channels permission there
+func (s *Service) pluginMayManageChannels(ctx context.Context, communityID, pluginID uuid.UUID) bool {
+	var granted int64
+	err := s.db.QueryRow(ctx,
+		`SELECT granted_permissions FROM community_plugins
+		WHERE community_id = $1 AND plugin_id = $2 AND enabled = TRUE`,
+		communityID, pluginID,
+	).Scan(&granted)
+	if err != nil {
+		return false
+	}
+	install := &models.CommunityPlugin{GrantedPermissions: granted}
+	return install.HasPermission(models.PluginPermManageChannels)
+}
 
+func (s *Service) createChannel(ctx context.Context, communityID, userID uuid.UUID, pluginID *uuid.UUID, req *CreateChannelRequest) (*models.Channel, error) {
 	// Validate that the requested type actually exists in the registry
 	typeDef, err := s.typeRegistry.Get(req.Type)
 	if err != nil {
@@ -84,24 +139,32 @@ func (s *Service) CreateChannel(ctx context.Context, communityID, userID uuid.UU
 		Position:        maxPos + 1,
 		IsNSFW:          req.IsNSFW,
 		SlowmodeSeconds: req.SlowmodeSeconds,
+		UserLimit:       req.UserLimit,
 		Metadata:        metadata,
+		ManagedByPlugin: pluginID,
 		CreatedAt:       time.Now(),
 		UpdatedAt:       time.Now(),
 	}
 
 	_, err = s.db.Exec(ctx,
-		`INSERT INTO channels (id, community_id, category_id, name, topic, type, position, is_nsfw, slowmode_seconds, metadata, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		`INSERT INTO channels (id, community_id, category_id, name, topic, type, position, is_nsfw, slowmode_seconds, user_limit, metadata, managed_by_plugin, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
 		channel.ID, channel.CommunityID, channel.CategoryID, channel.Name, channel.Topic,
-		channel.Type, channel.Position, channel.IsNSFW, channel.SlowmodeSeconds, channel.Metadata,
-		channel.CreatedAt, channel.UpdatedAt,
+		channel.Type, channel.Position, channel.IsNSFW, channel.SlowmodeSeconds, channel.UserLimit, channel.Metadata,
+		channel.ManagedByPlugin, channel.CreatedAt, channel.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
-	details, _ := json.Marshal(map[string]string{"name": channel.Name, "type": string(channel.Type)})
+	auditDetails := map[string]string{"name": channel.Name, "type": string(channel.Type)}
+	if pluginID != nil {
+		auditDetails["plugin"] = pluginID.String()
+	}
+	details, _ := json.Marshal(auditDetails)
 	s.communityService.LogAudit(ctx, &communityID, userID, models.AuditActionChannelCreate, "channel", &channel.ID, details)
+	s.permissions.InvalidateCommunity(ctx, communityID)
+	s.broadcast(ctx, EventChannelCreate, communityID, &channel.ID, channel)
 
 	return channel, nil
 }
@@ -109,13 +172,14 @@ func (s *Service) CreateChannel(ctx context.Context, communityID, userID uuid.UU
 func (s *Service) GetChannel(ctx context.Context, id uuid.UUID) (*models.Channel, error) {
 	channel := &models.Channel{}
 	err := s.db.QueryRow(ctx,
-		`SELECT id, community_id, category_id, name, topic, type, position, is_nsfw, slowmode_seconds, metadata, created_at, updated_at
+		`SELECT id, community_id, category_id, name, topic, type, position, is_nsfw, slowmode_seconds, user_limit, metadata,
+		managed_by_plugin, archived_at, created_at, updated_at
 		FROM channels WHERE id = $1`,
 		id,
 	).Scan(
 		&channel.ID, &channel.CommunityID, &channel.CategoryID, &channel.Name, &channel.Topic,
-		&channel.Type, &channel.Position, &channel.IsNSFW, &channel.SlowmodeSeconds, &channel.Metadata,
-		&channel.CreatedAt, &channel.UpdatedAt,
+		&channel.Type, &channel.Position, &channel.IsNSFW, &channel.SlowmodeSeconds, &channel.UserLimit, &channel.Metadata,
+		&channel.ManagedByPlugin, &channel.ArchivedAt, &channel.CreatedAt, &channel.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -126,10 +190,72 @@ func (s *Service) GetChannel(ctx context.Context, id uuid.UUID) (*models.Channel
 	return channel, nil
 }
 
+// GetChannelsForUser returns the channels among ids the user can see, in no
+// particular order. Missing channels and ones the user can't see are left
+// out. Permissions are looked up once per community.
+func (s *Service) GetChannelsForUser(ctx context.Context, ids []uuid.UUID, userID uuid.UUID) ([]*models.Channel, error) {
+	if len(ids) == 0 {
+		return []*models.Channel{}, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT id, community_id, category_id, name, topic, type, position, is_nsfw, slowmode_seconds, user_limit, metadata,
+		managed_by_plugin, archived_at, created_at, updated_at
+		FROM channels WHERE id = ANY($1)`,
+		ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found []*models.Channel
+	for rows.Next() {
+		channel := &models.Channel{}
+		err := rows.Scan(
+			&channel.ID, &channel.CommunityID, &channel.CategoryID, &channel.Name, &channel.Topic,
+			&channel.Type, &channel.Position, &channel.IsNSFW, &channel.SlowmodeSeconds, &channel.UserLimit, &channel.Metadata,
+			&channel.ManagedByPlugin, &channel.ArchivedAt, &channel.CreatedAt, &channel.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, channel)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	channels := []*models.Channel{}
+	entries := make(map[uuid.UUID]*permcache.Entry)
+	for _, c := range found {
+		entry, ok := entries[c.CommunityID]
+		if !ok {
+			var err error
+			entry, err = s.memberPermissions(ctx, c.CommunityID, userID)
+			switch {
+			case errors.Is(err, community.ErrNotMember), errors.Is(err, community.ErrCommunityNotFound):
+				// Nothing in this community is visible
+			case err != nil:
+				return nil, err
+			}
+			entries[c.CommunityID] = entry
+		}
+		if entry == nil {
+			continue
+		}
+		if models.HasPermission(s.entryPermissions(ctx, entry, c.ID, userID), models.PermissionViewChannels) {
+			channels = append(channels, c)
+		}
+	}
+	return channels, nil
+}
+
 func (s *Service) GetCommunityChannels(ctx context.Context, communityID uuid.UUID) ([]*models.ChannelWithCategory, error) {
 	rows, err := s.db.Query(ctx,
 		`SELECT c.id, c.community_id, c.category_id, c.name, c.topic, c.type, c.position, 
-		c.is_nsfw, c.slowmode_seconds, c.metadata, c.created_at, c.updated_at, cat.name as category_name
+		c.is_nsfw, c.slowmode_seconds, c.user_limit, c.metadata, c.managed_by_plugin, c.archived_at, c.created_at, c.updated_at,
+		cat.name as category_name
 		FROM channels c
 		LEFT JOIN channel_categories cat ON cat.id = c.category_id
 		WHERE c.community_id = $1
@@ -146,7 +272,8 @@ func (s *Service) GetCommunityChannels(ctx context.Context, communityID uuid.UUI
 		c := &models.ChannelWithCategory{}
 		err := rows.Scan(
 			&c.ID, &c.CommunityID, &c.CategoryID, &c.Name, &c.Topic, &c.Type,
-			&c.Position, &c.IsNSFW, &c.SlowmodeSeconds, &c.Metadata, &c.CreatedAt, &c.UpdatedAt, &c.CategoryName,
+			&c.Position, &c.IsNSFW, &c.SlowmodeSeconds, &c.UserLimit, &c.Metadata, &c.ManagedByPlugin, &c.ArchivedAt,
+			&c.CreatedAt, &c.UpdatedAt, &c.CategoryName,
 		)
 		if err != nil {
 			return nil, err
@@ -163,6 +290,9 @@ type UpdateChannelRequest struct {
 	CategoryID      *uuid.UUID `json:"categoryId"`
 	IsNSFW          *bool      `json:"isNsfw"`
 	SlowmodeSeconds *int       `json:"slowmodeSeconds" validate:"omitempty,min=0,max=21600"`
+	UserLimit       *int       `json:"userLimit" validate:"omitempty,min=0,max=99"`
+	// Archived archives or restores the channel. Administrators only.
+	Archived *bool `json:"archived"`
 }
 
 func (s *Service) UpdateChannel(ctx context.Context, channelID, userID uuid.UUID, req *UpdateChannelRequest) (*models.Channel, error) {
@@ -174,6 +304,11 @@ func (s *Service) UpdateChannel(ctx context.Context, channelID, userID uuid.UUID
 	if err := s.requireChannelPermission(ctx, channel.CommunityID, userID, models.PermissionManageChannels); err != nil {
 		return nil, err
 	}
+	if req.Archived != nil {
+		if err := s.requireChannelPermission(ctx, channel.CommunityID, userID, models.PermissionAdministrator); err != nil {
+			return nil, err
+		}
+	}
 
 	_, err = s.db.Exec(ctx,
 		`UPDATE channels SET 
@@ -182,9 +317,12 @@ func (s *Service) UpdateChannel(ctx context.Context, channelID, userID uuid.UUID
 			category_id = COALESCE($4, category_id),
 			is_nsfw = COALESCE($5, is_nsfw),
 			slowmode_seconds = COALESCE($6, slowmode_seconds),
+			archived_at = CASE WHEN $7::boolean IS NULL THEN archived_at
+				WHEN $7 THEN COALESCE(archived_at, NOW()) ELSE NULL END,
+			user_limit = COALESCE($8, user_limit),
 			updated_at = NOW()
 		WHERE id = $1`,
-		channelID, req.Name, req.Topic, req.CategoryID, req.IsNSFW, req.SlowmodeSeconds,
+		channelID, req.Name, req.Topic, req.CategoryID, req.IsNSFW, req.SlowmodeSeconds, req.Archived, req.UserLimit,
 	)
 	if err != nil {
 		return nil, err
@@ -197,12 +335,27 @@ func (s *Service) UpdateChannel(ctx context.Context, channelID, userID uuid.UUID
 	if req.Topic != nil {
 		changes["topic"] = *req.Topic
 	}
+	if req.Archived != nil {
+		changes["archived"] = *req.Archived
+	}
+	if req.UserLimit != nil {
+		changes["userLimit"] = *req.UserLimit
+	}
 	if len(changes) > 0 {
 		details, _ := json.Marshal(changes)
 		s.communityService.LogAudit(ctx, &channel.CommunityID, userID, models.AuditActionChannelUpdate, "channel", &channelID, details)
 	}
+	if req.Archived != nil {
+		s.permissions.InvalidateCommunity(ctx, channel.CommunityID)
+	}
 
-	return s.GetChannel(ctx, channelID)
+	updated, err := s.GetChannel(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	s.broadcast(ctx, EventChannelUpdate, updated.CommunityID, &updated.ID, updated)
+
+	return updated, nil
 }
 
 func (s *Service) DeleteChannel(ctx context.Context, channelID, userID uuid.UUID) error {
@@ -214,22 +367,149 @@ func (s *Service) DeleteChannel(ctx context.Context, channelID, userID uuid.UUID
 	if err := s.requireChannelPermission(ctx, channel.CommunityID, userID, models.PermissionManageChannels); err != nil {
 		return err
 	}
+	// Plugin-managed channels are removed through the plugin, or by an administrator
+	if channel.ManagedByPlugin != nil {
+		if err := s.requireChannelPermission(ctx, channel.CommunityID, userID, models.PermissionAdministrator); err != nil {
+			return ErrPluginManaged
+		}
+	}
+
+	return s.deleteChannel(ctx, channel, userID)
+}
+
+// DeleteManagedChannel deletes a channel through the plugin that manages it
+func (s *Service) DeleteManagedChannel(ctx context.Context, communityID, pluginID, channelID, userID uuid.UUID) error {
+	channel, err := s.GetChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	if channel.CommunityID != communityID || channel.ManagedByPlugin == nil || *channel.ManagedByPlugin != pluginID {
+		return ErrChannelNotFound
+	}
+
+	if err := s.requireChannelPermission(ctx, communityID, userID, models.PermissionManageChannels); err != nil {
+		return err
+	}
+
+	return s.deleteChannel(ctx, channel, userID)
+}
 
+// RemoveSpawnedChannel deletes a channel made by SpawnManagedChannel once the
+// feature that owns it is done with it
+func (s *Service) RemoveSpawnedChannel(ctx context.Context, pluginID, channelID, userID uuid.UUID) error {
+	channel, err := s.GetChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	if channel.ManagedByPlugin == nil || *channel.ManagedByPlugin != pluginID {
+		return ErrChannelNotFound
+	}
+
+	return s.deleteChannel(ctx, channel, userID)
+}
+
+func (s *Service) deleteChannel(ctx context.Context, channel *models.Channel, userID uuid.UUID) error {
 	details, _ := json.Marshal(map[string]string{"name": channel.Name})
 
-	_, err = s.db.Exec(ctx, `DELETE FROM channels WHERE id = $1`, channelID)
+	_, err := s.db.Exec(ctx, `DELETE FROM channels WHERE id = $1`, channel.ID)
 	if err == nil {
-		s.communityService.LogAudit(ctx, &channel.CommunityID, userID, models.AuditActionChannelDelete, "channel", &channelID, details)
+		s.communityService.LogAudit(ctx, &channel.CommunityID, userID, models.AuditActionChannelDelete, "channel", &channel.ID, details)
+		s.channelCommunities.remove(channel.ID)
+		s.permissions.InvalidateCommunity(ctx, channel.CommunityID)
+		s.broadcast(ctx, EventChannelDelete, channel.CommunityID, nil, DeleteEvent{ID: channel.ID, CommunityID: channel.CommunityID})
 	}
 	return err
 }
 
+// GetManagedChannels returns the channels a plugin manages in a community
+func (s *Service) GetManagedChannels(ctx context.Context, communityID, pluginID uuid.UUID) ([]*models.Channel, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, community_id, category_id, name, topic, type, position, is_nsfw, slowmode_seconds, user_limit, metadata,
+		managed_by_plugin, archived_at, created_at, updated_at
+		FROM channels WHERE community_id = $1 AND managed_by_plugin = $2
+		ORDER BY position`,
+		communityID, pluginID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []*models.Channel{}
+	for rows.Next() {
+		c := &models.Channel{}
+		if err := rows.Scan(
+			&c.ID, &c.CommunityID, &c.CategoryID, &c.Name, &c.Topic,
+			&c.Type, &c.Position, &c.IsNSFW, &c.SlowmodeSeconds, &c.UserLimit, &c.Metadata,
+			&c.ManagedByPlugin, &c.ArchivedAt, &c.CreatedAt, &c.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		channels = append(channels, c)
+	}
+	return channels, rows.Err()
+}
+
+// ReleasePluginChannels cleans up after a plugin is uninstalled. Its channels
+// are deleted, or archived and handed back to the community's administrators.
+func (s *Service) ReleasePluginChannels(ctx context.Context, communityID, pluginID, actorID uuid.UUID, remove bool) (int64, error) {
+	query := `UPDATE channels SET managed_by_plugin = NULL, archived_at = COALESCE(archived_at, NOW()), updated_at = NOW()
+		WHERE community_id = $1 AND managed_by_plugin = $2
+		RETURNING id, name`
+	action := models.AuditActionChannelUpdate
+	if remove {
+		query = `DELETE FROM channels WHERE community_id = $1 AND managed_by_plugin = $2 RETURNING id, name`
+		action = models.AuditActionChannelDelete
+	}
+
+	type released struct {
+		id   uuid.UUID
+		name string
+	}
+	var channels []released
+	rows, err := s.db.Query(ctx, query, communityID, pluginID)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var c released
+		if err := rows.Scan(&c.id, &c.name); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		channels = append(channels, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(channels) > 0 {
+		s.permissions.InvalidateCommunity(ctx, communityID)
+	}
+
+	for _, c := range channels {
+		details, _ := json.Marshal(map[string]any{"name": c.name, "plugin": pluginID.String(), "archived": !remove})
+		s.communityService.LogAudit(ctx, &communityID, actorID, action, "channel", &c.id, details)
+		if remove {
+			s.channelCommunities.remove(c.id)
+			s.broadcast(ctx, EventChannelDelete, communityID, nil, DeleteEvent{ID: c.id, CommunityID: communityID})
+		} else {
+			s.broadcastChannel(ctx, EventChannelUpdate, c.id)
+		}
+	}
+	return int64(len(channels)), nil
+}
+
+type ReorderChannelsRequest struct {
+	ChannelIDs []uuid.UUID `json:"channelIds" validate:"required,min=1"`
+}
+
 func (s *Service) ReorderChannels(ctx context.Context, communityID, userID uuid.UUID, channelIDs []uuid.UUID) error {
 	if err := s.requireChannelPermission(ctx, communityID, userID, models.PermissionManageChannels); err != nil {
 		return err
 	}
 
-	return database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
 		for i, channelID := range channelIDs {
 			_, err := tx.Exec(ctx,
 				`UPDATE channels SET position = $2 WHERE id = $1 AND community_id = $3`,
@@ -241,6 +521,12 @@ func (s *Service) ReorderChannels(ctx context.Context, communityID, userID uuid.
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(ctx, EventChannelPositionsUpdate, communityID, nil, PositionsEvent{CommunityID: communityID, IDs: channelIDs})
+	return nil
 }
 
 // Categories
@@ -277,6 +563,7 @@ func (s *Service) CreateCategory(ctx context.Context, communityID, userID uuid.U
 		return nil, err
 	}
 
+	s.broadcast(ctx, EventCategoryCreate, category.CommunityID, nil, category)
 	return category, nil
 }
 
@@ -305,6 +592,10 @@ func (s *Service) GetCategories(ctx context.Context, communityID uuid.UUID) ([]*
 	return categories, nil
 }
 
+type UpdateCategoryRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=64"`
+}
+
 func (s *Service) UpdateCategory(ctx context.Context, categoryID, userID uuid.UUID, name string) (*models.ChannelCategory, error) {
 	var communityID uuid.UUID
 	err := s.db.QueryRow(ctx,
@@ -339,6 +630,7 @@ func (s *Service) UpdateCategory(ctx context.Context, categoryID, userID uuid.UU
 		return nil, err
 	}
 
+	s.broadcast(ctx, EventCategoryUpdate, category.CommunityID, nil, category)
 	return category, nil
 }
 
@@ -368,7 +660,17 @@ func (s *Service) DeleteCategory(ctx context.Context, categoryID, userID uuid.UU
 	}
 
 	_, err = s.db.Exec(ctx, `DELETE FROM channel_categories WHERE id = $1`, categoryID)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// Clients move the category's channels out of it, matching what was just done here
+	s.broadcast(ctx, EventCategoryDelete, communityID, nil, DeleteEvent{ID: categoryID, CommunityID: communityID})
+	return nil
+}
+
+type ReorderCategoriesRequest struct {
+	CategoryIDs []uuid.UUID `json:"categoryIds" validate:"required,min=1"`
 }
 
 func (s *Service) ReorderCategories(ctx context.Context, communityID, userID uuid.UUID, categoryIDs []uuid.UUID) error {
@@ -376,7 +678,7 @@ func (s *Service) ReorderCategories(ctx context.Context, communityID, userID uui
 		return err
 	}
 
-	return database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
 		for i, categoryID := range categoryIDs {
 			_, err := tx.Exec(ctx,
 				`UPDATE channel_categories SET position = $2 WHERE id = $1 AND community_id = $3`,
@@ -388,6 +690,12 @@ func (s *Service) ReorderCategories(ctx context.Context, communityID, userID uui
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	s.broadcast(ctx, EventCategoryPositionsUpdate, communityID, nil, PositionsEvent{CommunityID: communityID, IDs: categoryIDs})
+	return nil
 }
 
 // Channel Permissions
@@ -451,7 +759,14 @@ func (s *Service) SetChannelPermission(ctx context.Context, channelID, userID uu
 		DO UPDATE SET allow_permissions = $5, deny_permissions = $6`,
 		uuid.New(), channelID, req.TargetType, req.TargetID, req.AllowPermissions, req.DenyPermissions,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	s.permissions.InvalidateCommunity(ctx, channel.CommunityID)
+
+	// Lets the hub drop subscribers who just lost access
+	s.broadcastChannel(ctx, EventChannelUpdate, channelID)
+	return nil
 }
 
 func (s *Service) DeleteChannelPermission(ctx context.Context, channelID, userID uuid.UUID, targetType string, targetID uuid.UUID) error {
@@ -468,7 +783,13 @@ func (s *Service) DeleteChannelPermission(ctx context.Context, channelID, userID
 		`DELETE FROM channel_permissions WHERE channel_id = $1 AND target_type = $2 AND target_id = $3`,
 		channelID, targetType, targetID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	s.permissions.InvalidateCommunity(ctx, channel.CommunityID)
+
+	s.broadcastChannel(ctx, EventChannelUpdate, channelID)
+	return nil
 }
 
 // Permission helpers
@@ -535,37 +856,367 @@ func (s *Service) CanMentionEveryone(ctx context.Context, channelID, userID uuid
 	return models.HasPermission(permissions, models.PermissionMentionEveryone)
 }
 
-func (s *Service) getChannelPermissions(ctx context.Context, channelID, userID uuid.UUID) (int64, error) {
+// CanConnectVoice is whether the user may join the voice channel
+func (s *Service) CanConnectVoice(ctx context.Context, channelID, userID uuid.UUID) bool {
+	permissions, err := s.getChannelPermissions(ctx, channelID, userID)
+	if err != nil {
+		return false
+	}
+
+	return models.HasPermission(permissions, models.PermissionViewChannels|models.PermissionVoiceConnect)
+}
+
+// CanSpeakVoice is whether the user may publish audio and video in the voice
+// channel once connected
+func (s *Service) CanSpeakVoice(ctx context.Context, channelID, userID uuid.UUID) bool {
+	permissions, err := s.getChannelPermissions(ctx, channelID, userID)
+	if err != nil {
+		return false
+	}
+
+	return models.HasPermission(permissions, models.PermissionVoiceSpeak)
+}
+
+// CanModerateVoice is whether the user holds permission (one of the voice
+// moderation bits, e.g. PermissionVoiceMuteOthers) in the channel
+func (s *Service) CanModerateVoice(ctx context.Context, channelID, userID uuid.UUID, permission int64) bool {
+	permissions, err := s.getChannelPermissions(ctx, channelID, userID)
+	if err != nil {
+		return false
+	}
+
+	return models.HasPermission(permissions, permission)
+}
+
+// GetVisibleChannels returns the community's channels the user can see, in
+// the order of GetCommunityChannels
+func (s *Service) GetVisibleChannels(ctx context.Context, communityID, userID uuid.UUID) ([]*models.ChannelWithCategory, error) {
+	channels, err := s.GetCommunityChannels(ctx, communityID)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := s.memberPermissions(ctx, communityID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := channels[:0]
+	for _, c := range channels {
+		if models.HasPermission(s.entryPermissions(ctx, entry, c.ID, userID), models.PermissionViewChannels) {
+			visible = append(visible, c)
+		}
+	}
+	return visible, nil
+}
+
+// VisibleChannels splits a community's channels into those the user can see
+// and those they can't, from one lookup of the user's cached permissions. A
+// user who isn't a member sees nothing.
+func (s *Service) VisibleChannels(ctx context.Context, communityID, userID uuid.UUID) (visible, hidden []uuid.UUID, err error) {
+	channels, err := s.GetCommunityChannels(ctx, communityID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entry, err := s.memberPermissions(ctx, communityID, userID)
+	if err != nil {
+		for _, c := range channels {
+			hidden = append(hidden, c.ID)
+		}
+		return nil, hidden, nil
+	}
+
+	for _, c := range channels {
+		if models.HasPermission(s.entryPermissions(ctx, entry, c.ID, userID), models.PermissionViewChannels) {
+			visible = append(visible, c.ID)
+		} else {
+			hidden = append(hidden, c.ID)
+		}
+	}
+	return visible, hidden, nil
+}
+
+// CommunityMembers narrows userIDs down to members of the community
+func (s *Service) CommunityMembers(ctx context.Context, communityID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	return s.communityService.FilterMembers(ctx, communityID, userIDs)
+}
+
+// ChannelViewers narrows userIDs down to the members who can see the channel.
+// It resolves everyone's permissions from one load of the community's roles
+// and the channel's overwrites, so it suits member lists of any size.
+func (s *Service) ChannelViewers(ctx context.Context, channelID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
 	channel, err := s.GetChannel(ctx, channelID)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	community, err := s.communityService.GetCommunity(ctx, channel.CommunityID)
+	if err != nil {
+		return nil, err
+	}
+	roles, err := s.communityService.GetRoles(ctx, channel.CommunityID)
+	if err != nil {
+		return nil, err
+	}
+
+	rolePermissions := make(map[uuid.UUID]int64, len(roles))
+	var defaultRole *models.Role
+	for _, r := range roles {
+		rolePermissions[r.ID] = r.Permissions
+		if r.IsDefault {
+			defaultRole = r
+		}
 	}
 
-	basePermissions, err := s.communityService.GetMemberPermissions(ctx, channel.CommunityID, userID)
+	rows, err := s.db.Query(ctx,
+		`SELECT target_type, target_id, allow_permissions, deny_permissions
+		FROM channel_permissions WHERE channel_id = $1`,
+		channelID,
+	)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	var overwrites []permissionOverwrite
+	for rows.Next() {
+		var o permissionOverwrite
+		if err := rows.Scan(&o.targetType, &o.targetID, &o.allow, &o.deny); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		overwrites = append(overwrites, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
 	}
 
-	if basePermissions&models.PermissionAdministrator != 0 {
-		return basePermissions, nil
+	rows, err = s.db.Query(ctx,
+		`SELECT cm.id, cm.user_id, cm.timeout_until, COALESCE(array_agg(mr.role_id) FILTER (WHERE mr.role_id IS NOT NULL), '{}')
+		FROM community_members cm
+		LEFT JOIN member_roles mr ON mr.member_id = cm.id
+		WHERE cm.community_id = $1 AND cm.user_id = ANY($2)
+		GROUP BY cm.id`,
+		channel.CommunityID, userIDs,
+	)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	member, err := s.communityService.GetMember(ctx, channel.CommunityID, userID)
+	var viewers []uuid.UUID
+	for rows.Next() {
+		member := &models.CommunityMember{CommunityID: channel.CommunityID}
+		var roleIDs []uuid.UUID
+		if err := rows.Scan(&member.ID, &member.UserID, &member.TimeoutUntil, &roleIDs); err != nil {
+			return nil, err
+		}
+
+		// Same base permissions as community.GetMemberPermissions
+		pc := &permissionContext{member: member, roleIDs: roleIDs}
+		if member.UserID == community.OwnerID {
+			pc.base = models.PermissionAdministrator
+		} else {
+			for _, roleID := range roleIDs {
+				pc.base |= rolePermissions[roleID]
+			}
+			if len(roleIDs) == 0 && defaultRole != nil {
+				pc.base = defaultRole.Permissions
+			}
+			if member.TimedOut() && !pc.isAdmin() {
+				pc.base &^= models.TimeoutRevokedPermissions
+			}
+		}
+		if defaultRole != nil {
+			pc.roleIDs = append(pc.roleIDs, defaultRole.ID)
+		}
+
+		var applicable []permissionOverwrite
+		for _, o := range overwrites {
+			if o.appliesTo(pc) {
+				applicable = append(applicable, o)
+			}
+		}
+		if models.HasPermission(pc.resolve(applicable, channel.ArchivedAt != nil), models.PermissionViewChannels) {
+			viewers = append(viewers, member.UserID)
+		}
+	}
+	return viewers, rows.Err()
+}
+
+// IntegrationCanPost reports whether a bot user posting for pluginID may post
+// in one of communityID's channels. Bot users hold no roles, so they post
+// where the default role can view and send messages, and in the plugin's own
+// channels. pluginID is uuid.Nil for integrations that aren't plugins.
+func (s *Service) IntegrationCanPost(ctx context.Context, communityID, channelID, pluginID uuid.UUID) (bool, error) {
+	channel, err := s.GetChannel(ctx, channelID)
 	if err != nil {
-		return 0, err
+		return false, err
+	}
+	if channel.CommunityID != communityID {
+		return false, ErrChannelNotFound
+	}
+	if channel.ArchivedAt != nil {
+		return false, nil
+	}
+	if pluginID != uuid.Nil && channel.ManagedByPlugin != nil && *channel.ManagedByPlugin == pluginID {
+		return true, nil
+	}
+
+	defaultRole, err := s.communityService.GetDefaultRole(ctx, channel.CommunityID)
+	if err != nil {
+		return false, err
+	}
+
+	pc := &permissionContext{
+		base:    defaultRole.Permissions,
+		member:  &models.CommunityMember{CommunityID: channel.CommunityID},
+		roleIDs: []uuid.UUID{defaultRole.ID},
+	}
+	var overwrites []permissionOverwrite
+	o := permissionOverwrite{targetType: "role", targetID: defaultRole.ID}
+	err = s.db.QueryRow(ctx,
+		`SELECT allow_permissions, deny_permissions
+		FROM channel_permissions WHERE channel_id = $1 AND target_type = 'role' AND target_id = $2`,
+		channelID, defaultRole.ID,
+	).Scan(&o.allow, &o.deny)
+	switch {
+	case err == nil:
+		overwrites = append(overwrites, o)
+	case !errors.Is(err, pgx.ErrNoRows):
+		return false, err
 	}
 
-	roleIDs, err := s.communityService.GetMemberRoleIDs(ctx, channel.CommunityID, userID)
+	permissions := pc.resolve(overwrites, false)
+	return models.HasPermission(permissions, models.PermissionViewChannels) &&
+		models.HasPermission(permissions, models.PermissionSendMessages), nil
+}
+
+// getChannelPermissions returns the user's permissions in a channel from the
+// permission cache, computing them directly for a channel created since the
+// cached entry was
+func (s *Service) getChannelPermissions(ctx context.Context, channelID, userID uuid.UUID) (int64, error) {
+	communityID, err := s.channelCommunity(ctx, channelID)
+	if err != nil {
+		return 0, err
+	}
+	entry, err := s.memberPermissions(ctx, communityID, userID)
 	if err != nil {
 		return 0, err
 	}
-	if roleIDs == nil {
-		roleIDs = []uuid.UUID{}
+	if permissions, ok := entry.Channel(channelID); ok {
+		return permissions, nil
 	}
+	return s.computeChannelPermissions(ctx, channelID, userID)
+}
 
-	defaultRole, err := s.communityService.GetDefaultRole(ctx, channel.CommunityID)
-	if err == nil && defaultRole != nil {
-		roleIDs = append(roleIDs, defaultRole.ID)
+// entryPermissions is the user's permissions in a channel of the entry's
+// community, or none when they can't be worked out
+func (s *Service) entryPermissions(ctx context.Context, entry *permcache.Entry, channelID, userID uuid.UUID) int64 {
+	if permissions, ok := entry.Channel(channelID); ok {
+		return permissions
+	}
+	permissions, err := s.computeChannelPermissions(ctx, channelID, userID)
+	if err != nil {
+		return 0
+	}
+	return permissions
+}
+
+func (s *Service) channelCommunity(ctx context.Context, channelID uuid.UUID) (uuid.UUID, error) {
+	if communityID, ok := s.channelCommunities.get(channelID); ok {
+		return communityID, nil
+	}
+	channel, err := s.GetChannel(ctx, channelID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	s.channelCommunities.put(channelID, channel.CommunityID)
+	return channel.CommunityID, nil
+}
+
+// memberPermissions returns the user's permissions in every channel of the
+// community. It fails when they aren't a member.
+func (s *Service) memberPermissions(ctx context.Context, communityID, userID uuid.UUID) (*permcache.Entry, error) {
+	return s.permissions.Get(ctx, communityID, userID, func(ctx context.Context) (*permcache.Entry, error) {
+		channels, err := s.GetCommunityChannels(ctx, communityID)
+		if err != nil {
+			return nil, err
+		}
+		pc, err := s.loadPermissionContext(ctx, communityID, userID)
+		if err != nil {
+			return nil, err
+		}
+		overwrites, err := s.memberOverwrites(ctx, communityID, pc)
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &permcache.Entry{Base: pc.base, Channels: make(map[uuid.UUID]int64, len(channels))}
+		for _, c := range channels {
+			s.channelCommunities.put(c.ID, communityID)
+			entry.Channels[c.ID] = pc.resolve(overwrites[c.ID], c.ArchivedAt != nil)
+		}
+		// The timeout ends without anything being invalidated
+		if pc.member != nil && pc.member.TimedOut() {
+			entry.ExpiresAt = *pc.member.TimeoutUntil
+		}
+		return entry, nil
+	})
+}
+
+// memberOverwrites loads the overwrites that apply to the member in every
+// channel of the community, keyed by channel. Administrators skip overwrites,
+// so none are loaded for them.
+func (s *Service) memberOverwrites(ctx context.Context, communityID uuid.UUID, pc *permissionContext) (map[uuid.UUID][]permissionOverwrite, error) {
+	overwrites := make(map[uuid.UUID][]permissionOverwrite)
+	if pc.isAdmin() {
+		return overwrites, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT cp.channel_id, cp.target_type, cp.target_id, cp.allow_permissions, cp.deny_permissions
+		FROM channel_permissions cp
+		JOIN channels c ON c.id = cp.channel_id
+		WHERE c.community_id = $1
+		AND (
+			(cp.target_type = 'role' AND cp.target_id = ANY($2))
+			OR (cp.target_type = 'member' AND cp.target_id = $3)
+		)`,
+		communityID, pc.roleIDs, pc.member.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var channelID uuid.UUID
+		var o permissionOverwrite
+		if err := rows.Scan(&channelID, &o.targetType, &o.targetID, &o.allow, &o.deny); err != nil {
+			return nil, err
+		}
+		overwrites[channelID] = append(overwrites[channelID], o)
+	}
+	return overwrites, rows.Err()
+}
+
+// computeChannelPermissions resolves the user's permissions in one channel
+// without the cache
+func (s *Service) computeChannelPermissions(ctx context.Context, channelID, userID uuid.UUID) (int64, error) {
+	channel, err := s.GetChannel(ctx, channelID)
+	if err != nil {
+		return 0, err
+	}
+
+	pc, err := s.loadPermissionContext(ctx, channel.CommunityID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if pc.isAdmin() {
+		return pc.base, nil
 	}
 
 	rows, err := s.db.Query(ctx,
@@ -576,41 +1227,127 @@ func (s *Service) getChannelPermissions(ctx context.Context, channelID, userID u
 			(target_type = 'role' AND target_id = ANY($2))
 			OR (target_type = 'member' AND target_id = $3)
 		)`,
-		channelID, roleIDs, member.ID,
+		channelID, pc.roleIDs, pc.member.ID,
 	)
 	if err != nil {
 		return 0, err
 	}
 	defer rows.Close()
 
-	var roleAllow int64
-	var roleDeny int64
-	var memberAllow int64
-	var memberDeny int64
+	var overwrites []permissionOverwrite
 	for rows.Next() {
-		var targetType string
-		var targetID uuid.UUID
-		var allowPerms int64
-		var denyPerms int64
-		if err := rows.Scan(&targetType, &targetID, &allowPerms, &denyPerms); err != nil {
+		var o permissionOverwrite
+		if err := rows.Scan(&o.targetType, &o.targetID, &o.allow, &o.deny); err != nil {
 			return 0, err
 		}
+		overwrites = append(overwrites, o)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return pc.resolve(overwrites, channel.ArchivedAt != nil), nil
+}
+
+// permissionContext is what channel permissions are computed from for one
+// member of one community
+type permissionContext struct {
+	base    int64
+	member  *models.CommunityMember
+	roleIDs []uuid.UUID // including the default role
+}
+
+// permissionOverwrite is a channel_permissions row that applies to the member
+type permissionOverwrite struct {
+	targetType string
+	targetID   uuid.UUID
+	allow      int64
+	deny       int64
+}
+
+func (s *Service) loadPermissionContext(ctx context.Context, communityID, userID uuid.UUID) (*permissionContext, error) {
+	basePermissions, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil {
+		return nil, err
+	}
+	pc := &permissionContext{base: basePermissions}
+	if pc.isAdmin() {
+		return pc, nil
+	}
+
+	pc.member, err = s.communityService.GetMember(ctx, communityID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pc.roleIDs, err = s.communityService.GetMemberRoleIDs(ctx, communityID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if pc.roleIDs == nil {
+		pc.roleIDs = []uuid.UUID{}
+	}
+
+	defaultRole, err := s.communityService.GetDefaultRole(ctx, communityID)
+	if err == nil && defaultRole != nil {
+		pc.roleIDs = append(pc.roleIDs, defaultRole.ID)
+	}
+	return pc, nil
+}
+
+// appliesTo reports whether the overwrite targets the member or one of their
+// roles
+func (o permissionOverwrite) appliesTo(pc *permissionContext) bool {
+	if o.targetType == "member" {
+		return o.targetID == pc.member.ID
+	}
+	for _, roleID := range pc.roleIDs {
+		if o.targetID == roleID {
+			return true
+		}
+	}
+	return false
+}
 
-		if targetType == "member" {
-			memberAllow |= allowPerms
-			memberDeny |= denyPerms
+func (pc *permissionContext) isAdmin() bool {
+	return pc.base&models.PermissionAdministrator != 0
+}
+
+// resolve applies a channel's overwrites: role denies, role allows, then the
+// member's own denies and allows
+func (pc *permissionContext) resolve(overwrites []permissionOverwrite, archived bool) int64 {
+	if pc.isAdmin() {
+		return pc.base
+	}
+
+	var roleAllow int64
+	var roleDeny int64
+	var memberAllow int64
+	var memberDeny int64
+	for _, o := range overwrites {
+		if o.targetType == "member" {
+			memberAllow |= o.allow
+			memberDeny |= o.deny
 			continue
 		}
 
-		roleAllow |= allowPerms
-		roleDeny |= denyPerms
+		roleAllow |= o.allow
+		roleDeny |= o.deny
 	}
 
-	permissions := basePermissions
+	permissions := pc.base
 	permissions &= ^roleDeny
 	permissions |= roleAllow
 	permissions &= ^memberDeny
 	permissions |= memberAllow
 
-	return permissions, nil
+	// Overwrites can't grant back what a timeout takes away
+	if pc.member.TimedOut() {
+		permissions &^= models.TimeoutRevokedPermissions
+	}
+	if archived {
+		permissions &^= models.ArchivedRevokedPermissions
+	}
+
+	return permissions
 }
@@ -0,0 +1,172 @@
+package channelitem
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/channels/{channelId}", func(r chi.Router) {
+		r.Get("/", h.ListItems)
+		r.Post("/", h.CreateItem)
+		r.Get("/pages/{slug}", h.GetPage)
+	})
+
+	r.Route("/{itemId}", func(r chi.Router) {
+		r.Get("/", h.GetItem)
+		r.Patch("/", h.UpdateItem)
+		r.Delete("/", h.DeleteItem)
+	})
+
+	return r
+}
+
+func (h *Handler) ListItems(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "channelId", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	items, err := h.service.ListItems(r.Context(), id, userID, ListItemsOptions{
+		Kind:   q.Get("kind"),
+		Column: q.Get("column"),
+	})
+	if err != nil {
+		respondItemError(w, err, "Failed to list items")
+		return
+	}
+
+	utils.RespondSuccess(w, items)
+}
+
+func (h *Handler) CreateItem(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "channelId", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	var req CreateItemRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	item, err := h.service.CreateItem(r.Context(), id, userID, &req)
+	if err != nil {
+		respondItemError(w, err, "Failed to create item")
+		return
+	}
+
+	utils.RespondCreated(w, item)
+}
+
+func (h *Handler) GetPage(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "channelId", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	item, err := h.service.GetPage(r.Context(), id, userID, chi.URLParam(r, "slug"))
+	if err != nil {
+		respondItemError(w, err, "Failed to get page")
+		return
+	}
+
+	utils.RespondSuccess(w, item)
+}
+
+func (h *Handler) GetItem(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "itemId", "Invalid item ID")
+	if !ok {
+		return
+	}
+
+	item, err := h.service.GetItem(r.Context(), id, userID)
+	if err != nil {
+		respondItemError(w, err, "Failed to get item")
+		return
+	}
+
+	utils.RespondSuccess(w, item)
+}
+
+func (h *Handler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "itemId", "Invalid item ID")
+	if !ok {
+		return
+	}
+
+	var req UpdateItemRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	item, err := h.service.UpdateItem(r.Context(), id, userID, &req)
+	if err != nil {
+		respondItemError(w, err, "Failed to update item")
+		return
+	}
+
+	utils.RespondSuccess(w, item)
+}
+
+func (h *Handler) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "itemId", "Invalid item ID")
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteItem(r.Context(), id, userID); err != nil {
+		respondItemError(w, err, "Failed to delete item")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func requireParam(w http.ResponseWriter, r *http.Request, param, invalid string) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, param))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, invalid)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, id, true
+}
+
+func respondItemError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, channel.ErrChannelNotFound), errors.Is(err, ErrItemNotFound):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrKindNotSupported), errors.Is(err, ErrInvalidItem):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrSlugTaken), errors.Is(err, ErrVersionConflict), errors.Is(err, ErrTooManyItems):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
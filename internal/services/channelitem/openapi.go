@@ -0,0 +1,51 @@
+package channelitem
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	channelID := []openapi.Param{openapi.ID("channelId")}
+	itemID := []openapi.Param{openapi.ID("itemId")}
+
+	openapi.Describe((*Handler).ListItems, openapi.Operation{
+		Summary: "List a channel's cards, pages or media",
+		Path:    channelID,
+		Query: []openapi.Param{
+			{Name: "kind", Enum: []string{KindCard, KindPage, KindMedia}, Description: "Only items of this kind"},
+			{Name: "column", Description: "Only cards in this board column"},
+		},
+		Response: []*Item{},
+	})
+	openapi.Describe((*Handler).CreateItem, openapi.Operation{
+		Summary:  "Add an item to a channel",
+		Path:     channelID,
+		Body:     CreateItemRequest{},
+		Response: Item{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetPage, openapi.Operation{
+		Summary:  "Get a wiki page by its slug",
+		Path:     append(channelID, openapi.Param{Name: "slug"}),
+		Response: Item{},
+	})
+
+	openapi.Describe((*Handler).GetItem, openapi.Operation{
+		Summary:  "Get an item",
+		Path:     itemID,
+		Response: Item{},
+	})
+	openapi.Describe((*Handler).UpdateItem, openapi.Operation{
+		Summary:  "Update an item",
+		Path:     itemID,
+		Body:     UpdateItemRequest{},
+		Response: Item{},
+	})
+	openapi.Describe((*Handler).DeleteItem, openapi.Operation{
+		Summary: "Delete an item",
+		Path:    itemID,
+		Status:  http.StatusNoContent,
+	})
+}
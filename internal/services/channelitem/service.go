@@ -0,0 +1,579 @@
+package channelitem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/channeltype"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Item kinds, each enabled by one of the channel type item capabilities
+const (
+	KindCard  = "card"
+	KindPage  = "page"
+	KindMedia = "media"
+)
+
+var kindCapabilities = map[string]int64{
+	KindCard:  models.CapKanban,
+	KindPage:  models.CapWiki,
+	KindMedia: models.CapMediaBoard,
+}
+
+// Event types sent on the channel's stream
+const (
+	EventTypeItemCreate = "CHANNEL_ITEM_CREATE"
+	EventTypeItemUpdate = "CHANNEL_ITEM_UPDATE"
+	EventTypeItemDelete = "CHANNEL_ITEM_DELETE"
+)
+
+const (
+	MaxItemsPerChannel = 5000
+	MaxCardBodyLength  = 10000
+	MaxPageBodyLength  = 100000
+	MaxMediaBodyLength = 2000
+	MaxDataBytes       = 16 * 1024
+	maxURLLength       = 2048
+)
+
+var (
+	ErrItemNotFound      = errors.New("item not found")
+	ErrKindNotSupported  = errors.New("this channel type doesn't hold items of that kind")
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrInvalidItem       = errors.New("invalid item")
+	ErrSlugTaken         = errors.New("a page with that slug already exists in this channel")
+	ErrVersionConflict   = errors.New("item was changed by someone else; reload it and try again")
+	ErrTooManyItems      = errors.New("channel has too many items")
+)
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// Item is a structured item in a kanban, wiki or media board channel
+type Item struct {
+	ID          uuid.UUID       `json:"id"`
+	ChannelID   uuid.UUID       `json:"channelId"`
+	CommunityID uuid.UUID       `json:"communityId"`
+	Kind        string          `json:"kind"`
+	Title       string          `json:"title"`
+	Body        *string         `json:"body,omitempty"`
+	Slug        *string         `json:"slug,omitempty"`
+	Column      *string         `json:"column,omitempty"`
+	MediaURL    *string         `json:"mediaUrl,omitempty"`
+	Position    int             `json:"position"`
+	Data        json.RawMessage `json:"data"`
+	Version     int             `json:"version"`
+	CreatedBy   *uuid.UUID      `json:"createdBy,omitempty"`
+	UpdatedBy   *uuid.UUID      `json:"updatedBy,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+type CreateItemRequest struct {
+	// May be left out when the channel type holds one kind of item
+	Kind     string          `json:"kind" validate:"omitempty,oneof=card page media"`
+	Title    string          `json:"title" validate:"max=200"`
+	Body     *string         `json:"body"`
+	Slug     *string         `json:"slug" validate:"omitempty,min=1,max=100"`
+	Column   *string         `json:"column" validate:"omitempty,min=1,max=64"`
+	MediaURL *string         `json:"mediaUrl"`
+	Position *int            `json:"position" validate:"omitempty,min=0"`
+	Data     json.RawMessage `json:"data"`
+}
+
+type UpdateItemRequest struct {
+	Title    *string         `json:"title" validate:"omitempty,max=200"`
+	Body     *string         `json:"body"`
+	Slug     *string         `json:"slug" validate:"omitempty,min=1,max=100"`
+	Column   *string         `json:"column" validate:"omitempty,min=1,max=64"`
+	MediaURL *string         `json:"mediaUrl"`
+	Position *int            `json:"position" validate:"omitempty,min=0"`
+	Data     json.RawMessage `json:"data"`
+	// The version the change was made against; a newer one fails the update
+	Version *int `json:"version"`
+}
+
+type ListItemsOptions struct {
+	Kind   string
+	Column string
+}
+
+type Service struct {
+	db             *pgxpool.Pool
+	channelService *channel.Service
+	typeRegistry   *channeltype.Registry
+}
+
+func NewService(db *pgxpool.Pool, channelService *channel.Service, typeRegistry *channeltype.Registry) *Service {
+	return &Service{
+		db:             db,
+		channelService: channelService,
+		typeRegistry:   typeRegistry,
+	}
+}
+
+// ListItems returns a channel's items in board order. Page bodies are left
+// out; fetch a page on its own to read it.
+func (s *Service) ListItems(ctx context.Context, channelID, userID uuid.UUID, opts ListItemsOptions) ([]*Item, error) {
+	if _, _, err := s.requireChannel(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT id, channel_id, community_id, kind, title, CASE WHEN kind = 'page' THEN NULL ELSE body END,
+		slug, column_key, media_url, position, data, version, created_by, updated_by, created_at, updated_at
+		FROM channel_items
+		WHERE channel_id = $1 AND ($2 = '' OR kind = $2) AND ($3 = '' OR column_key = $3)
+		ORDER BY kind, column_key NULLS FIRST, position, created_at`,
+		channelID, opts.Kind, opts.Column,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*Item, 0)
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *Service) GetItem(ctx context.Context, itemID, userID uuid.UUID) (*Item, error) {
+	item, err := s.load(ctx, s.db, itemID, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := s.requireChannel(ctx, item.ChannelID, userID); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// GetPage looks up a wiki page by its slug
+func (s *Service) GetPage(ctx context.Context, channelID, userID uuid.UUID, slug string) (*Item, error) {
+	if _, _, err := s.requireChannel(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+
+	item, err := scanItem(s.db.QueryRow(ctx,
+		`SELECT `+itemColumns+` FROM channel_items WHERE channel_id = $1 AND slug = $2`,
+		channelID, slug,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// CreateItem adds an item. Members who can post in the channel can add
+// items; in read-only types only moderators can.
+func (s *Service) CreateItem(ctx context.Context, channelID, userID uuid.UUID, req *CreateItemRequest) (*Item, error) {
+	ch, def, err := s.requireChannel(ctx, channelID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireWrite(ctx, channelID, userID, def); err != nil {
+		return nil, err
+	}
+
+	kind := req.Kind
+	if kind == "" {
+		kind = onlyKind(def)
+	}
+	if capability, ok := kindCapabilities[kind]; !ok || !def.HasCapability(capability) {
+		return nil, ErrKindNotSupported
+	}
+
+	now := time.Now()
+	item := &Item{
+		ID:          uuid.New(),
+		ChannelID:   channelID,
+		CommunityID: ch.CommunityID,
+		Kind:        kind,
+		Title:       strings.TrimSpace(req.Title),
+		Body:        req.Body,
+		Slug:        req.Slug,
+		Column:      req.Column,
+		MediaURL:    req.MediaURL,
+		Data:        req.Data,
+		Version:     1,
+		CreatedBy:   &userID,
+		UpdatedBy:   &userID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if kind == KindCard && item.Column == nil {
+		if columns := boardColumns(ch.Metadata); len(columns) > 0 {
+			item.Column = &columns[0]
+		}
+	}
+	if err := validateItem(item, ch.Metadata); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Serializes creates in the channel so the item cap and positions hold
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM channels WHERE id = $1 FOR UPDATE`, channelID); err != nil {
+		return nil, err
+	}
+
+	var count, nextPosition int
+	err = tx.QueryRow(ctx,
+		`SELECT COUNT(*),
+		        COALESCE(MAX(position) FILTER (WHERE kind = $2 AND column_key IS NOT DISTINCT FROM $3), -1) + 1
+		FROM channel_items WHERE channel_id = $1`,
+		channelID, kind, item.Column,
+	).Scan(&count, &nextPosition)
+	if err != nil {
+		return nil, err
+	}
+	if count >= MaxItemsPerChannel {
+		return nil, ErrTooManyItems
+	}
+	item.Position = nextPosition
+	if req.Position != nil {
+		item.Position = *req.Position
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO channel_items (id, channel_id, community_id, kind, title, body, slug, column_key, media_url,
+		position, data, version, created_by, updated_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 1, $12, $12, $13, $13)`,
+		item.ID, item.ChannelID, item.CommunityID, item.Kind, item.Title, item.Body, item.Slug, item.Column, item.MediaURL,
+		item.Position, item.Data, userID, now,
+	)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrSlugTaken
+		}
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.broadcast(ctx, channelID, EventTypeItemCreate, item)
+	return item, nil
+}
+
+// UpdateItem edits an item. Boards and wikis are collaborative, so anyone who
+// can add items can edit them.
+func (s *Service) UpdateItem(ctx context.Context, itemID, userID uuid.UUID, req *UpdateItemRequest) (*Item, error) {
+	current, err := s.load(ctx, s.db, itemID, false)
+	if err != nil {
+		return nil, err
+	}
+	ch, def, err := s.requireChannel(ctx, current.ChannelID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireWrite(ctx, current.ChannelID, userID, def); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	item, err := s.load(ctx, tx, itemID, true)
+	if err != nil {
+		return nil, err
+	}
+	if req.Version != nil && *req.Version != item.Version {
+		return nil, ErrVersionConflict
+	}
+
+	if req.Title != nil {
+		item.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Body != nil {
+		item.Body = req.Body
+	}
+	if req.Slug != nil {
+		item.Slug = req.Slug
+	}
+	if req.Column != nil {
+		item.Column = req.Column
+	}
+	if req.MediaURL != nil {
+		item.MediaURL = req.MediaURL
+	}
+	if req.Position != nil {
+		item.Position = *req.Position
+	}
+	if req.Data != nil {
+		item.Data = req.Data
+	}
+	if err := validateItem(item, ch.Metadata); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx,
+		`UPDATE channel_items
+		SET title = $2, body = $3, slug = $4, column_key = $5, media_url = $6, position = $7, data = $8,
+		    version = version + 1, updated_by = $9, updated_at = NOW()
+		WHERE id = $1
+		RETURNING version, updated_at`,
+		item.ID, item.Title, item.Body, item.Slug, item.Column, item.MediaURL, item.Position, item.Data, userID,
+	).Scan(&item.Version, &item.UpdatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrSlugTaken
+		}
+		return nil, err
+	}
+	item.UpdatedBy = &userID
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.broadcast(ctx, item.ChannelID, EventTypeItemUpdate, item)
+	return item, nil
+}
+
+// DeleteItem removes an item. Its creator or a moderator can delete it.
+func (s *Service) DeleteItem(ctx context.Context, itemID, userID uuid.UUID) error {
+	item, err := s.load(ctx, s.db, itemID, false)
+	if err != nil {
+		return err
+	}
+	if _, _, err := s.requireChannel(ctx, item.ChannelID, userID); err != nil {
+		return err
+	}
+	isCreator := item.CreatedBy != nil && *item.CreatedBy == userID
+	if !isCreator && !s.channelService.CanManageMessages(ctx, item.ChannelID, userID) {
+		return ErrInsufficientPerms
+	}
+
+	tag, err := s.db.Exec(ctx, `DELETE FROM channel_items WHERE id = $1`, itemID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrItemNotFound
+	}
+
+	s.broadcast(ctx, item.ChannelID, EventTypeItemDelete, map[string]interface{}{
+		"id":        item.ID,
+		"channelId": item.ChannelID,
+		"kind":      item.Kind,
+	})
+	return nil
+}
+
+// requireChannel loads a channel the user can see, along with its type,
+// which has to hold items
+func (s *Service) requireChannel(ctx context.Context, channelID, userID uuid.UUID) (*models.Channel, *models.ChannelTypeDefinition, error) {
+	ch, err := s.channelService.GetChannel(ctx, channelID)
+	if err != nil {
+		return nil, nil, err
+	}
+	def, err := s.typeRegistry.Get(string(ch.Type))
+	if err != nil || !def.HasCapability(models.CapItems) {
+		return nil, nil, ErrKindNotSupported
+	}
+	if !s.channelService.CanAccessChannel(ctx, channelID, userID) {
+		return nil, nil, ErrInsufficientPerms
+	}
+	return ch, def, nil
+}
+
+func (s *Service) requireWrite(ctx context.Context, channelID, userID uuid.UUID, def *models.ChannelTypeDefinition) error {
+	if def.HasCapability(models.CapReadOnly) {
+		if !s.channelService.CanManageMessages(ctx, channelID, userID) {
+			return ErrInsufficientPerms
+		}
+		return nil
+	}
+	if !s.channelService.CanSendMessage(ctx, channelID, userID) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+// onlyKind is the item kind of a type that holds just one, or "" when it holds several
+func onlyKind(def *models.ChannelTypeDefinition) string {
+	kind := ""
+	for k, capability := range kindCapabilities {
+		if def.HasCapability(capability) {
+			if kind != "" {
+				return ""
+			}
+			kind = k
+		}
+	}
+	return kind
+}
+
+// boardColumns reads the kanban columns configured in a channel's metadata
+func boardColumns(metadata json.RawMessage) []string {
+	var meta struct {
+		Columns []string `json:"columns"`
+	}
+	if len(metadata) == 0 || json.Unmarshal(metadata, &meta) != nil {
+		return nil
+	}
+	return meta.Columns
+}
+
+// validateItem checks the fields an item of its kind needs and clears the
+// ones it doesn't use
+func validateItem(item *Item, channelMetadata json.RawMessage) error {
+	if item.Data == nil || string(item.Data) == "null" {
+		item.Data = json.RawMessage("{}")
+	}
+	if len(item.Data) > MaxDataBytes {
+		return fmt.Errorf("%w: data is too large", ErrInvalidItem)
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(item.Data, &data); err != nil {
+		return fmt.Errorf("%w: data must be a JSON object", ErrInvalidItem)
+	}
+	if len(item.Title) > 200 {
+		return fmt.Errorf("%w: title is too long", ErrInvalidItem)
+	}
+
+	switch item.Kind {
+	case KindCard:
+		item.Slug, item.MediaURL = nil, nil
+		if item.Title == "" {
+			return fmt.Errorf("%w: cards need a title", ErrInvalidItem)
+		}
+		if item.Body != nil && len(*item.Body) > MaxCardBodyLength {
+			return fmt.Errorf("%w: body is too long", ErrInvalidItem)
+		}
+		if item.Column == nil || *item.Column == "" {
+			return fmt.Errorf("%w: cards need a column", ErrInvalidItem)
+		}
+		if columns := boardColumns(channelMetadata); len(columns) > 0 && !contains(columns, *item.Column) {
+			return fmt.Errorf("%w: column is not one of the board's columns", ErrInvalidItem)
+		}
+
+	case KindPage:
+		item.Column, item.MediaURL = nil, nil
+		if item.Title == "" {
+			return fmt.Errorf("%w: pages need a title", ErrInvalidItem)
+		}
+		if item.Body != nil && len(*item.Body) > MaxPageBodyLength {
+			return fmt.Errorf("%w: body is too long", ErrInvalidItem)
+		}
+		if item.Slug == nil || len(*item.Slug) > 100 || !slugPattern.MatchString(*item.Slug) {
+			return fmt.Errorf("%w: pages need a slug of lowercase letters, digits and dashes", ErrInvalidItem)
+		}
+
+	case KindMedia:
+		item.Slug, item.Column = nil, nil
+		if item.Body != nil && len(*item.Body) > MaxMediaBodyLength {
+			return fmt.Errorf("%w: caption is too long", ErrInvalidItem)
+		}
+		if item.MediaURL == nil || !isHTTPURL(*item.MediaURL) {
+			return fmt.Errorf("%w: media needs an http(s) mediaUrl", ErrInvalidItem)
+		}
+	}
+	return nil
+}
+
+func isHTTPURL(raw string) bool {
+	if len(raw) > maxURLLength {
+		return false
+	}
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != "" && u.User == nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+const itemColumns = `id, channel_id, community_id, kind, title, body, slug, column_key, media_url, position, data,
+	version, created_by, updated_by, created_at, updated_at`
+
+func (s *Service) load(ctx context.Context, q querier, itemID uuid.UUID, forUpdate bool) (*Item, error) {
+	query := `SELECT ` + itemColumns + ` FROM channel_items WHERE id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	item, err := scanItem(q.QueryRow(ctx, query, itemID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrItemNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func scanItem(row pgx.Row) (*Item, error) {
+	item := &Item{}
+	err := row.Scan(
+		&item.ID, &item.ChannelID, &item.CommunityID, &item.Kind, &item.Title, &item.Body,
+		&item.Slug, &item.Column, &item.MediaURL, &item.Position, &item.Data,
+		&item.Version, &item.CreatedBy, &item.UpdatedBy, &item.CreatedAt, &item.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (s *Service) broadcast(ctx context.Context, channelID uuid.UUID, eventType string, data interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"channelId": channelID.String(),
+		"event": map[string]interface{}{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", eventType).Msg("Failed to marshal channel item event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Str("event", eventType).Msg("Failed to publish channel item event")
+	}
+}
@@ -0,0 +1,18 @@
+package channeltype
+
+import (
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	openapi.Describe((*Handler).ListChannelTypes, openapi.Operation{
+		Summary:  "List the channel types",
+		Response: []*models.ChannelTypeDefinition{},
+	})
+	openapi.Describe((*Handler).GetChannelType, openapi.Operation{
+		Summary:  "Get a channel type",
+		Path:     []openapi.Param{{Name: "typeId", Description: "Channel type ID, such as text or kanban"}},
+		Response: models.ChannelTypeDefinition{},
+	})
+}
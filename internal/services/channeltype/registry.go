@@ -129,6 +129,48 @@ func (r *Registry) Register(ctx context.Context, def *models.ChannelTypeDefiniti
 	return nil
 }
 
+// Update replaces a plugin-provided type's definition, e.g. when a new
+// version of the plugin changes its capabilities. Only the plugin that
+// registered a type can update it.
+func (r *Registry) Update(ctx context.Context, def *models.ChannelTypeDefinition) error {
+	if def.ID == "" || def.Name == "" {
+		return ErrInvalidType
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.types[def.ID]
+	if !ok {
+		return ErrTypeNotFound
+	}
+	if existing.BuiltIn {
+		return ErrBuiltInType
+	}
+	if existing.PluginID == nil || def.PluginID == nil || *existing.PluginID != *def.PluginID {
+		return ErrTypeExists
+	}
+
+	if def.DefaultMetadata == nil {
+		def.DefaultMetadata = json.RawMessage("{}")
+	}
+
+	_, err := r.db.Exec(ctx,
+		`UPDATE channel_type_definitions
+		SET name = $2, description = $3, icon = $4, capabilities = $5, default_metadata = $6
+		WHERE id = $1 AND built_in = FALSE`,
+		def.ID, def.Name, def.Description, def.Icon, def.Capabilities, def.DefaultMetadata,
+	)
+	if err != nil {
+		return err
+	}
+
+	def.BuiltIn = false
+	def.CreatedAt = existing.CreatedAt
+	r.types[def.ID] = def
+	return nil
+}
+
 // Unregister removes a plugin-provided channel type. Built-in types can't
 // be removed.
 func (r *Registry) Unregister(ctx context.Context, id string) error {
@@ -0,0 +1,519 @@
+package community
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/database"
+)
+
+var (
+	ErrCaseNotFound        = errors.New("moderation case not found")
+	ErrInvalidEvidence     = errors.New("evidence must be messages from this community")
+	ErrCannotModerateOwner = errors.New("cannot moderate the owner")
+	ErrNotTimedOut         = errors.New("member is not timed out")
+	ErrTimeoutDuration     = errors.New("timeouts need a duration")
+	ErrInvalidCaseAction   = errors.New("invalid moderation action")
+)
+
+type CreateCaseRequest struct {
+	UserID             uuid.UUID               `json:"userId" validate:"required"`
+	Action             models.ModerationAction `json:"action" validate:"required,oneof=warn timeout kick ban"`
+	Reason             *string                 `json:"reason" validate:"omitempty,max=512"`
+	EvidenceMessageIDs []uuid.UUID             `json:"evidenceMessageIds" validate:"max=10"`
+	// DurationSeconds is required for timeouts (up to 28 days) and ignored otherwise
+	DurationSeconds *int `json:"durationSeconds" validate:"required_if=Action timeout,omitempty,min=60,max=2419200"`
+}
+
+type UpdateCaseRequest struct {
+	Reason             *string      `json:"reason" validate:"omitempty,max=512"`
+	EvidenceMessageIDs *[]uuid.UUID `json:"evidenceMessageIds" validate:"omitempty,max=10"`
+}
+
+type CaseFilter struct {
+	UserID *uuid.UUID
+	Action models.ModerationAction
+}
+
+// CreateCase applies a moderation action and records it under the community's
+// next case number. The action and the case are written in one transaction so
+// the log never disagrees with what actually happened.
+func (s *Service) CreateCase(ctx context.Context, communityID, actorID uuid.UUID, req *CreateCaseRequest) (*models.ModerationCase, error) {
+	required := models.PermissionKickMembers
+	if req.Action == models.ModerationActionBan {
+		required = models.PermissionBanMembers
+	}
+	if err := s.requirePermission(ctx, communityID, actorID, required); err != nil {
+		return nil, err
+	}
+
+	community, err := s.GetCommunity(ctx, communityID)
+	if err != nil {
+		return nil, err
+	}
+	if community.OwnerID == req.UserID {
+		switch req.Action {
+		case models.ModerationActionKick:
+			return nil, ErrCannotRemoveOwner
+		case models.ModerationActionBan:
+			return nil, ErrCannotBanOwner
+		default:
+			return nil, ErrCannotModerateOwner
+		}
+	}
+
+	evidence, err := s.resolveEvidence(ctx, communityID, req.EvidenceMessageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &models.ModerationCase{
+		ID:          uuid.New(),
+		CommunityID: communityID,
+		Action:      req.Action,
+		TargetID:    req.UserID,
+		ModeratorID: &actorID,
+		Reason:      req.Reason,
+		Evidence:    evidence,
+	}
+	if req.Action == models.ModerationActionTimeout {
+		if req.DurationSeconds == nil {
+			return nil, ErrTimeoutDuration
+		}
+		expiresAt := time.Now().Add(time.Duration(*req.DurationSeconds) * time.Second)
+		c.DurationSeconds = req.DurationSeconds
+		c.ExpiresAt = &expiresAt
+	}
+
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		if err := s.applyCaseAction(ctx, tx, c); err != nil {
+			return err
+		}
+
+		if err := tx.QueryRow(ctx,
+			`UPDATE communities SET moderation_case_count = moderation_case_count + 1
+			WHERE id = $1 RETURNING moderation_case_count`,
+			communityID,
+		).Scan(&c.CaseNumber); err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx,
+			`INSERT INTO moderation_cases (id, community_id, case_number, action, target_id, moderator_id, reason, evidence, duration_seconds, expires_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING created_at, updated_at`,
+			c.ID, c.CommunityID, c.CaseNumber, c.Action, c.TargetID, c.ModeratorID, c.Reason, c.Evidence, c.DurationSeconds, c.ExpiresAt,
+		).Scan(&c.CreatedAt, &c.UpdatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.Action != models.ModerationActionWarn {
+		s.permissions.InvalidateMember(ctx, communityID, c.TargetID)
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"caseNumber": c.CaseNumber,
+		"reason":     c.Reason,
+		"expiresAt":  c.ExpiresAt,
+	})
+	s.LogAudit(ctx, &communityID, actorID, caseAuditAction(c.Action), "user", &c.TargetID, details)
+
+	switch c.Action {
+	case models.ModerationActionKick:
+		s.dispatchEvent(ctx, communityID, models.EventHookMemberKick, c)
+		s.broadcastMembership(ctx, communityID, c.TargetID, "MEMBER_LEAVE")
+	case models.ModerationActionBan:
+		s.dispatchEvent(ctx, communityID, models.EventHookMemberBan, c)
+		s.broadcastMembership(ctx, communityID, c.TargetID, "MEMBER_LEAVE")
+	}
+
+	// Let the member know what happened without revealing who did it
+	s.sendToUser(ctx, c.TargetID, "MODERATION_ACTION", map[string]interface{}{
+		"communityId": communityID,
+		"action":      c.Action,
+		"reason":      c.Reason,
+		"expiresAt":   c.ExpiresAt,
+	})
+
+	return c, nil
+}
+
+// applyCaseAction carries out the side effect of a case inside the case transaction
+func (s *Service) applyCaseAction(ctx context.Context, tx pgx.Tx, c *models.ModerationCase) error {
+	switch c.Action {
+	case models.ModerationActionWarn:
+		var exists bool
+		if err := tx.QueryRow(ctx,
+			`SELECT EXISTS(SELECT 1 FROM community_members WHERE community_id = $1 AND user_id = $2)`,
+			c.CommunityID, c.TargetID,
+		).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNotMember
+		}
+
+	case models.ModerationActionTimeout:
+		result, err := tx.Exec(ctx,
+			`UPDATE community_members SET timeout_until = $3 WHERE community_id = $1 AND user_id = $2`,
+			c.CommunityID, c.TargetID, c.ExpiresAt,
+		)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNotMember
+		}
+
+	case models.ModerationActionKick:
+		result, err := tx.Exec(ctx,
+			`DELETE FROM community_members WHERE community_id = $1 AND user_id = $2`,
+			c.CommunityID, c.TargetID,
+		)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNotMember
+		}
+
+	case models.ModerationActionBan:
+		// Remove from members if they're currently in the community
+		if _, err := tx.Exec(ctx,
+			`DELETE FROM community_members WHERE community_id = $1 AND user_id = $2`,
+			c.CommunityID, c.TargetID,
+		); err != nil {
+			return err
+		}
+
+		result, err := tx.Exec(ctx,
+			`INSERT INTO community_bans (id, community_id, user_id, banned_by, reason, created_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+			ON CONFLICT (community_id, user_id) DO NOTHING`,
+			uuid.New(), c.CommunityID, c.TargetID, c.ModeratorID, c.Reason,
+		)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrUserBanned
+		}
+
+	default:
+		return ErrInvalidCaseAction
+	}
+	return nil
+}
+
+// RemoveTimeout lifts a member's timeout early. It does not open a case; the
+// original timeout case stays in the history.
+func (s *Service) RemoveTimeout(ctx context.Context, communityID, actorID, targetID uuid.UUID) error {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec(ctx,
+		`UPDATE community_members SET timeout_until = NULL
+		WHERE community_id = $1 AND user_id = $2 AND timeout_until > NOW()`,
+		communityID, targetID,
+	)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotTimedOut
+	}
+	s.permissions.InvalidateMember(ctx, communityID, targetID)
+
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionMemberUntimeout, "user", &targetID, nil)
+	return nil
+}
+
+// UpdateCase lets moderators fill in a reason or evidence after the fact
+func (s *Service) UpdateCase(ctx context.Context, communityID, actorID uuid.UUID, caseNumber int, req *UpdateCaseRequest) (*models.ModerationCaseWithUsers, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return nil, err
+	}
+
+	var evidence []models.ModerationEvidence
+	if req.EvidenceMessageIDs != nil {
+		resolved, err := s.resolveEvidence(ctx, communityID, *req.EvidenceMessageIDs)
+		if err != nil {
+			return nil, err
+		}
+		evidence = resolved
+	}
+
+	result, err := s.db.Exec(ctx,
+		`UPDATE moderation_cases SET
+			reason = CASE WHEN $3 THEN $4 ELSE reason END,
+			evidence = CASE WHEN $5 THEN $6 ELSE evidence END
+		WHERE community_id = $1 AND case_number = $2`,
+		communityID, caseNumber, req.Reason != nil, req.Reason, req.EvidenceMessageIDs != nil, evidence,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected() == 0 {
+		return nil, ErrCaseNotFound
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{"caseNumber": caseNumber})
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionCaseUpdate, "moderation_case", nil, details)
+
+	return s.GetCase(ctx, communityID, actorID, caseNumber)
+}
+
+// GetCase looks up a case by its community case number
+func (s *Service) GetCase(ctx context.Context, communityID, actorID uuid.UUID, caseNumber int) (*models.ModerationCaseWithUsers, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return nil, err
+	}
+
+	cases, err := s.queryCases(ctx,
+		`WHERE mc.community_id = $1 AND mc.case_number = $2`,
+		communityID, caseNumber,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, ErrCaseNotFound
+	}
+	return cases[0], nil
+}
+
+// GetCases returns a page of a community's cases, newest first, and the cursor
+// of the next page or "" on the last one
+func (s *Service) GetCases(ctx context.Context, communityID, actorID uuid.UUID, filter CaseFilter, cursor string, limit int) ([]*models.ModerationCaseWithUsers, string, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return nil, "", err
+	}
+
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	before, err := utils.DecodeRankCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Case numbers are unique within a community, so they order the page alone
+	query := `WHERE mc.community_id = $1
+		AND ($2::uuid IS NULL OR mc.target_id = $2)
+		AND ($3 = '' OR mc.action = $3)`
+	args := []any{communityID, filter.UserID, string(filter.Action), limit + 1}
+	if before != nil {
+		query += ` AND mc.case_number < $5`
+		args = append(args, before.Rank)
+	}
+	cases, err := s.queryCases(ctx, query+`
+		ORDER BY mc.case_number DESC
+		LIMIT $4`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(cases) > limit {
+		cases = cases[:limit]
+		last := cases[limit-1]
+		next = utils.EncodeCursor(utils.RankCursor{Rank: int64(last.CaseNumber), ID: last.ID})
+	}
+	return cases, next, nil
+}
+
+// GetMemberHistory returns every case against a user in the community along with
+// per-action counts, so repeat offenders stand out.
+func (s *Service) GetMemberHistory(ctx context.Context, communityID, actorID, targetID uuid.UUID) (*models.ModerationHistory, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return nil, err
+	}
+
+	cases, err := s.queryCases(ctx,
+		`WHERE mc.community_id = $1 AND mc.target_id = $2
+		ORDER BY mc.case_number DESC`,
+		communityID, targetID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	history := &models.ModerationHistory{
+		UserID: targetID,
+		Counts: map[models.ModerationAction]int{
+			models.ModerationActionWarn:    0,
+			models.ModerationActionTimeout: 0,
+			models.ModerationActionKick:    0,
+			models.ModerationActionBan:     0,
+		},
+		Banned: s.IsUserBanned(ctx, communityID, targetID),
+		Cases:  cases,
+	}
+	for _, c := range cases {
+		history.Counts[c.Action]++
+	}
+
+	if member, err := s.GetMember(ctx, communityID, targetID); err == nil && member.TimedOut() {
+		history.TimeoutUntil = member.TimeoutUntil
+	}
+	if q := s.getQuarantine(ctx, communityID, targetID); q != nil {
+		history.QuarantinedAt = &q.QuarantinedAt
+	}
+
+	return history, nil
+}
+
+func (s *Service) queryCases(ctx context.Context, where string, args ...interface{}) ([]*models.ModerationCaseWithUsers, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT mc.id, mc.community_id, mc.case_number, mc.action, mc.target_id, mc.moderator_id,
+			mc.reason, mc.evidence, mc.duration_seconds, mc.expires_at, mc.created_at, mc.updated_at,
+			t.id, t.username, t.display_name, t.avatar_url, t.bio, t.status, t.custom_status, t.created_at,
+			m.id, m.username, m.display_name, m.avatar_url, m.bio, m.status, m.custom_status, m.created_at
+		FROM moderation_cases mc
+		JOIN users t ON t.id = mc.target_id
+		LEFT JOIN users m ON m.id = mc.moderator_id
+		`+where,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cases := make([]*models.ModerationCaseWithUsers, 0)
+	for rows.Next() {
+		c := &models.ModerationCaseWithUsers{}
+		target := &models.PublicUser{}
+		var (
+			modID           *uuid.UUID
+			modUsername     *string
+			modDisplayName  *string
+			modAvatarURL    *string
+			modBio          *string
+			modStatus       *models.UserStatus
+			modCustomStatus *string
+			modCreatedAt    *time.Time
+		)
+		err := rows.Scan(
+			&c.ID, &c.CommunityID, &c.CaseNumber, &c.Action, &c.TargetID, &c.ModeratorID,
+			&c.Reason, &c.Evidence, &c.DurationSeconds, &c.ExpiresAt, &c.CreatedAt, &c.UpdatedAt,
+			&target.ID, &target.Username, &target.DisplayName, &target.AvatarURL, &target.Bio, &target.Status, &target.CustomStatus, &target.CreatedAt,
+			&modID, &modUsername, &modDisplayName, &modAvatarURL, &modBio, &modStatus, &modCustomStatus, &modCreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		c.Target = target
+		if modID != nil {
+			c.Moderator = &models.PublicUser{
+				ID:           *modID,
+				Username:     *modUsername,
+				DisplayName:  modDisplayName,
+				AvatarURL:    modAvatarURL,
+				Bio:          modBio,
+				Status:       *modStatus,
+				CustomStatus: modCustomStatus,
+				CreatedAt:    *modCreatedAt,
+			}
+		}
+		if c.Evidence == nil {
+			c.Evidence = []models.ModerationEvidence{}
+		}
+		cases = append(cases, c)
+	}
+	return cases, rows.Err()
+}
+
+// resolveEvidence checks that every evidence message lives in one of the
+// community's channels and pairs it with its channel, keeping the given order.
+func (s *Service) resolveEvidence(ctx context.Context, communityID uuid.UUID, messageIDs []uuid.UUID) ([]models.ModerationEvidence, error) {
+	evidence := make([]models.ModerationEvidence, 0, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return evidence, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT m.id, m.channel_id FROM messages m
+		JOIN channels c ON c.id = m.channel_id
+		WHERE m.id = ANY($1) AND c.community_id = $2`,
+		messageIDs, communityID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := make(map[uuid.UUID]uuid.UUID, len(messageIDs))
+	for rows.Next() {
+		var messageID, channelID uuid.UUID
+		if err := rows.Scan(&messageID, &channelID); err != nil {
+			return nil, err
+		}
+		channels[messageID] = channelID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uuid.UUID]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		channelID, ok := channels[id]
+		if !ok {
+			return nil, ErrInvalidEvidence
+		}
+		evidence = append(evidence, models.ModerationEvidence{MessageID: id, ChannelID: channelID})
+	}
+	return evidence, nil
+}
+
+func caseAuditAction(action models.ModerationAction) string {
+	switch action {
+	case models.ModerationActionWarn:
+		return models.AuditActionMemberWarn
+	case models.ModerationActionTimeout:
+		return models.AuditActionMemberTimeout
+	case models.ModerationActionKick:
+		return models.AuditActionMemberKick
+	default:
+		return models.AuditActionMemberBan
+	}
+}
+
+func (s *Service) sendToUser(ctx context.Context, userID uuid.UUID, eventType string, data interface{}) {
+	broadcast := struct {
+		ChannelID string      `json:"channelId"`
+		Event     interface{} `json:"event"`
+	}{
+		ChannelID: database.UserStream(userID.String()),
+		Event: struct {
+			Type string      `json:"type"`
+			Data interface{} `json:"data"`
+		}{Type: eventType, Data: data},
+	}
+
+	jsonData, err := json.Marshal(broadcast)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal user event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, jsonData); err != nil {
+		log.Error().Err(err).Msg("Failed to publish user event to Redis")
+	}
+}
@@ -0,0 +1,87 @@
+package community
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+)
+
+// EditPolicyRequest changes the edit policy. Omitted fields are kept; an
+// editWindowMinutes of 0 removes the window, which is at most a week.
+type EditPolicyRequest struct {
+	EditWindowMinutes *int  `json:"editWindowMinutes" validate:"omitempty,min=0,max=10080"`
+	LockPinned        *bool `json:"lockPinned"`
+}
+
+// GetEditPolicy returns the community's edit policy so clients can hide the
+// edit action once it no longer applies
+func (s *Service) GetEditPolicy(ctx context.Context, communityID, actorID uuid.UUID) (*models.EditPolicy, error) {
+	if !s.IsMember(ctx, communityID, actorID) {
+		return nil, ErrNotMember
+	}
+	return s.getEditPolicy(ctx, communityID)
+}
+
+// UpdateEditPolicy sets how long messages stay editable and whether pinned
+// messages are locked
+func (s *Service) UpdateEditPolicy(ctx context.Context, communityID, actorID uuid.UUID, req *EditPolicyRequest) (*models.EditPolicy, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+
+	policy, err := s.getEditPolicy(ctx, communityID)
+	if err != nil {
+		return nil, err
+	}
+	if req.EditWindowMinutes != nil {
+		policy.EditWindowMinutes = req.EditWindowMinutes
+		if *req.EditWindowMinutes == 0 {
+			policy.EditWindowMinutes = nil
+		}
+	}
+	if req.LockPinned != nil {
+		policy.LockPinned = *req.LockPinned
+	}
+	policy.UpdatedBy = &actorID
+
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO community_edit_policies (community_id, edit_window_minutes, lock_pinned, updated_by, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (community_id) DO UPDATE SET
+			edit_window_minutes = EXCLUDED.edit_window_minutes,
+			lock_pinned = EXCLUDED.lock_pinned,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		communityID, policy.EditWindowMinutes, policy.LockPinned, actorID,
+	).Scan(&policy.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"editWindowMinutes": policy.EditWindowMinutes,
+		"lockPinned":        policy.LockPinned,
+	})
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionEditPolicy, "community", &communityID, details)
+	s.broadcast(ctx, communityID, "COMMUNITY_EDIT_POLICY_UPDATE", policy)
+
+	return policy, nil
+}
+
+func (s *Service) getEditPolicy(ctx context.Context, communityID uuid.UUID) (*models.EditPolicy, error) {
+	policy := &models.EditPolicy{CommunityID: communityID}
+	err := s.db.QueryRow(ctx,
+		`SELECT edit_window_minutes, lock_pinned, updated_by, updated_at
+		FROM community_edit_policies WHERE community_id = $1`,
+		communityID,
+	).Scan(&policy.EditWindowMinutes, &policy.LockPinned, &policy.UpdatedBy, &policy.UpdatedAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	return policy, nil
+}
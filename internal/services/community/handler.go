@@ -2,6 +2,7 @@ package community
 
 import (
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -62,6 +63,34 @@ func (h *Handler) Routes(secret string) chi.Router {
 			r.Post("/bans/{userId}", h.BanMember)
 			r.Delete("/bans/{userId}", h.UnbanMember)
 
+			// Moderation cases
+			r.Get("/cases", h.GetCases)
+			r.Post("/cases", h.CreateCase)
+			r.Get("/cases/{caseNumber}", h.GetCase)
+			r.Patch("/cases/{caseNumber}", h.UpdateCase)
+			r.Get("/members/{userId}/cases", h.GetMemberCases)
+			r.Delete("/members/{userId}/timeout", h.RemoveTimeout)
+
+			// Quarantine (shadow ban)
+			r.Get("/quarantines", h.GetQuarantines)
+			r.Put("/members/{userId}/quarantine", h.QuarantineMember)
+			r.Delete("/members/{userId}/quarantine", h.LiftQuarantine)
+
+			// Lockdown (panic mode)
+			r.Get("/lockdown", h.GetLockdown)
+			r.Put("/lockdown", h.EnableLockdown)
+			r.Delete("/lockdown", h.DisableLockdown)
+
+			// Message edit policy
+			r.Get("/edit-policy", h.GetEditPolicy)
+			r.Patch("/edit-policy", h.UpdateEditPolicy)
+
+			// Partners
+			r.Get("/partners", h.GetPartners)
+			r.Post("/partners", h.ProposePartner)
+			r.Post("/partners/{partnerId}/accept", h.AcceptPartner)
+			r.Delete("/partners/{partnerId}", h.RemovePartner)
+
 			// Audit Log
 			r.Get("/audit-log", h.GetAuditLog)
 
@@ -89,13 +118,7 @@ func (h *Handler) CreateCommunity(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateCommunityRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -124,13 +147,7 @@ func (h *Handler) ImportDiscordServer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req DiscordImportRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -166,6 +183,10 @@ func (h *Handler) GetCommunity(w http.ResponseWriter, r *http.Request) {
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to get community")
 		return
 	}
+	if err := h.service.AttachPartners(r.Context(), community); err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get community")
+		return
+	}
 
 	utils.RespondSuccess(w, community)
 }
@@ -188,17 +209,19 @@ func (h *Handler) GetUserCommunities(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) DiscoverCommunities(w http.ResponseWriter, r *http.Request) {
 	query := utils.GetQueryString(r, "q", "")
-	page := utils.GetQueryInt(r, "page", 1)
-	pageSize := utils.GetQueryInt(r, "pageSize", 20)
-	offset := (page - 1) * pageSize
+	page := utils.GetCursorParams(r, 20, 50)
 
-	communities, total, err := h.service.DiscoverCommunities(r.Context(), query, pageSize, offset)
+	communities, next, err := h.service.DiscoverCommunities(r.Context(), query, page.Cursor, page.Limit)
 	if err != nil {
+		if err == utils.ErrInvalidCursor {
+			utils.RespondInvalidCursor(w)
+			return
+		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to discover communities")
 		return
 	}
 
-	utils.RespondPaginated(w, communities, total, page, pageSize)
+	utils.RespondCursorPage(w, communities, next)
 }
 
 func (h *Handler) UpdateCommunity(w http.ResponseWriter, r *http.Request) {
@@ -215,8 +238,7 @@ func (h *Handler) UpdateCommunity(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateCommunityRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -333,7 +355,7 @@ func (h *Handler) JoinCommunity(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.JoinCommunity(r.Context(), id, userID); err != nil {
+	if err := h.service.JoinCommunity(r.Context(), id, userID, middleware.ClientIP(r)); err != nil {
 		switch err {
 		case ErrCommunityNotFound:
 			utils.RespondError(w, http.StatusNotFound, "Community not found")
@@ -341,6 +363,12 @@ func (h *Handler) JoinCommunity(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusConflict, "Already a member of this community")
 		case ErrUserBanned:
 			utils.RespondError(w, http.StatusForbidden, "You are banned from this community")
+		case ErrVerificationLevel:
+			utils.RespondError(w, http.StatusForbidden, "Your account does not meet this community's verification level")
+		case ErrJoinRateLimited:
+			utils.RespondErrorWithCode(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", err.Error())
+		case ErrCommunityLocked:
+			utils.RespondErrorWithCode(w, http.StatusForbidden, "COMMUNITY_LOCKED", err.Error())
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to join community")
 		}
@@ -363,7 +391,7 @@ func (h *Handler) JoinWithInvite(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	community, err := h.service.JoinWithInvite(r.Context(), code, userID)
+	community, err := h.service.JoinWithInvite(r.Context(), code, userID, middleware.ClientIP(r))
 	if err != nil {
 		switch err {
 		case ErrInvalidInvite:
@@ -372,6 +400,14 @@ func (h *Handler) JoinWithInvite(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusConflict, "Already a member of this community")
 		case ErrUserBanned:
 			utils.RespondError(w, http.StatusForbidden, "You are banned from this community")
+		case ErrVerificationLevel:
+			utils.RespondError(w, http.StatusForbidden, "Your account does not meet this community's verification level")
+		case ErrJoinRateLimited:
+			utils.RespondErrorWithCode(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", err.Error())
+		case ErrCommunityLocked:
+			utils.RespondErrorWithCode(w, http.StatusForbidden, "COMMUNITY_LOCKED", err.Error())
+		case ErrInvitesPaused:
+			utils.RespondErrorWithCode(w, http.StatusForbidden, "INVITES_PAUSED", "Invites to this community are paused")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to join community")
 		}
@@ -452,17 +488,18 @@ func (h *Handler) GetMembers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page := utils.GetQueryInt(r, "page", 1)
-	pageSize := utils.GetQueryInt(r, "pageSize", 50)
-	offset := (page - 1) * pageSize
-
-	members, total, err := h.service.GetMembers(r.Context(), id, pageSize, offset)
+	page := utils.GetCursorParams(r, 50, 100)
+	members, next, err := h.service.GetMembers(r.Context(), id, page.Cursor, page.Limit)
 	if err != nil {
+		if err == utils.ErrInvalidCursor {
+			utils.RespondInvalidCursor(w)
+			return
+		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to get members")
 		return
 	}
 
-	utils.RespondPaginated(w, members, total, page, pageSize)
+	utils.RespondCursorPage(w, members, next)
 }
 
 func (h *Handler) KickMember(w http.ResponseWriter, r *http.Request) {
@@ -490,6 +527,8 @@ func (h *Handler) KickMember(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
 		case ErrCannotRemoveOwner:
 			utils.RespondError(w, http.StatusForbidden, "Cannot kick the owner")
+		case ErrNotMember:
+			utils.RespondError(w, http.StatusNotFound, "User is not a member of this community")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to kick member")
 		}
@@ -520,7 +559,9 @@ func (h *Handler) BanMember(w http.ResponseWriter, r *http.Request) {
 
 	var req BanMemberRequest
 	// Body is optional for bans (reason is optional)
-	_ = utils.DecodeJSON(r, &req)
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
 
 	if err := h.service.BanMember(r.Context(), communityID, userID, targetID, req.Reason); err != nil {
 		switch err {
@@ -528,6 +569,8 @@ func (h *Handler) BanMember(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
 		case ErrCannotBanOwner:
 			utils.RespondError(w, http.StatusForbidden, "Cannot ban the owner")
+		case ErrUserBanned:
+			utils.RespondError(w, http.StatusConflict, "User is already banned")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to ban member")
 		}
@@ -598,6 +641,544 @@ func (h *Handler) GetBans(w http.ResponseWriter, r *http.Request) {
 	utils.RespondSuccess(w, bans)
 }
 
+// CreateCase warns, times out, kicks or bans a member and logs it as a numbered case
+func (h *Handler) CreateCase(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req CreateCaseRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	c, err := h.service.CreateCase(r.Context(), communityID, userID, &req)
+	if err != nil {
+		respondCaseError(w, err, "Failed to create case")
+		return
+	}
+
+	utils.RespondCreated(w, c)
+}
+
+func (h *Handler) GetCases(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var filter CaseFilter
+	if raw := r.URL.Query().Get("userId"); raw != "" {
+		targetID, err := uuid.Parse(raw)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		filter.UserID = &targetID
+	}
+	switch action := models.ModerationAction(r.URL.Query().Get("action")); action {
+	case "", models.ModerationActionWarn, models.ModerationActionTimeout, models.ModerationActionKick, models.ModerationActionBan:
+		filter.Action = action
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "Invalid action")
+		return
+	}
+
+	page := utils.GetCursorParams(r, 50, 100)
+
+	cases, next, err := h.service.GetCases(r.Context(), communityID, userID, filter, page.Cursor, page.Limit)
+	if err != nil {
+		respondCaseError(w, err, "Failed to get cases")
+		return
+	}
+
+	utils.RespondCursorPage(w, cases, next)
+}
+
+func (h *Handler) GetCase(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	caseNumber, err := strconv.Atoi(chi.URLParam(r, "caseNumber"))
+	if err != nil || caseNumber < 1 {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid case number")
+		return
+	}
+
+	c, err := h.service.GetCase(r.Context(), communityID, userID, caseNumber)
+	if err != nil {
+		respondCaseError(w, err, "Failed to get case")
+		return
+	}
+
+	utils.RespondSuccess(w, c)
+}
+
+// UpdateCase edits the reason or evidence of an existing case
+func (h *Handler) UpdateCase(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	caseNumber, err := strconv.Atoi(chi.URLParam(r, "caseNumber"))
+	if err != nil || caseNumber < 1 {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid case number")
+		return
+	}
+
+	var req UpdateCaseRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	c, err := h.service.UpdateCase(r.Context(), communityID, userID, caseNumber, &req)
+	if err != nil {
+		respondCaseError(w, err, "Failed to update case")
+		return
+	}
+
+	utils.RespondSuccess(w, c)
+}
+
+// GetMemberCases returns a user's case history with per-action counts
+func (h *Handler) GetMemberCases(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	history, err := h.service.GetMemberHistory(r.Context(), communityID, userID, targetID)
+	if err != nil {
+		respondCaseError(w, err, "Failed to get case history")
+		return
+	}
+
+	utils.RespondSuccess(w, history)
+}
+
+func (h *Handler) RemoveTimeout(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.service.RemoveTimeout(r.Context(), communityID, userID, targetID); err != nil {
+		respondCaseError(w, err, "Failed to remove timeout")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// QuarantineMember hides a member's messages from everyone except themselves and moderators
+func (h *Handler) QuarantineMember(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req QuarantineRequest
+	// Body is optional (reason is optional)
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	q, err := h.service.QuarantineMember(r.Context(), communityID, userID, targetID, req.Reason)
+	if err != nil {
+		respondCaseError(w, err, "Failed to quarantine member")
+		return
+	}
+
+	utils.RespondSuccess(w, q)
+}
+
+func (h *Handler) LiftQuarantine(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.service.LiftQuarantine(r.Context(), communityID, userID, targetID); err != nil {
+		respondCaseError(w, err, "Failed to lift quarantine")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func (h *Handler) GetQuarantines(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	quarantines, err := h.service.GetQuarantines(r.Context(), communityID, userID)
+	if err != nil {
+		respondCaseError(w, err, "Failed to get quarantined members")
+		return
+	}
+
+	utils.RespondSuccess(w, quarantines)
+}
+
+func (h *Handler) GetLockdown(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	lockdown, err := h.service.GetLockdown(r.Context(), communityID, userID)
+	if err != nil {
+		respondCaseError(w, err, "Failed to get lockdown")
+		return
+	}
+
+	utils.RespondSuccess(w, lockdown)
+}
+
+// EnableLockdown puts the community into lockdown. An empty body applies every
+// restriction with the defaults.
+func (h *Handler) EnableLockdown(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req LockdownRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	lockdown, err := h.service.EnableLockdown(r.Context(), communityID, userID, &req)
+	if err != nil {
+		respondCaseError(w, err, "Failed to enable lockdown")
+		return
+	}
+
+	utils.RespondSuccess(w, lockdown)
+}
+
+// DisableLockdown lifts the lockdown and restores the settings it changed
+func (h *Handler) DisableLockdown(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	lockdown, err := h.service.DisableLockdown(r.Context(), communityID, userID)
+	if err != nil {
+		respondCaseError(w, err, "Failed to disable lockdown")
+		return
+	}
+
+	utils.RespondSuccess(w, lockdown)
+}
+
+// GetEditPolicy returns the community's message edit policy
+func (h *Handler) GetEditPolicy(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	policy, err := h.service.GetEditPolicy(r.Context(), communityID, userID)
+	if err != nil {
+		respondCaseError(w, err, "Failed to get edit policy")
+		return
+	}
+
+	utils.RespondSuccess(w, policy)
+}
+
+func (h *Handler) UpdateEditPolicy(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req EditPolicyRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	policy, err := h.service.UpdateEditPolicy(r.Context(), communityID, userID, &req)
+	if err != nil {
+		respondCaseError(w, err, "Failed to update edit policy")
+		return
+	}
+
+	utils.RespondSuccess(w, policy)
+}
+
+// GetPartners lists the community's partners. Managers also see pending
+// requests in both directions.
+func (h *Handler) GetPartners(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	partners, err := h.service.ListPartners(r.Context(), communityID, userID)
+	if err != nil {
+		respondCaseError(w, err, "Failed to get partners")
+		return
+	}
+
+	utils.RespondSuccess(w, partners)
+}
+
+func (h *Handler) ProposePartner(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req ProposePartnerRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	partner, err := h.service.ProposePartner(r.Context(), communityID, userID, &req)
+	if err != nil {
+		respondCaseError(w, err, "Failed to propose partner")
+		return
+	}
+
+	utils.RespondCreated(w, partner)
+}
+
+func (h *Handler) AcceptPartner(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, partnerID, ok := partnerParams(w, r)
+	if !ok {
+		return
+	}
+
+	partner, err := h.service.AcceptPartner(r.Context(), communityID, userID, partnerID)
+	if err != nil {
+		respondCaseError(w, err, "Failed to accept partner")
+		return
+	}
+
+	utils.RespondSuccess(w, partner)
+}
+
+// RemovePartner unlinks a partner, withdraws this community's request or
+// declines one it received
+func (h *Handler) RemovePartner(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, partnerID, ok := partnerParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RemovePartner(r.Context(), communityID, userID, partnerID); err != nil {
+		respondCaseError(w, err, "Failed to remove partner")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func partnerParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	partnerID, err := uuid.Parse(chi.URLParam(r, "partnerId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid partner ID")
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, communityID, partnerID, true
+}
+
+func respondCaseError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case utils.ErrInvalidCursor:
+		utils.RespondInvalidCursor(w)
+	case ErrInsufficientPerms:
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case ErrCannotRemoveOwner, ErrCannotBanOwner, ErrCannotModerateOwner:
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case ErrCommunityNotFound:
+		utils.RespondError(w, http.StatusNotFound, "Community not found")
+	case ErrCaseNotFound:
+		utils.RespondError(w, http.StatusNotFound, "Case not found")
+	case ErrNotMember:
+		utils.RespondError(w, http.StatusNotFound, "User is not a member of this community")
+	case ErrNotTimedOut:
+		utils.RespondError(w, http.StatusNotFound, "Member is not timed out")
+	case ErrNotQuarantined:
+		utils.RespondError(w, http.StatusNotFound, "Member is not quarantined")
+	case ErrAlreadyQuarantined:
+		utils.RespondError(w, http.StatusConflict, "Member is already quarantined")
+	case ErrLockdownActive, ErrLockdownInactive:
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case ErrPartnerNotFound:
+		utils.RespondError(w, http.StatusNotFound, "Partner not found")
+	case ErrAlreadyPartners, ErrPartnerPending, ErrPartnerLimit:
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case ErrPartnerCooldown:
+		utils.RespondError(w, http.StatusTooManyRequests, err.Error())
+	case ErrPartnerSelf:
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case ErrUserBanned:
+		utils.RespondError(w, http.StatusConflict, "User is already banned")
+	case ErrInvalidEvidence, ErrTimeoutDuration, ErrInvalidCaseAction:
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
 func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -611,13 +1192,13 @@ func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page := utils.GetQueryInt(r, "page", 1)
-	pageSize := utils.GetQueryInt(r, "pageSize", 50)
-	offset := (page - 1) * pageSize
+	page := utils.GetCursorParams(r, 50, 100)
 
-	logs, total, err := h.service.GetAuditLogs(r.Context(), communityID, userID, pageSize, offset)
+	logs, next, err := h.service.GetAuditLogs(r.Context(), communityID, userID, page.Cursor, page.Limit)
 	if err != nil {
 		switch err {
+		case utils.ErrInvalidCursor:
+			utils.RespondInvalidCursor(w)
 		case ErrInsufficientPerms:
 			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
 		default:
@@ -626,7 +1207,7 @@ func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.RespondPaginated(w, logs, total, page, pageSize)
+	utils.RespondCursorPage(w, logs, next)
 }
 
 func (h *Handler) GetInvites(w http.ResponseWriter, r *http.Request) {
@@ -669,12 +1250,8 @@ func (h *Handler) CreateInvite(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		MaxUses   *int   `json:"maxUses" validate:"omitempty,min=1,max=100"`
-		ExpiresIn *int64 `json:"expiresIn"` // Duration in seconds
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req CreateInviteRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -755,13 +1332,7 @@ func (h *Handler) CreateRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateRoleRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -833,13 +1404,7 @@ func (h *Handler) UpdateRole(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateRoleRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -918,11 +1483,8 @@ func (h *Handler) SetMemberRoles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		RoleIDs []uuid.UUID `json:"roleIds"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req SetMemberRolesRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
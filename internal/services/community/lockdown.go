@@ -0,0 +1,479 @@
+package community
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+var (
+	ErrLockdownActive   = errors.New("community is already in lockdown")
+	ErrLockdownInactive = errors.New("community is not in lockdown")
+	ErrCommunityLocked  = errors.New("this community is in lockdown and not accepting new members")
+)
+
+const (
+	defaultLockdownSlowmode        = 30
+	defaultLockdownMinRolePosition = 1
+)
+
+// LockdownRequest configures a lockdown. Everything is on by default so an
+// empty body is the one-click panic button.
+type LockdownRequest struct {
+	StopJoins       *bool `json:"stopJoins"`
+	SlowmodeSeconds *int  `json:"slowmodeSeconds" validate:"omitempty,min=0,max=21600"`
+	RestrictPosting *bool `json:"restrictPosting"`
+	// MinRolePosition is the lowest role position that may still post; the
+	// default role never can
+	MinRolePosition *int    `json:"minRolePosition" validate:"omitempty,min=1"`
+	SilenceEveryone *bool   `json:"silenceEveryone"`
+	DurationMinutes *int    `json:"durationMinutes" validate:"omitempty,min=5,max=10080"`
+	Reason          *string `json:"reason" validate:"omitempty,max=512"`
+}
+
+func (r *LockdownRequest) lockdown(communityID uuid.UUID) *models.Lockdown {
+	l := &models.Lockdown{
+		CommunityID:     communityID,
+		State:           models.LockdownActive,
+		StopJoins:       r.StopJoins == nil || *r.StopJoins,
+		SlowmodeSeconds: defaultLockdownSlowmode,
+		SilenceEveryone: r.SilenceEveryone == nil || *r.SilenceEveryone,
+		Reason:          r.Reason,
+	}
+	if r.SlowmodeSeconds != nil {
+		l.SlowmodeSeconds = *r.SlowmodeSeconds
+	}
+	if r.RestrictPosting == nil || *r.RestrictPosting {
+		position := defaultLockdownMinRolePosition
+		if r.MinRolePosition != nil {
+			position = *r.MinRolePosition
+		}
+		l.MinRolePosition = &position
+	}
+	if r.DurationMinutes != nil {
+		expiresAt := time.Now().Add(time.Duration(*r.DurationMinutes) * time.Minute)
+		l.ExpiresAt = &expiresAt
+	}
+	return l
+}
+
+// GetLockdown returns the community's current or most recent lockdown
+func (s *Service) GetLockdown(ctx context.Context, communityID, actorID uuid.UUID) (*models.Lockdown, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionBanMembers); err != nil {
+		return nil, err
+	}
+	return s.getLockdown(ctx, communityID)
+}
+
+// EnableLockdown moves the community from inactive to active: joins stop,
+// every channel gets at least the lockdown slowmode, roles below the threshold
+// lose Send Messages and no role below Administrator keeps Mention Everyone.
+// Role-targeted channel overwrites lose the same allows so they can't grant
+// them back. What was changed is stored with the lockdown so lifting it
+// restores exactly that and nothing else.
+func (s *Service) EnableLockdown(ctx context.Context, communityID, actorID uuid.UUID, req *LockdownRequest) (*models.Lockdown, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionBanMembers); err != nil {
+		return nil, err
+	}
+
+	l := req.lockdown(communityID)
+	l.EnabledBy = &actorID
+
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		state, err := lockLockdownRow(ctx, tx, communityID)
+		if err != nil {
+			return err
+		}
+		if state == models.LockdownActive {
+			return ErrLockdownActive
+		}
+
+		l.Snapshot, err = applyLockdown(ctx, tx, l)
+		if err != nil {
+			return err
+		}
+		snapshot, err := json.Marshal(l.Snapshot)
+		if err != nil {
+			return err
+		}
+
+		return tx.QueryRow(ctx,
+			`UPDATE community_lockdowns
+			SET state = $2, stop_joins = $3, slowmode_seconds = $4, min_role_position = $5, silence_everyone = $6,
+			    reason = $7, snapshot = $8, enabled_by = $9, enabled_at = NOW(), expires_at = $10,
+			    disabled_by = NULL, disabled_at = NULL, updated_at = NOW()
+			WHERE community_id = $1
+			RETURNING enabled_at`,
+			communityID, l.State, l.StopJoins, l.SlowmodeSeconds, l.MinRolePosition, l.SilenceEveryone,
+			l.Reason, snapshot, actorID, l.ExpiresAt,
+		).Scan(&l.EnabledAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.permissions.InvalidateCommunity(ctx, communityID)
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"stopJoins":       l.StopJoins,
+		"slowmodeSeconds": l.SlowmodeSeconds,
+		"minRolePosition": l.MinRolePosition,
+		"silenceEveryone": l.SilenceEveryone,
+		"expiresAt":       l.ExpiresAt,
+		"reason":          l.Reason,
+		"channels":        len(l.Snapshot.Channels),
+		"roles":           len(l.Snapshot.Roles),
+		"overrides":       len(l.Snapshot.Overrides),
+	})
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionLockdownEnable, "community", &communityID, details)
+	s.broadcastLockdown(ctx, l)
+
+	return l, nil
+}
+
+// DisableLockdown moves an active lockdown back to inactive and restores the
+// settings it changed.
+func (s *Service) DisableLockdown(ctx context.Context, communityID, actorID uuid.UUID) (*models.Lockdown, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionBanMembers); err != nil {
+		return nil, err
+	}
+	return s.liftLockdown(ctx, communityID, &actorID)
+}
+
+// ExpireLockdowns lifts lockdowns whose duration has run out
+func (s *Service) ExpireLockdowns(ctx context.Context) (int64, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT community_id FROM community_lockdowns
+		WHERE state = $1 AND expires_at IS NOT NULL AND expires_at <= NOW()`,
+		models.LockdownActive,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var communityIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		communityIDs = append(communityIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var lifted int64
+	for _, communityID := range communityIDs {
+		if _, err := s.liftLockdown(ctx, communityID, nil); err != nil {
+			if !errors.Is(err, ErrLockdownInactive) {
+				log.Error().Err(err).Str("communityId", communityID.String()).Msg("Failed to lift expired lockdown")
+			}
+			continue
+		}
+		lifted++
+	}
+	return lifted, nil
+}
+
+// liftLockdown undoes an active lockdown. actorID is nil when it expired.
+// Slowmode and permissions are only put back where they're still at the
+// lockdown value, so a moderator's change made during the lockdown is kept.
+func (s *Service) liftLockdown(ctx context.Context, communityID uuid.UUID, actorID *uuid.UUID) (*models.Lockdown, error) {
+	var l *models.Lockdown
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		state, err := lockLockdownRow(ctx, tx, communityID)
+		if err != nil {
+			return err
+		}
+		if state != models.LockdownActive {
+			return ErrLockdownInactive
+		}
+
+		l, err = scanLockdown(tx.QueryRow(ctx, `SELECT `+lockdownColumns+` FROM community_lockdowns WHERE community_id = $1`, communityID))
+		if err != nil {
+			return err
+		}
+
+		for _, c := range l.Snapshot.Channels {
+			if _, err := tx.Exec(ctx,
+				`UPDATE channels SET slowmode_seconds = $2, updated_at = NOW() WHERE id = $1 AND slowmode_seconds = $3`,
+				c.ChannelID, c.SlowmodeSeconds, l.SlowmodeSeconds,
+			); err != nil {
+				return err
+			}
+		}
+		for _, r := range l.Snapshot.Roles {
+			query, args := restorePermissions("roles", "permissions", r.Permissions, r.Removed)
+			if _, err := tx.Exec(ctx, query, append([]any{r.RoleID}, args...)...); err != nil {
+				return err
+			}
+		}
+		for _, o := range l.Snapshot.Overrides {
+			query, args := restorePermissions("channel_permissions", "allow_permissions", o.AllowPermissions, o.Removed)
+			if _, err := tx.Exec(ctx, query, append([]any{o.OverrideID}, args...)...); err != nil {
+				return err
+			}
+		}
+
+		l.State = models.LockdownInactive
+		l.DisabledBy = actorID
+		return tx.QueryRow(ctx,
+			`UPDATE community_lockdowns SET state = $2, disabled_by = $3, disabled_at = NOW(), updated_at = NOW()
+			WHERE community_id = $1
+			RETURNING disabled_at`,
+			communityID, l.State, actorID,
+		).Scan(&l.DisabledAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.permissions.InvalidateCommunity(ctx, communityID)
+
+	auditActor := actorID
+	if auditActor == nil {
+		auditActor = l.EnabledBy
+	}
+	if auditActor != nil {
+		details, _ := json.Marshal(map[string]interface{}{
+			"expired":   actorID == nil,
+			"channels":  len(l.Snapshot.Channels),
+			"roles":     len(l.Snapshot.Roles),
+			"overrides": len(l.Snapshot.Overrides),
+		})
+		s.LogAudit(ctx, &communityID, *auditActor, models.AuditActionLockdownDisable, "community", &communityID, details)
+	}
+	s.broadcastLockdown(ctx, l)
+
+	return l, nil
+}
+
+// restorePermissions is the UPDATE that puts a role's or overwrite's
+// permission column back to the set saved before the lockdown. Like slowmode,
+// it's only put back where the column still holds what the lockdown left, so
+// a moderator's change made during the lockdown is kept. Lockdowns enabled
+// before the full set was saved only know what they removed, which is added
+// back instead.
+func restorePermissions(table, column string, saved, removed int64) (string, []any) {
+	if saved == 0 {
+		return `UPDATE ` + table + ` SET ` + column + ` = ` + column + ` | $2 WHERE id = $1`, []any{removed}
+	}
+	return `UPDATE ` + table + ` SET ` + column + ` = $2 WHERE id = $1 AND ` + column + ` = $3`, []any{saved, saved &^ removed}
+}
+
+// joinsLocked reports whether a lockdown is keeping new members out. Errors
+// fail open, like the join guard.
+func (s *Service) joinsLocked(ctx context.Context, communityID uuid.UUID) bool {
+	var locked bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM community_lockdowns WHERE community_id = $1 AND state = $2 AND stop_joins)`,
+		communityID, models.LockdownActive,
+	).Scan(&locked)
+	if err != nil {
+		log.Warn().Err(err).Str("communityId", communityID.String()).Msg("Lockdown check failed")
+		return false
+	}
+	return locked
+}
+
+// lockLockdownRow makes sure the community has a lockdown row and locks it,
+// so concurrent toggles run one after the other
+func lockLockdownRow(ctx context.Context, tx pgx.Tx, communityID uuid.UUID) (models.LockdownState, error) {
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO community_lockdowns (community_id) VALUES ($1) ON CONFLICT (community_id) DO NOTHING`,
+		communityID,
+	); err != nil {
+		return "", err
+	}
+
+	var state models.LockdownState
+	err := tx.QueryRow(ctx,
+		`SELECT state FROM community_lockdowns WHERE community_id = $1 FOR UPDATE`,
+		communityID,
+	).Scan(&state)
+	return state, err
+}
+
+// applyLockdown makes the lockdown's changes and returns what they replaced
+func applyLockdown(ctx context.Context, tx pgx.Tx, l *models.Lockdown) (*models.LockdownSnapshot, error) {
+	snapshot := emptyLockdownSnapshot()
+
+	if l.SlowmodeSeconds > 0 {
+		rows, err := tx.Query(ctx,
+			`UPDATE channels c SET slowmode_seconds = $2, updated_at = NOW()
+			FROM (
+				SELECT id, COALESCE(slowmode_seconds, 0) AS previous FROM channels
+				WHERE community_id = $1 AND COALESCE(slowmode_seconds, 0) < $2
+				FOR UPDATE
+			) old
+			WHERE c.id = old.id
+			RETURNING c.id, old.previous`,
+			l.CommunityID, l.SlowmodeSeconds,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var c models.LockdownChannel
+			if err := rows.Scan(&c.ChannelID, &c.SlowmodeSeconds); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			snapshot.Channels = append(snapshot.Channels, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// What each role loses; administrators keep everything
+	masks := make(map[uuid.UUID]int64)
+	rows, err := tx.Query(ctx,
+		`SELECT id, position, is_default, permissions FROM roles WHERE community_id = $1 FOR UPDATE`,
+		l.CommunityID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var position int
+		var isDefault bool
+		var permissions int64
+		if err := rows.Scan(&id, &position, &isDefault, &permissions); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if permissions&models.PermissionAdministrator != 0 {
+			continue
+		}
+		mask := lockdownMask(l, position, isDefault)
+		masks[id] = mask
+		if removed := permissions & mask; removed != 0 {
+			snapshot.Roles = append(snapshot.Roles, models.LockdownRole{RoleID: id, Permissions: permissions, Removed: removed})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.Query(ctx,
+		`SELECT cp.id, cp.channel_id, cp.target_id, cp.allow_permissions
+		FROM channel_permissions cp
+		JOIN channels c ON c.id = cp.channel_id
+		WHERE c.community_id = $1 AND cp.target_type = 'role'
+		FOR UPDATE OF cp`,
+		l.CommunityID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var o models.LockdownOverride
+		if err := rows.Scan(&o.OverrideID, &o.ChannelID, &o.RoleID, &o.AllowPermissions); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if o.Removed = o.AllowPermissions & masks[o.RoleID]; o.Removed != 0 {
+			snapshot.Overrides = append(snapshot.Overrides, o)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range snapshot.Roles {
+		if _, err := tx.Exec(ctx,
+			`UPDATE roles SET permissions = permissions & ~$2::bigint WHERE id = $1`,
+			r.RoleID, r.Removed,
+		); err != nil {
+			return nil, err
+		}
+	}
+	for _, o := range snapshot.Overrides {
+		if _, err := tx.Exec(ctx,
+			`UPDATE channel_permissions SET allow_permissions = allow_permissions & ~$2::bigint WHERE id = $1`,
+			o.OverrideID, o.Removed,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshot, nil
+}
+
+// lockdownMask is the permissions a lockdown takes from a role below Administrator
+func lockdownMask(l *models.Lockdown, position int, isDefault bool) int64 {
+	var mask int64
+	if l.MinRolePosition != nil && (isDefault || position < *l.MinRolePosition) {
+		mask |= models.PermissionSendMessages
+	}
+	if l.SilenceEveryone {
+		mask |= models.PermissionMentionEveryone
+	}
+	return mask
+}
+
+// broadcastLockdown tells clients the lockdown changed. The snapshot is left
+// out; clients refetch channels and roles.
+func (s *Service) broadcastLockdown(ctx context.Context, l *models.Lockdown) {
+	event := *l
+	event.Snapshot = nil
+	s.broadcast(ctx, l.CommunityID, "COMMUNITY_LOCKDOWN_UPDATE", &event)
+}
+
+const lockdownColumns = `community_id, state, stop_joins, slowmode_seconds, min_role_position, silence_everyone, reason,
+	snapshot, enabled_by, enabled_at, expires_at, disabled_by, disabled_at`
+
+func (s *Service) getLockdown(ctx context.Context, communityID uuid.UUID) (*models.Lockdown, error) {
+	l, err := scanLockdown(s.db.QueryRow(ctx,
+		`SELECT `+lockdownColumns+` FROM community_lockdowns WHERE community_id = $1`,
+		communityID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &models.Lockdown{
+			CommunityID: communityID,
+			State:       models.LockdownInactive,
+			Snapshot:    emptyLockdownSnapshot(),
+		}, nil
+	}
+	return l, err
+}
+
+func scanLockdown(row pgx.Row) (*models.Lockdown, error) {
+	l := &models.Lockdown{}
+	var snapshot []byte
+	err := row.Scan(
+		&l.CommunityID, &l.State, &l.StopJoins, &l.SlowmodeSeconds, &l.MinRolePosition, &l.SilenceEveryone, &l.Reason,
+		&snapshot, &l.EnabledBy, &l.EnabledAt, &l.ExpiresAt, &l.DisabledBy, &l.DisabledAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	l.Snapshot = emptyLockdownSnapshot()
+	if len(snapshot) > 0 {
+		if err := json.Unmarshal(snapshot, l.Snapshot); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func emptyLockdownSnapshot() *models.LockdownSnapshot {
+	return &models.LockdownSnapshot{
+		Channels:  []models.LockdownChannel{},
+		Roles:     []models.LockdownRole{},
+		Overrides: []models.LockdownOverride{},
+	}
+}
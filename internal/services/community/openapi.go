@@ -0,0 +1,304 @@
+package community
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+	code := []openapi.Param{{Name: "code", Description: "Invite code"}}
+	member := []openapi.Param{openapi.ID("id"), openapi.ID("userId")}
+	caseNumber := []openapi.Param{openapi.ID("id"), {Name: "caseNumber", Type: "integer"}}
+	partner := []openapi.Param{openapi.ID("id"), openapi.ID("partnerId")}
+	role := []openapi.Param{openapi.ID("id"), openapi.ID("roleId")}
+
+	openapi.Describe((*Handler).DiscoverCommunities, openapi.Operation{
+		Summary:  "Search public communities",
+		Query:    append([]openapi.Param{{Name: "q", Description: "Search text"}}, openapi.CursorQuery...),
+		Response: []*models.Community{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).GetInviteInfo, openapi.Operation{
+		Summary: "Look up the community an invite is for",
+		Path:    code,
+		Response: struct {
+			Community models.Community `json:"community"`
+			Valid     bool             `json:"valid"`
+		}{},
+	})
+	openapi.Describe((*Handler).GetDiscordImportStatus, openapi.Operation{
+		Summary:  "Check whether Discord imports are configured",
+		Response: map[string]bool{},
+	})
+	openapi.Describe((*Handler).ImportDiscordServer, openapi.Operation{
+		Summary:     "Import a Discord server export",
+		Description: "Needs the configured import token in the X-Discord-Import-Token header.",
+		Body:        DiscordImportRequest{},
+		Response:    DiscordImportResponse{},
+		Status:      http.StatusCreated,
+	})
+
+	openapi.Describe((*Handler).CreateCommunity, openapi.Operation{
+		Summary:  "Create a community",
+		Body:     CreateCommunityRequest{},
+		Response: models.Community{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetUserCommunities, openapi.Operation{
+		Summary:  "List the current user's communities",
+		Response: []*models.Community{},
+	})
+	openapi.Describe((*Handler).JoinWithInvite, openapi.Operation{
+		Summary:  "Join a community with an invite",
+		Path:     code,
+		Response: models.Community{},
+	})
+
+	openapi.Describe((*Handler).GetCommunity, openapi.Operation{
+		Summary:  "Get a community",
+		Path:     id,
+		Response: models.Community{},
+	})
+	openapi.Describe((*Handler).UpdateCommunity, openapi.Operation{
+		Summary:  "Update a community",
+		Path:     id,
+		Body:     UpdateCommunityRequest{},
+		Response: models.Community{},
+	})
+	openapi.Describe((*Handler).DeleteCommunity, openapi.Operation{
+		Summary: "Delete a community",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RemoveCommunityIcon, openapi.Operation{
+		Summary: "Remove a community's icon",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RemoveCommunityBanner, openapi.Operation{
+		Summary: "Remove a community's banner",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).JoinCommunity, openapi.Operation{
+		Summary: "Join an open community",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).LeaveCommunity, openapi.Operation{
+		Summary: "Leave a community",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetMembers, openapi.Operation{
+		Summary:  "Page through a community's members",
+		Path:     id,
+		Query:    openapi.CursorQuery,
+		Response: []*models.CommunityMemberWithUser{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).KickMember, openapi.Operation{
+		Summary: "Kick a member",
+		Path:    member,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetMemberRoles, openapi.Operation{
+		Summary:  "List a member's roles",
+		Path:     member,
+		Response: []*models.Role{},
+	})
+	openapi.Describe((*Handler).SetMemberRoles, openapi.Operation{
+		Summary: "Replace a member's roles",
+		Path:    member,
+		Body:    SetMemberRolesRequest{},
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetBans, openapi.Operation{
+		Summary:  "List a community's bans",
+		Path:     id,
+		Response: []*models.CommunityBanWithUser{},
+	})
+	openapi.Describe((*Handler).BanMember, openapi.Operation{
+		Summary:      "Ban a user",
+		Path:         member,
+		Body:         BanMemberRequest{},
+		BodyOptional: true,
+		Status:       http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UnbanMember, openapi.Operation{
+		Summary: "Lift a ban",
+		Path:    member,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetCases, openapi.Operation{
+		Summary: "Search a community's moderation cases",
+		Path:    id,
+		Query: append([]openapi.Param{
+			{Name: "userId", Format: "uuid", Description: "Only cases against this user"},
+			{Name: "action", Enum: []string{
+				string(models.ModerationActionWarn),
+				string(models.ModerationActionTimeout),
+				string(models.ModerationActionKick),
+				string(models.ModerationActionBan),
+			}},
+		}, openapi.CursorQuery...),
+		Response: []*models.ModerationCaseWithUsers{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).CreateCase, openapi.Operation{
+		Summary:     "Warn, time out, kick or ban a member",
+		Description: "Records a moderation case and applies its action.",
+		Path:        id,
+		Body:        CreateCaseRequest{},
+		Response:    models.ModerationCase{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetCase, openapi.Operation{
+		Summary:  "Get a moderation case",
+		Path:     caseNumber,
+		Response: models.ModerationCaseWithUsers{},
+	})
+	openapi.Describe((*Handler).UpdateCase, openapi.Operation{
+		Summary:  "Update a moderation case's reason or evidence",
+		Path:     caseNumber,
+		Body:     UpdateCaseRequest{},
+		Response: models.ModerationCaseWithUsers{},
+	})
+	openapi.Describe((*Handler).GetMemberCases, openapi.Operation{
+		Summary:  "Get a member's moderation history",
+		Path:     member,
+		Response: models.ModerationHistory{},
+	})
+	openapi.Describe((*Handler).RemoveTimeout, openapi.Operation{
+		Summary: "End a member's timeout early",
+		Path:    member,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetQuarantines, openapi.Operation{
+		Summary:  "List quarantined members",
+		Path:     id,
+		Response: []*models.Quarantine{},
+	})
+	openapi.Describe((*Handler).QuarantineMember, openapi.Operation{
+		Summary:      "Quarantine a member",
+		Path:         member,
+		Body:         QuarantineRequest{},
+		BodyOptional: true,
+		Response:     models.Quarantine{},
+	})
+	openapi.Describe((*Handler).LiftQuarantine, openapi.Operation{
+		Summary: "Lift a member's quarantine",
+		Path:    member,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetLockdown, openapi.Operation{
+		Summary:  "Get a community's lockdown state",
+		Path:     id,
+		Response: models.Lockdown{},
+	})
+	openapi.Describe((*Handler).EnableLockdown, openapi.Operation{
+		Summary:      "Lock a community down",
+		Path:         id,
+		Body:         LockdownRequest{},
+		BodyOptional: true,
+		Response:     models.Lockdown{},
+	})
+	openapi.Describe((*Handler).DisableLockdown, openapi.Operation{
+		Summary:  "End a community's lockdown",
+		Path:     id,
+		Response: models.Lockdown{},
+	})
+
+	openapi.Describe((*Handler).GetEditPolicy, openapi.Operation{
+		Summary:  "Get a community's message edit policy",
+		Path:     id,
+		Response: models.EditPolicy{},
+	})
+	openapi.Describe((*Handler).UpdateEditPolicy, openapi.Operation{
+		Summary:  "Update a community's message edit policy",
+		Path:     id,
+		Body:     EditPolicyRequest{},
+		Response: models.EditPolicy{},
+	})
+
+	openapi.Describe((*Handler).GetPartners, openapi.Operation{
+		Summary:  "List a community's partnerships",
+		Path:     id,
+		Response: []*models.CommunityPartner{},
+	})
+	openapi.Describe((*Handler).ProposePartner, openapi.Operation{
+		Summary:  "Propose a partnership to another community",
+		Path:     id,
+		Body:     ProposePartnerRequest{},
+		Response: models.CommunityPartner{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).AcceptPartner, openapi.Operation{
+		Summary:  "Accept a proposed partnership",
+		Path:     partner,
+		Response: models.CommunityPartner{},
+	})
+	openapi.Describe((*Handler).RemovePartner, openapi.Operation{
+		Summary: "End or decline a partnership",
+		Path:    partner,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetAuditLog, openapi.Operation{
+		Summary:  "Page through a community's audit log",
+		Path:     id,
+		Query:    openapi.CursorQuery,
+		Response: []*models.AuditLogWithActor{},
+		Shape:    openapi.Page,
+	})
+
+	openapi.Describe((*Handler).GetInvites, openapi.Operation{
+		Summary:  "List a community's invites",
+		Path:     id,
+		Response: []*models.CommunityInvite{},
+	})
+	openapi.Describe((*Handler).CreateInvite, openapi.Operation{
+		Summary:  "Create an invite",
+		Path:     id,
+		Body:     CreateInviteRequest{},
+		Response: models.CommunityInvite{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).DeleteInvite, openapi.Operation{
+		Summary: "Delete an invite",
+		Path:    []openapi.Param{openapi.ID("id"), openapi.ID("inviteId")},
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetRoles, openapi.Operation{
+		Summary:  "List a community's roles",
+		Path:     id,
+		Response: []*models.Role{},
+	})
+	openapi.Describe((*Handler).CreateRole, openapi.Operation{
+		Summary:  "Create a role",
+		Path:     id,
+		Body:     CreateRoleRequest{},
+		Response: models.Role{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).UpdateRole, openapi.Operation{
+		Summary:  "Update a role",
+		Path:     role,
+		Body:     UpdateRoleRequest{},
+		Response: models.Role{},
+	})
+	openapi.Describe((*Handler).DeleteRole, openapi.Operation{
+		Summary: "Delete a role",
+		Path:    role,
+		Status:  http.StatusNoContent,
+	})
+}
@@ -0,0 +1,418 @@
+package community
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+var (
+	ErrPartnerNotFound = errors.New("partner link not found")
+	ErrPartnerSelf     = errors.New("a community cannot partner with itself")
+	ErrAlreadyPartners = errors.New("these communities are already partners")
+	ErrPartnerPending  = errors.New("a partner request is already pending")
+	ErrPartnerLimit    = errors.New("partner limit reached")
+	ErrPartnerCooldown = errors.New("this community declined a partner request recently")
+)
+
+const (
+	// MaxPartners caps accepted links per community
+	MaxPartners = 10
+	// MaxPendingPartnerRequests caps outgoing requests awaiting an answer
+	MaxPendingPartnerRequests = 5
+	// partnerDeclineCooldown is how long a declined requester has to wait
+	// before asking the same community again
+	partnerDeclineCooldown = 7 * 24 * time.Hour
+)
+
+type ProposePartnerRequest struct {
+	CommunityID uuid.UUID `json:"communityId" validate:"required"`
+}
+
+// partnerRow is a community_partners row before it is turned into the view of
+// one side
+type partnerRow struct {
+	ID          uuid.UUID
+	RequesterID uuid.UUID
+	TargetID    uuid.UUID
+	Status      string
+	ProposedBy  *uuid.UUID
+	AcceptedBy  *uuid.UUID
+	CreatedAt   time.Time
+	AcceptedAt  *time.Time
+	DeclinedAt  *time.Time
+}
+
+func (p *partnerRow) other(communityID uuid.UUID) uuid.UUID {
+	if p.RequesterID == communityID {
+		return p.TargetID
+	}
+	return p.RequesterID
+}
+
+// ListPartners returns the community's partner links. Everyone sees accepted
+// links to public communities; managers also see private partners and
+// requests that are still pending in either direction.
+func (s *Service) ListPartners(ctx context.Context, communityID, actorID uuid.UUID) ([]*models.CommunityPartner, error) {
+	if _, err := s.GetCommunity(ctx, communityID); err != nil {
+		return nil, err
+	}
+	manager := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity) == nil
+
+	rows, err := s.db.Query(ctx,
+		`SELECT p.id, p.status, p.requester_id = $1, p.proposed_by, p.accepted_by, p.created_at, p.accepted_at,
+		        c.id, c.name, c.icon_url, c.member_count, c.is_public
+		FROM community_partners p
+		JOIN communities c ON c.id = CASE WHEN p.requester_id = $1 THEN p.target_id ELSE p.requester_id END
+		WHERE (p.requester_id = $1 OR p.target_id = $1) AND c.deleted_at IS NULL
+		  AND ((p.status = $2 AND (c.is_public OR $3)) OR ($3 AND p.status = $4))
+		ORDER BY p.status, c.name`,
+		communityID, models.PartnerStatusAccepted, manager, models.PartnerStatusPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partners := []*models.CommunityPartner{}
+	for rows.Next() {
+		p := &models.CommunityPartner{Community: &models.PartnerCommunity{}}
+		if err := rows.Scan(
+			&p.ID, &p.Status, &p.Outgoing, &p.ProposedBy, &p.AcceptedBy, &p.CreatedAt, &p.AcceptedAt,
+			&p.Community.ID, &p.Community.Name, &p.Community.IconURL, &p.Community.MemberCount, &p.Community.IsPublic,
+		); err != nil {
+			return nil, err
+		}
+		partners = append(partners, p)
+	}
+	return partners, rows.Err()
+}
+
+// ProposePartner asks another community to become a partner. If that
+// community already asked this one, the request is accepted instead. The
+// target has to be public unless the actor is a member of it, so private
+// communities can't be found by probing.
+func (s *Service) ProposePartner(ctx context.Context, communityID, actorID uuid.UUID, req *ProposePartnerRequest) (*models.CommunityPartner, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+	targetID := req.CommunityID
+	if targetID == communityID {
+		return nil, ErrPartnerSelf
+	}
+	target, err := s.GetCommunity(ctx, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if !target.IsPublic && !s.IsMember(ctx, targetID, actorID) {
+		return nil, ErrCommunityNotFound
+	}
+
+	var row *partnerRow
+	action := models.AuditActionPartnerPropose
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		if err := lockPartnerCommunities(ctx, tx, communityID, targetID); err != nil {
+			return err
+		}
+
+		existing, err := getPartnerRow(ctx, tx, communityID, targetID)
+		if err != nil && !errors.Is(err, ErrPartnerNotFound) {
+			return err
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Status == models.PartnerStatusAccepted:
+				return ErrAlreadyPartners
+			case existing.Status == models.PartnerStatusPending && existing.RequesterID == communityID:
+				return ErrPartnerPending
+			case existing.Status == models.PartnerStatusPending:
+				// They asked first, so asking back is agreeing
+				action = models.AuditActionPartnerAccept
+				row, err = acceptPartnerRow(ctx, tx, existing, actorID)
+				return err
+			case existing.RequesterID == communityID && existing.DeclinedAt != nil &&
+				time.Since(*existing.DeclinedAt) < partnerDeclineCooldown:
+				return ErrPartnerCooldown
+			}
+		}
+
+		if err := checkPartnerLimits(ctx, tx, communityID, true); err != nil {
+			return err
+		}
+
+		if existing != nil {
+			row = existing
+			return tx.QueryRow(ctx,
+				`UPDATE community_partners
+				SET requester_id = $2, target_id = $3, status = $4, proposed_by = $5,
+				    accepted_by = NULL, accepted_at = NULL, declined_at = NULL, created_at = NOW()
+				WHERE id = $1
+				RETURNING requester_id, target_id, status, proposed_by, accepted_by, created_at, accepted_at, declined_at`,
+				existing.ID, communityID, targetID, models.PartnerStatusPending, actorID,
+			).Scan(&row.RequesterID, &row.TargetID, &row.Status, &row.ProposedBy, &row.AcceptedBy, &row.CreatedAt, &row.AcceptedAt, &row.DeclinedAt)
+		}
+
+		row = &partnerRow{}
+		return tx.QueryRow(ctx,
+			`INSERT INTO community_partners (requester_id, target_id, status, proposed_by)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id, requester_id, target_id, status, proposed_by, accepted_by, created_at, accepted_at, declined_at`,
+			communityID, targetID, models.PartnerStatusPending, actorID,
+		).Scan(&row.ID, &row.RequesterID, &row.TargetID, &row.Status, &row.ProposedBy, &row.AcceptedBy, &row.CreatedAt, &row.AcceptedAt, &row.DeclinedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{"partnerName": target.Name})
+	s.LogAudit(ctx, &communityID, actorID, action, "community", &targetID, details)
+	return s.broadcastPartner(ctx, "COMMUNITY_PARTNER_UPDATE", row, communityID)
+}
+
+// AcceptPartner accepts a pending request partnerID sent to this community
+func (s *Service) AcceptPartner(ctx context.Context, communityID, actorID, partnerID uuid.UUID) (*models.CommunityPartner, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity); err != nil {
+		return nil, err
+	}
+
+	var row *partnerRow
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		if err := lockPartnerCommunities(ctx, tx, communityID, partnerID); err != nil {
+			return err
+		}
+		existing, err := getPartnerRow(ctx, tx, communityID, partnerID)
+		if err != nil {
+			return err
+		}
+		if existing.Status == models.PartnerStatusAccepted {
+			return ErrAlreadyPartners
+		}
+		if existing.Status != models.PartnerStatusPending || existing.TargetID != communityID {
+			return ErrPartnerNotFound
+		}
+		row, err = acceptPartnerRow(ctx, tx, existing, actorID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionPartnerAccept, "community", &partnerID, nil)
+	return s.broadcastPartner(ctx, "COMMUNITY_PARTNER_UPDATE", row, communityID)
+}
+
+// RemovePartner ends the link with partnerID from this community's side:
+// an accepted link or this community's own request is deleted, while a
+// request received from partnerID is marked declined so it can't be resent
+// straight away.
+func (s *Service) RemovePartner(ctx context.Context, communityID, actorID, partnerID uuid.UUID) error {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageCommunity); err != nil {
+		return err
+	}
+
+	var row *partnerRow
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		var err error
+		row, err = getPartnerRow(ctx, tx, communityID, partnerID)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case row.Status == models.PartnerStatusDeclined:
+			return ErrPartnerNotFound
+		case row.Status == models.PartnerStatusPending && row.TargetID == communityID:
+			_, err = tx.Exec(ctx,
+				`UPDATE community_partners SET status = $2, declined_at = NOW() WHERE id = $1`,
+				row.ID, models.PartnerStatusDeclined,
+			)
+		default:
+			_, err = tx.Exec(ctx, `DELETE FROM community_partners WHERE id = $1`, row.ID)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"status":   row.Status,
+		"declined": row.Status == models.PartnerStatusPending && row.TargetID == communityID,
+	})
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionPartnerRemove, "community", &partnerID, details)
+
+	for _, id := range []uuid.UUID{row.RequesterID, row.TargetID} {
+		s.broadcast(ctx, id, "COMMUNITY_PARTNER_REMOVE", map[string]interface{}{
+			"id":          row.ID,
+			"communityId": id,
+			"partnerId":   row.other(id),
+		})
+	}
+	return nil
+}
+
+// AttachPartners fills Partners on each community with its accepted, public
+// partners for the profile and discovery views
+func (s *Service) AttachPartners(ctx context.Context, communities ...*models.Community) error {
+	if len(communities) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(communities))
+	byID := make(map[uuid.UUID]*models.Community, len(communities))
+	for _, c := range communities {
+		ids = append(ids, c.ID)
+		byID[c.ID] = c
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT own.id, c.id, c.name, c.icon_url, c.member_count, c.is_public
+		FROM community_partners p
+		CROSS JOIN LATERAL (
+			SELECT p.requester_id AS id, p.target_id AS partner_id
+			UNION ALL
+			SELECT p.target_id, p.requester_id
+		) own
+		JOIN communities c ON c.id = own.partner_id
+		WHERE own.id = ANY($1) AND p.status = $2 AND c.is_public = TRUE AND c.deleted_at IS NULL
+		ORDER BY c.member_count DESC, c.name`,
+		ids, models.PartnerStatusAccepted,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ownerID uuid.UUID
+		pc := &models.PartnerCommunity{}
+		if err := rows.Scan(&ownerID, &pc.ID, &pc.Name, &pc.IconURL, &pc.MemberCount, &pc.IsPublic); err != nil {
+			return err
+		}
+		if c := byID[ownerID]; c != nil {
+			c.Partners = append(c.Partners, pc)
+		}
+	}
+	return rows.Err()
+}
+
+// lockPartnerCommunities locks both community rows in a fixed order so the
+// partner limits can't be raced past from either side
+func lockPartnerCommunities(ctx context.Context, tx pgx.Tx, a, b uuid.UUID) error {
+	rows, err := tx.Query(ctx,
+		`SELECT id FROM communities WHERE id IN ($1, $2) AND deleted_at IS NULL ORDER BY id FOR UPDATE`,
+		a, b,
+	)
+	if err != nil {
+		return err
+	}
+	var locked int
+	for rows.Next() {
+		locked++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if locked != 2 {
+		return ErrCommunityNotFound
+	}
+	return nil
+}
+
+func getPartnerRow(ctx context.Context, tx pgx.Tx, a, b uuid.UUID) (*partnerRow, error) {
+	row := &partnerRow{}
+	err := tx.QueryRow(ctx,
+		`SELECT id, requester_id, target_id, status, proposed_by, accepted_by, created_at, accepted_at, declined_at
+		FROM community_partners
+		WHERE (requester_id = $1 AND target_id = $2) OR (requester_id = $2 AND target_id = $1)
+		FOR UPDATE`,
+		a, b,
+	).Scan(&row.ID, &row.RequesterID, &row.TargetID, &row.Status, &row.ProposedBy, &row.AcceptedBy, &row.CreatedAt, &row.AcceptedAt, &row.DeclinedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrPartnerNotFound
+		}
+		return nil, err
+	}
+	return row, nil
+}
+
+// checkPartnerLimits rejects a new link once a community has MaxPartners
+// accepted links, and a new request once it has MaxPendingPartnerRequests
+// unanswered ones
+func checkPartnerLimits(ctx context.Context, tx pgx.Tx, communityID uuid.UUID, proposing bool) error {
+	var accepted, pending int
+	err := tx.QueryRow(ctx,
+		`SELECT COUNT(*) FILTER (WHERE status = $2),
+		        COUNT(*) FILTER (WHERE status = $3 AND requester_id = $1)
+		FROM community_partners
+		WHERE requester_id = $1 OR target_id = $1`,
+		communityID, models.PartnerStatusAccepted, models.PartnerStatusPending,
+	).Scan(&accepted, &pending)
+	if err != nil {
+		return err
+	}
+	if accepted >= MaxPartners || (proposing && pending >= MaxPendingPartnerRequests) {
+		return ErrPartnerLimit
+	}
+	return nil
+}
+
+func acceptPartnerRow(ctx context.Context, tx pgx.Tx, row *partnerRow, actorID uuid.UUID) (*partnerRow, error) {
+	for _, id := range []uuid.UUID{row.RequesterID, row.TargetID} {
+		if err := checkPartnerLimits(ctx, tx, id, false); err != nil {
+			return nil, err
+		}
+	}
+	err := tx.QueryRow(ctx,
+		`UPDATE community_partners SET status = $2, accepted_by = $3, accepted_at = NOW()
+		WHERE id = $1
+		RETURNING status, accepted_by, accepted_at`,
+		row.ID, models.PartnerStatusAccepted, actorID,
+	).Scan(&row.Status, &row.AcceptedBy, &row.AcceptedAt)
+	if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// broadcastPartner sends each side its own view of the link and returns the
+// view for communityID
+func (s *Service) broadcastPartner(ctx context.Context, eventType string, row *partnerRow, communityID uuid.UUID) (*models.CommunityPartner, error) {
+	cards := make(map[uuid.UUID]*models.PartnerCommunity, 2)
+	for _, id := range []uuid.UUID{row.RequesterID, row.TargetID} {
+		c, err := s.GetCommunity(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		cards[id] = &models.PartnerCommunity{
+			ID: c.ID, Name: c.Name, IconURL: c.IconURL, MemberCount: c.MemberCount, IsPublic: c.IsPublic,
+		}
+	}
+
+	var view *models.CommunityPartner
+	for _, id := range []uuid.UUID{row.RequesterID, row.TargetID} {
+		p := &models.CommunityPartner{
+			ID:         row.ID,
+			Community:  cards[row.other(id)],
+			Status:     row.Status,
+			Outgoing:   row.RequesterID == id,
+			ProposedBy: row.ProposedBy,
+			AcceptedBy: row.AcceptedBy,
+			CreatedAt:  row.CreatedAt,
+			AcceptedAt: row.AcceptedAt,
+		}
+		s.broadcast(ctx, id, eventType, p)
+		if id == communityID {
+			view = p
+		}
+	}
+	return view, nil
+}
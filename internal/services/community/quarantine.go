@@ -0,0 +1,162 @@
+package community
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+var (
+	ErrAlreadyQuarantined = errors.New("member is already quarantined")
+	ErrNotQuarantined     = errors.New("member is not quarantined")
+)
+
+type QuarantineRequest struct {
+	Reason *string `json:"reason" validate:"omitempty,max=512"`
+}
+
+// QuarantineMember shadow-bans a member: they can keep posting, but what they
+// send is only shown to them and to moderators until the quarantine is lifted.
+// The member is not told.
+func (s *Service) QuarantineMember(ctx context.Context, communityID, actorID, targetID uuid.UUID, reason *string) (*models.Quarantine, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return nil, err
+	}
+
+	community, err := s.GetCommunity(ctx, communityID)
+	if err != nil {
+		return nil, err
+	}
+	if community.OwnerID == targetID {
+		return nil, ErrCannotModerateOwner
+	}
+
+	q := &models.Quarantine{CommunityID: communityID, UserID: targetID, QuarantinedBy: &actorID, Reason: reason}
+	err = s.db.QueryRow(ctx,
+		`UPDATE community_members SET quarantined_at = NOW(), quarantined_by = $3, quarantine_reason = $4
+		WHERE community_id = $1 AND user_id = $2 AND quarantined_at IS NULL
+		RETURNING quarantined_at`,
+		communityID, targetID, actorID, reason,
+	).Scan(&q.QuarantinedAt)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		if !s.IsMember(ctx, communityID, targetID) {
+			return nil, ErrNotMember
+		}
+		return nil, ErrAlreadyQuarantined
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{"reason": reason})
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionQuarantineAdd, "user", &targetID, details)
+
+	return q, nil
+}
+
+// LiftQuarantine ends a quarantine and makes the messages sent during it visible.
+// Members found to be spammers should be banned instead, which keeps them hidden.
+func (s *Service) LiftQuarantine(ctx context.Context, communityID, actorID, targetID uuid.UUID) error {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return err
+	}
+
+	var released int64
+	var channelIDs []uuid.UUID
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		result, err := tx.Exec(ctx,
+			`UPDATE community_members SET quarantined_at = NULL, quarantined_by = NULL, quarantine_reason = NULL
+			WHERE community_id = $1 AND user_id = $2 AND quarantined_at IS NOT NULL`,
+			communityID, targetID,
+		)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNotQuarantined
+		}
+
+		rows, err := tx.Query(ctx,
+			`WITH released AS (
+				UPDATE messages SET is_quarantined = FALSE
+				WHERE author_id = $2 AND is_quarantined
+				AND channel_id IN (SELECT id FROM channels WHERE community_id = $1)
+				RETURNING channel_id
+			)
+			SELECT channel_id, COUNT(*) FROM released GROUP BY channel_id`,
+			communityID, targetID,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var channelID uuid.UUID
+			var count int64
+			if err := rows.Scan(&channelID, &count); err != nil {
+				return err
+			}
+			channelIDs = append(channelIDs, channelID)
+			released += count
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+	for _, channelID := range channelIDs {
+		s.history.Invalidate(ctx, channelID)
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{"releasedMessages": released})
+	s.LogAudit(ctx, &communityID, actorID, models.AuditActionQuarantineLift, "user", &targetID, details)
+
+	return nil
+}
+
+// GetQuarantines lists the community's quarantined members, newest first
+func (s *Service) GetQuarantines(ctx context.Context, communityID, actorID uuid.UUID) ([]*models.Quarantine, error) {
+	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT community_id, user_id, quarantined_at, quarantined_by, quarantine_reason
+		FROM community_members
+		WHERE community_id = $1 AND quarantined_at IS NOT NULL
+		ORDER BY quarantined_at DESC`,
+		communityID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	quarantines := make([]*models.Quarantine, 0)
+	for rows.Next() {
+		q := &models.Quarantine{}
+		if err := rows.Scan(&q.CommunityID, &q.UserID, &q.QuarantinedAt, &q.QuarantinedBy, &q.Reason); err != nil {
+			return nil, err
+		}
+		quarantines = append(quarantines, q)
+	}
+	return quarantines, rows.Err()
+}
+
+func (s *Service) getQuarantine(ctx context.Context, communityID, userID uuid.UUID) *models.Quarantine {
+	q := &models.Quarantine{CommunityID: communityID, UserID: userID}
+	err := s.db.QueryRow(ctx,
+		`SELECT quarantined_at, quarantined_by, quarantine_reason FROM community_members
+		WHERE community_id = $1 AND user_id = $2 AND quarantined_at IS NOT NULL`,
+		communityID, userID,
+	).Scan(&q.QuarantinedAt, &q.QuarantinedBy, &q.Reason)
+	if err != nil {
+		return nil
+	}
+	return q
+}
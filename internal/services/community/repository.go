@@ -0,0 +1,48 @@
+package community
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+)
+
+// Repository is the storage behind communities, their members and roles,
+// which is what every permission check reads. The service keeps the rules
+// (who may do what, audit entries, events, cache invalidation) and the
+// repository only reads and writes rows, so the rules can run against a fake
+// in tests or against another backend. PostgresRepository is the default.
+//
+// Reads return ErrCommunityNotFound, ErrNotMember and ErrRoleNotFound for
+// missing rows.
+type Repository interface {
+	// Community returns a community that hasn't been deleted
+	Community(ctx context.Context, communityID uuid.UUID) (*models.Community, error)
+	// Member returns a user's membership of a community
+	Member(ctx context.Context, communityID, userID uuid.UUID) (*models.CommunityMember, error)
+
+	// Roles returns a community's roles, highest first
+	Roles(ctx context.Context, communityID uuid.UUID) ([]*models.Role, error)
+	// Role returns one of a community's roles
+	Role(ctx context.Context, communityID, roleID uuid.UUID) (*models.Role, error)
+	// DefaultRole returns the role members without roles have
+	DefaultRole(ctx context.Context, communityID uuid.UUID) (*models.Role, error)
+	// CreateRole stores a role above the community's other roles, setting
+	// its position
+	CreateRole(ctx context.Context, role *models.Role) error
+	// UpdateRole applies the fields of the update that are set
+	UpdateRole(ctx context.Context, communityID, roleID uuid.UUID, update *UpdateRoleRequest) error
+	// DeleteRole deletes a role and takes it away from its members
+	DeleteRole(ctx context.Context, communityID, roleID uuid.UUID) error
+
+	// MemberRoles returns the roles a member was given, highest first
+	MemberRoles(ctx context.Context, memberID uuid.UUID) ([]*models.Role, error)
+	// MemberRolePermissions returns the permissions of the roles a member was
+	// given combined, and how many roles that is
+	MemberRolePermissions(ctx context.Context, memberID uuid.UUID) (permissions int64, roles int, err error)
+	// SetMemberRoles replaces the roles a member was given, all or nothing
+	SetMemberRoles(ctx context.Context, memberID uuid.UUID, roleIDs []uuid.UUID) error
+
+	// AddAuditLog stores an audit log entry
+	AddAuditLog(ctx context.Context, entry *models.AuditLog) error
+}
@@ -0,0 +1,228 @@
+package community
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+)
+
+// fakeRepository is an in-memory Repository for service tests
+type fakeRepository struct {
+	mu sync.Mutex
+
+	communities map[uuid.UUID]*models.Community
+	members     map[uuid.UUID]*models.CommunityMember
+	roles       map[uuid.UUID]*models.Role
+	memberRoles map[uuid.UUID][]uuid.UUID
+	audit       []*models.AuditLog
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		communities: map[uuid.UUID]*models.Community{},
+		members:     map[uuid.UUID]*models.CommunityMember{},
+		roles:       map[uuid.UUID]*models.Role{},
+		memberRoles: map[uuid.UUID][]uuid.UUID{},
+	}
+}
+
+// addCommunity stores a community owned by ownerID with a default role
+// granting defaultPermissions, and makes the owner a member
+func (r *fakeRepository) addCommunity(ownerID uuid.UUID, defaultPermissions int64) uuid.UUID {
+	community := &models.Community{ID: uuid.New(), Name: "community", OwnerID: ownerID}
+	r.communities[community.ID] = community
+	r.addRole(community.ID, "everyone", 0, defaultPermissions, true)
+	r.addMember(community.ID, ownerID)
+	return community.ID
+}
+
+func (r *fakeRepository) addMember(communityID, userID uuid.UUID) *models.CommunityMember {
+	member := &models.CommunityMember{ID: uuid.New(), CommunityID: communityID, UserID: userID, JoinedAt: time.Now()}
+	r.members[member.ID] = member
+	return member
+}
+
+func (r *fakeRepository) addRole(communityID uuid.UUID, name string, position int, permissions int64, isDefault bool) uuid.UUID {
+	role := &models.Role{
+		ID:          uuid.New(),
+		CommunityID: communityID,
+		Name:        name,
+		Position:    position,
+		Permissions: permissions,
+		IsDefault:   isDefault,
+	}
+	r.roles[role.ID] = role
+	return role.ID
+}
+
+func (r *fakeRepository) findMember(communityID, userID uuid.UUID) *models.CommunityMember {
+	for _, m := range r.members {
+		if m.CommunityID == communityID && m.UserID == userID {
+			return m
+		}
+	}
+	return nil
+}
+
+// byPosition sorts roles highest first
+func byPosition(roles []*models.Role) []*models.Role {
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Position > roles[j].Position })
+	return roles
+}
+
+func (r *fakeRepository) Community(_ context.Context, communityID uuid.UUID) (*models.Community, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.communities[communityID]
+	if !ok {
+		return nil, ErrCommunityNotFound
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r *fakeRepository) Member(_ context.Context, communityID, userID uuid.UUID) (*models.CommunityMember, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := r.findMember(communityID, userID)
+	if m == nil {
+		return nil, ErrNotMember
+	}
+	copied := *m
+	return &copied, nil
+}
+
+func (r *fakeRepository) Roles(_ context.Context, communityID uuid.UUID) ([]*models.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var roles []*models.Role
+	for _, role := range r.roles {
+		if role.CommunityID == communityID {
+			copied := *role
+			roles = append(roles, &copied)
+		}
+	}
+	return byPosition(roles), nil
+}
+
+func (r *fakeRepository) Role(_ context.Context, communityID, roleID uuid.UUID) (*models.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	role, ok := r.roles[roleID]
+	if !ok || role.CommunityID != communityID {
+		return nil, ErrRoleNotFound
+	}
+	copied := *role
+	return &copied, nil
+}
+
+func (r *fakeRepository) DefaultRole(_ context.Context, communityID uuid.UUID) (*models.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, role := range r.roles {
+		if role.CommunityID == communityID && role.IsDefault {
+			copied := *role
+			return &copied, nil
+		}
+	}
+	return nil, ErrRoleNotFound
+}
+
+func (r *fakeRepository) CreateRole(_ context.Context, role *models.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	role.Position = 1
+	for _, other := range r.roles {
+		if other.CommunityID == role.CommunityID && other.Position >= role.Position {
+			role.Position = other.Position + 1
+		}
+	}
+	copied := *role
+	r.roles[role.ID] = &copied
+	return nil
+}
+
+func (r *fakeRepository) UpdateRole(_ context.Context, communityID, roleID uuid.UUID, update *UpdateRoleRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	role, ok := r.roles[roleID]
+	if !ok || role.CommunityID != communityID {
+		return ErrRoleNotFound
+	}
+	if update.Name != nil {
+		role.Name = *update.Name
+	}
+	if update.Color != nil {
+		role.Color = update.Color
+	}
+	if update.Permissions != nil {
+		role.Permissions = *update.Permissions
+	}
+	role.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *fakeRepository) DeleteRole(_ context.Context, communityID, roleID uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	role, ok := r.roles[roleID]
+	if !ok || role.CommunityID != communityID {
+		return ErrRoleNotFound
+	}
+	delete(r.roles, roleID)
+	for memberID, roleIDs := range r.memberRoles {
+		kept := roleIDs[:0]
+		for _, id := range roleIDs {
+			if id != roleID {
+				kept = append(kept, id)
+			}
+		}
+		r.memberRoles[memberID] = kept
+	}
+	return nil
+}
+
+func (r *fakeRepository) MemberRoles(_ context.Context, memberID uuid.UUID) ([]*models.Role, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var roles []*models.Role
+	for _, id := range r.memberRoles[memberID] {
+		if role, ok := r.roles[id]; ok {
+			copied := *role
+			roles = append(roles, &copied)
+		}
+	}
+	return byPosition(roles), nil
+}
+
+func (r *fakeRepository) MemberRolePermissions(_ context.Context, memberID uuid.UUID) (int64, int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var permissions int64
+	count := 0
+	for _, id := range r.memberRoles[memberID] {
+		if role, ok := r.roles[id]; ok {
+			permissions |= role.Permissions
+			count++
+		}
+	}
+	return permissions, count, nil
+}
+
+func (r *fakeRepository) SetMemberRoles(_ context.Context, memberID uuid.UUID, roleIDs []uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.memberRoles[memberID] = append([]uuid.UUID(nil), roleIDs...)
+	return nil
+}
+
+func (r *fakeRepository) AddAuditLog(_ context.Context, entry *models.AuditLog) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.audit = append(r.audit, entry)
+	return nil
+}
@@ -0,0 +1,201 @@
+package community
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zentra/server/internal/models"
+)
+
+// PostgresRepository is the Repository the server runs with
+type PostgresRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// roleColumns are the columns scanRole reads, r being roles
+const roleColumns = `r.id, r.community_id, r.name, r.color, r.position, r.permissions, r.is_default, r.created_at, r.updated_at`
+
+func scanRole(row pgx.Row) (*models.Role, error) {
+	r := &models.Role{}
+	err := row.Scan(&r.ID, &r.CommunityID, &r.Name, &r.Color, &r.Position, &r.Permissions, &r.IsDefault, &r.CreatedAt, &r.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrRoleNotFound
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *PostgresRepository) queryRoles(ctx context.Context, query string, args ...any) ([]*models.Role, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []*models.Role
+	for rows.Next() {
+		role, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+func (r *PostgresRepository) Community(ctx context.Context, communityID uuid.UUID) (*models.Community, error) {
+	community := &models.Community{}
+	err := r.db.QueryRow(ctx,
+		`SELECT id, name, description, icon_url, banner_url, owner_id, is_public, is_open, member_count, created_at, updated_at
+		FROM communities WHERE id = $1 AND deleted_at IS NULL`,
+		communityID,
+	).Scan(
+		&community.ID, &community.Name, &community.Description, &community.IconURL,
+		&community.BannerURL, &community.OwnerID, &community.IsPublic, &community.IsOpen,
+		&community.MemberCount, &community.CreatedAt, &community.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCommunityNotFound
+		}
+		return nil, err
+	}
+	return community, nil
+}
+
+func (r *PostgresRepository) Member(ctx context.Context, communityID, userID uuid.UUID) (*models.CommunityMember, error) {
+	member := &models.CommunityMember{}
+	err := r.db.QueryRow(ctx,
+		`SELECT id, community_id, user_id, nickname, joined_at, timeout_until
+		FROM community_members WHERE community_id = $1 AND user_id = $2`,
+		communityID, userID,
+	).Scan(&member.ID, &member.CommunityID, &member.UserID, &member.Nickname, &member.JoinedAt, &member.TimeoutUntil)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotMember
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+func (r *PostgresRepository) Roles(ctx context.Context, communityID uuid.UUID) ([]*models.Role, error) {
+	return r.queryRoles(ctx,
+		`SELECT `+roleColumns+` FROM roles r WHERE r.community_id = $1 ORDER BY r.position DESC`,
+		communityID,
+	)
+}
+
+func (r *PostgresRepository) Role(ctx context.Context, communityID, roleID uuid.UUID) (*models.Role, error) {
+	return scanRole(r.db.QueryRow(ctx,
+		`SELECT `+roleColumns+` FROM roles r WHERE r.id = $1 AND r.community_id = $2`,
+		roleID, communityID,
+	))
+}
+
+func (r *PostgresRepository) DefaultRole(ctx context.Context, communityID uuid.UUID) (*models.Role, error) {
+	return scanRole(r.db.QueryRow(ctx,
+		`SELECT `+roleColumns+` FROM roles r WHERE r.community_id = $1 AND r.is_default = TRUE`,
+		communityID,
+	))
+}
+
+func (r *PostgresRepository) CreateRole(ctx context.Context, role *models.Role) error {
+	return r.db.QueryRow(ctx,
+		`INSERT INTO roles (id, community_id, name, color, position, permissions, is_default, created_at, updated_at)
+		SELECT $1, $2, $3, $4, COALESCE(MAX(position), 0) + 1, $5, $6, $7, $8
+		FROM roles WHERE community_id = $2
+		RETURNING position`,
+		role.ID, role.CommunityID, role.Name, role.Color, role.Permissions, role.IsDefault, role.CreatedAt, role.UpdatedAt,
+	).Scan(&role.Position)
+}
+
+func (r *PostgresRepository) UpdateRole(ctx context.Context, communityID, roleID uuid.UUID, update *UpdateRoleRequest) error {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE roles SET
+			name = COALESCE($3, name),
+			color = COALESCE($4, color),
+			permissions = COALESCE($5, permissions),
+			updated_at = NOW()
+		WHERE id = $1 AND community_id = $2`,
+		roleID, communityID, update.Name, update.Color, update.Permissions,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) DeleteRole(ctx context.Context, communityID, roleID uuid.UUID) error {
+	tag, err := r.db.Exec(ctx, `DELETE FROM roles WHERE id = $1 AND community_id = $2`, roleID, communityID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) MemberRoles(ctx context.Context, memberID uuid.UUID) ([]*models.Role, error) {
+	return r.queryRoles(ctx,
+		`SELECT `+roleColumns+`
+		FROM member_roles mr
+		JOIN roles r ON r.id = mr.role_id
+		WHERE mr.member_id = $1
+		ORDER BY r.position DESC`,
+		memberID,
+	)
+}
+
+func (r *PostgresRepository) MemberRolePermissions(ctx context.Context, memberID uuid.UUID) (int64, int, error) {
+	var permissions int64
+	var roles int
+	err := r.db.QueryRow(ctx,
+		`SELECT COALESCE(BIT_OR(r.permissions), 0), COUNT(r.id)
+		FROM member_roles mr
+		JOIN roles r ON r.id = mr.role_id
+		WHERE mr.member_id = $1`,
+		memberID,
+	).Scan(&permissions, &roles)
+	return permissions, roles, err
+}
+
+func (r *PostgresRepository) SetMemberRoles(ctx context.Context, memberID uuid.UUID, roleIDs []uuid.UUID) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM member_roles WHERE member_id = $1`, memberID); err != nil {
+			return err
+		}
+		for _, roleID := range roleIDs {
+			_, err := tx.Exec(ctx,
+				`INSERT INTO member_roles (member_id, role_id) VALUES ($1, $2)`,
+				memberID, roleID,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *PostgresRepository) AddAuditLog(ctx context.Context, entry *models.AuditLog) error {
+	_, err := r.db.Exec(ctx,
+		`INSERT INTO audit_logs (id, community_id, actor_id, action, target_type, target_id, details, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		entry.ID, entry.CommunityID, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, []byte(entry.Details), entry.CreatedAt,
+	)
+	return err
+}
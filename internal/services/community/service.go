@@ -17,9 +17,11 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
 	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/services/permcache"
 	"github.com/zentra/server/internal/utils"
 	"github.com/zentra/server/pkg/auth"
 	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -35,16 +37,75 @@ var (
 	ErrUserBanned        = errors.New("user is banned from this community")
 	ErrNotBanned         = errors.New("user is not banned from this community")
 	ErrCannotBanOwner    = errors.New("cannot ban the owner")
+	ErrVerificationLevel = errors.New("account does not meet this community's verification level")
+	ErrJoinRateLimited   = errors.New("too many people are joining right now, try again shortly")
+	ErrInvitesPaused     = errors.New("invites to this community are paused")
 )
 
+// JoinGuard screens and observes joins; the antispam service implements it.
+type JoinGuard interface {
+	ScreenJoin(ctx context.Context, attempt *models.JoinAttempt) (models.JoinVerdict, error)
+	RecordJoin(ctx context.Context, attempt *models.JoinAttempt)
+}
+
+// EventDispatcher forwards community events to outgoing event hooks.
+type EventDispatcher interface {
+	Dispatch(ctx context.Context, communityID uuid.UUID, eventType string, data any)
+}
+
 type Service struct {
-	db     *pgxpool.Pool
-	redis  *redis.Client
-	cipher messaging.ContentCipher
+	db          *pgxpool.Pool
+	repo        Repository
+	redis       *redis.Client
+	cipher      messaging.ContentCipher
+	joinGuard   JoinGuard
+	events      EventDispatcher
+	permissions *permcache.Cache
+	search      *messaging.SearchIndex
+	history     *messaging.HistoryCache
+}
+
+func NewService(db *pgxpool.Pool, redis *redis.Client, keys *encryption.Keyring) *Service {
+	return &Service{db: db, repo: NewPostgresRepository(db), redis: redis, cipher: messaging.NewChannelCipher(keys)}
+}
+
+// SetRepository replaces the PostgreSQL storage, e.g. with a fake in tests
+func (s *Service) SetRepository(repo Repository) {
+	s.repo = repo
+}
+
+// SetJoinGuard installs the join checks. It is set after construction because the
+// antispam service itself depends on the community service.
+func (s *Service) SetJoinGuard(guard JoinGuard) {
+	s.joinGuard = guard
+}
+
+// SetEventDispatcher enables event hook deliveries for member events.
+func (s *Service) SetEventDispatcher(events EventDispatcher) {
+	s.events = events
 }
 
-func NewService(db *pgxpool.Pool, redis *redis.Client, encryptionKey []byte) *Service {
-	return &Service{db: db, redis: redis, cipher: messaging.NewChannelCipher(encryptionKey)}
+// SetPermissionCache makes role and member changes invalidate the cached
+// channel permissions in c.
+func (s *Service) SetPermissionCache(c *permcache.Cache) {
+	s.permissions = c
+}
+
+// SetHistoryCache lets releasing quarantined messages drop the cached history
+// of their channels
+func (s *Service) SetHistoryCache(c *messaging.HistoryCache) {
+	s.history = c
+}
+
+// SetSearchIndex makes messages of Discord imports searchable with i
+func (s *Service) SetSearchIndex(i *messaging.SearchIndex) {
+	s.search = i
+}
+
+func (s *Service) dispatchEvent(ctx context.Context, communityID uuid.UUID, eventType string, data any) {
+	if s.events != nil {
+		s.events.Dispatch(ctx, communityID, eventType, data)
+	}
 }
 
 type CreateCommunityRequest struct {
@@ -145,12 +206,21 @@ func (s *Service) broadcast(ctx context.Context, communityID uuid.UUID, eventTyp
 		return
 	}
 
-	err = s.redis.Publish(ctx, "websocket:broadcast", jsonData).Err()
+	err = database.PublishBroadcast(ctx, jsonData)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to publish community update to Redis")
 	}
 }
 
+// broadcastMembership announces a member joining or leaving, which keeps
+// member lists on the gateway up to date
+func (s *Service) broadcastMembership(ctx context.Context, communityID, userID uuid.UUID, eventType string) {
+	s.broadcast(ctx, communityID, eventType, map[string]interface{}{
+		"communityId": communityID,
+		"userId":      userID,
+	})
+}
+
 func (s *Service) CreateCommunity(ctx context.Context, ownerID uuid.UUID, req *CreateCommunityRequest) (*models.Community, error) {
 	community := &models.Community{
 		ID:          uuid.New(),
@@ -210,7 +280,7 @@ func (s *Service) CreateCommunity(ctx context.Context, ownerID uuid.UUID, req *C
 		_, err = tx.Exec(ctx,
 			`INSERT INTO roles (id, community_id, name, permissions, is_default, position)
 			VALUES ($1, $2, 'Member', $3, TRUE, 0)`,
-			uuid.New(), community.ID, models.PermissionAllText,
+			uuid.New(), community.ID, models.PermissionDefaultMember,
 		)
 		if err != nil {
 			return err
@@ -323,7 +393,7 @@ func (s *Service) ImportDiscordServer(ctx context.Context, req *DiscordImportReq
 		_, err = tx.Exec(ctx,
 			`INSERT INTO roles (id, community_id, name, permissions, is_default, position)
 			VALUES ($1, $2, 'Member', $3, TRUE, 0)`,
-			defaultRoleID, community.ID, models.PermissionAllText,
+			defaultRoleID, community.ID, models.PermissionDefaultMember,
 		)
 		if err != nil {
 			return err
@@ -372,6 +442,8 @@ func (s *Service) ImportDiscordServer(ctx context.Context, req *DiscordImportReq
 		createdMessageBySource := make(map[string]uuid.UUID)
 		createdMessageTimeByID := make(map[uuid.UUID]time.Time)
 		lastMessageAtByChannel := make(map[uuid.UUID]time.Time)
+		var messageRows []messaging.MessageRow
+		var attachmentRows []messaging.AttachmentRow
 		authorUserIDByKey := make(map[string]uuid.UUID)
 		memberIDByUserID := map[uuid.UUID]uuid.UUID{req.OwnerID: memberID}
 
@@ -408,9 +480,9 @@ func (s *Service) ImportDiscordServer(ctx context.Context, req *DiscordImportReq
 					createdAt = importedMessage.CreatedAt.UTC()
 				}
 
-				if err := ensureMessagePartition(ctx, tx, createdAt); err != nil {
+				if err := database.EnsureMessagePartition(ctx, tx, createdAt); err != nil {
 					fallback := now.Add(time.Duration(response.ImportedCounts.Messages+messageIndex) * time.Millisecond)
-					if partitionErr := ensureMessagePartition(ctx, tx, fallback); partitionErr != nil {
+					if partitionErr := database.EnsureMessagePartition(ctx, tx, fallback); partitionErr != nil {
 						return fmt.Errorf("failed to prepare message partition: %w", err)
 					}
 					createdAt = fallback
@@ -456,12 +528,11 @@ func (s *Service) ImportDiscordServer(ctx context.Context, req *DiscordImportReq
 
 				importedContent := importedMessage.Content
 
-				encryptedContent, _, err := s.cipher.Encrypt(importedContent)
+				messageID, createdAt := messaging.MessageIDAt(createdAt)
+				encryptedContent, _, err := s.cipher.Encrypt(messaging.ChannelRow(messageID), importedContent)
 				if err != nil {
 					return err
 				}
-
-				messageID := uuid.New()
 				var replyToID *uuid.UUID
 				if importedMessage.ReplyToSourceID != nil {
 					if mappedReplyID, ok := createdMessageBySource[*importedMessage.ReplyToSourceID]; ok {
@@ -474,14 +545,18 @@ func (s *Service) ImportDiscordServer(ctx context.Context, req *DiscordImportReq
 				if importedMessage.EditedAt != nil && !importedMessage.EditedAt.IsZero() {
 					updatedAt = importedMessage.EditedAt.UTC()
 				}
-				_, err = tx.Exec(ctx,
-					`INSERT INTO messages (id, channel_id, author_id, encrypted_content, reply_to_id, is_edited, is_pinned, reactions, link_previews, created_at, updated_at)
-					VALUES ($1, $2, $3, $4, $5, $6, $7, '{}'::jsonb, '[]'::jsonb, $8, $9)`,
-					messageID, channelID, authorID, encryptedContent, replyToID, isEdited, importedMessage.Pinned, createdAt, updatedAt,
-				)
-				if err != nil {
-					return err
-				}
+				messageRows = append(messageRows, messaging.MessageRow{
+					ID:               messageID,
+					ChannelID:        channelID,
+					AuthorID:         authorID,
+					EncryptedContent: encryptedContent,
+					ReplyToID:        replyToID,
+					IsEdited:         isEdited,
+					IsPinned:         importedMessage.Pinned,
+					CreatedAt:        createdAt,
+					UpdatedAt:        updatedAt,
+					SearchTokens:     s.search.Tokens(channelID, importedContent),
+				})
 
 				if importedMessage.SourceID != "" {
 					createdMessageBySource[importedMessage.SourceID] = messageID
@@ -491,25 +566,32 @@ func (s *Service) ImportDiscordServer(ctx context.Context, req *DiscordImportReq
 				response.ImportedCounts.Messages++
 
 				for _, attachment := range importedMessage.Attachments {
-					attachmentID := uuid.New()
-					_, err = tx.Exec(ctx,
-						`INSERT INTO message_attachments (
-							id, message_id, message_created_at, uploader_id, filename, file_url,
-							file_size, content_type, thumbnail_url, width, height, created_at
-						)
-						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
-						attachmentID, messageID, createdMessageTimeByID[messageID], authorID, attachment.Filename,
-						attachment.URL, attachment.Size, attachment.ContentType, attachment.ThumbnailURL,
-						attachment.Width, attachment.Height, createdAt,
-					)
-					if err != nil {
-						return err
-					}
+					attachmentRows = append(attachmentRows, messaging.AttachmentRow{
+						ID:               uuid.New(),
+						MessageID:        messageID,
+						MessageCreatedAt: createdMessageTimeByID[messageID],
+						UploaderID:       authorID,
+						Filename:         attachment.Filename,
+						FileURL:          attachment.URL,
+						FileSize:         attachment.Size,
+						ContentType:      attachment.ContentType,
+						ThumbnailURL:     attachment.ThumbnailURL,
+						Width:            attachment.Width,
+						Height:           attachment.Height,
+						CreatedAt:        createdAt,
+					})
 					response.ImportedCounts.Attachments++
 				}
 			}
 		}
 
+		if err := messaging.CopyMessages(ctx, tx, messageRows); err != nil {
+			return fmt.Errorf("failed to insert imported messages: %w", err)
+		}
+		if err := messaging.CopyAttachments(ctx, tx, attachmentRows); err != nil {
+			return fmt.Errorf("failed to insert imported attachments: %w", err)
+		}
+
 		for channelID, lastMessageAt := range lastMessageAtByChannel {
 			_, err = tx.Exec(ctx,
 				`UPDATE channels SET last_message_at = $2, updated_at = NOW() WHERE id = $1`,
@@ -645,25 +727,6 @@ func ensureImportedAuthorUser(ctx context.Context, tx pgx.Tx, communityID uuid.U
 	return ensuredUserID, nil
 }
 
-func ensureMessagePartition(ctx context.Context, tx pgx.Tx, createdAt time.Time) error {
-	createdAt = createdAt.UTC()
-	start := time.Date(createdAt.Year(), createdAt.Month(), 1, 0, 0, 0, 0, time.UTC)
-	end := start.AddDate(0, 1, 0)
-	partitionName := fmt.Sprintf("messages_%04d_%02d", start.Year(), int(start.Month()))
-
-	// DDL statements like CREATE TABLE PARTITION OF don't support parameters ($1, $2).
-	// We use the already-formatted/safe partition name and format the range values as literal strings.
-	query := fmt.Sprintf(
-		"CREATE TABLE IF NOT EXISTS %s PARTITION OF messages FOR VALUES FROM ('%s') TO ('%s')",
-		partitionName,
-		start.Format("2006-01-02 15:04:05Z07:00"),
-		end.Format("2006-01-02 15:04:05Z07:00"),
-	)
-
-	_, err := tx.Exec(ctx, query)
-	return err
-}
-
 func normalizeImportedChannelType(importedType string) models.ChannelType {
 	switch strings.ToLower(strings.TrimSpace(importedType)) {
 	case "announcement", "news":
@@ -678,23 +741,7 @@ func normalizeImportedChannelType(importedType string) models.ChannelType {
 }
 
 func (s *Service) GetCommunity(ctx context.Context, id uuid.UUID) (*models.Community, error) {
-	community := &models.Community{}
-	err := s.db.QueryRow(ctx,
-		`SELECT id, name, description, icon_url, banner_url, owner_id, is_public, is_open, member_count, created_at, updated_at
-		FROM communities WHERE id = $1 AND deleted_at IS NULL`,
-		id,
-	).Scan(
-		&community.ID, &community.Name, &community.Description, &community.IconURL,
-		&community.BannerURL, &community.OwnerID, &community.IsPublic, &community.IsOpen,
-		&community.MemberCount, &community.CreatedAt, &community.UpdatedAt,
-	)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrCommunityNotFound
-		}
-		return nil, err
-	}
-	return community, nil
+	return s.repo.Community(ctx, id)
 }
 
 func (s *Service) GetUserCommunities(ctx context.Context, userID uuid.UUID) ([]*models.Community, error) {
@@ -728,33 +775,38 @@ func (s *Service) GetUserCommunities(ctx context.Context, userID uuid.UUID) ([]*
 	return communities, nil
 }
 
-func (s *Service) DiscoverCommunities(ctx context.Context, query string, limit, offset int) ([]*models.Community, int64, error) {
+// DiscoverCommunities returns a page of public communities, biggest first, and
+// the cursor of the next page or "" on the last one
+func (s *Service) DiscoverCommunities(ctx context.Context, query, cursor string, limit int) ([]*models.Community, string, error) {
 	if limit <= 0 || limit > 50 {
 		limit = 20
 	}
+	after, err := utils.DecodeRankCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
 
-	var total int64
-	baseQuery := `WHERE is_public = TRUE AND deleted_at IS NULL`
-	args := []interface{}{}
-
+	// One extra row tells whether there is a next page
+	selectQuery := `SELECT id, name, description, icon_url, banner_url, owner_id, is_public, is_open, member_count, created_at, updated_at
+		FROM communities
+		WHERE is_public = TRUE AND deleted_at IS NULL`
+	args := []any{limit + 1}
 	if query != "" {
-		baseQuery += ` AND (name ILIKE $1 OR description ILIKE $1)`
 		args = append(args, "%"+query+"%")
+		selectQuery += fmt.Sprintf(` AND (name ILIKE $%d OR description ILIKE $%d)`, len(args), len(args))
 	}
-
-	countQuery := `SELECT COUNT(*) FROM communities ` + baseQuery
-	err := s.db.QueryRow(ctx, countQuery, args...).Scan(&total)
-	if err != nil {
-		return nil, 0, err
+	if after != nil {
+		args = append(args, after.Rank, after.ID)
+		selectQuery += fmt.Sprintf(` AND (member_count, id) < ($%d, $%d)`, len(args)-1, len(args))
 	}
 
-	selectQuery := `SELECT id, name, description, icon_url, banner_url, owner_id, is_public, is_open, member_count, created_at, updated_at
-		FROM communities ` + baseQuery + ` ORDER BY member_count DESC LIMIT $` + string(rune('0'+len(args)+1)) + ` OFFSET $` + string(rune('0'+len(args)+2))
-	args = append(args, limit, offset)
-
-	rows, err := s.db.Query(ctx, selectQuery, args...)
+	rows, err := s.db.Query(ctx, selectQuery+`
+		ORDER BY member_count DESC, id DESC
+		LIMIT $1`,
+		args...,
+	)
 	if err != nil {
-		return nil, 0, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
@@ -766,12 +818,25 @@ func (s *Service) DiscoverCommunities(ctx context.Context, query string, limit,
 			&c.OwnerID, &c.IsPublic, &c.IsOpen, &c.MemberCount, &c.CreatedAt, &c.UpdatedAt,
 		)
 		if err != nil {
-			return nil, 0, err
+			return nil, "", err
 		}
 		communities = append(communities, c)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(communities) > limit {
+		communities = communities[:limit]
+		last := communities[limit-1]
+		next = utils.EncodeCursor(utils.RankCursor{Rank: int64(last.MemberCount), ID: last.ID})
+	}
+	if err := s.AttachPartners(ctx, communities...); err != nil {
+		return nil, "", err
+	}
 
-	return communities, total, nil
+	return communities, next, nil
 }
 
 type UpdateCommunityRequest struct {
@@ -915,6 +980,7 @@ func (s *Service) DeleteCommunity(ctx context.Context, communityID, userID uuid.
 		communityID,
 	)
 	if err == nil {
+		s.permissions.InvalidateCommunity(ctx, communityID)
 		details, _ := json.Marshal(map[string]string{"name": community.Name})
 		s.LogAudit(ctx, &communityID, userID, models.AuditActionCommunityDelete, "community", &communityID, details)
 	}
@@ -924,47 +990,90 @@ func (s *Service) DeleteCommunity(ctx context.Context, communityID, userID uuid.
 // Member Management
 
 func (s *Service) GetMember(ctx context.Context, communityID, userID uuid.UUID) (*models.CommunityMember, error) {
-	member := &models.CommunityMember{}
-	err := s.db.QueryRow(ctx,
-		`SELECT id, community_id, user_id, nickname, joined_at
-		FROM community_members WHERE community_id = $1 AND user_id = $2`,
-		communityID, userID,
-	).Scan(&member.ID, &member.CommunityID, &member.UserID, &member.Nickname, &member.JoinedAt)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrNotMember
-		}
-		return nil, err
-	}
-	return member, nil
+	return s.repo.Member(ctx, communityID, userID)
 }
 
-func (s *Service) GetMembers(ctx context.Context, communityID uuid.UUID, limit, offset int) ([]*models.CommunityMemberWithUser, int64, error) {
+// GetMembers returns a page of the community's members in the order they
+// joined, and the cursor of the next page or "" on the last one
+func (s *Service) GetMembers(ctx context.Context, communityID uuid.UUID, cursor string, limit int) ([]*models.CommunityMemberWithUser, string, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 50
 	}
+	after, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
 
-	var total int64
-	err := s.db.QueryRow(ctx,
-		`SELECT COUNT(*) FROM community_members WHERE community_id = $1`,
-		communityID,
-	).Scan(&total)
+	// One extra row tells whether there is a next page
+	query := `SELECT cm.id, cm.community_id, cm.user_id, cm.nickname, cm.joined_at, cm.timeout_until,
+		u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
+		FROM community_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.community_id = $1`
+	args := []any{communityID, limit + 1}
+	if after != nil {
+		query += ` AND (cm.joined_at, cm.id) > ($3, $4)`
+		args = append(args, after.Time, after.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		ORDER BY cm.joined_at, cm.id
+		LIMIT $2`,
+		args...,
+	)
 	if err != nil {
-		return nil, 0, err
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var members []*models.CommunityMemberWithUser
+	for rows.Next() {
+		m := &models.CommunityMemberWithUser{}
+		u := &models.PublicUser{}
+		err := rows.Scan(
+			&m.ID, &m.CommunityID, &m.UserID, &m.Nickname, &m.JoinedAt, &m.TimeoutUntil,
+			&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Bio, &u.Status, &u.CustomStatus, &u.CreatedAt,
+		)
+		if err != nil {
+			return nil, "", err
+		}
+		m.User = u
+		members = append(members, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(members) > limit {
+		members = members[:limit]
+		last := members[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.JoinedAt, ID: last.ID})
+	}
+	if err := s.attachMemberRoles(ctx, communityID, members); err != nil {
+		return nil, "", err
+	}
+
+	return members, next, nil
+}
+
+// GetMembersByUserIDs loads the given users' memberships with their roles, in
+// no particular order. Users who aren't members are left out.
+func (s *Service) GetMembersByUserIDs(ctx context.Context, communityID uuid.UUID, userIDs []uuid.UUID) ([]*models.CommunityMemberWithUser, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
 	}
 
 	rows, err := s.db.Query(ctx,
-		`SELECT cm.id, cm.community_id, cm.user_id, cm.nickname, cm.joined_at,
+		`SELECT cm.id, cm.community_id, cm.user_id, cm.nickname, cm.joined_at, cm.timeout_until,
 		u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
 		FROM community_members cm
 		JOIN users u ON u.id = cm.user_id
-		WHERE cm.community_id = $1
-		ORDER BY cm.joined_at
-		LIMIT $2 OFFSET $3`,
-		communityID, limit, offset,
+		WHERE cm.community_id = $1 AND cm.user_id = ANY($2)`,
+		communityID, userIDs,
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -973,66 +1082,160 @@ func (s *Service) GetMembers(ctx context.Context, communityID uuid.UUID, limit,
 		m := &models.CommunityMemberWithUser{}
 		u := &models.PublicUser{}
 		err := rows.Scan(
-			&m.ID, &m.CommunityID, &m.UserID, &m.Nickname, &m.JoinedAt,
+			&m.ID, &m.CommunityID, &m.UserID, &m.Nickname, &m.JoinedAt, &m.TimeoutUntil,
 			&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Bio, &u.Status, &u.CustomStatus, &u.CreatedAt,
 		)
 		if err != nil {
-			return nil, 0, err
+			return nil, err
 		}
 		m.User = u
 		members = append(members, m)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	if len(members) > 0 {
-		memberIDs := make([]uuid.UUID, 0, len(members))
-		memberByID := make(map[uuid.UUID]*models.CommunityMemberWithUser, len(members))
-		for _, member := range members {
-			memberIDs = append(memberIDs, member.ID)
-			memberByID[member.ID] = member
-		}
+	if err := s.attachMemberRoles(ctx, communityID, members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// attachMemberRoles fills in each member's roles, falling back to the
+// default role for members without any
+func (s *Service) attachMemberRoles(ctx context.Context, communityID uuid.UUID, members []*models.CommunityMemberWithUser) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	memberIDs := make([]uuid.UUID, 0, len(members))
+	memberByID := make(map[uuid.UUID]*models.CommunityMemberWithUser, len(members))
+	for _, member := range members {
+		memberIDs = append(memberIDs, member.ID)
+		memberByID[member.ID] = member
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT mr.member_id, r.id, r.community_id, r.name, r.color, r.position, r.permissions, r.is_default, r.created_at, r.updated_at
+		FROM member_roles mr
+		JOIN roles r ON r.id = mr.role_id
+		WHERE mr.member_id = ANY($1)
+		ORDER BY r.position DESC`,
+		memberIDs,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-		rows, err := s.db.Query(ctx,
-			`SELECT mr.member_id, r.id, r.community_id, r.name, r.color, r.position, r.permissions, r.is_default, r.created_at, r.updated_at
-			FROM member_roles mr
-			JOIN roles r ON r.id = mr.role_id
-			WHERE mr.member_id = ANY($1)
-			ORDER BY r.position DESC`,
-			memberIDs,
+	for rows.Next() {
+		var memberID uuid.UUID
+		r := &models.Role{}
+		err := rows.Scan(
+			&memberID, &r.ID, &r.CommunityID, &r.Name, &r.Color, &r.Position,
+			&r.Permissions, &r.IsDefault, &r.CreatedAt, &r.UpdatedAt,
 		)
 		if err != nil {
-			return nil, 0, err
+			return err
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var memberID uuid.UUID
-			r := &models.Role{}
-			err := rows.Scan(
-				&memberID, &r.ID, &r.CommunityID, &r.Name, &r.Color, &r.Position,
-				&r.Permissions, &r.IsDefault, &r.CreatedAt, &r.UpdatedAt,
-			)
-			if err != nil {
-				return nil, 0, err
-			}
-			if member, ok := memberByID[memberID]; ok {
-				member.Roles = append(member.Roles, r)
-			}
+		if member, ok := memberByID[memberID]; ok {
+			member.Roles = append(member.Roles, r)
 		}
+	}
 
-		defaultRole, err := s.GetDefaultRole(ctx, communityID)
-		if err == nil && defaultRole != nil {
-			for _, member := range members {
-				if len(member.Roles) == 0 {
-					member.Roles = []*models.Role{defaultRole}
-				}
+	defaultRole, err := s.GetDefaultRole(ctx, communityID)
+	if err == nil && defaultRole != nil {
+		for _, member := range members {
+			if len(member.Roles) == 0 {
+				member.Roles = []*models.Role{defaultRole}
 			}
 		}
 	}
+	return nil
+}
 
-	return members, total, nil
+// MemberSummary is what it takes to place a member in a sorted member list
+type MemberSummary struct {
+	UserID uuid.UUID
+	Name   string // nickname, display name or username
+	Status models.UserStatus
 }
 
-func (s *Service) JoinCommunity(ctx context.Context, communityID, userID uuid.UUID) error {
+// MemberSummaries returns a summary of every member of the community, or of
+// just userIDs when given
+func (s *Service) MemberSummaries(ctx context.Context, communityID uuid.UUID, userIDs []uuid.UUID) ([]MemberSummary, error) {
+	query := `SELECT cm.user_id, COALESCE(cm.nickname, u.display_name, u.username), u.status
+		FROM community_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.community_id = $1`
+	args := []interface{}{communityID}
+	if userIDs != nil {
+		query += ` AND cm.user_id = ANY($2)`
+		args = append(args, userIDs)
+	}
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []MemberSummary
+	for rows.Next() {
+		var m MemberSummary
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Status); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, m)
+	}
+	return summaries, rows.Err()
+}
+
+// CommunityConnections is how many gateway connections members of a
+// community hold
+type CommunityConnections struct {
+	CommunityID uuid.UUID `json:"communityId"`
+	Connections int       `json:"connections"`
+}
+
+// TopCommunitiesByConnections ranks communities by the connections their
+// members hold, given each connected user's connection count
+func (s *Service) TopCommunitiesByConnections(ctx context.Context, connections map[uuid.UUID]int, limit int) ([]CommunityConnections, error) {
+	userIDs := make([]uuid.UUID, 0, len(connections))
+	counts := make([]int32, 0, len(connections))
+	for userID, count := range connections {
+		userIDs = append(userIDs, userID)
+		counts = append(counts, int32(count))
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT cm.community_id, SUM(c.connections)::int AS connections
+		FROM unnest($1::uuid[], $2::int[]) AS c(user_id, connections)
+		JOIN community_members cm ON cm.user_id = c.user_id
+		GROUP BY cm.community_id
+		ORDER BY connections DESC
+		LIMIT $3`,
+		userIDs, counts, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []CommunityConnections
+	for rows.Next() {
+		var c CommunityConnections
+		if err := rows.Scan(&c.CommunityID, &c.Connections); err != nil {
+			return nil, err
+		}
+		top = append(top, c)
+	}
+	return top, rows.Err()
+}
+
+// JoinCommunity joins an open community directly. ip is the client address,
+// used to spot several accounts joining from one network.
+func (s *Service) JoinCommunity(ctx context.Context, communityID, userID uuid.UUID, ip string) error {
 	community, err := s.GetCommunity(ctx, communityID)
 	if err != nil {
 		return err
@@ -1047,16 +1250,29 @@ func (s *Service) JoinCommunity(ctx context.Context, communityID, userID uuid.UU
 	if s.IsUserBanned(ctx, communityID, userID) {
 		return ErrUserBanned
 	}
+	if s.joinsLocked(ctx, communityID) {
+		return ErrCommunityLocked
+	}
+
+	attempt := &models.JoinAttempt{CommunityID: communityID, UserID: userID, IP: ip}
+	if err := s.checkJoinGuard(ctx, attempt); err != nil {
+		return err
+	}
 
 	if err := s.addMember(ctx, communityID, userID); err != nil {
 		return err
 	}
 
 	s.LogAudit(ctx, &communityID, userID, models.AuditActionMemberJoin, "user", &userID, nil)
+	if s.joinGuard != nil {
+		s.joinGuard.RecordJoin(ctx, attempt)
+	}
+	s.dispatchEvent(ctx, communityID, models.EventHookMemberJoin, map[string]any{"userId": userID})
+	s.broadcastMembership(ctx, communityID, userID, "MEMBER_JOIN")
 	return nil
 }
 
-func (s *Service) JoinWithInvite(ctx context.Context, code string, userID uuid.UUID) (*models.Community, error) {
+func (s *Service) JoinWithInvite(ctx context.Context, code string, userID uuid.UUID, ip string) (*models.Community, error) {
 	// Find and validate invite
 	var invite models.CommunityInvite
 	err := s.db.QueryRow(ctx,
@@ -1085,6 +1301,14 @@ func (s *Service) JoinWithInvite(ctx context.Context, code string, userID uuid.U
 	if s.IsUserBanned(ctx, invite.CommunityID, userID) {
 		return nil, ErrUserBanned
 	}
+	if s.joinsLocked(ctx, invite.CommunityID) {
+		return nil, ErrCommunityLocked
+	}
+
+	attempt := &models.JoinAttempt{CommunityID: invite.CommunityID, UserID: userID, InviteID: &invite.ID, IP: ip}
+	if err := s.checkJoinGuard(ctx, attempt); err != nil {
+		return nil, err
+	}
 
 	// Add member
 	if err := s.addMember(ctx, invite.CommunityID, userID); err != nil {
@@ -1092,6 +1316,11 @@ func (s *Service) JoinWithInvite(ctx context.Context, code string, userID uuid.U
 	}
 
 	s.LogAudit(ctx, &invite.CommunityID, userID, models.AuditActionMemberJoin, "user", &userID, nil)
+	if s.joinGuard != nil {
+		s.joinGuard.RecordJoin(ctx, attempt)
+	}
+	s.dispatchEvent(ctx, invite.CommunityID, models.EventHookMemberJoin, map[string]any{"userId": userID, "inviteId": invite.ID})
+	s.broadcastMembership(ctx, invite.CommunityID, userID, "MEMBER_JOIN")
 
 	// Increment use count
 	_, err = s.db.Exec(ctx,
@@ -1105,6 +1334,25 @@ func (s *Service) JoinWithInvite(ctx context.Context, code string, userID uuid.U
 	return s.GetCommunity(ctx, invite.CommunityID)
 }
 
+func (s *Service) checkJoinGuard(ctx context.Context, attempt *models.JoinAttempt) error {
+	if s.joinGuard == nil {
+		return nil
+	}
+	verdict, err := s.joinGuard.ScreenJoin(ctx, attempt)
+	if err != nil {
+		return err
+	}
+	switch verdict {
+	case models.JoinBelowVerification:
+		return ErrVerificationLevel
+	case models.JoinRateLimited:
+		return ErrJoinRateLimited
+	case models.JoinInvitesPaused:
+		return ErrInvitesPaused
+	}
+	return nil
+}
+
 func (s *Service) addMember(ctx context.Context, communityID, userID uuid.UUID) error {
 	// Check if already a member
 	_, err := s.GetMember(ctx, communityID, userID)
@@ -1116,7 +1364,7 @@ func (s *Service) addMember(ctx context.Context, communityID, userID uuid.UUID)
 	}
 
 	memberID := uuid.New()
-	return database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
 		_, err = tx.Exec(ctx,
 			`INSERT INTO community_members (id, community_id, user_id, joined_at)
 			VALUES ($1, $2, $3, NOW())`,
@@ -1146,6 +1394,12 @@ func (s *Service) addMember(ctx context.Context, communityID, userID uuid.UUID)
 		)
 		return err
 	})
+	if err != nil {
+		return err
+	}
+	// A failed lookup from before they joined may be cached
+	s.permissions.InvalidateMember(ctx, communityID, userID)
+	return nil
 }
 
 func (s *Service) LeaveCommunity(ctx context.Context, communityID, userID uuid.UUID) error {
@@ -1164,36 +1418,21 @@ func (s *Service) LeaveCommunity(ctx context.Context, communityID, userID uuid.U
 		communityID, userID,
 	)
 	if err == nil {
+		s.permissions.InvalidateMember(ctx, communityID, userID)
 		s.LogAudit(ctx, &communityID, userID, models.AuditActionMemberLeave, "user", &userID, nil)
+		s.dispatchEvent(ctx, communityID, models.EventHookMemberLeave, map[string]any{"userId": userID})
+		s.broadcastMembership(ctx, communityID, userID, "MEMBER_LEAVE")
 	}
 	return err
 }
 
+// KickMember removes a member and records it as a moderation case
 func (s *Service) KickMember(ctx context.Context, communityID, actorID, targetID uuid.UUID) error {
-	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionKickMembers); err != nil {
-		return err
-	}
-
-	community, err := s.GetCommunity(ctx, communityID)
-	if err != nil {
-		return err
-	}
-	if community.OwnerID == targetID {
-		return ErrCannotRemoveOwner
-	}
-
-	_, err = s.db.Exec(ctx,
-		`DELETE FROM community_members WHERE community_id = $1 AND user_id = $2`,
-		communityID, targetID,
-	)
-	if err != nil {
-		return err
-	}
-
-	// Log to audit trail
-	s.LogAudit(ctx, &communityID, actorID, models.AuditActionMemberKick, "user", &targetID, nil)
-
-	return nil
+	_, err := s.CreateCase(ctx, communityID, actorID, &CreateCaseRequest{
+		UserID: targetID,
+		Action: models.ModerationActionKick,
+	})
+	return err
 }
 
 // Ban Management
@@ -1202,43 +1441,14 @@ type BanMemberRequest struct {
 	Reason *string `json:"reason" validate:"omitempty,max=512"`
 }
 
+// BanMember bans a user (member or not) and records it as a moderation case
 func (s *Service) BanMember(ctx context.Context, communityID, actorID, targetID uuid.UUID, reason *string) error {
-	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionBanMembers); err != nil {
-		return err
-	}
-
-	community, err := s.GetCommunity(ctx, communityID)
-	if err != nil {
-		return err
-	}
-	if community.OwnerID == targetID {
-		return ErrCannotBanOwner
-	}
-
-	return database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
-		// Remove from members if they're currently in the community
-		_, _ = tx.Exec(ctx,
-			`DELETE FROM community_members WHERE community_id = $1 AND user_id = $2`,
-			communityID, targetID,
-		)
-
-		// Insert the ban record
-		_, err := tx.Exec(ctx,
-			`INSERT INTO community_bans (id, community_id, user_id, banned_by, reason, created_at)
-			VALUES ($1, $2, $3, $4, $5, NOW())
-			ON CONFLICT (community_id, user_id) DO NOTHING`,
-			uuid.New(), communityID, targetID, actorID, reason,
-		)
-		if err != nil {
-			return err
-		}
-
-		// Write audit log
-		details, _ := json.Marshal(map[string]interface{}{"reason": reason})
-		s.LogAudit(ctx, &communityID, actorID, models.AuditActionMemberBan, "user", &targetID, details)
-
-		return nil
+	_, err := s.CreateCase(ctx, communityID, actorID, &CreateCaseRequest{
+		UserID: targetID,
+		Action: models.ModerationActionBan,
+		Reason: reason,
 	})
+	return err
 }
 
 func (s *Service) UnbanMember(ctx context.Context, communityID, actorID, targetID uuid.UUID) error {
@@ -1318,36 +1528,38 @@ func (s *Service) IsUserBanned(ctx context.Context, communityID, userID uuid.UUI
 
 // Audit Log
 
-func (s *Service) GetAuditLogs(ctx context.Context, communityID, actorID uuid.UUID, limit, offset int) ([]*models.AuditLogWithActor, int64, error) {
+// GetAuditLogs returns a page of the community's audit log, newest first, and
+// the cursor of the next page or "" on the last one
+func (s *Service) GetAuditLogs(ctx context.Context, communityID, actorID uuid.UUID, cursor string, limit int) ([]*models.AuditLogWithActor, string, error) {
 	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionViewAuditLog); err != nil {
-		return nil, 0, err
+		return nil, "", err
 	}
 
 	if limit <= 0 || limit > 100 {
 		limit = 50
 	}
-
-	var total int64
-	err := s.db.QueryRow(ctx,
-		`SELECT COUNT(*) FROM audit_logs WHERE community_id = $1`,
-		communityID,
-	).Scan(&total)
+	before, err := utils.DecodeKeysetCursor(cursor, "")
 	if err != nil {
-		return nil, 0, err
+		return nil, "", err
 	}
 
-	rows, err := s.db.Query(ctx,
-		`SELECT al.id, al.community_id, al.actor_id, al.action, al.target_type, al.target_id, al.details, al.created_at,
+	query := `SELECT al.id, al.community_id, al.actor_id, al.action, al.target_type, al.target_id, al.details, al.created_at,
 			u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
 		FROM audit_logs al
 		JOIN users u ON u.id = al.actor_id
-		WHERE al.community_id = $1
-		ORDER BY al.created_at DESC
-		LIMIT $2 OFFSET $3`,
-		communityID, limit, offset,
+		WHERE al.community_id = $1`
+	args := []any{communityID, limit + 1}
+	if before != nil {
+		query += ` AND (al.created_at, al.id) < ($3, $4)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		ORDER BY al.created_at DESC, al.id DESC
+		LIMIT $2`,
+		args...,
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
@@ -1362,21 +1574,35 @@ func (s *Service) GetAuditLogs(ctx context.Context, communityID, actorID uuid.UU
 			&actor.Bio, &actor.Status, &actor.CustomStatus, &actor.CreatedAt,
 		)
 		if err != nil {
-			return nil, 0, err
+			return nil, "", err
 		}
 		entry.Actor = actor
 		logs = append(logs, entry)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return logs, total, nil
+	var next string
+	if len(logs) > limit {
+		logs = logs[:limit]
+		last := logs[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.CreatedAt, ID: last.ID})
+	}
+	return logs, next, nil
 }
 
 func (s *Service) LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte) {
-	_, err := s.db.Exec(ctx,
-		`INSERT INTO audit_logs (id, community_id, actor_id, action, target_type, target_id, details, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())`,
-		uuid.New(), communityID, actorID, action, targetType, targetID, details,
-	)
+	err := s.repo.AddAuditLog(ctx, &models.AuditLog{
+		ID:          uuid.New(),
+		CommunityID: communityID,
+		ActorID:     actorID,
+		Action:      action,
+		TargetType:  &targetType,
+		TargetID:    targetID,
+		Details:     details,
+		CreatedAt:   time.Now(),
+	})
 	if err != nil {
 		log.Error().Err(err).Str("action", action).Msg("Failed to write audit log")
 	}
@@ -1384,6 +1610,11 @@ func (s *Service) LogAudit(ctx context.Context, communityID *uuid.UUID, actorID
 
 // Invites
 
+type CreateInviteRequest struct {
+	MaxUses   *int   `json:"maxUses" validate:"omitempty,min=1,max=100"`
+	ExpiresIn *int64 `json:"expiresIn"` // Duration in seconds
+}
+
 func (s *Service) CreateInvite(ctx context.Context, communityID, userID uuid.UUID, maxUses *int, expiresIn *time.Duration) (*models.CommunityInvite, error) {
 	if err := s.requirePermission(ctx, communityID, userID, models.PermissionCreateInvites); err != nil {
 		return nil, err
@@ -1502,28 +1733,7 @@ func (s *Service) DeleteInvite(ctx context.Context, communityID, inviteID, userI
 // Roles
 
 func (s *Service) GetRoles(ctx context.Context, communityID uuid.UUID) ([]*models.Role, error) {
-	rows, err := s.db.Query(ctx,
-		`SELECT id, community_id, name, color, position, permissions, is_default, created_at, updated_at
-		FROM roles WHERE community_id = $1
-		ORDER BY position DESC`,
-		communityID,
-	)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var roles []*models.Role
-	for rows.Next() {
-		r := &models.Role{}
-		err := rows.Scan(&r.ID, &r.CommunityID, &r.Name, &r.Color, &r.Position, &r.Permissions, &r.IsDefault, &r.CreatedAt, &r.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		roles = append(roles, r)
-	}
-
-	return roles, nil
+	return s.repo.Roles(ctx, communityID)
 }
 
 type CreateRoleRequest struct {
@@ -1543,33 +1753,22 @@ func (s *Service) CreateRole(ctx context.Context, communityID, userID uuid.UUID,
 		return nil, err
 	}
 
-	// Get max position
-	var maxPos int
-	s.db.QueryRow(ctx,
-		`SELECT COALESCE(MAX(position), 0) FROM roles WHERE community_id = $1`,
-		communityID,
-	).Scan(&maxPos)
-
+	now := time.Now()
 	role := &models.Role{
 		ID:          uuid.New(),
 		CommunityID: communityID,
 		Name:        req.Name,
 		Color:       req.Color,
-		Position:    maxPos + 1,
 		Permissions: req.Permissions,
 		IsDefault:   false,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
 	}
-
-	_, err := s.db.Exec(ctx,
-		`INSERT INTO roles (id, community_id, name, color, position, permissions, is_default, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-		role.ID, role.CommunityID, role.Name, role.Color, role.Position, role.Permissions, role.IsDefault, role.CreatedAt, role.UpdatedAt,
-	)
-	if err != nil {
+	if err := s.repo.CreateRole(ctx, role); err != nil {
 		return nil, err
 	}
+	// A role nobody has yet can still be the target of channel overwrites
+	s.permissions.InvalidateCommunity(ctx, communityID)
 
 	details, _ := json.Marshal(map[string]string{"name": role.Name})
 	s.LogAudit(ctx, &communityID, userID, models.AuditActionRoleCreate, "role", &role.ID, details)
@@ -1583,26 +1782,24 @@ func (s *Service) DeleteRole(ctx context.Context, communityID, roleID, userID uu
 	}
 
 	// Cannot delete default role
-	var isDefault bool
-	err := s.db.QueryRow(ctx,
-		`SELECT is_default FROM roles WHERE id = $1 AND community_id = $2`,
-		roleID, communityID,
-	).Scan(&isDefault)
+	role, err := s.repo.Role(ctx, communityID, roleID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrRoleNotFound
-		}
 		return err
 	}
-	if isDefault {
+	if role.IsDefault {
 		return errors.New("cannot delete the default role")
 	}
 
-	_, err = s.db.Exec(ctx, `DELETE FROM roles WHERE id = $1 AND community_id = $2`, roleID, communityID)
-	if err == nil {
-		s.LogAudit(ctx, &communityID, userID, models.AuditActionRoleDelete, "role", &roleID, nil)
+	if err := s.repo.DeleteRole(ctx, communityID, roleID); err != nil {
+		return err
 	}
-	return err
+	s.permissions.InvalidateCommunity(ctx, communityID)
+	s.LogAudit(ctx, &communityID, userID, models.AuditActionRoleDelete, "role", &roleID, nil)
+	s.broadcast(ctx, communityID, "ROLE_DELETE", map[string]interface{}{
+		"communityId": communityID,
+		"roleId":      roleID,
+	})
+	return nil
 }
 
 func (s *Service) UpdateRole(ctx context.Context, communityID, roleID, userID uuid.UUID, req *UpdateRoleRequest) (*models.Role, error) {
@@ -1610,32 +1807,11 @@ func (s *Service) UpdateRole(ctx context.Context, communityID, roleID, userID uu
 		return nil, err
 	}
 
-	role := &models.Role{}
-	if err := s.db.QueryRow(ctx,
-		`SELECT id, community_id, name, color, position, permissions, is_default, created_at, updated_at
-		FROM roles WHERE id = $1 AND community_id = $2`,
-		roleID, communityID,
-	).Scan(
-		&role.ID, &role.CommunityID, &role.Name, &role.Color, &role.Position,
-		&role.Permissions, &role.IsDefault, &role.CreatedAt, &role.UpdatedAt,
-	); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrRoleNotFound
-		}
+	if err := s.repo.UpdateRole(ctx, communityID, roleID, req); err != nil {
 		return nil, err
 	}
-
-	_, err := s.db.Exec(ctx,
-		`UPDATE roles SET
-			name = COALESCE($3, name),
-			color = COALESCE($4, color),
-			permissions = COALESCE($5, permissions),
-			updated_at = NOW()
-		WHERE id = $1 AND community_id = $2`,
-		roleID, communityID, req.Name, req.Color, req.Permissions,
-	)
-	if err != nil {
-		return nil, err
+	if req.Permissions != nil {
+		s.permissions.InvalidateCommunity(ctx, communityID)
 	}
 
 	changes := map[string]interface{}{}
@@ -1650,47 +1826,21 @@ func (s *Service) UpdateRole(ctx context.Context, communityID, roleID, userID uu
 		s.LogAudit(ctx, &communityID, userID, models.AuditActionRoleUpdate, "role", &roleID, details)
 	}
 
-	return s.GetRole(ctx, communityID, roleID)
-}
-
-func (s *Service) GetRole(ctx context.Context, communityID, roleID uuid.UUID) (*models.Role, error) {
-	role := &models.Role{}
-	err := s.db.QueryRow(ctx,
-		`SELECT id, community_id, name, color, position, permissions, is_default, created_at, updated_at
-		FROM roles WHERE id = $1 AND community_id = $2`,
-		roleID, communityID,
-	).Scan(
-		&role.ID, &role.CommunityID, &role.Name, &role.Color, &role.Position,
-		&role.Permissions, &role.IsDefault, &role.CreatedAt, &role.UpdatedAt,
-	)
+	updated, err := s.GetRole(ctx, communityID, roleID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrRoleNotFound
-		}
 		return nil, err
 	}
+	s.broadcast(ctx, communityID, "ROLE_UPDATE", updated)
 
-	return role, nil
+	return updated, nil
 }
 
-func (s *Service) GetDefaultRole(ctx context.Context, communityID uuid.UUID) (*models.Role, error) {
-	role := &models.Role{}
-	err := s.db.QueryRow(ctx,
-		`SELECT id, community_id, name, color, position, permissions, is_default, created_at, updated_at
-		FROM roles WHERE community_id = $1 AND is_default = TRUE`,
-		communityID,
-	).Scan(
-		&role.ID, &role.CommunityID, &role.Name, &role.Color, &role.Position,
-		&role.Permissions, &role.IsDefault, &role.CreatedAt, &role.UpdatedAt,
-	)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrRoleNotFound
-		}
-		return nil, err
-	}
+func (s *Service) GetRole(ctx context.Context, communityID, roleID uuid.UUID) (*models.Role, error) {
+	return s.repo.Role(ctx, communityID, roleID)
+}
 
-	return role, nil
+func (s *Service) GetDefaultRole(ctx context.Context, communityID uuid.UUID) (*models.Role, error) {
+	return s.repo.DefaultRole(ctx, communityID)
 }
 
 func (s *Service) GetMemberRoles(ctx context.Context, communityID, userID uuid.UUID) ([]*models.Role, error) {
@@ -1699,31 +1849,10 @@ func (s *Service) GetMemberRoles(ctx context.Context, communityID, userID uuid.U
 		return nil, err
 	}
 
-	rows, err := s.db.Query(ctx,
-		`SELECT r.id, r.community_id, r.name, r.color, r.position, r.permissions, r.is_default, r.created_at, r.updated_at
-		FROM member_roles mr
-		JOIN roles r ON r.id = mr.role_id
-		WHERE mr.member_id = $1
-		ORDER BY r.position DESC`,
-		member.ID,
-	)
+	roles, err := s.repo.MemberRoles(ctx, member.ID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var roles []*models.Role
-	for rows.Next() {
-		r := &models.Role{}
-		err := rows.Scan(
-			&r.ID, &r.CommunityID, &r.Name, &r.Color, &r.Position,
-			&r.Permissions, &r.IsDefault, &r.CreatedAt, &r.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-		roles = append(roles, r)
-	}
 
 	if len(roles) == 0 {
 		defaultRole, err := s.GetDefaultRole(ctx, communityID)
@@ -1741,27 +1870,22 @@ func (s *Service) GetMemberRoleIDs(ctx context.Context, communityID, userID uuid
 		return nil, err
 	}
 
-	rows, err := s.db.Query(ctx,
-		`SELECT role_id FROM member_roles WHERE member_id = $1`,
-		member.ID,
-	)
+	roles, err := s.repo.MemberRoles(ctx, member.ID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
 	var roleIDs []uuid.UUID
-	for rows.Next() {
-		var roleID uuid.UUID
-		if err := rows.Scan(&roleID); err != nil {
-			return nil, err
-		}
-		roleIDs = append(roleIDs, roleID)
+	for _, role := range roles {
+		roleIDs = append(roleIDs, role.ID)
 	}
-
 	return roleIDs, nil
 }
 
+type SetMemberRolesRequest struct {
+	RoleIDs []uuid.UUID `json:"roleIds"`
+}
+
 func (s *Service) SetMemberRoles(ctx context.Context, communityID, actorID, targetID uuid.UUID, roleIDs []uuid.UUID) error {
 	if err := s.requirePermission(ctx, communityID, actorID, models.PermissionManageRoles); err != nil {
 		return err
@@ -1780,67 +1904,46 @@ func (s *Service) SetMemberRoles(ctx context.Context, communityID, actorID, targ
 		return err
 	}
 
-	uniqueRoleIDs := make(map[uuid.UUID]struct{}, len(roleIDs))
+	wanted := make(map[uuid.UUID]bool, len(roleIDs))
 	for _, roleID := range roleIDs {
-		uniqueRoleIDs[roleID] = struct{}{}
+		wanted[roleID] = true
 	}
 
-	filteredIDs := make([]uuid.UUID, 0, len(uniqueRoleIDs))
-	if len(uniqueRoleIDs) > 0 {
-		roleIDList := make([]uuid.UUID, 0, len(uniqueRoleIDs))
-		for roleID := range uniqueRoleIDs {
-			roleIDList = append(roleIDList, roleID)
-		}
-
-		rows, err := s.db.Query(ctx,
-			`SELECT id, is_default FROM roles WHERE community_id = $1 AND id = ANY($2)`,
-			communityID, roleIDList,
-		)
+	filteredIDs := make([]uuid.UUID, 0, len(wanted))
+	if len(wanted) > 0 {
+		roles, err := s.repo.Roles(ctx, communityID)
 		if err != nil {
 			return err
 		}
-		defer rows.Close()
-
-		foundIDs := make(map[uuid.UUID]bool, len(roleIDList))
-		filteredIDs = filteredIDs[:0]
-		for rows.Next() {
-			var roleID uuid.UUID
-			var isDefault bool
-			if err := rows.Scan(&roleID, &isDefault); err != nil {
-				return err
+
+		found := 0
+		for _, role := range roles {
+			if !wanted[role.ID] {
+				continue
 			}
-			foundIDs[roleID] = true
-			if !isDefault {
-				filteredIDs = append(filteredIDs, roleID)
+			found++
+			if !role.IsDefault {
+				filteredIDs = append(filteredIDs, role.ID)
 			}
 		}
 
-		if len(foundIDs) != len(roleIDList) {
+		if found != len(wanted) {
 			return ErrRoleNotFound
 		}
 	}
 
-	return database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
-		_, err := tx.Exec(ctx,
-			`DELETE FROM member_roles WHERE member_id = $1`,
-			member.ID,
-		)
-		if err != nil {
-			return err
-		}
-
-		for _, roleID := range filteredIDs {
-			_, err := tx.Exec(ctx,
-				`INSERT INTO member_roles (member_id, role_id) VALUES ($1, $2)`,
-				member.ID, roleID,
-			)
-			if err != nil {
-				return err
-			}
-		}
+	if err := s.repo.SetMemberRoles(ctx, member.ID, filteredIDs); err != nil {
+		return err
+	}
+	s.permissions.InvalidateMember(ctx, communityID, targetID)
 
-		return nil
+	// Lets the hub recheck which channels the member can still see
+	s.broadcast(ctx, communityID, "MEMBER_UPDATE", map[string]interface{}{
+		"communityId": communityID,
+		"userId":      targetID,
+		"roleIds":     filteredIDs,
 	})
+	return nil
 }
 
 // Permission helpers
@@ -1877,32 +1980,52 @@ func (s *Service) GetMemberPermissions(ctx context.Context, communityID, userID
 		return models.PermissionAdministrator, nil
 	}
 
-	var userPermissions int64
-	var roleCount int
-	err = s.db.QueryRow(ctx,
-		`SELECT COALESCE(BIT_OR(r.permissions), 0), COUNT(r.id)
-		FROM member_roles mr
-		JOIN roles r ON r.id = mr.role_id
-		WHERE mr.member_id = $1`,
-		member.ID,
-	).Scan(&userPermissions, &roleCount)
+	userPermissions, roleCount, err := s.repo.MemberRolePermissions(ctx, member.ID)
 	if err != nil {
 		return 0, err
 	}
 
 	if roleCount == 0 {
-		err = s.db.QueryRow(ctx,
-			`SELECT permissions FROM roles WHERE community_id = $1 AND is_default = TRUE`,
-			communityID,
-		).Scan(&userPermissions)
+		defaultRole, err := s.repo.DefaultRole(ctx, communityID)
 		if err != nil {
 			return 0, ErrInsufficientPerms
 		}
+		userPermissions = defaultRole.Permissions
+	}
+
+	if member.TimedOut() && userPermissions&models.PermissionAdministrator == 0 {
+		userPermissions &^= models.TimeoutRevokedPermissions
 	}
 
 	return userPermissions, nil
 }
 
+// FilterMembers returns the users in userIDs that are members of the community
+func (s *Service) FilterMembers(ctx context.Context, communityID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT user_id FROM community_members WHERE community_id = $1 AND user_id = ANY($2)`,
+		communityID, userIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		members = append(members, id)
+	}
+	return members, rows.Err()
+}
+
 func (s *Service) IsMember(ctx context.Context, communityID, userID uuid.UUID) bool {
 	_, err := s.GetMember(ctx, communityID, userID)
 	return err == nil
@@ -0,0 +1,131 @@
+package community
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+)
+
+func newTestService(repo Repository) *Service {
+	s := NewService(nil, nil, nil)
+	s.SetRepository(repo)
+	return s
+}
+
+func TestGetMemberPermissions(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepository()
+	owner := uuid.New()
+	communityID := repo.addCommunity(owner, models.PermissionAllText)
+	s := newTestService(repo)
+
+	permissions := func(userID uuid.UUID) int64 {
+		t.Helper()
+		got, err := s.GetMemberPermissions(ctx, communityID, userID)
+		if err != nil {
+			t.Fatalf("GetMemberPermissions: %v", err)
+		}
+		return got
+	}
+
+	if got := permissions(owner); got != models.PermissionAdministrator {
+		t.Errorf("owner = %b, want administrator", got)
+	}
+
+	plain := uuid.New()
+	repo.addMember(communityID, plain)
+	if got := permissions(plain); got != models.PermissionAllText {
+		t.Errorf("member without roles = %b, want the default role's %b", got, models.PermissionAllText)
+	}
+
+	// Given roles replace the default role rather than adding to it
+	moderator := uuid.New()
+	member := repo.addMember(communityID, moderator)
+	pins := repo.addRole(communityID, "pins", 1, models.PermissionPinMessages, false)
+	messages := repo.addRole(communityID, "messages", 2, models.PermissionManageMessages|models.PermissionViewChannels, false)
+	repo.memberRoles[member.ID] = []uuid.UUID{pins, messages}
+	want := models.PermissionPinMessages | models.PermissionManageMessages | models.PermissionViewChannels
+	if got := permissions(moderator); got != want {
+		t.Errorf("member with two roles = %b, want %b", got, want)
+	}
+
+	timedOut := uuid.New()
+	until := time.Now().Add(time.Hour)
+	repo.addMember(communityID, timedOut).TimeoutUntil = &until
+	if got := permissions(timedOut); got&models.TimeoutRevokedPermissions != 0 {
+		t.Errorf("timed out member = %b, still has %b", got, got&models.TimeoutRevokedPermissions)
+	}
+
+	admin := uuid.New()
+	adminMember := repo.addMember(communityID, admin)
+	adminMember.TimeoutUntil = &until
+	repo.memberRoles[adminMember.ID] = []uuid.UUID{repo.addRole(communityID, "admin", 3, models.PermissionAdministrator|models.PermissionSendMessages, false)}
+	if got := permissions(admin); got&models.PermissionSendMessages == 0 {
+		t.Error("a timeout took permissions away from an administrator")
+	}
+
+	if _, err := s.GetMemberPermissions(ctx, communityID, uuid.New()); !errors.Is(err, ErrNotMember) {
+		t.Errorf("stranger: err = %v, want ErrNotMember", err)
+	}
+}
+
+func TestSetMemberRoles(t *testing.T) {
+	ctx := context.Background()
+	repo := newFakeRepository()
+	owner := uuid.New()
+	communityID := repo.addCommunity(owner, models.PermissionAllText)
+	s := newTestService(repo)
+
+	manager := uuid.New()
+	managerMember := repo.addMember(communityID, manager)
+	managers := repo.addRole(communityID, "managers", 2, models.PermissionManageRoles, false)
+	repo.memberRoles[managerMember.ID] = []uuid.UUID{managers}
+
+	target := uuid.New()
+	targetMember := repo.addMember(communityID, target)
+	helpers := repo.addRole(communityID, "helpers", 1, models.PermissionPinMessages, false)
+	defaultRole, err := repo.DefaultRole(ctx, communityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.SetMemberRoles(ctx, communityID, target, target, []uuid.UUID{helpers}); !errors.Is(err, ErrInsufficientPerms) {
+		t.Errorf("without manage roles: err = %v, want ErrInsufficientPerms", err)
+	}
+
+	if err := s.SetMemberRoles(ctx, communityID, manager, target, []uuid.UUID{helpers, uuid.New()}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("unknown role: err = %v, want ErrRoleNotFound", err)
+	}
+
+	otherCommunity := repo.addCommunity(uuid.New(), 0)
+	foreign := repo.addRole(otherCommunity, "foreign", 1, models.PermissionAdministrator, false)
+	if err := s.SetMemberRoles(ctx, communityID, manager, target, []uuid.UUID{foreign}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("another community's role: err = %v, want ErrRoleNotFound", err)
+	}
+	if len(repo.memberRoles[targetMember.ID]) != 0 {
+		t.Fatalf("rejected changes were stored: %v", repo.memberRoles[targetMember.ID])
+	}
+
+	if err := s.SetMemberRoles(ctx, communityID, manager, owner, []uuid.UUID{helpers}); !errors.Is(err, ErrNotOwner) {
+		t.Errorf("changing the owner's roles: err = %v, want ErrNotOwner", err)
+	}
+
+	// The default role is implied, so it isn't stored
+	if err := s.SetMemberRoles(ctx, communityID, manager, target, []uuid.UUID{helpers, defaultRole.ID, helpers}); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.memberRoles[targetMember.ID]; len(got) != 1 || got[0] != helpers {
+		t.Errorf("stored roles = %v, want only helpers", got)
+	}
+
+	if err := s.SetMemberRoles(ctx, communityID, manager, target, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := repo.memberRoles[targetMember.ID]; len(got) != 0 {
+		t.Errorf("stored roles = %v, want none", got)
+	}
+}
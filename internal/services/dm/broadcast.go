@@ -0,0 +1,145 @@
+package dm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/services/notification"
+)
+
+// Announcement conversations connect a community's broadcast sender with one
+// member. Only the sender can post in them.
+
+// DeliverBroadcast posts a community announcement to recipientID, opening the
+// announcement conversation first if needed. Link previews are built once by
+// the caller rather than per recipient.
+func (s *Service) DeliverBroadcast(ctx context.Context, communityID, senderID, recipientID uuid.UUID, content string, previews []models.LinkPreview) (uuid.UUID, error) {
+	if blocked, err := s.userService.IsBlocked(ctx, recipientID, senderID); err != nil {
+		return uuid.Nil, err
+	} else if blocked {
+		return uuid.Nil, ErrBlocked
+	}
+
+	messageID, now := messaging.NewMessageID()
+	ciphertext, nonce, err := s.cipher.Encrypt(messaging.DMRow(messageID), content)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	conversationID, err := s.announcementConversation(ctx, tx, communityID, senderID, recipientID, now)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO direct_messages (id, conversation_id, sender_id, encrypted_content, nonce, link_previews, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $7)`,
+		messageID, conversationID, senderID, ciphertext, nonce, string(messaging.EncodeLinkPreviews(previews)), now,
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE dm_conversations SET updated_at = $2 WHERE id = $1`,
+		conversationID, now,
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, err
+	}
+
+	resp, err := s.GetMessage(ctx, messageID, senderID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	s.broadcast(ctx, conversationID.String(), "DM_MESSAGE_CREATE", s.eventView(resp))
+
+	if s.notificationService != nil {
+		senderName := ""
+		if resp.Sender != nil {
+			if resp.Sender.DisplayName != nil && *resp.Sender.DisplayName != "" {
+				senderName = *resp.Sender.DisplayName
+			} else {
+				senderName = resp.Sender.Username
+			}
+		}
+		go s.notificationService.ProcessDMNotification(notification.DMNotificationContext{
+			ConversationID: conversationID,
+			MessageID:      messageID,
+			SenderID:       senderID,
+			SenderName:     senderName,
+			Content:        content,
+			CommunityID:    &communityID,
+		})
+	}
+
+	return messageID, nil
+}
+
+func (s *Service) announcementConversation(ctx context.Context, tx pgx.Tx, communityID, senderID, recipientID uuid.UUID, now time.Time) (uuid.UUID, error) {
+	var conversationID uuid.UUID
+	err := tx.QueryRow(ctx,
+		`SELECT c.id
+		 FROM dm_conversations c
+		 JOIN dm_participants p ON p.conversation_id = c.id AND p.user_id = $3
+		 WHERE c.community_id = $1 AND c.broadcast_sender_id = $2
+		 LIMIT 1`,
+		communityID, senderID, recipientID,
+	).Scan(&conversationID)
+	if err == nil {
+		return conversationID, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, err
+	}
+
+	conversationID = uuid.New()
+	_, err = tx.Exec(ctx,
+		`INSERT INTO dm_conversations (id, community_id, broadcast_sender_id, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $4)`,
+		conversationID, communityID, senderID, now,
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO dm_participants (conversation_id, user_id, last_read_at)
+		 VALUES ($1, $2, $4), ($1, $3, NULL)`,
+		conversationID, senderID, recipientID, now,
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return conversationID, nil
+}
+
+// isReadOnlyFor reports whether conversationID is an announcement conversation
+// that userID can't post in
+func (s *Service) isReadOnlyFor(ctx context.Context, conversationID, userID uuid.UUID) bool {
+	var senderID *uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`SELECT broadcast_sender_id FROM dm_conversations WHERE id = $1`,
+		conversationID,
+	).Scan(&senderID)
+	if err != nil {
+		return false
+	}
+	return senderID != nil && *senderID != userID
+}
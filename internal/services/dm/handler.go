@@ -1,12 +1,14 @@
 package dm
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/messaging"
 	"github.com/zentra/server/internal/utils"
 )
 
@@ -28,7 +30,7 @@ func (h *Handler) Routes() chi.Router {
 			r.Get("/", h.GetConversation)
 			r.Post("/read", h.MarkRead)
 			r.Get("/messages", h.GetMessages)
-			r.Post("/messages", h.SendMessage)
+			r.With(middleware.Idempotent).Post("/messages", h.SendMessage)
 		})
 	})
 
@@ -49,13 +51,18 @@ func (h *Handler) ListConversations(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conversations, err := h.service.ListConversations(r.Context(), userID)
+	page := utils.GetCursorParams(r, 50, 100)
+	conversations, next, err := h.service.ListConversations(r.Context(), userID, r.URL.Query().Get("sort"), page.Cursor, page.Limit)
 	if err != nil {
+		if err == utils.ErrInvalidCursor {
+			utils.RespondInvalidCursor(w)
+			return
+		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to load conversations")
 		return
 	}
 
-	utils.RespondSuccess(w, conversations)
+	utils.RespondCursorPage(w, conversations, next)
 }
 
 func (h *Handler) CreateConversation(w http.ResponseWriter, r *http.Request) {
@@ -66,13 +73,7 @@ func (h *Handler) CreateConversation(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateConversationRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -132,20 +133,25 @@ func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	params := &GetMessagesParams{}
-	if before := r.URL.Query().Get("before"); before != "" {
-		if id, err := uuid.Parse(before); err == nil {
-			params.Before = &id
+	// A cursor continues from an earlier page; before or after start from a
+	// message the client already has, such as a jump to a reply
+	params := &GetMessagesParams{Limit: utils.GetQueryInt(r, "limit", 0)}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		params.Before, params.After, err = messaging.DecodeHistoryCursor(cursor)
+		if err != nil {
+			utils.RespondInvalidCursor(w)
+			return
 		}
-	}
-	if after := r.URL.Query().Get("after"); after != "" {
-		if id, err := uuid.Parse(after); err == nil {
-			params.After = &id
+	} else {
+		if before := r.URL.Query().Get("before"); before != "" {
+			if id, err := uuid.Parse(before); err == nil {
+				params.Before = &id
+			}
 		}
-	}
-	if limit := r.URL.Query().Get("limit"); limit != "" {
-		if l, err := strconv.Atoi(limit); err == nil {
-			params.Limit = l
+		if after := r.URL.Query().Get("after"); after != "" {
+			if id, err := uuid.Parse(after); err == nil {
+				params.After = &id
+			}
 		}
 	}
 
@@ -160,7 +166,11 @@ func (h *Handler) GetMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.RespondSuccess(w, messages)
+	var next string
+	if len(messages) > 0 {
+		next = messaging.NextHistoryCursor(params.After != nil, len(messages), params.Limit, messages[len(messages)-1].ID)
+	}
+	utils.RespondCursorPage(w, messages, next)
 }
 
 func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
@@ -177,13 +187,7 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req SendMessageRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -192,6 +196,10 @@ func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
 		switch err {
 		case ErrNotParticipant:
 			utils.RespondError(w, http.StatusForbidden, "Not a participant")
+		case ErrReadOnly:
+			utils.RespondError(w, http.StatusForbidden, "This conversation does not accept replies")
+		case ErrBlockedByAutoMod:
+			utils.RespondError(w, http.StatusForbidden, "Message blocked by AutoMod")
 		case ErrInvalidAttachment:
 			utils.RespondError(w, http.StatusBadRequest, "Invalid attachment")
 		case ErrMessageNotFound:
@@ -219,13 +227,7 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateMessageRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -236,6 +238,8 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusNotFound, "Message not found")
 		case ErrNotMessageOwner:
 			utils.RespondError(w, http.StatusForbidden, "Cannot edit this message")
+		case ErrBlockedByAutoMod:
+			utils.RespondError(w, http.StatusForbidden, "Message blocked by AutoMod")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to update message")
 		}
@@ -286,20 +290,17 @@ func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Emoji string `json:"emoji" validate:"required"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	var req AddReactionRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
 	if err := h.service.AddReaction(r.Context(), messageID, userID, req.Emoji); err != nil {
+		var limited *messaging.RateLimitError
+		if errors.As(err, &limited) {
+			utils.RespondRateLimited(w, limited.Code, limited.Message, limited.RetryAfter)
+			return
+		}
 		switch err {
 		case ErrMessageNotFound:
 			utils.RespondError(w, http.StatusNotFound, "Message not found")
@@ -307,6 +308,9 @@ func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusForbidden, "Not a participant")
 		case ErrInvalidReaction:
 			utils.RespondError(w, http.StatusBadRequest, "Invalid reaction")
+		case ErrTooManyReactions:
+			utils.RespondErrorWithCode(w, http.StatusBadRequest, "MAX_REACTIONS",
+				fmt.Sprintf("You can add at most %d different reactions to a message", messaging.MaxReactionsPerUser))
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to add reaction")
 		}
@@ -0,0 +1,80 @@
+package dm
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+
+	openapi.Describe((*Handler).ListConversations, openapi.Operation{
+		Summary: "Page through the current user's conversations",
+		Query: append(openapi.CursorQuery, openapi.Param{
+			Name:        "sort",
+			Enum:        []string{ConversationSortRecent},
+			Description: "recent orders by the last interaction instead of the last message",
+		}),
+		Response: []*DMConversationResponse{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).CreateConversation, openapi.Operation{
+		Summary:     "Open a conversation with a user",
+		Description: "Returns the existing conversation if there is one.",
+		Body:        CreateConversationRequest{},
+		Response:    DMConversationResponse{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetConversation, openapi.Operation{
+		Summary:  "Get a conversation",
+		Path:     id,
+		Response: DMConversationResponse{},
+	})
+	openapi.Describe((*Handler).MarkRead, openapi.Operation{
+		Summary: "Mark a conversation read",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetMessages, openapi.Operation{
+		Summary:     "Page through a conversation's messages",
+		Description: "Newest first. before or after start the history at a message instead, and after pages forward.",
+		Path:        id,
+		Query: append(openapi.CursorQuery,
+			openapi.Param{Name: "before", Format: "uuid", Description: "Start with the messages before this one"},
+			openapi.Param{Name: "after", Format: "uuid", Description: "Start with the messages after this one"},
+		),
+		Response: []*DMMessageResponse{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).SendMessage, openapi.Operation{
+		Summary:  "Send a direct message",
+		Path:     id,
+		Body:     SendMessageRequest{},
+		Response: DMMessageResponse{},
+		Status:   http.StatusCreated,
+	})
+
+	openapi.Describe((*Handler).UpdateMessage, openapi.Operation{
+		Summary:  "Edit a direct message",
+		Path:     id,
+		Body:     UpdateMessageRequest{},
+		Response: DMMessageResponse{},
+	})
+	openapi.Describe((*Handler).DeleteMessage, openapi.Operation{
+		Summary: "Delete a direct message",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).AddReaction, openapi.Operation{
+		Summary: "React to a direct message",
+		Path:    id,
+		Body:    AddReactionRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RemoveReaction, openapi.Operation{
+		Summary: "Remove the current user's reaction from a direct message",
+		Path:    append(id, openapi.Param{Name: "emoji"}),
+		Status:  http.StatusNoContent,
+	})
+}
@@ -14,8 +14,13 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/automod"
 	"github.com/zentra/server/internal/services/messaging"
 	"github.com/zentra/server/internal/services/notification"
+	"github.com/zentra/server/internal/services/recency"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
 )
 
 var (
@@ -26,6 +31,9 @@ var (
 	ErrBlocked              = errors.New("user is blocked")
 	ErrInvalidAttachment    = errors.New("invalid attachment")
 	ErrInvalidReaction      = errors.New("invalid reaction")
+	ErrTooManyReactions     = errors.New("too many reactions on this message")
+	ErrReadOnly             = errors.New("conversation is read-only")
+	ErrBlockedByAutoMod     = errors.New("message blocked by automod")
 )
 
 type Service struct {
@@ -33,7 +41,11 @@ type Service struct {
 	redis               *redis.Client
 	userService         UserServiceInterface
 	notificationService *notification.Service
+	recencyService      *recency.Service
 	cipher              messaging.ContentCipher
+	urlSigner           *messaging.URLSigner
+	camo                *messaging.Camo
+	automodService      *automod.Service
 }
 
 type UserServiceInterface interface {
@@ -41,12 +53,12 @@ type UserServiceInterface interface {
 	IsBlocked(ctx context.Context, blockerID, blockedID uuid.UUID) (bool, error)
 }
 
-func NewService(db *pgxpool.Pool, redis *redis.Client, encryptionKey []byte, userService UserServiceInterface) *Service {
+func NewService(db *pgxpool.Pool, redis *redis.Client, keys *encryption.Keyring, userService UserServiceInterface) *Service {
 	return &Service{
 		db:          db,
 		redis:       redis,
 		userService: userService,
-		cipher:      messaging.NewDMCipher(encryptionKey),
+		cipher:      messaging.NewDMCipher(keys),
 	}
 }
 
@@ -56,6 +68,39 @@ func (s *Service) SetNotificationService(ns *notification.Service) {
 	s.notificationService = ns
 }
 
+// SetRecencyService enables recording DM interactions for quick switcher ranking.
+func (s *Service) SetRecencyService(rs *recency.Service) {
+	s.recencyService = rs
+}
+
+// SetURLSigner hands out attachments as signed, expiring links.
+func (s *Service) SetURLSigner(signer *messaging.URLSigner) {
+	s.urlSigner = signer
+}
+
+// SetCamo rewrites the preview images of direct messages, including each
+// conversation's last message, to image proxy URLs.
+func (s *Service) SetCamo(c *messaging.Camo) {
+	s.camo = c
+}
+
+// SetAutoModService runs the AutoMod rules of the communities participants
+// share over direct messages.
+func (s *Service) SetAutoModService(a *automod.Service) {
+	s.automodService = a
+}
+
+// eventView is resp with attachment links that aren't tied to the participant
+// who fetched it, for events sent to the whole conversation
+func (s *Service) eventView(resp *DMMessageResponse) *DMMessageResponse {
+	if s.urlSigner == nil || len(resp.Attachments) == 0 {
+		return resp
+	}
+	view := *resp
+	view.Attachments = s.urlSigner.SignAttachments(resp.Attachments, uuid.Nil)
+	return &view
+}
+
 type CreateConversationRequest struct {
 	UserID uuid.UUID `json:"userId" validate:"required"`
 }
@@ -64,10 +109,16 @@ type SendMessageRequest struct {
 	Content     string      `json:"content" validate:"required_without=Attachments,max=4000"`
 	ReplyToID   *uuid.UUID  `json:"replyToId,omitempty"`
 	Attachments []uuid.UUID `json:"attachments,omitempty" validate:"max=10"`
+	// Optional per-attachment spoiler/alt text, keyed by attachment ID.
+	AttachmentOptions []models.AttachmentOptions `json:"attachmentOptions,omitempty" validate:"max=10,dive"`
+	// Optional label clients show instead of the content until it is expanded.
+	ContentWarning *string `json:"contentWarning,omitempty" validate:"omitempty,max=100"`
 }
 
 type UpdateMessageRequest struct {
 	Content string `json:"content" validate:"required,max=4000"`
+	// ContentWarning replaces the message's warning when set; "" removes it.
+	ContentWarning *string `json:"contentWarning,omitempty" validate:"omitempty,max=100"`
 }
 
 type DMMessageResponse struct {
@@ -75,6 +126,7 @@ type DMMessageResponse struct {
 	ConversationID uuid.UUID                  `json:"conversationId"`
 	SenderID       uuid.UUID                  `json:"senderId"`
 	Content        string                     `json:"content"`
+	ContentWarning *string                    `json:"contentWarning,omitempty"`
 	IsEdited       bool                       `json:"isEdited"`
 	Reactions      []models.ReactionCount     `json:"reactions,omitempty"`
 	Attachments    []models.MessageAttachment `json:"attachments,omitempty"`
@@ -86,10 +138,11 @@ type DMMessageResponse struct {
 }
 
 type DMReplyPreview struct {
-	ID       uuid.UUID          `json:"id"`
-	Content  string             `json:"content"`
-	SenderID uuid.UUID          `json:"senderId"`
-	Sender   *models.PublicUser `json:"sender"`
+	ID             uuid.UUID          `json:"id"`
+	Content        string             `json:"content"`
+	ContentWarning *string            `json:"contentWarning,omitempty"`
+	SenderID       uuid.UUID          `json:"senderId"`
+	Sender         *models.PublicUser `json:"sender"`
 }
 
 type DMConversationResponse struct {
@@ -97,10 +150,15 @@ type DMConversationResponse struct {
 	Participants []models.PublicUser `json:"participants"`
 	LastMessage  *DMMessageResponse  `json:"lastMessage,omitempty"`
 	UnreadCount  int                 `json:"unreadCount"`
-	CreatedAt    time.Time           `json:"createdAt"`
-	UpdatedAt    time.Time           `json:"updatedAt"`
+	// LastInteractionAt is when the requesting user last sent to or read this conversation
+	LastInteractionAt *time.Time `json:"lastInteractionAt,omitempty"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
 }
 
+// ConversationSortRecent orders conversations by the user's own last interaction
+const ConversationSortRecent = "recent"
+
 type GetMessagesParams struct {
 	Before *uuid.UUID
 	After  *uuid.UUID
@@ -130,7 +188,7 @@ func (s *Service) broadcast(ctx context.Context, conversationID string, eventTyp
 		return
 	}
 
-	if err := s.redis.Publish(ctx, "websocket:broadcast", jsonData).Err(); err != nil {
+	if err := database.PublishBroadcast(ctx, jsonData); err != nil {
 		log.Error().Err(err).Msg("Failed to publish DM broadcast")
 	}
 }
@@ -219,34 +277,156 @@ func (s *Service) CreateOrGetConversation(ctx context.Context, userID, otherUser
 	return s.buildConversationResponse(ctx, convo, userID)
 }
 
-func (s *Service) ListConversations(ctx context.Context, userID uuid.UUID) ([]*DMConversationResponse, error) {
+// ListConversations returns a page of the user's DMs, newest activity first,
+// and the cursor of the next page or "" on the last one. With
+// ConversationSortRecent they are ordered by the user's own last interaction
+// instead. A limit of 0 returns every conversation.
+//
+// Everything is loaded for the whole page at once: one query for the
+// conversations with their last message and unread count, then one each for
+// participants, attachments and reply previews.
+func (s *Service) ListConversations(ctx context.Context, userID uuid.UUID, sort, cursor string, limit int) ([]*DMConversationResponse, string, error) {
+	if sort != ConversationSortRecent {
+		sort = ""
+	}
+	sortKey := `c.updated_at`
+	if sort == ConversationSortRecent {
+		sortKey = `COALESCE(p.last_interaction_at, c.updated_at)`
+	}
+	after, err := utils.DecodeKeysetCursor(cursor, sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	where := `p.user_id = $1`
+	args := []any{userID}
+	if after != nil {
+		where += ` AND (` + sortKey + `, c.id) < ($2, $3)`
+		args = append(args, after.Time, after.ID)
+	}
+	page := ``
+	if limit > 0 {
+		// One extra row tells whether there is a next page
+		args = append(args, limit+1)
+		page = fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
 	rows, err := s.db.Query(ctx,
-		`SELECT c.id, c.created_at, c.updated_at
+		`SELECT c.id, c.created_at, c.updated_at, p.last_interaction_at, unread.count,
+		        lm.id, lm.sender_id, lm.encrypted_content, lm.nonce, lm.content_warning, lm.reply_to_id,
+		        lm.is_edited, lm.reactions, lm.link_previews, lm.created_at, lm.updated_at
 		 FROM dm_conversations c
 		 JOIN dm_participants p ON p.conversation_id = c.id
-		 WHERE p.user_id = $1
-		 ORDER BY c.updated_at DESC`,
-		userID,
+		 LEFT JOIN LATERAL (
+		   SELECT id, sender_id, encrypted_content, nonce, content_warning, reply_to_id,
+		          is_edited, reactions, link_previews, created_at, updated_at
+		   FROM direct_messages
+		   WHERE conversation_id = c.id AND deleted_at IS NULL
+		   ORDER BY created_at DESC, id DESC
+		   LIMIT 1
+		 ) lm ON TRUE
+		 CROSS JOIN LATERAL (
+		   SELECT COUNT(*) AS count FROM direct_messages d
+		   WHERE d.conversation_id = c.id AND d.deleted_at IS NULL
+		     AND d.created_at > COALESCE(p.last_read_at, 'epoch') AND d.sender_id <> $1
+		 ) unread
+		 WHERE `+where+`
+		 ORDER BY `+sortKey+` DESC, c.id DESC`+page,
+		args...,
 	)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
+	type lastMessage struct {
+		msg          models.DirectMessage
+		nonce        []byte
+		reactionsRaw []byte
+		previewsRaw  []byte
+	}
+
 	var responses []*DMConversationResponse
+	hasMore := false
+	lastMessages := make(map[uuid.UUID]*lastMessage)
+	var conversationIDs, lastMessageIDs, replyToIDs []uuid.UUID
 	for rows.Next() {
-		var convo models.DMConversation
-		if err := rows.Scan(&convo.ID, &convo.CreatedAt, &convo.UpdatedAt); err != nil {
-			return nil, err
-		}
-		resp, err := s.buildConversationResponse(ctx, convo, userID)
+		resp := &DMConversationResponse{}
+		var last lastMessage
+		var lastID, senderID *uuid.UUID
+		var isEdited *bool
+		var createdAt, updatedAt *time.Time
+		err := rows.Scan(
+			&resp.ID, &resp.CreatedAt, &resp.UpdatedAt, &resp.LastInteractionAt, &resp.UnreadCount,
+			&lastID, &senderID, &last.msg.EncryptedContent, &last.nonce, &last.msg.ContentWarning, &last.msg.ReplyToID,
+			&isEdited, &last.reactionsRaw, &last.previewsRaw, &createdAt, &updatedAt,
+		)
 		if err != nil {
-			return nil, err
+			return nil, "", err
+		}
+		if limit > 0 && len(responses) == limit {
+			hasMore = true
+			break
 		}
 		responses = append(responses, resp)
+		conversationIDs = append(conversationIDs, resp.ID)
+
+		if lastID == nil {
+			continue
+		}
+		last.msg.ID, last.msg.ConversationID, last.msg.SenderID = *lastID, resp.ID, *senderID
+		last.msg.IsEdited, last.msg.CreatedAt, last.msg.UpdatedAt = *isEdited, *createdAt, *updatedAt
+		lastMessages[resp.ID] = &last
+		lastMessageIDs = append(lastMessageIDs, last.msg.ID)
+		if last.msg.ReplyToID != nil {
+			replyToIDs = append(replyToIDs, *last.msg.ReplyToID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	rows.Close()
+	if len(responses) == 0 {
+		return responses, "", nil
+	}
+
+	var next string
+	if hasMore {
+		last := responses[limit-1]
+		position := utils.KeysetCursor{Time: last.UpdatedAt, ID: last.ID, Sort: sort}
+		if sort == ConversationSortRecent && last.LastInteractionAt != nil {
+			position.Time = *last.LastInteractionAt
+		}
+		next = utils.EncodeCursor(position)
+	}
+
+	participants, err := s.getParticipantsByConversation(ctx, conversationIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	attachments := s.batchGetDmAttachments(ctx, lastMessageIDs)
+	replyPreviews, err := s.getReplyPreviews(ctx, replyToIDs)
+	if err != nil {
+		return nil, "", err
 	}
 
-	return responses, nil
+	for _, resp := range responses {
+		resp.Participants = participants[resp.ID]
+		last, ok := lastMessages[resp.ID]
+		if !ok {
+			continue
+		}
+		if last.reactionsRaw != nil {
+			json.Unmarshal(last.reactionsRaw, &last.msg.Reactions)
+		}
+		last.msg.LinkPreviews = s.camo.LinkPreviews(messaging.DecodeLinkPreviews(last.previewsRaw))
+		resp.LastMessage = s.lastMessageResponse(ctx, &last.msg, last.nonce, resp.Participants, attachments[last.msg.ID], userID)
+		if last.msg.ReplyToID != nil {
+			resp.LastMessage.ReplyTo = replyPreviews[*last.msg.ReplyToID]
+		}
+	}
+
+	return responses, next, nil
 }
 
 func (s *Service) GetConversation(ctx context.Context, conversationID, userID uuid.UUID) (*DMConversationResponse, error) {
@@ -269,53 +449,151 @@ func (s *Service) GetConversation(ctx context.Context, conversationID, userID uu
 	return s.buildConversationResponse(ctx, convo, userID)
 }
 
+// dmMessageColumns are the columns queryMessages scans
+const dmMessageColumns = `m.id, m.conversation_id, m.sender_id, m.encrypted_content, m.nonce, m.content_warning, m.reply_to_id, m.is_edited, m.reactions, m.link_previews, m.created_at, m.updated_at,
+	       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at`
+
+// GetMessages pages a conversation's history. Messages with sortable IDs are
+// paged on the ID alone; older ones with random IDs all come before them and
+// are paged on (created_at, id), which also fills the rest of a page once the
+// sortable ones run out.
 func (s *Service) GetMessages(ctx context.Context, conversationID, userID uuid.UUID, params *GetMessagesParams) ([]*DMMessageResponse, error) {
 	if !s.CanAccessConversation(ctx, conversationID, userID) {
 		return nil, ErrNotParticipant
 	}
 
-	limit := params.Limit
-	if limit <= 0 || limit > 100 {
-		limit = 50
-	}
-
-	var query string
-	var args []interface{}
+	limit := messaging.HistoryLimit(params.Limit)
+	sortable := messaging.SortableID("m.id")
 
-	if params.Before != nil {
-		query = `
-			SELECT m.id, m.conversation_id, m.sender_id, m.encrypted_content, m.nonce, m.reply_to_id, m.is_edited, m.reactions, m.link_previews, m.created_at, m.updated_at,
-			       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
+	var messages []*DMMessageResponse
+	var err error
+	switch {
+	case params.Before != nil:
+		cursorTime, ok := messaging.MessageIDTime(*params.Before)
+		if !ok {
+			messages, err = s.messagesBefore(ctx, conversationID, userID, *params.Before, nil, limit)
+			break
+		}
+		messages, err = s.queryMessages(ctx, userID, `
+			SELECT `+dmMessageColumns+`
 			FROM direct_messages m
 			JOIN users u ON u.id = m.sender_id
 			WHERE m.conversation_id = $1 AND m.deleted_at IS NULL
-			  AND m.created_at < (SELECT created_at FROM direct_messages WHERE id = $2)
-			ORDER BY m.created_at DESC
-			LIMIT $3`
-		args = []interface{}{conversationID, *params.Before, limit}
-	} else if params.After != nil {
-		query = `
-			SELECT m.id, m.conversation_id, m.sender_id, m.encrypted_content, m.nonce, m.reply_to_id, m.is_edited, m.reactions, m.link_previews, m.created_at, m.updated_at,
-			       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
+			  AND `+sortable+` AND m.id < $2
+			ORDER BY m.id DESC
+			LIMIT $3`,
+			conversationID, *params.Before, limit,
+		)
+		if err == nil && len(messages) < limit {
+			messages, err = s.fillMessages(ctx, conversationID, userID, messages, *params.Before, cursorTime, limit)
+		}
+	case params.After != nil:
+		if _, ok := messaging.MessageIDTime(*params.After); ok {
+			messages, err = s.queryMessages(ctx, userID, `
+				SELECT `+dmMessageColumns+`
+				FROM direct_messages m
+				JOIN users u ON u.id = m.sender_id
+				WHERE m.conversation_id = $1 AND m.deleted_at IS NULL
+				  AND `+sortable+` AND m.id > $2
+				ORDER BY m.id ASC
+				LIMIT $3`,
+				conversationID, *params.After, limit,
+			)
+			break
+		}
+		// Messages with random IDs come first, in (created_at, id) order,
+		// then the sortable ones, whose (created_at, id) order is their ID's
+		messages, err = s.queryMessages(ctx, userID, `
+			SELECT `+dmMessageColumns+`
 			FROM direct_messages m
 			JOIN users u ON u.id = m.sender_id
 			WHERE m.conversation_id = $1 AND m.deleted_at IS NULL
-			  AND m.created_at > (SELECT created_at FROM direct_messages WHERE id = $2)
-			ORDER BY m.created_at ASC
-			LIMIT $3`
-		args = []interface{}{conversationID, *params.After, limit}
-	} else {
-		query = `
-			SELECT m.id, m.conversation_id, m.sender_id, m.encrypted_content, m.nonce, m.reply_to_id, m.is_edited, m.reactions, m.link_previews, m.created_at, m.updated_at,
-			       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
+			  AND (m.created_at, m.id) > ((SELECT created_at FROM direct_messages WHERE id = $2 AND conversation_id = $1), $2)
+			ORDER BY m.created_at ASC, m.id ASC
+			LIMIT $3`,
+			conversationID, *params.After, limit,
+		)
+	default:
+		messages, err = s.queryMessages(ctx, userID, `
+			SELECT `+dmMessageColumns+`
 			FROM direct_messages m
 			JOIN users u ON u.id = m.sender_id
 			WHERE m.conversation_id = $1 AND m.deleted_at IS NULL
-			ORDER BY m.created_at DESC
-			LIMIT $2`
-		args = []interface{}{conversationID, limit}
+			  AND `+sortable+`
+			ORDER BY m.id DESC
+			LIMIT $2`,
+			conversationID, limit,
+		)
+		if err != nil || len(messages) == limit {
+			break
+		}
+		if len(messages) == 0 {
+			messages, err = s.queryMessages(ctx, userID, `
+				SELECT `+dmMessageColumns+`
+				FROM direct_messages m
+				JOIN users u ON u.id = m.sender_id
+				WHERE m.conversation_id = $1 AND m.deleted_at IS NULL
+				ORDER BY m.created_at DESC, m.id DESC
+				LIMIT $2`,
+				conversationID, limit,
+			)
+			break
+		}
+		messages, err = s.fillMessages(ctx, conversationID, userID, messages, uuid.Nil, time.Time{}, limit)
+	}
+	if err != nil {
+		return nil, err
 	}
 
+	if len(messages) > 0 {
+		messageIDs := make([]uuid.UUID, 0, len(messages))
+		for _, message := range messages {
+			messageIDs = append(messageIDs, message.ID)
+		}
+		attachmentMap := s.batchGetDmAttachments(ctx, messageIDs)
+		for _, message := range messages {
+			if attachments, ok := attachmentMap[message.ID]; ok {
+				message.Attachments = s.urlSigner.SignAttachments(attachments, userID)
+			}
+		}
+	}
+
+	return messages, nil
+}
+
+// fillMessages completes a page of sortable messages that ran out with the
+// older ones before the page's last message, or before the cursor when the
+// page is empty
+func (s *Service) fillMessages(ctx context.Context, conversationID, userID uuid.UUID, page []*DMMessageResponse, cursor uuid.UUID, cursorTime time.Time, limit int) ([]*DMMessageResponse, error) {
+	if len(page) > 0 {
+		last := page[len(page)-1]
+		cursor, cursorTime = last.ID, last.CreatedAt
+	}
+	older, err := s.messagesBefore(ctx, conversationID, userID, cursor, &cursorTime, limit-len(page))
+	if err != nil {
+		return nil, err
+	}
+	return append(page, older...), nil
+}
+
+// messagesBefore pages on (created_at, id) before cursor. cursorTime is the
+// cursor's created_at, or nil to look it up.
+func (s *Service) messagesBefore(ctx context.Context, conversationID, userID, cursor uuid.UUID, cursorTime *time.Time, limit int) ([]*DMMessageResponse, error) {
+	return s.queryMessages(ctx, userID, `
+		SELECT `+dmMessageColumns+`
+		FROM direct_messages m
+		JOIN users u ON u.id = m.sender_id
+		WHERE m.conversation_id = $1 AND m.deleted_at IS NULL
+		  AND (m.created_at, m.id) < (COALESCE($4, (SELECT created_at FROM direct_messages WHERE id = $2 AND conversation_id = $1)), $2)
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $3`,
+		conversationID, cursor, limit, cursorTime,
+	)
+}
+
+// queryMessages runs a history query selecting dmMessageColumns and builds
+// the responses, without attachments
+func (s *Service) queryMessages(ctx context.Context, userID uuid.UUID, query string, args ...any) ([]*DMMessageResponse, error) {
 	rows, err := s.db.Query(ctx, query, args...)
 	if err != nil {
 		return nil, err
@@ -323,7 +601,6 @@ func (s *Service) GetMessages(ctx context.Context, conversationID, userID uuid.U
 	defer rows.Close()
 
 	var messages []*DMMessageResponse
-	messageIDs := make([]uuid.UUID, 0)
 	for rows.Next() {
 		var msg models.DirectMessage
 		var nonce []byte
@@ -331,24 +608,22 @@ func (s *Service) GetMessages(ctx context.Context, conversationID, userID uuid.U
 		var sender models.PublicUser
 
 		if err := rows.Scan(
-			&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.EncryptedContent, &nonce,
+			&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.EncryptedContent, &nonce, &msg.ContentWarning,
 			&msg.ReplyToID, &msg.IsEdited, &msg.Reactions, &linkPreviewRaw, &msg.CreatedAt, &msg.UpdatedAt,
 			&sender.ID, &sender.Username, &sender.DisplayName, &sender.AvatarURL, &sender.Bio, &sender.Status, &sender.CustomStatus, &sender.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
-		msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
+		msg.LinkPreviews = s.camo.LinkPreviews(messaging.DecodeLinkPreviews(linkPreviewRaw))
 
-		content, err := s.cipher.Decrypt(msg.EncryptedContent, nonce)
-		if err != nil {
-			content = "[Decryption Error]"
-		}
+		content, _ := messaging.DecryptOrPlaceholder(s.db, s.cipher, contentRef(&msg), msg.EncryptedContent, nonce)
 
 		response := &DMMessageResponse{
 			ID:             msg.ID,
 			ConversationID: msg.ConversationID,
 			SenderID:       msg.SenderID,
 			Content:        content,
+			ContentWarning: msg.ContentWarning,
 			IsEdited:       msg.IsEdited,
 			Reactions:      s.buildReactions(msg.Reactions, userID),
 			LinkPreviews:   msg.LinkPreviews,
@@ -360,36 +635,30 @@ func (s *Service) GetMessages(ctx context.Context, conversationID, userID uuid.U
 			response.ReplyTo, _ = s.getReplyPreview(ctx, *msg.ReplyToID)
 		}
 		messages = append(messages, response)
-		messageIDs = append(messageIDs, msg.ID)
 	}
-
-	if len(messageIDs) > 0 {
-		attachmentMap := s.batchGetDmAttachments(ctx, messageIDs)
-		for _, message := range messages {
-			if attachments, ok := attachmentMap[message.ID]; ok {
-				message.Attachments = attachments
-			}
-		}
-	}
-
-	return messages, nil
+	return messages, rows.Err()
 }
 
 func (s *Service) SendMessage(ctx context.Context, conversationID, userID uuid.UUID, req *SendMessageRequest) (*DMMessageResponse, error) {
 	if !s.CanAccessConversation(ctx, conversationID, userID) {
 		return nil, ErrNotParticipant
 	}
+	if s.isReadOnlyFor(ctx, conversationID, userID) {
+		return nil, ErrReadOnly
+	}
+	if err := s.runAutoMod(ctx, conversationID, userID, req.Content); err != nil {
+		return nil, err
+	}
 
 	linkPreviews := messaging.BuildLinkPreviews(ctx, req.Content)
 	linkPreviewJSON := messaging.EncodeLinkPreviews(linkPreviews)
 
-	ciphertext, nonce, err := s.cipher.Encrypt(req.Content)
+	messageID, now := messaging.NewMessageID()
+	ciphertext, nonce, err := s.cipher.Encrypt(messaging.DMRow(messageID), req.Content)
 	if err != nil {
 		return nil, err
 	}
-
-	now := time.Now()
-	messageID := uuid.New()
+	contentWarning := messaging.ContentWarningOrNil(req.ContentWarning)
 
 	tx, err := s.db.Begin(ctx)
 	if err != nil {
@@ -415,9 +684,9 @@ func (s *Service) SendMessage(ctx context.Context, conversationID, userID uuid.U
 	}
 
 	_, err = tx.Exec(ctx,
-		`INSERT INTO direct_messages (id, conversation_id, sender_id, encrypted_content, nonce, reply_to_id, link_previews, created_at, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8, $8)`,
-		messageID, conversationID, userID, ciphertext, nonce, req.ReplyToID, string(linkPreviewJSON), now,
+		`INSERT INTO direct_messages (id, conversation_id, sender_id, encrypted_content, nonce, content_warning, reply_to_id, link_previews, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8::jsonb, $9, $9)`,
+		messageID, conversationID, userID, ciphertext, nonce, contentWarning, req.ReplyToID, string(linkPreviewJSON), now,
 	)
 	if err != nil {
 		return nil, err
@@ -443,6 +712,13 @@ func (s *Service) SendMessage(ctx context.Context, conversationID, userID uuid.U
 		}
 	}
 
+	if err := messaging.ApplyAttachmentOptions(ctx, tx, userID, req.Attachments, req.AttachmentOptions); err != nil {
+		if errors.Is(err, messaging.ErrUnknownAttachment) {
+			return nil, ErrInvalidAttachment
+		}
+		return nil, err
+	}
+
 	_, err = tx.Exec(ctx,
 		`UPDATE dm_conversations SET updated_at = $2 WHERE id = $1`,
 		conversationID, now,
@@ -452,7 +728,7 @@ func (s *Service) SendMessage(ctx context.Context, conversationID, userID uuid.U
 	}
 
 	_, err = tx.Exec(ctx,
-		`UPDATE dm_participants SET last_read_at = $3 WHERE conversation_id = $1 AND user_id = $2`,
+		`UPDATE dm_participants SET last_read_at = $3, last_interaction_at = $3 WHERE conversation_id = $1 AND user_id = $2`,
 		conversationID, userID, now,
 	)
 	if err != nil {
@@ -468,7 +744,11 @@ func (s *Service) SendMessage(ctx context.Context, conversationID, userID uuid.U
 		return nil, err
 	}
 
-	s.broadcast(ctx, conversationID.String(), "DM_MESSAGE_CREATE", resp)
+	s.broadcast(ctx, conversationID.String(), "DM_MESSAGE_CREATE", s.eventView(resp))
+
+	if s.recencyService != nil {
+		s.recencyService.Touch(ctx, userID, recency.Ref{Kind: recency.KindDM, ID: conversationID})
+	}
 
 	// Dispatch DM notification to other participants.
 	if s.notificationService != nil {
@@ -486,6 +766,7 @@ func (s *Service) SendMessage(ctx context.Context, conversationID, userID uuid.U
 			SenderID:       userID,
 			SenderName:     senderName,
 			Content:        req.Content,
+			ContentWarning: contentWarning,
 		})
 	}
 
@@ -499,14 +780,14 @@ func (s *Service) GetMessage(ctx context.Context, messageID, userID uuid.UUID) (
 	var sender models.PublicUser
 
 	err := s.db.QueryRow(ctx,
-		`SELECT m.id, m.conversation_id, m.sender_id, m.encrypted_content, m.nonce, m.reply_to_id, m.is_edited, m.reactions, m.link_previews, m.created_at, m.updated_at,
+		`SELECT m.id, m.conversation_id, m.sender_id, m.encrypted_content, m.nonce, m.content_warning, m.reply_to_id, m.is_edited, m.reactions, m.link_previews, m.created_at, m.updated_at,
 		        u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
 		 FROM direct_messages m
 		 JOIN users u ON u.id = m.sender_id
 		 WHERE m.id = $1 AND m.deleted_at IS NULL`,
 		messageID,
 	).Scan(
-		&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.EncryptedContent, &nonce, &msg.ReplyToID, &msg.IsEdited, &msg.Reactions, &linkPreviewRaw, &msg.CreatedAt, &msg.UpdatedAt,
+		&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.EncryptedContent, &nonce, &msg.ContentWarning, &msg.ReplyToID, &msg.IsEdited, &msg.Reactions, &linkPreviewRaw, &msg.CreatedAt, &msg.UpdatedAt,
 		&sender.ID, &sender.Username, &sender.DisplayName, &sender.AvatarURL, &sender.Bio, &sender.Status, &sender.CustomStatus, &sender.CreatedAt,
 	)
 	if err != nil {
@@ -520,19 +801,18 @@ func (s *Service) GetMessage(ctx context.Context, messageID, userID uuid.UUID) (
 		return nil, ErrNotParticipant
 	}
 
-	content, err := s.cipher.Decrypt(msg.EncryptedContent, nonce)
-	if err != nil {
-		content = "[Decryption Error]"
-	}
-	msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
+	content, _ := messaging.DecryptOrPlaceholder(s.db, s.cipher, contentRef(&msg), msg.EncryptedContent, nonce)
+	msg.LinkPreviews = s.camo.LinkPreviews(messaging.DecodeLinkPreviews(linkPreviewRaw))
 
 	attachments, _ := s.getDmMessageAttachments(ctx, msg.ID)
+	attachments = s.urlSigner.SignAttachments(attachments, userID)
 
 	response := &DMMessageResponse{
 		ID:             msg.ID,
 		ConversationID: msg.ConversationID,
 		SenderID:       msg.SenderID,
 		Content:        content,
+		ContentWarning: msg.ContentWarning,
 		IsEdited:       msg.IsEdited,
 		Reactions:      s.buildReactions(msg.Reactions, userID),
 		Attachments:    attachments,
@@ -566,18 +846,23 @@ func (s *Service) UpdateMessage(ctx context.Context, messageID, userID uuid.UUID
 	if senderID != userID {
 		return nil, ErrNotMessageOwner
 	}
+	if err := s.runAutoMod(ctx, conversationID, userID, req.Content); err != nil {
+		return nil, err
+	}
 
 	linkPreviews := messaging.BuildLinkPreviews(ctx, req.Content)
 	linkPreviewJSON := messaging.EncodeLinkPreviews(linkPreviews)
 
-	ciphertext, nonce, err := s.cipher.Encrypt(req.Content)
+	ciphertext, nonce, err := s.cipher.Encrypt(messaging.DMRow(messageID), req.Content)
 	if err != nil {
 		return nil, err
 	}
 
 	_, err = s.db.Exec(ctx,
-		`UPDATE direct_messages SET encrypted_content = $1, nonce = $2, link_previews = $3::jsonb, is_edited = TRUE, updated_at = $4 WHERE id = $5`,
-		ciphertext, nonce, string(linkPreviewJSON), time.Now(), messageID,
+		`UPDATE direct_messages SET encrypted_content = $1, nonce = $2, link_previews = $3::jsonb, is_edited = TRUE, updated_at = $4,
+			content_warning = CASE WHEN $6::text IS NULL THEN content_warning ELSE NULLIF($6, '') END
+		 WHERE id = $5`,
+		ciphertext, nonce, string(linkPreviewJSON), time.Now(), messageID, messaging.NormalizeContentWarning(req.ContentWarning),
 	)
 	if err != nil {
 		return nil, err
@@ -588,7 +873,7 @@ func (s *Service) UpdateMessage(ctx context.Context, messageID, userID uuid.UUID
 		return nil, err
 	}
 
-	s.broadcast(ctx, conversationID.String(), "DM_MESSAGE_UPDATE", resp)
+	s.broadcast(ctx, conversationID.String(), "DM_MESSAGE_UPDATE", s.eventView(resp))
 
 	return resp, nil
 }
@@ -628,6 +913,10 @@ func (s *Service) DeleteMessage(ctx context.Context, messageID, userID uuid.UUID
 	return nil
 }
 
+type AddReactionRequest struct {
+	Emoji string `json:"emoji" validate:"required"`
+}
+
 func (s *Service) AddReaction(ctx context.Context, messageID, userID uuid.UUID, emoji string) error {
 	emoji = strings.TrimSpace(emoji)
 	if len(emoji) == 0 || len(emoji) > 128 {
@@ -649,7 +938,11 @@ func (s *Service) AddReaction(ctx context.Context, messageID, userID uuid.UUID,
 	if !s.CanAccessConversation(ctx, conversationID, userID) {
 		return ErrNotParticipant
 	}
+	if err := messaging.CheckReactionRate(ctx, s.redis, userID); err != nil {
+		return err
+	}
 
+	// Re-adding an emoji is always fine; a new one counts towards the user's cap
 	query := `
 		UPDATE direct_messages
 		SET reactions = jsonb_set(
@@ -658,12 +951,19 @@ func (s *Service) AddReaction(ctx context.Context, messageID, userID uuid.UUID,
 			(coalesce(reactions->$1, '[]'::jsonb) - $2::text) || jsonb_build_array($2::text)
 		),
 		updated_at = $3
-		WHERE id = $4 AND deleted_at IS NULL`
+		WHERE id = $4 AND deleted_at IS NULL
+		AND (
+			coalesce(reactions->$1, '[]'::jsonb) ? $2::text
+			OR (SELECT COUNT(*) FROM jsonb_each(coalesce(reactions, '{}'::jsonb)) r WHERE r.value ? $2::text) < $5
+		)`
 
-	_, err = s.db.Exec(ctx, query, emoji, userID.String(), time.Now(), messageID)
+	tag, err := s.db.Exec(ctx, query, emoji, userID.String(), time.Now(), messageID, messaging.MaxReactionsPerUser)
 	if err != nil {
 		return err
 	}
+	if tag.RowsAffected() == 0 {
+		return ErrTooManyReactions
+	}
 
 	s.broadcast(ctx, conversationID.String(), "DM_REACTION_ADD", map[string]interface{}{
 		"conversationId": conversationID.String(),
@@ -723,7 +1023,7 @@ func (s *Service) MarkRead(ctx context.Context, conversationID, userID uuid.UUID
 	}
 
 	_, err := s.db.Exec(ctx,
-		`UPDATE dm_participants SET last_read_at = $3 WHERE conversation_id = $1 AND user_id = $2`,
+		`UPDATE dm_participants SET last_read_at = $3, last_interaction_at = $3 WHERE conversation_id = $1 AND user_id = $2`,
 		conversationID, userID, time.Now(),
 	)
 	return err
@@ -740,18 +1040,19 @@ func (s *Service) buildConversationResponse(ctx context.Context, convo models.DM
 		return nil, err
 	}
 
-	unreadCount, err := s.getUnreadCount(ctx, convo.ID, userID)
+	unreadCount, lastInteraction, err := s.getReadState(ctx, convo.ID, userID)
 	if err != nil {
 		return nil, err
 	}
 
 	return &DMConversationResponse{
-		ID:           convo.ID,
-		Participants: participants,
-		LastMessage:  lastMessage,
-		UnreadCount:  unreadCount,
-		CreatedAt:    convo.CreatedAt,
-		UpdatedAt:    convo.UpdatedAt,
+		ID:                convo.ID,
+		Participants:      participants,
+		LastMessage:       lastMessage,
+		UnreadCount:       unreadCount,
+		LastInteractionAt: lastInteraction,
+		CreatedAt:         convo.CreatedAt,
+		UpdatedAt:         convo.UpdatedAt,
 	}, nil
 }
 
@@ -780,19 +1081,47 @@ func (s *Service) getParticipants(ctx context.Context, conversationID uuid.UUID)
 	return participants, nil
 }
 
+// getParticipantsByConversation loads the participants of several
+// conversations in one query
+func (s *Service) getParticipantsByConversation(ctx context.Context, conversationIDs []uuid.UUID) (map[uuid.UUID][]models.PublicUser, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT p.conversation_id, u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
+		 FROM dm_participants p
+		 JOIN users u ON u.id = p.user_id
+		 WHERE p.conversation_id = ANY($1) AND u.deleted_at IS NULL`,
+		conversationIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := make(map[uuid.UUID][]models.PublicUser, len(conversationIDs))
+	for rows.Next() {
+		var conversationID uuid.UUID
+		var user models.PublicUser
+		if err := rows.Scan(&conversationID, &user.ID, &user.Username, &user.DisplayName, &user.AvatarURL, &user.Bio, &user.Status, &user.CustomStatus, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		participants[conversationID] = append(participants[conversationID], user)
+	}
+
+	return participants, rows.Err()
+}
+
 func (s *Service) getLastMessage(ctx context.Context, conversationID uuid.UUID, participants []models.PublicUser, userID uuid.UUID) (*DMMessageResponse, error) {
 	var msg models.DirectMessage
 	var nonce []byte
 	var linkPreviewRaw []byte
 
 	err := s.db.QueryRow(ctx,
-		`SELECT id, conversation_id, sender_id, encrypted_content, nonce, reply_to_id, is_edited, reactions, link_previews, created_at, updated_at
+		`SELECT id, conversation_id, sender_id, encrypted_content, nonce, content_warning, reply_to_id, is_edited, reactions, link_previews, created_at, updated_at
 		 FROM direct_messages
 		 WHERE conversation_id = $1 AND deleted_at IS NULL
 		 ORDER BY created_at DESC
 		 LIMIT 1`,
 		conversationID,
-	).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.EncryptedContent, &nonce, &msg.ReplyToID, &msg.IsEdited, &msg.Reactions, &linkPreviewRaw, &msg.CreatedAt, &msg.UpdatedAt)
+	).Scan(&msg.ID, &msg.ConversationID, &msg.SenderID, &msg.EncryptedContent, &nonce, &msg.ContentWarning, &msg.ReplyToID, &msg.IsEdited, &msg.Reactions, &linkPreviewRaw, &msg.CreatedAt, &msg.UpdatedAt)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
@@ -800,11 +1129,21 @@ func (s *Service) getLastMessage(ctx context.Context, conversationID uuid.UUID,
 		return nil, err
 	}
 
-	content, err := s.cipher.Decrypt(msg.EncryptedContent, nonce)
-	if err != nil {
-		content = "[Decryption Error]"
+	msg.LinkPreviews = s.camo.LinkPreviews(messaging.DecodeLinkPreviews(linkPreviewRaw))
+	attachments, _ := s.getDmMessageAttachments(ctx, msg.ID)
+
+	response := s.lastMessageResponse(ctx, &msg, nonce, participants, attachments, userID)
+	if msg.ReplyToID != nil {
+		response.ReplyTo, _ = s.getReplyPreview(ctx, *msg.ReplyToID)
 	}
-	msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
+
+	return response, nil
+}
+
+// lastMessageResponse decrypts a conversation's last message, taking its
+// sender from the participants when they're still in the conversation
+func (s *Service) lastMessageResponse(ctx context.Context, msg *models.DirectMessage, nonce []byte, participants []models.PublicUser, attachments []models.MessageAttachment, userID uuid.UUID) *DMMessageResponse {
+	content, _ := messaging.DecryptOrPlaceholder(s.db, s.cipher, contentRef(msg), msg.EncryptedContent, nonce)
 
 	var sender *models.PublicUser
 	for i := range participants {
@@ -820,36 +1159,31 @@ func (s *Service) getLastMessage(ctx context.Context, conversationID uuid.UUID,
 		}
 	}
 
-	attachments, _ := s.getDmMessageAttachments(ctx, msg.ID)
-
-	response := &DMMessageResponse{
+	return &DMMessageResponse{
 		ID:             msg.ID,
 		ConversationID: msg.ConversationID,
 		SenderID:       msg.SenderID,
 		Content:        content,
+		ContentWarning: msg.ContentWarning,
 		IsEdited:       msg.IsEdited,
 		Reactions:      s.buildReactions(msg.Reactions, userID),
-		Attachments:    attachments,
+		Attachments:    s.urlSigner.SignAttachments(attachments, userID),
 		LinkPreviews:   msg.LinkPreviews,
 		CreatedAt:      msg.CreatedAt,
 		UpdatedAt:      msg.UpdatedAt,
 		Sender:         sender,
 	}
-	if msg.ReplyToID != nil {
-		response.ReplyTo, _ = s.getReplyPreview(ctx, *msg.ReplyToID)
-	}
-
-	return response, nil
 }
 
-func (s *Service) getUnreadCount(ctx context.Context, conversationID, userID uuid.UUID) (int, error) {
+// getReadState returns the user's unread count and last interaction time for a conversation
+func (s *Service) getReadState(ctx context.Context, conversationID, userID uuid.UUID) (int, *time.Time, error) {
 	var count int
-	var lastRead *time.Time
+	var lastRead, lastInteraction *time.Time
 
 	_ = s.db.QueryRow(ctx,
-		`SELECT last_read_at FROM dm_participants WHERE conversation_id = $1 AND user_id = $2`,
+		`SELECT last_read_at, last_interaction_at FROM dm_participants WHERE conversation_id = $1 AND user_id = $2`,
 		conversationID, userID,
-	).Scan(&lastRead)
+	).Scan(&lastRead, &lastInteraction)
 
 	if lastRead == nil {
 		lastReadTime := time.Unix(0, 0)
@@ -863,10 +1197,10 @@ func (s *Service) getUnreadCount(ctx context.Context, conversationID, userID uui
 		conversationID, *lastRead, userID,
 	).Scan(&count)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
-	return count, nil
+	return count, lastInteraction, nil
 }
 
 func (s *Service) buildReactions(reactions map[string][]uuid.UUID, userID uuid.UUID) []models.ReactionCount {
@@ -896,43 +1230,116 @@ func (s *Service) buildReactions(reactions map[string][]uuid.UUID, userID uuid.U
 	return result
 }
 
+// runAutoMod checks content about to be sent to a conversation against the
+// AutoMod rules of the communities the sender shares with the other
+// participants. Nothing is stored for review, so a match that would delete
+// the message blocks it. Evaluation errors let the message through.
+func (s *Service) runAutoMod(ctx context.Context, conversationID, userID uuid.UUID, content string) error {
+	if s.automodService == nil {
+		return nil
+	}
+	var recipientIDs []uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`SELECT COALESCE(array_agg(user_id), '{}') FROM dm_participants WHERE conversation_id = $1 AND user_id <> $2`,
+		conversationID, userID,
+	).Scan(&recipientIDs)
+	if err != nil {
+		return err
+	}
+	verdicts, err := s.automodService.EvaluateDM(ctx, userID, recipientIDs, content)
+	if err != nil {
+		log.Warn().Err(err).Str("conversationId", conversationID.String()).Msg("AutoMod evaluation failed")
+		return nil
+	}
+
+	blocked := false
+	for _, verdict := range verdicts {
+		s.automodService.LogVerdict(ctx, verdict, nil)
+		if verdict.Action != models.AutoModActionFlag {
+			blocked = true
+		}
+	}
+	if blocked {
+		return ErrBlockedByAutoMod
+	}
+	return nil
+}
+
+func contentRef(msg *models.DirectMessage) messaging.ContentRef {
+	return messaging.ContentRef{Kind: messaging.ContentKindDM, ID: msg.ID, ContainerID: msg.ConversationID}
+}
+
 func (s *Service) getReplyPreview(ctx context.Context, messageID uuid.UUID) (*DMReplyPreview, error) {
+	previews, err := s.getReplyPreviews(ctx, []uuid.UUID{messageID})
+	if err != nil {
+		return nil, err
+	}
+	preview, ok := previews[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return preview, nil
+}
+
+// replyPreviewLength is how many characters of the replied-to message a
+// preview shows
+const replyPreviewLength = 100
+
+// getReplyPreviews loads the previews of several replied-to messages in one
+// query, keyed by message. Deleted messages have no preview.
+func (s *Service) getReplyPreviews(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID]*DMReplyPreview, error) {
+	previews := make(map[uuid.UUID]*DMReplyPreview, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return previews, nil
+	}
+
 	query := `
-		SELECT m.id, m.sender_id, m.encrypted_content, m.nonce,
+		SELECT m.id, m.conversation_id, m.sender_id, m.encrypted_content, m.nonce, m.content_warning,
 		       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
 		FROM direct_messages m
 		JOIN users u ON u.id = m.sender_id
-		WHERE m.id = $1 AND m.deleted_at IS NULL`
-
-	var preview DMReplyPreview
-	var encContent []byte
-	var nonce []byte
-	var sender models.PublicUser
+		WHERE m.id = ANY($1) AND m.deleted_at IS NULL`
 
-	err := s.db.QueryRow(ctx, query, messageID).Scan(
-		&preview.ID, &preview.SenderID, &encContent, &nonce,
-		&sender.ID, &sender.Username, &sender.DisplayName, &sender.AvatarURL, &sender.Bio, &sender.Status, &sender.CustomStatus, &sender.CreatedAt,
-	)
+	rows, err := s.db.Query(ctx, query, messageIDs)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	content, err := s.cipher.Decrypt(encContent, nonce)
-	if err != nil {
-		content = "[Decryption Error]"
-	} else if len(content) > 100 {
-		content = content[:100] + "..."
-	}
+	for rows.Next() {
+		var preview DMReplyPreview
+		var conversationID uuid.UUID
+		var encContent []byte
+		var nonce []byte
+		var sender models.PublicUser
+
+		err := rows.Scan(
+			&preview.ID, &conversationID, &preview.SenderID, &encContent, &nonce, &preview.ContentWarning,
+			&sender.ID, &sender.Username, &sender.DisplayName, &sender.AvatarURL, &sender.Bio, &sender.Status, &sender.CustomStatus, &sender.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		ref := messaging.ContentRef{Kind: messaging.ContentKindDM, ID: preview.ID, ContainerID: conversationID}
+		content, ok := messaging.DecryptOrPlaceholder(s.db, s.cipher, ref, encContent, nonce)
+		if ok {
+			if runes := []rune(content); len(runes) > replyPreviewLength {
+				content = string(runes[:replyPreviewLength]) + "..."
+			}
+		}
 
-	preview.Content = content
-	preview.Sender = &sender
+		preview.Content = content
+		preview.Sender = &sender
+		previews[preview.ID] = &preview
+	}
 
-	return &preview, nil
+	return previews, rows.Err()
 }
 
 func (s *Service) getDmMessageAttachments(ctx context.Context, messageID uuid.UUID) ([]models.MessageAttachment, error) {
 	query := `
-		SELECT id, dm_message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, width, height, created_at
+		SELECT id, dm_message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, medium_url, video_url, poster_url, width, height, duration_seconds, blurhash, processing_status, is_spoiler, description, created_at
 		FROM message_attachments
 		WHERE dm_message_id = $1`
 
@@ -946,7 +1353,7 @@ func (s *Service) getDmMessageAttachments(ctx context.Context, messageID uuid.UU
 	for rows.Next() {
 		var a models.MessageAttachment
 		err := rows.Scan(&a.ID, &a.MessageID, &a.MessageCreatedAt, &a.UploaderID, &a.Filename, &a.FileURL,
-			&a.FileSize, &a.ContentType, &a.ThumbnailURL, &a.Width, &a.Height, &a.CreatedAt)
+			&a.FileSize, &a.ContentType, &a.ThumbnailURL, &a.MediumURL, &a.VideoURL, &a.PosterURL, &a.Width, &a.Height, &a.DurationSeconds, &a.Blurhash, &a.ProcessingStatus, &a.IsSpoiler, &a.Description, &a.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -960,7 +1367,7 @@ func (s *Service) batchGetDmAttachments(ctx context.Context, messageIDs []uuid.U
 	result := make(map[uuid.UUID][]models.MessageAttachment)
 
 	query := `
-		SELECT id, dm_message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, width, height, created_at
+		SELECT id, dm_message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, medium_url, video_url, poster_url, width, height, duration_seconds, blurhash, processing_status, is_spoiler, description, created_at
 		FROM message_attachments
 		WHERE dm_message_id = ANY($1)`
 
@@ -974,7 +1381,7 @@ func (s *Service) batchGetDmAttachments(ctx context.Context, messageIDs []uuid.U
 		var a models.MessageAttachment
 		var dmMessageID *uuid.UUID
 		err := rows.Scan(&a.ID, &dmMessageID, &a.MessageCreatedAt, &a.UploaderID, &a.Filename, &a.FileURL,
-			&a.FileSize, &a.ContentType, &a.ThumbnailURL, &a.Width, &a.Height, &a.CreatedAt)
+			&a.FileSize, &a.ContentType, &a.ThumbnailURL, &a.MediumURL, &a.VideoURL, &a.PosterURL, &a.Width, &a.Height, &a.DurationSeconds, &a.Blurhash, &a.ProcessingStatus, &a.IsSpoiler, &a.Description, &a.CreatedAt)
 		if err != nil {
 			continue
 		}
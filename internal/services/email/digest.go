@@ -0,0 +1,356 @@
+package email
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/mailtemplate"
+)
+
+const (
+	digestPollInterval = time.Minute
+	digestBatch        = 20
+
+	// Notifications get this long to be seen in the app before they are mailed
+	digestDelay = 5 * time.Minute
+	// Older notifications are never mailed, so turning digests on doesn't dump a backlog
+	digestMaxAge = 7 * 24 * time.Hour
+
+	maxDigestItems     = 50
+	maxItemsPerGroup   = 5
+	maxItemBodyRunes   = 300
+	replyTokenTTL      = 7 * 24 * time.Hour
+	inboundRetention   = 30 * 24 * time.Hour
+	replyMarker        = "--- Reply above this line ---"
+	directMessageLabel = "Direct message"
+)
+
+// digestTypes are the notifications worth an email: things addressed to the user
+var digestTypes = []string{
+	string(models.NotificationTypeMentionUser),
+	string(models.NotificationTypeMentionRole),
+	string(models.NotificationTypeReply),
+	string(models.NotificationTypeDMMessage),
+	string(models.NotificationTypeMissedCall),
+}
+
+// digestItem is an unread notification with what the email needs to label it
+type digestItem struct {
+	ID             uuid.UUID
+	Title          string
+	Body           *string
+	CommunityID    *uuid.UUID
+	ChannelID      *uuid.UUID
+	MessageID      *uuid.UUID
+	ConversationID *uuid.UUID
+	CreatedAt      time.Time
+	ChannelName    *string
+	CommunityName  *string
+	ActorName      *string
+}
+
+// digestGroup is the notifications from one channel or DM conversation
+type digestGroup struct {
+	Label     string
+	Path      string
+	ReplyTo   string
+	Items     []digestItem
+	Remaining int
+}
+
+// Run mails digests of missed mentions, replies and DMs to users who are
+// offline and opted in
+func (s *Service) Run(ctx context.Context) {
+	if !s.Enabled() {
+		log.Info().Msg("SMTP is not configured; email digests are disabled")
+		return
+	}
+
+	ticker := time.NewTicker(digestPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sendDueDigests(ctx)
+		}
+	}
+}
+
+func (s *Service) sendDueDigests(ctx context.Context) {
+	userIDs, err := s.claimDueUsers(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to claim email digests")
+		return
+	}
+
+	for _, userID := range userIDs {
+		// Someone who is connected sees their notifications in the app
+		if s.presence.IsOnline(ctx, userID) {
+			continue
+		}
+		if err := s.sendDigest(ctx, userID); err != nil {
+			log.Warn().Err(err).Str("userId", userID.String()).Msg("Failed to send email digest")
+		}
+	}
+}
+
+// claimDueUsers moves last_digest_at forward for users whose interval has
+// passed and who have something to mail. Claiming first keeps two instances
+// from mailing the same user; a failed send waits for the next interval.
+func (s *Service) claimDueUsers(ctx context.Context) ([]uuid.UUID, error) {
+	now := time.Now()
+	rows, err := s.db.Query(ctx,
+		`UPDATE email_preferences ep SET last_digest_at = NOW()
+		WHERE ep.user_id IN (
+			SELECT p.user_id FROM email_preferences p
+			JOIN users u ON u.id = p.user_id
+			WHERE p.digest_enabled = TRUE AND u.email_verified = TRUE
+			  AND (p.last_digest_at IS NULL OR p.last_digest_at <= NOW() - make_interval(mins => p.digest_interval_minutes))
+			  AND EXISTS (
+				SELECT 1 FROM notifications n
+				WHERE n.user_id = p.user_id AND n.is_read = FALSE AND n.emailed_at IS NULL
+				  AND n.type = ANY($1) AND n.created_at BETWEEN $2 AND $3
+			  )
+			ORDER BY p.last_digest_at NULLS FIRST
+			LIMIT $4
+			FOR UPDATE OF p SKIP LOCKED
+		)
+		RETURNING ep.user_id`,
+		digestTypes, now.Add(-digestMaxAge), now.Add(-digestDelay), digestBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, id)
+	}
+	return userIDs, rows.Err()
+}
+
+func (s *Service) sendDigest(ctx context.Context, userID uuid.UUID) error {
+	var address, username string
+	var displayName *string
+	var replyEnabled bool
+	err := s.db.QueryRow(ctx,
+		`SELECT u.email, u.username, u.display_name, ep.reply_enabled
+		FROM users u JOIN email_preferences ep ON ep.user_id = u.id
+		WHERE u.id = $1`,
+		userID,
+	).Scan(&address, &username, &displayName, &replyEnabled)
+	if err != nil {
+		return err
+	}
+
+	items, err := s.pendingItems(ctx, userID)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+
+	groups := groupItems(items)
+	if replyEnabled && s.RepliesEnabled() {
+		for _, group := range groups {
+			if group.ReplyTo, err = s.issueReplyAddress(ctx, userID, group.Items[len(group.Items)-1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	name := username
+	if displayName != nil && strings.TrimSpace(*displayName) != "" {
+		name = *displayName
+	}
+
+	rendered, err := s.templates.Render(ctx, mailtemplate.TemplateNotificationDigest,
+		s.templates.UserLocale(ctx, userID), s.digestVars(name, len(items), groups))
+	if err != nil {
+		return err
+	}
+
+	msg := mailtemplate.Mail{
+		To:            address,
+		Message:       rendered,
+		AutoGenerated: true,
+	}
+	// A single conversation can be answered with the mail client's reply button
+	if len(groups) == 1 {
+		msg.ReplyTo = groups[0].ReplyTo
+	}
+	if err := s.send(msg); err != nil {
+		return err
+	}
+
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	_, err = s.db.Exec(ctx, `UPDATE notifications SET emailed_at = NOW() WHERE id = ANY($1)`, ids)
+	return err
+}
+
+func (s *Service) pendingItems(ctx context.Context, userID uuid.UUID) ([]digestItem, error) {
+	now := time.Now()
+	rows, err := s.db.Query(ctx,
+		`SELECT n.id, n.title, n.body, n.community_id, n.channel_id, n.message_id,
+		        (n.metadata->>'conversationId')::uuid, n.created_at,
+		        ch.name, co.name, COALESCE(a.display_name, a.username)
+		FROM notifications n
+		LEFT JOIN channels ch ON ch.id = n.channel_id
+		LEFT JOIN communities co ON co.id = n.community_id
+		LEFT JOIN users a ON a.id = n.actor_id
+		WHERE n.user_id = $1 AND n.is_read = FALSE AND n.emailed_at IS NULL
+		  AND n.type = ANY($2) AND n.created_at BETWEEN $3 AND $4
+		ORDER BY n.created_at
+		LIMIT $5`,
+		userID, digestTypes, now.Add(-digestMaxAge), now.Add(-digestDelay), maxDigestItems,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []digestItem
+	for rows.Next() {
+		var item digestItem
+		if err := rows.Scan(
+			&item.ID, &item.Title, &item.Body, &item.CommunityID, &item.ChannelID, &item.MessageID,
+			&item.ConversationID, &item.CreatedAt,
+			&item.ChannelName, &item.CommunityName, &item.ActorName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// groupItems splits notifications by conversation, keeping first-seen order
+func groupItems(items []digestItem) []*digestGroup {
+	var groups []*digestGroup
+	byKey := make(map[string]*digestGroup)
+
+	for _, item := range items {
+		var key string
+		switch {
+		case item.ConversationID != nil:
+			key = "dm:" + item.ConversationID.String()
+		case item.ChannelID != nil:
+			key = "channel:" + item.ChannelID.String()
+		default:
+			continue
+		}
+
+		group, ok := byKey[key]
+		if !ok {
+			group = &digestGroup{Label: groupLabel(item), Path: groupPath(item)}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		if len(group.Items) == maxItemsPerGroup {
+			// Keep the newest ones; the reply goes to the last item
+			group.Items = group.Items[1:]
+			group.Remaining++
+		}
+		group.Items = append(group.Items, item)
+	}
+	return groups
+}
+
+func groupLabel(item digestItem) string {
+	if item.ConversationID != nil {
+		if item.ActorName != nil {
+			return fmt.Sprintf("%s with %s", directMessageLabel, *item.ActorName)
+		}
+		return directMessageLabel
+	}
+
+	label := "#unknown-channel"
+	if item.ChannelName != nil {
+		label = "#" + *item.ChannelName
+	}
+	if item.CommunityName != nil {
+		label += " in " + *item.CommunityName
+	}
+	return label
+}
+
+// groupPath is the client route, matching the notification service's routes
+func groupPath(item digestItem) string {
+	if item.ConversationID != nil {
+		return fmt.Sprintf("/dms/%s", item.ConversationID)
+	}
+	if item.CommunityID != nil {
+		return fmt.Sprintf("/communities/%s/channels/%s", item.CommunityID, item.ChannelID)
+	}
+	return ""
+}
+
+// digestVars shapes the groups for the notification_digest template
+func (s *Service) digestVars(name string, count int, groups []*digestGroup) map[string]any {
+	// A single conversation is answered with the reply button; several list
+	// their own reply addresses
+	marker := ""
+	if len(groups) == 1 && groups[0].ReplyTo != "" {
+		marker = replyMarker
+	}
+
+	views := make([]map[string]any, 0, len(groups))
+	for _, group := range groups {
+		items := make([]map[string]any, 0, len(group.Items))
+		for _, item := range group.Items {
+			var lines []string
+			if item.Body != nil && strings.TrimSpace(*item.Body) != "" {
+				lines = strings.Split(truncateRunes(*item.Body, maxItemBodyRunes), "\n")
+			}
+			items = append(items, map[string]any{
+				"Title": item.Title,
+				"Time":  item.CreatedAt.UTC().Format("Jan 2 15:04") + " UTC",
+				"Lines": lines,
+			})
+		}
+
+		view := map[string]any{
+			"Label":     group.Label,
+			"URL":       "",
+			"ReplyTo":   "",
+			"Remaining": group.Remaining,
+			"Items":     items,
+		}
+		if s.cfg.AppURL != "" && group.Path != "" {
+			view["URL"] = s.cfg.AppURL + group.Path
+		}
+		if len(groups) > 1 {
+			view["ReplyTo"] = group.ReplyTo
+		}
+		views = append(views, view)
+	}
+
+	return map[string]any{
+		"Name":        name,
+		"Count":       count,
+		"ReplyMarker": marker,
+		"Groups":      views,
+	}
+}
+
+func truncateRunes(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return string(runes[:limit]) + "…"
+}
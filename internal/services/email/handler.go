@@ -0,0 +1,135 @@
+package email
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes(secret string) chi.Router {
+	r := chi.NewRouter()
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(secret))
+		r.Get("/preferences", h.GetPreferences)
+		r.Patch("/preferences", h.UpdatePreferences)
+	})
+
+	// Called by the mail relay with the raw message; authenticated with EMAIL_INBOUND_TOKEN.
+	r.Post("/inbound", h.Inbound)
+
+	return r
+}
+
+// GetPreferences returns the user's email digest and reply settings
+func (h *Handler) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	prefs, err := h.service.GetPreferences(r.Context(), userID)
+	if err != nil {
+		respondError(w, err, "Failed to get email preferences")
+		return
+	}
+
+	utils.RespondSuccess(w, prefs)
+}
+
+// UpdatePreferences changes the user's email digest and reply settings
+func (h *Handler) UpdatePreferences(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req UpdatePreferencesRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	prefs, err := h.service.UpdatePreferences(r.Context(), userID, &req)
+	if err != nil {
+		respondError(w, err, "Failed to update email preferences")
+		return
+	}
+
+	utils.RespondSuccess(w, prefs)
+}
+
+// Inbound accepts a reply-by-email as a raw RFC 822 message
+func (h *Handler) Inbound(w http.ResponseWriter, r *http.Request) {
+	if !h.service.RepliesEnabled() {
+		utils.RespondError(w, http.StatusNotFound, "Not found")
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.service.cfg.InboundToken)) != 1 {
+		utils.RespondError(w, http.StatusUnauthorized, ErrInvalidInbound.Error())
+		return
+	}
+
+	bodyReader := http.MaxBytesReader(w, r.Body, MaxInboundBytes)
+	defer bodyReader.Close()
+
+	raw, err := io.ReadAll(bodyReader)
+	if err != nil {
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, "Email is too large")
+		return
+	}
+
+	reply, err := h.service.HandleInbound(r.Context(), raw)
+	if err != nil {
+		respondError(w, err, "Failed to process email")
+		return
+	}
+
+	utils.RespondCreated(w, reply)
+}
+
+// respondError maps errors to statuses. Inbound rejections use 4xx codes so
+// the relay bounces the email instead of retrying it.
+func respondError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrNotConfigured), errors.Is(err, ErrEmptyReply), errors.Is(err, ErrMalformedEmail):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrRepliesDisabled), errors.Is(err, ErrSenderMismatch):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrUnknownReplyToken), errors.Is(err, message.ErrInvalidReply):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrDuplicateReply):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrAutoReply):
+		// Accepted and dropped, so the relay doesn't bounce an auto-reply back
+		utils.RespondNoContent(w)
+	case errors.Is(err, message.ErrInsufficientPerms), errors.Is(err, dm.ErrNotParticipant), errors.Is(err, dm.ErrReadOnly):
+		utils.RespondError(w, http.StatusForbidden, "Not allowed to post in this conversation")
+	case errors.Is(err, message.ErrBlockedByAutoMod), errors.Is(err, message.ErrRemovedByAutoMod),
+		errors.Is(err, message.ErrVerificationLevel):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, message.ErrSlowDown), errors.Is(err, message.ErrDuplicateMessage):
+		utils.RespondError(w, http.StatusTooManyRequests, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,31 @@
+package email
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zentra/server/internal/services/mailtemplate"
+)
+
+// SendRendered mails an already rendered template, for template test sends
+func (s *Service) SendRendered(ctx context.Context, to string, msg *mailtemplate.Rendered) error {
+	return s.send(mailtemplate.Mail{To: to, Message: msg, AutoGenerated: true})
+}
+
+func (s *Service) send(msg mailtemplate.Mail) error {
+	err := mailtemplate.SendSMTP(s.smtpConfig(), msg)
+	if errors.Is(err, mailtemplate.ErrSMTPNotConfigured) {
+		return ErrNotConfigured
+	}
+	return err
+}
+
+func (s *Service) smtpConfig() mailtemplate.SMTPConfig {
+	return mailtemplate.SMTPConfig{
+		Host:        s.cfg.SMTPHost,
+		Port:        s.cfg.SMTPPort,
+		Username:    s.cfg.SMTPUsername,
+		Password:    s.cfg.SMTPPassword,
+		FromAddress: s.cfg.FromAddress,
+	}
+}
@@ -0,0 +1,26 @@
+package email
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	openapi.Describe((*Handler).GetPreferences, openapi.Operation{
+		Summary:  "Get the current user's email digest and reply settings",
+		Response: Preferences{},
+	})
+	openapi.Describe((*Handler).UpdatePreferences, openapi.Operation{
+		Summary:  "Update the current user's email digest and reply settings",
+		Body:     UpdatePreferencesRequest{},
+		Response: Preferences{},
+	})
+	openapi.Describe((*Handler).Inbound, openapi.Operation{
+		Summary:     "Post a reply-by-email",
+		Description: "Called by the mail relay with the raw RFC 822 message, with EMAIL_INBOUND_TOKEN as a bearer token. Rejections are 4xx so the relay bounces the email; auto-replies are dropped with a 204.",
+		BodyType:    "message/rfc822",
+		Response:    Reply{},
+		Status:      http.StatusCreated,
+	})
+}
@@ -0,0 +1,342 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/message"
+)
+
+const (
+	// MaxInboundBytes caps the raw email accepted by the inbound endpoint
+	MaxInboundBytes = 5 * 1024 * 1024
+
+	replyPrefix     = "reply+"
+	maxReplyRunes   = 4000
+	maxMimeDepth    = 5
+	replySigBytes   = 8
+	replyTokenBytes = 16
+)
+
+// "On Mon, Jan 2, 2026 at 10:00 AM Someone <x@y> wrote:"
+var attributionLine = regexp.MustCompile(`^On .+ wrote:$`)
+
+// Reply is where an accepted email was posted
+type Reply struct {
+	ChannelID      *uuid.UUID `json:"channelId,omitempty"`
+	ConversationID *uuid.UUID `json:"conversationId,omitempty"`
+	MessageID      uuid.UUID  `json:"messageId"`
+}
+
+// replyToken is an email_reply_tokens row
+type replyToken struct {
+	ID             uuid.UUID
+	UserID         uuid.UUID
+	ChannelID      *uuid.UUID
+	ConversationID *uuid.UUID
+	ReplyToID      *uuid.UUID
+	Email          string
+	ReplyEnabled   bool
+}
+
+// issueReplyAddress stores a reply token for the item's conversation and
+// returns reply+<id>-<signature>@ReplyDomain. The ID is looked up on the way
+// back in; the signature stops anyone forging or guessing addresses.
+func (s *Service) issueReplyAddress(ctx context.Context, userID uuid.UUID, item digestItem) (string, error) {
+	var channelID *uuid.UUID
+	if item.ConversationID == nil {
+		channelID = item.ChannelID
+	}
+
+	var id uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO email_reply_tokens (user_id, channel_id, conversation_id, reply_to_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`,
+		userID, channelID, item.ConversationID, item.MessageID, time.Now().Add(replyTokenTTL),
+	).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s%s-%s@%s", replyPrefix, hex.EncodeToString(id[:]), s.signReplyToken(id), s.cfg.ReplyDomain), nil
+}
+
+func (s *Service) signReplyToken(id uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(s.cfg.ReplySecret))
+	mac.Write(id[:])
+	return hex.EncodeToString(mac.Sum(nil)[:replySigBytes])
+}
+
+// parseReplyAddress returns the token ID from a signed reply address on the
+// reply domain
+func (s *Service) parseReplyAddress(address string) (uuid.UUID, bool) {
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !strings.EqualFold(address[at+1:], s.cfg.ReplyDomain) {
+		return uuid.Nil, false
+	}
+
+	local := strings.ToLower(address[:at])
+	if !strings.HasPrefix(local, replyPrefix) {
+		return uuid.Nil, false
+	}
+	rawID, sig, ok := strings.Cut(strings.TrimPrefix(local, replyPrefix), "-")
+	if !ok || len(rawID) != hex.EncodedLen(replyTokenBytes) {
+		return uuid.Nil, false
+	}
+
+	decoded, err := hex.DecodeString(rawID)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	id, err := uuid.FromBytes(decoded)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signReplyToken(id))) {
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// HandleInbound posts a reply-by-email into the conversation it answers. raw
+// is the full RFC 822 message as received by the mail relay. The From address
+// is trusted, so the relay must reject mail that fails SPF/DKIM/DMARC.
+func (s *Service) HandleInbound(ctx context.Context, raw []byte) (*Reply, error) {
+	if !s.RepliesEnabled() {
+		return nil, ErrRepliesDisabled
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, ErrMalformedEmail
+	}
+	if isAutomated(msg.Header) {
+		return nil, ErrAutoReply
+	}
+
+	tokenID, ok := s.findReplyToken(msg.Header)
+	if !ok {
+		return nil, ErrUnknownReplyToken
+	}
+	token, err := s.getReplyToken(ctx, tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if !token.ReplyEnabled {
+		return nil, ErrRepliesDisabled
+	}
+
+	from, err := mail.ParseAddress(msg.Header.Get("From"))
+	if err != nil || !strings.EqualFold(from.Address, token.Email) {
+		return nil, ErrSenderMismatch
+	}
+
+	text, err := textBody(messageHeader(msg.Header), msg.Body, 0)
+	if err != nil {
+		return nil, err
+	}
+	content := truncateRunes(stripQuoted(text), maxReplyRunes)
+	if content == "" {
+		return nil, ErrEmptyReply
+	}
+
+	messageID := strings.TrimSpace(msg.Header.Get("Message-Id"))
+	if messageID == "" {
+		sum := sha256.Sum256(raw)
+		messageID = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	tag, err := s.db.Exec(ctx,
+		`INSERT INTO email_inbound_messages (message_id, token_id) VALUES ($1, $2)
+		ON CONFLICT (message_id) DO NOTHING`,
+		messageID, token.ID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrDuplicateReply
+	}
+
+	reply, err := s.postReply(ctx, token, content)
+	if err != nil {
+		// Let the relay retry once whatever refused the post is resolved
+		if _, delErr := s.db.Exec(ctx, `DELETE FROM email_inbound_messages WHERE message_id = $1`, messageID); delErr != nil {
+			return nil, delErr
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (s *Service) postReply(ctx context.Context, token *replyToken, content string) (*Reply, error) {
+	if token.ConversationID != nil {
+		msg, err := s.dms.SendMessage(ctx, *token.ConversationID, token.UserID, &dm.SendMessageRequest{
+			Content:   content,
+			ReplyToID: token.ReplyToID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &Reply{ConversationID: token.ConversationID, MessageID: msg.ID}, nil
+	}
+
+	msg, err := s.messages.CreateMessage(ctx, *token.ChannelID, token.UserID, &message.CreateMessageRequest{
+		Content:   content,
+		ReplyToID: token.ReplyToID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Reply{ChannelID: token.ChannelID, MessageID: msg.ID}, nil
+}
+
+// findReplyToken looks for a reply address among the envelope and header
+// recipients. Relays that rewrite To usually keep the original in one of the
+// delivery headers.
+func (s *Service) findReplyToken(header mail.Header) (uuid.UUID, bool) {
+	for _, key := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		addresses, err := header.AddressList(key)
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if id, ok := s.parseReplyAddress(address.Address); ok {
+				return id, true
+			}
+		}
+	}
+	return uuid.Nil, false
+}
+
+func (s *Service) getReplyToken(ctx context.Context, id uuid.UUID) (*replyToken, error) {
+	t := &replyToken{ID: id}
+	err := s.db.QueryRow(ctx,
+		`SELECT t.user_id, t.channel_id, t.conversation_id, t.reply_to_id, u.email, COALESCE(ep.reply_enabled, TRUE)
+		FROM email_reply_tokens t
+		JOIN users u ON u.id = t.user_id
+		LEFT JOIN email_preferences ep ON ep.user_id = t.user_id
+		WHERE t.id = $1 AND t.expires_at > NOW()`,
+		id,
+	).Scan(&t.UserID, &t.ChannelID, &t.ConversationID, &t.ReplyToID, &t.Email, &t.ReplyEnabled)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUnknownReplyToken
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// isAutomated spots vacation responders and bounces so they don't get posted
+func isAutomated(header mail.Header) bool {
+	if v := strings.ToLower(strings.TrimSpace(header.Get("Auto-Submitted"))); v != "" && v != "no" {
+		return true
+	}
+	switch strings.ToLower(strings.TrimSpace(header.Get("Precedence"))) {
+	case "bulk", "junk", "list", "auto_reply":
+		return true
+	}
+	return header.Get("X-Autoreply") != "" || header.Get("X-Autorespond") != ""
+}
+
+// partHeader is the subset of headers textBody needs from a message or a part
+type partHeader struct {
+	ContentType string
+	Encoding    string
+}
+
+func messageHeader(header mail.Header) partHeader {
+	return partHeader{
+		ContentType: header.Get("Content-Type"),
+		Encoding:    header.Get("Content-Transfer-Encoding"),
+	}
+}
+
+// textBody returns the first text/plain part, decoded
+func textBody(header partHeader, body io.Reader, depth int) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(header.ContentType)
+	if err != nil {
+		// No or broken Content-Type means plain text per RFC 2045
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if depth >= maxMimeDepth || params["boundary"] == "" {
+			return "", ErrMalformedEmail
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return "", ErrEmptyReply
+			}
+			if err != nil {
+				return "", ErrMalformedEmail
+			}
+			if strings.HasPrefix(strings.ToLower(part.Header.Get("Content-Disposition")), "attachment") {
+				continue
+			}
+			text, err := textBody(partHeader{
+				ContentType: part.Header.Get("Content-Type"),
+				Encoding:    part.Header.Get("Content-Transfer-Encoding"),
+			}, part, depth+1)
+			if err == nil {
+				return text, nil
+			}
+			if !errors.Is(err, ErrEmptyReply) {
+				return "", err
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", ErrEmptyReply
+	}
+
+	switch strings.ToLower(strings.TrimSpace(header.Encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	decoded, err := io.ReadAll(io.LimitReader(body, MaxInboundBytes))
+	if err != nil {
+		return "", ErrMalformedEmail
+	}
+	return strings.ReplaceAll(string(decoded), "\r\n", "\n"), nil
+}
+
+// stripQuoted keeps what the user wrote above the quoted digest and their signature
+func stripQuoted(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, ">") ||
+			trimmed == replyMarker ||
+			line == "-- " || trimmed == "--" ||
+			strings.HasPrefix(trimmed, "-----Original Message-----") ||
+			attributionLine.MatchString(trimmed) {
+			break
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
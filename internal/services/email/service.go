@@ -0,0 +1,173 @@
+package email
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/mailtemplate"
+	"github.com/zentra/server/internal/services/message"
+)
+
+var (
+	ErrNotConfigured     = errors.New("email is not configured on this server")
+	ErrRepliesDisabled   = errors.New("reply by email is not enabled on this server")
+	ErrInvalidInbound    = errors.New("invalid inbound token")
+	ErrMalformedEmail    = errors.New("the email could not be parsed")
+	ErrUnknownReplyToken = errors.New("the reply address is invalid or has expired")
+	ErrSenderMismatch    = errors.New("the reply was not sent from the account's email address")
+	ErrEmptyReply        = errors.New("the reply has no text")
+	ErrDuplicateReply    = errors.New("the reply was already delivered")
+	ErrAutoReply         = errors.New("automatic replies are ignored")
+)
+
+// Config is the SMTP and reply-by-email setup
+type Config struct {
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	FromAddress  string
+	// AppURL is the web client, used for links in digests
+	AppURL string
+	// ReplyDomain receives reply+<token>@ReplyDomain and forwards it to the inbound endpoint
+	ReplyDomain  string
+	ReplySecret  string
+	InboundToken string
+}
+
+// PresenceChecker reports whether a user has a live connection
+type PresenceChecker interface {
+	IsOnline(ctx context.Context, userID uuid.UUID) bool
+}
+
+// MessagePoster posts channel messages as a user, with the usual checks
+type MessagePoster interface {
+	CreateMessage(ctx context.Context, channelID, userID uuid.UUID, req *message.CreateMessageRequest) (*message.MessageResponse, error)
+}
+
+// DMSender posts direct messages as a user, with the usual checks
+type DMSender interface {
+	SendMessage(ctx context.Context, conversationID, userID uuid.UUID, req *dm.SendMessageRequest) (*dm.DMMessageResponse, error)
+}
+
+type Service struct {
+	db        *pgxpool.Pool
+	cfg       Config
+	templates *mailtemplate.Service
+	presence  PresenceChecker
+	messages  MessagePoster
+	dms       DMSender
+}
+
+func NewService(db *pgxpool.Pool, cfg Config, templates *mailtemplate.Service, presence PresenceChecker, messages MessagePoster, dms DMSender) *Service {
+	return &Service{
+		db:        db,
+		cfg:       cfg,
+		templates: templates,
+		presence:  presence,
+		messages:  messages,
+		dms:       dms,
+	}
+}
+
+// Preferences controls which emails a user gets
+type Preferences struct {
+	DigestEnabled         bool       `json:"digestEnabled"`
+	DigestIntervalMinutes int        `json:"digestIntervalMinutes"`
+	ReplyEnabled          bool       `json:"replyEnabled"`
+	LastDigestAt          *time.Time `json:"lastDigestAt,omitempty"`
+	// Whether the server can send mail and accept replies at all
+	Available        bool `json:"available"`
+	RepliesAvailable bool `json:"repliesAvailable"`
+}
+
+// UpdatePreferencesRequest changes email preferences. Omitted fields are kept.
+type UpdatePreferencesRequest struct {
+	DigestEnabled         *bool `json:"digestEnabled"`
+	DigestIntervalMinutes *int  `json:"digestIntervalMinutes" validate:"omitempty,min=15,max=1440"`
+	ReplyEnabled          *bool `json:"replyEnabled"`
+}
+
+// Enabled reports whether outgoing mail is configured
+func (s *Service) Enabled() bool {
+	return s.smtpConfig().Enabled()
+}
+
+// RepliesEnabled reports whether reply-by-email is configured
+func (s *Service) RepliesEnabled() bool {
+	return s.Enabled() && s.cfg.ReplyDomain != "" && s.cfg.ReplySecret != "" && s.cfg.InboundToken != ""
+}
+
+// GetPreferences returns the user's email preferences, with defaults when unset
+func (s *Service) GetPreferences(ctx context.Context, userID uuid.UUID) (*Preferences, error) {
+	prefs := &Preferences{DigestIntervalMinutes: 60, ReplyEnabled: true}
+	err := s.db.QueryRow(ctx,
+		`SELECT digest_enabled, digest_interval_minutes, reply_enabled, last_digest_at
+		FROM email_preferences WHERE user_id = $1`,
+		userID,
+	).Scan(&prefs.DigestEnabled, &prefs.DigestIntervalMinutes, &prefs.ReplyEnabled, &prefs.LastDigestAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	prefs.Available = s.Enabled()
+	prefs.RepliesAvailable = s.RepliesEnabled()
+	return prefs, nil
+}
+
+// UpdatePreferences changes the user's email preferences
+func (s *Service) UpdatePreferences(ctx context.Context, userID uuid.UUID, req *UpdatePreferencesRequest) (*Preferences, error) {
+	prefs, err := s.GetPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if req.DigestEnabled != nil {
+		if *req.DigestEnabled && !prefs.Available {
+			return nil, ErrNotConfigured
+		}
+		prefs.DigestEnabled = *req.DigestEnabled
+	}
+	if req.DigestIntervalMinutes != nil {
+		prefs.DigestIntervalMinutes = *req.DigestIntervalMinutes
+	}
+	if req.ReplyEnabled != nil {
+		prefs.ReplyEnabled = *req.ReplyEnabled
+	}
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO email_preferences (user_id, digest_enabled, digest_interval_minutes, reply_enabled, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			digest_enabled = EXCLUDED.digest_enabled,
+			digest_interval_minutes = EXCLUDED.digest_interval_minutes,
+			reply_enabled = EXCLUDED.reply_enabled,
+			updated_at = EXCLUDED.updated_at`,
+		userID, prefs.DigestEnabled, prefs.DigestIntervalMinutes, prefs.ReplyEnabled,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// PruneReplies forgets expired reply addresses and old inbound Message-IDs
+func (s *Service) PruneReplies(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM email_reply_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	removed := tag.RowsAffected()
+
+	tag, err = s.db.Exec(ctx,
+		`DELETE FROM email_inbound_messages WHERE created_at < $1`,
+		time.Now().Add(-inboundRetention),
+	)
+	if err != nil {
+		return removed, err
+	}
+	return removed + tag.RowsAffected(), nil
+}
@@ -0,0 +1,91 @@
+package emoji
+
+import "encoding/binary"
+
+// isAnimated reports whether a GIF or WebP has more than one frame
+func isAnimated(data []byte, contentType string) bool {
+	switch contentType {
+	case "image/gif":
+		return countGIFFrames(data, 2) > 1
+	case "image/webp":
+		return isAnimatedWebP(data)
+	}
+	return false
+}
+
+// countGIFFrames counts a GIF's frames, stopping at limit. It walks the block
+// structure without decompressing any frame, so a small file claiming
+// thousands of huge frames costs nothing to check.
+func countGIFFrames(data []byte, limit int) int {
+	// Header and logical screen descriptor, then the global colour table
+	if len(data) < 13 || string(data[0:3]) != "GIF" {
+		return 0
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	frames := 0
+	for pos < len(data) && frames < limit {
+		switch data[pos] {
+		case 0x21: // extension: a label, then sub-blocks
+			pos = skipGIFSubBlocks(data, pos+2)
+		case 0x2C: // image descriptor, local colour table, LZW code size, data
+			if pos+10 > len(data) {
+				return frames
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos = skipGIFSubBlocks(data, pos+1)
+			frames++
+		default: // the trailer, or something that isn't a GIF block
+			return frames
+		}
+	}
+	return frames
+}
+
+// skipGIFSubBlocks returns the position after the sub-blocks starting at pos
+func skipGIFSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos
+		}
+		pos += size
+	}
+	return len(data)
+}
+
+// isAnimatedWebP checks the extended header's animation flag, falling back to
+// looking for an ANIM chunk
+func isAnimatedWebP(data []byte) bool {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return false
+	}
+
+	for pos := 12; pos+8 <= len(data); {
+		fourCC := string(data[pos : pos+4])
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		switch fourCC {
+		case "VP8X":
+			if pos+9 <= len(data) && data[pos+8]&0x02 != 0 {
+				return true
+			}
+		case "ANIM", "ANMF":
+			return true
+		}
+		// Chunks are padded to an even size
+		next := pos + 8 + size + size%2
+		if size < 0 || next <= pos {
+			break
+		}
+		pos = next
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package emoji
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -23,6 +24,9 @@ func (h *Handler) Routes() chi.Router {
 	// Get all custom emojis the user can access (across all their communities)
 	r.Get("/", h.GetAccessibleEmojis)
 
+	// Autocomplete across all the user's communities
+	r.Get("/search", h.SearchEmojis)
+
 	// Resolve a single emoji by ID (for rendering in messages)
 	r.Get("/{id}", h.GetEmoji)
 
@@ -30,6 +34,8 @@ func (h *Handler) Routes() chi.Router {
 	r.Route("/communities/{communityId}", func(r chi.Router) {
 		r.Get("/", h.GetCommunityEmojis)
 		r.Post("/", h.CreateEmoji)
+		r.Get("/search", h.SearchCommunityEmojis)
+		r.Get("/usage", h.GetEmojiUsage)
 	})
 
 	// Single emoji operations
@@ -39,7 +45,8 @@ func (h *Handler) Routes() chi.Router {
 	return r
 }
 
-// GetAccessibleEmojis returns every custom emoji the user can use (from all their communities)
+// GetAccessibleEmojis returns every custom emoji the user can use (from all their communities).
+// Pass ?communityId= when the picker is open in a community so its internal-only emojis are included.
 func (h *Handler) GetAccessibleEmojis(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -47,7 +54,12 @@ func (h *Handler) GetAccessibleEmojis(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	emojis, err := h.service.GetAllAccessibleEmojis(r.Context(), userID)
+	contextCommunityID, ok := parseContextCommunity(w, r)
+	if !ok {
+		return
+	}
+
+	emojis, err := h.service.GetAllAccessibleEmojis(r.Context(), userID, contextCommunityID)
 	if err != nil {
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch emojis")
 		return
@@ -56,6 +68,69 @@ func (h *Handler) GetAccessibleEmojis(w http.ResponseWriter, r *http.Request) {
 	utils.RespondSuccess(w, emojis)
 }
 
+// SearchEmojis autocompletes emoji names from every community the user is in
+func (h *Handler) SearchEmojis(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	contextCommunityID, ok := parseContextCommunity(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if len(query) > 64 {
+		utils.RespondError(w, http.StatusBadRequest, "Query too long")
+		return
+	}
+	limit := utils.GetQueryInt(r, "limit", DefaultSearchResults)
+
+	emojis, err := h.service.SearchEmojis(r.Context(), userID, query, contextCommunityID, false, limit)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to search emojis")
+		return
+	}
+
+	utils.RespondSuccess(w, emojis)
+}
+
+// SearchCommunityEmojis autocompletes emoji names within a single community
+func (h *Handler) SearchCommunityEmojis(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if len(query) > 64 {
+		utils.RespondError(w, http.StatusBadRequest, "Query too long")
+		return
+	}
+	limit := utils.GetQueryInt(r, "limit", DefaultSearchResults)
+
+	emojis, err := h.service.SearchEmojis(r.Context(), userID, query, &communityID, true, limit)
+	if err != nil {
+		if err == ErrNotMember {
+			utils.RespondError(w, http.StatusForbidden, "Not a member of this community")
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to search emojis")
+		return
+	}
+
+	utils.RespondSuccess(w, emojis)
+}
+
 // GetEmoji resolves a single emoji by ID
 func (h *Handler) GetEmoji(w http.ResponseWriter, r *http.Request) {
 	emojiID, err := uuid.Parse(chi.URLParam(r, "id"))
@@ -138,16 +213,22 @@ func (h *Handler) CreateEmoji(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	emoji, err := h.service.CreateEmoji(r.Context(), communityID, userID, name, file, header)
+	// Aliases come as one comma-separated field
+	var aliases []string
+	if raw := r.FormValue("aliases"); raw != "" {
+		aliases = strings.Split(raw, ",")
+	}
+
+	emoji, err := h.service.CreateEmoji(r.Context(), communityID, userID, name, aliases, file, header)
 	if err != nil {
 		switch err {
-		case ErrInvalidName:
+		case ErrInvalidName, ErrInvalidAlias, ErrTooManyAliases:
 			utils.RespondError(w, http.StatusBadRequest, err.Error())
 		case ErrNameTaken:
 			utils.RespondError(w, http.StatusConflict, err.Error())
 		case ErrInvalidImage:
 			utils.RespondError(w, http.StatusBadRequest, err.Error())
-		case ErrImageTooLarge:
+		case ErrImageTooLarge, ErrTooManyPixels:
 			utils.RespondError(w, http.StatusBadRequest, err.Error())
 		case ErrTooManyEmojis:
 			utils.RespondError(w, http.StatusBadRequest, err.Error())
@@ -164,7 +245,16 @@ func (h *Handler) CreateEmoji(w http.ResponseWriter, r *http.Request) {
 	utils.RespondCreated(w, emoji)
 }
 
-// UpdateEmoji renames an existing emoji
+// UpdateEmojiRequest is the body of PATCH /emojis/{id}; fields left out are
+// kept
+type UpdateEmojiRequest struct {
+	Name          string    `json:"name"`
+	Aliases       *[]string `json:"aliases"`
+	AllowExternal *bool     `json:"allowExternal"`
+}
+
+// UpdateEmoji renames an emoji, replaces its aliases or toggles whether other
+// communities can use it
 func (h *Handler) UpdateEmoji(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -178,20 +268,17 @@ func (h *Handler) UpdateEmoji(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Name string `json:"name"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req UpdateEmojiRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
-	emoji, err := h.service.UpdateEmoji(r.Context(), emojiID, userID, req.Name)
+	emoji, err := h.service.UpdateEmoji(r.Context(), emojiID, userID, req.Name, req.Aliases, req.AllowExternal)
 	if err != nil {
 		switch err {
 		case ErrEmojiNotFound:
 			utils.RespondError(w, http.StatusNotFound, "Emoji not found")
-		case ErrInvalidName:
+		case ErrInvalidName, ErrInvalidAlias, ErrTooManyAliases:
 			utils.RespondError(w, http.StatusBadRequest, err.Error())
 		case ErrNameTaken:
 			utils.RespondError(w, http.StatusConflict, err.Error())
@@ -208,6 +295,37 @@ func (h *Handler) UpdateEmoji(w http.ResponseWriter, r *http.Request) {
 	utils.RespondSuccess(w, emoji)
 }
 
+// GetEmojiUsage lists a community's emojis by how often they're used, least used
+// first, for pruning
+func (h *Handler) GetEmojiUsage(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	usage, err := h.service.GetUsage(r.Context(), communityID, userID)
+	if err != nil {
+		switch err {
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "You don't have permission to manage emojis")
+		case ErrNotMember:
+			utils.RespondError(w, http.StatusForbidden, "Not a member of this community")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch emoji usage")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, usage)
+}
+
 // DeleteEmoji removes a custom emoji
 func (h *Handler) DeleteEmoji(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
@@ -238,3 +356,18 @@ func (h *Handler) DeleteEmoji(w http.ResponseWriter, r *http.Request) {
 
 	utils.RespondNoContent(w)
 }
+
+// parseContextCommunity reads the optional ?communityId= the picker is open in.
+// It writes the error response itself and returns false on a bad ID.
+func parseContextCommunity(w http.ResponseWriter, r *http.Request) (*uuid.UUID, bool) {
+	raw := r.URL.Query().Get("communityId")
+	if raw == "" {
+		return nil, true
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return nil, false
+	}
+	return &id, true
+}
@@ -0,0 +1,73 @@
+package emoji
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	contextCommunity := openapi.Param{Name: "communityId", Format: "uuid", Description: "Community the picker is open in, to include its emojis that only it can use"}
+	search := []openapi.Param{
+		{Name: "q", Description: "Name or alias prefix, at most 64 characters"},
+		{Name: "limit", Type: "integer", Description: "At most this many, 25 by default and up to 50"},
+	}
+
+	openapi.Describe((*Handler).GetAccessibleEmojis, openapi.Operation{
+		Summary:  "List every custom emoji the current user can use",
+		Query:    []openapi.Param{contextCommunity},
+		Response: []models.CustomEmojiWithCommunity{},
+	})
+	openapi.Describe((*Handler).SearchEmojis, openapi.Operation{
+		Summary:  "Autocomplete custom emojis across the current user's communities",
+		Query:    append([]openapi.Param{contextCommunity}, search...),
+		Response: []models.CustomEmojiWithCommunity{},
+	})
+	openapi.Describe((*Handler).GetEmoji, openapi.Operation{
+		Summary:  "Get a custom emoji, to render it in a message",
+		Path:     id,
+		Response: models.CustomEmoji{},
+	})
+	openapi.Describe((*Handler).UpdateEmoji, openapi.Operation{
+		Summary:  "Rename an emoji, replace its aliases or let other communities use it",
+		Path:     id,
+		Body:     UpdateEmojiRequest{},
+		Response: models.CustomEmoji{},
+	})
+	openapi.Describe((*Handler).DeleteEmoji, openapi.Operation{
+		Summary: "Delete a custom emoji",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetCommunityEmojis, openapi.Operation{
+		Summary:  "List a community's custom emojis",
+		Path:     communityID,
+		Response: []models.CustomEmoji{},
+	})
+	openapi.Describe((*Handler).CreateEmoji, openapi.Operation{
+		Summary: "Upload a custom emoji",
+		Path:    communityID,
+		Upload:  "image",
+		Form: []openapi.Param{
+			{Name: "name", Required: true},
+			{Name: "aliases", Description: "Comma-separated"},
+		},
+		Response: models.CustomEmoji{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).SearchCommunityEmojis, openapi.Operation{
+		Summary:  "Autocomplete a community's custom emojis",
+		Path:     communityID,
+		Query:    search,
+		Response: []models.CustomEmojiWithCommunity{},
+	})
+	openapi.Describe((*Handler).GetEmojiUsage, openapi.Operation{
+		Summary:  "List a community's emojis by use, least used first",
+		Path:     communityID,
+		Response: []models.CustomEmojiUsage{},
+	})
+}
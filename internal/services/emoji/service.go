@@ -11,17 +11,22 @@ import (
 	"image/png"
 	"io"
 	"mime/multipart"
+	"net/http"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/minio/minio-go/v7"
 	"github.com/nfnt/resize"
+	"github.com/redis/go-redis/v9"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
 )
 
 var (
@@ -30,18 +35,29 @@ var (
 	ErrInvalidName       = errors.New("emoji name must be 2-32 alphanumeric or underscore characters")
 	ErrInvalidImage      = errors.New("emoji must be a PNG, JPEG, GIF, or WebP image")
 	ErrImageTooLarge     = errors.New("emoji image must be under 256KB")
+	ErrTooManyPixels     = errors.New("emoji image must be at most 2048x2048 pixels")
 	ErrTooManyEmojis     = errors.New("community has reached the emoji limit")
 	ErrInsufficientPerms = errors.New("insufficient permissions")
 	ErrNotMember         = errors.New("user is not a member of this community")
+	ErrInvalidAlias      = errors.New("emoji aliases must be 2-32 alphanumeric or underscore characters")
+	ErrTooManyAliases    = errors.New("an emoji can have at most 10 aliases")
 )
 
 const (
 	MaxEmojiSize          = 256 * 1024 // 256KB
 	MaxEmojisPerCommunity = 200
 	MaxEmojiDimension     = 128
+	// Larger sources are refused before anything decodes them
+	MaxEmojiSourcePixels = 2048 * 2048
+	MaxAliasesPerEmoji   = 10
+	DefaultSearchResults = 25
+	MaxSearchResults     = 50
 )
 
-var emojiNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_]{2,32}$`)
+var (
+	emojiNameRegex      = regexp.MustCompile(`^[a-zA-Z0-9_]{2,32}$`)
+	emojiQuerySanitizer = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
 
 var allowedEmojiTypes = map[string]bool{
 	"image/png":  true,
@@ -58,15 +74,17 @@ type CommunityServiceInterface interface {
 
 type Service struct {
 	db               *pgxpool.Pool
+	redis            *redis.Client
 	minio            *minio.Client
 	bucketCommunity  string
 	cdnBaseURL       string
 	communityService CommunityServiceInterface
 }
 
-func NewService(db *pgxpool.Pool, minioClient *minio.Client, bucketCommunity, cdnBaseURL string, communityService CommunityServiceInterface) *Service {
+func NewService(db *pgxpool.Pool, redisClient *redis.Client, minioClient *minio.Client, bucketCommunity, cdnBaseURL string, communityService CommunityServiceInterface) *Service {
 	return &Service{
 		db:               db,
+		redis:            redisClient,
 		minio:            minioClient,
 		bucketCommunity:  bucketCommunity,
 		cdnBaseURL:       cdnBaseURL,
@@ -75,7 +93,7 @@ func NewService(db *pgxpool.Pool, minioClient *minio.Client, bucketCommunity, cd
 }
 
 // CreateEmoji uploads a custom emoji image and stores the record
-func (s *Service) CreateEmoji(ctx context.Context, communityID, uploaderID uuid.UUID, name string, file multipart.File, header *multipart.FileHeader) (*models.CustomEmoji, error) {
+func (s *Service) CreateEmoji(ctx context.Context, communityID, uploaderID uuid.UUID, name string, aliases []string, file multipart.File, header *multipart.FileHeader) (*models.CustomEmoji, error) {
 	// Check permissions
 	if err := s.requireManageEmojis(ctx, communityID, uploaderID); err != nil {
 		return nil, err
@@ -86,10 +104,14 @@ func (s *Service) CreateEmoji(ctx context.Context, communityID, uploaderID uuid.
 	if !emojiNameRegex.MatchString(name) {
 		return nil, ErrInvalidName
 	}
+	aliases, err := normalizeAliases(name, aliases)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check community emoji count
 	var count int
-	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM custom_emojis WHERE community_id = $1`, communityID).Scan(&count)
+	err = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM custom_emojis WHERE community_id = $1`, communityID).Scan(&count)
 	if err != nil {
 		return nil, fmt.Errorf("failed to count emojis: %w", err)
 	}
@@ -97,38 +119,37 @@ func (s *Service) CreateEmoji(ctx context.Context, communityID, uploaderID uuid.
 		return nil, ErrTooManyEmojis
 	}
 
-	// Check for duplicate name within community
-	var exists bool
-	err = s.db.QueryRow(ctx,
-		`SELECT EXISTS(SELECT 1 FROM custom_emojis WHERE community_id = $1 AND LOWER(name) = LOWER($2))`,
-		communityID, name,
-	).Scan(&exists)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check emoji name: %w", err)
-	}
-	if exists {
-		return nil, ErrNameTaken
+	// Check for duplicate names and aliases within community
+	if err := s.checkNamesFree(ctx, communityID, uuid.Nil, name, aliases); err != nil {
+		return nil, err
 	}
 
-	// Validate image type and size
-	contentType := header.Header.Get("Content-Type")
-	if !allowedEmojiTypes[contentType] {
-		return nil, ErrInvalidImage
-	}
+	// Validate image size and type. The type is sniffed from the bytes rather
+	// than trusted from the upload.
 	if header.Size > MaxEmojiSize {
 		return nil, ErrImageTooLarge
 	}
 
-	fileData, err := io.ReadAll(file)
+	fileData, err := io.ReadAll(io.LimitReader(file, MaxEmojiSize+1))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	if len(fileData) > MaxEmojiSize {
+		return nil, ErrImageTooLarge
+	}
+	contentType := http.DetectContentType(fileData)
+	if !allowedEmojiTypes[contentType] {
+		return nil, ErrInvalidImage
+	}
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(fileData)); err == nil && cfg.Width*cfg.Height > MaxEmojiSourcePixels {
+		return nil, ErrTooManyPixels
+	}
 
-	animated := contentType == "image/gif"
+	animated := isAnimated(fileData, contentType)
 	emojiID := uuid.New()
 
 	// Compress and resize the emoji to save space
-	processedData, processedType, ext := s.processEmojiImage(fileData, contentType, header.Filename)
+	processedData, processedType, ext := s.processEmojiImage(fileData, contentType, animated, header.Filename)
 
 	objectName := fmt.Sprintf("emojis/%s/%s%s", communityID.String(), emojiID.String(), ext)
 
@@ -141,32 +162,45 @@ func (s *Service) CreateEmoji(ctx context.Context, communityID, uploaderID uuid.
 	imageURL := fmt.Sprintf("%s/%s/%s", s.cdnBaseURL, s.bucketCommunity, objectName)
 
 	emoji := &models.CustomEmoji{
-		ID:          emojiID,
-		CommunityID: communityID,
-		Name:        name,
-		ImageURL:    imageURL,
-		UploaderID:  uploaderID,
-		Animated:    animated,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-	}
-
-	_, err = s.db.Exec(ctx,
-		`INSERT INTO custom_emojis (id, community_id, name, image_url, uploader_id, animated, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		emoji.ID, emoji.CommunityID, emoji.Name, emoji.ImageURL, emoji.UploaderID, emoji.Animated, emoji.CreatedAt, emoji.UpdatedAt,
-	)
+		ID:            emojiID,
+		CommunityID:   communityID,
+		Name:          name,
+		ImageURL:      imageURL,
+		UploaderID:    uploaderID,
+		Animated:      animated,
+		AllowExternal: true,
+		Aliases:       aliases,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO custom_emojis (id, community_id, name, image_url, uploader_id, animated, allow_external, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			emoji.ID, emoji.CommunityID, emoji.Name, emoji.ImageURL, emoji.UploaderID, emoji.Animated, emoji.AllowExternal, emoji.CreatedAt, emoji.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return replaceAliases(ctx, tx, emoji.ID, emoji.CommunityID, aliases)
+	})
 	if err != nil {
 		// Clean up the uploaded file if the DB insert fails
 		_ = s.minio.RemoveObject(ctx, s.bucketCommunity, objectName, minio.RemoveObjectOptions{})
+		if isUniqueViolation(err) {
+			return nil, ErrNameTaken
+		}
 		return nil, fmt.Errorf("failed to save emoji: %w", err)
 	}
 
 	return emoji, nil
 }
 
-// UpdateEmoji renames an existing emoji
-func (s *Service) UpdateEmoji(ctx context.Context, emojiID, userID uuid.UUID, newName string) (*models.CustomEmoji, error) {
+// UpdateEmoji renames an emoji, replaces its aliases and/or changes whether it
+// can be used outside its community. An empty name and nil aliases keep the
+// current ones.
+func (s *Service) UpdateEmoji(ctx context.Context, emojiID, userID uuid.UUID, newName string, aliases *[]string, allowExternal *bool) (*models.CustomEmoji, error) {
 	emoji, err := s.getEmoji(ctx, emojiID)
 	if err != nil {
 		return nil, err
@@ -177,32 +211,49 @@ func (s *Service) UpdateEmoji(ctx context.Context, emojiID, userID uuid.UUID, ne
 	}
 
 	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		newName = emoji.Name
+	}
 	if !emojiNameRegex.MatchString(newName) {
 		return nil, ErrInvalidName
 	}
-
-	// Check for duplicate name (excluding current emoji)
-	var exists bool
-	err = s.db.QueryRow(ctx,
-		`SELECT EXISTS(SELECT 1 FROM custom_emojis WHERE community_id = $1 AND LOWER(name) = LOWER($2) AND id != $3)`,
-		emoji.CommunityID, newName, emojiID,
-	).Scan(&exists)
+	if allowExternal == nil {
+		allowExternal = &emoji.AllowExternal
+	}
+	newAliases := emoji.Aliases
+	if aliases != nil {
+		newAliases = *aliases
+	}
+	newAliases, err = normalizeAliases(newName, newAliases)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check emoji name: %w", err)
+		return nil, err
 	}
-	if exists {
-		return nil, ErrNameTaken
+
+	// Check for duplicate names and aliases (excluding current emoji)
+	if err := s.checkNamesFree(ctx, emoji.CommunityID, emojiID, newName, newAliases); err != nil {
+		return nil, err
 	}
 
-	_, err = s.db.Exec(ctx,
-		`UPDATE custom_emojis SET name = $1, updated_at = NOW() WHERE id = $2`,
-		newName, emojiID,
-	)
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			`UPDATE custom_emojis SET name = $1, allow_external = $2, updated_at = NOW() WHERE id = $3`,
+			newName, *allowExternal, emojiID,
+		)
+		if err != nil {
+			return err
+		}
+		return replaceAliases(ctx, tx, emojiID, emoji.CommunityID, newAliases)
+	})
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrNameTaken
+		}
 		return nil, fmt.Errorf("failed to update emoji: %w", err)
 	}
 
 	emoji.Name = newName
+	emoji.Aliases = newAliases
+	emoji.AllowExternal = *allowExternal
 	return emoji, nil
 }
 
@@ -238,10 +289,10 @@ func (s *Service) GetCommunityEmojis(ctx context.Context, communityID, userID uu
 	}
 
 	rows, err := s.db.Query(ctx,
-		`SELECT id, community_id, name, image_url, uploader_id, animated, created_at, updated_at
-		FROM custom_emojis
-		WHERE community_id = $1
-		ORDER BY name ASC`,
+		`SELECT `+emojiColumns+`
+		FROM custom_emojis e
+		WHERE e.community_id = $1
+		ORDER BY e.name ASC`,
 		communityID,
 	)
 	if err != nil {
@@ -252,7 +303,7 @@ func (s *Service) GetCommunityEmojis(ctx context.Context, communityID, userID uu
 	var emojis []models.CustomEmoji
 	for rows.Next() {
 		var e models.CustomEmoji
-		if err := rows.Scan(&e.ID, &e.CommunityID, &e.Name, &e.ImageURL, &e.UploaderID, &e.Animated, &e.CreatedAt, &e.UpdatedAt); err != nil {
+		if err := rows.Scan(emojiDest(&e)...); err != nil {
 			return nil, fmt.Errorf("failed to scan emoji: %w", err)
 		}
 		emojis = append(emojis, e)
@@ -266,32 +317,94 @@ func (s *Service) GetCommunityEmojis(ctx context.Context, communityID, userID uu
 
 // GetAllAccessibleEmojis returns emojis from every community the user belongs to.
 // This powers the "use anywhere" feature similar to Discord Nitro, but free for everyone.
-func (s *Service) GetAllAccessibleEmojis(ctx context.Context, userID uuid.UUID) ([]models.CustomEmojiWithCommunity, error) {
+// Emojis a community keeps to itself are only included when contextCommunityID is
+// that community, i.e. the picker is open there; pass nil for DMs.
+func (s *Service) GetAllAccessibleEmojis(ctx context.Context, userID uuid.UUID, contextCommunityID *uuid.UUID) ([]models.CustomEmojiWithCommunity, error) {
 	rows, err := s.db.Query(ctx,
-		`SELECT e.id, e.community_id, e.name, e.image_url, e.uploader_id, e.animated, e.created_at, e.updated_at,
-		        c.name AS community_name
+		`SELECT `+emojiColumns+`, c.name AS community_name
 		FROM custom_emojis e
 		JOIN communities c ON c.id = e.community_id
 		JOIN community_members cm ON cm.community_id = e.community_id AND cm.user_id = $1
+		WHERE e.allow_external OR e.community_id = $2
 		ORDER BY c.name ASC, e.name ASC`,
-		userID,
+		userID, contextCommunityID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch accessible emojis: %w", err)
 	}
+	return scanEmojisWithCommunity(rows)
+}
+
+// SearchEmojis autocompletes emoji names and aliases across the user's
+// communities, or within one community when onlyCommunity is set. Exact and
+// prefix matches rank first, then emojis from the community the user is typing in.
+func (s *Service) SearchEmojis(ctx context.Context, userID uuid.UUID, query string, contextCommunityID *uuid.UUID, onlyCommunity bool, limit int) ([]models.CustomEmojiWithCommunity, error) {
+	if limit <= 0 || limit > MaxSearchResults {
+		limit = DefaultSearchResults
+	}
+
+	if onlyCommunity {
+		if contextCommunityID == nil || !s.communityService.IsMember(ctx, *contextCommunityID, userID) {
+			return nil, ErrNotMember
+		}
+	}
+
+	// Names are [a-zA-Z0-9_], so this also drops the colons of ":name"
+	needle := strings.ToLower(emojiQuerySanitizer.ReplaceAllString(query, ""))
+	if needle == "" {
+		return []models.CustomEmojiWithCommunity{}, nil
+	}
+	// _ is a LIKE wildcard
+	pattern := strings.ReplaceAll(needle, "_", `\_`)
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+emojiColumns+`, c.name AS community_name
+		FROM custom_emojis e
+		JOIN communities c ON c.id = e.community_id
+		JOIN community_members cm ON cm.community_id = e.community_id AND cm.user_id = $1
+		LEFT JOIN LATERAL (
+		    SELECT BOOL_OR(LOWER(a.alias) = $5) AS exact, BOOL_OR(LOWER(a.alias) LIKE $2 || '%') AS prefix
+		    FROM custom_emoji_aliases a
+		    WHERE a.emoji_id = e.id AND LOWER(a.alias) LIKE '%' || $2 || '%'
+		) am ON TRUE
+		WHERE (LOWER(e.name) LIKE '%' || $2 || '%' OR am.exact IS NOT NULL)
+		  AND (e.allow_external OR e.community_id = $3)
+		  AND (NOT $4 OR e.community_id = $3)
+		ORDER BY (LOWER(e.name) = $5 OR COALESCE(am.exact, FALSE)) DESC,
+		         (LOWER(e.name) LIKE $2 || '%' OR COALESCE(am.prefix, FALSE)) DESC,
+		         COALESCE(e.community_id = $3, FALSE) DESC,
+		         LENGTH(e.name) ASC,
+		         e.name ASC
+		LIMIT $6`,
+		userID, pattern, contextCommunityID, onlyCommunity, needle, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search emojis: %w", err)
+	}
+	return scanEmojisWithCommunity(rows)
+}
+
+// ResolveEmoji looks up a single custom emoji by ID for rendering in messages
+func (s *Service) ResolveEmoji(ctx context.Context, emojiID uuid.UUID) (*models.CustomEmoji, error) {
+	return s.getEmoji(ctx, emojiID)
+}
+
+// --- internal helpers ---
+
+func scanEmojisWithCommunity(rows pgx.Rows) ([]models.CustomEmojiWithCommunity, error) {
 	defer rows.Close()
 
 	var emojis []models.CustomEmojiWithCommunity
 	for rows.Next() {
 		var e models.CustomEmojiWithCommunity
-		if err := rows.Scan(
-			&e.ID, &e.CommunityID, &e.Name, &e.ImageURL, &e.UploaderID, &e.Animated, &e.CreatedAt, &e.UpdatedAt,
-			&e.CommunityName,
-		); err != nil {
+		if err := rows.Scan(append(emojiDest(&e.CustomEmoji), &e.CommunityName)...); err != nil {
 			return nil, fmt.Errorf("failed to scan emoji: %w", err)
 		}
 		emojis = append(emojis, e)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read emojis: %w", err)
+	}
 
 	if emojis == nil {
 		emojis = []models.CustomEmojiWithCommunity{}
@@ -299,20 +412,86 @@ func (s *Service) GetAllAccessibleEmojis(ctx context.Context, userID uuid.UUID)
 	return emojis, nil
 }
 
-// ResolveEmoji looks up a single custom emoji by ID for rendering in messages
-func (s *Service) ResolveEmoji(ctx context.Context, emojiID uuid.UUID) (*models.CustomEmoji, error) {
-	return s.getEmoji(ctx, emojiID)
+// emojiColumns selects an emoji aliased as e, with its aliases
+const emojiColumns = `e.id, e.community_id, e.name, e.image_url, e.uploader_id, e.animated, e.allow_external, e.created_at, e.updated_at,
+	COALESCE((SELECT ARRAY_AGG(a.alias ORDER BY a.alias) FROM custom_emoji_aliases a WHERE a.emoji_id = e.id), '{}')`
+
+func emojiDest(e *models.CustomEmoji) []any {
+	return []any{&e.ID, &e.CommunityID, &e.Name, &e.ImageURL, &e.UploaderID, &e.Animated, &e.AllowExternal, &e.CreatedAt, &e.UpdatedAt, &e.Aliases}
 }
 
-// --- internal helpers ---
+// normalizeAliases validates aliases and drops duplicates and ones equal to the name
+func normalizeAliases(name string, aliases []string) ([]string, error) {
+	seen := map[string]bool{strings.ToLower(name): true}
+	out := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		alias = strings.TrimSpace(alias)
+		if !emojiNameRegex.MatchString(alias) {
+			return nil, ErrInvalidAlias
+		}
+		if seen[strings.ToLower(alias)] {
+			continue
+		}
+		seen[strings.ToLower(alias)] = true
+		out = append(out, alias)
+	}
+	if len(out) > MaxAliasesPerEmoji {
+		return nil, ErrTooManyAliases
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// checkNamesFree fails when another emoji in the community already goes by
+// the name or one of the aliases
+func (s *Service) checkNamesFree(ctx context.Context, communityID, emojiID uuid.UUID, name string, aliases []string) error {
+	names := []string{strings.ToLower(name)}
+	for _, alias := range aliases {
+		names = append(names, strings.ToLower(alias))
+	}
+
+	var taken bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM custom_emojis WHERE community_id = $1 AND id != $2 AND LOWER(name) = ANY($3))
+		     OR EXISTS(SELECT 1 FROM custom_emoji_aliases WHERE community_id = $1 AND emoji_id != $2 AND LOWER(alias) = ANY($3))`,
+		communityID, emojiID, names,
+	).Scan(&taken)
+	if err != nil {
+		return fmt.Errorf("failed to check emoji name: %w", err)
+	}
+	if taken {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+func replaceAliases(ctx context.Context, tx pgx.Tx, emojiID, communityID uuid.UUID, aliases []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM custom_emoji_aliases WHERE emoji_id = $1`, emojiID); err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO custom_emoji_aliases (emoji_id, community_id, alias) VALUES ($1, $2, $3)`,
+			emojiID, communityID, alias,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
 
 func (s *Service) getEmoji(ctx context.Context, emojiID uuid.UUID) (*models.CustomEmoji, error) {
 	var e models.CustomEmoji
 	err := s.db.QueryRow(ctx,
-		`SELECT id, community_id, name, image_url, uploader_id, animated, created_at, updated_at
-		FROM custom_emojis WHERE id = $1`,
+		`SELECT `+emojiColumns+` FROM custom_emojis e WHERE e.id = $1`,
 		emojiID,
-	).Scan(&e.ID, &e.CommunityID, &e.Name, &e.ImageURL, &e.UploaderID, &e.Animated, &e.CreatedAt, &e.UpdatedAt)
+	).Scan(emojiDest(&e)...)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrEmojiNotFound
@@ -343,11 +522,14 @@ func (s *Service) extractObjectName(imageURL string) string {
 }
 
 // processEmojiImage resizes and compresses the emoji for storage.
-// GIFs are kept as-is to preserve animation. Everything else gets
-// downscaled to 128x128 max and re-encoded to save space.
-func (s *Service) processEmojiImage(data []byte, contentType string, filename string) ([]byte, string, string) {
-	// Don't touch GIFs -- we'd lose the animation
-	if contentType == "image/gif" {
+// Animated GIFs and WebPs are kept as-is to preserve the animation.
+// Everything else gets downscaled to 128x128 max and re-encoded to save space.
+func (s *Service) processEmojiImage(data []byte, contentType string, animated bool, filename string) ([]byte, string, string) {
+	// Don't touch animations -- we'd flatten them to their first frame
+	if animated {
+		if contentType == "image/webp" {
+			return data, contentType, ".webp"
+		}
 		return data, contentType, ".gif"
 	}
 
@@ -363,6 +545,8 @@ func (s *Service) processEmojiImage(data []byte, contentType string, filename st
 				ext = ".jpg"
 			case "image/webp":
 				ext = ".webp"
+			case "image/gif":
+				ext = ".gif"
 			default:
 				ext = ".png"
 			}
@@ -378,14 +562,14 @@ func (s *Service) processEmojiImage(data []byte, contentType string, filename st
 		img = resize.Thumbnail(uint(MaxEmojiDimension), uint(MaxEmojiDimension), img, resize.Lanczos3)
 	}
 
-	// PNG and WebP sources stay as PNG to keep transparency
-	if contentType == "image/png" || contentType == "image/webp" {
+	// PNG, WebP and still GIF sources become PNG to keep transparency
+	if contentType == "image/png" || contentType == "image/webp" || contentType == "image/gif" {
 		var buf bytes.Buffer
 		if err := png.Encode(&buf, img); err != nil {
 			return data, contentType, ".png"
 		}
 		// Only use the compressed version if it actually saved space
-		if buf.Len() < len(data) {
+		if buf.Len() < len(data) || contentType != "image/png" {
 			return buf.Bytes(), "image/png", ".png"
 		}
 		return data, "image/png", ".png"
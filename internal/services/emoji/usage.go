@@ -0,0 +1,149 @@
+package emoji
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Consumer group usage counting reads the broadcast stream through
+const usageGroup = "emoji_usage"
+
+// Custom emojis are referenced in message content as <:name:id>, or <a:name:id>
+// for animated ones, and in reactions by the same reference
+var emojiRefRe = regexp.MustCompile(`<a?:[a-zA-Z0-9_]{2,32}:([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})>`)
+
+type usageMessageEvent struct {
+	ChannelID string `json:"channelId"`
+	Content   string `json:"content"`
+}
+
+type usageReactionEvent struct {
+	Emoji string `json:"emoji"`
+}
+
+// Run counts custom emoji used in messages and reactions on the realtime
+// broadcast stream. Gateway instances share one consumer group, named
+// consumer, so each event is counted once.
+func (s *Service) Run(ctx context.Context, consumer string) {
+	database.NewBroadcastConsumer(s.redis, usageGroup, consumer).Run(ctx, func(entry database.BroadcastEntry) {
+		var data struct {
+			ChannelID string `json:"channelId"`
+			Event     struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			} `json:"event"`
+		}
+		if err := json.Unmarshal(entry.Payload, &data); err != nil {
+			return
+		}
+
+		var (
+			column  string
+			content string
+		)
+		switch data.Event.Type {
+		case "MESSAGE_CREATE":
+			var ev usageMessageEvent
+			if err := json.Unmarshal(data.Event.Data, &ev); err != nil {
+				return
+			}
+			// Quarantined messages are only sent to their author's and
+			// moderators' streams and don't count until released
+			if ev.ChannelID == "" || ev.ChannelID != data.ChannelID {
+				return
+			}
+			column, content = "message_count", ev.Content
+		case "REACTION_ADD":
+			var ev usageReactionEvent
+			if err := json.Unmarshal(data.Event.Data, &ev); err != nil {
+				return
+			}
+			column, content = "reaction_count", ev.Emoji
+		default:
+			return
+		}
+
+		ids := parseEmojiRefs(content)
+		if len(ids) == 0 {
+			return
+		}
+		if err := s.recordUsage(ctx, column, ids); err != nil {
+			log.Warn().Err(err).Str("event", data.Event.Type).Msg("Failed to record emoji usage")
+		}
+	})
+}
+
+// parseEmojiRefs returns the distinct custom emoji referenced in content
+func parseEmojiRefs(content string) []uuid.UUID {
+	if !strings.Contains(content, "<") {
+		return nil
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	ids := make([]uuid.UUID, 0)
+	for _, match := range emojiRefRe.FindAllStringSubmatch(content, -1) {
+		id, err := uuid.Parse(match[1])
+		if err != nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// recordUsage bumps column by one for each emoji that still exists. A message
+// using the same emoji several times counts once.
+func (s *Service) recordUsage(ctx context.Context, column string, ids []uuid.UUID) error {
+	_, err := s.db.Exec(ctx,
+		fmt.Sprintf(`INSERT INTO custom_emoji_usage (emoji_id, %[1]s, last_used_at)
+		SELECT id, 1, NOW() FROM custom_emojis WHERE id = ANY($1)
+		ON CONFLICT (emoji_id) DO UPDATE
+		SET %[1]s = custom_emoji_usage.%[1]s + 1, last_used_at = NOW()`, column),
+		ids,
+	)
+	return err
+}
+
+// GetUsage lists a community's emojis with how often they've been used, least
+// used first, so unused ones can be pruned
+func (s *Service) GetUsage(ctx context.Context, communityID, userID uuid.UUID) ([]models.CustomEmojiUsage, error) {
+	if err := s.requireManageEmojis(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+emojiColumns+`,
+		        COALESCE(u.message_count, 0), COALESCE(u.reaction_count, 0), u.last_used_at
+		FROM custom_emojis e
+		LEFT JOIN custom_emoji_usage u ON u.emoji_id = e.id
+		WHERE e.community_id = $1
+		ORDER BY u.last_used_at ASC NULLS FIRST,
+		         COALESCE(u.message_count, 0) + COALESCE(u.reaction_count, 0) ASC,
+		         e.name ASC`,
+		communityID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get emoji usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make([]models.CustomEmojiUsage, 0)
+	for rows.Next() {
+		var u models.CustomEmojiUsage
+		if err := rows.Scan(append(emojiDest(&u.CustomEmoji), &u.MessageCount, &u.ReactionCount, &u.LastUsedAt)...); err != nil {
+			return nil, fmt.Errorf("failed to scan emoji usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+
+	return usage, rows.Err()
+}
@@ -0,0 +1,93 @@
+package encryptionaudit
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Routes are operator endpoints and must be mounted behind
+// middleware.AdminTokenMiddleware
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/report", h.GetReport)
+	r.Get("/failures", h.ListFailures)
+	r.Post("/repair", h.Repair)
+
+	return r
+}
+
+// GetReport lists affected ranges grouped by channel, conversation or
+// community and key version
+func (h *Handler) GetReport(w http.ResponseWriter, r *http.Request) {
+	report, err := h.service.Report(r.Context(), r.URL.Query().Get("kind"))
+	if err != nil {
+		respondAuditError(w, err, "Failed to build decryption report")
+		return
+	}
+
+	utils.RespondSuccess(w, report)
+}
+
+// ListFailures lists individual failures, optionally for one container
+func (h *Handler) ListFailures(w http.ResponseWriter, r *http.Request) {
+	var containerID *uuid.UUID
+	if raw := r.URL.Query().Get("containerId"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid container ID")
+			return
+		}
+		containerID = &id
+	}
+
+	page := utils.GetCursorParams(r, 50, 200)
+
+	failures, next, err := h.service.ListFailures(r.Context(), r.URL.Query().Get("kind"), containerID, page.Cursor, page.Limit)
+	if err != nil {
+		respondAuditError(w, err, "Failed to list decryption failures")
+		return
+	}
+
+	utils.RespondCursorPage(w, failures, next)
+}
+
+// Repair re-checks recorded failures and re-encrypts what a previous key can
+// still open
+func (h *Handler) Repair(w http.ResponseWriter, r *http.Request) {
+	var req RepairRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	result, err := h.service.Repair(r.Context(), &req)
+	if err != nil {
+		respondAuditError(w, err, "Failed to repair decryption failures")
+		return
+	}
+
+	utils.RespondSuccess(w, result)
+}
+
+func respondAuditError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidCursor):
+		utils.RespondInvalidCursor(w)
+	case errors.Is(err, ErrUnknownKind):
+		utils.RespondError(w, http.StatusBadRequest, "kind must be channel, dm or broadcast")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,36 @@
+package encryptionaudit
+
+import (
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+func init() {
+	kind := openapi.Param{
+		Name:        "kind",
+		Enum:        []string{messaging.ContentKindChannel, messaging.ContentKindDM, messaging.ContentKindBroadcast},
+		Description: "Only content of this kind",
+	}
+
+	openapi.Describe((*Handler).GetReport, openapi.Operation{
+		Summary:  "Summarize content that failed to decrypt, by container and key version",
+		Query:    []openapi.Param{kind},
+		Response: models.DecryptionReport{},
+	})
+	openapi.Describe((*Handler).ListFailures, openapi.Operation{
+		Summary: "List content that failed to decrypt",
+		Query: append([]openapi.Param{
+			kind,
+			{Name: "containerId", Format: "uuid", Description: "Only failures in this channel, conversation or community"},
+		}, openapi.CursorQuery...),
+		Response: []*models.DecryptionFailure{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).Repair, openapi.Operation{
+		Summary:      "Re-check failures and re-encrypt what a previous key still opens",
+		Body:         RepairRequest{},
+		BodyOptional: true,
+		Response:     models.DecryptionRepairResult{},
+	})
+}
@@ -0,0 +1,131 @@
+package encryptionaudit
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+// secretColumn is a column of secrets sealed with the keyring: webhook and
+// plugin signing secrets, and import credentials
+type secretColumn struct {
+	table  string
+	column string
+	// key is the column of the ID the secret is sealed for, id if empty
+	key    string
+	base64 bool // stored as text
+}
+
+var secretColumns = []secretColumn{
+	{table: "event_hooks", column: "encrypted_secret"},
+	{table: "git_endpoints", column: "encrypted_secret", key: "community_id"},
+	{table: "community_plugins", column: "encrypted_secret"},
+	{table: "import_jobs", column: "credentials", base64: true},
+}
+
+// RotateSecrets moves the stored signing secrets and import credentials onto
+// the current key. There are few of them, so they are checked in one pass.
+func (s *Service) RotateSecrets(ctx context.Context, dryRun bool) (*models.DecryptionRepairResult, error) {
+	result := &models.DecryptionRepairResult{DryRun: dryRun}
+	for _, col := range secretColumns {
+		if err := s.rotateSecretColumn(ctx, col, dryRun, result); err != nil {
+			return result, fmt.Errorf("rotate %s.%s: %w", col.table, col.column, err)
+		}
+	}
+	return result, nil
+}
+
+func (s *Service) rotateSecretColumn(ctx context.Context, col secretColumn, dryRun bool, result *models.DecryptionRepairResult) error {
+	key := col.key
+	if key == "" {
+		key = "id"
+	}
+	rows, err := s.db.Query(ctx,
+		fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s IS NOT NULL`, key, col.column, col.table, col.column),
+	)
+	if err != nil {
+		return err
+	}
+	type secret struct {
+		id     uuid.UUID
+		stored any
+		sealed []byte
+	}
+	var secrets []secret
+	for rows.Next() {
+		var sec secret
+		if col.base64 {
+			var encoded string
+			if err := rows.Scan(&sec.id, &encoded); err != nil {
+				rows.Close()
+				return err
+			}
+			sec.stored = encoded
+			// Undecodable values are left for Open to reject
+			sec.sealed, _ = base64.StdEncoding.DecodeString(encoded)
+		} else {
+			if err := rows.Scan(&sec.id, &sec.sealed); err != nil {
+				rows.Close()
+				return err
+			}
+			sec.stored = sec.sealed
+		}
+		secrets = append(secrets, sec)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, sec := range secrets {
+		res, updated, err := s.rotateSecret(sec.sealed, encryption.Row{Table: col.table, ID: sec.id})
+		if err != nil {
+			return err
+		}
+		count(result, res)
+		if res == outcomeUnrecoverable {
+			log.Warn().Str("table", col.table).Str("id", sec.id.String()).Msg("Stored secret can't be decrypted with any configured key")
+		}
+		if dryRun || updated == nil {
+			continue
+		}
+
+		var value any = updated
+		if col.base64 {
+			value = base64.StdEncoding.EncodeToString(updated)
+		}
+		// Only the value that was read is replaced, so a secret rotated in
+		// the meantime is kept
+		_, err = s.db.Exec(ctx,
+			fmt.Sprintf(`UPDATE %s SET %s = $2 WHERE %s = $1 AND %s = $3`, col.table, col.column, key, col.column),
+			sec.id, value, sec.stored,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotateSecret returns the secret sealed for row under the current key, or
+// nil when it already is or can't be opened
+func (s *Service) rotateSecret(sealed []byte, row encryption.Row) (outcome, []byte, error) {
+	plaintext, err := s.keys.Open(sealed, row)
+	if err != nil {
+		return outcomeUnrecoverable, nil, nil
+	}
+	if keyVersion, ok := encryption.EnvelopeKeyID(sealed); ok && encryption.IsBound(sealed) {
+		if keyVersion == s.keys.CurrentID() {
+			return outcomeReadable, nil, nil
+		}
+		rewrapped, err := s.keys.Rewrap(sealed)
+		return outcomeRewrapped, rewrapped, err
+	}
+	resealed, err := s.keys.Seal(plaintext, row)
+	return outcomeRepaired, resealed, err
+}
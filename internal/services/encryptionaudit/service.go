@@ -0,0 +1,508 @@
+// Package encryptionaudit reports stored content that no longer decrypts,
+// repairs what can be recovered with a previous encryption key and moves
+// content onto the current key after a rotation.
+package encryptionaudit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/encryption"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+const (
+	DefaultRepairLimit = 500
+	MaxRepairLimit     = 5000
+	DefaultSweepBatch  = 500
+)
+
+var ErrUnknownKind = errors.New("unknown content kind")
+
+var repairsTotal = metrics.NewCounter("zentra_decryption_repairs_total",
+	"Outcome of checking stored content during repair and re-encrypt passes.", "kind", "result")
+
+// contentTable says where each kind of encrypted content lives
+type contentTable struct {
+	table     string
+	container string
+	hasNonce  bool // channel messages carry the nonce inside the ciphertext
+}
+
+var contentTables = map[string]contentTable{
+	messaging.ContentKindChannel:   {table: "messages", container: "channel_id"},
+	messaging.ContentKindDM:        {table: "direct_messages", container: "conversation_id", hasNonce: true},
+	messaging.ContentKindBroadcast: {table: "community_broadcasts", container: "community_id", hasNonce: true},
+}
+
+// outcome is what checking one piece of content found
+type outcome string
+
+const (
+	outcomeReadable      outcome = "readable"
+	outcomeRewrapped     outcome = "rewrapped"
+	outcomeRepaired      outcome = "repaired"
+	outcomeUnrecoverable outcome = "unrecoverable"
+	outcomeMissing       outcome = "missing"
+)
+
+type Service struct {
+	db   *pgxpool.Pool
+	keys *encryption.Keyring
+}
+
+// NewService takes the keyring holding the current key and the keys it
+// replaced. Data keys wrapped with a previous key are re-wrapped with the
+// current one, and content written before envelope encryption is re-encrypted
+// into an envelope.
+func NewService(db *pgxpool.Pool, keys *encryption.Keyring) *Service {
+	return &Service{db: db, keys: keys}
+}
+
+type RepairRequest struct {
+	DryRun bool `json:"dryRun"`
+	Limit  int  `json:"limit" validate:"omitempty,min=1,max=5000"`
+	// Also retry failures an earlier pass gave up on, e.g. after adding a key
+	// to ENCRYPTION_PREVIOUS_KEYS
+	IncludeUnrecoverable bool `json:"includeUnrecoverable"`
+}
+
+func (s *Service) cipherFor(kind string) messaging.ContentCipher {
+	if kind == messaging.ContentKindChannel {
+		return messaging.NewChannelCipher(s.keys)
+	}
+	return messaging.NewDMCipher(s.keys)
+}
+
+func validKind(kind string) bool {
+	_, ok := contentTables[kind]
+	return ok
+}
+
+// Report groups recorded failures into ranges per channel, conversation or
+// community and key version. kind may be empty to include every kind.
+func (s *Service) Report(ctx context.Context, kind string) (*models.DecryptionReport, error) {
+	if kind != "" && !validKind(kind) {
+		return nil, ErrUnknownKind
+	}
+
+	report := &models.DecryptionReport{
+		CurrentKeyVersion:   s.keys.CurrentID(),
+		PreviousKeyVersions: s.keys.PreviousIDs(),
+		Ranges:              []*models.DecryptionFailureRange{},
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT f.kind, f.container_id, f.key_version,
+		        COUNT(*), COUNT(*) FILTER (WHERE f.unrecoverable),
+		        MIN(c.created_at), MAX(c.created_at),
+		        MIN(f.first_seen_at), MAX(f.last_seen_at)
+		 FROM decryption_failures f
+		 LEFT JOIN LATERAL (`+createdAtLookup+`) c ON TRUE
+		 WHERE $1 = '' OR f.kind = $1
+		 GROUP BY f.kind, f.container_id, f.key_version
+		 ORDER BY MAX(f.last_seen_at) DESC`,
+		kind,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := &models.DecryptionFailureRange{}
+		if err := rows.Scan(
+			&r.Kind, &r.ContainerID, &r.KeyVersion,
+			&r.Failures, &r.Unrecoverable,
+			&r.OldestContent, &r.NewestContent,
+			&r.FirstSeenAt, &r.LastSeenAt,
+		); err != nil {
+			return nil, err
+		}
+		report.TotalFailures += r.Failures
+		report.Unrecoverable += r.Unrecoverable
+		report.Ranges = append(report.Ranges, r)
+	}
+	return report, rows.Err()
+}
+
+// createdAtLookup finds when the failed content was written; it is empty once
+// the content is gone
+const createdAtLookup = `SELECT created_at FROM messages WHERE f.kind = 'channel' AND id = f.content_id
+		     UNION ALL SELECT created_at FROM direct_messages WHERE f.kind = 'dm' AND id = f.content_id
+		     UNION ALL SELECT created_at FROM community_broadcasts WHERE f.kind = 'broadcast' AND id = f.content_id
+		     LIMIT 1`
+
+// ListFailures returns a page of individual failures, most recently seen
+// first, and the cursor of the next page or "" on the last one
+func (s *Service) ListFailures(ctx context.Context, kind string, containerID *uuid.UUID, cursor string, limit int) ([]*models.DecryptionFailure, string, error) {
+	if kind != "" && !validKind(kind) {
+		return nil, "", ErrUnknownKind
+	}
+	before, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	query := `SELECT f.kind, f.content_id, f.container_id, f.key_version, f.error, f.occurrences, f.unrecoverable,
+		        c.created_at, f.first_seen_at, f.last_seen_at, f.repair_attempted_at
+		 FROM decryption_failures f
+		 LEFT JOIN LATERAL (` + createdAtLookup + `) c ON TRUE
+		 WHERE ($1 = '' OR f.kind = $1) AND ($2::uuid IS NULL OR f.container_id = $2)`
+	args := []any{kind, containerID, limit + 1}
+	if before != nil {
+		query += ` AND (f.last_seen_at, f.content_id) < ($4, $5)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		 ORDER BY f.last_seen_at DESC, f.content_id DESC
+		 LIMIT $3`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	failures := []*models.DecryptionFailure{}
+	for rows.Next() {
+		f := &models.DecryptionFailure{}
+		if err := rows.Scan(
+			&f.Kind, &f.ContentID, &f.ContainerID, &f.KeyVersion, &f.Error, &f.Occurrences, &f.Unrecoverable,
+			&f.ContentCreatedAt, &f.FirstSeenAt, &f.LastSeenAt, &f.RepairAttemptedAt,
+		); err != nil {
+			return nil, "", err
+		}
+		failures = append(failures, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(failures) > limit {
+		failures = failures[:limit]
+		last := failures[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.LastSeenAt, ID: last.ContentID})
+	}
+	return failures, next, nil
+}
+
+// Repair re-checks recorded failures. Content that now decrypts is cleared
+// and moved onto the current key, and the rest is marked unrecoverable.
+func (s *Service) Repair(ctx context.Context, req *RepairRequest) (*models.DecryptionRepairResult, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = DefaultRepairLimit
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT kind, content_id FROM decryption_failures
+		 WHERE $1 OR NOT unrecoverable
+		 ORDER BY last_seen_at DESC
+		 LIMIT $2`,
+		req.IncludeUnrecoverable, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	type failed struct {
+		kind string
+		id   uuid.UUID
+	}
+	var pending []failed
+	for rows.Next() {
+		var f failed
+		if err := rows.Scan(&f.kind, &f.id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &models.DecryptionRepairResult{DryRun: req.DryRun}
+	for _, f := range pending {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		res, err := s.repairOne(ctx, f.kind, f.id, req.DryRun)
+		if err != nil {
+			return result, err
+		}
+		count(result, res)
+
+		if req.DryRun {
+			continue
+		}
+		if res == outcomeUnrecoverable {
+			_, err = s.db.Exec(ctx,
+				`UPDATE decryption_failures SET unrecoverable = TRUE, repair_attempted_at = NOW()
+				 WHERE kind = $1 AND content_id = $2`,
+				f.kind, f.id,
+			)
+		} else {
+			_, err = s.db.Exec(ctx, `DELETE FROM decryption_failures WHERE kind = $1 AND content_id = $2`, f.kind, f.id)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	log.Info().
+		Bool("dryRun", req.DryRun).
+		Int("scanned", result.Scanned).
+		Int("rewrapped", result.Rewrapped).
+		Int("repaired", result.Repaired).
+		Int("unrecoverable", result.Unrecoverable).
+		Msg("Decryption repair pass finished")
+	return result, nil
+}
+
+func (s *Service) repairOne(ctx context.Context, kind string, id uuid.UUID, dryRun bool) (outcome, error) {
+	t, ok := contentTables[kind]
+	if !ok {
+		return "", ErrUnknownKind
+	}
+
+	var containerID uuid.UUID
+	var ciphertext, nonce []byte
+	err := s.db.QueryRow(ctx,
+		fmt.Sprintf(`SELECT %s, encrypted_content, %s FROM %s WHERE id = $1`, t.container, nonceColumn(t), t.table),
+		id,
+	).Scan(&containerID, &ciphertext, &nonce)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+
+	return s.check(ctx, kind, id, ciphertext, nonce, dryRun)
+}
+
+// check moves content onto the current key: an envelope wrapped with a
+// previous key has its data key re-wrapped, and content written before
+// envelope encryption, or in an envelope not bound to its row, is
+// re-encrypted into one. A nil ciphertext means the
+// content is gone.
+func (s *Service) check(ctx context.Context, kind string, id uuid.UUID, ciphertext, nonce []byte, dryRun bool) (outcome, error) {
+	res, err := s.checkContent(ctx, kind, id, ciphertext, nonce, dryRun)
+	if err == nil {
+		repairsTotal.Inc(kind, string(res))
+	}
+	return res, err
+}
+
+func (s *Service) checkContent(ctx context.Context, kind string, id uuid.UUID, ciphertext, nonce []byte, dryRun bool) (outcome, error) {
+	if ciphertext == nil {
+		return outcomeMissing, nil
+	}
+
+	c := s.cipherFor(kind)
+	row := messaging.ContentRef{Kind: kind, ID: id}.Row()
+	content, err := c.Decrypt(row, ciphertext, nonce)
+	if err != nil {
+		return outcomeUnrecoverable, nil
+	}
+
+	keyVersion, isEnvelope := encryption.EnvelopeKeyID(ciphertext)
+	if isEnvelope && encryption.IsBound(ciphertext) && len(nonce) == 0 {
+		if keyVersion == s.keys.CurrentID() {
+			return outcomeReadable, nil
+		}
+		if !dryRun {
+			rewrapped, err := s.keys.Rewrap(ciphertext)
+			if err != nil {
+				return "", err
+			}
+			if err := s.replace(ctx, kind, id, rewrapped, nonce, ciphertext); err != nil {
+				return "", err
+			}
+		}
+		log.Debug().
+			Str("kind", kind).
+			Str("messageId", id.String()).
+			Str("fromKeyVersion", keyVersion).
+			Str("toKeyVersion", s.keys.CurrentID()).
+			Bool("dryRun", dryRun).
+			Msg("Re-wrapped data key")
+		return outcomeRewrapped, nil
+	}
+
+	if !dryRun {
+		sealed, newNonce, err := c.Encrypt(row, content)
+		if err != nil {
+			return "", err
+		}
+		if err := s.replace(ctx, kind, id, sealed, newNonce, ciphertext); err != nil {
+			return "", err
+		}
+	}
+	log.Debug().
+		Str("kind", kind).
+		Str("messageId", id.String()).
+		Str("toKeyVersion", s.keys.CurrentID()).
+		Bool("dryRun", dryRun).
+		Msg("Re-encrypted content into a bound envelope")
+	return outcomeRepaired, nil
+}
+
+// replace only replaces the ciphertext it read, so an edit that landed in
+// the meantime is never overwritten
+func (s *Service) replace(ctx context.Context, kind string, id uuid.UUID, ciphertext, nonce, oldCiphertext []byte) error {
+	t := contentTables[kind]
+	var err error
+	if t.hasNonce {
+		_, err = s.db.Exec(ctx,
+			fmt.Sprintf(`UPDATE %s SET encrypted_content = $2, nonce = $3 WHERE id = $1 AND encrypted_content = $4`, t.table),
+			id, ciphertext, nonce, oldCiphertext,
+		)
+	} else {
+		_, err = s.db.Exec(ctx,
+			fmt.Sprintf(`UPDATE %s SET encrypted_content = $2 WHERE id = $1 AND encrypted_content = $3`, t.table),
+			id, ciphertext, oldCiphertext,
+		)
+	}
+	return err
+}
+
+// Sweep walks every row of one kind of content in creation order, moves rows
+// onto the current key and records rows no key can open. progress, when set,
+// is called after each batch.
+func (s *Service) Sweep(ctx context.Context, kind string, batchSize int, dryRun bool, progress func(*models.DecryptionRepairResult)) (*models.DecryptionRepairResult, error) {
+	return s.walk(ctx, kind, batchSize, dryRun, nil, progress)
+}
+
+// Rotate is Sweep for after a key rotation. Only rows that aren't already
+// envelopes wrapped with the current key are read, which the database can
+// tell from their first bytes, and envelopes only have their data key
+// re-wrapped, so the content itself is never re-encrypted.
+func (s *Service) Rotate(ctx context.Context, kind string, batchSize int, dryRun bool, progress func(*models.DecryptionRepairResult)) (*models.DecryptionRepairResult, error) {
+	return s.walk(ctx, kind, batchSize, dryRun, s.keys.CurrentPrefix(), progress)
+}
+
+// walk checks content in creation order, skipping rows that start with skip
+// when it is set
+func (s *Service) walk(ctx context.Context, kind string, batchSize int, dryRun bool, skip []byte, progress func(*models.DecryptionRepairResult)) (*models.DecryptionRepairResult, error) {
+	t, ok := contentTables[kind]
+	if !ok {
+		return nil, ErrUnknownKind
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultSweepBatch
+	}
+
+	type row struct {
+		id          uuid.UUID
+		containerID uuid.UUID
+		ciphertext  []byte
+		nonce       []byte
+		createdAt   time.Time
+	}
+
+	result := &models.DecryptionRepairResult{DryRun: dryRun}
+	keyVersion := s.keys.CurrentID()
+	var afterTime time.Time
+	afterID := uuid.Nil
+
+	for {
+		rows, err := s.db.Query(ctx,
+			fmt.Sprintf(`SELECT id, %s, encrypted_content, %s, created_at FROM %s
+			 WHERE encrypted_content IS NOT NULL AND (created_at, id) > ($1, $2)
+			   AND ($4::bytea IS NULL OR substring(encrypted_content FROM 1 FOR length($4)) <> $4)
+			 ORDER BY created_at, id
+			 LIMIT $3`, t.container, nonceColumn(t), t.table),
+			afterTime, afterID, batchSize, skip,
+		)
+		if err != nil {
+			return result, err
+		}
+
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.containerID, &r.ciphertext, &r.nonce, &r.createdAt); err != nil {
+				rows.Close()
+				return result, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			return result, nil
+		}
+
+		for _, r := range batch {
+			res, err := s.check(ctx, kind, r.id, r.ciphertext, r.nonce, dryRun)
+			if err != nil {
+				return result, err
+			}
+			count(result, res)
+
+			if dryRun {
+				continue
+			}
+			switch res {
+			case outcomeRewrapped, outcomeRepaired:
+				_, err = s.db.Exec(ctx, `DELETE FROM decryption_failures WHERE kind = $1 AND content_id = $2`, kind, r.id)
+			case outcomeUnrecoverable:
+				ref := messaging.ContentRef{Kind: kind, ID: r.id, ContainerID: r.containerID}
+				if err = messaging.RecordDecryptionFailure(ctx, s.db, ref, keyVersion, encryption.ErrDecryptionFailed); err == nil {
+					_, err = s.db.Exec(ctx,
+						`UPDATE decryption_failures SET unrecoverable = TRUE, repair_attempted_at = NOW()
+						 WHERE kind = $1 AND content_id = $2`,
+						kind, r.id,
+					)
+				}
+			}
+			if err != nil {
+				return result, err
+			}
+		}
+
+		last := batch[len(batch)-1]
+		afterTime, afterID = last.createdAt, last.id
+		if progress != nil {
+			progress(result)
+		}
+	}
+}
+
+func nonceColumn(t contentTable) string {
+	if t.hasNonce {
+		return "nonce"
+	}
+	return "NULL::bytea"
+}
+
+func count(result *models.DecryptionRepairResult, res outcome) {
+	result.Scanned++
+	switch res {
+	case outcomeReadable:
+		result.Readable++
+	case outcomeRewrapped:
+		result.Rewrapped++
+	case outcomeRepaired:
+		result.Repaired++
+	case outcomeUnrecoverable:
+		result.Unrecoverable++
+	case outcomeMissing:
+		result.Missing++
+	}
+}
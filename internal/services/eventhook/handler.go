@@ -0,0 +1,224 @@
+package eventhook
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/communities/{communityId}", func(r chi.Router) {
+		r.Get("/hooks", h.ListHooks)
+		r.Post("/hooks", h.CreateHook)
+	})
+
+	r.Route("/hooks/{hookId}", func(r chi.Router) {
+		r.Get("/", h.GetHook)
+		r.Patch("/", h.UpdateHook)
+		r.Delete("/", h.DeleteHook)
+		r.Post("/rotate-secret", h.RotateSecret)
+		r.Post("/ping", h.Ping)
+		r.Get("/deliveries", h.ListDeliveries)
+	})
+
+	return r
+}
+
+// ListHooks returns the event hooks of a community
+func (h *Handler) ListHooks(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	hooks, err := h.service.ListHooks(r.Context(), communityID, userID)
+	if err != nil {
+		h.respondHookError(w, err, "Failed to get event hooks")
+		return
+	}
+
+	utils.RespondSuccess(w, hooks)
+}
+
+// CreateHook registers an endpoint. The response holds the signing secret,
+// which is not shown again.
+func (h *Handler) CreateHook(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	var req CreateHookRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	hook, err := h.service.CreateHook(r.Context(), communityID, userID, &req)
+	if err != nil {
+		h.respondHookError(w, err, "Failed to create event hook")
+		return
+	}
+
+	utils.RespondCreated(w, hook)
+}
+
+// GetHook returns a single event hook
+func (h *Handler) GetHook(w http.ResponseWriter, r *http.Request) {
+	userID, hookID, ok := h.hookParams(w, r)
+	if !ok {
+		return
+	}
+
+	hook, err := h.service.GetHook(r.Context(), hookID, userID)
+	if err != nil {
+		h.respondHookError(w, err, "Failed to get event hook")
+		return
+	}
+
+	utils.RespondSuccess(w, hook)
+}
+
+// UpdateHook changes the URL, subscribed events or active state of a hook
+func (h *Handler) UpdateHook(w http.ResponseWriter, r *http.Request) {
+	userID, hookID, ok := h.hookParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateHookRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	hook, err := h.service.UpdateHook(r.Context(), hookID, userID, &req)
+	if err != nil {
+		h.respondHookError(w, err, "Failed to update event hook")
+		return
+	}
+
+	utils.RespondSuccess(w, hook)
+}
+
+// DeleteHook removes a hook and its delivery log
+func (h *Handler) DeleteHook(w http.ResponseWriter, r *http.Request) {
+	userID, hookID, ok := h.hookParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteHook(r.Context(), hookID, userID); err != nil {
+		h.respondHookError(w, err, "Failed to delete event hook")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// RotateSecret issues a new signing secret
+func (h *Handler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	userID, hookID, ok := h.hookParams(w, r)
+	if !ok {
+		return
+	}
+
+	hook, err := h.service.RotateSecret(r.Context(), hookID, userID)
+	if err != nil {
+		h.respondHookError(w, err, "Failed to rotate event hook secret")
+		return
+	}
+
+	utils.RespondSuccess(w, hook)
+}
+
+// Ping queues a test delivery
+func (h *Handler) Ping(w http.ResponseWriter, r *http.Request) {
+	userID, hookID, ok := h.hookParams(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := h.service.Ping(r.Context(), hookID, userID)
+	if err != nil {
+		h.respondHookError(w, err, "Failed to ping event hook")
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusAccepted, utils.SuccessResponse{Data: delivery})
+}
+
+// ListDeliveries returns the delivery log of a hook
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, hookID, ok := h.hookParams(w, r)
+	if !ok {
+		return
+	}
+
+	page := utils.GetCursorParams(r, 50, 100)
+
+	deliveries, next, err := h.service.ListDeliveries(r.Context(), hookID, userID, page.Cursor, page.Limit)
+	if err != nil {
+		h.respondHookError(w, err, "Failed to get event hook deliveries")
+		return
+	}
+
+	utils.RespondCursorPage(w, deliveries, next)
+}
+
+func (h *Handler) hookParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	hookID, err := uuid.Parse(chi.URLParam(r, "hookId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid hook ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, hookID, true
+}
+
+func (h *Handler) respondHookError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidCursor):
+		utils.RespondInvalidCursor(w)
+	case errors.Is(err, ErrHookNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Event hook not found")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrInvalidURL), errors.Is(err, ErrUnknownEventType), errors.Is(err, ErrTooManyHooks):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,62 @@
+package eventhook
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	hookID := []openapi.Param{openapi.ID("hookId")}
+
+	openapi.Describe((*Handler).ListHooks, openapi.Operation{
+		Summary:  "List a community's event hooks",
+		Path:     communityID,
+		Response: []*models.EventHook{},
+	})
+	openapi.Describe((*Handler).CreateHook, openapi.Operation{
+		Summary:     "Create an event hook",
+		Description: "The signing secret is only returned here and when it is rotated.",
+		Path:        communityID,
+		Body:        CreateHookRequest{},
+		Response:    HookWithSecret{},
+		Status:      http.StatusCreated,
+	})
+
+	openapi.Describe((*Handler).GetHook, openapi.Operation{
+		Summary:  "Get an event hook",
+		Path:     hookID,
+		Response: models.EventHook{},
+	})
+	openapi.Describe((*Handler).UpdateHook, openapi.Operation{
+		Summary:  "Update an event hook",
+		Path:     hookID,
+		Body:     UpdateHookRequest{},
+		Response: models.EventHook{},
+	})
+	openapi.Describe((*Handler).DeleteHook, openapi.Operation{
+		Summary: "Delete an event hook",
+		Path:    hookID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RotateSecret, openapi.Operation{
+		Summary:  "Replace an event hook's signing secret",
+		Path:     hookID,
+		Response: HookWithSecret{},
+	})
+	openapi.Describe((*Handler).Ping, openapi.Operation{
+		Summary:  "Queue a test delivery to an event hook",
+		Path:     hookID,
+		Response: models.EventHookDelivery{},
+		Status:   http.StatusAccepted,
+	})
+	openapi.Describe((*Handler).ListDeliveries, openapi.Operation{
+		Summary:  "List an event hook's deliveries, newest first",
+		Path:     hookID,
+		Query:    openapi.CursorQuery,
+		Response: []*models.EventHookDelivery{},
+		Shape:    openapi.Page,
+	})
+}
@@ -0,0 +1,97 @@
+package eventhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// Headers sent with every delivery. Receivers verify a delivery by computing
+// HMAC-SHA256 over "<timestamp>.<body>" with the hook secret and comparing it
+// to the hex digest in SignatureHeader.
+const (
+	EventHeader     = "X-Zentra-Event"
+	DeliveryHeader  = "X-Zentra-Delivery"
+	TimestampHeader = "X-Zentra-Timestamp"
+	SignatureHeader = "X-Zentra-Signature"
+)
+
+// Sender posts signed deliveries. Plugin event deliveries go through it too.
+type Sender struct {
+	client    *http.Client
+	userAgent string
+}
+
+func NewSender(timeout time.Duration, userAgent string) *Sender {
+	return &Sender{
+		userAgent: userAgent,
+		client: &http.Client{
+			Timeout: timeout,
+			// The address is checked when it's dialed, so a host can't resolve
+			// to a public address for the check and a private one for the post
+			Transport: messaging.PublicTransport(),
+			// A redirect could point anywhere, including inside our network
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Sign returns the signature header value for body sent at timestamp
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts one delivery. It returns the response status when there was one,
+// and an error unless the endpoint answered with a 2xx.
+func (s *Sender) Send(ctx context.Context, endpoint string, secret []byte, eventType string, deliveryID uuid.UUID, payload []byte) (*int, error) {
+	// The host is checked again on every attempt since DNS may have changed
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if err := messaging.ValidatePublicHost(ctx, parsed.Hostname()); err != nil {
+		return nil, fmt.Errorf("refusing to deliver: %w", err)
+	}
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", s.userAgent)
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(DeliveryHeader, deliveryID.String())
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, payload))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	status := resp.StatusCode
+	if status < 200 || status > 299 {
+		return &status, fmt.Errorf("endpoint responded with %d", status)
+	}
+	return &status, nil
+}
@@ -0,0 +1,834 @@
+package eventhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	MaxHooksPerCommunity = 10
+
+	// A delivery is retried with exponential backoff, 30s up to an hour apart
+	maxAttempts    = 8
+	baseRetryDelay = 30 * time.Second
+	maxRetryDelay  = time.Hour
+
+	// Hooks are switched off after this many deliveries in a row ran out of retries
+	disableAfterFailures = 10
+
+	deliveryTimeout   = 10 * time.Second
+	deliveryLease     = time.Minute
+	pollInterval      = 5 * time.Second
+	deliveryBatch     = 50
+	deliveryWorkers   = 8
+	deliveryRetention = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrHookNotFound      = errors.New("event hook not found")
+	ErrInvalidURL        = errors.New("event hook URL must be a public https URL")
+	ErrUnknownEventType  = errors.New("unknown event type")
+	ErrTooManyHooks      = errors.New("community has too many event hooks")
+)
+
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+	LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte)
+}
+
+// Subscriber is handed every dispatched event, encoded the same way hook
+// deliveries are, whether or not any hook wants it. Installed plugins receive
+// events this way.
+type Subscriber interface {
+	EnqueueEvent(ctx context.Context, communityID uuid.UUID, eventType string, payload []byte)
+}
+
+// ChannelViewers is the part of the channel service used to keep channel
+// events away from hooks whose creator can't see the channel
+type ChannelViewers interface {
+	ChannelViewers(ctx context.Context, channelID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error)
+}
+
+type Service struct {
+	db               *pgxpool.Pool
+	communityService CommunityServiceInterface
+	channels         ChannelViewers
+	keys             *encryption.Keyring
+	sender           *Sender
+	subscribers      []Subscriber
+	wake             chan struct{}
+}
+
+func NewService(db *pgxpool.Pool, communityService CommunityServiceInterface, keys *encryption.Keyring) *Service {
+	return &Service{
+		db:               db,
+		communityService: communityService,
+		keys:             keys,
+		sender:           NewSender(deliveryTimeout, "Zentra-EventHooks/1.0"),
+		wake:             make(chan struct{}, 1),
+	}
+}
+
+// SetChannelService limits channel events to hooks whose creator can see the
+// channel. Call during startup only.
+func (s *Service) SetChannelService(channels ChannelViewers) {
+	s.channels = channels
+}
+
+// Subscribe hands sub every event dispatched from now on. Call during startup only.
+func (s *Service) Subscribe(sub Subscriber) {
+	s.subscribers = append(s.subscribers, sub)
+}
+
+type CreateHookRequest struct {
+	URL        string   `json:"url" validate:"required,url,max=2048"`
+	EventTypes []string `json:"eventTypes" validate:"required,min=1,max=20"`
+}
+
+type UpdateHookRequest struct {
+	URL        *string   `json:"url" validate:"omitempty,url,max=2048"`
+	EventTypes *[]string `json:"eventTypes" validate:"omitempty,min=1,max=20"`
+	IsActive   *bool     `json:"isActive"`
+}
+
+// HookWithSecret is returned when a signing secret is created or rotated. The
+// secret is not shown again.
+type HookWithSecret struct {
+	*models.EventHook
+	Secret string `json:"secret"`
+}
+
+// envelope is the JSON body of every delivery
+type envelope struct {
+	ID          uuid.UUID `json:"id"`
+	Type        string    `json:"type"`
+	CommunityID uuid.UUID `json:"communityId"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Data        any       `json:"data"`
+}
+
+const hookColumns = `id, community_id, url, event_types, is_active, consecutive_failures, disabled_reason,
+	last_delivery_at, created_by, created_at, updated_at`
+
+func scanHook(scanner interface{ Scan(dest ...any) error }) (*models.EventHook, error) {
+	h := &models.EventHook{}
+	err := scanner.Scan(
+		&h.ID, &h.CommunityID, &h.URL, &h.EventTypes, &h.IsActive, &h.ConsecutiveFailures, &h.DisabledReason,
+		&h.LastDeliveryAt, &h.CreatedBy, &h.CreatedAt, &h.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+const deliveryColumns = `id, hook_id, event_type, payload, status, attempts, response_status, last_error,
+	next_attempt_at, created_at, completed_at`
+
+func scanDelivery(scanner interface{ Scan(dest ...any) error }) (*models.EventHookDelivery, error) {
+	d := &models.EventHookDelivery{}
+	err := scanner.Scan(
+		&d.ID, &d.HookID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.LastError,
+		&d.NextAttemptAt, &d.CreatedAt, &d.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListHooks returns the event hooks of a community
+func (s *Service) ListHooks(ctx context.Context, communityID, userID uuid.UUID) ([]*models.EventHook, error) {
+	if err := s.requirePermission(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+hookColumns+` FROM event_hooks WHERE community_id = $1 ORDER BY created_at ASC`,
+		communityID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list event hooks: %w", err)
+	}
+	defer rows.Close()
+
+	hooks := make([]*models.EventHook, 0)
+	for rows.Next() {
+		h, err := scanHook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan event hook: %w", err)
+		}
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// CreateHook registers an endpoint and returns it with its signing secret
+func (s *Service) CreateHook(ctx context.Context, communityID, userID uuid.UUID, req *CreateHookRequest) (*HookWithSecret, error) {
+	if err := s.requirePermission(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	hookURL, err := validateURL(ctx, req.URL)
+	if err != nil {
+		return nil, err
+	}
+	eventTypes, err := validateEventTypes(req.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	err = s.db.QueryRow(ctx, `SELECT COUNT(*) FROM event_hooks WHERE community_id = $1`, communityID).Scan(&count)
+	if err != nil {
+		return nil, fmt.Errorf("count event hooks: %w", err)
+	}
+	if count >= MaxHooksPerCommunity {
+		return nil, ErrTooManyHooks
+	}
+
+	// The ID is chosen here since the secret is sealed for the row
+	hookID := uuid.New()
+	secret, encryptedSecret, err := s.newSecret(hookID)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := scanHook(s.db.QueryRow(ctx,
+		`INSERT INTO event_hooks (id, community_id, url, encrypted_secret, event_types, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING `+hookColumns,
+		hookID, communityID, hookURL, encryptedSecret, eventTypes, userID,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create event hook: %w", err)
+	}
+
+	s.logAudit(ctx, hook, userID, models.AuditActionEventHookCreate)
+	return &HookWithSecret{EventHook: hook, Secret: secret}, nil
+}
+
+// GetHook returns a single hook
+func (s *Service) GetHook(ctx context.Context, hookID, userID uuid.UUID) (*models.EventHook, error) {
+	hook, err := s.getHook(ctx, hookID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requirePermission(ctx, hook.CommunityID, userID); err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+// UpdateHook changes the provided fields. Turning a hook back on clears the
+// failure streak that disabled it.
+func (s *Service) UpdateHook(ctx context.Context, hookID, userID uuid.UUID, req *UpdateHookRequest) (*models.EventHook, error) {
+	existing, err := s.GetHook(ctx, hookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var hookURL *string
+	if req.URL != nil {
+		validated, err := validateURL(ctx, *req.URL)
+		if err != nil {
+			return nil, err
+		}
+		hookURL = &validated
+	}
+	var eventTypes []string
+	if req.EventTypes != nil {
+		if eventTypes, err = validateEventTypes(*req.EventTypes); err != nil {
+			return nil, err
+		}
+	}
+
+	hook, err := scanHook(s.db.QueryRow(ctx,
+		`UPDATE event_hooks SET
+			url = COALESCE($2, url),
+			event_types = COALESCE($3, event_types),
+			is_active = COALESCE($4::boolean, is_active),
+			consecutive_failures = CASE WHEN $4::boolean THEN 0 ELSE consecutive_failures END,
+			disabled_reason = CASE WHEN $4::boolean IS NULL THEN disabled_reason ELSE NULL END
+		 WHERE id = $1
+		 RETURNING `+hookColumns,
+		existing.ID, hookURL, eventTypes, req.IsActive,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrHookNotFound
+		}
+		return nil, fmt.Errorf("update event hook: %w", err)
+	}
+
+	s.logAudit(ctx, hook, userID, models.AuditActionEventHookUpdate)
+	return hook, nil
+}
+
+// DeleteHook removes a hook along with its delivery log
+func (s *Service) DeleteHook(ctx context.Context, hookID, userID uuid.UUID) error {
+	hook, err := s.GetHook(ctx, hookID, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM event_hooks WHERE id = $1`, hookID); err != nil {
+		return fmt.Errorf("delete event hook: %w", err)
+	}
+
+	s.logAudit(ctx, hook, userID, models.AuditActionEventHookDelete)
+	return nil
+}
+
+// RotateSecret replaces the signing secret. Deliveries still pending are signed
+// with the new one.
+func (s *Service) RotateSecret(ctx context.Context, hookID, userID uuid.UUID) (*HookWithSecret, error) {
+	if _, err := s.GetHook(ctx, hookID, userID); err != nil {
+		return nil, err
+	}
+
+	secret, encryptedSecret, err := s.newSecret(hookID)
+	if err != nil {
+		return nil, err
+	}
+
+	hook, err := scanHook(s.db.QueryRow(ctx,
+		`UPDATE event_hooks SET encrypted_secret = $2 WHERE id = $1 RETURNING `+hookColumns,
+		hookID, encryptedSecret,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrHookNotFound
+		}
+		return nil, fmt.Errorf("rotate event hook secret: %w", err)
+	}
+
+	details, _ := json.Marshal(map[string]any{"secretRotated": true})
+	s.communityService.LogAudit(ctx, &hook.CommunityID, userID, models.AuditActionEventHookUpdate, "event_hook", &hook.ID, details)
+	return &HookWithSecret{EventHook: hook, Secret: secret}, nil
+}
+
+// Ping queues a test event for one hook, active or not, so admins can check an
+// endpoint before turning it (back) on. Pings are tried once.
+func (s *Service) Ping(ctx context.Context, hookID, userID uuid.UUID) (*models.EventHookDelivery, error) {
+	hook, err := s.GetHook(ctx, hookID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(envelope{
+		ID:          uuid.New(),
+		Type:        models.EventHookPing,
+		CommunityID: hook.CommunityID,
+		CreatedAt:   time.Now().UTC(),
+		Data:        map[string]any{"hookId": hook.ID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err := scanDelivery(s.db.QueryRow(ctx,
+		`INSERT INTO event_hook_deliveries (hook_id, event_type, payload)
+		 VALUES ($1, $2, $3)
+		 RETURNING `+deliveryColumns,
+		hook.ID, models.EventHookPing, payload,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("queue event hook ping: %w", err)
+	}
+
+	s.wakeUp()
+	return delivery, nil
+}
+
+// ListDeliveries returns a page of a hook's delivery log, newest first, and
+// the cursor of the next page or "" on the last one
+func (s *Service) ListDeliveries(ctx context.Context, hookID, userID uuid.UUID, cursor string, limit int) ([]*models.EventHookDelivery, string, error) {
+	if _, err := s.GetHook(ctx, hookID, userID); err != nil {
+		return nil, "", err
+	}
+	before, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	query := `SELECT ` + deliveryColumns + ` FROM event_hook_deliveries
+		 WHERE hook_id = $1`
+	args := []any{hookID, limit + 1}
+	if before != nil {
+		query += ` AND (created_at, id) < ($3, $4)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $2`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("list event hook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]*models.EventHookDelivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("scan event hook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.CreatedAt, ID: last.ID})
+	}
+	return deliveries, next, nil
+}
+
+// Dispatch queues eventType for every active hook of the community subscribed
+// to it and hands it to the subscribers. It returns straight away; the database work happens in the background
+// so the request that caused the event isn't held up.
+func (s *Service) Dispatch(ctx context.Context, communityID uuid.UUID, eventType string, data any) {
+	payload, err := s.encode(communityID, eventType, data)
+	if err != nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		s.enqueue(ctx, communityID, eventType, payload)
+		s.notifySubscribers(ctx, communityID, eventType, payload)
+	}()
+}
+
+// DispatchForChannel is Dispatch for events that only know their channel.
+// Channels outside communities are ignored.
+func (s *Service) DispatchForChannel(ctx context.Context, channelID uuid.UUID, eventType string, data any) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+
+		var communityID uuid.UUID
+		if err := s.db.QueryRow(ctx, `SELECT community_id FROM channels WHERE id = $1`, channelID).Scan(&communityID); err != nil {
+			return
+		}
+		payload, err := s.encode(communityID, eventType, data)
+		if err != nil {
+			return
+		}
+		s.enqueueForChannel(ctx, communityID, channelID, eventType, payload)
+		s.notifySubscribers(ctx, communityID, eventType, payload)
+	}()
+}
+
+// enqueueForChannel queues a channel event for the hooks whose creator can
+// see the channel. A hook is only as private as the member who made it, so
+// hooks whose creator left or lost access get nothing from the channel.
+func (s *Service) enqueueForChannel(ctx context.Context, communityID, channelID uuid.UUID, eventType string, payload []byte) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, created_by FROM event_hooks
+		 WHERE community_id = $1 AND is_active AND $2 = ANY(event_types) AND created_by IS NOT NULL`,
+		communityID, eventType,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", communityID.String()).Str("type", eventType).Msg("Failed to load event hooks")
+		return
+	}
+	hookCreators := make(map[uuid.UUID]uuid.UUID)
+	var creators []uuid.UUID
+	for rows.Next() {
+		var hookID, createdBy uuid.UUID
+		if err := rows.Scan(&hookID, &createdBy); err != nil {
+			rows.Close()
+			log.Error().Err(err).Msg("Failed to scan event hook")
+			return
+		}
+		hookCreators[hookID] = createdBy
+		creators = append(creators, createdBy)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(hookCreators) == 0 {
+		return
+	}
+
+	viewers, err := s.channels.ChannelViewers(ctx, channelID, creators)
+	if err != nil {
+		log.Error().Err(err).Str("channelId", channelID.String()).Msg("Failed to check event hook creators' channel access")
+		return
+	}
+	canView := make(map[uuid.UUID]bool, len(viewers))
+	for _, userID := range viewers {
+		canView[userID] = true
+	}
+	var hookIDs []uuid.UUID
+	for hookID, createdBy := range hookCreators {
+		if canView[createdBy] {
+			hookIDs = append(hookIDs, hookID)
+		}
+	}
+	if len(hookIDs) == 0 {
+		return
+	}
+
+	result, err := s.db.Exec(ctx,
+		`INSERT INTO event_hook_deliveries (hook_id, event_type, payload)
+		 SELECT unnest($1::uuid[]), $2, $3`,
+		hookIDs, eventType, payload,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", communityID.String()).Str("type", eventType).Msg("Failed to queue event hook deliveries")
+		return
+	}
+	if result.RowsAffected() > 0 {
+		s.wakeUp()
+	}
+}
+
+func (s *Service) encode(communityID uuid.UUID, eventType string, data any) ([]byte, error) {
+	payload, err := json.Marshal(envelope{
+		ID:          uuid.New(),
+		Type:        eventType,
+		CommunityID: communityID,
+		CreatedAt:   time.Now().UTC(),
+		Data:        data,
+	})
+	if err != nil {
+		log.Error().Err(err).Str("type", eventType).Msg("Failed to encode event hook payload")
+	}
+	return payload, err
+}
+
+func (s *Service) enqueue(ctx context.Context, communityID uuid.UUID, eventType string, payload []byte) {
+	result, err := s.db.Exec(ctx,
+		`INSERT INTO event_hook_deliveries (hook_id, event_type, payload)
+		 SELECT id, $2, $3 FROM event_hooks
+		 WHERE community_id = $1 AND is_active AND $2 = ANY(event_types)`,
+		communityID, eventType, payload,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", communityID.String()).Str("type", eventType).Msg("Failed to queue event hook deliveries")
+		return
+	}
+	if result.RowsAffected() > 0 {
+		s.wakeUp()
+	}
+}
+
+func (s *Service) notifySubscribers(ctx context.Context, communityID uuid.UUID, eventType string, payload []byte) {
+	for _, sub := range s.subscribers {
+		sub.EnqueueEvent(ctx, communityID, eventType, payload)
+	}
+}
+
+func (s *Service) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due events until ctx is cancelled. Every instance can run it;
+// deliveries are claimed with SKIP LOCKED so each is sent by one instance.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Service) deliverDue(ctx context.Context) {
+	for {
+		batch, err := s.claimDue(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to claim event hook deliveries")
+			return
+		}
+
+		sem := make(chan struct{}, deliveryWorkers)
+		var wg sync.WaitGroup
+		for _, d := range batch {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(d *models.EventHookDelivery) {
+				defer func() { <-sem; wg.Done() }()
+				s.deliver(ctx, d)
+			}(d)
+		}
+		wg.Wait()
+
+		if len(batch) < deliveryBatch {
+			return
+		}
+	}
+}
+
+// claimDue pushes the next attempt of due deliveries past the lease so other
+// instances leave them alone while this one sends them.
+func (s *Service) claimDue(ctx context.Context) ([]*models.EventHookDelivery, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE event_hook_deliveries SET next_attempt_at = $1
+		 WHERE id IN (
+			SELECT id FROM event_hook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED)
+		 RETURNING `+deliveryColumns,
+		time.Now().Add(deliveryLease), deliveryBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []*models.EventHookDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, d)
+	}
+	return batch, rows.Err()
+}
+
+func (s *Service) deliver(ctx context.Context, d *models.EventHookDelivery) {
+	var hookURL string
+	var encryptedSecret []byte
+	var active bool
+	err := s.db.QueryRow(ctx,
+		`SELECT url, encrypted_secret, is_active FROM event_hooks WHERE id = $1`, d.HookID,
+	).Scan(&hookURL, &encryptedSecret, &active)
+	if err != nil {
+		// Deleted hooks take their deliveries with them
+		return
+	}
+
+	ping := d.EventType == models.EventHookPing
+	if !active && !ping {
+		s.finish(ctx, d, nil, "hook is disabled", false)
+		return
+	}
+
+	secret, err := s.keys.Open(encryptedSecret, secretRow(d.HookID))
+	if err != nil {
+		log.Error().Err(err).Str("hookId", d.HookID.String()).Msg("Failed to decrypt event hook secret")
+		s.finish(ctx, d, nil, "signing secret unavailable", !ping)
+		return
+	}
+
+	status, err := s.sender.Send(ctx, hookURL, secret, d.EventType, d.ID, d.Payload)
+	if err == nil {
+		s.succeed(ctx, d, status)
+		return
+	}
+
+	attempts := d.Attempts + 1
+	if ping || attempts >= maxAttempts {
+		s.finish(ctx, d, status, err.Error(), !ping)
+		return
+	}
+
+	_, dbErr := s.db.Exec(ctx,
+		`UPDATE event_hook_deliveries SET attempts = $2, response_status = $3, last_error = $4, next_attempt_at = $5
+		 WHERE id = $1`,
+		d.ID, attempts, status, truncate(err.Error(), 512), time.Now().Add(retryDelay(attempts)),
+	)
+	if dbErr != nil {
+		log.Error().Err(dbErr).Str("deliveryId", d.ID.String()).Msg("Failed to schedule event hook retry")
+	}
+}
+
+func (s *Service) succeed(ctx context.Context, d *models.EventHookDelivery, status *int) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE event_hook_deliveries SET status = 'succeeded', attempts = attempts + 1, response_status = $2,
+		        last_error = NULL, completed_at = NOW()
+		 WHERE id = $1`,
+		d.ID, status,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("deliveryId", d.ID.String()).Msg("Failed to record event hook delivery")
+	}
+
+	if d.EventType == models.EventHookPing {
+		return
+	}
+	_, err = s.db.Exec(ctx,
+		`UPDATE event_hooks SET consecutive_failures = 0, last_delivery_at = NOW() WHERE id = $1`,
+		d.HookID,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("hookId", d.HookID.String()).Msg("Failed to reset event hook failures")
+	}
+}
+
+// finish gives up on a delivery. countFailure adds it to the hook's failure
+// streak, disabling the hook once the streak is long enough.
+func (s *Service) finish(ctx context.Context, d *models.EventHookDelivery, status *int, reason string, countFailure bool) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE event_hook_deliveries SET status = 'failed', attempts = attempts + 1, response_status = $2,
+		        last_error = $3, completed_at = NOW()
+		 WHERE id = $1`,
+		d.ID, status, truncate(reason, 512),
+	)
+	if err != nil {
+		log.Error().Err(err).Str("deliveryId", d.ID.String()).Msg("Failed to record event hook delivery")
+	}
+	if !countFailure {
+		return
+	}
+
+	var disabled bool
+	err = s.db.QueryRow(ctx,
+		`UPDATE event_hooks SET
+			consecutive_failures = consecutive_failures + 1,
+			is_active = is_active AND consecutive_failures + 1 < $2,
+			disabled_reason = CASE WHEN is_active AND consecutive_failures + 1 >= $2
+				THEN 'Disabled after repeated delivery failures' ELSE disabled_reason END
+		 WHERE id = $1
+		 RETURNING NOT is_active AND consecutive_failures = $2`,
+		d.HookID, disableAfterFailures,
+	).Scan(&disabled)
+	if err != nil {
+		log.Error().Err(err).Str("hookId", d.HookID.String()).Msg("Failed to record event hook failure")
+		return
+	}
+	if disabled {
+		log.Warn().Str("hookId", d.HookID.String()).Msg("Disabled event hook after repeated delivery failures")
+	}
+}
+
+// PruneDeliveries deletes finished deliveries past the retention period. It is
+// registered as a maintenance task.
+func (s *Service) PruneDeliveries(ctx context.Context) (int64, error) {
+	result, err := s.db.Exec(ctx,
+		`DELETE FROM event_hook_deliveries WHERE status <> 'pending' AND completed_at < $1`,
+		time.Now().Add(-deliveryRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *Service) getHook(ctx context.Context, hookID uuid.UUID) (*models.EventHook, error) {
+	hook, err := scanHook(s.db.QueryRow(ctx, `SELECT `+hookColumns+` FROM event_hooks WHERE id = $1`, hookID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrHookNotFound
+		}
+		return nil, err
+	}
+	return hook, nil
+}
+
+// secretRow is the row a hook's signing secret is sealed for
+func secretRow(hookID uuid.UUID) encryption.Row {
+	return encryption.Row{Table: "event_hooks", ID: hookID}
+}
+
+func (s *Service) newSecret(hookID uuid.UUID) (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("generate event hook secret: %w", err)
+	}
+	secret := "zhs_" + hex.EncodeToString(raw)
+
+	encrypted, err := s.keys.Seal([]byte(secret), secretRow(hookID))
+	if err != nil {
+		return "", nil, fmt.Errorf("encrypt event hook secret: %w", err)
+	}
+	return secret, encrypted, nil
+}
+
+func (s *Service) requirePermission(ctx context.Context, communityID, userID uuid.UUID) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(perms, models.PermissionManageWebhooks) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+func (s *Service) logAudit(ctx context.Context, hook *models.EventHook, actorID uuid.UUID, action string) {
+	details, _ := json.Marshal(map[string]any{
+		"url":        hook.URL,
+		"eventTypes": hook.EventTypes,
+		"isActive":   hook.IsActive,
+	})
+	s.communityService.LogAudit(ctx, &hook.CommunityID, actorID, action, "event_hook", &hook.ID, details)
+}
+
+func validateURL(ctx context.Context, raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil {
+		return "", ErrInvalidURL
+	}
+	if err := messaging.ValidatePublicHost(ctx, parsed.Hostname()); err != nil {
+		return "", ErrInvalidURL
+	}
+	return parsed.String(), nil
+}
+
+func validateEventTypes(eventTypes []string) ([]string, error) {
+	seen := make([]string, 0, len(eventTypes))
+	for _, t := range eventTypes {
+		if !slices.Contains(models.EventHookTypes, t) {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownEventType, t)
+		}
+		if !slices.Contains(seen, t) {
+			seen = append(seen, t)
+		}
+	}
+	return seen, nil
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay << (attempts - 1)
+	if delay > maxRetryDelay || delay <= 0 {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// An export is a zip archive laid out as
+//
+//	manifest.json               Manifest
+//	roles.json                  []Role, highest position first
+//	categories.json             []Category
+//	channels.json               []Channel, with permission overwrites
+//	emojis.json                 []Emoji
+//	users.json                  []User: every member and every message author
+//	members.json                []Member
+//	messages/<channelId>.jsonl  one Message per line, oldest first
+//	media/...                   files referenced by a Path field
+//
+// IDs are the ones used on the exporting instance and tie the files together;
+// an importer should map them to new IDs. Message content is decrypted. When
+// media is included every Path names an entry in the archive; URL always holds
+// the original link, which stops working if the source instance goes away.
+const (
+	FormatName    = "peridotite-community-export"
+	FormatVersion = 1
+)
+
+const (
+	ManifestFile   = "manifest.json"
+	RolesFile      = "roles.json"
+	CategoriesFile = "categories.json"
+	ChannelsFile   = "channels.json"
+	EmojisFile     = "emojis.json"
+	UsersFile      = "users.json"
+	MembersFile    = "members.json"
+	MessagesDir    = "messages/"
+	MediaDir       = "media/"
+)
+
+type Manifest struct {
+	Format        string            `json:"format"`
+	Version       int               `json:"version"`
+	ExportedAt    time.Time         `json:"exportedAt"`
+	IncludesMedia bool              `json:"includesMedia"`
+	Community     ManifestCommunity `json:"community"`
+	Counts        ManifestCounts    `json:"counts"`
+}
+
+type ManifestCommunity struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description,omitempty"`
+	IconURL     *string   `json:"iconUrl,omitempty"`
+	IconPath    string    `json:"iconPath,omitempty"`
+	BannerURL   *string   `json:"bannerUrl,omitempty"`
+	BannerPath  string    `json:"bannerPath,omitempty"`
+	IsPublic    bool      `json:"isPublic"`
+	IsOpen      bool      `json:"isOpen"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type ManifestCounts struct {
+	Roles       int `json:"roles"`
+	Channels    int `json:"channels"`
+	Emojis      int `json:"emojis"`
+	Members     int `json:"members"`
+	Messages    int `json:"messages"`
+	Attachments int `json:"attachments"`
+}
+
+type Role struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Color       *string   `json:"color,omitempty"`
+	Position    int       `json:"position"`
+	Permissions int64     `json:"permissions"`
+	IsDefault   bool      `json:"isDefault"`
+}
+
+type Category struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Position int       `json:"position"`
+}
+
+type Channel struct {
+	ID              uuid.UUID       `json:"id"`
+	CategoryID      *uuid.UUID      `json:"categoryId,omitempty"`
+	Name            string          `json:"name"`
+	Topic           *string         `json:"topic,omitempty"`
+	Type            string          `json:"type"`
+	Position        int             `json:"position"`
+	IsNSFW          bool            `json:"isNsfw"`
+	SlowmodeSeconds int             `json:"slowmodeSeconds"`
+	Metadata        json.RawMessage `json:"metadata,omitempty"`
+	Overwrites      []Overwrite     `json:"overwrites"`
+	// MessageCount is the number of lines in the channel's messages file
+	MessageCount int `json:"messageCount"`
+}
+
+// Overwrite is a channel permission overwrite for a role or a member's user ID
+type Overwrite struct {
+	TargetType string    `json:"targetType"`
+	TargetID   uuid.UUID `json:"targetId"`
+	Allow      int64     `json:"allow"`
+	Deny       int64     `json:"deny"`
+}
+
+type Emoji struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Animated bool      `json:"animated"`
+	URL      string    `json:"url"`
+	Path     string    `json:"path,omitempty"`
+}
+
+type User struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName *string   `json:"displayName,omitempty"`
+	AvatarURL   *string   `json:"avatarUrl,omitempty"`
+}
+
+type Member struct {
+	UserID   uuid.UUID   `json:"userId"`
+	Nickname *string     `json:"nickname,omitempty"`
+	RoleIDs  []uuid.UUID `json:"roleIds"`
+	JoinedAt time.Time   `json:"joinedAt"`
+}
+
+type Message struct {
+	ID             uuid.UUID       `json:"id"`
+	AuthorID       uuid.UUID       `json:"authorId"`
+	Content        string          `json:"content"`
+	ContentWarning *string         `json:"contentWarning,omitempty"` // optional, older readers ignore it
+	ReplyToID      *uuid.UUID      `json:"replyToId,omitempty"`
+	IsEdited       bool            `json:"isEdited"`
+	IsPinned       bool            `json:"isPinned"`
+	Reactions      json.RawMessage `json:"reactions,omitempty"`
+	Attachments    []Attachment    `json:"attachments,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+}
+
+type Attachment struct {
+	ID          uuid.UUID `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType *string   `json:"contentType,omitempty"`
+	Size        int64     `json:"size"`
+	Width       *int      `json:"width,omitempty"`
+	Height      *int      `json:"height,omitempty"`
+	IsSpoiler   bool      `json:"isSpoiler"`
+	Description *string   `json:"description,omitempty"`
+	URL         string    `json:"url"`
+	Path        string    `json:"path,omitempty"`
+}
+
+// MessagesFile is the entry holding a channel's history
+func MessagesFile(channelID uuid.UUID) string {
+	return MessagesDir + channelID.String() + ".jsonl"
+}
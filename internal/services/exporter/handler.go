@@ -0,0 +1,153 @@
+package exporter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/communities/{communityId}", func(r chi.Router) {
+		r.Get("/", h.ListExports)
+		r.Post("/", h.CreateExport)
+	})
+
+	r.Route("/{exportId}", func(r chi.Router) {
+		r.Get("/", h.GetExport)
+		r.Get("/download", h.GetDownloadURL)
+	})
+
+	return r
+}
+
+// ListExports returns a community's recent exports
+func (h *Handler) ListExports(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := h.communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	exports, err := h.service.ListExports(r.Context(), communityID, userID)
+	if err != nil {
+		h.respondExportError(w, err, "Failed to get exports")
+		return
+	}
+
+	utils.RespondSuccess(w, exports)
+}
+
+// CreateExport queues an export of the community
+func (h *Handler) CreateExport(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := h.communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateExportRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	export, err := h.service.CreateExport(r.Context(), communityID, userID, &req)
+	if err != nil {
+		h.respondExportError(w, err, "Failed to create export")
+		return
+	}
+
+	utils.RespondCreated(w, export)
+}
+
+// GetExport returns an export's progress
+func (h *Handler) GetExport(w http.ResponseWriter, r *http.Request) {
+	userID, exportID, ok := h.exportParams(w, r)
+	if !ok {
+		return
+	}
+
+	export, err := h.service.GetExport(r.Context(), exportID, userID)
+	if err != nil {
+		h.respondExportError(w, err, "Failed to get export")
+		return
+	}
+
+	utils.RespondSuccess(w, export)
+}
+
+// GetDownloadURL returns a signed link to a finished export
+func (h *Handler) GetDownloadURL(w http.ResponseWriter, r *http.Request) {
+	userID, exportID, ok := h.exportParams(w, r)
+	if !ok {
+		return
+	}
+
+	download, err := h.service.GetDownloadURL(r.Context(), exportID, userID)
+	if err != nil {
+		h.respondExportError(w, err, "Failed to sign download link")
+		return
+	}
+
+	utils.RespondSuccess(w, download)
+}
+
+func (h *Handler) communityParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, communityID, true
+}
+
+func (h *Handler) exportParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	exportID, err := uuid.Parse(chi.URLParam(r, "exportId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid export ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, exportID, true
+}
+
+func (h *Handler) respondExportError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrExportNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Export not found")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrExportInProgress), errors.Is(err, ErrExportNotReady):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrExportTooSoon):
+		utils.RespondError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, ErrExportExpired):
+		utils.RespondError(w, http.StatusGone, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
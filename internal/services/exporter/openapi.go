@@ -0,0 +1,37 @@
+package exporter
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	exportID := []openapi.Param{openapi.ID("exportId")}
+
+	openapi.Describe((*Handler).ListExports, openapi.Operation{
+		Summary:  "List a community's exports",
+		Path:     communityID,
+		Response: []*models.CommunityExport{},
+	})
+	openapi.Describe((*Handler).CreateExport, openapi.Operation{
+		Summary:      "Start exporting a community",
+		Path:         communityID,
+		Body:         CreateExportRequest{},
+		BodyOptional: true,
+		Response:     models.CommunityExport{},
+		Status:       http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetExport, openapi.Operation{
+		Summary:  "Get an export's progress",
+		Path:     exportID,
+		Response: models.CommunityExport{},
+	})
+	openapi.Describe((*Handler).GetDownloadURL, openapi.Operation{
+		Summary:  "Get a short-lived link to a finished export",
+		Path:     exportID,
+		Response: DownloadResponse{},
+	})
+}
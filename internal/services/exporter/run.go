@@ -0,0 +1,680 @@
+package exporter
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// Run builds queued exports until ctx is cancelled. An export that was running
+// on an instance that died is started over by another one once its lease runs
+// out.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for s.runNext(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// runNext builds one export and reports whether there was one
+func (s *Service) runNext(ctx context.Context) bool {
+	var objectName string
+	var attempts int
+	export, err := scanExport(s.db.QueryRow(ctx,
+		`UPDATE community_exports e
+		SET status = $1, lease_owner = $2, lease_until = $3, attempts = attempts + 1,
+		    channel_count = 0, message_count = 0, attachment_count = 0, failed_media_count = 0,
+		    started_at = NOW(), updated_at = NOW()
+		FROM (
+			SELECT id FROM community_exports
+			WHERE status IN ($4, $1) AND (lease_until IS NULL OR lease_until < NOW())
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) due
+		WHERE e.id = due.id
+		RETURNING `+prefixedExportColumns("e.")+`, e.archive_object, e.attempts`,
+		models.ExportRunning, s.instanceID, time.Now().Add(jobLease), models.ExportQueued,
+	), &objectName, &attempts)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Error().Err(err).Msg("Failed to claim community export")
+		}
+		return false
+	}
+
+	if attempts > maxJobAttempts {
+		s.fail(ctx, export, errors.New("the export kept stopping unexpectedly"))
+		return true
+	}
+
+	log.Info().Str("exportId", export.ID.String()).Str("communityId", export.CommunityID.String()).Msg("Building community export")
+	s.publishProgress(ctx, export)
+
+	err = s.build(ctx, export, objectName)
+	switch {
+	case err == nil:
+		s.complete(ctx, export)
+	case errors.Is(err, errLeaseLost):
+		log.Info().Str("exportId", export.ID.String()).Msg("Export taken over by another instance")
+	case ctx.Err() != nil:
+		// Shutting down; the lease runs out and the export is started again
+	default:
+		log.Error().Err(err).Str("exportId", export.ID.String()).Msg("Community export failed")
+		s.fail(ctx, export, err)
+	}
+	return true
+}
+
+var errCommunityGone = errors.New("the community no longer exists")
+
+// archiveWriter writes one export archive
+type archiveWriter struct {
+	s      *Service
+	export *models.CommunityExport
+	zip    *zip.Writer
+	users  map[uuid.UUID]bool
+	counts ManifestCounts
+}
+
+// build writes the archive to a temporary file and uploads it
+func (s *Service) build(ctx context.Context, export *models.CommunityExport, objectName string) error {
+	file, err := os.CreateTemp("", "zentra-export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w := &archiveWriter{
+		s:      s,
+		export: export,
+		zip:    zip.NewWriter(file),
+		users:  make(map[uuid.UUID]bool),
+	}
+	if err := w.write(ctx); err != nil {
+		return err
+	}
+	if err := w.zip.Close(); err != nil {
+		return err
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := s.minio.PutObject(ctx, s.bucket, objectName, file, size, minio.PutObjectOptions{
+		ContentType: "application/zip",
+	}); err != nil {
+		return fmt.Errorf("upload archive: %w", err)
+	}
+	export.ArchiveSize = &size
+	return nil
+}
+
+func (w *archiveWriter) write(ctx context.Context) error {
+	manifest := Manifest{
+		Format:        FormatName,
+		Version:       FormatVersion,
+		ExportedAt:    time.Now().UTC(),
+		IncludesMedia: w.export.IncludeMedia,
+	}
+	c := &manifest.Community
+	err := w.s.db.QueryRow(ctx,
+		`SELECT id, name, description, icon_url, banner_url, is_public, is_open, created_at
+		FROM communities WHERE id = $1 AND deleted_at IS NULL`,
+		w.export.CommunityID,
+	).Scan(&c.ID, &c.Name, &c.Description, &c.IconURL, &c.BannerURL, &c.IsPublic, &c.IsOpen, &c.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return errCommunityGone
+	}
+	if err != nil {
+		return err
+	}
+	if c.IconURL != nil {
+		if c.IconPath, err = w.copyMedia(ctx, *c.IconURL, MediaDir+"community/icon"); err != nil {
+			return err
+		}
+	}
+	if c.BannerURL != nil {
+		if c.BannerPath, err = w.copyMedia(ctx, *c.BannerURL, MediaDir+"community/banner"); err != nil {
+			return err
+		}
+	}
+
+	for _, step := range []func(context.Context) error{w.writeRoles, w.writeCategories, w.writeEmojis, w.writeMembers, w.writeChannels, w.writeUsers} {
+		if err := step(ctx); err != nil {
+			return err
+		}
+	}
+
+	manifest.Counts = w.counts
+	return w.writeJSON(ManifestFile, manifest)
+}
+
+func (w *archiveWriter) writeRoles(ctx context.Context) error {
+	rows, err := w.s.db.Query(ctx,
+		`SELECT id, name, color, position, permissions, is_default
+		FROM roles WHERE community_id = $1
+		ORDER BY position DESC, created_at`,
+		w.export.CommunityID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var r Role
+		if err := rows.Scan(&r.ID, &r.Name, &r.Color, &r.Position, &r.Permissions, &r.IsDefault); err != nil {
+			return err
+		}
+		roles = append(roles, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.counts.Roles = len(roles)
+	return w.writeJSON(RolesFile, roles)
+}
+
+func (w *archiveWriter) writeCategories(ctx context.Context) error {
+	rows, err := w.s.db.Query(ctx,
+		`SELECT id, name, position FROM channel_categories WHERE community_id = $1 ORDER BY position, created_at`,
+		w.export.CommunityID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.ID, &c.Name, &c.Position); err != nil {
+			return err
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.writeJSON(CategoriesFile, categories)
+}
+
+func (w *archiveWriter) writeEmojis(ctx context.Context) error {
+	rows, err := w.s.db.Query(ctx,
+		`SELECT id, name, animated, image_url FROM custom_emojis WHERE community_id = $1 ORDER BY name`,
+		w.export.CommunityID,
+	)
+	if err != nil {
+		return err
+	}
+	emojis := []Emoji{}
+	for rows.Next() {
+		var e Emoji
+		if err := rows.Scan(&e.ID, &e.Name, &e.Animated, &e.URL); err != nil {
+			rows.Close()
+			return err
+		}
+		emojis = append(emojis, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range emojis {
+		if emojis[i].Path, err = w.copyMedia(ctx, emojis[i].URL, MediaDir+"emojis/"+emojis[i].ID.String()); err != nil {
+			return err
+		}
+	}
+	w.counts.Emojis = len(emojis)
+	return w.writeJSON(EmojisFile, emojis)
+}
+
+func (w *archiveWriter) writeMembers(ctx context.Context) error {
+	rows, err := w.s.db.Query(ctx,
+		`SELECT m.user_id, m.nickname, m.joined_at,
+			COALESCE(ARRAY_AGG(mr.role_id ORDER BY mr.role_id) FILTER (WHERE mr.role_id IS NOT NULL), '{}')
+		FROM community_members m
+		LEFT JOIN member_roles mr ON mr.member_id = m.id
+		WHERE m.community_id = $1
+		GROUP BY m.id
+		ORDER BY m.joined_at`,
+		w.export.CommunityID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	members := []Member{}
+	for rows.Next() {
+		var m Member
+		if err := rows.Scan(&m.UserID, &m.Nickname, &m.JoinedAt, &m.RoleIDs); err != nil {
+			return err
+		}
+		w.users[m.UserID] = true
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	w.counts.Members = len(members)
+	return w.writeJSON(MembersFile, members)
+}
+
+func (w *archiveWriter) writeChannels(ctx context.Context) error {
+	rows, err := w.s.db.Query(ctx,
+		`SELECT c.id, c.category_id, c.name, c.topic, c.type, c.position, c.is_nsfw, c.slowmode_seconds, c.metadata
+		FROM channels c
+		LEFT JOIN channel_categories cc ON cc.id = c.category_id
+		WHERE c.community_id = $1
+		ORDER BY cc.position NULLS FIRST, c.position, c.created_at`,
+		w.export.CommunityID,
+	)
+	if err != nil {
+		return err
+	}
+	channels := []*Channel{}
+	byID := make(map[uuid.UUID]*Channel)
+	for rows.Next() {
+		c := &Channel{Overwrites: []Overwrite{}}
+		var metadata []byte
+		if err := rows.Scan(&c.ID, &c.CategoryID, &c.Name, &c.Topic, &c.Type, &c.Position, &c.IsNSFW, &c.SlowmodeSeconds, &metadata); err != nil {
+			rows.Close()
+			return err
+		}
+		if len(metadata) > 0 && string(metadata) != "{}" {
+			c.Metadata = metadata
+		}
+		channels = append(channels, c)
+		byID[c.ID] = c
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Member overwrites are keyed by member ID, which means nothing outside
+	// this instance, so they are exported by user ID
+	rows, err = w.s.db.Query(ctx,
+		`SELECT cp.channel_id, cp.target_type,
+			CASE WHEN cp.target_type = 'member' THEN m.user_id ELSE cp.target_id END,
+			cp.allow_permissions, cp.deny_permissions
+		FROM channel_permissions cp
+		JOIN channels c ON c.id = cp.channel_id
+		LEFT JOIN community_members m ON cp.target_type = 'member' AND m.id = cp.target_id
+		WHERE c.community_id = $1 AND (cp.target_type <> 'member' OR m.id IS NOT NULL)`,
+		w.export.CommunityID,
+	)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var channelID uuid.UUID
+		var o Overwrite
+		if err := rows.Scan(&channelID, &o.TargetType, &o.TargetID, &o.Allow, &o.Deny); err != nil {
+			rows.Close()
+			return err
+		}
+		if c, ok := byID[channelID]; ok {
+			c.Overwrites = append(c.Overwrites, o)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range channels {
+		count, err := w.writeMessages(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		c.MessageCount = count
+		w.counts.Channels++
+		if err := w.checkpoint(ctx); err != nil {
+			return err
+		}
+	}
+	return w.writeJSON(ChannelsFile, channels)
+}
+
+// writeMessages writes a channel's history. Lines are spooled to a temporary
+// file while the channel's media is copied, since a zip can only have one
+// entry open at a time.
+func (w *archiveWriter) writeMessages(ctx context.Context, channelID uuid.UUID) (int, error) {
+	spool, err := os.CreateTemp("", "zentra-export-messages-*.jsonl")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	enc := json.NewEncoder(spool)
+
+	count := 0
+	var afterTime time.Time
+	afterID := uuid.Nil
+	for {
+		batch, err := w.messageBatch(ctx, channelID, afterTime, afterID)
+		if err != nil {
+			return 0, err
+		}
+		for i := range batch {
+			m := &batch[i]
+			for j := range m.Attachments {
+				a := &m.Attachments[j]
+				if a.Path, err = w.copyMedia(ctx, a.URL, MediaDir+"attachments/"+a.ID.String()); err != nil {
+					return 0, err
+				}
+				a.URL = w.s.urlSigner.Sign(a.URL, w.export.RequestedBy)
+				w.counts.Attachments++
+			}
+			if err := enc.Encode(m); err != nil {
+				return 0, err
+			}
+			w.users[m.AuthorID] = true
+		}
+		count += len(batch)
+		w.counts.Messages += len(batch)
+		if err := w.checkpoint(ctx); err != nil {
+			return 0, err
+		}
+		if len(batch) < messageBatch {
+			break
+		}
+		last := batch[len(batch)-1]
+		afterTime, afterID = last.CreatedAt, last.ID
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	entry, err := w.zip.Create(MessagesFile(channelID))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.Copy(entry, spool); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// messageBatch loads the next messages after (afterTime, afterID) with their
+// attachments. Deleted and quarantined messages are left out.
+func (w *archiveWriter) messageBatch(ctx context.Context, channelID uuid.UUID, afterTime time.Time, afterID uuid.UUID) ([]Message, error) {
+	rows, err := w.s.db.Query(ctx,
+		`SELECT id, author_id, encrypted_content, content_warning, reply_to_id, is_edited, is_pinned, reactions, created_at, updated_at
+		FROM messages
+		WHERE channel_id = $1 AND deleted_at IS NULL AND is_quarantined = FALSE
+			AND (created_at, id) > ($2, $3)
+		ORDER BY created_at, id
+		LIMIT $4`,
+		channelID, afterTime, afterID, messageBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]Message, 0, messageBatch)
+	index := make(map[uuid.UUID]int)
+	var ids []uuid.UUID
+	for rows.Next() {
+		var m Message
+		var encrypted, reactions []byte
+		if err := rows.Scan(&m.ID, &m.AuthorID, &encrypted, &m.ContentWarning, &m.ReplyToID, &m.IsEdited, &m.IsPinned, &reactions, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		m.Content, _ = messaging.DecryptOrPlaceholder(w.s.db, w.s.cipher, messaging.ContentRef{
+			Kind:        messaging.ContentKindChannel,
+			ID:          m.ID,
+			ContainerID: channelID,
+		}, encrypted, nil)
+		if len(reactions) > 0 && string(reactions) != "{}" {
+			m.Reactions = reactions
+		}
+		index[m.ID] = len(messages)
+		ids = append(ids, m.ID)
+		messages = append(messages, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return messages, nil
+	}
+
+	rows, err = w.s.db.Query(ctx,
+		`SELECT message_id, id, filename, content_type, file_size, width, height, is_spoiler, description, file_url
+		FROM message_attachments
+		WHERE message_id = ANY($1)
+		ORDER BY created_at, id`,
+		ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var messageID uuid.UUID
+		var a Attachment
+		if err := rows.Scan(&messageID, &a.ID, &a.Filename, &a.ContentType, &a.Size, &a.Width, &a.Height, &a.IsSpoiler, &a.Description, &a.URL); err != nil {
+			return nil, err
+		}
+		if i, ok := index[messageID]; ok {
+			messages[i].Attachments = append(messages[i].Attachments, a)
+		}
+	}
+	return messages, rows.Err()
+}
+
+// writeUsers writes every member and message author. It runs last so authors
+// who have since left are included.
+func (w *archiveWriter) writeUsers(ctx context.Context) error {
+	ids := make([]uuid.UUID, 0, len(w.users))
+	for id := range w.users {
+		ids = append(ids, id)
+	}
+	rows, err := w.s.db.Query(ctx,
+		`SELECT id, username, display_name, avatar_url FROM users WHERE id = ANY($1) ORDER BY username`,
+		ids,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL); err != nil {
+			return err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return w.writeJSON(UsersFile, users)
+}
+
+func (w *archiveWriter) writeJSON(name string, v any) error {
+	entry, err := w.zip.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(entry)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// copyMedia copies a file served from one of our buckets into the archive
+// under name plus the file's extension and returns the entry name. Files
+// hosted elsewhere, missing objects and exports without media return "".
+func (w *archiveWriter) copyMedia(ctx context.Context, rawURL, name string) (string, error) {
+	if !w.export.IncludeMedia {
+		return "", nil
+	}
+	bucket, objectName, ok := w.s.objectFor(rawURL)
+	if !ok {
+		return "", nil
+	}
+
+	object, err := w.s.minio.GetObject(ctx, bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer object.Close()
+	if _, err := object.Stat(); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			w.export.FailedMediaCount++
+			return "", nil
+		}
+		return "", err
+	}
+
+	// Media is already compressed, so it is stored as is
+	name += strings.ToLower(path.Ext(objectName))
+	entry, err := w.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(entry, object); err != nil {
+		return "", fmt.Errorf("copy %s: %w", objectName, err)
+	}
+	return name, nil
+}
+
+// objectFor maps a public media URL back to its bucket and object
+func (s *Service) objectFor(rawURL string) (string, string, bool) {
+	baseURL := strings.TrimSuffix(s.cdnBaseURL, "/")
+	for _, b := range s.mediaBuckets {
+		if strings.HasSuffix(baseURL, "/"+b) {
+			baseURL = strings.TrimSuffix(baseURL, "/"+b)
+			break
+		}
+	}
+	rawURL, _, _ = strings.Cut(rawURL, "?")
+	for _, b := range s.mediaBuckets {
+		if objectName, ok := strings.CutPrefix(rawURL, baseURL+"/"+b+"/"); ok && objectName != "" {
+			return b, objectName, true
+		}
+	}
+	return "", "", false
+}
+
+// checkpoint renews the lease and records progress. It fails with
+// errLeaseLost if another worker has taken the export over.
+func (w *archiveWriter) checkpoint(ctx context.Context) error {
+	e := w.export
+	e.ChannelCount, e.MessageCount, e.AttachmentCount = w.counts.Channels, w.counts.Messages, w.counts.Attachments
+	tag, err := w.s.db.Exec(ctx,
+		`UPDATE community_exports
+		SET channel_count = $3, message_count = $4, attachment_count = $5, failed_media_count = $6,
+		    lease_until = $7, updated_at = NOW()
+		WHERE id = $1 AND lease_owner = $2 AND status = $8`,
+		e.ID, w.s.instanceID, e.ChannelCount, e.MessageCount, e.AttachmentCount, e.FailedMediaCount,
+		time.Now().Add(jobLease), models.ExportRunning,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errLeaseLost
+	}
+	e.UpdatedAt = time.Now()
+	w.s.publishProgress(ctx, e)
+	return nil
+}
+
+func (s *Service) complete(ctx context.Context, export *models.CommunityExport) {
+	now := time.Now()
+	expiresAt := now.Add(archiveRetention)
+	_, err := s.db.Exec(ctx,
+		`UPDATE community_exports
+		SET status = $3, archive_size = $4, channel_count = $5, message_count = $6, attachment_count = $7,
+		    failed_media_count = $8, lease_owner = NULL, lease_until = NULL, finished_at = $9, expires_at = $10, updated_at = $9
+		WHERE id = $1 AND lease_owner = $2`,
+		export.ID, s.instanceID, models.ExportCompleted, export.ArchiveSize, export.ChannelCount, export.MessageCount,
+		export.AttachmentCount, export.FailedMediaCount, now, expiresAt,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("exportId", export.ID.String()).Msg("Failed to complete community export")
+		return
+	}
+	export.Status = models.ExportCompleted
+	export.FinishedAt = &now
+	export.ExpiresAt = &expiresAt
+
+	details, _ := json.Marshal(map[string]any{
+		"exportId":     export.ID,
+		"includeMedia": export.IncludeMedia,
+		"channels":     export.ChannelCount,
+		"messages":     export.MessageCount,
+		"attachments":  export.AttachmentCount,
+	})
+	s.communityService.LogAudit(ctx, &export.CommunityID, export.RequestedBy, models.AuditActionCommunityExport, "community", &export.CommunityID, details)
+	log.Info().Str("exportId", export.ID.String()).Int("messages", export.MessageCount).Msg("Community export completed")
+	s.publishProgress(ctx, export)
+}
+
+func (s *Service) fail(ctx context.Context, export *models.CommunityExport, cause error) {
+	message := "the export could not be built, request a new one"
+	if errors.Is(cause, errCommunityGone) {
+		message = cause.Error()
+	}
+	now := time.Now()
+	_, err := s.db.Exec(ctx,
+		`UPDATE community_exports
+		SET status = $3, error = $4, lease_owner = NULL, lease_until = NULL, finished_at = $5, updated_at = $5
+		WHERE id = $1 AND lease_owner = $2`,
+		export.ID, s.instanceID, models.ExportFailed, message, now,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("exportId", export.ID.String()).Msg("Failed to mark community export as failed")
+		return
+	}
+	export.Status = models.ExportFailed
+	export.Error = &message
+	export.FinishedAt = &now
+	s.publishProgress(ctx, export)
+}
+
+func prefixedExportColumns(prefix string) string {
+	columns := strings.Split(exportColumns, ",")
+	for i, c := range columns {
+		columns[i] = prefix + strings.TrimSpace(c)
+	}
+	return strings.Join(columns, ", ")
+}
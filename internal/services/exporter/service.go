@@ -0,0 +1,335 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	// Download links are short lived; a new one can be requested at any time
+	// until the archive expires
+	downloadURLExpiry = 15 * time.Minute
+	archiveRetention  = 7 * 24 * time.Hour
+	// A community may request one export per cooldown
+	exportCooldown = time.Hour
+
+	pollInterval   = 5 * time.Second
+	jobLease       = 2 * time.Minute
+	maxJobAttempts = 3
+	messageBatch   = 500
+)
+
+var (
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrExportNotFound    = errors.New("export not found")
+	ErrExportInProgress  = errors.New("an export of this community is already in progress")
+	ErrExportTooSoon     = errors.New("this community was exported recently, try again later")
+	ErrExportNotReady    = errors.New("export is not ready for download")
+	ErrExportExpired     = errors.New("export has expired")
+
+	// errLeaseLost stops a worker whose job was taken over
+	errLeaseLost = errors.New("export lease lost")
+)
+
+// CommunityServiceInterface is what the exporter needs from the community service
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+	LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte)
+}
+
+type Service struct {
+	db               *pgxpool.Pool
+	minio            *minio.Client
+	bucket           string
+	mediaBuckets     []string
+	cdnBaseURL       string
+	cipher           messaging.ContentCipher
+	communityService CommunityServiceInterface
+	instanceID       uuid.UUID
+	wake             chan struct{}
+	urlSigner        *messaging.URLSigner
+}
+
+// NewService creates the exporter. Archives are written to bucket, which must
+// not be public. Media is copied from mediaBuckets, the buckets files served
+// under cdnBaseURL live in.
+func NewService(db *pgxpool.Pool, minioClient *minio.Client, bucket string, mediaBuckets []string, cdnBaseURL string, keys *encryption.Keyring, communityService CommunityServiceInterface) *Service {
+	return &Service{
+		db:               db,
+		minio:            minioClient,
+		bucket:           bucket,
+		mediaBuckets:     mediaBuckets,
+		cdnBaseURL:       cdnBaseURL,
+		cipher:           messaging.NewChannelCipher(keys),
+		communityService: communityService,
+		instanceID:       uuid.New(),
+		wake:             make(chan struct{}, 1),
+	}
+}
+
+// SetURLSigner signs attachment links in archives for the member who asked
+// for the export, like the links the API hands them.
+func (s *Service) SetURLSigner(signer *messaging.URLSigner) {
+	s.urlSigner = signer
+}
+
+// EnsureBucket creates the private archive bucket if it is missing
+func (s *Service) EnsureBucket(ctx context.Context) error {
+	exists, err := s.minio.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if err := s.minio.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{}); err != nil {
+		return err
+	}
+	log.Info().Str("bucket", s.bucket).Msg("Created MinIO bucket")
+	return nil
+}
+
+type CreateExportRequest struct {
+	// IncludeMedia copies attachments, emojis and community images into the
+	// archive; otherwise only their URLs are kept. Defaults to true.
+	IncludeMedia *bool `json:"includeMedia"`
+}
+
+type DownloadResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+const exportColumns = `id, community_id, requested_by, status, include_media, archive_size, channel_count, message_count,
+	attachment_count, failed_media_count, error, created_at, updated_at, started_at, finished_at, expires_at`
+
+// CreateExport queues an export of the community. Exports hold every
+// channel's decrypted history, so only administrators may request them.
+func (s *Service) CreateExport(ctx context.Context, communityID, userID uuid.UUID, req *CreateExportRequest) (*models.CommunityExport, error) {
+	if err := s.requireAdmin(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	includeMedia := true
+	if req.IncludeMedia != nil {
+		includeMedia = *req.IncludeMedia
+	}
+
+	var export *models.CommunityExport
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		// Serialise requests for the same community
+		if _, err := tx.Exec(ctx, `SELECT id FROM communities WHERE id = $1 FOR UPDATE`, communityID); err != nil {
+			return err
+		}
+
+		var active bool
+		var lastCreated *time.Time
+		if err := tx.QueryRow(ctx,
+			`SELECT
+				EXISTS(SELECT 1 FROM community_exports WHERE community_id = $1 AND status IN ($2, $3)),
+				(SELECT MAX(created_at) FROM community_exports WHERE community_id = $1 AND status <> $4)`,
+			communityID, models.ExportQueued, models.ExportRunning, models.ExportFailed,
+		).Scan(&active, &lastCreated); err != nil {
+			return err
+		}
+		if active {
+			return ErrExportInProgress
+		}
+		if lastCreated != nil && time.Since(*lastCreated) < exportCooldown {
+			return ErrExportTooSoon
+		}
+
+		exportID := uuid.New()
+		var err error
+		export, err = scanExport(tx.QueryRow(ctx,
+			`INSERT INTO community_exports (id, community_id, requested_by, status, include_media, archive_object)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			RETURNING `+exportColumns,
+			exportID, communityID, userID, models.ExportQueued, includeMedia, fmt.Sprintf("%s/%s.zip", communityID, exportID),
+		))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify()
+	s.publishProgress(ctx, export)
+	return export, nil
+}
+
+// ListExports returns the community's recent exports
+func (s *Service) ListExports(ctx context.Context, communityID, userID uuid.UUID) ([]*models.CommunityExport, error) {
+	if err := s.requireAdmin(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+exportColumns+` FROM community_exports WHERE community_id = $1 ORDER BY created_at DESC LIMIT 20`,
+		communityID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exports := []*models.CommunityExport{}
+	for rows.Next() {
+		export, err := scanExport(rows)
+		if err != nil {
+			return nil, err
+		}
+		exports = append(exports, export)
+	}
+	return exports, rows.Err()
+}
+
+func (s *Service) GetExport(ctx context.Context, exportID, userID uuid.UUID) (*models.CommunityExport, error) {
+	export, _, err := s.accessibleExport(ctx, exportID, userID)
+	return export, err
+}
+
+// GetDownloadURL signs a short-lived link to a finished archive
+func (s *Service) GetDownloadURL(ctx context.Context, exportID, userID uuid.UUID) (*DownloadResponse, error) {
+	export, objectName, err := s.accessibleExport(ctx, exportID, userID)
+	if err != nil {
+		return nil, err
+	}
+	switch export.Status {
+	case models.ExportCompleted:
+	case models.ExportExpired:
+		return nil, ErrExportExpired
+	default:
+		return nil, ErrExportNotReady
+	}
+
+	expiresAt := time.Now().Add(downloadURLExpiry)
+	if export.ExpiresAt != nil && export.ExpiresAt.Before(expiresAt) {
+		if !export.ExpiresAt.After(time.Now()) {
+			return nil, ErrExportExpired
+		}
+		expiresAt = *export.ExpiresAt
+	}
+
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="community-export-%s.zip"`, export.ID))
+	signed, err := s.minio.PresignedGetObject(ctx, s.bucket, objectName, time.Until(expiresAt), params)
+	if err != nil {
+		return nil, err
+	}
+	return &DownloadResponse{URL: signed.String(), ExpiresAt: expiresAt}, nil
+}
+
+// ExpireArchives deletes archives past their retention
+func (s *Service) ExpireArchives(ctx context.Context) (int64, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE community_exports SET status = $1, updated_at = NOW()
+		WHERE status = $2 AND expires_at < NOW()
+		RETURNING archive_object`,
+		models.ExportExpired, models.ExportCompleted,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var objects []string
+	for rows.Next() {
+		var objectName string
+		if err := rows.Scan(&objectName); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		objects = append(objects, objectName)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, objectName := range objects {
+		if err := s.minio.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{}); err != nil {
+			log.Warn().Err(err).Str("object", objectName).Msg("Failed to delete expired export")
+		}
+	}
+	return int64(len(objects)), nil
+}
+
+// accessibleExport loads an export the user may still see: they must be an
+// administrator of its community now, not only when they requested it
+func (s *Service) accessibleExport(ctx context.Context, exportID, userID uuid.UUID) (*models.CommunityExport, string, error) {
+	var objectName string
+	export, err := scanExport(s.db.QueryRow(ctx,
+		`SELECT `+exportColumns+`, archive_object FROM community_exports WHERE id = $1`,
+		exportID,
+	), &objectName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", ErrExportNotFound
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if err := s.requireAdmin(ctx, export.CommunityID, userID); err != nil {
+		return nil, "", ErrExportNotFound
+	}
+	return export, objectName, nil
+}
+
+func (s *Service) requireAdmin(ctx context.Context, communityID, userID uuid.UUID) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(perms, models.PermissionAdministrator) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+// notify wakes the worker so a queued export starts without waiting for the poll
+func (s *Service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publishProgress sends the export to the user who requested it
+func (s *Service) publishProgress(ctx context.Context, export *models.CommunityExport) {
+	payload, err := json.Marshal(map[string]any{
+		"channelId": database.UserStream(export.RequestedBy.String()),
+		"event": map[string]any{
+			"type": "EXPORT_PROGRESS",
+			"data": export,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal export progress")
+		return
+	}
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Msg("Failed to publish export progress")
+	}
+}
+
+// scanExport reads exportColumns, followed by any extra columns into extra
+func scanExport(row pgx.Row, extra ...any) (*models.CommunityExport, error) {
+	e := &models.CommunityExport{}
+	dest := []any{
+		&e.ID, &e.CommunityID, &e.RequestedBy, &e.Status, &e.IncludeMedia, &e.ArchiveSize, &e.ChannelCount, &e.MessageCount,
+		&e.AttachmentCount, &e.FailedMediaCount, &e.Error, &e.CreatedAt, &e.UpdatedAt, &e.StartedAt, &e.FinishedAt, &e.ExpiresAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,100 @@
+package feeds
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// PluginSlug is the slug the feeds plugin is seeded under in the plugins table.
+const PluginSlug = "feeds"
+
+const (
+	DefaultIntervalMinutes = 30
+	MinIntervalMinutes     = 5
+	MaxIntervalMinutes     = 24 * 60
+	MaxFeeds               = 25
+	maxURLLength           = 2048
+)
+
+var ErrInvalidConfig = errors.New("invalid feeds config")
+
+// Config is the per-community feeds config stored in community_plugins.config
+type Config struct {
+	Feeds []FeedConfig `json:"feeds"`
+}
+
+// FeedConfig is one feed and the channel its entries are posted to
+type FeedConfig struct {
+	URL             string    `json:"url"`
+	ChannelID       uuid.UUID `json:"channelId"`
+	IntervalMinutes int       `json:"intervalMinutes,omitempty"`
+}
+
+// ParseConfig decodes a feeds config, filling in defaults for missing fields
+func ParseConfig(raw json.RawMessage) (*Config, error) {
+	cfg := &Config{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	if len(cfg.Feeds) > MaxFeeds {
+		return nil, fmt.Errorf("%w: at most %d feeds", ErrInvalidConfig, MaxFeeds)
+	}
+
+	seen := make(map[string]bool, len(cfg.Feeds))
+	for i := range cfg.Feeds {
+		f := &cfg.Feeds[i]
+		f.URL = strings.TrimSpace(f.URL)
+		if len(f.URL) > maxURLLength {
+			return nil, fmt.Errorf("%w: feed url is too long", ErrInvalidConfig)
+		}
+		parsed, err := url.Parse(f.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.User != nil {
+			return nil, fmt.Errorf("%w: %q is not an http(s) url", ErrInvalidConfig, f.URL)
+		}
+		if f.ChannelID == uuid.Nil {
+			return nil, fmt.Errorf("%w: feed %q needs a channelId", ErrInvalidConfig, f.URL)
+		}
+
+		if f.IntervalMinutes == 0 {
+			f.IntervalMinutes = DefaultIntervalMinutes
+		}
+		if f.IntervalMinutes < MinIntervalMinutes || f.IntervalMinutes > MaxIntervalMinutes {
+			return nil, fmt.Errorf("%w: intervalMinutes must be between %d and %d", ErrInvalidConfig, MinIntervalMinutes, MaxIntervalMinutes)
+		}
+
+		key := f.ChannelID.String() + " " + f.URL
+		if seen[key] {
+			return nil, fmt.Errorf("%w: %q is posted to the same channel twice", ErrInvalidConfig, f.URL)
+		}
+		seen[key] = true
+	}
+
+	return cfg, nil
+}
+
+// ValidateConfig rejects non-http(s) feed URLs, intervals out of range and feeds posted twice to a channel
+func ValidateConfig(raw json.RawMessage) error {
+	_, err := ParseConfig(raw)
+	return err
+}
+
+// ConfigChannels is registered with the plugin service so the member saving
+// a config must be able to send messages in every channel it posts to
+func ConfigChannels(raw json.RawMessage) []uuid.UUID {
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		return nil
+	}
+	channelIDs := make([]uuid.UUID, 0, len(cfg.Feeds))
+	for _, f := range cfg.Feeds {
+		channelIDs = append(channelIDs, f.ChannelID)
+	}
+	return channelIDs
+}
@@ -0,0 +1,179 @@
+package feeds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+var errNotAFeed = errors.New("response is not an RSS or Atom feed")
+
+// entry is one item of a feed, in any of the supported formats
+type entry struct {
+	Key       string // stable identity used for dedupe
+	Title     string
+	Link      string
+	Published time.Time
+}
+
+// xmlFeed covers RSS 2.0 (<rss><channel><item>), RSS 1.0 (<rdf:RDF><item>)
+// and Atom (<feed><entry>)
+type xmlFeed struct {
+	XMLName xml.Name
+	Title   string `xml:"title"`
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+	DCDate  string `xml:"http://purl.org/dc/elements/1.1/ date"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02",
+}
+
+// parseFeed reads a feed and returns its title and entries, oldest first.
+// Relative links are resolved against base.
+func parseFeed(r io.Reader, base *url.URL) (string, []entry, error) {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = charset.NewReaderLabel
+	dec.Strict = false
+
+	var feed xmlFeed
+	if err := dec.Decode(&feed); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errNotAFeed, err)
+	}
+
+	var title string
+	var entries []entry
+	switch strings.ToLower(feed.XMLName.Local) {
+	case "rss", "rdf":
+		title = feed.Channel.Title
+		items := feed.Channel.Items
+		if len(items) == 0 {
+			items = feed.Items
+		}
+		for _, it := range items {
+			e := entry{Title: it.Title, Link: resolve(base, it.Link), Published: parseDate(it.PubDate, it.DCDate)}
+			e.Key = entryKey(it.GUID, e.Link, it.Title+"|"+it.PubDate)
+			entries = append(entries, e)
+		}
+	case "feed":
+		title = feed.Title
+		for _, it := range feed.Entries {
+			e := entry{Title: it.Title, Link: resolve(base, atomHref(it.Links)), Published: parseDate(it.Published, it.Updated)}
+			e.Key = entryKey(it.ID, e.Link, it.Title+"|"+it.Updated)
+			entries = append(entries, e)
+		}
+	default:
+		return "", nil, errNotAFeed
+	}
+
+	// Feeds are usually newest first but not always; post in publication order
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Published.Before(entries[j].Published)
+	})
+	return clean(title), entries, nil
+}
+
+// atomHref picks the entry's alternate link
+func atomHref(links []atomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return strings.TrimSpace(l.Href)
+		}
+	}
+	if len(links) > 0 {
+		return strings.TrimSpace(links[0].Href)
+	}
+	return ""
+}
+
+// resolve returns an absolute http(s) link, or "" for anything else
+func resolve(base *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return ""
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+func parseDate(values ...string) time.Time {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		for _, layout := range dateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// entryKey hashes the first non-empty identity so keys fit a fixed column
+func entryKey(candidates ...string) string {
+	for _, c := range candidates {
+		c = strings.TrimSpace(c)
+		if c != "" && c != "|" {
+			sum := sha256.Sum256([]byte(c))
+			return hex.EncodeToString(sum[:])
+		}
+	}
+	return ""
+}
+
+// clean collapses whitespace so titles fit on one line
+func clean(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,596 @@
+package feeds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+const (
+	pollInterval = 30 * time.Second
+	jobLease     = 2 * time.Minute
+	claimBatch   = 10
+
+	fetchTimeout = 15 * time.Second
+	maxFeedBytes = 2 * 1024 * 1024
+	maxRedirects = 5
+
+	// A feed that gained many entries at once only posts the newest few
+	maxPostsPerPoll = 5
+	maxTitleLength  = 300
+
+	// After this many failed polls in a row an alert goes to the plugin audit log
+	failureAlertThreshold = 3
+	maxBackoff            = 24 * time.Hour
+
+	// Entries that left the feed this long ago are forgotten
+	entryRetention = 30 * 24 * time.Hour
+)
+
+// BotUserID is the system account entries are posted as (seeded by migration 000033).
+var BotUserID = uuid.MustParse("fee0d500-0000-4000-8000-000000000001")
+
+var errChannelGone = errors.New("the configured channel no longer exists or is archived")
+
+// MessagePoster posts entries as the feeds account
+type MessagePoster interface {
+	CreateBotMessage(ctx context.Context, m *message.BotMessage) (*message.MessageResponse, error)
+}
+
+type Service struct {
+	db         *pgxpool.Pool
+	messages   MessagePoster
+	client     *http.Client
+	instanceID uuid.UUID
+}
+
+func NewService(db *pgxpool.Pool, messages MessagePoster) *Service {
+	s := &Service{
+		db:         db,
+		messages:   messages,
+		instanceID: uuid.New(),
+	}
+	s.client = &http.Client{
+		Timeout: fetchTimeout,
+		// The address is checked when it's dialed, so a host can't resolve
+		// to a public address for the check and a private one for the fetch
+		Transport: messaging.PublicTransport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return messaging.ValidatePublicHost(req.Context(), req.URL.Hostname())
+		},
+	}
+	return s
+}
+
+// subscription is a claimed feed_subscriptions row
+type subscription struct {
+	ID                  uuid.UUID
+	CommunityID         uuid.UUID
+	ChannelID           uuid.UUID
+	PluginID            uuid.UUID
+	URL                 string
+	IntervalMinutes     int
+	FeedTitle           *string
+	ETag                *string
+	LastModified        *string
+	LastSuccessAt       *time.Time
+	ConsecutiveFailures int
+	Alerted             bool
+}
+
+// Run keeps feed_subscriptions in step with the plugin configs and polls
+// feeds that are due. Every gateway instance runs this; leases keep a feed
+// from being polled twice.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.syncSubscriptions(ctx); err != nil {
+			log.Warn().Err(err).Msg("Failed to sync feed subscriptions")
+		}
+		for {
+			subs, err := s.claim(ctx)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to claim feeds")
+				break
+			}
+			for _, sub := range subs {
+				s.poll(ctx, sub)
+			}
+			if len(subs) < claimBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// syncSubscriptions creates rows for newly configured feeds and drops rows for
+// feeds removed from a config or communities that uninstalled the plugin
+func (s *Service) syncSubscriptions(ctx context.Context) error {
+	rows, err := s.db.Query(ctx,
+		`SELECT cp.community_id, cp.config
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 WHERE p.slug = $1`,
+		PluginSlug,
+	)
+	if err != nil {
+		return err
+	}
+	configs := make(map[uuid.UUID]*Config)
+	for rows.Next() {
+		var communityID uuid.UUID
+		var raw json.RawMessage
+		if err := rows.Scan(&communityID, &raw); err != nil {
+			rows.Close()
+			return err
+		}
+		cfg, err := ParseConfig(raw)
+		if err != nil {
+			// Configs are validated on save; leave existing rows alone rather than dropping them
+			log.Warn().Err(err).Str("communityId", communityID.String()).Msg("Ignoring invalid feeds config")
+			continue
+		}
+		configs[communityID] = cfg
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(ctx,
+		`DELETE FROM feed_subscriptions fs
+		 WHERE NOT EXISTS (
+			SELECT 1 FROM community_plugins cp
+			JOIN plugins p ON p.id = cp.plugin_id
+			WHERE cp.community_id = fs.community_id AND p.slug = $1
+		 )`,
+		PluginSlug,
+	); err != nil {
+		return err
+	}
+
+	for communityID, cfg := range configs {
+		channelIDs := make([]uuid.UUID, 0, len(cfg.Feeds))
+		urls := make([]string, 0, len(cfg.Feeds))
+		for _, f := range cfg.Feeds {
+			if _, err := s.db.Exec(ctx,
+				`INSERT INTO feed_subscriptions (community_id, channel_id, url, interval_minutes)
+				 VALUES ($1, $2, $3, $4)
+				 ON CONFLICT (community_id, channel_id, url) DO UPDATE
+				 SET interval_minutes = EXCLUDED.interval_minutes, updated_at = NOW()
+				 WHERE feed_subscriptions.interval_minutes <> EXCLUDED.interval_minutes`,
+				communityID, f.ChannelID, f.URL, f.IntervalMinutes,
+			); err != nil {
+				return err
+			}
+			channelIDs = append(channelIDs, f.ChannelID)
+			urls = append(urls, f.URL)
+		}
+
+		if _, err := s.db.Exec(ctx,
+			`DELETE FROM feed_subscriptions fs
+			 WHERE fs.community_id = $1
+			   AND NOT EXISTS (
+				SELECT 1 FROM unnest($2::uuid[], $3::text[]) AS f(channel_id, url)
+				WHERE f.channel_id = fs.channel_id AND f.url = fs.url
+			   )`,
+			communityID, channelIDs, urls,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claim leases feeds that are due on communities where the plugin is enabled
+// and allowed to post
+func (s *Service) claim(ctx context.Context) ([]*subscription, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE feed_subscriptions fs
+		 SET lease_owner = $1, lease_until = NOW() + $2::interval
+		 FROM (
+			SELECT f.id, cp.plugin_id
+			FROM feed_subscriptions f
+			JOIN community_plugins cp ON cp.community_id = f.community_id
+			JOIN plugins p ON p.id = cp.plugin_id AND p.slug = $3
+			WHERE cp.enabled = TRUE
+			  AND cp.granted_permissions & $4 <> 0
+			  AND f.next_poll_at <= NOW()
+			  AND (f.lease_until IS NULL OR f.lease_until < NOW())
+			ORDER BY f.next_poll_at
+			LIMIT $5
+			FOR UPDATE OF f SKIP LOCKED
+		 ) due
+		 WHERE fs.id = due.id
+		 RETURNING fs.id, fs.community_id, fs.channel_id, due.plugin_id, fs.url, fs.interval_minutes, fs.feed_title,
+			fs.etag, fs.last_modified, fs.last_success_at, fs.consecutive_failures, fs.alerted`,
+		s.instanceID, fmt.Sprintf("%d seconds", int(jobLease.Seconds())), PluginSlug, models.PluginPermSendMessages, claimBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []*subscription
+	for rows.Next() {
+		sub := &subscription{}
+		if err := rows.Scan(
+			&sub.ID, &sub.CommunityID, &sub.ChannelID, &sub.PluginID, &sub.URL, &sub.IntervalMinutes, &sub.FeedTitle,
+			&sub.ETag, &sub.LastModified, &sub.LastSuccessAt, &sub.ConsecutiveFailures, &sub.Alerted,
+		); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// poll fetches one feed and posts the entries it has not seen before
+func (s *Service) poll(ctx context.Context, sub *subscription) {
+	result, err := s.fetch(ctx, sub)
+	if err == nil && result != nil {
+		err = s.publishNew(ctx, sub, result)
+	}
+	if err != nil {
+		s.recordFailure(ctx, sub, err)
+		return
+	}
+	s.recordSuccess(ctx, sub, result)
+}
+
+type fetchResult struct {
+	title        string
+	entries      []entry
+	etag         string
+	lastModified string
+}
+
+// fetch downloads and parses the feed. A nil result means it has not changed.
+func (s *Service) fetch(ctx context.Context, sub *subscription) (*fetchResult, error) {
+	parsed, err := url.Parse(sub.URL)
+	if err != nil {
+		return nil, err
+	}
+	// The host is checked on every poll since DNS may have changed
+	if err := messaging.ValidatePublicHost(ctx, parsed.Hostname()); err != nil {
+		return nil, fmt.Errorf("refusing to fetch: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ZentraFeeds/1.0")
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.8, */*;q=0.5")
+	if sub.ETag != nil {
+		req.Header.Set("If-None-Match", *sub.ETag)
+	}
+	if sub.LastModified != nil {
+		req.Header.Set("If-Modified-Since", *sub.LastModified)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("feed responded with %d", resp.StatusCode)
+	}
+
+	title, entries, err := parseFeed(io.LimitReader(resp.Body, maxFeedBytes), resp.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	return &fetchResult{
+		title:        title,
+		entries:      entries,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// publishNew posts entries missing from feed_entries. On the first successful
+// poll everything is only recorded, so subscribing doesn't flood the channel.
+func (s *Service) publishNew(ctx context.Context, sub *subscription, result *fetchResult) error {
+	keys := make([]string, 0, len(result.entries))
+	for _, e := range result.entries {
+		if e.Key != "" {
+			keys = append(keys, e.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`UPDATE feed_entries SET last_seen_at = NOW()
+		 WHERE subscription_id = $1 AND entry_key = ANY($2)
+		 RETURNING entry_key`,
+		sub.ID, keys,
+	)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		seen[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var fresh []entry
+	for _, e := range result.entries {
+		if e.Key != "" && !seen[e.Key] {
+			seen[e.Key] = true // feeds sometimes repeat an item
+			fresh = append(fresh, e)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	post := fresh
+	if sub.LastSuccessAt == nil {
+		post = nil
+	} else if len(post) > maxPostsPerPoll {
+		post = post[len(post)-maxPostsPerPoll:]
+	}
+	skipped := fresh[:len(fresh)-len(post)]
+	if len(skipped) > 0 {
+		skippedKeys := make([]string, len(skipped))
+		for i, e := range skipped {
+			skippedKeys[i] = e.Key
+		}
+		if _, err := s.db.Exec(ctx,
+			`INSERT INTO feed_entries (subscription_id, entry_key)
+			 SELECT $1, unnest($2::text[])
+			 ON CONFLICT DO NOTHING`,
+			sub.ID, skippedKeys,
+		); err != nil {
+			return err
+		}
+	}
+
+	feedTitle := result.title
+	if feedTitle == "" && sub.FeedTitle != nil {
+		feedTitle = *sub.FeedTitle
+	}
+	for _, e := range post {
+		if err := s.postEntry(ctx, sub, feedTitle, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postEntry posts one entry as the feeds account. The entry is claimed in
+// feed_entries first so it is posted at most once; the claim is released
+// if the message couldn't be stored.
+func (s *Service) postEntry(ctx context.Context, sub *subscription, feedTitle string, e entry) error {
+	messageID, now := messaging.NewMessageID()
+	tag, err := s.db.Exec(ctx,
+		`INSERT INTO feed_entries (subscription_id, entry_key, message_id)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT DO NOTHING`,
+		sub.ID, e.Key, messageID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	_, err = s.messages.CreateBotMessage(ctx, &message.BotMessage{
+		ID:          messageID,
+		CreatedAt:   now,
+		ChannelID:   sub.ChannelID,
+		CommunityID: sub.CommunityID,
+		AuthorID:    BotUserID,
+		Content:     buildContent(feedTitle, e),
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, message.ErrBlockedByAutoMod), errors.Is(err, message.ErrRemovedByAutoMod):
+		// AutoMod dealt with the entry; posting it again next poll would only repeat that
+		return nil
+	}
+
+	if _, releaseErr := s.db.Exec(ctx,
+		`DELETE FROM feed_entries WHERE subscription_id = $1 AND entry_key = $2 AND message_id = $3`,
+		sub.ID, e.Key, messageID,
+	); releaseErr != nil {
+		log.Warn().Err(releaseErr).Str("feed", sub.URL).Msg("Failed to release feed entry")
+	}
+	if errors.Is(err, message.ErrChannelUnavailable) {
+		return errChannelGone
+	}
+	return fmt.Errorf("post feed entry: %w", err)
+}
+
+// buildContent renders an entry: the feed's name, the entry title and its link.
+// The link comes last on its own line so it gets the link preview.
+func buildContent(feedTitle string, e entry) string {
+	title := clean(e.Title)
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength]) + "…"
+	}
+
+	var b strings.Builder
+	if feedTitle != "" {
+		if runes := []rune(feedTitle); len(runes) > maxTitleLength {
+			feedTitle = string(runes[:maxTitleLength]) + "…"
+		}
+		fmt.Fprintf(&b, "📰 **%s**\n", feedTitle)
+	}
+	if title != "" {
+		b.WriteString(title)
+		b.WriteString("\n")
+	}
+	if e.Link != "" {
+		b.WriteString(e.Link)
+	}
+	if b.Len() == 0 {
+		return "New feed entry"
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func (s *Service) recordSuccess(ctx context.Context, sub *subscription, result *fetchResult) {
+	var title, etag, lastModified *string
+	if result != nil {
+		if result.title != "" {
+			t := result.title
+			if runes := []rune(t); len(runes) > 256 {
+				t = string(runes[:256])
+			}
+			title = &t
+		}
+		etag = nonEmpty(result.etag)
+		lastModified = nonEmpty(result.lastModified)
+	}
+
+	_, err := s.db.Exec(ctx,
+		`UPDATE feed_subscriptions SET
+			feed_title = COALESCE($2, feed_title),
+			etag = CASE WHEN $5 THEN $3 ELSE etag END,
+			last_modified = CASE WHEN $5 THEN $4 ELSE last_modified END,
+			last_polled_at = NOW(),
+			last_success_at = NOW(),
+			next_poll_at = NOW() + make_interval(mins => interval_minutes),
+			consecutive_failures = 0,
+			last_error = NULL,
+			alerted = FALSE,
+			lease_owner = NULL,
+			lease_until = NULL,
+			updated_at = NOW()
+		 WHERE id = $1`,
+		sub.ID, title, etag, lastModified, result != nil,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("feed", sub.URL).Msg("Failed to record feed poll")
+		return
+	}
+
+	if sub.Alerted {
+		s.logAction(ctx, sub, "feed_recovered", map[string]any{
+			"url":       sub.URL,
+			"channelId": sub.ChannelID,
+		})
+	}
+}
+
+// recordFailure backs off exponentially and, once a feed keeps failing,
+// writes an alert to the plugin audit log
+func (s *Service) recordFailure(ctx context.Context, sub *subscription, cause error) {
+	failures := sub.ConsecutiveFailures + 1
+	backoff := time.Duration(sub.IntervalMinutes) * time.Minute
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	alert := failures >= failureAlertThreshold && !sub.Alerted
+
+	message := cause.Error()
+	if len(message) > 500 {
+		message = message[:500]
+	}
+	_, err := s.db.Exec(ctx,
+		`UPDATE feed_subscriptions SET
+			last_polled_at = NOW(),
+			next_poll_at = NOW() + $2::interval,
+			consecutive_failures = $3,
+			last_error = $4,
+			alerted = alerted OR $5,
+			lease_owner = NULL,
+			lease_until = NULL,
+			updated_at = NOW()
+		 WHERE id = $1`,
+		sub.ID, fmt.Sprintf("%d seconds", int(backoff.Seconds())), failures, message, alert,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("feed", sub.URL).Msg("Failed to record feed failure")
+	}
+	log.Debug().Err(cause).Str("feed", sub.URL).Int("failures", failures).Msg("Feed poll failed")
+
+	if alert {
+		s.logAction(ctx, sub, "feed_failing", map[string]any{
+			"url":       sub.URL,
+			"channelId": sub.ChannelID,
+			"failures":  failures,
+			"error":     message,
+			"nextPoll":  time.Now().Add(backoff),
+		})
+	}
+}
+
+// PruneEntries forgets entries that have been out of their feed for a while
+func (s *Service) PruneEntries(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM feed_entries WHERE last_seen_at < $1`,
+		time.Now().Add(-entryRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// logAction writes to the plugin audit log, where community admins see plugin activity
+func (s *Service) logAction(ctx context.Context, sub *subscription, action string, details map[string]any) {
+	detailsJSON, _ := json.Marshal(details)
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO plugin_audit_log (community_id, plugin_id, actor_id, action, details)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		sub.CommunityID, sub.PluginID, BotUserID, action, detailsJSON,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("action", action).Msg("Failed to log feeds action")
+	}
+}
+
+func nonEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
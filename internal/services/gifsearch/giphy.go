@@ -0,0 +1,101 @@
+package gifsearch
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const giphyAPIBase = "https://api.giphy.com/v1/gifs"
+
+type giphyProvider struct {
+	client *http.Client
+	apiKey string
+	rating string
+}
+
+type giphyImage struct {
+	URL    string `json:"url"`
+	MP4    string `json:"mp4"`
+	Width  string `json:"width"`
+	Height string `json:"height"`
+}
+
+type giphyResponse struct {
+	Data []struct {
+		ID     string                `json:"id"`
+		Title  string                `json:"title"`
+		URL    string                `json:"url"`
+		Images map[string]giphyImage `json:"images"`
+	} `json:"data"`
+	Pagination struct {
+		TotalCount int `json:"total_count"`
+		Count      int `json:"count"`
+		Offset     int `json:"offset"`
+	} `json:"pagination"`
+}
+
+func (p *giphyProvider) name() string { return "giphy" }
+
+func (p *giphyProvider) search(ctx context.Context, query string, limit int, pos string) (*Page, error) {
+	params := p.params(limit, pos)
+	params.Set("q", query)
+	return p.fetch(ctx, "/search", params)
+}
+
+func (p *giphyProvider) trending(ctx context.Context, limit int, pos string) (*Page, error) {
+	return p.fetch(ctx, "/trending", p.params(limit, pos))
+}
+
+// params maps pos onto GIPHY's numeric offset
+func (p *giphyProvider) params(limit int, pos string) url.Values {
+	params := url.Values{}
+	params.Set("api_key", p.apiKey)
+	params.Set("limit", strconv.Itoa(limit))
+	if p.rating != "" {
+		params.Set("rating", p.rating)
+	}
+	if offset, err := strconv.Atoi(pos); err == nil && offset > 0 {
+		params.Set("offset", strconv.Itoa(offset))
+	}
+	return params
+}
+
+func (p *giphyProvider) fetch(ctx context.Context, path string, params url.Values) (*Page, error) {
+	var resp giphyResponse
+	if err := getJSON(ctx, p.client, giphyAPIBase+path+"?"+params.Encode(), &resp); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Provider: p.name(), Results: make([]Result, 0, len(resp.Data))}
+	if next := resp.Pagination.Offset + resp.Pagination.Count; resp.Pagination.Count > 0 && next < resp.Pagination.TotalCount {
+		page.Next = strconv.Itoa(next)
+	}
+	for _, item := range resp.Data {
+		original, ok := item.Images["original"]
+		if !ok || original.URL == "" {
+			continue
+		}
+		result := Result{
+			ID:          item.ID,
+			Title:       item.Title,
+			URL:         original.URL,
+			PreviewURL:  original.URL,
+			MP4URL:      original.MP4,
+			ProviderURL: item.URL,
+		}
+		result.Width, _ = strconv.Atoi(original.Width)
+		result.Height, _ = strconv.Atoi(original.Height)
+		if preview, ok := item.Images["fixed_width_small"]; ok && preview.URL != "" {
+			result.PreviewURL = preview.URL
+		}
+		page.Results = append(page.Results, result)
+	}
+	return page, nil
+}
+
+func (p *giphyProvider) allowsMediaHost(host string) bool {
+	return host == "giphy.com" || strings.HasSuffix(host, ".giphy.com")
+}
@@ -0,0 +1,95 @@
+package gifsearch
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes(secret string) chi.Router {
+	r := chi.NewRouter()
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(secret))
+		r.Get("/search", h.Search)
+		r.Get("/trending", h.Trending)
+	})
+
+	// Loaded by <img> and <video> tags, which can't send a token; only signed
+	// links from search results are proxied
+	r.Get("/media", h.Media)
+
+	return r
+}
+
+// Search proxies a GIF search to the configured provider
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if len(q.Get("q")) > 256 {
+		utils.RespondError(w, http.StatusBadRequest, "Query too long")
+		return
+	}
+
+	page, err := h.service.Search(r.Context(), q.Get("q"), utils.GetQueryInt(r, "limit", DefaultResults), q.Get("pos"))
+	if err != nil {
+		respondGIFError(w, err, "Failed to search GIFs")
+		return
+	}
+
+	utils.RespondSuccess(w, page)
+}
+
+// Trending lists the provider's popular GIFs
+func (h *Handler) Trending(w http.ResponseWriter, r *http.Request) {
+	page, err := h.service.Trending(r.Context(), utils.GetQueryInt(r, "limit", DefaultResults), r.URL.Query().Get("pos"))
+	if err != nil {
+		respondGIFError(w, err, "Failed to fetch trending GIFs")
+		return
+	}
+
+	utils.RespondSuccess(w, page)
+}
+
+// Media streams a provider GIF or video to the client
+func (h *Handler) Media(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	media, err := h.service.FetchMedia(r.Context(), query.Get("url"), query.Get("sig"))
+	if err != nil {
+		respondGIFError(w, err, "Failed to fetch GIF")
+		return
+	}
+	defer media.Body.Close()
+
+	mediaHeaders(w, media)
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, media.Body)
+}
+
+func respondGIFError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrNotConfigured):
+		utils.RespondError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, ErrInvalidQuery), errors.Is(err, ErrMediaNotAllowed):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrInvalidMediaLink):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrMediaTooLarge):
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, ErrProviderFailed):
+		utils.RespondError(w, http.StatusBadGateway, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,33 @@
+package gifsearch
+
+import (
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	page := []openapi.Param{
+		{Name: "limit", Type: "integer", Description: "At most this many, 24 by default and up to 50"},
+		{Name: "pos", Description: "next of the previous page; omit for the first page"},
+	}
+
+	openapi.Describe((*Handler).Search, openapi.Operation{
+		Summary:  "Search GIFs",
+		Query:    append([]openapi.Param{{Name: "q", Description: "Search text, at most 256 characters"}}, page...),
+		Response: Page{},
+	})
+	openapi.Describe((*Handler).Trending, openapi.Operation{
+		Summary:  "List trending GIFs",
+		Query:    page,
+		Response: Page{},
+	})
+	openapi.Describe((*Handler).Media, openapi.Operation{
+		Summary:     "Stream a GIF or video from the provider",
+		Description: "Loaded by img and video tags. Only signed links from search results are proxied, and only from the provider's media hosts.",
+		Query: []openapi.Param{
+			{Name: "url", Required: true, Format: "uri", Description: "Media URL from a search result"},
+			{Name: "sig", Required: true, Description: "Signature from the same link"},
+		},
+		Shape:        openapi.Binary,
+		ResponseType: "image/*",
+	})
+}
@@ -0,0 +1,263 @@
+package gifsearch
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+const (
+	cacheKeyPrefix       = "gifsearch:"
+	maxQueryLength       = 64
+	DefaultResults       = 24
+	MaxResults           = 50
+	providerTimeout      = 5 * time.Second
+	providerResponseSize = 2 * 1024 * 1024
+)
+
+var (
+	ErrNotConfigured  = errors.New("GIF search is not configured")
+	ErrInvalidQuery   = errors.New("search query must contain letters or numbers")
+	ErrProviderFailed = errors.New("GIF provider request failed")
+)
+
+// Result is a GIF normalized across providers. Media URLs point at this
+// server's media proxy rather than the provider.
+type Result struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	URL         string `json:"url"`
+	PreviewURL  string `json:"previewUrl"`
+	MP4URL      string `json:"mp4Url,omitempty"`
+	ProviderURL string `json:"providerUrl,omitempty"`
+}
+
+// Page is one page of results. Next is passed back as pos for the next page
+// and is empty on the last one.
+type Page struct {
+	Provider string   `json:"provider"`
+	Results  []Result `json:"results"`
+	Next     string   `json:"next,omitempty"`
+}
+
+// provider fetches raw results; media URLs are still the provider's own
+type provider interface {
+	name() string
+	search(ctx context.Context, query string, limit int, pos string) (*Page, error)
+	trending(ctx context.Context, limit int, pos string) (*Page, error)
+	// allowsMediaHost reports whether the media proxy may fetch from host
+	allowsMediaHost(host string) bool
+}
+
+type Service struct {
+	redis        *redis.Client
+	provider     provider
+	httpClient   *http.Client
+	mediaClient  *http.Client
+	cacheTTL     time.Duration
+	mediaBaseURL string
+	mediaSecret  []byte
+}
+
+// NewService builds the GIF search proxy. An empty apiKey leaves search
+// disabled. Media URLs in results point at the media proxy at mediaBaseURL
+// and are signed with mediaSecret; without one, a random secret is used and
+// the links only work on this instance until it restarts.
+func NewService(redisClient *redis.Client, providerName, apiKey, contentFilter string, cacheTTL time.Duration, mediaBaseURL, mediaSecret string) *Service {
+	httpClient := &http.Client{Timeout: providerTimeout}
+	s := &Service{
+		redis:        redisClient,
+		httpClient:   httpClient,
+		cacheTTL:     cacheTTL,
+		mediaBaseURL: strings.TrimRight(mediaBaseURL, "/"),
+		mediaSecret:  []byte(mediaSecret),
+	}
+	s.mediaClient = &http.Client{
+		Timeout:   mediaTimeout,
+		Transport: messaging.PublicTransport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return s.checkMediaURL(req.URL.String())
+		},
+	}
+	if apiKey == "" {
+		return s
+	}
+	if mediaSecret == "" {
+		log.Warn().Msg("GIF_MEDIA_SECRET is not set; GIF links only work on this instance until it restarts")
+		s.mediaSecret = make([]byte, 32)
+		if _, err := rand.Read(s.mediaSecret); err != nil {
+			log.Fatal().Err(err).Msg("Failed to generate GIF media secret")
+		}
+	}
+
+	switch providerName {
+	case "tenor", "":
+		s.provider = &tenorProvider{client: httpClient, apiKey: apiKey, contentFilter: contentFilter}
+	case "giphy":
+		s.provider = &giphyProvider{client: httpClient, apiKey: apiKey, rating: contentFilter}
+	default:
+		log.Warn().Str("provider", providerName).Msg("Unknown GIF provider, GIF search disabled")
+	}
+	return s
+}
+
+// Search finds GIFs matching query
+func (s *Service) Search(ctx context.Context, query string, limit int, pos string) (*Page, error) {
+	if s.provider == nil {
+		return nil, ErrNotConfigured
+	}
+	query = SanitizeQuery(query)
+	if query == "" {
+		return nil, ErrInvalidQuery
+	}
+	limit = clampLimit(limit)
+
+	return s.cached(ctx, "search", []string{query, fmt.Sprint(limit), pos}, func() (*Page, error) {
+		return s.provider.search(ctx, query, limit, pos)
+	})
+}
+
+// Trending lists the provider's currently popular GIFs
+func (s *Service) Trending(ctx context.Context, limit int, pos string) (*Page, error) {
+	if s.provider == nil {
+		return nil, ErrNotConfigured
+	}
+	limit = clampLimit(limit)
+
+	return s.cached(ctx, "trending", []string{fmt.Sprint(limit), pos}, func() (*Page, error) {
+		return s.provider.trending(ctx, limit, pos)
+	})
+}
+
+// SanitizeQuery keeps letters, numbers and single spaces, lowercased and cut
+// to 64 characters, so odd input never reaches the provider or the cache key
+func SanitizeQuery(query string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(query) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			space = true
+		}
+	}
+
+	sanitized := []rune(b.String())
+	if len(sanitized) > maxQueryLength {
+		sanitized = sanitized[:maxQueryLength]
+	}
+	return strings.TrimSpace(string(sanitized))
+}
+
+func clampLimit(limit int) int {
+	if limit < 1 {
+		return DefaultResults
+	}
+	if limit > MaxResults {
+		return MaxResults
+	}
+	return limit
+}
+
+// cached returns a page from Redis, or fetches and stores it. Results are
+// cached with the provider's URLs and rewritten on the way out.
+func (s *Service) cached(ctx context.Context, kind string, parts []string, fetch func() (*Page, error)) (*Page, error) {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	key := cacheKeyPrefix + s.provider.name() + ":" + kind + ":" + hex.EncodeToString(sum[:])
+
+	var page *Page
+	if raw, err := s.redis.Get(ctx, key).Bytes(); err == nil {
+		if err := json.Unmarshal(raw, &page); err != nil {
+			page = nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		log.Warn().Err(err).Msg("Failed to read GIF search cache")
+	}
+
+	if page == nil {
+		fetched, err := fetch()
+		if err != nil {
+			log.Warn().Err(err).Str("provider", s.provider.name()).Msg("GIF provider request failed")
+			return nil, ErrProviderFailed
+		}
+		page = fetched
+		if raw, err := json.Marshal(page); err == nil {
+			if err := s.redis.Set(ctx, key, raw, s.cacheTTL).Err(); err != nil {
+				log.Warn().Err(err).Msg("Failed to write GIF search cache")
+			}
+		}
+	}
+
+	out := &Page{Provider: page.Provider, Next: page.Next, Results: make([]Result, 0, len(page.Results))}
+	for _, r := range page.Results {
+		r.URL = s.proxyURL(r.URL)
+		r.PreviewURL = s.proxyURL(r.PreviewURL)
+		r.MP4URL = s.proxyURL(r.MP4URL)
+		out.Results = append(out.Results, r)
+	}
+	return out, nil
+}
+
+func (s *Service) proxyURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	return s.mediaBaseURL + "?url=" + url.QueryEscape(raw) + "&sig=" + s.mediaSignature(raw)
+}
+
+// mediaSignature lets the media proxy tell links from search results apart
+// from URLs someone made up. Links end up in messages, so they don't expire.
+func (s *Service) mediaSignature(raw string) string {
+	mac := hmac.New(sha256.New, s.mediaSecret)
+	mac.Write([]byte(raw))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// getJSON fetches a provider API URL into out without forwarding anything
+// about the user who searched
+func getJSON(ctx context.Context, client *http.Client, endpoint string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "zentra-server-gif-search")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("provider returned %s", resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, providerResponseSize)).Decode(out)
+}
@@ -0,0 +1,100 @@
+package gifsearch
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const tenorAPIBase = "https://tenor.googleapis.com/v2"
+
+type tenorProvider struct {
+	client        *http.Client
+	apiKey        string
+	contentFilter string
+}
+
+type tenorMedia struct {
+	URL  string `json:"url"`
+	Dims []int  `json:"dims"`
+}
+
+type tenorResponse struct {
+	Results []struct {
+		ID                 string                `json:"id"`
+		Title              string                `json:"title"`
+		ContentDescription string                `json:"content_description"`
+		ItemURL            string                `json:"itemurl"`
+		MediaFormats       map[string]tenorMedia `json:"media_formats"`
+	} `json:"results"`
+	Next string `json:"next"`
+}
+
+func (p *tenorProvider) name() string { return "tenor" }
+
+func (p *tenorProvider) search(ctx context.Context, query string, limit int, pos string) (*Page, error) {
+	params := p.params(limit, pos)
+	params.Set("q", query)
+	return p.fetch(ctx, "/search", params)
+}
+
+func (p *tenorProvider) trending(ctx context.Context, limit int, pos string) (*Page, error) {
+	return p.fetch(ctx, "/featured", p.params(limit, pos))
+}
+
+func (p *tenorProvider) params(limit int, pos string) url.Values {
+	params := url.Values{}
+	params.Set("key", p.apiKey)
+	params.Set("client_key", "zentra")
+	params.Set("limit", strconv.Itoa(limit))
+	params.Set("media_filter", "gif,tinygif,mp4")
+	if p.contentFilter != "" {
+		params.Set("contentfilter", p.contentFilter)
+	}
+	if pos != "" {
+		params.Set("pos", pos)
+	}
+	return params
+}
+
+func (p *tenorProvider) fetch(ctx context.Context, path string, params url.Values) (*Page, error) {
+	var resp tenorResponse
+	if err := getJSON(ctx, p.client, tenorAPIBase+path+"?"+params.Encode(), &resp); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Provider: p.name(), Next: resp.Next, Results: make([]Result, 0, len(resp.Results))}
+	for _, item := range resp.Results {
+		gif, ok := item.MediaFormats["gif"]
+		if !ok || gif.URL == "" {
+			continue
+		}
+		result := Result{
+			ID:          item.ID,
+			Title:       item.Title,
+			URL:         gif.URL,
+			PreviewURL:  gif.URL,
+			ProviderURL: item.ItemURL,
+		}
+		if result.Title == "" {
+			result.Title = item.ContentDescription
+		}
+		if len(gif.Dims) == 2 {
+			result.Width, result.Height = gif.Dims[0], gif.Dims[1]
+		}
+		if tiny, ok := item.MediaFormats["tinygif"]; ok && tiny.URL != "" {
+			result.PreviewURL = tiny.URL
+		}
+		if mp4, ok := item.MediaFormats["mp4"]; ok {
+			result.MP4URL = mp4.URL
+		}
+		page.Results = append(page.Results, result)
+	}
+	return page, nil
+}
+
+func (p *tenorProvider) allowsMediaHost(host string) bool {
+	return strings.HasSuffix(host, ".tenor.com")
+}
@@ -0,0 +1,157 @@
+package githooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// PluginSlug is the slug the GitHub/GitLab plugin is seeded under in the plugins table.
+const PluginSlug = "git"
+
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// Event kinds a route can select. GitLab merge requests are reported as
+// pull_request so one route covers both providers.
+const (
+	EventPush        = "push"
+	EventPullRequest = "pull_request"
+	EventIssues      = "issues"
+	EventRelease     = "release"
+)
+
+const (
+	MaxRoutes          = 25
+	MaxRepositoryRules = 50
+	maxRepositoryRule  = 200
+)
+
+var ErrInvalidConfig = errors.New("invalid git config")
+
+var validEvents = map[string]bool{
+	EventPush:        true,
+	EventPullRequest: true,
+	EventIssues:      true,
+	EventRelease:     true,
+}
+
+// Config is the per-community config stored in community_plugins.config
+type Config struct {
+	Routes []RouteConfig `json:"routes"`
+}
+
+// RouteConfig sends matching events to a channel. Empty filters match
+// everything.
+type RouteConfig struct {
+	ChannelID uuid.UUID `json:"channelId"`
+	Provider  string    `json:"provider,omitempty"`
+	// Repositories are owner/name paths; * matches one path segment, so
+	// "acme/*" covers every repository of acme
+	Repositories []string `json:"repositories,omitempty"`
+	Events       []string `json:"events,omitempty"`
+}
+
+// ParseConfig decodes a git config, normalising filters for matching
+func ParseConfig(raw json.RawMessage) (*Config, error) {
+	cfg := &Config{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	if len(cfg.Routes) > MaxRoutes {
+		return nil, fmt.Errorf("%w: at most %d routes", ErrInvalidConfig, MaxRoutes)
+	}
+
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		if route.ChannelID == uuid.Nil {
+			return nil, fmt.Errorf("%w: every route needs a channelId", ErrInvalidConfig)
+		}
+
+		route.Provider = strings.ToLower(strings.TrimSpace(route.Provider))
+		if route.Provider != "" && route.Provider != ProviderGitHub && route.Provider != ProviderGitLab {
+			return nil, fmt.Errorf("%w: provider must be %q or %q", ErrInvalidConfig, ProviderGitHub, ProviderGitLab)
+		}
+
+		if len(route.Repositories) > MaxRepositoryRules {
+			return nil, fmt.Errorf("%w: at most %d repositories per route", ErrInvalidConfig, MaxRepositoryRules)
+		}
+		for j, repo := range route.Repositories {
+			repo = strings.ToLower(strings.Trim(strings.TrimSpace(repo), "/"))
+			if repo == "" || len(repo) > maxRepositoryRule || !strings.Contains(repo, "/") {
+				return nil, fmt.Errorf("%w: %q is not an owner/name repository", ErrInvalidConfig, route.Repositories[j])
+			}
+			if _, err := path.Match(repo, ""); err != nil {
+				return nil, fmt.Errorf("%w: bad repository pattern %q", ErrInvalidConfig, route.Repositories[j])
+			}
+			route.Repositories[j] = repo
+		}
+
+		for j, event := range route.Events {
+			event = strings.ToLower(strings.TrimSpace(event))
+			if !validEvents[event] {
+				return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidConfig, route.Events[j])
+			}
+			route.Events[j] = event
+		}
+	}
+
+	return cfg, nil
+}
+
+// ValidateConfig rejects routes without a channel, unknown providers or events and bad repository patterns
+func ValidateConfig(raw json.RawMessage) error {
+	_, err := ParseConfig(raw)
+	return err
+}
+
+// ConfigChannels is registered with the plugin service so the member saving
+// a config must be able to send messages in every channel it routes to
+func ConfigChannels(raw json.RawMessage) []uuid.UUID {
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		return nil
+	}
+	channelIDs := make([]uuid.UUID, 0, len(cfg.Routes))
+	for _, route := range cfg.Routes {
+		channelIDs = append(channelIDs, route.ChannelID)
+	}
+	return channelIDs
+}
+
+// matches reports whether the route wants this event
+func (r *RouteConfig) matches(provider, kind, repository string) bool {
+	if r.Provider != "" && r.Provider != provider {
+		return false
+	}
+	if len(r.Events) > 0 && !contains(r.Events, kind) {
+		return false
+	}
+	if len(r.Repositories) == 0 {
+		return true
+	}
+	repository = strings.ToLower(repository)
+	for _, pattern := range r.Repositories {
+		if ok, _ := path.Match(pattern, repository); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
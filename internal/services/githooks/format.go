@@ -0,0 +1,434 @@
+package githooks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+)
+
+const (
+	// Pushes list at most this many commits; the rest are summarised
+	maxListedCommits = 5
+	maxTitleLength   = 200
+	maxBodyLength    = 300
+)
+
+var errUnsupportedEvent = errors.New("unsupported event")
+
+// event is a delivery reduced to what routing and posting need. Skip is set
+// for events of a supported kind that are not worth a message, such as a
+// pull request being relabelled.
+type event struct {
+	Provider   string
+	Kind       string
+	Repository string
+	Content    string
+	Preview    *models.LinkPreview
+	Skip       bool
+}
+
+// parseEvent turns a GitHub or GitLab delivery into an event. A GitHub ping
+// is returned with Skip set so the handler can answer it.
+func parseEvent(provider string, headers http.Header, body []byte) (*event, error) {
+	switch provider {
+	case ProviderGitHub:
+		return parseGitHub(headers.Get("X-GitHub-Event"), body)
+	case ProviderGitLab:
+		return parseGitLab(body)
+	}
+	return nil, errUnsupportedEvent
+}
+
+type ghUser struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type ghPayload struct {
+	Action  string `json:"action"`
+	Ref     string `json:"ref"`
+	Created bool   `json:"created"`
+	Deleted bool   `json:"deleted"`
+	Forced  bool   `json:"forced"`
+	Compare string `json:"compare"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name     string `json:"name"`
+			Username string `json:"username"`
+		} `json:"author"`
+	} `json:"commits"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+	} `json:"repository"`
+	Sender      ghUser `json:"sender"`
+	PullRequest *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+	} `json:"pull_request"`
+	Issue *struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		Body    string `json:"body"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue"`
+	Release *struct {
+		TagName    string `json:"tag_name"`
+		Name       string `json:"name"`
+		Body       string `json:"body"`
+		HTMLURL    string `json:"html_url"`
+		Prerelease bool   `json:"prerelease"`
+	} `json:"release"`
+}
+
+func parseGitHub(kind string, body []byte) (*event, error) {
+	kind = strings.ToLower(strings.TrimSpace(kind))
+	if kind == "ping" {
+		return &event{Provider: ProviderGitHub, Kind: kind, Skip: true}, nil
+	}
+	if !validEvents[kind] {
+		return nil, errUnsupportedEvent
+	}
+
+	var p ghPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("decode github payload: %w", err)
+	}
+	e := &event{Provider: ProviderGitHub, Kind: kind, Repository: p.Repository.FullName}
+	repo := p.Repository.FullName
+	actor := firstNonEmpty(p.Sender.Login, "Someone")
+
+	switch kind {
+	case EventPush:
+		commits := make([]commit, 0, len(p.Commits))
+		for _, c := range p.Commits {
+			commits = append(commits, commit{ID: c.ID, Message: c.Message, URL: c.URL, Author: firstNonEmpty(c.Author.Username, c.Author.Name)})
+		}
+		e.Content = pushContent(actor, repo, p.Ref, p.Created, p.Deleted, p.Forced, commits, len(commits))
+		e.Preview = preview(ProviderGitHub, firstNonEmpty(p.Compare, p.Repository.HTMLURL), repo+" "+shortRef(p.Ref), "", p.Sender.AvatarURL)
+
+	case EventPullRequest:
+		pr := p.PullRequest
+		if pr == nil {
+			return nil, errUnsupportedEvent
+		}
+		verb, ok := githubPullRequestVerb(p.Action, pr.Merged)
+		if !ok {
+			e.Skip = true
+			return e, nil
+		}
+		e.Content = itemContent("🔀", actor, verb, "pull request", repo, "#", pr.Number, pr.Title)
+		e.Preview = preview(ProviderGitHub, pr.HTMLURL, fmt.Sprintf("#%d %s", pr.Number, pr.Title), pr.Body, p.Sender.AvatarURL)
+
+	case EventIssues:
+		issue := p.Issue
+		if issue == nil {
+			return nil, errUnsupportedEvent
+		}
+		verb, ok := issueVerb(p.Action)
+		if !ok {
+			e.Skip = true
+			return e, nil
+		}
+		e.Content = itemContent("📋", actor, verb, "issue", repo, "#", issue.Number, issue.Title)
+		e.Preview = preview(ProviderGitHub, issue.HTMLURL, fmt.Sprintf("#%d %s", issue.Number, issue.Title), issue.Body, p.Sender.AvatarURL)
+
+	case EventRelease:
+		release := p.Release
+		// published covers both new releases and drafts being made public
+		if release == nil || p.Action != "published" {
+			e.Skip = true
+			return e, nil
+		}
+		e.Content = releaseContent(actor, repo, release.TagName, release.Name, release.Prerelease)
+		e.Preview = preview(ProviderGitHub, release.HTMLURL, firstNonEmpty(release.Name, release.TagName), release.Body, p.Sender.AvatarURL)
+	}
+	return e, nil
+}
+
+type glProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+}
+
+type glPayload struct {
+	ObjectKind        string    `json:"object_kind"`
+	Ref               string    `json:"ref"`
+	Before            string    `json:"before"`
+	After             string    `json:"after"`
+	UserUsername      string    `json:"user_username"`
+	UserAvatar        string    `json:"user_avatar"`
+	TotalCommitsCount int       `json:"total_commits_count"`
+	Project           glProject `json:"project"`
+	Commits           []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+	User struct {
+		Username  string `json:"username"`
+		AvatarURL string `json:"avatar_url"`
+	} `json:"user"`
+	ObjectAttributes struct {
+		IID         int    `json:"iid"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+		URL         string `json:"url"`
+		Action      string `json:"action"`
+	} `json:"object_attributes"`
+
+	// Release hooks carry the release at the top level
+	Action      string `json:"action"`
+	Tag         string `json:"tag"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	URL         string `json:"url"`
+}
+
+// A deleted branch or tag is pushed with an all-zero after sha
+const gitlabZeroSHA = "0000000000000000000000000000000000000000"
+
+func parseGitLab(body []byte) (*event, error) {
+	var p glPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("decode gitlab payload: %w", err)
+	}
+	repo := p.Project.PathWithNamespace
+	attrs := p.ObjectAttributes
+
+	switch p.ObjectKind {
+	case "push", "tag_push":
+		e := &event{Provider: ProviderGitLab, Kind: EventPush, Repository: repo}
+		actor := firstNonEmpty(p.UserUsername, "Someone")
+		commits := make([]commit, 0, len(p.Commits))
+		for _, c := range p.Commits {
+			commits = append(commits, commit{ID: c.ID, Message: c.Message, URL: c.URL, Author: c.Author.Name})
+		}
+		total := p.TotalCommitsCount
+		if total < len(commits) {
+			total = len(commits)
+		}
+		e.Content = pushContent(actor, repo, p.Ref, p.Before == gitlabZeroSHA, p.After == gitlabZeroSHA, false, commits, total)
+		e.Preview = preview(ProviderGitLab, p.Project.WebURL, repo+" "+shortRef(p.Ref), "", p.UserAvatar)
+		return e, nil
+
+	case "merge_request":
+		e := &event{Provider: ProviderGitLab, Kind: EventPullRequest, Repository: repo}
+		verb, ok := gitlabMergeRequestVerb(attrs.Action)
+		if !ok {
+			e.Skip = true
+			return e, nil
+		}
+		actor := firstNonEmpty(p.User.Username, "Someone")
+		e.Content = itemContent("🔀", actor, verb, "merge request", repo, "!", attrs.IID, attrs.Title)
+		e.Preview = preview(ProviderGitLab, attrs.URL, fmt.Sprintf("!%d %s", attrs.IID, attrs.Title), attrs.Description, p.User.AvatarURL)
+		return e, nil
+
+	case "issue":
+		e := &event{Provider: ProviderGitLab, Kind: EventIssues, Repository: repo}
+		verb, ok := issueVerb(attrs.Action)
+		if !ok {
+			e.Skip = true
+			return e, nil
+		}
+		actor := firstNonEmpty(p.User.Username, "Someone")
+		e.Content = itemContent("📋", actor, verb, "issue", repo, "#", attrs.IID, attrs.Title)
+		e.Preview = preview(ProviderGitLab, attrs.URL, fmt.Sprintf("#%d %s", attrs.IID, attrs.Title), attrs.Description, p.User.AvatarURL)
+		return e, nil
+
+	case "release":
+		e := &event{Provider: ProviderGitLab, Kind: EventRelease, Repository: repo}
+		if p.Action != "create" {
+			e.Skip = true
+			return e, nil
+		}
+		// Release hooks don't say who made the release
+		e.Content = releaseContent("", repo, p.Tag, p.Name, false)
+		e.Preview = preview(ProviderGitLab, p.URL, firstNonEmpty(p.Name, p.Tag), p.Description, "")
+		return e, nil
+	}
+	return nil, errUnsupportedEvent
+}
+
+type commit struct {
+	ID      string
+	Message string
+	URL     string
+	Author  string
+}
+
+// pushContent renders a push as a header line and up to maxListedCommits
+// commit lines
+func pushContent(actor, repo, ref string, created, deleted, forced bool, commits []commit, total int) string {
+	kind := "branch"
+	if strings.HasPrefix(ref, "refs/tags/") {
+		kind = "tag"
+	}
+	name := shortRef(ref)
+
+	var b strings.Builder
+	switch {
+	case deleted:
+		fmt.Fprintf(&b, "🗑️ **%s** deleted %s `%s` in **%s**", actor, kind, name, repo)
+		return b.String()
+	case created && total == 0:
+		fmt.Fprintf(&b, "🌱 **%s** created %s `%s` in **%s**", actor, kind, name, repo)
+		return b.String()
+	case forced:
+		fmt.Fprintf(&b, "⚠️ **%s** force-pushed %s to `%s` in **%s**", actor, plural(total, "commit"), name, repo)
+	default:
+		fmt.Fprintf(&b, "⬆️ **%s** pushed %s to `%s` in **%s**", actor, plural(total, "commit"), name, repo)
+	}
+
+	listed := commits
+	if len(listed) > maxListedCommits {
+		listed = listed[len(listed)-maxListedCommits:]
+	}
+	for _, c := range listed {
+		sha := c.ID
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		line := truncate(firstLine(c.Message), maxTitleLength)
+		if c.URL != "" {
+			fmt.Fprintf(&b, "\n[`%s`](%s) %s", sha, c.URL, line)
+		} else {
+			fmt.Fprintf(&b, "\n`%s` %s", sha, line)
+		}
+		if c.Author != "" {
+			fmt.Fprintf(&b, " — %s", c.Author)
+		}
+	}
+	if total > len(listed) {
+		fmt.Fprintf(&b, "\n…and %s more", plural(total-len(listed), "commit"))
+	}
+	return b.String()
+}
+
+func itemContent(icon, actor, verb, noun, repo, sigil string, number int, title string) string {
+	return fmt.Sprintf("%s **%s** %s %s %s%d in **%s**: %s",
+		icon, actor, verb, noun, sigil, number, repo, truncate(firstLine(title), maxTitleLength))
+}
+
+func releaseContent(actor, repo, tag, name string, prerelease bool) string {
+	label := "release"
+	if prerelease {
+		label = "pre-release"
+	}
+	title := firstNonEmpty(name, tag)
+	if actor == "" {
+		return fmt.Sprintf("🚀 New %s in **%s**: **%s**", label, repo, truncate(title, maxTitleLength))
+	}
+	return fmt.Sprintf("🚀 **%s** published %s **%s** in **%s**", actor, label, truncate(title, maxTitleLength), repo)
+}
+
+func preview(provider, url, title, description, imageURL string) *models.LinkPreview {
+	if url == "" {
+		return nil
+	}
+	site := "GitHub"
+	if provider == ProviderGitLab {
+		site = "GitLab"
+	}
+	return &models.LinkPreview{
+		URL:         url,
+		Title:       truncate(strings.TrimSpace(title), maxTitleLength),
+		Description: truncate(strings.Join(strings.Fields(description), " "), maxBodyLength),
+		SiteName:    site,
+		ImageURL:    imageURL,
+	}
+}
+
+func githubPullRequestVerb(action string, merged bool) (string, bool) {
+	switch action {
+	case "opened":
+		return "opened", true
+	case "reopened":
+		return "reopened", true
+	case "ready_for_review":
+		return "marked ready for review", true
+	case "closed":
+		if merged {
+			return "merged", true
+		}
+		return "closed", true
+	}
+	return "", false
+}
+
+func gitlabMergeRequestVerb(action string) (string, bool) {
+	switch action {
+	case "open":
+		return "opened", true
+	case "reopen":
+		return "reopened", true
+	case "merge":
+		return "merged", true
+	case "close":
+		return "closed", true
+	}
+	return "", false
+}
+
+// issueVerb covers both providers: GitHub sends opened/closed/reopened and
+// GitLab open/close/reopen
+func issueVerb(action string) (string, bool) {
+	switch action {
+	case "opened", "open":
+		return "opened", true
+	case "closed", "close":
+		return "closed", true
+	case "reopened", "reopen":
+		return "reopened", true
+	}
+	return "", false
+}
+
+func shortRef(ref string) string {
+	ref = strings.TrimPrefix(ref, "refs/heads/")
+	return strings.TrimPrefix(ref, "refs/tags/")
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+func truncate(s string, max int) string {
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max]) + "…"
+	}
+	return s
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package githooks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+// EndpointResponse is the endpoint with the URL to paste into GitHub or GitLab
+type EndpointResponse struct {
+	*Endpoint
+	URL string `json:"url"`
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes(secret string) chi.Router {
+	r := chi.NewRouter()
+
+	// Authenticated management routes.
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(secret))
+		r.Get("/communities/{communityId}/endpoint", h.GetEndpoint)
+		r.Post("/communities/{communityId}/endpoint/rotate", h.RotateSecret)
+	})
+
+	// Public receiver; deliveries are authenticated by their signature or token.
+	r.Post("/hooks/{endpointId}", h.Receive)
+
+	return r
+}
+
+// GetEndpoint returns the community's receiving URL and secret
+func (h *Handler) GetEndpoint(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	endpoint, err := h.service.GetEndpoint(r.Context(), communityID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to get endpoint")
+		return
+	}
+
+	utils.RespondSuccess(w, EndpointResponse{Endpoint: endpoint, URL: buildEndpointURL(r, endpoint.ID)})
+}
+
+// RotateSecret issues a new secret for the community's endpoint
+func (h *Handler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := communityParams(w, r)
+	if !ok {
+		return
+	}
+
+	endpoint, err := h.service.RotateSecret(r.Context(), communityID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to rotate secret")
+		return
+	}
+
+	utils.RespondSuccess(w, EndpointResponse{Endpoint: endpoint, URL: buildEndpointURL(r, endpoint.ID)})
+}
+
+// Receive handles a GitHub or GitLab webhook delivery
+func (h *Handler) Receive(w http.ResponseWriter, r *http.Request) {
+	endpointID, err := uuid.Parse(chi.URLParam(r, "endpointId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusNotFound, "Endpoint not found")
+		return
+	}
+
+	bodyReader := http.MaxBytesReader(w, r.Body, MaxPayloadBytes)
+	defer bodyReader.Close()
+
+	rawBody, err := io.ReadAll(bodyReader)
+	if err != nil {
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, "Webhook payload is too large")
+		return
+	}
+
+	delivery, err := h.service.Receive(r.Context(), endpointID, r.Header, rawBody)
+	if err != nil {
+		respondError(w, err, "Failed to process webhook")
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusAccepted, delivery)
+}
+
+func communityParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, communityID, true
+}
+
+func respondError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrEndpointNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Endpoint not found")
+	case errors.Is(err, ErrNotInstalled):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidSignature):
+		utils.RespondError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, ErrPluginDisabled):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrUnknownProvider):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+func buildEndpointURL(r *http.Request, endpointID uuid.UUID) string {
+	proto := firstForwardedValue(r.Header.Get("X-Forwarded-Proto"))
+	if proto == "" {
+		if r.TLS != nil {
+			proto = "https"
+		} else {
+			proto = "http"
+		}
+	}
+
+	host := firstForwardedValue(r.Header.Get("X-Forwarded-Host"))
+	if host == "" {
+		host = r.Host
+	}
+
+	return fmt.Sprintf("%s://%s/api/v1/integrations/git/hooks/%s", proto, host, endpointID.String())
+}
+
+func firstForwardedValue(value string) string {
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		return ""
+	}
+	parts := strings.Split(trimmed, ",")
+	return strings.TrimSpace(parts[0])
+}
@@ -0,0 +1,32 @@
+package githooks
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+
+	openapi.Describe((*Handler).GetEndpoint, openapi.Operation{
+		Summary:  "Get a community's GitHub and GitLab webhook URL and secret",
+		Path:     communityID,
+		Response: EndpointResponse{},
+	})
+	openapi.Describe((*Handler).RotateSecret, openapi.Operation{
+		Summary:  "Replace a community's webhook secret",
+		Path:     communityID,
+		Response: EndpointResponse{},
+	})
+	openapi.Describe((*Handler).Receive, openapi.Operation{
+		Summary:     "Receive a GitHub or GitLab webhook delivery",
+		Description: "Authenticated by the delivery's X-Hub-Signature-256 or X-Gitlab-Token header. Payloads are at most 5 MiB.",
+		Path:        []openapi.Param{openapi.ID("endpointId")},
+		Body:        json.RawMessage{},
+		Response:    Delivery{},
+		Shape:       openapi.Raw,
+		Status:      http.StatusAccepted,
+	})
+}
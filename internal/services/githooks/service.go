@@ -0,0 +1,407 @@
+package githooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	// GitHub caps deliveries at 25MB but anything we post from is far smaller
+	MaxPayloadBytes = 5 * 1024 * 1024
+
+	secretBytes       = 32
+	maxDeliveryID     = 128
+	deliveryRetention = 7 * 24 * time.Hour
+)
+
+// BotUserID is the system account events are posted as (seeded by migration 000035).
+var BotUserID = uuid.MustParse("c0de0000-0000-4000-8000-000000000001")
+
+var (
+	ErrEndpointNotFound  = errors.New("endpoint not found")
+	ErrNotInstalled      = errors.New("the GitHub & GitLab plugin is not installed in this community")
+	ErrPluginDisabled    = errors.New("the GitHub & GitLab plugin is disabled or may not post messages")
+	ErrInvalidSignature  = errors.New("invalid webhook signature")
+	ErrUnknownProvider   = errors.New("request is not a GitHub or GitLab webhook")
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+)
+
+// CommunityServiceInterface is what the plugin needs from the community service
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+}
+
+// MessagePoster posts events as the git account
+type MessagePoster interface {
+	CreateBotMessage(ctx context.Context, m *message.BotMessage) (*message.MessageResponse, error)
+}
+
+type Service struct {
+	db               *pgxpool.Pool
+	cipher           messaging.ContentCipher
+	messages         MessagePoster
+	communityService CommunityServiceInterface
+}
+
+func NewService(db *pgxpool.Pool, keys *encryption.Keyring, messages MessagePoster, communityService CommunityServiceInterface) *Service {
+	return &Service{
+		db:               db,
+		cipher:           messaging.NewChannelCipher(keys),
+		messages:         messages,
+		communityService: communityService,
+	}
+}
+
+// Endpoint is a community's receiving endpoint. Secret goes in GitHub's
+// "Secret" field or GitLab's "Secret token" field.
+type Endpoint struct {
+	ID             uuid.UUID  `json:"id"`
+	CommunityID    uuid.UUID  `json:"communityId"`
+	Secret         string     `json:"secret"`
+	LastDeliveryAt *time.Time `json:"lastDeliveryAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	RotatedAt      time.Time  `json:"rotatedAt"`
+}
+
+// Delivery is the outcome of a received event
+type Delivery struct {
+	Provider string `json:"provider"`
+	Event    string `json:"event"`
+	Posted   int    `json:"posted"`
+}
+
+// installation is the community's install of the plugin
+type installation struct {
+	PluginID uuid.UUID
+	Enabled  bool
+	Granted  int64
+	Config   json.RawMessage
+}
+
+// GetEndpoint returns the community's endpoint, creating it the first time
+func (s *Service) GetEndpoint(ctx context.Context, communityID, userID uuid.UUID) (*Endpoint, error) {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+	if _, err := s.getInstallation(ctx, communityID); err != nil {
+		return nil, err
+	}
+
+	endpoint, err := s.getEndpoint(ctx, `community_id = $1`, communityID)
+	if !errors.Is(err, ErrEndpointNotFound) {
+		return endpoint, err
+	}
+
+	secret, err := s.newEncryptedSecret(communityID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(ctx,
+		`INSERT INTO git_endpoints (community_id, encrypted_secret, created_by)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (community_id) DO NOTHING`,
+		communityID, secret, userID,
+	); err != nil {
+		return nil, err
+	}
+	return s.getEndpoint(ctx, `community_id = $1`, communityID)
+}
+
+// RotateSecret replaces the endpoint secret. Deliveries signed with the old
+// one are rejected from then on.
+func (s *Service) RotateSecret(ctx context.Context, communityID, userID uuid.UUID) (*Endpoint, error) {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+	inst, err := s.getInstallation(ctx, communityID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := s.newEncryptedSecret(communityID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(ctx,
+		`INSERT INTO git_endpoints (community_id, encrypted_secret, created_by)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (community_id) DO UPDATE
+		 SET encrypted_secret = EXCLUDED.encrypted_secret, rotated_at = NOW()`,
+		communityID, secret, userID,
+	); err != nil {
+		return nil, err
+	}
+
+	s.logAction(ctx, communityID, inst.PluginID, userID, "secret_rotate", nil)
+	return s.getEndpoint(ctx, `community_id = $1`, communityID)
+}
+
+// Receive verifies a delivery against the endpoint secret and posts it to
+// every channel whose route matches. Event kinds the plugin doesn't format,
+// and actions not worth a message, are accepted with nothing posted.
+func (s *Service) Receive(ctx context.Context, endpointID uuid.UUID, headers http.Header, body []byte) (*Delivery, error) {
+	endpoint, err := s.getEndpoint(ctx, `id = $1`, endpointID)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := detectProvider(headers)
+	if provider == "" {
+		return nil, ErrUnknownProvider
+	}
+	if !verify(provider, headers, body, endpoint.Secret) {
+		return nil, ErrInvalidSignature
+	}
+
+	inst, err := s.getInstallation(ctx, endpoint.CommunityID)
+	if err != nil {
+		return nil, err
+	}
+	if !inst.Enabled || inst.Granted&models.PluginPermSendMessages == 0 {
+		return nil, ErrPluginDisabled
+	}
+
+	delivery := &Delivery{Provider: provider}
+	e, err := parseEvent(provider, headers, body)
+	if errors.Is(err, errUnsupportedEvent) {
+		return delivery, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	delivery.Event = e.Kind
+	if e.Skip {
+		return delivery, nil
+	}
+
+	cfg, err := ParseConfig(inst.Config)
+	if err != nil {
+		return nil, err
+	}
+	var channelIDs []uuid.UUID
+	seen := make(map[uuid.UUID]bool)
+	for i := range cfg.Routes {
+		route := &cfg.Routes[i]
+		if !seen[route.ChannelID] && route.matches(e.Provider, e.Kind, e.Repository) {
+			seen[route.ChannelID] = true
+			channelIDs = append(channelIDs, route.ChannelID)
+		}
+	}
+	if len(channelIDs) == 0 {
+		return delivery, nil
+	}
+
+	delivery.Posted, err = s.post(ctx, endpoint, deliveryID(headers), e, channelIDs)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// post writes the event to each channel. The delivery id is claimed first so
+// a redelivered event is never posted twice; the claim is released when
+// nothing could be posted, so the provider's retry gets another go.
+func (s *Service) post(ctx context.Context, endpoint *Endpoint, delivery string, e *event, channelIDs []uuid.UUID) (int, error) {
+	if delivery != "" {
+		tag, err := s.db.Exec(ctx,
+			`INSERT INTO git_deliveries (endpoint_id, delivery_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			endpoint.ID, delivery,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if tag.RowsAffected() == 0 {
+			return 0, nil
+		}
+	}
+	if _, err := s.db.Exec(ctx, `UPDATE git_endpoints SET last_delivery_at = NOW() WHERE id = $1`, endpoint.ID); err != nil {
+		return 0, err
+	}
+
+	// The event's own preview is used instead of fetching the links in it
+	linkPreviews := []models.LinkPreview{}
+	if e.Preview != nil {
+		linkPreviews = append(linkPreviews, *e.Preview)
+	}
+
+	posted := 0
+	for _, channelID := range channelIDs {
+		_, err := s.messages.CreateBotMessage(ctx, &message.BotMessage{
+			ChannelID:    channelID,
+			CommunityID:  endpoint.CommunityID,
+			AuthorID:     BotUserID,
+			Content:      e.Content,
+			LinkPreviews: linkPreviews,
+		})
+		switch {
+		case err == nil:
+			posted++
+		case errors.Is(err, message.ErrChannelUnavailable):
+			// A route pointing at a deleted channel shouldn't hold up the others
+		case errors.Is(err, message.ErrBlockedByAutoMod), errors.Is(err, message.ErrRemovedByAutoMod):
+		default:
+			if posted == 0 && delivery != "" {
+				if _, releaseErr := s.db.Exec(ctx,
+					`DELETE FROM git_deliveries WHERE endpoint_id = $1 AND delivery_id = $2`,
+					endpoint.ID, delivery,
+				); releaseErr != nil {
+					log.Warn().Err(releaseErr).Str("delivery", delivery).Msg("Failed to release git delivery")
+				}
+			}
+			return posted, fmt.Errorf("post git event: %w", err)
+		}
+	}
+	return posted, nil
+}
+
+// PruneDeliveries forgets delivery ids old enough that they won't be redelivered
+func (s *Service) PruneDeliveries(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM git_deliveries WHERE created_at < $1`,
+		time.Now().Add(-deliveryRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// detectProvider tells GitHub and GitLab deliveries apart by their event header
+func detectProvider(headers http.Header) string {
+	switch {
+	case headers.Get("X-GitHub-Event") != "":
+		return ProviderGitHub
+	case headers.Get("X-Gitlab-Event") != "":
+		return ProviderGitLab
+	}
+	return ""
+}
+
+// verify checks GitHub's HMAC-SHA256 body signature or GitLab's secret token
+func verify(provider string, headers http.Header, body []byte, secret string) bool {
+	switch provider {
+	case ProviderGitHub:
+		signature, ok := strings.CutPrefix(headers.Get("X-Hub-Signature-256"), "sha256=")
+		if !ok {
+			return false
+		}
+		got, err := hex.DecodeString(signature)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return hmac.Equal(got, mac.Sum(nil))
+	case ProviderGitLab:
+		token := headers.Get("X-Gitlab-Token")
+		return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+	}
+	return false
+}
+
+// deliveryID is the provider's id for this delivery, reused on redelivery
+func deliveryID(headers http.Header) string {
+	id := strings.TrimSpace(headers.Get("X-GitHub-Delivery"))
+	if id == "" {
+		id = strings.TrimSpace(headers.Get("X-Gitlab-Event-UUID"))
+	}
+	if len(id) > maxDeliveryID {
+		id = id[:maxDeliveryID]
+	}
+	return id
+}
+
+func (s *Service) requireManager(ctx context.Context, communityID, userID uuid.UUID) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(perms, models.PermissionManageWebhooks) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+func (s *Service) getInstallation(ctx context.Context, communityID uuid.UUID) (*installation, error) {
+	inst := &installation{}
+	err := s.db.QueryRow(ctx,
+		`SELECT cp.plugin_id, cp.enabled, cp.granted_permissions, cp.config
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 WHERE cp.community_id = $1 AND p.slug = $2`,
+		communityID, PluginSlug,
+	).Scan(&inst.PluginID, &inst.Enabled, &inst.Granted, &inst.Config)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotInstalled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+func (s *Service) getEndpoint(ctx context.Context, where string, arg uuid.UUID) (*Endpoint, error) {
+	endpoint := &Endpoint{}
+	var encryptedSecret []byte
+	err := s.db.QueryRow(ctx,
+		`SELECT id, community_id, encrypted_secret, last_delivery_at, created_at, rotated_at
+		 FROM git_endpoints WHERE `+where,
+		arg,
+	).Scan(&endpoint.ID, &endpoint.CommunityID, &encryptedSecret, &endpoint.LastDeliveryAt, &endpoint.CreatedAt, &endpoint.RotatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrEndpointNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint.Secret, err = s.cipher.Decrypt(secretRow(endpoint.CommunityID), encryptedSecret, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt git endpoint secret: %w", err)
+	}
+	return endpoint, nil
+}
+
+// secretRow is the row an endpoint's secret is sealed for. A community has
+// one endpoint, replaced in place on rotation, so it is keyed by the community.
+func secretRow(communityID uuid.UUID) encryption.Row {
+	return encryption.Row{Table: "git_endpoints", ID: communityID}
+}
+
+func (s *Service) newEncryptedSecret(communityID uuid.UUID) ([]byte, error) {
+	buf := make([]byte, secretBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	encrypted, _, err := s.cipher.Encrypt(secretRow(communityID), hex.EncodeToString(buf))
+	return encrypted, err
+}
+
+// logAction writes to the plugin audit log, where community admins see plugin activity
+func (s *Service) logAction(ctx context.Context, communityID, pluginID, actorID uuid.UUID, action string, details map[string]any) {
+	detailsJSON, _ := json.Marshal(details)
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO plugin_audit_log (community_id, plugin_id, actor_id, action, details)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		communityID, pluginID, actorID, action, detailsJSON,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("action", action).Msg("Failed to log git plugin action")
+	}
+}
@@ -0,0 +1,12 @@
+package githubstats
+
+import (
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	openapi.Describe((*Handler).GetStats, openapi.Operation{
+		Summary:  "Get the project's GitHub stars, forks and contributors",
+		Response: Stats{},
+	})
+}
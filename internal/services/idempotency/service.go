@@ -0,0 +1,189 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	// KeyTTL is how long a key's response is replayed to retries
+	KeyTTL = 24 * time.Hour
+
+	// A request that held its key this long without finishing most likely
+	// died with its instance; a retry takes the key over
+	staleAfter = 2 * time.Minute
+)
+
+var (
+	ErrInProgress = errors.New("a request with this idempotency key is still in progress")
+	ErrKeyReused  = errors.New("idempotency key was already used for a different request")
+	// ErrReservationLost means a retry took the key over from a request that
+	// ran past staleAfter, so its response isn't stored
+	ErrReservationLost = errors.New("idempotency key was taken over by a retry")
+)
+
+// Response is a finished request's response, kept to replay to retries
+type Response struct {
+	StatusCode  int
+	ContentType string
+	Location    string
+	Body        []byte
+}
+
+// Service stores Idempotency-Key reservations in Postgres, scoped per user.
+// Response bodies are sealed with the keyring, as they hold message content.
+type Service struct {
+	db   *pgxpool.Pool
+	keys *encryption.Keyring
+}
+
+func NewService(db *pgxpool.Pool, keys *encryption.Keyring) *Service {
+	return &Service{db: db, keys: keys}
+}
+
+// Begin reserves key for a request. It returns a nil response when the caller
+// should run the request and then Complete or Release the key with the
+// returned lock time, or the response to replay when a request with the same
+// fingerprint already finished.
+func (s *Service) Begin(ctx context.Context, userID uuid.UUID, key string, fingerprint []byte) (*Response, time.Time, error) {
+	var locked time.Time
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO idempotency_keys (user_id, key, fingerprint) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, key) DO NOTHING
+		RETURNING locked_at`,
+		userID, key, fingerprint,
+	).Scan(&locked)
+	if err == nil {
+		return nil, locked, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, time.Time{}, err
+	}
+
+	var stored []byte
+	var statusCode *int
+	var contentType, location *string
+	var body []byte
+	var createdAt, lockedAt time.Time
+	err = s.db.QueryRow(ctx,
+		`SELECT fingerprint, status_code, content_type, location, response_body, created_at, locked_at
+		FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		userID, key,
+	).Scan(&stored, &statusCode, &contentType, &location, &body, &createdAt, &lockedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Released between the insert and here; try again
+		return s.Begin(ctx, userID, key, fingerprint)
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if time.Since(createdAt) > KeyTTL {
+		// Expired but not pruned yet: the key is free again
+		locked, err := s.take(ctx, userID, key, fingerprint, `created_at = $4`, createdAt)
+		return nil, locked, err
+	}
+	if !bytes.Equal(stored, fingerprint) {
+		return nil, time.Time{}, ErrKeyReused
+	}
+	if statusCode != nil {
+		if len(body) > 0 {
+			if body, err = s.keys.Open(body, keyRow(userID, key)); err != nil {
+				return nil, time.Time{}, err
+			}
+		}
+		resp := &Response{StatusCode: *statusCode, Body: body}
+		if contentType != nil {
+			resp.ContentType = *contentType
+		}
+		if location != nil {
+			resp.Location = *location
+		}
+		return resp, time.Time{}, nil
+	}
+	if time.Since(lockedAt) < staleAfter {
+		return nil, time.Time{}, ErrInProgress
+	}
+	locked, err = s.take(ctx, userID, key, fingerprint, `locked_at = $4 AND status_code IS NULL`, lockedAt)
+	return nil, locked, err
+}
+
+// take resets a key row for a new request, as long as it still matches cond.
+// Two retries racing for the same row can't both get it.
+func (s *Service) take(ctx context.Context, userID uuid.UUID, key string, fingerprint []byte, cond string, seen time.Time) (time.Time, error) {
+	var locked time.Time
+	err := s.db.QueryRow(ctx,
+		`UPDATE idempotency_keys
+		SET fingerprint = $3, status_code = NULL, content_type = NULL, location = NULL, response_body = NULL,
+			created_at = NOW(), locked_at = NOW()
+		WHERE user_id = $1 AND key = $2 AND `+cond+`
+		RETURNING locked_at`,
+		userID, key, fingerprint, seen,
+	).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return time.Time{}, ErrInProgress
+	}
+	return locked, err
+}
+
+// Complete stores the response of the request that holds key, as long as it
+// still holds it: a retry may have taken over a request that ran too long
+func (s *Service) Complete(ctx context.Context, userID uuid.UUID, key string, locked time.Time, resp *Response) error {
+	var body []byte
+	if len(resp.Body) > 0 {
+		sealed, err := s.keys.Seal(resp.Body, keyRow(userID, key))
+		if err != nil {
+			return err
+		}
+		body = sealed
+	}
+	tag, err := s.db.Exec(ctx,
+		`UPDATE idempotency_keys
+		SET status_code = $3, content_type = NULLIF($4, ''), location = NULLIF($5, ''), response_body = $6
+		WHERE user_id = $1 AND key = $2 AND locked_at = $7 AND status_code IS NULL`,
+		userID, key, resp.StatusCode, resp.ContentType, resp.Location, body, locked,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrReservationLost
+	}
+	return nil
+}
+
+// Release frees key without a response, so a retry runs the request again.
+// Used when the request failed in a way a retry might not. A key a retry has
+// taken over is left alone.
+func (s *Service) Release(ctx context.Context, userID uuid.UUID, key string, locked time.Time) error {
+	_, err := s.db.Exec(ctx,
+		`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND locked_at = $3 AND status_code IS NULL`,
+		userID, key, locked,
+	)
+	return err
+}
+
+// PruneExpired deletes keys older than KeyTTL and returns how many
+func (s *Service) PruneExpired(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM idempotency_keys WHERE created_at < $1`,
+		time.Now().Add(-KeyTTL),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// keyRow is the row a stored response is sealed for. Keys have no ID of their
+// own, so one is derived from the user and the key.
+func keyRow(userID uuid.UUID, key string) encryption.Row {
+	return encryption.Row{Table: "idempotency_keys", ID: uuid.NewSHA1(userID, []byte(key))}
+}
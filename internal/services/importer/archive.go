@@ -0,0 +1,193 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zentra/server/internal/models"
+)
+
+// maxArchiveJSONSize caps how much one JSON file in an archive may inflate to.
+// Files are decoded whole, so this bounds the memory one import can use.
+const maxArchiveJSONSize = 64 << 20
+
+// archiveSource reads one platform's export format into the common shape below.
+// Both methods must return things in the same order every time they are called
+// on the same archive, since job checkpoints are indexes into them.
+type archiveSource interface {
+	// Structure returns everything except message history
+	Structure() (*archiveStructure, error)
+	// Messages returns a channel's history, oldest first
+	Messages(ch *archiveChannel) ([]*archiveMessage, error)
+}
+
+type archiveStructure struct {
+	Name     string
+	Roles    []*archiveRole
+	Users    []*archiveUser // members listed by the export, whether or not they posted
+	Channels []*archiveChannel
+	// DefaultRoleID and DefaultPermissions describe the everyone role when the
+	// export has one; it maps onto the community's own default role
+	DefaultRoleID      string
+	DefaultPermissions *int64
+}
+
+type archiveRole struct {
+	SourceID    string
+	Name        string
+	Color       *string
+	Position    int
+	Permissions int64
+}
+
+type archiveUser struct {
+	SourceID    string
+	Username    string
+	DisplayName string
+	AvatarURL   *string
+	RoleIDs     []string
+}
+
+type archiveChannel struct {
+	SourceID string
+	Name     string
+	Topic    *string
+	Category string
+	Type     models.ChannelType
+	Private  bool
+	// RoleOverwrites are permission overwrites by source role ID
+	RoleOverwrites []*archiveOverwrite
+	// MessageCount is filled in by the structure pass for progress reporting
+	MessageCount int
+
+	// files holds the archive entries the channel's history is read from
+	files []string
+}
+
+type archiveOverwrite struct {
+	RoleID string
+	Allow  int64
+	Deny   int64
+}
+
+type archiveMessage struct {
+	SourceID       string
+	Author         *archiveUser
+	Content        string
+	ContentWarning *string // only in Peridotite exports
+	CreatedAt      time.Time
+	EditedAt       *time.Time
+	Pinned         bool
+	ReplyTo        string // SourceID of a message in the same channel
+	Attachments    []*archiveAttachment
+}
+
+// archiveAttachment is a file that is either inside the archive (Path) or
+// linked from it (URL)
+type archiveAttachment struct {
+	SourceID    string
+	Filename    string
+	ContentType string
+	Size        int64
+	Path        string
+	URL         string
+	// Token authorises downloading URL, for exports that link private files
+	Token string
+}
+
+// archiveFiles indexes an export zip by entry name
+type archiveFiles struct {
+	zip   *zip.Reader
+	files map[string]*zip.File
+}
+
+func newArchiveFiles(r io.ReaderAt, size int64) (*archiveFiles, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+	a := &archiveFiles{zip: zr, files: make(map[string]*zip.File, len(zr.File))}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		a.files[path.Clean(f.Name)] = f
+	}
+	return a, nil
+}
+
+// names returns entry names matching the predicate, sorted
+func (a *archiveFiles) names(match func(name string) bool) []string {
+	var names []string
+	for name := range a.files {
+		if match(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *archiveFiles) has(name string) bool {
+	_, ok := a.files[path.Clean(name)]
+	return ok
+}
+
+func (a *archiveFiles) readJSON(name string, v any) error {
+	f, ok := a.files[path.Clean(name)]
+	if !ok {
+		return fmt.Errorf("%w: %s is missing", ErrInvalidArchive, name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := json.NewDecoder(io.LimitReader(rc, maxArchiveJSONSize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+	}
+	return nil
+}
+
+// open returns an entry's contents and uncompressed size
+func (a *archiveFiles) open(name string) (io.ReadCloser, int64, error) {
+	f, ok := a.files[path.Clean(name)]
+	if !ok {
+		return nil, 0, fmt.Errorf("%s is not in the archive", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	return rc, int64(f.UncompressedSize64), nil
+}
+
+// sortChannels orders channels by category and name so positions and
+// checkpoints are stable
+func sortChannels(channels []*archiveChannel) {
+	sort.SliceStable(channels, func(i, j int) bool {
+		a, b := channels[i], channels[j]
+		if a.Category != b.Category {
+			return a.Category < b.Category
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.SourceID < b.SourceID
+	})
+}
+
+func optionalString(s string) *string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,130 @@
+package importer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/storage"
+)
+
+// maxAttachmentSize is the largest file re-uploaded from an archive, the same
+// as the largest regular upload
+const maxAttachmentSize = 100 * 1024 * 1024
+
+// attachmentHosts are where linked attachments may be downloaded from. Exports
+// are user supplied, so anything else is refused rather than fetched from
+// inside the network.
+var attachmentHosts = map[models.ImportSource][]string{
+	models.ImportSourceDiscord: {"cdn.discordapp.com", "media.discordapp.net"},
+	models.ImportSourceSlack:   {"files.slack.com", ".slack-edge.com"},
+}
+
+func allowedAttachmentURL(source models.ImportSource, raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.User != nil || u.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range attachmentHosts[source] {
+		if host == allowed || (strings.HasPrefix(allowed, ".") && strings.HasSuffix(host, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// storeAttachment copies an attachment from the archive or its link into the
+// attachments bucket under the same layout as regular uploads
+func (s *Service) storeAttachment(ctx context.Context, run *importRun, source models.ImportSource, communityID, channelID, attachmentID uuid.UUID, a *archiveAttachment) (*storedAttachment, error) {
+	var body io.ReadCloser
+	var size int64
+	contentType := a.ContentType
+
+	switch {
+	case a.Path != "":
+		rc, n, err := run.files.open(a.Path)
+		if err != nil {
+			return nil, err
+		}
+		body, size = rc, n
+	case a.URL != "":
+		if !allowedAttachmentURL(source, a.URL) {
+			return nil, fmt.Errorf("refusing to download from %s", a.URL)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if a.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+a.Token)
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("download returned %s", resp.Status)
+		}
+		// Slack answers an unauthorised file request with its sign-in page
+		if source == models.ImportSourceSlack && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") && !strings.HasPrefix(contentType, "text/html") {
+			resp.Body.Close()
+			return nil, fmt.Errorf("download was not authorised")
+		}
+		body, size = resp.Body, resp.ContentLength
+	default:
+		return nil, fmt.Errorf("attachment has no source")
+	}
+	defer body.Close()
+
+	if size > maxAttachmentSize {
+		return nil, fmt.Errorf("attachment is larger than %d bytes", maxAttachmentSize)
+	}
+
+	filename := strings.TrimSpace(path.Base(a.Filename))
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "file"
+	}
+	filename = truncate(filename, 255)
+	if contentType == "" {
+		contentType = storage.GetContentTypeFromFilename(filename)
+	}
+
+	// Read at most one byte past the limit so a download without a length
+	// can't stream forever
+	limited := io.LimitReader(body, maxAttachmentSize+1)
+	objectName := fmt.Sprintf("%s/%s/%s%s", communityID, channelID, attachmentID, strings.ToLower(path.Ext(filename)))
+	info, err := s.minio.PutObject(ctx, s.bucketAttachments, objectName, limited, -1, minio.PutObjectOptions{
+		ContentType: contentType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info.Size > maxAttachmentSize {
+		_ = s.minio.RemoveObject(ctx, s.bucketAttachments, objectName, minio.RemoveObjectOptions{})
+		return nil, fmt.Errorf("attachment is larger than %d bytes", maxAttachmentSize)
+	}
+
+	return &storedAttachment{
+		id:          attachmentID,
+		filename:    filename,
+		url:         s.publicURL(objectName),
+		size:        info.Size,
+		contentType: contentType,
+	}, nil
+}
+
+// publicURL builds an attachment URL the way the media service does
+func (s *Service) publicURL(objectName string) string {
+	baseURL := strings.TrimSuffix(s.cdnBaseURL, "/")
+	baseURL = strings.TrimSuffix(baseURL, "/"+s.bucketAttachments)
+	return fmt.Sprintf("%s/%s/%s", baseURL, s.bucketAttachments, objectName)
+}
@@ -0,0 +1,214 @@
+package importer
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/zentra/server/internal/models"
+)
+
+// discordArchive reads a zip of DiscordChatExporter JSON exports, one file per
+// channel. Attachments exported with --media are read from the zip; otherwise
+// they are downloaded from the CDN links in the export.
+type discordArchive struct {
+	files *archiveFiles
+	users map[string]*archiveUser
+}
+
+type dceExport struct {
+	Guild struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		IconURL string `json:"iconUrl"`
+	} `json:"guild"`
+	Channel struct {
+		ID       string  `json:"id"`
+		Type     string  `json:"type"`
+		Category string  `json:"category"`
+		Name     string  `json:"name"`
+		Topic    *string `json:"topic"`
+	} `json:"channel"`
+	Messages []dceMessage `json:"messages"`
+}
+
+type dceMessage struct {
+	ID              string     `json:"id"`
+	Type            string     `json:"type"`
+	Timestamp       time.Time  `json:"timestamp"`
+	TimestampEdited *time.Time `json:"timestampEdited"`
+	IsPinned        bool       `json:"isPinned"`
+	Content         string     `json:"content"`
+	Author          dceAuthor  `json:"author"`
+	Attachments     []struct {
+		ID            string `json:"id"`
+		URL           string `json:"url"`
+		FileName      string `json:"fileName"`
+		FileSizeBytes int64  `json:"fileSizeBytes"`
+	} `json:"attachments"`
+	Reference *struct {
+		MessageID string `json:"messageId"`
+		ChannelID string `json:"channelId"`
+	} `json:"reference"`
+}
+
+type dceAuthor struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Nickname  string `json:"nickname"`
+	AvatarURL string `json:"avatarUrl"`
+	Roles     []struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Color    string `json:"color"`
+		Position int    `json:"position"`
+	} `json:"roles"`
+}
+
+func newDiscordArchive(files *archiveFiles) *discordArchive {
+	return &discordArchive{files: files, users: make(map[string]*archiveUser)}
+}
+
+func (d *discordArchive) Structure() (*archiveStructure, error) {
+	names := d.files.names(func(name string) bool { return strings.HasSuffix(strings.ToLower(name), ".json") })
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: no DiscordChatExporter JSON files found", ErrInvalidArchive)
+	}
+
+	s := &archiveStructure{}
+	roles := make(map[string]*archiveRole)
+	for _, name := range names {
+		var export dceExport
+		if err := d.files.readJSON(name, &export); err != nil {
+			return nil, err
+		}
+		if export.Channel.ID == "" {
+			continue // not a channel export
+		}
+		if s.Name == "" {
+			s.Name = export.Guild.Name
+		}
+
+		s.Channels = append(s.Channels, &archiveChannel{
+			SourceID:     export.Channel.ID,
+			Name:         export.Channel.Name,
+			Topic:        export.Channel.Topic,
+			Category:     strings.TrimSpace(export.Channel.Category),
+			Type:         discordChannelType(export.Channel.Type),
+			MessageCount: len(export.Messages),
+			files:        []string{name},
+		})
+
+		for i := range export.Messages {
+			author := &export.Messages[i].Author
+			if _, ok := d.users[author.ID]; ok || author.ID == "" {
+				continue
+			}
+			u := d.user(author)
+			s.Users = append(s.Users, u)
+			for _, r := range author.Roles {
+				if _, ok := roles[r.ID]; !ok {
+					roles[r.ID] = &archiveRole{SourceID: r.ID, Name: r.Name, Color: optionalString(r.Color), Position: r.Position}
+					s.Roles = append(s.Roles, roles[r.ID])
+				}
+			}
+		}
+	}
+	if len(s.Channels) == 0 {
+		return nil, fmt.Errorf("%w: no channel exports found", ErrInvalidArchive)
+	}
+
+	sortChannels(s.Channels)
+	return s, nil
+}
+
+func (d *discordArchive) Messages(ch *archiveChannel) ([]*archiveMessage, error) {
+	var export dceExport
+	if err := d.files.readJSON(ch.files[0], &export); err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(export.Messages))
+	for _, m := range export.Messages {
+		known[m.ID] = true
+	}
+
+	dir := path.Dir(ch.files[0])
+	messages := make([]*archiveMessage, 0, len(export.Messages))
+	for i := range export.Messages {
+		m := &export.Messages[i]
+		msg := &archiveMessage{
+			SourceID:  m.ID,
+			Content:   m.Content,
+			CreatedAt: m.Timestamp,
+			EditedAt:  m.TimestampEdited,
+			Pinned:    m.IsPinned,
+		}
+		// Only plain messages and replies are imported; joins, pins and other
+		// system messages are left out
+		if m.Type == "Default" || m.Type == "Reply" {
+			msg.Author = d.user(&m.Author)
+		}
+		if m.Reference != nil && known[m.Reference.MessageID] {
+			msg.ReplyTo = m.Reference.MessageID
+		}
+		for _, a := range m.Attachments {
+			attachment := &archiveAttachment{SourceID: a.ID, Filename: a.FileName, Size: a.FileSizeBytes}
+			if strings.HasPrefix(a.URL, "https://") || strings.HasPrefix(a.URL, "http://") {
+				attachment.URL = a.URL
+			} else {
+				attachment.Path = d.mediaPath(dir, a.URL)
+			}
+			msg.Attachments = append(msg.Attachments, attachment)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (d *discordArchive) user(a *dceAuthor) *archiveUser {
+	if u, ok := d.users[a.ID]; ok {
+		return u
+	}
+	display := a.Nickname
+	if display == "" {
+		display = a.Name
+	}
+	u := &archiveUser{SourceID: a.ID, Username: a.Name, DisplayName: display}
+	if strings.HasPrefix(a.AvatarURL, "https://") {
+		u.AvatarURL = &a.AvatarURL
+	}
+	for _, r := range a.Roles {
+		u.RoleIDs = append(u.RoleIDs, r.ID)
+	}
+	d.users[a.ID] = u
+	return u
+}
+
+// mediaPath resolves a --media path, which is relative to the export file and
+// may be URL-escaped
+func (d *discordArchive) mediaPath(dir, ref string) string {
+	candidate := path.Join(dir, ref)
+	if d.files.has(candidate) {
+		return candidate
+	}
+	if unescaped, err := url.PathUnescape(ref); err == nil {
+		return path.Join(dir, unescaped)
+	}
+	return candidate
+}
+
+func discordChannelType(t string) models.ChannelType {
+	switch t {
+	case "GuildNews", "GuildAnnouncement":
+		return models.ChannelTypeAnnouncement
+	case "GuildForum":
+		return models.ChannelTypeForum
+	case "GuildMedia":
+		return models.ChannelTypeGallery
+	default:
+		return models.ChannelTypeText
+	}
+}
@@ -0,0 +1,170 @@
+package importer
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", h.ListImports)
+	r.Post("/", h.CreateImport)
+
+	r.Route("/{importId}", func(r chi.Router) {
+		r.Get("/", h.GetImport)
+		r.Post("/start", h.StartImport)
+		r.Post("/resume", h.ResumeImport)
+		r.Post("/cancel", h.CancelImport)
+	})
+
+	return r
+}
+
+// ListImports returns the user's recent imports
+func (h *Handler) ListImports(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	jobs, err := h.service.ListImports(r.Context(), userID)
+	if err != nil {
+		h.respondImportError(w, err, "Failed to get imports")
+		return
+	}
+
+	utils.RespondSuccess(w, jobs)
+}
+
+// CreateImport creates an import and returns a URL to PUT the archive to
+func (h *Handler) CreateImport(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateImportRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	resp, err := h.service.CreateImport(r.Context(), userID, &req)
+	if err != nil {
+		h.respondImportError(w, err, "Failed to create import")
+		return
+	}
+
+	utils.RespondCreated(w, resp)
+}
+
+// GetImport returns an import's progress
+func (h *Handler) GetImport(w http.ResponseWriter, r *http.Request) {
+	userID, importID, ok := h.importParams(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.service.GetImport(r.Context(), importID, userID)
+	if err != nil {
+		h.respondImportError(w, err, "Failed to get import")
+		return
+	}
+
+	utils.RespondSuccess(w, job)
+}
+
+// StartImport queues an import once its archive has been uploaded
+func (h *Handler) StartImport(w http.ResponseWriter, r *http.Request) {
+	userID, importID, ok := h.importParams(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.service.StartImport(r.Context(), importID, userID)
+	if err != nil {
+		h.respondImportError(w, err, "Failed to start import")
+		return
+	}
+
+	utils.RespondSuccess(w, job)
+}
+
+// ResumeImport queues a failed import again from its last checkpoint
+func (h *Handler) ResumeImport(w http.ResponseWriter, r *http.Request) {
+	userID, importID, ok := h.importParams(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.service.ResumeImport(r.Context(), importID, userID)
+	if err != nil {
+		h.respondImportError(w, err, "Failed to resume import")
+		return
+	}
+
+	utils.RespondSuccess(w, job)
+}
+
+// CancelImport stops an import. What was already imported is kept.
+func (h *Handler) CancelImport(w http.ResponseWriter, r *http.Request) {
+	userID, importID, ok := h.importParams(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := h.service.CancelImport(r.Context(), importID, userID)
+	if err != nil {
+		h.respondImportError(w, err, "Failed to cancel import")
+		return
+	}
+
+	utils.RespondSuccess(w, job)
+}
+
+func (h *Handler) importParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	importID, err := uuid.Parse(chi.URLParam(r, "importId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid import ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, importID, true
+}
+
+func (h *Handler) respondImportError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrImportNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Import not found")
+	case errors.Is(err, ErrInvalidSource), errors.Is(err, ErrArchiveMissing):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrArchiveTooLarge):
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, ErrImportInProgress), errors.Is(err, ErrImportNotStartable),
+		errors.Is(err, ErrImportNotResumable), errors.Is(err, ErrImportFinished):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,44 @@
+package importer
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	importID := []openapi.Param{openapi.ID("importId")}
+
+	openapi.Describe((*Handler).ListImports, openapi.Operation{
+		Summary:  "List the current user's imports",
+		Response: []*models.ImportJob{},
+	})
+	openapi.Describe((*Handler).CreateImport, openapi.Operation{
+		Summary:     "Create an import",
+		Description: "Returns where to upload the export archive; start the import once it is uploaded.",
+		Body:        CreateImportRequest{},
+		Response:    CreateImportResponse{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetImport, openapi.Operation{
+		Summary:  "Get an import's progress",
+		Path:     importID,
+		Response: models.ImportJob{},
+	})
+	openapi.Describe((*Handler).StartImport, openapi.Operation{
+		Summary:  "Start an import once its archive is uploaded",
+		Path:     importID,
+		Response: models.ImportJob{},
+	})
+	openapi.Describe((*Handler).ResumeImport, openapi.Operation{
+		Summary:  "Resume a failed import where it stopped",
+		Path:     importID,
+		Response: models.ImportJob{},
+	})
+	openapi.Describe((*Handler).CancelImport, openapi.Operation{
+		Summary:  "Cancel an import",
+		Path:     importID,
+		Response: models.ImportJob{},
+	})
+}
@@ -0,0 +1,183 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/exporter"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// peridotiteArchive reads a community export from another instance. Only
+// media inside the archive is imported; links back to the exporting instance
+// are not followed.
+type peridotiteArchive struct {
+	files *archiveFiles
+	users map[uuid.UUID]*archiveUser
+}
+
+func newPeridotiteArchive(files *archiveFiles) *peridotiteArchive {
+	return &peridotiteArchive{files: files, users: make(map[uuid.UUID]*archiveUser)}
+}
+
+func (p *peridotiteArchive) Structure() (*archiveStructure, error) {
+	var manifest exporter.Manifest
+	if err := p.files.readJSON(exporter.ManifestFile, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Format != exporter.FormatName || manifest.Version < 1 || manifest.Version > exporter.FormatVersion {
+		return nil, fmt.Errorf("%w: unsupported export format %q version %d", ErrInvalidArchive, manifest.Format, manifest.Version)
+	}
+
+	var (
+		roles      []exporter.Role
+		categories []exporter.Category
+		channels   []exporter.Channel
+		users      []exporter.User
+		members    []exporter.Member
+	)
+	for name, v := range map[string]any{
+		exporter.RolesFile:      &roles,
+		exporter.CategoriesFile: &categories,
+		exporter.ChannelsFile:   &channels,
+		exporter.UsersFile:      &users,
+		exporter.MembersFile:    &members,
+	} {
+		if err := p.files.readJSON(name, v); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &archiveStructure{Name: manifest.Community.Name}
+	for _, r := range roles {
+		if r.IsDefault {
+			permissions := r.Permissions
+			s.DefaultRoleID, s.DefaultPermissions = r.ID.String(), &permissions
+			continue
+		}
+		s.Roles = append(s.Roles, &archiveRole{
+			SourceID:    r.ID.String(),
+			Name:        r.Name,
+			Color:       r.Color,
+			Position:    r.Position,
+			Permissions: r.Permissions,
+		})
+	}
+
+	for _, u := range users {
+		display := u.Username
+		if u.DisplayName != nil && *u.DisplayName != "" {
+			display = *u.DisplayName
+		}
+		p.users[u.ID] = &archiveUser{SourceID: u.ID.String(), Username: u.Username, DisplayName: display, AvatarURL: u.AvatarURL}
+	}
+	for _, m := range members {
+		u, ok := p.users[m.UserID]
+		if !ok {
+			continue
+		}
+		if m.Nickname != nil && *m.Nickname != "" {
+			u.DisplayName = *m.Nickname
+		}
+		for _, roleID := range m.RoleIDs {
+			u.RoleIDs = append(u.RoleIDs, roleID.String())
+		}
+		s.Users = append(s.Users, u)
+	}
+
+	categoryNames := make(map[uuid.UUID]string, len(categories))
+	for _, c := range categories {
+		categoryNames[c.ID] = c.Name
+	}
+	// Channels keep the export's order rather than being sorted by name
+	for _, c := range channels {
+		ch := &archiveChannel{
+			SourceID:     c.ID.String(),
+			Name:         c.Name,
+			Topic:        c.Topic,
+			Type:         models.ChannelType(c.Type),
+			MessageCount: c.MessageCount,
+			files:        []string{exporter.MessagesFile(c.ID)},
+		}
+		if c.CategoryID != nil {
+			ch.Category = categoryNames[*c.CategoryID]
+		}
+		for _, o := range c.Overwrites {
+			if o.TargetType == "role" {
+				ch.RoleOverwrites = append(ch.RoleOverwrites, &archiveOverwrite{RoleID: o.TargetID.String(), Allow: o.Allow, Deny: o.Deny})
+			}
+		}
+		s.Channels = append(s.Channels, ch)
+	}
+	if len(s.Channels) == 0 {
+		return nil, fmt.Errorf("%w: the export has no channels", ErrInvalidArchive)
+	}
+	return s, nil
+}
+
+func (p *peridotiteArchive) Messages(ch *archiveChannel) ([]*archiveMessage, error) {
+	name := ch.files[0]
+	if !p.files.has(name) {
+		return nil, nil // channels without history may have no file
+	}
+	rc, _, err := p.files.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var messages []*archiveMessage
+	known := make(map[uuid.UUID]bool)
+	dec := json.NewDecoder(io.LimitReader(rc, maxArchiveJSONSize))
+	for {
+		var m exporter.Message
+		if err := dec.Decode(&m); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, name, err)
+		}
+		known[m.ID] = true
+
+		msg := &archiveMessage{
+			SourceID:       m.ID.String(),
+			Author:         p.author(m.AuthorID),
+			Content:        m.Content,
+			ContentWarning: messaging.ContentWarningOrNil(m.ContentWarning),
+			CreatedAt:      m.CreatedAt,
+			Pinned:         m.IsPinned,
+		}
+		if m.IsEdited {
+			editedAt := m.UpdatedAt
+			msg.EditedAt = &editedAt
+		}
+		// Messages are oldest first, so a reply's target has been seen
+		if m.ReplyToID != nil && known[*m.ReplyToID] {
+			msg.ReplyTo = m.ReplyToID.String()
+		}
+		for _, a := range m.Attachments {
+			attachment := &archiveAttachment{SourceID: a.ID.String(), Filename: a.Filename, Size: a.Size, Path: a.Path}
+			if a.ContentType != nil {
+				attachment.ContentType = *a.ContentType
+			}
+			msg.Attachments = append(msg.Attachments, attachment)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// author finds a message's author. Users missing from users.json get a
+// placeholder rather than dropping their messages.
+func (p *peridotiteArchive) author(id uuid.UUID) *archiveUser {
+	if u, ok := p.users[id]; ok {
+		return u
+	}
+	u := &archiveUser{SourceID: id.String(), Username: "unknown", DisplayName: "Unknown user"}
+	p.users[id] = u
+	return u
+}
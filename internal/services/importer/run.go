@@ -0,0 +1,730 @@
+package importer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxContentLength matches what a regular message may hold; longer imported
+// messages are cut
+const maxContentLength = 4000
+
+// earliestMessageTime is the oldest timestamp an imported message may have.
+// Archive timestamps are clamped to between this and now, so a crafted
+// archive can't create a message partition for every month it likes.
+var earliestMessageTime = time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Run imports queued archives until ctx is cancelled. Jobs are claimed with a
+// lease that each checkpoint renews, so when an instance dies another one
+// picks the job up from its last checkpoint once the lease runs out.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		for s.runNext(ctx) {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// runNext processes one job and reports whether there was one
+func (s *Service) runNext(ctx context.Context) bool {
+	job, objectName, credentials, attempts, err := s.claim(ctx)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Error().Err(err).Msg("Failed to claim import job")
+		}
+		return false
+	}
+
+	if attempts > maxJobAttempts {
+		s.fail(ctx, job, errors.New("the import kept stopping unexpectedly"))
+		return true
+	}
+
+	log.Info().Str("importId", job.ID.String()).Str("source", string(job.Source)).Int("attempt", attempts).Msg("Running import")
+	s.publishProgress(ctx, job)
+
+	err = s.process(ctx, job, objectName, credentials)
+	switch {
+	case err == nil:
+		s.complete(ctx, job, objectName)
+	case errors.Is(err, errLeaseLost):
+		log.Info().Str("importId", job.ID.String()).Msg("Import stopped: cancelled or taken over")
+	case ctx.Err() != nil:
+		// Shutting down; the lease runs out and the job is picked up again
+	default:
+		log.Error().Err(err).Str("importId", job.ID.String()).Msg("Import failed")
+		s.fail(ctx, job, err)
+	}
+	return true
+}
+
+func (s *Service) claim(ctx context.Context) (*models.ImportJob, string, *string, int, error) {
+	var objectName string
+	var credentials *string
+	var attempts int
+	job, err := scanJob(s.db.QueryRow(ctx,
+		`UPDATE import_jobs j
+		SET status = $1, lease_owner = $2, lease_until = $3, attempts = attempts + 1,
+		    started_at = COALESCE(started_at, NOW()), updated_at = NOW()
+		FROM (
+			SELECT id FROM import_jobs
+			WHERE status IN ($4, $1) AND (lease_until IS NULL OR lease_until < NOW())
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		) due
+		WHERE j.id = due.id
+		RETURNING `+prefixedJobColumns("j.")+`, j.archive_object, j.credentials, j.attempts`,
+		models.ImportRunning, s.instanceID, time.Now().Add(jobLease), models.ImportQueued,
+	), &objectName, &credentials, &attempts)
+	return job, objectName, credentials, attempts, err
+}
+
+// importRun is the state of one pass over a job's archive
+type importRun struct {
+	job       *models.ImportJob
+	files     *archiveFiles
+	structure *archiveStructure
+	// placeholder user IDs by source user ID, and the community's member roles
+	users         map[string]uuid.UUID
+	defaultRoleID uuid.UUID
+	partitions    map[string]bool
+}
+
+func (s *Service) process(ctx context.Context, job *models.ImportJob, objectName string, credentials *string) error {
+	archive, err := os.CreateTemp("", "zentra-import-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	object, err := s.minio.GetObject(ctx, s.bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("download archive: %w", err)
+	}
+	size, err := io.Copy(archive, io.LimitReader(object, MaxArchiveSize+1))
+	object.Close()
+	if err != nil {
+		return fmt.Errorf("download archive: %w", err)
+	}
+	if size > MaxArchiveSize {
+		return ErrArchiveTooLarge
+	}
+
+	files, err := newArchiveFiles(archive, size)
+	if err != nil {
+		return err
+	}
+
+	var source archiveSource
+	switch job.Source {
+	case models.ImportSourceDiscord:
+		source = newDiscordArchive(files)
+	case models.ImportSourceSlack:
+		token := ""
+		if credentials != nil {
+			if token, err = s.keys.OpenString(*credentials, credentialsRow(job.ID)); err != nil {
+				return fmt.Errorf("decrypt slack token: %w", err)
+			}
+		}
+		source = newSlackArchive(files, token)
+	case models.ImportSourcePeridotite:
+		source = newPeridotiteArchive(files)
+	default:
+		return ErrInvalidSource
+	}
+
+	structure, err := source.Structure()
+	if err != nil {
+		return err
+	}
+
+	run := &importRun{
+		job:           job,
+		files:         files,
+		structure:     structure,
+		users:         make(map[string]uuid.UUID),
+		defaultRoleID: s.importID(job, "role", "@default"),
+		partitions:    make(map[string]bool),
+	}
+	if job.CommunityID == nil {
+		if err := s.importStructure(ctx, run); err != nil {
+			return err
+		}
+		s.publishProgress(ctx, job)
+	}
+
+	for job.ChannelIndex < len(structure.Channels) {
+		ch := structure.Channels[job.ChannelIndex]
+		messages, err := source.Messages(ch)
+		if err != nil {
+			return err
+		}
+		for {
+			end := min(job.MessageIndex+messageBatch, len(messages))
+			if err := s.importBatch(ctx, run, ch, messages[job.MessageIndex:end], end == len(messages)); err != nil {
+				return err
+			}
+			s.publishProgress(ctx, job)
+			if job.MessageIndex == 0 {
+				break // moved on to the next channel
+			}
+		}
+	}
+	return nil
+}
+
+// importStructure creates the community, roles, categories, channels and
+// members in one transaction together with the checkpoint
+func (s *Service) importStructure(ctx context.Context, run *importRun) error {
+	job, st := run.job, run.structure
+	communityID := s.importID(job, "community", "")
+
+	name := st.Name
+	if job.CommunityName != nil {
+		name = *job.CommunityName
+	}
+	name = truncate(strings.TrimSpace(name), 100)
+	if len(name) < 2 {
+		name = "Imported community"
+	}
+
+	totalMessages := 0
+	for _, ch := range st.Channels {
+		totalMessages += ch.MessageCount
+	}
+
+	return database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		now := time.Now().UTC()
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO communities (id, name, owner_id, is_public, is_open, member_count, created_at, updated_at)
+			VALUES ($1, $2, $3, FALSE, FALSE, 0, $4, $4)`,
+			communityID, name, job.OwnerID, now,
+		); err != nil {
+			return err
+		}
+
+		// Imported roles keep their order, below the owner's Administrator role
+		roles := append([]*archiveRole(nil), st.Roles...)
+		sort.SliceStable(roles, func(i, j int) bool { return roles[i].Position < roles[j].Position })
+		adminPosition := max(100, len(roles)+1)
+
+		defaultPermissions := models.PermissionDefaultMember
+		if st.DefaultPermissions != nil {
+			defaultPermissions = *st.DefaultPermissions
+		}
+
+		adminRoleID := s.importID(job, "role", "@admin")
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO roles (id, community_id, name, permissions, is_default, position)
+			VALUES ($1, $2, 'Administrator', $3, FALSE, $4), ($5, $2, 'Member', $6, TRUE, 0)`,
+			adminRoleID, communityID, models.PermissionAllAdmin, adminPosition, run.defaultRoleID, defaultPermissions,
+		); err != nil {
+			return err
+		}
+		for i, r := range roles {
+			if _, err := tx.Exec(ctx,
+				`INSERT INTO roles (id, community_id, name, color, permissions, is_default, position)
+				VALUES ($1, $2, $3, $4, $5, FALSE, $6)`,
+				s.importID(job, "role", r.SourceID), communityID, truncate(r.Name, 64), validColor(r.Color), r.Permissions, i+1,
+			); err != nil {
+				return err
+			}
+		}
+
+		ownerMemberID := uuid.New()
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO community_members (id, community_id, user_id, joined_at) VALUES ($1, $2, $3, NOW())`,
+			ownerMemberID, communityID, job.OwnerID,
+		); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO member_roles (member_id, role_id) VALUES ($1, $2), ($1, $3)`,
+			ownerMemberID, adminRoleID, run.defaultRoleID,
+		); err != nil {
+			return err
+		}
+
+		categories := make(map[string]uuid.UUID)
+		for _, ch := range st.Channels {
+			if ch.Category == "" {
+				continue
+			}
+			if _, ok := categories[ch.Category]; ok {
+				continue
+			}
+			categoryID := s.importID(job, "category", ch.Category)
+			if _, err := tx.Exec(ctx,
+				`INSERT INTO channel_categories (id, community_id, name, position, created_at) VALUES ($1, $2, $3, $4, $5)`,
+				categoryID, communityID, truncate(ch.Category, 64), len(categories), now,
+			); err != nil {
+				return err
+			}
+			categories[ch.Category] = categoryID
+		}
+
+		for i, ch := range st.Channels {
+			channelID := s.importID(job, "channel", ch.SourceID)
+			channelName := utils.NormalizeChannelName(ch.Name)
+			if channelName == "" {
+				channelName = "channel-" + channelID.String()[:8]
+			}
+			var categoryID *uuid.UUID
+			if id, ok := categories[ch.Category]; ok {
+				categoryID = &id
+			}
+			var topic *string
+			if ch.Topic != nil {
+				t := truncate(*ch.Topic, 1024)
+				topic = &t
+			}
+
+			if _, err := tx.Exec(ctx,
+				`INSERT INTO channels (id, community_id, category_id, name, topic, type, position, created_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)`,
+				channelID, communityID, categoryID, truncate(channelName, 64), topic, ch.Type, i, now,
+			); err != nil {
+				return err
+			}
+
+			// Private channels stay hidden from the default role
+			if ch.Private {
+				if _, err := tx.Exec(ctx,
+					`INSERT INTO channel_permissions (id, channel_id, target_type, target_id, allow_permissions, deny_permissions)
+					VALUES ($1, $2, 'role', $3, 0, $4)`,
+					uuid.New(), channelID, run.defaultRoleID, models.PermissionViewChannels,
+				); err != nil {
+					return err
+				}
+			}
+			for _, o := range ch.RoleOverwrites {
+				roleID := s.importID(job, "role", o.RoleID)
+				if o.RoleID == st.DefaultRoleID {
+					roleID = run.defaultRoleID
+				}
+				if _, err := tx.Exec(ctx,
+					`INSERT INTO channel_permissions (id, channel_id, target_type, target_id, allow_permissions, deny_permissions)
+					SELECT $1, $2, 'role', id, $4, $5 FROM roles WHERE id = $3
+					ON CONFLICT (channel_id, target_type, target_id) DO NOTHING`,
+					uuid.New(), channelID, roleID, o.Allow, o.Deny,
+				); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, u := range st.Users {
+			if _, err := s.ensureMember(ctx, tx, run, communityID, u); err != nil {
+				return err
+			}
+		}
+
+		job.CommunityID = &communityID
+		job.TotalChannels = len(st.Channels)
+		job.TotalMessages = totalMessages
+		return s.checkpoint(ctx, tx, job, 0, 0)
+	})
+}
+
+// storedAttachment is an attachment re-uploaded to the attachments bucket
+type storedAttachment struct {
+	id          uuid.UUID
+	filename    string
+	url         string
+	size        int64
+	contentType string
+}
+
+// importBatch imports a run of messages from one channel and checkpoints
+// after them in the same transaction. Attachments are uploaded first under
+// IDs derived from the source, so a batch that is retried overwrites rather
+// than duplicates them.
+func (s *Service) importBatch(ctx context.Context, run *importRun, ch *archiveChannel, messages []*archiveMessage, lastInChannel bool) error {
+	job := run.job
+	communityID := *job.CommunityID
+	channelID := s.importID(job, "channel", ch.SourceID)
+
+	stored := make([][]*storedAttachment, len(messages))
+	failedAttachments := 0
+	for i, m := range messages {
+		if m.Author == nil {
+			continue
+		}
+		for _, a := range m.Attachments {
+			attachmentID := s.importID(job, "attachment", ch.SourceID+":"+m.SourceID+":"+a.SourceID+":"+a.Filename)
+			sa, err := s.storeAttachment(ctx, run, job.Source, communityID, channelID, attachmentID, a)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Warn().Err(err).Str("importId", job.ID.String()).Str("file", a.Filename).Msg("Failed to import attachment")
+				failedAttachments++
+				continue
+			}
+			stored[i] = append(stored[i], sa)
+		}
+	}
+
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		imported, skipped, attachments := 0, 0, 0
+		messageRows := make([]messaging.MessageRow, 0, len(messages))
+		var attachmentRows []messaging.AttachmentRow
+		for i, m := range messages {
+			content := truncate(strings.TrimSpace(m.Content), maxContentLength)
+			if m.Author == nil || (content == "" && len(stored[i]) == 0) || m.CreatedAt.IsZero() {
+				skipped++
+				continue
+			}
+
+			authorID, err := s.ensureMember(ctx, tx, run, communityID, m.Author)
+			if err != nil {
+				return err
+			}
+
+			createdAt := clampMessageTime(m.CreatedAt)
+			month := createdAt.Format("2006-01")
+			if !run.partitions[month] {
+				if err := database.EnsureMessagePartition(ctx, tx, createdAt); err != nil {
+					return fmt.Errorf("prepare message partition: %w", err)
+				}
+				run.partitions[month] = true
+			}
+
+			messageID := s.importID(job, "message", ch.SourceID+":"+m.SourceID)
+			encrypted, _, err := s.cipher.Encrypt(messaging.ChannelRow(messageID), content)
+			if err != nil {
+				return err
+			}
+			var replyToID *uuid.UUID
+			if m.ReplyTo != "" {
+				id := s.importID(job, "message", ch.SourceID+":"+m.ReplyTo)
+				replyToID = &id
+			}
+			updatedAt := createdAt
+			if m.EditedAt != nil && m.EditedAt.After(createdAt) {
+				updatedAt = clampMessageTime(*m.EditedAt)
+			}
+
+			messageRows = append(messageRows, messaging.MessageRow{
+				ID:               messageID,
+				ChannelID:        channelID,
+				AuthorID:         authorID,
+				EncryptedContent: encrypted,
+				ContentWarning:   m.ContentWarning,
+				ReplyToID:        replyToID,
+				IsEdited:         m.EditedAt != nil,
+				IsPinned:         m.Pinned,
+				CreatedAt:        createdAt,
+				UpdatedAt:        updatedAt,
+				SearchTokens:     s.search.Tokens(channelID, content),
+			})
+
+			for _, a := range stored[i] {
+				attachmentRows = append(attachmentRows, messaging.AttachmentRow{
+					ID:               a.id,
+					MessageID:        messageID,
+					MessageCreatedAt: createdAt,
+					UploaderID:       authorID,
+					Filename:         a.filename,
+					FileURL:          a.url,
+					FileSize:         a.size,
+					ContentType:      &a.contentType,
+					CreatedAt:        createdAt,
+				})
+				attachments++
+			}
+			imported++
+		}
+
+		// A batch retried after a crash may find some of its rows already there
+		if _, err := messaging.MergeMessages(ctx, tx, messageRows); err != nil {
+			return fmt.Errorf("insert messages: %w", err)
+		}
+		if _, err := messaging.MergeAttachments(ctx, tx, attachmentRows); err != nil {
+			return fmt.Errorf("insert attachments: %w", err)
+		}
+
+		channelIndex, messageIndex := job.ChannelIndex, job.MessageIndex+len(messages)
+		if lastInChannel {
+			if _, err := tx.Exec(ctx,
+				`UPDATE channels SET last_message_at = (SELECT MAX(created_at) FROM messages WHERE channel_id = $1), updated_at = NOW()
+				WHERE id = $1`,
+				channelID,
+			); err != nil {
+				return err
+			}
+			channelIndex, messageIndex = channelIndex+1, 0
+		}
+
+		job.ImportedMessages += imported
+		job.SkippedMessages += skipped
+		job.ImportedAttachments += attachments
+		job.FailedAttachments += failedAttachments
+		return s.checkpoint(ctx, tx, job, channelIndex, messageIndex)
+	})
+	if err != nil {
+		return err
+	}
+	s.history.Invalidate(ctx, channelID)
+	return nil
+}
+
+// checkpoint records progress and renews the lease. It fails with
+// errLeaseLost if the job was cancelled or another worker took it over.
+func (s *Service) checkpoint(ctx context.Context, tx pgx.Tx, job *models.ImportJob, channelIndex, messageIndex int) error {
+	tag, err := tx.Exec(ctx,
+		`UPDATE import_jobs
+		SET community_id = $3, total_channels = $4, total_messages = $5, channel_index = $6, message_index = $7,
+		    imported_messages = $8, skipped_messages = $9, imported_attachments = $10, failed_attachments = $11,
+		    lease_until = $12, updated_at = NOW()
+		WHERE id = $1 AND lease_owner = $2 AND status = $13`,
+		job.ID, s.instanceID, job.CommunityID, job.TotalChannels, job.TotalMessages, channelIndex, messageIndex,
+		job.ImportedMessages, job.SkippedMessages, job.ImportedAttachments, job.FailedAttachments,
+		time.Now().Add(jobLease), models.ImportRunning,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errLeaseLost
+	}
+	job.ChannelIndex, job.MessageIndex = channelIndex, messageIndex
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *Service) complete(ctx context.Context, job *models.ImportJob, objectName string) {
+	now := time.Now()
+	_, err := s.db.Exec(ctx,
+		`UPDATE import_jobs
+		SET status = $3, credentials = NULL, lease_owner = NULL, lease_until = NULL, finished_at = $4, updated_at = $4
+		WHERE id = $1 AND lease_owner = $2`,
+		job.ID, s.instanceID, models.ImportCompleted, now,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("importId", job.ID.String()).Msg("Failed to complete import")
+		return
+	}
+	job.Status = models.ImportCompleted
+	job.FinishedAt = &now
+	if job.CommunityID != nil {
+		s.permissions.InvalidateCommunity(ctx, *job.CommunityID)
+	}
+
+	s.removeArchive(ctx, objectName)
+	details, _ := json.Marshal(map[string]any{
+		"importId":            job.ID,
+		"source":              job.Source,
+		"channels":            job.TotalChannels,
+		"messages":            job.ImportedMessages,
+		"attachments":         job.ImportedAttachments,
+		"failedAttachments":   job.FailedAttachments,
+		"skippedMessageCount": job.SkippedMessages,
+	})
+	s.communityService.LogAudit(ctx, job.CommunityID, job.OwnerID, models.AuditActionCommunityImport, "community", job.CommunityID, details)
+	log.Info().Str("importId", job.ID.String()).Int("messages", job.ImportedMessages).Msg("Import completed")
+	s.publishProgress(ctx, job)
+}
+
+// fail stops the job with an error the owner can see. The archive is kept so
+// the job can be resumed.
+func (s *Service) fail(ctx context.Context, job *models.ImportJob, cause error) {
+	message := cause.Error()
+	if !errors.Is(cause, ErrInvalidArchive) && !errors.Is(cause, ErrArchiveTooLarge) {
+		message = "the import stopped unexpectedly, resume it to continue"
+	}
+	now := time.Now()
+	_, err := s.db.Exec(ctx,
+		`UPDATE import_jobs
+		SET status = $3, error = $4, lease_owner = NULL, lease_until = NULL, finished_at = $5, updated_at = $5
+		WHERE id = $1 AND lease_owner = $2`,
+		job.ID, s.instanceID, models.ImportFailed, message, now,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("importId", job.ID.String()).Msg("Failed to mark import as failed")
+		return
+	}
+	job.Status = models.ImportFailed
+	job.Error = &message
+	job.FinishedAt = &now
+	s.publishProgress(ctx, job)
+}
+
+// importID derives the ID of something created by a job from its source ID,
+// so retried batches and resumed jobs find what they already created
+func (s *Service) importID(job *models.ImportJob, kind, sourceID string) uuid.UUID {
+	return uuid.NewSHA1(job.ID, []byte(kind+":"+sourceID))
+}
+
+var placeholderUsernameSanitizer = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// ensureMember makes sure a source user has a placeholder account that is a
+// member of the community with their roles, and returns the account's ID.
+// Placeholders belong to one community: imports into the same community
+// reuse them so one person isn't several users, but an import elsewhere
+// can't pick up accounts made for another community. Nobody can sign in to
+// them.
+func (s *Service) ensureMember(ctx context.Context, tx pgx.Tx, run *importRun, communityID uuid.UUID, u *archiveUser) (uuid.UUID, error) {
+	if id, ok := run.users[u.SourceID]; ok {
+		return id, nil
+	}
+
+	passwordHash, err := s.placeholderPasswordHash()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	sum := sha1.Sum([]byte(communityID.String() + ":" + string(run.job.Source) + ":" + u.SourceID))
+	hash := hex.EncodeToString(sum[:])
+	email := fmt.Sprintf("import+%s@zentra.import", hash[:24])
+	displayName := truncate(strings.TrimSpace(u.DisplayName), 64)
+	if displayName == "" {
+		displayName = "Imported user"
+	}
+
+	base := placeholderUsernameSanitizer.ReplaceAllString(strings.ToLower(u.Username), "")
+	if base == "" {
+		base = string(run.job.Source) + "user"
+	}
+	candidates := []string{truncate(base, 20) + "_" + hash[:6], "imported_" + hash[:20]}
+
+	var userID uuid.UUID
+	for _, username := range candidates {
+		err = tx.QueryRow(ctx,
+			`INSERT INTO users (id, username, email, password_hash, display_name, avatar_url, status, email_verified, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, 'offline', TRUE, NOW(), NOW())
+			ON CONFLICT DO NOTHING
+			RETURNING id`,
+			uuid.New(), username, email, passwordHash, displayName, u.AvatarURL,
+		).Scan(&userID)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, err
+		}
+		// Either the placeholder exists already or the username is taken
+		err = tx.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, email).Scan(&userID)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, err
+		}
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("create placeholder for %s: %w", u.SourceID, err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO community_members (id, community_id, user_id, joined_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (community_id, user_id) DO NOTHING`,
+		s.importID(run.job, "member", u.SourceID), communityID, userID,
+	); err != nil {
+		return uuid.Nil, err
+	}
+
+	roleIDs := []uuid.UUID{run.defaultRoleID}
+	for _, r := range u.RoleIDs {
+		roleIDs = append(roleIDs, s.importID(run.job, "role", r))
+	}
+	if _, err := tx.Exec(ctx,
+		`INSERT INTO member_roles (member_id, role_id)
+		SELECT m.id, r.id FROM community_members m
+		JOIN roles r ON r.community_id = m.community_id AND r.id = ANY($3)
+		WHERE m.community_id = $1 AND m.user_id = $2
+		ON CONFLICT DO NOTHING`,
+		communityID, userID, roleIDs,
+	); err != nil {
+		return uuid.Nil, err
+	}
+
+	run.users[u.SourceID] = userID
+	return userID, nil
+}
+
+// placeholderPasswordHash is a hash of a random password nobody knows
+func (s *Service) placeholderPasswordHash() (string, error) {
+	s.placeholderOnce.Do(func() {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			s.placeholderErr = err
+			return
+		}
+		hash, err := bcrypt.GenerateFromPassword(secret, bcrypt.DefaultCost)
+		s.placeholderHash, s.placeholderErr = string(hash), err
+	})
+	return s.placeholderHash, s.placeholderErr
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+func validColor(color *string) *string {
+	if color == nil || !hexColorPattern.MatchString(*color) {
+		return nil
+	}
+	return color
+}
+
+// truncate cuts s to at most n characters
+// clampMessageTime keeps an archive timestamp between earliestMessageTime
+// and now
+func clampMessageTime(t time.Time) time.Time {
+	t = t.UTC()
+	if t.Before(earliestMessageTime) {
+		return earliestMessageTime
+	}
+	if now := time.Now().UTC(); t.After(now) {
+		return now
+	}
+	return t
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
+
+func prefixedJobColumns(prefix string) string {
+	columns := strings.Split(jobColumns, ",")
+	for i, c := range columns {
+		columns[i] = prefix + strings.TrimSpace(c)
+	}
+	return strings.Join(columns, ", ")
+}
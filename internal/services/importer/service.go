@@ -0,0 +1,427 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/services/permcache"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	// MaxArchiveSize is the largest export archive accepted
+	MaxArchiveSize = 4 << 30
+
+	uploadURLExpiry = time.Hour
+	pollInterval    = 5 * time.Second
+	jobLease        = 2 * time.Minute
+	// A job whose lease ran out this many times is crashing its worker
+	maxJobAttempts = 5
+	messageBatch   = 100
+	// Jobs nobody uploaded an archive for, and failed jobs nobody resumed,
+	// are cleaned up after this long
+	abandonedImportAge = 7 * 24 * time.Hour
+)
+
+var (
+	ErrImportNotFound     = errors.New("import not found")
+	ErrInvalidSource      = errors.New("source must be discord, slack or peridotite")
+	ErrImportInProgress   = errors.New("you already have an import in progress")
+	ErrArchiveMissing     = errors.New("the archive has not been uploaded")
+	ErrArchiveTooLarge    = errors.New("the archive is too large")
+	ErrInvalidArchive     = errors.New("invalid export archive")
+	ErrImportNotStartable = errors.New("import is not awaiting an upload")
+	ErrImportNotResumable = errors.New("only failed imports can be resumed")
+	ErrImportFinished     = errors.New("import has already finished")
+
+	// errLeaseLost stops a worker whose job was cancelled or taken over
+	errLeaseLost = errors.New("import lease lost")
+)
+
+// CommunityServiceInterface is what the importer needs from the community service
+type CommunityServiceInterface interface {
+	LogAudit(ctx context.Context, communityID *uuid.UUID, actorID uuid.UUID, action string, targetType string, targetID *uuid.UUID, details []byte)
+}
+
+type Service struct {
+	db                *pgxpool.Pool
+	minio             *minio.Client
+	bucket            string
+	bucketAttachments string
+	cdnBaseURL        string
+	keys              *encryption.Keyring
+	cipher            messaging.ContentCipher
+	communityService  CommunityServiceInterface
+	permissions       *permcache.Cache
+	search            *messaging.SearchIndex
+	history           *messaging.HistoryCache
+	httpClient        *http.Client
+	instanceID        uuid.UUID
+	wake              chan struct{}
+
+	placeholderOnce sync.Once
+	placeholderHash string
+	placeholderErr  error
+}
+
+// NewService creates the importer. Archives are uploaded to bucket, which must
+// not be public; imported attachments go to the attachments bucket like
+// regular uploads.
+func NewService(db *pgxpool.Pool, minioClient *minio.Client, bucket, bucketAttachments, cdnBaseURL string, keys *encryption.Keyring, communityService CommunityServiceInterface) *Service {
+	return &Service{
+		db:                db,
+		minio:             minioClient,
+		bucket:            bucket,
+		bucketAttachments: bucketAttachments,
+		cdnBaseURL:        cdnBaseURL,
+		keys:              keys,
+		cipher:            messaging.NewChannelCipher(keys),
+		communityService:  communityService,
+		httpClient:        &http.Client{Timeout: 2 * time.Minute},
+		instanceID:        uuid.New(),
+		wake:              make(chan struct{}, 1),
+	}
+}
+
+// SetPermissionCache makes a finished import invalidate the community's cached
+// channel permissions, since its channels and members are written directly.
+func (s *Service) SetPermissionCache(c *permcache.Cache) {
+	s.permissions = c
+}
+
+// SetHistoryCache drops the cached history of channels messages are imported
+// into
+func (s *Service) SetHistoryCache(c *messaging.HistoryCache) {
+	s.history = c
+}
+
+// SetSearchIndex makes imported messages searchable with i
+func (s *Service) SetSearchIndex(i *messaging.SearchIndex) {
+	s.search = i
+}
+
+// EnsureBucket creates the private archive bucket if it is missing
+func (s *Service) EnsureBucket(ctx context.Context) error {
+	exists, err := s.minio.BucketExists(ctx, s.bucket)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	if err := s.minio.MakeBucket(ctx, s.bucket, minio.MakeBucketOptions{}); err != nil {
+		return err
+	}
+	log.Info().Str("bucket", s.bucket).Msg("Created MinIO bucket")
+	return nil
+}
+
+type CreateImportRequest struct {
+	Source models.ImportSource `json:"source" validate:"required,oneof=discord slack peridotite"`
+	// Name overrides the community name taken from the export
+	Name *string `json:"name" validate:"omitempty,min=2,max=100"`
+	// SlackToken downloads files a Slack export links to (needs files:read).
+	// It is stored encrypted until the import finishes.
+	SlackToken *string `json:"slackToken" validate:"omitempty,max=256"`
+}
+
+type CreateImportResponse struct {
+	Import          *models.ImportJob `json:"import"`
+	UploadURL       string            `json:"uploadUrl"`
+	UploadExpiresAt time.Time         `json:"uploadExpiresAt"`
+}
+
+const jobColumns = `id, owner_id, source, status, community_name, community_id, archive_size, total_channels, channel_index,
+	message_index, total_messages, imported_messages, skipped_messages, imported_attachments, failed_attachments, error,
+	created_at, updated_at, started_at, finished_at`
+
+// credentialsRow is the row an import's credentials are sealed for
+func credentialsRow(jobID uuid.UUID) encryption.Row {
+	return encryption.Row{Table: "import_jobs", ID: jobID}
+}
+
+// CreateImport registers an import and returns a URL the archive is PUT to.
+// Nothing happens until StartImport is called after the upload.
+func (s *Service) CreateImport(ctx context.Context, userID uuid.UUID, req *CreateImportRequest) (*CreateImportResponse, error) {
+	if req.Source != models.ImportSourceDiscord && req.Source != models.ImportSourceSlack && req.Source != models.ImportSourcePeridotite {
+		return nil, ErrInvalidSource
+	}
+
+	var active bool
+	if err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM import_jobs WHERE owner_id = $1 AND status IN ($2, $3, $4))`,
+		userID, models.ImportAwaitingUpload, models.ImportQueued, models.ImportRunning,
+	).Scan(&active); err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, ErrImportInProgress
+	}
+
+	jobID := uuid.New()
+	var credentials *string
+	if req.SlackToken != nil && req.Source == models.ImportSourceSlack && strings.TrimSpace(*req.SlackToken) != "" {
+		encrypted, err := s.keys.SealString(strings.TrimSpace(*req.SlackToken), credentialsRow(jobID))
+		if err != nil {
+			return nil, err
+		}
+		credentials = &encrypted
+	}
+
+	objectName := jobID.String() + ".zip"
+	job, err := scanJob(s.db.QueryRow(ctx,
+		`INSERT INTO import_jobs (id, owner_id, source, community_name, archive_object, credentials)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+jobColumns,
+		jobID, userID, req.Source, req.Name, objectName, credentials,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	uploadURL, err := s.minio.PresignedPutObject(ctx, s.bucket, objectName, uploadURLExpiry)
+	if err != nil {
+		return nil, fmt.Errorf("presign archive upload: %w", err)
+	}
+
+	return &CreateImportResponse{
+		Import:          job,
+		UploadURL:       uploadURL.String(),
+		UploadExpiresAt: time.Now().Add(uploadURLExpiry),
+	}, nil
+}
+
+// StartImport queues an import once its archive has been uploaded
+func (s *Service) StartImport(ctx context.Context, jobID, userID uuid.UUID) (*models.ImportJob, error) {
+	job, objectName, err := s.ownedJob(ctx, jobID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != models.ImportAwaitingUpload {
+		return nil, ErrImportNotStartable
+	}
+
+	info, err := s.minio.StatObject(ctx, s.bucket, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrArchiveMissing
+		}
+		return nil, err
+	}
+	if info.Size > MaxArchiveSize {
+		s.removeArchive(ctx, objectName)
+		return nil, ErrArchiveTooLarge
+	}
+
+	job, err = scanJob(s.db.QueryRow(ctx,
+		`UPDATE import_jobs SET status = $3, archive_size = $4, updated_at = NOW()
+		WHERE id = $1 AND owner_id = $2 AND status = $5
+		RETURNING `+jobColumns,
+		jobID, userID, models.ImportQueued, info.Size, models.ImportAwaitingUpload,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrImportNotStartable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify()
+	s.publishProgress(ctx, job)
+	return job, nil
+}
+
+// ResumeImport queues a failed import again. It continues from its last
+// checkpoint rather than starting over.
+func (s *Service) ResumeImport(ctx context.Context, jobID, userID uuid.UUID) (*models.ImportJob, error) {
+	job, err := scanJob(s.db.QueryRow(ctx,
+		`UPDATE import_jobs SET status = $3, attempts = 0, error = NULL, finished_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND owner_id = $2 AND status = $4
+		RETURNING `+jobColumns,
+		jobID, userID, models.ImportQueued, models.ImportFailed,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, _, err := s.ownedJob(ctx, jobID, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrImportNotResumable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify()
+	s.publishProgress(ctx, job)
+	return job, nil
+}
+
+// CancelImport stops an import and deletes its archive. Whatever was already
+// imported stays; the owner can delete the community if they don't want it.
+func (s *Service) CancelImport(ctx context.Context, jobID, userID uuid.UUID) (*models.ImportJob, error) {
+	var objectName string
+	var job *models.ImportJob
+	err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		if err := tx.QueryRow(ctx,
+			`SELECT archive_object FROM import_jobs WHERE id = $1 AND owner_id = $2 FOR UPDATE`,
+			jobID, userID,
+		).Scan(&objectName); err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrImportNotFound
+			}
+			return err
+		}
+
+		var err error
+		job, err = scanJob(tx.QueryRow(ctx,
+			`UPDATE import_jobs
+			SET status = $2, credentials = NULL, lease_owner = NULL, lease_until = NULL, finished_at = NOW(), updated_at = NOW()
+			WHERE id = $1 AND status NOT IN ($3, $2)
+			RETURNING `+jobColumns,
+			jobID, models.ImportCancelled, models.ImportCompleted,
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrImportFinished
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.removeArchive(ctx, objectName)
+	s.publishProgress(ctx, job)
+	return job, nil
+}
+
+func (s *Service) GetImport(ctx context.Context, jobID, userID uuid.UUID) (*models.ImportJob, error) {
+	job, _, err := s.ownedJob(ctx, jobID, userID)
+	return job, err
+}
+
+// ListImports returns the user's imports, newest first
+func (s *Service) ListImports(ctx context.Context, userID uuid.UUID) ([]*models.ImportJob, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT `+jobColumns+` FROM import_jobs WHERE owner_id = $1 ORDER BY created_at DESC LIMIT 50`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []*models.ImportJob{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// PruneAbandoned cancels imports whose archive never arrived and failed
+// imports nobody resumed, and deletes their archives
+func (s *Service) PruneAbandoned(ctx context.Context) (int64, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE import_jobs
+		SET status = $1, credentials = NULL, finished_at = COALESCE(finished_at, NOW()), updated_at = NOW()
+		WHERE status IN ($2, $3) AND updated_at < $4
+		RETURNING archive_object`,
+		models.ImportCancelled, models.ImportAwaitingUpload, models.ImportFailed, time.Now().Add(-abandonedImportAge),
+	)
+	if err != nil {
+		return 0, err
+	}
+	var objects []string
+	for rows.Next() {
+		var objectName string
+		if err := rows.Scan(&objectName); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		objects = append(objects, objectName)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, objectName := range objects {
+		s.removeArchive(ctx, objectName)
+	}
+	return int64(len(objects)), nil
+}
+
+func (s *Service) ownedJob(ctx context.Context, jobID, userID uuid.UUID) (*models.ImportJob, string, error) {
+	var objectName string
+	row := s.db.QueryRow(ctx,
+		`SELECT `+jobColumns+`, archive_object FROM import_jobs WHERE id = $1 AND owner_id = $2`,
+		jobID, userID,
+	)
+	job, err := scanJob(row, &objectName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", ErrImportNotFound
+	}
+	return job, objectName, err
+}
+
+func (s *Service) removeArchive(ctx context.Context, objectName string) {
+	if err := s.minio.RemoveObject(ctx, s.bucket, objectName, minio.RemoveObjectOptions{}); err != nil {
+		log.Warn().Err(err).Str("object", objectName).Msg("Failed to delete import archive")
+	}
+}
+
+// notify wakes the worker so a queued import starts without waiting for the poll
+func (s *Service) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// publishProgress sends the job to its owner's open connections
+func (s *Service) publishProgress(ctx context.Context, job *models.ImportJob) {
+	payload, err := json.Marshal(map[string]any{
+		"channelId": database.UserStream(job.OwnerID.String()),
+		"event": map[string]any{
+			"type": "IMPORT_PROGRESS",
+			"data": job,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal import progress")
+		return
+	}
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Msg("Failed to publish import progress")
+	}
+}
+
+// scanJob reads jobColumns, followed by any extra columns into extra
+func scanJob(row pgx.Row, extra ...any) (*models.ImportJob, error) {
+	j := &models.ImportJob{}
+	dest := []any{
+		&j.ID, &j.OwnerID, &j.Source, &j.Status, &j.CommunityName, &j.CommunityID, &j.ArchiveSize, &j.TotalChannels,
+		&j.ChannelIndex, &j.MessageIndex, &j.TotalMessages, &j.ImportedMessages, &j.SkippedMessages, &j.ImportedAttachments,
+		&j.FailedAttachments, &j.Error, &j.CreatedAt, &j.UpdatedAt, &j.StartedAt, &j.FinishedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
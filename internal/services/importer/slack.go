@@ -0,0 +1,346 @@
+package importer
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zentra/server/internal/models"
+)
+
+// slackArchive reads a Slack workspace export: channels.json (and groups.json
+// for private channels), users.json and a folder of daily JSON files per
+// channel. Files are linked rather than included, so downloading them needs a
+// token with files:read unless the export carries file tokens.
+type slackArchive struct {
+	files    *archiveFiles
+	token    string
+	users    map[string]*archiveUser
+	channels map[string]string // channel ID to name, for #channel links
+	pins     map[string]map[string]bool
+}
+
+type slackChannel struct {
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name"`
+	Topic   struct{ Value string } `json:"topic"`
+	Purpose struct{ Value string } `json:"purpose"`
+	Pins    []struct {
+		ID string `json:"id"`
+	} `json:"pins"`
+}
+
+type slackUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	IsAdmin  bool   `json:"is_admin"`
+	IsOwner  bool   `json:"is_owner"`
+	Profile  struct {
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+		Image72     string `json:"image_72"`
+	} `json:"profile"`
+}
+
+type slackMessage struct {
+	Type     string   `json:"type"`
+	Subtype  string   `json:"subtype"`
+	User     string   `json:"user"`
+	BotID    string   `json:"bot_id"`
+	Username string   `json:"username"`
+	Text     string   `json:"text"`
+	TS       string   `json:"ts"`
+	ThreadTS string   `json:"thread_ts"`
+	PinnedTo []string `json:"pinned_to"`
+	Edited   *struct {
+		TS string `json:"ts"`
+	} `json:"edited"`
+	Files []struct {
+		ID                 string `json:"id"`
+		Name               string `json:"name"`
+		Mimetype           string `json:"mimetype"`
+		Size               int64  `json:"size"`
+		Mode               string `json:"mode"`
+		URLPrivate         string `json:"url_private"`
+		URLPrivateDownload string `json:"url_private_download"`
+	} `json:"files"`
+}
+
+// slackAdminRole is the one role a Slack import creates, for workspace admins
+// and owners
+const slackAdminRole = "workspace-admins"
+
+// slackSkippedSubtypes are system messages that aren't worth importing
+var slackSkippedSubtypes = map[string]bool{
+	"channel_join":      true,
+	"channel_leave":     true,
+	"channel_topic":     true,
+	"channel_purpose":   true,
+	"channel_name":      true,
+	"channel_archive":   true,
+	"channel_unarchive": true,
+	"group_join":        true,
+	"group_leave":       true,
+	"group_topic":       true,
+	"group_purpose":     true,
+	"group_name":        true,
+	"pinned_item":       true,
+	"unpinned_item":     true,
+	"bot_add":           true,
+	"bot_remove":        true,
+	"tombstone":         true,
+}
+
+func newSlackArchive(files *archiveFiles, token string) *slackArchive {
+	return &slackArchive{
+		files:    files,
+		token:    token,
+		users:    make(map[string]*archiveUser),
+		channels: make(map[string]string),
+		pins:     make(map[string]map[string]bool),
+	}
+}
+
+func (s *slackArchive) Structure() (*archiveStructure, error) {
+	if !s.files.has("channels.json") || !s.files.has("users.json") {
+		return nil, fmt.Errorf("%w: channels.json and users.json are required", ErrInvalidArchive)
+	}
+
+	var users []slackUser
+	if err := s.files.readJSON("users.json", &users); err != nil {
+		return nil, err
+	}
+	structure := &archiveStructure{Name: "Slack workspace"}
+	for _, su := range users {
+		u := s.addUser(su)
+		if su.IsAdmin || su.IsOwner {
+			u.RoleIDs = []string{slackAdminRole}
+			if len(structure.Roles) == 0 {
+				structure.Roles = append(structure.Roles, &archiveRole{SourceID: slackAdminRole, Name: "Workspace Admin", Position: 1})
+			}
+		}
+		structure.Users = append(structure.Users, u)
+	}
+
+	for _, list := range []struct {
+		file    string
+		private bool
+	}{{"channels.json", false}, {"groups.json", true}} {
+		if !s.files.has(list.file) {
+			continue
+		}
+		var channels []slackChannel
+		if err := s.files.readJSON(list.file, &channels); err != nil {
+			return nil, err
+		}
+		for _, sc := range channels {
+			s.channels[sc.ID] = sc.Name
+			pins := make(map[string]bool, len(sc.Pins))
+			for _, p := range sc.Pins {
+				pins[p.ID] = true
+			}
+			s.pins[sc.ID] = pins
+
+			topic := sc.Topic.Value
+			if topic == "" {
+				topic = sc.Purpose.Value
+			}
+			prefix := sc.Name + "/"
+			ch := &archiveChannel{
+				SourceID: sc.ID,
+				Name:     sc.Name,
+				Topic:    optionalString(html.UnescapeString(topic)),
+				Type:     models.ChannelTypeText,
+				Private:  list.private,
+				files: s.files.names(func(name string) bool {
+					return strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".json") && !strings.Contains(name[len(prefix):], "/")
+				}),
+			}
+			structure.Channels = append(structure.Channels, ch)
+		}
+	}
+	if len(structure.Channels) == 0 {
+		return nil, fmt.Errorf("%w: the export has no channels", ErrInvalidArchive)
+	}
+	sortChannels(structure.Channels)
+
+	for _, ch := range structure.Channels {
+		messages, err := s.Messages(ch)
+		if err != nil {
+			return nil, err
+		}
+		ch.MessageCount = len(messages)
+	}
+	return structure, nil
+}
+
+func (s *slackArchive) Messages(ch *archiveChannel) ([]*archiveMessage, error) {
+	var raw []slackMessage
+	for _, name := range ch.files {
+		var day []slackMessage
+		if err := s.files.readJSON(name, &day); err != nil {
+			return nil, err
+		}
+		raw = append(raw, day...)
+	}
+	sort.SliceStable(raw, func(i, j int) bool { return slackTime(raw[i].TS).Before(slackTime(raw[j].TS)) })
+
+	known := make(map[string]bool, len(raw))
+	for _, m := range raw {
+		known[m.TS] = true
+	}
+
+	messages := make([]*archiveMessage, 0, len(raw))
+	for _, m := range raw {
+		msg := &archiveMessage{
+			SourceID:  m.TS,
+			Content:   s.convertText(m.Text),
+			CreatedAt: slackTime(m.TS),
+			Pinned:    s.pins[ch.SourceID][m.TS],
+		}
+		if m.Type == "message" && !slackSkippedSubtypes[m.Subtype] {
+			msg.Author = s.author(&m)
+		}
+		for _, pinned := range m.PinnedTo {
+			if pinned == ch.SourceID {
+				msg.Pinned = true
+			}
+		}
+		if m.Edited != nil {
+			edited := slackTime(m.Edited.TS)
+			msg.EditedAt = &edited
+		}
+		if m.ThreadTS != "" && m.ThreadTS != m.TS && known[m.ThreadTS] {
+			msg.ReplyTo = m.ThreadTS
+		}
+		for _, f := range m.Files {
+			download := f.URLPrivateDownload
+			if download == "" {
+				download = f.URLPrivate
+			}
+			if download == "" || f.Mode == "tombstone" || f.Mode == "hidden_by_limit" {
+				continue
+			}
+			msg.Attachments = append(msg.Attachments, &archiveAttachment{
+				SourceID:    f.ID,
+				Filename:    f.Name,
+				ContentType: f.Mimetype,
+				Size:        f.Size,
+				URL:         download,
+				Token:       s.token,
+			})
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+func (s *slackArchive) addUser(su slackUser) *archiveUser {
+	display := su.Profile.DisplayName
+	if display == "" {
+		display = su.Profile.RealName
+	}
+	if display == "" {
+		display = su.RealName
+	}
+	if display == "" {
+		display = su.Name
+	}
+	u := &archiveUser{SourceID: su.ID, Username: su.Name, DisplayName: display}
+	if strings.HasPrefix(su.Profile.Image72, "https://") {
+		u.AvatarURL = &su.Profile.Image72
+	}
+	s.users[su.ID] = u
+	return u
+}
+
+// author finds who sent a message. Bot and integration messages often have no
+// user, so they get a placeholder named after the bot.
+func (s *slackArchive) author(m *slackMessage) *archiveUser {
+	if u, ok := s.users[m.User]; ok {
+		return u
+	}
+	id := m.User
+	if id == "" {
+		id = "bot:" + m.BotID
+		if m.BotID == "" {
+			id = "bot:" + m.Username
+		}
+	}
+	if u, ok := s.users[id]; ok {
+		return u
+	}
+	name := m.Username
+	if name == "" {
+		name = "slack-bot"
+	}
+	u := &archiveUser{SourceID: id, Username: name, DisplayName: name}
+	s.users[id] = u
+	return u
+}
+
+var (
+	slackLinkPattern   = regexp.MustCompile(`<([^<>|]+)(?:\|([^<>]*))?>`)
+	slackBoldPattern   = regexp.MustCompile(`(^|[\s(])\*([^*\n]+)\*`)
+	slackStrikePattern = regexp.MustCompile(`(^|[\s(])~([^~\n]+)~`)
+)
+
+// convertText turns Slack mrkdwn into the markdown Zentra renders: user,
+// channel and special mentions become plain @name/#name text, links become
+// markdown links and *bold*/~strike~ get doubled markers
+func (s *slackArchive) convertText(text string) string {
+	text = slackLinkPattern.ReplaceAllStringFunc(text, func(token string) string {
+		parts := slackLinkPattern.FindStringSubmatch(token)
+		target, label := parts[1], parts[2]
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if u, ok := s.users[target[1:]]; ok {
+				return "@" + u.DisplayName
+			}
+			if label != "" {
+				return "@" + label
+			}
+			return target
+		case strings.HasPrefix(target, "#"):
+			if label == "" {
+				label = s.channels[target[1:]]
+			}
+			return "#" + label
+		case strings.HasPrefix(target, "!"):
+			switch strings.SplitN(target[1:], "^", 2)[0] {
+			case "channel", "everyone":
+				return "@everyone"
+			case "here":
+				return "@here"
+			}
+			return label
+		case label != "" && label != target:
+			return "[" + label + "](" + target + ")"
+		default:
+			return strings.TrimPrefix(target, "mailto:")
+		}
+	})
+	text = slackBoldPattern.ReplaceAllString(text, "$1**$2**")
+	text = slackStrikePattern.ReplaceAllString(text, "$1~~$2~~")
+	return html.UnescapeString(text)
+}
+
+// slackTime parses a message timestamp such as "1500000000.000100"
+func slackTime(ts string) time.Time {
+	seconds, fraction, _ := strings.Cut(ts, ".")
+	sec, err := strconv.ParseInt(seconds, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	var micros int64
+	if fraction != "" {
+		fraction = (fraction + "000000")[:6]
+		micros, _ = strconv.ParseInt(fraction, 10, 64)
+	}
+	return time.Unix(sec, micros*1000).UTC()
+}
@@ -0,0 +1,117 @@
+package leveling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// PluginSlug is the slug leveling is seeded under in the plugins table.
+const PluginSlug = "leveling"
+
+const (
+	DefaultMinXP           = 15
+	DefaultMaxXP           = 25
+	MaxXPPerMessage        = 1000
+	DefaultCooldownSeconds = 60
+	MaxCooldownSeconds     = 24 * 60 * 60
+	MaxIgnoredChannels     = 100
+	MaxRoleRewards         = 50
+)
+
+var ErrInvalidConfig = errors.New("invalid leveling config")
+
+// Config is the per-community leveling config stored in community_plugins.config
+type Config struct {
+	// Each message outside the cooldown earns a random amount in [MinXP, MaxXP]
+	MinXP int `json:"minXp,omitempty"`
+	MaxXP int `json:"maxXp,omitempty"`
+	// How long after earning XP a member's messages earn nothing
+	CooldownSeconds   int          `json:"cooldownSeconds,omitempty"`
+	IgnoredChannelIDs []uuid.UUID  `json:"ignoredChannelIds,omitempty"`
+	RoleRewards       []RoleReward `json:"roleRewards,omitempty"`
+}
+
+// RoleReward is a role granted to members once they reach a level
+type RoleReward struct {
+	Level  int       `json:"level"`
+	RoleID uuid.UUID `json:"roleId"`
+}
+
+// ParseConfig decodes a leveling config, filling in defaults for missing fields
+func ParseConfig(raw json.RawMessage) (*Config, error) {
+	cfg := &Config{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	if cfg.MinXP == 0 && cfg.MaxXP == 0 {
+		cfg.MinXP, cfg.MaxXP = DefaultMinXP, DefaultMaxXP
+	}
+	if cfg.MaxXP == 0 {
+		cfg.MaxXP = cfg.MinXP
+	}
+	if cfg.MinXP < 1 || cfg.MaxXP > MaxXPPerMessage || cfg.MinXP > cfg.MaxXP {
+		return nil, fmt.Errorf("%w: minXp and maxXp must be between 1 and %d, minXp first", ErrInvalidConfig, MaxXPPerMessage)
+	}
+
+	if cfg.CooldownSeconds == 0 {
+		cfg.CooldownSeconds = DefaultCooldownSeconds
+	}
+	if cfg.CooldownSeconds < 1 || cfg.CooldownSeconds > MaxCooldownSeconds {
+		return nil, fmt.Errorf("%w: cooldownSeconds must be between 1 and %d", ErrInvalidConfig, MaxCooldownSeconds)
+	}
+
+	if len(cfg.IgnoredChannelIDs) > MaxIgnoredChannels {
+		return nil, fmt.Errorf("%w: at most %d ignored channels", ErrInvalidConfig, MaxIgnoredChannels)
+	}
+
+	if len(cfg.RoleRewards) > MaxRoleRewards {
+		return nil, fmt.Errorf("%w: at most %d role rewards", ErrInvalidConfig, MaxRoleRewards)
+	}
+	seen := make(map[uuid.UUID]bool, len(cfg.RoleRewards))
+	for _, reward := range cfg.RoleRewards {
+		if reward.Level < 1 || reward.Level > MaxLevel {
+			return nil, fmt.Errorf("%w: reward levels must be between 1 and %d", ErrInvalidConfig, MaxLevel)
+		}
+		if reward.RoleID == uuid.Nil {
+			return nil, fmt.Errorf("%w: role reward for level %d needs a roleId", ErrInvalidConfig, reward.Level)
+		}
+		if seen[reward.RoleID] {
+			return nil, fmt.Errorf("%w: role %s is rewarded twice", ErrInvalidConfig, reward.RoleID)
+		}
+		seen[reward.RoleID] = true
+	}
+
+	return cfg, nil
+}
+
+// ValidateConfig rejects XP ranges, cooldowns and role rewards outside their limits
+func ValidateConfig(raw json.RawMessage) error {
+	_, err := ParseConfig(raw)
+	return err
+}
+
+func (c *Config) ignores(channelID uuid.UUID) bool {
+	for _, id := range c.IgnoredChannelIDs {
+		if id == channelID {
+			return true
+		}
+	}
+	return false
+}
+
+// rewardsUpTo returns the roles rewarded at or below level
+func (c *Config) rewardsUpTo(level int) []uuid.UUID {
+	roleIDs := make([]uuid.UUID, 0)
+	for _, reward := range c.RoleRewards {
+		if reward.Level <= level {
+			roleIDs = append(roleIDs, reward.RoleID)
+		}
+	}
+	return roleIDs
+}
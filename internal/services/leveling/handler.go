@@ -0,0 +1,95 @@
+package leveling
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/communities/{communityId}", func(r chi.Router) {
+		r.Get("/leaderboard", h.GetLeaderboard)
+		r.Get("/members/{userId}", h.GetMemberXP)
+	})
+
+	return r
+}
+
+func (h *Handler) GetLeaderboard(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := requireCommunity(w, r)
+	if !ok {
+		return
+	}
+
+	page := utils.GetCursorParams(r, 50, 100)
+
+	entries, next, err := h.service.GetLeaderboard(r.Context(), communityID, userID, page.Cursor, page.Limit)
+	if err != nil {
+		respondLevelingError(w, err, "Failed to get leaderboard")
+		return
+	}
+
+	utils.RespondCursorPage(w, entries, next)
+}
+
+func (h *Handler) GetMemberXP(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, ok := requireCommunity(w, r)
+	if !ok {
+		return
+	}
+
+	targetID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	member, err := h.service.GetMemberXP(r.Context(), communityID, userID, targetID)
+	if err != nil {
+		respondLevelingError(w, err, "Failed to get member XP")
+		return
+	}
+
+	utils.RespondSuccess(w, member)
+}
+
+func requireCommunity(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, communityID, true
+}
+
+func respondLevelingError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidCursor):
+		utils.RespondInvalidCursor(w)
+	case errors.Is(err, ErrNotMember), errors.Is(err, ErrNotEnabled):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
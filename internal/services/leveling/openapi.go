@@ -0,0 +1,22 @@
+package leveling
+
+import (
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+
+	openapi.Describe((*Handler).GetLeaderboard, openapi.Operation{
+		Summary:  "List a community's members by XP",
+		Path:     communityID,
+		Query:    openapi.CursorQuery,
+		Response: []*MemberXP{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).GetMemberXP, openapi.Operation{
+		Summary:  "Get a member's XP and level",
+		Path:     append(communityID, openapi.ID("userId")),
+		Response: MemberXP{},
+	})
+}
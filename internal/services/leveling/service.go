@@ -0,0 +1,479 @@
+package leveling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/permcache"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Consumer group leveling reads the broadcast stream through
+const broadcastGroup = "leveling"
+
+// MaxLevel caps the level curve; reaching it takes about 1.7 billion XP
+const MaxLevel = 1000
+
+var (
+	ErrNotEnabled = errors.New("leveling is not enabled in this community")
+	ErrNotMember  = errors.New("not a member of this community")
+)
+
+// XPToNextLevel is the XP it takes to go from level to level+1
+func XPToNextLevel(level int) int64 {
+	l := int64(level)
+	return 5*l*l + 50*l + 100
+}
+
+// XPForLevel is the total XP a member needs to reach level
+func XPForLevel(level int) int64 {
+	var total int64
+	for l := 0; l < level; l++ {
+		total += XPToNextLevel(l)
+	}
+	return total
+}
+
+// LevelForXP is the level a member with xp total XP is at
+func LevelForXP(xp int64) int {
+	level := 0
+	for level < MaxLevel {
+		need := XPToNextLevel(level)
+		if xp < need {
+			break
+		}
+		xp -= need
+		level++
+	}
+	return level
+}
+
+// MemberXP is a member's standing on a community's leaderboard
+type MemberXP struct {
+	Rank         int64     `json:"rank"`
+	UserID       uuid.UUID `json:"userId"`
+	Username     string    `json:"username"`
+	DisplayName  *string   `json:"displayName,omitempty"`
+	AvatarURL    *string   `json:"avatarUrl,omitempty"`
+	XP           int64     `json:"xp"`
+	Level        int       `json:"level"`
+	MessageCount int64     `json:"messageCount"`
+	// XP into the current level, and what the next level takes
+	LevelXP     int64 `json:"levelXp"`
+	NextLevelXP int64 `json:"nextLevelXp"`
+}
+
+func (m *MemberXP) fillProgress() {
+	m.LevelXP = m.XP - XPForLevel(m.Level)
+	m.NextLevelXP = XPToNextLevel(m.Level)
+}
+
+type Service struct {
+	db          *pgxpool.Pool
+	redis       *redis.Client
+	permissions *permcache.Cache
+}
+
+func NewService(db *pgxpool.Pool, redisClient *redis.Client) *Service {
+	return &Service{db: db, redis: redisClient}
+}
+
+// SetPermissionCache makes granted reward roles invalidate the member's cached
+// channel permissions in c.
+func (s *Service) SetPermissionCache(c *permcache.Cache) {
+	s.permissions = c
+}
+
+type messageEvent struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channelId"`
+	AuthorID  string `json:"authorId"`
+}
+
+// Run awards XP for messages on the realtime broadcast stream. Gateway
+// instances share one consumer group, named consumer, so each message is
+// counted by one of them.
+func (s *Service) Run(ctx context.Context, consumer string) {
+	database.NewBroadcastConsumer(s.redis, broadcastGroup, consumer).Run(ctx, func(entry database.BroadcastEntry) {
+		var data struct {
+			ChannelID string `json:"channelId"`
+			Event     struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			} `json:"event"`
+		}
+		if err := json.Unmarshal(entry.Payload, &data); err != nil {
+			return
+		}
+		if data.Event.Type != "MESSAGE_CREATE" {
+			return
+		}
+
+		var ev messageEvent
+		if err := json.Unmarshal(data.Event.Data, &ev); err != nil {
+			return
+		}
+		// Quarantined messages are only sent to their author's and moderators'
+		// streams and don't count until released
+		if ev.ChannelID == "" || ev.ChannelID != data.ChannelID {
+			return
+		}
+		channelID, err := uuid.Parse(ev.ChannelID)
+		if err != nil {
+			return
+		}
+		authorID, err := uuid.Parse(ev.AuthorID)
+		if err != nil {
+			return
+		}
+
+		if err := s.HandleMessage(ctx, channelID, authorID); err != nil {
+			log.Warn().Err(err).Str("messageId", ev.ID).Msg("Failed to award leveling XP")
+		}
+	})
+}
+
+// HandleMessage awards XP to a message's author unless they earned some too
+// recently. Members who level up get the role rewards they've reached.
+func (s *Service) HandleMessage(ctx context.Context, channelID, authorID uuid.UUID) error {
+	var communityID uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`SELECT community_id FROM channels WHERE id = $1`, channelID,
+	).Scan(&communityID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	cfg, granted, ok, err := s.getCommunityConfig(ctx, communityID)
+	if err != nil || !ok {
+		return err
+	}
+	if cfg.ignores(channelID) {
+		return nil
+	}
+
+	award := cfg.MinXP + rand.IntN(cfg.MaxXP-cfg.MinXP+1)
+
+	// Only members earn XP, which leaves out webhooks, plugins and system
+	// accounts. Messages inside the cooldown still count towards messageCount.
+	var xp int64
+	var level int
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO member_xp (community_id, user_id, xp, message_count, last_awarded_at)
+		 SELECT cm.community_id, cm.user_id, $3, 1, NOW()
+		 FROM community_members cm WHERE cm.community_id = $1 AND cm.user_id = $2
+		 ON CONFLICT (community_id, user_id) DO UPDATE
+		 SET message_count = member_xp.message_count + 1,
+		     xp = CASE WHEN member_xp.last_awarded_at <= NOW() - make_interval(secs => $4)
+		               THEN member_xp.xp + EXCLUDED.xp ELSE member_xp.xp END,
+		     last_awarded_at = CASE WHEN member_xp.last_awarded_at <= NOW() - make_interval(secs => $4)
+		                            THEN NOW() ELSE member_xp.last_awarded_at END,
+		     updated_at = NOW()
+		 RETURNING xp, level`,
+		communityID, authorID, award, cfg.CooldownSeconds,
+	).Scan(&xp, &level)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	newLevel := LevelForXP(xp)
+	if newLevel <= level {
+		return nil
+	}
+
+	// Another message may have raised the level first; only one of them announces it
+	tag, err := s.db.Exec(ctx,
+		`UPDATE member_xp SET level = $3 WHERE community_id = $1 AND user_id = $2 AND level < $3`,
+		communityID, authorID, newLevel,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	// Only the member hears about it; the leaderboard is there for everyone else
+	s.broadcast(ctx, database.UserStream(authorID.String()), "MEMBER_LEVEL_UP", map[string]any{
+		"communityId": communityID,
+		"userId":      authorID,
+		"level":       newLevel,
+		"xp":          xp,
+	})
+
+	install := &models.CommunityPlugin{GrantedPermissions: granted}
+	if !install.HasPermission(models.PluginPermManageMembers) {
+		return nil
+	}
+	return s.grantRewards(ctx, communityID, authorID, cfg.rewardsUpTo(newLevel))
+}
+
+// grantRewards gives a member the reward roles they don't have yet. Roles
+// carrying admin permissions are never granted, so a manager can't use a
+// reward to hand out more than they could assign themselves. Neither are roles
+// at or above the highest role of the member who last saved the config (or
+// installed the plugin), unless that member owns the community.
+func (s *Service) grantRewards(ctx context.Context, communityID, userID uuid.UUID, roleIDs []uuid.UUID) error {
+	if len(roleIDs) == 0 {
+		return nil
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`WITH granter AS (
+		     SELECT COALESCE(cp.config_updated_by, cp.installed_by) AS user_id
+		     FROM community_plugins cp
+		     JOIN plugins p ON p.id = cp.plugin_id
+		     WHERE cp.community_id = $1 AND p.slug = $5
+		 ), ceiling AS (
+		     SELECT CASE WHEN c.owner_id = g.user_id THEN NULL
+		            ELSE (SELECT COALESCE(MAX(gr.position), 0)
+		                  FROM community_members gm
+		                  JOIN member_roles gmr ON gmr.member_id = gm.id
+		                  JOIN roles gr ON gr.id = gmr.role_id
+		                  WHERE gm.community_id = $1 AND gm.user_id = g.user_id)
+		            END AS position
+		     FROM communities c, granter g
+		     WHERE c.id = $1
+		 )
+		 INSERT INTO member_roles (member_id, role_id)
+		 SELECT cm.id, r.id
+		 FROM community_members cm
+		 JOIN roles r ON r.community_id = cm.community_id
+		 CROSS JOIN ceiling
+		 WHERE cm.community_id = $1 AND cm.user_id = $2 AND r.id = ANY($3)
+		   AND r.is_default IS NOT TRUE AND COALESCE(r.permissions, 0) & $4 = 0
+		   AND (ceiling.position IS NULL OR r.position < ceiling.position)
+		 ON CONFLICT DO NOTHING`,
+		communityID, userID, roleIDs, models.PermissionAllAdmin, PluginSlug,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+	s.permissions.InvalidateMember(ctx, communityID, userID)
+
+	rows, err := s.db.Query(ctx,
+		`SELECT mr.role_id
+		 FROM member_roles mr
+		 JOIN community_members cm ON cm.id = mr.member_id
+		 WHERE cm.community_id = $1 AND cm.user_id = $2`,
+		communityID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	memberRoleIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return err
+	}
+
+	// Same event the community service sends when roles are assigned, so the
+	// hub rechecks which channels the member can see
+	s.broadcast(ctx, "", "MEMBER_UPDATE", map[string]any{
+		"communityId": communityID,
+		"userId":      userID,
+		"roleIds":     memberRoleIDs,
+	})
+	return nil
+}
+
+// getCommunityConfig loads the leveling config and granted permissions if the
+// plugin is installed, enabled and allowed to read messages on the community.
+func (s *Service) getCommunityConfig(ctx context.Context, communityID uuid.UUID) (*Config, int64, bool, error) {
+	var raw json.RawMessage
+	var granted int64
+	err := s.db.QueryRow(ctx,
+		`SELECT cp.config, cp.granted_permissions
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 WHERE cp.community_id = $1 AND p.slug = $2 AND cp.enabled = TRUE`,
+		communityID, PluginSlug,
+	).Scan(&raw, &granted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, 0, false, nil
+		}
+		return nil, 0, false, err
+	}
+
+	install := &models.CommunityPlugin{GrantedPermissions: granted}
+	if !install.HasPermission(models.PluginPermReadMessages) {
+		return nil, 0, false, nil
+	}
+
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		// Configs are validated on save, so this only happens for rows written before that
+		log.Warn().Err(err).Str("communityId", communityID.String()).Msg("Ignoring invalid leveling config")
+		return nil, 0, false, nil
+	}
+	return cfg, granted, true, nil
+}
+
+// GetLeaderboard returns a page of a community's members by XP, highest first,
+// and the cursor of the next page or "" on the last one
+func (s *Service) GetLeaderboard(ctx context.Context, communityID, userID uuid.UUID, cursor string, limit int) ([]*MemberXP, string, error) {
+	if err := s.requireViewer(ctx, communityID, userID); err != nil {
+		return nil, "", err
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	after, err := utils.DecodeRankCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Ranks are computed over the whole board before the cursor narrows it.
+	// One extra row tells whether there is a next page.
+	query := `SELECT ` + memberXPColumns + `
+		 FROM (
+		     SELECT mx.*, RANK() OVER (ORDER BY mx.xp DESC) AS rank
+		     FROM member_xp mx
+		     JOIN community_members cm ON cm.community_id = mx.community_id AND cm.user_id = mx.user_id
+		     WHERE mx.community_id = $1 AND mx.xp > 0
+		 ) mx
+		 JOIN users u ON u.id = mx.user_id`
+	args := []any{communityID, limit + 1}
+	if after != nil {
+		query += `
+		 WHERE mx.xp < $3 OR (mx.xp = $3 AND mx.user_id > $4)`
+		args = append(args, after.Rank, after.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		 ORDER BY mx.rank, mx.user_id
+		 LIMIT $2`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entries := make([]*MemberXP, 0)
+	for rows.Next() {
+		m, err := scanMemberXP(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		next = utils.EncodeCursor(utils.RankCursor{Rank: last.XP, ID: last.UserID})
+	}
+	return entries, next, nil
+}
+
+// GetMemberXP returns one member's standing; members who never earned XP
+// are unranked at level 0
+func (s *Service) GetMemberXP(ctx context.Context, communityID, userID, targetID uuid.UUID) (*MemberXP, error) {
+	if err := s.requireViewer(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	m, err := scanMemberXP(s.db.QueryRow(ctx,
+		`SELECT `+memberXPColumns+`
+		 FROM (
+		     SELECT cm.user_id, COALESCE(x.xp, 0) AS xp, COALESCE(x.level, 0) AS level,
+		            COALESCE(x.message_count, 0) AS message_count,
+		            CASE WHEN COALESCE(x.xp, 0) > 0 THEN
+		                (SELECT COUNT(*) + 1 FROM member_xp o
+		                 JOIN community_members ocm ON ocm.community_id = o.community_id AND ocm.user_id = o.user_id
+		                 WHERE o.community_id = $1 AND o.xp > x.xp)
+		            ELSE 0 END AS rank
+		     FROM community_members cm
+		     LEFT JOIN member_xp x ON x.community_id = cm.community_id AND x.user_id = cm.user_id
+		     WHERE cm.community_id = $1 AND cm.user_id = $2
+		 ) mx
+		 JOIN users u ON u.id = mx.user_id`,
+		communityID, targetID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// requireViewer checks leveling is enabled on the community and the user is a member of it
+func (s *Service) requireViewer(ctx context.Context, communityID, userID uuid.UUID) error {
+	var enabled, member bool
+	err := s.db.QueryRow(ctx,
+		`SELECT
+		     EXISTS (SELECT 1 FROM community_plugins cp JOIN plugins p ON p.id = cp.plugin_id
+		             WHERE cp.community_id = $1 AND p.slug = $3 AND cp.enabled = TRUE),
+		     EXISTS (SELECT 1 FROM community_members WHERE community_id = $1 AND user_id = $2)`,
+		communityID, userID, PluginSlug,
+	).Scan(&enabled, &member)
+	if err != nil {
+		return err
+	}
+	if !member {
+		return ErrNotMember
+	}
+	if !enabled {
+		return ErrNotEnabled
+	}
+	return nil
+}
+
+const memberXPColumns = `mx.rank, u.id, u.username, u.display_name, u.avatar_url, mx.xp, mx.level, mx.message_count`
+
+func scanMemberXP(row pgx.Row) (*MemberXP, error) {
+	m := &MemberXP{}
+	err := row.Scan(&m.Rank, &m.UserID, &m.Username, &m.DisplayName, &m.AvatarURL, &m.XP, &m.Level, &m.MessageCount)
+	if err != nil {
+		return nil, err
+	}
+	m.fillProgress()
+	return m, nil
+}
+
+func (s *Service) broadcast(ctx context.Context, streamID string, eventType string, data any) {
+	broadcast := struct {
+		ChannelID string `json:"channelId"`
+		Event     any    `json:"event"`
+	}{
+		ChannelID: streamID,
+		Event: struct {
+			Type string `json:"type"`
+			Data any    `json:"data"`
+		}{Type: eventType, Data: data},
+	}
+
+	jsonData, err := json.Marshal(broadcast)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal leveling broadcast")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, jsonData); err != nil {
+		log.Error().Err(err).Msg("Failed to publish leveling broadcast")
+	}
+}
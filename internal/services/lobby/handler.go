@@ -0,0 +1,183 @@
+package lobby
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/channels/{channelId}", func(r chi.Router) {
+		r.Get("/", h.ListLobbies)
+		r.Post("/", h.CreateLobby)
+	})
+
+	r.Route("/{lobbyId}", func(r chi.Router) {
+		r.Get("/", h.GetLobby)
+		r.Patch("/", h.UpdateLobby)
+		r.Delete("/", h.CloseLobby)
+		r.Post("/join", h.JoinLobby)
+		r.Post("/leave", h.LeaveLobby)
+	})
+
+	return r
+}
+
+func (h *Handler) ListLobbies(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "channelId", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	lobbies, err := h.service.ListLobbies(r.Context(), id, userID)
+	if err != nil {
+		respondLobbyError(w, err, "Failed to list lobbies")
+		return
+	}
+
+	utils.RespondSuccess(w, lobbies)
+}
+
+func (h *Handler) CreateLobby(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "channelId", "Invalid channel ID")
+	if !ok {
+		return
+	}
+
+	var req CreateLobbyRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	lobby, err := h.service.CreateLobby(r.Context(), id, userID, &req)
+	if err != nil {
+		respondLobbyError(w, err, "Failed to create lobby")
+		return
+	}
+
+	utils.RespondCreated(w, lobby)
+}
+
+func (h *Handler) GetLobby(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "lobbyId", "Invalid lobby ID")
+	if !ok {
+		return
+	}
+
+	lobby, err := h.service.GetLobby(r.Context(), id, userID)
+	if err != nil {
+		respondLobbyError(w, err, "Failed to get lobby")
+		return
+	}
+
+	utils.RespondSuccess(w, lobby)
+}
+
+func (h *Handler) UpdateLobby(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "lobbyId", "Invalid lobby ID")
+	if !ok {
+		return
+	}
+
+	var req UpdateLobbyRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	lobby, err := h.service.UpdateLobby(r.Context(), id, userID, &req)
+	if err != nil {
+		respondLobbyError(w, err, "Failed to update lobby")
+		return
+	}
+
+	utils.RespondSuccess(w, lobby)
+}
+
+func (h *Handler) CloseLobby(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "lobbyId", "Invalid lobby ID")
+	if !ok {
+		return
+	}
+
+	if err := h.service.CloseLobby(r.Context(), id, userID); err != nil {
+		respondLobbyError(w, err, "Failed to close lobby")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func (h *Handler) JoinLobby(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "lobbyId", "Invalid lobby ID")
+	if !ok {
+		return
+	}
+
+	lobby, err := h.service.JoinLobby(r.Context(), id, userID)
+	if err != nil {
+		respondLobbyError(w, err, "Failed to join lobby")
+		return
+	}
+
+	utils.RespondSuccess(w, lobby)
+}
+
+func (h *Handler) LeaveLobby(w http.ResponseWriter, r *http.Request) {
+	userID, id, ok := requireParam(w, r, "lobbyId", "Invalid lobby ID")
+	if !ok {
+		return
+	}
+
+	if err := h.service.LeaveLobby(r.Context(), id, userID); err != nil {
+		respondLobbyError(w, err, "Failed to leave lobby")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func requireParam(w http.ResponseWriter, r *http.Request, param, invalid string) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	id, err := uuid.Parse(chi.URLParam(r, param))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, invalid)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, id, true
+}
+
+func respondLobbyError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, channel.ErrChannelNotFound), errors.Is(err, ErrLobbyNotFound), errors.Is(err, ErrNotInLobby):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrNotLobbyChannel), errors.Is(err, ErrTooManyPlayers):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrInsufficientPerms), errors.Is(err, ErrNotHost):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrLobbyFull), errors.Is(err, ErrAlreadyInLobby):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,52 @@
+package lobby
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	channelID := []openapi.Param{openapi.ID("channelId")}
+	lobbyID := []openapi.Param{openapi.ID("lobbyId")}
+
+	openapi.Describe((*Handler).ListLobbies, openapi.Operation{
+		Summary:  "List a channel's open lobbies",
+		Path:     channelID,
+		Response: []*Lobby{},
+	})
+	openapi.Describe((*Handler).CreateLobby, openapi.Operation{
+		Summary:  "Open a lobby in a channel",
+		Path:     channelID,
+		Body:     CreateLobbyRequest{},
+		Response: Lobby{},
+		Status:   http.StatusCreated,
+	})
+
+	openapi.Describe((*Handler).GetLobby, openapi.Operation{
+		Summary:  "Get a lobby",
+		Path:     lobbyID,
+		Response: Lobby{},
+	})
+	openapi.Describe((*Handler).UpdateLobby, openapi.Operation{
+		Summary:  "Update a lobby",
+		Path:     lobbyID,
+		Body:     UpdateLobbyRequest{},
+		Response: Lobby{},
+	})
+	openapi.Describe((*Handler).CloseLobby, openapi.Operation{
+		Summary: "Close a lobby",
+		Path:    lobbyID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).JoinLobby, openapi.Operation{
+		Summary:  "Join a lobby",
+		Path:     lobbyID,
+		Response: Lobby{},
+	})
+	openapi.Describe((*Handler).LeaveLobby, openapi.Operation{
+		Summary: "Leave a lobby",
+		Path:    lobbyID,
+		Status:  http.StatusNoContent,
+	})
+}
@@ -0,0 +1,641 @@
+package lobby
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/database"
+)
+
+// PluginSlug is the slug the lobbies plugin is seeded under in the plugins table.
+const PluginSlug = "lobbies"
+
+// ChannelType is the channel type registered by the plugin (migration 000047)
+const ChannelType models.ChannelType = "lobby"
+
+// Event types sent on the lobby channel's stream
+const (
+	EventTypeLobbyCreate = "LOBBY_CREATE"
+	EventTypeLobbyUpdate = "LOBBY_UPDATE"
+	EventTypeLobbyDelete = "LOBBY_DELETE"
+)
+
+const (
+	// DefaultDuration applies when neither the request nor the channel's
+	// defaultDurationMinutes metadata set one
+	DefaultDuration = 2 * time.Hour
+	MaxDuration     = 24 * time.Hour
+)
+
+var (
+	ErrNotLobbyChannel   = errors.New("channel is not a lobby channel")
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrLobbyNotFound     = errors.New("lobby not found")
+	ErrLobbyFull         = errors.New("lobby is full")
+	ErrAlreadyInLobby    = errors.New("already in a lobby in this channel")
+	ErrNotInLobby        = errors.New("not in this lobby")
+	ErrNotHost           = errors.New("only the host can change the lobby")
+	ErrTooManyPlayers    = errors.New("max players is below the number already in the lobby")
+)
+
+// Lobby is an open game lobby in a lobby channel. It closes when it
+// expires or the last player leaves.
+type Lobby struct {
+	ID             uuid.UUID  `json:"id"`
+	ChannelID      uuid.UUID  `json:"channelId"`
+	CommunityID    uuid.UUID  `json:"communityId"`
+	HostID         uuid.UUID  `json:"hostId"`
+	Title          string     `json:"title"`
+	MaxPlayers     int        `json:"maxPlayers"`
+	Tags           []string   `json:"tags"`
+	VoiceChannelID *uuid.UUID `json:"voiceChannelId,omitempty"`
+	Members        []*Member  `json:"members"`
+	ExpiresAt      time.Time  `json:"expiresAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+type Member struct {
+	UserID   uuid.UUID `json:"userId"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+type CreateLobbyRequest struct {
+	Title           string   `json:"title" validate:"required,min=1,max=100"`
+	MaxPlayers      int      `json:"maxPlayers" validate:"required,min=2,max=100"`
+	Tags            []string `json:"tags" validate:"omitempty,max=5,dive,min=1,max=24"`
+	DurationMinutes int      `json:"durationMinutes" validate:"omitempty,min=15,max=1440"`
+	// VoiceChannel spawns a voice channel for the lobby, removed with it
+	VoiceChannel bool `json:"voiceChannel"`
+}
+
+type UpdateLobbyRequest struct {
+	Title      *string   `json:"title" validate:"omitempty,min=1,max=100"`
+	MaxPlayers *int      `json:"maxPlayers" validate:"omitempty,min=2,max=100"`
+	Tags       *[]string `json:"tags" validate:"omitempty,max=5,dive,min=1,max=24"`
+}
+
+type Service struct {
+	db             *pgxpool.Pool
+	channelService *channel.Service
+}
+
+func NewService(db *pgxpool.Pool, channelService *channel.Service) *Service {
+	return &Service{
+		db:             db,
+		channelService: channelService,
+	}
+}
+
+// ListLobbies returns the open lobbies in a channel, newest first
+func (s *Service) ListLobbies(ctx context.Context, channelID, userID uuid.UUID) ([]*Lobby, error) {
+	if _, err := s.requireChannel(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT id, channel_id, community_id, host_id, title, max_players, tags, voice_channel_id,
+		expires_at, created_at, updated_at
+		FROM game_lobbies
+		WHERE channel_id = $1 AND expires_at > NOW()
+		ORDER BY created_at DESC`,
+		channelID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lobbies := make([]*Lobby, 0)
+	byID := make(map[uuid.UUID]*Lobby)
+	for rows.Next() {
+		l, err := scanLobby(rows)
+		if err != nil {
+			return nil, err
+		}
+		lobbies = append(lobbies, l)
+		byID[l.ID] = l
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := s.loadMembers(ctx, s.db, byID); err != nil {
+		return nil, err
+	}
+	return lobbies, nil
+}
+
+func (s *Service) GetLobby(ctx context.Context, lobbyID, userID uuid.UUID) (*Lobby, error) {
+	l, err := s.load(ctx, s.db, lobbyID, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireChannel(ctx, l.ChannelID, userID); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// CreateLobby opens a lobby with the caller as host and first player
+func (s *Service) CreateLobby(ctx context.Context, channelID, userID uuid.UUID, req *CreateLobbyRequest) (*Lobby, error) {
+	ch, err := s.requireChannel(ctx, channelID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.channelService.CanSendMessage(ctx, channelID, userID) {
+		return nil, ErrInsufficientPerms
+	}
+	// Players still in an expired lobby the sweep hasn't reached yet would
+	// otherwise be stuck
+	s.expireChannel(ctx, channelID)
+
+	duration := defaultDuration(ch.Metadata)
+	if req.DurationMinutes > 0 {
+		duration = time.Duration(req.DurationMinutes) * time.Minute
+	}
+
+	now := time.Now()
+	l := &Lobby{
+		ID:          uuid.New(),
+		ChannelID:   channelID,
+		CommunityID: ch.CommunityID,
+		HostID:      userID,
+		Title:       strings.TrimSpace(req.Title),
+		MaxPlayers:  req.MaxPlayers,
+		Tags:        normalizeTags(req.Tags),
+		Members:     []*Member{{UserID: userID, JoinedAt: now}},
+		ExpiresAt:   now.Add(duration),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO game_lobbies (id, channel_id, community_id, host_id, title, max_players, tags, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)`,
+		l.ID, l.ChannelID, l.CommunityID, l.HostID, l.Title, l.MaxPlayers, l.Tags, l.ExpiresAt, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := addMember(ctx, tx, l.ID, channelID, userID, now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	// A lobby without its voice channel is still useful, so a failure here
+	// doesn't undo the lobby
+	if req.VoiceChannel {
+		if voiceID, err := s.spawnVoiceChannel(ctx, ch, l); err != nil {
+			log.Warn().Err(err).Str("lobbyId", l.ID.String()).Msg("Failed to create lobby voice channel")
+		} else {
+			l.VoiceChannelID = &voiceID
+		}
+	}
+
+	s.broadcast(ctx, channelID, EventTypeLobbyCreate, l)
+	return l, nil
+}
+
+// JoinLobby adds the caller to a lobby that has room. A player can be in
+// one lobby per channel at a time.
+func (s *Service) JoinLobby(ctx context.Context, lobbyID, userID uuid.UUID) (*Lobby, error) {
+	l, err := s.load(ctx, s.db, lobbyID, false)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.requireChannel(ctx, l.ChannelID, userID); err != nil {
+		return nil, err
+	}
+	s.expireChannel(ctx, l.ChannelID)
+
+	return s.update(ctx, lobbyID, func(tx pgx.Tx, l *Lobby) error {
+		if len(l.Members) >= l.MaxPlayers {
+			return ErrLobbyFull
+		}
+		now := time.Now()
+		if err := addMember(ctx, tx, l.ID, l.ChannelID, userID, now); err != nil {
+			return err
+		}
+		l.Members = append(l.Members, &Member{UserID: userID, JoinedAt: now})
+		return nil
+	})
+}
+
+// LeaveLobby removes the caller. The longest-standing player takes over
+// when the host leaves, and the lobby closes when nobody is left.
+func (s *Service) LeaveLobby(ctx context.Context, lobbyID, userID uuid.UUID) error {
+	var empty *Lobby
+	_, err := s.update(ctx, lobbyID, func(tx pgx.Tx, l *Lobby) error {
+		tag, err := tx.Exec(ctx,
+			`DELETE FROM game_lobby_members WHERE lobby_id = $1 AND user_id = $2`,
+			l.ID, userID,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotInLobby
+		}
+
+		remaining := make([]*Member, 0, len(l.Members))
+		for _, m := range l.Members {
+			if m.UserID != userID {
+				remaining = append(remaining, m)
+			}
+		}
+		l.Members = remaining
+
+		if len(remaining) == 0 {
+			empty = l
+			return errLobbyEmpty
+		}
+		if l.HostID == userID {
+			l.HostID = remaining[0].UserID
+		}
+		return nil
+	})
+	if errors.Is(err, errLobbyEmpty) {
+		return s.close(ctx, empty, userID)
+	}
+	return err
+}
+
+// UpdateLobby changes a lobby's details. Moderators can edit any lobby.
+func (s *Service) UpdateLobby(ctx context.Context, lobbyID, userID uuid.UUID, req *UpdateLobbyRequest) (*Lobby, error) {
+	return s.update(ctx, lobbyID, func(tx pgx.Tx, l *Lobby) error {
+		if err := s.requireHost(ctx, l, userID); err != nil {
+			return err
+		}
+		if req.Title != nil {
+			l.Title = strings.TrimSpace(*req.Title)
+		}
+		if req.MaxPlayers != nil {
+			if *req.MaxPlayers < len(l.Members) {
+				return ErrTooManyPlayers
+			}
+			l.MaxPlayers = *req.MaxPlayers
+		}
+		if req.Tags != nil {
+			l.Tags = normalizeTags(*req.Tags)
+		}
+		return nil
+	})
+}
+
+// CloseLobby ends a lobby early. Only the host or a moderator can.
+func (s *Service) CloseLobby(ctx context.Context, lobbyID, userID uuid.UUID) error {
+	l, err := s.load(ctx, s.db, lobbyID, false)
+	if err != nil {
+		return err
+	}
+	if err := s.requireHost(ctx, l, userID); err != nil {
+		return err
+	}
+	return s.close(ctx, l, userID)
+}
+
+// ExpireLobbies closes lobbies whose time is up. It runs as a maintenance
+// task; reads already hide expired lobbies in between.
+func (s *Service) ExpireLobbies(ctx context.Context) (int64, error) {
+	return s.expire(ctx, nil)
+}
+
+func (s *Service) expireChannel(ctx context.Context, channelID uuid.UUID) {
+	if _, err := s.expire(ctx, &channelID); err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("Failed to expire lobbies")
+	}
+}
+
+func (s *Service) expire(ctx context.Context, channelID *uuid.UUID) (int64, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, channel_id, community_id, host_id, title, max_players, tags, voice_channel_id,
+		expires_at, created_at, updated_at
+		FROM game_lobbies
+		WHERE expires_at <= NOW() AND ($1::UUID IS NULL OR channel_id = $1)`,
+		channelID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var expired []*Lobby
+	for rows.Next() {
+		l, err := scanLobby(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var closed int64
+	for _, l := range expired {
+		if err := s.close(ctx, l, l.HostID); err != nil {
+			log.Error().Err(err).Str("lobbyId", l.ID.String()).Msg("Failed to close expired lobby")
+			continue
+		}
+		closed++
+	}
+	return closed, nil
+}
+
+// errLobbyEmpty stops an update whose lobby should be closed instead
+var errLobbyEmpty = errors.New("lobby is empty")
+
+// update locks the lobby, applies change and saves it, then sends the new
+// state. Expired lobbies are treated as gone.
+func (s *Service) update(ctx context.Context, lobbyID uuid.UUID, change func(pgx.Tx, *Lobby) error) (*Lobby, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	l, err := s.load(ctx, tx, lobbyID, true)
+	if err != nil {
+		return nil, err
+	}
+	if err := change(tx, l); err != nil {
+		return nil, err
+	}
+
+	l.UpdatedAt = time.Now()
+	_, err = tx.Exec(ctx,
+		`UPDATE game_lobbies SET host_id = $2, title = $3, max_players = $4, tags = $5, updated_at = $6
+		WHERE id = $1`,
+		l.ID, l.HostID, l.Title, l.MaxPlayers, l.Tags, l.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.broadcast(ctx, l.ChannelID, EventTypeLobbyUpdate, l)
+	return l, nil
+}
+
+// close deletes the lobby and the voice channel spawned for it
+func (s *Service) close(ctx context.Context, l *Lobby, actorID uuid.UUID) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM game_lobbies WHERE id = $1`, l.ID)
+	if err != nil {
+		return err
+	}
+	// Someone else closed it first
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if l.VoiceChannelID != nil {
+		pluginID, err := s.pluginID(ctx)
+		if err == nil {
+			err = s.channelService.RemoveSpawnedChannel(ctx, pluginID, *l.VoiceChannelID, actorID)
+		}
+		if err != nil && !errors.Is(err, channel.ErrChannelNotFound) {
+			log.Warn().Err(err).Str("lobbyId", l.ID.String()).Msg("Failed to remove lobby voice channel")
+		}
+	}
+
+	s.broadcast(ctx, l.ChannelID, EventTypeLobbyDelete, map[string]interface{}{
+		"id":        l.ID,
+		"channelId": l.ChannelID,
+	})
+	return nil
+}
+
+func (s *Service) spawnVoiceChannel(ctx context.Context, lobbyChannel *models.Channel, l *Lobby) (uuid.UUID, error) {
+	pluginID, err := s.pluginID(ctx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	name := utils.NormalizeChannelName(l.Title)
+	if name == "" {
+		name = "lobby"
+	}
+	if len(name) > 64 {
+		name = strings.Trim(name[:64], "-")
+	}
+	metadata, _ := json.Marshal(map[string]int{"maxParticipants": l.MaxPlayers})
+
+	voice, err := s.channelService.SpawnManagedChannel(ctx, l.CommunityID, pluginID, l.HostID, &channel.CreateChannelRequest{
+		Name:       name,
+		Type:       string(models.ChannelTypeVoice),
+		CategoryID: lobbyChannel.CategoryID,
+		Metadata:   metadata,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// The lobby may have closed while the channel was being made
+	tag, err := s.db.Exec(ctx,
+		`UPDATE game_lobbies SET voice_channel_id = $2 WHERE id = $1`,
+		l.ID, voice.ID,
+	)
+	if err == nil && tag.RowsAffected() == 0 {
+		err = ErrLobbyNotFound
+	}
+	if err != nil {
+		if rmErr := s.channelService.RemoveSpawnedChannel(ctx, pluginID, voice.ID, l.HostID); rmErr != nil {
+			log.Warn().Err(rmErr).Str("channelId", voice.ID.String()).Msg("Failed to remove orphaned lobby voice channel")
+		}
+		return uuid.Nil, err
+	}
+	return voice.ID, nil
+}
+
+func (s *Service) pluginID(ctx context.Context) (uuid.UUID, error) {
+	var id uuid.UUID
+	err := s.db.QueryRow(ctx, `SELECT id FROM plugins WHERE slug = $1`, PluginSlug).Scan(&id)
+	return id, err
+}
+
+func (s *Service) requireChannel(ctx context.Context, channelID, userID uuid.UUID) (*models.Channel, error) {
+	ch, err := s.channelService.GetChannel(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if ch.Type != ChannelType {
+		return nil, ErrNotLobbyChannel
+	}
+	if !s.channelService.CanAccessChannel(ctx, channelID, userID) {
+		return nil, ErrInsufficientPerms
+	}
+	return ch, nil
+}
+
+func (s *Service) requireHost(ctx context.Context, l *Lobby, userID uuid.UUID) error {
+	if l.HostID == userID {
+		return nil
+	}
+	if s.channelService.CanManageMessages(ctx, l.ChannelID, userID) {
+		return nil
+	}
+	return ErrNotHost
+}
+
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func (s *Service) load(ctx context.Context, q querier, lobbyID uuid.UUID, forUpdate bool) (*Lobby, error) {
+	query := `SELECT id, channel_id, community_id, host_id, title, max_players, tags, voice_channel_id,
+		expires_at, created_at, updated_at
+		FROM game_lobbies WHERE id = $1 AND expires_at > NOW()`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	l, err := scanLobby(q.QueryRow(ctx, query, lobbyID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrLobbyNotFound
+		}
+		return nil, err
+	}
+	if err := s.loadMembers(ctx, q, map[uuid.UUID]*Lobby{l.ID: l}); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadMembers fills in the players of each lobby in join order
+func (s *Service) loadMembers(ctx context.Context, q querier, lobbies map[uuid.UUID]*Lobby) error {
+	if len(lobbies) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, 0, len(lobbies))
+	for id, l := range lobbies {
+		ids = append(ids, id)
+		l.Members = make([]*Member, 0)
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT lobby_id, user_id, joined_at FROM game_lobby_members
+		WHERE lobby_id = ANY($1)
+		ORDER BY joined_at`,
+		ids,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lobbyID uuid.UUID
+		m := &Member{}
+		if err := rows.Scan(&lobbyID, &m.UserID, &m.JoinedAt); err != nil {
+			return err
+		}
+		if l, ok := lobbies[lobbyID]; ok {
+			l.Members = append(l.Members, m)
+		}
+	}
+	return rows.Err()
+}
+
+func scanLobby(row pgx.Row) (*Lobby, error) {
+	l := &Lobby{}
+	err := row.Scan(
+		&l.ID, &l.ChannelID, &l.CommunityID, &l.HostID, &l.Title, &l.MaxPlayers, &l.Tags,
+		&l.VoiceChannelID, &l.ExpiresAt, &l.CreatedAt, &l.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if l.Tags == nil {
+		l.Tags = []string{}
+	}
+	return l, nil
+}
+
+// addMember records a player, refusing anyone already in a lobby in the channel
+func addMember(ctx context.Context, tx pgx.Tx, lobbyID, channelID, userID uuid.UUID, joinedAt time.Time) error {
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO game_lobby_members (lobby_id, user_id, channel_id, joined_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING`,
+		lobbyID, userID, channelID, joinedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAlreadyInLobby
+	}
+	return nil
+}
+
+// normalizeTags lowercases and trims tags and drops empty and repeated ones
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// defaultDuration reads the channel's defaultDurationMinutes metadata
+func defaultDuration(metadata json.RawMessage) time.Duration {
+	var meta struct {
+		DefaultDurationMinutes int `json:"defaultDurationMinutes"`
+	}
+	if len(metadata) > 0 {
+		_ = json.Unmarshal(metadata, &meta)
+	}
+	d := time.Duration(meta.DefaultDurationMinutes) * time.Minute
+	if d <= 0 || d > MaxDuration {
+		return DefaultDuration
+	}
+	return d
+}
+
+func (s *Service) broadcast(ctx context.Context, channelID uuid.UUID, eventType string, data interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"channelId": channelID.String(),
+		"event": map[string]interface{}{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", eventType).Msg("Failed to marshal lobby event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Str("event", eventType).Msg("Failed to publish lobby event")
+	}
+}
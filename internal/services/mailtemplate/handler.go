@@ -0,0 +1,127 @@
+package mailtemplate
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Routes are operator endpoints and must be mounted behind
+// middleware.AdminTokenMiddleware
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", h.ListTemplates)
+	r.Get("/{name}/{locale}", h.GetTemplate)
+	r.Put("/{name}/{locale}", h.UpsertTemplate)
+	r.Delete("/{name}/{locale}", h.DeleteTemplate)
+	r.Post("/{name}/preview", h.Preview)
+	r.Post("/{name}/test", h.TestSend)
+
+	return r
+}
+
+// ListTemplates lists the templates the server sends and their locales
+func (h *Handler) ListTemplates(w http.ResponseWriter, r *http.Request) {
+	templates, err := h.service.List(r.Context())
+	if err != nil {
+		respondTemplateError(w, err, "Failed to list email templates")
+		return
+	}
+
+	utils.RespondSuccess(w, templates)
+}
+
+// GetTemplate returns the source used for one locale
+func (h *Handler) GetTemplate(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := h.service.Get(r.Context(), chi.URLParam(r, "name"), chi.URLParam(r, "locale"))
+	if err != nil {
+		respondTemplateError(w, err, "Failed to get email template")
+		return
+	}
+
+	utils.RespondSuccess(w, tmpl)
+}
+
+// UpsertTemplate overrides a template for one locale
+func (h *Handler) UpsertTemplate(w http.ResponseWriter, r *http.Request) {
+	var req UpsertTemplateRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	tmpl, err := h.service.Upsert(r.Context(), chi.URLParam(r, "name"), chi.URLParam(r, "locale"), &req)
+	if err != nil {
+		respondTemplateError(w, err, "Failed to save email template")
+		return
+	}
+
+	utils.RespondSuccess(w, tmpl)
+}
+
+// DeleteTemplate drops an override so the built-in version is used again
+func (h *Handler) DeleteTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.Delete(r.Context(), chi.URLParam(r, "name"), chi.URLParam(r, "locale")); err != nil {
+		respondTemplateError(w, err, "Failed to delete email template")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// Preview renders a saved template or a draft without sending it
+func (h *Handler) Preview(w http.ResponseWriter, r *http.Request) {
+	var req PreviewRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	rendered, err := h.service.Preview(r.Context(), chi.URLParam(r, "name"), &req)
+	if err != nil {
+		respondTemplateError(w, err, "Failed to render email template")
+		return
+	}
+
+	utils.RespondSuccess(w, rendered)
+}
+
+// TestSend mails a rendered template to the given address
+func (h *Handler) TestSend(w http.ResponseWriter, r *http.Request) {
+	var req TestSendRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.service.TestSend(r.Context(), chi.URLParam(r, "name"), &req); err != nil {
+		respondTemplateError(w, err, "Failed to send test email")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func respondTemplateError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrUnknownTemplate), errors.Is(err, ErrNotFound):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidLocale), errors.Is(err, ErrInvalidTemplate):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrSendUnavailable):
+		utils.RespondError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, ErrSendFailed):
+		// The operator needs the SMTP server's answer to fix the setup
+		utils.RespondError(w, http.StatusBadGateway, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,50 @@
+package mailtemplate
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"mime/quotedprintable"
+	"strings"
+)
+
+// MIMEBody returns the Content-Type header value and the CRLF body for a
+// message: text/plain alone, or multipart/alternative when there is HTML.
+// Parts are quoted-printable so long HTML lines survive SMTP's line limit.
+func (r *Rendered) MIMEBody() (string, string) {
+	if r.HTML == "" {
+		return "text/plain; charset=UTF-8", crlf(r.Text)
+	}
+
+	var boundaryBytes [12]byte
+	_, _ = rand.Read(boundaryBytes[:])
+	boundary := "zentra-" + hex.EncodeToString(boundaryBytes[:])
+
+	var body strings.Builder
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", crlf(r.Text)},
+		{"text/html; charset=UTF-8", crlf(r.HTML)},
+	} {
+		fmt.Fprintf(&body, "--%s\r\n", boundary)
+		fmt.Fprintf(&body, "Content-Type: %s\r\n", part.contentType)
+		body.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		body.WriteString(quotedPrintable(part.content))
+		body.WriteString("\r\n")
+	}
+	fmt.Fprintf(&body, "--%s--\r\n", boundary)
+
+	return fmt.Sprintf("multipart/alternative; boundary=%q", boundary), body.String()
+}
+
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
+
+func quotedPrintable(s string) string {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	_, _ = w.Write([]byte(s))
+	_ = w.Close()
+	return buf.String()
+}
@@ -0,0 +1,46 @@
+package mailtemplate
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	name := openapi.Param{Name: "name", Description: "Template name, such as verify_email"}
+	template := []openapi.Param{name, {Name: "locale", Description: "Locale, such as en or pt-BR"}}
+
+	openapi.Describe((*Handler).ListTemplates, openapi.Operation{
+		Summary:  "List the email templates and their locales",
+		Response: []*TemplateInfo{},
+	})
+	openapi.Describe((*Handler).GetTemplate, openapi.Operation{
+		Summary:  "Get an email template in one locale",
+		Path:     template,
+		Response: Template{},
+	})
+	openapi.Describe((*Handler).UpsertTemplate, openapi.Operation{
+		Summary:  "Override an email template in one locale",
+		Path:     template,
+		Body:     UpsertTemplateRequest{},
+		Response: Template{},
+	})
+	openapi.Describe((*Handler).DeleteTemplate, openapi.Operation{
+		Summary: "Remove an override, going back to the built-in template",
+		Path:    template,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).Preview, openapi.Operation{
+		Summary:      "Render an email template with sample variables",
+		Path:         []openapi.Param{name},
+		Body:         PreviewRequest{},
+		BodyOptional: true,
+		Response:     Rendered{},
+	})
+	openapi.Describe((*Handler).TestSend, openapi.Operation{
+		Summary: "Send a rendered email template to an address",
+		Path:    []openapi.Param{name},
+		Body:    TestSendRequest{},
+		Status:  http.StatusNoContent,
+	})
+}
@@ -0,0 +1,582 @@
+package mailtemplate
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	TemplateVerifyEmail        = "verify_email"
+	TemplateNotificationDigest = "notification_digest"
+
+	// DefaultLocale always has a built-in version of every template
+	DefaultLocale = "en"
+
+	maxTemplateBytes = 64 * 1024
+	defaultColor     = "#5865f2"
+)
+
+var (
+	ErrUnknownTemplate = errors.New("unknown email template")
+	ErrInvalidLocale   = errors.New("invalid locale")
+	ErrInvalidTemplate = errors.New("invalid email template")
+	ErrNotFound        = errors.New("template not found for this locale")
+	ErrSendUnavailable = errors.New("email is not configured on this server")
+	ErrSendFailed      = errors.New("the test email could not be sent")
+)
+
+var (
+	localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	colorPattern  = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+//go:embed templates
+var builtinFS embed.FS
+
+var layout = htmltemplate.Must(htmltemplate.ParseFS(builtinFS, "templates/layout.html.tmpl"))
+
+// Branding is injected into every template as .Brand and styles the HTML layout
+type Branding struct {
+	Name           string `json:"name"`
+	LogoURL        string `json:"logoUrl,omitempty"`
+	Color          string `json:"color"`
+	SupportAddress string `json:"supportAddress,omitempty"`
+	Footer         string `json:"footer,omitempty"`
+	AppURL         string `json:"appUrl,omitempty"`
+}
+
+// definition describes a template the server sends. Sample fills in the
+// variables for previews and for checking overrides before they are saved.
+type definition struct {
+	Description string
+	Sample      map[string]any
+}
+
+var definitions = map[string]definition{
+	TemplateVerifyEmail: {
+		Description: "Sent after sign up to confirm the account's email address",
+		Sample: map[string]any{
+			"Username":        "ada",
+			"VerificationURL": "https://example.com/verify-email?token=sample",
+			"ExpiresIn":       "24h0m0s",
+		},
+	},
+	TemplateNotificationDigest: {
+		Description: "Missed mentions, replies and DMs mailed to offline users",
+		Sample: map[string]any{
+			"Name":        "Ada",
+			"Count":       3,
+			"ReplyMarker": "",
+			"Groups": []map[string]any{
+				{
+					"Label":     "#general in Example Community",
+					"URL":       "https://example.com/communities/sample/channels/sample",
+					"ReplyTo":   "",
+					"Remaining": 0,
+					"Items": []map[string]any{
+						{"Title": "You were mentioned", "Time": "Jan 2 15:04 UTC", "Lines": []string{"@ada are you around later?"}},
+						{"Title": "Someone replied to your message", "Time": "Jan 2 15:09 UTC", "Lines": []string{"Sounds good to me"}},
+					},
+				},
+				{
+					"Label":     "Direct message with Grace",
+					"URL":       "https://example.com/dms/sample",
+					"ReplyTo":   "",
+					"Remaining": 0,
+					"Items": []map[string]any{
+						{"Title": "Grace sent you a message", "Time": "Jan 2 16:20 UTC", "Lines": []string{"Lunch tomorrow?"}},
+					},
+				},
+			},
+		},
+	},
+}
+
+// Rendered is a ready-to-send email. HTML is empty for text-only templates.
+type Rendered struct {
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Template is one template's source in one locale
+type Template struct {
+	Name    string `json:"name"`
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+	// Override is set when the source comes from this instance rather than the built-in version
+	Override  bool       `json:"override"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// TemplateInfo lists a template for the admin endpoints
+type TemplateInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Variables   []string `json:"variables"`
+	Locales     []string `json:"locales"`
+	Overrides   []string `json:"overrides"`
+}
+
+// UpsertTemplateRequest replaces a template for one locale
+type UpsertTemplateRequest struct {
+	Subject string `json:"subject" validate:"required,max=1000"`
+	Text    string `json:"text" validate:"required"`
+	HTML    string `json:"html"`
+}
+
+// PreviewRequest renders a template with sample or given variables. Subject,
+// Text and HTML preview a draft instead of the saved template.
+type PreviewRequest struct {
+	Locale  string         `json:"locale"`
+	Vars    map[string]any `json:"vars"`
+	Subject *string        `json:"subject"`
+	Text    *string        `json:"text"`
+	HTML    *string        `json:"html"`
+}
+
+// TestSendRequest mails a rendered template to an operator's address
+type TestSendRequest struct {
+	To     string         `json:"to" validate:"required,email"`
+	Locale string         `json:"locale"`
+	Vars   map[string]any `json:"vars"`
+}
+
+// Sender delivers rendered emails for test sends
+type Sender interface {
+	SendRendered(ctx context.Context, to string, msg *Rendered) error
+}
+
+type Service struct {
+	db            *pgxpool.Pool
+	brand         Branding
+	defaultLocale string
+	sender        Sender
+}
+
+// NewService sets up rendering with the instance's branding. Templates
+// missing in a locale fall back to defaultLocale, then to DefaultLocale.
+func NewService(db *pgxpool.Pool, brand Branding, defaultLocale string) *Service {
+	if strings.TrimSpace(brand.Name) == "" {
+		brand.Name = "Zentra"
+	}
+	if !colorPattern.MatchString(brand.Color) {
+		brand.Color = defaultColor
+	}
+	brand.AppURL = strings.TrimRight(brand.AppURL, "/")
+
+	locale, err := normalizeLocale(defaultLocale)
+	if err != nil {
+		locale = DefaultLocale
+	}
+	return &Service{db: db, brand: brand, defaultLocale: locale}
+}
+
+// SetSender enables test sends
+func (s *Service) SetSender(sender Sender) {
+	s.sender = sender
+}
+
+// UserLocale is the locale the user picked in the client, stored as
+// settings.locale, or the instance default
+func (s *Service) UserLocale(ctx context.Context, userID uuid.UUID) string {
+	var raw *string
+	err := s.db.QueryRow(ctx,
+		`SELECT settings_json->>'locale' FROM user_settings WHERE user_id = $1`,
+		userID,
+	).Scan(&raw)
+	if err != nil || raw == nil {
+		return s.defaultLocale
+	}
+	locale, err := normalizeLocale(*raw)
+	if err != nil {
+		return s.defaultLocale
+	}
+	return locale
+}
+
+// Render renders a template in the closest available locale
+func (s *Service) Render(ctx context.Context, name, locale string, vars map[string]any) (*Rendered, error) {
+	tmpl, err := s.resolve(ctx, name, locale)
+	if err != nil {
+		return nil, err
+	}
+	return s.render(tmpl, vars)
+}
+
+// List returns every template with the locales it is available in
+func (s *Service) List(ctx context.Context) ([]*TemplateInfo, error) {
+	overrides := map[string][]string{}
+	rows, err := s.db.Query(ctx, `SELECT name, locale FROM email_templates ORDER BY name, locale`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, locale string
+		if err := rows.Scan(&name, &locale); err != nil {
+			return nil, err
+		}
+		overrides[name] = append(overrides[name], locale)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	infos := make([]*TemplateInfo, 0, len(definitions))
+	for name, def := range definitions {
+		info := &TemplateInfo{
+			Name:        name,
+			Description: def.Description,
+			Variables:   []string{"Brand"},
+			Locales:     builtinLocales(name),
+			Overrides:   overrides[name],
+		}
+		for key := range def.Sample {
+			info.Variables = append(info.Variables, key)
+		}
+		sort.Strings(info.Variables)
+		for _, locale := range info.Overrides {
+			if !contains(info.Locales, locale) {
+				info.Locales = append(info.Locales, locale)
+			}
+		}
+		sort.Strings(info.Locales)
+		if info.Overrides == nil {
+			info.Overrides = []string{}
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Get returns the source used for exactly this locale, without falling back
+func (s *Service) Get(ctx context.Context, name, locale string) (*Template, error) {
+	if _, ok := definitions[name]; !ok {
+		return nil, ErrUnknownTemplate
+	}
+	locale, err := normalizeLocale(locale)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := s.loadOverride(ctx, name, locale)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl != nil {
+		return tmpl, nil
+	}
+	if tmpl := loadBuiltin(name, locale); tmpl != nil {
+		return tmpl, nil
+	}
+	return nil, ErrNotFound
+}
+
+// Upsert stores an override after checking it renders with the sample variables
+func (s *Service) Upsert(ctx context.Context, name, locale string, req *UpsertTemplateRequest) (*Template, error) {
+	if _, ok := definitions[name]; !ok {
+		return nil, ErrUnknownTemplate
+	}
+	locale, err := normalizeLocale(locale)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl := &Template{Name: name, Locale: locale, Subject: req.Subject, Text: req.Text, HTML: req.HTML, Override: true}
+	if len(tmpl.Subject)+len(tmpl.Text)+len(tmpl.HTML) > maxTemplateBytes {
+		return nil, fmt.Errorf("%w: templates are limited to %d bytes", ErrInvalidTemplate, maxTemplateBytes)
+	}
+	if _, err := s.render(tmpl, definitions[name].Sample); err != nil {
+		return nil, err
+	}
+
+	var updatedAt time.Time
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO email_templates (name, locale, subject, text_body, html_body)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name, locale) DO UPDATE SET
+			subject = EXCLUDED.subject,
+			text_body = EXCLUDED.text_body,
+			html_body = EXCLUDED.html_body,
+			updated_at = NOW()
+		RETURNING updated_at`,
+		name, locale, tmpl.Subject, tmpl.Text, tmpl.HTML,
+	).Scan(&updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	tmpl.UpdatedAt = &updatedAt
+	return tmpl, nil
+}
+
+// Delete removes an override, going back to the built-in version
+func (s *Service) Delete(ctx context.Context, name, locale string) error {
+	locale, err := normalizeLocale(locale)
+	if err != nil {
+		return err
+	}
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM email_templates WHERE name = $1 AND locale = $2`,
+		name, locale,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Preview renders a saved template or a draft. Missing variables are taken
+// from the sample.
+func (s *Service) Preview(ctx context.Context, name string, req *PreviewRequest) (*Rendered, error) {
+	def, ok := definitions[name]
+	if !ok {
+		return nil, ErrUnknownTemplate
+	}
+
+	locale := req.Locale
+	if locale == "" {
+		locale = s.defaultLocale
+	}
+	tmpl, err := s.resolve(ctx, name, locale)
+	if err != nil {
+		return nil, err
+	}
+	if req.Subject != nil {
+		tmpl.Subject = *req.Subject
+	}
+	if req.Text != nil {
+		tmpl.Text = *req.Text
+	}
+	if req.HTML != nil {
+		tmpl.HTML = *req.HTML
+	}
+	return s.render(tmpl, withSample(def.Sample, req.Vars))
+}
+
+// TestSend mails a rendered template so operators can check it in real clients
+func (s *Service) TestSend(ctx context.Context, name string, req *TestSendRequest) error {
+	if s.sender == nil {
+		return ErrSendUnavailable
+	}
+	msg, err := s.Preview(ctx, name, &PreviewRequest{Locale: req.Locale, Vars: req.Vars})
+	if err != nil {
+		return err
+	}
+	msg.Subject = "[Test] " + msg.Subject
+	if err := s.sender.SendRendered(ctx, req.To, msg); err != nil {
+		return fmt.Errorf("%w: %v", ErrSendFailed, err)
+	}
+	return nil
+}
+
+// resolve finds the template for the first locale in the fallback chain that
+// has one, preferring this instance's overrides over the built-in versions
+func (s *Service) resolve(ctx context.Context, name, locale string) (*Template, error) {
+	if _, ok := definitions[name]; !ok {
+		return nil, ErrUnknownTemplate
+	}
+
+	for _, candidate := range s.localeChain(locale) {
+		tmpl, err := s.loadOverride(ctx, name, candidate)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl != nil {
+			return tmpl, nil
+		}
+		if tmpl := loadBuiltin(name, candidate); tmpl != nil {
+			return tmpl, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// localeChain is e.g. pt-br, pt, then the instance default and its language,
+// then DefaultLocale
+func (s *Service) localeChain(locale string) []string {
+	var chain []string
+	add := func(l string) {
+		for l != "" {
+			if !contains(chain, l) {
+				chain = append(chain, l)
+			}
+			i := strings.LastIndex(l, "-")
+			if i < 0 {
+				break
+			}
+			l = l[:i]
+		}
+	}
+	if normalized, err := normalizeLocale(locale); err == nil {
+		add(normalized)
+	}
+	add(s.defaultLocale)
+	add(DefaultLocale)
+	return chain
+}
+
+func (s *Service) loadOverride(ctx context.Context, name, locale string) (*Template, error) {
+	tmpl := &Template{Name: name, Locale: locale, Override: true}
+	var updatedAt time.Time
+	err := s.db.QueryRow(ctx,
+		`SELECT subject, text_body, html_body, updated_at FROM email_templates WHERE name = $1 AND locale = $2`,
+		name, locale,
+	).Scan(&tmpl.Subject, &tmpl.Text, &tmpl.HTML, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tmpl.UpdatedAt = &updatedAt
+	return tmpl, nil
+}
+
+// render executes the subject and text as text templates and the HTML as an
+// html/template inside the branded layout. Variables missing from vars are
+// an error rather than blanks in a sent email.
+func (s *Service) render(tmpl *Template, vars map[string]any) (*Rendered, error) {
+	data := make(map[string]any, len(vars)+1)
+	for key, value := range vars {
+		data[key] = value
+	}
+	data["Brand"] = s.brand
+
+	subject, err := executeText(tmpl.Name+".subject", tmpl.Subject, data)
+	if err != nil {
+		return nil, err
+	}
+	// Headers are one line
+	subject = strings.Join(strings.Fields(subject), " ")
+	if subject == "" {
+		return nil, fmt.Errorf("%w: the subject is empty", ErrInvalidTemplate)
+	}
+
+	text, err := executeText(tmpl.Name+".text", tmpl.Text, data)
+	if err != nil {
+		return nil, err
+	}
+	msg := &Rendered{Subject: subject, Text: strings.TrimSpace(text) + "\n"}
+
+	if strings.TrimSpace(tmpl.HTML) == "" {
+		return msg, nil
+	}
+	content, err := htmltemplate.New(tmpl.Name + ".html").Option("missingkey=error").Parse(tmpl.HTML)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	var body bytes.Buffer
+	if err := content.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+
+	var page bytes.Buffer
+	err = layout.Execute(&page, map[string]any{
+		"Locale":  tmpl.Locale,
+		"Subject": subject,
+		"Brand":   s.brand,
+		// Already escaped by html/template above
+		"Content": htmltemplate.HTML(body.String()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	msg.HTML = page.String()
+	return msg, nil
+}
+
+func executeText(name, source string, data map[string]any) (string, error) {
+	t, err := texttemplate.New(name).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidTemplate, err)
+	}
+	return out.String(), nil
+}
+
+func loadBuiltin(name, locale string) *Template {
+	subject, err := fs.ReadFile(builtinFS, "templates/"+locale+"/"+name+".subject.tmpl")
+	if err != nil {
+		return nil
+	}
+	text, err := fs.ReadFile(builtinFS, "templates/"+locale+"/"+name+".text.tmpl")
+	if err != nil {
+		return nil
+	}
+	// HTML is optional
+	html, _ := fs.ReadFile(builtinFS, "templates/"+locale+"/"+name+".html.tmpl")
+	return &Template{Name: name, Locale: locale, Subject: string(subject), Text: string(text), HTML: string(html)}
+}
+
+func builtinLocales(name string) []string {
+	entries, err := fs.ReadDir(builtinFS, "templates")
+	if err != nil {
+		return nil
+	}
+	locales := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && loadBuiltin(name, entry.Name()) != nil {
+			locales = append(locales, entry.Name())
+		}
+	}
+	return locales
+}
+
+// normalizeLocale lowercases a BCP 47 style tag, e.g. pt_BR becomes pt-br
+func normalizeLocale(locale string) (string, error) {
+	locale = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(locale), "_", "-"))
+	if len(locale) > 16 || !localePattern.MatchString(locale) {
+		return "", ErrInvalidLocale
+	}
+	return locale, nil
+}
+
+// withSample fills variables the caller didn't give from the sample. Whole
+// JSON numbers become ints so templates can compare them with literals.
+func withSample(sample, vars map[string]any) map[string]any {
+	merged := make(map[string]any, len(sample)+len(vars))
+	for key, value := range sample {
+		merged[key] = value
+	}
+	for key, value := range vars {
+		if f, ok := value.(float64); ok && f == math.Trunc(f) {
+			value = int(f)
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package mailtemplate
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"strings"
+)
+
+var (
+	ErrSMTPNotConfigured = errors.New("smtp is not configured")
+	ErrInvalidRecipient  = errors.New("invalid recipient")
+)
+
+// SMTPConfig is the server outgoing mail goes through
+type SMTPConfig struct {
+	Host        string
+	Port        int // 587 when unset
+	Username    string
+	Password    string
+	FromAddress string
+}
+
+// Enabled reports whether there is a server and a sender address
+func (c SMTPConfig) Enabled() bool {
+	return strings.TrimSpace(c.Host) != "" && strings.TrimSpace(c.FromAddress) != ""
+}
+
+// Mail is a rendered email and its envelope
+type Mail struct {
+	To      string
+	Message *Rendered
+	ReplyTo string
+	// Set on mail nobody wrote, such as digests, so mail servers don't answer
+	// it with auto-replies
+	AutoGenerated bool
+}
+
+// SendSMTP mails a rendered email. It returns ErrSMTPNotConfigured when cfg
+// has no server or a bad sender address, and ErrInvalidRecipient when the
+// recipient isn't an address.
+func SendSMTP(cfg SMTPConfig, msg Mail) error {
+	if !cfg.Enabled() {
+		return ErrSMTPNotConfigured
+	}
+	fromAddress := strings.TrimSpace(cfg.FromAddress)
+	parsedFrom, err := mail.ParseAddress(fromAddress)
+	if err != nil {
+		return ErrSMTPNotConfigured
+	}
+	parsedTo, err := mail.ParseAddress(strings.TrimSpace(msg.To))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+
+	host := strings.TrimSpace(cfg.Host)
+	port := cfg.Port
+	if port <= 0 {
+		port = 587
+	}
+
+	var header strings.Builder
+	fmt.Fprintf(&header, "From: %s\r\n", fromAddress)
+	fmt.Fprintf(&header, "To: %s\r\n", parsedTo.Address)
+	if msg.ReplyTo != "" {
+		fmt.Fprintf(&header, "Reply-To: %s\r\n", msg.ReplyTo)
+	}
+	fmt.Fprintf(&header, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Message.Subject))
+	if msg.AutoGenerated {
+		header.WriteString("Auto-Submitted: auto-generated\r\n")
+	}
+	contentType, body := msg.Message.MIMEBody()
+	fmt.Fprintf(&header, "MIME-Version: 1.0\r\nContent-Type: %s\r\n\r\n", contentType)
+
+	var smtpAuth smtp.Auth
+	if strings.TrimSpace(cfg.Username) != "" || cfg.Password != "" {
+		smtpAuth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	return smtp.SendMail(fmt.Sprintf("%s:%d", host, port), smtpAuth, parsedFrom.Address, []string{parsedTo.Address}, []byte(header.String()+body))
+}
@@ -0,0 +1,203 @@
+package maintenance
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// historyIndex is a history index of messages and its direct_messages
+// counterpart. Its migration creates the messages index on the parent only,
+// which is instant; it's built here on each partition CONCURRENTLY and
+// attached. The direct_messages index can't be built CONCURRENTLY in a
+// migration, so it's built here too. Indexes they replace are dropped once
+// they are valid.
+type historyIndex struct {
+	index    string
+	suffix   string // partitions' indexes are named after the partition plus this
+	columns  string
+	replaces string
+
+	dmIndex    string
+	dmColumns  string
+	dmReplaces string
+}
+
+var historyIndexes = []historyIndex{
+	// Migration 000069: pages on (created_at, id)
+	{
+		index:      "idx_messages_channel_history",
+		suffix:     "_channel_history",
+		columns:    "(channel_id, created_at DESC, id DESC)",
+		replaces:   "idx_messages_channel_id",
+		dmIndex:    "idx_direct_messages_history",
+		dmColumns:  "(conversation_id, created_at DESC, id DESC)",
+		dmReplaces: "idx_direct_messages_conversation_id",
+	},
+	// Migration 000075: pages on the ID alone for sortable IDs
+	{
+		index:     "idx_messages_channel_id_order",
+		suffix:    "_channel_id_order",
+		columns:   "(channel_id, id DESC) WHERE " + messaging.SortableID("id"),
+		dmIndex:   "idx_direct_messages_id_order",
+		dmColumns: "(conversation_id, id DESC) WHERE " + messaging.SortableID("id"),
+	},
+}
+
+// No new build is started after this long, so a pass stays within the
+// maintenance lock
+const historyIndexBudget = 5 * time.Minute
+
+// buildHistoryIndexes builds the history indexes of the migrations that have
+// run without blocking writes. It returns how many indexes it built; an index
+// left unfinished is built again on a later pass.
+func (s *Service) buildHistoryIndexes(ctx context.Context) (int64, error) {
+	deadline := time.Now().Add(historyIndexBudget)
+	var built int64
+	for _, index := range historyIndexes {
+		n, err := s.buildHistoryIndex(ctx, index, deadline)
+		built += n
+		if err != nil || time.Now().After(deadline) {
+			return built, err
+		}
+	}
+	return built, nil
+}
+
+func (s *Service) buildHistoryIndex(ctx context.Context, h historyIndex, deadline time.Time) (int64, error) {
+	var built int64
+
+	valid, exists, err := s.indexValid(ctx, h.index)
+	if err != nil || !exists {
+		// Before its migration there is nothing to build
+		return 0, err
+	}
+	if !valid {
+		partitions, err := s.partitionsWithoutIndex(ctx, h.index)
+		if err != nil {
+			return 0, err
+		}
+		for _, partition := range partitions {
+			if time.Now().After(deadline) {
+				return built, nil
+			}
+			index := partition + h.suffix
+			if err := s.buildIndexConcurrently(ctx, index, partition, h.columns); err != nil {
+				return built, fmt.Errorf("build %s: %w", index, err)
+			}
+			_, err := s.db.Exec(ctx, fmt.Sprintf(`ALTER INDEX %s ATTACH PARTITION %s`,
+				pgx.Identifier{h.index}.Sanitize(), pgx.Identifier{index}.Sanitize()))
+			if err != nil {
+				return built, fmt.Errorf("attach %s: %w", index, err)
+			}
+			built++
+			log.Info().Str("partition", partition).Str("index", h.index).Msg("Built message history index")
+		}
+		if valid, _, err = s.indexValid(ctx, h.index); err != nil || !valid {
+			return built, err
+		}
+	}
+	if h.replaces != "" {
+		// Dropping an index of a partitioned table can't be done CONCURRENTLY,
+		// but it only takes a moment
+		if _, err := s.db.Exec(ctx, `DROP INDEX IF EXISTS `+pgx.Identifier{h.replaces}.Sanitize()); err != nil {
+			return built, err
+		}
+	}
+
+	if time.Now().After(deadline) {
+		return built, nil
+	}
+	valid, _, err = s.indexValid(ctx, h.dmIndex)
+	if err != nil {
+		return built, err
+	}
+	if !valid {
+		if err := s.buildIndexConcurrently(ctx, h.dmIndex, "direct_messages", h.dmColumns); err != nil {
+			return built, fmt.Errorf("build %s: %w", h.dmIndex, err)
+		}
+		built++
+	}
+	if h.dmReplaces != "" {
+		_, err = s.db.Exec(ctx, `DROP INDEX CONCURRENTLY IF EXISTS `+pgx.Identifier{h.dmReplaces}.Sanitize())
+	}
+	return built, err
+}
+
+// buildIndexConcurrently creates an index on table's columns CONCURRENTLY. A
+// build that failed or was interrupted leaves an invalid index behind, which is
+// dropped and built again unless another session is still building it.
+func (s *Service) buildIndexConcurrently(ctx context.Context, name, table, columns string) error {
+	valid, exists, err := s.indexValid(ctx, name)
+	if err != nil {
+		return err
+	}
+	if valid {
+		return nil
+	}
+	if exists {
+		var building bool
+		err := s.db.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM pg_stat_progress_create_index WHERE index_relid = to_regclass($1))`,
+			name,
+		).Scan(&building)
+		if err != nil {
+			return err
+		}
+		if building {
+			return fmt.Errorf("%s is being built by another session", name)
+		}
+		if _, err := s.db.Exec(ctx, `DROP INDEX CONCURRENTLY IF EXISTS `+pgx.Identifier{name}.Sanitize()); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.Exec(ctx, fmt.Sprintf(`CREATE INDEX CONCURRENTLY %s ON %s %s`,
+		pgx.Identifier{name}.Sanitize(), pgx.Identifier{table}.Sanitize(), columns))
+	return err
+}
+
+// indexValid reports whether an index exists and whether it's valid. A
+// partitioned index is valid once every partition has one attached.
+func (s *Service) indexValid(ctx context.Context, name string) (valid, exists bool, err error) {
+	err = s.db.QueryRow(ctx,
+		`SELECT COALESCE(bool_and(indisvalid), FALSE), COUNT(*) > 0 FROM pg_index WHERE indexrelid = to_regclass($1)`,
+		name,
+	).Scan(&valid, &exists)
+	return valid, exists, err
+}
+
+// partitionsWithoutIndex returns the attached partitions of messages that
+// have no index attached to the partitioned index yet
+func (s *Service) partitionsWithoutIndex(ctx context.Context, index string) ([]string, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'messages'::regclass
+		  AND NOT EXISTS (
+		      SELECT 1 FROM pg_inherits ii
+		      JOIN pg_index x ON x.indexrelid = ii.inhrelid
+		      WHERE ii.inhparent = to_regclass($1) AND x.indrelid = c.oid
+		  )
+		ORDER BY c.relname DESC`,
+		index,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
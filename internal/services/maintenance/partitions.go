@@ -0,0 +1,279 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+// archiveSchema holds message partitions detached for retention (see
+// migration 000070)
+const archiveSchema = "message_archive"
+
+var (
+	partitionsCreatedTotal = metrics.NewCounter("zentra_message_partitions_created_total",
+		"Monthly messages partitions created ahead of time by the maintenance job.")
+	partitionsMissing = metrics.NewGauge("zentra_message_partitions_missing",
+		"Months from the current one through the look-ahead window with no messages partition. Inserts into them fail.")
+)
+
+// PartitionPolicy is how the maintenance job manages the monthly partitions of
+// the messages table.
+type PartitionPolicy struct {
+	// Ahead is how many months after the current one must already have a
+	// partition
+	Ahead int
+	// Retention is how long after a month ends its partition stays attached.
+	// Older partitions are detached and moved to the message_archive schema,
+	// where they can be dumped or dropped. Zero keeps every month.
+	Retention time.Duration
+}
+
+// DefaultPartitionPolicy creates three months ahead and archives nothing
+var DefaultPartitionPolicy = PartitionPolicy{Ahead: 3}
+
+// SetPartitionPolicy replaces DefaultPartitionPolicy. Set it before Run is
+// started.
+func (s *Service) SetPartitionPolicy(policy PartitionPolicy) {
+	if policy.Ahead < 0 {
+		policy.Ahead = 0
+	}
+	s.partitions = policy
+}
+
+// createMessagePartitions makes sure the current month and the next
+// policy.Ahead have partitions. It fails, which is logged and counted, when
+// any of them is still missing afterwards; zentra_message_partitions_missing
+// is the gauge to alert on.
+func (s *Service) createMessagePartitions(ctx context.Context) (int64, error) {
+	existing, err := s.messagePartitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	var missing []time.Time
+	for i := 0; i <= s.partitions.Ahead; i++ {
+		month := current.AddDate(0, i, 0)
+		if _, ok := existing[month]; ok {
+			continue
+		}
+		err := database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+			return database.EnsureMessagePartition(ctx, tx, month)
+		})
+		if err != nil {
+			log.Error().Err(err).Str("month", month.Format("2006-01")).Msg("Failed to create messages partition")
+			missing = append(missing, month)
+			continue
+		}
+		partitionsCreatedTotal.Inc()
+		log.Info().Str("partition", partitionName(month)).Msg("Created messages partition")
+	}
+
+	partitionsMissing.Set(float64(len(missing)))
+	if len(missing) > 0 {
+		return 0, fmt.Errorf("%d upcoming messages partitions are missing, starting with %s", len(missing), partitionName(missing[0]))
+	}
+	return 0, nil
+}
+
+// archiveMessagePartitions detaches the partitions of months that ended more
+// than policy.Retention ago and moves them to the archive schema, along with
+// their messages' attachment rows. The attachments' files are deleted. It
+// returns how many partitions it moved.
+func (s *Service) archiveMessagePartitions(ctx context.Context) (int64, error) {
+	if s.partitions.Retention <= 0 {
+		return 0, nil
+	}
+	existing, err := s.messagePartitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// A pass that stopped between detaching a partition and moving it leaves
+	// it detached in place; it is finished here
+	detached, err := s.detachedPartitions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for month, name := range detached {
+		existing[month] = name
+	}
+
+	cutoff := time.Now().Add(-s.partitions.Retention)
+	var archived int64
+	for month, name := range existing {
+		if month.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+		if err := s.archivePartition(ctx, name, month); err != nil {
+			return archived, fmt.Errorf("archive %s: %w", name, err)
+		}
+		archived++
+		log.Info().Str("partition", name).Msg("Archived messages partition")
+	}
+	return archived, nil
+}
+
+func (s *Service) archivePartition(ctx context.Context, name string, month time.Time) error {
+	// Partition names come from messagePartitions, which only returns
+	// messages_YYYY_MM ones, so they are safe to format into DDL.
+	//
+	// DETACH CONCURRENTLY doesn't block reads and writes to other months, but
+	// it can't run in a transaction. One that was interrupted leaves the
+	// partition pending and is finished with FINALIZE.
+	var pending bool
+	err := s.db.QueryRow(ctx,
+		`SELECT inhdetachpending FROM pg_inherits WHERE inhparent = 'messages'::regclass AND inhrelid = $1::regclass`,
+		name,
+	).Scan(&pending)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// Detached already
+	case err != nil:
+		return err
+	default:
+		detach := fmt.Sprintf(`ALTER TABLE messages DETACH PARTITION %s CONCURRENTLY`, name)
+		if pending {
+			detach = fmt.Sprintf(`ALTER TABLE messages DETACH PARTITION %s FINALIZE`, name)
+		}
+		if _, err := s.db.Exec(ctx, detach); err != nil {
+			return err
+		}
+	}
+
+	var fileURLs []string
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		// A month can be archived twice if an import recreated its partition
+		// afterwards; the second copy keeps the time it was archived in its name
+		var taken bool
+		if err := tx.QueryRow(ctx,
+			`SELECT to_regclass($1) IS NOT NULL`, archiveSchema+"."+name,
+		).Scan(&taken); err != nil {
+			return err
+		}
+		archivedName := name
+		if taken {
+			archivedName = fmt.Sprintf("%s_%d", name, time.Now().Unix())
+			if _, err := tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s RENAME TO %s`, name, archivedName)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s SET SCHEMA %s`, archivedName, archiveSchema)); err != nil {
+			return err
+		}
+
+		// The month's attachment rows go next to its messages, so nothing
+		// can serve or link them any more
+		attachments := archiveSchema + "." + archivedName + "_attachments"
+		if _, err := tx.Exec(ctx, fmt.Sprintf(`CREATE TABLE %s (LIKE message_attachments)`, attachments)); err != nil {
+			return err
+		}
+		rows, err := tx.Query(ctx, fmt.Sprintf(
+			`WITH moved AS (
+				DELETE FROM message_attachments
+				WHERE message_created_at >= $1 AND message_created_at < $2
+				RETURNING *
+			)
+			INSERT INTO %s SELECT * FROM moved
+			RETURNING file_url, thumbnail_url, medium_url, video_url, poster_url`, attachments),
+			month, month.AddDate(0, 1, 0),
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var fileURL string
+			var renditions [4]*string
+			if err := rows.Scan(&fileURL, &renditions[0], &renditions[1], &renditions[2], &renditions[3]); err != nil {
+				return err
+			}
+			fileURLs = append(fileURLs, fileURL)
+			for _, url := range renditions {
+				if url != nil {
+					fileURLs = append(fileURLs, *url)
+				}
+			}
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+
+	// The rows are archived, so the files go once that has committed
+	if s.attachments != nil && len(fileURLs) > 0 {
+		s.attachments.RemoveAttachmentFiles(ctx, fileURLs)
+	}
+	return nil
+}
+
+// messagePartitions returns the attached monthly partitions of messages by
+// the month they start in
+func (s *Service) messagePartitions(ctx context.Context) (map[time.Time]string, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'messages'::regclass`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := make(map[time.Time]string)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		month, err := time.Parse("messages_2006_01", name)
+		if err != nil {
+			// Not one of ours; leave it alone
+			continue
+		}
+		partitions[month] = name
+	}
+	return partitions, rows.Err()
+}
+
+// detachedPartitions returns monthly messages tables outside the archive that
+// are no longer attached, by the month they hold
+func (s *Service) detachedPartitions(ctx context.Context) (map[time.Time]string, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = current_schema() AND c.relkind = 'r' AND NOT c.relispartition
+		  AND c.relname ~ '^messages_[0-9]{4}_[0-9]{2}$'`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tables := make(map[time.Time]string)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		if month, err := time.Parse("messages_2006_01", name); err == nil {
+			tables[month] = name
+		}
+	}
+	return tables, rows.Err()
+}
+
+// partitionName matches the names database.EnsureMessagePartition creates
+func partitionName(month time.Time) string {
+	return month.Format("messages_2006_01")
+}
@@ -0,0 +1,214 @@
+package maintenance
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+const (
+	// Interval is how often the cleanup pass runs.
+	Interval = 15 * time.Minute
+
+	// Dead sessions are kept for a week after they expire or are revoked.
+	sessionRetention = 7 * 24 * time.Hour
+
+	lockKey = "maintenance:lock"
+	lockTTL = 10 * time.Minute
+	// advisoryLockID is the Postgres advisory lock a pass takes instead of
+	// lockKey while Redis is unavailable
+	advisoryLockID int64 = 0x7a656e7472616d74
+)
+
+var (
+	removedTotal = metrics.NewCounter("zentra_maintenance_removed_total",
+		"Rows and keys removed by the maintenance job.", "task")
+	failuresTotal = metrics.NewCounter("zentra_maintenance_failures_total",
+		"Maintenance tasks that returned an error.", "task")
+	lastRunSeconds = metrics.NewGauge("zentra_maintenance_last_run_timestamp_seconds",
+		"Unix time the maintenance job last finished a pass.")
+)
+
+// Task deletes one kind of dead data and returns how much it removed.
+type Task func(ctx context.Context) (int64, error)
+
+// PresencePruner is the subset of the presence service maintenance needs.
+type PresencePruner interface {
+	PruneStale(ctx context.Context) (int64, error)
+}
+
+// AttachmentRemover deletes attachment files from storage. The media service
+// implements it.
+type AttachmentRemover interface {
+	RemoveAttachmentFiles(ctx context.Context, fileURLs []string)
+}
+
+type namedTask struct {
+	name string
+	run  Task
+}
+
+type Service struct {
+	db          *pgxpool.Pool
+	redis       *redis.Client
+	tasks       []namedTask
+	partitions  PartitionPolicy
+	attachments AttachmentRemover
+}
+
+func NewService(db *pgxpool.Pool, redisClient *redis.Client, presence PresencePruner) *Service {
+	s := &Service{db: db, redis: redisClient, partitions: DefaultPartitionPolicy}
+
+	s.Register("expired_invites", s.deleteExpiredInvites)
+	s.Register("exhausted_invites", s.deleteExhaustedInvites)
+	s.Register("stale_sessions", s.deleteStaleSessions)
+	s.Register("expired_timeouts", s.clearExpiredTimeouts)
+	s.Register("stale_presence", presence.PruneStale)
+	s.Register("message_partitions", s.createMessagePartitions)
+	s.Register("archived_message_partitions", s.archiveMessagePartitions)
+	s.Register("history_indexes", s.buildHistoryIndexes)
+
+	return s
+}
+
+// SetAttachmentRemover lets archiving a messages partition delete the files of
+// its attachments. Without it they stay in storage.
+func (s *Service) SetAttachmentRemover(remover AttachmentRemover) {
+	s.attachments = remover
+}
+
+// Register adds a task to every pass. Services with their own expiring data
+// (scheduled messages, reminders, ...) hook in here instead of running a loop
+// of their own. Register before Run is started.
+func (s *Service) Register(name string, task Task) {
+	s.tasks = append(s.tasks, namedTask{name: name, run: task})
+}
+
+// Run performs a pass on start and then every Interval until ctx is cancelled.
+func (s *Service) Run(ctx context.Context) {
+	s.RunOnce(ctx)
+
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce runs every task once. Only one instance in the cluster runs a pass
+// at a time; the others skip it. It returns the removed count per task, or nil
+// when another instance holds the lock.
+func (s *Service) RunOnce(ctx context.Context) map[string]int64 {
+	unlock, ok := s.lock(ctx)
+	if !ok {
+		return nil
+	}
+	defer unlock()
+
+	counts := make(map[string]int64, len(s.tasks))
+	for _, t := range s.tasks {
+		removed, err := t.run(ctx)
+		if err != nil {
+			failuresTotal.Inc(t.name)
+			log.Error().Err(err).Str("task", t.name).Msg("Maintenance task failed")
+		}
+		removedTotal.Add(float64(removed), t.name)
+		counts[t.name] = removed
+	}
+
+	lastRunSeconds.Set(float64(time.Now().Unix()))
+
+	event := log.Info()
+	for name, removed := range counts {
+		event = event.Int64(name, removed)
+	}
+	event.Msg("Maintenance pass finished")
+
+	return counts
+}
+
+// lock takes the maintenance lock in Redis or, while Redis is unavailable, as
+// a Postgres advisory lock, so partitions are still created during an outage.
+// An instance that can reach Redis and one that can't may then run a pass at
+// the same time; every task tolerates that.
+func (s *Service) lock(ctx context.Context) (unlock func(), ok bool) {
+	acquired, err := s.redis.SetNX(ctx, lockKey, "1", lockTTL).Result()
+	if err == nil {
+		if !acquired {
+			return nil, false
+		}
+		return func() { s.redis.Del(ctx, lockKey) }, true
+	}
+	log.Warn().Err(err).Msg("Redis unavailable, taking the maintenance lock in Postgres")
+
+	// Advisory locks belong to a session, so the pass keeps one connection
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return nil, false
+	}
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, advisoryLockID).Scan(&acquired); err != nil || !acquired {
+		conn.Release()
+		return nil, false
+	}
+	return func() {
+		// A connection that still holds the lock mustn't go back to the pool
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, advisoryLockID); err != nil {
+			conn.Conn().Close(context.Background())
+		}
+		conn.Release()
+	}, true
+}
+
+func (s *Service) deleteExpiredInvites(ctx context.Context) (int64, error) {
+	result, err := s.db.Exec(ctx,
+		`DELETE FROM community_invites WHERE expires_at IS NOT NULL AND expires_at < NOW()`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *Service) deleteExhaustedInvites(ctx context.Context) (int64, error) {
+	result, err := s.db.Exec(ctx,
+		`DELETE FROM community_invites WHERE max_uses IS NOT NULL AND use_count >= max_uses`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+func (s *Service) deleteStaleSessions(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-sessionRetention)
+	result, err := s.db.Exec(ctx,
+		`DELETE FROM user_sessions WHERE expires_at < $1 OR revoked_at < $1`,
+		cutoff,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// clearExpiredTimeouts resets timeouts that already ran out. Permission checks
+// ignore them anyway; this just keeps member lists from showing stale values.
+func (s *Service) clearExpiredTimeouts(ctx context.Context) (int64, error) {
+	result, err := s.db.Exec(ctx,
+		`UPDATE community_members SET timeout_until = NULL WHERE timeout_until < NOW()`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,243 @@
+package media
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+const (
+	// Channel sensitivity and viewer access are rechecked this often while a
+	// file is fetched repeatedly
+	accessCacheTTL  = time.Minute
+	accessCacheSize = 10000
+)
+
+// ChannelAccess reports whether a user can see a channel
+type ChannelAccess interface {
+	CanAccessChannel(ctx context.Context, channelID, userID uuid.UUID) bool
+}
+
+// SignedFile is an attachment object opened through a signed link
+type SignedFile struct {
+	Object  *minio.Object
+	Info    minio.ObjectInfo
+	Expires time.Time
+}
+
+// SetChannelAccess checks attachment access against channel permissions
+// rather than community membership.
+func (s *Service) SetChannelAccess(channels ChannelAccess) {
+	s.channelAccess = channels
+}
+
+// SetURLSigner hands out attachments as signed, expiring links served by
+// the file proxy. The attachment bucket should not be public.
+func (s *Service) SetURLSigner(signer *messaging.URLSigner) {
+	s.urlSigner = signer
+}
+
+// SetHistoryCache drops the cached history of a channel when one of its
+// attachments finishes processing
+func (s *Service) SetHistoryCache(c *messaging.HistoryCache) {
+	s.history = c
+}
+
+// GetVisibleAttachment returns an attachment userID can see, with its links
+// signed for them
+func (s *Service) GetVisibleAttachment(ctx context.Context, attachmentID, userID uuid.UUID) (*models.MessageAttachment, error) {
+	attachment, err := s.GetAttachment(ctx, attachmentID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.canViewAttachment(ctx, attachment, userID) {
+		return nil, ErrAttachmentNotFound
+	}
+	s.urlSigner.SignAttachment(attachment, userID)
+	return attachment, nil
+}
+
+// OpenSignedFile opens the attachment object a signed link points to. Links
+// issued to a member for a sensitive channel or a DM only work while that
+// member can still see it.
+func (s *Service) OpenSignedFile(ctx context.Context, objectName string, query url.Values) (*SignedFile, error) {
+	if s.urlSigner == nil {
+		return nil, ErrAttachmentNotFound
+	}
+	expires, err := s.checkSignedLink(ctx, objectName, query)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := s.minio.GetObject(ctx, s.bucketAttachments, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &SignedFile{Object: obj, Info: info, Expires: expires}, nil
+}
+
+// checkSignedLink verifies a signed link to objectName and returns when it
+// expires. Links to sensitive objects only work for a viewer who can still
+// see them, so links from events, which name no one, don't.
+func (s *Service) checkSignedLink(ctx context.Context, objectName string, query url.Values) (time.Time, error) {
+	viewer, expires, err := s.urlSigner.Verify(objectName, query)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if s.isSensitiveObject(ctx, objectName) {
+		if viewer == uuid.Nil {
+			return time.Time{}, ErrViewerLinkRequired
+		}
+		if !s.canViewObject(ctx, objectName, viewer) {
+			return time.Time{}, ErrAttachmentNotFound
+		}
+	}
+	return expires, nil
+}
+
+// canViewAttachment reports whether userID can see the attachment: its
+// uploader, and anyone who can see the channel or conversation it was posted in
+func (s *Service) canViewAttachment(ctx context.Context, a *models.MessageAttachment, userID uuid.UUID) bool {
+	if a.UploaderID == userID {
+		return true
+	}
+
+	var channelID, conversationID *uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`SELECT m.channel_id, a.dm_conversation_id
+		FROM message_attachments a
+		LEFT JOIN messages m ON m.id = a.message_id AND m.created_at = a.message_created_at AND m.deleted_at IS NULL
+		WHERE a.id = $1`,
+		a.ID,
+	).Scan(&channelID, &conversationID)
+	if err != nil {
+		return false
+	}
+
+	switch {
+	case channelID != nil:
+		return s.canAccessChannel(ctx, *channelID, userID)
+	case conversationID != nil:
+		return s.canAccessDmConversation(ctx, *conversationID, userID)
+	}
+	return false
+}
+
+// attachmentContainer reads where an attachment object was posted from its
+// name, which is dm/{conversation}/... or {community}/{channel}/...
+func attachmentContainer(objectName string) (dm bool, id uuid.UUID, ok bool) {
+	parts := strings.SplitN(objectName, "/", 3)
+	if len(parts) < 3 {
+		return false, uuid.Nil, false
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return false, uuid.Nil, false
+	}
+	return parts[0] == "dm", id, true
+}
+
+// canViewObject reports whether userID can see the channel or conversation an
+// attachment object belongs to
+func (s *Service) canViewObject(ctx context.Context, objectName string, userID uuid.UUID) bool {
+	dm, id, ok := attachmentContainer(objectName)
+	if !ok {
+		return false
+	}
+	return s.accessCache.get("view:"+id.String()+":"+userID.String(), func() bool {
+		if dm {
+			return s.canAccessDmConversation(ctx, id, userID)
+		}
+		return s.canAccessChannel(ctx, id, userID)
+	})
+}
+
+// isSensitiveObject reports whether an attachment object is in a DM or a
+// sensitive channel: one marked NSFW or hidden from the community's default
+// role
+func (s *Service) isSensitiveObject(ctx context.Context, objectName string) bool {
+	dm, id, ok := attachmentContainer(objectName)
+	if !ok {
+		return false
+	}
+	if dm {
+		return true
+	}
+	return s.accessCache.get("sensitive:"+id.String(), func() bool {
+		var sensitive bool
+		err := s.db.QueryRow(ctx,
+			`SELECT COALESCE(c.is_nsfw, FALSE) OR EXISTS (
+				SELECT 1 FROM channel_permissions cp
+				JOIN roles r ON r.id = cp.target_id AND r.community_id = c.community_id
+				WHERE cp.channel_id = c.id AND cp.target_type = 'role' AND r.is_default
+				  AND cp.deny_permissions & $2 <> 0
+			)
+			FROM channels c
+			WHERE c.id = $1`,
+			id, models.PermissionViewChannels,
+		).Scan(&sensitive)
+		// A channel that can't be looked up is treated as sensitive
+		return err != nil || sensitive
+	})
+}
+
+func (s *Service) canAccessChannel(ctx context.Context, channelID, userID uuid.UUID) bool {
+	if s.channelAccess != nil {
+		return s.channelAccess.CanAccessChannel(ctx, channelID, userID)
+	}
+	var communityID uuid.UUID
+	if err := s.db.QueryRow(ctx, "SELECT community_id FROM channels WHERE id = $1", channelID).Scan(&communityID); err != nil {
+		return false
+	}
+	return s.communityService.IsMember(ctx, communityID, userID)
+}
+
+// accessCache remembers access decisions briefly, so each image on a page
+// doesn't cost a permission lookup
+type accessCache struct {
+	mu      sync.Mutex
+	entries map[string]accessCacheEntry
+}
+
+type accessCacheEntry struct {
+	allowed bool
+	expires time.Time
+}
+
+func newAccessCache() *accessCache {
+	return &accessCache{entries: make(map[string]accessCacheEntry)}
+}
+
+func (c *accessCache) get(key string, load func() bool) bool {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.allowed
+	}
+
+	allowed := load()
+	c.mu.Lock()
+	if len(c.entries) >= accessCacheSize {
+		clear(c.entries)
+	}
+	c.entries[key] = accessCacheEntry{allowed: allowed, expires: now.Add(accessCacheTTL)}
+	c.mu.Unlock()
+	return allowed
+}
@@ -1,13 +1,21 @@
 package media
 
 import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"github.com/zentra/server/internal/middleware"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
 	"github.com/zentra/server/internal/utils"
 )
 
@@ -29,6 +37,20 @@ func (h *Handler) Routes() chi.Router {
 	r.Delete("/attachments/{id}", h.DeleteAttachment)
 	r.Get("/attachments/{id}/download", h.GetPresignedURL)
 
+	// Direct-to-storage uploads: get a presigned URL, PUT the file, confirm
+	r.Post("/uploads", h.CreateUploadIntent)
+	r.Post("/uploads/{id}/confirm", h.ConfirmUpload)
+
+	// Resumable uploads (tus 1.0 core with creation, termination and expiration)
+	r.Route("/tus", func(r chi.Router) {
+		r.Use(tusHeaders)
+		r.Options("/", h.TusOptions)
+		r.Post("/", h.CreateResumableUpload)
+		r.Head("/{id}", h.GetResumableUpload)
+		r.Patch("/{id}", h.AppendResumableUpload)
+		r.Delete("/{id}", h.CancelResumableUpload)
+	})
+
 	// Avatar routes
 	r.Post("/avatars/user", h.UploadUserAvatar)
 	r.Post("/avatars/community/{communityId}", h.UploadCommunityAvatar)
@@ -40,6 +62,27 @@ func (h *Handler) Routes() chi.Router {
 	return r
 }
 
+// FileRoutes serves attachments through signed links. They're public, since
+// browsers load them without the session, and sit outside the API timeout so
+// long videos can stream.
+func (h *Handler) FileRoutes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/*", h.ServeFile)
+	r.Head("/*", h.ServeFile)
+	return r
+}
+
+// ProxyRoutes serves resized attachment images. Signed links work without
+// the session, since browsers load images without it; public attachment
+// links need it. Resizing is costly, so requests are rate limited.
+func (h *Handler) ProxyRoutes(jwtSecret string, redisClient *redis.Client, rps int) chi.Router {
+	r := chi.NewRouter()
+	r.Use(middleware.OptionalAuthMiddleware(jwtSecret))
+	r.Use(middleware.RateLimitMiddleware(redisClient, rps))
+	r.Get("/proxy", h.ProxyImage)
+	return r
+}
+
 func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -76,13 +119,15 @@ func (h *Handler) UploadAttachment(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	result, err := h.service.UploadAttachment(r.Context(), userID, channelID, file, header)
+	result, err := h.service.UploadAttachment(r.Context(), userID, channelID, file, header, parseAttachmentMetadata(r))
 	if err != nil {
 		switch err {
 		case ErrFileTooLarge:
 			utils.RespondError(w, http.StatusRequestEntityTooLarge, "File too large")
 		case ErrInvalidFileType:
 			utils.RespondError(w, http.StatusBadRequest, "Invalid file type")
+		case ErrUnreadableImage:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to upload file")
 		}
@@ -125,13 +170,15 @@ func (h *Handler) UploadDmAttachment(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	result, err := h.service.UploadDmAttachment(r.Context(), userID, conversationID, file, header)
+	result, err := h.service.UploadDmAttachment(r.Context(), userID, conversationID, file, header, parseAttachmentMetadata(r))
 	if err != nil {
 		switch err {
 		case ErrFileTooLarge:
 			utils.RespondError(w, http.StatusRequestEntityTooLarge, "File too large")
 		case ErrInvalidFileType:
 			utils.RespondError(w, http.StatusBadRequest, "Invalid file type")
+		case ErrUnreadableImage:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
 		case ErrNotParticipant:
 			utils.RespondError(w, http.StatusForbidden, "Not a participant")
 		default:
@@ -143,14 +190,232 @@ func (h *Handler) UploadDmAttachment(w http.ResponseWriter, r *http.Request) {
 	utils.RespondCreated(w, result)
 }
 
+func (h *Handler) CreateUploadIntent(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req UploadIntentRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	intent, err := h.service.CreateUploadIntent(r.Context(), userID, &req)
+	if err != nil {
+		switch err {
+		case ErrFileTooLarge:
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "File too large")
+		case ErrInvalidFileType:
+			utils.RespondError(w, http.StatusBadRequest, "Invalid file type")
+		case ErrInvalidUploadTarget:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		case ErrNotParticipant:
+			utils.RespondError(w, http.StatusForbidden, "Not a participant")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to create upload")
+		}
+		return
+	}
+
+	utils.RespondCreated(w, intent)
+}
+
+func (h *Handler) ConfirmUpload(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	uploadID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid upload ID")
+		return
+	}
+
+	result, err := h.service.ConfirmUpload(r.Context(), uploadID, userID)
+	if err != nil {
+		switch err {
+		case ErrUploadNotFound:
+			utils.RespondError(w, http.StatusNotFound, "Upload not found")
+		case ErrUploadMissing:
+			utils.RespondError(w, http.StatusConflict, err.Error())
+		case ErrFileTooLarge:
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "File too large")
+		case ErrUploadMismatch, ErrImageTooLarge:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to confirm upload")
+		}
+		return
+	}
+
+	utils.RespondCreated(w, result)
+}
+
+const tusVersion = "1.0.0"
+
+// tusHeaders sets Tus-Resumable on every response and rejects requests for
+// another protocol version
+func tusHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			utils.RespondError(w, http.StatusPreconditionFailed, "Unsupported tus version")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Handler) TusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.service.maxUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) CreateResumableUpload(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid Upload-Length")
+		return
+	}
+
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid Upload-Metadata")
+		return
+	}
+
+	req := ResumableUploadRequest{
+		Filename:    meta["filename"],
+		ContentType: meta["filetype"],
+		Length:      length,
+	}
+	if v, ok := meta["channelId"]; ok {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid channelId")
+			return
+		}
+		req.ChannelID = &id
+	}
+	if v, ok := meta["conversationId"]; ok {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			utils.RespondError(w, http.StatusBadRequest, "Invalid conversationId")
+			return
+		}
+		req.ConversationID = &id
+	}
+	req.IsSpoiler, _ = strconv.ParseBool(meta["spoiler"])
+	if v, ok := meta["description"]; ok {
+		req.Description = &v
+	}
+
+	upload, err := h.service.CreateResumableUpload(r.Context(), userID, &req)
+	if err != nil {
+		switch err {
+		case ErrFileTooLarge:
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "File too large")
+		case ErrInvalidFileType:
+			utils.RespondError(w, http.StatusBadRequest, "Invalid file type")
+		case ErrInvalidUploadTarget:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		case ErrNotParticipant:
+			utils.RespondError(w, http.StatusForbidden, "Not a participant")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to create upload")
+		}
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.ID.String())
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) GetResumableUpload(w http.ResponseWriter, r *http.Request) {
+	userID, uploadID, ok := requireResumableUpload(w, r)
+	if !ok {
+		return
+	}
+
+	upload, err := h.service.GetResumableUpload(r.Context(), uploadID, userID)
+	if err != nil {
+		respondResumableError(w, err, "Failed to get upload")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) AppendResumableUpload(w http.ResponseWriter, r *http.Request) {
+	userID, uploadID, ok := requireResumableUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		utils.RespondError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream")
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid Upload-Offset")
+		return
+	}
+
+	upload, err := h.service.AppendChunk(r.Context(), uploadID, userID, offset, r.Body)
+	if err != nil {
+		respondResumableError(w, err, "Failed to write upload")
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) CancelResumableUpload(w http.ResponseWriter, r *http.Request) {
+	userID, uploadID, ok := requireResumableUpload(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.CancelResumableUpload(r.Context(), uploadID, userID); err != nil {
+		respondResumableError(w, err, "Failed to cancel upload")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *Handler) GetAttachment(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
 	attachmentID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid attachment ID")
 		return
 	}
 
-	attachment, err := h.service.GetAttachment(r.Context(), attachmentID)
+	attachment, err := h.service.GetVisibleAttachment(r.Context(), attachmentID, userID)
 	if err != nil {
 		if err == ErrAttachmentNotFound {
 			utils.RespondError(w, http.StatusNotFound, "Attachment not found")
@@ -189,6 +454,12 @@ func (h *Handler) DeleteAttachment(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetPresignedURL(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
 	attachmentID, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
 		utils.RespondError(w, http.StatusBadRequest, "Invalid attachment ID")
@@ -196,7 +467,7 @@ func (h *Handler) GetPresignedURL(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 1 hour expiry for download URLs
-	url, err := h.service.GetPresignedURL(r.Context(), attachmentID, 1*time.Hour)
+	url, err := h.service.GetPresignedURL(r.Context(), attachmentID, userID, 1*time.Hour)
 	if err != nil {
 		if err == ErrAttachmentNotFound {
 			utils.RespondError(w, http.StatusNotFound, "Attachment not found")
@@ -209,6 +480,88 @@ func (h *Handler) GetPresignedURL(w http.ResponseWriter, r *http.Request) {
 	utils.RespondSuccess(w, map[string]string{"url": url})
 }
 
+func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request) {
+	file, err := h.service.OpenSignedFile(r.Context(), chi.URLParam(r, "*"), r.URL.Query())
+	if err != nil {
+		switch {
+		case errors.Is(err, messaging.ErrInvalidURLSignature):
+			utils.RespondError(w, http.StatusForbidden, "Invalid signature")
+		case errors.Is(err, messaging.ErrExpiredURL):
+			utils.RespondError(w, http.StatusGone, "Link has expired")
+		case errors.Is(err, ErrViewerLinkRequired):
+			utils.RespondErrorWithCode(w, http.StatusForbidden, "VIEWER_LINK_REQUIRED", "Fetch the attachment for a link of your own")
+		case errors.Is(err, ErrAttachmentNotFound):
+			utils.RespondError(w, http.StatusNotFound, "File not found")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to get file")
+		}
+		return
+	}
+	defer file.Object.Close()
+
+	// Uploads are served from the API's origin, so they must never be
+	// sniffed or run as a page
+	w.Header().Set("Content-Type", file.Info.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(int64(time.Until(file.Expires).Seconds()), 10))
+	w.Header().Set("ETag", `"`+file.Info.ETag+`"`)
+	http.ServeContent(w, r, "", file.Info.LastModified, file.Object)
+}
+
+func (h *Handler) ProxyImage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	width, err := strconv.Atoi(query.Get("w"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid width")
+		return
+	}
+	format := query.Get("format")
+	viewerID, _ := middleware.GetUserID(r.Context())
+
+	img, err := h.service.ProxyImage(r.Context(), viewerID, query.Get("url"), width, format, r.Header.Get("Accept"))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrProxyAuthRequired):
+			utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		case errors.Is(err, ErrInvalidProxyURL), errors.Is(err, ErrInvalidProxyFormat), errors.Is(err, ErrProxyFormatDisabled):
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrInvalidProxyWidth):
+			utils.RespondError(w, http.StatusBadRequest, "Width must be one of "+strings.Trim(fmt.Sprint(ProxyWidths), "[]"))
+		case errors.Is(err, ErrNotResizable):
+			utils.RespondError(w, http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, ErrImageTooLarge):
+			utils.RespondError(w, http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, messaging.ErrInvalidURLSignature):
+			utils.RespondError(w, http.StatusForbidden, "Invalid signature")
+		case errors.Is(err, messaging.ErrExpiredURL):
+			utils.RespondError(w, http.StatusGone, "Link has expired")
+		case errors.Is(err, ErrViewerLinkRequired):
+			utils.RespondErrorWithCode(w, http.StatusForbidden, "VIEWER_LINK_REQUIRED", "Fetch the attachment for a link of your own")
+		case errors.Is(err, ErrAttachmentNotFound):
+			utils.RespondError(w, http.StatusNotFound, "File not found")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to resize image")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("ETag", `"`+img.ETag+`"`)
+	if format == "" || format == ProxyFormatAuto {
+		w.Header().Set("Vary", "Accept")
+	}
+	// A variant never changes; a signed link's only lasts as long as the link
+	if img.Expires.IsZero() {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.FormatInt(int64(time.Until(img.Expires).Seconds()), 10))
+	}
+	http.ServeContent(w, r, "", img.LastModified, bytes.NewReader(img.Data))
+}
+
 func (h *Handler) UploadUserAvatar(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -399,3 +752,76 @@ func (h *Handler) UploadCommunityIcon(w http.ResponseWriter, r *http.Request) {
 
 	utils.RespondSuccess(w, map[string]string{"url": url})
 }
+
+// parseAttachmentMetadata reads the optional "spoiler" and "description" form fields.
+func parseAttachmentMetadata(r *http.Request) AttachmentMetadata {
+	var meta AttachmentMetadata
+	meta.IsSpoiler, _ = strconv.ParseBool(r.FormValue("spoiler"))
+	if _, ok := r.MultipartForm.Value["description"]; ok {
+		description := r.FormValue("description")
+		meta.Description = &description
+	}
+	return meta
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma-separated keys,
+// each followed by a space and its base64 value unless it's empty
+func parseTusMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	if strings.TrimSpace(header) == "" {
+		return meta, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload *ResumableUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+func requireResumableUpload(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	uploadID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusNotFound, "Upload not found")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, uploadID, true
+}
+
+func respondResumableError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case ErrUploadNotFound:
+		utils.RespondError(w, http.StatusNotFound, "Upload not found")
+	case ErrOffsetMismatch, ErrUploadComplete:
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case ErrUploadLocked:
+		utils.RespondError(w, http.StatusLocked, err.Error())
+	case ErrChunkTooLarge, ErrFileTooLarge:
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, err.Error())
+	case ErrUploadMismatch, ErrImageTooLarge:
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case ErrNotParticipant:
+		utils.RespondError(w, http.StatusForbidden, "Not a participant")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,280 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+var errMalformedImage = errors.New("malformed image")
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// stripImageMetadata removes EXIF, XMP, IPTC and text metadata (camera
+// details, GPS position, comments) from a JPEG, PNG or WebP without
+// re-encoding it. A JPEG's EXIF orientation is kept in a minimal EXIF block so
+// it still displays the right way up, and is returned so renditions can be
+// rotated to match. Other types are returned as they are.
+func stripImageMetadata(contentType string, data []byte) ([]byte, int, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		out, err := stripPNGMetadata(data)
+		return out, 1, err
+	case "image/webp":
+		out, err := stripWebPMetadata(data)
+		return out, 1, err
+	}
+	return data, 1, nil
+}
+
+func stripJPEGMetadata(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, 0, errMalformedImage
+	}
+
+	orientation := 1
+	segments := make([][]byte, 0, 8)
+	pos := 2
+	for {
+		if pos+4 > len(data) || data[pos] != 0xff {
+			return nil, 0, errMalformedImage
+		}
+		marker := data[pos+1]
+		if marker == 0xff {
+			// Fill byte before a marker
+			pos++
+			continue
+		}
+		// Entropy-coded data starts after SOS; everything from here is kept
+		if marker == 0xda {
+			segments = append(segments, data[pos:])
+			break
+		}
+		// Markers without a length
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			segments = append(segments, data[pos:pos+2])
+			pos += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, 0, errMalformedImage
+		}
+		segment := data[pos:end]
+		pos = end
+
+		switch {
+		case marker == 0xe1:
+			// EXIF or XMP; only the orientation survives
+			if o := exifOrientation(segment[4:]); o != 0 {
+				orientation = o
+			}
+			continue
+		case marker >= 0xe0 && marker <= 0xef:
+			// JFIF, the ICC colour profile and Adobe's colour transform
+			// affect how the image looks; the rest is metadata
+			if marker != 0xe0 && marker != 0xe2 && marker != 0xee {
+				continue
+			}
+		case marker == 0xfe:
+			// Comment
+			continue
+		}
+		segments = append(segments, segment)
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xff, 0xd8)
+	// The orientation goes after JFIF, which must come first
+	inserted := orientation == 1
+	for _, segment := range segments {
+		if !inserted && segment[1] != 0xe0 {
+			out = append(out, orientationSegment(orientation)...)
+			inserted = true
+		}
+		out = append(out, segment...)
+	}
+	return out, orientation, nil
+}
+
+// exifOrientation reads the orientation tag from an APP1 payload, or 0 when
+// it's not EXIF or has none
+func exifOrientation(payload []byte) int {
+	if len(payload) < 14 || string(payload[:6]) != "Exif\x00\x00" {
+		return 0
+	}
+	tiff := payload[6:]
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd : ifd+2]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// Orientation is a SHORT held in the entry itself
+		if order.Uint16(tiff[entry:entry+2]) == 0x0112 && order.Uint16(tiff[entry+2:entry+4]) == 3 {
+			o := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orientationSegment is an APP1 segment with an EXIF block holding nothing
+// but the orientation tag
+func orientationSegment(orientation int) []byte {
+	return []byte{
+		0xff, 0xe1, 0x00, 0x22,
+		'E', 'x', 'i', 'f', 0x00, 0x00,
+		// Big-endian TIFF header, first IFD at offset 8
+		'M', 'M', 0x00, 0x2a, 0x00, 0x00, 0x00, 0x08,
+		// One entry: tag 0x0112, type SHORT, count 1, then the value
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, byte(orientation), 0x00, 0x00,
+		// No next IFD
+		0x00, 0x00, 0x00, 0x00,
+	}
+}
+
+func stripPNGMetadata(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errMalformedImage
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	pos := len(pngSignature)
+	for pos < len(data) {
+		if pos+12 > len(data) {
+			return nil, errMalformedImage
+		}
+		length := int(binary.BigEndian.Uint32(data[pos : pos+4]))
+		end := pos + 12 + length
+		if end > len(data) {
+			return nil, errMalformedImage
+		}
+		switch string(data[pos+4 : pos+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return out, nil
+}
+
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformedImage
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:12]...)
+	pos := 12
+	for pos < len(data) {
+		if pos+8 > len(data) {
+			return nil, errMalformedImage
+		}
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		// Chunks are padded to an even length
+		end := pos + 8 + size + size&1
+		if pos+8+size > len(data) {
+			return nil, errMalformedImage
+		}
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[pos:end]
+		pos = end
+
+		switch string(chunk[:4]) {
+		case "EXIF", "XMP ":
+			continue
+		case "VP8X":
+			// Clear the EXIF and XMP flags
+			if len(chunk) > 8 {
+				chunk = append([]byte(nil), chunk...)
+				chunk[8] &^= 0x08 | 0x04
+			}
+		}
+		out = append(out, chunk...)
+	}
+	binary.LittleEndian.PutUint32(out[4:8], uint32(len(out)-8))
+	return out, nil
+}
+
+// applyOrientation turns a decoded image the way its EXIF orientation says it
+// should be displayed. It's meant for renditions, which are small.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(x, y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// stripUploadedImage strips an image before it is stored where it can be
+// served. One that can't be parsed is refused rather than stored with its
+// metadata.
+func stripUploadedImage(contentType string, data []byte) ([]byte, error) {
+	stripped, _, err := stripImageMetadata(contentType, data)
+	if err != nil {
+		return nil, ErrUnreadableImage
+	}
+	return stripped, nil
+}
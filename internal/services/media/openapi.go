@@ -0,0 +1,129 @@
+package media
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	attachmentForm := []openapi.Param{
+		{Name: "spoiler", Type: "boolean", Description: "Blur the attachment until it is clicked"},
+		{Name: "description", Description: "Alt text"},
+	}
+	tusResumable := openapi.Param{Name: "Tus-Resumable", Required: true, Enum: []string{tusVersion}}
+	imageURL := struct {
+		URL string `json:"url"`
+	}{}
+
+	openapi.Describe((*Handler).UploadAttachment, openapi.Operation{
+		Summary:  "Upload a file to attach to a channel message",
+		Upload:   "file",
+		Form:     append([]openapi.Param{{Name: "channelId", Format: "uuid", Required: true}}, attachmentForm...),
+		Response: UploadResult{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).UploadDmAttachment, openapi.Operation{
+		Summary:  "Upload a file to attach to a direct message",
+		Upload:   "file",
+		Form:     append([]openapi.Param{{Name: "conversationId", Format: "uuid", Required: true}}, attachmentForm...),
+		Response: UploadResult{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetAttachment, openapi.Operation{
+		Summary:  "Get an attachment",
+		Path:     id,
+		Response: models.MessageAttachment{},
+	})
+	openapi.Describe((*Handler).DeleteAttachment, openapi.Operation{
+		Summary: "Delete an attachment",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetPresignedURL, openapi.Operation{
+		Summary:  "Get a download link to an attachment, valid for an hour",
+		Path:     id,
+		Response: imageURL,
+	})
+
+	openapi.Describe((*Handler).CreateUploadIntent, openapi.Operation{
+		Summary:     "Start a direct upload to storage",
+		Description: "PUT the file to the returned URL, then confirm the upload.",
+		Body:        UploadIntentRequest{},
+		Response:    UploadIntent{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).ConfirmUpload, openapi.Operation{
+		Summary:  "Turn a finished direct upload into an attachment",
+		Path:     id,
+		Response: UploadResult{},
+		Status:   http.StatusCreated,
+	})
+
+	openapi.Describe((*Handler).TusOptions, openapi.Operation{
+		Summary: "Get the tus protocol versions, extensions and maximum size",
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).CreateResumableUpload, openapi.Operation{
+		Summary:     "Start a resumable tus upload",
+		Description: "Upload-Metadata carries filename, filetype, channelId or conversationId, spoiler and description. The Location header is the upload's URL.",
+		Headers: []openapi.Param{
+			tusResumable,
+			{Name: "Upload-Length", Type: "integer", Required: true},
+			{Name: "Upload-Metadata", Description: "Comma-separated keys, each followed by a space and its base64 value"},
+		},
+		Shape:  openapi.Empty,
+		Status: http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetResumableUpload, openapi.Operation{
+		Summary:     "Get how much of a resumable upload has arrived",
+		Description: "The offset is in the Upload-Offset header.",
+		Path:        id,
+		Headers:     []openapi.Param{tusResumable},
+		Shape:       openapi.Empty,
+	})
+	openapi.Describe((*Handler).AppendResumableUpload, openapi.Operation{
+		Summary:     "Upload the next part of a resumable upload",
+		Description: "The upload becomes an attachment once its last byte arrives.",
+		Path:        id,
+		Headers: []openapi.Param{
+			tusResumable,
+			{Name: "Upload-Offset", Type: "integer", Required: true},
+		},
+		BodyType: "application/offset+octet-stream",
+		Status:   http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).CancelResumableUpload, openapi.Operation{
+		Summary: "Cancel a resumable upload",
+		Path:    id,
+		Headers: []openapi.Param{tusResumable},
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).UploadUserAvatar, openapi.Operation{
+		Summary:  "Upload the current user's avatar",
+		Upload:   "avatar",
+		Response: imageURL,
+	})
+	openapi.Describe((*Handler).UploadCommunityAvatar, openapi.Operation{
+		Summary:  "Upload a community's avatar",
+		Upload:   "avatar",
+		Path:     communityID,
+		Response: imageURL,
+	})
+	openapi.Describe((*Handler).UploadCommunityBanner, openapi.Operation{
+		Summary:  "Upload a community's banner",
+		Upload:   "banner",
+		Path:     communityID,
+		Response: imageURL,
+	})
+	openapi.Describe((*Handler).UploadCommunityIcon, openapi.Operation{
+		Summary:  "Upload a community's icon",
+		Upload:   "icon",
+		Path:     communityID,
+		Response: imageURL,
+	})
+}
@@ -0,0 +1,389 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/nfnt/resize"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+const (
+	MediumMaxSize = 1280
+
+	processingQueueSize = 256
+	// How long one image may take before another worker retries it
+	processingTimeout = 10 * time.Minute
+	// How often attachments that missed the queue are picked up
+	processingSweepInterval = time.Minute
+	// Images with more pixels get no renditions rather than being decoded
+	maxDecodePixels = 50_000_000
+)
+
+// claimExpiredBefore is when a claim must have been made for another worker to
+// take over, given the image cutoff as $1 and the video cutoff as $2
+const claimExpiredBefore = `CASE WHEN content_type LIKE 'video/%' THEN $2::timestamptz ELSE $1::timestamptz END`
+
+// errUnprocessable marks a file processing can never succeed on, so it's
+// marked failed instead of retried
+var errUnprocessable = errors.New("attachment can't be processed")
+
+// StartProcessing starts the workers that make renditions of new attachments
+// and picks up any an earlier run didn't finish. Videos have their own
+// workers so a long transcode doesn't hold up images. It returns once ctx is
+// done.
+func (s *Service) StartProcessing(ctx context.Context, imageWorkers, videoWorkers int) {
+	for i := 0; i < max(imageWorkers, 1); i++ {
+		go s.processWorker(ctx, s.processQueue, processingTimeout)
+	}
+	if s.transcoder != nil {
+		for i := 0; i < max(videoWorkers, 1); i++ {
+			go s.processWorker(ctx, s.transcodeQueue, transcodeTimeout)
+		}
+	}
+
+	ticker := time.NewTicker(processingSweepInterval)
+	defer ticker.Stop()
+	for {
+		s.queueUnprocessed(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processingStatusFor is the status a new attachment starts with, nil when
+// there is nothing to do for it
+func (s *Service) processingStatusFor(contentType string, size int64) *models.AttachmentStatus {
+	if AllowedImageTypes[contentType] || (s.transcodes(contentType) && size <= s.maxTranscodeSize) {
+		status := models.AttachmentProcessing
+		return &status
+	}
+	return nil
+}
+
+func (s *Service) transcodes(contentType string) bool {
+	return s.transcoder != nil && AllowedVideoTypes[contentType]
+}
+
+// queueProcessing hands an attachment to the workers. If the queue is full the
+// sweep picks it up later.
+func (s *Service) queueProcessing(attachmentID uuid.UUID, contentType string) {
+	queue := s.processQueue
+	if AllowedVideoTypes[contentType] {
+		queue = s.transcodeQueue
+	}
+	select {
+	case queue <- attachmentID:
+	default:
+		log.Warn().Str("attachmentId", attachmentID.String()).Msg("Processing queue full, deferring attachment")
+	}
+}
+
+// queueUnprocessed queues attachments nobody is working on: ones that didn't
+// fit in the queue, were left by a stopped instance or whose worker gave up
+func (s *Service) queueUnprocessed(ctx context.Context) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, content_type FROM message_attachments
+		WHERE processing_status = 'processing'
+		  AND (processing_started_at < `+claimExpiredBefore+`
+		       OR (processing_started_at IS NULL AND created_at < NOW() - INTERVAL '1 minute'))
+		ORDER BY created_at
+		LIMIT $3`,
+		time.Now().Add(-processingTimeout), time.Now().Add(-transcodeTimeout), processingQueueSize/2,
+	)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to find unprocessed attachments")
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id          uuid.UUID
+			contentType string
+		)
+		if err := rows.Scan(&id, &contentType); err != nil {
+			return
+		}
+		s.queueProcessing(id, contentType)
+	}
+}
+
+func (s *Service) processWorker(ctx context.Context, queue <-chan uuid.UUID, timeout time.Duration) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-queue:
+			jobCtx, cancel := context.WithTimeout(ctx, timeout)
+			if err := s.processAttachment(jobCtx, id); err != nil {
+				log.Warn().Err(err).Str("attachmentId", id.String()).Msg("Failed to process attachment")
+			}
+			cancel()
+		}
+	}
+}
+
+// processAttachment claims an attachment and runs the processing for its type.
+// Claims expire, so an attachment whose worker died is retried by another.
+func (s *Service) processAttachment(ctx context.Context, attachmentID uuid.UUID) error {
+	var fileURL, contentType string
+	err := s.db.QueryRow(ctx,
+		`UPDATE message_attachments SET processing_started_at = NOW()
+		WHERE id = $3 AND processing_status = 'processing'
+		  AND (processing_started_at IS NULL OR processing_started_at < `+claimExpiredBefore+`)
+		RETURNING file_url, content_type`,
+		time.Now().Add(-processingTimeout), time.Now().Add(-transcodeTimeout), attachmentID,
+	).Scan(&fileURL, &contentType)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Done, deleted or claimed by another worker
+			return nil
+		}
+		return err
+	}
+
+	objectName := s.trimURLToObjectName(fileURL, s.bucketAttachments)
+	switch {
+	case AllowedImageTypes[contentType]:
+		err = s.processImage(ctx, attachmentID, objectName, contentType)
+	case s.transcodes(contentType):
+		err = s.processVideo(ctx, attachmentID, objectName, contentType)
+	default:
+		err = fmt.Errorf("%w: nothing to do for %s", errUnprocessable, contentType)
+	}
+
+	if errors.Is(err, errUnprocessable) {
+		_, dbErr := s.db.Exec(ctx,
+			`UPDATE message_attachments SET processing_status = 'failed', processing_started_at = NULL WHERE id = $1`,
+			attachmentID,
+		)
+		if dbErr != nil {
+			return dbErr
+		}
+		s.publishAttachmentUpdate(ctx, attachmentID)
+	}
+	return err
+}
+
+// processImage strips metadata from the stored image, then records its size
+// and blurhash and stores a thumbnail and, for large images, a medium-size
+// rendition next to it
+func (s *Service) processImage(ctx context.Context, attachmentID uuid.UUID, objectName, contentType string) error {
+	obj, err := s.minio.GetObject(ctx, s.bucketAttachments, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(obj, MaxImageSize+1))
+	obj.Close()
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return fmt.Errorf("%w: %v", errUnprocessable, err)
+		}
+		return err
+	}
+
+	// Uploads are stripped before they are stored, which leaves this with
+	// only the orientation to read. Files stored before that are stripped here.
+	stripped, orientation, err := stripImageMetadata(contentType, data)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnprocessable, err)
+	}
+	if !bytes.Equal(stripped, data) {
+		_, err := s.minio.PutObject(ctx, s.bucketAttachments, objectName, bytes.NewReader(stripped), int64(len(stripped)),
+			minio.PutObjectOptions{ContentType: contentType})
+		if err != nil {
+			return fmt.Errorf("failed to store stripped image: %w", err)
+		}
+	}
+
+	width, height, err := imageDimensions(stripped)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnprocessable, err)
+	}
+	if orientation >= 5 {
+		width, height = height, width
+	}
+
+	var renditions imageRenditions
+	if width*height <= maxDecodePixels {
+		renditions, err = s.storeRenditions(ctx, stripped, objectName, attachmentID, contentType, orientation, width, height)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE message_attachments
+		SET file_size = $2, width = $3, height = $4, thumbnail_url = COALESCE($5, thumbnail_url), medium_url = $6, blurhash = $7,
+		    processing_status = 'ready', processing_started_at = NULL
+		WHERE id = $1`,
+		attachmentID, len(stripped), width, height, renditions.thumbnailURL, renditions.mediumURL, renditions.blurhash,
+	)
+	if err != nil {
+		return err
+	}
+
+	s.publishAttachmentUpdate(ctx, attachmentID)
+	return nil
+}
+
+type imageRenditions struct {
+	thumbnailURL *string
+	mediumURL    *string
+	blurhash     *string
+}
+
+// storeRenditions decodes the image and stores its renditions under thumbs/
+// next to the original. Images without a registered decoder (WebP) keep their
+// original as the only rendition.
+func (s *Service) storeRenditions(ctx context.Context, data []byte, objectName string, attachmentID uuid.UUID, contentType string, orientation, width, height int) (imageRenditions, error) {
+	var r imageRenditions
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return r, nil
+		}
+		return r, fmt.Errorf("%w: %v", errUnprocessable, err)
+	}
+
+	// Transparent images keep their transparency as PNG
+	ext, encode := ".jpg", func(w io.Writer, m image.Image) error { return jpeg.Encode(w, m, &jpeg.Options{Quality: 80}) }
+	if o, ok := img.(interface{ Opaque() bool }); ok && !o.Opaque() {
+		ext, encode = ".png", png.Encode
+	}
+
+	// Bounds are in display orientation, the decoded image may be turned
+	fit := func(maxW, maxH int) image.Image {
+		if orientation >= 5 {
+			maxW, maxH = maxH, maxW
+		}
+		return applyOrientation(resize.Thumbnail(uint(maxW), uint(maxH), img, resize.Lanczos3), orientation)
+	}
+	dir := path.Join(path.Dir(objectName), "thumbs")
+
+	thumb := fit(ThumbnailMaxWidth, ThumbnailMaxHeight)
+	thumbURL, err := s.putRendition(ctx, path.Join(dir, attachmentID.String()+"_thumb"+ext), thumb, ext, encode)
+	if err != nil {
+		return r, err
+	}
+	r.thumbnailURL = &thumbURL
+
+	// Animated GIFs decode to their first frame, which would stand in badly
+	// for the animation at full size
+	if contentType != "image/gif" && (width > MediumMaxSize || height > MediumMaxSize) {
+		mediumURL, err := s.putRendition(ctx, path.Join(dir, attachmentID.String()+"_medium"+ext), fit(MediumMaxSize, MediumMaxSize), ext, encode)
+		if err != nil {
+			return r, err
+		}
+		r.mediumURL = &mediumURL
+	}
+
+	// 4x3 components across the longer side is what the reference encoder suggests
+	xComponents, yComponents := 4, 3
+	if height > width {
+		xComponents, yComponents = 3, 4
+	}
+	hash := encodeBlurhash(resize.Thumbnail(32, 32, thumb, resize.Bilinear), xComponents, yComponents)
+	r.blurhash = &hash
+
+	return r, nil
+}
+
+func (s *Service) putRendition(ctx context.Context, objectName string, img image.Image, ext string, encode func(io.Writer, image.Image) error) (string, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, img); err != nil {
+		return "", err
+	}
+
+	contentType := "image/jpeg"
+	if ext == ".png" {
+		contentType = "image/png"
+	}
+	_, err := s.minio.PutObject(ctx, s.bucketAttachments, objectName, &buf, int64(buf.Len()),
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", fmt.Errorf("failed to store rendition: %w", err)
+	}
+	return s.getPublicURL(s.bucketAttachments, objectName), nil
+}
+
+// publishAttachmentUpdate sends ATTACHMENT_UPDATE where the attachment can be
+// seen: the channel or conversation of its message, or only its uploader
+// while it isn't on a message yet or the message is quarantined
+func (s *Service) publishAttachmentUpdate(ctx context.Context, attachmentID uuid.UUID) {
+	attachment, err := s.GetAttachment(ctx, attachmentID)
+	if err != nil {
+		return
+	}
+
+	var (
+		channelID      *uuid.UUID
+		quarantined    bool
+		dmMessageID    *uuid.UUID
+		conversationID *uuid.UUID
+	)
+	err = s.db.QueryRow(ctx,
+		`SELECT m.channel_id, COALESCE(m.is_quarantined, FALSE), a.dm_message_id, a.dm_conversation_id
+		FROM message_attachments a
+		LEFT JOIN messages m ON m.id = a.message_id AND m.created_at = a.message_created_at AND m.deleted_at IS NULL
+		WHERE a.id = $1`,
+		attachmentID,
+	).Scan(&channelID, &quarantined, &dmMessageID, &conversationID)
+	if err != nil {
+		return
+	}
+	if channelID != nil {
+		s.history.Invalidate(ctx, *channelID)
+	}
+
+	stream := database.UserStream(attachment.UploaderID.String())
+	switch {
+	case channelID != nil && !quarantined:
+		stream = channelID.String()
+	case dmMessageID != nil && conversationID != nil:
+		attachment.MessageID = dmMessageID
+		stream = conversationID.String()
+	}
+
+	// Events reach everyone watching the stream, so links aren't tied to a viewer
+	s.urlSigner.SignAttachment(attachment, uuid.Nil)
+
+	broadcast := struct {
+		ChannelID string      `json:"channelId"`
+		Event     interface{} `json:"event"`
+	}{
+		ChannelID: stream,
+		Event: struct {
+			Type string      `json:"type"`
+			Data interface{} `json:"data"`
+		}{Type: "ATTACHMENT_UPDATE", Data: attachment},
+	}
+
+	jsonData, err := json.Marshal(broadcast)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal attachment event")
+		return
+	}
+	if err := database.PublishBroadcast(ctx, jsonData); err != nil {
+		log.Error().Err(err).Msg("Failed to publish attachment event to Redis")
+	}
+}
@@ -0,0 +1,322 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/nfnt/resize"
+	"github.com/rs/zerolog/log"
+)
+
+// Widths the image proxy resizes to. A fixed set keeps the number of stored
+// variants of each image small.
+var ProxyWidths = []int{64, 128, 256, 400, 640, 800, 1280, 1920}
+
+// Output formats of the image proxy. "auto" picks the best one the client
+// accepts.
+const (
+	ProxyFormatAuto = "auto"
+	ProxyFormatJPEG = "jpeg"
+	ProxyFormatPNG  = "png"
+	ProxyFormatWebP = "webp"
+	ProxyFormatAVIF = "avif"
+)
+
+var proxyContentTypes = map[string]string{
+	ProxyFormatJPEG: "image/jpeg",
+	ProxyFormatPNG:  "image/png",
+	ProxyFormatWebP: "image/webp",
+	ProxyFormatAVIF: "image/avif",
+}
+
+var (
+	ErrInvalidProxyURL      = errors.New("not an attachment URL")
+	ErrInvalidProxyWidth    = errors.New("unsupported width")
+	ErrInvalidProxyFormat   = errors.New("unsupported format")
+	ErrProxyFormatDisabled  = errors.New("format is not available on this server")
+	ErrNotResizable         = errors.New("attachment is not an image")
+	ErrProxyAuthRequired    = errors.New("sign in to resize public attachments")
+	errProxyDecoderNotFound = errors.New("no decoder for image")
+)
+
+// ImageEncoder writes images in formats the standard library can't encode
+type ImageEncoder interface {
+	// EncodeImage converts the PNG at src to format (webp or avif) at dst
+	EncodeImage(ctx context.Context, src, dst, format string) error
+}
+
+// SetImageEncoder lets the image proxy serve WebP and AVIF.
+func (s *Service) SetImageEncoder(e ImageEncoder) {
+	s.imageEncoder = e
+}
+
+// ProxiedImage is an attachment image as the proxy serves it
+type ProxiedImage struct {
+	Data         []byte
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	// Zero unless the attachment was reached through a signed link
+	Expires time.Time
+}
+
+// ProxyImage returns the image rawURL points to, at most width pixels wide, in
+// format. With ProxyFormatAuto the format is chosen from the client's Accept
+// header. Variants are stored next to the image's other renditions so each is
+// only made once; images the proxy can't decode (WebP without an encoder,
+// GIF) are returned as they are. While attachments are public anyone could
+// pass a link, so the caller must be signed in (viewerID); otherwise the
+// signed link is what lets them in.
+func (s *Service) ProxyImage(ctx context.Context, viewerID uuid.UUID, rawURL string, width int, format, accept string) (*ProxiedImage, error) {
+	if s.urlSigner == nil && viewerID == uuid.Nil {
+		return nil, ErrProxyAuthRequired
+	}
+	if !slices.Contains(ProxyWidths, width) {
+		return nil, ErrInvalidProxyWidth
+	}
+	objectName, expires, err := s.proxyObject(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := s.minio.StatObject(ctx, s.bucketAttachments, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	if !AllowedImageTypes[info.ContentType] {
+		return nil, ErrNotResizable
+	}
+
+	format, err = s.proxyFormat(format, accept, info.ContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	stem := strings.TrimSuffix(path.Base(objectName), path.Ext(objectName))
+	variantName := path.Join(path.Dir(objectName), "thumbs", fmt.Sprintf("%s_w%d.%s", stem, width, format))
+	result := &ProxiedImage{
+		ContentType:  proxyContentTypes[format],
+		ETag:         fmt.Sprintf("%s-w%d-%s", info.ETag, width, format),
+		LastModified: info.LastModified,
+		Expires:      expires,
+	}
+
+	if data, err := s.readObject(ctx, variantName); err == nil {
+		result.Data = data
+		return result, nil
+	}
+
+	original, err := s.readObject(ctx, objectName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.renderVariant(ctx, original, width, format)
+	if errors.Is(err, errProxyDecoderNotFound) {
+		result.Data, result.ContentType, result.ETag = original, info.ContentType, info.ETag
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Storing the variant is only a cache; the image is served either way
+	_, err = s.minio.PutObject(ctx, s.bucketAttachments, variantName, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: result.ContentType})
+	if err != nil {
+		log.Warn().Err(err).Str("object", variantName).Msg("Failed to store resized image")
+	}
+
+	result.Data = data
+	return result, nil
+}
+
+// proxyObject checks rawURL is a link to an attachment the caller may fetch,
+// a signed link while attachments are private, and returns its object
+func (s *Service) proxyObject(ctx context.Context, rawURL string) (string, time.Time, error) {
+	if s.urlSigner == nil {
+		objectName := s.trimURLToObjectName(rawURL, s.bucketAttachments)
+		if objectName == rawURL || objectName == "" || strings.Contains(objectName, "?") {
+			return "", time.Time{}, ErrInvalidProxyURL
+		}
+		return objectName, time.Time{}, nil
+	}
+
+	objectName, ok := s.urlSigner.ObjectName(rawURL)
+	if !ok {
+		return "", time.Time{}, ErrInvalidProxyURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidProxyURL
+	}
+	expires, err := s.checkSignedLink(ctx, objectName, parsed.Query())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return objectName, expires, nil
+}
+
+// proxyFormat resolves the requested format. Automatic selection prefers AVIF,
+// then WebP, and otherwise keeps PNGs as PNG and makes everything else JPEG.
+func (s *Service) proxyFormat(format, accept, contentType string) (string, error) {
+	switch format {
+	case ProxyFormatJPEG, ProxyFormatPNG:
+		return format, nil
+	case ProxyFormatWebP, ProxyFormatAVIF:
+		if s.imageEncoder == nil {
+			return "", ErrProxyFormatDisabled
+		}
+		return format, nil
+	case "", ProxyFormatAuto:
+	default:
+		return "", ErrInvalidProxyFormat
+	}
+
+	if s.imageEncoder != nil {
+		for _, f := range []string{ProxyFormatAVIF, ProxyFormatWebP} {
+			if strings.Contains(accept, proxyContentTypes[f]) {
+				return f, nil
+			}
+		}
+	}
+	if contentType == "image/png" {
+		return ProxyFormatPNG, nil
+	}
+	return ProxyFormatJPEG, nil
+}
+
+// renderVariant resizes an image to at most width pixels wide, turned the way
+// its EXIF orientation says, and encodes it as format
+func (s *Service) renderVariant(ctx context.Context, data []byte, width int, format string) ([]byte, error) {
+	select {
+	case s.resizeSlots <- struct{}{}:
+		defer func() { <-s.resizeSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	cfg, kind, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || kind == "gif" {
+		// Resizing would drop a GIF's animation
+		return nil, errProxyDecoderNotFound
+	}
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	orientation := 1
+	if kind == "jpeg" {
+		_, orientation, _ = stripJPEGMetadata(data)
+	}
+	// The width is of the image as displayed, the decoded one may be turned
+	w, h := uint(width), uint(0)
+	displayWidth := cfg.Width
+	if orientation >= 5 {
+		w, h = 0, uint(width)
+		displayWidth = cfg.Height
+	}
+	if width < displayWidth {
+		img = resize.Resize(w, h, img, resize.Lanczos3)
+	}
+	img = applyOrientation(img, orientation)
+
+	var buf bytes.Buffer
+	switch format {
+	case ProxyFormatJPEG:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 82})
+	case ProxyFormatPNG:
+		err = png.Encode(&buf, img)
+	default:
+		return s.encodeWithEncoder(ctx, img, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *Service) encodeWithEncoder(ctx context.Context, img image.Image, format string) ([]byte, error) {
+	workDir, err := os.MkdirTemp("", "zentra-proxy-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(workDir)
+
+	src := filepath.Join(workDir, "source.png")
+	f, err := os.Create(src)
+	if err != nil {
+		return nil, err
+	}
+	err = png.Encode(f, img)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dst := filepath.Join(workDir, "variant."+format)
+	if err := s.imageEncoder.EncodeImage(ctx, src, dst, format); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(dst)
+}
+
+func (s *Service) readObject(ctx context.Context, objectName string) ([]byte, error) {
+	obj, err := s.minio.GetObject(ctx, s.bucketAttachments, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(io.LimitReader(obj, MaxImageSize+1))
+}
+
+// removeAttachmentObject deletes an attachment's file and the proxy's
+// variants of it. Every path that deletes an attachment's file goes through
+// here, so no resized copy outlives it.
+func (s *Service) removeAttachmentObject(ctx context.Context, objectName string) {
+	s.minio.RemoveObject(ctx, s.bucketAttachments, objectName, minio.RemoveObjectOptions{})
+	s.removeImageVariants(ctx, objectName)
+}
+
+// RemoveAttachmentFiles deletes files of attachments whose rows are gone,
+// e.g. archived with their messages partition, along with their variants
+func (s *Service) RemoveAttachmentFiles(ctx context.Context, fileURLs []string) {
+	for _, fileURL := range fileURLs {
+		s.removeAttachmentObject(ctx, s.trimURLToObjectName(fileURL, s.bucketAttachments))
+	}
+}
+
+// removeImageVariants deletes the proxy's stored variants of an image
+func (s *Service) removeImageVariants(ctx context.Context, objectName string) {
+	stem := strings.TrimSuffix(path.Base(objectName), path.Ext(objectName))
+	prefix := path.Join(path.Dir(objectName), "thumbs", stem+"_w")
+	for obj := range s.minio.ListObjects(ctx, s.bucketAttachments, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return
+		}
+		s.minio.RemoveObject(ctx, s.bucketAttachments, obj.Key, minio.RemoveObjectOptions{})
+	}
+}
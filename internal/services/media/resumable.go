@@ -0,0 +1,436 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/database"
+)
+
+const (
+	// Every part but the last must be at least 5MB for the storage multipart API
+	resumablePartSize = 8 * 1024 * 1024
+	// Uploads nobody appended to for this long are removed
+	resumableUploadExpiry = 24 * time.Hour
+	// How long one chunk may take to write before another request can take over
+	resumableLockTTL = 15 * time.Minute
+)
+
+var (
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	ErrUploadLocked   = errors.New("another chunk is being written to this upload")
+	ErrUploadComplete = errors.New("upload is already complete")
+	ErrChunkTooLarge  = errors.New("chunk goes past the upload length")
+)
+
+// ResumableUploadRequest starts a resumable upload of Length bytes. Exactly
+// one of ChannelID and ConversationID is set.
+type ResumableUploadRequest struct {
+	ChannelID      *uuid.UUID
+	ConversationID *uuid.UUID
+	Filename       string
+	ContentType    string
+	Length         int64
+	IsSpoiler      bool
+	Description    *string
+}
+
+// ResumableUpload is how far a resumable upload has got. Once Offset reaches
+// Length the file is an attachment with the upload's ID.
+type ResumableUpload struct {
+	ID        uuid.UUID `json:"id"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type uploadPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+}
+
+type resumableUpload struct {
+	uploadIntent
+	multipartID string
+	offset      int64
+	parts       []uploadPart
+	pendingSize int64
+	expiresAt   time.Time
+}
+
+// MaxResumableSize is the largest file a resumable upload of contentType can
+// be. Images keep their usual limit since they're decoded for thumbnails.
+func (s *Service) MaxResumableSize(contentType string) int64 {
+	if AllowedImageTypes[contentType] {
+		return MaxImageSize
+	}
+	return s.maxUploadSize
+}
+
+// CreateResumableUpload starts an object storage multipart upload that chunks
+// are appended to with AppendChunk
+func (s *Service) CreateResumableUpload(ctx context.Context, userID uuid.UUID, req *ResumableUploadRequest) (*ResumableUpload, error) {
+	if (req.ChannelID == nil) == (req.ConversationID == nil) {
+		return nil, ErrInvalidUploadTarget
+	}
+	if !s.isAllowedType(req.ContentType) {
+		return nil, ErrInvalidFileType
+	}
+	if req.Length <= 0 || req.Length > s.MaxResumableSize(req.ContentType) {
+		return nil, ErrFileTooLarge
+	}
+	if req.Filename == "" || len(req.Filename) > 255 {
+		req.Filename = "upload" + filepath.Ext(req.Filename)
+	}
+
+	uploadID := uuid.New()
+	objectName, err := s.attachmentObjectName(ctx, userID, req.ChannelID, req.ConversationID, uploadID, filepath.Ext(req.Filename))
+	if err != nil {
+		return nil, err
+	}
+
+	multipartID, err := s.core().NewMultipartUpload(ctx, s.bucketAttachments, objectName, minio.PutObjectOptions{ContentType: req.ContentType})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	expiresAt := time.Now().Add(resumableUploadExpiry)
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO resumable_uploads (id, uploader_id, channel_id, dm_conversation_id, object_name, multipart_id, filename, content_type, upload_length, is_spoiler, description, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		uploadID, userID, req.ChannelID, req.ConversationID, objectName, multipartID, req.Filename, req.ContentType, req.Length,
+		req.IsSpoiler, messaging.NormalizeAttachmentDescription(req.Description), expiresAt,
+	)
+	if err != nil {
+		_ = s.core().AbortMultipartUpload(ctx, s.bucketAttachments, objectName, multipartID)
+		return nil, fmt.Errorf("failed to save resumable upload: %w", err)
+	}
+
+	return &ResumableUpload{ID: uploadID, Length: req.Length, ExpiresAt: expiresAt}, nil
+}
+
+// GetResumableUpload returns how many bytes of an upload have been received
+func (s *Service) GetResumableUpload(ctx context.Context, uploadID, userID uuid.UUID) (*ResumableUpload, error) {
+	var u ResumableUpload
+	err := s.db.QueryRow(ctx,
+		`SELECT id, upload_length, upload_offset, expires_at FROM resumable_uploads
+		WHERE id = $1 AND uploader_id = $2 AND expires_at > NOW()`,
+		uploadID, userID,
+	).Scan(&u.ID, &u.Length, &u.Offset, &u.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+// AppendChunk writes body to the upload at offset, which must be where the
+// last chunk ended. Whatever arrives is kept even if the connection drops, so
+// the client can resume from the returned offset. The chunk that reaches the
+// upload's length completes it and creates the attachment.
+func (s *Service) AppendChunk(ctx context.Context, uploadID, userID uuid.UUID, offset int64, body io.Reader) (*ResumableUpload, error) {
+	u, err := s.lockResumableUpload(ctx, uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.unlockResumableUpload(uploadID)
+
+	if u.offset != offset {
+		return nil, ErrOffsetMismatch
+	}
+	if u.offset == u.size {
+		return nil, ErrUploadComplete
+	}
+
+	// Storage writes outlive the request so what was received is kept even if
+	// the client goes away mid-chunk; reading the body fails instead
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resumableLockTTL)
+	defer cancel()
+
+	// Bytes in finished parts; the rest of the offset is in the pending object
+	committed := u.offset - u.pendingSize
+	buf := bytes.NewBuffer(make([]byte, 0, resumablePartSize))
+	if u.pendingSize > 0 {
+		if err := s.readPending(saveCtx, u, buf); err != nil {
+			return nil, err
+		}
+	}
+
+	// One byte past the length tells a chunk that's too long from one that fits
+	remaining := u.size - u.offset
+	reader := io.LimitReader(body, remaining+1)
+	received := int64(0)
+	var readErr error
+	for {
+		n, err := io.CopyN(buf, reader, int64(resumablePartSize-buf.Len()))
+		received += n
+		if received > remaining {
+			return nil, ErrChunkTooLarge
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				readErr = err
+			}
+			break
+		}
+		if err := s.uploadPart(saveCtx, u, buf); err != nil {
+			return nil, err
+		}
+		committed += resumablePartSize
+	}
+
+	if u.offset+received == u.size {
+		if buf.Len() > 0 {
+			if err := s.uploadPart(saveCtx, u, buf); err != nil {
+				return nil, err
+			}
+		}
+		// The pending object is only dropped once the upload is assembled, so
+		// a failed completion can be retried from the old offset
+		if err := s.completeResumableUpload(saveCtx, u, userID); err != nil {
+			return nil, err
+		}
+		if u.pendingSize > 0 {
+			s.minio.RemoveObject(saveCtx, s.bucketAttachments, pendingObjectName(u.id), minio.RemoveObjectOptions{})
+		}
+		return &ResumableUpload{ID: u.id, Length: u.size, Offset: u.size, ExpiresAt: u.expiresAt}, nil
+	}
+
+	// Keep the tail for the next chunk; if that fails, resume from the last part
+	pendingSize := int64(buf.Len())
+	if err := s.savePending(saveCtx, u, buf); err != nil {
+		log.Warn().Err(err).Str("uploadId", uploadID.String()).Msg("Failed to save pending upload bytes")
+		pendingSize = 0
+	}
+	u.offset = committed + pendingSize
+	u.pendingSize = pendingSize
+
+	parts, _ := json.Marshal(u.parts)
+	err = s.db.QueryRow(saveCtx,
+		`UPDATE resumable_uploads
+		SET upload_offset = $2, parts = $3, pending_size = $4, expires_at = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING expires_at`,
+		u.id, u.offset, parts, u.pendingSize, time.Now().Add(resumableUploadExpiry),
+	).Scan(&u.expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save upload progress: %w", err)
+	}
+	if readErr != nil {
+		log.Debug().Err(readErr).Str("uploadId", uploadID.String()).Int64("offset", u.offset).Msg("Upload chunk interrupted")
+	}
+
+	return &ResumableUpload{ID: u.id, Length: u.size, Offset: u.offset, ExpiresAt: u.expiresAt}, nil
+}
+
+// CancelResumableUpload aborts an upload and deletes what was received
+func (s *Service) CancelResumableUpload(ctx context.Context, uploadID, userID uuid.UUID) error {
+	u, err := s.lockResumableUpload(ctx, uploadID, userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM resumable_uploads WHERE id = $1`, uploadID); err != nil {
+		return err
+	}
+	s.abortResumableUpload(ctx, u.id, u.objectName, u.multipartID)
+	return nil
+}
+
+// PruneResumableUploads aborts uploads that expired before completing. It runs
+// as a maintenance task.
+func (s *Service) PruneResumableUploads(ctx context.Context) (int64, error) {
+	rows, err := s.db.Query(ctx,
+		`DELETE FROM resumable_uploads
+		WHERE expires_at < NOW() AND (locked_until IS NULL OR locked_until < NOW())
+		RETURNING id, object_name, multipart_id`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var removed int64
+	for rows.Next() {
+		var (
+			id                      uuid.UUID
+			objectName, multipartID string
+		)
+		if err := rows.Scan(&id, &objectName, &multipartID); err != nil {
+			return removed, err
+		}
+		s.abortResumableUpload(ctx, id, objectName, multipartID)
+		removed++
+	}
+	return removed, rows.Err()
+}
+
+func (s *Service) core() *minio.Core {
+	return &minio.Core{Client: s.minio}
+}
+
+// lockResumableUpload claims an upload for one request at a time
+func (s *Service) lockResumableUpload(ctx context.Context, uploadID, userID uuid.UUID) (*resumableUpload, error) {
+	var (
+		u     resumableUpload
+		parts []byte
+	)
+	err := s.db.QueryRow(ctx,
+		`UPDATE resumable_uploads SET locked_until = $3
+		WHERE id = $1 AND uploader_id = $2 AND expires_at > NOW() AND (locked_until IS NULL OR locked_until < NOW())
+		RETURNING id, channel_id, dm_conversation_id, object_name, multipart_id, filename, content_type, upload_length,
+		          upload_offset, parts, pending_size, is_spoiler, description, expires_at`,
+		uploadID, userID, time.Now().Add(resumableLockTTL),
+	).Scan(&u.id, &u.channelID, &u.conversationID, &u.objectName, &u.multipartID, &u.filename, &u.contentType, &u.size,
+		&u.offset, &parts, &u.pendingSize, &u.isSpoiler, &u.description, &u.expiresAt)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := s.GetResumableUpload(ctx, uploadID, userID); err != nil {
+			return nil, err
+		}
+		return nil, ErrUploadLocked
+	}
+	if err := json.Unmarshal(parts, &u.parts); err != nil {
+		s.unlockResumableUpload(uploadID)
+		return nil, fmt.Errorf("invalid upload parts: %w", err)
+	}
+	return &u, nil
+}
+
+// unlockResumableUpload releases the claim even if the request was cancelled
+func (s *Service) unlockResumableUpload(uploadID uuid.UUID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.db.Exec(ctx, `UPDATE resumable_uploads SET locked_until = NULL WHERE id = $1`, uploadID); err != nil {
+		log.Warn().Err(err).Str("uploadId", uploadID.String()).Msg("Failed to unlock resumable upload")
+	}
+}
+
+func (s *Service) uploadPart(ctx context.Context, u *resumableUpload, buf *bytes.Buffer) error {
+	number := len(u.parts) + 1
+	part, err := s.core().PutObjectPart(ctx, s.bucketAttachments, u.objectName, u.multipartID, number,
+		bytes.NewReader(buf.Bytes()), int64(buf.Len()), minio.PutObjectPartOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to upload part %d: %w", number, err)
+	}
+	u.parts = append(u.parts, uploadPart{Number: number, ETag: part.ETag})
+	buf.Reset()
+	return nil
+}
+
+// pendingObjectName is where bytes waiting for the next part are kept
+func pendingObjectName(uploadID uuid.UUID) string {
+	return "pending/" + uploadID.String()
+}
+
+func (s *Service) readPending(ctx context.Context, u *resumableUpload, buf *bytes.Buffer) error {
+	obj, err := s.minio.GetObject(ctx, s.bucketAttachments, pendingObjectName(u.id), minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer obj.Close()
+
+	n, err := io.Copy(buf, obj)
+	if err != nil {
+		return fmt.Errorf("failed to read pending upload bytes: %w", err)
+	}
+	if n != u.pendingSize {
+		return fmt.Errorf("pending upload bytes are %d long, expected %d", n, u.pendingSize)
+	}
+	return nil
+}
+
+// savePending stores bytes that don't fill a part yet, or removes the pending
+// object when there are none
+func (s *Service) savePending(ctx context.Context, u *resumableUpload, buf *bytes.Buffer) error {
+	if buf.Len() == 0 {
+		if u.pendingSize > 0 {
+			return s.minio.RemoveObject(ctx, s.bucketAttachments, pendingObjectName(u.id), minio.RemoveObjectOptions{})
+		}
+		return nil
+	}
+	_, err := s.minio.PutObject(ctx, s.bucketAttachments, pendingObjectName(u.id), bytes.NewReader(buf.Bytes()), int64(buf.Len()),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+// completeResumableUpload assembles the parts and turns the file into an
+// attachment after the same checks as a confirmed presigned upload
+func (s *Service) completeResumableUpload(ctx context.Context, u *resumableUpload, userID uuid.UUID) error {
+	parts := make([]minio.CompletePart, 0, len(u.parts))
+	for _, p := range u.parts {
+		parts = append(parts, minio.CompletePart{PartNumber: p.Number, ETag: p.ETag})
+	}
+	_, err := s.core().CompleteMultipartUpload(ctx, s.bucketAttachments, u.objectName, u.multipartID, parts,
+		minio.PutObjectOptions{ContentType: u.contentType})
+	if err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	discard := func() {
+		_, _ = s.db.Exec(ctx, `DELETE FROM resumable_uploads WHERE id = $1`, u.id)
+		s.removeAttachmentObject(ctx, u.objectName)
+	}
+
+	info, err := s.minio.StatObject(ctx, s.bucketAttachments, u.objectName, minio.StatObjectOptions{})
+	if err != nil {
+		discard()
+		return err
+	}
+	checked, err := s.verifyUpload(ctx, &u.uploadIntent, u.objectName, info, s.MaxResumableSize(u.contentType))
+	if err != nil {
+		discard()
+		return err
+	}
+
+	// The attachment row doesn't exist yet, so nothing links to the object
+	// before its metadata is gone
+	size := info.Size
+	if checked.image != nil {
+		size = int64(len(checked.image))
+		_, err = s.minio.PutObject(ctx, s.bucketAttachments, u.objectName, bytes.NewReader(checked.image), size,
+			minio.PutObjectOptions{ContentType: u.contentType})
+		if err != nil {
+			discard()
+			return fmt.Errorf("failed to store stripped image: %w", err)
+		}
+	}
+
+	fileURL := s.getPublicURL(s.bucketAttachments, u.objectName)
+	status := s.processingStatusFor(u.contentType, size)
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM resumable_uploads WHERE id = $1`, u.id); err != nil {
+			return err
+		}
+		return insertUploadedAttachment(ctx, tx, &u.uploadIntent, userID, fileURL, size, checked, status)
+	})
+	if err != nil {
+		return err
+	}
+	if status != nil {
+		s.queueProcessing(u.id, u.contentType)
+	}
+	return nil
+}
+
+func (s *Service) abortResumableUpload(ctx context.Context, uploadID uuid.UUID, objectName, multipartID string) {
+	if err := s.core().AbortMultipartUpload(ctx, s.bucketAttachments, objectName, multipartID); err != nil {
+		log.Warn().Err(err).Str("object", objectName).Msg("Failed to abort multipart upload")
+	}
+	s.minio.RemoveObject(ctx, s.bucketAttachments, pendingObjectName(uploadID), minio.RemoveObjectOptions{})
+}
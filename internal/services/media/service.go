@@ -12,6 +12,7 @@ import (
 	"io"
 	"mime/multipart"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -22,6 +23,7 @@ import (
 	"github.com/nfnt/resize"
 	"github.com/zentra/server/internal/models"
 	"github.com/zentra/server/internal/services/community"
+	"github.com/zentra/server/internal/services/messaging"
 )
 
 var (
@@ -30,6 +32,10 @@ var (
 	ErrUploadFailed       = errors.New("upload failed")
 	ErrAttachmentNotFound = errors.New("attachment not found")
 	ErrNotParticipant     = errors.New("not a participant")
+	ErrUnreadableImage    = errors.New("the image could not be read")
+	// A link from an event to a DM or sensitive channel; it names no one, so
+	// access can't be checked
+	ErrViewerLinkRequired = errors.New("link must be fetched by a signed-in user")
 )
 
 // File size limits
@@ -38,6 +44,7 @@ const (
 	MaxVideoSize       = 100 * 1024 * 1024 // 100MB
 	MaxFileSize        = 50 * 1024 * 1024  // 50MB
 	MaxAvatarSize      = 5 * 1024 * 1024   // 5MB
+	MaxSoundClipSize   = 512 * 1024        // 512KB
 	ThumbnailMaxWidth  = 400
 	ThumbnailMaxHeight = 300
 )
@@ -78,9 +85,20 @@ type Service struct {
 	bucketCommunity   string
 	cdnBaseURL        string
 	communityService  *community.Service
+	maxUploadSize     int64
+	processQueue      chan uuid.UUID
+	transcodeQueue    chan uuid.UUID
+	transcoder        Transcoder
+	maxTranscodeSize  int64
+	channelAccess     ChannelAccess
+	urlSigner         *messaging.URLSigner
+	history           *messaging.HistoryCache
+	accessCache       *accessCache
+	imageEncoder      ImageEncoder
+	resizeSlots       chan struct{}
 }
 
-func NewService(db *pgxpool.Pool, minioClient *minio.Client, buckets [3]string, cdnBaseURL string, communityService *community.Service) *Service {
+func NewService(db *pgxpool.Pool, minioClient *minio.Client, buckets [3]string, cdnBaseURL string, maxUploadSize int64, communityService *community.Service) *Service {
 	return &Service{
 		db:                db,
 		minio:             minioClient,
@@ -89,6 +107,11 @@ func NewService(db *pgxpool.Pool, minioClient *minio.Client, buckets [3]string,
 		bucketCommunity:   buckets[2],
 		cdnBaseURL:        cdnBaseURL,
 		communityService:  communityService,
+		maxUploadSize:     maxUploadSize,
+		processQueue:      make(chan uuid.UUID, processingQueueSize),
+		transcodeQueue:    make(chan uuid.UUID, processingQueueSize),
+		accessCache:       newAccessCache(),
+		resizeSlots:       make(chan struct{}, runtime.NumCPU()),
 	}
 }
 
@@ -99,10 +122,20 @@ type UploadResult struct {
 	Size         int64     `json:"size"`
 	URL          string    `json:"url"`
 	ThumbnailURL *string   `json:"thumbnailUrl,omitempty"`
+	IsSpoiler    bool      `json:"isSpoiler"`
+	// Renditions and dimensions arrive in an ATTACHMENT_UPDATE event while processing
+	ProcessingStatus *models.AttachmentStatus `json:"processingStatus,omitempty"`
+	Description      *string                  `json:"description,omitempty"`
+}
+
+// AttachmentMetadata is the optional spoiler flag and alt text sent along with an upload.
+type AttachmentMetadata struct {
+	IsSpoiler   bool
+	Description *string
 }
 
 // UploadAttachment handles file uploads for message attachments
-func (s *Service) UploadAttachment(ctx context.Context, userID, channelID uuid.UUID, file multipart.File, header *multipart.FileHeader) (*UploadResult, error) {
+func (s *Service) UploadAttachment(ctx context.Context, userID, channelID uuid.UUID, file multipart.File, header *multipart.FileHeader, meta AttachmentMetadata) (*UploadResult, error) {
 	contentType := header.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
@@ -130,6 +163,10 @@ func (s *Service) UploadAttachment(ctx context.Context, userID, channelID uuid.U
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	fileData, err = stripUploadedImage(contentType, fileData)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate unique filename with organized path: community/channel/filename
 	ext := filepath.Ext(header.Filename)
@@ -147,55 +184,53 @@ func (s *Service) UploadAttachment(ctx context.Context, userID, channelID uuid.U
 
 	fileURL := s.getPublicURL(s.bucketAttachments, objectName)
 
-	// Generate thumbnail for images in separate thumbs folder
-	var thumbnailURL *string
-	if AllowedImageTypes[contentType] {
-		thumbURL, err := s.generateThumbnail(ctx, fileData, attachmentID, communityID, channelID, ext)
-		if err == nil {
-			thumbnailURL = &thumbURL
-		}
-	}
-
 	// Store in database
 	contentTypePtr := &contentType
 	attachment := &models.MessageAttachment{
-		ID:           attachmentID,
-		UploaderID:   userID,
-		Filename:     header.Filename,
-		ContentType:  contentTypePtr,
-		FileSize:     header.Size,
-		FileURL:      fileURL,
-		ThumbnailURL: thumbnailURL,
-		CreatedAt:    time.Now(),
+		ID:               attachmentID,
+		UploaderID:       userID,
+		Filename:         header.Filename,
+		ContentType:      contentTypePtr,
+		FileSize:         int64(len(fileData)),
+		FileURL:          fileURL,
+		ProcessingStatus: s.processingStatusFor(contentType, int64(len(fileData))),
+		IsSpoiler:        meta.IsSpoiler,
+		Description:      messaging.NormalizeAttachmentDescription(meta.Description),
+		CreatedAt:        time.Now(),
 	}
 
 	query := `
-		INSERT INTO message_attachments (id, uploader_id, filename, content_type, file_size, file_url, thumbnail_url, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+		INSERT INTO message_attachments (id, uploader_id, filename, content_type, file_size, file_url, processing_status, is_spoiler, description, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
 
 	_, err = s.db.Exec(ctx, query,
 		attachment.ID, attachment.UploaderID, attachment.Filename,
 		attachment.ContentType, attachment.FileSize, attachment.FileURL,
-		attachment.ThumbnailURL, attachment.CreatedAt,
+		attachment.ProcessingStatus, attachment.IsSpoiler, attachment.Description, attachment.CreatedAt,
 	)
 	if err != nil {
 		// Cleanup uploaded file
-		s.minio.RemoveObject(ctx, s.bucketAttachments, objectName, minio.RemoveObjectOptions{})
+		s.removeAttachmentObject(ctx, objectName)
 		return nil, fmt.Errorf("failed to save attachment record: %w", err)
 	}
+	if attachment.ProcessingStatus != nil {
+		s.queueProcessing(attachment.ID, contentType)
+	}
 
 	return &UploadResult{
-		ID:           attachment.ID,
-		Filename:     attachment.Filename,
-		ContentType:  *attachment.ContentType,
-		Size:         attachment.FileSize,
-		URL:          attachment.FileURL,
-		ThumbnailURL: attachment.ThumbnailURL,
+		ID:               attachment.ID,
+		Filename:         attachment.Filename,
+		ContentType:      *attachment.ContentType,
+		Size:             attachment.FileSize,
+		URL:              s.urlSigner.Sign(attachment.FileURL, userID),
+		IsSpoiler:        attachment.IsSpoiler,
+		Description:      attachment.Description,
+		ProcessingStatus: attachment.ProcessingStatus,
 	}, nil
 }
 
 // UploadDmAttachment handles file uploads for DM attachments
-func (s *Service) UploadDmAttachment(ctx context.Context, userID, conversationID uuid.UUID, file multipart.File, header *multipart.FileHeader) (*UploadResult, error) {
+func (s *Service) UploadDmAttachment(ctx context.Context, userID, conversationID uuid.UUID, file multipart.File, header *multipart.FileHeader, meta AttachmentMetadata) (*UploadResult, error) {
 	contentType := header.Header.Get("Content-Type")
 	if contentType == "" {
 		contentType = "application/octet-stream"
@@ -219,6 +254,10 @@ func (s *Service) UploadDmAttachment(ctx context.Context, userID, conversationID
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	fileData, err = stripUploadedImage(contentType, fileData)
+	if err != nil {
+		return nil, err
+	}
 
 	ext := filepath.Ext(header.Filename)
 	attachmentID := uuid.New()
@@ -234,47 +273,46 @@ func (s *Service) UploadDmAttachment(ctx context.Context, userID, conversationID
 
 	fileURL := s.getPublicURL(s.bucketAttachments, objectName)
 
-	var thumbnailURL *string
-	if AllowedImageTypes[contentType] {
-		thumbURL, err := s.generateDmThumbnail(ctx, fileData, attachmentID, conversationID)
-		if err == nil {
-			thumbnailURL = &thumbURL
-		}
-	}
-
 	contentTypePtr := &contentType
 	attachment := &models.MessageAttachment{
-		ID:           attachmentID,
-		UploaderID:   userID,
-		Filename:     header.Filename,
-		ContentType:  contentTypePtr,
-		FileSize:     header.Size,
-		FileURL:      fileURL,
-		ThumbnailURL: thumbnailURL,
-		CreatedAt:    time.Now(),
+		ID:               attachmentID,
+		UploaderID:       userID,
+		Filename:         header.Filename,
+		ContentType:      contentTypePtr,
+		FileSize:         int64(len(fileData)),
+		FileURL:          fileURL,
+		ProcessingStatus: s.processingStatusFor(contentType, int64(len(fileData))),
+		IsSpoiler:        meta.IsSpoiler,
+		Description:      messaging.NormalizeAttachmentDescription(meta.Description),
+		CreatedAt:        time.Now(),
 	}
 
 	query := `
-		INSERT INTO message_attachments (id, uploader_id, filename, content_type, file_size, file_url, thumbnail_url, created_at, dm_conversation_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+		INSERT INTO message_attachments (id, uploader_id, filename, content_type, file_size, file_url, processing_status, is_spoiler, description, created_at, dm_conversation_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 
 	_, err = s.db.Exec(ctx, query,
 		attachment.ID, attachment.UploaderID, attachment.Filename,
 		attachment.ContentType, attachment.FileSize, attachment.FileURL,
-		attachment.ThumbnailURL, attachment.CreatedAt, conversationID,
+		attachment.ProcessingStatus, attachment.IsSpoiler, attachment.Description, attachment.CreatedAt, conversationID,
 	)
 	if err != nil {
-		s.minio.RemoveObject(ctx, s.bucketAttachments, objectName, minio.RemoveObjectOptions{})
+		s.removeAttachmentObject(ctx, objectName)
 		return nil, fmt.Errorf("failed to save attachment record: %w", err)
 	}
+	if attachment.ProcessingStatus != nil {
+		s.queueProcessing(attachment.ID, contentType)
+	}
 
 	return &UploadResult{
-		ID:           attachment.ID,
-		Filename:     attachment.Filename,
-		ContentType:  *attachment.ContentType,
-		Size:         attachment.FileSize,
-		URL:          attachment.FileURL,
-		ThumbnailURL: attachment.ThumbnailURL,
+		ID:               attachment.ID,
+		Filename:         attachment.Filename,
+		ContentType:      *attachment.ContentType,
+		Size:             attachment.FileSize,
+		URL:              s.urlSigner.Sign(attachment.FileURL, userID),
+		IsSpoiler:        attachment.IsSpoiler,
+		Description:      attachment.Description,
+		ProcessingStatus: attachment.ProcessingStatus,
 	}, nil
 }
 
@@ -385,6 +423,46 @@ func (s *Service) UploadCommunityAsset(ctx context.Context, communityID uuid.UUI
 	return url, nil
 }
 
+// UploadSoundClip stores a community soundboard clip under its ID and returns
+// its public URL
+func (s *Service) UploadSoundClip(ctx context.Context, communityID, clipID uuid.UUID, file multipart.File, header *multipart.FileHeader) (string, error) {
+	contentType := header.Header.Get("Content-Type")
+	if !AllowedAudioTypes[contentType] {
+		return "", ErrInvalidFileType
+	}
+	if header.Size > MaxSoundClipSize {
+		return "", ErrFileTooLarge
+	}
+
+	fileData, err := io.ReadAll(io.LimitReader(file, MaxSoundClipSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(fileData) > MaxSoundClipSize {
+		return "", ErrFileTooLarge
+	}
+
+	objectName := fmt.Sprintf("sounds/%s/%s%s", communityID.String(), clipID.String(), filepath.Ext(header.Filename))
+	_, err = s.minio.PutObject(ctx, s.bucketCommunity, objectName, bytes.NewReader(fileData), int64(len(fileData)),
+		minio.PutObjectOptions{
+			ContentType: contentType,
+		})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload sound clip: %w", err)
+	}
+
+	return s.getPublicURL(s.bucketCommunity, objectName), nil
+}
+
+// DeleteSoundClip removes a clip stored by UploadSoundClip
+func (s *Service) DeleteSoundClip(ctx context.Context, clipURL string) error {
+	objectName := s.trimURLToObjectName(clipURL, s.bucketCommunity)
+	if !strings.HasPrefix(objectName, "sounds/") {
+		return nil
+	}
+	return s.minio.RemoveObject(ctx, s.bucketCommunity, objectName, minio.RemoveObjectOptions{})
+}
+
 // getPublicURL constructs a public URL for an object
 func (s *Service) getPublicURL(bucket, objectName string) string {
 	baseURL := strings.TrimSuffix(s.cdnBaseURL, "/")
@@ -427,13 +505,13 @@ func (s *Service) trimURLToObjectName(fileURL, bucket string) string {
 func (s *Service) GetAttachment(ctx context.Context, attachmentID uuid.UUID) (*models.MessageAttachment, error) {
 	var a models.MessageAttachment
 	query := `
-		SELECT id, message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, width, height, created_at
+		SELECT id, message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, medium_url, video_url, poster_url, width, height, duration_seconds, blurhash, processing_status, is_spoiler, description, created_at
 		FROM message_attachments
 		WHERE id = $1`
 
 	err := s.db.QueryRow(ctx, query, attachmentID).Scan(
 		&a.ID, &a.MessageID, &a.MessageCreatedAt, &a.UploaderID, &a.Filename, &a.FileURL, &a.FileSize,
-		&a.ContentType, &a.ThumbnailURL, &a.Width, &a.Height, &a.CreatedAt,
+		&a.ContentType, &a.ThumbnailURL, &a.MediumURL, &a.VideoURL, &a.PosterURL, &a.Width, &a.Height, &a.DurationSeconds, &a.Blurhash, &a.ProcessingStatus, &a.IsSpoiler, &a.Description, &a.CreatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -458,30 +536,43 @@ func (s *Service) DeleteAttachment(ctx context.Context, attachmentID, userID uui
 	}
 
 	// Delete from database
-	_, err = s.db.Exec(ctx, `DELETE FROM message_attachments WHERE id = $1`, attachmentID)
-	if err != nil {
+	var channelID *uuid.UUID
+	err = s.db.QueryRow(ctx,
+		`DELETE FROM message_attachments a WHERE a.id = $1
+		RETURNING (SELECT m.channel_id FROM messages m WHERE m.id = a.message_id AND m.created_at = a.message_created_at)`,
+		attachmentID,
+	).Scan(&channelID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
 		return err
 	}
+	if channelID != nil {
+		s.history.Invalidate(ctx, *channelID)
+	}
 
 	// Delete from MinIO
 	objectName := s.trimURLToObjectName(attachment.FileURL, s.bucketAttachments)
-	s.minio.RemoveObject(ctx, s.bucketAttachments, objectName, minio.RemoveObjectOptions{})
+	s.removeAttachmentObject(ctx, objectName)
 
-	// Delete thumbnail if exists
-	if attachment.ThumbnailURL != nil {
-		thumbObjectName := s.trimURLToObjectName(*attachment.ThumbnailURL, s.bucketAttachments)
-		s.minio.RemoveObject(ctx, s.bucketAttachments, thumbObjectName, minio.RemoveObjectOptions{})
+	// Delete renditions if they exist
+	for _, renditionURL := range []*string{attachment.ThumbnailURL, attachment.MediumURL, attachment.VideoURL, attachment.PosterURL} {
+		if renditionURL != nil {
+			renditionObjectName := s.trimURLToObjectName(*renditionURL, s.bucketAttachments)
+			s.removeAttachmentObject(ctx, renditionObjectName)
+		}
 	}
 
 	return nil
 }
 
 // GetPresignedURL generates a presigned URL for direct download
-func (s *Service) GetPresignedURL(ctx context.Context, attachmentID uuid.UUID, expiry time.Duration) (string, error) {
+func (s *Service) GetPresignedURL(ctx context.Context, attachmentID, userID uuid.UUID, expiry time.Duration) (string, error) {
 	attachment, err := s.GetAttachment(ctx, attachmentID)
 	if err != nil {
 		return "", err
 	}
+	if !s.canViewAttachment(ctx, attachment, userID) {
+		return "", ErrAttachmentNotFound
+	}
 
 	objectName := s.trimURLToObjectName(attachment.FileURL, s.bucketAttachments)
 
@@ -520,64 +611,6 @@ func (s *Service) canAccessDmConversation(ctx context.Context, conversationID, u
 	return err == nil && exists
 }
 
-// generateThumbnail creates a thumbnail for image attachments
-// Currently, only JPEG thumbnails are generated.
-// I need to modify this later to support PNG's with transparency.
-// For now, this will do.
-func (s *Service) generateThumbnail(ctx context.Context, imageData []byte, attachmentID, communityID, channelID uuid.UUID, ext string) (string, error) {
-	img, _, err := image.Decode(bytes.NewReader(imageData))
-	if err != nil {
-		return "", err
-	}
-
-	// Resize maintaining aspect ratio
-	thumb := resize.Thumbnail(ThumbnailMaxWidth, ThumbnailMaxHeight, img, resize.Lanczos3)
-
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
-		return "", err
-	}
-
-	// Store thumbnails in: community/channel/thumbs/filename
-	thumbObjectName := fmt.Sprintf("%s/%s/thumbs/%s_thumb.jpg", communityID.String(), channelID.String(), attachmentID.String())
-
-	_, err = s.minio.PutObject(ctx, s.bucketAttachments, thumbObjectName, &buf, int64(buf.Len()),
-		minio.PutObjectOptions{
-			ContentType: "image/jpeg",
-		})
-	if err != nil {
-		return "", err
-	}
-
-	return s.getPublicURL(s.bucketAttachments, thumbObjectName), nil
-}
-
-func (s *Service) generateDmThumbnail(ctx context.Context, imageData []byte, attachmentID, conversationID uuid.UUID) (string, error) {
-	img, _, err := image.Decode(bytes.NewReader(imageData))
-	if err != nil {
-		return "", err
-	}
-
-	thumb := resize.Thumbnail(ThumbnailMaxWidth, ThumbnailMaxHeight, img, resize.Lanczos3)
-
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80}); err != nil {
-		return "", err
-	}
-
-	thumbObjectName := fmt.Sprintf("dm/%s/thumbs/%s_thumb.jpg", conversationID.String(), attachmentID.String())
-
-	_, err = s.minio.PutObject(ctx, s.bucketAttachments, thumbObjectName, &buf, int64(buf.Len()),
-		minio.PutObjectOptions{
-			ContentType: "image/jpeg",
-		})
-	if err != nil {
-		return "", err
-	}
-
-	return s.getPublicURL(s.bucketAttachments, thumbObjectName), nil
-}
-
 func (s *Service) processAvatar(imageData []byte) ([]byte, error) {
 	img, _, err := image.Decode(bytes.NewReader(imageData))
 	if err != nil {
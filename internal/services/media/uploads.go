@@ -0,0 +1,443 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/minio/minio-go/v7"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/database"
+)
+
+const (
+	// How long a presigned upload URL, and the intent behind it, stays valid
+	uploadIntentExpiry = 15 * time.Minute
+	// Presigned uploads land under this prefix and are copied to the
+	// attachment's own key on confirm, which no client can write to
+	uploadStagingPrefix = "uploads/"
+	// Larger images are refused rather than decoded for a thumbnail
+	MaxImageDimension = 16384
+)
+
+var (
+	ErrUploadNotFound      = errors.New("upload not found")
+	ErrInvalidUploadTarget = errors.New("exactly one of channelId and conversationId is required")
+	ErrUploadMissing       = errors.New("the file has not been uploaded")
+	ErrUploadMismatch      = errors.New("the uploaded file does not match the upload intent")
+	ErrImageTooLarge       = errors.New("image dimensions are too large")
+)
+
+// UploadIntentRequest describes a file the client is about to upload to storage.
+// Exactly one of ChannelID and ConversationID is set.
+type UploadIntentRequest struct {
+	ChannelID      *uuid.UUID `json:"channelId"`
+	ConversationID *uuid.UUID `json:"conversationId"`
+	Filename       string     `json:"filename" validate:"required,max=255"`
+	ContentType    string     `json:"contentType" validate:"required"`
+	Size           int64      `json:"size" validate:"required,gt=0"`
+	IsSpoiler      bool       `json:"isSpoiler"`
+	Description    *string    `json:"description"`
+}
+
+// UploadIntent is where to POST the file: a multipart form with the given
+// fields followed by the file. The storage policy only accepts the declared
+// content type and size. The upload is confirmed with the upload ID afterwards.
+type UploadIntent struct {
+	UploadID  uuid.UUID         `json:"uploadId"`
+	UploadURL string            `json:"uploadUrl"`
+	Fields    map[string]string `json:"fields"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+type uploadIntent struct {
+	id             uuid.UUID
+	channelID      *uuid.UUID
+	conversationID *uuid.UUID
+	objectName     string
+	filename       string
+	contentType    string
+	size           int64
+	isSpoiler      bool
+	description    *string
+}
+
+// CreateUploadIntent checks a planned upload against the same limits as a
+// multipart upload and returns a presigned POST policy to upload it with, so
+// large files don't stream through the gateway
+func (s *Service) CreateUploadIntent(ctx context.Context, userID uuid.UUID, req *UploadIntentRequest) (*UploadIntent, error) {
+	if (req.ChannelID == nil) == (req.ConversationID == nil) {
+		return nil, ErrInvalidUploadTarget
+	}
+	if !s.isAllowedType(req.ContentType) {
+		return nil, ErrInvalidFileType
+	}
+	if req.Size > s.getMaxSizeForType(req.ContentType) {
+		return nil, ErrFileTooLarge
+	}
+
+	uploadID := uuid.New()
+	objectName, err := s.attachmentObjectName(ctx, userID, req.ChannelID, req.ConversationID, uploadID, filepath.Ext(req.Filename))
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(uploadIntentExpiry)
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO upload_intents (id, uploader_id, channel_id, dm_conversation_id, object_name, filename, content_type, file_size, is_spoiler, description, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		uploadID, userID, req.ChannelID, req.ConversationID, objectName, req.Filename, req.ContentType, req.Size,
+		req.IsSpoiler, messaging.NormalizeAttachmentDescription(req.Description), expiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save upload intent: %w", err)
+	}
+
+	policy := minio.NewPostPolicy()
+	if err := errors.Join(
+		policy.SetBucket(s.bucketAttachments),
+		policy.SetKey(stagingObjectName(uploadID)),
+		policy.SetExpires(expiresAt),
+		policy.SetContentType(req.ContentType),
+		policy.SetContentLengthRange(req.Size, req.Size),
+	); err != nil {
+		return nil, fmt.Errorf("build upload policy: %w", err)
+	}
+	uploadURL, fields, err := s.minio.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return nil, fmt.Errorf("presign attachment upload: %w", err)
+	}
+
+	return &UploadIntent{
+		UploadID:  uploadID,
+		UploadURL: uploadURL.String(),
+		Fields:    fields,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ConfirmUpload checks an uploaded object's size, content type and, for images,
+// dimensions against its intent, and turns it into an attachment that can be
+// sent with a message. A file that doesn't match is deleted. The checked
+// object is copied out of the staging key, so uploading again with the same
+// policy can't replace it; images are stored stripped of their metadata
+// instead of copied.
+func (s *Service) ConfirmUpload(ctx context.Context, uploadID, userID uuid.UUID) (*UploadResult, error) {
+	intent, err := s.getUploadIntent(ctx, uploadID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	staging := stagingObjectName(intent.id)
+	info, err := s.minio.StatObject(ctx, s.bucketAttachments, staging, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrUploadMissing
+		}
+		return nil, err
+	}
+
+	checked, err := s.verifyUpload(ctx, intent, staging, info, s.getMaxSizeForType(intent.contentType))
+	if err != nil {
+		if errors.Is(err, ErrUploadMismatch) || errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrImageTooLarge) {
+			s.discardUpload(ctx, intent)
+		}
+		return nil, err
+	}
+
+	size := info.Size
+	if checked.image != nil {
+		size = int64(len(checked.image))
+		_, err = s.minio.PutObject(ctx, s.bucketAttachments, intent.objectName, bytes.NewReader(checked.image), size,
+			minio.PutObjectOptions{ContentType: intent.contentType})
+	} else {
+		// The ETag pins the copy to the object that was checked
+		_, err = s.minio.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: s.bucketAttachments, Object: intent.objectName},
+			minio.CopySrcOptions{Bucket: s.bucketAttachments, Object: staging, MatchETag: info.ETag},
+		)
+	}
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return nil, ErrUploadMismatch
+		}
+		return nil, fmt.Errorf("failed to store upload: %w", err)
+	}
+	s.minio.RemoveObject(ctx, s.bucketAttachments, staging, minio.RemoveObjectOptions{})
+
+	fileURL := s.getPublicURL(s.bucketAttachments, intent.objectName)
+	status := s.processingStatusFor(intent.contentType, size)
+	err = database.WithTransaction(ctx, func(ctx context.Context, tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `DELETE FROM upload_intents WHERE id = $1 AND uploader_id = $2`, uploadID, userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrUploadNotFound
+		}
+
+		return insertUploadedAttachment(ctx, tx, intent, userID, fileURL, size, checked, status)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if status != nil {
+		s.queueProcessing(intent.id, intent.contentType)
+	}
+
+	return &UploadResult{
+		ID:               intent.id,
+		Filename:         intent.filename,
+		ContentType:      intent.contentType,
+		Size:             size,
+		URL:              s.urlSigner.Sign(fileURL, userID),
+		IsSpoiler:        intent.isSpoiler,
+		Description:      intent.description,
+		ProcessingStatus: status,
+	}, nil
+}
+
+// attachmentObjectName checks the uploader can post attachments to the channel
+// or conversation and returns where the file is stored, the same place a
+// multipart upload would put it
+func (s *Service) attachmentObjectName(ctx context.Context, userID uuid.UUID, channelID, conversationID *uuid.UUID, attachmentID uuid.UUID, ext string) (string, error) {
+	if conversationID != nil {
+		if !s.canAccessDmConversation(ctx, *conversationID, userID) {
+			return "", ErrNotParticipant
+		}
+		return fmt.Sprintf("dm/%s/%s%s", conversationID.String(), attachmentID.String(), ext), nil
+	}
+
+	var communityID uuid.UUID
+	err := s.db.QueryRow(ctx, "SELECT community_id FROM channels WHERE id = $1", *channelID).Scan(&communityID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotParticipant
+		}
+		return "", fmt.Errorf("failed to get community for channel: %w", err)
+	}
+	if !s.communityService.IsMember(ctx, communityID, userID) {
+		return "", ErrNotParticipant
+	}
+	return fmt.Sprintf("%s/%s/%s%s", communityID.String(), channelID.String(), attachmentID.String(), ext), nil
+}
+
+func insertUploadedAttachment(ctx context.Context, tx pgx.Tx, intent *uploadIntent, userID uuid.UUID, fileURL string, size int64, checked *checkedUpload, status *models.AttachmentStatus) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO message_attachments (id, uploader_id, filename, content_type, file_size, file_url, width, height, processing_status, is_spoiler, description, created_at, dm_conversation_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW(), $12)`,
+		intent.id, userID, intent.filename, intent.contentType, size, fileURL,
+		checked.width, checked.height, status, intent.isSpoiler, intent.description, intent.conversationID,
+	)
+	return err
+}
+
+// checkedUpload is what verifyUpload found in an upload. For an image it has
+// the dimensions and the file stripped of its metadata, which is what gets
+// stored.
+type checkedUpload struct {
+	width, height *int
+	image         []byte
+}
+
+// verifyUpload checks an upload stored at objectName, returning
+// ErrUploadMismatch when the object isn't what the intent declared
+func (s *Service) verifyUpload(ctx context.Context, intent *uploadIntent, objectName string, info minio.ObjectInfo, maxSize int64) (*checkedUpload, error) {
+	if info.Size != intent.size {
+		return nil, ErrUploadMismatch
+	}
+	if info.Size > maxSize {
+		return nil, ErrFileTooLarge
+	}
+	// The stored type is what the CDN serves the file as
+	if !strings.EqualFold(info.ContentType, intent.contentType) {
+		return nil, ErrUploadMismatch
+	}
+
+	// Reads fail if the object was replaced after the stat
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetMatchETag(info.ETag); err != nil {
+		return nil, err
+	}
+	obj, err := s.minio.GetObject(ctx, s.bucketAttachments, objectName, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	if !AllowedImageTypes[intent.contentType] {
+		head := make([]byte, 512)
+		n, err := io.ReadFull(obj, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return nil, replacedUpload(err)
+		}
+		if !sniffedTypeMatches(intent.contentType, http.DetectContentType(head[:n])) {
+			return nil, ErrUploadMismatch
+		}
+		return &checkedUpload{}, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(obj, MaxImageSize+1))
+	if err != nil {
+		return nil, replacedUpload(err)
+	}
+	if !sniffedTypeMatches(intent.contentType, http.DetectContentType(data)) {
+		return nil, ErrUploadMismatch
+	}
+	w, h, err := imageDimensions(data)
+	if err != nil {
+		return nil, ErrUploadMismatch
+	}
+	if w > MaxImageDimension || h > MaxImageDimension {
+		return nil, ErrImageTooLarge
+	}
+	stripped, err := stripUploadedImage(intent.contentType, data)
+	if err != nil {
+		return nil, ErrUploadMismatch
+	}
+
+	return &checkedUpload{width: &w, height: &h, image: stripped}, nil
+}
+
+// replacedUpload turns the error of a read pinned to an ETag into
+// ErrUploadMismatch when the object changed in between
+func replacedUpload(err error) error {
+	if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+		return ErrUploadMismatch
+	}
+	return err
+}
+
+// sniffedTypeMatches reports whether a file's content is plausibly the type it
+// was declared as. Images must sniff as exactly that type, and audio and video
+// must not sniff as text; other files only must not look like an image or a
+// web page.
+func sniffedTypeMatches(declared, sniffed string) bool {
+	sniffed = strings.TrimSpace(strings.SplitN(sniffed, ";", 2)[0])
+	if AllowedImageTypes[declared] {
+		return sniffed == declared
+	}
+	if (AllowedVideoTypes[declared] || AllowedAudioTypes[declared]) && strings.HasPrefix(sniffed, "text/") {
+		return false
+	}
+	if strings.HasPrefix(sniffed, "image/") || sniffed == "text/html" || sniffed == "text/xml" {
+		return false
+	}
+	return true
+}
+
+// imageDimensions reads an image's size from its header. WebP has no decoder
+// registered, so its header is parsed here.
+func imageDimensions(data []byte) (int, int, error) {
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return cfg.Width, cfg.Height, nil
+	}
+	if len(data) < 30 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errors.New("unrecognized image")
+	}
+
+	chunk := data[12:]
+	switch string(chunk[0:4]) {
+	case "VP8X":
+		// 24-bit canvas width and height minus one
+		w := int(chunk[12]) | int(chunk[13])<<8 | int(chunk[14])<<16
+		h := int(chunk[15]) | int(chunk[16])<<8 | int(chunk[17])<<16
+		return w + 1, h + 1, nil
+	case "VP8 ":
+		// Frame tag, then the 0x9d012a start code and 14-bit sizes
+		if chunk[11] != 0x9d || chunk[12] != 0x01 || chunk[13] != 0x2a {
+			return 0, 0, errors.New("invalid VP8 header")
+		}
+		w := int(binary.LittleEndian.Uint16(chunk[14:16]) & 0x3fff)
+		h := int(binary.LittleEndian.Uint16(chunk[16:18]) & 0x3fff)
+		return w, h, nil
+	case "VP8L":
+		if chunk[8] != 0x2f {
+			return 0, 0, errors.New("invalid VP8L header")
+		}
+		bits := binary.LittleEndian.Uint32(chunk[9:13])
+		return int(bits&0x3fff) + 1, int((bits>>14)&0x3fff) + 1, nil
+	}
+	return 0, 0, errors.New("unrecognized WebP chunk")
+}
+
+func (s *Service) getUploadIntent(ctx context.Context, uploadID, userID uuid.UUID) (*uploadIntent, error) {
+	var intent uploadIntent
+	err := s.db.QueryRow(ctx,
+		`SELECT id, channel_id, dm_conversation_id, object_name, filename, content_type, file_size, is_spoiler, description
+		FROM upload_intents
+		WHERE id = $1 AND uploader_id = $2 AND expires_at > NOW()`,
+		uploadID, userID,
+	).Scan(&intent.id, &intent.channelID, &intent.conversationID, &intent.objectName, &intent.filename,
+		&intent.contentType, &intent.size, &intent.isSpoiler, &intent.description)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, err
+	}
+	return &intent, nil
+}
+
+func (s *Service) discardUpload(ctx context.Context, intent *uploadIntent) {
+	if _, err := s.db.Exec(ctx, `DELETE FROM upload_intents WHERE id = $1`, intent.id); err != nil {
+		log.Warn().Err(err).Str("uploadId", intent.id.String()).Msg("Failed to delete upload intent")
+	}
+	s.minio.RemoveObject(ctx, s.bucketAttachments, stagingObjectName(intent.id), minio.RemoveObjectOptions{})
+}
+
+// stagingObjectName is the only key a presigned upload may write
+func stagingObjectName(uploadID uuid.UUID) string {
+	return uploadStagingPrefix + uploadID.String()
+}
+
+// PruneUploadIntents removes intents that expired unconfirmed, along with
+// anything uploaded for them, and staged files left after their intent was
+// confirmed or discarded. It runs as a maintenance task.
+func (s *Service) PruneUploadIntents(ctx context.Context) (int64, error) {
+	rows, err := s.db.Query(ctx,
+		`DELETE FROM upload_intents WHERE expires_at < NOW() RETURNING id`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var removed int64
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return removed, err
+		}
+		s.minio.RemoveObject(ctx, s.bucketAttachments, stagingObjectName(id), minio.RemoveObjectOptions{})
+		removed++
+	}
+	if err := rows.Err(); err != nil {
+		return removed, err
+	}
+
+	// A policy stays usable until it expires, so a file can be staged again
+	// after its intent is gone
+	cutoff := time.Now().Add(-uploadIntentExpiry)
+	for obj := range s.minio.ListObjects(ctx, s.bucketAttachments, minio.ListObjectsOptions{Prefix: uploadStagingPrefix}) {
+		if obj.Err != nil {
+			return removed, obj.Err
+		}
+		if obj.LastModified.Before(cutoff) {
+			s.minio.RemoveObject(ctx, s.bucketAttachments, obj.Key, minio.RemoveObjectOptions{})
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,268 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Renditions and posters fit in this many pixels on their longer side
+	VideoMaxSize = 1280
+	// A transcode may run this long before it's given up on
+	transcodeTimeout = 15 * time.Minute
+	// Longer videos, or ones with more pixels per frame, are served as
+	// uploaded rather than transcoded
+	maxTranscodeDuration = 30 * time.Minute
+	maxTranscodePixels   = 3840 * 2160
+)
+
+// videoDemuxers is the ffmpeg demuxer each transcoded type is read with. The
+// format is forced so a file can't pick another demuxer (hls, concat and the
+// like open further files or URLs) by its content.
+var videoDemuxers = map[string]string{
+	"video/mp4":       "mov",
+	"video/quicktime": "mov",
+	"video/webm":      "matroska",
+}
+
+// errVideoTooLarge marks a video over the duration or pixel cap
+var errVideoTooLarge = errors.New("video too long or too large to transcode")
+
+// Transcoder makes a rendition of an uploaded video that every browser can
+// play. Paths are local files.
+type Transcoder interface {
+	// Transcode writes an H.264/AAC MP4 of src, read as the given content
+	// type, at most VideoMaxSize pixels on its longer side, to dst
+	Transcode(ctx context.Context, src, contentType, dst string) (*VideoInfo, error)
+	// Poster writes a JPEG of the frame at the given offset into src to dst
+	Poster(ctx context.Context, src, dst string, at time.Duration) error
+}
+
+// VideoInfo describes a transcoded video
+type VideoInfo struct {
+	Width    int
+	Height   int
+	Duration time.Duration
+}
+
+// SetTranscoder enables video processing. Videos larger than maxSize bytes are
+// served as uploaded.
+func (s *Service) SetTranscoder(t Transcoder, maxSize int64) {
+	s.transcoder = t
+	s.maxTranscodeSize = maxSize
+}
+
+// processVideo transcodes the stored video and stores the rendition and a
+// poster frame next to it, along with a thumbnail and blurhash of the poster
+func (s *Service) processVideo(ctx context.Context, attachmentID uuid.UUID, objectName, contentType string) error {
+	workDir, err := os.MkdirTemp("", "zentra-transcode-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	src := filepath.Join(workDir, "source")
+	if err := s.minio.FGetObject(ctx, s.bucketAttachments, objectName, src, minio.GetObjectOptions{}); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return fmt.Errorf("%w: %v", errUnprocessable, err)
+		}
+		return err
+	}
+
+	// A video ffmpeg can't read won't become readable on a retry
+	rendition := filepath.Join(workDir, "web.mp4")
+	info, err := s.transcoder.Transcode(ctx, src, contentType, rendition)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnprocessable, err)
+	}
+	poster := filepath.Join(workDir, "poster.jpg")
+	if err := s.transcoder.Poster(ctx, rendition, poster, min(time.Second, info.Duration/2)); err != nil {
+		return fmt.Errorf("%w: %v", errUnprocessable, err)
+	}
+
+	dir := path.Join(path.Dir(objectName), "thumbs")
+	videoName := path.Join(dir, attachmentID.String()+"_web.mp4")
+	_, err = s.minio.FPutObject(ctx, s.bucketAttachments, videoName, rendition, minio.PutObjectOptions{ContentType: "video/mp4"})
+	if err != nil {
+		return fmt.Errorf("failed to store video rendition: %w", err)
+	}
+
+	posterData, err := os.ReadFile(poster)
+	if err != nil {
+		return err
+	}
+	posterName := path.Join(dir, attachmentID.String()+"_poster.jpg")
+	_, err = s.minio.PutObject(ctx, s.bucketAttachments, posterName, bytes.NewReader(posterData), int64(len(posterData)),
+		minio.PutObjectOptions{ContentType: "image/jpeg"})
+	if err != nil {
+		return fmt.Errorf("failed to store poster: %w", err)
+	}
+	renditions, err := s.storeRenditions(ctx, posterData, objectName, attachmentID, "image/jpeg", 1, info.Width, info.Height)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE message_attachments
+		SET video_url = $2, poster_url = $3, thumbnail_url = $4, blurhash = $5, width = $6, height = $7, duration_seconds = $8,
+		    processing_status = 'ready', processing_started_at = NULL
+		WHERE id = $1`,
+		attachmentID, s.getPublicURL(s.bucketAttachments, videoName), s.getPublicURL(s.bucketAttachments, posterName),
+		renditions.thumbnailURL, renditions.blurhash, info.Width, info.Height, info.Duration.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+
+	s.publishAttachmentUpdate(ctx, attachmentID)
+	return nil
+}
+
+// FFmpegTranscoder transcodes with the ffmpeg and ffprobe binaries
+type FFmpegTranscoder struct {
+	ffmpegPath  string
+	ffprobePath string
+}
+
+func NewFFmpegTranscoder(ffmpegPath, ffprobePath string) *FFmpegTranscoder {
+	return &FFmpegTranscoder{ffmpegPath: ffmpegPath, ffprobePath: ffprobePath}
+}
+
+// fitFilter scales down to VideoMaxSize on the longer side, keeping even
+// dimensions as H.264 requires
+var fitFilter = fmt.Sprintf(
+	"scale=w='min(%[1]d,iw)':h='min(%[1]d,ih)':force_original_aspect_ratio=decrease:force_divisible_by=2",
+	VideoMaxSize,
+)
+
+// inputArgs read src as a local file with only the given demuxer, refusing
+// frames larger than the pixel cap
+func inputArgs(demuxer, src string) []string {
+	return []string{
+		"-protocol_whitelist", "file",
+		"-f", demuxer, "-format_whitelist", demuxer,
+		"-max_pixels", strconv.Itoa(maxTranscodePixels),
+		"-i", src,
+	}
+}
+
+func (t *FFmpegTranscoder) Transcode(ctx context.Context, src, contentType, dst string) (*VideoInfo, error) {
+	demuxer, ok := videoDemuxers[contentType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported video type %q", contentType)
+	}
+	source, err := t.probe(ctx, demuxer, src)
+	if err != nil {
+		return nil, err
+	}
+	if source.Duration > maxTranscodeDuration || source.Width*source.Height > maxTranscodePixels {
+		return nil, errVideoTooLarge
+	}
+
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y"}, inputArgs(demuxer, src)...)
+	err = t.run(ctx, t.ffmpegPath, append(args,
+		// The container's duration may not be the truth
+		"-t", strconv.Itoa(int(maxTranscodeDuration.Seconds())),
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-vf", fitFilter,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-profile:v", "high", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k", "-ac", "2",
+		// The index goes first so playback can start before the download ends
+		"-movflags", "+faststart",
+		"-map_metadata", "-1",
+		dst,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	return t.probe(ctx, "mov", dst)
+}
+
+func (t *FFmpegTranscoder) Poster(ctx context.Context, src, dst string, at time.Duration) error {
+	args := []string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+		"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64)}
+	args = append(args, inputArgs("mov", src)...)
+	return t.run(ctx, t.ffmpegPath, append(args, "-frames:v", "1", "-q:v", "3", dst)...)
+}
+
+// EncodeImage encodes a still image with libwebp or libaom-av1
+func (t *FFmpegTranscoder) EncodeImage(ctx context.Context, src, dst, format string) error {
+	var codec []string
+	switch format {
+	case ProxyFormatWebP:
+		codec = []string{"-c:v", "libwebp", "-quality", "80"}
+	case ProxyFormatAVIF:
+		codec = []string{"-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32", "-cpu-used", "6"}
+	default:
+		return fmt.Errorf("unsupported image format %q", format)
+	}
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+		"-protocol_whitelist", "file", "-i", src}, codec...)
+	return t.run(ctx, t.ffmpegPath, append(args, dst)...)
+}
+
+func (t *FFmpegTranscoder) probe(ctx context.Context, demuxer, file string) (*VideoInfo, error) {
+	cmd := exec.CommandContext(ctx, t.ffprobePath,
+		"-v", "error",
+		"-protocol_whitelist", "file",
+		"-f", demuxer, "-format_whitelist", demuxer,
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration",
+		"-of", "json",
+		file,
+	)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probe struct {
+		Streams []struct {
+			Width  int `json:"width"`
+			Height int `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return nil, fmt.Errorf("invalid ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return nil, errors.New("no video stream")
+	}
+	seconds, _ := strconv.ParseFloat(probe.Format.Duration, 64)
+	return &VideoInfo{
+		Width:    probe.Streams[0].Width,
+		Height:   probe.Streams[0].Height,
+		Duration: time.Duration(seconds * float64(time.Second)),
+	}, nil
+}
+
+// run runs a command, returning the end of its output as the error if it fails
+func (t *FFmpegTranscoder) run(ctx context.Context, name string, args ...string) error {
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		tail := output.Bytes()
+		if len(tail) > 512 {
+			tail = tail[len(tail)-512:]
+		}
+		return fmt.Errorf("%s failed: %w: %s", filepath.Base(name), err, bytes.TrimSpace(tail))
+	}
+	return nil
+}
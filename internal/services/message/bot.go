@@ -0,0 +1,127 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// BotMessage is a message an integration posts as its bot user. Bot users
+// aren't community members, so the caller checks that the integration may
+// post in the channel before handing it over.
+type BotMessage struct {
+	// ID and CreatedAt are optional, for callers that record the message
+	// before posting it; they come from messaging.NewMessageID
+	ID        uuid.UUID
+	CreatedAt time.Time
+	ChannelID uuid.UUID
+	// CommunityID is the integration's community. The message is only stored
+	// while the channel is in it and not archived.
+	CommunityID uuid.UUID
+	AuthorID    uuid.UUID
+	Content     string
+	Components  []models.ComponentRow
+	// LinkPreviews replaces the previews built from Content when not nil;
+	// an empty slice posts none
+	LinkPreviews []models.LinkPreview
+}
+
+// CreateBotMessage posts an integration's message. It goes through AutoMod,
+// encryption, link previews, events and mention notifications like a
+// member's message, returning ErrChannelUnavailable when the channel was
+// archived or moved out of the community.
+func (s *Service) CreateBotMessage(ctx context.Context, b *BotMessage) (*MessageResponse, error) {
+	verdict := s.runIntegrationAutoMod(ctx, b.ChannelID, b.AuthorID, b.Content)
+
+	communityID := b.CommunityID
+	m := &NewMessage{
+		ID:           b.ID,
+		ChannelID:    b.ChannelID,
+		CommunityID:  &communityID,
+		AuthorID:     b.AuthorID,
+		Components:   b.Components,
+		LinkPreviews: b.LinkPreviews,
+		CreatedAt:    b.CreatedAt,
+	}
+	if err := s.store(ctx, m, b.Content, verdict); err != nil {
+		return nil, err
+	}
+
+	// GetMessage checks the viewer's access, which bot users don't have
+	stored, err := s.repo.Get(ctx, m.ID)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to fetch bot message after creation")
+		return nil, err
+	}
+	resp := s.decrypt(stored)
+	resp.Reactions = reactionSummaries(nil, uuid.Nil)
+
+	s.announce(ctx, m, resp, b.Content, false)
+	return resp, nil
+}
+
+// BotMessageEdit changes a message an integration posted
+type BotMessageEdit struct {
+	MessageID uuid.UUID
+	// AuthorID is the integration's bot user. Messages it didn't post are
+	// reported as not found.
+	AuthorID uuid.UUID
+	// Content replaces the text when not empty
+	Content string
+	// Components replaces the message's components when not nil; an empty
+	// slice removes them
+	Components []models.ComponentRow
+}
+
+// UpdateBotMessage edits an integration's message. New content goes through
+// AutoMod like a member's edit, and the update is broadcast and handed to
+// event hooks.
+func (s *Service) UpdateBotMessage(ctx context.Context, e *BotMessageEdit) (*MessageResponse, error) {
+	ref, err := s.repo.Locate(ctx, e.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if ref.AuthorID != e.AuthorID {
+		return nil, ErrMessageNotFound
+	}
+
+	edit := &ContentEdit{Components: e.Components, UpdatedAt: time.Now()}
+	if e.Content != "" {
+		if verdict := s.runIntegrationAutoMod(ctx, ref.ChannelID, e.AuthorID, e.Content); verdict != nil {
+			s.automodService.LogVerdict(ctx, verdict, &e.MessageID)
+			if verdict.Action != models.AutoModActionFlag {
+				return nil, ErrBlockedByAutoMod
+			}
+		}
+		encryptedContent, _, err := s.cipher.Encrypt(messaging.ChannelRow(e.MessageID), e.Content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		edit.EncryptedContent = encryptedContent
+		edit.LinkPreviews = messaging.BuildLinkPreviews(ctx, e.Content)
+		edit.SearchTokens = s.search.Tokens(ref.ChannelID, e.Content)
+	}
+	if err := s.repo.UpdateContent(ctx, e.MessageID, edit); err != nil {
+		return nil, err
+	}
+	s.history.Invalidate(ctx, ref.ChannelID)
+
+	stored, err := s.repo.Get(ctx, e.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	resp := s.decrypt(stored)
+	resp.Reactions = reactionSummaries(stored.Message.Reactions, uuid.Nil)
+
+	event := s.eventView(resp)
+	s.broadcastMessage(ctx, "MESSAGE_UPDATE", event, ref.Quarantined)
+	if !ref.Quarantined {
+		s.dispatchEvent(ctx, ref.ChannelID, models.EventHookMessageUpdate, event)
+	}
+	return resp, nil
+}
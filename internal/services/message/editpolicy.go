@@ -0,0 +1,55 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Reasons an edit is refused by the community's edit policy
+const (
+	EditLockWindowExpired = "edit_window_expired"
+	EditLockPinned        = "message_pinned"
+)
+
+// EditLockedError is returned when the community's edit policy refuses an
+// edit. It matches ErrCannotEdit and carries what the client needs to explain
+// why.
+type EditLockedError struct {
+	Reason            string     `json:"reason"`
+	EditWindowMinutes *int       `json:"editWindowMinutes,omitempty"`
+	EditableUntil     *time.Time `json:"editableUntil,omitempty"`
+}
+
+func (e *EditLockedError) Error() string {
+	if e.Reason == EditLockPinned {
+		return "pinned messages cannot be edited"
+	}
+	return fmt.Sprintf("messages can only be edited for %d minutes after posting", *e.EditWindowMinutes)
+}
+
+func (e *EditLockedError) Is(target error) bool {
+	return target == ErrCannotEdit
+}
+
+// checkEditPolicy refuses edits to pinned messages and edits outside the edit
+// window. Members who can manage messages in the channel are exempt, so a
+// moderator can still fix their own announcement after pinning it.
+func (s *Service) checkEditPolicy(ctx context.Context, t *EditTarget, userID uuid.UUID) error {
+	var locked *EditLockedError
+	switch {
+	case t.LockPinned && t.Pinned:
+		locked = &EditLockedError{Reason: EditLockPinned}
+	case t.EditWindowMinutes != nil:
+		until := t.CreatedAt.Add(time.Duration(*t.EditWindowMinutes) * time.Minute)
+		if time.Now().After(until) {
+			locked = &EditLockedError{Reason: EditLockWindowExpired, EditWindowMinutes: t.EditWindowMinutes, EditableUntil: &until}
+		}
+	}
+	if locked == nil || s.channelService.CanManageMessages(ctx, t.ChannelID, userID) {
+		return nil
+	}
+	return locked
+}
@@ -1,12 +1,15 @@
 package message
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/messaging"
 	"github.com/zentra/server/internal/utils"
 )
 
@@ -24,7 +27,7 @@ func (h *Handler) Routes() chi.Router {
 	// Channel-scoped message routes
 	r.Route("/channels/{channelId}/messages", func(r chi.Router) {
 		r.Get("/", h.GetChannelMessages)
-		r.Post("/", h.CreateMessage)
+		r.With(middleware.Idempotent).Post("/", h.CreateMessage)
 		r.Get("/pinned", h.GetPinnedMessages)
 		r.Get("/search", h.SearchMessages)
 		r.Post("/typing", h.StartTyping)
@@ -60,13 +63,7 @@ func (h *Handler) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateMessageRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -75,6 +72,20 @@ func (h *Handler) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		switch err {
 		case ErrInsufficientPerms:
 			utils.RespondError(w, http.StatusForbidden, "Cannot send messages in this channel")
+		case ErrInvalidAttachment:
+			utils.RespondError(w, http.StatusBadRequest, "Invalid attachment")
+		case ErrInvalidReply:
+			utils.RespondError(w, http.StatusBadRequest, "Replied-to message is not in this channel")
+		case ErrBlockedByAutoMod:
+			utils.RespondError(w, http.StatusForbidden, "Message blocked by AutoMod")
+		case ErrRemovedByAutoMod:
+			utils.RespondError(w, http.StatusForbidden, "Message removed by AutoMod")
+		case ErrSlowDown:
+			utils.RespondError(w, http.StatusTooManyRequests, "You are sending messages too quickly")
+		case ErrDuplicateMessage:
+			utils.RespondError(w, http.StatusTooManyRequests, "You are sending the same message too often")
+		case ErrVerificationLevel:
+			utils.RespondError(w, http.StatusForbidden, "Your account does not meet this community's verification level yet")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to create message: "+err.Error())
 		}
@@ -126,23 +137,25 @@ func (h *Handler) GetChannelMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	params := &GetMessagesParams{}
-
-	if before := r.URL.Query().Get("before"); before != "" {
-		if id, err := uuid.Parse(before); err == nil {
-			params.Before = &id
+	// A cursor continues from an earlier page; before or after start from a
+	// message the client already has, such as a jump to a reply
+	params := &GetMessagesParams{Limit: utils.GetQueryInt(r, "limit", 0)}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		params.Before, params.After, err = messaging.DecodeHistoryCursor(cursor)
+		if err != nil {
+			utils.RespondInvalidCursor(w)
+			return
 		}
-	}
-
-	if after := r.URL.Query().Get("after"); after != "" {
-		if id, err := uuid.Parse(after); err == nil {
-			params.After = &id
+	} else {
+		if before := r.URL.Query().Get("before"); before != "" {
+			if id, err := uuid.Parse(before); err == nil {
+				params.Before = &id
+			}
 		}
-	}
-
-	if limit := r.URL.Query().Get("limit"); limit != "" {
-		if l, err := strconv.Atoi(limit); err == nil {
-			params.Limit = l
+		if after := r.URL.Query().Get("after"); after != "" {
+			if id, err := uuid.Parse(after); err == nil {
+				params.After = &id
+			}
 		}
 	}
 
@@ -157,7 +170,11 @@ func (h *Handler) GetChannelMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.RespondSuccess(w, messages)
+	var next string
+	if len(messages) > 0 {
+		next = messaging.NextHistoryCursor(params.After != nil, len(messages), params.Limit, messages[len(messages)-1].ID)
+	}
+	utils.RespondCursorPage(w, messages, next)
 }
 
 func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
@@ -174,23 +191,28 @@ func (h *Handler) UpdateMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateMessageRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
 	message, err := h.service.UpdateMessage(r.Context(), messageID, userID, &req)
 	if err != nil {
+		var locked *EditLockedError
+		if errors.As(err, &locked) {
+			utils.RespondJSON(w, http.StatusForbidden, utils.ErrorResponse{
+				Error:   "This message can no longer be edited: " + locked.Error(),
+				Code:    "EDIT_LOCKED",
+				Details: locked,
+			})
+			return
+		}
 		switch err {
 		case ErrMessageNotFound:
 			utils.RespondError(w, http.StatusNotFound, "Message not found")
 		case ErrNotMessageOwner:
 			utils.RespondError(w, http.StatusForbidden, "Cannot edit this message")
+		case ErrBlockedByAutoMod:
+			utils.RespondError(w, http.StatusForbidden, "Edit blocked by AutoMod")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to update message")
 		}
@@ -257,15 +279,17 @@ func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Emoji string `json:"emoji" validate:"required"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req AddReactionRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
 	if err := h.service.AddReaction(r.Context(), messageID, userID, req.Emoji); err != nil {
+		var limited *messaging.RateLimitError
+		if errors.As(err, &limited) {
+			utils.RespondRateLimited(w, limited.Code, limited.Message, limited.RetryAfter)
+			return
+		}
 		switch err {
 		case ErrMessageNotFound:
 			utils.RespondError(w, http.StatusNotFound, "Message not found")
@@ -273,6 +297,9 @@ func (h *Handler) AddReaction(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusBadRequest, "Invalid emoji")
 		case ErrInsufficientPerms:
 			utils.RespondError(w, http.StatusForbidden, "Cannot react to this message")
+		case ErrTooManyReactions:
+			utils.RespondErrorWithCode(w, http.StatusBadRequest, "MAX_REACTIONS",
+				fmt.Sprintf("You can add at most %d different reactions to a message", messaging.MaxReactionsPerUser))
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to add reaction")
 		}
@@ -446,7 +473,12 @@ func (h *Handler) StartTyping(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.service.SetTyping(r.Context(), channelID, userID); err != nil {
-		utils.RespondError(w, http.StatusInternalServerError, "Failed to set typing indicator")
+		switch err {
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Cannot access this channel")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to set typing indicator")
+		}
 		return
 	}
 
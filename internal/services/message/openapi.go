@@ -0,0 +1,88 @@
+package message
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+	channelID := []openapi.Param{openapi.ID("channelId")}
+
+	openapi.Describe((*Handler).GetChannelMessages, openapi.Operation{
+		Summary:     "Page through a channel's messages",
+		Description: "Newest first. before or after start the history at a message instead, and after pages forward.",
+		Path:        channelID,
+		Query: append(openapi.CursorQuery,
+			openapi.Param{Name: "before", Format: "uuid", Description: "Start with the messages before this one"},
+			openapi.Param{Name: "after", Format: "uuid", Description: "Start with the messages after this one"},
+		),
+		Response: []*MessageResponse{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).CreateMessage, openapi.Operation{
+		Summary:  "Send a message",
+		Path:     channelID,
+		Body:     CreateMessageRequest{},
+		Response: MessageResponse{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetPinnedMessages, openapi.Operation{
+		Summary:  "List a channel's pinned messages",
+		Path:     channelID,
+		Response: []*MessageResponse{},
+	})
+	openapi.Describe((*Handler).SearchMessages, openapi.Operation{
+		Summary: "Search a channel's messages",
+		Path:    channelID,
+		Query: []openapi.Param{
+			{Name: "q", Required: true, Description: "Search text"},
+			{Name: "limit", Type: "integer", Description: "At most this many, 25 by default"},
+		},
+		Response: []*MessageResponse{},
+	})
+	openapi.Describe((*Handler).StartTyping, openapi.Operation{
+		Summary: "Show the current user as typing in a channel",
+		Path:    channelID,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetMessage, openapi.Operation{
+		Summary:  "Get a message",
+		Path:     id,
+		Response: MessageResponse{},
+	})
+	openapi.Describe((*Handler).UpdateMessage, openapi.Operation{
+		Summary:  "Edit a message",
+		Path:     id,
+		Body:     UpdateMessageRequest{},
+		Response: MessageResponse{},
+	})
+	openapi.Describe((*Handler).DeleteMessage, openapi.Operation{
+		Summary: "Delete a message",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).PinMessage, openapi.Operation{
+		Summary: "Pin a message",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UnpinMessage, openapi.Operation{
+		Summary: "Unpin a message",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).AddReaction, openapi.Operation{
+		Summary: "React to a message",
+		Path:    id,
+		Body:    AddReactionRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RemoveReaction, openapi.Operation{
+		Summary: "Remove the current user's reaction from a message",
+		Path:    append(id, openapi.Param{Name: "emoji"}),
+		Status:  http.StatusNoContent,
+	})
+}
@@ -0,0 +1,106 @@
+package message
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/database"
+)
+
+// isQuarantined reports whether userID is quarantined in the community that
+// owns channelID. Lookup failures count as not quarantined.
+func (s *Service) isQuarantined(ctx context.Context, channelID, userID uuid.UUID) bool {
+	quarantined, err := s.repo.IsQuarantined(ctx, channelID, userID)
+	return err == nil && quarantined
+}
+
+// broadcastMessage sends a message event to the channel. Messages from a
+// quarantined author go only to the author and the community's moderators, and
+// the author's copy never carries the quarantine flag.
+func (s *Service) broadcastMessage(ctx context.Context, eventType string, resp *MessageResponse, quarantined bool) {
+	if !quarantined {
+		s.broadcast(ctx, resp.ChannelID.String(), eventType, resp)
+		return
+	}
+
+	modView := *resp
+	modMsg := *resp.Message
+	modMsg.IsQuarantined = true
+	modView.Message = &modMsg
+
+	authorView := *resp
+	authorMsg := *resp.Message
+	authorMsg.IsQuarantined = false
+	authorView.Message = &authorMsg
+
+	s.broadcast(ctx, database.UserStream(resp.AuthorID.String()), eventType, &authorView)
+	for _, moderatorID := range s.quarantineModerators(ctx, resp.ChannelID, resp.AuthorID) {
+		s.broadcast(ctx, database.UserStream(moderatorID.String()), eventType, &modView)
+	}
+}
+
+// broadcastFor sends an event about a message, such as a reaction or its
+// deletion, to whoever can see the message. Events about a quarantined
+// message go only to its author and the community's moderators.
+func (s *Service) broadcastFor(ctx context.Context, ref *MessageRef, eventType string, data any) {
+	if !ref.Quarantined {
+		s.broadcast(ctx, ref.ChannelID.String(), eventType, data)
+		return
+	}
+
+	s.broadcast(ctx, database.UserStream(ref.AuthorID.String()), eventType, data)
+	for _, moderatorID := range s.quarantineModerators(ctx, ref.ChannelID, ref.AuthorID) {
+		s.broadcast(ctx, database.UserStream(moderatorID.String()), eventType, data)
+	}
+}
+
+// quarantineModerators returns the moderators who see quarantined messages in
+// the channel, leaving out the author
+func (s *Service) quarantineModerators(ctx context.Context, channelID, authorID uuid.UUID) []uuid.UUID {
+	moderators, err := s.repo.QuarantineModerators(ctx, channelID)
+	if err != nil {
+		log.Error().Err(err).Str("channelId", channelID.String()).Msg("Failed to get moderators for quarantined message")
+	}
+	others := moderators[:0]
+	for _, moderatorID := range moderators {
+		if moderatorID != authorID {
+			others = append(others, moderatorID)
+		}
+	}
+	return others
+}
+
+// canSee reports whether userID may see a message they can already reach the
+// channel of. Quarantined messages only exist for their author and moderators.
+func (s *Service) canSee(ctx context.Context, ref *MessageRef, userID uuid.UUID) bool {
+	return !ref.Quarantined || ref.AuthorID == userID || s.channelService.CanManageMessages(ctx, ref.ChannelID, userID)
+}
+
+// checkReplyTarget returns ErrInvalidReply unless the replied-to message is
+// in the channel and userID can see it.
+func (s *Service) checkReplyTarget(ctx context.Context, channelID, userID, replyToID uuid.UUID) error {
+	ref, err := s.repo.Locate(ctx, replyToID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return ErrInvalidReply
+	}
+	if err != nil {
+		return err
+	}
+	if ref.ChannelID != channelID || !s.canSee(ctx, ref, userID) {
+		return ErrInvalidReply
+	}
+	return nil
+}
+
+// hideQuarantineFlag clears the quarantine flag for viewers who aren't
+// moderators, so a quarantined author can't tell from their own messages.
+func hideQuarantineFlag(messages []*MessageResponse, canModerate bool) {
+	if canModerate {
+		return
+	}
+	for _, m := range messages {
+		m.IsQuarantined = false
+	}
+}
@@ -0,0 +1,162 @@
+package message
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// Repository is the storage behind the message service. The service keeps
+// the rules (permissions, AutoMod, encryption, events) and the repository only
+// reads and writes rows, so the service can run against a fake in tests or
+// against another backend. PostgresRepository is the default.
+//
+// Content goes in and comes out encrypted: Message.EncryptedContent is set and
+// Message.Content is not. Reads return ErrMessageNotFound for missing and
+// deleted messages.
+type Repository interface {
+	// Create stores a message, links its attachments and moves the channel's
+	// last message time, all or nothing. Only attachments the author uploaded
+	// and that aren't on a message yet are linked; any other fails the create
+	// with messaging.ErrUnknownAttachment.
+	Create(ctx context.Context, m *NewMessage) error
+	// Get returns a message with its author
+	Get(ctx context.Context, messageID uuid.UUID) (*StoredMessage, error)
+	// Locate returns what writes check before they touch a message
+	Locate(ctx context.Context, messageID uuid.UUID) (*MessageRef, error)
+	// GetEditTarget returns a message along with the edit policy of its
+	// community
+	GetEditTarget(ctx context.Context, messageID uuid.UUID) (*EditTarget, error)
+
+	// History returns a page of a channel's messages. Pages before a cursor,
+	// or without one, are newest first; pages after a cursor are oldest first.
+	History(ctx context.Context, q HistoryQuery) ([]*StoredMessage, error)
+	// Pinned returns a channel's pinned messages, newest first
+	Pinned(ctx context.Context, channelID uuid.UUID, v Visibility, limit int) ([]*StoredMessage, error)
+	// Search returns a channel's messages that have every one of tokens,
+	// newest first
+	Search(ctx context.Context, channelID uuid.UUID, tokens []string, v Visibility, limit int) ([]*StoredMessage, error)
+	// ReplyPreviews returns the messages with the given IDs that are in the
+	// channel. Only the fields a reply preview shows are set.
+	ReplyPreviews(ctx context.Context, channelID uuid.UUID, messageIDs []uuid.UUID, v Visibility) ([]*StoredMessage, error)
+	// Attachments returns the attachments of messages, by message
+	Attachments(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID][]models.MessageAttachment, error)
+
+	// UpdateContent applies an edit to a message, returning
+	// ErrMessageNotFound once it has been deleted
+	UpdateContent(ctx context.Context, messageID uuid.UUID, edit *ContentEdit) error
+	// Delete soft-deletes a message
+	Delete(ctx context.Context, messageID uuid.UUID, at time.Time) error
+	// AddReaction adds userID to the users reacting with emoji. It reports
+	// false, without adding, when that would put the user over maxPerUser
+	// different emoji on the message.
+	AddReaction(ctx context.Context, ref *MessageRef, userID uuid.UUID, emoji string, maxPerUser int, at time.Time) (bool, error)
+	// RemoveReaction removes userID from the users reacting with emoji
+	RemoveReaction(ctx context.Context, ref *MessageRef, userID uuid.UUID, emoji string, at time.Time) error
+	// SetPinned pins or unpins a message
+	SetPinned(ctx context.Context, messageID uuid.UUID, pinned bool, at time.Time) error
+
+	// ChannelCommunity returns the community a channel belongs to
+	ChannelCommunity(ctx context.Context, channelID uuid.UUID) (uuid.UUID, error)
+	// IsQuarantined reports whether userID is quarantined in the community
+	// that owns channelID
+	IsQuarantined(ctx context.Context, channelID, userID uuid.UUID) (bool, error)
+	// QuarantineModerators returns the members who can see quarantined
+	// messages in the community that owns channelID: the owner and anyone
+	// whose roles grant Administrator or ManageMessages
+	QuarantineModerators(ctx context.Context, channelID uuid.UUID) ([]uuid.UUID, error)
+
+	// RecordDecryptionFailure stores a message that failed to decrypt for the
+	// admin report
+	RecordDecryptionFailure(ctx context.Context, ref messaging.ContentRef, keyVersion string, cause error) error
+}
+
+// StoredMessage is a message as the repository returns it, content still
+// encrypted
+type StoredMessage struct {
+	Message models.Message
+	Author  models.PublicUser
+}
+
+// MessageRef identifies a stored message and carries what writes check first
+type MessageRef struct {
+	ID          uuid.UUID
+	ChannelID   uuid.UUID
+	AuthorID    uuid.UUID
+	CreatedAt   time.Time
+	Quarantined bool
+}
+
+// NewMessage is a message for Repository.Create
+type NewMessage struct {
+	ID        uuid.UUID
+	ChannelID uuid.UUID
+	// CommunityID, when set, stores the message only while the channel is in
+	// that community and not archived, failing with ErrChannelUnavailable
+	CommunityID      *uuid.UUID
+	AuthorID         uuid.UUID
+	EncryptedContent []byte
+	ContentWarning   *string
+	ReplyToID        *uuid.UUID
+	LinkPreviews     []models.LinkPreview
+	// Components are only set on bot messages
+	Components []models.ComponentRow
+	CreatedAt  time.Time
+	// DeletedAt is set for messages AutoMod removed, which are kept for review
+	DeletedAt *time.Time
+	// Quarantined messages don't move the channel's last message time
+	Quarantined  bool
+	SearchTokens []string
+	// Attachments the author uploaded earlier, linked to the message along
+	// with their options. An option for an attachment not in the list fails
+	// the create with messaging.ErrUnknownAttachment.
+	Attachments       []uuid.UUID
+	AttachmentOptions []models.AttachmentOptions
+}
+
+// ContentEdit is an edit for Repository.UpdateContent
+type ContentEdit struct {
+	// EncryptedContent, when set, replaces the content, link previews and
+	// search tokens and marks the message edited; nil keeps all of them
+	EncryptedContent []byte
+	LinkPreviews     []models.LinkPreview
+	SearchTokens     []string
+	// ContentWarning replaces the warning when set; "" removes it
+	ContentWarning *string
+	// Components replaces a bot message's components when not nil; an empty
+	// slice removes them
+	Components []models.ComponentRow
+	UpdatedAt  time.Time
+}
+
+// HistoryQuery selects a page of a channel's history
+type HistoryQuery struct {
+	ChannelID uuid.UUID
+	// At most one of Before and After is set
+	Before *uuid.UUID
+	After  *uuid.UUID
+	Limit  int
+	Visibility
+}
+
+// Visibility is which quarantined messages a read returns: the viewer's own,
+// or everyone's with AllQuarantined
+type Visibility struct {
+	ViewerID       uuid.UUID
+	AllQuarantined bool
+}
+
+// EditTarget is what UpdateMessage needs to know about the message and the
+// policy of the community it was posted in
+type EditTarget struct {
+	AuthorID          uuid.UUID
+	ChannelID         uuid.UUID
+	Quarantined       bool
+	Pinned            bool
+	CreatedAt         time.Time
+	EditWindowMinutes *int
+	LockPinned        bool
+}
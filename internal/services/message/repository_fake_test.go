@@ -0,0 +1,419 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// fakeRepository is an in-memory Repository for service tests
+type fakeRepository struct {
+	mu sync.Mutex
+
+	messages    map[uuid.UUID]*fakeMessage
+	users       map[uuid.UUID]models.PublicUser
+	channels    map[uuid.UUID]*fakeChannel
+	attachments map[uuid.UUID]*models.MessageAttachment
+	quarantined map[uuid.UUID]bool
+	moderators  []uuid.UUID
+	failures    []messaging.ContentRef
+}
+
+type fakeMessage struct {
+	models.Message
+	deletedAt    *time.Time
+	searchTokens []string
+}
+
+type fakeChannel struct {
+	communityID uuid.UUID
+	archived    bool
+}
+
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		messages:    make(map[uuid.UUID]*fakeMessage),
+		users:       make(map[uuid.UUID]models.PublicUser),
+		channels:    make(map[uuid.UUID]*fakeChannel),
+		attachments: make(map[uuid.UUID]*models.MessageAttachment),
+		quarantined: make(map[uuid.UUID]bool),
+	}
+}
+
+func (r *fakeRepository) addUser(username string) uuid.UUID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := uuid.New()
+	r.users[id] = models.PublicUser{ID: id, Username: username, CreatedAt: time.Now()}
+	return id
+}
+
+func (r *fakeRepository) addChannel(communityID uuid.UUID) uuid.UUID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := uuid.New()
+	r.channels[id] = &fakeChannel{communityID: communityID}
+	return id
+}
+
+func (r *fakeRepository) addAttachment(uploaderID uuid.UUID) uuid.UUID {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := uuid.New()
+	r.attachments[id] = &models.MessageAttachment{ID: id, UploaderID: uploaderID, Filename: "file.png", CreatedAt: time.Now()}
+	return id
+}
+
+func (r *fakeRepository) stored(m *fakeMessage) *StoredMessage {
+	msg := m.Message
+	if m.Reactions != nil {
+		msg.Reactions = make(map[string][]uuid.UUID, len(m.Reactions))
+		for emoji, users := range m.Reactions {
+			msg.Reactions[emoji] = append([]uuid.UUID(nil), users...)
+		}
+	}
+	return &StoredMessage{Message: msg, Author: r.users[m.AuthorID]}
+}
+
+// live returns a message that hasn't been deleted
+func (r *fakeRepository) live(messageID uuid.UUID) (*fakeMessage, error) {
+	m, ok := r.messages[messageID]
+	if !ok || m.deletedAt != nil {
+		return nil, ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func visible(m *fakeMessage, v Visibility) bool {
+	return !m.IsQuarantined || m.AuthorID == v.ViewerID || v.AllQuarantined
+}
+
+func (r *fakeRepository) Create(_ context.Context, m *NewMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch, ok := r.channels[m.ChannelID]
+	if !ok || (m.CommunityID != nil && (ch.communityID != *m.CommunityID || ch.archived)) {
+		return ErrChannelUnavailable
+	}
+	for _, id := range m.Attachments {
+		a, ok := r.attachments[id]
+		if !ok || a.UploaderID != m.AuthorID || a.MessageID != nil {
+			return messaging.ErrUnknownAttachment
+		}
+	}
+	for _, opt := range m.AttachmentOptions {
+		found := false
+		for _, id := range m.Attachments {
+			found = found || id == opt.ID
+		}
+		if !found {
+			return messaging.ErrUnknownAttachment
+		}
+	}
+
+	for _, id := range m.Attachments {
+		messageID, createdAt := m.ID, m.CreatedAt
+		r.attachments[id].MessageID = &messageID
+		r.attachments[id].MessageCreatedAt = &createdAt
+	}
+	r.messages[m.ID] = &fakeMessage{
+		Message: models.Message{
+			ID:               m.ID,
+			ChannelID:        m.ChannelID,
+			AuthorID:         m.AuthorID,
+			EncryptedContent: m.EncryptedContent,
+			ContentWarning:   m.ContentWarning,
+			ReplyToID:        m.ReplyToID,
+			LinkPreviews:     m.LinkPreviews,
+			Components:       m.Components,
+			IsQuarantined:    m.Quarantined,
+			CreatedAt:        m.CreatedAt,
+			UpdatedAt:        m.CreatedAt,
+		},
+		deletedAt:    m.DeletedAt,
+		searchTokens: m.SearchTokens,
+	}
+	return nil
+}
+
+func (r *fakeRepository) Get(_ context.Context, messageID uuid.UUID) (*StoredMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.live(messageID)
+	if err != nil {
+		return nil, err
+	}
+	return r.stored(m), nil
+}
+
+func (r *fakeRepository) Locate(_ context.Context, messageID uuid.UUID) (*MessageRef, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.live(messageID)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageRef{ID: m.ID, ChannelID: m.ChannelID, AuthorID: m.AuthorID, CreatedAt: m.CreatedAt, Quarantined: m.IsQuarantined}, nil
+}
+
+func (r *fakeRepository) GetEditTarget(_ context.Context, messageID uuid.UUID) (*EditTarget, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.live(messageID)
+	if err != nil {
+		return nil, err
+	}
+	return &EditTarget{AuthorID: m.AuthorID, ChannelID: m.ChannelID, Quarantined: m.IsQuarantined, Pinned: m.IsPinned, CreatedAt: m.CreatedAt}, nil
+}
+
+// channelMessages returns the live messages of a channel v can see, oldest first
+func (r *fakeRepository) channelMessages(channelID uuid.UUID, v Visibility, keep func(*fakeMessage) bool) []*fakeMessage {
+	var messages []*fakeMessage
+	for _, m := range r.messages {
+		if m.ChannelID == channelID && m.deletedAt == nil && visible(m, v) && (keep == nil || keep(m)) {
+			messages = append(messages, m)
+		}
+	}
+	sort.Slice(messages, func(i, j int) bool { return before(messages[i], messages[j]) })
+	return messages
+}
+
+func before(a, b *fakeMessage) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) < 0
+}
+
+func (r *fakeRepository) newestFirst(messages []*fakeMessage, limit int) []*StoredMessage {
+	var result []*StoredMessage
+	for i := len(messages) - 1; i >= 0 && len(result) < limit; i-- {
+		result = append(result, r.stored(messages[i]))
+	}
+	return result
+}
+
+func (r *fakeRepository) History(_ context.Context, q HistoryQuery) ([]*StoredMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cursor := func(id uuid.UUID) *fakeMessage {
+		if m, ok := r.messages[id]; ok {
+			return m
+		}
+		at, _ := messaging.MessageIDTime(id)
+		return &fakeMessage{Message: models.Message{ID: id, CreatedAt: at}}
+	}
+	switch {
+	case q.Before != nil:
+		c := cursor(*q.Before)
+		return r.newestFirst(r.channelMessages(q.ChannelID, q.Visibility, func(m *fakeMessage) bool { return before(m, c) }), q.Limit), nil
+	case q.After != nil:
+		c := cursor(*q.After)
+		var result []*StoredMessage
+		for _, m := range r.channelMessages(q.ChannelID, q.Visibility, func(m *fakeMessage) bool { return before(c, m) }) {
+			if len(result) == q.Limit {
+				break
+			}
+			result = append(result, r.stored(m))
+		}
+		return result, nil
+	}
+	return r.newestFirst(r.channelMessages(q.ChannelID, q.Visibility, nil), q.Limit), nil
+}
+
+func (r *fakeRepository) Pinned(_ context.Context, channelID uuid.UUID, v Visibility, limit int) ([]*StoredMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.newestFirst(r.channelMessages(channelID, v, func(m *fakeMessage) bool { return m.IsPinned }), limit), nil
+}
+
+func (r *fakeRepository) Search(_ context.Context, channelID uuid.UUID, tokens []string, v Visibility, limit int) ([]*StoredMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	hasAll := func(m *fakeMessage) bool {
+		for _, token := range tokens {
+			found := false
+			for _, t := range m.searchTokens {
+				found = found || t == token
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return r.newestFirst(r.channelMessages(channelID, v, hasAll), limit), nil
+}
+
+func (r *fakeRepository) ReplyPreviews(_ context.Context, channelID uuid.UUID, messageIDs []uuid.UUID, v Visibility) ([]*StoredMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []*StoredMessage
+	for _, id := range messageIDs {
+		m, err := r.live(id)
+		if err == nil && m.ChannelID == channelID && visible(m, v) {
+			result = append(result, r.stored(m))
+		}
+	}
+	return result, nil
+}
+
+func (r *fakeRepository) Attachments(_ context.Context, messageIDs []uuid.UUID) (map[uuid.UUID][]models.MessageAttachment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wanted := make(map[uuid.UUID]bool, len(messageIDs))
+	for _, id := range messageIDs {
+		wanted[id] = true
+	}
+	result := make(map[uuid.UUID][]models.MessageAttachment)
+	for _, a := range r.attachments {
+		if a.MessageID != nil && wanted[*a.MessageID] {
+			result[*a.MessageID] = append(result[*a.MessageID], *a)
+		}
+	}
+	return result, nil
+}
+
+func (r *fakeRepository) UpdateContent(_ context.Context, messageID uuid.UUID, edit *ContentEdit) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.live(messageID)
+	if err != nil {
+		return err
+	}
+	if edit.EncryptedContent != nil {
+		m.EncryptedContent = edit.EncryptedContent
+		m.LinkPreviews = edit.LinkPreviews
+		m.searchTokens = edit.SearchTokens
+		m.IsEdited = true
+	}
+	if edit.ContentWarning != nil {
+		m.ContentWarning = nil
+		if *edit.ContentWarning != "" {
+			warning := *edit.ContentWarning
+			m.ContentWarning = &warning
+		}
+	}
+	if edit.Components != nil {
+		m.Components = edit.Components
+		if len(edit.Components) == 0 {
+			m.Components = nil
+		}
+	}
+	m.UpdatedAt = edit.UpdatedAt
+	return nil
+}
+
+func (r *fakeRepository) Delete(_ context.Context, messageID uuid.UUID, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.messages[messageID]; ok {
+		m.deletedAt = &at
+	}
+	return nil
+}
+
+func (r *fakeRepository) AddReaction(_ context.Context, ref *MessageRef, userID uuid.UUID, emoji string, maxPerUser int, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.live(ref.ID)
+	if err != nil {
+		return false, nil
+	}
+	has := func(users []uuid.UUID) bool {
+		for _, u := range users {
+			if u == userID {
+				return true
+			}
+		}
+		return false
+	}
+	if !has(m.Reactions[emoji]) {
+		count := 0
+		for _, users := range m.Reactions {
+			if has(users) {
+				count++
+			}
+		}
+		if count >= maxPerUser {
+			return false, nil
+		}
+	}
+	if m.Reactions == nil {
+		m.Reactions = make(map[string][]uuid.UUID)
+	}
+	users := m.Reactions[emoji][:0:0]
+	for _, u := range m.Reactions[emoji] {
+		if u != userID {
+			users = append(users, u)
+		}
+	}
+	m.Reactions[emoji] = append(users, userID)
+	m.UpdatedAt = at
+	return true, nil
+}
+
+func (r *fakeRepository) RemoveReaction(_ context.Context, ref *MessageRef, userID uuid.UUID, emoji string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, err := r.live(ref.ID)
+	if err != nil {
+		return nil
+	}
+	var users []uuid.UUID
+	for _, u := range m.Reactions[emoji] {
+		if u != userID {
+			users = append(users, u)
+		}
+	}
+	m.Reactions[emoji] = users
+	m.UpdatedAt = at
+	return nil
+}
+
+func (r *fakeRepository) SetPinned(_ context.Context, messageID uuid.UUID, pinned bool, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.messages[messageID]; ok {
+		m.IsPinned = pinned
+		m.UpdatedAt = at
+	}
+	return nil
+}
+
+func (r *fakeRepository) ChannelCommunity(_ context.Context, channelID uuid.UUID) (uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch, ok := r.channels[channelID]
+	if !ok {
+		return uuid.Nil, ErrChannelUnavailable
+	}
+	return ch.communityID, nil
+}
+
+func (r *fakeRepository) IsQuarantined(_ context.Context, _, userID uuid.UUID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.quarantined[userID], nil
+}
+
+func (r *fakeRepository) QuarantineModerators(context.Context, uuid.UUID) ([]uuid.UUID, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]uuid.UUID(nil), r.moderators...), nil
+}
+
+func (r *fakeRepository) RecordDecryptionFailure(_ context.Context, ref messaging.ContentRef, _ string, _ error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, ref)
+	return nil
+}
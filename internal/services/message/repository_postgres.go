@@ -0,0 +1,491 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// PostgresRepository is the Repository the server runs with
+type PostgresRepository struct {
+	db *pgxpool.Pool
+}
+
+func NewPostgresRepository(db *pgxpool.Pool) *PostgresRepository {
+	return &PostgresRepository{db: db}
+}
+
+// messageColumns are the columns scanMessage reads, m being messages and u
+// the author in users
+const messageColumns = `m.id, m.channel_id, m.author_id, m.encrypted_content, m.content_warning, m.reply_to_id,
+	m.link_previews, m.components, m.is_pinned, m.is_edited, m.is_quarantined, m.reactions, m.created_at, m.updated_at,
+	u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at`
+
+func scanMessage(row pgx.Row) (*StoredMessage, error) {
+	var sm StoredMessage
+	var linkPreviewRaw, componentsRaw []byte
+	msg, author := &sm.Message, &sm.Author
+	err := row.Scan(
+		&msg.ID, &msg.ChannelID, &msg.AuthorID, &msg.EncryptedContent, &msg.ContentWarning,
+		&msg.ReplyToID, &linkPreviewRaw, &componentsRaw, &msg.IsPinned, &msg.IsEdited, &msg.IsQuarantined, &msg.Reactions, &msg.CreatedAt, &msg.UpdatedAt,
+		&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
+	msg.Components = messaging.DecodeComponents(componentsRaw)
+	return &sm, nil
+}
+
+func (r *PostgresRepository) queryMessages(ctx context.Context, query string, args ...any) ([]*StoredMessage, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*StoredMessage
+	for rows.Next() {
+		sm, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, sm)
+	}
+	return messages, rows.Err()
+}
+
+func (r *PostgresRepository) Create(ctx context.Context, m *NewMessage) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO messages (id, channel_id, author_id, encrypted_content, content_warning, reply_to_id, link_previews, components, created_at, updated_at, deleted_at, is_quarantined, search_tokens)
+			SELECT $1, c.id, $3, $4, $5, $6, $7::jsonb, $12::jsonb, $8, $8, $9, $10, $11
+			FROM channels c
+			WHERE c.id = $2 AND ($13::uuid IS NULL OR (c.community_id = $13 AND c.archived_at IS NULL))`,
+			m.ID, m.ChannelID, m.AuthorID, m.EncryptedContent, m.ContentWarning, m.ReplyToID,
+			string(messaging.EncodeLinkPreviews(m.LinkPreviews)), m.CreatedAt, m.DeletedAt, m.Quarantined, m.SearchTokens,
+			messaging.EncodeComponents(m.Components), m.CommunityID,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrChannelUnavailable
+		}
+
+		for _, attachmentID := range m.Attachments {
+			tag, err := tx.Exec(ctx,
+				`UPDATE message_attachments SET message_id = $1, message_created_at = $2
+				WHERE id = $3 AND uploader_id = $4 AND message_id IS NULL`,
+				m.ID, m.CreatedAt, attachmentID, m.AuthorID,
+			)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				return messaging.ErrUnknownAttachment
+			}
+		}
+		if err := messaging.ApplyAttachmentOptions(ctx, tx, m.AuthorID, m.Attachments, m.AttachmentOptions); err != nil {
+			return err
+		}
+
+		// Hidden messages shouldn't mark the channel unread for others
+		if !m.Quarantined {
+			_, err = tx.Exec(ctx,
+				`UPDATE channels SET last_message_at = $1 WHERE id = $2`,
+				m.CreatedAt, m.ChannelID,
+			)
+		}
+		return err
+	})
+}
+
+func (r *PostgresRepository) Get(ctx context.Context, messageID uuid.UUID) (*StoredMessage, error) {
+	sm, err := scanMessage(r.db.QueryRow(ctx,
+		`SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON u.id = m.author_id
+		WHERE m.id = $1 AND m.deleted_at IS NULL`,
+		messageID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	return sm, err
+}
+
+func (r *PostgresRepository) Locate(ctx context.Context, messageID uuid.UUID) (*MessageRef, error) {
+	ref := &MessageRef{ID: messageID}
+	err := r.db.QueryRow(ctx,
+		`SELECT channel_id, author_id, created_at, is_quarantined FROM messages WHERE id = $1 AND deleted_at IS NULL`,
+		messageID,
+	).Scan(&ref.ChannelID, &ref.AuthorID, &ref.CreatedAt, &ref.Quarantined)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (r *PostgresRepository) GetEditTarget(ctx context.Context, messageID uuid.UUID) (*EditTarget, error) {
+	t := &EditTarget{}
+	err := r.db.QueryRow(ctx,
+		`SELECT m.author_id, m.channel_id, m.is_quarantined, m.is_pinned, m.created_at,
+		        ep.edit_window_minutes, COALESCE(ep.lock_pinned, FALSE)
+		FROM messages m
+		LEFT JOIN channels c ON c.id = m.channel_id
+		LEFT JOIN community_edit_policies ep ON ep.community_id = c.community_id
+		WHERE m.id = $1 AND m.deleted_at IS NULL`,
+		messageID,
+	).Scan(&t.AuthorID, &t.ChannelID, &t.Quarantined, &t.Pinned, &t.CreatedAt, &t.EditWindowMinutes, &t.LockPinned)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrMessageNotFound
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+// History pages messages with sortable IDs on the ID alone. Older messages
+// with random IDs all come before them and are paged on (created_at, id),
+// which also fills the rest of a page once the sortable ones run out. A
+// sortable cursor's time bounds created_at so only the partitions it can
+// reach are read.
+func (r *PostgresRepository) History(ctx context.Context, q HistoryQuery) ([]*StoredMessage, error) {
+	sortable := messaging.SortableID("m.id")
+	switch {
+	case q.Before != nil:
+		cursorTime, ok := messaging.MessageIDTime(*q.Before)
+		if !ok {
+			return r.historyBefore(ctx, q, *q.Before, nil, q.Limit)
+		}
+		page, err := r.queryMessages(ctx,
+			`SELECT `+messageColumns+`
+			FROM messages m
+			JOIN users u ON u.id = m.author_id
+			WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+			  AND `+sortable+` AND m.id < $2 AND m.created_at <= $6
+			  AND (NOT m.is_quarantined OR m.author_id = $4 OR $5)
+			ORDER BY m.id DESC
+			LIMIT $3`,
+			q.ChannelID, *q.Before, q.Limit, q.ViewerID, q.AllQuarantined, cursorTime,
+		)
+		if err != nil || len(page) == q.Limit {
+			return page, err
+		}
+		return r.fillHistory(ctx, q, page, *q.Before, cursorTime)
+	case q.After != nil:
+		cursorTime, ok := messaging.MessageIDTime(*q.After)
+		if !ok {
+			// Messages with random IDs come first, in (created_at, id) order,
+			// then the sortable ones, whose (created_at, id) order is their ID's
+			return r.queryMessages(ctx,
+				`SELECT `+messageColumns+`
+				FROM messages m
+				JOIN users u ON u.id = m.author_id
+				WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+				  AND (m.created_at, m.id) > ((SELECT created_at FROM messages WHERE id = $2 AND channel_id = $1), $2)
+				  AND (NOT m.is_quarantined OR m.author_id = $4 OR $5)
+				ORDER BY m.created_at ASC, m.id ASC
+				LIMIT $3`,
+				q.ChannelID, *q.After, q.Limit, q.ViewerID, q.AllQuarantined,
+			)
+		}
+		return r.queryMessages(ctx,
+			`SELECT `+messageColumns+`
+			FROM messages m
+			JOIN users u ON u.id = m.author_id
+			WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+			  AND `+sortable+` AND m.id > $2 AND m.created_at >= $6
+			  AND (NOT m.is_quarantined OR m.author_id = $4 OR $5)
+			ORDER BY m.id ASC
+			LIMIT $3`,
+			q.ChannelID, *q.After, q.Limit, q.ViewerID, q.AllQuarantined, cursorTime,
+		)
+	}
+	page, err := r.queryMessages(ctx,
+		`SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON u.id = m.author_id
+		WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+		  AND `+sortable+`
+		  AND (NOT m.is_quarantined OR m.author_id = $3 OR $4)
+		ORDER BY m.id DESC
+		LIMIT $2`,
+		q.ChannelID, q.Limit, q.ViewerID, q.AllQuarantined,
+	)
+	if err != nil || len(page) == q.Limit {
+		return page, err
+	}
+	if len(page) == 0 {
+		return r.queryMessages(ctx,
+			`SELECT `+messageColumns+`
+			FROM messages m
+			JOIN users u ON u.id = m.author_id
+			WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+			  AND (NOT m.is_quarantined OR m.author_id = $3 OR $4)
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT $2`,
+			q.ChannelID, q.Limit, q.ViewerID, q.AllQuarantined,
+		)
+	}
+	last := &page[len(page)-1].Message
+	return r.fillHistory(ctx, q, page, last.ID, last.CreatedAt)
+}
+
+// fillHistory completes a page of sortable messages that ran out with the
+// older ones before the cursor, or before the page's last message
+func (r *PostgresRepository) fillHistory(ctx context.Context, q HistoryQuery, page []*StoredMessage, cursor uuid.UUID, cursorTime time.Time) ([]*StoredMessage, error) {
+	if len(page) > 0 {
+		last := &page[len(page)-1].Message
+		cursor, cursorTime = last.ID, last.CreatedAt
+	}
+	older, err := r.historyBefore(ctx, q, cursor, &cursorTime, q.Limit-len(page))
+	if err != nil {
+		return nil, err
+	}
+	return append(page, older...), nil
+}
+
+// historyBefore pages on (created_at, id) before cursor. cursorTime is the
+// cursor's created_at, or nil to look it up.
+func (r *PostgresRepository) historyBefore(ctx context.Context, q HistoryQuery, cursor uuid.UUID, cursorTime *time.Time, limit int) ([]*StoredMessage, error) {
+	return r.queryMessages(ctx,
+		`SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON u.id = m.author_id
+		WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+		  AND (m.created_at, m.id) < (COALESCE($6, (SELECT created_at FROM messages WHERE id = $2 AND channel_id = $1)), $2)
+		  AND (NOT m.is_quarantined OR m.author_id = $4 OR $5)
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $3`,
+		q.ChannelID, cursor, limit, q.ViewerID, q.AllQuarantined, cursorTime,
+	)
+}
+
+func (r *PostgresRepository) Pinned(ctx context.Context, channelID uuid.UUID, v Visibility, limit int) ([]*StoredMessage, error) {
+	return r.queryMessages(ctx,
+		`SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON u.id = m.author_id
+		WHERE m.channel_id = $1 AND m.is_pinned = true AND m.deleted_at IS NULL
+		  AND (NOT m.is_quarantined OR m.author_id = $2 OR $3)
+		ORDER BY m.created_at DESC
+		LIMIT $4`,
+		channelID, v.ViewerID, v.AllQuarantined, limit,
+	)
+}
+
+func (r *PostgresRepository) Search(ctx context.Context, channelID uuid.UUID, tokens []string, v Visibility, limit int) ([]*StoredMessage, error) {
+	return r.queryMessages(ctx,
+		`SELECT `+messageColumns+`
+		FROM messages m
+		JOIN users u ON u.id = m.author_id
+		WHERE m.channel_id = $1 AND m.deleted_at IS NULL
+		  AND m.search_tokens @> $2::text[]
+		  AND (NOT m.is_quarantined OR m.author_id = $4 OR $5)
+		ORDER BY m.created_at DESC
+		LIMIT $3`,
+		channelID, tokens, limit, v.ViewerID, v.AllQuarantined,
+	)
+}
+
+func (r *PostgresRepository) ReplyPreviews(ctx context.Context, channelID uuid.UUID, messageIDs []uuid.UUID, v Visibility) ([]*StoredMessage, error) {
+	if len(messageIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := r.db.Query(ctx,
+		`SELECT m.id, m.channel_id, m.encrypted_content, m.content_warning, m.author_id,
+		       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
+		FROM messages m
+		JOIN users u ON u.id = m.author_id
+		WHERE m.id = ANY($1) AND m.channel_id = $2 AND m.deleted_at IS NULL
+		  AND (NOT m.is_quarantined OR m.author_id = $3 OR $4)`,
+		messageIDs, channelID, v.ViewerID, v.AllQuarantined,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*StoredMessage
+	for rows.Next() {
+		var sm StoredMessage
+		msg, author := &sm.Message, &sm.Author
+		err := rows.Scan(
+			&msg.ID, &msg.ChannelID, &msg.EncryptedContent, &msg.ContentWarning, &msg.AuthorID,
+			&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, &sm)
+	}
+	return messages, rows.Err()
+}
+
+func (r *PostgresRepository) Attachments(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID][]models.MessageAttachment, error) {
+	result := make(map[uuid.UUID][]models.MessageAttachment)
+	if len(messageIDs) == 0 {
+		return result, nil
+	}
+	rows, err := r.db.Query(ctx,
+		`SELECT id, message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, medium_url, video_url, poster_url, width, height, duration_seconds, blurhash, processing_status, is_spoiler, description, created_at
+		FROM message_attachments
+		WHERE message_id = ANY($1)`,
+		messageIDs,
+	)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.MessageAttachment
+		err := rows.Scan(&a.ID, &a.MessageID, &a.MessageCreatedAt, &a.UploaderID, &a.Filename, &a.FileURL,
+			&a.FileSize, &a.ContentType, &a.ThumbnailURL, &a.MediumURL, &a.VideoURL, &a.PosterURL, &a.Width, &a.Height, &a.DurationSeconds, &a.Blurhash, &a.ProcessingStatus, &a.IsSpoiler, &a.Description, &a.CreatedAt)
+		if err != nil {
+			return result, err
+		}
+		if a.MessageID != nil {
+			result[*a.MessageID] = append(result[*a.MessageID], a)
+		}
+	}
+	return result, rows.Err()
+}
+
+func (r *PostgresRepository) UpdateContent(ctx context.Context, messageID uuid.UUID, edit *ContentEdit) error {
+	tag, err := r.db.Exec(ctx,
+		`UPDATE messages SET
+			encrypted_content = COALESCE($1, encrypted_content),
+			link_previews = CASE WHEN $1::bytea IS NULL THEN link_previews ELSE $2::jsonb END,
+			search_tokens = CASE WHEN $1::bytea IS NULL THEN search_tokens ELSE $6 END,
+			is_edited = is_edited OR $1::bytea IS NOT NULL,
+			content_warning = CASE WHEN $5::text IS NULL THEN content_warning ELSE NULLIF($5, '') END,
+			components = CASE WHEN $7 THEN $8::jsonb ELSE components END,
+			updated_at = $3
+		WHERE id = $4 AND deleted_at IS NULL`,
+		edit.EncryptedContent, string(messaging.EncodeLinkPreviews(edit.LinkPreviews)), edit.UpdatedAt, messageID, edit.ContentWarning,
+		edit.SearchTokens, edit.Components != nil, messaging.EncodeComponents(edit.Components),
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
+}
+
+func (r *PostgresRepository) Delete(ctx context.Context, messageID uuid.UUID, at time.Time) error {
+	_, err := r.db.Exec(ctx, `UPDATE messages SET deleted_at = $1 WHERE id = $2`, at, messageID)
+	return err
+}
+
+func (r *PostgresRepository) AddReaction(ctx context.Context, ref *MessageRef, userID uuid.UUID, emoji string, maxPerUser int, at time.Time) (bool, error) {
+	// Re-adding an emoji is always fine; a new one counts towards the user's cap
+	tag, err := r.db.Exec(ctx,
+		`UPDATE messages
+		SET reactions = jsonb_set(
+			coalesce(reactions, '{}'::jsonb),
+			ARRAY[$1::text],
+			(coalesce(reactions->$1, '[]'::jsonb) - $2::text) || jsonb_build_array($2::text)
+		),
+		updated_at = $3
+		WHERE id = $4 AND created_at = $5
+		AND (
+			coalesce(reactions->$1, '[]'::jsonb) ? $2::text
+			OR (SELECT COUNT(*) FROM jsonb_each(coalesce(reactions, '{}'::jsonb)) r WHERE r.value ? $2::text) < $6
+		)`,
+		emoji, userID.String(), at, ref.ID, ref.CreatedAt, maxPerUser,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *PostgresRepository) RemoveReaction(ctx context.Context, ref *MessageRef, userID uuid.UUID, emoji string, at time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE messages
+		SET reactions = jsonb_set(
+			reactions,
+			ARRAY[$1::text],
+			(reactions->$1) - $2::text
+		),
+		updated_at = $3
+		WHERE id = $4 AND created_at = $5`,
+		emoji, userID.String(), at, ref.ID, ref.CreatedAt,
+	)
+	return err
+}
+
+func (r *PostgresRepository) SetPinned(ctx context.Context, messageID uuid.UUID, pinned bool, at time.Time) error {
+	_, err := r.db.Exec(ctx,
+		`UPDATE messages SET is_pinned = $1, updated_at = $2 WHERE id = $3`,
+		pinned, at, messageID,
+	)
+	return err
+}
+
+func (r *PostgresRepository) ChannelCommunity(ctx context.Context, channelID uuid.UUID) (uuid.UUID, error) {
+	var communityID uuid.UUID
+	err := r.db.QueryRow(ctx, `SELECT community_id FROM channels WHERE id = $1`, channelID).Scan(&communityID)
+	return communityID, err
+}
+
+func (r *PostgresRepository) IsQuarantined(ctx context.Context, channelID, userID uuid.UUID) (bool, error) {
+	var quarantined bool
+	err := r.db.QueryRow(ctx,
+		`SELECT cm.quarantined_at IS NOT NULL
+		FROM channels c
+		JOIN community_members cm ON cm.community_id = c.community_id AND cm.user_id = $2
+		WHERE c.id = $1`,
+		channelID, userID,
+	).Scan(&quarantined)
+	return quarantined, err
+}
+
+func (r *PostgresRepository) QuarantineModerators(ctx context.Context, channelID uuid.UUID) ([]uuid.UUID, error) {
+	modPerms := models.PermissionAdministrator | models.PermissionManageMessages
+	rows, err := r.db.Query(ctx,
+		`SELECT co.owner_id FROM channels c
+		JOIN communities co ON co.id = c.community_id
+		WHERE c.id = $1
+		UNION
+		SELECT cm.user_id FROM channels c
+		JOIN community_members cm ON cm.community_id = c.community_id
+		JOIN member_roles mr ON mr.member_id = cm.id
+		JOIN roles r ON r.id = mr.role_id
+		WHERE c.id = $1 AND (r.permissions & $2) <> 0`,
+		channelID, modPerms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r *PostgresRepository) RecordDecryptionFailure(ctx context.Context, ref messaging.ContentRef, keyVersion string, cause error) error {
+	return messaging.RecordDecryptionFailure(ctx, r.db, ref, keyVersion, cause)
+}
@@ -9,13 +9,19 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/antispam"
+	"github.com/zentra/server/internal/services/automod"
+	"github.com/zentra/server/internal/services/eventhook"
 	"github.com/zentra/server/internal/services/messaging"
 	"github.com/zentra/server/internal/services/notification"
+	"github.com/zentra/server/internal/services/presence"
+	"github.com/zentra/server/internal/services/recency"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
 )
 
 var (
@@ -24,14 +30,33 @@ var (
 	ErrNotMessageOwner   = errors.New("not message owner")
 	ErrCannotEdit        = errors.New("cannot edit this message")
 	ErrInvalidReaction   = errors.New("invalid reaction")
+	ErrInvalidAttachment = errors.New("invalid attachment")
+	ErrBlockedByAutoMod  = errors.New("message blocked by automod")
+	ErrRemovedByAutoMod  = errors.New("message removed by automod")
+	ErrSlowDown          = errors.New("sending messages too quickly")
+	ErrDuplicateMessage  = errors.New("duplicate message")
+	ErrVerificationLevel = errors.New("account does not meet the verification level")
+	ErrTooManyReactions  = errors.New("too many reactions on this message")
+
+	ErrChannelUnavailable = errors.New("channel is archived or outside the community")
+	ErrInvalidReply       = errors.New("replied-to message is not in this channel")
 )
 
 type Service struct {
-	db                  *pgxpool.Pool
+	repo                Repository
 	redis               *redis.Client
 	channelService      ChannelServiceInterface
 	notificationService *notification.Service
+	presenceService     *presence.Service
+	automodService      *automod.Service
+	antispamService     *antispam.Service
+	recencyService      *recency.Service
+	eventHookService    *eventhook.Service
 	cipher              messaging.ContentCipher
+	urlSigner           *messaging.URLSigner
+	camo                *messaging.Camo
+	search              *messaging.SearchIndex
+	history             *messaging.HistoryCache
 }
 
 type ChannelServiceInterface interface {
@@ -40,17 +65,26 @@ type ChannelServiceInterface interface {
 	CanManageMessages(ctx context.Context, channelID, userID uuid.UUID) bool
 	CanPinMessages(ctx context.Context, channelID, userID uuid.UUID) bool
 	CanMentionEveryone(ctx context.Context, channelID, userID uuid.UUID) bool
+	RecordInteraction(ctx context.Context, channelID, userID uuid.UUID, messageID *uuid.UUID) error
 }
 
-func NewService(db *pgxpool.Pool, redis *redis.Client, encryptionKey []byte, channelService ChannelServiceInterface) *Service {
+func NewService(db *pgxpool.Pool, redis *redis.Client, keys *encryption.Keyring, channelService ChannelServiceInterface, presenceService *presence.Service, automodService *automod.Service, antispamService *antispam.Service) *Service {
 	return &Service{
-		db:             db,
-		redis:          redis,
-		channelService: channelService,
-		cipher:         messaging.NewChannelCipher(encryptionKey),
+		repo:            NewPostgresRepository(db),
+		redis:           redis,
+		channelService:  channelService,
+		presenceService: presenceService,
+		automodService:  automodService,
+		antispamService: antispamService,
+		cipher:          messaging.NewChannelCipher(keys),
 	}
 }
 
+// SetRepository replaces the PostgreSQL storage, e.g. with a fake in tests
+func (s *Service) SetRepository(repo Repository) {
+	s.repo = repo
+}
+
 // SetNotificationService wires the notification service into the message service after
 // both have been created (the hub is needed by the notification service, which is
 // initialised after the message service in main).
@@ -58,15 +92,84 @@ func (s *Service) SetNotificationService(ns *notification.Service) {
 	s.notificationService = ns
 }
 
+// SetRecencyService enables recording channel interactions for quick switcher ranking.
+func (s *Service) SetRecencyService(rs *recency.Service) {
+	s.recencyService = rs
+}
+
+// SetEventHookService enables delivering message events to community event hooks.
+func (s *Service) SetEventHookService(es *eventhook.Service) {
+	s.eventHookService = es
+}
+
+// SetURLSigner hands out attachments as signed, expiring links.
+func (s *Service) SetURLSigner(signer *messaging.URLSigner) {
+	s.urlSigner = signer
+}
+
+// SetCamo rewrites the preview images of returned messages to image proxy URLs.
+func (s *Service) SetCamo(c *messaging.Camo) {
+	s.camo = c
+}
+
+// SetSearchIndex stores search tokens with new and edited messages and
+// matches searches against them. Without it messages are left for the
+// backfill and searches find nothing.
+func (s *Service) SetSearchIndex(i *messaging.SearchIndex) {
+	s.search = i
+}
+
+// SetHistoryCache serves the newest page of channel history from c. Without
+// it history is always read from the repository.
+func (s *Service) SetHistoryCache(c *messaging.HistoryCache) {
+	s.history = c
+}
+
+// eventView is resp with attachment links that aren't tied to the member who
+// fetched it, for events sent to everyone in the channel
+func (s *Service) eventView(resp *MessageResponse) *MessageResponse {
+	if s.urlSigner == nil || len(resp.Attachments) == 0 {
+		return resp
+	}
+	view := *resp
+	view.Attachments = s.urlSigner.SignAttachments(resp.Attachments, uuid.Nil)
+	return &view
+}
+
+func (s *Service) dispatchEvent(ctx context.Context, channelID uuid.UUID, eventType string, data any) {
+	if s.eventHookService != nil {
+		s.eventHookService.DispatchForChannel(ctx, channelID, eventType, data)
+	}
+}
+
+// touchRecency marks the channel and its community as just used by userID
+func (s *Service) touchRecency(ctx context.Context, userID, channelID uuid.UUID) {
+	if s.recencyService == nil {
+		return
+	}
+
+	refs := []recency.Ref{{Kind: recency.KindChannel, ID: channelID}}
+	if communityID, err := s.repo.ChannelCommunity(ctx, channelID); err == nil {
+		refs = append(refs, recency.Ref{Kind: recency.KindCommunity, ID: communityID})
+	}
+	s.recencyService.Touch(ctx, userID, refs...)
+}
+
 // Request/Response types
 type CreateMessageRequest struct {
 	Content     string      `json:"content" validate:"required_without=Attachments,max=4000"`
 	ReplyToID   *uuid.UUID  `json:"replyToId,omitempty"`
 	Attachments []uuid.UUID `json:"attachments,omitempty" validate:"max=10"`
+	// Optional label clients show instead of the content until it is expanded.
+	ContentWarning *string `json:"contentWarning,omitempty" validate:"omitempty,max=100"`
+	// Optional per-attachment spoiler/alt text, keyed by attachment ID.
+	AttachmentOptions []models.AttachmentOptions `json:"attachmentOptions,omitempty" validate:"max=10,dive"`
 }
 
 type UpdateMessageRequest struct {
 	Content string `json:"content" validate:"required,max=4000"`
+	// ContentWarning replaces the message's warning when set; "" removes it.
+	ContentWarning *string `json:"contentWarning,omitempty" validate:"omitempty,max=100"`
 }
 
 type MessageResponse struct {
@@ -78,10 +181,11 @@ type MessageResponse struct {
 }
 
 type MessageReplyPreview struct {
-	ID       uuid.UUID          `json:"id"`
-	Content  string             `json:"content"`
-	AuthorID uuid.UUID          `json:"authorId"`
-	Author   *models.PublicUser `json:"author"`
+	ID             uuid.UUID          `json:"id"`
+	Content        string             `json:"content"`
+	ContentWarning *string            `json:"contentWarning,omitempty"`
+	AuthorID       uuid.UUID          `json:"authorId"`
+	Author         *models.PublicUser `json:"author"`
 }
 
 type ReactionSummary struct {
@@ -120,7 +224,7 @@ func (s *Service) broadcast(ctx context.Context, channelID string, eventType str
 		return
 	}
 
-	err = s.redis.Publish(ctx, "websocket:broadcast", jsonData).Err()
+	err = database.PublishBroadcast(ctx, jsonData)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to publish message broadcast to Redis")
 	}
@@ -132,188 +236,169 @@ func (s *Service) CreateMessage(ctx context.Context, channelID, userID uuid.UUID
 		return nil, ErrInsufficientPerms
 	}
 
-	linkPreviews := messaging.BuildLinkPreviews(ctx, req.Content)
-	linkPreviewJSON := messaging.EncodeLinkPreviews(linkPreviews)
+	if err := s.checkSpam(ctx, channelID, userID, req.Content); err != nil {
+		return nil, err
+	}
 
-	// Encrypt message content
-	encryptedContent, _, err := s.cipher.Encrypt(req.Content)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encrypt message: %w", err)
+	if req.ReplyToID != nil {
+		if err := s.checkReplyTarget(ctx, channelID, userID, *req.ReplyToID); err != nil {
+			return nil, err
+		}
 	}
 
-	messageID := uuid.New()
-	now := time.Now()
+	// AutoMod runs before anything is written so blocked messages never reach the DB
+	verdict := s.runAutoMod(ctx, channelID, userID, req.Content)
 
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		return nil, err
+	// Quarantined members' messages are stored hidden from everyone else
+	m := &NewMessage{
+		ChannelID:         channelID,
+		AuthorID:          userID,
+		ContentWarning:    messaging.ContentWarningOrNil(req.ContentWarning),
+		ReplyToID:         req.ReplyToID,
+		Quarantined:       s.isQuarantined(ctx, channelID, userID),
+		Attachments:       req.Attachments,
+		AttachmentOptions: req.AttachmentOptions,
 	}
-	defer tx.Rollback(ctx)
-
-	// Insert message
-	query := `
-		INSERT INTO messages (id, channel_id, author_id, encrypted_content, reply_to_id, link_previews, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7, $7)
-		RETURNING id, channel_id, author_id, encrypted_content, reply_to_id, link_previews, is_pinned, is_edited, created_at, updated_at`
-
-	var msg models.Message
-	var encContent []byte
-	var linkPreviewRaw []byte
-	err = tx.QueryRow(ctx, query,
-		messageID, channelID, userID, encryptedContent, req.ReplyToID, string(linkPreviewJSON), now,
-	).Scan(
-		&msg.ID, &msg.ChannelID, &msg.AuthorID, &encContent,
-		&msg.ReplyToID, &linkPreviewRaw, &msg.IsPinned, &msg.IsEdited, &msg.CreatedAt, &msg.UpdatedAt,
-	)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to insert message")
+	if err := s.store(ctx, m, req.Content, verdict); err != nil {
 		return nil, err
 	}
-	msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
 
-	// Decrypt for response
-	contentStr, err := s.cipher.Decrypt(encContent, nil)
+	// Fetch complete response
+	resp, err := s.GetMessage(ctx, m.ID, userID)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to decrypt message after insert")
+		log.Error().Err(err).Msg("Failed to fetch message after creation")
 		return nil, err
 	}
-	msg.Content = &contentStr
 
-	// Link attachments to message
-	if len(req.Attachments) > 0 {
-		for _, attachmentID := range req.Attachments {
-			_, err = tx.Exec(ctx,
-				`UPDATE message_attachments SET message_id = $1, message_created_at = $2 WHERE id = $3`,
-				messageID, now, attachmentID,
-			)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to link attachment")
-				return nil, err
-			}
-		}
+	s.announce(ctx, m, resp, req.Content, s.channelService.CanMentionEveryone(ctx, channelID, userID))
+
+	if err := s.channelService.RecordInteraction(ctx, channelID, userID, &m.ID); err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("Failed to record channel interaction")
 	}
+	s.touchRecency(ctx, userID, channelID)
 
-	// Update channel's last message
-	_, err = tx.Exec(ctx,
-		`UPDATE channels SET last_message_at = $1 WHERE id = $2`,
-		now, channelID,
-	)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to update channel last_message_at")
-		return nil, err
+	return resp, nil
+}
+
+// store encrypts and stores a new message that AutoMod gave verdict on,
+// filling in m's ID, content, search tokens and, unless set, link previews. Blocked messages are never
+// written; ones AutoMod deletes are stored deleted for review and return
+// ErrRemovedByAutoMod.
+func (s *Service) store(ctx context.Context, m *NewMessage, content string, verdict *automod.Verdict) error {
+	if verdict != nil && verdict.Action == models.AutoModActionBlock {
+		s.automodService.LogVerdict(ctx, verdict, nil)
+		return ErrBlockedByAutoMod
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		log.Error().Err(err).Msg("Failed to commit message transaction")
-		return nil, err
+	if m.ID == uuid.Nil {
+		m.ID, m.CreatedAt = messaging.NewMessageID()
 	}
 
-	// Fetch complete response
-	resp, err := s.GetMessage(ctx, messageID, userID)
+	// Encrypt message content
+	encryptedContent, _, err := s.cipher.Encrypt(messaging.ChannelRow(m.ID), content)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to fetch message after creation")
-		return nil, err
+		return fmt.Errorf("failed to encrypt message: %w", err)
+	}
+
+	// Auto-deleted messages are still stored (already deleted) so moderators can review them
+	if verdict != nil && verdict.Action == models.AutoModActionDelete {
+		deletedAt := m.CreatedAt
+		m.DeletedAt = &deletedAt
+	}
+
+	m.EncryptedContent = encryptedContent
+	if m.LinkPreviews == nil {
+		m.LinkPreviews = messaging.BuildLinkPreviews(ctx, content)
 	}
+	m.SearchTokens = s.search.Tokens(m.ChannelID, content)
+	if err := s.repo.Create(ctx, m); err != nil {
+		if errors.Is(err, messaging.ErrUnknownAttachment) {
+			return ErrInvalidAttachment
+		}
+		if errors.Is(err, ErrChannelUnavailable) {
+			return err
+		}
+		log.Error().Err(err).Msg("Failed to store message")
+		return err
+	}
+	s.history.Invalidate(ctx, m.ChannelID)
+
+	if verdict != nil {
+		s.automodService.LogVerdict(ctx, verdict, &m.ID)
+		if m.DeletedAt != nil {
+			return ErrRemovedByAutoMod
+		}
+	}
+	return nil
+}
 
+// announce broadcasts a stored message, hands it to event hooks and queues
+// its mention and reply notifications
+func (s *Service) announce(ctx context.Context, m *NewMessage, resp *MessageResponse, content string, canMentionEveryone bool) {
 	// Broadcast to WebSocket clients
-	s.broadcast(ctx, channelID.String(), "MESSAGE_CREATE", resp)
+	event := s.eventView(resp)
+	s.broadcastMessage(ctx, "MESSAGE_CREATE", event, m.Quarantined)
+	if !m.Quarantined {
+		s.dispatchEvent(ctx, m.ChannelID, models.EventHookMessageCreate, event)
+	}
 
 	// Dispatch mention and reply notifications asynchronously.
-	if s.notificationService != nil && req.Content != "" {
+	if s.notificationService != nil && content != "" && !m.Quarantined {
 		var replyToAuthorID *uuid.UUID
 		if resp.ReplyTo != nil {
 			replyToAuthorID = &resp.ReplyTo.AuthorID
 		}
-		canMention := s.channelService.CanMentionEveryone(ctx, channelID, userID)
 		mctx := notification.MentionContext{
-			ChannelID:          channelID,
-			MessageID:          messageID,
-			MessageCreatedAt:   now,
-			AuthorID:           userID,
-			Content:            req.Content,
+			ChannelID:          m.ChannelID,
+			MessageID:          m.ID,
+			MessageCreatedAt:   m.CreatedAt,
+			AuthorID:           m.AuthorID,
+			Content:            content,
+			ContentWarning:     m.ContentWarning,
 			ReplyToAuthorID:    replyToAuthorID,
-			CanMentionEveryone: canMention,
+			CanMentionEveryone: canMentionEveryone,
 		}
 		go s.notificationService.ProcessMessageMentions(mctx)
 	}
-
-	return resp, nil
 }
 
 // GetMessage retrieves a single message
 func (s *Service) GetMessage(ctx context.Context, messageID, userID uuid.UUID) (*MessageResponse, error) {
-	query := `
-		SELECT m.id, m.channel_id, m.author_id, m.encrypted_content, m.reply_to_id,
-		       m.link_previews, m.is_pinned, m.is_edited, m.reactions, m.created_at, m.updated_at,
-		       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-		FROM messages m
-		JOIN users u ON u.id = m.author_id
-		WHERE m.id = $1 AND m.deleted_at IS NULL`
-
-	var msg models.Message
-	var encContent []byte
-	var linkPreviewRaw []byte
-	var author models.PublicUser
-
-	err := s.db.QueryRow(ctx, query, messageID).Scan(
-		&msg.ID, &msg.ChannelID, &msg.AuthorID, &encContent,
-		&msg.ReplyToID, &linkPreviewRaw, &msg.IsPinned, &msg.IsEdited, &msg.Reactions, &msg.CreatedAt, &msg.UpdatedAt,
-		&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
-	)
+	stored, err := s.repo.Get(ctx, messageID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrMessageNotFound
+		if !errors.Is(err, ErrMessageNotFound) {
+			log.Error().Err(err).Msg("Failed to load message in GetMessage")
 		}
-		log.Error().Err(err).Msg("Failed to scan message in GetMessage")
 		return nil, err
 	}
+	msg := &stored.Message
 
 	// Check access
 	if !s.channelService.CanAccessChannel(ctx, msg.ChannelID, userID) {
 		return nil, ErrInsufficientPerms
 	}
 
-	// Decrypt content
-	contentStr, err := s.cipher.Decrypt(encContent, nil)
-	if err != nil {
-		contentErr := "[Decryption Error]"
-		msg.Content = &contentErr
-	} else {
-		msg.Content = &contentStr
+	// Quarantined messages only exist for their author and moderators
+	if msg.IsQuarantined && !s.channelService.CanManageMessages(ctx, msg.ChannelID, userID) {
+		if msg.AuthorID != userID {
+			return nil, ErrMessageNotFound
+		}
+		msg.IsQuarantined = false
 	}
-	msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
 
-	response := &MessageResponse{
-		Message: &msg,
-		Author:  &author,
-	}
+	response := s.decrypt(stored)
 
 	// Fetch attachments
-	response.Attachments, _ = s.getMessageAttachments(ctx, messageID)
+	attachments, _ := s.repo.Attachments(ctx, []uuid.UUID{messageID})
+	response.Attachments = s.urlSigner.SignAttachments(attachments[messageID], userID)
 
 	// Fetch reactions (now from the JSONB field)
-	response.Reactions = make([]ReactionSummary, 0)
-	for emoji, users := range msg.Reactions {
-		if len(users) > 0 {
-			reacted := false
-			for _, u := range users {
-				if u == userID {
-					reacted = true
-					break
-				}
-			}
-			response.Reactions = append(response.Reactions, ReactionSummary{
-				Emoji:   emoji,
-				Count:   len(users),
-				Users:   users,
-				Reacted: reacted,
-			})
-		}
-	}
+	response.Reactions = reactionSummaries(msg.Reactions, userID)
 
 	// Fetch reply preview if exists
 	if msg.ReplyToID != nil {
-		response.ReplyTo, _ = s.getReplyPreview(ctx, *msg.ReplyToID)
+		v := Visibility{ViewerID: userID, AllQuarantined: s.channelService.CanManageMessages(ctx, msg.ChannelID, userID)}
+		response.ReplyTo, _ = s.getReplyPreview(ctx, msg.ChannelID, *msg.ReplyToID, v)
 	}
 
 	return response, nil
@@ -325,167 +410,224 @@ func (s *Service) GetChannelMessages(ctx context.Context, channelID, userID uuid
 		return nil, ErrInsufficientPerms
 	}
 
-	limit := params.Limit
-	if limit <= 0 || limit > 100 {
-		limit = 50
-	}
-
-	var query string
-	var args []interface{}
-
-	if params.Before != nil {
-		query = `
-			SELECT m.id, m.channel_id, m.author_id, m.encrypted_content, m.reply_to_id,
-			       m.link_previews, m.is_pinned, m.is_edited, m.reactions, m.created_at, m.updated_at,
-			       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-			FROM messages m
-			JOIN users u ON u.id = m.author_id
-			WHERE m.channel_id = $1 AND m.deleted_at IS NULL
-			  AND m.created_at < (SELECT created_at FROM messages WHERE id = $2)
-			ORDER BY m.created_at DESC
-			LIMIT $3`
-		args = []interface{}{channelID, *params.Before, limit}
-	} else if params.After != nil {
-		query = `
-			SELECT m.id, m.channel_id, m.author_id, m.encrypted_content, m.reply_to_id,
-			       m.link_previews, m.is_pinned, m.is_edited, m.reactions, m.created_at, m.updated_at,
-			       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-			FROM messages m
-			JOIN users u ON u.id = m.author_id
-			WHERE m.channel_id = $1 AND m.deleted_at IS NULL
-			  AND m.created_at > (SELECT created_at FROM messages WHERE id = $2)
-			ORDER BY m.created_at ASC
-			LIMIT $3`
-		args = []interface{}{channelID, *params.After, limit}
-	} else {
-		query = `
-			SELECT m.id, m.channel_id, m.author_id, m.encrypted_content, m.reply_to_id,
-			       m.link_previews, m.is_pinned, m.is_edited, m.reactions, m.created_at, m.updated_at,
-			       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-			FROM messages m
-			JOIN users u ON u.id = m.author_id
-			WHERE m.channel_id = $1 AND m.deleted_at IS NULL
-			ORDER BY m.created_at DESC
-			LIMIT $2`
-		args = []interface{}{channelID, limit}
-	}
-
-	rows, err := s.db.Query(ctx, query, args...)
+	limit := messaging.HistoryLimit(params.Limit)
+
+	canModerate := s.channelService.CanManageMessages(ctx, channelID, userID)
+
+	if params.Before == nil && params.After == nil {
+		if messages, ok := s.recentMessages(ctx, channelID, userID, canModerate, limit); ok {
+			return messages, nil
+		}
+	}
+
+	stored, err := s.repo.History(ctx, HistoryQuery{
+		ChannelID:  channelID,
+		Before:     params.Before,
+		After:      params.After,
+		Limit:      limit,
+		Visibility: Visibility{ViewerID: userID, AllQuarantined: canModerate},
+	})
 	if err != nil {
+		log.Error().Err(err).Msg("Failed to load messages in GetChannelMessages")
 		return nil, err
 	}
-	defer rows.Close()
+	messages := s.withDetails(ctx, channelID, stored, Visibility{ViewerID: userID, AllQuarantined: canModerate})
+	for _, m := range messages {
+		m.Attachments = s.urlSigner.SignAttachments(m.Attachments, userID)
+		m.Reactions = reactionSummaries(m.Message.Reactions, userID)
+	}
 
-	var messages []*MessageResponse
-	messageIDs := make([]uuid.UUID, 0)
-
-	for rows.Next() {
-		var msg models.Message
-		var encContent []byte
-		var linkPreviewRaw []byte
-		var author models.PublicUser
-
-		err := rows.Scan(
-			&msg.ID, &msg.ChannelID, &msg.AuthorID, &encContent,
-			&msg.ReplyToID, &linkPreviewRaw, &msg.IsPinned, &msg.IsEdited, &msg.Reactions, &msg.CreatedAt, &msg.UpdatedAt,
-			&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
-		)
+	hideQuarantineFlag(messages, canModerate)
+
+	return messages, nil
+}
+
+// cachedMessage is a message as kept in the channel history cache, before
+// anything that depends on the viewer is applied
+type cachedMessage struct {
+	Message     *models.Message            `json:"message"`
+	Author      *models.PublicUser         `json:"author"`
+	Attachments []models.MessageAttachment `json:"attachments,omitempty"`
+	ReplyTo     *MessageReplyPreview       `json:"replyTo,omitempty"`
+}
+
+// recentMessages serves the newest page of a channel from the history cache.
+// It reports false when there is no cache, or when leaving out the quarantined
+// messages the user can't see leaves fewer than a page of cached ones.
+func (s *Service) recentMessages(ctx context.Context, channelID, userID uuid.UUID, canModerate bool, limit int) ([]*MessageResponse, bool) {
+	cached, ok, err := messaging.CachedHistory(ctx, s.history, channelID, func(ctx context.Context) ([]*cachedMessage, error) {
+		stored, err := s.repo.History(ctx, HistoryQuery{
+			ChannelID:  channelID,
+			Limit:      messaging.HistoryCacheSize,
+			Visibility: Visibility{AllQuarantined: true},
+		})
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to scan message in GetChannelMessages")
 			return nil, err
 		}
-
-		// Decrypt content
-		contentStr, err := s.cipher.Decrypt(encContent, nil)
-		if err != nil {
-			errStr := "[Decryption Error]"
-			msg.Content = &errStr
-		} else {
-			msg.Content = &contentStr
+		// The cache is shared by every viewer, so replies to quarantined
+		// messages go without a preview
+		recent := s.withDetails(ctx, channelID, stored, Visibility{})
+		items := make([]*cachedMessage, len(recent))
+		for i, m := range recent {
+			items[i] = &cachedMessage{Message: m.Message, Author: m.Author, Attachments: m.Attachments, ReplyTo: m.ReplyTo}
 		}
-		msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
+		return items, nil
+	})
+	if !ok {
+		return nil, false
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("Failed to load channel history for the cache")
+		return nil, false
+	}
 
+	var messages []*MessageResponse
+	for _, c := range cached {
+		if c.Message.IsQuarantined && c.Message.AuthorID != userID && !canModerate {
+			continue
+		}
+		if len(messages) == limit {
+			break
+		}
 		messages = append(messages, &MessageResponse{
-			Message: &msg,
-			Author:  &author,
+			Message:     c.Message,
+			Author:      c.Author,
+			Attachments: s.urlSigner.SignAttachments(c.Attachments, userID),
+			Reactions:   reactionSummaries(c.Message.Reactions, userID),
+			ReplyTo:     c.ReplyTo,
 		})
-		messageIDs = append(messageIDs, msg.ID)
+	}
+	if len(messages) < limit && len(cached) == messaging.HistoryCacheSize {
+		return nil, false
 	}
 
-	// Batch fetch attachments
-	if len(messageIDs) > 0 {
-		attachmentMap := s.batchGetAttachments(ctx, messageIDs)
+	hideQuarantineFlag(messages, canModerate)
+	return messages, true
+}
 
-		for _, m := range messages {
-			if attachments, ok := attachmentMap[m.ID]; ok {
-				m.Attachments = attachments
-			}
+// withDetails decrypts stored messages and loads their attachments, unsigned,
+// and the reply previews v can see. Failures to load either are logged and
+// leave them out.
+func (s *Service) withDetails(ctx context.Context, channelID uuid.UUID, stored []*StoredMessage, v Visibility) []*MessageResponse {
+	messages := s.decryptAll(stored)
+	if len(messages) == 0 {
+		return messages
+	}
+
+	messageIDs := make([]uuid.UUID, len(messages))
+	var replyToIDs []uuid.UUID
+	for i, m := range messages {
+		messageIDs[i] = m.ID
+		if m.ReplyToID != nil {
+			replyToIDs = append(replyToIDs, *m.ReplyToID)
+		}
+	}
 
-			// Populate reactions from the JSONB field
-			m.Reactions = make([]ReactionSummary, 0)
-			for emoji, users := range m.Message.Reactions {
-				if len(users) > 0 {
-					reacted := false
-					for _, u := range users {
-						if u == userID {
-							reacted = true
-							break
-						}
-					}
-					m.Reactions = append(m.Reactions, ReactionSummary{
-						Emoji:   emoji,
-						Count:   len(users),
-						Users:   users,
-						Reacted: reacted,
-					})
-				}
-			}
+	// Attachments and reply previews for the whole page in one query each
+	attachments, err := s.repo.Attachments(ctx, messageIDs)
+	if err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("Failed to load attachments")
+	}
+	previews, err := s.getReplyPreviews(ctx, channelID, replyToIDs, v)
+	if err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("Failed to load reply previews")
+	}
+	for _, m := range messages {
+		m.Attachments = attachments[m.ID]
+		if m.ReplyToID != nil {
+			m.ReplyTo = previews[*m.ReplyToID]
+		}
+	}
+	return messages
+}
+
+// decrypt turns a stored message into a response without attachments,
+// reactions or reply preview
+func (s *Service) decrypt(stored *StoredMessage) *MessageResponse {
+	msg := &stored.Message
+	contentStr, _ := messaging.DecryptOrPlaceholderWith(s.repo, s.cipher, contentRef(msg), msg.EncryptedContent, nil)
+	msg.Content = &contentStr
+	msg.LinkPreviews = s.camo.LinkPreviews(msg.LinkPreviews)
+	return &MessageResponse{
+		Message: msg,
+		Author:  &stored.Author,
+	}
+}
+
+func (s *Service) decryptAll(stored []*StoredMessage) []*MessageResponse {
+	var messages []*MessageResponse
+	for _, sm := range stored {
+		messages = append(messages, s.decrypt(sm))
+	}
+	return messages
+}
 
-			if m.ReplyToID != nil {
-				m.ReplyTo, _ = s.getReplyPreview(ctx, *m.ReplyToID)
+// reactionSummaries turns a message's reactions JSONB into the summaries
+// returned to userID
+func reactionSummaries(reactions map[string][]uuid.UUID, userID uuid.UUID) []ReactionSummary {
+	summaries := make([]ReactionSummary, 0)
+	for emoji, users := range reactions {
+		if len(users) > 0 {
+			reacted := false
+			for _, u := range users {
+				if u == userID {
+					reacted = true
+					break
+				}
 			}
+			summaries = append(summaries, ReactionSummary{
+				Emoji:   emoji,
+				Count:   len(users),
+				Users:   users,
+				Reacted: reacted,
+			})
 		}
 	}
-
-	return messages, nil
+	return summaries
 }
 
 // UpdateMessage updates message content
 func (s *Service) UpdateMessage(ctx context.Context, messageID, userID uuid.UUID, req *UpdateMessageRequest) (*MessageResponse, error) {
 	// First check if user owns the message
-	var authorID uuid.UUID
-	err := s.db.QueryRow(ctx,
-		`SELECT author_id FROM messages WHERE id = $1 AND deleted_at IS NULL`,
-		messageID,
-	).Scan(&authorID)
+	target, err := s.repo.GetEditTarget(ctx, messageID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, ErrMessageNotFound
-		}
 		return nil, err
 	}
+	channelID, quarantined := target.ChannelID, target.Quarantined
 
-	if authorID != userID {
+	if target.AuthorID != userID {
 		return nil, ErrNotMessageOwner
 	}
+	if err := s.checkEditPolicy(ctx, target, userID); err != nil {
+		return nil, err
+	}
+
+	// Edits go through AutoMod too, otherwise a filter is one edit away from useless.
+	// Delete-action rules reject the edit rather than deleting the original.
+	if verdict := s.runAutoMod(ctx, channelID, userID, req.Content); verdict != nil {
+		s.automodService.LogVerdict(ctx, verdict, &messageID)
+		if verdict.Action != models.AutoModActionFlag {
+			return nil, ErrBlockedByAutoMod
+		}
+	}
 
 	// Encrypt new content
-	encryptedContent, _, err := s.cipher.Encrypt(req.Content)
+	encryptedContent, _, err := s.cipher.Encrypt(messaging.ChannelRow(messageID), req.Content)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt message: %w", err)
 	}
 
-	now := time.Now()
-	linkPreviews := messaging.BuildLinkPreviews(ctx, req.Content)
-	linkPreviewJSON := messaging.EncodeLinkPreviews(linkPreviews)
-
-	_, err = s.db.Exec(ctx,
-		`UPDATE messages SET encrypted_content = $1, link_previews = $2::jsonb, is_edited = TRUE, updated_at = $3 WHERE id = $4`,
-		encryptedContent, string(linkPreviewJSON), now, messageID,
-	)
+	err = s.repo.UpdateContent(ctx, messageID, &ContentEdit{
+		EncryptedContent: encryptedContent,
+		LinkPreviews:     messaging.BuildLinkPreviews(ctx, req.Content),
+		ContentWarning:   messaging.NormalizeContentWarning(req.ContentWarning),
+		SearchTokens:     s.search.Tokens(channelID, req.Content),
+		UpdatedAt:        time.Now(),
+	})
 	if err != nil {
 		return nil, err
 	}
+	s.history.Invalidate(ctx, channelID)
 
 	resp, err := s.GetMessage(ctx, messageID, userID)
 	if err != nil {
@@ -493,48 +635,51 @@ func (s *Service) UpdateMessage(ctx context.Context, messageID, userID uuid.UUID
 	}
 
 	// Broadcast update
-	s.broadcast(ctx, resp.ChannelID.String(), "MESSAGE_UPDATE", resp)
+	event := s.eventView(resp)
+	s.broadcastMessage(ctx, "MESSAGE_UPDATE", event, quarantined)
+	if !quarantined {
+		s.dispatchEvent(ctx, channelID, models.EventHookMessageUpdate, event)
+	}
 
 	return resp, nil
 }
 
 // DeleteMessage soft-deletes a message
 func (s *Service) DeleteMessage(ctx context.Context, messageID, userID uuid.UUID, hasModPerm bool) error {
-	var authorID, channelID uuid.UUID
-	err := s.db.QueryRow(ctx,
-		`SELECT author_id, channel_id FROM messages WHERE id = $1 AND deleted_at IS NULL`,
-		messageID,
-	).Scan(&authorID, &channelID)
+	ref, err := s.repo.Locate(ctx, messageID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrMessageNotFound
-		}
 		return err
 	}
+	channelID := ref.ChannelID
 
 	// User can delete if they own the message or have mod permissions
-	if authorID != userID && !hasModPerm {
+	if ref.AuthorID != userID && !hasModPerm {
 		return ErrInsufficientPerms
 	}
 
-	_, err = s.db.Exec(ctx,
-		`UPDATE messages SET deleted_at = $1 WHERE id = $2`,
-		time.Now(), messageID,
-	)
-	if err != nil {
+	if err := s.repo.Delete(ctx, messageID, time.Now()); err != nil {
 		return err
 	}
+	s.history.Invalidate(ctx, channelID)
 
 	// Broadcast delete
-	s.broadcast(ctx, channelID.String(), "MESSAGE_DELETE", map[string]interface{}{
+	deleted := map[string]interface{}{
 		"channelId": channelID.String(),
 		"messageId": messageID.String(),
-	})
+	}
+	s.broadcastFor(ctx, ref, "MESSAGE_DELETE", deleted)
+	if !ref.Quarantined {
+		s.dispatchEvent(ctx, channelID, models.EventHookMessageDelete, deleted)
+	}
 
 	return nil
 }
 
 // AddReaction adds a reaction to a message
+type AddReactionRequest struct {
+	Emoji string `json:"emoji" validate:"required"`
+}
+
 func (s *Service) AddReaction(ctx context.Context, messageID, userID uuid.UUID, emoji string) error {
 	emoji = strings.TrimSpace(emoji)
 	if len(emoji) == 0 || len(emoji) > 128 {
@@ -542,125 +687,101 @@ func (s *Service) AddReaction(ctx context.Context, messageID, userID uuid.UUID,
 	}
 
 	// Verify message exists and user can access
-	var channelID uuid.UUID
-	var createdAt time.Time
-	err := s.db.QueryRow(ctx,
-		`SELECT channel_id, created_at FROM messages WHERE id = $1 AND deleted_at IS NULL`,
-		messageID,
-	).Scan(&channelID, &createdAt)
+	ref, err := s.repo.Locate(ctx, messageID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrMessageNotFound
-		}
 		return err
 	}
+	channelID := ref.ChannelID
 
 	if !s.channelService.CanAccessChannel(ctx, channelID, userID) {
 		return ErrInsufficientPerms
 	}
+	if !s.canSee(ctx, ref, userID) {
+		return ErrMessageNotFound
+	}
+	if err := messaging.CheckReactionRate(ctx, s.redis, userID); err != nil {
+		return err
+	}
+	if err := s.checkReactionSpam(ctx, channelID, userID); err != nil {
+		return err
+	}
 
-	query := `
-		UPDATE messages
-		SET reactions = jsonb_set(
-			coalesce(reactions, '{}'::jsonb),
-			ARRAY[$1::text],
-			(coalesce(reactions->$1, '[]'::jsonb) - $2::text) || jsonb_build_array($2::text)
-		),
-		updated_at = $3
-		WHERE id = $4 AND created_at = $5`
-
-	_, err = s.db.Exec(ctx, query, emoji, userID.String(), time.Now(), messageID, createdAt)
+	added, err := s.repo.AddReaction(ctx, ref, userID, emoji, messaging.MaxReactionsPerUser, time.Now())
 	if err != nil {
 		return err
 	}
+	if !added {
+		return ErrTooManyReactions
+	}
+	s.history.Invalidate(ctx, channelID)
 
 	// Broadcast reaction add
-	s.broadcast(ctx, channelID.String(), "REACTION_ADD", map[string]interface{}{
+	reaction := map[string]interface{}{
 		"channelId": channelID.String(),
 		"messageId": messageID.String(),
 		"userId":    userID.String(),
 		"emoji":     emoji,
-	})
+	}
+	s.broadcastFor(ctx, ref, "REACTION_ADD", reaction)
+	if !ref.Quarantined {
+		s.dispatchEvent(ctx, channelID, models.EventHookReactionAdd, reaction)
+	}
 
 	return nil
 }
 
 // RemoveReaction removes a reaction from a message
 func (s *Service) RemoveReaction(ctx context.Context, messageID, userID uuid.UUID, emoji string) error {
-	// Need createdAt for partitioned table update
-	var channelID uuid.UUID
-	var createdAtTime time.Time
-	err := s.db.QueryRow(ctx,
-		`SELECT created_at, channel_id FROM messages WHERE id = $1 AND deleted_at IS NULL`,
-		messageID,
-	).Scan(&createdAtTime, &channelID)
+	// The reaction update needs the message's created_at to find its partition
+	ref, err := s.repo.Locate(ctx, messageID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrMessageNotFound
-		}
 		return err
 	}
+	channelID := ref.ChannelID
 
-	query := `
-		UPDATE messages
-		SET reactions = jsonb_set(
-			reactions,
-			ARRAY[$1::text],
-			(reactions->$1) - $2::text
-		),
-		updated_at = $3
-		WHERE id = $4 AND created_at = $5`
-
-	_, err = s.db.Exec(ctx, query, emoji, userID.String(), time.Now(), messageID, createdAtTime)
-	if err != nil {
+	if err := s.repo.RemoveReaction(ctx, ref, userID, emoji, time.Now()); err != nil {
 		return err
 	}
+	s.history.Invalidate(ctx, channelID)
 
 	// Broadcast reaction remove
-	s.broadcast(ctx, channelID.String(), "REACTION_REMOVE", map[string]interface{}{
+	reaction := map[string]interface{}{
 		"channelId": channelID.String(),
 		"messageId": messageID.String(),
 		"userId":    userID.String(),
 		"emoji":     emoji,
-	})
+	}
+	s.broadcastFor(ctx, ref, "REACTION_REMOVE", reaction)
+	if !ref.Quarantined {
+		s.dispatchEvent(ctx, channelID, models.EventHookReactionRemove, reaction)
+	}
 
 	return nil
 }
 
 // PinMessage pins/unpins a message
 func (s *Service) PinMessage(ctx context.Context, messageID, userID uuid.UUID, pin bool) error {
-	var channelID uuid.UUID
-	err := s.db.QueryRow(ctx,
-		`SELECT channel_id FROM messages WHERE id = $1 AND deleted_at IS NULL`,
-		messageID,
-	).Scan(&channelID)
+	ref, err := s.repo.Locate(ctx, messageID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return ErrMessageNotFound
-		}
 		return err
 	}
+	channelID, quarantined := ref.ChannelID, ref.Quarantined
 
 	if !s.channelService.CanPinMessages(ctx, channelID, userID) {
 		return ErrInsufficientPerms
 	}
 
-	updatedAt := time.Now()
-
-	_, err = s.db.Exec(ctx,
-		`UPDATE messages SET is_pinned = $1, updated_at = $2 WHERE id = $3`,
-		pin, updatedAt, messageID,
-	)
-	if err != nil {
+	if err := s.repo.SetPinned(ctx, messageID, pin, time.Now()); err != nil {
 		return err
 	}
+	s.history.Invalidate(ctx, channelID)
 
 	updatedMessage, err := s.GetMessage(ctx, messageID, userID)
 	if err != nil {
 		return err
 	}
 
-	s.broadcast(ctx, channelID.String(), "MESSAGE_UPDATE", updatedMessage)
+	s.broadcastMessage(ctx, "MESSAGE_UPDATE", s.eventView(updatedMessage), quarantined)
 
 	return nil
 }
@@ -671,54 +792,15 @@ func (s *Service) GetPinnedMessages(ctx context.Context, channelID, userID uuid.
 		return nil, ErrInsufficientPerms
 	}
 
-	query := `
-		SELECT m.id, m.channel_id, m.author_id, m.encrypted_content, m.reply_to_id,
-		       m.link_previews, m.is_pinned, m.is_edited, m.reactions, m.created_at, m.updated_at,
-		       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-		FROM messages m
-		JOIN users u ON u.id = m.author_id
-		WHERE m.channel_id = $1 AND m.is_pinned = true AND m.deleted_at IS NULL
-		ORDER BY m.created_at DESC
-		LIMIT 50`
-
-	rows, err := s.db.Query(ctx, query, channelID)
+	canModerate := s.channelService.CanManageMessages(ctx, channelID, userID)
+	stored, err := s.repo.Pinned(ctx, channelID, Visibility{ViewerID: userID, AllQuarantined: canModerate}, 50)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to query pinned messages")
 		return nil, err
 	}
-	defer rows.Close()
-
-	var messages []*MessageResponse
-	for rows.Next() {
-		var msg models.Message
-		var encContent []byte
-		var linkPreviewRaw []byte
-		var author models.PublicUser
-
-		err := rows.Scan(
-			&msg.ID, &msg.ChannelID, &msg.AuthorID, &encContent,
-			&msg.ReplyToID, &linkPreviewRaw, &msg.IsPinned, &msg.IsEdited, &msg.Reactions, &msg.CreatedAt, &msg.UpdatedAt,
-			&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
-		)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to scan pinned message")
-			return nil, err
-		}
 
-		contentStr, err := s.cipher.Decrypt(encContent, nil)
-		if err != nil {
-			errStr := "[Decryption Error]"
-			msg.Content = &errStr
-		} else {
-			msg.Content = &contentStr
-		}
-		msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
-
-		messages = append(messages, &MessageResponse{
-			Message: &msg,
-			Author:  &author,
-		})
-	}
+	messages := s.decryptAll(stored)
+	hideQuarantineFlag(messages, canModerate)
 
 	return messages, nil
 }
@@ -733,121 +815,76 @@ func (s *Service) SearchMessages(ctx context.Context, channelID, userID uuid.UUI
 		limit = 25
 	}
 
-	// Note: Searching encrypted content is complex. This is a simplified approach.
-	// In production, we might use a separate search index with encrypted tokens.
-	// For now, we'll fetch recent messages and filter client-side or use metadata.
-
-	// This query searches by author username as a simple example
-	query := `
-		SELECT m.id, m.channel_id, m.author_id, m.encrypted_content, m.reply_to_id,
-		       m.link_previews, m.is_pinned, m.created_at, m.updated_at, m.is_edited,
-		       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-		FROM messages m
-		JOIN users u ON u.id = m.author_id
-		WHERE m.channel_id = $1 AND m.deleted_at IS NULL
-		  AND u.username ILIKE '%' || $2 || '%'
-		ORDER BY m.created_at DESC
-		LIMIT $3`
+	// Content is encrypted, so it's matched through the keyed tokens stored
+	// with each message: a message matches when it has every word of the query
+	tokens := s.search.Tokens(channelID, searchQuery)
+	if len(tokens) == 0 {
+		return []*MessageResponse{}, nil
+	}
 
-	rows, err := s.db.Query(ctx, query, channelID, searchQuery, limit)
+	canModerate := s.channelService.CanManageMessages(ctx, channelID, userID)
+	stored, err := s.repo.Search(ctx, channelID, tokens, Visibility{ViewerID: userID, AllQuarantined: canModerate}, limit)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var messages []*MessageResponse
-	for rows.Next() {
-		var msg models.Message
-		var encContent []byte
-		var linkPreviewRaw []byte
-		var author models.PublicUser
-
-		err := rows.Scan(
-			&msg.ID, &msg.ChannelID, &msg.AuthorID, &encContent,
-			&msg.ReplyToID, &linkPreviewRaw, &msg.IsPinned, &msg.CreatedAt, &msg.UpdatedAt, &msg.IsEdited,
-			&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		contentStr, err := s.cipher.Decrypt(encContent, nil)
-		if err != nil {
-			errStr := "[Decryption Error]"
-			msg.Content = &errStr
-		} else {
-			msg.Content = &contentStr
-		}
-		msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
-
-		messages = append(messages, &MessageResponse{
-			Message: &msg,
-			Author:  &author,
-		})
-	}
+	messages := s.decryptAll(stored)
+	hideQuarantineFlag(messages, canModerate)
 
 	return messages, nil
 }
 
 // Helper functions
-func (s *Service) getMessageAttachments(ctx context.Context, messageID uuid.UUID) ([]models.MessageAttachment, error) {
-	query := `
-		SELECT id, message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, width, height, created_at
-		FROM message_attachments
-		WHERE message_id = $1`
+func contentRef(msg *models.Message) messaging.ContentRef {
+	return messaging.ContentRef{Kind: messaging.ContentKindChannel, ID: msg.ID, ContainerID: msg.ChannelID}
+}
 
-	rows, err := s.db.Query(ctx, query, messageID)
+// replyPreviewLength is how many characters of the replied-to message a
+// preview shows
+const replyPreviewLength = 100
+
+func (s *Service) getReplyPreview(ctx context.Context, channelID, messageID uuid.UUID, v Visibility) (*MessageReplyPreview, error) {
+	previews, err := s.getReplyPreviews(ctx, channelID, []uuid.UUID{messageID}, v)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var attachments []models.MessageAttachment
-	for rows.Next() {
-		var a models.MessageAttachment
-		err := rows.Scan(&a.ID, &a.MessageID, &a.MessageCreatedAt, &a.UploaderID, &a.Filename, &a.FileURL,
-			&a.FileSize, &a.ContentType, &a.ThumbnailURL, &a.Width, &a.Height, &a.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		attachments = append(attachments, a)
+	preview, ok := previews[messageID]
+	if !ok {
+		return nil, ErrMessageNotFound
 	}
-
-	return attachments, nil
+	return preview, nil
 }
 
-func (s *Service) getReplyPreview(ctx context.Context, messageID uuid.UUID) (*MessageReplyPreview, error) {
-	query := `
-		SELECT m.id, m.encrypted_content, m.author_id,
-		       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-		FROM messages m
-		JOIN users u ON u.id = m.author_id
-		WHERE m.id = $1`
-
-	var preview MessageReplyPreview
-	var encContent []byte
-	var author models.PublicUser
+// getReplyPreviews loads the previews of the messages a page of messages
+// replies to in one query, keyed by the replied-to message. Deleted messages,
+// ones in another channel and quarantined ones v can't see have no preview.
+func (s *Service) getReplyPreviews(ctx context.Context, channelID uuid.UUID, messageIDs []uuid.UUID, v Visibility) (map[uuid.UUID]*MessageReplyPreview, error) {
+	previews := make(map[uuid.UUID]*MessageReplyPreview, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return previews, nil
+	}
 
-	err := s.db.QueryRow(ctx, query, messageID).Scan(
-		&preview.ID, &encContent, &preview.AuthorID,
-		&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
-	)
+	stored, err := s.repo.ReplyPreviews(ctx, channelID, messageIDs, v)
 	if err != nil {
 		return nil, err
 	}
-
-	contentStr, err := s.cipher.Decrypt(encContent, nil)
-	if err != nil {
-		preview.Content = "[Decryption Error]"
-	} else {
-		if len(contentStr) > 100 {
-			contentStr = contentStr[:100] + "..."
+	for _, sm := range stored {
+		msg := &sm.Message
+		contentStr, ok := messaging.DecryptOrPlaceholderWith(s.repo, s.cipher, contentRef(msg), msg.EncryptedContent, nil)
+		if ok {
+			if runes := []rune(contentStr); len(runes) > replyPreviewLength {
+				contentStr = string(runes[:replyPreviewLength]) + "..."
+			}
+		}
+		previews[msg.ID] = &MessageReplyPreview{
+			ID:             msg.ID,
+			Content:        contentStr,
+			ContentWarning: msg.ContentWarning,
+			AuthorID:       msg.AuthorID,
+			Author:         &sm.Author,
 		}
-		preview.Content = contentStr
 	}
-	preview.Author = &author
-
-	return &preview, nil
+	return previews, nil
 }
 
 func (s *Service) CanManageMessages(ctx context.Context, channelID, userID uuid.UUID) bool {
@@ -858,79 +895,82 @@ func (s *Service) CanPinMessages(ctx context.Context, channelID, userID uuid.UUI
 	return s.channelService.CanPinMessages(ctx, channelID, userID)
 }
 
-func (s *Service) batchGetAttachments(ctx context.Context, messageIDs []uuid.UUID) map[uuid.UUID][]models.MessageAttachment {
-	result := make(map[uuid.UUID][]models.MessageAttachment)
+// checkSpam applies the community's burst, duplicate and verification limits.
+// Lookup failures are logged and let the message through.
+func (s *Service) checkSpam(ctx context.Context, channelID, userID uuid.UUID, content string) error {
+	if s.antispamService == nil {
+		return nil
+	}
+	err := s.antispamService.CheckMessage(ctx, channelID, userID, content)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, antispam.ErrMessageBurst):
+		return ErrSlowDown
+	case errors.Is(err, antispam.ErrDuplicateMessage):
+		return ErrDuplicateMessage
+	case errors.Is(err, antispam.ErrVerificationPending):
+		return ErrVerificationLevel
+	}
+	log.Error().Err(err).Str("channelId", channelID.String()).Msg("Spam check failed")
+	return nil
+}
 
-	query := `
-		SELECT id, message_id, message_created_at, uploader_id, filename, file_url, file_size, content_type, thumbnail_url, width, height, created_at
-		FROM message_attachments
-		WHERE message_id = ANY($1)`
+// checkReactionSpam applies the community's reaction burst limit and raid
+// detection. Lookup failures are logged and let the reaction through.
+func (s *Service) checkReactionSpam(ctx context.Context, channelID, userID uuid.UUID) error {
+	if s.antispamService == nil {
+		return nil
+	}
+	err := s.antispamService.CheckReaction(ctx, channelID, userID)
+	var limited *messaging.RateLimitError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &limited):
+		return err
+	}
+	log.Error().Err(err).Str("channelId", channelID.String()).Msg("Reaction spam check failed")
+	return nil
+}
 
-	rows, err := s.db.Query(ctx, query, messageIDs)
+// runAutoMod evaluates the community's AutoMod rules. Evaluation errors let the
+// message through; a broken rule shouldn't take chat down with it.
+func (s *Service) runAutoMod(ctx context.Context, channelID, userID uuid.UUID, content string) *automod.Verdict {
+	if s.automodService == nil {
+		return nil
+	}
+	verdict, err := s.automodService.Evaluate(ctx, channelID, userID, content)
 	if err != nil {
-		return result
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("AutoMod evaluation failed")
+		return nil
 	}
-	defer rows.Close()
+	return verdict
+}
 
-	for rows.Next() {
-		var a models.MessageAttachment
-		err := rows.Scan(&a.ID, &a.MessageID, &a.MessageCreatedAt, &a.UploaderID, &a.Filename, &a.FileURL,
-			&a.FileSize, &a.ContentType, &a.ThumbnailURL, &a.Width, &a.Height, &a.CreatedAt)
-		if err != nil {
-			continue
-		}
-		if a.MessageID != nil {
-			result[*a.MessageID] = append(result[*a.MessageID], a)
-		}
+// runIntegrationAutoMod is runAutoMod for bot users, which have no member exemptions
+func (s *Service) runIntegrationAutoMod(ctx context.Context, channelID, userID uuid.UUID, content string) *automod.Verdict {
+	if s.automodService == nil {
+		return nil
 	}
-
-	return result
+	verdict, err := s.automodService.EvaluateIntegration(ctx, channelID, userID, content)
+	if err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("AutoMod evaluation failed")
+		return nil
+	}
+	return verdict
 }
 
-// Typing indicator methods using Redis
+// Typing state is shared with the gateway through the presence service
 func (s *Service) SetTyping(ctx context.Context, channelID, userID uuid.UUID) error {
-	key := fmt.Sprintf("typing:%s", channelID.String())
-	member := userID.String()
-
-	// Add to sorted set with current timestamp as score
-	s.redis.ZAdd(ctx, key, redis.Z{
-		Score:  float64(time.Now().Unix()),
-		Member: member,
-	})
-	s.redis.Expire(ctx, key, 10*time.Second)
-
-	// Publish typing event
-	event := map[string]interface{}{
-		"channelId": channelID.String(),
-		"userId":    userID.String(),
-		"typing":    true,
+	if !s.channelService.CanAccessChannel(ctx, channelID, userID) {
+		return ErrInsufficientPerms
 	}
-	eventJSON, _ := json.Marshal(event)
-	s.redis.Publish(ctx, fmt.Sprintf("channel:%s:typing", channelID.String()), eventJSON)
 
+	s.presenceService.StartTyping(ctx, channelID.String(), userID)
 	return nil
 }
 
 func (s *Service) GetTypingUsers(ctx context.Context, channelID uuid.UUID) ([]uuid.UUID, error) {
-	key := fmt.Sprintf("typing:%s", channelID.String())
-
-	// Get users who typed in the last 5 seconds
-	cutoff := float64(time.Now().Add(-5 * time.Second).Unix())
-
-	members, err := s.redis.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-		Min: fmt.Sprintf("%f", cutoff),
-		Max: "+inf",
-	}).Result()
-	if err != nil {
-		return nil, err
-	}
-
-	var users []uuid.UUID
-	for _, m := range members {
-		if id, err := uuid.Parse(m); err == nil {
-			users = append(users, id)
-		}
-	}
-
-	return users, nil
+	return s.presenceService.TypingUsers(ctx, channelID.String()), nil
 }
@@ -0,0 +1,381 @@
+package message
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+// fakeChannels grants channel permissions per user
+type fakeChannels struct {
+	access, send, manage map[uuid.UUID]bool
+}
+
+func newFakeChannels() *fakeChannels {
+	return &fakeChannels{access: map[uuid.UUID]bool{}, send: map[uuid.UUID]bool{}, manage: map[uuid.UUID]bool{}}
+}
+
+func (c *fakeChannels) member(userID uuid.UUID) {
+	c.access[userID] = true
+	c.send[userID] = true
+}
+
+func (c *fakeChannels) CanAccessChannel(_ context.Context, _, userID uuid.UUID) bool {
+	return c.access[userID]
+}
+
+func (c *fakeChannels) CanSendMessage(_ context.Context, _, userID uuid.UUID) bool {
+	return c.send[userID]
+}
+
+func (c *fakeChannels) CanManageMessages(_ context.Context, _, userID uuid.UUID) bool {
+	return c.manage[userID]
+}
+
+func (c *fakeChannels) CanPinMessages(_ context.Context, _, userID uuid.UUID) bool {
+	return c.manage[userID]
+}
+
+func (c *fakeChannels) CanMentionEveryone(_ context.Context, _, userID uuid.UUID) bool {
+	return c.manage[userID]
+}
+
+func (c *fakeChannels) RecordInteraction(context.Context, uuid.UUID, uuid.UUID, *uuid.UUID) error {
+	return nil
+}
+
+// sentEvent is a broadcast the service published
+type sentEvent struct {
+	ChannelID string `json:"channelId"`
+	Event     struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	} `json:"event"`
+}
+
+// events collects what the service broadcasts. Without Redis, broadcasts are
+// handed to the local deliverer.
+type events struct {
+	mu   sync.Mutex
+	sent []sentEvent
+}
+
+func (e *events) ofType(eventType string) []sentEvent {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var matched []sentEvent
+	for _, ev := range e.sent {
+		if ev.Event.Type == eventType {
+			matched = append(matched, ev)
+		}
+	}
+	return matched
+}
+
+type testEnv struct {
+	service   *Service
+	repo      *fakeRepository
+	channels  *fakeChannels
+	events    *events
+	community uuid.UUID
+	channel   uuid.UUID
+	author    uuid.UUID
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := encryption.NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := &testEnv{repo: newFakeRepository(), channels: newFakeChannels(), events: &events{}, community: uuid.New()}
+	env.service = NewService(nil, nil, keys, env.channels, nil, nil, nil)
+	env.service.SetRepository(env.repo)
+	env.service.SetSearchIndex(messaging.NewSearchIndex(key))
+	env.channel = env.repo.addChannel(env.community)
+	env.author = env.repo.addUser("author")
+	env.channels.member(env.author)
+
+	database.SetLocalBroadcast("test", func(payload []byte) {
+		var ev sentEvent
+		if err := json.Unmarshal(payload, &ev); err != nil {
+			t.Errorf("broadcast isn't JSON: %v", err)
+			return
+		}
+		env.events.mu.Lock()
+		env.events.sent = append(env.events.sent, ev)
+		env.events.mu.Unlock()
+	})
+	t.Cleanup(func() { database.SetLocalBroadcast("", nil) })
+	return env
+}
+
+func (env *testEnv) send(t *testing.T, userID uuid.UUID, content string) *MessageResponse {
+	t.Helper()
+	resp, err := env.service.CreateMessage(context.Background(), env.channel, userID, &CreateMessageRequest{Content: content})
+	if err != nil {
+		t.Fatalf("CreateMessage: %v", err)
+	}
+	return resp
+}
+
+func TestCreateMessageStoresEncryptedAndBroadcasts(t *testing.T) {
+	env := newTestEnv(t)
+
+	resp := env.send(t, env.author, "hello there")
+	if resp.Content == nil || *resp.Content != "hello there" {
+		t.Fatalf("content = %v, want hello there", resp.Content)
+	}
+
+	stored := env.repo.messages[resp.ID]
+	if stored == nil {
+		t.Fatal("message wasn't stored")
+	}
+	if string(stored.EncryptedContent) == "hello there" {
+		t.Error("content was stored in plaintext")
+	}
+
+	created := env.events.ofType("MESSAGE_CREATE")
+	if len(created) != 1 || created[0].ChannelID != env.channel.String() {
+		t.Fatalf("MESSAGE_CREATE broadcasts = %+v, want one to the channel", created)
+	}
+}
+
+func TestContentOnlyDecryptsInItsOwnRow(t *testing.T) {
+	env := newTestEnv(t)
+	first := env.send(t, env.author, "first")
+	second := env.send(t, env.author, "second")
+
+	// Someone with write access to the database copies one message's
+	// ciphertext over another's
+	env.repo.messages[first.ID].EncryptedContent = env.repo.messages[second.ID].EncryptedContent
+
+	got, err := env.service.GetMessage(context.Background(), first.ID, env.author)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content == nil || *got.Content != messaging.DecryptionErrorPlaceholder {
+		t.Errorf("moved content = %v, want the decryption error placeholder", got.Content)
+	}
+}
+
+func TestSearchTokensDependOnChannel(t *testing.T) {
+	env := newTestEnv(t)
+	other := env.repo.addChannel(env.community)
+	here := env.send(t, env.author, "quarterly numbers")
+	there, err := env.service.CreateMessage(context.Background(), other, env.author, &CreateMessageRequest{Content: "quarterly numbers"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hereTokens := env.repo.messages[here.ID].searchTokens
+	thereTokens := env.repo.messages[there.ID].searchTokens
+	if len(hereTokens) != 2 || len(thereTokens) != 2 {
+		t.Fatalf("tokens = %v and %v, want two each", hereTokens, thereTokens)
+	}
+	for _, token := range hereTokens {
+		for _, otherToken := range thereTokens {
+			if token == otherToken {
+				t.Fatalf("the same word has token %q in both channels", token)
+			}
+		}
+	}
+
+	found, err := env.service.SearchMessages(context.Background(), env.channel, env.author, "Numbers", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].ID != here.ID {
+		t.Errorf("search found %d messages, want the one in this channel", len(found))
+	}
+}
+
+func TestCreateMessageNeedsSendPermission(t *testing.T) {
+	env := newTestEnv(t)
+	reader := env.repo.addUser("reader")
+	env.channels.access[reader] = true
+
+	_, err := env.service.CreateMessage(context.Background(), env.channel, reader, &CreateMessageRequest{Content: "hi"})
+	if !errors.Is(err, ErrInsufficientPerms) {
+		t.Fatalf("err = %v, want ErrInsufficientPerms", err)
+	}
+	if len(env.repo.messages) != 0 {
+		t.Error("message was stored anyway")
+	}
+}
+
+func TestCreateMessageRejectsReplyFromAnotherChannel(t *testing.T) {
+	env := newTestEnv(t)
+	other := env.repo.addChannel(env.community)
+	elsewhere, err := env.service.CreateMessage(context.Background(), other, env.author, &CreateMessageRequest{Content: "elsewhere"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = env.service.CreateMessage(context.Background(), env.channel, env.author, &CreateMessageRequest{
+		Content:   "reply",
+		ReplyToID: &elsewhere.ID,
+	})
+	if !errors.Is(err, ErrInvalidReply) {
+		t.Fatalf("err = %v, want ErrInvalidReply", err)
+	}
+}
+
+func TestCreateMessageOnlyLinksOwnUnusedAttachments(t *testing.T) {
+	env := newTestEnv(t)
+	other := env.repo.addUser("other")
+	env.channels.member(other)
+
+	theirs := env.repo.addAttachment(other)
+	_, err := env.service.CreateMessage(context.Background(), env.channel, env.author, &CreateMessageRequest{
+		Content:     "look",
+		Attachments: []uuid.UUID{theirs},
+	})
+	if !errors.Is(err, ErrInvalidAttachment) {
+		t.Fatalf("someone else's attachment: err = %v, want ErrInvalidAttachment", err)
+	}
+
+	mine := env.repo.addAttachment(env.author)
+	resp, err := env.service.CreateMessage(context.Background(), env.channel, env.author, &CreateMessageRequest{
+		Content:     "look",
+		Attachments: []uuid.UUID{mine},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Attachments) != 1 || resp.Attachments[0].ID != mine {
+		t.Fatalf("attachments = %+v, want the uploaded one", resp.Attachments)
+	}
+
+	_, err = env.service.CreateMessage(context.Background(), env.channel, env.author, &CreateMessageRequest{
+		Content:     "again",
+		Attachments: []uuid.UUID{mine},
+	})
+	if !errors.Is(err, ErrInvalidAttachment) {
+		t.Fatalf("attachment already on a message: err = %v, want ErrInvalidAttachment", err)
+	}
+}
+
+func TestQuarantinedMessagesOnlyReachAuthorAndModerators(t *testing.T) {
+	env := newTestEnv(t)
+	reader := env.repo.addUser("reader")
+	env.channels.member(reader)
+	moderator := env.repo.addUser("moderator")
+	env.channels.member(moderator)
+	env.channels.manage[moderator] = true
+	env.repo.moderators = []uuid.UUID{moderator}
+	env.repo.quarantined[env.author] = true
+
+	resp := env.send(t, env.author, "hidden")
+
+	for _, ev := range env.events.ofType("MESSAGE_CREATE") {
+		if ev.ChannelID == env.channel.String() {
+			t.Fatal("quarantined message was broadcast to the channel")
+		}
+	}
+
+	page := func(userID uuid.UUID) []*MessageResponse {
+		messages, err := env.service.GetChannelMessages(context.Background(), env.channel, userID, &GetMessagesParams{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messages
+	}
+	if got := page(reader); len(got) != 0 {
+		t.Errorf("reader sees %d messages, want none", len(got))
+	}
+	if got := page(env.author); len(got) != 1 || got[0].IsQuarantined {
+		t.Errorf("author's page = %+v, want the message without the quarantine flag", got)
+	}
+	if got := page(moderator); len(got) != 1 || !got[0].IsQuarantined {
+		t.Errorf("moderator's page = %+v, want the flagged message", got)
+	}
+
+	if _, err := env.service.GetMessage(context.Background(), resp.ID, reader); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("reader GetMessage err = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestUpdateMessageOnlyByAuthor(t *testing.T) {
+	env := newTestEnv(t)
+	other := env.repo.addUser("other")
+	env.channels.member(other)
+	resp := env.send(t, env.author, "first")
+
+	_, err := env.service.UpdateMessage(context.Background(), resp.ID, other, &UpdateMessageRequest{Content: "mine now"})
+	if !errors.Is(err, ErrNotMessageOwner) {
+		t.Fatalf("err = %v, want ErrNotMessageOwner", err)
+	}
+
+	updated, err := env.service.UpdateMessage(context.Background(), resp.ID, env.author, &UpdateMessageRequest{Content: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.Content != "second" || !updated.IsEdited {
+		t.Errorf("updated = %q edited %v, want second, edited", *updated.Content, updated.IsEdited)
+	}
+}
+
+func TestBotMessages(t *testing.T) {
+	env := newTestEnv(t)
+	bot := env.repo.addUser("bot")
+	buttons := []models.ComponentRow{{}}
+
+	resp, err := env.service.CreateBotMessage(context.Background(), &BotMessage{
+		ChannelID:   env.channel,
+		CommunityID: env.community,
+		AuthorID:    bot,
+		Content:     "build passed",
+		Components:  buttons,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = env.service.CreateBotMessage(context.Background(), &BotMessage{
+		ChannelID:   env.channel,
+		CommunityID: uuid.New(),
+		AuthorID:    bot,
+		Content:     "wrong community",
+	})
+	if !errors.Is(err, ErrChannelUnavailable) {
+		t.Fatalf("post outside the bot's community: err = %v, want ErrChannelUnavailable", err)
+	}
+
+	_, err = env.service.UpdateBotMessage(context.Background(), &BotMessageEdit{MessageID: resp.ID, AuthorID: uuid.New(), Content: "hijacked"})
+	if !errors.Is(err, ErrMessageNotFound) {
+		t.Fatalf("edit by another bot: err = %v, want ErrMessageNotFound", err)
+	}
+
+	// Empty content keeps the text; an empty component list removes them
+	updated, err := env.service.UpdateBotMessage(context.Background(), &BotMessageEdit{
+		MessageID:  resp.ID,
+		AuthorID:   bot,
+		Components: []models.ComponentRow{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updated.Content != "build passed" || len(updated.Components) != 0 || updated.IsEdited {
+		t.Errorf("updated = %q components %d edited %v, want the same text without components, not edited",
+			*updated.Content, len(updated.Components), updated.IsEdited)
+	}
+	if len(env.events.ofType("MESSAGE_UPDATE")) != 1 {
+		t.Error("the edit wasn't broadcast")
+	}
+}
@@ -0,0 +1,58 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+)
+
+var ErrUnknownAttachment = errors.New("attachment options reference an attachment that is not on the message")
+
+// ApplyAttachmentOptions stores send-time spoiler flags and alt text for
+// attachments that were just linked to a message inside tx.
+func ApplyAttachmentOptions(ctx context.Context, tx pgx.Tx, uploaderID uuid.UUID, attachments []uuid.UUID, opts []models.AttachmentOptions) error {
+	if len(opts) == 0 {
+		return nil
+	}
+
+	linked := make(map[uuid.UUID]bool, len(attachments))
+	for _, id := range attachments {
+		linked[id] = true
+	}
+
+	for _, opt := range opts {
+		if !linked[opt.ID] {
+			return ErrUnknownAttachment
+		}
+
+		_, err := tx.Exec(ctx,
+			`UPDATE message_attachments
+			 SET is_spoiler = COALESCE($1, is_spoiler),
+			     description = COALESCE($2, description)
+			 WHERE id = $3 AND uploader_id = $4`,
+			opt.IsSpoiler, NormalizeAttachmentDescription(opt.Description), opt.ID, uploaderID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NormalizeAttachmentDescription trims alt text and clamps it to the column size.
+// An all-whitespace description is kept as "" so it clears an earlier value.
+func NormalizeAttachmentDescription(description *string) *string {
+	if description == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*description)
+	if runes := []rune(trimmed); len(runes) > models.MaxAttachmentDescriptionLength {
+		trimmed = string(runes[:models.MaxAttachmentDescriptionLength])
+	}
+	return &trimmed
+}
@@ -0,0 +1,51 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/auth"
+)
+
+// BotUserKind is the kind of integration a bot user posts for. It sets the
+// username prefix and the email domain, so bot users never collide with
+// each other or with people.
+type BotUserKind struct {
+	prefix string
+	domain string
+}
+
+var (
+	BotUserAPIToken = BotUserKind{prefix: "ct", domain: "token.zentra.local"}
+	BotUserPlugin   = BotUserKind{prefix: "pl", domain: "plugin.zentra.local"}
+	BotUserWebhook  = BotUserKind{prefix: "wh", domain: "webhook.zentra.local"}
+)
+
+// CreateBotUser adds the user an integration's messages are attributed to.
+// sourceID is the token, installation or webhook it belongs to. The user
+// can't sign in: the password is random and never stored.
+func CreateBotUser(ctx context.Context, tx pgx.Tx, kind BotUserKind, sourceID uuid.UUID, displayName string, avatarURL *string) (uuid.UUID, error) {
+	botUserID := uuid.New()
+	compactID := strings.ReplaceAll(sourceID.String(), "-", "")
+	username := kind.prefix + compactID[:30]
+	email := fmt.Sprintf("%s@%s", username, kind.domain)
+
+	passwordHash, err := auth.HashPassword(uuid.NewString() + ":" + kind.prefix)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO users (id, username, email, password_hash, display_name, avatar_url, status, email_verified, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, TRUE, NOW(), NOW())`,
+		botUserID, username, email, passwordHash, displayName, avatarURL, models.UserStatusOffline,
+	)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("create %s bot user: %w", kind.domain, err)
+	}
+	return botUserID, nil
+}
@@ -0,0 +1,144 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// MessageRow is a channel message for CopyMessages and MergeMessages. The
+// columns it doesn't have take their defaults: no reactions, link previews or
+// components.
+type MessageRow struct {
+	ID               uuid.UUID
+	ChannelID        uuid.UUID
+	AuthorID         uuid.UUID
+	EncryptedContent []byte
+	ContentWarning   *string
+	ReplyToID        *uuid.UUID
+	IsEdited         bool
+	IsPinned         bool
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	SearchTokens     []string
+}
+
+var messageCopyColumns = []string{
+	"id", "channel_id", "author_id", "encrypted_content", "content_warning", "reply_to_id",
+	"is_edited", "is_pinned", "created_at", "updated_at", "search_tokens",
+}
+
+func (r *MessageRow) values() []any {
+	return []any{
+		r.ID, r.ChannelID, r.AuthorID, r.EncryptedContent, r.ContentWarning, r.ReplyToID,
+		r.IsEdited, r.IsPinned, r.CreatedAt, r.UpdatedAt, r.SearchTokens,
+	}
+}
+
+// AttachmentRow is an attachment of a channel message for CopyAttachments and
+// MergeAttachments. Its file must already be stored.
+type AttachmentRow struct {
+	ID               uuid.UUID
+	MessageID        uuid.UUID
+	MessageCreatedAt time.Time
+	UploaderID       uuid.UUID
+	Filename         string
+	FileURL          string
+	FileSize         int64
+	ContentType      *string
+	ThumbnailURL     *string
+	Width            *int
+	Height           *int
+	CreatedAt        time.Time
+}
+
+var attachmentCopyColumns = []string{
+	"id", "message_id", "message_created_at", "uploader_id", "filename", "file_url",
+	"file_size", "content_type", "thumbnail_url", "width", "height", "created_at",
+}
+
+func (r *AttachmentRow) values() []any {
+	return []any{
+		r.ID, r.MessageID, r.MessageCreatedAt, r.UploaderID, r.Filename, r.FileURL,
+		r.FileSize, r.ContentType, r.ThumbnailURL, r.Width, r.Height, r.CreatedAt,
+	}
+}
+
+// CopyMessages inserts messages with a single COPY instead of one INSERT each,
+// for imports and other bulk writes. The monthly partitions they fall in must
+// already exist (see database.EnsureMessagePartition). The whole copy fails if
+// any of the messages already exists; use MergeMessages when a write can be
+// retried with the same IDs.
+func CopyMessages(ctx context.Context, tx pgx.Tx, rows []MessageRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"messages"}, messageCopyColumns,
+		pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) { return rows[i].values(), nil }),
+	)
+	return err
+}
+
+// MergeMessages is CopyMessages for writes that may be repeated: the messages
+// are copied into a staging table and only the ones that don't exist yet are
+// inserted. It returns how many were.
+func MergeMessages(ctx context.Context, tx pgx.Tx, rows []MessageRow) (int64, error) {
+	return merge(ctx, tx, "messages", messageCopyColumns, len(rows), func(i int) []any { return rows[i].values() })
+}
+
+// CopyAttachments inserts attachments with a single COPY. It fails if any of
+// them already exists.
+func CopyAttachments(ctx context.Context, tx pgx.Tx, rows []AttachmentRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"message_attachments"}, attachmentCopyColumns,
+		pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) { return rows[i].values(), nil }),
+	)
+	return err
+}
+
+// MergeAttachments inserts the attachments that don't exist yet, like
+// MergeMessages, and returns how many were.
+func MergeAttachments(ctx context.Context, tx pgx.Tx, rows []AttachmentRow) (int64, error) {
+	return merge(ctx, tx, "message_attachments", attachmentCopyColumns, len(rows), func(i int) []any { return rows[i].values() })
+}
+
+// merge copies rows into a temporary table shaped like table, then moves them
+// over, skipping any that conflict. COPY itself can't skip conflicts. The
+// staging table lives until the transaction ends and is left empty, so merging
+// more than once in a transaction reuses it.
+func merge(ctx context.Context, tx pgx.Tx, table string, columns []string, n int, values func(int) []any) (int64, error) {
+	if n == 0 {
+		return 0, nil
+	}
+	staging := table + "_staging"
+
+	// Table names are constants from this file, never user input
+	if _, err := tx.Exec(ctx, fmt.Sprintf(
+		`CREATE TEMP TABLE IF NOT EXISTS %s (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP`, staging, table,
+	)); err != nil {
+		return 0, fmt.Errorf("create %s: %w", staging, err)
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{staging}, columns,
+		pgx.CopyFromSlice(n, func(i int) ([]any, error) { return values(i), nil }),
+	); err != nil {
+		return 0, err
+	}
+
+	list := strings.Join(columns, ", ")
+	tag, err := tx.Exec(ctx, fmt.Sprintf(
+		`WITH staged AS (DELETE FROM %s RETURNING %s)
+		INSERT INTO %s (%s) SELECT %s FROM staged
+		ON CONFLICT DO NOTHING`,
+		staging, list, table, list, list,
+	))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,78 @@
+package messaging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+)
+
+var ErrInvalidCamoSignature = errors.New("invalid image proxy signature")
+
+// Camo rewrites links to images on other sites into signed links to the
+// image proxy, so clients never load them from the site itself and don't give
+// their IP address away. Only URLs the server signed can be fetched through
+// the proxy. A nil Camo leaves URLs as they are.
+type Camo struct {
+	secret  []byte
+	baseURL string
+}
+
+// NewCamo signs image URLs as links to the image proxy at baseURL
+func NewCamo(secret, baseURL string) *Camo {
+	return &Camo{
+		secret:  []byte(secret),
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/",
+	}
+}
+
+// URL returns the proxy link for an external image. Links that aren't http or
+// https, and ones already pointing at the proxy, are returned as they are.
+func (c *Camo) URL(rawURL string) string {
+	if c == nil || rawURL == "" || strings.HasPrefix(rawURL, c.baseURL) {
+		return rawURL
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return rawURL
+	}
+	return c.baseURL + c.signature(rawURL) + "/" + base64.RawURLEncoding.EncodeToString([]byte(rawURL))
+}
+
+// Verify checks a proxy link's signature and returns the URL it was made for
+func (c *Camo) Verify(sig, encodedURL string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encodedURL)
+	if err != nil {
+		return "", ErrInvalidCamoSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(c.signature(string(raw)))) {
+		return "", ErrInvalidCamoSignature
+	}
+	return string(raw), nil
+}
+
+func (c *Camo) signature(rawURL string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(rawURL))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// LinkPreviews returns a copy of previews with their images and favicons
+// loaded through the image proxy. Previews are stored with the original URLs
+// and rewritten on the way out, so the proxy can be turned on, off or rekeyed.
+func (c *Camo) LinkPreviews(previews []models.LinkPreview) []models.LinkPreview {
+	if c == nil || len(previews) == 0 {
+		return previews
+	}
+	proxied := make([]models.LinkPreview, len(previews))
+	for i, p := range previews {
+		p.ImageURL = c.URL(p.ImageURL)
+		p.FaviconURL = c.URL(p.FaviconURL)
+		proxied[i] = p
+	}
+	return proxied
+}
@@ -3,79 +3,92 @@ package messaging
 import (
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
-	"io"
 
 	"github.com/zentra/server/pkg/encryption"
 )
 
+// ContentCipher encrypts content for the row it is stored in (see
+// encryption.Row). Content must be decrypted with the same row.
 type ContentCipher interface {
-	Encrypt(content string) (ciphertext []byte, nonce []byte, err error)
-	Decrypt(ciphertext []byte, nonce []byte) (string, error)
+	Encrypt(row encryption.Row, content string) (ciphertext []byte, nonce []byte, err error)
+	Decrypt(row encryption.Row, ciphertext []byte, nonce []byte) (string, error)
+	// KeyVersion is the fingerprint of the key new content is wrapped with
+	KeyVersion() string
 }
 
+// Both ciphers write envelopes (see encryption.Keyring) and read content
+// written under any key in the keyring. They differ only in the format of
+// content written before envelopes: channel ciphertext carries its nonce,
+// DM and broadcast ciphertext has it in a separate column.
 type ChannelCipher struct {
-	key []byte
+	keys *encryption.Keyring
 }
 
 type DMCipher struct {
-	key []byte
+	keys *encryption.Keyring
 }
 
-func NewChannelCipher(key []byte) *ChannelCipher {
-	return &ChannelCipher{key: key}
+func NewChannelCipher(keys *encryption.Keyring) *ChannelCipher {
+	return &ChannelCipher{keys: keys}
 }
 
-func NewDMCipher(key []byte) *DMCipher {
-	return &DMCipher{key: key}
+func NewDMCipher(keys *encryption.Keyring) *DMCipher {
+	return &DMCipher{keys: keys}
 }
 
-func (c *ChannelCipher) Encrypt(content string) ([]byte, []byte, error) {
-	ciphertext, err := encryption.Encrypt([]byte(content), c.key)
+func (c *ChannelCipher) KeyVersion() string {
+	return c.keys.CurrentID()
+}
+
+func (c *DMCipher) KeyVersion() string {
+	return c.keys.CurrentID()
+}
+
+func (c *ChannelCipher) Encrypt(row encryption.Row, content string) ([]byte, []byte, error) {
+	ciphertext, err := c.keys.Seal([]byte(content), row)
 	if err != nil {
 		return nil, nil, err
 	}
 	return ciphertext, nil, nil
 }
 
-func (c *ChannelCipher) Decrypt(ciphertext []byte, _ []byte) (string, error) {
-	plaintext, err := encryption.Decrypt(ciphertext, c.key)
+func (c *ChannelCipher) Decrypt(row encryption.Row, ciphertext []byte, _ []byte) (string, error) {
+	plaintext, err := c.keys.Open(ciphertext, row)
 	if err != nil {
 		return "", err
 	}
 	return string(plaintext), nil
 }
 
-func (c *DMCipher) Encrypt(content string) ([]byte, []byte, error) {
-	if len(c.key) != 32 {
-		return nil, nil, encryption.ErrInvalidKeyLength
-	}
-
-	block, err := aes.NewCipher(c.key)
+// Encrypt returns an empty nonce, since the envelope carries its own; the
+// nonce columns are NOT NULL
+func (c *DMCipher) Encrypt(row encryption.Row, content string) ([]byte, []byte, error) {
+	ciphertext, err := c.keys.Seal([]byte(content), row)
 	if err != nil {
 		return nil, nil, err
 	}
+	return ciphertext, []byte{}, nil
+}
 
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, nil, err
+func (c *DMCipher) Decrypt(row encryption.Row, ciphertext, nonce []byte) (string, error) {
+	if len(nonce) == 0 {
+		plaintext, err := c.keys.Open(ciphertext, row)
+		if err != nil {
+			return "", err
+		}
+		return string(plaintext), nil
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, nil, err
+	for _, key := range c.keys.Keys() {
+		if plaintext, err := decryptLegacyDM(key, ciphertext, nonce); err == nil {
+			return plaintext, nil
+		}
 	}
-
-	ciphertext := gcm.Seal(nil, nonce, []byte(content), nil)
-	return ciphertext, nonce, nil
+	return "", encryption.ErrDecryptionFailed
 }
 
-func (c *DMCipher) Decrypt(ciphertext, nonce []byte) (string, error) {
-	if len(c.key) != 32 {
-		return "", encryption.ErrInvalidKeyLength
-	}
-
-	block, err := aes.NewCipher(c.key)
+func decryptLegacyDM(key, ciphertext, nonce []byte) (string, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
 	}
@@ -84,6 +97,9 @@ func (c *DMCipher) Decrypt(ciphertext, nonce []byte) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	if len(nonce) != gcm.NonceSize() {
+		return "", encryption.ErrDecryptionFailed
+	}
 
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
@@ -0,0 +1,176 @@
+package messaging
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/zentra/server/internal/models"
+)
+
+var (
+	ErrInvalidComponents = errors.New("invalid message components")
+	ErrComponentNotFound = errors.New("component not found")
+	ErrInvalidValues     = errors.New("invalid component values")
+)
+
+// ValidateComponents checks the rules struct tags can't express: row limits,
+// unique custom IDs, the fields each button style needs and select menu
+// bounds. Field-level tags are checked separately when the request is bound.
+func ValidateComponents(rows []models.ComponentRow) error {
+	if len(rows) > models.MaxComponentRows {
+		return fmt.Errorf("%w: at most %d rows", ErrInvalidComponents, models.MaxComponentRows)
+	}
+
+	customIDs := make(map[string]bool)
+	for i, row := range rows {
+		if len(row.Components) == 0 || len(row.Components) > models.MaxComponentsPerRow {
+			return fmt.Errorf("%w: row %d must have 1-%d components", ErrInvalidComponents, i, models.MaxComponentsPerRow)
+		}
+
+		for _, c := range row.Components {
+			if c.Type == models.ComponentTypeSelect && len(row.Components) > 1 {
+				return fmt.Errorf("%w: a select menu must be alone in its row", ErrInvalidComponents)
+			}
+			if err := validateComponent(c); err != nil {
+				return err
+			}
+			if c.CustomID == "" {
+				continue
+			}
+			if customIDs[c.CustomID] {
+				return fmt.Errorf("%w: duplicate customId %q", ErrInvalidComponents, c.CustomID)
+			}
+			customIDs[c.CustomID] = true
+		}
+	}
+	return nil
+}
+
+func validateComponent(c models.MessageComponent) error {
+	switch c.Type {
+	case models.ComponentTypeButton:
+		if c.Label == "" && c.Emoji == "" {
+			return fmt.Errorf("%w: buttons need a label or emoji", ErrInvalidComponents)
+		}
+		if len(c.Options) > 0 || c.Placeholder != "" || c.MinValues != nil || c.MaxValues != nil {
+			return fmt.Errorf("%w: buttons can't have select menu fields", ErrInvalidComponents)
+		}
+		if c.Style == models.ButtonStyleLink {
+			if c.URL == "" || c.CustomID != "" {
+				return fmt.Errorf("%w: link buttons need a url and no customId", ErrInvalidComponents)
+			}
+			return nil
+		}
+		if c.URL != "" || c.CustomID == "" {
+			return fmt.Errorf("%w: buttons need a customId and no url", ErrInvalidComponents)
+		}
+
+	case models.ComponentTypeSelect:
+		if c.CustomID == "" {
+			return fmt.Errorf("%w: select menus need a customId", ErrInvalidComponents)
+		}
+		if c.Label != "" || c.Style != "" || c.Emoji != "" || c.URL != "" {
+			return fmt.Errorf("%w: select menus can't have button fields", ErrInvalidComponents)
+		}
+		if len(c.Options) == 0 || len(c.Options) > models.MaxSelectOptions {
+			return fmt.Errorf("%w: select menus need 1-%d options", ErrInvalidComponents, models.MaxSelectOptions)
+		}
+		values := make(map[string]bool, len(c.Options))
+		for _, opt := range c.Options {
+			if values[opt.Value] {
+				return fmt.Errorf("%w: duplicate option value %q", ErrInvalidComponents, opt.Value)
+			}
+			values[opt.Value] = true
+		}
+		minValues, maxValues := selectBounds(c)
+		if minValues > maxValues || maxValues > len(c.Options) {
+			return fmt.Errorf("%w: minValues and maxValues must fit the options", ErrInvalidComponents)
+		}
+
+	default:
+		return fmt.Errorf("%w: unknown component type %q", ErrInvalidComponents, c.Type)
+	}
+	return nil
+}
+
+// FindComponent returns the interactive component with customID
+func FindComponent(rows []models.ComponentRow, customID string) (*models.MessageComponent, error) {
+	for _, row := range rows {
+		for i := range row.Components {
+			if c := &row.Components[i]; c.CustomID == customID {
+				return c, nil
+			}
+		}
+	}
+	return nil, ErrComponentNotFound
+}
+
+// ValidateValues checks what a member picked against the component. Buttons
+// take no values; select menus take known option values within their bounds.
+func ValidateValues(c *models.MessageComponent, values []string) error {
+	if c.Type == models.ComponentTypeButton {
+		if len(values) > 0 {
+			return ErrInvalidValues
+		}
+		return nil
+	}
+
+	minValues, maxValues := selectBounds(*c)
+	if len(values) < minValues || len(values) > maxValues {
+		return ErrInvalidValues
+	}
+
+	picked := make(map[string]bool, len(values))
+	for _, v := range values {
+		if picked[v] || !hasOption(c, v) {
+			return ErrInvalidValues
+		}
+		picked[v] = true
+	}
+	return nil
+}
+
+func selectBounds(c models.MessageComponent) (int, int) {
+	minValues, maxValues := 1, 1
+	if c.MinValues != nil {
+		minValues = *c.MinValues
+	}
+	if c.MaxValues != nil {
+		maxValues = *c.MaxValues
+	}
+	return minValues, maxValues
+}
+
+func hasOption(c *models.MessageComponent, value string) bool {
+	for _, opt := range c.Options {
+		if opt.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func EncodeComponents(rows []models.ComponentRow) []byte {
+	if len(rows) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(rows)
+	if err != nil {
+		return nil
+	}
+	return payload
+}
+
+func DecodeComponents(raw []byte) []models.ComponentRow {
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var rows []models.ComponentRow
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		return nil
+	}
+
+	return rows
+}
@@ -0,0 +1,30 @@
+package messaging
+
+import (
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+)
+
+// NormalizeContentWarning trims a content warning and clamps it to the column
+// size. An all-whitespace label becomes "" so an edit can clear it.
+func NormalizeContentWarning(warning *string) *string {
+	if warning == nil {
+		return nil
+	}
+	trimmed := strings.Join(strings.Fields(*warning), " ")
+	if runes := []rune(trimmed); len(runes) > models.MaxContentWarningLength {
+		trimmed = string(runes[:models.MaxContentWarningLength])
+	}
+	return &trimmed
+}
+
+// ContentWarningOrNil is NormalizeContentWarning for new messages, where an
+// empty label means no warning at all.
+func ContentWarningOrNil(warning *string) *string {
+	normalized := NormalizeContentWarning(warning)
+	if normalized == nil || *normalized == "" {
+		return nil
+	}
+	return normalized
+}
@@ -0,0 +1,181 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/encryption"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+// DecryptionErrorPlaceholder is shown in place of content that cannot be
+// decrypted
+const DecryptionErrorPlaceholder = "[Decryption Error]"
+
+// Kinds of stored encrypted content
+const (
+	ContentKindChannel   = "channel"   // messages, keyed by channel
+	ContentKindDM        = "dm"        // direct_messages, keyed by conversation
+	ContentKindBroadcast = "broadcast" // community_broadcasts, keyed by community
+)
+
+// contentTables are the tables each kind of content is stored in
+var contentTables = map[string]string{
+	ContentKindChannel:   "messages",
+	ContentKindDM:        "direct_messages",
+	ContentKindBroadcast: "community_broadcasts",
+}
+
+const (
+	recordTimeout = 5 * time.Second
+
+	// The same content's failure is logged and recorded at most once per
+	// reportInterval, for up to reportThrottleSize pieces of content at once
+	reportInterval     = time.Minute
+	reportThrottleSize = 10000
+)
+
+var decryptionFailuresTotal = metrics.NewCounter("zentra_decryption_failures_total",
+	"Stored message content that failed to decrypt.", "kind", "key_version")
+
+// ContentRef identifies a piece of encrypted content for failure reports
+type ContentRef struct {
+	Kind        string
+	ID          uuid.UUID
+	ContainerID uuid.UUID // channel, conversation or community
+}
+
+// Row is the row the content is stored in, which its ciphertext is bound to
+func (r ContentRef) Row() encryption.Row {
+	return encryption.Row{Table: contentTables[r.Kind], ID: r.ID}
+}
+
+// ChannelRow is the row of a channel message
+func ChannelRow(messageID uuid.UUID) encryption.Row {
+	return ContentRef{Kind: ContentKindChannel, ID: messageID}.Row()
+}
+
+// DMRow is the row of a direct message
+func DMRow(messageID uuid.UUID) encryption.Row {
+	return ContentRef{Kind: ContentKindDM, ID: messageID}.Row()
+}
+
+// DecryptionFailureRecorder stores failures for the admin report
+type DecryptionFailureRecorder interface {
+	RecordDecryptionFailure(ctx context.Context, ref ContentRef, keyVersion string, cause error) error
+}
+
+// poolRecorder records failures straight into PostgreSQL
+type poolRecorder struct {
+	db *pgxpool.Pool
+}
+
+func (r poolRecorder) RecordDecryptionFailure(ctx context.Context, ref ContentRef, keyVersion string, cause error) error {
+	return RecordDecryptionFailure(ctx, r.db, ref, keyVersion, cause)
+}
+
+// DecryptOrPlaceholder decrypts stored content. Failures are reported and the
+// placeholder is returned with ok set to false.
+func DecryptOrPlaceholder(db *pgxpool.Pool, c ContentCipher, ref ContentRef, ciphertext, nonce []byte) (content string, ok bool) {
+	return DecryptOrPlaceholderWith(poolRecorder{db}, c, ref, ciphertext, nonce)
+}
+
+// DecryptOrPlaceholderWith is DecryptOrPlaceholder for services whose storage
+// records the failures
+func DecryptOrPlaceholderWith(rec DecryptionFailureRecorder, c ContentCipher, ref ContentRef, ciphertext, nonce []byte) (content string, ok bool) {
+	content, err := c.Decrypt(ref.Row(), ciphertext, nonce)
+	if err != nil {
+		ReportDecryptionFailureWith(rec, c, ref, err)
+		return DecryptionErrorPlaceholder, false
+	}
+	return content, true
+}
+
+// ReportDecryptionFailure counts and logs a failure and records it for the
+// admin report. The record is written in the background so reads that hit
+// corrupt content are not slowed down further. Every failure is counted, but
+// the same content is logged and recorded at most once a minute.
+func ReportDecryptionFailure(db *pgxpool.Pool, c ContentCipher, ref ContentRef, err error) {
+	ReportDecryptionFailureWith(poolRecorder{db}, c, ref, err)
+}
+
+// ReportDecryptionFailureWith is ReportDecryptionFailure with the record
+// written through rec
+func ReportDecryptionFailureWith(rec DecryptionFailureRecorder, c ContentCipher, ref ContentRef, err error) {
+	keyVersion := c.KeyVersion()
+	// Content wrapped with a key that isn't configured is reported under that key
+	var unknown *encryption.UnknownKeyError
+	if errors.As(err, &unknown) {
+		keyVersion = unknown.KeyID
+	}
+	decryptionFailuresTotal.Inc(ref.Kind, keyVersion)
+	if !reports.allow(ref, time.Now()) {
+		return
+	}
+	log.Error().Err(err).
+		Str("kind", ref.Kind).
+		Str("messageId", ref.ID.String()).
+		Str("containerId", ref.ContainerID.String()).
+		Str("keyVersion", keyVersion).
+		Msg("Failed to decrypt stored content")
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), recordTimeout)
+		defer cancel()
+		if err := rec.RecordDecryptionFailure(ctx, ref, keyVersion, err); err != nil {
+			log.Warn().Err(err).Str("messageId", ref.ID.String()).Msg("Failed to record decryption failure")
+		}
+	}()
+}
+
+// reportThrottle limits how often one piece of content's failure is reported,
+// so reloading a corrupt message can't turn every read into a log line and a
+// database write
+type reportThrottle struct {
+	mu   sync.Mutex
+	seen map[ContentRef]time.Time
+}
+
+var reports = &reportThrottle{seen: make(map[ContentRef]time.Time)}
+
+func (t *reportThrottle) allow(ref ContentRef, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if last, ok := t.seen[ref]; ok && now.Sub(last) < reportInterval {
+		return false
+	}
+	if len(t.seen) >= reportThrottleSize {
+		for other, last := range t.seen {
+			if now.Sub(last) >= reportInterval {
+				delete(t.seen, other)
+			}
+		}
+		// So many failures at once still show up in the counter
+		if len(t.seen) >= reportThrottleSize {
+			return false
+		}
+	}
+	t.seen[ref] = now
+	return true
+}
+
+// RecordDecryptionFailure upserts the failure row for ref. A failure that was
+// already marked unrecoverable stays that way.
+func RecordDecryptionFailure(ctx context.Context, db *pgxpool.Pool, ref ContentRef, keyVersion string, cause error) error {
+	_, err := db.Exec(ctx,
+		`INSERT INTO decryption_failures (kind, content_id, container_id, key_version, error)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (kind, content_id) DO UPDATE
+		 SET key_version = EXCLUDED.key_version,
+		     error = EXCLUDED.error,
+		     occurrences = decryption_failures.occurrences + 1,
+		     last_seen_at = NOW()`,
+		ref.Kind, ref.ID, ref.ContainerID, keyVersion, cause.Error(),
+	)
+	return err
+}
@@ -0,0 +1,121 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+const (
+	// HistoryCacheSize is how many of a channel's newest messages are cached
+	HistoryCacheSize = 100
+
+	defaultHistoryCacheTTL = 2 * time.Minute
+	historyVersionTTL      = 24 * time.Hour
+)
+
+var historyCacheLookupsTotal = metrics.NewCounter("zentra_history_cache_lookups_total",
+	"Newest-page channel history reads by result: hit, miss or error.", "result")
+
+// HistoryCache keeps the newest messages of active channels in Redis,
+// decrypted, so opening a channel doesn't have to query and decrypt them.
+// Anything that depends on who is reading (signed links, own reactions,
+// quarantined messages) is applied after the cache.
+//
+// Entries are stored with the channel's version. Invalidate bumps it, which makes the current entry and any being loaded at the same
+// time stale, so a load racing an edit can't bring the old content back.
+// Author profiles in an entry can be up to the TTL old.
+type HistoryCache struct {
+	redis *redis.Client
+	ttl   time.Duration
+}
+
+// NewHistoryCache keeps entries for ttl, or two minutes when ttl is zero
+func NewHistoryCache(client *redis.Client, ttl time.Duration) *HistoryCache {
+	if ttl <= 0 {
+		ttl = defaultHistoryCacheTTL
+	}
+	return &HistoryCache{redis: client, ttl: ttl}
+}
+
+// Invalidate drops a channel's cached history. Call it after writing anything
+// a history read returns: a message in the channel, its reactions, pin or
+// attachments. A nil HistoryCache does nothing.
+func (c *HistoryCache) Invalidate(ctx context.Context, channelID uuid.UUID) {
+	if c == nil {
+		return
+	}
+	versionKey := historyVersionKey(channelID)
+	pipe := c.redis.TxPipeline()
+	pipe.Incr(ctx, versionKey)
+	pipe.Expire(ctx, versionKey, historyVersionTTL)
+	pipe.Del(ctx, historyKey(channelID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Warn().Err(err).Str("channelId", channelID.String()).Msg("Failed to invalidate channel history cache")
+	}
+}
+
+type historyEntry[T any] struct {
+	Version int64 `json:"v"`
+	Items   []T   `json:"items"`
+}
+
+// CachedHistory returns a channel's newest messages from c, or loads and
+// caches them. load must return the newest HistoryCacheSize messages, newest
+// first. It reports false, without calling load, when c is nil or Redis can't
+// be reached.
+func CachedHistory[T any](ctx context.Context, c *HistoryCache, channelID uuid.UUID, load func(context.Context) ([]T, error)) ([]T, bool, error) {
+	if c == nil {
+		return nil, false, nil
+	}
+
+	values, err := c.redis.MGet(ctx, historyVersionKey(channelID), historyKey(channelID)).Result()
+	if err != nil {
+		historyCacheLookupsTotal.Inc("error")
+		return nil, false, nil
+	}
+	var version int64
+	if s, ok := values[0].(string); ok {
+		version, _ = strconv.ParseInt(s, 10, 64)
+	}
+
+	if raw, ok := values[1].(string); ok {
+		var entry historyEntry[T]
+		if json.Unmarshal([]byte(raw), &entry) == nil && entry.Version == version {
+			historyCacheLookupsTotal.Inc("hit")
+			return entry.Items, true, nil
+		}
+	}
+
+	historyCacheLookupsTotal.Inc("miss")
+	items, err := load(ctx)
+	if err != nil {
+		return nil, true, err
+	}
+	data, err := json.Marshal(historyEntry[T]{Version: version, Items: items})
+	if err != nil {
+		return items, true, nil
+	}
+
+	// Stored under the version read before loading. If the channel was
+	// invalidated meanwhile, the version has moved on and reads ignore this.
+	if err := c.redis.Set(ctx, historyKey(channelID), data, c.ttl).Err(); err != nil {
+		log.Debug().Err(err).Str("channelId", channelID.String()).Msg("Failed to store channel history cache")
+	}
+	return items, true, nil
+}
+
+func historyKey(channelID uuid.UUID) string {
+	return database.KeyPrefixMessageCache + channelID.String()
+}
+
+func historyVersionKey(channelID uuid.UUID) string {
+	return database.KeyPrefixMessageCache + channelID.String() + ":version"
+}
@@ -0,0 +1,53 @@
+package messaging
+
+import (
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/utils"
+)
+
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 100
+)
+
+// historyPosition is what a channel or DM history cursor holds: the message
+// to continue from and which way
+type historyPosition struct {
+	After bool      `json:"a,omitempty"`
+	ID    uuid.UUID `json:"id"`
+}
+
+// HistoryLimit is the page size history reads use for a requested limit
+func HistoryLimit(limit int) int {
+	if limit <= 0 || limit > MaxHistoryLimit {
+		return DefaultHistoryLimit
+	}
+	return limit
+}
+
+// DecodeHistoryCursor returns the message a history cursor continues from,
+// as the before or the after one
+func DecodeHistoryCursor(cursor string) (before, after *uuid.UUID, err error) {
+	var pos historyPosition
+	if err := utils.DecodeCursor(cursor, &pos); err != nil {
+		return nil, nil, err
+	}
+	if pos.ID == uuid.Nil {
+		return nil, nil, utils.ErrInvalidCursor
+	}
+	if pos.After {
+		return nil, &pos.ID, nil
+	}
+	return &pos.ID, nil, nil
+}
+
+// NextHistoryCursor is the cursor of the page after one of count messages
+// read with limit, or "" when it was the last. Pages going back in history
+// end with their oldest message and pages going forward with their newest,
+// so either way last is the one to continue from.
+func NextHistoryCursor(forward bool, count, limit int, last uuid.UUID) string {
+	if count < HistoryLimit(limit) {
+		return ""
+	}
+	return utils.EncodeCursor(historyPosition{After: forward, ID: last})
+}
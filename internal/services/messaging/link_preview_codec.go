@@ -14,6 +14,8 @@ func EncodeLinkPreviews(previews []models.LinkPreview) []byte {
 	return payload
 }
 
+// DecodeLinkPreviews reads stored previews as they are. Services hand them to
+// clients through Camo.LinkPreviews.
 func DecodeLinkPreviews(raw []byte) []models.LinkPreview {
 	if len(raw) == 0 {
 		return nil
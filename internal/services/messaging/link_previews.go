@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/html"
@@ -72,7 +73,8 @@ func FetchLinkPreview(ctx context.Context, urlStr string) (*models.LinkPreview,
 	}
 
 	client := &http.Client{
-		Timeout: linkPreviewTimeout,
+		Timeout:   linkPreviewTimeout,
+		Transport: PublicTransport(),
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			if len(via) >= 5 {
 				return errors.New("too many redirects")
@@ -281,6 +283,37 @@ func firstNonEmpty(values ...string) string {
 	return ""
 }
 
+// ValidatePublicHost rejects hosts that are or resolve to loopback and private
+// addresses. Use it for any URL the server calls on a user's behalf.
+func ValidatePublicHost(ctx context.Context, host string) error {
+	return validatePreviewHost(ctx, host)
+}
+
+// PublicTransport is an HTTP transport that only connects to public
+// addresses. Every connection is checked against the address actually
+// dialed, so a host can't resolve to a public address when validated and a
+// private one when fetched. Proxy settings are ignored for the same reason.
+func PublicTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || isPrivateIP(ip) {
+				return errors.New("blocked ip")
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
 func validatePreviewHost(ctx context.Context, host string) error {
 	if host == "" {
 		return errors.New("missing host")
@@ -315,7 +348,7 @@ func validatePreviewHost(ctx context.Context, host string) error {
 }
 
 func isPrivateIP(ip net.IP) bool {
-	if ip.IsLoopback() || ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast() {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalMulticast() || ip.IsLinkLocalUnicast() {
 		return true
 	}
 
@@ -0,0 +1,65 @@
+package messaging
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NewMessageID returns the ID of a new channel or direct message and the
+// creation time it encodes, which must be stored as the message's created_at.
+//
+// Message IDs are UUIDv7: the first 48 bits are the creation time in
+// milliseconds, so IDs sort in the order messages were written, and history
+// pages over them on the ID alone. Because created_at is taken from the ID, a
+// page cursor also carries the time that bounds which partitions are read.
+// Messages from before sortable IDs keep their random UUIDs and are paged on
+// (created_at, id); they are all older than the sortable ones.
+func NewMessageID() (uuid.UUID, time.Time) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		// Only fails when the system random source does
+		panic(err)
+	}
+	createdAt, _ := MessageIDTime(id)
+	return id, createdAt
+}
+
+// MessageIDAt returns a sortable ID for a message created at t, for history
+// brought in from elsewhere, and t cut to the millisecond the ID encodes,
+// which must be stored as created_at like with NewMessageID. Unlike
+// NewMessageID, IDs made for the same millisecond aren't ordered among
+// themselves.
+func MessageIDAt(t time.Time) (uuid.UUID, time.Time) {
+	var id uuid.UUID
+	if _, err := rand.Read(id[6:]); err != nil {
+		panic(err)
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(id[:6], ms[2:])
+	id[6] = 0x70 | id[6]&0x0f // version 7
+	id[8] = 0x80 | id[8]&0x3f // RFC 4122 variant
+	createdAt, _ := MessageIDTime(id)
+	return id, createdAt
+}
+
+// MessageIDTime returns the creation time encoded in a message ID. It reports
+// false for IDs made before messages had sortable IDs.
+func MessageIDTime(id uuid.UUID) (time.Time, bool) {
+	if id.Version() != 7 || id.Variant() != uuid.RFC4122 {
+		return time.Time{}, false
+	}
+	var ms [8]byte
+	copy(ms[2:], id[:6])
+	return time.UnixMilli(int64(binary.BigEndian.Uint64(ms[:]))), true
+}
+
+// SortableID is an SQL condition that holds when column is a UUIDv7. It's
+// the predicate of the ID order indexes of migration 000075, so history
+// queries use it verbatim to be able to read them.
+func SortableID(column string) string {
+	return "substr(" + column + "::text, 15, 1) = '7'"
+}
@@ -0,0 +1,57 @@
+package messaging
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// MaxReactionsPerUser caps the distinct emoji one user can put on a message
+	MaxReactionsPerUser = 20
+
+	// Server-wide reaction limit per user, across channels and DMs
+	reactionRateLimit  = 20
+	reactionRateWindow = 10 * time.Second
+)
+
+// RateLimitError rejects an action until RetryAfter has passed. Code is the
+// API error code clients switch on.
+type RateLimitError struct {
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Message
+}
+
+// CheckReactionRate counts a reaction against the user's server-wide limit.
+// Redis errors fail open.
+func CheckReactionRate(ctx context.Context, redisClient *redis.Client, userID uuid.UUID) error {
+	count, ttl, err := HitWindow(ctx, redisClient, "ratelimit:reactions:"+userID.String(), reactionRateWindow)
+	if err != nil || count <= reactionRateLimit {
+		return nil
+	}
+	return &RateLimitError{
+		Code:       "REACTION_RATE_LIMITED",
+		Message:    "You are reacting too quickly",
+		RetryAfter: ttl,
+	}
+}
+
+// HitWindow increments a fixed-window counter that starts with the first hit,
+// and returns the count and the time left in the window
+func HitWindow(ctx context.Context, redisClient *redis.Client, key string, window time.Duration) (int64, time.Duration, error) {
+	pipe := redisClient.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, window)
+	ttl := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	return incr.Val(), ttl.Val(), nil
+}
@@ -0,0 +1,90 @@
+package messaging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// Longer words are cut, so a pasted hash or URL doesn't make a token of its own
+	maxSearchWordLength = 64
+	// Tokens kept per message; the rest of a very long message isn't indexed
+	maxSearchTokens = 512
+	searchTokenSize = 12
+)
+
+// SearchIndex turns message content into search tokens: an HMAC of the
+// channel and each normalized word under a key only the server holds. Tokens
+// are stored next to the encrypted content, so a search can be matched in the
+// database without decrypting anything, while the stored tokens don't reveal
+// the words. The same word always gives the same token within a channel, so
+// they do show which messages of a channel share a word, but a word can't be
+// followed from one channel to another.
+type SearchIndex struct {
+	key []byte
+}
+
+// NewSearchIndex derives tokens with key. Changing the key invalidates every
+// stored token until the history is indexed again.
+func NewSearchIndex(key []byte) *SearchIndex {
+	return &SearchIndex{key: key}
+}
+
+// Tokens returns the distinct tokens of the words of content posted in
+// channelID, in the order they first appear. A search query is tokenized the
+// same way, and a message matches when it has every token of the query. A nil
+// SearchIndex returns nil, leaving messages for the backfill to index.
+func (i *SearchIndex) Tokens(channelID uuid.UUID, content string) []string {
+	if i == nil {
+		return nil
+	}
+	words := searchWords(content)
+	tokens := make([]string, 0, len(words))
+	for _, word := range words {
+		mac := hmac.New(sha256.New, i.key)
+		mac.Write(channelID[:])
+		mac.Write([]byte(word))
+		tokens = append(tokens, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:searchTokenSize]))
+	}
+	return tokens
+}
+
+// searchWords splits text into distinct lowercase words without accents, so
+// "Café" and "cafe" match. Single letters are left out.
+func searchWords(text string) []string {
+	var folded strings.Builder
+	for _, r := range norm.NFKD.String(text) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		folded.WriteRune(unicode.ToLower(r))
+	}
+
+	fields := strings.FieldsFunc(folded.String(), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	seen := make(map[string]struct{}, len(fields))
+	words := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if runes := []rune(field); len(runes) < 2 {
+			continue
+		} else if len(runes) > maxSearchWordLength {
+			field = string(runes[:maxSearchWordLength])
+		}
+		if _, ok := seen[field]; ok {
+			continue
+		}
+		seen[field] = struct{}{}
+		words = append(words, field)
+		if len(words) == maxSearchTokens {
+			break
+		}
+	}
+	return words
+}
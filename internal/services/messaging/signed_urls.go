@@ -0,0 +1,153 @@
+package messaging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+)
+
+// Links in events fan out to everyone watching a channel or conversation, so
+// they aren't tied to a viewer and expire sooner
+const UnboundURLTTL = 10 * time.Minute
+
+var (
+	ErrInvalidURLSignature = errors.New("invalid attachment URL signature")
+	ErrExpiredURL          = errors.New("attachment URL has expired")
+)
+
+// URLSigner rewrites attachment URLs into expiring links to the file proxy,
+// for deployments that keep the attachment bucket private. A link handed to a
+// signed-in member names them, so the proxy can check they still have access
+// when the attachment is in a sensitive channel. A nil signer leaves URLs as
+// they are.
+type URLSigner struct {
+	secret     []byte
+	ttl        time.Duration
+	objectBase string
+	proxyBase  string
+}
+
+// NewURLSigner signs URLs of objects in bucket, which are stored as
+// cdnBaseURL/bucket/object, as links to the file proxy at proxyBaseURL
+func NewURLSigner(secret string, ttl time.Duration, cdnBaseURL, bucket, proxyBaseURL string) *URLSigner {
+	baseURL := strings.TrimSuffix(cdnBaseURL, "/")
+	baseURL = strings.TrimSuffix(baseURL, "/"+bucket)
+	return &URLSigner{
+		secret:     []byte(secret),
+		ttl:        ttl,
+		objectBase: baseURL + "/" + bucket + "/",
+		proxyBase:  strings.TrimSuffix(proxyBaseURL, "/") + "/",
+	}
+}
+
+// ObjectName returns the object a stored or signed URL points to
+func (s *URLSigner) ObjectName(rawURL string) (string, bool) {
+	if name, ok := strings.CutPrefix(rawURL, s.objectBase); ok && name != "" {
+		return name, true
+	}
+	if rest, ok := strings.CutPrefix(rawURL, s.proxyBase); ok {
+		rest, _, _ = strings.Cut(rest, "?")
+		name, err := url.PathUnescape(rest)
+		return name, err == nil && name != ""
+	}
+	return "", false
+}
+
+// Sign returns an expiring link to the object rawURL points to, tied to viewer
+// unless it's uuid.Nil. URLs outside the attachment bucket are returned as
+// they are.
+func (s *URLSigner) Sign(rawURL string, viewer uuid.UUID) string {
+	if s == nil {
+		return rawURL
+	}
+	objectName, ok := s.ObjectName(rawURL)
+	if !ok {
+		return rawURL
+	}
+
+	ttl := s.ttl
+	if viewer == uuid.Nil {
+		ttl = min(ttl, UnboundURLTTL)
+	}
+	// Expiry is rounded so the same file gets the same link for a while and
+	// stays in clients' caches; every link is good for at least the TTL
+	expires := time.Now().Add(ttl).Truncate(ttl / 2).Add(ttl / 2).Unix()
+
+	query := url.Values{}
+	query.Set("exp", strconv.FormatInt(expires, 10))
+	if viewer != uuid.Nil {
+		query.Set("uid", viewer.String())
+	}
+	query.Set("sig", s.signature(objectName, expires, viewer))
+	return s.proxyBase + (&url.URL{Path: objectName}).EscapedPath() + "?" + query.Encode()
+}
+
+// Verify checks a signed link's query and returns the viewer it was issued
+// to, which is uuid.Nil for links from events, and when it expires
+func (s *URLSigner) Verify(objectName string, query url.Values) (uuid.UUID, time.Time, error) {
+	expires, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return uuid.Nil, time.Time{}, ErrInvalidURLSignature
+	}
+	viewer := uuid.Nil
+	if raw := query.Get("uid"); raw != "" {
+		if viewer, err = uuid.Parse(raw); err != nil {
+			return uuid.Nil, time.Time{}, ErrInvalidURLSignature
+		}
+	}
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(s.signature(objectName, expires, viewer))) {
+		return uuid.Nil, time.Time{}, ErrInvalidURLSignature
+	}
+	if time.Now().Unix() > expires {
+		return uuid.Nil, time.Time{}, ErrExpiredURL
+	}
+	return viewer, time.Unix(expires, 0), nil
+}
+
+// SignAttachments returns a copy of attachments with their file and rendition
+// URLs signed for viewer
+func (s *URLSigner) SignAttachments(attachments []models.MessageAttachment, viewer uuid.UUID) []models.MessageAttachment {
+	if s == nil || len(attachments) == 0 {
+		return attachments
+	}
+	signed := make([]models.MessageAttachment, len(attachments))
+	for i := range attachments {
+		signed[i] = attachments[i]
+		s.SignAttachment(&signed[i], viewer)
+	}
+	return signed
+}
+
+// SignAttachment signs the file and rendition URLs of a in place
+func (s *URLSigner) SignAttachment(a *models.MessageAttachment, viewer uuid.UUID) {
+	if s == nil {
+		return
+	}
+	a.FileURL = s.Sign(a.FileURL, viewer)
+	for _, u := range []**string{&a.ThumbnailURL, &a.MediumURL, &a.VideoURL, &a.PosterURL} {
+		if *u != nil {
+			signed := s.Sign(**u, viewer)
+			*u = &signed
+		}
+	}
+}
+
+func (s *URLSigner) signature(objectName string, expires int64, viewer uuid.UUID) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(objectName))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	mac.Write([]byte{'\n'})
+	if viewer != uuid.Nil {
+		mac.Write([]byte(viewer.String()))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
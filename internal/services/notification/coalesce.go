@@ -0,0 +1,67 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+)
+
+// A notification like an unread one from the same channel or conversation
+// within this long of its last update is merged into it
+const coalesceWindow = 2 * time.Minute
+
+// coalesceKey groups notifications that can be merged, or nil for ones that
+// always stand alone. Moderator alerts are each worth looking at.
+func coalesceKey(n *models.Notification) *string {
+	if n.Type == models.NotificationTypeModAlert {
+		return nil
+	}
+	if conversationID := metadataUUID(n.Metadata, "conversationId"); conversationID != nil {
+		// Missed calls stack up on their own rather than under the messages
+		if n.Type == models.NotificationTypeMissedCall {
+			return strPtr("call:" + conversationID.String())
+		}
+		return strPtr("dm:" + conversationID.String())
+	}
+	if n.ChannelID != nil {
+		return strPtr("channel:" + n.ChannelID.String() + ":" + string(n.Type))
+	}
+	return nil
+}
+
+// coalesce merges n into the recipient's latest matching unread notification
+// and reports whether it did, filling in the merged row's ID, count and
+// timestamps. Notifications already mailed are left alone so the new event
+// still makes it into an email digest, and held ones only merge with held
+// ones so quiet hours don't swallow or leak anything.
+func (s *Service) coalesce(ctx context.Context, n *models.Notification, key *string, metaJSON string, held bool) (bool, error) {
+	if key == nil {
+		return false, nil
+	}
+
+	err := s.db.QueryRow(ctx, `
+		UPDATE notifications SET
+			title = $4, body = $5, message_id = $6, actor_id = $7,
+			metadata = $8::jsonb, count = count + 1, updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM notifications
+			WHERE user_id = $1 AND coalesce_key = $2 AND is_read = FALSE
+			  AND emailed_at IS NULL AND (held_until IS NOT NULL) = $3
+			  AND updated_at > $9
+			ORDER BY updated_at DESC
+			LIMIT 1
+			FOR UPDATE
+		)
+		RETURNING id, count, created_at, updated_at`,
+		n.UserID, *key, held,
+		n.Title, n.Body, n.MessageID, n.ActorID,
+		metaJSON, time.Now().Add(-coalesceWindow),
+	).Scan(&n.ID, &n.Count, &n.CreatedAt, &n.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -2,7 +2,6 @@ package notification
 
 import (
 	"net/http"
-	"strconv"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
@@ -25,9 +24,18 @@ func (h *Handler) Routes() chi.Router {
 	r := chi.NewRouter()
 
 	r.Get("/", h.ListNotifications)
+	r.Delete("/", h.ClearNotifications)
 	r.Get("/unread-count", h.GetUnreadCount)
 	r.Post("/read-all", h.MarkAllRead)
 
+	// Web Push
+	r.Route("/push", func(r chi.Router) {
+		r.Get("/", h.GetPushSettings)
+		r.Patch("/preferences", h.UpdatePushPreferences)
+		r.Post("/subscriptions", h.RegisterPushSubscription)
+		r.Delete("/subscriptions/{subscriptionId}", h.DeletePushSubscription)
+	})
+
 	r.Route("/{id}", func(r chi.Router) {
 		r.Post("/read", h.MarkRead)
 		r.Delete("/", h.DeleteNotification)
@@ -39,7 +47,7 @@ func (h *Handler) Routes() chi.Router {
 	return r
 }
 
-// GET /notifications?page=1&limit=50
+// GET /notifications?cursor=&limit=50
 func (h *Handler) ListNotifications(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -47,23 +55,18 @@ func (h *Handler) ListNotifications(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
-	}
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	if limit < 1 || limit > 100 {
-		limit = 50
-	}
-	offset := (page - 1) * limit
-
-	notifications, total, err := h.service.GetNotifications(r.Context(), userID, limit, offset)
+	page := utils.GetCursorParams(r, 50, 100)
+	notifications, next, err := h.service.GetNotifications(r.Context(), userID, page.Cursor, page.Limit)
 	if err != nil {
+		if err == utils.ErrInvalidCursor {
+			utils.RespondInvalidCursor(w)
+			return
+		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to fetch notifications")
 		return
 	}
 
-	utils.RespondPaginated(w, notifications, total, page, limit)
+	utils.RespondCursorPage(w, notifications, next)
 }
 
 // GET /notifications/unread-count
@@ -126,6 +129,22 @@ func (h *Handler) MarkAllRead(w http.ResponseWriter, r *http.Request) {
 	utils.RespondNoContent(w)
 }
 
+// DELETE /notifications
+func (h *Handler) ClearNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if err := h.service.ClearAll(r.Context(), userID); err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to clear notifications")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
 // DELETE /notifications/{id}
 func (h *Handler) DeleteNotification(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
@@ -175,3 +194,98 @@ func (h *Handler) GetMessageMentions(w http.ResponseWriter, r *http.Request) {
 
 	utils.RespondSuccess(w, mentions)
 }
+
+// GET /notifications/push
+func (h *Handler) GetPushSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	settings, err := h.service.GetPushSettings(r.Context(), userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get push settings")
+		return
+	}
+
+	utils.RespondSuccess(w, settings)
+}
+
+// PATCH /notifications/push/preferences
+func (h *Handler) UpdatePushPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req UpdatePushPreferencesRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	prefs, err := h.service.UpdatePushPreferences(r.Context(), userID, &req)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to update push preferences")
+		return
+	}
+
+	utils.RespondSuccess(w, prefs)
+}
+
+// POST /notifications/push/subscriptions
+func (h *Handler) RegisterPushSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req PushSubscriptionRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	sub, err := h.service.RegisterPushSubscription(r.Context(), userID, &req, r.UserAgent())
+	if err != nil {
+		switch err {
+		case ErrPushNotConfigured:
+			utils.RespondError(w, http.StatusNotImplemented, err.Error())
+		case ErrInvalidPushSubscription:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to register push subscription")
+		}
+		return
+	}
+
+	utils.RespondCreated(w, sub)
+}
+
+// DELETE /notifications/push/subscriptions/{subscriptionId}
+func (h *Handler) DeletePushSubscription(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	subscriptionID, err := uuid.Parse(chi.URLParam(r, "subscriptionId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid subscription ID")
+		return
+	}
+
+	if err := h.service.DeletePushSubscription(r.Context(), userID, subscriptionID); err != nil {
+		switch err {
+		case ErrNotFound:
+			utils.RespondError(w, http.StatusNotFound, "Subscription not found")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to delete push subscription")
+		}
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
@@ -0,0 +1,148 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+)
+
+// defaultPriorities is how loudly each category is delivered unless the user
+// chose otherwise.
+var defaultPriorities = map[models.NotificationCategory]models.NotificationPriority{
+	models.NotificationCategoryMention:       models.NotificationPriorityHigh,
+	models.NotificationCategoryDirectMessage: models.NotificationPriorityHigh,
+	models.NotificationCategoryModeration:    models.NotificationPriorityHigh,
+	models.NotificationCategoryReply:         models.NotificationPriorityNormal,
+	models.NotificationCategoryMassMention:   models.NotificationPriorityNormal,
+	models.NotificationCategoryAnnouncement:  models.NotificationPriorityLow,
+}
+
+// preferences are the parts of a user's settings that affect notification
+// payloads. Priorities come from settings_json:
+//
+//	{"notifications": {"priorities": {"mention": "low", "reply": "silent"}}}
+type preferences struct {
+	SoundEnabled bool
+	Priorities   map[models.NotificationCategory]models.NotificationPriority
+	QuietHours   models.QuietHours
+}
+
+func (s *Service) loadPreferences(ctx context.Context, userID uuid.UUID) preferences {
+	prefs := preferences{SoundEnabled: true}
+
+	var raw []byte
+	err := s.db.QueryRow(ctx,
+		`SELECT sound_enabled, settings_json->'notifications',
+			quiet_hours_enabled, quiet_hours_start, quiet_hours_end, quiet_hours_timezone
+		FROM user_settings WHERE user_id = $1`,
+		userID,
+	).Scan(&prefs.SoundEnabled, &raw,
+		&prefs.QuietHours.Enabled, &prefs.QuietHours.StartMinute,
+		&prefs.QuietHours.EndMinute, &prefs.QuietHours.Timezone)
+	if err != nil {
+		return preferences{SoundEnabled: true}
+	}
+	if len(raw) == 0 {
+		return prefs
+	}
+
+	var stored struct {
+		Priorities map[models.NotificationCategory]models.NotificationPriority `json:"priorities"`
+	}
+	if json.Unmarshal(raw, &stored) == nil {
+		prefs.Priorities = stored.Priorities
+	}
+	return prefs
+}
+
+// decorate fills in the computed category, priority and route of n.
+func decorate(n *models.Notification, prefs preferences) {
+	n.Category = categoryOf(n)
+
+	n.Priority = defaultPriorities[n.Category]
+	if p, ok := prefs.Priorities[n.Category]; ok && p.IsValid() {
+		n.Priority = p
+	}
+	if n.Priority == "" {
+		n.Priority = models.NotificationPriorityNormal
+	}
+	if !prefs.SoundEnabled {
+		n.Priority = models.NotificationPrioritySilent
+	}
+
+	n.Route = routeOf(n)
+}
+
+func categoryOf(n *models.Notification) models.NotificationCategory {
+	switch n.Type {
+	case models.NotificationTypeMentionUser, models.NotificationTypeMentionRole:
+		return models.NotificationCategoryMention
+	case models.NotificationTypeMentionEveryone, models.NotificationTypeMentionHere:
+		return models.NotificationCategoryMassMention
+	case models.NotificationTypeReply:
+		return models.NotificationCategoryReply
+	case models.NotificationTypeDMMessage:
+		// Community announcement DMs carry the community they came from
+		if n.CommunityID != nil {
+			return models.NotificationCategoryAnnouncement
+		}
+		return models.NotificationCategoryDirectMessage
+	case models.NotificationTypeMissedCall:
+		return models.NotificationCategoryDirectMessage
+	case models.NotificationTypeModAlert:
+		return models.NotificationCategoryModeration
+	}
+	return models.NotificationCategoryMention
+}
+
+func routeOf(n *models.Notification) *models.NotificationRoute {
+	if conversationID := metadataUUID(n.Metadata, "conversationId"); conversationID != nil {
+		path := fmt.Sprintf("/dms/%s", conversationID)
+		if n.MessageID != nil {
+			path += fmt.Sprintf("/messages/%s", n.MessageID)
+		}
+		return &models.NotificationRoute{
+			Kind:           models.NotificationRouteDM,
+			Path:           path,
+			CommunityID:    n.CommunityID,
+			ConversationID: conversationID,
+			MessageID:      n.MessageID,
+		}
+	}
+
+	if n.CommunityID == nil {
+		return nil
+	}
+
+	route := &models.NotificationRoute{
+		Kind:        models.NotificationRouteCommunity,
+		Path:        fmt.Sprintf("/communities/%s", n.CommunityID),
+		CommunityID: n.CommunityID,
+	}
+	if n.ChannelID != nil {
+		route.Kind = models.NotificationRouteChannel
+		route.Path += fmt.Sprintf("/channels/%s", n.ChannelID)
+		route.ChannelID = n.ChannelID
+		if n.MessageID != nil {
+			route.Kind = models.NotificationRouteMessage
+			route.Path += fmt.Sprintf("/messages/%s", n.MessageID)
+			route.MessageID = n.MessageID
+		}
+	}
+	return route
+}
+
+func metadataUUID(metadata map[string]any, key string) *uuid.UUID {
+	raw, ok := metadata[key].(string)
+	if !ok {
+		return nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
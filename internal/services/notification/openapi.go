@@ -0,0 +1,67 @@
+package notification
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+
+	openapi.Describe((*Handler).ListNotifications, openapi.Operation{
+		Summary:  "Page through the current user's notifications",
+		Query:    openapi.CursorQuery,
+		Response: []*models.Notification{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).ClearNotifications, openapi.Operation{
+		Summary: "Delete all of the current user's notifications",
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetUnreadCount, openapi.Operation{
+		Summary:  "Count unread notifications",
+		Response: map[string]int64{},
+	})
+	openapi.Describe((*Handler).MarkAllRead, openapi.Operation{
+		Summary: "Mark all notifications read",
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).MarkRead, openapi.Operation{
+		Summary: "Mark a notification read",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).DeleteNotification, openapi.Operation{
+		Summary: "Delete a notification",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetMessageMentions, openapi.Operation{
+		Summary:  "List the mentions in a message",
+		Path:     []openapi.Param{openapi.ID("messageId")},
+		Response: []*models.MessageMention{},
+	})
+
+	openapi.Describe((*Handler).GetPushSettings, openapi.Operation{
+		Summary:  "Get Web Push settings and subscriptions",
+		Response: PushSettings{},
+	})
+	openapi.Describe((*Handler).UpdatePushPreferences, openapi.Operation{
+		Summary:  "Choose which notifications are pushed",
+		Body:     UpdatePushPreferencesRequest{},
+		Response: models.PushPreferences{},
+	})
+	openapi.Describe((*Handler).RegisterPushSubscription, openapi.Operation{
+		Summary:  "Register a Web Push subscription",
+		Body:     PushSubscriptionRequest{},
+		Response: models.PushSubscription{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).DeletePushSubscription, openapi.Operation{
+		Summary: "Remove a Web Push subscription",
+		Path:    []openapi.Param{openapi.ID("subscriptionId")},
+		Status:  http.StatusNoContent,
+	})
+}
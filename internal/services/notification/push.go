@@ -0,0 +1,453 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+const (
+	pushWorkers   = 4
+	pushQueueSize = 1024
+	pushTTL       = 24 * time.Hour
+
+	// Oldest subscriptions are dropped past this many per user
+	maxPushSubscriptions = 10
+	// A subscription that keeps failing is dropped
+	maxPushFailures = 5
+)
+
+var (
+	ErrPushNotConfigured       = errors.New("web push is not configured on this server")
+	ErrInvalidPushSubscription = errors.New("invalid push subscription")
+)
+
+// PushSubscriptionRequest is the browser's PushSubscription.toJSON()
+type PushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint" validate:"required,url,max=2048"`
+	Keys     struct {
+		P256DH string `json:"p256dh" validate:"required,max=128"`
+		Auth   string `json:"auth" validate:"required,max=64"`
+	} `json:"keys"`
+	// Milliseconds since the epoch, null when the subscription doesn't expire
+	ExpirationTime *int64 `json:"expirationTime"`
+}
+
+// UpdatePushPreferencesRequest changes push preferences. Omitted fields are kept.
+type UpdatePushPreferencesRequest struct {
+	Enabled        *bool `json:"enabled"`
+	Mentions       *bool `json:"mentions"`
+	DirectMessages *bool `json:"directMessages"`
+}
+
+// PushSettings is what a client needs to subscribe and show the toggles
+type PushSettings struct {
+	Available      bool                       `json:"available"`
+	VAPIDPublicKey string                     `json:"vapidPublicKey,omitempty"`
+	Preferences    *models.PushPreferences    `json:"preferences"`
+	Subscriptions  []*models.PushSubscription `json:"subscriptions"`
+}
+
+// SetWebPush enables Web Push with the server's VAPID key and starts the
+// workers that deliver pushes.
+func (s *Service) SetWebPush(cfg WebPushConfig) error {
+	keys, err := parseVAPIDKeys(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.vapid = keys
+	// Endpoints are checked when subscribed, but the host could resolve
+	// elsewhere by the time a push is sent, so every dial is checked too
+	s.pushClient = &http.Client{
+		Timeout:   pushTimeout,
+		Transport: messaging.PublicTransport(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	s.pushQueue = make(chan models.Notification, pushQueueSize)
+	for i := 0; i < pushWorkers; i++ {
+		go s.pushWorker()
+	}
+	return nil
+}
+
+// SetMobilePush hands pushable notifications to native app delivery as well
+func (s *Service) SetMobilePush(p MobilePusher) {
+	s.mobilePush = p
+}
+
+// PushEnabled reports whether a VAPID key is configured
+func (s *Service) PushEnabled() bool {
+	return s.vapid != nil
+}
+
+// GetPushSettings returns the VAPID key, the user's preferences and their subscriptions
+func (s *Service) GetPushSettings(ctx context.Context, userID uuid.UUID) (*PushSettings, error) {
+	prefs, err := s.GetPushPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	subs, err := s.listPushSubscriptions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &PushSettings{Available: s.PushEnabled(), Preferences: prefs, Subscriptions: subs}
+	if s.vapid != nil {
+		settings.VAPIDPublicKey = s.vapid.publicKey
+	}
+	return settings, nil
+}
+
+// GetPushPreferences returns the user's push toggles, all on by default
+func (s *Service) GetPushPreferences(ctx context.Context, userID uuid.UUID) (*models.PushPreferences, error) {
+	prefs := &models.PushPreferences{Enabled: true, Mentions: true, DirectMessages: true}
+	err := s.db.QueryRow(ctx,
+		`SELECT enabled, mentions, direct_messages FROM push_preferences WHERE user_id = $1`,
+		userID,
+	).Scan(&prefs.Enabled, &prefs.Mentions, &prefs.DirectMessages)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// UpdatePushPreferences changes the user's push toggles
+func (s *Service) UpdatePushPreferences(ctx context.Context, userID uuid.UUID, req *UpdatePushPreferencesRequest) (*models.PushPreferences, error) {
+	prefs, err := s.GetPushPreferences(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if req.Enabled != nil {
+		prefs.Enabled = *req.Enabled
+	}
+	if req.Mentions != nil {
+		prefs.Mentions = *req.Mentions
+	}
+	if req.DirectMessages != nil {
+		prefs.DirectMessages = *req.DirectMessages
+	}
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO push_preferences (user_id, enabled, mentions, direct_messages, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			mentions = EXCLUDED.mentions,
+			direct_messages = EXCLUDED.direct_messages,
+			updated_at = EXCLUDED.updated_at`,
+		userID, prefs.Enabled, prefs.Mentions, prefs.DirectMessages,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
+
+// RegisterPushSubscription stores a browser subscription. Subscribing again
+// with the same endpoint refreshes its keys, and moves it to this user if the
+// browser was signed in as someone else.
+func (s *Service) RegisterPushSubscription(ctx context.Context, userID uuid.UUID, req *PushSubscriptionRequest, userAgent string) (*models.PushSubscription, error) {
+	if !s.PushEnabled() {
+		return nil, ErrPushNotConfigured
+	}
+
+	endpoint, err := url.Parse(req.Endpoint)
+	if err != nil || endpoint.Scheme != "https" {
+		return nil, ErrInvalidPushSubscription
+	}
+	if err := messaging.ValidatePublicHost(ctx, endpoint.Hostname()); err != nil {
+		return nil, ErrInvalidPushSubscription
+	}
+	if key, err := decodeBase64URL(req.Keys.P256DH); err != nil || len(key) != 65 {
+		return nil, ErrInvalidPushSubscription
+	}
+	if auth, err := decodeBase64URL(req.Keys.Auth); err != nil || len(auth) != 16 {
+		return nil, ErrInvalidPushSubscription
+	}
+
+	var expiresAt *time.Time
+	if req.ExpirationTime != nil {
+		t := time.UnixMilli(*req.ExpirationTime)
+		if t.Before(time.Now()) {
+			return nil, ErrInvalidPushSubscription
+		}
+		expiresAt = &t
+	}
+	var ua *string
+	if userAgent = strings.TrimSpace(userAgent); userAgent != "" {
+		ua = strPtr(truncate(userAgent, 250))
+	}
+
+	sub := &models.PushSubscription{UserID: userID, Endpoint: req.Endpoint, P256DH: req.Keys.P256DH, Auth: req.Keys.Auth, UserAgent: ua, ExpiresAt: expiresAt}
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (endpoint) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			p256dh = EXCLUDED.p256dh,
+			auth = EXCLUDED.auth,
+			user_agent = EXCLUDED.user_agent,
+			expires_at = EXCLUDED.expires_at,
+			failure_count = 0,
+			updated_at = NOW()
+		RETURNING id, last_success_at, created_at`,
+		userID, sub.Endpoint, sub.P256DH, sub.Auth, sub.UserAgent, sub.ExpiresAt,
+	).Scan(&sub.ID, &sub.LastSuccessAt, &sub.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(ctx,
+		`DELETE FROM push_subscriptions WHERE id IN (
+			SELECT id FROM push_subscriptions WHERE user_id = $1
+			ORDER BY updated_at DESC OFFSET $2
+		)`,
+		userID, maxPushSubscriptions,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("userId", userID.String()).Msg("Failed to trim push subscriptions")
+	}
+
+	return sub, nil
+}
+
+// DeletePushSubscription removes one of the user's subscriptions
+func (s *Service) DeletePushSubscription(ctx context.Context, userID, subscriptionID uuid.UUID) error {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM push_subscriptions WHERE id = $1 AND user_id = $2`,
+		subscriptionID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PruneExpiredPushSubscriptions drops subscriptions past their expiration time
+func (s *Service) PruneExpiredPushSubscriptions(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM push_subscriptions WHERE expires_at < NOW()`)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (s *Service) listPushSubscriptions(ctx context.Context, userID uuid.UUID) ([]*models.PushSubscription, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, user_id, endpoint, p256dh, auth, user_agent, expires_at, last_success_at, created_at
+		FROM push_subscriptions
+		WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []*models.PushSubscription{}
+	for rows.Next() {
+		sub := &models.PushSubscription{}
+		if err := rows.Scan(
+			&sub.ID, &sub.UserID, &sub.Endpoint, &sub.P256DH, &sub.Auth,
+			&sub.UserAgent, &sub.ExpiresAt, &sub.LastSuccessAt, &sub.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// queuePush hands a notification to the push workers when it is worth a push
+// and the recipient has no live connection to see it on.
+func (s *Service) queuePush(n models.Notification) {
+	if s.pushQueue == nil && s.mobilePush == nil {
+		return
+	}
+	if n.Priority == models.NotificationPrioritySilent || pushToggle(n.Category) == "" {
+		return
+	}
+	if s.hub.IsUserOnline(n.UserID) {
+		return
+	}
+
+	if s.mobilePush != nil {
+		s.mobilePush.Notify(n)
+	}
+	if s.pushQueue == nil {
+		return
+	}
+	select {
+	case s.pushQueue <- n:
+	default:
+		log.Warn().Str("userId", n.UserID.String()).Msg("Push queue full, dropping push")
+	}
+}
+
+func (s *Service) pushWorker() {
+	for n := range s.pushQueue {
+		s.dispatchPush(context.Background(), n)
+	}
+}
+
+// dispatchPush sends n to every subscription of the recipient that the
+// preferences allow
+func (s *Service) dispatchPush(ctx context.Context, n models.Notification) {
+	if !s.PushAllowed(ctx, &n) {
+		return
+	}
+
+	subs, err := s.listPushSubscriptions(ctx, n.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("userId", n.UserID.String()).Msg("Failed to load push subscriptions")
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(pushPayload(&n))
+	if err != nil {
+		return
+	}
+
+	for _, sub := range subs {
+		err := s.sendPush(ctx, pushMessage{
+			Endpoint: sub.Endpoint,
+			P256DH:   sub.P256DH,
+			Auth:     sub.Auth,
+			Payload:  payload,
+			TTL:      pushTTL,
+			Urgency:  pushUrgency(n.Priority),
+			Topic:    pushTopic(&n),
+		})
+		RecordPushResult(ctx, s.db, "push_subscriptions", sub.ID, err, errors.Is(err, errPushGone), maxPushFailures)
+	}
+}
+
+// PushAllowed checks n against the recipient's push preferences. Web and
+// mobile pushes share them.
+func (s *Service) PushAllowed(ctx context.Context, n *models.Notification) bool {
+	prefs, err := s.GetPushPreferences(ctx, n.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("userId", n.UserID.String()).Msg("Failed to load push preferences")
+		return false
+	}
+	if !prefs.Enabled {
+		return false
+	}
+	switch pushToggle(n.Category) {
+	case "mentions":
+		return prefs.Mentions
+	case "directMessages":
+		return prefs.DirectMessages
+	}
+	return false
+}
+
+// RecordPushResult updates a Web Push subscription or a mobile device after a
+// send. table holds the targets and has failure_count and last_success_at
+// columns. A target the push service rejected for good (gone) is deleted, and
+// so is one that failed maxFailures times in a row.
+func RecordPushResult(ctx context.Context, db *pgxpool.Pool, table string, id uuid.UUID, sendErr error, gone bool, maxFailures int) {
+	name := pgx.Identifier{table}.Sanitize()
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = db.Exec(ctx,
+			`UPDATE `+name+` SET failure_count = 0, last_success_at = NOW() WHERE id = $1`,
+			id,
+		)
+	case gone:
+		_, err = db.Exec(ctx, `DELETE FROM `+name+` WHERE id = $1`, id)
+	default:
+		log.Debug().Err(sendErr).Str("table", table).Str("id", id.String()).Msg("Push failed")
+		_, err = db.Exec(ctx,
+			`WITH failed AS (
+				UPDATE `+name+` SET failure_count = failure_count + 1 WHERE id = $1
+				RETURNING id, failure_count
+			)
+			DELETE FROM `+name+` WHERE id IN (SELECT id FROM failed WHERE failure_count >= $2)`,
+			id, maxFailures,
+		)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("table", table).Str("id", id.String()).Msg("Failed to record push result")
+	}
+}
+
+// pushToggle is the preference that controls a category, or "" when the
+// category is never pushed
+func pushToggle(category models.NotificationCategory) string {
+	switch category {
+	case models.NotificationCategoryMention, models.NotificationCategoryMassMention, models.NotificationCategoryReply:
+		return "mentions"
+	case models.NotificationCategoryDirectMessage, models.NotificationCategoryAnnouncement:
+		return "directMessages"
+	}
+	return ""
+}
+
+// pushPayload is what the service worker receives. Keys stay small; pushes
+// are capped at about 4KB.
+func pushPayload(n *models.Notification) map[string]any {
+	payload := map[string]any{
+		"id":       n.ID,
+		"type":     n.Type,
+		"category": n.Category,
+		"title":    n.Title,
+	}
+	if n.Body != nil {
+		payload["body"] = *n.Body
+	}
+	if n.Route != nil {
+		payload["route"] = n.Route.Path
+	}
+	if topic := pushTopic(n); topic != "" {
+		payload["tag"] = topic
+	}
+	return payload
+}
+
+// pushTopic collapses pushes from the same conversation or channel, so an
+// offline device only gets the latest one
+func pushTopic(n *models.Notification) string {
+	if n.Route == nil {
+		return ""
+	}
+	switch {
+	case n.Route.ConversationID != nil:
+		return strings.ReplaceAll(n.Route.ConversationID.String(), "-", "")
+	case n.Route.ChannelID != nil:
+		return strings.ReplaceAll(n.Route.ChannelID.String(), "-", "")
+	}
+	return ""
+}
+
+func pushUrgency(priority models.NotificationPriority) string {
+	switch priority {
+	case models.NotificationPriorityHigh:
+		return "high"
+	case models.NotificationPriorityLow:
+		return "low"
+	}
+	return "normal"
+}
@@ -0,0 +1,179 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+)
+
+const (
+	EventTypeNotificationDigest = "NOTIFICATION_DIGEST"
+
+	quietHoursPollInterval = time.Minute
+	// Held notifications released per pass
+	quietHoursBatch = 1000
+	// The digest carries the newest few; the rest are in the notification list
+	maxDigestNotifications = 20
+)
+
+// QuietHoursDigest catches a user up on what arrived during their quiet hours
+type QuietHoursDigest struct {
+	// Count includes events merged into other notifications
+	Count         int                    `json:"count"`
+	UnreadCount   int64                  `json:"unreadCount"`
+	Notifications []*models.Notification `json:"notifications"`
+}
+
+// Run releases notifications held for quiet hours once the window ends,
+// sending each user one digest instead of every notification.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(quietHoursPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.releaseHeld(ctx)
+		}
+	}
+}
+
+func (s *Service) releaseHeld(ctx context.Context) {
+	held, err := s.claimHeld(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to release quiet hours notifications")
+		return
+	}
+
+	for userID, ids := range held {
+		if err := s.sendDigest(ctx, userID, ids); err != nil {
+			log.Warn().Err(err).Str("userId", userID.String()).Msg("Failed to send quiet hours digest")
+		}
+	}
+}
+
+// claimHeld clears held_until on notifications whose window has ended and
+// groups them by recipient. Claiming first keeps two instances from sending
+// the same digest; a failed send isn't retried, the notifications are still
+// in the list.
+func (s *Service) claimHeld(ctx context.Context) (map[uuid.UUID][]uuid.UUID, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE notifications SET held_until = NULL
+		WHERE id IN (
+			SELECT id FROM notifications
+			WHERE held_until <= NOW()
+			ORDER BY held_until
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id`,
+		quietHoursBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	held := map[uuid.UUID][]uuid.UUID{}
+	for rows.Next() {
+		var id, userID uuid.UUID
+		if err := rows.Scan(&id, &userID); err != nil {
+			return nil, err
+		}
+		held[userID] = append(held[userID], id)
+	}
+	return held, rows.Err()
+}
+
+// sendDigest sends the user the unread notifications among ids as one
+// NOTIFICATION_DIGEST event, and at most one push when they are offline
+func (s *Service) sendDigest(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) error {
+	rows, err := s.db.Query(ctx, `
+		SELECT n.id, n.user_id, n.type, n.title, n.body,
+		       n.community_id, n.channel_id, n.message_id, n.actor_id,
+		       n.metadata, n.is_read, n.count, n.created_at, n.updated_at,
+		       u.id, u.username, u.display_name, u.avatar_url,
+		       u.bio, u.status, u.custom_status, u.created_at
+		FROM notifications n
+		LEFT JOIN users u ON u.id = n.actor_id
+		WHERE n.id = ANY($1) AND n.is_read = FALSE
+		ORDER BY n.updated_at DESC`,
+		ids,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	prefs := s.loadPreferences(ctx, userID)
+
+	var notifications []*models.Notification
+	count := 0
+	for rows.Next() {
+		n, err := scanNotificationRow(rows)
+		if err != nil {
+			return err
+		}
+		decorate(n, prefs)
+		notifications = append(notifications, n)
+		count += n.Count
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	// Everything was read in the app during quiet hours
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	unread, err := s.GetUnreadCount(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	digest := &QuietHoursDigest{
+		Count:         count,
+		UnreadCount:   unread,
+		Notifications: notifications,
+	}
+	if len(digest.Notifications) > maxDigestNotifications {
+		digest.Notifications = digest.Notifications[:maxDigestNotifications]
+	}
+	s.hub.SendUserEvent(userID, EventTypeNotificationDigest, digest)
+
+	if lead := digestLead(notifications); lead != nil {
+		summary := *lead
+		if count > 1 {
+			summary.Title = fmt.Sprintf("%d notifications during quiet hours", count)
+			summary.Body = strPtr(lead.Title)
+			// Opens the app rather than one of the conversations
+			summary.Route = nil
+		}
+		s.queuePush(summary)
+	}
+	return nil
+}
+
+// digestLead picks the notification the catch-up push is built from: the
+// newest high priority one that can be pushed, else the newest that can be
+func digestLead(notifications []*models.Notification) *models.Notification {
+	var lead *models.Notification
+	for _, n := range notifications {
+		if n.Priority == models.NotificationPrioritySilent || pushToggle(n.Category) == "" {
+			continue
+		}
+		if n.Priority == models.NotificationPriorityHigh {
+			return n
+		}
+		if lead == nil {
+			lead = n
+		}
+	}
+	return lead
+}
@@ -0,0 +1,91 @@
+package notification
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rows deleted per statement, so a first run over a large table doesn't
+// hold locks for long
+const retentionBatch = 5000
+
+// Retention bounds how many notifications are kept. A zero field disables
+// that limit.
+type Retention struct {
+	// MaxAge drops notifications not updated for this long
+	MaxAge time.Duration
+	// MaxPerUser keeps only each user's newest notifications
+	MaxPerUser int
+}
+
+// ClearAll deletes all of the user's notifications
+func (s *Service) ClearAll(ctx context.Context, userID uuid.UUID) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM notifications WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	s.hub.SendUserEvent(userID, EventTypeNotificationDelete, map[string]any{"all": true})
+	return nil
+}
+
+// PruneNotifications applies the retention policy. It runs as a maintenance
+// task; clients aren't told, the notifications just stop being listed.
+func (s *Service) PruneNotifications(ctx context.Context) (int64, error) {
+	var removed int64
+
+	if s.retention.MaxAge > 0 {
+		n, err := s.deleteBatches(ctx,
+			`DELETE FROM notifications WHERE id IN (
+				SELECT id FROM notifications WHERE updated_at < $1 LIMIT $2
+			)`,
+			time.Now().Add(-s.retention.MaxAge),
+		)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	if s.retention.MaxPerUser > 0 {
+		n, err := s.deleteBatches(ctx,
+			`DELETE FROM notifications WHERE id IN (
+				SELECT id FROM (
+					SELECT n.id, ROW_NUMBER() OVER (PARTITION BY n.user_id ORDER BY n.updated_at DESC) AS rank
+					FROM notifications n
+					WHERE n.user_id IN (
+						SELECT user_id FROM notifications GROUP BY user_id HAVING COUNT(*) > $1
+					)
+				) ranked
+				WHERE rank > $1
+				LIMIT $2
+			)`,
+			s.retention.MaxPerUser,
+		)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// deleteBatches runs query, which takes arg and a batch size, until a batch
+// comes back short
+func (s *Service) deleteBatches(ctx context.Context, query string, arg any) (int64, error) {
+	var removed int64
+	for {
+		tag, err := s.db.Exec(ctx, query, arg, retentionBatch)
+		if err != nil {
+			return removed, err
+		}
+		removed += tag.RowsAffected()
+		if tag.RowsAffected() < retentionBatch {
+			return removed, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+	}
+}
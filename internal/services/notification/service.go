@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"regexp"
 	"time"
 
@@ -12,11 +13,13 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
 )
 
 const (
-	EventTypeNotification     = "NOTIFICATION"
-	EventTypeNotificationRead = "NOTIFICATION_READ"
+	EventTypeNotification       = "NOTIFICATION"
+	EventTypeNotificationRead   = "NOTIFICATION_READ"
+	EventTypeNotificationDelete = "NOTIFICATION_DELETE"
 )
 
 var (
@@ -42,6 +45,11 @@ type HubInterface interface {
 	IsUserOnline(userID uuid.UUID) bool
 }
 
+// MobilePusher delivers notifications to native apps. It must not block.
+type MobilePusher interface {
+	Notify(n models.Notification)
+}
+
 // ParsedMention is a single mention extracted from message content.
 type ParsedMention struct {
 	Type   models.MentionType
@@ -56,18 +64,28 @@ type MentionContext struct {
 	MessageCreatedAt   time.Time
 	AuthorID           uuid.UUID
 	Content            string
+	ContentWarning     *string    // shown in place of the content when set
 	ReplyToAuthorID    *uuid.UUID // if non-nil, a reply notification is also dispatched
 	CanMentionEveryone bool       // true if the author has the MentionEveryone permission
 }
 
 // Service handles notification persistence and real-time delivery.
 type Service struct {
-	db  *pgxpool.Pool
-	hub HubInterface
+	db        *pgxpool.Pool
+	hub       HubInterface
+	retention Retention
+
+	// Web Push, set up by SetWebPush
+	vapid      *vapidKeys
+	pushClient *http.Client
+	pushQueue  chan models.Notification
+
+	// Native app push, set up by SetMobilePush
+	mobilePush MobilePusher
 }
 
-func NewService(db *pgxpool.Pool, hub HubInterface) *Service {
-	return &Service{db: db, hub: hub}
+func NewService(db *pgxpool.Pool, hub HubInterface, retention Retention) *Service {
+	return &Service{db: db, hub: hub, retention: retention}
 }
 
 // DMNotificationContext carries context for notifying DM recipients.
@@ -75,8 +93,10 @@ type DMNotificationContext struct {
 	ConversationID uuid.UUID
 	MessageID      uuid.UUID
 	SenderID       uuid.UUID
-	SenderName     string // display name or username
-	Content        string // plaintext for notification body
+	SenderName     string     // display name or username
+	Content        string     // plaintext for notification body
+	ContentWarning *string    // shown in place of the content when set
+	CommunityID    *uuid.UUID // set for community announcement DMs
 }
 
 // ProcessDMNotification dispatches a DM_MESSAGE notification to all other
@@ -91,20 +111,87 @@ func (s *Service) ProcessDMNotification(nctx DMNotificationContext) {
 		return
 	}
 
-	body := truncate(nctx.Content, 200)
+	body := previewBody(nctx.Content, nctx.ContentWarning)
 
 	for _, recipientID := range participants {
 		if recipientID == nctx.SenderID {
 			continue
 		}
 		s.createAndSend(ctx, models.Notification{
-			UserID:    recipientID,
-			Type:      models.NotificationTypeDMMessage,
-			Title:     nctx.SenderName + " sent you a message",
-			Body:      strPtr(body),
-			MessageID: uuidPtr(nctx.MessageID),
-			ActorID:   uuidPtr(nctx.SenderID),
-			Metadata:  map[string]any{"conversationId": nctx.ConversationID.String()},
+			UserID:      recipientID,
+			Type:        models.NotificationTypeDMMessage,
+			Title:       nctx.SenderName + " sent you a message",
+			Body:        strPtr(body),
+			CommunityID: nctx.CommunityID,
+			MessageID:   uuidPtr(nctx.MessageID),
+			ActorID:     uuidPtr(nctx.SenderID),
+			Metadata:    map[string]any{"conversationId": nctx.ConversationID.String()},
+		})
+	}
+}
+
+// MissedCallContext describes a DM call someone didn't pick up.
+type MissedCallContext struct {
+	ConversationID uuid.UUID
+	CallID         uuid.UUID
+	RecipientID    uuid.UUID
+	CallerID       uuid.UUID
+	CallerName     string // display name or username
+	IsVideo        bool
+}
+
+// ProcessMissedCall sends a MISSED_CALL notification to a participant who
+// was rung and never answered. Safe to call in a goroutine.
+func (s *Service) ProcessMissedCall(mctx MissedCallContext) {
+	title := "Missed call from " + mctx.CallerName
+	if mctx.IsVideo {
+		title = "Missed video call from " + mctx.CallerName
+	}
+	s.createAndSend(context.Background(), models.Notification{
+		UserID:  mctx.RecipientID,
+		Type:    models.NotificationTypeMissedCall,
+		Title:   title,
+		ActorID: uuidPtr(mctx.CallerID),
+		Metadata: map[string]any{
+			"conversationId": mctx.ConversationID.String(),
+			"callId":         mctx.CallID.String(),
+		},
+	})
+}
+
+// ModeratorAlertContext describes something moderators of a community should look at.
+type ModeratorAlertContext struct {
+	CommunityID uuid.UUID
+	ChannelID   *uuid.UUID
+	ActorID     *uuid.UUID // the user who triggered the alert, if any
+	Title       string
+	Body        string
+	Metadata    map[string]any
+}
+
+// ProcessModeratorAlert sends a MOD_ALERT notification to the owner and every member
+// whose roles can manage messages, kick, ban or administer the community.
+// Safe to call in a goroutine.
+func (s *Service) ProcessModeratorAlert(actx ModeratorAlertContext) {
+	ctx := context.Background()
+
+	moderators, err := s.getCommunityModerators(ctx, actx.CommunityID)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", actx.CommunityID.String()).
+			Msg("Failed to get moderators for alert")
+		return
+	}
+
+	for _, moderatorID := range moderators {
+		s.createAndSend(ctx, models.Notification{
+			UserID:      moderatorID,
+			Type:        models.NotificationTypeModAlert,
+			Title:       actx.Title,
+			Body:        strPtr(truncate(actx.Body, 200)),
+			CommunityID: uuidPtr(actx.CommunityID),
+			ChannelID:   actx.ChannelID,
+			ActorID:     actx.ActorID,
+			Metadata:    actx.Metadata,
 		})
 	}
 }
@@ -128,50 +215,62 @@ func (s *Service) getDMParticipants(ctx context.Context, conversationID uuid.UUI
 
 // ---------- Public read/write API ----------
 
-// GetNotifications returns paginated notifications for a user, newest first.
-func (s *Service) GetNotifications(ctx context.Context, userID uuid.UUID, limit, offset int) ([]*models.Notification, int64, error) {
+// GetNotifications returns a page of a user's notifications, most recently
+// updated first, and the cursor of the next page or "" on the last one.
+// A notification that is bumped while the user pages moves to the top rather
+// than showing up twice.
+func (s *Service) GetNotifications(ctx context.Context, userID uuid.UUID, cursor string, limit int) ([]*models.Notification, string, error) {
 	if limit <= 0 || limit > 100 {
 		limit = 50
 	}
-
-	var total int64
-	if err := s.db.QueryRow(ctx,
-		`SELECT COUNT(*) FROM notifications WHERE user_id = $1`, userID,
-	).Scan(&total); err != nil {
-		return nil, 0, err
+	before, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
 	}
 
-	rows, err := s.db.Query(ctx, `
+	query := `
 		SELECT n.id, n.user_id, n.type, n.title, n.body,
 		       n.community_id, n.channel_id, n.message_id, n.actor_id,
-		       n.metadata, n.is_read, n.created_at,
+		       n.metadata, n.is_read, n.count, n.created_at, n.updated_at,
 		       u.id, u.username, u.display_name, u.avatar_url,
 		       u.bio, u.status, u.custom_status, u.created_at
 		FROM notifications n
 		LEFT JOIN users u ON u.id = n.actor_id
-		WHERE n.user_id = $1
-		ORDER BY n.created_at DESC
-		LIMIT $2 OFFSET $3`,
-		userID, limit, offset,
+		WHERE n.user_id = $1`
+	args := []any{userID, limit + 1}
+	if before != nil {
+		query += ` AND (n.updated_at, n.id) < ($3, $4)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		ORDER BY n.updated_at DESC, n.id DESC
+		LIMIT $2`,
+		args...,
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
-	var notifications []*models.Notification
+	prefs := s.loadPreferences(ctx, userID)
+
+	notifications := []*models.Notification{}
+	var next string
 	for rows.Next() {
 		n, err := scanNotificationRow(rows)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to scan notification")
 			continue
 		}
+		if len(notifications) == limit {
+			last := notifications[limit-1]
+			next = utils.EncodeCursor(utils.KeysetCursor{Time: last.UpdatedAt, ID: last.ID})
+			break
+		}
+		decorate(n, prefs)
 		notifications = append(notifications, n)
 	}
-	if notifications == nil {
-		notifications = []*models.Notification{}
-	}
-	return notifications, total, nil
+	return notifications, next, nil
 }
 
 // GetUnreadCount returns the count of unread notifications for a user.
@@ -225,6 +324,7 @@ func (s *Service) DeleteNotification(ctx context.Context, notifID, userID uuid.U
 	if tag.RowsAffected() == 0 {
 		return ErrNotFound
 	}
+	s.hub.SendUserEvent(userID, EventTypeNotificationDelete, map[string]any{"id": notifID})
 	return nil
 }
 
@@ -275,7 +375,7 @@ func (s *Service) ProcessMessageMentions(mctx MentionContext) {
 		communityID = &comID
 	}
 
-	body := truncate(mctx.Content, 200)
+	body := previewBody(mctx.Content, mctx.ContentWarning)
 
 	// notified tracks users already scheduled for a notification on this message.
 	notified := map[uuid.UUID]bool{mctx.AuthorID: true}
@@ -419,7 +519,7 @@ func (s *Service) ProcessMessageMentions(mctx MentionContext) {
 
 	// Reply notification (send after mention processing so both can't notify same user twice).
 	if mctx.ReplyToAuthorID != nil && !notified[*mctx.ReplyToAuthorID] {
-		body := truncate(mctx.Content, 200)
+		body := previewBody(mctx.Content, mctx.ContentWarning)
 		s.createAndSend(ctx, models.Notification{
 			UserID:      *mctx.ReplyToAuthorID,
 			Type:        models.NotificationTypeReply,
@@ -438,22 +538,45 @@ func (s *Service) ProcessMessageMentions(mctx MentionContext) {
 func (s *Service) createAndSend(ctx context.Context, n models.Notification) {
 	n.ID = uuid.New()
 	n.IsRead = false
+	n.Count = 1
 	n.CreatedAt = time.Now()
+	n.UpdatedAt = n.CreatedAt
 
 	metaJSON, _ := json.Marshal(n.Metadata)
 
-	if err := s.db.QueryRow(ctx, `
-		INSERT INTO notifications
-			(id, user_id, type, title, body,
-			 community_id, channel_id, message_id, actor_id,
-			 metadata, is_read, created_at)
-		VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10::jsonb,$11,$12)
-		RETURNING id, created_at`,
-		n.ID, n.UserID, n.Type, n.Title, n.Body,
-		n.CommunityID, n.ChannelID, n.MessageID, n.ActorID,
-		string(metaJSON), n.IsRead, n.CreatedAt,
-	).Scan(&n.ID, &n.CreatedAt); err != nil {
-		log.Error().Err(err).Str("userId", n.UserID.String()).Msg("Failed to insert notification")
+	// During the recipient's quiet hours the notification is only stored; the
+	// catch-up digest tells them about it when the window ends.
+	prefs := s.loadPreferences(ctx, n.UserID)
+	var heldUntil *time.Time
+	if until, quiet := prefs.QuietHours.Until(n.CreatedAt); quiet {
+		heldUntil = &until
+	}
+
+	key := coalesceKey(&n)
+	merged, err := s.coalesce(ctx, &n, key, string(metaJSON), heldUntil != nil)
+	if err != nil {
+		log.Error().Err(err).Str("userId", n.UserID.String()).Msg("Failed to coalesce notification")
+		return
+	}
+
+	if !merged {
+		if err := s.db.QueryRow(ctx, `
+			INSERT INTO notifications
+				(id, user_id, type, title, body,
+				 community_id, channel_id, message_id, actor_id,
+				 metadata, is_read, created_at, held_until, coalesce_key, count, updated_at)
+			VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10::jsonb,$11,$12,$13,$14,$15,$16)
+			RETURNING id, created_at`,
+			n.ID, n.UserID, n.Type, n.Title, n.Body,
+			n.CommunityID, n.ChannelID, n.MessageID, n.ActorID,
+			string(metaJSON), n.IsRead, n.CreatedAt, heldUntil, key, n.Count, n.UpdatedAt,
+		).Scan(&n.ID, &n.CreatedAt); err != nil {
+			log.Error().Err(err).Str("userId", n.UserID.String()).Msg("Failed to insert notification")
+			return
+		}
+	}
+
+	if heldUntil != nil {
 		return
 	}
 
@@ -470,8 +593,15 @@ func (s *Service) createAndSend(ctx context.Context, n models.Notification) {
 		}
 	}
 
+	decorate(&n, prefs)
+
+	// A merged notification keeps its ID, so clients replace the one they have
 	ptr := n
 	s.hub.SendUserEvent(n.UserID, EventTypeNotification, &ptr)
+	// The first notification of a burst already buzzed the device
+	if !merged {
+		s.queuePush(n)
+	}
 }
 
 func (s *Service) storeMention(ctx context.Context, m models.MessageMention) {
@@ -533,6 +663,31 @@ func (s *Service) getCommunityMembers(ctx context.Context, communityID uuid.UUID
 	return ids, nil
 }
 
+func (s *Service) getCommunityModerators(ctx context.Context, communityID uuid.UUID) ([]uuid.UUID, error) {
+	modPerms := models.PermissionAdministrator | models.PermissionManageMessages |
+		models.PermissionKickMembers | models.PermissionBanMembers
+	rows, err := s.db.Query(ctx,
+		`SELECT owner_id FROM communities WHERE id = $1
+		UNION
+		SELECT cm.user_id FROM community_members cm
+		JOIN member_roles mr ON mr.member_id = cm.id
+		JOIN roles r ON r.id = mr.role_id
+		WHERE cm.community_id = $1 AND (r.permissions & $2) <> 0`,
+		communityID, modPerms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
 func (s *Service) sendReadEvent(userID, notifID uuid.UUID) {
 	s.hub.SendUserEvent(userID, EventTypeNotificationRead, map[string]any{"id": notifID})
 }
@@ -550,7 +705,7 @@ func scanNotificationRow(row interface{ Scan(dest ...any) error }) (*models.Noti
 	err := row.Scan(
 		&n.ID, &n.UserID, &n.Type, &n.Title, &n.Body,
 		&n.CommunityID, &n.ChannelID, &n.MessageID, &n.ActorID,
-		&metaJSON, &n.IsRead, &n.CreatedAt,
+		&metaJSON, &n.IsRead, &n.Count, &n.CreatedAt, &n.UpdatedAt,
 		&actorID, &actorUsername, &actorDisplayName, &actorAvatarURL,
 		&actorBio, &actorStatus, &actorCustomStatus, &actorCreatedAt,
 	)
@@ -636,6 +791,16 @@ func ParseMentions(content string) []ParsedMention {
 
 func strPtr(s string) *string         { return &s }
 func uuidPtr(id uuid.UUID) *uuid.UUID { return &id }
+
+// previewBody is the notification body for a message. Content behind a
+// content warning stays out of notifications; the warning is shown instead.
+func previewBody(content string, contentWarning *string) string {
+	if contentWarning != nil && *contentWarning != "" {
+		return "CW: " + *contentWarning
+	}
+	return truncate(content, 200)
+}
+
 func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
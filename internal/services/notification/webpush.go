@@ -0,0 +1,250 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Web Push message encryption (RFC 8291, aes128gcm) and VAPID (RFC 8292).
+
+const (
+	pushRecordSize = 4096
+	pushTimeout    = 10 * time.Second
+	vapidTokenTTL  = 12 * time.Hour
+)
+
+var (
+	errPushGone     = errors.New("push subscription is no longer valid")
+	errInvalidVAPID = errors.New("WEBPUSH_VAPID_PRIVATE_KEY must be a base64url P-256 private key")
+)
+
+// WebPushConfig is the server's VAPID identity
+type WebPushConfig struct {
+	// VAPIDPrivateKey is the raw 32-byte P-256 scalar, base64url encoded
+	VAPIDPrivateKey string
+	// Subject is a mailto: or https: contact for push services
+	Subject string
+}
+
+// vapidKeys is a parsed VAPID key pair
+type vapidKeys struct {
+	private   *ecdsa.PrivateKey
+	publicKey string // uncompressed point, base64url, as clients pass it to pushManager.subscribe
+	subject   string
+}
+
+func parseVAPIDKeys(cfg WebPushConfig) (*vapidKeys, error) {
+	raw, err := decodeBase64URL(cfg.VAPIDPrivateKey)
+	if err != nil {
+		return nil, errInvalidVAPID
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, errInvalidVAPID
+	}
+
+	public := key.PublicKey().Bytes()
+	private := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(public[1:33]),
+			Y:     new(big.Int).SetBytes(public[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+
+	return &vapidKeys{
+		private:   private,
+		publicKey: base64.RawURLEncoding.EncodeToString(public),
+		subject:   cfg.Subject,
+	}, nil
+}
+
+// authorization builds the VAPID Authorization header for a push service
+func (k *vapidKeys) authorization(endpoint *url.URL) (string, error) {
+	claims := jwt.MapClaims{
+		"aud": endpoint.Scheme + "://" + endpoint.Host,
+		"exp": time.Now().Add(vapidTokenTTL).Unix(),
+	}
+	if k.subject != "" {
+		claims["sub"] = k.subject
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(k.private)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("vapid t=%s, k=%s", token, k.publicKey), nil
+}
+
+// pushMessage is one delivery to one subscription
+type pushMessage struct {
+	Endpoint string
+	P256DH   string
+	Auth     string
+	Payload  []byte
+	TTL      time.Duration
+	Urgency  string
+	// Topic replaces an undelivered message with the same topic
+	Topic string
+}
+
+// encryptPushPayload encrypts payload for the subscription's keys as a single
+// aes128gcm record
+func encryptPushPayload(payload []byte, p256dh, authSecret string) ([]byte, error) {
+	uaPublicRaw, err := decodeBase64URL(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	auth, err := decodeBase64URL(authSecret)
+	if err != nil || len(auth) != 16 {
+		return nil, errors.New("invalid auth secret")
+	}
+
+	curve := ecdh.P256()
+	uaPublic, err := curve.NewPublicKey(uaPublicRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	asPrivate, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	sharedSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicRaw...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdfRead(sharedSecret, auth, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	cek, err := hkdfRead(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfRead(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// 0x02 marks the last (and only) record
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	if len(plaintext)+gcm.Overhead() > pushRecordSize {
+		return nil, errors.New("push payload is too large")
+	}
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(pushRecordSize))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	body.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return body.Bytes(), nil
+}
+
+func hkdfRead(secret, salt, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// sendPush delivers one message. errPushGone means the subscription should be
+// deleted.
+func (s *Service) sendPush(ctx context.Context, msg pushMessage) error {
+	endpoint, err := url.Parse(msg.Endpoint)
+	if err != nil {
+		return errPushGone
+	}
+
+	body, err := encryptPushPayload(msg.Payload, msg.P256DH, msg.Auth)
+	if err != nil {
+		// Keys that can't be used will never work
+		return fmt.Errorf("%w: %v", errPushGone, err)
+	}
+	authorization, err := s.vapid.authorization(endpoint)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(msg.TTL.Seconds())))
+	if msg.Urgency != "" {
+		req.Header.Set("Urgency", msg.Urgency)
+	}
+	if msg.Topic != "" {
+		req.Header.Set("Topic", msg.Topic)
+	}
+
+	resp, err := s.pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushGone
+	default:
+		return fmt.Errorf("push service responded with %d", resp.StatusCode)
+	}
+}
+
+// decodeBase64URL accepts keys with or without padding, as browsers differ
+func decodeBase64URL(value string) ([]byte, error) {
+	value = strings.TrimRight(strings.TrimSpace(value), "=")
+	return base64.RawURLEncoding.DecodeString(value)
+}
@@ -0,0 +1,536 @@
+package oauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/zentra/server/internal/models"
+)
+
+// The authorization-code flow with PKCE (RFC 6749, RFC 7636). PKCE is
+// required for every app, and only the S256 challenge method is accepted.
+
+// OAuth error codes (RFC 6749 section 5.2)
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeInvalidClient        = "invalid_client"
+	ErrCodeInvalidGrant         = "invalid_grant"
+	ErrCodeInvalidScope         = "invalid_scope"
+	ErrCodeUnsupportedGrantType = "unsupported_grant_type"
+	ErrCodeAccessDenied         = "access_denied"
+)
+
+// Error is an OAuth protocol error, sent to apps in the standard format
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+type AuthorizeRequest struct {
+	ClientID            string `json:"clientId" validate:"required,uuid"`
+	RedirectURI         string `json:"redirectUri" validate:"required,max=512"`
+	ResponseType        string `json:"responseType" validate:"required,oneof=code"`
+	Scope               string `json:"scope" validate:"required,max=200"`
+	State               string `json:"state" validate:"max=500"`
+	CodeChallenge       string `json:"codeChallenge" validate:"required,min=43,max=128"`
+	CodeChallengeMethod string `json:"codeChallengeMethod" validate:"required,oneof=S256"`
+}
+
+type ApproveRequest struct {
+	AuthorizeRequest
+	Approve *bool `json:"approve" validate:"required"`
+}
+
+// ConsentScope is one line of the consent screen
+type ConsentScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ConsentApp is what the consent screen shows about the app
+type ConsentApp struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description,omitempty"`
+	IconURL     *string   `json:"iconUrl,omitempty"`
+	WebsiteURL  *string   `json:"websiteUrl,omitempty"`
+	OwnerID     uuid.UUID `json:"ownerId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Consent is everything a client needs to render the consent screen
+type Consent struct {
+	App         ConsentApp     `json:"app"`
+	Scopes      []ConsentScope `json:"scopes"`
+	RedirectURI string         `json:"redirectUri"`
+	// AlreadyAuthorized is set when the user already granted every requested
+	// scope, so clients may approve without asking again
+	AlreadyAuthorized bool `json:"alreadyAuthorized"`
+}
+
+// AuthorizeResult tells the client where to send the user back to the app
+type AuthorizeResult struct {
+	RedirectTo string `json:"redirectTo"`
+}
+
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	ClientID     string
+	ClientSecret string
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// Introspection follows RFC 7662. Inactive tokens only report active=false.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Username  string `json:"username,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+}
+
+// GetConsent validates an authorization request and returns what the consent
+// screen should show
+func (s *Service) GetConsent(ctx context.Context, userID uuid.UUID, req *AuthorizeRequest) (*Consent, error) {
+	app, scopes, err := s.checkAuthorizeRequest(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	consent := &Consent{
+		App: ConsentApp{
+			ID:          app.ID,
+			Name:        app.Name,
+			Description: app.Description,
+			IconURL:     app.IconURL,
+			WebsiteURL:  app.WebsiteURL,
+			OwnerID:     app.OwnerID,
+			CreatedAt:   app.CreatedAt,
+		},
+		Scopes:      make([]ConsentScope, 0, len(scopes)),
+		RedirectURI: req.RedirectURI,
+	}
+	for _, scope := range scopes {
+		consent.Scopes = append(consent.Scopes, ConsentScope{Name: scope, Description: models.OAuthScopeDescriptions[scope]})
+	}
+
+	var granted []string
+	err = s.db.QueryRow(ctx,
+		`SELECT scopes FROM oauth_authorizations WHERE app_id = $1 AND user_id = $2`,
+		app.ID, userID,
+	).Scan(&granted)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("get oauth authorization: %w", err)
+	}
+	consent.AlreadyAuthorized = err == nil && containsAll(granted, scopes)
+
+	return consent, nil
+}
+
+// Authorize records the user's decision. Approving issues an authorization
+// code; either way the result is the URL to send the user back to.
+func (s *Service) Authorize(ctx context.Context, userID uuid.UUID, req *ApproveRequest) (*AuthorizeResult, error) {
+	app, scopes, err := s.checkAuthorizeRequest(ctx, &req.AuthorizeRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	if !*req.Approve {
+		params.Set("error", ErrCodeAccessDenied)
+		params.Set("error_description", "The user denied the request")
+		return &AuthorizeResult{RedirectTo: withQuery(req.RedirectURI, params)}, nil
+	}
+
+	code, err := generateSecret("")
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO oauth_codes (code_hash, app_id, user_id, redirect_uri, scopes, code_challenge, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		hashSecret(code), app.ID, userID, req.RedirectURI, scopes, req.CodeChallenge, time.Now().Add(codeTTL),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create oauth code: %w", err)
+	}
+
+	// Consent accumulates: approving more scopes later adds to the earlier grant
+	_, err = tx.Exec(ctx,
+		`INSERT INTO oauth_authorizations (app_id, user_id, scopes)
+		 VALUES ($1, $2, $3)
+		 ON CONFLICT (app_id, user_id) DO UPDATE
+		 SET scopes = ARRAY(SELECT DISTINCT unnest(oauth_authorizations.scopes || EXCLUDED.scopes) ORDER BY 1)`,
+		app.ID, userID, scopes,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("save oauth authorization: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	params.Set("code", code)
+	return &AuthorizeResult{RedirectTo: withQuery(req.RedirectURI, params)}, nil
+}
+
+// Token handles the token endpoint for the authorization_code and
+// refresh_token grants
+func (s *Service) Token(ctx context.Context, req *TokenRequest) (*TokenResponse, error) {
+	app, err := s.authenticateClient(ctx, req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.GrantType {
+	case "authorization_code":
+		return s.exchangeCode(ctx, app, req)
+	case "refresh_token":
+		return s.refresh(ctx, app, req)
+	case "":
+		return nil, oauthError(ErrCodeInvalidRequest, "grant_type is required")
+	default:
+		return nil, oauthError(ErrCodeUnsupportedGrantType, "Only authorization_code and refresh_token are supported")
+	}
+}
+
+// Introspect describes a token held by the authenticated app
+func (s *Service) Introspect(ctx context.Context, clientID, clientSecret, raw string) (*Introspection, error) {
+	app, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		userID    uuid.UUID
+		username  string
+		scopes    []string
+		expiresAt time.Time
+		issuedAt  time.Time
+	)
+	err = s.db.QueryRow(ctx,
+		`SELECT t.user_id, u.username, t.scopes,
+		        CASE WHEN t.access_token_hash = $1 THEN t.access_expires_at ELSE t.refresh_expires_at END,
+		        t.created_at
+		 FROM oauth_tokens t
+		 JOIN users u ON u.id = t.user_id
+		 WHERE (t.access_token_hash = $1 OR t.refresh_token_hash = $1) AND t.app_id = $2 AND t.revoked_at IS NULL`,
+		hashSecret(raw), app.ID,
+	).Scan(&userID, &username, &scopes, &expiresAt, &issuedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return &Introspection{Active: false}, nil
+		}
+		return nil, fmt.Errorf("introspect oauth token: %w", err)
+	}
+	if time.Now().After(expiresAt) {
+		return &Introspection{Active: false}, nil
+	}
+
+	return &Introspection{
+		Active:    true,
+		Scope:     strings.Join(scopes, " "),
+		ClientID:  app.ID.String(),
+		Username:  username,
+		Subject:   userID.String(),
+		TokenType: "Bearer",
+		ExpiresAt: expiresAt.Unix(),
+		IssuedAt:  issuedAt.Unix(),
+	}, nil
+}
+
+// Revoke revokes an access or refresh token held by the authenticated app.
+// Unknown tokens are not an error (RFC 7009).
+func (s *Service) Revoke(ctx context.Context, clientID, clientSecret, raw string) error {
+	app, err := s.authenticateClient(ctx, clientID, clientSecret)
+	if err != nil {
+		return err
+	}
+
+	hash := hashSecret(raw)
+	_, err = s.db.Exec(ctx,
+		`UPDATE oauth_tokens SET revoked_at = NOW()
+		 WHERE (access_token_hash = $1 OR refresh_token_hash = $1) AND app_id = $2 AND revoked_at IS NULL`,
+		hash, app.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke oauth token: %w", err)
+	}
+	return nil
+}
+
+// AuthenticateAccessToken resolves a bearer token sent to the resource API
+func (s *Service) AuthenticateAccessToken(ctx context.Context, raw string) (*models.OAuthToken, error) {
+	if !strings.HasPrefix(raw, accessTokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+
+	t := &models.OAuthToken{}
+	err := s.db.QueryRow(ctx,
+		`SELECT id, app_id, user_id, scopes, access_expires_at, created_at FROM oauth_tokens
+		 WHERE access_token_hash = $1 AND revoked_at IS NULL AND access_expires_at > NOW()`,
+		hashSecret(raw),
+	).Scan(&t.ID, &t.AppID, &t.UserID, &t.Scopes, &t.ExpiresAt, &t.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInvalidToken
+		}
+		return nil, fmt.Errorf("authenticate oauth token: %w", err)
+	}
+	return t, nil
+}
+
+func (s *Service) exchangeCode(ctx context.Context, app *models.OAuthApp, req *TokenRequest) (*TokenResponse, error) {
+	if req.Code == "" || req.RedirectURI == "" || req.CodeVerifier == "" {
+		return nil, oauthError(ErrCodeInvalidRequest, "code, redirect_uri and code_verifier are required")
+	}
+	if len(req.CodeVerifier) < 43 || len(req.CodeVerifier) > 128 {
+		return nil, oauthError(ErrCodeInvalidRequest, "code_verifier must be 43-128 characters")
+	}
+
+	var (
+		codeAppID   uuid.UUID
+		userID      uuid.UUID
+		redirectURI string
+		scopes      []string
+		challenge   string
+		expiresAt   time.Time
+	)
+	// Codes are single use: claiming one marks it used whatever happens next
+	err := s.db.QueryRow(ctx,
+		`UPDATE oauth_codes SET used_at = NOW()
+		 WHERE code_hash = $1 AND used_at IS NULL
+		 RETURNING app_id, user_id, redirect_uri, scopes, code_challenge, expires_at`,
+		hashSecret(req.Code),
+	).Scan(&codeAppID, &userID, &redirectURI, &scopes, &challenge, &expiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, oauthError(ErrCodeInvalidGrant, "Authorization code is invalid or already used")
+		}
+		return nil, fmt.Errorf("claim oauth code: %w", err)
+	}
+
+	if codeAppID != app.ID || redirectURI != req.RedirectURI {
+		return nil, oauthError(ErrCodeInvalidGrant, "Authorization code was issued to another client or redirect_uri")
+	}
+	if time.Now().After(expiresAt) {
+		return nil, oauthError(ErrCodeInvalidGrant, "Authorization code has expired")
+	}
+
+	verifierHash := sha256.Sum256([]byte(req.CodeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(verifierHash[:])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) != 1 {
+		return nil, oauthError(ErrCodeInvalidGrant, "code_verifier does not match the code challenge")
+	}
+
+	return s.issueTokens(ctx, s.db, app.ID, userID, scopes)
+}
+
+func (s *Service) refresh(ctx context.Context, app *models.OAuthApp, req *TokenRequest) (*TokenResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, oauthError(ErrCodeInvalidRequest, "refresh_token is required")
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Refresh tokens rotate: the old pair is revoked as the new one is issued
+	var userID uuid.UUID
+	var scopes []string
+	err = tx.QueryRow(ctx,
+		`UPDATE oauth_tokens SET revoked_at = NOW()
+		 WHERE refresh_token_hash = $1 AND app_id = $2 AND revoked_at IS NULL AND refresh_expires_at > NOW()
+		 RETURNING user_id, scopes`,
+		hashSecret(req.RefreshToken), app.ID,
+	).Scan(&userID, &scopes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, oauthError(ErrCodeInvalidGrant, "Refresh token is invalid or expired")
+		}
+		return nil, fmt.Errorf("claim oauth refresh token: %w", err)
+	}
+
+	// Apps may ask for fewer scopes than they were granted, never more
+	if req.Scope != "" {
+		requested, ok := parseScopes(req.Scope)
+		if !ok || !containsAll(scopes, requested) {
+			return nil, oauthError(ErrCodeInvalidScope, "Requested scopes exceed the original grant")
+		}
+		scopes = requested
+	}
+
+	resp, err := s.issueTokens(ctx, tx, app.ID, userID, scopes)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// execer is satisfied by both the pool and a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+func (s *Service) issueTokens(ctx context.Context, db execer, appID, userID uuid.UUID, scopes []string) (*TokenResponse, error) {
+	accessToken, err := generateSecret(accessTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+	refreshToken, err := generateSecret(refreshTokenPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	_, err = db.Exec(ctx,
+		`INSERT INTO oauth_tokens (app_id, user_id, access_token_hash, refresh_token_hash, scopes, access_expires_at, refresh_expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		appID, userID, hashSecret(accessToken), hashSecret(refreshToken), scopes,
+		now.Add(accessTokenTTL), now.Add(refreshTokenTTL),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("issue oauth tokens: %w", err)
+	}
+
+	return &TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}, nil
+}
+
+// checkAuthorizeRequest validates the client, redirect URI and scopes of an
+// authorization request. Until the redirect URI is known to be registered,
+// errors must be shown to the user rather than sent to it.
+func (s *Service) checkAuthorizeRequest(ctx context.Context, req *AuthorizeRequest) (*models.OAuthApp, []string, error) {
+	appID, err := uuid.Parse(req.ClientID)
+	if err != nil {
+		return nil, nil, ErrAppNotFound
+	}
+	app, err := s.getApp(ctx, appID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !slices.Contains(app.RedirectURIs, req.RedirectURI) {
+		return nil, nil, ErrRedirectMismatch
+	}
+
+	scopes, ok := parseScopes(req.Scope)
+	if !ok {
+		return nil, nil, oauthError(ErrCodeInvalidScope, "Unknown or missing scope")
+	}
+	return app, scopes, nil
+}
+
+// authenticateClient checks the client credentials sent to the token,
+// introspection and revocation endpoints
+func (s *Service) authenticateClient(ctx context.Context, clientID, clientSecret string) (*models.OAuthApp, error) {
+	invalid := oauthError(ErrCodeInvalidClient, "Client authentication failed")
+
+	appID, err := uuid.Parse(clientID)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var secretHash *string
+	err = s.db.QueryRow(ctx, `SELECT client_secret_hash FROM oauth_apps WHERE id = $1`, appID).Scan(&secretHash)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, invalid
+		}
+		return nil, fmt.Errorf("authenticate oauth client: %w", err)
+	}
+
+	app, err := s.getApp(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.IsPublic {
+		if clientSecret != "" {
+			return nil, invalid
+		}
+		return app, nil
+	}
+	if secretHash == nil || subtle.ConstantTimeCompare([]byte(hashSecret(clientSecret)), []byte(*secretHash)) != 1 {
+		return nil, invalid
+	}
+	return app, nil
+}
+
+func containsAll(have, want []string) bool {
+	for _, scope := range want {
+		if !slices.Contains(have, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func withQuery(rawURL string, params url.Values) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := u.Query()
+	for key, values := range params {
+		for _, v := range values {
+			query.Add(key, v)
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
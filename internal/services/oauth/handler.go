@@ -0,0 +1,491 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/utils"
+)
+
+// UserProvider, CommunityLister and MessageSender back the resource API apps
+// call with an access token
+type UserProvider interface {
+	GetPublicUser(ctx context.Context, id uuid.UUID) (*models.PublicUser, error)
+}
+
+type CommunityLister interface {
+	GetUserCommunities(ctx context.Context, userID uuid.UUID) ([]*models.Community, error)
+}
+
+type MessageSender interface {
+	CreateMessage(ctx context.Context, channelID, userID uuid.UUID, req *message.CreateMessageRequest) (*message.MessageResponse, error)
+}
+
+type Handler struct {
+	service     *Service
+	users       UserProvider
+	communities CommunityLister
+	messages    MessageSender
+}
+
+func NewHandler(service *Service, users UserProvider, communities CommunityLister, messages MessageSender) *Handler {
+	return &Handler{service: service, users: users, communities: communities, messages: messages}
+}
+
+type SendMessageRequest struct {
+	Content   string     `json:"content" validate:"required,max=4000"`
+	ReplyToID *uuid.UUID `json:"replyToId,omitempty"`
+}
+
+// Routes are the app registration, consent and token endpoints
+func (h *Handler) Routes(secret string) chi.Router {
+	r := chi.NewRouter()
+
+	// Used by Zentra clients on behalf of the signed-in user
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(secret))
+		r.Get("/apps", h.ListApps)
+		r.Post("/apps", h.CreateApp)
+		r.Get("/apps/{appId}", h.GetApp)
+		r.Patch("/apps/{appId}", h.UpdateApp)
+		r.Delete("/apps/{appId}", h.DeleteApp)
+		r.Post("/apps/{appId}/secret", h.RotateSecret)
+
+		r.Get("/authorize", h.GetConsent)
+		r.Post("/authorize", h.Authorize)
+
+		r.Get("/authorizations", h.ListAuthorizations)
+		r.Delete("/authorizations/{appId}", h.RevokeAuthorization)
+	})
+
+	// Called by third-party apps with their client credentials
+	r.Post("/token", h.Token)
+	r.Post("/introspect", h.Introspect)
+	r.Post("/revoke", h.Revoke)
+
+	return r
+}
+
+// ResourceRoutes are what apps can do with an access token. They must be
+// mounted behind middleware.OAuthMiddleware.
+func (h *Handler) ResourceRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	r.With(middleware.RequireOAuthScope(models.OAuthScopeIdentify)).Get("/users/@me", h.GetCurrentUser)
+	r.With(middleware.RequireOAuthScope(models.OAuthScopeCommunitiesRead)).Get("/users/@me/communities", h.GetCurrentUserCommunities)
+	r.With(middleware.RequireOAuthScope(models.OAuthScopeMessagesWrite), middleware.Idempotent).Post("/channels/{channelId}/messages", h.SendMessage)
+
+	return r
+}
+
+// ListApps returns the user's registered apps
+func (h *Handler) ListApps(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	apps, err := h.service.ListApps(r.Context(), userID)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to get apps")
+		return
+	}
+
+	utils.RespondSuccess(w, apps)
+}
+
+// CreateApp registers an app. The response holds the client secret, which is
+// not shown again.
+func (h *Handler) CreateApp(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateAppRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	app, err := h.service.CreateApp(r.Context(), userID, &req)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to create app")
+		return
+	}
+
+	utils.RespondCreated(w, app)
+}
+
+// GetApp returns one of the user's apps
+func (h *Handler) GetApp(w http.ResponseWriter, r *http.Request) {
+	userID, appID, ok := h.appParams(w, r)
+	if !ok {
+		return
+	}
+
+	app, err := h.service.GetApp(r.Context(), appID, userID)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to get app")
+		return
+	}
+
+	utils.RespondSuccess(w, app)
+}
+
+// UpdateApp changes an app's details or redirect URIs
+func (h *Handler) UpdateApp(w http.ResponseWriter, r *http.Request) {
+	userID, appID, ok := h.appParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateAppRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	app, err := h.service.UpdateApp(r.Context(), appID, userID, &req)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to update app")
+		return
+	}
+
+	utils.RespondSuccess(w, app)
+}
+
+// DeleteApp removes an app and every token issued to it
+func (h *Handler) DeleteApp(w http.ResponseWriter, r *http.Request) {
+	userID, appID, ok := h.appParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteApp(r.Context(), appID, userID); err != nil {
+		h.respondOAuthError(w, err, "Failed to delete app")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// RotateSecret issues a new client secret
+func (h *Handler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	userID, appID, ok := h.appParams(w, r)
+	if !ok {
+		return
+	}
+
+	app, err := h.service.RotateSecret(r.Context(), appID, userID)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to rotate client secret")
+		return
+	}
+
+	utils.RespondSuccess(w, app)
+}
+
+// GetConsent validates an authorization request and returns the data for the
+// consent screen. It takes the standard OAuth query parameters.
+func (h *Handler) GetConsent(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	q := r.URL.Query()
+	req := AuthorizeRequest{
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		ResponseType:        q.Get("response_type"),
+		Scope:               q.Get("scope"),
+		State:               q.Get("state"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+	}
+	if err := utils.Validate(&req); err != nil {
+		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+		return
+	}
+
+	consent, err := h.service.GetConsent(r.Context(), userID, &req)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to check authorization request")
+		return
+	}
+
+	utils.RespondSuccess(w, consent)
+}
+
+// Authorize records the user's approval or denial and returns the URL to send
+// them back to the app with
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req ApproveRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	result, err := h.service.Authorize(r.Context(), userID, &req)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to authorize app")
+		return
+	}
+
+	utils.RespondSuccess(w, result)
+}
+
+// ListAuthorizations returns the apps the user has authorized
+func (h *Handler) ListAuthorizations(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	authorizations, err := h.service.ListAuthorizations(r.Context(), userID)
+	if err != nil {
+		h.respondOAuthError(w, err, "Failed to get authorized apps")
+		return
+	}
+
+	utils.RespondSuccess(w, authorizations)
+}
+
+// RevokeAuthorization deauthorizes an app and revokes its tokens for the user
+func (h *Handler) RevokeAuthorization(w http.ResponseWriter, r *http.Request) {
+	userID, appID, ok := h.appParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeAuthorization(r.Context(), appID, userID); err != nil {
+		h.respondOAuthError(w, err, "Failed to revoke app")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// Token is the OAuth token endpoint. It takes a form-encoded body and answers
+// in the standard OAuth format rather than the API envelope.
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondProtocolError(w, oauthError(ErrCodeInvalidRequest, "Body must be form-encoded"))
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	resp, err := h.service.Token(r.Context(), &TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		Scope:        r.PostForm.Get("scope"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+	})
+	if err != nil {
+		respondProtocolError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.RespondJSON(w, http.StatusOK, resp)
+}
+
+// Introspect reports whether a token is active (RFC 7662)
+func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondProtocolError(w, oauthError(ErrCodeInvalidRequest, "Body must be form-encoded"))
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondProtocolError(w, oauthError(ErrCodeInvalidRequest, "token is required"))
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	result, err := h.service.Introspect(r.Context(), clientID, clientSecret, token)
+	if err != nil {
+		respondProtocolError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	utils.RespondJSON(w, http.StatusOK, result)
+}
+
+// Revoke revokes an access or refresh token (RFC 7009)
+func (h *Handler) Revoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondProtocolError(w, oauthError(ErrCodeInvalidRequest, "Body must be form-encoded"))
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		respondProtocolError(w, oauthError(ErrCodeInvalidRequest, "token is required"))
+		return
+	}
+
+	clientID, clientSecret := clientCredentials(r)
+	if err := h.service.Revoke(r.Context(), clientID, clientSecret, token); err != nil {
+		respondProtocolError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetCurrentUser returns the profile of the user the token acts for
+func (h *Handler) GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	user, err := h.users.GetPublicUser(r.Context(), userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	utils.RespondSuccess(w, user)
+}
+
+// GetCurrentUserCommunities returns the communities the user is in
+func (h *Handler) GetCurrentUserCommunities(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communities, err := h.communities.GetUserCommunities(r.Context(), userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get communities")
+		return
+	}
+
+	utils.RespondSuccess(w, communities)
+}
+
+// SendMessage posts a message as the user. The usual permission, slowmode and
+// AutoMod checks apply.
+func (h *Handler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return
+	}
+
+	var req SendMessageRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	msg, err := h.messages.CreateMessage(r.Context(), channelID, userID, &message.CreateMessageRequest{
+		Content:   req.Content,
+		ReplyToID: req.ReplyToID,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, message.ErrInsufficientPerms):
+			utils.RespondError(w, http.StatusForbidden, "Cannot send messages in this channel")
+		case errors.Is(err, message.ErrInvalidReply):
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, message.ErrBlockedByAutoMod), errors.Is(err, message.ErrRemovedByAutoMod),
+			errors.Is(err, message.ErrVerificationLevel):
+			utils.RespondError(w, http.StatusForbidden, err.Error())
+		case errors.Is(err, message.ErrSlowDown), errors.Is(err, message.ErrDuplicateMessage):
+			utils.RespondError(w, http.StatusTooManyRequests, err.Error())
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to send message")
+		}
+		return
+	}
+
+	utils.RespondCreated(w, msg)
+}
+
+func (h *Handler) appParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	appID, err := uuid.Parse(chi.URLParam(r, "appId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid app ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, appID, true
+}
+
+// clientCredentials reads client credentials from HTTP Basic auth, falling
+// back to the form body
+func clientCredentials(r *http.Request) (string, string) {
+	if id, secret, ok := r.BasicAuth(); ok {
+		return id, secret
+	}
+	return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+}
+
+func respondProtocolError(w http.ResponseWriter, err error) {
+	var oerr *Error
+	if !errors.As(err, &oerr) {
+		utils.RespondJSON(w, http.StatusInternalServerError, oauthError("server_error", "Something went wrong"))
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oerr.Code == ErrCodeInvalidClient {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		status = http.StatusUnauthorized
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	utils.RespondJSON(w, status, oerr)
+}
+
+func (h *Handler) respondOAuthError(w http.ResponseWriter, err error, fallback string) {
+	var oerr *Error
+	switch {
+	case errors.As(err, &oerr):
+		utils.RespondErrorWithCode(w, http.StatusBadRequest, oerr.Code, oerr.Description)
+	case errors.Is(err, ErrAppNotFound):
+		utils.RespondError(w, http.StatusNotFound, "App not found")
+	case errors.Is(err, ErrAuthorizationNotFound):
+		utils.RespondError(w, http.StatusNotFound, "App is not authorized")
+	case errors.Is(err, ErrInvalidRedirectURI), errors.Is(err, ErrRedirectMismatch),
+		errors.Is(err, ErrPublicAppSecret), errors.Is(err, ErrTooManyApps):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,121 @@
+package oauth
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+	"github.com/zentra/server/internal/services/message"
+)
+
+func init() {
+	appID := []openapi.Param{openapi.ID("appId")}
+	clientAuth := []openapi.Param{
+		{Name: "client_id", Description: "Unless sent with HTTP Basic authentication"},
+		{Name: "client_secret", Description: "Unless sent with HTTP Basic authentication; public clients have none"},
+	}
+	authorizeQuery := []openapi.Param{
+		{Name: "client_id", Format: "uuid", Required: true},
+		{Name: "redirect_uri", Required: true},
+		{Name: "response_type", Enum: []string{"code"}, Required: true},
+		{Name: "scope", Required: true, Description: "Space-separated scopes"},
+		{Name: "state"},
+		{Name: "code_challenge", Required: true},
+		{Name: "code_challenge_method", Enum: []string{"S256"}, Required: true},
+	}
+
+	openapi.Describe((*Handler).ListApps, openapi.Operation{
+		Summary:  "List the current user's OAuth apps",
+		Response: []*models.OAuthApp{},
+	})
+	openapi.Describe((*Handler).CreateApp, openapi.Operation{
+		Summary:     "Register an OAuth app",
+		Description: "The client secret is only in this response.",
+		Body:        CreateAppRequest{},
+		Response:    AppWithSecret{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).GetApp, openapi.Operation{
+		Summary:  "Get one of the current user's OAuth apps",
+		Path:     appID,
+		Response: models.OAuthApp{},
+	})
+	openapi.Describe((*Handler).UpdateApp, openapi.Operation{
+		Summary:  "Update an OAuth app",
+		Path:     appID,
+		Body:     UpdateAppRequest{},
+		Response: models.OAuthApp{},
+	})
+	openapi.Describe((*Handler).DeleteApp, openapi.Operation{
+		Summary: "Delete an OAuth app and revoke its tokens",
+		Path:    appID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RotateSecret, openapi.Operation{
+		Summary:  "Issue a new client secret for an OAuth app",
+		Path:     appID,
+		Response: AppWithSecret{},
+	})
+
+	openapi.Describe((*Handler).GetConsent, openapi.Operation{
+		Summary:  "Check an authorization request and get what the consent screen shows",
+		Query:    authorizeQuery,
+		Response: Consent{},
+	})
+	openapi.Describe((*Handler).Authorize, openapi.Operation{
+		Summary:  "Approve or deny an authorization request",
+		Body:     ApproveRequest{},
+		Response: AuthorizeResult{},
+	})
+	openapi.Describe((*Handler).ListAuthorizations, openapi.Operation{
+		Summary:  "List the apps the current user has authorized",
+		Response: []*models.OAuthAuthorization{},
+	})
+	openapi.Describe((*Handler).RevokeAuthorization, openapi.Operation{
+		Summary: "Deauthorize an app and revoke its tokens",
+		Path:    appID,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).Token, openapi.Operation{
+		Summary:     "Exchange an authorization code or refresh token for tokens",
+		Description: "Errors are in the OAuth format rather than the API's.",
+		Form: append([]openapi.Param{
+			{Name: "grant_type", Enum: []string{"authorization_code", "refresh_token"}, Required: true},
+			{Name: "code"},
+			{Name: "redirect_uri"},
+			{Name: "code_verifier"},
+			{Name: "refresh_token"},
+			{Name: "scope"},
+		}, clientAuth...),
+		Response: TokenResponse{},
+		Shape:    openapi.Raw,
+	})
+	openapi.Describe((*Handler).Introspect, openapi.Operation{
+		Summary:  "Check whether a token is active (RFC 7662)",
+		Form:     append([]openapi.Param{{Name: "token", Required: true}}, clientAuth...),
+		Response: Introspection{},
+		Shape:    openapi.Raw,
+	})
+	openapi.Describe((*Handler).Revoke, openapi.Operation{
+		Summary: "Revoke an access or refresh token (RFC 7009)",
+		Form:    append([]openapi.Param{{Name: "token", Required: true}}, clientAuth...),
+		Shape:   openapi.Empty,
+	})
+
+	openapi.Describe((*Handler).GetCurrentUser, openapi.Operation{
+		Summary:  "Get the user the token acts for",
+		Response: models.PublicUser{},
+	})
+	openapi.Describe((*Handler).GetCurrentUserCommunities, openapi.Operation{
+		Summary:  "List the communities the user is in",
+		Response: []*models.Community{},
+	})
+	openapi.Describe((*Handler).SendMessage, openapi.Operation{
+		Summary:  "Send a message as the user",
+		Path:     []openapi.Param{openapi.ID("channelId")},
+		Body:     SendMessageRequest{},
+		Response: message.MessageResponse{},
+		Status:   http.StatusCreated,
+	})
+}
@@ -0,0 +1,405 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zentra/server/internal/models"
+)
+
+const (
+	MaxAppsPerUser  = 25
+	MaxRedirectURIs = 10
+
+	codeTTL         = 10 * time.Minute
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	// Secrets are shown once; the prefixes make leaked ones easy to spot
+	clientSecretPrefix = "zcs_"
+	accessTokenPrefix  = "zoa_"
+	refreshTokenPrefix = "zor_"
+)
+
+var (
+	ErrAppNotFound           = errors.New("oauth app not found")
+	ErrTooManyApps           = errors.New("too many oauth apps")
+	ErrInvalidRedirectURI    = errors.New("redirect uris must be absolute https urls, or http on localhost")
+	ErrRedirectMismatch      = errors.New("redirect uri is not registered for this app")
+	ErrPublicAppSecret       = errors.New("public apps don't have a client secret")
+	ErrAuthorizationNotFound = errors.New("authorization not found")
+	ErrInvalidToken          = errors.New("invalid oauth token")
+)
+
+type Service struct {
+	db *pgxpool.Pool
+}
+
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{db: db}
+}
+
+type CreateAppRequest struct {
+	Name         string   `json:"name" validate:"required,min=1,max=80"`
+	Description  *string  `json:"description" validate:"omitempty,max=1000"`
+	IconURL      *string  `json:"iconUrl" validate:"omitempty,url,max=512"`
+	WebsiteURL   *string  `json:"websiteUrl" validate:"omitempty,url,max=512"`
+	RedirectURIs []string `json:"redirectUris" validate:"required,min=1,max=10,unique,dive,required,max=512"`
+	// IsPublic is for apps that can't keep a secret, like mobile and browser
+	// apps. They authenticate with PKCE alone.
+	IsPublic bool `json:"isPublic"`
+}
+
+type UpdateAppRequest struct {
+	Name         *string   `json:"name" validate:"omitempty,min=1,max=80"`
+	Description  *string   `json:"description" validate:"omitempty,max=1000"`
+	IconURL      *string   `json:"iconUrl" validate:"omitempty,url,max=512"`
+	WebsiteURL   *string   `json:"websiteUrl" validate:"omitempty,url,max=512"`
+	RedirectURIs *[]string `json:"redirectUris" validate:"omitempty,min=1,max=10,unique,dive,required,max=512"`
+}
+
+// AppWithSecret is returned when an app is created or its secret rotated.
+// The secret is not shown again.
+type AppWithSecret struct {
+	*models.OAuthApp
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret,omitempty"`
+}
+
+const appColumns = `id, owner_id, name, description, icon_url, website_url, redirect_uris, is_public,
+	client_secret_preview, created_at, updated_at`
+
+func scanApp(scanner interface{ Scan(dest ...any) error }) (*models.OAuthApp, error) {
+	a := &models.OAuthApp{}
+	err := scanner.Scan(
+		&a.ID, &a.OwnerID, &a.Name, &a.Description, &a.IconURL, &a.WebsiteURL, &a.RedirectURIs, &a.IsPublic,
+		&a.ClientSecretPreview, &a.CreatedAt, &a.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ListApps returns the apps userID registered
+func (s *Service) ListApps(ctx context.Context, userID uuid.UUID) ([]*models.OAuthApp, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT `+appColumns+` FROM oauth_apps WHERE owner_id = $1 ORDER BY created_at ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list oauth apps: %w", err)
+	}
+	defer rows.Close()
+
+	apps := make([]*models.OAuthApp, 0)
+	for rows.Next() {
+		a, err := scanApp(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan oauth app: %w", err)
+		}
+		apps = append(apps, a)
+	}
+	return apps, rows.Err()
+}
+
+// CreateApp registers an app. Confidential apps get a client secret.
+func (s *Service) CreateApp(ctx context.Context, userID uuid.UUID, req *CreateAppRequest) (*AppWithSecret, error) {
+	if err := validateRedirectURIs(req.RedirectURIs); err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM oauth_apps WHERE owner_id = $1`, userID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("count oauth apps: %w", err)
+	}
+	if count >= MaxAppsPerUser {
+		return nil, ErrTooManyApps
+	}
+
+	var secret string
+	var secretHash, secretPreview *string
+	if !req.IsPublic {
+		raw, err := generateSecret(clientSecretPrefix)
+		if err != nil {
+			return nil, err
+		}
+		hash, preview := hashSecret(raw), previewSecret(raw)
+		secret, secretHash, secretPreview = raw, &hash, &preview
+	}
+
+	app, err := scanApp(s.db.QueryRow(ctx,
+		`INSERT INTO oauth_apps (owner_id, name, description, icon_url, website_url, redirect_uris, is_public,
+		                         client_secret_hash, client_secret_preview)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		 RETURNING `+appColumns,
+		userID, strings.TrimSpace(req.Name), req.Description, req.IconURL, req.WebsiteURL, req.RedirectURIs, req.IsPublic,
+		secretHash, secretPreview,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("create oauth app: %w", err)
+	}
+
+	return &AppWithSecret{OAuthApp: app, ClientID: app.ID.String(), ClientSecret: secret}, nil
+}
+
+// GetApp returns one of userID's apps
+func (s *Service) GetApp(ctx context.Context, appID, userID uuid.UUID) (*models.OAuthApp, error) {
+	app, err := s.getApp(ctx, appID)
+	if err != nil {
+		return nil, err
+	}
+	if app.OwnerID != userID {
+		return nil, ErrAppNotFound
+	}
+	return app, nil
+}
+
+// UpdateApp changes an app's details or redirect URIs
+func (s *Service) UpdateApp(ctx context.Context, appID, userID uuid.UUID, req *UpdateAppRequest) (*models.OAuthApp, error) {
+	app, err := s.GetApp(ctx, appID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		app.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Description != nil {
+		app.Description = req.Description
+	}
+	if req.IconURL != nil {
+		app.IconURL = req.IconURL
+	}
+	if req.WebsiteURL != nil {
+		app.WebsiteURL = req.WebsiteURL
+	}
+	if req.RedirectURIs != nil {
+		if err := validateRedirectURIs(*req.RedirectURIs); err != nil {
+			return nil, err
+		}
+		app.RedirectURIs = *req.RedirectURIs
+	}
+
+	app, err = scanApp(s.db.QueryRow(ctx,
+		`UPDATE oauth_apps SET name = $2, description = $3, icon_url = $4, website_url = $5, redirect_uris = $6
+		 WHERE id = $1
+		 RETURNING `+appColumns,
+		appID, app.Name, app.Description, app.IconURL, app.WebsiteURL, app.RedirectURIs,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("update oauth app: %w", err)
+	}
+	return app, nil
+}
+
+// DeleteApp removes an app along with every token it holds
+func (s *Service) DeleteApp(ctx context.Context, appID, userID uuid.UUID) error {
+	tag, err := s.db.Exec(ctx, `DELETE FROM oauth_apps WHERE id = $1 AND owner_id = $2`, appID, userID)
+	if err != nil {
+		return fmt.Errorf("delete oauth app: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAppNotFound
+	}
+	return nil
+}
+
+// RotateSecret issues a new client secret. The old one stops working at once.
+func (s *Service) RotateSecret(ctx context.Context, appID, userID uuid.UUID) (*AppWithSecret, error) {
+	app, err := s.GetApp(ctx, appID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if app.IsPublic {
+		return nil, ErrPublicAppSecret
+	}
+
+	secret, err := generateSecret(clientSecretPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	app, err = scanApp(s.db.QueryRow(ctx,
+		`UPDATE oauth_apps SET client_secret_hash = $2, client_secret_preview = $3 WHERE id = $1
+		 RETURNING `+appColumns,
+		appID, hashSecret(secret), previewSecret(secret),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("rotate oauth app secret: %w", err)
+	}
+
+	return &AppWithSecret{OAuthApp: app, ClientID: app.ID.String(), ClientSecret: secret}, nil
+}
+
+// ListAuthorizations returns the apps userID has authorized
+func (s *Service) ListAuthorizations(ctx context.Context, userID uuid.UUID) ([]*models.OAuthAuthorization, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT `+prefixColumns("a.", appColumns)+`, z.scopes, z.created_at, z.updated_at
+		 FROM oauth_authorizations z
+		 JOIN oauth_apps a ON a.id = z.app_id
+		 WHERE z.user_id = $1
+		 ORDER BY z.updated_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list oauth authorizations: %w", err)
+	}
+	defer rows.Close()
+
+	authorizations := make([]*models.OAuthAuthorization, 0)
+	for rows.Next() {
+		a := &models.OAuthAuthorization{App: &models.OAuthApp{}}
+		app := a.App
+		if err := rows.Scan(
+			&app.ID, &app.OwnerID, &app.Name, &app.Description, &app.IconURL, &app.WebsiteURL, &app.RedirectURIs, &app.IsPublic,
+			&app.ClientSecretPreview, &app.CreatedAt, &app.UpdatedAt,
+			&a.Scopes, &a.CreatedAt, &a.UpdatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan oauth authorization: %w", err)
+		}
+		// Only the owner needs to see the secret preview
+		app.ClientSecretPreview = nil
+		authorizations = append(authorizations, a)
+	}
+	return authorizations, rows.Err()
+}
+
+// RevokeAuthorization withdraws userID's consent to an app, revokes every
+// token the app holds for them and deletes codes it hasn't exchanged yet
+func (s *Service) RevokeAuthorization(ctx context.Context, appID, userID uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM oauth_authorizations WHERE app_id = $1 AND user_id = $2`, appID, userID)
+	if err != nil {
+		return fmt.Errorf("delete oauth authorization: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAuthorizationNotFound
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE oauth_tokens SET revoked_at = NOW() WHERE app_id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		appID, userID,
+	); err != nil {
+		return fmt.Errorf("revoke oauth tokens: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM oauth_codes WHERE app_id = $1 AND user_id = $2 AND used_at IS NULL`,
+		appID, userID,
+	); err != nil {
+		return fmt.Errorf("delete oauth codes: %w", err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// PruneExpired deletes codes and tokens that can no longer be used
+func (s *Service) PruneExpired(ctx context.Context) (int64, error) {
+	codes, err := s.db.Exec(ctx, `DELETE FROM oauth_codes WHERE expires_at < NOW() - INTERVAL '1 day'`)
+	if err != nil {
+		return 0, err
+	}
+	tokens, err := s.db.Exec(ctx,
+		`DELETE FROM oauth_tokens WHERE refresh_expires_at < NOW() OR revoked_at < NOW() - INTERVAL '7 days'`,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return codes.RowsAffected() + tokens.RowsAffected(), nil
+}
+
+func (s *Service) getApp(ctx context.Context, appID uuid.UUID) (*models.OAuthApp, error) {
+	app, err := scanApp(s.db.QueryRow(ctx, `SELECT `+appColumns+` FROM oauth_apps WHERE id = $1`, appID))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAppNotFound
+		}
+		return nil, fmt.Errorf("get oauth app: %w", err)
+	}
+	return app, nil
+}
+
+// validateRedirectURIs only accepts https, or plain http on the loopback
+// interface for local development and native apps
+func validateRedirectURIs(uris []string) error {
+	if len(uris) == 0 || len(uris) > MaxRedirectURIs {
+		return ErrInvalidRedirectURI
+	}
+	for _, raw := range uris {
+		u, err := url.Parse(raw)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" || u.User != nil {
+			return ErrInvalidRedirectURI
+		}
+		switch u.Scheme {
+		case "https":
+		case "http":
+			host := u.Hostname()
+			if host != "localhost" && host != "127.0.0.1" && host != "::1" {
+				return ErrInvalidRedirectURI
+			}
+		default:
+			return ErrInvalidRedirectURI
+		}
+	}
+	return nil
+}
+
+// parseScopes splits a space separated scope string, rejecting unknown scopes
+func parseScopes(raw string) ([]string, bool) {
+	scopes := make([]string, 0)
+	for _, scope := range strings.Fields(raw) {
+		if _, ok := models.OAuthScopeDescriptions[scope]; !ok {
+			return nil, false
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	slices.Sort(scopes)
+	return scopes, len(scopes) > 0
+}
+
+func prefixColumns(prefix, columns string) string {
+	parts := strings.Split(columns, ",")
+	for i, part := range parts {
+		parts[i] = prefix + strings.TrimSpace(part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func generateSecret(prefix string) (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func previewSecret(secret string) string {
+	if len(secret) <= 12 {
+		return secret
+	}
+	return secret[:12]
+}
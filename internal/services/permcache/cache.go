@@ -0,0 +1,407 @@
+// Package permcache caches the resolved channel permissions of community
+// members. Entries are shared between gateways through Redis, with a small
+// in-process LRU in front for the hottest members.
+//
+// Nothing is updated in place. A change to roles, overwrites or channels bumps
+// the community's version and a change to one member bumps theirs, which makes
+// every Redis entry computed before it stale. The bump is also published so
+// each gateway drops its local copies right away; a missed message is covered
+// by the short local TTL. A bump Redis doesn't take is retried, and until it
+// goes through this gateway computes the affected members itself.
+package permcache
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+const (
+	keyPrefix           = "permcache:"
+	invalidationChannel = "permcache:invalidate"
+
+	// DefaultLocalSize is how many members each gateway keeps in memory
+	DefaultLocalSize = 10000
+
+	redisTTL   = 2 * time.Minute
+	localTTL   = 30 * time.Second
+	versionTTL = 24 * time.Hour
+
+	// bumpRetryInterval is how often Run retries failed bumps
+	bumpRetryInterval = 5 * time.Second
+)
+
+var lookupsTotal = metrics.NewCounter("zentra_permission_cache_lookups_total",
+	"Permission lookups by where they were answered: local, redis or computed.", "source")
+
+// Entry is one member's permissions in every channel of a community
+type Entry struct {
+	CommunityVersion int64 `json:"cv"`
+	MemberVersion    int64 `json:"mv"`
+	// Base is the member's community-wide permissions
+	Base     int64               `json:"base"`
+	Channels map[uuid.UUID]int64 `json:"channels"`
+	// ExpiresAt, when set, is when the entry stops being valid on its own,
+	// e.g. when the member's timeout ends
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Channel returns the member's permissions in a channel, or false when the
+// channel isn't in the entry
+func (e *Entry) Channel(channelID uuid.UUID) (int64, bool) {
+	permissions, ok := e.Channels[channelID]
+	return permissions, ok
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// Loader computes an entry from the database. The versions are filled in by
+// the cache.
+type Loader func(ctx context.Context) (*Entry, error)
+
+// Cache is safe for concurrent use. A nil *Cache computes every lookup and
+// ignores invalidations, so callers don't need to check whether one is set.
+type Cache struct {
+	redis *redis.Client
+	local *lru
+
+	mu sync.Mutex
+	// pending holds the bumps Redis didn't take, by version key, with the
+	// message to publish once it does
+	pending map[string]string
+}
+
+func New(redisClient *redis.Client, localSize int) *Cache {
+	if localSize <= 0 {
+		localSize = DefaultLocalSize
+	}
+	return &Cache{redis: redisClient, local: newLRU(localSize), pending: make(map[string]string)}
+}
+
+// Get returns the member's entry, computing it with load when neither cache
+// has a current one. Load errors are returned and not cached. When Redis is
+// unavailable the entry is computed and not stored.
+func (c *Cache) Get(ctx context.Context, communityID, userID uuid.UUID, load Loader) (*Entry, error) {
+	if c == nil {
+		lookupsTotal.Inc("computed")
+		return load(ctx)
+	}
+
+	now := time.Now()
+	k := localKey{communityID, userID}
+	if e := c.local.get(k, now); e != nil {
+		lookupsTotal.Inc("local")
+		return e, nil
+	}
+	if c.bumpPending(communityID, userID) {
+		// Redis may still hold entries the failed bump should have made stale
+		lookupsTotal.Inc("computed")
+		return load(ctx)
+	}
+	// An invalidation from here on means what gets loaded may already be stale
+	generation := c.local.generation()
+
+	values, err := c.redis.MGet(ctx,
+		communityVersionKey(communityID),
+		memberVersionKey(communityID, userID),
+		entryKey(communityID, userID),
+	).Result()
+	if err != nil {
+		log.Debug().Err(err).Msg("Permission cache unavailable")
+		lookupsTotal.Inc("computed")
+		return load(ctx)
+	}
+	communityVersion := parseVersion(values[0])
+	memberVersion := parseVersion(values[1])
+
+	if raw, ok := values[2].(string); ok {
+		var e Entry
+		if json.Unmarshal([]byte(raw), &e) == nil &&
+			e.CommunityVersion == communityVersion && e.MemberVersion == memberVersion && !e.expired(now) {
+			c.local.put(k, &e, now, generation)
+			lookupsTotal.Inc("redis")
+			return &e, nil
+		}
+	}
+
+	lookupsTotal.Inc("computed")
+	e, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.CommunityVersion = communityVersion
+	e.MemberVersion = memberVersion
+
+	ttl := redisTTL
+	if !e.ExpiresAt.IsZero() {
+		if until := e.ExpiresAt.Sub(now); until < ttl {
+			ttl = until
+		}
+	}
+	if ttl > 0 {
+		if data, err := json.Marshal(e); err == nil {
+			if err := c.redis.Set(ctx, entryKey(communityID, userID), data, ttl).Err(); err != nil {
+				log.Debug().Err(err).Msg("Failed to store permission cache entry")
+			}
+		}
+		c.local.put(k, e, now, generation)
+	}
+	return e, nil
+}
+
+// InvalidateCommunity drops every member's entry for the community. Call it
+// after roles, channels or channel overwrites change.
+func (c *Cache) InvalidateCommunity(ctx context.Context, communityID uuid.UUID) {
+	if c == nil {
+		return
+	}
+	c.local.removeCommunity(communityID)
+	c.bump(ctx, communityVersionKey(communityID), communityID.String())
+}
+
+// InvalidateMember drops one member's entry. Call it after the member joins,
+// leaves, is timed out or has their roles changed.
+func (c *Cache) InvalidateMember(ctx context.Context, communityID, userID uuid.UUID) {
+	if c == nil {
+		return
+	}
+	c.local.remove(localKey{communityID, userID})
+	c.bump(ctx, memberVersionKey(communityID, userID), communityID.String()+":"+userID.String())
+}
+
+// bump makes the Redis entries behind versionKey stale and tells the other
+// gateways. When Redis doesn't take it, every local entry is dropped, since
+// one loaded from Redis before the failure may be stale too, and the bump is
+// kept for Run to retry.
+func (c *Cache) bump(ctx context.Context, versionKey, message string) {
+	if err := c.publishBump(ctx, versionKey, message); err != nil {
+		log.Warn().Err(err).Str("key", versionKey).Msg("Failed to invalidate permission cache")
+		c.mu.Lock()
+		c.pending[versionKey] = message
+		c.mu.Unlock()
+		c.local.clear()
+	}
+}
+
+func (c *Cache) publishBump(ctx context.Context, versionKey, message string) error {
+	pipe := c.redis.TxPipeline()
+	pipe.Incr(ctx, versionKey)
+	pipe.Expire(ctx, versionKey, versionTTL)
+	pipe.Publish(ctx, invalidationChannel, message)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// bumpPending reports whether a failed bump covers the member
+func (c *Cache) bumpPending(communityID, userID uuid.UUID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.pending) == 0 {
+		return false
+	}
+	_, community := c.pending[communityVersionKey(communityID)]
+	_, member := c.pending[memberVersionKey(communityID, userID)]
+	return community || member
+}
+
+// retryBumps tries the failed bumps again, keeping the ones that still fail
+func (c *Cache) retryBumps(ctx context.Context) {
+	c.mu.Lock()
+	pending := make(map[string]string, len(c.pending))
+	for versionKey, message := range c.pending {
+		pending[versionKey] = message
+	}
+	c.mu.Unlock()
+
+	for versionKey, message := range pending {
+		if err := c.publishBump(ctx, versionKey, message); err != nil {
+			log.Debug().Err(err).Str("key", versionKey).Msg("Permission cache invalidation still failing")
+			return
+		}
+		c.mu.Lock()
+		delete(c.pending, versionKey)
+		c.mu.Unlock()
+	}
+}
+
+// Run drops local entries as other gateways invalidate them and retries failed
+// bumps, until ctx is cancelled. The subscription reconnects by itself;
+// anything published while it is down only lives on locally until localTTL.
+func (c *Cache) Run(ctx context.Context) {
+	if c == nil {
+		return
+	}
+	sub := c.redis.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+
+	retry := time.NewTicker(bumpRetryInterval)
+	defer retry.Stop()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retry.C:
+			c.retryBumps(ctx)
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			c.handleInvalidation(msg.Payload)
+		}
+	}
+}
+
+func (c *Cache) handleInvalidation(payload string) {
+	communityPart, userPart, member := strings.Cut(payload, ":")
+	communityID, err := uuid.Parse(communityPart)
+	if err != nil {
+		return
+	}
+	if !member {
+		c.local.removeCommunity(communityID)
+		return
+	}
+	userID, err := uuid.Parse(userPart)
+	if err != nil {
+		return
+	}
+	c.local.remove(localKey{communityID, userID})
+}
+
+func entryKey(communityID, userID uuid.UUID) string {
+	return keyPrefix + communityID.String() + ":" + userID.String()
+}
+
+func communityVersionKey(communityID uuid.UUID) string {
+	return keyPrefix + "version:" + communityID.String()
+}
+
+func memberVersionKey(communityID, userID uuid.UUID) string {
+	return keyPrefix + "version:" + communityID.String() + ":" + userID.String()
+}
+
+// parseVersion reads a version counter from MGET. A missing one is version 0.
+func parseVersion(v any) int64 {
+	s, ok := v.(string)
+	if !ok {
+		return 0
+	}
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+type localKey struct {
+	communityID uuid.UUID
+	userID      uuid.UUID
+}
+
+type localEntry struct {
+	key       localKey
+	entry     *Entry
+	expiresAt time.Time
+}
+
+// lru is the in-process cache. Entries live for localTTL at most, since only
+// the published invalidations reach it.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // most recently used first
+	items map[localKey]*list.Element
+	// gen counts removals. An entry loaded across one isn't kept.
+	gen uint64
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), items: make(map[localKey]*list.Element)}
+}
+
+func (l *lru) get(k localKey, now time.Time) *Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[k]
+	if !ok {
+		return nil
+	}
+	le := el.Value.(*localEntry)
+	if !now.Before(le.expiresAt) {
+		l.order.Remove(el)
+		delete(l.items, k)
+		return nil
+	}
+	l.order.MoveToFront(el)
+	return le.entry
+}
+
+func (l *lru) generation() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.gen
+}
+
+func (l *lru) put(k localKey, e *Entry, now time.Time, generation uint64) {
+	expiresAt := now.Add(localTTL)
+	if !e.ExpiresAt.IsZero() && e.ExpiresAt.Before(expiresAt) {
+		expiresAt = e.ExpiresAt
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.gen != generation {
+		return
+	}
+	if el, ok := l.items[k]; ok {
+		el.Value = &localEntry{key: k, entry: e, expiresAt: expiresAt}
+		l.order.MoveToFront(el)
+		return
+	}
+	l.items[k] = l.order.PushFront(&localEntry{key: k, entry: e, expiresAt: expiresAt})
+	for l.order.Len() > l.size {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*localEntry).key)
+	}
+}
+
+func (l *lru) remove(k localKey) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gen++
+	if el, ok := l.items[k]; ok {
+		l.order.Remove(el)
+		delete(l.items, k)
+	}
+}
+
+func (l *lru) removeCommunity(communityID uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gen++
+	for k, el := range l.items {
+		if k.communityID == communityID {
+			l.order.Remove(el)
+			delete(l.items, k)
+		}
+	}
+}
+
+func (l *lru) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gen++
+	l.order.Init()
+	clear(l.items)
+}
@@ -0,0 +1,220 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/utils"
+)
+
+// What plugins can read and do through their API token (see apitoken.go).
+// Reads return the same JSON shapes the sandbox's host functions do.
+
+var (
+	ErrNotInCommunity     = errors.New("not found in this community")
+	ErrChannelNotPostable = errors.New("plugin may not post in this channel")
+)
+
+// MessagePoster posts messages as an installation's bot user
+type MessagePoster interface {
+	CreateBotMessage(ctx context.Context, m *message.BotMessage) (*message.MessageResponse, error)
+}
+
+// SetMessageService posts plugin messages through the message service so
+// they get AutoMod, events and notifications like any other message. Call
+// during startup only.
+func (s *Service) SetMessageService(messages MessagePoster) {
+	s.messages = messages
+}
+
+// CommunityInfo returns the token's community
+func (s *Service) CommunityInfo(ctx context.Context, token *models.PluginAPIToken) (json.RawMessage, error) {
+	if !token.HasPermission(models.PluginPermServerInfo) {
+		return nil, ErrPermissionDenied
+	}
+	return s.communityJSON(ctx, token.CommunityID)
+}
+
+// ListChannels returns every channel of the token's community
+func (s *Service) ListChannels(ctx context.Context, token *models.PluginAPIToken) (json.RawMessage, error) {
+	if !token.HasPermission(models.PluginPermReadChannels) {
+		return nil, ErrPermissionDenied
+	}
+	return s.queryJSON(ctx,
+		`SELECT COALESCE(json_agg(`+channelObject+` ORDER BY position, created_at), '[]'::json)
+		 FROM channels WHERE community_id = $1`,
+		token.CommunityID,
+	)
+}
+
+// GetChannel returns one channel of the token's community
+func (s *Service) GetChannel(ctx context.Context, token *models.PluginAPIToken, channelID uuid.UUID) (json.RawMessage, error) {
+	if !token.HasPermission(models.PluginPermReadChannels) {
+		return nil, ErrPermissionDenied
+	}
+	return s.channelJSON(ctx, token.CommunityID, channelID)
+}
+
+// ListMembers returns a page of the token's community's members, oldest first,
+// and the cursor of the next page or "" on the last one
+func (s *Service) ListMembers(ctx context.Context, token *models.PluginAPIToken, cursor string, limit int) (json.RawMessage, string, error) {
+	if !token.HasPermission(models.PluginPermReadMembers) {
+		return nil, "", ErrPermissionDenied
+	}
+	after, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	query := `SELECT row_to_json(m), m."joinedAt", m."userId"
+		 FROM (
+		     SELECT ` + memberColumns + `
+		     FROM community_members cm
+		     JOIN users u ON u.id = cm.user_id
+		     WHERE cm.community_id = $1`
+	args := []any{token.CommunityID, limit + 1}
+	if after != nil {
+		query += ` AND (cm.joined_at, cm.user_id) > ($3, $4)`
+		args = append(args, after.Time, after.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		     ORDER BY cm.joined_at, cm.user_id
+		     LIMIT $2
+		 ) m
+		 ORDER BY m."joinedAt", m."userId"`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	members := make([]json.RawMessage, 0)
+	var last utils.KeysetCursor
+	more := false
+	for rows.Next() {
+		if len(members) == limit {
+			more = true
+			break
+		}
+		var member json.RawMessage
+		if err := rows.Scan(&member, &last.Time, &last.ID); err != nil {
+			return nil, "", err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if more {
+		next = utils.EncodeCursor(last)
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, next, nil
+}
+
+// GetMember returns one member of the token's community
+func (s *Service) GetMember(ctx context.Context, token *models.PluginAPIToken, userID uuid.UUID) (json.RawMessage, error) {
+	if !token.HasPermission(models.PluginPermReadMembers) {
+		return nil, ErrPermissionDenied
+	}
+	return s.memberJSON(ctx, token.CommunityID, userID)
+}
+
+// SendMessage posts a message as the installation's bot user
+func (s *Service) SendMessage(ctx context.Context, token *models.PluginAPIToken, channelID uuid.UUID, content string) (*message.MessageResponse, error) {
+	if !token.HasPermission(models.PluginPermSendMessages) {
+		return nil, ErrPermissionDenied
+	}
+
+	// The bot user has no roles, so it only posts where everyone may, or in
+	// the plugin's own channels
+	allowed, err := s.channels.IntegrationCanPost(ctx, token.CommunityID, channelID, token.PluginID)
+	if err != nil {
+		if errors.Is(err, channel.ErrChannelNotFound) {
+			return nil, ErrNotInCommunity
+		}
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrChannelNotPostable
+	}
+
+	resp, err := s.messages.CreateBotMessage(ctx, &message.BotMessage{
+		ChannelID:   channelID,
+		CommunityID: token.CommunityID,
+		AuthorID:    token.BotUserID,
+		Content:     content,
+	})
+	if err != nil {
+		if errors.Is(err, message.ErrChannelUnavailable) {
+			return nil, ErrNotInCommunity
+		}
+		return nil, err
+	}
+
+	s.logAction(ctx, token.CommunityID, token.PluginID, token.BotUserID, "message_send", map[string]any{
+		"channelId": channelID,
+		"messageId": resp.ID,
+	})
+	return resp, nil
+}
+
+const channelObject = `json_build_object('id', id, 'communityId', community_id, 'categoryId', category_id, 'name', name,
+	'topic', topic, 'type', type, 'position', position, 'isNsfw', is_nsfw, 'archived', archived_at IS NOT NULL)`
+
+const memberColumns = `u.id AS "userId", u.username AS "username", u.display_name AS "displayName",
+	u.avatar_url AS "avatarUrl", cm.nickname AS "nickname", cm.joined_at AS "joinedAt"`
+
+func (s *Service) communityJSON(ctx context.Context, communityID uuid.UUID) (json.RawMessage, error) {
+	return s.queryJSON(ctx,
+		`SELECT json_build_object('id', id, 'name', name, 'description', description, 'iconUrl', icon_url,
+		        'ownerId', owner_id, 'memberCount', member_count, 'createdAt', created_at)
+		 FROM communities WHERE id = $1 AND deleted_at IS NULL`,
+		communityID,
+	)
+}
+
+func (s *Service) channelJSON(ctx context.Context, communityID, channelID uuid.UUID) (json.RawMessage, error) {
+	return s.queryJSON(ctx,
+		`SELECT `+channelObject+` FROM channels WHERE id = $1 AND community_id = $2`,
+		channelID, communityID,
+	)
+}
+
+func (s *Service) memberJSON(ctx context.Context, communityID, userID uuid.UUID) (json.RawMessage, error) {
+	return s.queryJSON(ctx,
+		`SELECT row_to_json(m) FROM (
+		     SELECT `+memberColumns+`
+		     FROM community_members cm
+		     JOIN users u ON u.id = cm.user_id
+		     WHERE cm.community_id = $1 AND cm.user_id = $2
+		 ) m`,
+		communityID, userID,
+	)
+}
+
+// queryJSON runs a query returning one JSON value
+func (s *Service) queryJSON(ctx context.Context, query string, args ...any) (json.RawMessage, error) {
+	var data json.RawMessage
+	err := s.db.QueryRow(ctx, query, args...).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotInCommunity
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
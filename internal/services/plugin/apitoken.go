@@ -0,0 +1,234 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// Each installation can have one API token, which the plugin sends as
+// "Authorization: Plugin zpt_..." to the /plugin-api routes. The token only
+// reaches the installation's community, and only what its granted
+// permissions cover; changing the grants applies to the token straight away.
+// Requests act as a bot user made for the installation, so messages the
+// plugin sends show up under its name.
+
+const (
+	// Tokens are shown once; the prefix makes leaked ones easy to spot
+	apiTokenPrefix = "zpt_"
+
+	// last_used_at is only written this often per token
+	apiTokenLastUsedResolution = time.Minute
+)
+
+var (
+	ErrAPITokenNotFound = errors.New("plugin has no api token")
+	ErrInvalidAPIToken  = errors.New("invalid plugin api token")
+	ErrPermissionDenied = errors.New("plugin does not have the required permission")
+)
+
+// APITokenWithSecret is a token as issued, the only time the secret is shown
+type APITokenWithSecret struct {
+	*models.PluginAPIToken
+	Token string `json:"token"`
+}
+
+const apiTokenColumns = `cp.id, cp.community_id, cp.plugin_id, cp.bot_user_id, cp.granted_permissions,
+	t.token_preview, t.created_at, t.last_used_at`
+
+func scanAPIToken(scanner interface{ Scan(dest ...any) error }) (*models.PluginAPIToken, error) {
+	t := &models.PluginAPIToken{}
+	err := scanner.Scan(
+		&t.InstallationID, &t.CommunityID, &t.PluginID, &t.BotUserID, &t.GrantedPermissions,
+		&t.TokenPreview, &t.CreatedAt, &t.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetAPIToken returns the installation's token without its secret
+func (s *Service) GetAPIToken(ctx context.Context, communityID, pluginID, userID uuid.UUID) (*models.PluginAPIToken, error) {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	token, err := scanAPIToken(s.db.QueryRow(ctx,
+		`SELECT `+apiTokenColumns+`
+		 FROM plugin_api_tokens t
+		 JOIN community_plugins cp ON cp.id = t.installation_id
+		 WHERE cp.community_id = $1 AND cp.plugin_id = $2`,
+		communityID, pluginID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAPITokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get plugin api token: %w", err)
+	}
+	return token, nil
+}
+
+// IssueAPIToken creates the installation's token, or replaces it so the old
+// one stops working at once
+func (s *Service) IssueAPIToken(ctx context.Context, communityID, pluginID, userID uuid.UUID) (*APITokenWithSecret, error) {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+
+	secret, err := generateAPIToken()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var installationID uuid.UUID
+	var botUserID *uuid.UUID
+	var pluginName string
+	err = tx.QueryRow(ctx,
+		`SELECT cp.id, cp.bot_user_id, p.name
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 WHERE cp.community_id = $1 AND cp.plugin_id = $2
+		 FOR UPDATE OF cp`,
+		communityID, pluginID,
+	).Scan(&installationID, &botUserID, &pluginName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotInstalled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if botUserID == nil {
+		id, err := messaging.CreateBotUser(ctx, tx, messaging.BotUserPlugin, installationID, pluginName, nil)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE community_plugins SET bot_user_id = $1 WHERE id = $2`, id, installationID); err != nil {
+			return nil, err
+		}
+		botUserID = &id
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO plugin_api_tokens (installation_id, token_hash, token_preview, created_by)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (installation_id) DO UPDATE
+		 SET token_hash = EXCLUDED.token_hash, token_preview = EXCLUDED.token_preview,
+		     created_by = EXCLUDED.created_by, created_at = NOW(), last_used_at = NULL`,
+		installationID, hashAPIToken(secret), apiTokenPreview(secret), userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("issue plugin api token: %w", err)
+	}
+
+	token, err := scanAPIToken(tx.QueryRow(ctx,
+		`SELECT `+apiTokenColumns+`
+		 FROM plugin_api_tokens t
+		 JOIN community_plugins cp ON cp.id = t.installation_id
+		 WHERE t.installation_id = $1`,
+		installationID,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.logAction(ctx, communityID, pluginID, userID, "api_token_issue", map[string]any{
+		"tokenPreview": token.TokenPreview,
+	})
+	return &APITokenWithSecret{PluginAPIToken: token, Token: secret}, nil
+}
+
+// RevokeAPIToken deletes the installation's token. The bot user stays so
+// messages it sent keep their author.
+func (s *Service) RevokeAPIToken(ctx context.Context, communityID, pluginID, userID uuid.UUID) error {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return err
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM plugin_api_tokens t
+		 USING community_plugins cp
+		 WHERE cp.id = t.installation_id AND cp.community_id = $1 AND cp.plugin_id = $2`,
+		communityID, pluginID,
+	)
+	if err != nil {
+		return fmt.Errorf("revoke plugin api token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPITokenNotFound
+	}
+
+	s.logAction(ctx, communityID, pluginID, userID, "api_token_revoke", nil)
+	return nil
+}
+
+// AuthenticateAPIToken resolves a raw token. Tokens of disabled plugins are
+// rejected until the plugin is turned back on.
+func (s *Service) AuthenticateAPIToken(ctx context.Context, raw string) (*models.PluginAPIToken, error) {
+	if !strings.HasPrefix(raw, apiTokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	token, err := scanAPIToken(s.db.QueryRow(ctx,
+		`SELECT `+apiTokenColumns+`
+		 FROM plugin_api_tokens t
+		 JOIN community_plugins cp ON cp.id = t.installation_id
+		 WHERE t.token_hash = $1 AND cp.enabled AND cp.bot_user_id IS NOT NULL`,
+		hashAPIToken(raw),
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > apiTokenLastUsedResolution {
+		_, _ = s.db.Exec(ctx, `UPDATE plugin_api_tokens SET last_used_at = $1 WHERE installation_id = $2`, now, token.InstallationID)
+		token.LastUsedAt = &now
+	}
+	return token, nil
+}
+
+func generateAPIToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func apiTokenPreview(token string) string {
+	if len(token) <= 12 {
+		return token
+	}
+	return token[:12]
+}
@@ -0,0 +1,85 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
+)
+
+var ErrChannelsNotAllowed = errors.New("plugin is not allowed to manage channels")
+
+// CreateChannelAsUser creates a channel managed by the plugin on behalf of the
+// signed-in user. The plugin needs ManageChannels, and so does the user.
+func (s *Service) CreateChannelAsUser(ctx context.Context, communityID, pluginID, userID uuid.UUID, req *channel.CreateChannelRequest) (*models.Channel, error) {
+	if err := s.requireChannelGrant(ctx, communityID, pluginID); err != nil {
+		return nil, err
+	}
+
+	ch, err := s.channels.CreateManagedChannel(ctx, communityID, pluginID, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logAction(ctx, communityID, pluginID, userID, "channel_create", map[string]any{
+		"channelId": ch.ID,
+		"name":      ch.Name,
+	})
+	return ch, nil
+}
+
+// DeleteChannelAsUser deletes one of the plugin's channels on behalf of the
+// signed-in user
+func (s *Service) DeleteChannelAsUser(ctx context.Context, communityID, pluginID, channelID, userID uuid.UUID) error {
+	if err := s.requireChannelGrant(ctx, communityID, pluginID); err != nil {
+		return err
+	}
+
+	if err := s.channels.DeleteManagedChannel(ctx, communityID, pluginID, channelID, userID); err != nil {
+		return err
+	}
+
+	s.logAction(ctx, communityID, pluginID, userID, "channel_delete", map[string]any{
+		"channelId": channelID,
+	})
+	return nil
+}
+
+// GetManagedChannels lists the plugin's channels in a community that the
+// user can see
+func (s *Service) GetManagedChannels(ctx context.Context, communityID, pluginID, userID uuid.UUID) ([]*models.Channel, error) {
+	if !s.isCommunityMember(ctx, communityID, userID) {
+		return nil, ErrNotInstalled
+	}
+	if _, err := s.GetCommunityPlugin(ctx, communityID, pluginID); err != nil {
+		return nil, err
+	}
+
+	channels, err := s.channels.GetManagedChannels(ctx, communityID, pluginID)
+	if err != nil {
+		return nil, err
+	}
+	visible := []*models.Channel{}
+	for _, ch := range channels {
+		if s.channelAccess.CanAccessChannel(ctx, ch.ID, userID) {
+			visible = append(visible, ch)
+		}
+	}
+	return visible, nil
+}
+
+func (s *Service) requireChannelGrant(ctx context.Context, communityID, pluginID uuid.UUID) error {
+	install, err := s.GetCommunityPlugin(ctx, communityID, pluginID)
+	if err != nil {
+		return err
+	}
+	if !install.Enabled {
+		return ErrNotInstalled
+	}
+	if !install.HasPermission(models.PluginPermManageChannels) {
+		return ErrChannelsNotAllowed
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+// Plugins that declare an endpoint in their manifest get the community events
+// listed in their hooks POSTed to it, signed the same way event hooks are with
+// a secret per installation. Plugins that ship a WASM module have the events
+// handed to it in the sandbox instead (see wasm.go). An installation only
+// receives the events its granted permissions cover, plus the runs of its
+// scheduled jobs (see jobs.go).
+
+const (
+	// A delivery is retried with exponential backoff, 30s up to an hour apart
+	maxDeliveryAttempts = 8
+	baseRetryDelay      = 30 * time.Second
+	maxRetryDelay       = time.Hour
+
+	// Deliveries to an installation stop after this many in a row ran out of
+	// retries, until the plugin is turned off and on again
+	pauseAfterFailures = 10
+
+	deliveryTimeout      = 10 * time.Second
+	deliveryLease        = time.Minute
+	deliveryPollInterval = 5 * time.Second
+	deliveryBatch        = 50
+	deliveryWorkers      = 8
+	deliveryRetention    = 7 * 24 * time.Hour
+)
+
+var ErrInsufficientPerms = errors.New("insufficient permissions")
+
+// eventPermissions is the permission an installation needs to receive each
+// event. Events not listed are never delivered to plugins.
+var eventPermissions = map[string]int64{
+	models.EventHookMessageCreate:  models.PluginPermReadMessages,
+	models.EventHookMessageUpdate:  models.PluginPermReadMessages,
+	models.EventHookMessageDelete:  models.PluginPermReadMessages,
+	models.EventHookReactionAdd:    models.PluginPermReadMessages,
+	models.EventHookReactionRemove: models.PluginPermReadMessages,
+	models.EventHookMemberJoin:     models.PluginPermReadMembers,
+	models.EventHookMemberLeave:    models.PluginPermReadMembers,
+	models.EventHookMemberKick:     models.PluginPermReadMembers,
+	models.EventHookMemberBan:      models.PluginPermReadMembers,
+}
+
+const deliveryColumns = `id, installation_id, event_type, payload, status, attempts, response_status, last_error,
+	next_attempt_at, created_at, completed_at`
+
+func scanDelivery(scanner interface{ Scan(dest ...any) error }) (*models.PluginDelivery, error) {
+	d := &models.PluginDelivery{}
+	err := scanner.Scan(
+		&d.ID, &d.InstallationID, &d.EventType, &d.Payload, &d.Status, &d.Attempts, &d.ResponseStatus, &d.LastError,
+		&d.NextAttemptAt, &d.CreatedAt, &d.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// EnqueueEvent queues an event for every enabled installation in the
+// community whose plugin subscribes to it and is allowed to see it. The event
+// hook service calls it for each event it dispatches.
+func (s *Service) EnqueueEvent(ctx context.Context, communityID uuid.UUID, eventType string, payload []byte) {
+	perm, ok := eventPermissions[eventType]
+	if !ok {
+		return
+	}
+
+	result, err := s.db.Exec(ctx,
+		`INSERT INTO plugin_deliveries (installation_id, event_type, payload)
+		 SELECT cp.id, $2, $3
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 WHERE cp.community_id = $1 AND cp.enabled AND NOT cp.deliveries_paused
+		   AND cp.granted_permissions & $4 <> 0
+		   AND (p.manifest->'wasm' IS NOT NULL
+		        OR (COALESCE(p.manifest->>'endpoint', '') <> '' AND cp.encrypted_secret IS NOT NULL))
+		   AND p.manifest->'hooks' ? $2::text`,
+		communityID, eventType, payload, perm,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("communityId", communityID.String()).Str("type", eventType).Msg("Failed to queue plugin deliveries")
+		return
+	}
+	if result.RowsAffected() > 0 {
+		s.wakeUp()
+	}
+}
+
+// RotateSigningSecret replaces an installation's signing secret and returns
+// it, the only time it is shown. Installations made before plugins received
+// events have no secret until one is created here. Deliveries still pending
+// are signed with the new one.
+func (s *Service) RotateSigningSecret(ctx context.Context, communityID, pluginID, userID uuid.UUID) (*models.CommunityPlugin, error) {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+	cp, err := s.GetCommunityPlugin(ctx, communityID, pluginID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, encryptedSecret, err := s.newSigningSecret(cp.ID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.db.Exec(ctx,
+		`UPDATE community_plugins SET encrypted_secret = $2, updated_at = NOW() WHERE id = $1`,
+		cp.ID, encryptedSecret,
+	); err != nil {
+		return nil, fmt.Errorf("rotate plugin signing secret: %w", err)
+	}
+
+	s.logAction(ctx, communityID, pluginID, userID, "secret_rotate", nil)
+	cp.SigningSecret = secret
+	return cp, nil
+}
+
+// ListDeliveries returns a page of an installation's delivery log, newest
+// first, and the cursor of the next page or "" on the last one
+func (s *Service) ListDeliveries(ctx context.Context, communityID, pluginID, userID uuid.UUID, cursor string, limit int) ([]*models.PluginDelivery, string, error) {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return nil, "", err
+	}
+	cp, err := s.GetCommunityPlugin(ctx, communityID, pluginID)
+	if err != nil {
+		return nil, "", err
+	}
+	before, err := utils.DecodeKeysetCursor(cursor, "")
+	if err != nil {
+		return nil, "", err
+	}
+
+	// One extra row tells whether there is a next page
+	query := `SELECT ` + deliveryColumns + ` FROM plugin_deliveries
+		 WHERE installation_id = $1`
+	args := []any{cp.ID, limit + 1}
+	if before != nil {
+		query += ` AND (created_at, id) < ($3, $4)`
+		args = append(args, before.Time, before.ID)
+	}
+	rows, err := s.db.Query(ctx, query+`
+		 ORDER BY created_at DESC, id DESC
+		 LIMIT $2`,
+		args...,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("list plugin deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]*models.PluginDelivery, 0)
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, "", fmt.Errorf("scan plugin delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		last := deliveries[limit-1]
+		next = utils.EncodeCursor(utils.KeysetCursor{Time: last.CreatedAt, ID: last.ID})
+	}
+	return deliveries, next, nil
+}
+
+func (s *Service) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run delivers due plugin events, and queues the runs of scheduled jobs,
+// until ctx is cancelled. Every instance can run it; deliveries are claimed
+// with SKIP LOCKED so each is sent by one instance.
+func (s *Service) Run(ctx context.Context) {
+	go s.runScheduler(ctx)
+
+	ticker := time.NewTicker(deliveryPollInterval)
+	defer ticker.Stop()
+
+	for {
+		s.deliverDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Service) deliverDue(ctx context.Context) {
+	for {
+		batch, err := s.claimDue(ctx)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to claim plugin deliveries")
+			return
+		}
+
+		sem := make(chan struct{}, deliveryWorkers)
+		var wg sync.WaitGroup
+		for _, d := range batch {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(d *models.PluginDelivery) {
+				defer func() { <-sem; wg.Done() }()
+				s.deliver(ctx, d)
+			}(d)
+		}
+		wg.Wait()
+
+		if len(batch) < deliveryBatch {
+			return
+		}
+	}
+}
+
+// claimDue pushes the next attempt of due deliveries past the lease so other
+// instances leave them alone while this one sends them.
+func (s *Service) claimDue(ctx context.Context) ([]*models.PluginDelivery, error) {
+	rows, err := s.db.Query(ctx,
+		`UPDATE plugin_deliveries SET next_attempt_at = $1
+		 WHERE id IN (
+			SELECT id FROM plugin_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED)
+		 RETURNING `+deliveryColumns,
+		time.Now().Add(deliveryLease), deliveryBatch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []*models.PluginDelivery
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		batch = append(batch, d)
+	}
+	return batch, rows.Err()
+}
+
+// deliveryTarget is the installation a delivery is for, as it is now
+type deliveryTarget struct {
+	InstallationID  uuid.UUID
+	CommunityID     uuid.UUID
+	PluginID        uuid.UUID
+	Manifest        *models.PluginManifest
+	Config          json.RawMessage
+	EncryptedSecret []byte
+	Active          bool
+	Granted         int64
+}
+
+func (s *Service) getDeliveryTarget(ctx context.Context, installationID uuid.UUID) (*deliveryTarget, error) {
+	t := &deliveryTarget{InstallationID: installationID}
+	var manifest json.RawMessage
+	err := s.db.QueryRow(ctx,
+		`SELECT cp.community_id, cp.plugin_id, p.manifest, cp.config, cp.encrypted_secret,
+		        cp.enabled AND NOT cp.deliveries_paused, cp.granted_permissions
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 WHERE cp.id = $1`,
+		installationID,
+	).Scan(&t.CommunityID, &t.PluginID, &manifest, &t.Config, &t.EncryptedSecret, &t.Active, &t.Granted)
+	if err != nil {
+		return nil, err
+	}
+	t.Manifest = &models.PluginManifest{}
+	if err := json.Unmarshal(manifest, t.Manifest); err != nil {
+		return nil, fmt.Errorf("parse plugin manifest: %w", err)
+	}
+	return t, nil
+}
+
+func (s *Service) deliver(ctx context.Context, d *models.PluginDelivery) {
+	t, err := s.getDeliveryTarget(ctx, d.InstallationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Uninstalling takes the deliveries with it
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("installationId", d.InstallationID.String()).Msg("Failed to load plugin installation")
+		return
+	}
+
+	// The installation may have changed since the event was queued
+	if !t.Active {
+		s.finish(ctx, d, nil, "plugin is disabled", false)
+		return
+	}
+	if d.EventType != JobEventType && t.Granted&eventPermissions[d.EventType] == 0 {
+		s.finish(ctx, d, nil, "plugin is no longer allowed to receive this event", false)
+		return
+	}
+
+	var status *int
+	if t.Manifest.Wasm != nil {
+		if s.sandbox == nil {
+			s.finish(ctx, d, nil, "WASM plugins are disabled on this instance", false)
+			return
+		}
+		err = s.runWasm(ctx, t, d)
+	} else {
+		if !validEndpoint(t.Manifest.Endpoint) {
+			s.finish(ctx, d, nil, "plugin endpoint must be a public https URL", true)
+			return
+		}
+
+		secret, decryptErr := s.keys.Open(t.EncryptedSecret, secretRow(t.InstallationID))
+		if decryptErr != nil {
+			log.Error().Err(decryptErr).Str("installationId", d.InstallationID.String()).Msg("Failed to decrypt plugin signing secret")
+			s.finish(ctx, d, nil, "signing secret unavailable", true)
+			return
+		}
+		status, err = s.sender.Send(ctx, t.Manifest.Endpoint, secret, d.EventType, d.ID, d.Payload)
+	}
+	if err == nil {
+		s.succeed(ctx, d, status)
+		return
+	}
+
+	// Job runs aren't retried; a failed run backs the job off instead
+	attempts := d.Attempts + 1
+	if attempts >= maxDeliveryAttempts || d.EventType == JobEventType {
+		s.finish(ctx, d, status, err.Error(), true)
+		return
+	}
+
+	_, dbErr := s.db.Exec(ctx,
+		`UPDATE plugin_deliveries SET attempts = $2, response_status = $3, last_error = $4, next_attempt_at = $5
+		 WHERE id = $1`,
+		d.ID, attempts, status, truncateError(err.Error()), time.Now().Add(retryDelay(attempts)),
+	)
+	if dbErr != nil {
+		log.Error().Err(dbErr).Str("deliveryId", d.ID.String()).Msg("Failed to schedule plugin delivery retry")
+	}
+}
+
+func (s *Service) succeed(ctx context.Context, d *models.PluginDelivery, status *int) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE plugin_deliveries SET status = 'succeeded', attempts = attempts + 1, response_status = $2,
+		        last_error = NULL, completed_at = NOW()
+		 WHERE id = $1`,
+		d.ID, status,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("deliveryId", d.ID.String()).Msg("Failed to record plugin delivery")
+	}
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE community_plugins SET delivery_failures = 0 WHERE id = $1 AND delivery_failures > 0`,
+		d.InstallationID,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("installationId", d.InstallationID.String()).Msg("Failed to reset plugin delivery failures")
+	}
+
+	if d.EventType == JobEventType {
+		s.jobSucceeded(ctx, d)
+	}
+}
+
+// finish gives up on a delivery. countFailure adds it to the installation's
+// failure streak, pausing deliveries once the streak is long enough.
+func (s *Service) finish(ctx context.Context, d *models.PluginDelivery, status *int, reason string, countFailure bool) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE plugin_deliveries SET status = 'failed', attempts = attempts + 1, response_status = $2,
+		        last_error = $3, completed_at = NOW()
+		 WHERE id = $1`,
+		d.ID, status, truncateError(reason),
+	)
+	if err != nil {
+		log.Error().Err(err).Str("deliveryId", d.ID.String()).Msg("Failed to record plugin delivery")
+	}
+	if !countFailure {
+		return
+	}
+	if d.EventType == JobEventType {
+		s.jobFailed(ctx, d, reason)
+	}
+
+	var paused bool
+	err = s.db.QueryRow(ctx,
+		`UPDATE community_plugins SET
+			delivery_failures = delivery_failures + 1,
+			deliveries_paused = deliveries_paused OR delivery_failures + 1 >= $2
+		 WHERE id = $1
+		 RETURNING deliveries_paused AND delivery_failures = $2`,
+		d.InstallationID, pauseAfterFailures,
+	).Scan(&paused)
+	if err != nil {
+		log.Error().Err(err).Str("installationId", d.InstallationID.String()).Msg("Failed to record plugin delivery failure")
+		return
+	}
+	if paused {
+		log.Warn().Str("installationId", d.InstallationID.String()).Msg("Paused plugin deliveries after repeated failures")
+	}
+}
+
+// PruneDeliveries deletes finished plugin deliveries past the retention
+// period. It is registered as a maintenance task.
+func (s *Service) PruneDeliveries(ctx context.Context) (int64, error) {
+	result, err := s.db.Exec(ctx,
+		`DELETE FROM plugin_deliveries WHERE status <> 'pending' AND completed_at < $1`,
+		time.Now().Add(-deliveryRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+// secretRow is the row an installation's signing secret is sealed for
+func secretRow(installationID uuid.UUID) encryption.Row {
+	return encryption.Row{Table: "community_plugins", ID: installationID}
+}
+
+func (s *Service) newSigningSecret(installationID uuid.UUID) (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, fmt.Errorf("generate plugin signing secret: %w", err)
+	}
+	secret := "zps_" + hex.EncodeToString(raw)
+
+	encrypted, err := s.keys.Seal([]byte(secret), secretRow(installationID))
+	if err != nil {
+		return "", nil, fmt.Errorf("encrypt plugin signing secret: %w", err)
+	}
+	return secret, encrypted, nil
+}
+
+func (s *Service) requireManager(ctx context.Context, communityID, userID uuid.UUID) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil || !models.HasPermission(perms, models.PermissionManageCommunity) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+// validEndpoint checks the manifest endpoint on every attempt; the sender
+// checks its host resolves to a public address
+func validEndpoint(raw string) bool {
+	parsed, err := url.Parse(raw)
+	return err == nil && parsed.Scheme == "https" && parsed.Host != "" && parsed.User == nil
+}
+
+func retryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay << (attempts - 1)
+	if delay > maxRetryDelay || delay <= 0 {
+		return maxRetryDelay
+	}
+	return delay
+}
+
+func truncateError(s string) string {
+	if len(s) <= 512 {
+		return s
+	}
+	return s[:512]
+}
@@ -0,0 +1,164 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+const (
+	// MaxEventPayloadBytes caps the JSON payload of a single plugin event.
+	MaxEventPayloadBytes = 8 * 1024
+
+	// Each plugin gets this many events per window on each community.
+	eventRateLimit  = 60
+	eventRateWindow = 10 * time.Second
+)
+
+var (
+	ErrEventsNotAllowed  = errors.New("plugin is not allowed to emit events")
+	ErrInvalidEventName  = errors.New("invalid event name")
+	ErrEventTooLarge     = errors.New("event payload is too large")
+	ErrInvalidEventData  = errors.New("event payload must be valid JSON")
+	ErrInvalidEventScope = errors.New("event must target exactly one channel or user in the community")
+	ErrEventRateLimited  = errors.New("plugin is emitting events too quickly")
+)
+
+// Event names are the last segment of PLUGIN:{slug}:{NAME}
+var eventNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,63}$`)
+
+// EventTarget picks who receives a plugin event: everyone subscribed to a
+// channel, or every connection of a single user. Exactly one must be set.
+type EventTarget struct {
+	ChannelID *uuid.UUID `json:"channelId,omitempty"`
+	UserID    *uuid.UUID `json:"userId,omitempty"`
+}
+
+// PluginEvent is the data of a PLUGIN:{slug}:{NAME} gateway event
+type PluginEvent struct {
+	PluginID    uuid.UUID       `json:"pluginId"`
+	CommunityID uuid.UUID       `json:"communityId"`
+	ChannelID   *uuid.UUID      `json:"channelId,omitempty"`
+	SenderID    *uuid.UUID      `json:"senderId,omitempty"`
+	Payload     json.RawMessage `json:"payload"`
+}
+
+// EventType returns the gateway event type for a plugin's custom event
+func EventType(slug, name string) string {
+	return "PLUGIN:" + slug + ":" + name
+}
+
+// EmitEvent pushes a custom event from a plugin running inside the server to
+// a channel or user of a community it is installed on.
+func (s *Service) EmitEvent(ctx context.Context, communityID, pluginID uuid.UUID, target EventTarget, name string, payload json.RawMessage) error {
+	return s.emitEvent(ctx, communityID, pluginID, nil, target, name, payload)
+}
+
+// EmitEventAsUser pushes a custom event from a plugin's UI on behalf of the
+// signed-in user. The user has to be able to see the target channel, or share
+// the community with the target user.
+func (s *Service) EmitEventAsUser(ctx context.Context, communityID, pluginID, userID uuid.UUID, target EventTarget, name string, payload json.RawMessage) error {
+	if !s.isCommunityMember(ctx, communityID, userID) {
+		return ErrEventsNotAllowed
+	}
+	if target.ChannelID != nil && !s.channelAccess.CanAccessChannel(ctx, *target.ChannelID, userID) {
+		return ErrInvalidEventScope
+	}
+	return s.emitEvent(ctx, communityID, pluginID, &userID, target, name, payload)
+}
+
+func (s *Service) emitEvent(ctx context.Context, communityID, pluginID uuid.UUID, senderID *uuid.UUID, target EventTarget, name string, payload json.RawMessage) error {
+	if !eventNamePattern.MatchString(name) {
+		return ErrInvalidEventName
+	}
+	if len(payload) > MaxEventPayloadBytes {
+		return ErrEventTooLarge
+	}
+	if len(payload) == 0 {
+		payload = json.RawMessage("null")
+	} else if !json.Valid(payload) {
+		return ErrInvalidEventData
+	}
+
+	install, err := s.GetCommunityPlugin(ctx, communityID, pluginID)
+	if err != nil {
+		return err
+	}
+	if !install.Enabled {
+		return ErrNotInstalled
+	}
+	if !install.HasPermission(models.PluginPermEmitEvents) {
+		return ErrEventsNotAllowed
+	}
+
+	var stream string
+	switch {
+	case target.ChannelID != nil && target.UserID == nil:
+		if !s.channelInCommunity(ctx, communityID, *target.ChannelID) {
+			return ErrInvalidEventScope
+		}
+		stream = target.ChannelID.String()
+	case target.UserID != nil && target.ChannelID == nil:
+		if !s.isCommunityMember(ctx, communityID, *target.UserID) {
+			return ErrInvalidEventScope
+		}
+		stream = database.UserStream(target.UserID.String())
+	default:
+		return ErrInvalidEventScope
+	}
+
+	count, err := database.IncrementRateLimit(ctx, fmt.Sprintf("plugin_events:%s:%s", pluginID, communityID), eventRateWindow)
+	if err != nil {
+		log.Warn().Err(err).Str("plugin", install.Plugin.Slug).Msg("Plugin event rate limit check failed")
+	} else if count > eventRateLimit {
+		return ErrEventRateLimited
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"channelId": stream,
+		"event": map[string]any{
+			"type": EventType(install.Plugin.Slug, name),
+			"data": PluginEvent{
+				PluginID:    pluginID,
+				CommunityID: communityID,
+				ChannelID:   target.ChannelID,
+				SenderID:    senderID,
+				Payload:     payload,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("marshal plugin event: %w", err)
+	}
+
+	if err := database.PublishBroadcast(ctx, data); err != nil {
+		return fmt.Errorf("publish plugin event: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) channelInCommunity(ctx context.Context, communityID, channelID uuid.UUID) bool {
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM channels WHERE id = $1 AND community_id = $2)`,
+		channelID, communityID,
+	).Scan(&exists)
+	return err == nil && exists
+}
+
+func (s *Service) isCommunityMember(ctx context.Context, communityID, userID uuid.UUID) bool {
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM community_members WHERE community_id = $1 AND user_id = $2)`,
+		communityID, userID,
+	).Scan(&exists)
+	return err == nil && exists
+}
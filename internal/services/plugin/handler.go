@@ -2,11 +2,16 @@ package plugin
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/message"
 	"github.com/zentra/server/internal/utils"
 )
 
@@ -35,6 +40,21 @@ func (h *Handler) Routes() chi.Router {
 		r.Patch("/{pluginId}/config", h.UpdateConfig)
 		r.Patch("/{pluginId}/permissions", h.UpdatePermissions)
 		r.Get("/{pluginId}", h.GetCommunityPlugin)
+		r.Post("/{pluginId}/events", h.EmitEvent)
+		r.Get("/{pluginId}/channels", h.GetManagedChannels)
+		r.Post("/{pluginId}/channels", h.CreateChannel)
+		r.Delete("/{pluginId}/channels/{channelId}", h.DeleteChannel)
+		r.Post("/{pluginId}/signing-secret", h.RotateSigningSecret)
+		r.Get("/{pluginId}/deliveries", h.ListDeliveries)
+		r.Get("/{pluginId}/api-token", h.GetAPIToken)
+		r.Post("/{pluginId}/api-token", h.IssueAPIToken)
+		r.Delete("/{pluginId}/api-token", h.RevokeAPIToken)
+		r.Get("/{pluginId}/jobs", h.ListJobs)
+		r.Post("/{pluginId}/jobs/{name}/run", h.RunJob)
+		r.Get("/{pluginId}/storage", h.ListStorage)
+		r.Get("/{pluginId}/storage/{key}", h.GetStorageValue)
+		r.Put("/{pluginId}/storage/{key}", h.PutStorageValue)
+		r.Delete("/{pluginId}/storage/{key}", h.DeleteStorageValue)
 		r.Get("/audit-log", h.GetAuditLog)
 
 		// Plugin sources
@@ -47,6 +67,26 @@ func (h *Handler) Routes() chi.Router {
 	return r
 }
 
+// APIRoutes are called by plugins with their installation's API token
+func (h *Handler) APIRoutes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/me", h.GetTokenInfo)
+	r.Get("/community", h.GetTokenCommunity)
+	r.Get("/channels", h.ListTokenChannels)
+	r.Get("/channels/{channelId}", h.GetTokenChannel)
+	r.With(middleware.Idempotent).Post("/channels/{channelId}/messages", h.SendTokenMessage)
+	r.Get("/members", h.ListTokenMembers)
+	r.Get("/members/{userId}", h.GetTokenMember)
+	r.Post("/events", h.EmitTokenEvent)
+	r.Get("/storage", h.ListTokenStorage)
+	r.Get("/storage/{key}", h.GetTokenStorageValue)
+	r.Put("/storage/{key}", h.PutTokenStorageValue)
+	r.Delete("/storage/{key}", h.DeleteTokenStorageValue)
+
+	return r
+}
+
 // ListPlugins returns all available plugins from the local catalog
 func (h *Handler) ListPlugins(w http.ResponseWriter, r *http.Request) {
 	_, err := middleware.RequireAuth(r.Context())
@@ -177,8 +217,8 @@ func (h *Handler) GetCommunityPlugin(w http.ResponseWriter, r *http.Request) {
 }
 
 type installRequest struct {
-	PluginID           string `json:"pluginId"`
-	GrantedPermissions int64  `json:"grantedPermissions"`
+	PluginID           string `json:"pluginId" validate:"required,uuid"`
+	GrantedPermissions int64  `json:"grantedPermissions" validate:"gte=0"`
 }
 
 // InstallPlugin installs a plugin on a community
@@ -196,8 +236,7 @@ func (h *Handler) InstallPlugin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req installRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -216,6 +255,10 @@ func (h *Handler) InstallPlugin(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusConflict, "Plugin already installed")
 		case ErrInvalidPermissions:
 			utils.RespondError(w, http.StatusBadRequest, "Granted permissions exceed what the plugin requests")
+		case ErrUnsignedPlugin:
+			utils.RespondErrorWithCode(w, http.StatusForbidden, "UNSIGNED_PLUGIN", "Plugin is not signed by its source")
+		case ErrUntrustedPlugin:
+			utils.RespondErrorWithCode(w, http.StatusForbidden, "UNTRUSTED_PLUGIN", "Plugin is not signed by a key this instance trusts")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to install plugin")
 		}
@@ -245,7 +288,18 @@ func (h *Handler) UninstallPlugin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.UninstallPlugin(r.Context(), communityID, pluginID, userID); err != nil {
+	// Managed channels are archived unless ?channels=delete
+	var deleteChannels bool
+	switch r.URL.Query().Get("channels") {
+	case "", "archive":
+	case "delete":
+		deleteChannels = true
+	default:
+		utils.RespondError(w, http.StatusBadRequest, "channels must be archive or delete")
+		return
+	}
+
+	if err := h.service.UninstallPlugin(r.Context(), communityID, pluginID, userID, deleteChannels); err != nil {
 		switch err {
 		case ErrPluginNotFound:
 			utils.RespondError(w, http.StatusNotFound, "Plugin not found")
@@ -253,6 +307,8 @@ func (h *Handler) UninstallPlugin(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
 		case ErrBuiltInPlugin:
 			utils.RespondError(w, http.StatusForbidden, "Cannot uninstall built-in plugins")
+		case ErrInsufficientPerms:
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to uninstall plugin")
 		}
@@ -263,7 +319,7 @@ func (h *Handler) UninstallPlugin(w http.ResponseWriter, r *http.Request) {
 }
 
 type toggleRequest struct {
-	Enabled bool `json:"enabled"`
+	Enabled *bool `json:"enabled" validate:"required"`
 }
 
 // TogglePlugin enables/disables a plugin
@@ -287,12 +343,11 @@ func (h *Handler) TogglePlugin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req toggleRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
-	if err := h.service.TogglePlugin(r.Context(), communityID, pluginID, userID, req.Enabled); err != nil {
+	if err := h.service.TogglePlugin(r.Context(), communityID, pluginID, userID, *req.Enabled); err != nil {
 		switch err {
 		case ErrBuiltInPlugin:
 			utils.RespondError(w, http.StatusForbidden, "Cannot toggle built-in plugins")
@@ -308,7 +363,7 @@ func (h *Handler) TogglePlugin(w http.ResponseWriter, r *http.Request) {
 }
 
 type configRequest struct {
-	Config json.RawMessage `json:"config"`
+	Config json.RawMessage `json:"config" validate:"required"`
 }
 
 // UpdateConfig updates plugin-specific settings for a community
@@ -332,16 +387,27 @@ func (h *Handler) UpdateConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req configRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
 	if err := h.service.UpdatePluginConfig(r.Context(), communityID, pluginID, userID, req.Config); err != nil {
-		if err == ErrNotInstalled {
+		if err == ErrNotInstalled || err == ErrPluginNotFound {
 			utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
 			return
 		}
+		if errors.Is(err, ErrInvalidConfig) {
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err == ErrInsufficientPerms {
+			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+			return
+		}
+		if err == ErrConfigChannelDenied {
+			utils.RespondError(w, http.StatusForbidden, "You can't send messages in a channel this config posts to")
+			return
+		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to update config")
 		return
 	}
@@ -350,7 +416,7 @@ func (h *Handler) UpdateConfig(w http.ResponseWriter, r *http.Request) {
 }
 
 type permissionsRequest struct {
-	GrantedPermissions int64 `json:"grantedPermissions"`
+	GrantedPermissions int64 `json:"grantedPermissions" validate:"gte=0"`
 }
 
 // UpdatePermissions changes what a plugin is allowed to do
@@ -374,8 +440,7 @@ func (h *Handler) UpdatePermissions(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req permissionsRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -394,6 +459,210 @@ func (h *Handler) UpdatePermissions(w http.ResponseWriter, r *http.Request) {
 	utils.RespondJSON(w, http.StatusNoContent, nil)
 }
 
+type emitEventRequest struct {
+	Name      string          `json:"name" validate:"required,max=64"`
+	ChannelID *uuid.UUID      `json:"channelId"`
+	UserID    *uuid.UUID      `json:"userId"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// EmitEvent lets a plugin's UI push a custom realtime event through the gateway
+func (h *Handler) EmitEvent(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	pluginID, err := uuid.Parse(chi.URLParam(r, "pluginId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid plugin ID")
+		return
+	}
+
+	var req emitEventRequest
+	r.Body = http.MaxBytesReader(w, r.Body, MaxEventPayloadBytes+1024)
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	target := EventTarget{ChannelID: req.ChannelID, UserID: req.UserID}
+	if err := h.service.EmitEventAsUser(r.Context(), communityID, pluginID, userID, target, req.Name, req.Payload); err != nil {
+		switch err {
+		case ErrNotInstalled:
+			utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
+		case ErrEventsNotAllowed:
+			utils.RespondError(w, http.StatusForbidden, "Plugin is not allowed to emit events")
+		case ErrInvalidEventName:
+			utils.RespondError(w, http.StatusBadRequest, "Event names must be upper-case letters, digits and underscores")
+		case ErrEventTooLarge:
+			utils.RespondError(w, http.StatusRequestEntityTooLarge, "Event payload is too large")
+		case ErrInvalidEventData:
+			utils.RespondError(w, http.StatusBadRequest, "Event payload must be valid JSON")
+		case ErrInvalidEventScope:
+			utils.RespondError(w, http.StatusBadRequest, "Event must target one channel or member of this community")
+		case ErrEventRateLimited:
+			utils.RespondErrorWithCode(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "Plugin is emitting events too quickly")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to emit event")
+		}
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusNoContent, nil)
+}
+
+// GetManagedChannels lists the channels a plugin manages on a community
+func (h *Handler) GetManagedChannels(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	channels, err := h.service.GetManagedChannels(r.Context(), communityID, pluginID, userID)
+	if err != nil {
+		h.respondChannelError(w, err, "Failed to get channels")
+		return
+	}
+
+	utils.RespondSuccess(w, channels)
+}
+
+// CreateChannel creates a channel managed by the plugin
+func (h *Handler) CreateChannel(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req channel.CreateChannelRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	ch, err := h.service.CreateChannelAsUser(r.Context(), communityID, pluginID, userID, &req)
+	if err != nil {
+		h.respondChannelError(w, err, "Failed to create channel")
+		return
+	}
+
+	utils.RespondCreated(w, ch)
+}
+
+// DeleteChannel deletes one of the plugin's channels
+func (h *Handler) DeleteChannel(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return
+	}
+
+	if err := h.service.DeleteChannelAsUser(r.Context(), communityID, pluginID, channelID, userID); err != nil {
+		h.respondChannelError(w, err, "Failed to delete channel")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// RotateSigningSecret replaces the secret events sent to the plugin are signed
+// with. The response holds the new secret, which is not shown again.
+func (h *Handler) RotateSigningSecret(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	cp, err := h.service.RotateSigningSecret(r.Context(), communityID, pluginID, userID)
+	if err != nil {
+		h.respondDeliveryError(w, err, "Failed to rotate signing secret")
+		return
+	}
+
+	utils.RespondSuccess(w, cp)
+}
+
+// ListDeliveries returns the log of events sent to the plugin's endpoint
+func (h *Handler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	page := utils.GetCursorParams(r, 50, 100)
+
+	deliveries, next, err := h.service.ListDeliveries(r.Context(), communityID, pluginID, userID, page.Cursor, page.Limit)
+	if err != nil {
+		h.respondDeliveryError(w, err, "Failed to get plugin deliveries")
+		return
+	}
+
+	utils.RespondCursorPage(w, deliveries, next)
+}
+
+func (h *Handler) respondDeliveryError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidCursor):
+		utils.RespondInvalidCursor(w)
+	case errors.Is(err, ErrNotInstalled):
+		utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+func (h *Handler) pluginParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	pluginID, err := uuid.Parse(chi.URLParam(r, "pluginId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid plugin ID")
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, communityID, pluginID, true
+}
+
+func (h *Handler) respondChannelError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrNotInstalled):
+		utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
+	case errors.Is(err, ErrChannelsNotAllowed):
+		utils.RespondError(w, http.StatusForbidden, "Plugin is not allowed to manage channels")
+	case errors.Is(err, channel.ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, channel.ErrChannelNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Channel not found")
+	case errors.Is(err, channel.ErrInvalidChannelType):
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel type")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
 // GetSources lists plugin sources for a community
 func (h *Handler) GetSources(w http.ResponseWriter, r *http.Request) {
 	_, err := middleware.RequireAuth(r.Context())
@@ -418,8 +687,8 @@ func (h *Handler) GetSources(w http.ResponseWriter, r *http.Request) {
 }
 
 type addSourceRequest struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	Name string `json:"name" validate:"required,max=100"`
+	URL  string `json:"url" validate:"required,url"`
 }
 
 // AddSource adds a plugin source repo
@@ -437,19 +706,22 @@ func (h *Handler) AddSource(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req addSourceRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if req.Name == "" || req.URL == "" {
-		utils.RespondError(w, http.StatusBadRequest, "Name and URL are required")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
 	src, err := h.service.AddSource(r.Context(), communityID, userID, req.Name, req.URL)
 	if err != nil {
-		utils.RespondError(w, http.StatusInternalServerError, "Failed to add source")
+		switch err {
+		case ErrInvalidSourceURL:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		case ErrFetchFailed:
+			utils.RespondError(w, http.StatusBadGateway, "Could not reach the source")
+		case ErrInvalidSourceKey:
+			utils.RespondError(w, http.StatusBadGateway, "Source published an invalid signing key")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to add source")
+		}
 		return
 	}
 
@@ -515,25 +787,34 @@ func (h *Handler) SyncSource(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var sourceURL string
+	var source *models.PluginSource
 	for _, src := range sources {
 		if src.ID == sourceID {
-			sourceURL = src.URL
+			source = src
 			break
 		}
 	}
-	if sourceURL == "" {
+	if source == nil {
 		utils.RespondError(w, http.StatusNotFound, "Source not found")
 		return
 	}
 
-	count, err := h.service.SyncFromSource(r.Context(), sourceURL)
+	result, err := h.service.SyncFromSource(r.Context(), source)
 	if err != nil {
-		utils.RespondError(w, http.StatusBadGateway, "Failed to sync from source")
+		switch err {
+		case ErrSourceKeyChanged:
+			utils.RespondErrorWithCode(w, http.StatusConflict, "SOURCE_KEY_CHANGED", "Source signing key changed; remove and re-add the source to trust the new key")
+		case ErrInvalidSourceURL:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		case ErrInvalidSourceKey:
+			utils.RespondError(w, http.StatusBadGateway, "Source published an invalid signing key")
+		default:
+			utils.RespondError(w, http.StatusBadGateway, "Failed to sync from source")
+		}
 		return
 	}
 
-	utils.RespondSuccess(w, map[string]int{"synced": count})
+	utils.RespondSuccess(w, result)
 }
 
 // GetAuditLog returns plugin activity for a community
@@ -558,3 +839,465 @@ func (h *Handler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
 
 	utils.RespondSuccess(w, entries)
 }
+
+// GetAPIToken returns the plugin's API token, without its secret
+func (h *Handler) GetAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.service.GetAPIToken(r.Context(), communityID, pluginID, userID)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to get plugin API token")
+		return
+	}
+
+	utils.RespondSuccess(w, token)
+}
+
+// IssueAPIToken creates or rotates the plugin's API token. The token is only
+// returned here.
+func (h *Handler) IssueAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	token, err := h.service.IssueAPIToken(r.Context(), communityID, pluginID, userID)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to issue plugin API token")
+		return
+	}
+
+	utils.RespondCreated(w, token)
+}
+
+// RevokeAPIToken deletes the plugin's API token
+func (h *Handler) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RevokeAPIToken(r.Context(), communityID, pluginID, userID); err != nil {
+		h.respondAPITokenError(w, err, "Failed to revoke plugin API token")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// GetTokenInfo returns the installation the token belongs to
+func (h *Handler) GetTokenInfo(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	utils.RespondSuccess(w, token)
+}
+
+// GetTokenCommunity returns the token's community
+func (h *Handler) GetTokenCommunity(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	community, err := h.service.CommunityInfo(r.Context(), token)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to get community")
+		return
+	}
+
+	utils.RespondSuccess(w, community)
+}
+
+// ListTokenChannels returns the channels of the token's community
+func (h *Handler) ListTokenChannels(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	channels, err := h.service.ListChannels(r.Context(), token)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to list channels")
+		return
+	}
+
+	utils.RespondSuccess(w, channels)
+}
+
+// GetTokenChannel returns one channel of the token's community
+func (h *Handler) GetTokenChannel(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return
+	}
+
+	ch, err := h.service.GetChannel(r.Context(), token, channelID)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to get channel")
+		return
+	}
+
+	utils.RespondSuccess(w, ch)
+}
+
+type sendMessageRequest struct {
+	Content string `json:"content" validate:"required,max=4000"`
+}
+
+// SendTokenMessage posts a message as the plugin
+func (h *Handler) SendTokenMessage(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return
+	}
+
+	var req sendMessageRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	msg, err := h.service.SendMessage(r.Context(), token, channelID, req.Content)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to send message")
+		return
+	}
+
+	utils.RespondCreated(w, msg)
+}
+
+// ListTokenMembers returns a page of the token's community's members
+func (h *Handler) ListTokenMembers(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	page := utils.GetCursorParams(r, 50, 100)
+
+	members, next, err := h.service.ListMembers(r.Context(), token, page.Cursor, page.Limit)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to list members")
+		return
+	}
+
+	utils.RespondCursorPage(w, members, next)
+}
+
+// GetTokenMember returns one member of the token's community
+func (h *Handler) GetTokenMember(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	userID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	member, err := h.service.GetMember(r.Context(), token, userID)
+	if err != nil {
+		h.respondAPITokenError(w, err, "Failed to get member")
+		return
+	}
+
+	utils.RespondSuccess(w, member)
+}
+
+// EmitTokenEvent pushes a custom realtime event from the plugin
+func (h *Handler) EmitTokenEvent(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	var req emitEventRequest
+	r.Body = http.MaxBytesReader(w, r.Body, MaxEventPayloadBytes+1024)
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	target := EventTarget{ChannelID: req.ChannelID, UserID: req.UserID}
+	if err := h.service.EmitEvent(r.Context(), token.CommunityID, token.PluginID, target, req.Name, req.Payload); err != nil {
+		h.respondAPITokenError(w, err, "Failed to emit event")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func requirePluginToken(w http.ResponseWriter, r *http.Request) (*models.PluginAPIToken, bool) {
+	token, ok := middleware.GetPluginToken(r.Context())
+	if !ok {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return nil, false
+	}
+	return token, true
+}
+
+func (h *Handler) respondAPITokenError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, utils.ErrInvalidCursor):
+		utils.RespondInvalidCursor(w)
+	case errors.Is(err, ErrNotInstalled):
+		utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
+	case errors.Is(err, ErrAPITokenNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Plugin has no API token")
+	case errors.Is(err, ErrNotInCommunity):
+		utils.RespondError(w, http.StatusNotFound, "Not found in this community")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrPermissionDenied), errors.Is(err, ErrEventsNotAllowed):
+		utils.RespondErrorWithCode(w, http.StatusForbidden, "MISSING_PERMISSION", "Plugin is not allowed to do this")
+	case errors.Is(err, ErrChannelNotPostable):
+		utils.RespondErrorWithCode(w, http.StatusForbidden, "MISSING_PERMISSION", "Plugin may only post where everyone can, or in its own channels")
+	case errors.Is(err, message.ErrBlockedByAutoMod):
+		utils.RespondError(w, http.StatusForbidden, "Message blocked by AutoMod")
+	case errors.Is(err, message.ErrRemovedByAutoMod):
+		utils.RespondError(w, http.StatusForbidden, "Message removed by AutoMod")
+	case errors.Is(err, ErrInvalidEventScope):
+		utils.RespondError(w, http.StatusBadRequest, "Event must target one channel or member of this community")
+	case errors.Is(err, ErrInvalidEventName):
+		utils.RespondError(w, http.StatusBadRequest, "Event names must be upper-case letters, digits and underscores")
+	case errors.Is(err, ErrInvalidEventData):
+		utils.RespondError(w, http.StatusBadRequest, "Event payload must be valid JSON")
+	case errors.Is(err, ErrEventTooLarge):
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, "Event payload is too large")
+	case errors.Is(err, ErrEventRateLimited):
+		utils.RespondErrorWithCode(w, http.StatusTooManyRequests, "RATE_LIMIT_EXCEEDED", "Plugin is emitting events too quickly")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+// ListStorage lists the keys a plugin stored on the community
+func (h *Handler) ListStorage(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	page, err := h.service.ListStorageAsUser(r.Context(), communityID, pluginID, userID,
+		q.Get("prefix"), q.Get("after"), utils.GetQueryInt(r, "limit", defaultStorageListLimit))
+	if err != nil {
+		h.respondStorageError(w, err, "Failed to list plugin storage")
+		return
+	}
+
+	utils.RespondSuccess(w, page)
+}
+
+// GetStorageValue reads one key a plugin stored on the community
+func (h *Handler) GetStorageValue(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.service.GetValueAsUser(r.Context(), communityID, pluginID, userID, chi.URLParam(r, "key"))
+	if err != nil {
+		h.respondStorageError(w, err, "Failed to get plugin storage")
+		return
+	}
+
+	utils.RespondSuccess(w, entry)
+}
+
+// PutStorageValue overwrites one key a plugin stored on the community. The
+// request body is the value.
+func (h *Handler) PutStorageValue(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	value, ok := readStorageValue(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.service.PutValueAsUser(r.Context(), communityID, pluginID, userID, chi.URLParam(r, "key"), value)
+	if err != nil {
+		h.respondStorageError(w, err, "Failed to update plugin storage")
+		return
+	}
+
+	utils.RespondSuccess(w, entry)
+}
+
+// DeleteStorageValue removes one key a plugin stored on the community
+func (h *Handler) DeleteStorageValue(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteValueAsUser(r.Context(), communityID, pluginID, userID, chi.URLParam(r, "key")); err != nil {
+		h.respondStorageError(w, err, "Failed to delete plugin storage")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// ListTokenStorage lists the keys the plugin stored on the token's community
+func (h *Handler) ListTokenStorage(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	page, err := h.service.ListStorage(r.Context(), token.InstallationID,
+		q.Get("prefix"), q.Get("after"), utils.GetQueryInt(r, "limit", defaultStorageListLimit))
+	if err != nil {
+		h.respondStorageError(w, err, "Failed to list storage")
+		return
+	}
+
+	utils.RespondSuccess(w, page)
+}
+
+// GetTokenStorageValue reads one key the plugin stored
+func (h *Handler) GetTokenStorageValue(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.service.GetValue(r.Context(), token.InstallationID, chi.URLParam(r, "key"))
+	if err != nil {
+		h.respondStorageError(w, err, "Failed to get storage")
+		return
+	}
+
+	utils.RespondSuccess(w, entry)
+}
+
+// PutTokenStorageValue stores one key for the plugin. The request body is the value.
+func (h *Handler) PutTokenStorageValue(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	value, ok := readStorageValue(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := h.service.PutValue(r.Context(), token.InstallationID, chi.URLParam(r, "key"), value)
+	if err != nil {
+		h.respondStorageError(w, err, "Failed to update storage")
+		return
+	}
+
+	utils.RespondSuccess(w, entry)
+}
+
+// DeleteTokenStorageValue removes one key the plugin stored
+func (h *Handler) DeleteTokenStorageValue(w http.ResponseWriter, r *http.Request) {
+	token, ok := requirePluginToken(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteValue(r.Context(), token.InstallationID, chi.URLParam(r, "key")); err != nil {
+		h.respondStorageError(w, err, "Failed to delete storage")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func readStorageValue(w http.ResponseWriter, r *http.Request) (json.RawMessage, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxStorageValueBytes+1))
+	if err != nil || len(body) > MaxStorageValueBytes {
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, "Storage value is too large")
+		return nil, false
+	}
+	return body, true
+}
+
+func (h *Handler) respondStorageError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrNotInstalled):
+		utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
+	case errors.Is(err, ErrStorageKeyNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Storage key not found")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrInvalidStorageKey), errors.Is(err, ErrInvalidStorageData):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrStorageValueSize):
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, "Storage value is too large")
+	case errors.Is(err, ErrStorageQuota):
+		utils.RespondErrorWithCode(w, http.StatusInsufficientStorage, "STORAGE_QUOTA_EXCEEDED", "Plugin storage quota exceeded")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+// ListJobs returns the plugin's scheduled jobs on the community
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	jobs, err := h.service.ListJobs(r.Context(), communityID, pluginID, userID)
+	if err != nil {
+		h.respondJobError(w, err, "Failed to get plugin jobs")
+		return
+	}
+
+	utils.RespondSuccess(w, jobs)
+}
+
+// RunJob runs one of the plugin's scheduled jobs now
+func (h *Handler) RunJob(w http.ResponseWriter, r *http.Request) {
+	userID, communityID, pluginID, ok := h.pluginParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.RunJobNow(r.Context(), communityID, pluginID, userID, chi.URLParam(r, "name")); err != nil {
+		h.respondJobError(w, err, "Failed to run plugin job")
+		return
+	}
+
+	utils.RespondJSON(w, http.StatusAccepted, utils.SuccessResponse{Data: map[string]bool{"queued": true}})
+}
+
+func (h *Handler) respondJobError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrNotInstalled):
+		utils.RespondError(w, http.StatusNotFound, "Plugin not installed")
+	case errors.Is(err, ErrJobNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Job not found")
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,386 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+)
+
+// Plugins list schedules in their manifest and every installation gets a job
+// for each. When a job is due the scheduler queues a plugin.job event for the
+// installation, delivered like the hooks it subscribes to (endpoint or WASM)
+// but tried only once. A job whose last run is still pending is skipped
+// rather than run twice, and every failed run in a row doubles the wait before
+// the next one, up to a day. Each instance runs the scheduler; due jobs are
+// claimed with SKIP LOCKED.
+
+const (
+	// Event type of a job run; it needs no granted permission
+	JobEventType = "plugin.job"
+
+	// Schedules past this many in a manifest are ignored
+	MaxPluginSchedules = 10
+
+	schedulerInterval = 30 * time.Second
+	jobBatch          = 100
+	baseJobBackoff    = time.Minute
+	maxJobBackoff     = 24 * time.Hour
+
+	// Each tick only syncs installations changed since the last one, reaching
+	// back a little for changes committed late. Every installation is synced
+	// again now and then in case something was still missed.
+	jobSyncOverlap      = time.Minute
+	jobFullSyncInterval = 15 * time.Minute
+)
+
+var ErrJobNotFound = errors.New("plugin job not found")
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// jobRun is the data of a plugin.job event
+type jobRun struct {
+	Job         string    `json:"job"`
+	ScheduledAt time.Time `json:"scheduledAt"`
+}
+
+// ListJobs returns a plugin's scheduled jobs on a community
+func (s *Service) ListJobs(ctx context.Context, communityID, pluginID, userID uuid.UUID) ([]*models.PluginJob, error) {
+	installationID, err := s.managedInstallation(ctx, communityID, pluginID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT j.name, j.cron, j.next_run_at, j.invalid, COALESCE(d.status = 'pending', FALSE),
+		        j.last_run_at, j.failures, j.skipped_runs, j.last_error
+		 FROM plugin_jobs j
+		 LEFT JOIN plugin_deliveries d ON d.id = j.delivery_id
+		 WHERE j.installation_id = $1
+		 ORDER BY j.name`,
+		installationID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list plugin jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]*models.PluginJob, 0)
+	for rows.Next() {
+		j := &models.PluginJob{}
+		if err := rows.Scan(&j.Name, &j.Cron, &j.NextRunAt, &j.Invalid, &j.Running,
+			&j.LastRunAt, &j.Failures, &j.SkippedRuns, &j.LastError); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// RunJobNow makes a job due, so the scheduler runs it within a tick unless
+// its last run is still pending
+func (s *Service) RunJobNow(ctx context.Context, communityID, pluginID, userID uuid.UUID, name string) error {
+	installationID, err := s.managedInstallation(ctx, communityID, pluginID, userID)
+	if err != nil {
+		return err
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`UPDATE plugin_jobs SET next_run_at = NOW() WHERE installation_id = $1 AND name = $2 AND NOT invalid`,
+		installationID, name,
+	)
+	if err != nil {
+		return fmt.Errorf("run plugin job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrJobNotFound
+	}
+
+	s.logAction(ctx, communityID, pluginID, userID, "job_run", map[string]any{"job": name})
+	return nil
+}
+
+func (s *Service) runScheduler(ctx context.Context) {
+	ticker := time.NewTicker(schedulerInterval)
+	defer ticker.Stop()
+
+	var since, fullSyncAt time.Time
+	for {
+		if time.Since(fullSyncAt) >= jobFullSyncInterval {
+			since, fullSyncAt = time.Time{}, time.Now()
+		}
+		since = s.scheduleJobs(ctx, since)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scheduleJobs runs one tick of the scheduler. It syncs the jobs of
+// installations changed since the given time, or all of them for the zero
+// time, and returns where the next tick's sync starts.
+func (s *Service) scheduleJobs(ctx context.Context, since time.Time) time.Time {
+	next, err := s.syncJobs(ctx, since)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to sync plugin jobs")
+		next = since
+	}
+	if err := s.planJobs(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to plan plugin jobs")
+	}
+
+	fired, err := s.fireDueJobs(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to run plugin jobs")
+	}
+	if fired > 0 {
+		s.wakeUp()
+	}
+	return next
+}
+
+// syncJobs matches the job rows to the schedules in the manifests of enabled
+// installations. A changed cron expression is planned again from scratch;
+// disabling a plugin drops its jobs. Only installations whose row or plugin
+// was updated since the given time are looked at, unless it's zero. It
+// returns the time the next sync can start from.
+func (s *Service) syncJobs(ctx context.Context, since time.Time) (time.Time, error) {
+	var now time.Time
+	if err := s.db.QueryRow(ctx, `SELECT NOW()`).Scan(&now); err != nil {
+		return since, err
+	}
+	var changedSince *time.Time
+	if !since.IsZero() {
+		changedSince = &since
+	}
+
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO plugin_jobs (installation_id, name, cron)
+		 SELECT DISTINCT ON (cp.id, x.sched->>'name') cp.id, x.sched->>'name', x.sched->>'cron'
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 CROSS JOIN LATERAL jsonb_array_elements(
+		     CASE jsonb_typeof(p.manifest->'schedules') WHEN 'array' THEN p.manifest->'schedules' ELSE '[]'::jsonb END
+		 ) WITH ORDINALITY AS x(sched, n)
+		 WHERE cp.enabled AND p.manifest ? 'schedules' AND x.n <= $1
+		   AND ($2::timestamptz IS NULL OR cp.updated_at >= $2 OR p.updated_at >= $2)
+		   AND COALESCE(x.sched->>'name', '') ~ '^[A-Za-z0-9_\-]{1,64}$'
+		   AND length(COALESCE(x.sched->>'cron', '')) BETWEEN 1 AND 128
+		 ORDER BY cp.id, x.sched->>'name', x.n
+		 ON CONFLICT (installation_id, name) DO UPDATE
+		 SET cron = EXCLUDED.cron, next_run_at = NULL, invalid = FALSE, last_error = NULL
+		 WHERE plugin_jobs.cron <> EXCLUDED.cron`,
+		MaxPluginSchedules, changedSince,
+	)
+	if err != nil {
+		return since, err
+	}
+
+	_, err = s.db.Exec(ctx,
+		`DELETE FROM plugin_jobs j
+		 USING community_plugins cp, plugins p
+		 WHERE j.installation_id = cp.id AND p.id = cp.plugin_id
+		   AND ($1::timestamptz IS NULL OR cp.updated_at >= $1 OR p.updated_at >= $1)
+		   AND (NOT cp.enabled
+		        OR jsonb_typeof(p.manifest->'schedules') IS DISTINCT FROM 'array'
+		        OR NOT p.manifest->'schedules' @> jsonb_build_array(jsonb_build_object('name', j.name)))`,
+		changedSince,
+	)
+	if err != nil {
+		return since, err
+	}
+	return now.Add(-jobSyncOverlap), nil
+}
+
+// planJobs works out the first run of new and changed jobs
+func (s *Service) planJobs(ctx context.Context) error {
+	type unplanned struct {
+		installationID uuid.UUID
+		name, cron     string
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT installation_id, name, cron FROM plugin_jobs WHERE next_run_at IS NULL AND NOT invalid LIMIT $1`,
+		jobBatch,
+	)
+	if err != nil {
+		return err
+	}
+	var jobs []unplanned
+	for rows.Next() {
+		var j unplanned
+		if err := rows.Scan(&j.installationID, &j.name, &j.cron); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// Schedules are in UTC unless they start with CRON_TZ=
+	now := time.Now().UTC()
+	for _, j := range jobs {
+		schedule, parseErr := cronParser.Parse(j.cron)
+		if parseErr != nil {
+			_, err = s.db.Exec(ctx,
+				`UPDATE plugin_jobs SET invalid = TRUE, last_error = $4
+				 WHERE installation_id = $1 AND name = $2 AND cron = $3`,
+				j.installationID, j.name, j.cron, truncateError("invalid schedule: "+parseErr.Error()),
+			)
+		} else {
+			_, err = s.db.Exec(ctx,
+				`UPDATE plugin_jobs SET next_run_at = $4
+				 WHERE installation_id = $1 AND name = $2 AND cron = $3 AND next_run_at IS NULL`,
+				j.installationID, j.name, j.cron, schedule.Next(now),
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fireDueJobs queues a run of every due job, or skips it while the previous
+// run is still pending, and moves the job on to its next time
+func (s *Service) fireDueJobs(ctx context.Context) (int, error) {
+	type dueJob struct {
+		installationID uuid.UUID
+		communityID    uuid.UUID
+		name, cron     string
+		scheduledAt    time.Time
+		running        bool
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		`SELECT j.installation_id, cp.community_id, j.name, j.cron, j.next_run_at, COALESCE(d.status = 'pending', FALSE)
+		 FROM plugin_jobs j
+		 JOIN community_plugins cp ON cp.id = j.installation_id
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 LEFT JOIN plugin_deliveries d ON d.id = j.delivery_id
+		 WHERE j.next_run_at <= NOW() AND NOT j.invalid
+		   AND cp.enabled AND NOT cp.deliveries_paused
+		   AND (p.manifest->'wasm' IS NOT NULL
+		        OR (COALESCE(p.manifest->>'endpoint', '') <> '' AND cp.encrypted_secret IS NOT NULL))
+		 ORDER BY j.next_run_at
+		 LIMIT $1
+		 FOR UPDATE OF j SKIP LOCKED`,
+		jobBatch,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var due []dueJob
+	for rows.Next() {
+		var j dueJob
+		if err := rows.Scan(&j.installationID, &j.communityID, &j.name, &j.cron, &j.scheduledAt, &j.running); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Schedules are in UTC unless they start with CRON_TZ=
+	now := time.Now().UTC()
+	fired := 0
+	for _, j := range due {
+		schedule, err := cronParser.Parse(j.cron)
+		if err != nil {
+			// planJobs parsed it already; the row changed under us
+			continue
+		}
+		next := schedule.Next(now)
+
+		if j.running {
+			_, err = tx.Exec(ctx,
+				`UPDATE plugin_jobs SET next_run_at = $3, skipped_runs = skipped_runs + 1
+				 WHERE installation_id = $1 AND name = $2`,
+				j.installationID, j.name, next,
+			)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		deliveryID := uuid.New()
+		payload, err := json.Marshal(map[string]any{
+			"id":          deliveryID,
+			"type":        JobEventType,
+			"communityId": j.communityID,
+			"createdAt":   now.UTC(),
+			"data":        jobRun{Job: j.name, ScheduledAt: j.scheduledAt.UTC()},
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO plugin_deliveries (id, installation_id, event_type, payload) VALUES ($1, $2, $3, $4)`,
+			deliveryID, j.installationID, JobEventType, payload,
+		)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE plugin_jobs SET delivery_id = $3, last_run_at = $4, next_run_at = $5
+			 WHERE installation_id = $1 AND name = $2`,
+			j.installationID, j.name, deliveryID, now, next,
+		)
+		if err != nil {
+			return 0, err
+		}
+		fired++
+	}
+
+	return fired, tx.Commit(ctx)
+}
+
+// jobSucceeded resets the failure streak of the job a delivery ran
+func (s *Service) jobSucceeded(ctx context.Context, d *models.PluginDelivery) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE plugin_jobs SET failures = 0, last_error = NULL WHERE delivery_id = $1`,
+		d.ID,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("deliveryId", d.ID.String()).Msg("Failed to record plugin job run")
+	}
+}
+
+// jobFailed backs the job a delivery ran off: its next run is at least
+// baseJobBackoff doubled for every earlier failure in a row
+func (s *Service) jobFailed(ctx context.Context, d *models.PluginDelivery, reason string) {
+	_, err := s.db.Exec(ctx,
+		`UPDATE plugin_jobs SET
+			failures = failures + 1,
+			last_error = $2,
+			next_run_at = GREATEST(next_run_at, NOW() + LEAST($3::interval * power(2, LEAST(failures, 16)), $4::interval))
+		 WHERE delivery_id = $1`,
+		d.ID, truncateError(reason), baseJobBackoff, maxJobBackoff,
+	)
+	if err != nil {
+		log.Error().Err(err).Str("deliveryId", d.ID.String()).Msg("Failed to record plugin job failure")
+	}
+}
@@ -0,0 +1,261 @@
+package plugin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/message"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	installation := []openapi.Param{openapi.ID("communityId"), openapi.ID("pluginId")}
+	storageKey := openapi.Param{Name: "key", Required: true}
+	storageQuery := []openapi.Param{
+		{Name: "prefix", Description: "Only keys starting with this"},
+		{Name: "after", Description: "Only keys after this one, to page through the list"},
+		{Name: "limit", Type: "integer", Description: "At most this many keys, 50 by default"},
+	}
+
+	openapi.Describe((*Handler).ListPlugins, openapi.Operation{
+		Summary:  "List the plugins in the catalog",
+		Query:    []openapi.Param{{Name: "source", Description: "Only plugins from this source"}},
+		Response: []*models.Plugin{},
+	})
+	openapi.Describe((*Handler).SearchPlugins, openapi.Operation{
+		Summary:  "Search the plugin catalog",
+		Query:    []openapi.Param{{Name: "q", Required: true}},
+		Response: []*models.Plugin{},
+	})
+	openapi.Describe((*Handler).GetPlugin, openapi.Operation{
+		Summary:  "Get a plugin from the catalog",
+		Path:     []openapi.Param{openapi.ID("pluginId")},
+		Response: models.Plugin{},
+	})
+
+	openapi.Describe((*Handler).GetCommunityPlugins, openapi.Operation{
+		Summary:  "List the plugins installed on a community",
+		Path:     communityID,
+		Response: []*models.CommunityPlugin{},
+	})
+	openapi.Describe((*Handler).GetCommunityPlugin, openapi.Operation{
+		Summary:  "Get a plugin installed on a community",
+		Path:     installation,
+		Response: models.CommunityPlugin{},
+	})
+	openapi.Describe((*Handler).InstallPlugin, openapi.Operation{
+		Summary:  "Install a plugin on a community",
+		Path:     communityID,
+		Body:     installRequest{},
+		Response: models.CommunityPlugin{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).UninstallPlugin, openapi.Operation{
+		Summary: "Uninstall a plugin from a community",
+		Path:    installation,
+		Query: []openapi.Param{
+			{Name: "channels", Enum: []string{"archive", "delete"}, Description: "What happens to the plugin's channels, archive by default"},
+		},
+		Status: http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).TogglePlugin, openapi.Operation{
+		Summary: "Enable or disable an installed plugin",
+		Path:    installation,
+		Body:    toggleRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UpdateConfig, openapi.Operation{
+		Summary: "Update an installed plugin's settings",
+		Path:    installation,
+		Body:    configRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UpdatePermissions, openapi.Operation{
+		Summary: "Change what an installed plugin may do",
+		Path:    installation,
+		Body:    permissionsRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).EmitEvent, openapi.Operation{
+		Summary: "Send a plugin's custom realtime event",
+		Path:    installation,
+		Body:    emitEventRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetManagedChannels, openapi.Operation{
+		Summary:  "List the channels a plugin manages",
+		Path:     installation,
+		Response: []*models.Channel{},
+	})
+	openapi.Describe((*Handler).CreateChannel, openapi.Operation{
+		Summary:  "Create a channel managed by a plugin",
+		Path:     installation,
+		Body:     channel.CreateChannelRequest{},
+		Response: models.Channel{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).DeleteChannel, openapi.Operation{
+		Summary: "Delete a channel managed by a plugin",
+		Path:    append(installation, openapi.ID("channelId")),
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RotateSigningSecret, openapi.Operation{
+		Summary:  "Replace the secret the plugin's events are signed with",
+		Path:     installation,
+		Response: models.CommunityPlugin{},
+	})
+	openapi.Describe((*Handler).ListDeliveries, openapi.Operation{
+		Summary:  "List the events sent to a plugin's endpoint",
+		Path:     installation,
+		Query:    openapi.CursorQuery,
+		Response: []*models.PluginDelivery{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).GetAPIToken, openapi.Operation{
+		Summary:  "Get a plugin's API token, without its secret",
+		Path:     installation,
+		Response: models.PluginAPIToken{},
+	})
+	openapi.Describe((*Handler).IssueAPIToken, openapi.Operation{
+		Summary:     "Create or rotate a plugin's API token",
+		Description: "The token is only in this response.",
+		Path:        installation,
+		Response:    APITokenWithSecret{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).RevokeAPIToken, openapi.Operation{
+		Summary: "Revoke a plugin's API token",
+		Path:    installation,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).ListJobs, openapi.Operation{
+		Summary:  "List a plugin's scheduled jobs",
+		Path:     installation,
+		Response: []*models.PluginJob{},
+	})
+	openapi.Describe((*Handler).RunJob, openapi.Operation{
+		Summary: "Run a plugin's scheduled job now",
+		Path:    append(installation, openapi.Param{Name: "name", Required: true}),
+		Response: struct {
+			Queued bool `json:"queued"`
+		}{},
+		Status: http.StatusAccepted,
+	})
+	openapi.Describe((*Handler).ListStorage, openapi.Operation{
+		Summary:  "List the keys a plugin stored on a community",
+		Path:     installation,
+		Query:    storageQuery,
+		Response: StoragePage{},
+	})
+	openapi.Describe((*Handler).GetStorageValue, openapi.Operation{
+		Summary:  "Read a key a plugin stored",
+		Path:     append(installation, storageKey),
+		Response: models.PluginStorageEntry{},
+	})
+	openapi.Describe((*Handler).PutStorageValue, openapi.Operation{
+		Summary:     "Overwrite a key a plugin stored",
+		Description: "The body is the value.",
+		Path:        append(installation, storageKey),
+		Body:        json.RawMessage{},
+		Response:    models.PluginStorageEntry{},
+	})
+	openapi.Describe((*Handler).DeleteStorageValue, openapi.Operation{
+		Summary: "Remove a key a plugin stored",
+		Path:    append(installation, storageKey),
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetAuditLog, openapi.Operation{
+		Summary:  "List the latest plugin changes on a community",
+		Path:     communityID,
+		Response: []*models.PluginAuditEntry{},
+	})
+
+	openapi.Describe((*Handler).GetSources, openapi.Operation{
+		Summary:  "List a community's plugin sources",
+		Path:     communityID,
+		Response: []*models.PluginSource{},
+	})
+	openapi.Describe((*Handler).AddSource, openapi.Operation{
+		Summary:  "Add a plugin source",
+		Path:     communityID,
+		Body:     addSourceRequest{},
+		Response: models.PluginSource{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).RemoveSource, openapi.Operation{
+		Summary: "Remove a plugin source",
+		Path:    append(communityID, openapi.ID("sourceId")),
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).SyncSource, openapi.Operation{
+		Summary:  "Fetch a plugin source's catalog",
+		Path:     append(communityID, openapi.ID("sourceId")),
+		Response: SyncResult{},
+	})
+
+	// Called by plugins with their API token
+	openapi.Describe((*Handler).GetTokenInfo, openapi.Operation{
+		Summary:  "Get the installation the token belongs to",
+		Response: models.PluginAPIToken{},
+	})
+	openapi.Describe((*Handler).GetTokenCommunity, openapi.Operation{
+		Summary:  "Get the token's community",
+		Response: json.RawMessage{},
+	})
+	openapi.Describe((*Handler).ListTokenChannels, openapi.Operation{
+		Summary:  "List the channels of the token's community",
+		Response: json.RawMessage{},
+	})
+	openapi.Describe((*Handler).GetTokenChannel, openapi.Operation{
+		Summary:  "Get a channel of the token's community",
+		Path:     []openapi.Param{openapi.ID("channelId")},
+		Response: json.RawMessage{},
+	})
+	openapi.Describe((*Handler).SendTokenMessage, openapi.Operation{
+		Summary:  "Send a message as the plugin",
+		Path:     []openapi.Param{openapi.ID("channelId")},
+		Body:     sendMessageRequest{},
+		Response: message.MessageResponse{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).ListTokenMembers, openapi.Operation{
+		Summary: "List the members of the token's community, oldest first",
+		Query:   openapi.CursorQuery,
+		Shape:   openapi.Page,
+	})
+	openapi.Describe((*Handler).GetTokenMember, openapi.Operation{
+		Summary:  "Get a member of the token's community",
+		Path:     []openapi.Param{openapi.ID("userId")},
+		Response: json.RawMessage{},
+	})
+	openapi.Describe((*Handler).EmitTokenEvent, openapi.Operation{
+		Summary: "Send a custom realtime event",
+		Body:    emitEventRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).ListTokenStorage, openapi.Operation{
+		Summary:  "List the keys the plugin stored",
+		Query:    storageQuery,
+		Response: StoragePage{},
+	})
+	openapi.Describe((*Handler).GetTokenStorageValue, openapi.Operation{
+		Summary:  "Read a key the plugin stored",
+		Path:     []openapi.Param{storageKey},
+		Response: models.PluginStorageEntry{},
+	})
+	openapi.Describe((*Handler).PutTokenStorageValue, openapi.Operation{
+		Summary:     "Store a key for the plugin",
+		Description: "The body is the value.",
+		Path:        []openapi.Param{storageKey},
+		Body:        json.RawMessage{},
+		Response:    models.PluginStorageEntry{},
+	})
+	openapi.Describe((*Handler).DeleteTokenStorageValue, openapi.Operation{
+		Summary: "Remove a key the plugin stored",
+		Path:    []openapi.Param{storageKey},
+		Status:  http.StatusNoContent,
+	})
+}
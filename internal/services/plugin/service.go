@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,7 +15,11 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
 	"github.com/zentra/server/internal/services/channeltype"
+	"github.com/zentra/server/internal/services/eventhook"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/encryption"
 )
 
 var (
@@ -26,35 +31,109 @@ var (
 	ErrSourceExists       = errors.New("source already exists for this community")
 	ErrInvalidPermissions = errors.New("granted permissions exceed what the plugin requests")
 	ErrFetchFailed        = errors.New("failed to fetch plugin from source")
+	ErrInvalidConfig      = errors.New("invalid plugin config")
+
+	ErrConfigChannelDenied = errors.New("config posts to a channel you can't send messages in")
 )
 
+// ConfigValidator checks a plugin-specific config blob before it gets saved.
+// Plugins that run inside the server register one for their slug.
+type ConfigValidator func(config json.RawMessage) error
+
+// ConfigChannels lists the channels a plugin config posts to. The member
+// saving the config must be able to send messages in each of them, so a
+// plugin's bot can't be pointed at channels its configurer can't post in.
+type ConfigChannels func(config json.RawMessage) []uuid.UUID
+
+// ChannelAccessChecker is the subset of the channel service used to check who
+// may send plugin events where.
+type ChannelAccessChecker interface {
+	CanAccessChannel(ctx context.Context, channelID, userID uuid.UUID) bool
+}
+
+// ChannelManager is the part of the channel service plugins use to create and
+// clean up the channels they manage.
+type ChannelManager interface {
+	ChannelAccessChecker
+	CreateManagedChannel(ctx context.Context, communityID, pluginID, userID uuid.UUID, req *channel.CreateChannelRequest) (*models.Channel, error)
+	DeleteManagedChannel(ctx context.Context, communityID, pluginID, channelID, userID uuid.UUID) error
+	GetManagedChannels(ctx context.Context, communityID, pluginID uuid.UUID) ([]*models.Channel, error)
+	ReleasePluginChannels(ctx context.Context, communityID, pluginID, actorID uuid.UUID, remove bool) (int64, error)
+	IntegrationCanPost(ctx context.Context, communityID, channelID, pluginID uuid.UUID) (bool, error)
+	CanSendMessage(ctx context.Context, channelID, userID uuid.UUID) bool
+}
+
+// CommunityServiceInterface is what plugins need from the community service
+type CommunityServiceInterface interface {
+	GetMemberPermissions(ctx context.Context, communityID, userID uuid.UUID) (int64, error)
+}
+
 type Service struct {
-	db              *pgxpool.Pool
-	channelRegistry *channeltype.Registry
-	httpClient      *http.Client
+	db               *pgxpool.Pool
+	channelRegistry  *channeltype.Registry
+	channelAccess    ChannelAccessChecker
+	channels         ChannelManager
+	communityService CommunityServiceInterface
+	keys             *encryption.Keyring
+	httpClient       *http.Client
+	sender           *eventhook.Sender
+	cipher           messaging.ContentCipher
+	messages         MessagePoster
+	wake             chan struct{}
+	sandbox          *sandbox
+	allowUnsigned    bool
+	trustedKeys      map[string]bool
+	configValidators map[string]ConfigValidator
+	configChannels   map[string]ConfigChannels
 }
 
-func NewService(db *pgxpool.Pool, channelRegistry *channeltype.Registry) *Service {
+func NewService(db *pgxpool.Pool, channelRegistry *channeltype.Registry, channels ChannelManager, communityService CommunityServiceInterface, keys *encryption.Keyring) *Service {
 	return &Service{
-		db:              db,
-		channelRegistry: channelRegistry,
+		db:               db,
+		channelRegistry:  channelRegistry,
+		channelAccess:    channels,
+		channels:         channels,
+		communityService: communityService,
+		keys:             keys,
 		httpClient: &http.Client{
 			Timeout: 15 * time.Second,
+			// Sources, signing keys and modules are fetched from URLs community
+			// managers give, so only public addresses are dialed and redirects
+			// aren't followed
+			Transport: messaging.PublicTransport(),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		},
+		sender:           eventhook.NewSender(deliveryTimeout, "Zentra-Plugins/1.0"),
+		cipher:           messaging.NewChannelCipher(keys),
+		wake:             make(chan struct{}, 1),
+		configValidators: make(map[string]ConfigValidator),
+		configChannels:   make(map[string]ConfigChannels),
 	}
 }
 
+// RegisterConfigValidator hooks config validation for a plugin slug. Call during startup only.
+func (s *Service) RegisterConfigValidator(slug string, validate ConfigValidator) {
+	s.configValidators[slug] = validate
+}
+
+// RegisterConfigChannels hooks the channel check for a plugin slug. Call during startup only.
+func (s *Service) RegisterConfigChannels(slug string, channels ConfigChannels) {
+	s.configChannels[slug] = channels
+}
+
 // GetPlugin fetches a single plugin by ID
 func (s *Service) GetPlugin(ctx context.Context, pluginID uuid.UUID) (*models.Plugin, error) {
 	plugin := &models.Plugin{}
 	err := s.db.QueryRow(ctx,
 		`SELECT id, slug, name, description, author, version, homepage_url, source_url, icon_url,
-		        requested_permissions, manifest, built_in, source, is_verified, created_at, updated_at
+		        requested_permissions, manifest, built_in, source, is_verified, signed_by, created_at, updated_at
 		 FROM plugins WHERE id = $1`, pluginID,
 	).Scan(
 		&plugin.ID, &plugin.Slug, &plugin.Name, &plugin.Description, &plugin.Author, &plugin.Version,
 		&plugin.HomepageURL, &plugin.SourceURL, &plugin.IconURL, &plugin.RequestedPermissions,
-		&plugin.Manifest, &plugin.BuiltIn, &plugin.Source, &plugin.IsVerified, &plugin.CreatedAt, &plugin.UpdatedAt,
+		&plugin.Manifest, &plugin.BuiltIn, &plugin.Source, &plugin.IsVerified, &plugin.SignedBy, &plugin.CreatedAt, &plugin.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -70,12 +149,12 @@ func (s *Service) GetPluginBySlug(ctx context.Context, slug string) (*models.Plu
 	plugin := &models.Plugin{}
 	err := s.db.QueryRow(ctx,
 		`SELECT id, slug, name, description, author, version, homepage_url, source_url, icon_url,
-		        requested_permissions, manifest, built_in, source, is_verified, created_at, updated_at
+		        requested_permissions, manifest, built_in, source, is_verified, signed_by, created_at, updated_at
 		 FROM plugins WHERE slug = $1`, slug,
 	).Scan(
 		&plugin.ID, &plugin.Slug, &plugin.Name, &plugin.Description, &plugin.Author, &plugin.Version,
 		&plugin.HomepageURL, &plugin.SourceURL, &plugin.IconURL, &plugin.RequestedPermissions,
-		&plugin.Manifest, &plugin.BuiltIn, &plugin.Source, &plugin.IsVerified, &plugin.CreatedAt, &plugin.UpdatedAt,
+		&plugin.Manifest, &plugin.BuiltIn, &plugin.Source, &plugin.IsVerified, &plugin.SignedBy, &plugin.CreatedAt, &plugin.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -89,7 +168,7 @@ func (s *Service) GetPluginBySlug(ctx context.Context, slug string) (*models.Plu
 // ListAvailablePlugins returns all plugins in the system (for marketplace browsing)
 func (s *Service) ListAvailablePlugins(ctx context.Context, source string) ([]*models.Plugin, error) {
 	query := `SELECT id, slug, name, description, author, version, homepage_url, source_url, icon_url,
-	                  requested_permissions, manifest, built_in, source, is_verified, created_at, updated_at
+	                  requested_permissions, manifest, built_in, source, is_verified, signed_by, created_at, updated_at
 	           FROM plugins`
 	args := []any{}
 
@@ -111,7 +190,7 @@ func (s *Service) ListAvailablePlugins(ctx context.Context, source string) ([]*m
 		if err := rows.Scan(
 			&p.ID, &p.Slug, &p.Name, &p.Description, &p.Author, &p.Version,
 			&p.HomepageURL, &p.SourceURL, &p.IconURL, &p.RequestedPermissions,
-			&p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.CreatedAt, &p.UpdatedAt,
+			&p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.SignedBy, &p.CreatedAt, &p.UpdatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("scan plugin: %w", err)
 		}
@@ -124,7 +203,7 @@ func (s *Service) ListAvailablePlugins(ctx context.Context, source string) ([]*m
 func (s *Service) SearchPlugins(ctx context.Context, query string) ([]*models.Plugin, error) {
 	rows, err := s.db.Query(ctx,
 		`SELECT id, slug, name, description, author, version, homepage_url, source_url, icon_url,
-		        requested_permissions, manifest, built_in, source, is_verified, created_at, updated_at
+		        requested_permissions, manifest, built_in, source, is_verified, signed_by, created_at, updated_at
 		 FROM plugins
 		 WHERE name ILIKE '%' || $1 || '%' OR description ILIKE '%' || $1 || '%' OR slug ILIKE '%' || $1 || '%'
 		 ORDER BY is_verified DESC, name ASC
@@ -141,7 +220,7 @@ func (s *Service) SearchPlugins(ctx context.Context, query string) ([]*models.Pl
 		if err := rows.Scan(
 			&p.ID, &p.Slug, &p.Name, &p.Description, &p.Author, &p.Version,
 			&p.HomepageURL, &p.SourceURL, &p.IconURL, &p.RequestedPermissions,
-			&p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.CreatedAt, &p.UpdatedAt,
+			&p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.SignedBy, &p.CreatedAt, &p.UpdatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("scan plugin: %w", err)
 		}
@@ -158,21 +237,34 @@ func (s *Service) InstallPlugin(ctx context.Context, communityID, pluginID, inst
 		return nil, err
 	}
 
+	if err := s.checkTrusted(plugin); err != nil {
+		return nil, err
+	}
+
 	// Don't let people grant more than the plugin asks for
 	if grantedPermissions & ^plugin.RequestedPermissions != 0 {
 		return nil, ErrInvalidPermissions
 	}
 
+	// Every installation gets a secret to sign the events sent to the plugin,
+	// sealed for the installation's row
+	installationID := uuid.New()
+	secret, encryptedSecret, err := s.newSigningSecret(installationID)
+	if err != nil {
+		return nil, err
+	}
+
 	cp := &models.CommunityPlugin{}
 	err = s.db.QueryRow(ctx,
-		`INSERT INTO community_plugins (community_id, plugin_id, enabled, granted_permissions, installed_by)
-		 VALUES ($1, $2, TRUE, $3, $4)
+		`INSERT INTO community_plugins (id, community_id, plugin_id, enabled, granted_permissions, installed_by, encrypted_secret)
+		 VALUES ($6, $1, $2, TRUE, $3, $4, $5)
 		 ON CONFLICT (community_id, plugin_id) DO NOTHING
-		 RETURNING id, community_id, plugin_id, enabled, granted_permissions, config, installed_by, installed_at, updated_at`,
-		communityID, pluginID, grantedPermissions, installedBy,
+		 RETURNING id, community_id, plugin_id, enabled, granted_permissions, config, installed_by, installed_at, updated_at,
+		           deliveries_paused`,
+		communityID, pluginID, grantedPermissions, installedBy, encryptedSecret, installationID,
 	).Scan(
 		&cp.ID, &cp.CommunityID, &cp.PluginID, &cp.Enabled, &cp.GrantedPermissions,
-		&cp.Config, &cp.InstalledBy, &cp.InstalledAt, &cp.UpdatedAt,
+		&cp.Config, &cp.InstalledBy, &cp.InstalledAt, &cp.UpdatedAt, &cp.DeliveriesPaused,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -181,6 +273,7 @@ func (s *Service) InstallPlugin(ctx context.Context, communityID, pluginID, inst
 		return nil, fmt.Errorf("install plugin: %w", err)
 	}
 	cp.Plugin = plugin
+	cp.SigningSecret = secret
 
 	// Register any channel types this plugin provides
 	s.registerPluginChannelTypes(ctx, plugin)
@@ -193,8 +286,13 @@ func (s *Service) InstallPlugin(ctx context.Context, communityID, pluginID, inst
 	return cp, nil
 }
 
-// UninstallPlugin removes a plugin from a community
-func (s *Service) UninstallPlugin(ctx context.Context, communityID, pluginID, actorID uuid.UUID) error {
+// UninstallPlugin removes a plugin from a community. Channels the plugin
+// manages there are archived, or deleted when deleteChannels is set. The
+// actor needs ManageCommunity.
+func (s *Service) UninstallPlugin(ctx context.Context, communityID, pluginID, actorID uuid.UUID, deleteChannels bool) error {
+	if err := s.requireManager(ctx, communityID, actorID); err != nil {
+		return err
+	}
 	plugin, err := s.GetPlugin(ctx, pluginID)
 	if err != nil {
 		return err
@@ -203,6 +301,8 @@ func (s *Service) UninstallPlugin(ctx context.Context, communityID, pluginID, ac
 		return ErrBuiltInPlugin
 	}
 
+	// Channels are only released once this installation is gone, and only
+	// the ones it managed in this community
 	tag, err := s.db.Exec(ctx,
 		`DELETE FROM community_plugins WHERE community_id = $1 AND plugin_id = $2`,
 		communityID, pluginID,
@@ -214,11 +314,20 @@ func (s *Service) UninstallPlugin(ctx context.Context, communityID, pluginID, ac
 		return ErrNotInstalled
 	}
 
-	s.logAction(ctx, communityID, pluginID, actorID, "uninstall", nil)
+	released, err := s.channels.ReleasePluginChannels(ctx, communityID, pluginID, actorID, deleteChannels)
+	if err != nil {
+		return fmt.Errorf("release plugin channels: %w", err)
+	}
+
+	s.logAction(ctx, communityID, pluginID, actorID, "uninstall", map[string]any{
+		"channels":        released,
+		"channelsDeleted": deleteChannels,
+	})
 	return nil
 }
 
-// TogglePlugin enables or disables a plugin on a community without removing it
+// TogglePlugin enables or disables a plugin on a community without removing it.
+// Enabling it resumes event deliveries paused after repeated failures.
 func (s *Service) TogglePlugin(ctx context.Context, communityID, pluginID, actorID uuid.UUID, enabled bool) error {
 	plugin, err := s.GetPlugin(ctx, pluginID)
 	if err != nil {
@@ -229,7 +338,10 @@ func (s *Service) TogglePlugin(ctx context.Context, communityID, pluginID, actor
 	}
 
 	tag, err := s.db.Exec(ctx,
-		`UPDATE community_plugins SET enabled = $3, updated_at = NOW()
+		`UPDATE community_plugins SET enabled = $3,
+			deliveries_paused = deliveries_paused AND NOT $3,
+			delivery_failures = CASE WHEN $3 THEN 0 ELSE delivery_failures END,
+			updated_at = NOW()
 		 WHERE community_id = $1 AND plugin_id = $2`,
 		communityID, pluginID, enabled,
 	)
@@ -250,10 +362,29 @@ func (s *Service) TogglePlugin(ctx context.Context, communityID, pluginID, actor
 
 // UpdatePluginConfig lets server owners change plugin-specific settings
 func (s *Service) UpdatePluginConfig(ctx context.Context, communityID, pluginID, actorID uuid.UUID, config json.RawMessage) error {
+	if err := s.requireManager(ctx, communityID, actorID); err != nil {
+		return err
+	}
+
+	plugin, err := s.GetPlugin(ctx, pluginID)
+	if err != nil {
+		return err
+	}
+	if validate, ok := s.configValidators[plugin.Slug]; ok {
+		if err := validate(config); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	if channels, ok := s.configChannels[plugin.Slug]; ok {
+		if err := s.checkConfigChannels(ctx, communityID, actorID, channels(config)); err != nil {
+			return err
+		}
+	}
+
 	tag, err := s.db.Exec(ctx,
-		`UPDATE community_plugins SET config = $3, updated_at = NOW()
+		`UPDATE community_plugins SET config = $3, config_updated_by = $4, updated_at = NOW()
 		 WHERE community_id = $1 AND plugin_id = $2`,
-		communityID, pluginID, config,
+		communityID, pluginID, config, actorID,
 	)
 	if err != nil {
 		return fmt.Errorf("update plugin config: %w", err)
@@ -266,6 +397,36 @@ func (s *Service) UpdatePluginConfig(ctx context.Context, communityID, pluginID,
 	return nil
 }
 
+// checkConfigChannels makes sure every channel a config posts to is in the
+// community and that actorID can see it and send messages there
+func (s *Service) checkConfigChannels(ctx context.Context, communityID, actorID uuid.UUID, channelIDs []uuid.UUID) error {
+	distinct := make(map[uuid.UUID]bool, len(channelIDs))
+	for _, id := range channelIDs {
+		distinct[id] = true
+	}
+	if len(distinct) == 0 {
+		return nil
+	}
+
+	var found int
+	if err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*) FROM channels WHERE community_id = $1 AND id = ANY($2)`,
+		communityID, channelIDs,
+	).Scan(&found); err != nil {
+		return err
+	}
+	if found != len(distinct) {
+		return fmt.Errorf("%w: a channel is not in this community", ErrInvalidConfig)
+	}
+
+	for channelID := range distinct {
+		if !s.channels.CanAccessChannel(ctx, channelID, actorID) || !s.channels.CanSendMessage(ctx, channelID, actorID) {
+			return ErrConfigChannelDenied
+		}
+	}
+	return nil
+}
+
 // UpdatePluginPermissions lets server owners change what a plugin is allowed to do
 func (s *Service) UpdatePluginPermissions(ctx context.Context, communityID, pluginID, actorID uuid.UUID, grantedPermissions int64) error {
 	plugin, err := s.GetPlugin(ctx, pluginID)
@@ -298,9 +459,9 @@ func (s *Service) UpdatePluginPermissions(ctx context.Context, communityID, plug
 func (s *Service) GetCommunityPlugins(ctx context.Context, communityID uuid.UUID) ([]*models.CommunityPlugin, error) {
 	rows, err := s.db.Query(ctx,
 		`SELECT cp.id, cp.community_id, cp.plugin_id, cp.enabled, cp.granted_permissions,
-		        cp.config, cp.installed_by, cp.installed_at, cp.updated_at,
+		        cp.config, cp.installed_by, cp.installed_at, cp.updated_at, cp.deliveries_paused,
 		        p.id, p.slug, p.name, p.description, p.author, p.version, p.homepage_url, p.source_url, p.icon_url,
-		        p.requested_permissions, p.manifest, p.built_in, p.source, p.is_verified, p.created_at, p.updated_at
+		        p.requested_permissions, p.manifest, p.built_in, p.source, p.is_verified, p.signed_by, p.created_at, p.updated_at
 		 FROM community_plugins cp
 		 JOIN plugins p ON p.id = cp.plugin_id
 		 WHERE cp.community_id = $1
@@ -317,9 +478,9 @@ func (s *Service) GetCommunityPlugins(ctx context.Context, communityID uuid.UUID
 		p := &models.Plugin{}
 		if err := rows.Scan(
 			&cp.ID, &cp.CommunityID, &cp.PluginID, &cp.Enabled, &cp.GrantedPermissions,
-			&cp.Config, &cp.InstalledBy, &cp.InstalledAt, &cp.UpdatedAt,
+			&cp.Config, &cp.InstalledBy, &cp.InstalledAt, &cp.UpdatedAt, &cp.DeliveriesPaused,
 			&p.ID, &p.Slug, &p.Name, &p.Description, &p.Author, &p.Version, &p.HomepageURL, &p.SourceURL, &p.IconURL,
-			&p.RequestedPermissions, &p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.CreatedAt, &p.UpdatedAt,
+			&p.RequestedPermissions, &p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.SignedBy, &p.CreatedAt, &p.UpdatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("scan community plugin: %w", err)
 		}
@@ -335,17 +496,17 @@ func (s *Service) GetCommunityPlugin(ctx context.Context, communityID, pluginID
 	p := &models.Plugin{}
 	err := s.db.QueryRow(ctx,
 		`SELECT cp.id, cp.community_id, cp.plugin_id, cp.enabled, cp.granted_permissions,
-		        cp.config, cp.installed_by, cp.installed_at, cp.updated_at,
+		        cp.config, cp.installed_by, cp.installed_at, cp.updated_at, cp.deliveries_paused,
 		        p.id, p.slug, p.name, p.description, p.author, p.version, p.homepage_url, p.source_url, p.icon_url,
-		        p.requested_permissions, p.manifest, p.built_in, p.source, p.is_verified, p.created_at, p.updated_at
+		        p.requested_permissions, p.manifest, p.built_in, p.source, p.is_verified, p.signed_by, p.created_at, p.updated_at
 		 FROM community_plugins cp
 		 JOIN plugins p ON p.id = cp.plugin_id
 		 WHERE cp.community_id = $1 AND cp.plugin_id = $2`, communityID, pluginID,
 	).Scan(
 		&cp.ID, &cp.CommunityID, &cp.PluginID, &cp.Enabled, &cp.GrantedPermissions,
-		&cp.Config, &cp.InstalledBy, &cp.InstalledAt, &cp.UpdatedAt,
+		&cp.Config, &cp.InstalledBy, &cp.InstalledAt, &cp.UpdatedAt, &cp.DeliveriesPaused,
 		&p.ID, &p.Slug, &p.Name, &p.Description, &p.Author, &p.Version, &p.HomepageURL, &p.SourceURL, &p.IconURL,
-		&p.RequestedPermissions, &p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.CreatedAt, &p.UpdatedAt,
+		&p.RequestedPermissions, &p.Manifest, &p.BuiltIn, &p.Source, &p.IsVerified, &p.SignedBy, &p.CreatedAt, &p.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -369,15 +530,25 @@ func (s *Service) IsPluginInstalled(ctx context.Context, communityID, pluginID u
 
 // Plugin Sources (apt-style repos)
 
-// AddSource registers a new plugin source for a community
+// AddSource registers a new plugin source for a community, pinning the
+// signing key it publishes
 func (s *Service) AddSource(ctx context.Context, communityID, addedBy uuid.UUID, name, url string) (*models.PluginSource, error) {
+	url = strings.TrimRight(url, "/")
+	if err := checkSourceURL(ctx, url); err != nil {
+		return nil, err
+	}
+	publicKey, err := s.fetchSourceKey(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
 	src := &models.PluginSource{}
-	err := s.db.QueryRow(ctx,
-		`INSERT INTO plugin_sources (community_id, name, url, added_by)
-		 VALUES ($1, $2, $3, $4)
-		 RETURNING id, community_id, name, url, enabled, added_by, created_at`,
-		communityID, name, url, addedBy,
-	).Scan(&src.ID, &src.CommunityID, &src.Name, &src.URL, &src.Enabled, &src.AddedBy, &src.CreatedAt)
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO plugin_sources (community_id, name, url, added_by, public_key)
+		 VALUES ($1, $2, $3, $4, $5)
+		 RETURNING id, community_id, name, url, enabled, public_key, added_by, created_at`,
+		communityID, name, url, addedBy, publicKey,
+	).Scan(&src.ID, &src.CommunityID, &src.Name, &src.URL, &src.Enabled, &src.PublicKey, &src.AddedBy, &src.CreatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("add source: %w", err)
 	}
@@ -402,7 +573,7 @@ func (s *Service) RemoveSource(ctx context.Context, communityID, sourceID uuid.U
 // GetSources lists all plugin sources for a community
 func (s *Service) GetSources(ctx context.Context, communityID uuid.UUID) ([]*models.PluginSource, error) {
 	rows, err := s.db.Query(ctx,
-		`SELECT id, community_id, name, url, enabled, added_by, created_at
+		`SELECT id, community_id, name, url, enabled, public_key, added_by, created_at
 		 FROM plugin_sources WHERE community_id = $1 ORDER BY created_at ASC`, communityID,
 	)
 	if err != nil {
@@ -413,7 +584,7 @@ func (s *Service) GetSources(ctx context.Context, communityID uuid.UUID) ([]*mod
 	sources := make([]*models.PluginSource, 0)
 	for rows.Next() {
 		src := &models.PluginSource{}
-		if err := rows.Scan(&src.ID, &src.CommunityID, &src.Name, &src.URL, &src.Enabled, &src.AddedBy, &src.CreatedAt); err != nil {
+		if err := rows.Scan(&src.ID, &src.CommunityID, &src.Name, &src.URL, &src.Enabled, &src.PublicKey, &src.AddedBy, &src.CreatedAt); err != nil {
 			return nil, fmt.Errorf("scan source: %w", err)
 		}
 		sources = append(sources, src)
@@ -423,7 +594,7 @@ func (s *Service) GetSources(ctx context.Context, communityID uuid.UUID) ([]*mod
 
 // FetchFromSource contacts a source URL and returns available plugins.
 // Sources expose a simple JSON API that returns a list of plugin manifests.
-func (s *Service) FetchFromSource(ctx context.Context, sourceURL string) ([]*models.Plugin, error) {
+func (s *Service) FetchFromSource(ctx context.Context, sourceURL string) ([]*SourcePlugin, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL+"/api/v1/plugins", nil)
 	if err != nil {
 		return nil, fmt.Errorf("build request: %w", err)
@@ -446,7 +617,7 @@ func (s *Service) FetchFromSource(ctx context.Context, sourceURL string) ([]*mod
 	}
 
 	var result struct {
-		Data []*models.Plugin `json:"data"`
+		Data []*SourcePlugin `json:"data"`
 	}
 	if err := json.Unmarshal(body, &result); err != nil {
 		return nil, fmt.Errorf("parse source response: %w", err)
@@ -455,19 +626,57 @@ func (s *Service) FetchFromSource(ctx context.Context, sourceURL string) ([]*mod
 	return result.Data, nil
 }
 
-// SyncFromSource fetches plugins from a source and upserts them into the local DB
-func (s *Service) SyncFromSource(ctx context.Context, sourceURL string) (int, error) {
-	plugins, err := s.FetchFromSource(ctx, sourceURL)
+// SyncFromSource fetches plugins from a source and upserts them into the local
+// DB, checking their signatures against the source's pinned key. They are
+// stored under the source's namespace.
+func (s *Service) SyncFromSource(ctx context.Context, src *models.PluginSource) (*SyncResult, error) {
+	if err := checkSourceURL(ctx, src.URL); err != nil {
+		return nil, err
+	}
+	publicKey, err := s.fetchSourceKey(ctx, src.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case src.PublicKey != nil && (publicKey == nil || *publicKey != *src.PublicKey):
+		return nil, ErrSourceKeyChanged
+	case src.PublicKey == nil && publicKey != nil:
+		// Sources added before they signed anything get their key pinned now
+		_, err := s.db.Exec(ctx,
+			`UPDATE plugin_sources SET public_key = $1 WHERE id = $2 AND public_key IS NULL`,
+			*publicKey, src.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("pin source key: %w", err)
+		}
+	}
+
+	plugins, err := s.FetchFromSource(ctx, src.URL)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	count := 0
+	namespace := sourceNamespace(src.URL)
+	result := &SyncResult{}
 	for _, p := range plugins {
-		_, err := s.db.Exec(ctx,
+		if p.Slug == "" || strings.Contains(p.Slug, "/") {
+			result.Rejected++
+			continue
+		}
+		var signedBy *string
+		if p.Signature != "" {
+			if publicKey == nil || !verifyPlugin(*publicKey, p) {
+				log.Warn().Str("slug", p.Slug).Str("source", src.URL).Msg("Rejected plugin with an invalid signature")
+				result.Rejected++
+				continue
+			}
+			signedBy = publicKey
+		}
+
+		tag, err := s.db.Exec(ctx,
 			`INSERT INTO plugins (slug, name, description, author, version, homepage_url, source_url, icon_url,
-			                      requested_permissions, manifest, source, is_verified)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			                      requested_permissions, manifest, source, is_verified, signed_by)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 			 ON CONFLICT (slug) DO UPDATE SET
 			   name = EXCLUDED.name,
 			   description = EXCLUDED.description,
@@ -479,19 +688,30 @@ func (s *Service) SyncFromSource(ctx context.Context, sourceURL string) (int, er
 			   requested_permissions = EXCLUDED.requested_permissions,
 			   manifest = EXCLUDED.manifest,
 			   is_verified = EXCLUDED.is_verified,
+			   signed_by = EXCLUDED.signed_by,
 			   updated_at = NOW()
-			 WHERE plugins.built_in = FALSE`,
-			p.Slug, p.Name, p.Description, p.Author, p.Version, p.HomepageURL, p.SourceURL, p.IconURL,
-			p.RequestedPermissions, p.Manifest, sourceURL, p.IsVerified,
+			 WHERE plugins.built_in = FALSE
+			   AND plugins.source = EXCLUDED.source
+			   AND (plugins.signed_by IS NULL OR plugins.signed_by = EXCLUDED.signed_by)`,
+			namespace+"/"+p.Slug, p.Name, p.Description, p.Author, p.Version, p.HomepageURL, p.SourceURL, p.IconURL,
+			p.RequestedPermissions, p.Manifest, src.URL, p.IsVerified, signedBy,
 		)
 		if err != nil {
 			log.Warn().Err(err).Str("slug", p.Slug).Msg("Failed to sync plugin from source")
 			continue
 		}
-		count++
+		if tag.RowsAffected() == 0 {
+			log.Warn().Str("slug", p.Slug).Str("source", src.URL).Msg("Rejected plugin that would replace a built-in or differently signed one")
+			result.Rejected++
+			continue
+		}
+		result.Synced++
+		if signedBy == nil {
+			result.Unsigned++
+		}
 	}
 
-	return count, nil
+	return result, nil
 }
 
 // EnsureBuiltInPluginsInstalled makes sure every community has the core plugin.
@@ -538,7 +758,8 @@ func (s *Service) GetPluginAuditLog(ctx context.Context, communityID uuid.UUID,
 	return entries, rows.Err()
 }
 
-// registerPluginChannelTypes takes a plugin's manifest and registers any channel types it declares
+// registerPluginChannelTypes takes a plugin's manifest and registers any channel
+// types it declares, or updates the ones an earlier version of it registered
 func (s *Service) registerPluginChannelTypes(ctx context.Context, plugin *models.Plugin) {
 	manifest, err := plugin.ParsedManifest()
 	if err != nil {
@@ -546,25 +767,48 @@ func (s *Service) registerPluginChannelTypes(ctx context.Context, plugin *models
 		return
 	}
 
-	for _, typeID := range manifest.ChannelTypes {
-		// Skip if this type is already registered (e.g. built-in types)
-		if s.channelRegistry.Exists(typeID) {
+	pluginIDStr := plugin.ID.String()
+	for _, declared := range manifest.ChannelTypes {
+		caps, err := declared.CapabilityFlags()
+		if err != nil || len(declared.ID) > 64 || len(declared.Name) > 128 || len(declared.Icon) > 64 {
+			log.Warn().Err(err).Str("type", declared.ID).Str("plugin", plugin.Slug).Msg("Skipping invalid plugin channel type")
 			continue
 		}
 
-		pluginIDStr := plugin.ID.String()
 		def := &models.ChannelTypeDefinition{
-			ID:           typeID,
-			Name:         typeID, // plugins will provide better names via their frontend bundle
-			Description:  fmt.Sprintf("Provided by %s", plugin.Name),
-			Icon:         "puzzle",
-			Capabilities: models.CapMessages,
-			BuiltIn:      false,
-			PluginID:     &pluginIDStr,
+			ID:              declared.ID,
+			Name:            declared.Name,
+			Description:     declared.Description,
+			Icon:            declared.Icon,
+			Capabilities:    caps,
+			DefaultMetadata: declared.DefaultMetadata,
+			BuiltIn:         false,
+			PluginID:        &pluginIDStr,
+		}
+		if def.Name == "" {
+			def.Name = declared.ID
+		}
+		if def.Description == "" {
+			def.Description = fmt.Sprintf("Provided by %s", plugin.Name)
+		}
+		if def.Icon == "" {
+			def.Icon = "puzzle"
+		}
+
+		if existing, err := s.channelRegistry.Get(declared.ID); err == nil {
+			// Types registered by built-ins or other plugins are left alone
+			if existing.PluginID == nil || *existing.PluginID != pluginIDStr || existing.BuiltIn {
+				continue
+			}
+			err = s.channelRegistry.Update(ctx, def)
+			if err != nil {
+				log.Warn().Err(err).Str("type", declared.ID).Str("plugin", plugin.Slug).Msg("Failed to update plugin channel type")
+			}
+			continue
 		}
 
 		if err := s.channelRegistry.Register(ctx, def); err != nil {
-			log.Warn().Err(err).Str("type", typeID).Str("plugin", plugin.Slug).Msg("Failed to register plugin channel type")
+			log.Warn().Err(err).Str("type", declared.ID).Str("plugin", plugin.Slug).Msg("Failed to register plugin channel type")
 		}
 	}
 }
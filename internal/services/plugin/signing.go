@@ -0,0 +1,178 @@
+package plugin
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+)
+
+// Sources sign what they publish, so a spoofed or compromised source can't
+// hand communities manifests its owner never signed. A source serves its
+// Ed25519 public key at {source}/api/v1/signing-key, and the key is pinned
+// when the source is added. Each plugin it lists carries a detached base64
+// "signature" over signedManifest. Syncing refuses a source whose key has
+// changed and skips plugins whose signature doesn't verify. A plugin signed
+// once is only replaced by a manifest signed with the same key, so it can't
+// be downgraded to unsigned or taken over by another source.
+//
+// Any community manager can add a source, so a signature only shows which
+// source a plugin came from. Installing also needs the key to be one the
+// operator trusts (PLUGIN_TRUSTED_KEYS). Plugins from sources are named
+// <source>/<slug>, so one source can't replace another's plugin by listing
+// the same slug.
+
+// Prefix of the signed bytes, so signatures can't be replayed elsewhere
+const signatureContext = "zentra-plugin-manifest-v1"
+
+var (
+	ErrSourceKeyChanged = errors.New("plugin source signing key changed")
+	ErrInvalidSourceKey = errors.New("plugin source published an invalid signing key")
+	ErrUnsignedPlugin   = errors.New("plugin is not signed by its source")
+	ErrUntrustedPlugin  = errors.New("plugin is signed by a key this instance doesn't trust")
+	ErrInvalidSourceURL = errors.New("plugin source must be a public http(s) URL")
+)
+
+// SourcePlugin is a plugin as a source lists it
+type SourcePlugin struct {
+	models.Plugin
+	// Base64 Ed25519 signature of signedManifest
+	Signature string `json:"signature,omitempty"`
+}
+
+// SyncResult counts what a source sync did with each plugin it listed
+type SyncResult struct {
+	Synced int `json:"synced"`
+	// Synced without a signature; only installable when the instance allows it
+	Unsigned int `json:"unsigned"`
+	// Left alone: bad slug or signature, or signed by another key than the copy we have
+	Rejected int `json:"rejected"`
+}
+
+// SetAllowUnsigned lets communities install plugins that weren't signed by
+// a trusted key. Call during startup only.
+func (s *Service) SetAllowUnsigned(allow bool) {
+	s.allowUnsigned = allow
+}
+
+// SetTrustedKeys sets the base64 Ed25519 source keys the operator trusts.
+// Only plugins signed with one of them can be installed. Call during startup
+// only.
+func (s *Service) SetTrustedKeys(keys []string) error {
+	trusted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid plugin signing key %q", k)
+		}
+		trusted[base64.StdEncoding.EncodeToString(key)] = true
+	}
+	s.trustedKeys = trusted
+	return nil
+}
+
+// checkTrusted reports whether communities may install plugin
+func (s *Service) checkTrusted(plugin *models.Plugin) error {
+	switch {
+	case plugin.BuiltIn || s.allowUnsigned:
+		return nil
+	case plugin.SignedBy == nil:
+		return ErrUnsignedPlugin
+	case !s.trustedKeys[*plugin.SignedBy]:
+		return ErrUntrustedPlugin
+	}
+	return nil
+}
+
+// sourceNamespace is what the slugs of a source's plugins start with: its
+// URL without the scheme
+func sourceNamespace(sourceURL string) string {
+	ns := strings.TrimPrefix(strings.TrimPrefix(sourceURL, "https://"), "http://")
+	return strings.TrimRight(ns, "/")
+}
+
+// checkSourceURL rejects source URLs the server shouldn't call. The address
+// is checked again when it's dialed.
+func checkSourceURL(ctx context.Context, sourceURL string) error {
+	parsed, err := url.Parse(sourceURL)
+	if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.User != nil {
+		return ErrInvalidSourceURL
+	}
+	if err := messaging.ValidatePublicHost(ctx, parsed.Hostname()); err != nil {
+		return ErrInvalidSourceURL
+	}
+	return nil
+}
+
+// signedManifest is what a source signs for a plugin: the fields that decide
+// what it can do, with the manifest exactly as served
+func signedManifest(p *models.Plugin) []byte {
+	head := signatureContext + "\n" + p.Slug + "\n" + p.Version + "\n" + strconv.FormatInt(p.RequestedPermissions, 10) + "\n"
+	return append([]byte(head), p.Manifest...)
+}
+
+func verifyPlugin(publicKey string, p *SourcePlugin) bool {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, signedManifest(&p.Plugin), sig)
+}
+
+// fetchSourceKey returns the public key a source publishes, or nil when it
+// doesn't sign its plugins
+func (s *Service) fetchSourceKey(ctx context.Context, sourceURL string) (*string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL+"/api/v1/signing-key", nil)
+	if err != nil {
+		return nil, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, ErrFetchFailed
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrFetchFailed
+	}
+
+	var result struct {
+		Data struct {
+			Algorithm string `json:"algorithm"`
+			PublicKey string `json:"publicKey"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		return nil, ErrInvalidSourceKey
+	}
+	if result.Data.Algorithm != "ed25519" {
+		return nil, ErrInvalidSourceKey
+	}
+	key, err := base64.StdEncoding.DecodeString(result.Data.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, ErrInvalidSourceKey
+	}
+
+	// Stored re-encoded so the same key always compares equal
+	publicKey := base64.StdEncoding.EncodeToString(key)
+	return &publicKey, nil
+}
@@ -0,0 +1,261 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+)
+
+// Plugins keep state in key-value storage instead of running a database of
+// their own. Every installation has its own namespace, so a plugin sees only
+// what it stored on that community, and the namespace goes away when the
+// plugin is uninstalled. Values are JSON.
+
+const (
+	// Largest single value
+	MaxStorageValueBytes = 64 * 1024
+
+	// Per installation
+	MaxStorageKeys    = 1000
+	StorageQuotaBytes = 4 * 1024 * 1024
+
+	defaultStorageListLimit = 50
+	maxStorageListLimit     = 100
+)
+
+var (
+	ErrStorageKeyNotFound = errors.New("storage key not found")
+	ErrInvalidStorageKey  = errors.New("storage keys are 1-128 letters, digits and . _ : -")
+	ErrInvalidStorageData = errors.New("storage value must be valid JSON")
+	ErrStorageValueSize   = errors.New("storage value is too large")
+	ErrStorageQuota       = errors.New("plugin storage quota exceeded")
+)
+
+var storageKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// StoragePage is a page of an installation's keys, with its usage
+type StoragePage struct {
+	Entries []*models.PluginStorageEntry `json:"entries"`
+	Usage   *models.PluginStorageUsage   `json:"usage"`
+}
+
+// GetValue reads one key of an installation's storage
+func (s *Service) GetValue(ctx context.Context, installationID uuid.UUID, key string) (*models.PluginStorageEntry, error) {
+	if !storageKeyPattern.MatchString(key) {
+		return nil, ErrInvalidStorageKey
+	}
+
+	e := &models.PluginStorageEntry{Key: key}
+	err := s.db.QueryRow(ctx,
+		`SELECT value, size, created_at, updated_at FROM plugin_storage WHERE installation_id = $1 AND key = $2`,
+		installationID, key,
+	).Scan(&e.Value, &e.Size, &e.CreatedAt, &e.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrStorageKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get plugin storage: %w", err)
+	}
+	return e, nil
+}
+
+// PutValue sets one key of an installation's storage, within its quota
+func (s *Service) PutValue(ctx context.Context, installationID uuid.UUID, key string, value json.RawMessage) (*models.PluginStorageEntry, error) {
+	if !storageKeyPattern.MatchString(key) {
+		return nil, ErrInvalidStorageKey
+	}
+	if len(value) > MaxStorageValueBytes {
+		return nil, ErrStorageValueSize
+	}
+	if !json.Valid(value) {
+		return nil, ErrInvalidStorageData
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Writes to one installation are serialized so they can't race past the quota
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT TRUE FROM community_plugins WHERE id = $1 FOR UPDATE`, installationID).Scan(&exists)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotInstalled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var keys int
+	var bytes int64
+	err = tx.QueryRow(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM plugin_storage WHERE installation_id = $1 AND key <> $2`,
+		installationID, key,
+	).Scan(&keys, &bytes)
+	if err != nil {
+		return nil, err
+	}
+	if keys+1 > MaxStorageKeys || bytes+int64(len(value)) > StorageQuotaBytes {
+		return nil, ErrStorageQuota
+	}
+
+	e := &models.PluginStorageEntry{Key: key, Size: len(value)}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO plugin_storage (installation_id, key, value, size)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (installation_id, key) DO UPDATE
+		 SET value = EXCLUDED.value, size = EXCLUDED.size, updated_at = NOW()
+		 RETURNING value, created_at, updated_at`,
+		installationID, key, value, len(value),
+	).Scan(&e.Value, &e.CreatedAt, &e.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("put plugin storage: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// DeleteValue removes one key of an installation's storage
+func (s *Service) DeleteValue(ctx context.Context, installationID uuid.UUID, key string) error {
+	if !storageKeyPattern.MatchString(key) {
+		return ErrInvalidStorageKey
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM plugin_storage WHERE installation_id = $1 AND key = $2`,
+		installationID, key,
+	)
+	if err != nil {
+		return fmt.Errorf("delete plugin storage: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrStorageKeyNotFound
+	}
+	return nil
+}
+
+// ListStorage returns an installation's keys in order, starting with prefix
+// and after the given key, without their values
+func (s *Service) ListStorage(ctx context.Context, installationID uuid.UUID, prefix, after string, limit int) (*StoragePage, error) {
+	if limit <= 0 || limit > maxStorageListLimit {
+		limit = defaultStorageListLimit
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT key, size, created_at, updated_at FROM plugin_storage
+		 WHERE installation_id = $1 AND starts_with(key, $2) AND key > $3
+		 ORDER BY key
+		 LIMIT $4`,
+		installationID, prefix, after, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list plugin storage: %w", err)
+	}
+	defer rows.Close()
+
+	page := &StoragePage{Entries: make([]*models.PluginStorageEntry, 0)}
+	for rows.Next() {
+		e := &models.PluginStorageEntry{}
+		if err := rows.Scan(&e.Key, &e.Size, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		page.Entries = append(page.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	page.Usage, err = s.storageUsage(ctx, installationID)
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// ListStorageAsUser lists a plugin's storage on a community for its managers
+func (s *Service) ListStorageAsUser(ctx context.Context, communityID, pluginID, userID uuid.UUID, prefix, after string, limit int) (*StoragePage, error) {
+	installationID, err := s.managedInstallation(ctx, communityID, pluginID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.ListStorage(ctx, installationID, prefix, after, limit)
+}
+
+// GetValueAsUser reads one key of a plugin's storage for a community manager
+func (s *Service) GetValueAsUser(ctx context.Context, communityID, pluginID, userID uuid.UUID, key string) (*models.PluginStorageEntry, error) {
+	installationID, err := s.managedInstallation(ctx, communityID, pluginID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetValue(ctx, installationID, key)
+}
+
+// PutValueAsUser lets a community manager fix up a plugin's stored state
+func (s *Service) PutValueAsUser(ctx context.Context, communityID, pluginID, userID uuid.UUID, key string, value json.RawMessage) (*models.PluginStorageEntry, error) {
+	installationID, err := s.managedInstallation(ctx, communityID, pluginID, userID)
+	if err != nil {
+		return nil, err
+	}
+	e, err := s.PutValue(ctx, installationID, key, value)
+	if err != nil {
+		return nil, err
+	}
+	s.logAction(ctx, communityID, pluginID, userID, "storage_put", map[string]any{"key": key})
+	return e, nil
+}
+
+// DeleteValueAsUser removes one key of a plugin's storage for a community manager
+func (s *Service) DeleteValueAsUser(ctx context.Context, communityID, pluginID, userID uuid.UUID, key string) error {
+	installationID, err := s.managedInstallation(ctx, communityID, pluginID, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.DeleteValue(ctx, installationID, key); err != nil {
+		return err
+	}
+	s.logAction(ctx, communityID, pluginID, userID, "storage_delete", map[string]any{"key": key})
+	return nil
+}
+
+func (s *Service) storageUsage(ctx context.Context, installationID uuid.UUID) (*models.PluginStorageUsage, error) {
+	usage := &models.PluginStorageUsage{MaxKeys: MaxStorageKeys, QuotaBytes: StorageQuotaBytes}
+	err := s.db.QueryRow(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(size), 0) FROM plugin_storage WHERE installation_id = $1`,
+		installationID,
+	).Scan(&usage.Keys, &usage.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// managedInstallation finds the installation of a plugin on a community the
+// user can manage
+func (s *Service) managedInstallation(ctx context.Context, communityID, pluginID, userID uuid.UUID) (uuid.UUID, error) {
+	if err := s.requireManager(ctx, communityID, userID); err != nil {
+		return uuid.Nil, err
+	}
+
+	var installationID uuid.UUID
+	err := s.db.QueryRow(ctx,
+		`SELECT id FROM community_plugins WHERE community_id = $1 AND plugin_id = $2`,
+		communityID, pluginID,
+	).Scan(&installationID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return uuid.Nil, ErrNotInstalled
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return installationID, nil
+}
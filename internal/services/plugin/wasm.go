@@ -0,0 +1,343 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/messaging"
+	"golang.org/x/sync/singleflight"
+)
+
+// Plugins without hosting of their own can ship a WASM module instead of an
+// endpoint. Each event is handled by a fresh instance of the module, so
+// nothing carries over between events or installations, under a memory cap
+// and a deadline; state it keeps goes in the installation's storage. The
+// module reaches the community only through the host functions in
+// wasm_host.go, each gated by the installation's granted permissions. WASI is available without a filesystem, environment or
+// network.
+//
+// A module is a WASI reactor exporting:
+//
+//	memory
+//	zentra_alloc(size i32) -> ptr i32
+//	zentra_on_event(type_ptr, type_len, payload_ptr, payload_len i32) -> i32
+//
+// zentra_on_event gets the event type and the same JSON body an endpoint
+// would, and returns 0 once it has handled the event. Anything else, a trap
+// or running out of time fails the attempt, which is retried like a failed
+// endpoint delivery.
+
+const (
+	// Largest module the sandbox fetches
+	MaxWasmModuleBytes = 8 * 1024 * 1024
+
+	guestAlloc   = "zentra_alloc"
+	guestOnEvent = "zentra_on_event"
+
+	// Compiled modules kept in memory, across all plugins
+	maxCompiledModules = 64
+
+	wasmPageSize = 64 * 1024
+)
+
+var (
+	errInvalidModule = errors.New("plugin module must export memory, " + guestAlloc + " and " + guestOnEvent)
+	errModuleDigest  = errors.New("plugin module does not match its sha256")
+	errModuleURL     = errors.New("plugin module URL must be a public https URL")
+	errModuleTooBig  = errors.New("plugin module is too large")
+)
+
+// SandboxLimits bound what a single event handler may use
+type SandboxLimits struct {
+	// Memory each module instance may grow to
+	MemoryMB int
+	// Wall time for one event, host calls included
+	Timeout time.Duration
+	// Handlers running at once on this instance
+	Concurrency int
+}
+
+type sandbox struct {
+	runtime wazero.Runtime
+	timeout time.Duration
+	slots   chan struct{}
+
+	// Fetches and compiles each module once, however many events wait on it
+	loads singleflight.Group
+
+	// Guards compiled and the use counts in it
+	mu       sync.Mutex
+	compiled map[string]*compiledModule
+}
+
+// compiledModule is closed once it has been evicted and no handler uses it
+type compiledModule struct {
+	module  wazero.CompiledModule
+	uses    int
+	evicted bool
+}
+
+// EnableSandbox starts the WASM runtime plugin modules run in. Without it,
+// events for WASM plugins fail without being retried. Call during startup
+// only.
+func (s *Service) EnableSandbox(ctx context.Context, limits SandboxLimits) error {
+	if limits.MemoryMB <= 0 || limits.Timeout <= 0 || limits.Concurrency <= 0 {
+		return errors.New("sandbox limits must be positive")
+	}
+
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(limits.MemoryMB*1024*1024/wasmPageSize)).
+		WithCloseOnContextDone(true))
+
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return fmt.Errorf("instantiate wasi: %w", err)
+	}
+	if err := s.instantiateHostModule(ctx, runtime); err != nil {
+		runtime.Close(ctx)
+		return fmt.Errorf("instantiate plugin host module: %w", err)
+	}
+
+	s.sandbox = &sandbox{
+		runtime:  runtime,
+		timeout:  limits.Timeout,
+		slots:    make(chan struct{}, limits.Concurrency),
+		compiled: make(map[string]*compiledModule),
+	}
+	return nil
+}
+
+// runWasm hands one event to the installation's module
+func (s *Service) runWasm(ctx context.Context, t *deliveryTarget, d *models.PluginDelivery) error {
+	sb := s.sandbox
+	compiled, release, err := s.loadModule(ctx, t.Manifest.Wasm)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	select {
+	case sb.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-sb.slots }()
+
+	runCtx, cancel := context.WithTimeout(ctx, sb.timeout)
+	defer cancel()
+	runCtx = context.WithValue(runCtx, invocationKey{}, &invocation{target: t})
+
+	mod, err := sb.runtime.InstantiateModule(runCtx, compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize").
+		WithRandSource(rand.Reader).
+		WithSysWalltime().
+		WithSysNanotime())
+	if err != nil {
+		return fmt.Errorf("start plugin module: %w", err)
+	}
+	defer mod.Close(context.WithoutCancel(ctx))
+
+	alloc := mod.ExportedFunction(guestAlloc)
+	onEvent := mod.ExportedFunction(guestOnEvent)
+	if alloc == nil || onEvent == nil || mod.Memory() == nil {
+		return errInvalidModule
+	}
+
+	typePtr, err := writeGuest(runCtx, mod, alloc, []byte(d.EventType))
+	if err != nil {
+		return sandboxError(runCtx, err)
+	}
+	payloadPtr, err := writeGuest(runCtx, mod, alloc, d.Payload)
+	if err != nil {
+		return sandboxError(runCtx, err)
+	}
+
+	results, err := onEvent.Call(runCtx, uint64(typePtr), uint64(len(d.EventType)), uint64(payloadPtr), uint64(len(d.Payload)))
+	if err != nil {
+		return sandboxError(runCtx, err)
+	}
+	if code := int32(results[0]); code != 0 {
+		return fmt.Errorf("plugin module returned %d", code)
+	}
+	return nil
+}
+
+// loadModule returns the compiled module for spec, fetching it the first
+// time any instance needs it. The module stays open until release is called.
+func (s *Service) loadModule(ctx context.Context, spec *models.PluginWasm) (wazero.CompiledModule, func(), error) {
+	digest := strings.ToLower(spec.SHA256)
+	sb := s.sandbox
+
+	for {
+		sb.mu.Lock()
+		entry, ok := sb.compiled[digest]
+		if ok {
+			entry.uses++
+		}
+		sb.mu.Unlock()
+		if ok {
+			return entry.module, func() { sb.release(ctx, entry) }, nil
+		}
+
+		// The fetch and compile run outside the lock and aren't tied to the
+		// first caller's context, since others may be waiting on them
+		ch := sb.loads.DoChan(digest, func() (any, error) {
+			return s.compileModule(context.WithoutCancel(ctx), spec.URL, digest)
+		})
+		select {
+		case res := <-ch:
+			if res.Err != nil {
+				return nil, nil, res.Err
+			}
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		// Loop to take a use of the cached module; if it was evicted in
+		// between, it is loaded again
+	}
+}
+
+// compileModule loads a module from the database, or fetches it, and caches
+// it compiled
+func (s *Service) compileModule(ctx context.Context, rawURL, digest string) (any, error) {
+	sb := s.sandbox
+
+	var module []byte
+	err := s.db.QueryRow(ctx, `SELECT module FROM plugin_wasm_modules WHERE sha256 = $1`, digest).Scan(&module)
+	if errors.Is(err, pgx.ErrNoRows) {
+		module, err = s.fetchModule(ctx, rawURL, digest)
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.db.Exec(ctx,
+			`INSERT INTO plugin_wasm_modules (sha256, module, size, source_url)
+			 VALUES ($1, $2, $3, $4)
+			 ON CONFLICT (sha256) DO NOTHING`,
+			digest, module, len(module), rawURL,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load plugin module: %w", err)
+	}
+
+	compiled, err := sb.runtime.CompileModule(ctx, module)
+	if err != nil {
+		return nil, fmt.Errorf("compile plugin module: %w", err)
+	}
+	exports := compiled.ExportedFunctions()
+	if _, ok := exports[guestAlloc]; !ok {
+		compiled.Close(ctx)
+		return nil, errInvalidModule
+	}
+	if _, ok := exports[guestOnEvent]; !ok {
+		compiled.Close(ctx)
+		return nil, errInvalidModule
+	}
+
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	// Another load may have finished just before this one started
+	if _, ok := sb.compiled[digest]; ok {
+		compiled.Close(ctx)
+		return nil, nil
+	}
+	// Plugins rarely change; starting over is simpler than tracking recency.
+	// Modules still in use are closed when their last handler finishes.
+	if len(sb.compiled) >= maxCompiledModules {
+		for key, old := range sb.compiled {
+			old.evicted = true
+			if old.uses == 0 {
+				old.module.Close(ctx)
+			}
+			delete(sb.compiled, key)
+		}
+	}
+	sb.compiled[digest] = &compiledModule{module: compiled}
+	return nil, nil
+}
+
+// release gives back a use of entry, closing it if it was evicted meanwhile
+func (sb *sandbox) release(ctx context.Context, entry *compiledModule) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	entry.uses--
+	if entry.evicted && entry.uses == 0 {
+		entry.module.Close(context.WithoutCancel(ctx))
+	}
+}
+
+func (s *Service) fetchModule(ctx context.Context, rawURL, digest string) ([]byte, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !validEndpoint(rawURL) {
+		return nil, errModuleURL
+	}
+	if err := messaging.ValidatePublicHost(ctx, parsed.Hostname()); err != nil {
+		return nil, errModuleURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch plugin module: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch plugin module: status %d", resp.StatusCode)
+	}
+
+	module, err := io.ReadAll(io.LimitReader(resp.Body, MaxWasmModuleBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetch plugin module: %w", err)
+	}
+	if len(module) > MaxWasmModuleBytes {
+		return nil, errModuleTooBig
+	}
+
+	sum := sha256.Sum256(module)
+	if hex.EncodeToString(sum[:]) != digest {
+		return nil, errModuleDigest
+	}
+
+	log.Info().Str("url", rawURL).Int("bytes", len(module)).Msg("Fetched plugin module")
+	return module, nil
+}
+
+// writeGuest copies data into memory the module allocated for it
+func writeGuest(ctx context.Context, mod api.Module, alloc api.Function, data []byte) (uint32, error) {
+	results, err := alloc.Call(ctx, uint64(len(data)))
+	if err != nil {
+		return 0, err
+	}
+	ptr := uint32(results[0])
+	if !mod.Memory().Write(ptr, data) {
+		return 0, fmt.Errorf("%s returned memory out of range", guestAlloc)
+	}
+	return ptr, nil
+}
+
+func sandboxError(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.New("plugin module ran out of time")
+	}
+	return fmt.Errorf("plugin module failed: %w", err)
+}
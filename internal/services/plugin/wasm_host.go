@@ -0,0 +1,293 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/zentra/server/internal/models"
+)
+
+// Host functions plugin modules import from the "zentra" module. Functions
+// that return data take an output buffer and return the data's length; when
+// that is more than the buffer holds nothing is written, and the module can
+// call again with a larger one. Negative results are errors:
+const (
+	hostErrDenied   int32 = -1 // the installation lacks the permission
+	hostErrNotFound int32 = -2 // not in this community
+	hostErrInvalid  int32 = -3 // bad argument
+	hostErrFailed   int32 = -4
+	hostErrLimit    int32 = -5 // too many host calls or events, or over the storage quota
+)
+
+const (
+	hostModule = "zentra"
+
+	// Host calls one event handler may make
+	maxHostCalls = 64
+
+	maxLogBytes = 1024
+)
+
+type invocationKey struct{}
+
+// invocation is the installation an event handler runs for
+type invocation struct {
+	target *deliveryTarget
+	calls  int
+}
+
+func (s *Service) instantiateHostModule(ctx context.Context, runtime wazero.Runtime) error {
+	_, err := runtime.NewHostModuleBuilder(hostModule).
+		// log(level, msg_ptr, msg_len): 0 debug, 1 info, 2 warn, 3 error
+		NewFunctionBuilder().WithFunc(s.hostLog).Export("log").
+		// config(out_ptr, out_cap) -> len: the installation's config JSON
+		NewFunctionBuilder().WithFunc(s.hostConfig).Export("config").
+		// community(out_ptr, out_cap) -> len: needs Server Info
+		NewFunctionBuilder().WithFunc(s.hostCommunity).Export("community").
+		// channel(id_ptr, id_len, out_ptr, out_cap) -> len: needs Read Channels
+		NewFunctionBuilder().WithFunc(s.hostChannel).Export("channel").
+		// member(user_id_ptr, user_id_len, out_ptr, out_cap) -> len: needs Read Members
+		NewFunctionBuilder().WithFunc(s.hostMember).Export("member").
+		// emit_event(target_ptr, target_len, name_ptr, name_len, payload_ptr, payload_len) -> 0:
+		// needs Emit Events; target is an EventTarget as JSON
+		NewFunctionBuilder().WithFunc(s.hostEmitEvent).Export("emit_event").
+		// storage_get(key_ptr, key_len, out_ptr, out_cap) -> len: a value the
+		// installation stored, as JSON
+		NewFunctionBuilder().WithFunc(s.hostStorageGet).Export("storage_get").
+		// storage_set(key_ptr, key_len, value_ptr, value_len) -> 0: value is JSON
+		NewFunctionBuilder().WithFunc(s.hostStorageSet).Export("storage_set").
+		// storage_delete(key_ptr, key_len) -> 0
+		NewFunctionBuilder().WithFunc(s.hostStorageDelete).Export("storage_delete").
+		Instantiate(ctx)
+	return err
+}
+
+func (s *Service) hostLog(ctx context.Context, m api.Module, level, ptr, length uint32) {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return
+	}
+	if length > maxLogBytes {
+		length = maxLogBytes
+	}
+	msg, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		return
+	}
+
+	zl := zerolog.DebugLevel
+	switch level {
+	case 1:
+		zl = zerolog.InfoLevel
+	case 2:
+		zl = zerolog.WarnLevel
+	case 3:
+		zl = zerolog.ErrorLevel
+	}
+	log.WithLevel(zl).
+		Str("pluginId", inv.target.PluginID.String()).
+		Str("communityId", inv.target.CommunityID.String()).
+		Msg(string(msg))
+}
+
+func (s *Service) hostConfig(ctx context.Context, m api.Module, outPtr, outCap uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	config := inv.target.Config
+	if len(config) == 0 {
+		config = json.RawMessage("{}")
+	}
+	return writeOut(m, config, outPtr, outCap)
+}
+
+func (s *Service) hostCommunity(ctx context.Context, m api.Module, outPtr, outCap uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	if inv.target.Granted&models.PluginPermServerInfo == 0 {
+		return hostErrDenied
+	}
+
+	data, err := s.communityJSON(ctx, inv.target.CommunityID)
+	return writeResult(m, data, err, outPtr, outCap)
+}
+
+func (s *Service) hostChannel(ctx context.Context, m api.Module, idPtr, idLen, outPtr, outCap uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	if inv.target.Granted&models.PluginPermReadChannels == 0 {
+		return hostErrDenied
+	}
+	channelID, ok := readUUID(m, idPtr, idLen)
+	if !ok {
+		return hostErrInvalid
+	}
+
+	data, err := s.channelJSON(ctx, inv.target.CommunityID, channelID)
+	return writeResult(m, data, err, outPtr, outCap)
+}
+
+func (s *Service) hostMember(ctx context.Context, m api.Module, idPtr, idLen, outPtr, outCap uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	if inv.target.Granted&models.PluginPermReadMembers == 0 {
+		return hostErrDenied
+	}
+	userID, ok := readUUID(m, idPtr, idLen)
+	if !ok {
+		return hostErrInvalid
+	}
+
+	data, err := s.memberJSON(ctx, inv.target.CommunityID, userID)
+	return writeResult(m, data, err, outPtr, outCap)
+}
+
+func (s *Service) hostEmitEvent(ctx context.Context, m api.Module, targetPtr, targetLen, namePtr, nameLen, payloadPtr, payloadLen uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	rawTarget, ok1 := m.Memory().Read(targetPtr, targetLen)
+	name, ok2 := m.Memory().Read(namePtr, nameLen)
+	payload, ok3 := m.Memory().Read(payloadPtr, payloadLen)
+	if !ok1 || !ok2 || !ok3 || payloadLen > MaxEventPayloadBytes {
+		return hostErrInvalid
+	}
+	var target EventTarget
+	if err := json.Unmarshal(rawTarget, &target); err != nil {
+		return hostErrInvalid
+	}
+
+	// Memory reads alias guest memory, so the payload is copied out
+	err := s.EmitEvent(ctx, inv.target.CommunityID, inv.target.PluginID, target, string(name), append(json.RawMessage(nil), payload...))
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrEventsNotAllowed), errors.Is(err, ErrNotInstalled):
+		return hostErrDenied
+	case errors.Is(err, ErrInvalidEventScope):
+		return hostErrNotFound
+	case errors.Is(err, ErrInvalidEventName), errors.Is(err, ErrEventTooLarge), errors.Is(err, ErrInvalidEventData):
+		return hostErrInvalid
+	case errors.Is(err, ErrEventRateLimited):
+		return hostErrLimit
+	default:
+		log.Warn().Err(err).Str("pluginId", inv.target.PluginID.String()).Msg("Plugin module failed to emit event")
+		return hostErrFailed
+	}
+}
+
+func (s *Service) hostStorageGet(ctx context.Context, m api.Module, keyPtr, keyLen, outPtr, outCap uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	key, ok := m.Memory().Read(keyPtr, keyLen)
+	if !ok {
+		return hostErrInvalid
+	}
+
+	entry, err := s.GetValue(ctx, inv.target.InstallationID, string(key))
+	if err != nil {
+		return storageResult(err)
+	}
+	return writeOut(m, entry.Value, outPtr, outCap)
+}
+
+func (s *Service) hostStorageSet(ctx context.Context, m api.Module, keyPtr, keyLen, valuePtr, valueLen uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	key, ok1 := m.Memory().Read(keyPtr, keyLen)
+	value, ok2 := m.Memory().Read(valuePtr, valueLen)
+	if !ok1 || !ok2 {
+		return hostErrInvalid
+	}
+
+	// Memory reads alias guest memory, so the value is copied out
+	_, err := s.PutValue(ctx, inv.target.InstallationID, string(key), append(json.RawMessage(nil), value...))
+	return storageResult(err)
+}
+
+func (s *Service) hostStorageDelete(ctx context.Context, m api.Module, keyPtr, keyLen uint32) int32 {
+	inv, ok := hostCall(ctx)
+	if !ok {
+		return hostErrLimit
+	}
+	key, ok := m.Memory().Read(keyPtr, keyLen)
+	if !ok {
+		return hostErrInvalid
+	}
+
+	return storageResult(s.DeleteValue(ctx, inv.target.InstallationID, string(key)))
+}
+
+func storageResult(err error) int32 {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrStorageKeyNotFound), errors.Is(err, ErrNotInstalled):
+		return hostErrNotFound
+	case errors.Is(err, ErrInvalidStorageKey), errors.Is(err, ErrInvalidStorageData), errors.Is(err, ErrStorageValueSize):
+		return hostErrInvalid
+	case errors.Is(err, ErrStorageQuota):
+		return hostErrLimit
+	default:
+		log.Warn().Err(err).Msg("Plugin module storage call failed")
+		return hostErrFailed
+	}
+}
+
+// hostCall counts a host call against the handler's budget
+func hostCall(ctx context.Context) (*invocation, bool) {
+	inv, ok := ctx.Value(invocationKey{}).(*invocation)
+	if !ok {
+		return nil, false
+	}
+	inv.calls++
+	return inv, inv.calls <= maxHostCalls
+}
+
+// writeResult writes out the result of one of the JSON queries in api.go
+func writeResult(m api.Module, data []byte, err error, outPtr, outCap uint32) int32 {
+	if errors.Is(err, ErrNotInCommunity) {
+		return hostErrNotFound
+	}
+	if err != nil {
+		return hostErrFailed
+	}
+	return writeOut(m, data, outPtr, outCap)
+}
+
+func writeOut(m api.Module, data []byte, outPtr, outCap uint32) int32 {
+	if uint32(len(data)) > outCap {
+		return int32(len(data))
+	}
+	if !m.Memory().Write(outPtr, data) {
+		return hostErrInvalid
+	}
+	return int32(len(data))
+}
+
+func readUUID(m api.Module, ptr, length uint32) (uuid.UUID, bool) {
+	raw, ok := m.Memory().Read(ptr, length)
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, err := uuid.ParseBytes(raw)
+	return id, err == nil
+}
@@ -0,0 +1,148 @@
+package portability
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/auth"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Routes serves the instance key publicly, since other instances fetch it to
+// verify bundles, and everything else to signed-in users
+func (h *Handler) Routes(jwtSecret string) chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/instance", h.GetInstance)
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.AuthMiddleware(jwtSecret))
+		r.Post("/export", h.Export)
+		r.Post("/import", h.Import)
+		r.Get("/imports", h.ListImports)
+		r.Get("/imports/{importId}/dm-history", h.GetDMHistory)
+	})
+
+	return r
+}
+
+func (h *Handler) GetInstance(w http.ResponseWriter, r *http.Request) {
+	info, err := h.service.Instance()
+	if err != nil {
+		respondPortabilityError(w, err, "Failed to get instance info")
+		return
+	}
+
+	utils.RespondSuccess(w, info)
+}
+
+// Export returns a signed bundle for importing on another instance
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req ExportRequest
+	if !utils.BindOptionalJSON(w, r, &req) {
+		return
+	}
+
+	bundle, err := h.service.Export(r.Context(), userID, &req)
+	if err != nil {
+		respondPortabilityError(w, err, "Failed to export account")
+		return
+	}
+
+	utils.RespondSuccess(w, bundle)
+}
+
+// Import applies a bundle exported by another instance to the caller's account
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxBundleBytes)
+	var req ImportRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	migration, err := h.service.Import(r.Context(), userID, &req.Bundle)
+	if err != nil {
+		respondPortabilityError(w, err, "Failed to import account")
+		return
+	}
+
+	utils.RespondCreated(w, migration)
+}
+
+func (h *Handler) ListImports(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	migrations, err := h.service.ListImports(r.Context(), userID)
+	if err != nil {
+		respondPortabilityError(w, err, "Failed to list imports")
+		return
+	}
+
+	utils.RespondSuccess(w, migrations)
+}
+
+func (h *Handler) GetDMHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	importID, err := uuid.Parse(chi.URLParam(r, "importId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid import ID")
+		return
+	}
+
+	history, err := h.service.GetDMHistory(r.Context(), importID, userID)
+	if err != nil {
+		respondPortabilityError(w, err, "Failed to get DM history")
+		return
+	}
+
+	utils.RespondSuccess(w, history)
+}
+
+func respondPortabilityError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrImportNotFound), errors.Is(err, auth.ErrUserNotFound):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidBundle), errors.Is(err, ErrBundleExpired), errors.Is(err, ErrSameInstance):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrBadSignature), errors.Is(err, ErrUntrustedIssuer):
+		utils.RespondError(w, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrBundleImported), errors.Is(err, auth.ErrIdentityInUse), errors.Is(err, auth.ErrIdentityMismatch):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrExportDisabled), errors.Is(err, ErrImportDisabled):
+		utils.RespondError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,36 @@
+package portability
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	openapi.Describe((*Handler).GetInstance, openapi.Operation{
+		Summary:     "Get this instance's signing key",
+		Description: "Other instances check exported bundles against it.",
+		Response:    InstanceInfo{},
+	})
+	openapi.Describe((*Handler).Export, openapi.Operation{
+		Summary:      "Export the current user's account as a signed bundle",
+		Body:         ExportRequest{},
+		BodyOptional: true,
+		Response:     Bundle{},
+	})
+	openapi.Describe((*Handler).Import, openapi.Operation{
+		Summary:  "Import a bundle exported by another instance",
+		Body:     ImportRequest{},
+		Response: Migration{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).ListImports, openapi.Operation{
+		Summary:  "List the current user's imports",
+		Response: []*Migration{},
+	})
+	openapi.Describe((*Handler).GetDMHistory, openapi.Operation{
+		Summary:  "Get the direct message history brought in by an import",
+		Path:     []openapi.Param{openapi.ID("importId")},
+		Response: []DMConversation{},
+	})
+}
@@ -0,0 +1,764 @@
+package portability
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/auth"
+	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/internal/services/user"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const (
+	BundleVersion  = 2
+	SignatureAlg   = "ed25519"
+	MaxBundleBytes = 32 * 1024 * 1024
+
+	bundleTTL = 7 * 24 * time.Hour
+	// DM history is capped so bundles stay small enough to upload
+	maxDMMessagesPerConversation = 1000
+	maxDMMessages                = 5000
+	dmPageSize                   = 100
+
+	fetchTimeout    = 10 * time.Second
+	maxRedirects    = 3
+	maxInstanceInfo = 64 * 1024
+)
+
+var (
+	ErrExportDisabled  = errors.New("account export is not configured on this instance")
+	ErrImportDisabled  = errors.New("account import is not configured on this instance")
+	ErrInvalidBundle   = errors.New("invalid account bundle")
+	ErrBadSignature    = errors.New("account bundle signature is invalid")
+	ErrBundleExpired   = errors.New("account bundle has expired")
+	ErrBundleImported  = errors.New("account bundle has already been imported")
+	ErrSameInstance    = errors.New("account bundle was issued by this instance")
+	ErrUntrustedIssuer = errors.New("could not verify the instance that issued the bundle")
+	ErrImportNotFound  = errors.New("import not found")
+)
+
+// portableSettingKeys are the settings_json keys that hold the portable
+// profile. They travel in the bundle's identity, not its settings.
+var portableSettingKeys = []string{
+	"portableIdentityId", "portableProfileVersion", "portableUsername",
+	"portableDisplayName", "portableAvatarUrl", "portableBio", "portableCustomStatus",
+}
+
+type Config struct {
+	// InstanceURL is this API's public origin, e.g. https://chat.example.com
+	InstanceURL string
+	// SigningKey signs exported bundles; nil disables export
+	SigningKey ed25519.PrivateKey
+	// TrustedIssuers are the instance origins bundles are accepted from; none
+	// disables import
+	TrustedIssuers []string
+}
+
+type Service struct {
+	db          *pgxpool.Pool
+	cfg         Config
+	cipher      messaging.ContentCipher
+	client      *http.Client
+	authService *auth.Service
+	userService *user.Service
+	dmService   *dm.Service
+}
+
+func NewService(db *pgxpool.Pool, cfg Config, keys *encryption.Keyring, authService *auth.Service, userService *user.Service, dmService *dm.Service) *Service {
+	cfg.InstanceURL = strings.TrimRight(cfg.InstanceURL, "/")
+	for i, issuer := range cfg.TrustedIssuers {
+		cfg.TrustedIssuers[i] = strings.TrimRight(issuer, "/")
+	}
+	return &Service{
+		db:     db,
+		cfg:    cfg,
+		cipher: messaging.NewDMCipher(keys),
+		client: &http.Client{
+			Timeout:   fetchTimeout,
+			Transport: messaging.PublicTransport(),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("too many redirects")
+				}
+				return messaging.ValidatePublicHost(req.Context(), req.URL.Hostname())
+			},
+		},
+		authService: authService,
+		userService: userService,
+		dmService:   dmService,
+	}
+}
+
+// Bundle is a signed account export. Payload is the base64url encoded JSON
+// of BundleContents and Signature signs those decoded bytes, so the issuer's
+// exact encoding is what gets verified.
+type Bundle struct {
+	Payload   string `json:"payload" validate:"required"`
+	Signature string `json:"signature" validate:"required"`
+}
+
+type BundleContents struct {
+	Version      int                           `json:"version"`
+	ID           uuid.UUID                     `json:"id"`
+	Issuer       string                        `json:"issuer"`
+	IssuedAt     time.Time                     `json:"issuedAt"`
+	ExpiresAt    time.Time                     `json:"expiresAt"`
+	SourceUserID uuid.UUID                     `json:"sourceUserId"`
+	Identity     *auth.PortableProfileEnvelope `json:"identity"`
+	Settings     *BundleSettings               `json:"settings"`
+	Friends      []BundleUser                  `json:"friends"`
+	DMHistory    []DMConversation              `json:"dmHistory,omitempty"`
+}
+
+type BundleSettings struct {
+	Theme                string            `json:"theme"`
+	NotificationsEnabled bool              `json:"notificationsEnabled"`
+	SoundEnabled         bool              `json:"soundEnabled"`
+	CompactMode          bool              `json:"compactMode"`
+	Settings             json.RawMessage   `json:"settings"`
+	QuietHours           models.QuietHours `json:"quietHours"`
+}
+
+// BundleUser names another user. Usernames are only meaningful on the
+// issuing instance, so other users are found elsewhere by a hash of their
+// identity ID. The ID itself signs its owner in and never leaves in a bundle.
+type BundleUser struct {
+	Username     string  `json:"username"`
+	IdentityHash *string `json:"identityHash,omitempty"`
+}
+
+type DMConversation struct {
+	Participants []BundleUser `json:"participants"`
+	Messages     []DMMessage  `json:"messages"`
+	// Truncated is set when older messages were left out
+	Truncated bool `json:"truncated"`
+}
+
+type DMMessage struct {
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type InstanceInfo struct {
+	InstanceURL string `json:"instanceUrl"`
+	PublicKey   string `json:"publicKey"`
+	Algorithm   string `json:"algorithm"`
+}
+
+type ExportRequest struct {
+	IncludeDMs bool `json:"includeDms"`
+}
+
+type ImportRequest struct {
+	Bundle Bundle `json:"bundle" validate:"required"`
+}
+
+// Migration is an imported bundle as recorded on this instance
+type Migration struct {
+	ID                uuid.UUID `json:"id"`
+	IdentityID        string    `json:"identityId"`
+	SourceInstance    string    `json:"sourceInstance"`
+	SourceUserID      uuid.UUID `json:"sourceUserId"`
+	FriendsRequested  int       `json:"friendsRequested"`
+	UnresolvedFriends []string  `json:"unresolvedFriends"`
+	HasDMHistory      bool      `json:"hasDmHistory"`
+	ImportedAt        time.Time `json:"importedAt"`
+}
+
+// Instance returns the key other instances verify this instance's bundles with
+func (s *Service) Instance() (*InstanceInfo, error) {
+	if s.cfg.SigningKey == nil {
+		return nil, ErrExportDisabled
+	}
+	return &InstanceInfo{
+		InstanceURL: s.cfg.InstanceURL,
+		PublicKey:   base64.StdEncoding.EncodeToString(s.cfg.SigningKey.Public().(ed25519.PublicKey)),
+		Algorithm:   SignatureAlg,
+	}, nil
+}
+
+// Export builds a signed bundle of the user's profile, settings and friends,
+// and optionally their DM history. Exporting mints a portable identity for
+// accounts that don't have one yet.
+func (s *Service) Export(ctx context.Context, userID uuid.UUID, req *ExportRequest) (*Bundle, error) {
+	if s.cfg.SigningKey == nil {
+		return nil, ErrExportDisabled
+	}
+
+	identity, err := s.authService.PortableIdentity(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := s.userService.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settingsJSON, err := withoutPortableKeys(settings.SettingsJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	friends, err := s.userService.GetFriends(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	friendIDs := make([]uuid.UUID, 0, len(friends))
+	for _, friend := range friends {
+		friendIDs = append(friendIDs, friend.ID)
+	}
+	identities, err := s.identityHashesOf(ctx, friendIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	contents := &BundleContents{
+		Version:      BundleVersion,
+		ID:           uuid.New(),
+		Issuer:       s.cfg.InstanceURL,
+		IssuedAt:     now,
+		ExpiresAt:    now.Add(bundleTTL),
+		SourceUserID: userID,
+		Identity:     identity,
+		Settings: &BundleSettings{
+			Theme:                settings.Theme,
+			NotificationsEnabled: settings.NotificationsEnabled,
+			SoundEnabled:         settings.SoundEnabled,
+			CompactMode:          settings.CompactMode,
+			Settings:             settingsJSON,
+			QuietHours:           settings.QuietHours,
+		},
+		Friends: make([]BundleUser, 0, len(friends)),
+	}
+	for _, friend := range friends {
+		contents.Friends = append(contents.Friends, BundleUser{Username: friend.Username, IdentityHash: identities[friend.ID]})
+	}
+
+	if req.IncludeDMs {
+		contents.DMHistory, err = s.exportDMHistory(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := json.Marshal(contents)
+	if err != nil {
+		return nil, err
+	}
+	return &Bundle{
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+		Signature: base64.RawURLEncoding.EncodeToString(ed25519.Sign(s.cfg.SigningKey, payload)),
+	}, nil
+}
+
+// exportDMHistory pages back through each conversation, newest first, until
+// the per-conversation or overall message cap is reached
+func (s *Service) exportDMHistory(ctx context.Context, userID uuid.UUID) ([]DMConversation, error) {
+	conversations, _, err := s.dmService.ListConversations(ctx, userID, "", "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	participantIDs := make([]uuid.UUID, 0)
+	for _, convo := range conversations {
+		for _, participant := range convo.Participants {
+			participantIDs = append(participantIDs, participant.ID)
+		}
+	}
+	identities, err := s.identityHashesOf(ctx, participantIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]DMConversation, 0, len(conversations))
+	total := 0
+	for _, convo := range conversations {
+		if total >= maxDMMessages {
+			break
+		}
+
+		exported := DMConversation{
+			Participants: make([]BundleUser, 0, len(convo.Participants)),
+			Messages:     make([]DMMessage, 0),
+		}
+		for _, participant := range convo.Participants {
+			exported.Participants = append(exported.Participants, BundleUser{Username: participant.Username, IdentityHash: identities[participant.ID]})
+		}
+
+		params := &dm.GetMessagesParams{Limit: dmPageSize}
+		for {
+			page, err := s.dmService.GetMessages(ctx, convo.ID, userID, params)
+			if err != nil {
+				return nil, err
+			}
+			for _, message := range page {
+				if len(exported.Messages) >= maxDMMessagesPerConversation || total >= maxDMMessages {
+					exported.Truncated = true
+					break
+				}
+				sender := ""
+				if message.Sender != nil {
+					sender = message.Sender.Username
+				}
+				exported.Messages = append(exported.Messages, DMMessage{
+					Sender:    sender,
+					Content:   message.Content,
+					CreatedAt: message.CreatedAt,
+				})
+				total++
+			}
+			if exported.Truncated || len(page) < dmPageSize {
+				break
+			}
+			params.Before = &page[len(page)-1].ID
+		}
+
+		// Oldest first reads naturally once the history is displayed again
+		for i, j := 0, len(exported.Messages)-1; i < j; i, j = i+1, j-1 {
+			exported.Messages[i], exported.Messages[j] = exported.Messages[j], exported.Messages[i]
+		}
+		history = append(history, exported)
+	}
+	return history, nil
+}
+
+// Import verifies a bundle against its issuer's published key and applies it
+// to userID: the portable identity is linked, settings replace the current
+// ones and any DM history is kept for the user to read back. These happen in
+// one transaction. Friends who already have accounts here then get friend
+// requests. Only bundles from trusted issuers are accepted, since the bundle
+// decides which identity the account takes over.
+func (s *Service) Import(ctx context.Context, userID uuid.UUID, bundle *Bundle) (*Migration, error) {
+	if len(s.cfg.TrustedIssuers) == 0 {
+		return nil, ErrImportDisabled
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(bundle.Payload)
+	if err != nil {
+		return nil, ErrInvalidBundle
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(bundle.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return nil, ErrInvalidBundle
+	}
+
+	var contents BundleContents
+	if err := json.Unmarshal(payload, &contents); err != nil {
+		return nil, ErrInvalidBundle
+	}
+	if contents.Version != BundleVersion || contents.ID == uuid.Nil ||
+		contents.Identity == nil || contents.Identity.IdentityID == "" || contents.Settings == nil {
+		return nil, ErrInvalidBundle
+	}
+
+	issuer := strings.TrimRight(contents.Issuer, "/")
+	if issuer == s.cfg.InstanceURL {
+		return nil, ErrSameInstance
+	}
+	if !s.trusts(issuer) {
+		return nil, ErrUntrustedIssuer
+	}
+	if time.Now().After(contents.ExpiresAt) {
+		return nil, ErrBundleExpired
+	}
+
+	key, err := s.fetchIssuerKey(ctx, issuer)
+	if err != nil {
+		log.Debug().Err(err).Str("issuer", issuer).Msg("Failed to fetch account bundle issuer key")
+		return nil, ErrUntrustedIssuer
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return nil, ErrBadSignature
+	}
+
+	var dmHistory, dmNonce []byte
+	if len(contents.DMHistory) > 0 {
+		raw, err := json.Marshal(contents.DMHistory)
+		if err != nil {
+			return nil, err
+		}
+		dmHistory, dmNonce, err = s.cipher.Encrypt(historyRow(contents.ID), string(raw))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var friendIDs map[string]uuid.UUID
+	err = pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO account_migrations (bundle_id, user_id, identity_id, source_instance, source_user_id, dm_history, dm_history_nonce)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (bundle_id) DO NOTHING`,
+			contents.ID, userID, contents.Identity.IdentityID, issuer, contents.SourceUserID, dmHistory, dmNonce,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrBundleImported
+		}
+
+		if err := s.authService.LinkPortableIdentity(ctx, tx, userID, contents.Identity); err != nil {
+			return err
+		}
+		if err := s.importSettings(ctx, tx, userID, contents.Settings); err != nil {
+			return err
+		}
+		friendIDs, err = s.resolveFriends(ctx, tx, contents.Friends)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.userService.SettingsChanged(ctx, userID)
+
+	requested, unresolved := s.importFriends(ctx, userID, contents.Friends, friendIDs)
+	unresolvedJSON, err := json.Marshal(unresolved)
+	if err != nil {
+		return nil, err
+	}
+	_, err = s.db.Exec(ctx,
+		`UPDATE account_migrations SET friends_requested = $2, unresolved_friends = $3 WHERE bundle_id = $1`,
+		contents.ID, requested, unresolvedJSON,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getMigration(ctx, contents.ID, userID)
+}
+
+func (s *Service) trusts(issuer string) bool {
+	for _, trusted := range s.cfg.TrustedIssuers {
+		if trusted == issuer {
+			return true
+		}
+	}
+	return false
+}
+
+// importSettings replaces the user's settings with the bundle's. The user's
+// portable profile keys, just written by linking, are carried over since
+// settings_json is replaced wholesale.
+func (s *Service) importSettings(ctx context.Context, tx pgx.Tx, userID uuid.UUID, bundled *BundleSettings) error {
+	// Linking just wrote the settings row, so it's read through tx
+	current := &models.UserSettings{}
+	err := tx.QueryRow(ctx,
+		`SELECT theme, settings_json, quiet_hours_enabled, quiet_hours_start, quiet_hours_end, quiet_hours_timezone
+		FROM user_settings WHERE user_id = $1`,
+		userID,
+	).Scan(&current.Theme, &current.SettingsJSON, &current.QuietHours.Enabled, &current.QuietHours.StartMinute,
+		&current.QuietHours.EndMinute, &current.QuietHours.Timezone)
+	if err != nil {
+		return err
+	}
+
+	settings := map[string]interface{}{}
+	if len(bundled.Settings) > 0 {
+		if err := json.Unmarshal(bundled.Settings, &settings); err != nil {
+			return ErrInvalidBundle
+		}
+	}
+	var linkedSettings map[string]interface{}
+	if err := json.Unmarshal(current.SettingsJSON, &linkedSettings); err == nil {
+		for _, key := range portableSettingKeys {
+			delete(settings, key)
+			if value, ok := linkedSettings[key]; ok {
+				settings[key] = value
+			}
+		}
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	theme := bundled.Theme
+	if theme != "dark" && theme != "light" {
+		theme = current.Theme
+	}
+	replaced := &models.UserSettings{
+		Theme:                theme,
+		NotificationsEnabled: bundled.NotificationsEnabled,
+		SoundEnabled:         bundled.SoundEnabled,
+		CompactMode:          bundled.CompactMode,
+		SettingsJSON:         settingsJSON,
+		QuietHours:           bundled.QuietHours,
+	}
+	err = s.userService.ReplaceSettings(ctx, tx, userID, replaced)
+	if errors.Is(err, user.ErrInvalidQuietHours) || errors.Is(err, user.ErrInvalidTimezone) {
+		// The other instance may know timezones this one doesn't; keep the
+		// current quiet hours rather than failing the whole import
+		replaced.QuietHours = current.QuietHours
+		err = s.userService.ReplaceSettings(ctx, tx, userID, replaced)
+	}
+	return err
+}
+
+// resolveFriends finds the accounts here of bundled friends, by the hash of
+// their portable identity
+func (s *Service) resolveFriends(ctx context.Context, tx pgx.Tx, friends []BundleUser) (map[string]uuid.UUID, error) {
+	users := make(map[string]uuid.UUID)
+	hashes := make([]string, 0, len(friends))
+	for _, friend := range friends {
+		if friend.IdentityHash != nil && *friend.IdentityHash != "" {
+			hashes = append(hashes, *friend.IdentityHash)
+		}
+	}
+	if len(hashes) == 0 {
+		return users, nil
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT encode(sha256(convert_to(us.settings_json->>'portableIdentityId', 'UTF8')), 'hex'), u.id
+		FROM user_settings us
+		JOIN users u ON u.id = us.user_id AND u.deleted_at IS NULL
+		WHERE us.settings_json->>'portableIdentityId' IS NOT NULL
+		AND encode(sha256(convert_to(us.settings_json->>'portableIdentityId', 'UTF8')), 'hex') = ANY($1)`,
+		hashes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash string
+		var id uuid.UUID
+		if err := rows.Scan(&hash, &id); err != nil {
+			return nil, err
+		}
+		users[hash] = id
+	}
+	return users, rows.Err()
+}
+
+// importFriends sends friend requests to bundled friends whose account here
+// was resolved. It returns how many requests were sent or accepted and the
+// usernames that could not be found or asked.
+func (s *Service) importFriends(ctx context.Context, userID uuid.UUID, friends []BundleUser, users map[string]uuid.UUID) (int, []string) {
+	unresolved := make([]string, 0)
+	requested := 0
+	for _, friend := range friends {
+		var friendID uuid.UUID
+		if friend.IdentityHash != nil {
+			friendID = users[*friend.IdentityHash]
+		}
+		if friendID == uuid.Nil || friendID == userID {
+			unresolved = append(unresolved, friend.Username)
+			continue
+		}
+
+		err := s.userService.SendFriendRequest(ctx, userID, friendID)
+		if errors.Is(err, user.ErrIncomingFriendRequest) {
+			err = s.userService.AcceptFriendRequest(ctx, userID, friendID)
+		}
+		switch {
+		case err == nil:
+			requested++
+		case errors.Is(err, user.ErrAlreadyFriends), errors.Is(err, user.ErrFriendRequestExists):
+		default:
+			if !errors.Is(err, user.ErrUsersBlocked) {
+				log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to send imported friend request")
+			}
+			unresolved = append(unresolved, friend.Username)
+		}
+	}
+	return requested, unresolved
+}
+
+// fetchIssuerKey asks the issuing instance for its bundle signing key. The
+// issuer must be a public https origin and must describe itself by the same
+// URL the bundle names. The client only dials public addresses, whatever the
+// host resolves to by then.
+func (s *Service) fetchIssuerKey(ctx context.Context, issuer string) (ed25519.PublicKey, error) {
+	parsed, err := url.Parse(issuer)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, errors.New("issuer must be an https origin")
+	}
+	if err := messaging.ValidatePublicHost(ctx, parsed.Hostname()); err != nil {
+		return nil, fmt.Errorf("refusing to fetch: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/api/v1/portability/instance", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("issuer responded %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data InstanceInfo `json:"data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxInstanceInfo)).Decode(&body); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(body.Data.InstanceURL, "/") != issuer || body.Data.Algorithm != SignatureAlg {
+		return nil, errors.New("issuer describes a different instance")
+	}
+	key, err := base64.StdEncoding.DecodeString(body.Data.PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid issuer key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func (s *Service) ListImports(ctx context.Context, userID uuid.UUID) ([]*Migration, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT bundle_id, identity_id, source_instance, source_user_id, friends_requested,
+			unresolved_friends, dm_history IS NOT NULL, imported_at
+		FROM account_migrations WHERE user_id = $1
+		ORDER BY imported_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	migrations := make([]*Migration, 0)
+	for rows.Next() {
+		m, err := scanMigration(rows)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, rows.Err()
+}
+
+// GetDMHistory returns the DM history imported with a bundle
+// historyRow is the row a migration's DM history is sealed for
+func historyRow(bundleID uuid.UUID) encryption.Row {
+	return encryption.Row{Table: "account_migrations", ID: bundleID}
+}
+
+func (s *Service) GetDMHistory(ctx context.Context, bundleID, userID uuid.UUID) ([]DMConversation, error) {
+	var ciphertext, nonce []byte
+	err := s.db.QueryRow(ctx,
+		`SELECT dm_history, dm_history_nonce FROM account_migrations WHERE bundle_id = $1 AND user_id = $2`,
+		bundleID, userID,
+	).Scan(&ciphertext, &nonce)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrImportNotFound
+		}
+		return nil, err
+	}
+
+	history := make([]DMConversation, 0)
+	if ciphertext == nil {
+		return history, nil
+	}
+	plaintext, err := s.cipher.Decrypt(historyRow(bundleID), ciphertext, nonce)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(plaintext), &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (s *Service) getMigration(ctx context.Context, bundleID, userID uuid.UUID) (*Migration, error) {
+	row := s.db.QueryRow(ctx,
+		`SELECT bundle_id, identity_id, source_instance, source_user_id, friends_requested,
+			unresolved_friends, dm_history IS NOT NULL, imported_at
+		FROM account_migrations WHERE bundle_id = $1 AND user_id = $2`,
+		bundleID, userID,
+	)
+	m, err := scanMigration(row)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrImportNotFound
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+func scanMigration(row pgx.Row) (*Migration, error) {
+	m := &Migration{}
+	var unresolved []byte
+	if err := row.Scan(&m.ID, &m.IdentityID, &m.SourceInstance, &m.SourceUserID, &m.FriendsRequested,
+		&unresolved, &m.HasDMHistory, &m.ImportedAt); err != nil {
+		return nil, err
+	}
+	m.UnresolvedFriends = make([]string, 0)
+	_ = json.Unmarshal(unresolved, &m.UnresolvedFriends)
+	return m, nil
+}
+
+// identityHashesOf maps users to the SHA-256 of their portable identity
+// IDs; users without one are left out
+func (s *Service) identityHashesOf(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID]*string, error) {
+	hashes := make(map[uuid.UUID]*string)
+	if len(userIDs) == 0 {
+		return hashes, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT user_id, settings_json->>'portableIdentityId'
+		FROM user_settings
+		WHERE user_id = ANY($1) AND settings_json->>'portableIdentityId' IS NOT NULL`,
+		userIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uuid.UUID
+		var identityID string
+		if err := rows.Scan(&id, &identityID); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256([]byte(identityID))
+		hash := hex.EncodeToString(sum[:])
+		hashes[id] = &hash
+	}
+	return hashes, rows.Err()
+}
+
+func withoutPortableKeys(raw json.RawMessage) (json.RawMessage, error) {
+	settings := map[string]interface{}{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, err
+		}
+	}
+	for _, key := range portableSettingKeys {
+		delete(settings, key)
+	}
+	return json.Marshal(settings)
+}
@@ -0,0 +1,584 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+// All presence and typing state lives in Redis so any gateway instance can
+// answer "is this user online / who is typing" without sticky sessions.
+//
+//	presence:conns:<userId>  ZSET clientId -> lease expiry (unix ms)
+//	presence:online          ZSET userId   -> latest lease expiry (unix ms)
+//	presence:pref:<userId>   status the user picked while connected (away, busy, ...)
+//	presence:idle:<userId>   SET of the user's clientIds that are idle
+//	presence:user:<userId>   last published status (written by the user service)
+//	typing:<channelId>       ZSET userId   -> typing expiry (unix ms)
+const (
+	// LeaseTTL is how long a connection counts as live without a refresh.
+	LeaseTTL = 90 * time.Second
+	// RefreshInterval is how often gateways renew leases for their local connections.
+	RefreshInterval = 30 * time.Second
+
+	typingTTL      = 8 * time.Second
+	sweepLockTTL   = 25 * time.Second
+	onlineKey      = "presence:online"
+	sweepLockKey   = "presence:sweep:lock"
+	connsKeyPrefix = "presence:conns:"
+	prefKeyPrefix  = "presence:pref:"
+	idleKeyPrefix  = "presence:idle:"
+	statusPrefix   = "presence:user:"
+	typingPrefix   = "typing:"
+
+	EventTypeTypingStart = "TYPING_START"
+)
+
+// UserStore is the subset of the user service presence needs.
+type UserStore interface {
+	UpdateStatus(ctx context.Context, userID uuid.UUID, status models.UserStatus) error
+	GetPublicUser(ctx context.Context, id uuid.UUID) (*models.PublicUser, error)
+	ListNonOfflineUserIDs(ctx context.Context) ([]uuid.UUID, error)
+}
+
+type Service struct {
+	redis *redis.Client
+	users UserStore
+	// typing is where typing state goes while Redis is down
+	typing *localTyping
+}
+
+func NewService(redisClient *redis.Client, users UserStore) *Service {
+	return &Service{redis: redisClient, users: users, typing: newLocalTyping()}
+}
+
+// Connect records a new live, active connection and publishes the user's
+// status if that changed it, e.g. their first connection anywhere in the
+// cluster or an active one next to idle ones.
+func (s *Service) Connect(ctx context.Context, userID, clientID uuid.UUID) {
+	key := connsKeyPrefix + userID.String()
+
+	expiry := float64(time.Now().Add(LeaseTTL).UnixMilli())
+	pipe := s.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: expiry, Member: clientID.String()})
+	pipe.Expire(ctx, key, LeaseTTL)
+	pipe.ZAdd(ctx, onlineKey, redis.Z{Score: expiry, Member: userID.String()})
+	pipe.SRem(ctx, idleKeyPrefix+userID.String(), clientID.String())
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to record presence connection")
+		return
+	}
+
+	s.publishEffectiveStatus(ctx, userID)
+}
+
+// Disconnect drops a connection lease. When no live connection is left on any
+// instance the user goes offline and true is returned.
+func (s *Service) Disconnect(ctx context.Context, userID, clientID uuid.UUID) bool {
+	s.redis.ZRem(ctx, connsKeyPrefix+userID.String(), clientID.String())
+	s.redis.SRem(ctx, idleKeyPrefix+userID.String(), clientID.String())
+
+	live, err := s.liveConnections(ctx, userID, time.Now())
+	if err != nil {
+		log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to read presence connections")
+		return false
+	}
+	if live > 0 {
+		// The connections left may all be idle
+		s.publishEffectiveStatus(ctx, userID)
+		return false
+	}
+
+	s.redis.ZRem(ctx, onlineKey, userID.String())
+	s.publishStatus(ctx, userID, models.UserStatusOffline)
+	return true
+}
+
+// Resync writes back the connections held by this instance after Redis was
+// down and publishes each user's status again, since connects and
+// disconnects while it was down were not recorded.
+func (s *Service) Resync(ctx context.Context, conns map[uuid.UUID][]uuid.UUID) {
+	s.Refresh(ctx, conns)
+	for userID := range conns {
+		s.publishEffectiveStatus(ctx, userID)
+	}
+}
+
+// Refresh renews the leases of connections held by this instance.
+func (s *Service) Refresh(ctx context.Context, conns map[uuid.UUID][]uuid.UUID) {
+	if len(conns) == 0 {
+		return
+	}
+
+	expiry := float64(time.Now().Add(LeaseTTL).UnixMilli())
+	pipe := s.redis.Pipeline()
+	for userID, clientIDs := range conns {
+		key := connsKeyPrefix + userID.String()
+		for _, clientID := range clientIDs {
+			pipe.ZAdd(ctx, key, redis.Z{Score: expiry, Member: clientID.String()})
+		}
+		pipe.Expire(ctx, key, LeaseTTL)
+		pipe.Expire(ctx, idleKeyPrefix+userID.String(), LeaseTTL)
+		pipe.ZAdd(ctx, onlineKey, redis.Z{Score: expiry, Member: userID.String()})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to refresh presence leases")
+	}
+}
+
+// SetStatus stores the status a connected user picked and publishes it.
+func (s *Service) SetStatus(ctx context.Context, userID uuid.UUID, status models.UserStatus) {
+	if status == models.UserStatusOffline {
+		status = models.UserStatusInvisible
+	}
+	s.redis.Set(ctx, prefKeyPrefix+userID.String(), string(status), 0)
+
+	if s.IsOnline(ctx, userID) {
+		s.publishEffectiveStatus(ctx, userID)
+	}
+}
+
+// SetIdle marks one of the user's connections idle or active. A user whose
+// every connection is idle shows as away unless they picked another status.
+func (s *Service) SetIdle(ctx context.Context, userID, clientID uuid.UUID, idle bool) {
+	key := idleKeyPrefix + userID.String()
+	pipe := s.redis.TxPipeline()
+	if idle {
+		pipe.SAdd(ctx, key, clientID.String())
+	} else {
+		pipe.SRem(ctx, key, clientID.String())
+	}
+	pipe.Expire(ctx, key, LeaseTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to record idle state")
+		return
+	}
+
+	if s.IsOnline(ctx, userID) {
+		s.publishEffectiveStatus(ctx, userID)
+	}
+}
+
+// IsOnline reports whether the user has a live connection on any instance.
+func (s *Service) IsOnline(ctx context.Context, userID uuid.UUID) bool {
+	score, err := s.redis.ZScore(ctx, onlineKey, userID.String()).Result()
+	if err != nil {
+		return false
+	}
+	return int64(score) > time.Now().UnixMilli()
+}
+
+// OnlineUsers filters userIDs down to those with a live connection.
+func (s *Service) OnlineUsers(ctx context.Context, userIDs []uuid.UUID) []uuid.UUID {
+	online := make([]uuid.UUID, 0)
+	if len(userIDs) == 0 {
+		return online
+	}
+
+	members := make([]string, len(userIDs))
+	for i, id := range userIDs {
+		members[i] = id.String()
+	}
+
+	scores, err := s.redis.ZMScore(ctx, onlineKey, members...).Result()
+	if err != nil {
+		return online
+	}
+
+	now := time.Now().UnixMilli()
+	for i, score := range scores {
+		if int64(score) > now {
+			online = append(online, userIDs[i])
+		}
+	}
+	return online
+}
+
+// GetStatus returns the user's published status, or offline when they have no
+// live connection.
+func (s *Service) GetStatus(ctx context.Context, userID uuid.UUID) string {
+	if !s.IsOnline(ctx, userID) {
+		return string(models.UserStatusOffline)
+	}
+
+	status, err := s.redis.Get(ctx, statusPrefix+userID.String()).Result()
+	if err == nil {
+		if normalized, ok := NormalizeStatus(status); ok {
+			return normalized
+		}
+	}
+	return string(models.UserStatusOnline)
+}
+
+// Run periodically reaps users whose every lease expired, which is what
+// happens when a gateway instance dies without cleaning up.
+func (s *Service) Run(ctx context.Context) {
+	ticker := time.NewTicker(RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(ctx)
+		}
+	}
+}
+
+// Sweep marks users offline whose leases all expired. Only one instance sweeps
+// at a time.
+func (s *Service) Sweep(ctx context.Context) {
+	// Leases lapse while Redis is down. Give every instance a refresh to
+	// write its own back before treating them as gone.
+	if h := database.GetRedisHealth(); h.Degraded || time.Since(h.Since) < RefreshInterval {
+		return
+	}
+
+	acquired, err := s.redis.SetNX(ctx, sweepLockKey, "1", sweepLockTTL).Result()
+	if err != nil || !acquired {
+		return
+	}
+	defer s.redis.Del(ctx, sweepLockKey)
+
+	now := time.Now()
+	expired, err := s.redis.ZRangeByScore(ctx, onlineKey, &redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load expired presence entries")
+		return
+	}
+
+	for _, member := range expired {
+		userID, err := uuid.Parse(member)
+		if err != nil {
+			s.redis.ZRem(ctx, onlineKey, member)
+			continue
+		}
+
+		live, err := s.liveConnections(ctx, userID, now)
+		if err != nil || live > 0 {
+			continue
+		}
+
+		s.redis.ZRem(ctx, onlineKey, member)
+		s.publishStatus(ctx, userID, models.UserStatusOffline)
+	}
+}
+
+// Reconcile resets users that the database still thinks are online but who
+// have no live lease, e.g. after the whole cluster restarted. Unlike marking
+// everyone offline on boot, this is safe while other instances are serving.
+func (s *Service) Reconcile(ctx context.Context) error {
+	userIDs, err := s.users.ListNonOfflineUserIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	online := make(map[uuid.UUID]bool)
+	for _, id := range s.OnlineUsers(ctx, userIDs) {
+		online[id] = true
+	}
+
+	for _, userID := range userIDs {
+		if online[userID] {
+			continue
+		}
+		if err := s.users.UpdateStatus(ctx, userID, models.UserStatusOffline); err != nil {
+			log.Warn().Err(err).Str("userId", userID.String()).Msg("Failed to reset stale presence")
+		}
+	}
+	return nil
+}
+
+// StartTyping marks userID as typing in channelID and tells every instance.
+func (s *Service) StartTyping(ctx context.Context, channelID string, userID uuid.UUID) {
+	key := typingPrefix + channelID
+	pipe := s.redis.Pipeline()
+	pipe.ZAdd(ctx, key, redis.Z{
+		Score:  float64(time.Now().Add(typingTTL).UnixMilli()),
+		Member: userID.String(),
+	})
+	pipe.Expire(ctx, key, typingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		if !errors.Is(err, database.ErrRedisUnavailable) {
+			log.Error().Err(err).Str("channelId", channelID).Msg("Failed to record typing state")
+		}
+		// Only this instance will list them, but the event still goes out
+		s.typing.start(channelID, userID, time.Now().Add(typingTTL))
+	}
+
+	u, err := s.users.GetPublicUser(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to fetch user for typing event")
+		return
+	}
+
+	s.broadcast(ctx, channelID, EventTypeTypingStart, map[string]interface{}{
+		"channelId": channelID,
+		"userId":    userID.String(),
+		"user":      u,
+	})
+}
+
+// TypingUsers returns who is currently typing in channelID.
+func (s *Service) TypingUsers(ctx context.Context, channelID string) []uuid.UUID {
+	key := typingPrefix + channelID
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	s.redis.ZRemRangeByScore(ctx, key, "-inf", now)
+	members, err := s.redis.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min: "(" + now,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return s.typing.users(channelID, time.Now())
+	}
+
+	users := make([]uuid.UUID, 0, len(members))
+	for _, m := range members {
+		if id, err := uuid.Parse(m); err == nil {
+			users = append(users, id)
+		}
+	}
+	return users
+}
+
+// NormalizeStatus maps client-provided statuses (including aliases) onto the
+// statuses stored on users.
+func NormalizeStatus(rawStatus string) (string, bool) {
+	status := strings.ToLower(strings.TrimSpace(rawStatus))
+
+	switch status {
+	case "online", "away", "busy", "invisible", "offline":
+		return status, true
+	case "idle":
+		return "away", true
+	case "dnd":
+		return "busy", true
+	default:
+		return "", false
+	}
+}
+
+// PruneStale clears presence and typing state nothing will read again: expired
+// leases and typing entries in keys that were never refreshed, and the last
+// published status of users who are no longer online. It returns how many
+// entries were removed.
+func (s *Service) PruneStale(ctx context.Context) (int64, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	var removed int64
+
+	for _, pattern := range []string{connsKeyPrefix + "*", typingPrefix + "*"} {
+		err := s.scanKeys(ctx, pattern, func(keys []string) error {
+			pipe := s.redis.Pipeline()
+			results := make([]*redis.IntCmd, len(keys))
+			for i, key := range keys {
+				// Redis drops the key itself once its last member is gone
+				results[i] = pipe.ZRemRangeByScore(ctx, key, "-inf", now)
+			}
+			if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+				return err
+			}
+			for _, res := range results {
+				removed += res.Val()
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+	}
+
+	err := s.scanKeys(ctx, statusPrefix+"*", func(keys []string) error {
+		for _, key := range keys {
+			userID := strings.TrimPrefix(key, statusPrefix)
+			if err := s.redis.ZScore(ctx, onlineKey, userID).Err(); err != redis.Nil {
+				continue
+			}
+			if n, err := s.redis.Del(ctx, key).Result(); err == nil {
+				removed += n
+			}
+		}
+		return nil
+	})
+	return removed, err
+}
+
+func (s *Service) scanKeys(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		keys, next, err := s.redis.Scan(ctx, cursor, pattern, 200).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// liveConnections prunes expired leases and returns how many remain.
+func (s *Service) liveConnections(ctx context.Context, userID uuid.UUID, now time.Time) (int64, error) {
+	key := connsKeyPrefix + userID.String()
+	pipe := s.redis.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	count := pipe.ZCard(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+func (s *Service) preferredStatus(ctx context.Context, userID uuid.UUID) models.UserStatus {
+	status, err := s.redis.Get(ctx, prefKeyPrefix+userID.String()).Result()
+	if err == nil {
+		if normalized, ok := NormalizeStatus(status); ok && normalized != string(models.UserStatusOffline) {
+			return models.UserStatus(normalized)
+		}
+	}
+	return models.UserStatusOnline
+}
+
+// effectiveStatus is the status the user picked, except that online reads as
+// away while every live connection of theirs is idle
+func (s *Service) effectiveStatus(ctx context.Context, userID uuid.UUID) models.UserStatus {
+	status := s.preferredStatus(ctx, userID)
+	if status != models.UserStatusOnline {
+		return status
+	}
+
+	pipe := s.redis.Pipeline()
+	conns := pipe.ZRangeByScore(ctx, connsKeyPrefix+userID.String(), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	})
+	idle := pipe.SMembers(ctx, idleKeyPrefix+userID.String())
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return status
+	}
+	if len(conns.Val()) == 0 {
+		return status
+	}
+
+	idleConns := make(map[string]bool, len(idle.Val()))
+	for _, clientID := range idle.Val() {
+		idleConns[clientID] = true
+	}
+	for _, clientID := range conns.Val() {
+		if !idleConns[clientID] {
+			return status
+		}
+	}
+	return models.UserStatusAway
+}
+
+// publishEffectiveStatus publishes the user's effective status unless it is
+// already the published one
+func (s *Service) publishEffectiveStatus(ctx context.Context, userID uuid.UUID) {
+	status := s.effectiveStatus(ctx, userID)
+	if current, err := s.redis.Get(ctx, statusPrefix+userID.String()).Result(); err == nil && current == string(status) {
+		return
+	}
+	s.publishStatus(ctx, userID, status)
+}
+
+// publishStatus persists the status through the user service, which also
+// sends USER_UPDATE to the user and PRESENCE_UPDATE to their presence stream.
+func (s *Service) publishStatus(ctx context.Context, userID uuid.UUID, status models.UserStatus) {
+	if err := s.users.UpdateStatus(ctx, userID, status); err != nil {
+		log.Error().Err(err).Str("userId", userID.String()).Str("status", string(status)).Msg("Failed to update presence status")
+	}
+}
+
+func (s *Service) broadcast(ctx context.Context, channelID string, eventType string, data interface{}) {
+	event := struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{
+		Type: eventType,
+		Data: data,
+	}
+
+	broadcast := struct {
+		ChannelID string      `json:"channelId"`
+		Event     interface{} `json:"event"`
+	}{
+		ChannelID: channelID,
+		Event:     event,
+	}
+
+	jsonData, err := json.Marshal(broadcast)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal presence broadcast")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, jsonData); err != nil {
+		log.Error().Err(err).Str("type", eventType).Msg("Failed to publish presence broadcast")
+	}
+}
+
+// localTyping keeps typing state in memory while Redis is down
+type localTyping struct {
+	mu       sync.Mutex
+	channels map[string]map[uuid.UUID]time.Time
+}
+
+func newLocalTyping() *localTyping {
+	return &localTyping{channels: make(map[string]map[uuid.UUID]time.Time)}
+}
+
+func (t *localTyping) start(channelID string, userID uuid.UUID, expires time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Drop channels nobody is typing in anymore so the map can't grow
+	now := time.Now()
+	for id, typing := range t.channels {
+		for user, exp := range typing {
+			if now.After(exp) {
+				delete(typing, user)
+			}
+		}
+		if len(typing) == 0 {
+			delete(t.channels, id)
+		}
+	}
+
+	if t.channels[channelID] == nil {
+		t.channels[channelID] = make(map[uuid.UUID]time.Time)
+	}
+	t.channels[channelID][userID] = expires
+}
+
+func (t *localTyping) users(channelID string, now time.Time) []uuid.UUID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	users := make([]uuid.UUID, 0, len(t.channels[channelID]))
+	for userID, expires := range t.channels[channelID] {
+		if expires.After(now) {
+			users = append(users, userID)
+		}
+	}
+	return users
+}
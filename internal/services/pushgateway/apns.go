@@ -0,0 +1,144 @@
+package pushgateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Apple Push Notification service over HTTP/2, with token-based (.p8) auth.
+
+const (
+	apnsProductionURL = "https://api.push.apple.com/3/device/"
+	apnsSandboxURL    = "https://api.sandbox.push.apple.com/3/device/"
+	// Apple rejects provider tokens older than an hour and throttles ones
+	// refreshed more than every 20 minutes
+	apnsTokenRefresh = 50 * time.Minute
+)
+
+var errInvalidAPNsKey = errors.New("APNS_KEY_FILE must be an APNs .p8 auth key, with APNS_KEY_ID, APNS_TEAM_ID and APNS_TOPIC set")
+
+type apnsClient struct {
+	keyID  string
+	teamID string
+	topic  string
+	key    *ecdsa.PrivateKey
+	http   *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+func newAPNsClient(keyPEM []byte, keyID, teamID, topic string, httpClient *http.Client) (*apnsClient, error) {
+	if keyID == "" || teamID == "" || topic == "" {
+		return nil, errInvalidAPNsKey
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(keyPEM)
+	if err != nil {
+		return nil, errInvalidAPNsKey
+	}
+
+	return &apnsClient{
+		keyID:  keyID,
+		teamID: teamID,
+		topic:  topic,
+		key:    key,
+		http:   httpClient,
+	}, nil
+}
+
+// providerToken returns the signed token, reused until it is due for refresh
+func (c *apnsClient) providerToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token != "" && time.Since(c.issuedAt) < apnsTokenRefresh {
+		return c.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": c.teamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = c.keyID
+
+	signed, err := token.SignedString(c.key)
+	if err != nil {
+		return "", err
+	}
+	c.token = signed
+	c.issuedAt = now
+	return signed, nil
+}
+
+// send delivers one notification. errDeviceGone means the token should be deleted.
+func (c *apnsClient) send(ctx context.Context, deviceToken string, sandbox bool, message apnsMessage) error {
+	providerToken, err := c.providerToken()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(message.Payload)
+	if err != nil {
+		return err
+	}
+
+	endpoint := apnsProductionURL
+	if sandbox {
+		endpoint = apnsSandboxURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+deviceToken, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apns-topic", c.topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", strconv.Itoa(message.Priority))
+	req.Header.Set("apns-expiration", strconv.FormatInt(time.Now().Add(pushTTL).Unix(), 10))
+	if message.CollapseID != "" {
+		req.Header.Set("apns-collapse-id", message.CollapseID)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return nil
+	}
+
+	var result struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result)
+
+	switch {
+	case resp.StatusCode == http.StatusGone,
+		result.Reason == "BadDeviceToken",
+		result.Reason == "DeviceTokenNotForTopic",
+		result.Reason == "Unregistered":
+		return errDeviceGone
+	case result.Reason == "ExpiredProviderToken" || result.Reason == "InvalidProviderToken":
+		c.mu.Lock()
+		c.token = ""
+		c.mu.Unlock()
+	}
+	return fmt.Errorf("apns responded with %d %s", resp.StatusCode, result.Reason)
+}
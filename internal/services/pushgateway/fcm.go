@@ -0,0 +1,192 @@
+package pushgateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Firebase Cloud Messaging HTTP v1, authenticated with a service account.
+
+const (
+	fcmScope       = "https://www.googleapis.com/auth/firebase.messaging"
+	fcmSendURL     = "https://fcm.googleapis.com/v1/projects/%s/messages:send"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+	// Access tokens last an hour; refresh a little early
+	fcmTokenSlack = 5 * time.Minute
+)
+
+var errInvalidFCMCredentials = errors.New("FCM_CREDENTIALS_FILE must be a Firebase service account JSON key")
+
+// serviceAccount is the subset of a Google service account key FCM needs
+type serviceAccount struct {
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type fcmClient struct {
+	projectID string
+	email     string
+	key       *rsa.PrivateKey
+	tokenURL  string
+	http      *http.Client
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+func newFCMClient(credentials []byte, httpClient *http.Client) (*fcmClient, error) {
+	var account serviceAccount
+	if err := json.Unmarshal(credentials, &account); err != nil {
+		return nil, errInvalidFCMCredentials
+	}
+	if account.ProjectID == "" || account.ClientEmail == "" {
+		return nil, errInvalidFCMCredentials
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, errInvalidFCMCredentials
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = googleTokenURL
+	}
+
+	return &fcmClient{
+		projectID: account.ProjectID,
+		email:     account.ClientEmail,
+		key:       key,
+		tokenURL:  account.TokenURI,
+		http:      httpClient,
+	}, nil
+}
+
+// token returns a cached OAuth access token, exchanging a signed assertion
+// for a new one when it is about to expire
+func (c *fcmClient) token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.accessToken != "" && time.Now().Add(fcmTokenSlack).Before(c.expiresAt) {
+		return c.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   c.email,
+		"scope": fcmScope,
+		"aud":   c.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(c.key)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("google token endpoint responded with %d", resp.StatusCode)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", errors.New("google token endpoint returned no access token")
+	}
+
+	c.accessToken = result.AccessToken
+	c.expiresAt = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return c.accessToken, nil
+}
+
+// send delivers one message. errDeviceGone means the token should be deleted.
+func (c *fcmClient) send(ctx context.Context, message fcmMessage) error {
+	accessToken, err := c.token(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{"message": message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf(fcmSendURL, c.projectID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		return nil
+	}
+
+	var result struct {
+		Error struct {
+			Status  string `json:"status"`
+			Details []struct {
+				ErrorCode string `json:"errorCode"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result)
+
+	// INVALID_ARGUMENT can also mean a bad payload, so only UNREGISTERED drops the token
+	for _, detail := range result.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return errDeviceGone
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound || result.Error.Status == "NOT_FOUND" {
+		return errDeviceGone
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		// Force a fresh access token next time
+		c.mu.Lock()
+		c.accessToken = ""
+		c.mu.Unlock()
+	}
+	return fmt.Errorf("fcm responded with %d %s", resp.StatusCode, result.Error.Status)
+}
@@ -0,0 +1,101 @@
+package pushgateway
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Get("/", h.GetSettings)
+	r.Post("/", h.RegisterDevice)
+	r.Delete("/{deviceId}", h.DeleteDevice)
+
+	return r
+}
+
+// GET /push/devices
+func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	settings, err := h.service.GetSettings(r.Context(), userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get push devices")
+		return
+	}
+
+	utils.RespondSuccess(w, settings)
+}
+
+// POST /push/devices
+func (h *Handler) RegisterDevice(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req RegisterDeviceRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	device, err := h.service.RegisterDevice(r.Context(), userID, &req)
+	if err != nil {
+		switch err {
+		case ErrNotConfigured:
+			utils.RespondError(w, http.StatusNotImplemented, err.Error())
+		case ErrInvalidToken:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to register device")
+		}
+		return
+	}
+
+	utils.RespondCreated(w, device)
+}
+
+// DELETE /push/devices/{deviceId}
+func (h *Handler) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	deviceID, err := uuid.Parse(chi.URLParam(r, "deviceId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	if err := h.service.DeleteDevice(r.Context(), userID, deviceID); err != nil {
+		switch err {
+		case ErrNotFound:
+			utils.RespondError(w, http.StatusNotFound, "Device not found")
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to delete device")
+		}
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
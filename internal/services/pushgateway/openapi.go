@@ -0,0 +1,26 @@
+package pushgateway
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	openapi.Describe((*Handler).GetSettings, openapi.Operation{
+		Summary:  "List the current user's push devices",
+		Response: Settings{},
+	})
+	openapi.Describe((*Handler).RegisterDevice, openapi.Operation{
+		Summary:  "Register a device for push notifications",
+		Body:     RegisterDeviceRequest{},
+		Response: models.PushDevice{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).DeleteDevice, openapi.Operation{
+		Summary: "Stop sending push notifications to a device",
+		Path:    []openapi.Param{openapi.ID("deviceId")},
+		Status:  http.StatusNoContent,
+	})
+}
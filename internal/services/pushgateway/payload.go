@@ -0,0 +1,176 @@
+package pushgateway
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zentra/server/internal/models"
+)
+
+// Both services cap payloads at 4KB; bodies are cut well before that
+const maxBodyRunes = 240
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification *fcmNotification  `json:"notification,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	Android      *fcmAndroid       `json:"android,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body,omitempty"`
+}
+
+type fcmAndroid struct {
+	Priority     string                  `json:"priority"`
+	TTL          string                  `json:"ttl"`
+	CollapseKey  string                  `json:"collapse_key,omitempty"`
+	Notification *fcmAndroidNotification `json:"notification,omitempty"`
+}
+
+type fcmAndroidNotification struct {
+	// Tag replaces a shown notification with the same tag
+	Tag               string `json:"tag,omitempty"`
+	NotificationCount int64  `json:"notification_count,omitempty"`
+}
+
+type apnsMessage struct {
+	Payload    map[string]any
+	Priority   int
+	CollapseID string
+}
+
+// buildFCMMessage is the Android message for n. badge is the recipient's
+// unread count.
+func buildFCMMessage(token string, n *models.Notification, badge int64) fcmMessage {
+	priority := "HIGH"
+	if n.Priority == models.NotificationPriorityLow {
+		priority = "NORMAL"
+	}
+
+	collapse := collapseKey(n)
+	data := notificationData(n)
+	data["badge"] = badgeString(badge)
+	return fcmMessage{
+		Token: token,
+		Notification: &fcmNotification{
+			Title: n.Title,
+			Body:  notificationBody(n),
+		},
+		Data: data,
+		Android: &fcmAndroid{
+			Priority:    priority,
+			TTL:         fmt.Sprintf("%ds", int(pushTTL.Seconds())),
+			CollapseKey: collapse,
+			Notification: &fcmAndroidNotification{
+				Tag:               collapse,
+				NotificationCount: badge,
+			},
+		},
+	}
+}
+
+// buildAPNsMessage is the iOS message for n. badge is the recipient's unread
+// count.
+func buildAPNsMessage(n *models.Notification, badge int64) apnsMessage {
+	priority := 10
+	if n.Priority == models.NotificationPriorityLow {
+		priority = 5
+	}
+
+	alert := map[string]any{"title": n.Title}
+	if body := notificationBody(n); body != "" {
+		alert["body"] = body
+	}
+	aps := map[string]any{
+		"alert": alert,
+		"badge": badge,
+		"sound": "default",
+		// Lets the app's notification service extension decorate it
+		"mutable-content": 1,
+	}
+	if thread := threadID(n); thread != "" {
+		aps["thread-id"] = thread
+	}
+
+	payload := map[string]any{"aps": aps}
+	for key, value := range notificationData(n) {
+		payload[key] = value
+	}
+
+	return apnsMessage{
+		Payload:    payload,
+		Priority:   priority,
+		CollapseID: collapseKey(n),
+	}
+}
+
+// notificationData is what the app needs to open the right screen. FCM only
+// accepts string values.
+func notificationData(n *models.Notification) map[string]string {
+	data := map[string]string{
+		"notificationId": n.ID.String(),
+		"type":           string(n.Type),
+		"category":       string(n.Category),
+	}
+	if n.Route == nil {
+		return data
+	}
+	data["route"] = n.Route.Path
+	if n.Route.CommunityID != nil {
+		data["communityId"] = n.Route.CommunityID.String()
+	}
+	if n.Route.ChannelID != nil {
+		data["channelId"] = n.Route.ChannelID.String()
+	}
+	if n.Route.ConversationID != nil {
+		data["conversationId"] = n.Route.ConversationID.String()
+	}
+	if n.Route.MessageID != nil {
+		data["messageId"] = n.Route.MessageID.String()
+	}
+	return data
+}
+
+// collapseKey folds pushes from the same DM thread into one, so a burst of
+// messages shows up as the latest instead of a stack. Channel notifications
+// are kept separate since each mention matters.
+func collapseKey(n *models.Notification) string {
+	if n.Route == nil || n.Route.ConversationID == nil {
+		return ""
+	}
+	// apns-collapse-id allows 64 bytes, so the UUID goes in without dashes
+	return "dm-" + strings.ReplaceAll(n.Route.ConversationID.String(), "-", "")
+}
+
+// threadID groups notifications from the same conversation or channel on iOS
+func threadID(n *models.Notification) string {
+	if n.Route == nil {
+		return ""
+	}
+	switch {
+	case n.Route.ConversationID != nil:
+		return "dm-" + n.Route.ConversationID.String()
+	case n.Route.ChannelID != nil:
+		return "channel-" + n.Route.ChannelID.String()
+	}
+	return ""
+}
+
+func notificationBody(n *models.Notification) string {
+	if n.Body == nil {
+		return ""
+	}
+	body := strings.TrimSpace(*n.Body)
+	if runes := []rune(body); len(runes) > maxBodyRunes {
+		body = string(runes[:maxBodyRunes-1]) + "…"
+	}
+	return body
+}
+
+// badgeString is the unread count as the data payload carries it
+func badgeString(badge int64) string {
+	return strconv.FormatInt(badge, 10)
+}
@@ -0,0 +1,328 @@
+package pushgateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/notification"
+)
+
+const (
+	pushWorkers   = 4
+	pushQueueSize = 1024
+	pushTTL       = 24 * time.Hour
+	pushTimeout   = 10 * time.Second
+
+	// Oldest devices are dropped past this many per user
+	maxDevices = 10
+	// A device that keeps failing is dropped
+	maxFailures = 5
+	// Apps re-register on every launch, so a token this old is abandoned
+	staleDeviceAge = 90 * 24 * time.Hour
+)
+
+var (
+	ErrNotConfigured = errors.New("push for this platform is not configured on this server")
+	ErrInvalidToken  = errors.New("invalid device token")
+	ErrNotFound      = errors.New("device not found")
+
+	errDeviceGone = errors.New("device token is no longer valid")
+)
+
+var (
+	apnsTokenPattern = regexp.MustCompile(`^[0-9a-fA-F]{64,200}$`)
+	fcmTokenPattern  = regexp.MustCompile(`^[A-Za-z0-9_:\-]{32,512}$`)
+)
+
+// Config is where the FCM and APNs credentials live. Either platform can be
+// left unset.
+type Config struct {
+	FCMCredentialsFile string
+	APNsKeyFile        string
+	APNsKeyID          string
+	APNsTeamID         string
+	// APNsTopic is the iOS app's bundle ID
+	APNsTopic string
+}
+
+// Notifications is what the gateway needs from the notification service
+type Notifications interface {
+	PushAllowed(ctx context.Context, n *models.Notification) bool
+	GetUnreadCount(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// RegisterDeviceRequest registers a native app's push token
+type RegisterDeviceRequest struct {
+	Platform string `json:"platform" validate:"required,oneof=fcm apns"`
+	Token    string `json:"token" validate:"required,max=512"`
+	// Sandbox marks APNs tokens from development builds
+	Sandbox    bool    `json:"sandbox"`
+	DeviceName *string `json:"deviceName" validate:"omitempty,max=100"`
+	AppVersion *string `json:"appVersion" validate:"omitempty,max=50"`
+}
+
+// Settings tells the app which platforms the server can push to
+type Settings struct {
+	FCM     bool                 `json:"fcm"`
+	APNs    bool                 `json:"apns"`
+	Devices []*models.PushDevice `json:"devices"`
+}
+
+type Service struct {
+	db            *pgxpool.Pool
+	notifications Notifications
+	fcm           *fcmClient
+	apns          *apnsClient
+	queue         chan models.Notification
+}
+
+// NewService loads the configured credentials and starts the delivery
+// workers. Unreadable or invalid credentials are an error rather than a
+// silently disabled platform.
+func NewService(db *pgxpool.Pool, cfg Config, notifications Notifications) (*Service, error) {
+	s := &Service{
+		db:            db,
+		notifications: notifications,
+		queue:         make(chan models.Notification, pushQueueSize),
+	}
+	httpClient := &http.Client{Timeout: pushTimeout}
+
+	if cfg.FCMCredentialsFile != "" {
+		credentials, err := os.ReadFile(cfg.FCMCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		if s.fcm, err = newFCMClient(credentials, httpClient); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.APNsKeyFile != "" {
+		key, err := os.ReadFile(cfg.APNsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if s.apns, err = newAPNsClient(key, cfg.APNsKeyID, cfg.APNsTeamID, cfg.APNsTopic, httpClient); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < pushWorkers; i++ {
+		go s.worker()
+	}
+	return s, nil
+}
+
+// Enabled reports whether at least one platform is configured
+func (s *Service) Enabled() bool {
+	return s.fcm != nil || s.apns != nil
+}
+
+func (s *Service) platformEnabled(platform string) bool {
+	switch platform {
+	case models.PushPlatformFCM:
+		return s.fcm != nil
+	case models.PushPlatformAPNs:
+		return s.apns != nil
+	}
+	return false
+}
+
+// GetSettings returns the available platforms and the user's devices
+func (s *Service) GetSettings(ctx context.Context, userID uuid.UUID) (*Settings, error) {
+	devices, err := s.listDevices(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Settings{FCM: s.fcm != nil, APNs: s.apns != nil, Devices: devices}, nil
+}
+
+// RegisterDevice stores a device token. Registering the same token again
+// refreshes it, and moves it to this user if the app was signed in as
+// someone else.
+func (s *Service) RegisterDevice(ctx context.Context, userID uuid.UUID, req *RegisterDeviceRequest) (*models.PushDevice, error) {
+	if !s.platformEnabled(req.Platform) {
+		return nil, ErrNotConfigured
+	}
+
+	token := strings.TrimSpace(req.Token)
+	switch req.Platform {
+	case models.PushPlatformAPNs:
+		if !apnsTokenPattern.MatchString(token) {
+			return nil, ErrInvalidToken
+		}
+		token = strings.ToLower(token)
+	case models.PushPlatformFCM:
+		if !fcmTokenPattern.MatchString(token) {
+			return nil, ErrInvalidToken
+		}
+		// Sandbox only means something to APNs
+		req.Sandbox = false
+	}
+
+	device := &models.PushDevice{
+		UserID:     userID,
+		Platform:   req.Platform,
+		Token:      token,
+		Sandbox:    req.Sandbox,
+		DeviceName: trimmed(req.DeviceName),
+		AppVersion: trimmed(req.AppVersion),
+	}
+	err := s.db.QueryRow(ctx,
+		`INSERT INTO push_devices (user_id, platform, token, sandbox, device_name, app_version)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (platform, token) DO UPDATE SET
+			user_id = EXCLUDED.user_id,
+			sandbox = EXCLUDED.sandbox,
+			device_name = EXCLUDED.device_name,
+			app_version = EXCLUDED.app_version,
+			failure_count = 0,
+			updated_at = NOW()
+		RETURNING id, last_success_at, created_at, updated_at`,
+		userID, device.Platform, device.Token, device.Sandbox, device.DeviceName, device.AppVersion,
+	).Scan(&device.ID, &device.LastSuccessAt, &device.CreatedAt, &device.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.Exec(ctx,
+		`DELETE FROM push_devices WHERE id IN (
+			SELECT id FROM push_devices WHERE user_id = $1
+			ORDER BY updated_at DESC OFFSET $2
+		)`,
+		userID, maxDevices,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("userId", userID.String()).Msg("Failed to trim push devices")
+	}
+
+	return device, nil
+}
+
+// DeleteDevice removes one of the user's devices, e.g. on sign out
+func (s *Service) DeleteDevice(ctx context.Context, userID, deviceID uuid.UUID) error {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM push_devices WHERE id = $1 AND user_id = $2`,
+		deviceID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PruneStaleDevices drops tokens that haven't been refreshed in a long time
+func (s *Service) PruneStaleDevices(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM push_devices WHERE updated_at < $1`,
+		time.Now().Add(-staleDeviceAge),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (s *Service) listDevices(ctx context.Context, userID uuid.UUID) ([]*models.PushDevice, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id, user_id, platform, token, sandbox, device_name, app_version, last_success_at, created_at, updated_at
+		FROM push_devices
+		WHERE user_id = $1
+		ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	devices := []*models.PushDevice{}
+	for rows.Next() {
+		d := &models.PushDevice{}
+		if err := rows.Scan(
+			&d.ID, &d.UserID, &d.Platform, &d.Token, &d.Sandbox,
+			&d.DeviceName, &d.AppVersion, &d.LastSuccessAt, &d.CreatedAt, &d.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// Notify queues n for the recipient's devices. The notification service has
+// already decided it is worth a push; preferences are checked by the workers.
+func (s *Service) Notify(n models.Notification) {
+	if !s.Enabled() {
+		return
+	}
+	select {
+	case s.queue <- n:
+	default:
+		log.Warn().Str("userId", n.UserID.String()).Msg("Mobile push queue full, dropping push")
+	}
+}
+
+func (s *Service) worker() {
+	for n := range s.queue {
+		s.dispatch(context.Background(), n)
+	}
+}
+
+// dispatch sends n to every device of the recipient on a configured platform
+func (s *Service) dispatch(ctx context.Context, n models.Notification) {
+	if !s.notifications.PushAllowed(ctx, &n) {
+		return
+	}
+
+	devices, err := s.listDevices(ctx, n.UserID)
+	if err != nil {
+		log.Error().Err(err).Str("userId", n.UserID.String()).Msg("Failed to load push devices")
+		return
+	}
+	if len(devices) == 0 {
+		return
+	}
+
+	// The badge is the unread count, which already includes n
+	badge, err := s.notifications.GetUnreadCount(ctx, n.UserID)
+	if err != nil {
+		log.Warn().Err(err).Str("userId", n.UserID.String()).Msg("Failed to load unread count for badge")
+		badge = 0
+	}
+
+	for _, device := range devices {
+		var sendErr error
+		switch {
+		case device.Platform == models.PushPlatformFCM && s.fcm != nil:
+			sendErr = s.fcm.send(ctx, buildFCMMessage(device.Token, &n, badge))
+		case device.Platform == models.PushPlatformAPNs && s.apns != nil:
+			sendErr = s.apns.send(ctx, device.Token, device.Sandbox, buildAPNsMessage(&n, badge))
+		default:
+			continue
+		}
+		notification.RecordPushResult(ctx, s.db, "push_devices", device.ID, sendErr, errors.Is(sendErr, errDeviceGone), maxFailures)
+	}
+}
+
+func trimmed(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	v := strings.TrimSpace(*value)
+	if v == "" {
+		return nil
+	}
+	return &v
+}
@@ -0,0 +1,41 @@
+package quicksearch
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+// Search backs the Ctrl+K switcher. It is mounted under /users/me so it sits
+// with the rest of the current-user routes.
+func (h *Handler) Search(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	query := r.URL.Query().Get("q")
+	if len(query) > 100 {
+		utils.RespondError(w, http.StatusBadRequest, "Query too long")
+		return
+	}
+	limit := utils.GetQueryInt(r, "limit", DefaultLimit)
+
+	results, err := h.service.Search(r.Context(), userID, query, limit)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to search")
+		return
+	}
+
+	utils.RespondSuccess(w, results)
+}
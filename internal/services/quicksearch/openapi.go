@@ -0,0 +1,14 @@
+package quicksearch
+
+import "github.com/zentra/server/internal/openapi"
+
+func init() {
+	openapi.Describe((*Handler).Search, openapi.Operation{
+		Summary: "Find communities, channels, conversations and people to switch to",
+		Query: []openapi.Param{
+			{Name: "q", Description: "A leading # finds only channels, @ only people and * only communities; empty for recent places"},
+			{Name: "limit", Type: "integer", Description: "At most this many results, 20 by default and 50 at most"},
+		},
+		Response: []*Result{},
+	})
+}
@@ -0,0 +1,367 @@
+package quicksearch
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/recency"
+)
+
+const (
+	ResultTypeChannel   = "channel"
+	ResultTypeDM        = "dm"
+	ResultTypeCommunity = "community"
+	ResultTypeUser      = "user"
+
+	DefaultLimit = 20
+	MaxLimit     = 50
+
+	// candidateLimit bounds how many rows of each kind are pulled before ranking
+	candidateLimit = 50
+	// recencyHalfLife is how quickly the boost from a past interaction fades
+	recencyHalfLife = 3 * 24 * time.Hour
+)
+
+type Result struct {
+	Type              string     `json:"type"`
+	ID                uuid.UUID  `json:"id"`
+	Name              string     `json:"name"`
+	IconURL           *string    `json:"iconUrl,omitempty"`
+	CommunityID       *uuid.UUID `json:"communityId,omitempty"`
+	CommunityName     *string    `json:"communityName,omitempty"`
+	ChannelType       string     `json:"channelType,omitempty"`
+	UserID            *uuid.UUID `json:"userId,omitempty"`
+	LastInteractionAt *time.Time `json:"lastInteractionAt,omitempty"`
+
+	score float64
+}
+
+type ChannelServiceInterface interface {
+	CanAccessChannel(ctx context.Context, channelID, userID uuid.UUID) bool
+}
+
+type Service struct {
+	db             *pgxpool.Pool
+	channelService ChannelServiceInterface
+	recency        *recency.Service
+}
+
+func NewService(db *pgxpool.Pool, channelService ChannelServiceInterface, recencyService *recency.Service) *Service {
+	return &Service{
+		db:             db,
+		channelService: channelService,
+		recency:        recencyService,
+	}
+}
+
+// Search returns channels, DMs, communities and users matching query, ranked
+// by how well the name matches and how recently the user interacted with it.
+// A leading # limits results to channels, @ to people and * to communities.
+// An empty query returns the user's most recent destinations.
+func (s *Service) Search(ctx context.Context, userID uuid.UUID, query string, limit int) ([]*Result, error) {
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+
+	query = strings.TrimSpace(query)
+	only := ""
+	if query != "" {
+		switch query[0] {
+		case '#':
+			only = ResultTypeChannel
+		case '@':
+			only = ResultTypeUser
+		case '*':
+			only = ResultTypeCommunity
+		}
+		if only != "" {
+			query = strings.TrimSpace(query[1:])
+		}
+	}
+	needle := strings.ToLower(query)
+
+	recentTimes, recentOrder, err := s.recency.Recent(ctx, userID, 0)
+	if err != nil {
+		// Ranking still works on name match alone
+		log.Warn().Err(err).Str("userId", userID.String()).Msg("Quick search could not load recency")
+		recentTimes = map[recency.Ref]time.Time{}
+	}
+
+	// With no query only recently used items are candidates
+	var recentIDs map[recency.Kind][]uuid.UUID
+	if needle == "" {
+		recentIDs = make(map[recency.Kind][]uuid.UUID)
+		for _, ref := range recentOrder {
+			recentIDs[ref.Kind] = append(recentIDs[ref.Kind], ref.ID)
+		}
+	}
+
+	var results []*Result
+	want := func(kind string) bool { return only == "" || only == kind }
+
+	if want(ResultTypeChannel) {
+		channels, err := s.searchChannels(ctx, userID, needle, recentIDs)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, channels...)
+	}
+	if want(ResultTypeCommunity) {
+		communities, err := s.searchCommunities(ctx, userID, needle, recentIDs)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, communities...)
+	}
+	if want(ResultTypeUser) {
+		people, err := s.searchPeople(ctx, userID, needle, recentIDs)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, people...)
+	}
+
+	now := time.Now()
+	for _, result := range results {
+		// Stored read-state times and the Redis store can each be ahead of the other
+		kind := recency.Kind(result.Type)
+		if at, ok := recentTimes[recency.Ref{Kind: kind, ID: result.ID}]; ok {
+			if result.LastInteractionAt == nil || at.After(*result.LastInteractionAt) {
+				result.LastInteractionAt = &at
+			}
+		}
+		// A friend with a DM is ranked by whichever was used last
+		if result.UserID != nil {
+			if at, ok := recentTimes[recency.Ref{Kind: recency.KindUser, ID: *result.UserID}]; ok {
+				if result.LastInteractionAt == nil || at.After(*result.LastInteractionAt) {
+					result.LastInteractionAt = &at
+				}
+			}
+		}
+		result.score = matchScore(result.Name, needle) + recencyScore(result.LastInteractionAt, now)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return strings.ToLower(results[i].Name) < strings.ToLower(results[j].Name)
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	if results == nil {
+		results = []*Result{}
+	}
+	return results, nil
+}
+
+func (s *Service) searchChannels(ctx context.Context, userID uuid.UUID, needle string, recentIDs map[recency.Kind][]uuid.UUID) ([]*Result, error) {
+	query := `SELECT ch.id, ch.name, ch.type::text, c.id, c.name, c.icon_url, rs.last_interaction_at
+		FROM channels ch
+		JOIN communities c ON c.id = ch.community_id AND c.deleted_at IS NULL
+		JOIN community_members m ON m.community_id = c.id AND m.user_id = $1
+		LEFT JOIN channel_read_states rs ON rs.channel_id = ch.id AND rs.user_id = $1
+		WHERE ch.type::text <> 'category'`
+	args := []interface{}{userID}
+	if recentIDs != nil {
+		query += ` AND (ch.id = ANY($2) OR rs.last_interaction_at IS NOT NULL)`
+		args = append(args, nonNil(recentIDs[recency.KindChannel]))
+	} else {
+		query += ` AND ch.name ILIKE $2`
+		args = append(args, likePattern(needle))
+	}
+	query += ` ORDER BY rs.last_interaction_at DESC NULLS LAST, ch.last_message_at DESC NULLS LAST LIMIT $3`
+	args = append(args, candidateLimit)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []*Result
+	for rows.Next() {
+		var communityID uuid.UUID
+		var communityName string
+		r := &Result{Type: ResultTypeChannel, CommunityID: &communityID, CommunityName: &communityName}
+		if err := rows.Scan(&r.ID, &r.Name, &r.ChannelType, &communityID, &communityName, &r.IconURL, &r.LastInteractionAt); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Channel overwrites can hide channels from members, so check each one
+	results := make([]*Result, 0, len(candidates))
+	for _, r := range candidates {
+		if s.channelService.CanAccessChannel(ctx, r.ID, userID) {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func (s *Service) searchCommunities(ctx context.Context, userID uuid.UUID, needle string, recentIDs map[recency.Kind][]uuid.UUID) ([]*Result, error) {
+	query := `SELECT c.id, c.name, c.icon_url
+		FROM communities c
+		JOIN community_members m ON m.community_id = c.id AND m.user_id = $1
+		WHERE c.deleted_at IS NULL`
+	args := []interface{}{userID}
+	if recentIDs != nil {
+		query += ` AND c.id = ANY($2)`
+		args = append(args, nonNil(recentIDs[recency.KindCommunity]))
+	} else {
+		query += ` AND c.name ILIKE $2`
+		args = append(args, likePattern(needle))
+	}
+	query += ` ORDER BY m.joined_at DESC LIMIT $3`
+	args = append(args, candidateLimit)
+
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*Result
+	for rows.Next() {
+		r := &Result{Type: ResultTypeCommunity}
+		if err := rows.Scan(&r.ID, &r.Name, &r.IconURL); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// searchPeople returns DM conversations and friends. Someone who has both is
+// returned once, as their DM, since that is where the switcher takes you.
+func (s *Service) searchPeople(ctx context.Context, userID uuid.UUID, needle string, recentIDs map[recency.Kind][]uuid.UUID) ([]*Result, error) {
+	dmQuery := `SELECT me.conversation_id, u.id, COALESCE(NULLIF(u.display_name, ''), u.username), u.avatar_url, me.last_interaction_at
+		FROM dm_participants me
+		JOIN dm_participants other ON other.conversation_id = me.conversation_id AND other.user_id <> me.user_id
+		JOIN users u ON u.id = other.user_id AND u.deleted_at IS NULL
+		WHERE me.user_id = $1`
+	dmArgs := []interface{}{userID}
+	if recentIDs != nil {
+		dmQuery += ` AND (me.conversation_id = ANY($2) OR u.id = ANY($3) OR me.last_interaction_at IS NOT NULL)`
+		dmArgs = append(dmArgs, nonNil(recentIDs[recency.KindDM]), nonNil(recentIDs[recency.KindUser]))
+	} else {
+		dmQuery += ` AND (u.username ILIKE $2 OR u.display_name ILIKE $2)`
+		dmArgs = append(dmArgs, likePattern(needle))
+	}
+	dmQuery += ` ORDER BY me.last_interaction_at DESC NULLS LAST LIMIT ` + strconv.Itoa(candidateLimit)
+
+	rows, err := s.db.Query(ctx, dmQuery, dmArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*Result
+	seenUsers := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var otherID uuid.UUID
+		r := &Result{Type: ResultTypeDM, UserID: &otherID}
+		if err := rows.Scan(&r.ID, &otherID, &r.Name, &r.IconURL, &r.LastInteractionAt); err != nil {
+			return nil, err
+		}
+		seenUsers[otherID] = true
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	friendQuery := `SELECT u.id, COALESCE(NULLIF(u.display_name, ''), u.username), u.avatar_url
+		FROM user_friendships f
+		JOIN users u ON u.id = f.friend_id AND u.deleted_at IS NULL
+		WHERE f.user_id = $1`
+	friendArgs := []interface{}{userID}
+	if recentIDs != nil {
+		friendQuery += ` AND u.id = ANY($2)`
+		friendArgs = append(friendArgs, nonNil(recentIDs[recency.KindUser]))
+	} else {
+		friendQuery += ` AND (u.username ILIKE $2 OR u.display_name ILIKE $2)`
+		friendArgs = append(friendArgs, likePattern(needle))
+	}
+	friendQuery += ` LIMIT ` + strconv.Itoa(candidateLimit)
+
+	friendRows, err := s.db.Query(ctx, friendQuery, friendArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer friendRows.Close()
+
+	for friendRows.Next() {
+		r := &Result{Type: ResultTypeUser}
+		if err := friendRows.Scan(&r.ID, &r.Name, &r.IconURL); err != nil {
+			return nil, err
+		}
+		if seenUsers[r.ID] {
+			continue
+		}
+		id := r.ID
+		r.UserID = &id
+		results = append(results, r)
+	}
+	return results, friendRows.Err()
+}
+
+// matchScore ranks an exact name above a prefix match above a word-start match
+// above a plain substring; with no query every name matches equally.
+func matchScore(name, needle string) float64 {
+	if needle == "" {
+		return 0
+	}
+	name = strings.ToLower(name)
+	switch {
+	case name == needle:
+		return 4
+	case strings.HasPrefix(name, needle):
+		return 3
+	case strings.Contains(name, " "+needle), strings.Contains(name, "-"+needle), strings.Contains(name, "_"+needle):
+		return 2
+	case strings.Contains(name, needle):
+		return 1
+	}
+	return 0
+}
+
+// recencyScore is worth up to 3 points for something used just now, halving
+// every recencyHalfLife, so a recent partial match can beat a stale exact one.
+func recencyScore(at *time.Time, now time.Time) float64 {
+	if at == nil {
+		return 0
+	}
+	age := now.Sub(*at)
+	if age < 0 {
+		age = 0
+	}
+	return 3 * math.Pow(0.5, float64(age)/float64(recencyHalfLife))
+}
+
+func likePattern(needle string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(needle) + "%"
+}
+
+func nonNil(ids []uuid.UUID) []uuid.UUID {
+	if ids == nil {
+		return []uuid.UUID{}
+	}
+	return ids
+}
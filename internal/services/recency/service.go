@@ -0,0 +1,117 @@
+package recency
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Each user has one sorted set of the things they recently interacted with:
+//
+//	recent:<userId>  ZSET "<kind>:<id>" -> last interaction (unix ms)
+//
+// It only feeds ranking (quick switcher, DM ordering), so losing it is harmless
+// and entries are capped and expire instead of being stored in Postgres.
+const (
+	keyPrefix  = "recent:"
+	maxEntries = 200
+	entryTTL   = 90 * 24 * time.Hour
+)
+
+// Kind is the type of thing a user interacted with.
+type Kind string
+
+const (
+	KindChannel   Kind = "channel"
+	KindDM        Kind = "dm"
+	KindCommunity Kind = "community"
+	KindUser      Kind = "user"
+)
+
+// Ref identifies one item in a user's recency set.
+type Ref struct {
+	Kind Kind
+	ID   uuid.UUID
+}
+
+func (r Ref) member() string {
+	return string(r.Kind) + ":" + r.ID.String()
+}
+
+func parseMember(member string) (Ref, bool) {
+	kind, id, ok := strings.Cut(member, ":")
+	if !ok {
+		return Ref{}, false
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return Ref{}, false
+	}
+	return Ref{Kind: Kind(kind), ID: parsed}, true
+}
+
+type Service struct {
+	redis *redis.Client
+}
+
+func NewService(redisClient *redis.Client) *Service {
+	return &Service{redis: redisClient}
+}
+
+// Touch records that the user interacted with refs just now. Failures are
+// logged and swallowed; callers should never fail a request over ranking data.
+func (s *Service) Touch(ctx context.Context, userID uuid.UUID, refs ...Ref) {
+	if len(refs) == 0 {
+		return
+	}
+
+	key := keyPrefix + userID.String()
+	score := float64(time.Now().UnixMilli())
+	members := make([]redis.Z, 0, len(refs))
+	for _, ref := range refs {
+		members = append(members, redis.Z{Score: score, Member: ref.member()})
+	}
+
+	pipe := s.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, members...)
+	// Keep only the newest maxEntries
+	pipe.ZRemRangeByRank(ctx, key, 0, -maxEntries-1)
+	pipe.Expire(ctx, key, entryTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to record interaction recency")
+	}
+}
+
+// Recent returns the user's most recent interactions, both as a lookup map and
+// ordered newest first. A limit <= 0 returns everything stored.
+func (s *Service) Recent(ctx context.Context, userID uuid.UUID, limit int) (map[Ref]time.Time, []Ref, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(limit - 1)
+	}
+
+	entries, err := s.redis.ZRevRangeWithScores(ctx, keyPrefix+userID.String(), 0, stop).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	times := make(map[Ref]time.Time, len(entries))
+	ordered := make([]Ref, 0, len(entries))
+	for _, entry := range entries {
+		member, ok := entry.Member.(string)
+		if !ok {
+			continue
+		}
+		ref, ok := parseMember(member)
+		if !ok {
+			continue
+		}
+		times[ref] = time.UnixMilli(int64(entry.Score))
+		ordered = append(ordered, ref)
+	}
+	return times, ordered, nil
+}
@@ -0,0 +1,115 @@
+// Package searchindex fills in the search tokens of channel messages written
+// before messages were indexed, or re-indexes them after SEARCH_TOKEN_KEY
+// changes.
+package searchindex
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+const DefaultBatch = 500
+
+// Result counts what a backfill did
+type Result struct {
+	Scanned int
+	Indexed int
+	// Content no configured key could open. It is left unindexed, so a later
+	// backfill picks it up once it has been repaired.
+	Unreadable int
+	DryRun     bool
+}
+
+type Service struct {
+	db     *pgxpool.Pool
+	cipher messaging.ContentCipher
+	search *messaging.SearchIndex
+}
+
+// NewService indexes with search, which must use the gateway's key
+func NewService(db *pgxpool.Pool, keys *encryption.Keyring, search *messaging.SearchIndex) *Service {
+	return &Service{db: db, cipher: messaging.NewChannelCipher(keys), search: search}
+}
+
+// Backfill walks messages in creation order and stores the search tokens of
+// the ones that have none. With reindex every message is indexed again.
+// channelID limits it to one channel. progress, when set, is called after
+// each batch.
+func (s *Service) Backfill(ctx context.Context, batchSize int, reindex bool, channelID *uuid.UUID, dryRun bool, progress func(*Result)) (*Result, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultBatch
+	}
+
+	type row struct {
+		id         uuid.UUID
+		channelID  uuid.UUID
+		ciphertext []byte
+		createdAt  time.Time
+	}
+
+	result := &Result{DryRun: dryRun}
+	var afterTime time.Time
+	afterID := uuid.Nil
+
+	for {
+		rows, err := s.db.Query(ctx,
+			`SELECT id, channel_id, encrypted_content, created_at FROM messages
+			 WHERE encrypted_content IS NOT NULL AND deleted_at IS NULL AND (created_at, id) > ($1, $2)
+			   AND ($4 OR search_tokens IS NULL)
+			   AND ($5::uuid IS NULL OR channel_id = $5)
+			 ORDER BY created_at, id
+			 LIMIT $3`,
+			afterTime, afterID, batchSize, reindex, channelID,
+		)
+		if err != nil {
+			return result, err
+		}
+
+		var batch []row
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.id, &r.channelID, &r.ciphertext, &r.createdAt); err != nil {
+				rows.Close()
+				return result, err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			return result, nil
+		}
+
+		for _, r := range batch {
+			result.Scanned++
+			content, err := s.cipher.Decrypt(messaging.ChannelRow(r.id), r.ciphertext, nil)
+			if err != nil {
+				result.Unreadable++
+				continue
+			}
+			result.Indexed++
+			if dryRun {
+				continue
+			}
+			if _, err := s.db.Exec(ctx,
+				`UPDATE messages SET search_tokens = $3 WHERE id = $1 AND created_at = $2`,
+				r.id, r.createdAt, s.search.Tokens(r.channelID, content),
+			); err != nil {
+				return result, err
+			}
+		}
+
+		last := batch[len(batch)-1]
+		afterTime, afterID = last.createdAt, last.id
+		if progress != nil {
+			progress(result)
+		}
+	}
+}
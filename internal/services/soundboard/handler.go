@@ -0,0 +1,282 @@
+package soundboard
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/media"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	// A community's clips
+	r.Get("/communities/{communityId}", h.ListSounds)
+	r.Post("/communities/{communityId}", h.CreateSound)
+	r.Patch("/{soundId}", h.UpdateSound)
+	r.Delete("/{soundId}", h.DeleteSound)
+
+	// Voice channels
+	r.Route("/channels/{channelId}", func(r chi.Router) {
+		r.Get("/", h.GetChannelSettings)
+		r.Put("/", h.UpdateChannelSettings)
+		r.Post("/play/{soundId}", h.PlaySound)
+	})
+
+	return r
+}
+
+func (h *Handler) ListSounds(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	sounds, err := h.service.ListSounds(r.Context(), communityID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to fetch sounds")
+		return
+	}
+
+	utils.RespondSuccess(w, sounds)
+}
+
+// CreateSound uploads a clip as multipart form data: the "sound" file, a
+// "name", and optionally an "emoji" and a "volume" between 0 and 1
+func (h *Handler) CreateSound(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	communityID, err := uuid.Parse(chi.URLParam(r, "communityId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid community ID")
+		return
+	}
+
+	// Limit upload size
+	r.Body = http.MaxBytesReader(w, r.Body, media.MaxSoundClipSize+4096) // extra room for form fields
+	if err := r.ParseMultipartForm(media.MaxSoundClipSize + 4096); err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Request too large")
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		utils.RespondError(w, http.StatusBadRequest, "Name is required")
+		return
+	}
+
+	var emoji *string
+	if e := r.FormValue("emoji"); e != "" {
+		if len(e) > 64 {
+			utils.RespondError(w, http.StatusBadRequest, "Emoji is too long")
+			return
+		}
+		emoji = &e
+	}
+
+	volume := 1.0
+	if v := r.FormValue("volume"); v != "" {
+		volume, err = strconv.ParseFloat(v, 64)
+		if err != nil || volume < 0 || volume > 1 {
+			utils.RespondError(w, http.StatusBadRequest, "Volume must be between 0 and 1")
+			return
+		}
+	}
+
+	file, header, err := r.FormFile("sound")
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Sound file is required")
+		return
+	}
+	defer file.Close()
+
+	sound, err := h.service.CreateSound(r.Context(), communityID, userID, name, emoji, volume, file, header)
+	if err != nil {
+		respondError(w, err, "Failed to create sound")
+		return
+	}
+
+	utils.RespondCreated(w, sound)
+}
+
+func (h *Handler) UpdateSound(w http.ResponseWriter, r *http.Request) {
+	userID, soundID, ok := soundParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateSoundRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	sound, err := h.service.UpdateSound(r.Context(), soundID, userID, &req)
+	if err != nil {
+		respondError(w, err, "Failed to update sound")
+		return
+	}
+
+	utils.RespondSuccess(w, sound)
+}
+
+func (h *Handler) DeleteSound(w http.ResponseWriter, r *http.Request) {
+	userID, soundID, ok := soundParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteSound(r.Context(), soundID, userID); err != nil {
+		respondError(w, err, "Failed to delete sound")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// ChannelSettings is whether a voice channel has the soundboard on
+type ChannelSettings struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (h *Handler) GetChannelSettings(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	enabled, err := h.service.ChannelEnabled(r.Context(), channelID, userID)
+	if err != nil {
+		respondError(w, err, "Failed to fetch soundboard settings")
+		return
+	}
+
+	utils.RespondSuccess(w, ChannelSettings{Enabled: enabled})
+}
+
+func (h *Handler) UpdateChannelSettings(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChannelSettings
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	if err := h.service.SetChannelEnabled(r.Context(), channelID, userID, req.Enabled); err != nil {
+		respondError(w, err, "Failed to update soundboard settings")
+		return
+	}
+
+	utils.RespondSuccess(w, req)
+}
+
+// PlaySound plays a clip to everyone in the caller's voice channel
+func (h *Handler) PlaySound(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	soundID, err := uuid.Parse(chi.URLParam(r, "soundId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid sound ID")
+		return
+	}
+
+	if err := h.service.PlaySound(r.Context(), channelID, soundID, userID); err != nil {
+		respondError(w, err, "Failed to play sound")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func soundParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	soundID, err := uuid.Parse(chi.URLParam(r, "soundId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid sound ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, soundID, true
+}
+
+func channelParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, channelID, true
+}
+
+func respondError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrSoundNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Sound not found")
+	case errors.Is(err, channel.ErrChannelNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Channel not found")
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrTooManySounds), errors.Is(err, ErrNotVoiceChannel):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, media.ErrInvalidFileType):
+		utils.RespondError(w, http.StatusBadRequest, "Sound must be an MP3, OGG, WAV or WebM audio file")
+	case errors.Is(err, media.ErrFileTooLarge):
+		utils.RespondError(w, http.StatusBadRequest, "Sound must be under 512KB")
+	case errors.Is(err, ErrNameTaken):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrNotMember):
+		utils.RespondError(w, http.StatusForbidden, "Not a member of this community")
+	case errors.Is(err, ErrSoundboardOff):
+		utils.RespondErrorWithCode(w, http.StatusForbidden, "SOUNDBOARD_DISABLED", err.Error())
+	case errors.Is(err, ErrNotInChannel):
+		utils.RespondErrorWithCode(w, http.StatusConflict, "NOT_IN_VOICE_CHANNEL", err.Error())
+	case errors.Is(err, ErrCannotSpeak):
+		utils.RespondErrorWithCode(w, http.StatusForbidden, "CANNOT_SPEAK", err.Error())
+	case errors.Is(err, ErrRateLimited):
+		utils.RespondRateLimited(w, "SOUNDBOARD_RATE_LIMITED", "You're playing sounds too quickly", PlayRateWindow)
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
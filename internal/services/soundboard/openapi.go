@@ -0,0 +1,58 @@
+package soundboard
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	communityID := []openapi.Param{openapi.ID("communityId")}
+	soundID := []openapi.Param{openapi.ID("soundId")}
+	channelID := []openapi.Param{openapi.ID("channelId")}
+
+	openapi.Describe((*Handler).ListSounds, openapi.Operation{
+		Summary:  "List a community's sounds",
+		Path:     communityID,
+		Response: []*Sound{},
+	})
+	openapi.Describe((*Handler).CreateSound, openapi.Operation{
+		Summary: "Upload a sound",
+		Path:    communityID,
+		Upload:  "sound",
+		Form: []openapi.Param{
+			{Name: "name", Required: true},
+			{Name: "emoji"},
+			{Name: "volume", Type: "number", Description: "From 0 to 1, 1 by default"},
+		},
+		Response: Sound{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).UpdateSound, openapi.Operation{
+		Summary:  "Update a sound",
+		Path:     soundID,
+		Body:     UpdateSoundRequest{},
+		Response: Sound{},
+	})
+	openapi.Describe((*Handler).DeleteSound, openapi.Operation{
+		Summary: "Delete a sound",
+		Path:    soundID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetChannelSettings, openapi.Operation{
+		Summary:  "Get whether a voice channel has the soundboard on",
+		Path:     channelID,
+		Response: ChannelSettings{},
+	})
+	openapi.Describe((*Handler).UpdateChannelSettings, openapi.Operation{
+		Summary:  "Turn the soundboard on or off in a voice channel",
+		Path:     channelID,
+		Body:     ChannelSettings{},
+		Response: ChannelSettings{},
+	})
+	openapi.Describe((*Handler).PlaySound, openapi.Operation{
+		Summary: "Play a sound to everyone in the voice channel",
+		Path:    append(channelID, openapi.ID("soundId")),
+		Status:  http.StatusNoContent,
+	})
+}
@@ -0,0 +1,418 @@
+package soundboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/community"
+	"github.com/zentra/server/internal/services/media"
+	"github.com/zentra/server/internal/services/voice"
+	"github.com/zentra/server/pkg/database"
+)
+
+// EventTypeVoiceSound is sent on a voice channel's stream when a member plays
+// a clip, for clients connected to the channel to play it too
+const EventTypeVoiceSound = "VOICE_SOUND"
+
+const (
+	MaxSoundsPerCommunity = 48
+
+	// Clips a member can play per window, and all members of a channel
+	// together
+	userPlayLimit    = 3
+	channelPlayLimit = 10
+	PlayRateWindow   = 10 * time.Second
+)
+
+var soundNameRegex = regexp.MustCompile(`^[\p{L}\p{N}_ '\-]{2,32}$`)
+
+var (
+	ErrSoundNotFound     = errors.New("sound not found")
+	ErrInvalidName       = errors.New("sound name must be 2-32 letters, digits, spaces, dashes or underscores")
+	ErrNameTaken         = errors.New("a sound with that name already exists in this community")
+	ErrTooManySounds     = errors.New("community has reached the sound limit")
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrNotMember         = errors.New("user is not a member of this community")
+	ErrNotVoiceChannel   = errors.New("channel is not a voice channel")
+	ErrSoundboardOff     = errors.New("the soundboard is turned off in this channel")
+	ErrNotInChannel      = errors.New("you must be connected to the voice channel to play sounds")
+	ErrCannotSpeak       = errors.New("you can't play sounds while muted or deafened")
+	ErrRateLimited       = errors.New("playing sounds too quickly")
+)
+
+// Sound is a clip on a community's soundboard
+type Sound struct {
+	ID          uuid.UUID  `json:"id"`
+	CommunityID uuid.UUID  `json:"communityId"`
+	Name        string     `json:"name"`
+	Emoji       *string    `json:"emoji,omitempty"`
+	URL         string     `json:"url"`
+	Volume      float64    `json:"volume"`
+	UploaderID  *uuid.UUID `json:"uploaderId,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+type UpdateSoundRequest struct {
+	Name   *string  `json:"name"`
+	Emoji  *string  `json:"emoji" validate:"omitempty,max=64"`
+	Volume *float64 `json:"volume" validate:"omitempty,min=0,max=1"`
+}
+
+type Service struct {
+	db               *pgxpool.Pool
+	mediaService     *media.Service
+	channelService   *channel.Service
+	communityService *community.Service
+	voiceService     *voice.Service
+}
+
+func NewService(db *pgxpool.Pool, mediaService *media.Service, channelService *channel.Service, communityService *community.Service, voiceService *voice.Service) *Service {
+	return &Service{
+		db:               db,
+		mediaService:     mediaService,
+		channelService:   channelService,
+		communityService: communityService,
+		voiceService:     voiceService,
+	}
+}
+
+// ListSounds returns a community's soundboard, oldest first
+func (s *Service) ListSounds(ctx context.Context, communityID, userID uuid.UUID) ([]*Sound, error) {
+	if !s.communityService.IsMember(ctx, communityID, userID) {
+		return nil, ErrNotMember
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT `+soundColumns+` FROM soundboard_sounds WHERE community_id = $1 ORDER BY created_at`,
+		communityID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sounds := []*Sound{}
+	for rows.Next() {
+		sound, err := scanSound(rows)
+		if err != nil {
+			return nil, err
+		}
+		sounds = append(sounds, sound)
+	}
+	return sounds, rows.Err()
+}
+
+// CreateSound uploads a clip to a community's soundboard through the media
+// service. Needs the Manage Emojis permission, as for other expressions.
+func (s *Service) CreateSound(ctx context.Context, communityID, userID uuid.UUID, name string, emoji *string, volume float64, file multipart.File, header *multipart.FileHeader) (*Sound, error) {
+	if err := s.requireManage(ctx, communityID, userID); err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if !soundNameRegex.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+
+	var count int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM soundboard_sounds WHERE community_id = $1`, communityID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count sounds: %w", err)
+	}
+	if count >= MaxSoundsPerCommunity {
+		return nil, ErrTooManySounds
+	}
+	if taken, err := s.nameTaken(ctx, communityID, uuid.Nil, name); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrNameTaken
+	}
+
+	now := time.Now()
+	sound := &Sound{
+		ID:          uuid.New(),
+		CommunityID: communityID,
+		Name:        name,
+		Emoji:       emoji,
+		Volume:      volume,
+		UploaderID:  &userID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	url, err := s.mediaService.UploadSoundClip(ctx, communityID, sound.ID, file, header)
+	if err != nil {
+		return nil, err
+	}
+	sound.URL = url
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO soundboard_sounds (id, community_id, name, emoji, url, volume, uploader_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)`,
+		sound.ID, sound.CommunityID, sound.Name, sound.Emoji, sound.URL, sound.Volume, sound.UploaderID, now,
+	)
+	if err != nil {
+		// Don't leave the clip behind if the row can't be saved
+		_ = s.mediaService.DeleteSoundClip(ctx, url)
+		return nil, fmt.Errorf("failed to save sound: %w", err)
+	}
+
+	details, _ := json.Marshal(map[string]string{"name": sound.Name})
+	s.communityService.LogAudit(ctx, &communityID, userID, models.AuditActionSoundCreate, "sound", &sound.ID, details)
+	return sound, nil
+}
+
+// UpdateSound renames a clip or changes its emoji or volume
+func (s *Service) UpdateSound(ctx context.Context, soundID, userID uuid.UUID, req *UpdateSoundRequest) (*Sound, error) {
+	sound, err := s.getSound(ctx, soundID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireManage(ctx, sound.CommunityID, userID); err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if !soundNameRegex.MatchString(name) {
+			return nil, ErrInvalidName
+		}
+		if taken, err := s.nameTaken(ctx, sound.CommunityID, sound.ID, name); err != nil {
+			return nil, err
+		} else if taken {
+			return nil, ErrNameTaken
+		}
+		sound.Name = name
+	}
+	if req.Emoji != nil {
+		// An empty emoji clears it
+		sound.Emoji = req.Emoji
+		if *req.Emoji == "" {
+			sound.Emoji = nil
+		}
+	}
+	if req.Volume != nil {
+		sound.Volume = *req.Volume
+	}
+	sound.UpdatedAt = time.Now()
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE soundboard_sounds SET name = $2, emoji = $3, volume = $4, updated_at = $5 WHERE id = $1`,
+		sound.ID, sound.Name, sound.Emoji, sound.Volume, sound.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update sound: %w", err)
+	}
+	return sound, nil
+}
+
+// DeleteSound removes a clip and its file
+func (s *Service) DeleteSound(ctx context.Context, soundID, userID uuid.UUID) error {
+	sound, err := s.getSound(ctx, soundID)
+	if err != nil {
+		return err
+	}
+	if err := s.requireManage(ctx, sound.CommunityID, userID); err != nil {
+		return err
+	}
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM soundboard_sounds WHERE id = $1`, soundID); err != nil {
+		return fmt.Errorf("failed to delete sound: %w", err)
+	}
+	if err := s.mediaService.DeleteSoundClip(ctx, sound.URL); err != nil {
+		log.Warn().Err(err).Str("soundId", soundID.String()).Msg("Failed to remove sound clip file")
+	}
+
+	details, _ := json.Marshal(map[string]string{"name": sound.Name})
+	s.communityService.LogAudit(ctx, &sound.CommunityID, userID, models.AuditActionSoundDelete, "sound", &sound.ID, details)
+	return nil
+}
+
+// ChannelEnabled is whether members can play sounds in a voice channel
+func (s *Service) ChannelEnabled(ctx context.Context, channelID, userID uuid.UUID) (bool, error) {
+	if !s.channelService.CanAccessChannel(ctx, channelID, userID) {
+		return false, ErrInsufficientPerms
+	}
+	return s.channelEnabled(ctx, channelID)
+}
+
+// SetChannelEnabled turns the soundboard on or off in a voice channel. Needs
+// the Manage Channels permission.
+func (s *Service) SetChannelEnabled(ctx context.Context, channelID, userID uuid.UUID, enabled bool) error {
+	ch, err := s.voiceChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	if err := s.communityService.RequirePermission(ctx, ch.CommunityID, userID, models.PermissionManageChannels); err != nil {
+		return ErrInsufficientPerms
+	}
+
+	if enabled {
+		_, err = s.db.Exec(ctx, `DELETE FROM soundboard_disabled_channels WHERE channel_id = $1`, channelID)
+	} else {
+		_, err = s.db.Exec(ctx,
+			`INSERT INTO soundboard_disabled_channels (channel_id, disabled_by) VALUES ($1, $2)
+			ON CONFLICT (channel_id) DO NOTHING`,
+			channelID, userID,
+		)
+	}
+	return err
+}
+
+// PlaySound plays a clip in the voice channel the caller is connected to. The
+// clip must be from the channel's community and the caller must be able to
+// speak there.
+func (s *Service) PlaySound(ctx context.Context, channelID, soundID, userID uuid.UUID) error {
+	ch, err := s.voiceChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	state, err := s.voiceService.GetUserVoiceState(ctx, channelID, userID)
+	if err != nil {
+		if errors.Is(err, voice.ErrNotInVoiceChannel) {
+			return ErrNotInChannel
+		}
+		return err
+	}
+	if state.IsMuted || state.IsDeafened || state.IsSelfMuted || state.IsSelfDeaf ||
+		!s.channelService.CanSpeakVoice(ctx, channelID, userID) {
+		return ErrCannotSpeak
+	}
+
+	enabled, err := s.channelEnabled(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrSoundboardOff
+	}
+
+	sound, err := s.getSound(ctx, soundID)
+	if err != nil {
+		return err
+	}
+	if sound.CommunityID != ch.CommunityID {
+		return ErrSoundNotFound
+	}
+
+	count, err := database.IncrementRateLimit(ctx, "soundboard:user:"+userID.String(), PlayRateWindow)
+	if err == nil && count > userPlayLimit {
+		return ErrRateLimited
+	}
+	count, err = database.IncrementRateLimit(ctx, "soundboard:channel:"+channelID.String(), PlayRateWindow)
+	if err == nil && count > channelPlayLimit {
+		return ErrRateLimited
+	}
+
+	s.broadcast(ctx, channelID, EventTypeVoiceSound, map[string]interface{}{
+		"channelId": channelID.String(),
+		"userId":    userID.String(),
+		"soundId":   sound.ID.String(),
+		"name":      sound.Name,
+		"emoji":     sound.Emoji,
+		"url":       sound.URL,
+		"volume":    sound.Volume,
+	})
+	return nil
+}
+
+func (s *Service) channelEnabled(ctx context.Context, channelID uuid.UUID) (bool, error) {
+	var disabled bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM soundboard_disabled_channels WHERE channel_id = $1)`,
+		channelID,
+	).Scan(&disabled)
+	return !disabled, err
+}
+
+func (s *Service) voiceChannel(ctx context.Context, channelID uuid.UUID) (*models.Channel, error) {
+	ch, err := s.channelService.GetChannel(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	if ch.Type != models.ChannelTypeVoice {
+		return nil, ErrNotVoiceChannel
+	}
+	return ch, nil
+}
+
+func (s *Service) requireManage(ctx context.Context, communityID, userID uuid.UUID) error {
+	perms, err := s.communityService.GetMemberPermissions(ctx, communityID, userID)
+	if err != nil {
+		return ErrNotMember
+	}
+	if !models.HasPermission(perms, models.PermissionManageEmojis) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+const soundColumns = `id, community_id, name, emoji, url, volume, uploader_id, created_at, updated_at`
+
+func scanSound(row pgx.Row) (*Sound, error) {
+	var sound Sound
+	var volume float32
+	err := row.Scan(&sound.ID, &sound.CommunityID, &sound.Name, &sound.Emoji, &sound.URL, &volume,
+		&sound.UploaderID, &sound.CreatedAt, &sound.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	sound.Volume = float64(volume)
+	return &sound, nil
+}
+
+func (s *Service) getSound(ctx context.Context, soundID uuid.UUID) (*Sound, error) {
+	sound, err := scanSound(s.db.QueryRow(ctx,
+		`SELECT `+soundColumns+` FROM soundboard_sounds WHERE id = $1`,
+		soundID,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSoundNotFound
+		}
+		return nil, err
+	}
+	return sound, nil
+}
+
+// nameTaken is whether another of the community's sounds has the name
+func (s *Service) nameTaken(ctx context.Context, communityID, soundID uuid.UUID, name string) (bool, error) {
+	var exists bool
+	err := s.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM soundboard_sounds WHERE community_id = $1 AND id <> $2 AND LOWER(name) = LOWER($3))`,
+		communityID, soundID, name,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check sound name: %w", err)
+	}
+	return exists, nil
+}
+
+func (s *Service) broadcast(ctx context.Context, channelID uuid.UUID, eventType string, data interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"channelId": channelID.String(),
+		"event": map[string]interface{}{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", eventType).Msg("Failed to marshal soundboard event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Str("event", eventType).Msg("Failed to publish soundboard event")
+	}
+}
@@ -0,0 +1,420 @@
+package starboard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
+	"github.com/zentra/server/internal/services/messaging"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/encryption"
+)
+
+// PluginSlug is the slug the starboard is seeded under in the plugins table.
+const PluginSlug = "starboard"
+
+// Consumer group the starboard reads the broadcast stream through
+const broadcastGroup = "starboard"
+
+const (
+	DefaultEmoji     = "⭐"
+	DefaultThreshold = 3
+	MaxThreshold     = 1000
+
+	// quoted source content is cut at this many runes so the highlight stays
+	// well under the 4000 character message limit
+	maxQuoteLength = 1500
+)
+
+// BotUserID is the system account highlights are posted as (seeded by migration 000013).
+var BotUserID = uuid.MustParse("5ba4b0a4-57a4-4b0a-8d00-000000000001")
+
+var (
+	ErrInvalidConfig = errors.New("invalid starboard config")
+)
+
+// Config is the per-community starboard config stored in community_plugins.config
+type Config struct {
+	ChannelID     *uuid.UUID `json:"channelId,omitempty"`
+	Emoji         string     `json:"emoji,omitempty"`
+	Threshold     int        `json:"threshold,omitempty"`
+	AllowSelfStar bool       `json:"allowSelfStar,omitempty"`
+}
+
+// ParseConfig decodes a starboard config, filling in defaults for missing fields
+func ParseConfig(raw json.RawMessage) (*Config, error) {
+	cfg := &Config{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+
+	cfg.Emoji = strings.TrimSpace(cfg.Emoji)
+	if cfg.Emoji == "" {
+		cfg.Emoji = DefaultEmoji
+	}
+	if len(cfg.Emoji) > 128 {
+		return nil, fmt.Errorf("%w: emoji is too long", ErrInvalidConfig)
+	}
+
+	if cfg.Threshold == 0 {
+		cfg.Threshold = DefaultThreshold
+	}
+	if cfg.Threshold < 1 || cfg.Threshold > MaxThreshold {
+		return nil, fmt.Errorf("%w: threshold must be between 1 and %d", ErrInvalidConfig, MaxThreshold)
+	}
+
+	return cfg, nil
+}
+
+// ValidateConfig is registered with the plugin service so bad configs are rejected on save
+func ValidateConfig(raw json.RawMessage) error {
+	_, err := ParseConfig(raw)
+	return err
+}
+
+// MessagePoster posts and edits highlights as the starboard account
+type MessagePoster interface {
+	CreateBotMessage(ctx context.Context, m *message.BotMessage) (*message.MessageResponse, error)
+	UpdateBotMessage(ctx context.Context, e *message.BotMessageEdit) (*message.MessageResponse, error)
+}
+
+type Service struct {
+	db        *pgxpool.Pool
+	redis     *redis.Client
+	cipher    messaging.ContentCipher
+	messages  MessagePoster
+	urlSigner *messaging.URLSigner
+}
+
+func NewService(db *pgxpool.Pool, redisClient *redis.Client, keys *encryption.Keyring, messages MessagePoster) *Service {
+	return &Service{
+		db:       db,
+		redis:    redisClient,
+		cipher:   messaging.NewChannelCipher(keys),
+		messages: messages,
+	}
+}
+
+// SetURLSigner tells the starboard attachments are private. Highlights then
+// name the source's attachments instead of linking them, since a signed link
+// in stored content would expire and skip the access check.
+func (s *Service) SetURLSigner(signer *messaging.URLSigner) {
+	s.urlSigner = signer
+}
+
+type reactionEvent struct {
+	ChannelID string `json:"channelId"`
+	MessageID string `json:"messageId"`
+	UserID    string `json:"userId"`
+	Emoji     string `json:"emoji"`
+}
+
+// Run reacts to reaction events on the realtime broadcast stream. Gateway
+// instances share one consumer group, named consumer, so each event is handled
+// by one of them; starboard_entries keeps the repost unique regardless.
+func (s *Service) Run(ctx context.Context, consumer string) {
+	database.NewBroadcastConsumer(s.redis, broadcastGroup, consumer).Run(ctx, func(entry database.BroadcastEntry) {
+		var data struct {
+			Event struct {
+				Type string          `json:"type"`
+				Data json.RawMessage `json:"data"`
+			} `json:"event"`
+		}
+		if err := json.Unmarshal(entry.Payload, &data); err != nil {
+			return
+		}
+		if data.Event.Type != "REACTION_ADD" && data.Event.Type != "REACTION_REMOVE" {
+			return
+		}
+
+		var ev reactionEvent
+		if err := json.Unmarshal(data.Event.Data, &ev); err != nil {
+			return
+		}
+		messageID, err := uuid.Parse(ev.MessageID)
+		if err != nil {
+			return
+		}
+
+		if err := s.HandleReaction(ctx, messageID, ev.Emoji); err != nil {
+			log.Warn().Err(err).Str("messageId", ev.MessageID).Msg("Failed to process starboard reaction")
+		}
+	})
+}
+
+// sourceMessage is what we need from the reacted message to build a highlight
+type sourceMessage struct {
+	ID               uuid.UUID
+	ChannelID        uuid.UUID
+	CommunityID      uuid.UUID
+	AuthorID         uuid.UUID
+	EncryptedContent []byte
+	Voters           []string
+	ChannelNSFW      bool
+	CreatedAt        time.Time
+}
+
+// HandleReaction re-evaluates a message after one of its reactions changed.
+// Messages that reach the threshold get reposted; ones already on the board
+// just get their count refreshed.
+func (s *Service) HandleReaction(ctx context.Context, messageID uuid.UUID, emoji string) error {
+	src, err := s.getSourceMessage(ctx, messageID, emoji)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	// Never star the starboard's own posts
+	if src.AuthorID == BotUserID {
+		return nil
+	}
+
+	cfg, ok, err := s.getCommunityConfig(ctx, src.CommunityID)
+	if err != nil || !ok {
+		return err
+	}
+	if cfg.ChannelID == nil || *cfg.ChannelID == src.ChannelID || cfg.Emoji != emoji {
+		return nil
+	}
+
+	count := 0
+	for _, voter := range src.Voters {
+		if !cfg.AllowSelfStar && voter == src.AuthorID.String() {
+			continue
+		}
+		count++
+	}
+
+	var entry struct {
+		highlightMessageID uuid.UUID
+		count              int
+	}
+	err = s.db.QueryRow(ctx,
+		`SELECT highlight_message_id, reaction_count FROM starboard_entries WHERE source_message_id = $1`,
+		messageID,
+	).Scan(&entry.highlightMessageID, &entry.count)
+	if err == nil {
+		if entry.count == count {
+			return nil
+		}
+		return s.updateHighlight(ctx, src, cfg, entry.highlightMessageID, count)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	if count < cfg.Threshold {
+		return nil
+	}
+	return s.createHighlight(ctx, src, cfg, count)
+}
+
+func (s *Service) getSourceMessage(ctx context.Context, messageID uuid.UUID, emoji string) (*sourceMessage, error) {
+	src := &sourceMessage{}
+	var votersRaw []byte
+	err := s.db.QueryRow(ctx,
+		`SELECT m.id, m.channel_id, c.community_id, m.author_id, m.encrypted_content,
+		        COALESCE(m.reactions->$2, '[]'::jsonb), COALESCE(c.is_nsfw, FALSE), m.created_at
+		 FROM messages m
+		 JOIN channels c ON c.id = m.channel_id
+		 WHERE m.id = $1 AND m.deleted_at IS NULL AND NOT m.is_quarantined`,
+		messageID, emoji,
+	).Scan(&src.ID, &src.ChannelID, &src.CommunityID, &src.AuthorID, &src.EncryptedContent, &votersRaw, &src.ChannelNSFW, &src.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(votersRaw, &src.Voters); err != nil {
+		return nil, fmt.Errorf("decode reactions: %w", err)
+	}
+	return src, nil
+}
+
+// getCommunityConfig loads the starboard config if the plugin is installed, enabled
+// and allowed to read and post messages on the community.
+func (s *Service) getCommunityConfig(ctx context.Context, communityID uuid.UUID) (*Config, bool, error) {
+	var raw json.RawMessage
+	var granted int64
+	err := s.db.QueryRow(ctx,
+		`SELECT cp.config, cp.granted_permissions
+		 FROM community_plugins cp
+		 JOIN plugins p ON p.id = cp.plugin_id
+		 WHERE cp.community_id = $1 AND p.slug = $2 AND cp.enabled = TRUE`,
+		communityID, PluginSlug,
+	).Scan(&raw, &granted)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	install := &models.CommunityPlugin{GrantedPermissions: granted}
+	if !install.HasPermission(models.PluginPermReadMessages) || !install.HasPermission(models.PluginPermSendMessages) {
+		return nil, false, nil
+	}
+
+	cfg, err := ParseConfig(raw)
+	if err != nil {
+		// Configs are validated on save, so this only happens for rows written before that
+		log.Warn().Err(err).Str("communityId", communityID.String()).Msg("Ignoring invalid starboard config")
+		return nil, false, nil
+	}
+	return cfg, true, nil
+}
+
+func (s *Service) createHighlight(ctx context.Context, src *sourceMessage, cfg *Config, count int) error {
+	// The highlight channel has to live in the same community, and NSFW content
+	// doesn't get copied into a SFW channel.
+	var highlightNSFW bool
+	err := s.db.QueryRow(ctx,
+		`SELECT COALESCE(is_nsfw, FALSE) FROM channels WHERE id = $1 AND community_id = $2`,
+		*cfg.ChannelID, src.CommunityID,
+	).Scan(&highlightNSFW)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+	if src.ChannelNSFW && !highlightNSFW {
+		return nil
+	}
+
+	highlightID, now := messaging.NewMessageID()
+
+	// Claim the entry first; if another instance beat us to it we're done
+	tag, err := s.db.Exec(ctx,
+		`INSERT INTO starboard_entries (source_message_id, source_channel_id, community_id, highlight_channel_id,
+		                                highlight_message_id, highlight_created_at, reaction_count)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 ON CONFLICT (source_message_id) DO NOTHING`,
+		src.ID, src.ChannelID, src.CommunityID, *cfg.ChannelID, highlightID, now, count,
+	)
+	if err != nil {
+		return fmt.Errorf("insert starboard entry: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	_, err = s.messages.CreateBotMessage(ctx, &message.BotMessage{
+		ID:          highlightID,
+		CreatedAt:   now,
+		ChannelID:   *cfg.ChannelID,
+		CommunityID: src.CommunityID,
+		AuthorID:    BotUserID,
+		Content:     s.buildContent(ctx, src, cfg, count),
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, message.ErrBlockedByAutoMod), errors.Is(err, message.ErrRemovedByAutoMod):
+		// The entry stays so the highlight isn't tried again on every reaction
+		return nil
+	}
+	// Let the next reaction try again
+	if _, releaseErr := s.db.Exec(ctx,
+		`DELETE FROM starboard_entries WHERE source_message_id = $1 AND highlight_message_id = $2`,
+		src.ID, highlightID,
+	); releaseErr != nil {
+		log.Warn().Err(releaseErr).Str("messageId", src.ID.String()).Msg("Failed to release starboard entry")
+	}
+	if errors.Is(err, message.ErrChannelUnavailable) {
+		return nil
+	}
+	return fmt.Errorf("post highlight: %w", err)
+}
+
+func (s *Service) updateHighlight(ctx context.Context, src *sourceMessage, cfg *Config, highlightID uuid.UUID, count int) error {
+	_, err := s.db.Exec(ctx,
+		`UPDATE starboard_entries SET reaction_count = $2, updated_at = $3 WHERE source_message_id = $1`,
+		src.ID, count, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.messages.UpdateBotMessage(ctx, &message.BotMessageEdit{
+		MessageID: highlightID,
+		AuthorID:  BotUserID,
+		Content:   s.buildContent(ctx, src, cfg, count),
+	})
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, message.ErrMessageNotFound):
+		// Moderators may have deleted the highlight; the entry stays so it isn't reposted
+		return nil
+	case errors.Is(err, message.ErrBlockedByAutoMod):
+		return nil
+	}
+	return fmt.Errorf("update highlight: %w", err)
+}
+
+// buildContent renders the highlight body: the count, a jump reference and the quoted original
+func (s *Service) buildContent(ctx context.Context, src *sourceMessage, cfg *Config, count int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s **%d** · <#%s> · <@%s>\n", cfg.Emoji, count, src.ChannelID, src.AuthorID)
+
+	ref := messaging.ContentRef{Kind: messaging.ContentKindChannel, ID: src.ID, ContainerID: src.ChannelID}
+	content, err := s.cipher.Decrypt(ref.Row(), src.EncryptedContent, nil)
+	if err != nil {
+		messaging.ReportDecryptionFailure(s.db, s.cipher, ref, err)
+		content = ""
+	}
+	content = strings.TrimSpace(content)
+	if runes := []rune(content); len(runes) > maxQuoteLength {
+		content = string(runes[:maxQuoteLength]) + "…"
+	}
+	if content != "" {
+		for _, line := range strings.Split(content, "\n") {
+			b.WriteString("> ")
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT file_url, filename, is_spoiler FROM message_attachments WHERE message_id = $1 ORDER BY created_at ASC`,
+		src.ID,
+	)
+	if err != nil {
+		log.Warn().Err(err).Str("messageId", src.ID.String()).Msg("Failed to load starboard source attachments")
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var url, filename string
+			var spoiler bool
+			if err := rows.Scan(&url, &filename, &spoiler); err != nil {
+				continue
+			}
+			// Members open private attachments from the source message
+			if s.urlSigner != nil {
+				url = "📎 " + strings.Join(strings.Fields(filename), " ")
+			}
+			if spoiler {
+				fmt.Fprintf(&b, "||%s||\n", url)
+			} else {
+				b.WriteString(url)
+				b.WriteString("\n")
+			}
+		}
+	}
+
+	fmt.Fprintf(&b, "[Jump to message](/channels/%s/messages/%s)", src.ChannelID, src.ID)
+	return b.String()
+}
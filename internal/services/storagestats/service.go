@@ -0,0 +1,258 @@
+package storagestats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/minio/minio-go/v7"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+const (
+	// HealthInterval is how often every instance checks MinIO is reachable.
+	HealthInterval = 30 * time.Second
+	// UsageInterval is how often bucket usage is sampled. Listing a large
+	// bucket is expensive, so one instance samples and the rest read the result.
+	UsageInterval = time.Hour
+
+	// Readiness reuses a health check this recent instead of calling MinIO
+	readyCacheTTL = 10 * time.Second
+	checkTimeout  = 5 * time.Second
+
+	// Growth is measured over the samples from this window
+	growthWindow = 7 * 24 * time.Hour
+	// Samples are kept long enough to look at a few months of growth
+	sampleRetention = 180 * 24 * time.Hour
+
+	lockKey = "storagestats:lock"
+	lockTTL = UsageInterval - time.Minute
+)
+
+var ErrStorageUnavailable = errors.New("object storage is unavailable")
+
+var (
+	upGauge = metrics.NewGauge("zentra_storage_up",
+		"Whether object storage answered the last health check (1) or not (0).")
+	checkSeconds = metrics.NewGauge("zentra_storage_check_duration_seconds",
+		"How long the last object storage health check took.")
+	checkFailuresTotal = metrics.NewCounter("zentra_storage_check_failures_total",
+		"Object storage health checks that failed.", "bucket")
+	objectsGauge = metrics.NewGauge("zentra_storage_bucket_objects",
+		"Objects in the bucket at the last usage sample.", "bucket")
+	bytesGauge = metrics.NewGauge("zentra_storage_bucket_bytes",
+		"Bytes stored in the bucket at the last usage sample.", "bucket")
+	growthGauge = metrics.NewGauge("zentra_storage_bucket_growth_bytes_per_day",
+		"Average daily growth of the bucket over the last 7 days of samples.", "bucket")
+	lastSampleSeconds = metrics.NewGauge("zentra_storage_last_sample_timestamp_seconds",
+		"Unix time of the bucket's last usage sample.", "bucket")
+)
+
+// Usage is one bucket's size at a point in time
+type Usage struct {
+	Bucket    string    `json:"bucket"`
+	Objects   int64     `json:"objects"`
+	Bytes     int64     `json:"bytes"`
+	SampledAt time.Time `json:"sampledAt"`
+	// GrowthBytesPerDay is nil until there are two samples in the window
+	GrowthBytesPerDay *float64 `json:"growthBytesPerDay,omitempty"`
+}
+
+type Service struct {
+	db      *pgxpool.Pool
+	redis   *redis.Client
+	minio   *minio.Client
+	buckets []string
+
+	mu        sync.RWMutex
+	checkedAt time.Time
+	healthErr error
+}
+
+func NewService(db *pgxpool.Pool, redisClient *redis.Client, minioClient *minio.Client, buckets []string) *Service {
+	return &Service{
+		db:      db,
+		redis:   redisClient,
+		minio:   minioClient,
+		buckets: buckets,
+	}
+}
+
+// Run checks health every HealthInterval and samples usage every
+// UsageInterval until ctx is cancelled.
+func (s *Service) Run(ctx context.Context) {
+	s.Check(ctx)
+	s.SampleUsage(ctx)
+
+	healthTicker := time.NewTicker(HealthInterval)
+	defer healthTicker.Stop()
+	usageTicker := time.NewTicker(UsageInterval)
+	defer usageTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-healthTicker.C:
+			s.Check(ctx)
+		case <-usageTicker.C:
+			s.SampleUsage(ctx)
+		}
+	}
+}
+
+// Check asks MinIO for every bucket and records the result
+func (s *Service) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var checkErr error
+	for _, bucket := range s.buckets {
+		exists, err := s.minio.BucketExists(ctx, bucket)
+		if err == nil && !exists {
+			err = fmt.Errorf("bucket %s does not exist", bucket)
+		}
+		if err != nil {
+			checkFailuresTotal.Inc(bucket)
+			checkErr = fmt.Errorf("%w: %v", ErrStorageUnavailable, err)
+			break
+		}
+	}
+	checkSeconds.Set(time.Since(start).Seconds())
+
+	if checkErr != nil {
+		upGauge.Set(0)
+		log.Warn().Err(checkErr).Msg("Object storage health check failed")
+	} else {
+		upGauge.Set(1)
+	}
+
+	s.mu.Lock()
+	s.checkedAt = time.Now()
+	s.healthErr = checkErr
+	s.mu.Unlock()
+
+	return checkErr
+}
+
+// Ready reports whether object storage is usable, for the readiness probe.
+// A recent health check is reused so probes don't hammer MinIO.
+func (s *Service) Ready(ctx context.Context) error {
+	s.mu.RLock()
+	checkedAt, healthErr := s.checkedAt, s.healthErr
+	s.mu.RUnlock()
+
+	if time.Since(checkedAt) < readyCacheTTL {
+		return healthErr
+	}
+	return s.Check(ctx)
+}
+
+// SampleUsage measures every bucket and refreshes the usage gauges. Only
+// one instance in the cluster measures per interval; the others load the
+// latest samples it stored.
+func (s *Service) SampleUsage(ctx context.Context) {
+	acquired, err := s.redis.SetNX(ctx, lockKey, "1", lockTTL).Result()
+	if err == nil && acquired {
+		// The lock is left to expire so the next instance waits out the interval
+		for _, bucket := range s.buckets {
+			if err := s.sampleBucket(ctx, bucket); err != nil {
+				log.Error().Err(err).Str("bucket", bucket).Msg("Failed to sample bucket usage")
+			}
+		}
+	}
+
+	usage, err := s.GetUsage(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load storage usage")
+		return
+	}
+	for _, u := range usage {
+		objectsGauge.Set(float64(u.Objects), u.Bucket)
+		bytesGauge.Set(float64(u.Bytes), u.Bucket)
+		lastSampleSeconds.Set(float64(u.SampledAt.Unix()), u.Bucket)
+		if u.GrowthBytesPerDay != nil {
+			growthGauge.Set(*u.GrowthBytesPerDay, u.Bucket)
+		}
+	}
+}
+
+func (s *Service) sampleBucket(ctx context.Context, bucket string) error {
+	var objects, bytes int64
+	for object := range s.minio.ListObjects(ctx, bucket, minio.ListObjectsOptions{Recursive: true}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		objects++
+		bytes += object.Size
+	}
+
+	_, err := s.db.Exec(ctx,
+		`INSERT INTO storage_usage_samples (bucket, object_count, total_bytes) VALUES ($1, $2, $3)`,
+		bucket, objects, bytes,
+	)
+	return err
+}
+
+// GetUsage returns the latest sample per bucket with its growth over the
+// last week of samples
+func (s *Service) GetUsage(ctx context.Context) ([]*Usage, error) {
+	rows, err := s.db.Query(ctx,
+		`WITH latest AS (
+			SELECT DISTINCT ON (bucket) bucket, object_count, total_bytes, sampled_at
+			FROM storage_usage_samples
+			WHERE bucket = ANY($1)
+			ORDER BY bucket, sampled_at DESC
+		), baseline AS (
+			SELECT DISTINCT ON (bucket) bucket, total_bytes, sampled_at
+			FROM storage_usage_samples
+			WHERE bucket = ANY($1) AND sampled_at >= $2
+			ORDER BY bucket, sampled_at ASC
+		)
+		SELECT l.bucket, l.object_count, l.total_bytes, l.sampled_at, b.total_bytes, b.sampled_at
+		FROM latest l
+		LEFT JOIN baseline b ON b.bucket = l.bucket
+		ORDER BY l.bucket`,
+		s.buckets, time.Now().Add(-growthWindow),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := []*Usage{}
+	for rows.Next() {
+		u := &Usage{}
+		var baseBytes *int64
+		var baseAt *time.Time
+		if err := rows.Scan(&u.Bucket, &u.Objects, &u.Bytes, &u.SampledAt, &baseBytes, &baseAt); err != nil {
+			return nil, err
+		}
+		if baseBytes != nil && baseAt != nil {
+			if days := u.SampledAt.Sub(*baseAt).Hours() / 24; days > 0 {
+				growth := float64(u.Bytes-*baseBytes) / days
+				u.GrowthBytesPerDay = &growth
+			}
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// PruneSamples drops samples past the retention window
+func (s *Service) PruneSamples(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM storage_usage_samples WHERE sampled_at < $1`,
+		time.Now().Add(-sampleRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
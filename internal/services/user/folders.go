@@ -0,0 +1,582 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+)
+
+var (
+	ErrFolderNotFound     = errors.New("folder not found")
+	ErrTooManyFolders     = errors.New("folder limit reached")
+	ErrCommunityNotJoined = errors.New("not a member of this community")
+	ErrInvalidSidebarItem = errors.New("sidebar order references an unknown folder or community")
+)
+
+const MaxFoldersPerUser = 50
+
+type CreateFolderRequest struct {
+	Name         string      `json:"name" validate:"required,min=1,max=64"`
+	Color        *string     `json:"color" validate:"omitempty,hexcolor,len=7"`
+	CommunityIDs []uuid.UUID `json:"communityIds" validate:"max=200"`
+}
+
+type UpdateFolderRequest struct {
+	Name  *string `json:"name" validate:"omitempty,min=1,max=64"`
+	Color *string `json:"color" validate:"omitempty,hexcolor,len=7"`
+}
+
+type ReorderSidebarRequest struct {
+	Items []models.SidebarItem `json:"items" validate:"required,max=500,dive"`
+}
+
+type ReorderFolderRequest struct {
+	CommunityIDs []uuid.UUID `json:"communityIds" validate:"required,max=200"`
+}
+
+// MoveCommunityRequest puts a community into a folder (or back on the top level
+// when FolderID is nil) at Position; a missing position appends it.
+type MoveCommunityRequest struct {
+	CommunityID uuid.UUID  `json:"communityId" validate:"required"`
+	FolderID    *uuid.UUID `json:"folderId"`
+	Position    *int       `json:"position" validate:"omitempty,min=0"`
+}
+
+// GetSidebar returns the user's community sidebar layout
+func (s *Service) GetSidebar(ctx context.Context, userID uuid.UUID) (*models.SidebarLayout, error) {
+	return s.loadSidebar(ctx, s.db, userID)
+}
+
+// CreateFolder makes a new folder at the top of the sidebar, optionally moving communities into it
+func (s *Service) CreateFolder(ctx context.Context, userID uuid.UUID, req *CreateFolderRequest) (*models.SidebarLayout, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var count int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM community_folders WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= MaxFoldersPerUser {
+		return nil, ErrTooManyFolders
+	}
+
+	var folderID uuid.UUID
+	err = tx.QueryRow(ctx,
+		`INSERT INTO community_folders (user_id, name, color) VALUES ($1, $2, $3) RETURNING id`,
+		userID, strings.TrimSpace(req.Name), normalizeColor(req.Color),
+	).Scan(&folderID)
+	if err != nil {
+		return nil, fmt.Errorf("create folder: %w", err)
+	}
+
+	if len(req.CommunityIDs) > 0 {
+		if err := s.setFolderContents(ctx, tx, userID, folderID, req.CommunityIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	top, err := s.topLevelItems(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	top = removeItem(top, models.SidebarItem{Type: models.SidebarItemFolder, ID: folderID})
+	top = append([]models.SidebarItem{{Type: models.SidebarItemFolder, ID: folderID}}, top...)
+	if err := s.writeTopLevel(ctx, tx, userID, top); err != nil {
+		return nil, err
+	}
+
+	return s.commitSidebar(ctx, tx, userID)
+}
+
+// UpdateFolder renames or recolors a folder
+func (s *Service) UpdateFolder(ctx context.Context, userID, folderID uuid.UUID, req *UpdateFolderRequest) (*models.SidebarLayout, error) {
+	var name *string
+	if req.Name != nil {
+		trimmed := strings.TrimSpace(*req.Name)
+		name = &trimmed
+	}
+
+	tag, err := s.db.Exec(ctx,
+		`UPDATE community_folders SET name = COALESCE($3, name), color = COALESCE($4, color)
+		 WHERE id = $1 AND user_id = $2`,
+		folderID, userID, name, normalizeColor(req.Color),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("update folder: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrFolderNotFound
+	}
+
+	layout, err := s.GetSidebar(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.sendToUser(ctx, userID, "SIDEBAR_UPDATE", layout)
+	return layout, nil
+}
+
+// DeleteFolder removes a folder; its communities take its place on the top level
+func (s *Service) DeleteFolder(ctx context.Context, userID, folderID uuid.UUID) (*models.SidebarLayout, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	contents, err := s.folderCommunities(ctx, tx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	top, err := s.topLevelItems(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := tx.Exec(ctx, `DELETE FROM community_folders WHERE id = $1 AND user_id = $2`, folderID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("delete folder: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil, ErrFolderNotFound
+	}
+
+	// Splice the folder's communities in where the folder was
+	next := make([]models.SidebarItem, 0, len(top)+len(contents))
+	for _, item := range top {
+		if item.Type == models.SidebarItemFolder && item.ID == folderID {
+			for _, communityID := range contents {
+				next = append(next, models.SidebarItem{Type: models.SidebarItemCommunity, ID: communityID})
+			}
+			continue
+		}
+		next = append(next, item)
+	}
+	if err := s.writeTopLevel(ctx, tx, userID, next); err != nil {
+		return nil, err
+	}
+
+	return s.commitSidebar(ctx, tx, userID)
+}
+
+// ReorderSidebar sets the order of top-level items. Communities listed here are
+// pulled out of whatever folder they were in; anything left out keeps its
+// relative order after the listed items.
+func (s *Service) ReorderSidebar(ctx context.Context, userID uuid.UUID, items []models.SidebarItem) (*models.SidebarLayout, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	top, err := s.topLevelItems(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	known, err := s.knownSidebarItems(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	next := make([]models.SidebarItem, 0, len(top))
+	seen := make(map[models.SidebarItem]bool, len(items))
+	for _, item := range items {
+		if !known[item] {
+			return nil, ErrInvalidSidebarItem
+		}
+		if seen[item] {
+			continue
+		}
+		seen[item] = true
+		next = append(next, item)
+	}
+	for _, item := range top {
+		if !seen[item] {
+			next = append(next, item)
+		}
+	}
+
+	if err := s.writeTopLevel(ctx, tx, userID, next); err != nil {
+		return nil, err
+	}
+	return s.commitSidebar(ctx, tx, userID)
+}
+
+// ReorderFolder sets a folder's contents in order, moving in any listed communities
+// from elsewhere. Communities currently in the folder but not listed go back to the top level.
+func (s *Service) ReorderFolder(ctx context.Context, userID, folderID uuid.UUID, communityIDs []uuid.UUID) (*models.SidebarLayout, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := s.requireFolder(ctx, tx, userID, folderID); err != nil {
+		return nil, err
+	}
+	if err := s.setFolderContents(ctx, tx, userID, folderID, communityIDs); err != nil {
+		return nil, err
+	}
+
+	// setFolderContents may have evicted communities; renumber the top level so they land at the end
+	top, err := s.topLevelItems(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.writeTopLevel(ctx, tx, userID, top); err != nil {
+		return nil, err
+	}
+
+	return s.commitSidebar(ctx, tx, userID)
+}
+
+// MoveCommunity moves one community into a folder or onto the top level at a position
+func (s *Service) MoveCommunity(ctx context.Context, userID uuid.UUID, req *MoveCommunityRequest) (*models.SidebarLayout, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var currentFolder *uuid.UUID
+	err = tx.QueryRow(ctx,
+		`SELECT folder_id FROM community_members WHERE user_id = $1 AND community_id = $2`,
+		userID, req.CommunityID,
+	).Scan(&currentFolder)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrCommunityNotJoined
+		}
+		return nil, err
+	}
+
+	if req.FolderID != nil {
+		if err := s.requireFolder(ctx, tx, userID, *req.FolderID); err != nil {
+			return nil, err
+		}
+	}
+
+	// Take it out of its current container first
+	if currentFolder != nil {
+		contents, err := s.folderCommunities(ctx, tx, userID, *currentFolder)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.writeFolder(ctx, tx, userID, *currentFolder, removeID(contents, req.CommunityID)); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.FolderID != nil {
+		contents, err := s.folderCommunities(ctx, tx, userID, *req.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		contents = insertAt(removeID(contents, req.CommunityID), req.CommunityID, req.Position)
+		if err := s.writeFolder(ctx, tx, userID, *req.FolderID, contents); err != nil {
+			return nil, err
+		}
+	}
+
+	top, err := s.topLevelItems(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	item := models.SidebarItem{Type: models.SidebarItemCommunity, ID: req.CommunityID}
+	top = removeItem(top, item)
+	if req.FolderID == nil {
+		top = insertItemAt(top, item, req.Position)
+	}
+	if err := s.writeTopLevel(ctx, tx, userID, top); err != nil {
+		return nil, err
+	}
+
+	return s.commitSidebar(ctx, tx, userID)
+}
+
+// queryer lets the sidebar helpers run on the pool or inside a transaction
+type queryer interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func (s *Service) loadSidebar(ctx context.Context, q queryer, userID uuid.UUID) (*models.SidebarLayout, error) {
+	rows, err := q.Query(ctx,
+		`SELECT id, user_id, name, color, position, created_at, updated_at
+		 FROM community_folders WHERE user_id = $1
+		 ORDER BY position ASC, created_at ASC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get folders: %w", err)
+	}
+	defer rows.Close()
+
+	layout := &models.SidebarLayout{
+		Items:   make([]models.SidebarItem, 0),
+		Folders: make([]*models.CommunityFolder, 0),
+	}
+	byID := make(map[uuid.UUID]*models.CommunityFolder)
+	for rows.Next() {
+		f := &models.CommunityFolder{CommunityIDs: make([]uuid.UUID, 0)}
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Name, &f.Color, &f.Position, &f.CreatedAt, &f.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan folder: %w", err)
+		}
+		layout.Folders = append(layout.Folders, f)
+		byID[f.ID] = f
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	memberRows, err := q.Query(ctx,
+		`SELECT community_id, folder_id, sidebar_position FROM community_members
+		 WHERE user_id = $1
+		 ORDER BY sidebar_position ASC, joined_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get sidebar communities: %w", err)
+	}
+	defer memberRows.Close()
+
+	type positioned struct {
+		item     models.SidebarItem
+		position int
+	}
+	var top []positioned
+	for _, f := range layout.Folders {
+		top = append(top, positioned{models.SidebarItem{Type: models.SidebarItemFolder, ID: f.ID}, f.Position})
+	}
+	for memberRows.Next() {
+		var communityID uuid.UUID
+		var folderID *uuid.UUID
+		var position int
+		if err := memberRows.Scan(&communityID, &folderID, &position); err != nil {
+			return nil, fmt.Errorf("scan sidebar community: %w", err)
+		}
+		if folderID != nil {
+			if f, ok := byID[*folderID]; ok {
+				f.CommunityIDs = append(f.CommunityIDs, communityID)
+				continue
+			}
+		}
+		top = append(top, positioned{models.SidebarItem{Type: models.SidebarItemCommunity, ID: communityID}, position})
+	}
+	if err := memberRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Folders first on ties so a freshly created folder at position 0 sorts above new joins
+	sort.SliceStable(top, func(i, j int) bool {
+		if top[i].position != top[j].position {
+			return top[i].position < top[j].position
+		}
+		return top[i].item.Type == models.SidebarItemFolder && top[j].item.Type != models.SidebarItemFolder
+	})
+	for _, p := range top {
+		layout.Items = append(layout.Items, p.item)
+	}
+
+	return layout, nil
+}
+
+func (s *Service) topLevelItems(ctx context.Context, tx pgx.Tx, userID uuid.UUID) ([]models.SidebarItem, error) {
+	layout, err := s.loadSidebar(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return layout.Items, nil
+}
+
+func (s *Service) knownSidebarItems(ctx context.Context, tx pgx.Tx, userID uuid.UUID) (map[models.SidebarItem]bool, error) {
+	layout, err := s.loadSidebar(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[models.SidebarItem]bool)
+	for _, item := range layout.Items {
+		known[item] = true
+	}
+	for _, f := range layout.Folders {
+		for _, communityID := range f.CommunityIDs {
+			known[models.SidebarItem{Type: models.SidebarItemCommunity, ID: communityID}] = true
+		}
+	}
+	return known, nil
+}
+
+func (s *Service) folderCommunities(ctx context.Context, tx pgx.Tx, userID, folderID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := tx.Query(ctx,
+		`SELECT community_id FROM community_members
+		 WHERE user_id = $1 AND folder_id = $2
+		 ORDER BY sidebar_position ASC, joined_at DESC`,
+		userID, folderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *Service) requireFolder(ctx context.Context, tx pgx.Tx, userID, folderID uuid.UUID) error {
+	var exists bool
+	err := tx.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM community_folders WHERE id = $1 AND user_id = $2)`,
+		folderID, userID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// setFolderContents makes communityIDs the exact, ordered contents of a folder
+func (s *Service) setFolderContents(ctx context.Context, tx pgx.Tx, userID, folderID uuid.UUID, communityIDs []uuid.UUID) error {
+	communityIDs = dedupeIDs(communityIDs) // never nil, so ANY($3) below can't compare against NULL
+	_, err := tx.Exec(ctx,
+		`UPDATE community_members SET folder_id = NULL, sidebar_position = 2147483647
+		 WHERE user_id = $1 AND folder_id = $2 AND NOT (community_id = ANY($3))`,
+		userID, folderID, communityIDs,
+	)
+	if err != nil {
+		return err
+	}
+	return s.writeFolder(ctx, tx, userID, folderID, communityIDs)
+}
+
+func (s *Service) writeFolder(ctx context.Context, tx pgx.Tx, userID, folderID uuid.UUID, communityIDs []uuid.UUID) error {
+	for i, communityID := range communityIDs {
+		tag, err := tx.Exec(ctx,
+			`UPDATE community_members SET folder_id = $3, sidebar_position = $4
+			 WHERE user_id = $1 AND community_id = $2`,
+			userID, communityID, folderID, i,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrCommunityNotJoined
+		}
+	}
+	return nil
+}
+
+// writeTopLevel renumbers top-level items; communities listed here leave any folder
+func (s *Service) writeTopLevel(ctx context.Context, tx pgx.Tx, userID uuid.UUID, items []models.SidebarItem) error {
+	for i, item := range items {
+		var err error
+		switch item.Type {
+		case models.SidebarItemFolder:
+			_, err = tx.Exec(ctx,
+				`UPDATE community_folders SET position = $3 WHERE id = $2 AND user_id = $1`,
+				userID, item.ID, i,
+			)
+		case models.SidebarItemCommunity:
+			_, err = tx.Exec(ctx,
+				`UPDATE community_members SET folder_id = NULL, sidebar_position = $3
+				 WHERE user_id = $1 AND community_id = $2`,
+				userID, item.ID, i,
+			)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitSidebar commits a layout change and pushes the new layout to the user's other devices
+func (s *Service) commitSidebar(ctx context.Context, tx pgx.Tx, userID uuid.UUID) (*models.SidebarLayout, error) {
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	layout, err := s.GetSidebar(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.sendToUser(ctx, userID, "SIDEBAR_UPDATE", layout)
+	return layout, nil
+}
+
+func normalizeColor(color *string) *string {
+	if color == nil {
+		return nil
+	}
+	lower := strings.ToLower(*color)
+	return &lower
+}
+
+func removeID(ids []uuid.UUID, target uuid.UUID) []uuid.UUID {
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if id != target {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func dedupeIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	out := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func insertAt(ids []uuid.UUID, id uuid.UUID, position *int) []uuid.UUID {
+	if position == nil || *position >= len(ids) {
+		return append(ids, id)
+	}
+	out := make([]uuid.UUID, 0, len(ids)+1)
+	out = append(out, ids[:*position]...)
+	out = append(out, id)
+	return append(out, ids[*position:]...)
+}
+
+func removeItem(items []models.SidebarItem, target models.SidebarItem) []models.SidebarItem {
+	out := make([]models.SidebarItem, 0, len(items))
+	for _, item := range items {
+		if item != target {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func insertItemAt(items []models.SidebarItem, item models.SidebarItem, position *int) []models.SidebarItem {
+	if position == nil || *position >= len(items) {
+		return append(items, item)
+	}
+	out := make([]models.SidebarItem, 0, len(items)+1)
+	out = append(out, items[:*position]...)
+	out = append(out, item)
+	return append(out, items[*position:]...)
+}
@@ -39,6 +39,15 @@ func (h *Handler) Routes() chi.Router {
 	r.Delete("/me/friends/requests/{id}", h.RemoveFriendRequest)
 	r.Delete("/me/friends/{id}", h.RemoveFriend)
 
+	// Sidebar folders and ordering
+	r.Get("/me/sidebar", h.GetSidebar)
+	r.Put("/me/sidebar/order", h.ReorderSidebar)
+	r.Post("/me/sidebar/move", h.MoveCommunity)
+	r.Post("/me/folders", h.CreateFolder)
+	r.Patch("/me/folders/{id}", h.UpdateFolder)
+	r.Delete("/me/folders/{id}", h.DeleteFolder)
+	r.Put("/me/folders/{id}/order", h.ReorderFolder)
+
 	// Block management
 	r.Get("/me/blocks", h.GetBlockedUsers)
 	r.Post("/me/blocks/{id}", h.BlockUser)
@@ -46,6 +55,7 @@ func (h *Handler) Routes() chi.Router {
 
 	// User lookup routes
 	r.Get("/search", h.SearchUsers)
+	r.Get("/batch", h.GetUsers)
 	r.Get("/{id}", h.GetUser)
 	r.Get("/username/{username}", h.GetUserByUsername)
 
@@ -115,6 +125,24 @@ func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 	utils.RespondSuccess(w, user)
 }
 
+// GetUsers returns several users at once: GET /users/batch?ids=a,b,c. Users
+// that don't exist are left out.
+func (h *Handler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	ids, err := utils.GetQueryIDs(r, "ids", utils.MaxBatchIDs)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	users, err := h.service.GetPublicUsers(r.Context(), ids)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get users")
+		return
+	}
+
+	utils.RespondSuccess(w, users)
+}
+
 func (h *Handler) GetUserByUsername(w http.ResponseWriter, r *http.Request) {
 	username := chi.URLParam(r, "username")
 	if username == "" {
@@ -143,13 +171,7 @@ func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateProfileRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -169,16 +191,8 @@ func (h *Handler) UpdateStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Status string `json:"status" validate:"required,oneof=online away busy invisible offline"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
-		return
-	}
-
-	if err := utils.Validate(&req); err != nil {
-		utils.RespondValidationError(w, utils.FormatValidationErrors(err))
+	var req UpdateStatusRequest
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -197,17 +211,19 @@ func (h *Handler) SearchUsers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	page := utils.GetQueryInt(r, "page", 1)
-	pageSize := utils.GetQueryInt(r, "pageSize", 20)
-	offset := (page - 1) * pageSize
+	page := utils.GetCursorParams(r, 20, 50)
 
-	users, total, err := h.service.SearchUsers(r.Context(), query, pageSize, offset)
+	users, next, err := h.service.SearchUsers(r.Context(), query, page.Cursor, page.Limit)
 	if err != nil {
+		if err == utils.ErrInvalidCursor {
+			utils.RespondInvalidCursor(w)
+			return
+		}
 		utils.RespondError(w, http.StatusInternalServerError, "Failed to search users")
 		return
 	}
 
-	utils.RespondPaginated(w, users, total, page, pageSize)
+	utils.RespondCursorPage(w, users, next)
 }
 
 func (h *Handler) GetSettings(w http.ResponseWriter, r *http.Request) {
@@ -234,14 +250,18 @@ func (h *Handler) UpdateSettings(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateSettingsRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
 	settings, err := h.service.UpdateSettings(r.Context(), userID, &req)
 	if err != nil {
-		utils.RespondError(w, http.StatusInternalServerError, "Failed to update settings")
+		switch err {
+		case ErrInvalidQuietHours, ErrInvalidTimezone:
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to update settings")
+		}
 		return
 	}
 
@@ -499,3 +519,175 @@ func (h *Handler) UnblockUser(w http.ResponseWriter, r *http.Request) {
 
 	utils.RespondNoContent(w)
 }
+
+// GetSidebar returns the user's folders and community ordering
+func (h *Handler) GetSidebar(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	layout, err := h.service.GetSidebar(r.Context(), userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to get sidebar")
+		return
+	}
+
+	utils.RespondSuccess(w, layout)
+}
+
+// ReorderSidebar sets the order of top-level folders and communities
+func (h *Handler) ReorderSidebar(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req ReorderSidebarRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	layout, err := h.service.ReorderSidebar(r.Context(), userID, req.Items)
+	if err != nil {
+		respondSidebarError(w, err, "Failed to reorder sidebar")
+		return
+	}
+
+	utils.RespondSuccess(w, layout)
+}
+
+// MoveCommunity moves a community into a folder or back onto the top level
+func (h *Handler) MoveCommunity(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req MoveCommunityRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	layout, err := h.service.MoveCommunity(r.Context(), userID, &req)
+	if err != nil {
+		respondSidebarError(w, err, "Failed to move community")
+		return
+	}
+
+	utils.RespondSuccess(w, layout)
+}
+
+func (h *Handler) CreateFolder(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req CreateFolderRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	layout, err := h.service.CreateFolder(r.Context(), userID, &req)
+	if err != nil {
+		respondSidebarError(w, err, "Failed to create folder")
+		return
+	}
+
+	utils.RespondCreated(w, layout)
+}
+
+func (h *Handler) UpdateFolder(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	folderID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid folder ID")
+		return
+	}
+
+	var req UpdateFolderRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	layout, err := h.service.UpdateFolder(r.Context(), userID, folderID, &req)
+	if err != nil {
+		respondSidebarError(w, err, "Failed to update folder")
+		return
+	}
+
+	utils.RespondSuccess(w, layout)
+}
+
+func (h *Handler) DeleteFolder(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	folderID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid folder ID")
+		return
+	}
+
+	layout, err := h.service.DeleteFolder(r.Context(), userID, folderID)
+	if err != nil {
+		respondSidebarError(w, err, "Failed to delete folder")
+		return
+	}
+
+	utils.RespondSuccess(w, layout)
+}
+
+// ReorderFolder sets the ordered contents of a folder
+func (h *Handler) ReorderFolder(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	folderID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid folder ID")
+		return
+	}
+
+	var req ReorderFolderRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	layout, err := h.service.ReorderFolder(r.Context(), userID, folderID, req.CommunityIDs)
+	if err != nil {
+		respondSidebarError(w, err, "Failed to reorder folder")
+		return
+	}
+
+	utils.RespondSuccess(w, layout)
+}
+
+func respondSidebarError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case ErrFolderNotFound:
+		utils.RespondError(w, http.StatusNotFound, "Folder not found")
+	case ErrCommunityNotJoined:
+		utils.RespondError(w, http.StatusNotFound, "Community not found")
+	case ErrTooManyFolders, ErrInvalidSidebarItem:
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,153 @@
+package user
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	id := []openapi.Param{openapi.ID("id")}
+
+	openapi.Describe((*Handler).GetCurrentUser, openapi.Operation{
+		Summary:  "Get the current user",
+		Response: models.User{},
+	})
+	openapi.Describe((*Handler).GetCurrentUserID, openapi.Operation{
+		Summary:  "Get the current user's ID",
+		Response: map[string]string{},
+	})
+	openapi.Describe((*Handler).UpdateProfile, openapi.Operation{
+		Summary:  "Update the current user's profile",
+		Body:     UpdateProfileRequest{},
+		Response: models.User{},
+	})
+	openapi.Describe((*Handler).RemoveAvatar, openapi.Operation{
+		Summary: "Remove the current user's avatar",
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetSettings, openapi.Operation{
+		Summary:  "Get the current user's settings",
+		Response: models.UserSettings{},
+	})
+	openapi.Describe((*Handler).UpdateSettings, openapi.Operation{
+		Summary:  "Update the current user's settings",
+		Body:     UpdateSettingsRequest{},
+		Response: models.UserSettings{},
+	})
+	openapi.Describe((*Handler).UpdateStatus, openapi.Operation{
+		Summary: "Set the current user's status",
+		Body:    UpdateStatusRequest{},
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetRelationship, openapi.Operation{
+		Summary:  "Get the current user's relationship with a user",
+		Path:     id,
+		Response: models.UserRelationship{},
+	})
+
+	openapi.Describe((*Handler).GetFriends, openapi.Operation{
+		Summary:  "List friends",
+		Response: []*models.PublicUser{},
+	})
+	openapi.Describe((*Handler).GetFriendRequests, openapi.Operation{
+		Summary:  "List incoming and outgoing friend requests",
+		Response: models.FriendRequests{},
+	})
+	openapi.Describe((*Handler).SendFriendRequest, openapi.Operation{
+		Summary: "Send a friend request",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).AcceptFriendRequest, openapi.Operation{
+		Summary: "Accept a friend request",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RemoveFriendRequest, openapi.Operation{
+		Summary: "Cancel or decline a friend request",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).RemoveFriend, openapi.Operation{
+		Summary: "Remove a friend",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).GetSidebar, openapi.Operation{
+		Summary:  "Get the sidebar layout",
+		Response: models.SidebarLayout{},
+	})
+	openapi.Describe((*Handler).ReorderSidebar, openapi.Operation{
+		Summary:  "Reorder the top level of the sidebar",
+		Body:     ReorderSidebarRequest{},
+		Response: models.SidebarLayout{},
+	})
+	openapi.Describe((*Handler).MoveCommunity, openapi.Operation{
+		Summary:  "Move a community into or out of a folder",
+		Body:     MoveCommunityRequest{},
+		Response: models.SidebarLayout{},
+	})
+	openapi.Describe((*Handler).CreateFolder, openapi.Operation{
+		Summary:  "Create a sidebar folder",
+		Body:     CreateFolderRequest{},
+		Response: models.SidebarLayout{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).UpdateFolder, openapi.Operation{
+		Summary:  "Rename or recolor a sidebar folder",
+		Path:     id,
+		Body:     UpdateFolderRequest{},
+		Response: models.SidebarLayout{},
+	})
+	openapi.Describe((*Handler).DeleteFolder, openapi.Operation{
+		Summary:  "Delete a sidebar folder, keeping its communities",
+		Path:     id,
+		Response: models.SidebarLayout{},
+	})
+	openapi.Describe((*Handler).ReorderFolder, openapi.Operation{
+		Summary:  "Set the communities in a sidebar folder, in order",
+		Path:     id,
+		Body:     ReorderFolderRequest{},
+		Response: models.SidebarLayout{},
+	})
+
+	openapi.Describe((*Handler).GetBlockedUsers, openapi.Operation{
+		Summary:  "List blocked users",
+		Response: []*models.PublicUser{},
+	})
+	openapi.Describe((*Handler).BlockUser, openapi.Operation{
+		Summary: "Block a user",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UnblockUser, openapi.Operation{
+		Summary: "Unblock a user",
+		Path:    id,
+		Status:  http.StatusNoContent,
+	})
+
+	openapi.Describe((*Handler).SearchUsers, openapi.Operation{
+		Summary:  "Search users by name",
+		Query:    append([]openapi.Param{{Name: "q", Required: true, Description: "Search text"}}, openapi.CursorQuery...),
+		Response: []*models.PublicUser{},
+		Shape:    openapi.Page,
+	})
+	openapi.Describe((*Handler).GetUsers, openapi.Operation{
+		Summary:     "Get several users",
+		Description: "Users that don't exist are left out.",
+		Query:       openapi.IDsQuery,
+		Response:    []*models.PublicUser{},
+	})
+	openapi.Describe((*Handler).GetUser, openapi.Operation{
+		Summary:  "Get a user",
+		Path:     id,
+		Response: models.PublicUser{},
+	})
+	openapi.Describe((*Handler).GetUserByUsername, openapi.Operation{
+		Summary:  "Get a user by username",
+		Response: models.PublicUser{},
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -12,6 +13,7 @@ import (
 	"github.com/redis/go-redis/v9"
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/utils"
 	"github.com/zentra/server/pkg/database"
 )
 
@@ -29,6 +31,8 @@ var (
 	ErrCannotAcceptOwnRequest  = errors.New("cannot accept your own friend request")
 	ErrCannotRemoveSelfRequest = errors.New("cannot remove a friend request to yourself")
 	ErrCannotRemoveSelfFriend  = errors.New("cannot remove yourself as a friend")
+	ErrInvalidQuietHours       = errors.New("quiet hours must start and end at different times")
+	ErrInvalidTimezone         = errors.New("unknown timezone")
 )
 
 type Service struct {
@@ -66,12 +70,98 @@ func (s *Service) broadcast(ctx context.Context, userID uuid.UUID, eventType str
 		return
 	}
 
-	err = s.redis.Publish(ctx, "websocket:broadcast", jsonData).Err()
+	err = database.PublishBroadcast(ctx, jsonData)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to publish user update to Redis")
 	}
 }
 
+// sendToUser publishes an event to every connected session of a single user,
+// across gateway instances, e.g. to sync settings between devices.
+func (s *Service) sendToUser(ctx context.Context, userID uuid.UUID, eventType string, data interface{}) {
+	broadcast := struct {
+		ChannelID string      `json:"channelId"`
+		Event     interface{} `json:"event"`
+	}{
+		ChannelID: database.UserStream(userID.String()),
+		Event: struct {
+			Type string      `json:"type"`
+			Data interface{} `json:"data"`
+		}{Type: eventType, Data: data},
+	}
+
+	jsonData, err := json.Marshal(broadcast)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal user event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, jsonData); err != nil {
+		log.Error().Err(err).Msg("Failed to publish user event to Redis")
+	}
+}
+
+// publishPresence sends a PRESENCE_UPDATE on the user's presence stream,
+// which gateways deliver to users who share a community or DM with them
+func (s *Service) publishPresence(ctx context.Context, userID uuid.UUID, status models.UserStatus) {
+	broadcast := struct {
+		ChannelID string      `json:"channelId"`
+		Event     interface{} `json:"event"`
+	}{
+		ChannelID: database.PresenceStream(userID.String()),
+		Event: struct {
+			Type string      `json:"type"`
+			Data interface{} `json:"data"`
+		}{Type: "PRESENCE_UPDATE", Data: map[string]interface{}{
+			"userId": userID.String(),
+			"status": string(status),
+		}},
+	}
+
+	jsonData, err := json.Marshal(broadcast)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to marshal presence update")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, jsonData); err != nil {
+		log.Error().Err(err).Msg("Failed to publish presence update to Redis")
+	}
+}
+
+// SharedUsers returns the users in candidates who share a community or a DM
+// conversation with userID, i.e. who may see their presence
+func (s *Service) SharedUsers(ctx context.Context, userID uuid.UUID, candidates []uuid.UUID) ([]uuid.UUID, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT cm.user_id FROM community_members cm
+		WHERE cm.user_id = ANY($2)
+		AND cm.community_id IN (SELECT community_id FROM community_members WHERE user_id = $1)
+		UNION
+		SELECT dp.user_id FROM dm_participants dp
+		WHERE dp.user_id = ANY($2)
+		AND dp.conversation_id IN (SELECT conversation_id FROM dm_participants WHERE user_id = $1)`,
+		userID, candidates,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shared []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		shared = append(shared, id)
+	}
+	return shared, rows.Err()
+}
+
 func (s *Service) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
 	user := &models.User{}
 	err := s.db.QueryRow(ctx,
@@ -101,6 +191,35 @@ func (s *Service) GetPublicUser(ctx context.Context, id uuid.UUID) (*models.Publ
 	return user.ToPublic(), nil
 }
 
+// GetPublicUsers returns the users among ids, in no particular order. Missing
+// and deleted users are left out.
+func (s *Service) GetPublicUsers(ctx context.Context, ids []uuid.UUID) ([]*models.PublicUser, error) {
+	users := []*models.PublicUser{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	rows, err := s.db.Query(ctx,
+		`SELECT id, username, display_name, avatar_url, bio, status, custom_status, created_at
+		FROM users WHERE id = ANY($1) AND deleted_at IS NULL`,
+		ids,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u := &models.PublicUser{}
+		err := rows.Scan(&u.ID, &u.Username, &u.DisplayName, &u.AvatarURL, &u.Bio, &u.Status, &u.CustomStatus, &u.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
 func (s *Service) GetUserByUsername(ctx context.Context, username string) (*models.PublicUser, error) {
 	user := &models.User{}
 	err := s.db.QueryRow(ctx,
@@ -174,6 +293,10 @@ func (s *Service) RemoveAvatar(ctx context.Context, userID uuid.UUID) error {
 	return err
 }
 
+type UpdateStatusRequest struct {
+	Status string `json:"status" validate:"required,oneof=online away busy invisible offline"`
+}
+
 func (s *Service) UpdateStatus(ctx context.Context, userID uuid.UUID, status models.UserStatus) error {
 	_, err := s.db.Exec(ctx,
 		`UPDATE users
@@ -187,13 +310,12 @@ func (s *Service) UpdateStatus(ctx context.Context, userID uuid.UUID, status mod
 		return err
 	}
 
+	// The user's own sessions see the status they picked; everyone else
+	// hears about it through their presence stream, where invisible reads as
+	// offline
 	if user, err := s.GetUserByID(ctx, userID); err == nil {
-		s.broadcast(ctx, userID, "USER_UPDATE", user)
-		// Also send explicit presence update
-		s.broadcast(ctx, userID, "PRESENCE_UPDATE", map[string]interface{}{
-			"userId": userID.String(),
-			"status": string(status),
-		})
+		s.sendToUser(ctx, userID, "USER_UPDATE", user)
+		s.publishPresence(ctx, userID, status.Public())
 	}
 
 	// Also update Redis presence
@@ -230,6 +352,27 @@ func (s *Service) MarkAllUsersOffline(ctx context.Context) error {
 	return nil
 }
 
+// ListNonOfflineUserIDs returns every user whose stored status is not offline.
+func (s *Service) ListNonOfflineUserIDs(ctx context.Context) ([]uuid.UUID, error) {
+	rows, err := s.db.Query(ctx,
+		`SELECT id FROM users WHERE deleted_at IS NULL AND status <> 'offline'`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
 func clearRedisKeysByPattern(ctx context.Context, client *redis.Client, pattern string) error {
 	var cursor uint64
 
@@ -254,31 +397,42 @@ func clearRedisKeysByPattern(ctx context.Context, client *redis.Client, pattern
 	return nil
 }
 
-func (s *Service) SearchUsers(ctx context.Context, query string, limit, offset int) ([]*models.PublicUser, int64, error) {
+// searchCursor is the position after a user in search results
+type searchCursor struct {
+	Username string    `json:"u"`
+	ID       uuid.UUID `json:"id"`
+}
+
+// SearchUsers returns a page of users whose username or display name matches
+// query, by username, and the cursor of the next page or "" on the last one
+func (s *Service) SearchUsers(ctx context.Context, query, cursor string, limit int) ([]*models.PublicUser, string, error) {
 	if limit <= 0 || limit > 50 {
 		limit = 20
 	}
 
-	var total int64
-	err := s.db.QueryRow(ctx,
-		`SELECT COUNT(*) FROM users 
-		WHERE deleted_at IS NULL AND (username ILIKE $1 OR display_name ILIKE $1)`,
-		"%"+query+"%",
-	).Scan(&total)
-	if err != nil {
-		return nil, 0, err
+	// One extra row tells whether there is a next page
+	selectQuery := `SELECT id, username, display_name, avatar_url, bio, status, custom_status, created_at
+		FROM users
+		WHERE deleted_at IS NULL AND (username ILIKE $1 OR display_name ILIKE $1)`
+	args := []any{"%" + query + "%", limit + 1}
+	if cursor != "" {
+		var after searchCursor
+		if err := utils.DecodeCursor(cursor, &after); err != nil {
+			return nil, "", err
+		}
+		if after.ID == uuid.Nil {
+			return nil, "", utils.ErrInvalidCursor
+		}
+		selectQuery += ` AND (username, id) > ($3, $4)`
+		args = append(args, after.Username, after.ID)
 	}
-
-	rows, err := s.db.Query(ctx,
-		`SELECT id, username, display_name, avatar_url, bio, status, custom_status, created_at
-		FROM users 
-		WHERE deleted_at IS NULL AND (username ILIKE $1 OR display_name ILIKE $1)
-		ORDER BY username
-		LIMIT $2 OFFSET $3`,
-		"%"+query+"%", limit, offset,
+	rows, err := s.db.Query(ctx, selectQuery+`
+		ORDER BY username, id
+		LIMIT $2`,
+		args...,
 	)
 	if err != nil {
-		return nil, 0, err
+		return nil, "", err
 	}
 	defer rows.Close()
 
@@ -290,12 +444,21 @@ func (s *Service) SearchUsers(ctx context.Context, query string, limit, offset i
 			&user.Bio, &user.Status, &user.CustomStatus, &user.CreatedAt,
 		)
 		if err != nil {
-			return nil, 0, err
+			return nil, "", err
 		}
 		users = append(users, user)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return users, total, nil
+	var next string
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[limit-1]
+		next = utils.EncodeCursor(searchCursor{Username: last.Username, ID: last.ID})
+	}
+	return users, next, nil
 }
 
 // User Settings
@@ -303,12 +466,15 @@ func (s *Service) SearchUsers(ctx context.Context, query string, limit, offset i
 func (s *Service) GetSettings(ctx context.Context, userID uuid.UUID) (*models.UserSettings, error) {
 	settings := &models.UserSettings{}
 	err := s.db.QueryRow(ctx,
-		`SELECT user_id, theme, notifications_enabled, sound_enabled, compact_mode, settings_json, updated_at
+		`SELECT user_id, theme, notifications_enabled, sound_enabled, compact_mode, settings_json,
+			quiet_hours_enabled, quiet_hours_start, quiet_hours_end, quiet_hours_timezone, updated_at
 		FROM user_settings WHERE user_id = $1`,
 		userID,
 	).Scan(
 		&settings.UserID, &settings.Theme, &settings.NotificationsEnabled,
-		&settings.SoundEnabled, &settings.CompactMode, &settings.SettingsJSON, &settings.UpdatedAt,
+		&settings.SoundEnabled, &settings.CompactMode, &settings.SettingsJSON,
+		&settings.QuietHours.Enabled, &settings.QuietHours.StartMinute,
+		&settings.QuietHours.EndMinute, &settings.QuietHours.Timezone, &settings.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -320,6 +486,7 @@ func (s *Service) GetSettings(ctx context.Context, userID uuid.UUID) (*models.Us
 				SoundEnabled:         true,
 				CompactMode:          false,
 				SettingsJSON:         json.RawMessage("{}"),
+				QuietHours:           defaultQuietHours,
 			}
 			_, err = s.db.Exec(ctx,
 				`INSERT INTO user_settings (user_id, theme, notifications_enabled, sound_enabled, compact_mode, settings_json)
@@ -340,15 +507,34 @@ func (s *Service) GetSettings(ctx context.Context, userID uuid.UUID) (*models.Us
 	return settings, nil
 }
 
+// defaultQuietHours matches the column defaults: off, 22:00 to 07:00 UTC
+var defaultQuietHours = models.QuietHours{StartMinute: 22 * 60, EndMinute: 7 * 60, Timezone: "UTC"}
+
 type UpdateSettingsRequest struct {
-	Theme                *string         `json:"theme" validate:"omitempty,oneof=dark light"`
-	NotificationsEnabled *bool           `json:"notificationsEnabled"`
-	SoundEnabled         *bool           `json:"soundEnabled"`
-	CompactMode          *bool           `json:"compactMode"`
-	SettingsJSON         json.RawMessage `json:"settings"`
+	Theme                *string                  `json:"theme" validate:"omitempty,oneof=dark light"`
+	NotificationsEnabled *bool                    `json:"notificationsEnabled"`
+	SoundEnabled         *bool                    `json:"soundEnabled"`
+	CompactMode          *bool                    `json:"compactMode"`
+	SettingsJSON         json.RawMessage          `json:"settings"`
+	QuietHours           *UpdateQuietHoursRequest `json:"quietHours"`
+}
+
+// UpdateQuietHoursRequest changes quiet hours. Omitted fields are kept.
+type UpdateQuietHoursRequest struct {
+	Enabled     *bool   `json:"enabled"`
+	StartMinute *int    `json:"startMinute" validate:"omitempty,min=0,max=1439"`
+	EndMinute   *int    `json:"endMinute" validate:"omitempty,min=0,max=1439"`
+	Timezone    *string `json:"timezone" validate:"omitempty,max=64"`
 }
 
 func (s *Service) UpdateSettings(ctx context.Context, userID uuid.UUID, req *UpdateSettingsRequest) (*models.UserSettings, error) {
+	// Quiet hours are validated first so a bad window doesn't half-apply the update
+	if req.QuietHours != nil {
+		if err := s.updateQuietHours(ctx, userID, req.QuietHours); err != nil {
+			return nil, err
+		}
+	}
+
 	// Build dynamic update query
 	query := `UPDATE user_settings SET updated_at = NOW()`
 	args := []interface{}{userID}
@@ -387,7 +573,123 @@ func (s *Service) UpdateSettings(ctx context.Context, userID uuid.UUID, req *Upd
 		return nil, err
 	}
 
-	return s.GetSettings(ctx, userID)
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.sendToUser(ctx, userID, "USER_SETTINGS_UPDATE", settings)
+	return settings, nil
+}
+
+// updateQuietHours merges req into the stored quiet hours. Turning them off
+// releases held notifications on the next catch-up pass instead of at the
+// end of the window.
+func (s *Service) updateQuietHours(ctx context.Context, userID uuid.UUID, req *UpdateQuietHoursRequest) error {
+	current, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		return err
+	}
+	quiet := current.QuietHours
+	if req.Enabled != nil {
+		quiet.Enabled = *req.Enabled
+	}
+	if req.StartMinute != nil {
+		quiet.StartMinute = *req.StartMinute
+	}
+	if req.EndMinute != nil {
+		quiet.EndMinute = *req.EndMinute
+	}
+	if req.Timezone != nil {
+		quiet.Timezone = strings.TrimSpace(*req.Timezone)
+	}
+
+	if err := checkQuietHours(quiet); err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(ctx,
+		`UPDATE user_settings SET
+			quiet_hours_enabled = $2, quiet_hours_start = $3,
+			quiet_hours_end = $4, quiet_hours_timezone = $5
+		WHERE user_id = $1`,
+		userID, quiet.Enabled, quiet.StartMinute, quiet.EndMinute, quiet.Timezone,
+	)
+	if err != nil {
+		return err
+	}
+
+	if !quiet.Enabled && current.QuietHours.Enabled {
+		_, err = s.db.Exec(ctx,
+			`UPDATE notifications SET held_until = NOW() WHERE user_id = $1 AND held_until > NOW()`,
+			userID,
+		)
+	}
+	return err
+}
+
+func checkQuietHours(quiet models.QuietHours) error {
+	if quiet.StartMinute == quiet.EndMinute {
+		return ErrInvalidQuietHours
+	}
+	// LoadLocation accepts "" and "Local", which mean the server's zone
+	if quiet.Timezone == "" || quiet.Timezone == "Local" {
+		return ErrInvalidTimezone
+	}
+	if _, err := time.LoadLocation(quiet.Timezone); err != nil {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
+
+// ReplaceSettings overwrites all of the user's settings as part of tx, e.g.
+// when an account is imported. Call SettingsChanged once tx has committed.
+func (s *Service) ReplaceSettings(ctx context.Context, tx pgx.Tx, userID uuid.UUID, settings *models.UserSettings) error {
+	if err := checkQuietHours(settings.QuietHours); err != nil {
+		return err
+	}
+
+	var wasQuiet bool
+	err := tx.QueryRow(ctx,
+		`INSERT INTO user_settings (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING quiet_hours_enabled`,
+		userID,
+	).Scan(&wasQuiet)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE user_settings SET
+			theme = $2, notifications_enabled = $3, sound_enabled = $4, compact_mode = $5,
+			settings_json = $6, quiet_hours_enabled = $7, quiet_hours_start = $8,
+			quiet_hours_end = $9, quiet_hours_timezone = $10, updated_at = NOW()
+		WHERE user_id = $1`,
+		userID, settings.Theme, settings.NotificationsEnabled, settings.SoundEnabled, settings.CompactMode,
+		settings.SettingsJSON, settings.QuietHours.Enabled, settings.QuietHours.StartMinute,
+		settings.QuietHours.EndMinute, settings.QuietHours.Timezone,
+	)
+	if err != nil {
+		return err
+	}
+
+	if wasQuiet && !settings.QuietHours.Enabled {
+		_, err = tx.Exec(ctx,
+			`UPDATE notifications SET held_until = NOW() WHERE user_id = $1 AND held_until > NOW()`,
+			userID,
+		)
+	}
+	return err
+}
+
+// SettingsChanged sends the user's current settings to their sessions
+func (s *Service) SettingsChanged(ctx context.Context, userID uuid.UUID) {
+	settings, err := s.GetSettings(ctx, userID)
+	if err != nil {
+		log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to load settings to send")
+		return
+	}
+	s.sendToUser(ctx, userID, "USER_SETTINGS_UPDATE", settings)
 }
 
 func sortedFriendPair(first, second uuid.UUID) (uuid.UUID, uuid.UUID) {
@@ -0,0 +1,49 @@
+package voice
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// DM calls get rooms of their own, call-<callId>, so they never collide with
+// a voice channel's and the webhook leaves them alone
+const sfuCallRoomPrefix = "call-"
+
+func sfuCallRoom(callID uuid.UUID) string {
+	return sfuCallRoomPrefix + callID.String()
+}
+
+// CallCredentials mints a token for userID to join a DM call's room. The
+// calls service decides who may join; everyone in a call can speak and
+// listen. Returns nil without an SFU.
+func (s *Service) CallCredentials(ctx context.Context, callID, userID uuid.UUID) (*SFUCredentials, error) {
+	if s.sfu == nil {
+		return nil, nil
+	}
+	return s.sfu.roomToken(sfuCallRoom(callID), userID, s.participantName(ctx, userID), true, true)
+}
+
+// LeaveCallRoom disconnects userID from a DM call's room
+func (s *Service) LeaveCallRoom(ctx context.Context, callID, userID uuid.UUID) {
+	if s.sfu == nil {
+		return
+	}
+	if err := s.sfu.removeParticipant(ctx, sfuCallRoom(callID), userID); err != nil {
+		log.Warn().Err(err).
+			Str("callId", callID.String()).
+			Str("userId", userID.String()).
+			Msg("Failed to remove participant from call room")
+	}
+}
+
+// EndCallRoom closes a DM call's room, disconnecting anyone still in it
+func (s *Service) EndCallRoom(ctx context.Context, callID uuid.UUID) {
+	if s.sfu == nil {
+		return
+	}
+	if err := s.sfu.deleteRoom(ctx, sfuCallRoom(callID)); err != nil {
+		log.Warn().Err(err).Str("callId", callID.String()).Msg("Failed to close call room")
+	}
+}
@@ -1,12 +1,17 @@
 package voice
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/middleware"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
 	"github.com/zentra/server/internal/utils"
 )
 
@@ -28,14 +33,54 @@ func (h *Handler) Routes() chi.Router {
 		r.Post("/leave", h.LeaveChannel)
 		r.Patch("/state", h.UpdateVoiceState)
 		r.Post("/mute/{userId}", h.ServerMuteUser)
+		r.Post("/deafen/{userId}", h.ServerDeafenUser)
+		r.Post("/move/{userId}", h.MoveUser)
+
+		// SFU rooms
+		r.Post("/token", h.GetSFUToken)
+		r.Get("/recordings", h.ListRecordings)
+		r.Post("/recordings", h.StartRecording)
+		r.Delete("/recordings/{egressId}", h.StopRecording)
+		r.Get("/ingresses", h.ListIngresses)
+		r.Post("/ingresses", h.CreateIngress)
+		r.Delete("/ingresses/{ingressId}", h.DeleteIngress)
 	})
 
 	// Current user voice state
 	r.Get("/me", h.GetMyVoiceState)
 
+	// STUN/TURN servers for peer-to-peer connections
+	r.Get("/ice-servers", h.GetICEServers)
+
+	// Connection quality reports
+	r.Post("/qos", h.ReportQoS)
+
+	return r
+}
+
+// AdminRoutes are operator endpoints and must be mounted behind
+// middleware.AdminTokenMiddleware
+func (h *Handler) AdminRoutes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/qos", h.GetQoSSummary)
+	return r
+}
+
+// WebhookRoutes receives LiveKit's webhooks, which are signed with the API
+// secret rather than sent with a user session
+func (h *Handler) WebhookRoutes() chi.Router {
+	r := chi.NewRouter()
+	r.Post("/webhook", h.ReceiveWebhook)
 	return r
 }
 
+// JoinResponse is the caller's voice state plus, when voice goes through an
+// SFU, the credentials to connect to the channel's room
+type JoinResponse struct {
+	*models.VoiceState
+	SFU *SFUCredentials `json:"sfu,omitempty"`
+}
+
 func (h *Handler) JoinChannel(w http.ResponseWriter, r *http.Request) {
 	userID, err := middleware.RequireAuth(r.Context())
 	if err != nil {
@@ -51,6 +96,15 @@ func (h *Handler) JoinChannel(w http.ResponseWriter, r *http.Request) {
 
 	state, err := h.service.JoinChannel(r.Context(), channelID, userID)
 	if err != nil {
+		var full *ChannelFullError
+		if errors.As(err, &full) {
+			utils.RespondJSON(w, http.StatusForbidden, utils.ErrorResponse{
+				Error:   "This voice channel is full",
+				Code:    "CHANNEL_FULL",
+				Details: full,
+			})
+			return
+		}
 		switch err {
 		case ErrNotVoiceChannel:
 			utils.RespondError(w, http.StatusBadRequest, "Not a voice channel")
@@ -64,7 +118,13 @@ func (h *Handler) JoinChannel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	utils.RespondSuccess(w, state)
+	sfu, err := h.service.SFUCredentials(r.Context(), channelID, userID)
+	if err != nil {
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to create voice room token")
+		return
+	}
+
+	utils.RespondSuccess(w, JoinResponse{VoiceState: state, SFU: sfu})
 }
 
 func (h *Handler) LeaveChannel(w http.ResponseWriter, r *http.Request) {
@@ -137,66 +197,99 @@ func (h *Handler) UpdateVoiceState(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		IsSelfMuted     *bool `json:"isSelfMuted"`
-		IsSelfDeafened  *bool `json:"isSelfDeafened"`
-		IsScreenSharing *bool `json:"isScreenSharing"`
-	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	var req StateUpdate
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
-	state, err := h.service.UpdateVoiceState(r.Context(), channelID, userID, req.IsSelfMuted, req.IsSelfDeafened, req.IsScreenSharing)
+	state, err := h.service.UpdateVoiceState(r.Context(), channelID, userID, &req)
 	if err != nil {
-		if err == ErrNotInVoiceChannel {
+		switch err {
+		case ErrNotInVoiceChannel:
 			utils.RespondError(w, http.StatusNotFound, "Not in this voice channel")
-			return
+		case ErrVideoLimitReached, ErrScreenLimitReached:
+			utils.RespondErrorWithCode(w, http.StatusConflict, "STREAM_LIMIT_REACHED", err.Error())
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to update voice state")
 		}
-		utils.RespondError(w, http.StatusInternalServerError, "Failed to update voice state")
 		return
 	}
 
 	utils.RespondSuccess(w, state)
 }
 
+type MuteRequest struct {
+	Muted bool `json:"muted"`
+}
+
 func (h *Handler) ServerMuteUser(w http.ResponseWriter, r *http.Request) {
-	actorID, err := middleware.RequireAuth(r.Context())
-	if err != nil {
-		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+	actorID, channelID, targetUserID, ok := memberParams(w, r)
+	if !ok {
 		return
 	}
 
-	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	var req MuteRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	state, err := h.service.ServerMuteUser(r.Context(), channelID, targetUserID, actorID, req.Muted)
 	if err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		respondModerationError(w, err, "Failed to mute user")
 		return
 	}
 
-	targetUserID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	utils.RespondSuccess(w, state)
+}
+
+type DeafenRequest struct {
+	Deafened bool `json:"deafened"`
+}
+
+func (h *Handler) ServerDeafenUser(w http.ResponseWriter, r *http.Request) {
+	actorID, channelID, targetUserID, ok := memberParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeafenRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	state, err := h.service.ServerDeafenUser(r.Context(), channelID, targetUserID, actorID, req.Deafened)
 	if err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		respondModerationError(w, err, "Failed to deafen user")
+		return
+	}
+
+	utils.RespondSuccess(w, state)
+}
+
+type MoveRequest struct {
+	ChannelID string `json:"channelId" validate:"required,uuid"`
+}
+
+// MoveUser moves a member from this voice channel into another
+func (h *Handler) MoveUser(w http.ResponseWriter, r *http.Request) {
+	actorID, channelID, targetUserID, ok := memberParams(w, r)
+	if !ok {
 		return
 	}
 
-	var req struct {
-		Muted bool `json:"muted"`
+	var req MoveRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
 	}
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	toChannelID, err := uuid.Parse(req.ChannelID)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
 		return
 	}
 
-	state, err := h.service.ServerMuteUser(r.Context(), channelID, targetUserID, actorID, req.Muted)
+	state, err := h.service.MoveUser(r.Context(), channelID, targetUserID, toChannelID, actorID)
 	if err != nil {
-		switch err {
-		case ErrInsufficientPerms:
-			utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
-		case ErrNotInVoiceChannel:
-			utils.RespondError(w, http.StatusNotFound, "User not in this voice channel")
-		default:
-			utils.RespondError(w, http.StatusInternalServerError, "Failed to mute user")
-		}
+		respondModerationError(w, err, "Failed to move user")
 		return
 	}
 
@@ -222,3 +315,295 @@ func (h *Handler) GetMyVoiceState(w http.ResponseWriter, r *http.Request) {
 
 	utils.RespondSuccess(w, state)
 }
+
+// GetICEServers returns STUN servers and short-lived TURN credentials for
+// the caller
+func (h *Handler) GetICEServers(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	// The credentials are per user and expire; caches must not reuse them
+	w.Header().Set("Cache-Control", "no-store")
+	utils.RespondSuccess(w, h.service.ICEServers(userID))
+}
+
+// ReportQoS records the caller's connection quality in a voice channel or
+// call, answering with a region to switch to when theirs is struggling
+func (h *Handler) ReportQoS(w http.ResponseWriter, r *http.Request) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	var req QoSReport
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	result, err := h.service.ReportQoS(r.Context(), userID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidQoSSession), errors.Is(err, ErrInvalidRegion):
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrNotInSession):
+			utils.RespondErrorWithCode(w, http.StatusConflict, "NOT_CONNECTED", err.Error())
+		case errors.Is(err, ErrQoSRateLimited):
+			utils.RespondRateLimited(w, "QOS_RATE_LIMITED", err.Error(), QoSReportInterval)
+		default:
+			utils.RespondError(w, http.StatusInternalServerError, "Failed to record quality report")
+		}
+		return
+	}
+
+	utils.RespondSuccess(w, result)
+}
+
+// GetQoSSummary aggregates quality reports by region or channel
+// (?groupBy=region|channel) over the last hour, or ?since= a duration ago
+func (h *Handler) GetQoSSummary(w http.ResponseWriter, r *http.Request) {
+	span := time.Hour
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 || d > MaxQoSReportSpan {
+			utils.RespondError(w, http.StatusBadRequest, "since must be a duration of up to 168h")
+			return
+		}
+		span = d
+	}
+
+	summary, err := h.service.QoSSummary(r.Context(), r.URL.Query().Get("groupBy"), time.Now().Add(-span))
+	if err != nil {
+		if errors.Is(err, ErrInvalidQoSGroup) {
+			utils.RespondError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to build quality report")
+		return
+	}
+
+	utils.RespondSuccess(w, summary)
+}
+
+// GetSFUToken mints a fresh token for the room of the voice channel the
+// caller is in, e.g. to reconnect after the one from joining expired
+func (h *Handler) GetSFUToken(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+	if !h.service.SFUEnabled() {
+		respondSFUError(w, ErrSFUNotConfigured, "")
+		return
+	}
+
+	sfu, err := h.service.SFUCredentials(r.Context(), channelID, userID)
+	if err != nil {
+		respondSFUError(w, err, "Failed to create voice room token")
+		return
+	}
+
+	utils.RespondSuccess(w, sfu)
+}
+
+func (h *Handler) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	recordings, err := h.service.ListRecordings(r.Context(), channelID, userID)
+	if err != nil {
+		respondSFUError(w, err, "Failed to list recordings")
+		return
+	}
+	if recordings == nil {
+		recordings = []Egress{}
+	}
+
+	utils.RespondSuccess(w, recordings)
+}
+
+func (h *Handler) StartRecording(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	recording, err := h.service.StartRecording(r.Context(), channelID, userID)
+	if err != nil {
+		respondSFUError(w, err, "Failed to start recording")
+		return
+	}
+
+	utils.RespondCreated(w, recording)
+}
+
+func (h *Handler) StopRecording(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	recording, err := h.service.StopRecording(r.Context(), channelID, userID, chi.URLParam(r, "egressId"))
+	if err != nil {
+		respondSFUError(w, err, "Failed to stop recording")
+		return
+	}
+
+	utils.RespondSuccess(w, recording)
+}
+
+func (h *Handler) ListIngresses(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	ingresses, err := h.service.ListIngresses(r.Context(), channelID, userID)
+	if err != nil {
+		respondSFUError(w, err, "Failed to list ingresses")
+		return
+	}
+	if ingresses == nil {
+		ingresses = []Ingress{}
+	}
+
+	utils.RespondSuccess(w, ingresses)
+}
+
+type CreateIngressRequest struct {
+	InputType string `json:"inputType" validate:"required"`
+	Name      string `json:"name" validate:"required,max=100"`
+}
+
+func (h *Handler) CreateIngress(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateIngressRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	ingress, err := h.service.CreateIngress(r.Context(), channelID, userID, req.InputType, req.Name)
+	if err != nil {
+		respondSFUError(w, err, "Failed to create ingress")
+		return
+	}
+
+	utils.RespondCreated(w, ingress)
+}
+
+func (h *Handler) DeleteIngress(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.DeleteIngress(r.Context(), channelID, userID, chi.URLParam(r, "ingressId")); err != nil {
+		respondSFUError(w, err, "Failed to delete ingress")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+// ReceiveWebhook applies a LiveKit webhook to voice states
+func (h *Handler) ReceiveWebhook(w http.ResponseWriter, r *http.Request) {
+	bodyReader := http.MaxBytesReader(w, r.Body, MaxWebhookBytes)
+	defer bodyReader.Close()
+
+	body, err := io.ReadAll(bodyReader)
+	if err != nil {
+		utils.RespondError(w, http.StatusRequestEntityTooLarge, "Webhook payload is too large")
+		return
+	}
+
+	if err := h.service.VerifyWebhook(r.Header.Get("Authorization"), body); err != nil {
+		respondSFUError(w, err, "")
+		return
+	}
+
+	if err := h.service.HandleWebhook(r.Context(), body); err != nil {
+		log.Error().Err(err).Msg("Failed to handle voice SFU webhook")
+		utils.RespondError(w, http.StatusInternalServerError, "Failed to handle webhook")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func channelParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, channelID, true
+}
+
+func memberParams(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, uuid.UUID, bool) {
+	actorID, channelID, ok := channelParams(w, r)
+	if !ok {
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	targetUserID, err := uuid.Parse(chi.URLParam(r, "userId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid user ID")
+		return uuid.Nil, uuid.Nil, uuid.Nil, false
+	}
+
+	return actorID, channelID, targetUserID, true
+}
+
+func respondModerationError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrNotInVoiceChannel):
+		utils.RespondError(w, http.StatusNotFound, "User not in this voice channel")
+	case errors.Is(err, ErrNotVoiceChannel):
+		utils.RespondError(w, http.StatusBadRequest, "Not a voice channel")
+	case errors.Is(err, ErrAlreadyInChannel):
+		utils.RespondError(w, http.StatusConflict, "Already in this voice channel")
+	case errors.Is(err, ErrMoveAcrossCommunities):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, channel.ErrChannelNotFound):
+		utils.RespondError(w, http.StatusNotFound, "Channel not found")
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
+
+func respondSFUError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, ErrSFUNotConfigured):
+		utils.RespondErrorWithCode(w, http.StatusNotFound, "SFU_NOT_CONFIGURED", err.Error())
+	case errors.Is(err, ErrInsufficientPerms):
+		utils.RespondError(w, http.StatusForbidden, "Insufficient permissions")
+	case errors.Is(err, ErrNotInVoiceChannel):
+		utils.RespondError(w, http.StatusNotFound, "Not in this voice channel")
+	case errors.Is(err, ErrRecordingNotFound), errors.Is(err, ErrIngressNotFound):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidIngressInput):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrInvalidWebhook):
+		utils.RespondError(w, http.StatusUnauthorized, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,80 @@
+package voice
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Peer-to-peer voice needs ICE servers: STUN to discover public addresses and
+// TURN to relay media for clients behind NATs that can't be traversed. TURN
+// credentials follow coturn's REST API scheme (use-auth-secret): the username
+// is <expiry>:<userId> and the password an HMAC-SHA1 of it under the secret
+// shared with the TURN server, so nothing has to be provisioned per user.
+
+const defaultTURNCredentialTTL = 12 * time.Hour
+
+// ICEConfig lists the instance's STUN and TURN servers
+type ICEConfig struct {
+	// e.g. stun:stun.example.com:3478
+	STUNURLs []string
+	// e.g. turn:turn.example.com:3478?transport=udp, turns:turn.example.com:5349
+	TURNURLs []string
+	// coturn's static-auth-secret
+	TURNSecret string
+	// How long TURN credentials are valid
+	TURNCredentialTTL time.Duration
+}
+
+// ICEServer is an RTCIceServer as the browser's RTCPeerConnection takes it
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEServers is what GET /voice/ice-servers returns. TTL is how many seconds
+// the TURN credentials are valid; clients should fetch new ones before then.
+type ICEServers struct {
+	ICEServers []ICEServer `json:"iceServers"`
+	TTL        int64       `json:"ttl"`
+}
+
+// SetICE sets the STUN and TURN servers handed to clients
+func (s *Service) SetICE(cfg ICEConfig) {
+	if cfg.TURNCredentialTTL <= 0 {
+		cfg.TURNCredentialTTL = defaultTURNCredentialTTL
+	}
+	s.ice = cfg
+}
+
+// ICEServers returns the instance's STUN servers and, when TURN is set up,
+// TURN credentials for userID that expire after the configured TTL
+func (s *Service) ICEServers(userID uuid.UUID) *ICEServers {
+	servers := &ICEServers{ICEServers: []ICEServer{}}
+	if len(s.ice.STUNURLs) > 0 {
+		servers.ICEServers = append(servers.ICEServers, ICEServer{URLs: s.ice.STUNURLs})
+	}
+	if len(s.ice.TURNURLs) > 0 && s.ice.TURNSecret != "" {
+		username, credential := turnCredentials(s.ice.TURNSecret, userID, time.Now().Add(s.ice.TURNCredentialTTL))
+		servers.ICEServers = append(servers.ICEServers, ICEServer{
+			URLs:       s.ice.TURNURLs,
+			Username:   username,
+			Credential: credential,
+		})
+		servers.TTL = int64(s.ice.TURNCredentialTTL.Seconds())
+	}
+	return servers
+}
+
+// turnCredentials derives coturn REST API credentials valid until expiry
+func turnCredentials(secret string, userID uuid.UUID, expiry time.Time) (string, string) {
+	username := strconv.FormatInt(expiry.Unix(), 10) + ":" + userID.String()
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
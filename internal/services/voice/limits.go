@@ -0,0 +1,49 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrChannelFull = errors.New("voice channel is full")
+
+// ChannelFullError is returned when joining a voice channel that has reached
+// its user limit. It matches ErrChannelFull and carries what the client needs
+// to say so.
+type ChannelFullError struct {
+	UserLimit int `json:"userLimit"`
+	Connected int `json:"connected"`
+}
+
+func (e *ChannelFullError) Error() string {
+	return fmt.Sprintf("voice channel is full (%d/%d)", e.Connected, e.UserLimit)
+}
+
+func (e *ChannelFullError) Is(target error) bool {
+	return target == ErrChannelFull
+}
+
+// checkUserLimit fails with a ChannelFullError when channelID already has
+// limit members other than userID. It locks the channel's joins until tx ends,
+// so two members can't both take the last place.
+func checkUserLimit(ctx context.Context, tx pgx.Tx, channelID, userID uuid.UUID, limit int) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "voice-join:"+channelID.String()); err != nil {
+		return err
+	}
+	var connected int
+	err := tx.QueryRow(ctx,
+		`SELECT COUNT(*) FROM voice_states WHERE channel_id = $1 AND user_id <> $2`,
+		channelID, userID,
+	).Scan(&connected)
+	if err != nil {
+		return err
+	}
+	if connected >= limit {
+		return &ChannelFullError{UserLimit: limit, Connected: connected}
+	}
+	return nil
+}
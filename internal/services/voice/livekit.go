@@ -0,0 +1,384 @@
+package voice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Group audio goes through a LiveKit SFU: each voice channel is a room, the
+// backend mints the tokens clients join it with and calls LiveKit's server
+// API (Twirp over HTTP) to kick participants, enforce server mutes and run
+// ingress and egress. Without one, clients fall back to peer-to-peer WebRTC
+// signaled over the gateway.
+
+const (
+	// Join tokens only need to last until the client connects; LiveKit
+	// refreshes a connected participant's token itself, and rejoining goes
+	// back through the permission checks for a new one
+	defaultSFUTokenTTL = 5 * time.Minute
+	sfuAPITimeout      = 10 * time.Second
+
+	// Tokens for server API calls only need to outlive the request
+	sfuAPITokenTTL = time.Minute
+
+	// Room names are voice-<channelId>
+	sfuRoomPrefix = "voice-"
+)
+
+var (
+	ErrSFUNotConfigured = errors.New("voice SFU is not configured on this server")
+	errInvalidSFUConfig = errors.New("LIVEKIT_URL needs LIVEKIT_API_KEY and LIVEKIT_API_SECRET")
+)
+
+// SFUConfig points the voice service at a LiveKit deployment
+type SFUConfig struct {
+	// URL clients connect to, e.g. wss://livekit.example.com
+	URL string
+	// APIURL reaches LiveKit's server API; defaults to URL over http(s)
+	APIURL    string
+	APIKey    string
+	APISecret string
+	// How long join tokens are valid for connecting; a connected client
+	// stays connected after they expire
+	TokenTTL time.Duration
+}
+
+// SFUCredentials is what a client needs to join a voice channel's room
+type SFUCredentials struct {
+	URL   string `json:"url"`
+	Room  string `json:"room"`
+	Token string `json:"token"`
+}
+
+// videoGrant is the "video" claim of a LiveKit access token
+type videoGrant struct {
+	RoomJoin       bool   `json:"roomJoin,omitempty"`
+	RoomCreate     bool   `json:"roomCreate,omitempty"`
+	RoomAdmin      bool   `json:"roomAdmin,omitempty"`
+	RoomRecord     bool   `json:"roomRecord,omitempty"`
+	IngressAdmin   bool   `json:"ingressAdmin,omitempty"`
+	Room           string `json:"room,omitempty"`
+	CanPublish     *bool  `json:"canPublish,omitempty"`
+	CanSubscribe   *bool  `json:"canSubscribe,omitempty"`
+	CanPublishData *bool  `json:"canPublishData,omitempty"`
+}
+
+// participantPermission is LiveKit's ParticipantPermission
+type participantPermission struct {
+	CanPublish     bool `json:"canPublish"`
+	CanSubscribe   bool `json:"canSubscribe"`
+	CanPublishData bool `json:"canPublishData"`
+}
+
+type livekitClient struct {
+	url       string
+	apiURL    string
+	apiKey    string
+	apiSecret string
+	tokenTTL  time.Duration
+	http      *http.Client
+}
+
+func newLiveKitClient(cfg SFUConfig) (*livekitClient, error) {
+	if cfg.APIKey == "" || cfg.APISecret == "" {
+		return nil, errInvalidSFUConfig
+	}
+	clientURL, err := url.Parse(cfg.URL)
+	if err != nil || clientURL.Host == "" {
+		return nil, fmt.Errorf("invalid LIVEKIT_URL %q", cfg.URL)
+	}
+
+	apiURL := cfg.APIURL
+	if apiURL == "" {
+		api := *clientURL
+		switch api.Scheme {
+		case "wss":
+			api.Scheme = "https"
+		case "ws":
+			api.Scheme = "http"
+		}
+		apiURL = api.String()
+	}
+
+	ttl := cfg.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultSFUTokenTTL
+	}
+
+	return &livekitClient{
+		url:       cfg.URL,
+		apiURL:    strings.TrimRight(apiURL, "/"),
+		apiKey:    cfg.APIKey,
+		apiSecret: cfg.APISecret,
+		tokenTTL:  ttl,
+		http:      &http.Client{Timeout: sfuAPITimeout},
+	}, nil
+}
+
+func sfuRoom(channelID uuid.UUID) string {
+	return sfuRoomPrefix + channelID.String()
+}
+
+// sfuChannel is the voice channel a room belongs to
+func sfuChannel(room string) (uuid.UUID, bool) {
+	id, ok := strings.CutPrefix(room, sfuRoomPrefix)
+	if !ok {
+		return uuid.Nil, false
+	}
+	channelID, err := uuid.Parse(id)
+	return channelID, err == nil
+}
+
+// accessToken signs a LiveKit access token. identity is empty for server API
+// calls.
+func (c *livekitClient) accessToken(identity, name string, grant videoGrant, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   c.apiKey,
+		"nbf":   now.Unix(),
+		"exp":   now.Add(ttl).Unix(),
+		"video": grant,
+	}
+	if identity != "" {
+		claims["sub"] = identity
+		claims["jti"] = identity
+	}
+	if name != "" {
+		claims["name"] = name
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(c.apiSecret))
+}
+
+// joinToken lets userID into channelID's room. Server-muted users may listen
+// but not publish, and server-deafened ones can't receive.
+func (c *livekitClient) joinToken(channelID, userID uuid.UUID, name string, canPublish, canSubscribe bool) (*SFUCredentials, error) {
+	return c.roomToken(sfuRoom(channelID), userID, name, canPublish, canSubscribe)
+}
+
+// roomToken lets userID into any room, e.g. a DM call's
+func (c *livekitClient) roomToken(room string, userID uuid.UUID, name string, canPublish, canSubscribe bool) (*SFUCredentials, error) {
+	token, err := c.accessToken(userID.String(), name, videoGrant{
+		RoomJoin:       true,
+		Room:           room,
+		CanPublish:     &canPublish,
+		CanSubscribe:   &canSubscribe,
+		CanPublishData: boolPtr(true),
+	}, c.tokenTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &SFUCredentials{URL: c.url, Room: room, Token: token}, nil
+}
+
+// call invokes a server API method, e.g. call(ctx, "RoomService",
+// "RemoveParticipant", ...)
+func (c *livekitClient) call(ctx context.Context, service, method string, grant videoGrant, request, response interface{}) error {
+	token, err := c.accessToken("", "", grant, sfuAPITokenTTL)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	endpoint := c.apiURL + "/twirp/livekit." + service + "/" + method
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var twirpErr struct {
+			Code string `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&twirpErr)
+		return &sfuError{Status: resp.StatusCode, Code: twirpErr.Code, Msg: twirpErr.Msg}
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// sfuError is an error response from LiveKit's server API
+type sfuError struct {
+	Status int
+	Code   string
+	Msg    string
+}
+
+func (e *sfuError) Error() string {
+	return fmt.Sprintf("livekit: %s (%d): %s", e.Code, e.Status, e.Msg)
+}
+
+// notFound is whether err says the room or participant doesn't exist, which
+// for removals means there was nothing to do
+func notFound(err error) bool {
+	var apiErr *sfuError
+	return errors.As(err, &apiErr) && apiErr.Code == "not_found"
+}
+
+func (c *livekitClient) removeParticipant(ctx context.Context, room string, userID uuid.UUID) error {
+	err := c.call(ctx, "RoomService", "RemoveParticipant", videoGrant{RoomAdmin: true, Room: room}, map[string]string{
+		"room":     room,
+		"identity": userID.String(),
+	}, nil)
+	if notFound(err) {
+		return nil
+	}
+	return err
+}
+
+// deleteRoom disconnects everyone in a room and closes it
+func (c *livekitClient) deleteRoom(ctx context.Context, room string) error {
+	err := c.call(ctx, "RoomService", "DeleteRoom", videoGrant{RoomCreate: true}, map[string]string{
+		"room": room,
+	}, nil)
+	if notFound(err) {
+		return nil
+	}
+	return err
+}
+
+// setPermissions grants or revokes a connected participant's rights to send
+// and receive media, unpublishing what they're sending when revoked
+func (c *livekitClient) setPermissions(ctx context.Context, channelID, userID uuid.UUID, canPublish, canSubscribe bool) error {
+	room := sfuRoom(channelID)
+	err := c.call(ctx, "RoomService", "UpdateParticipant", videoGrant{RoomAdmin: true, Room: room}, map[string]interface{}{
+		"room":     room,
+		"identity": userID.String(),
+		"permission": participantPermission{
+			CanPublish:     canPublish,
+			CanSubscribe:   canSubscribe,
+			CanPublishData: true,
+		},
+	}, nil)
+	if notFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Egress is a recording of a voice channel's room
+type Egress struct {
+	EgressID string `json:"egressId"`
+	RoomName string `json:"roomName"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// startRecording records the room's mixed audio to a file in the storage the
+// egress service is configured with
+func (c *livekitClient) startRecording(ctx context.Context, channelID uuid.UUID) (*Egress, error) {
+	room := sfuRoom(channelID)
+	var egress Egress
+	err := c.call(ctx, "Egress", "StartRoomCompositeEgress", videoGrant{RoomRecord: true, Room: room}, map[string]interface{}{
+		"roomName":  room,
+		"audioOnly": true,
+		"fileOutputs": []map[string]interface{}{{
+			"fileType": "OGG",
+			"filepath": "voice/{room_name}-{time}.ogg",
+		}},
+	}, &egress)
+	if err != nil {
+		return nil, err
+	}
+	return &egress, nil
+}
+
+// recordings lists the room's active recordings
+func (c *livekitClient) recordings(ctx context.Context, channelID uuid.UUID) ([]Egress, error) {
+	room := sfuRoom(channelID)
+	var resp struct {
+		Items []Egress `json:"items"`
+	}
+	err := c.call(ctx, "Egress", "ListEgress", videoGrant{RoomRecord: true, Room: room}, map[string]interface{}{
+		"roomName": room,
+		"active":   true,
+	}, &resp)
+	return resp.Items, err
+}
+
+func (c *livekitClient) stopRecording(ctx context.Context, egressID string) (*Egress, error) {
+	var egress Egress
+	err := c.call(ctx, "Egress", "StopEgress", videoGrant{RoomRecord: true}, map[string]string{
+		"egressId": egressID,
+	}, &egress)
+	if err != nil {
+		return nil, err
+	}
+	return &egress, nil
+}
+
+// Ingress lets an external encoder (OBS and the like) stream into a voice
+// channel as a participant
+type Ingress struct {
+	IngressID string `json:"ingressId"`
+	URL       string `json:"url"`
+	StreamKey string `json:"streamKey"`
+	InputType string `json:"inputType"`
+	RoomName  string `json:"roomName"`
+}
+
+// createIngress opens an RTMP or WHIP input publishing into the room as
+// identity
+func (c *livekitClient) createIngress(ctx context.Context, channelID uuid.UUID, inputType, identity, name string) (*Ingress, error) {
+	room := sfuRoom(channelID)
+	var ingress Ingress
+	err := c.call(ctx, "Ingress", "CreateIngress", videoGrant{IngressAdmin: true}, map[string]interface{}{
+		"inputType":           inputType,
+		"name":                name,
+		"roomName":            room,
+		"participantIdentity": identity,
+		"participantName":     name,
+	}, &ingress)
+	if err != nil {
+		return nil, err
+	}
+	return &ingress, nil
+}
+
+func (c *livekitClient) ingresses(ctx context.Context, channelID uuid.UUID) ([]Ingress, error) {
+	var resp struct {
+		Items []Ingress `json:"items"`
+	}
+	err := c.call(ctx, "Ingress", "ListIngress", videoGrant{IngressAdmin: true}, map[string]string{
+		"roomName": sfuRoom(channelID),
+	}, &resp)
+	return resp.Items, err
+}
+
+func (c *livekitClient) deleteIngress(ctx context.Context, ingressID string) error {
+	err := c.call(ctx, "Ingress", "DeleteIngress", videoGrant{IngressAdmin: true}, map[string]string{
+		"ingressId": ingressID,
+	}, nil)
+	if notFound(err) {
+		return nil
+	}
+	return err
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
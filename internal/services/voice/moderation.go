@@ -0,0 +1,149 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+var ErrMoveAcrossCommunities = errors.New("members can only be moved between voice channels of the same community")
+
+// ServerMuteUser lets a moderator stop another participant from speaking,
+// whatever their own mute says
+func (s *Service) ServerMuteUser(ctx context.Context, channelID, targetUserID, actorUserID uuid.UUID, muted bool) (*models.VoiceState, error) {
+	return s.setServerVoice(ctx, channelID, targetUserID, actorUserID, models.PermissionVoiceMuteOthers, "is_muted", muted)
+}
+
+// ServerDeafenUser lets a moderator stop another participant from hearing the
+// channel. A deafened participant can't speak either, as with self deafen.
+func (s *Service) ServerDeafenUser(ctx context.Context, channelID, targetUserID, actorUserID uuid.UUID, deafened bool) (*models.VoiceState, error) {
+	return s.setServerVoice(ctx, channelID, targetUserID, actorUserID, models.PermissionVoiceDeafenOthers, "is_deafened", deafened)
+}
+
+// setServerVoice sets the server mute or deafen column of a participant, then
+// applies it in the SFU and tells the channel
+func (s *Service) setServerVoice(ctx context.Context, channelID, targetUserID, actorUserID uuid.UUID, permission int64, column string, value bool) (*models.VoiceState, error) {
+	if !s.channelService.CanModerateVoice(ctx, channelID, actorUserID, permission) {
+		return nil, ErrInsufficientPerms
+	}
+
+	// column is one of two constants, never user input
+	state, err := scanVoiceState(s.db.QueryRow(ctx,
+		`UPDATE voice_states SET `+column+` = $3 WHERE channel_id = $1 AND user_id = $2
+		RETURNING `+voiceStateColumns,
+		channelID, targetUserID, value,
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotInVoiceChannel
+		}
+		return nil, err
+	}
+
+	s.applyServerVoice(ctx, state)
+	s.broadcast(ctx, channelID, EventTypeVoiceState, map[string]interface{}{
+		"channelId": channelID.String(),
+		"userId":    targetUserID.String(),
+		"state":     state,
+		"actorId":   actorUserID.String(),
+	})
+	return state, nil
+}
+
+// MoveUser lets a moderator move a participant from one voice channel to
+// another in the same community. The actor needs PermissionVoiceMoveMembers
+// in both, and the member must be able to see where they're going. Their
+// camera and screen share stop, as their client reconnects.
+func (s *Service) MoveUser(ctx context.Context, fromChannelID, targetUserID, toChannelID, actorUserID uuid.UUID) (*models.VoiceState, error) {
+	if fromChannelID == toChannelID {
+		return nil, ErrAlreadyInChannel
+	}
+	from, err := s.channelService.GetChannel(ctx, fromChannelID)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.channelService.GetChannel(ctx, toChannelID)
+	if err != nil {
+		return nil, err
+	}
+	if to.Type != models.ChannelTypeVoice {
+		return nil, ErrNotVoiceChannel
+	}
+	if from.CommunityID != to.CommunityID {
+		return nil, ErrMoveAcrossCommunities
+	}
+	if !s.channelService.CanModerateVoice(ctx, fromChannelID, actorUserID, models.PermissionVoiceMoveMembers) ||
+		!s.channelService.CanModerateVoice(ctx, toChannelID, actorUserID, models.PermissionVoiceMoveMembers) ||
+		!s.channelService.CanAccessChannel(ctx, toChannelID, targetUserID) {
+		return nil, ErrInsufficientPerms
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Same lock as JoinChannel, so a join can't race the move
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, targetUserID.String()); err != nil {
+		return nil, err
+	}
+	state, err := scanVoiceState(tx.QueryRow(ctx,
+		`UPDATE voice_states
+		SET channel_id = $3, joined_at = $4, is_screen_sharing = FALSE, is_video = FALSE, streams = '[]'
+		WHERE channel_id = $1 AND user_id = $2
+		RETURNING `+voiceStateColumns,
+		fromChannelID, targetUserID, toChannelID, time.Now(),
+	))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotInVoiceChannel
+		}
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	s.kickFromSFU([]uuid.UUID{fromChannelID}, targetUserID)
+
+	s.broadcast(ctx, fromChannelID, EventTypeVoiceLeave, map[string]interface{}{
+		"channelId": fromChannelID.String(),
+		"userId":    targetUserID.String(),
+	})
+	joined := map[string]interface{}{
+		"channelId": toChannelID.String(),
+		"userId":    targetUserID.String(),
+		"state":     state,
+	}
+	if u, err := s.userService.GetUserByID(ctx, targetUserID); err == nil {
+		joined["user"] = u
+	}
+	s.broadcast(ctx, toChannelID, EventTypeVoiceJoin, joined)
+
+	// The member's own clients need to switch rooms
+	moved := map[string]interface{}{
+		"fromChannelId": fromChannelID.String(),
+		"channelId":     toChannelID.String(),
+		"state":         state,
+		"actorId":       actorUserID.String(),
+	}
+	sfu, err := s.SFUCredentials(ctx, toChannelID, targetUserID)
+	if err != nil {
+		log.Error().Err(err).
+			Str("channelId", toChannelID.String()).
+			Str("userId", targetUserID.String()).
+			Msg("Failed to create voice room token for moved member")
+	}
+	if sfu != nil {
+		moved["sfu"] = sfu
+	}
+	s.publish(ctx, database.UserStream(targetUserID.String()), EventTypeVoiceMove, moved)
+
+	return state, nil
+}
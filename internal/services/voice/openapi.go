@@ -0,0 +1,125 @@
+package voice
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	channelID := []openapi.Param{openapi.ID("channelId")}
+	member := []openapi.Param{openapi.ID("channelId"), openapi.ID("userId")}
+
+	openapi.Describe((*Handler).GetChannelVoiceStates, openapi.Operation{
+		Summary:  "List who is in a voice channel",
+		Path:     channelID,
+		Response: []*models.VoiceStateWithUser{},
+	})
+	openapi.Describe((*Handler).JoinChannel, openapi.Operation{
+		Summary:     "Join a voice channel",
+		Description: "With an SFU configured, the response holds the credentials to connect to the channel's room.",
+		Path:        channelID,
+		Response:    JoinResponse{},
+	})
+	openapi.Describe((*Handler).LeaveChannel, openapi.Operation{
+		Summary: "Leave a voice channel",
+		Path:    channelID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UpdateVoiceState, openapi.Operation{
+		Summary:  "Mute, deafen, share video or the screen",
+		Path:     channelID,
+		Body:     StateUpdate{},
+		Response: models.VoiceState{},
+	})
+	openapi.Describe((*Handler).ServerMuteUser, openapi.Operation{
+		Summary:  "Mute or unmute a member for everyone",
+		Path:     member,
+		Body:     MuteRequest{},
+		Response: models.VoiceState{},
+	})
+	openapi.Describe((*Handler).ServerDeafenUser, openapi.Operation{
+		Summary:  "Deafen or undeafen a member",
+		Path:     member,
+		Body:     DeafenRequest{},
+		Response: models.VoiceState{},
+	})
+	openapi.Describe((*Handler).MoveUser, openapi.Operation{
+		Summary:  "Move a member into another voice channel",
+		Path:     member,
+		Body:     MoveRequest{},
+		Response: models.VoiceState{},
+	})
+	openapi.Describe((*Handler).GetSFUToken, openapi.Operation{
+		Summary:     "Get a fresh token for the room of the caller's voice channel",
+		Description: "For reconnecting once the token from joining expired.",
+		Path:        channelID,
+		Response:    SFUCredentials{},
+	})
+	openapi.Describe((*Handler).ListRecordings, openapi.Operation{
+		Summary:  "List a voice channel's recordings",
+		Path:     channelID,
+		Response: []Egress{},
+	})
+	openapi.Describe((*Handler).StartRecording, openapi.Operation{
+		Summary:  "Start recording a voice channel",
+		Path:     channelID,
+		Response: Egress{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).StopRecording, openapi.Operation{
+		Summary:  "Stop a recording",
+		Path:     append(channelID, openapi.Param{Name: "egressId", Required: true}),
+		Response: Egress{},
+	})
+	openapi.Describe((*Handler).ListIngresses, openapi.Operation{
+		Summary:  "List the streams into a voice channel",
+		Path:     channelID,
+		Response: []Ingress{},
+	})
+	openapi.Describe((*Handler).CreateIngress, openapi.Operation{
+		Summary:     "Create a stream into a voice channel",
+		Description: "inputType is " + IngressRTMP + " or " + IngressWHIP + ".",
+		Path:        channelID,
+		Body:        CreateIngressRequest{},
+		Response:    Ingress{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).DeleteIngress, openapi.Operation{
+		Summary: "Delete a stream into a voice channel",
+		Path:    append(channelID, openapi.Param{Name: "ingressId", Required: true}),
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).GetMyVoiceState, openapi.Operation{
+		Summary:  "Get the current user's voice state, null outside voice channels",
+		Response: models.VoiceState{},
+	})
+	openapi.Describe((*Handler).GetICEServers, openapi.Operation{
+		Summary:  "Get STUN servers and short-lived TURN credentials",
+		Response: ICEServers{},
+	})
+	openapi.Describe((*Handler).ReportQoS, openapi.Operation{
+		Summary:  "Report the caller's connection quality",
+		Body:     QoSReport{},
+		Response: QoSResult{},
+	})
+	openapi.Describe((*Handler).GetQoSSummary, openapi.Operation{
+		Summary: "Summarize connection quality reports",
+		Query: []openapi.Param{
+			{Name: "groupBy", Enum: []string{QoSGroupRegion, QoSGroupChannel}, Description: "region by default"},
+			{Name: "since", Description: "How far back, as a Go duration of up to 168h; 1h by default"},
+		},
+		Response: []QoSAggregate{},
+	})
+	openapi.Describe((*Handler).ReceiveWebhook, openapi.Operation{
+		Summary: "Receive LiveKit's webhooks",
+		Headers: []openapi.Param{
+			{Name: "Authorization", Required: true, Description: "LiveKit's signature of the body"},
+		},
+		BodyType: "application/webhook+json",
+		Body:     json.RawMessage{},
+		Status:   http.StatusNoContent,
+	})
+}
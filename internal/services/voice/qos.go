@@ -0,0 +1,280 @@
+package voice
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Clients in a voice channel or DM call report their connection quality
+// every so often: packet loss, jitter and round trip time, and the region of
+// the media server they're connected through. Operators see the aggregates
+// per region and channel, and a client that keeps struggling is told about a
+// region other users are doing better on.
+
+const (
+	// Shortest gap between two reports from one user
+	QoSReportInterval = 10 * time.Second
+
+	// Reports are kept this long
+	qosRetention = 7 * 24 * time.Hour
+
+	// A region switch is suggested from the user's reports over the last
+	// qosUserWindow, compared with everyone's over the last
+	// qosRegionWindow, when at least qosMinRegionReports back the
+	// alternative
+	qosUserWindow       = 2 * time.Minute
+	qosRegionWindow     = 15 * time.Minute
+	qosMinRegionReports = 20
+
+	// Beyond any of these, a connection is poor
+	poorPacketLoss = 5.0 // percent
+	poorJitterMs   = 30.0
+	poorRTTMs      = 300.0
+
+	// Longest span the admin report covers, which is all that's kept
+	MaxQoSReportSpan = qosRetention
+)
+
+// Ways to group the admin report
+const (
+	QoSGroupRegion  = "region"
+	QoSGroupChannel = "channel"
+)
+
+var (
+	ErrInvalidQoSSession = errors.New("report either a channelId or a callId")
+	ErrInvalidRegion     = errors.New("unknown voice region")
+	ErrNotInSession      = errors.New("not connected to this voice channel or call")
+	ErrQoSRateLimited    = errors.New("quality reports are sent too often")
+	ErrInvalidQoSGroup   = errors.New("groupBy must be region or channel")
+)
+
+var regionRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9\-]{0,31}$`)
+
+// QoSReport is one sample of a client's connection quality
+type QoSReport struct {
+	ChannelID  *uuid.UUID `json:"channelId"`
+	CallID     *uuid.UUID `json:"callId"`
+	Region     string     `json:"region" validate:"required,max=32"`
+	PacketLoss float64    `json:"packetLoss" validate:"gte=0,lte=100"`
+	Jitter     float64    `json:"jitter" validate:"gte=0,lte=60000"`
+	RTT        float64    `json:"rtt" validate:"gte=0,lte=60000"`
+}
+
+// QoSResult answers a report. SuggestedRegion is set when the user's recent
+// reports are poor and another region is doing clearly better.
+type QoSResult struct {
+	SuggestedRegion *string `json:"suggestedRegion,omitempty"`
+}
+
+// QoSAggregate is the connection quality of one region or channel
+type QoSAggregate struct {
+	Key           string  `json:"key"`
+	Reports       int64   `json:"reports"`
+	Users         int64   `json:"users"`
+	AvgPacketLoss float64 `json:"avgPacketLoss"`
+	P95PacketLoss float64 `json:"p95PacketLoss"`
+	AvgJitter     float64 `json:"avgJitter"`
+	P95Jitter     float64 `json:"p95Jitter"`
+	AvgRTT        float64 `json:"avgRtt"`
+	P95RTT        float64 `json:"p95Rtt"`
+	// Share of reports over any of the poor thresholds, 0 to 1
+	PoorRatio float64 `json:"poorRatio"`
+}
+
+// SetRegions lists the regions media servers run in. Reports must name one
+// of them, and switches are only suggested when there's more than one.
+func (s *Service) SetRegions(regions []string) {
+	s.regions = regions
+}
+
+// ReportQoS records a quality sample from userID, who must be connected to
+// the channel or call it's about
+func (s *Service) ReportQoS(ctx context.Context, userID uuid.UUID, report *QoSReport) (*QoSResult, error) {
+	if (report.ChannelID == nil) == (report.CallID == nil) {
+		return nil, ErrInvalidQoSSession
+	}
+	if !s.knownRegion(report.Region) {
+		return nil, ErrInvalidRegion
+	}
+
+	count, err := database.IncrementRateLimit(ctx, "voice-qos:"+userID.String(), QoSReportInterval)
+	if err != nil {
+		return nil, err
+	}
+	if count > 1 {
+		return nil, ErrQoSRateLimited
+	}
+
+	var connected bool
+	if report.ChannelID != nil {
+		err = s.db.QueryRow(ctx,
+			`SELECT EXISTS(SELECT 1 FROM voice_states WHERE channel_id = $1 AND user_id = $2)`,
+			*report.ChannelID, userID,
+		).Scan(&connected)
+	} else {
+		err = s.db.QueryRow(ctx,
+			`SELECT EXISTS(SELECT 1 FROM dm_call_participants WHERE call_id = $1 AND user_id = $2 AND state = 'joined')`,
+			*report.CallID, userID,
+		).Scan(&connected)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !connected {
+		return nil, ErrNotInSession
+	}
+
+	_, err = s.db.Exec(ctx,
+		`INSERT INTO voice_qos_reports (user_id, channel_id, call_id, region, packet_loss, jitter_ms, rtt_ms)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		userID, report.ChannelID, report.CallID, report.Region, report.PacketLoss, report.Jitter, report.RTT,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &QoSResult{}
+	if len(s.regions) > 1 {
+		result.SuggestedRegion, err = s.suggestRegion(ctx, userID, report.Region)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// suggestRegion picks the region to switch to when the user's connection
+// through region has been poor lately: the one with the best recent quality
+// across all users, if it isn't poor itself and beats what the user has
+func (s *Service) suggestRegion(ctx context.Context, userID uuid.UUID, region string) (*string, error) {
+	var loss, jitter, rtt float64
+	err := s.db.QueryRow(ctx,
+		`SELECT COALESCE(AVG(packet_loss), 0), COALESCE(AVG(jitter_ms), 0), COALESCE(AVG(rtt_ms), 0)
+		FROM voice_qos_reports
+		WHERE user_id = $1 AND region = $2 AND created_at > $3`,
+		userID, region, time.Now().Add(-qosUserWindow),
+	).Scan(&loss, &jitter, &rtt)
+	if err != nil {
+		return nil, err
+	}
+	if !poorQuality(loss, jitter, rtt) {
+		return nil, nil
+	}
+	current := qualityScore(loss, jitter, rtt)
+
+	rows, err := s.db.Query(ctx,
+		`SELECT region, AVG(packet_loss), AVG(jitter_ms), AVG(rtt_ms)
+		FROM voice_qos_reports
+		WHERE region = ANY($1) AND region <> $2 AND created_at > $3
+		GROUP BY region
+		HAVING COUNT(*) >= $4`,
+		s.regions, region, time.Now().Add(-qosRegionWindow), qosMinRegionReports,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var best *string
+	bestScore := current
+	for rows.Next() {
+		var candidate string
+		if err := rows.Scan(&candidate, &loss, &jitter, &rtt); err != nil {
+			return nil, err
+		}
+		if poorQuality(loss, jitter, rtt) {
+			continue
+		}
+		if score := qualityScore(loss, jitter, rtt); score < bestScore {
+			best, bestScore = &candidate, score
+		}
+	}
+	return best, rows.Err()
+}
+
+// QoSSummary aggregates the reports since a time by region or by voice
+// channel, worst first
+func (s *Service) QoSSummary(ctx context.Context, groupBy string, since time.Time) ([]QoSAggregate, error) {
+	var key, filter string
+	switch groupBy {
+	case QoSGroupRegion, "":
+		key, filter = "region", "TRUE"
+	case QoSGroupChannel:
+		key, filter = "channel_id::text", "channel_id IS NOT NULL"
+	default:
+		return nil, ErrInvalidQoSGroup
+	}
+
+	// key and filter are constants chosen above
+	rows, err := s.db.Query(ctx,
+		`SELECT `+key+`, COUNT(*), COUNT(DISTINCT user_id),
+			AVG(packet_loss), percentile_cont(0.95) WITHIN GROUP (ORDER BY packet_loss),
+			AVG(jitter_ms), percentile_cont(0.95) WITHIN GROUP (ORDER BY jitter_ms),
+			AVG(rtt_ms), percentile_cont(0.95) WITHIN GROUP (ORDER BY rtt_ms),
+			AVG(CASE WHEN packet_loss > $2 OR jitter_ms > $3 OR rtt_ms > $4 THEN 1 ELSE 0 END)
+		FROM voice_qos_reports
+		WHERE created_at > $1 AND `+filter+`
+		GROUP BY 1
+		ORDER BY 10 DESC, 2 DESC`,
+		since, poorPacketLoss, poorJitterMs, poorRTTMs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summary := []QoSAggregate{}
+	for rows.Next() {
+		var a QoSAggregate
+		if err := rows.Scan(&a.Key, &a.Reports, &a.Users,
+			&a.AvgPacketLoss, &a.P95PacketLoss,
+			&a.AvgJitter, &a.P95Jitter,
+			&a.AvgRTT, &a.P95RTT,
+			&a.PoorRatio,
+		); err != nil {
+			return nil, err
+		}
+		summary = append(summary, a)
+	}
+	return summary, rows.Err()
+}
+
+// PruneQoSReports deletes reports older than a week; it's a maintenance task
+func (s *Service) PruneQoSReports(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx,
+		`DELETE FROM voice_qos_reports WHERE created_at < $1`,
+		time.Now().Add(-qosRetention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (s *Service) knownRegion(region string) bool {
+	if len(s.regions) == 0 {
+		return regionRegex.MatchString(region)
+	}
+	for _, r := range s.regions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+func poorQuality(loss, jitter, rtt float64) bool {
+	return loss > poorPacketLoss || jitter > poorJitterMs || rtt > poorRTTMs
+}
+
+// qualityScore ranks connections, lower being better. Loss hurts calls most,
+// then jitter, so they weigh more than round trip time.
+func qualityScore(loss, jitter, rtt float64) float64 {
+	return loss*20 + jitter*2 + rtt
+}
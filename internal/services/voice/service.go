@@ -31,6 +31,11 @@ type Service struct {
 	db             *pgxpool.Pool
 	channelService *channel.Service
 	userService    *user.Service
+	// Set when voice goes through an SFU; see SetSFU
+	sfu *livekitClient
+	ice ICEConfig
+	// Regions media servers run in; see SetRegions
+	regions []string
 }
 
 func NewService(db *pgxpool.Pool, channelService *channel.Service, userService *user.Service) *Service {
@@ -53,9 +58,13 @@ func (s *Service) JoinChannel(ctx context.Context, channelID, userID uuid.UUID)
 	}
 
 	// Check if user can access the channel
-	if !s.channelService.CanAccessChannel(ctx, channelID, userID) {
+	if !s.channelService.CanConnectVoice(ctx, channelID, userID) {
 		return nil, ErrInsufficientPerms
 	}
+	// Members who can move others may join a full channel, as they could move
+	// themselves in anyway
+	enforceLimit := ch.UserLimit > 0 &&
+		!s.channelService.CanModerateVoice(ctx, channelID, userID, models.PermissionVoiceMoveMembers)
 
 	state := &models.VoiceState{
 		ID:              uuid.New(),
@@ -66,6 +75,8 @@ func (s *Service) JoinChannel(ctx context.Context, channelID, userID uuid.UUID)
 		IsSelfMuted:     false,
 		IsSelfDeaf:      false,
 		IsScreenSharing: false,
+		IsVideo:         false,
+		Streams:         []models.VoiceStream{},
 		JoinedAt:        time.Now(),
 	}
 
@@ -82,15 +93,32 @@ func (s *Service) JoinChannel(ctx context.Context, channelID, userID uuid.UUID)
 		return nil, err
 	}
 
-	_, err = tx.Exec(ctx, `DELETE FROM voice_states WHERE user_id = $1`, userID)
+	rows, err := tx.Query(ctx, `DELETE FROM voice_states WHERE user_id = $1 RETURNING channel_id`, userID)
 	if err != nil {
 		return nil, err
 	}
+	var previous []uuid.UUID
+	for rows.Next() {
+		var previousID uuid.UUID
+		if err := rows.Scan(&previousID); err == nil && previousID != channelID {
+			previous = append(previous, previousID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if enforceLimit {
+		if err := checkUserLimit(ctx, tx, ch.ID, userID, ch.UserLimit); err != nil {
+			return nil, err
+		}
+	}
 
 	_, err = tx.Exec(ctx,
 		`INSERT INTO voice_states (id, channel_id, user_id, is_muted, is_deafened, is_self_muted, is_self_deafened, is_screen_sharing, joined_at)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		ON CONFLICT (channel_id, user_id) DO UPDATE SET joined_at = $9`,
+		ON CONFLICT (channel_id, user_id) DO UPDATE SET joined_at = $9, is_screen_sharing = FALSE, is_video = FALSE, streams = '[]'`,
 		state.ID, state.ChannelID, state.UserID, state.IsMuted, state.IsDeafened,
 		state.IsSelfMuted, state.IsSelfDeaf, state.IsScreenSharing, state.JoinedAt,
 	)
@@ -101,6 +129,7 @@ func (s *Service) JoinChannel(ctx context.Context, channelID, userID uuid.UUID)
 	if err := tx.Commit(ctx); err != nil {
 		return nil, err
 	}
+	s.kickFromSFU(previous, userID)
 
 	return state, nil
 }
@@ -117,6 +146,7 @@ func (s *Service) LeaveChannel(ctx context.Context, channelID, userID uuid.UUID)
 	if result.RowsAffected() == 0 {
 		return ErrNotInVoiceChannel
 	}
+	s.kickFromSFU([]uuid.UUID{channelID}, userID)
 	return nil
 }
 
@@ -151,64 +181,73 @@ func (s *Service) DisconnectUser(ctx context.Context, userID uuid.UUID) ([]uuid.
 	}
 
 	// Remove from all voice channels
-	err = s.leaveAllChannels(ctx, userID)
-	return channelIDs, err
-}
-
-// UpdateVoiceState updates a user's mute/deafen state
-func (s *Service) UpdateVoiceState(ctx context.Context, channelID, userID uuid.UUID, isSelfMuted, isSelfDeafened, isScreenSharing *bool) (*models.VoiceState, error) {
-	state, err := s.GetUserVoiceState(ctx, channelID, userID)
-	if err != nil {
-		return nil, ErrNotInVoiceChannel
+	if err := s.leaveAllChannels(ctx, userID); err != nil {
+		return channelIDs, err
 	}
+	s.kickFromSFU(channelIDs, userID)
+	return channelIDs, nil
+}
 
-	if isSelfMuted != nil {
-		state.IsSelfMuted = *isSelfMuted
-	}
-	if isSelfDeafened != nil {
-		state.IsSelfDeaf = *isSelfDeafened
-	}
-	if isScreenSharing != nil {
-		state.IsScreenSharing = *isScreenSharing
-	}
+// UpdateVoiceState applies a participant's change to their own mute, deafen,
+// camera and screen share state, holding new cameras and screen shares to the
+// channel's caps
+func (s *Service) UpdateVoiceState(ctx context.Context, channelID, userID uuid.UUID, update *StateUpdate) (*models.VoiceState, error) {
+	return s.updateVoiceState(ctx, channelID, userID, update, true)
+}
 
-	_, err = s.db.Exec(ctx,
-		`UPDATE voice_states SET is_self_muted = $3, is_self_deafened = $4, is_screen_sharing = $5 WHERE channel_id = $1 AND user_id = $2`,
-		channelID, userID, state.IsSelfMuted, state.IsSelfDeaf, state.IsScreenSharing,
-	)
+// updateVoiceState is UpdateVoiceState, optionally without the stream caps for
+// changes the SFU reports after the fact
+func (s *Service) updateVoiceState(ctx context.Context, channelID, userID uuid.UUID, update *StateUpdate, enforceLimits bool) (*models.VoiceState, error) {
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback(ctx)
 
-	return state, nil
-}
-
-// ServerMuteUser allows a moderator to mute another user
-func (s *Service) ServerMuteUser(ctx context.Context, channelID, targetUserID, actorUserID uuid.UUID, muted bool) (*models.VoiceState, error) {
-	ch, err := s.channelService.GetChannel(ctx, channelID)
+	state, err := scanVoiceState(tx.QueryRow(ctx,
+		`SELECT `+voiceStateColumns+` FROM voice_states WHERE channel_id = $1 AND user_id = $2 FOR UPDATE`,
+		channelID, userID,
+	))
 	if err != nil {
-		return nil, err
+		return nil, ErrNotInVoiceChannel
 	}
 
-	// Check if actor has permission to mute others
-	if !s.channelService.CanManageMessages(ctx, channelID, actorUserID) {
-		_ = ch // suppress unused warning
-		return nil, ErrInsufficientPerms
+	wasVideo, wasSharing := state.IsVideo, state.IsScreenSharing
+	if update.IsSelfMuted != nil {
+		state.IsSelfMuted = *update.IsSelfMuted
 	}
+	if update.IsSelfDeafened != nil {
+		state.IsSelfDeaf = *update.IsSelfDeafened
+	}
+	if update.IsScreenSharing != nil {
+		state.IsScreenSharing = *update.IsScreenSharing
+	}
+	if update.IsVideo != nil {
+		state.IsVideo = *update.IsVideo
+	}
+	if update.Streams != nil {
+		state.Streams = update.Streams
+	}
+	state.Streams = normalizeStreams(state, state.Streams)
 
-	state, err := s.GetUserVoiceState(ctx, channelID, targetUserID)
-	if err != nil {
-		return nil, ErrNotInVoiceChannel
+	if enforceLimits {
+		err := s.checkStreamLimits(ctx, tx, channelID, userID, state.IsVideo && !wasVideo, state.IsScreenSharing && !wasSharing)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	state.IsMuted = muted
-	_, err = s.db.Exec(ctx,
-		`UPDATE voice_states SET is_muted = $3 WHERE channel_id = $1 AND user_id = $2`,
-		channelID, targetUserID, muted,
+	_, err = tx.Exec(ctx,
+		`UPDATE voice_states SET is_self_muted = $3, is_self_deafened = $4, is_screen_sharing = $5, is_video = $6, streams = $7
+		WHERE channel_id = $1 AND user_id = $2`,
+		channelID, userID, state.IsSelfMuted, state.IsSelfDeaf, state.IsScreenSharing, state.IsVideo, state.Streams,
 	)
 	if err != nil {
 		return nil, err
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
 
 	return state, nil
 }
@@ -216,7 +255,8 @@ func (s *Service) ServerMuteUser(ctx context.Context, channelID, targetUserID, a
 // GetChannelVoiceStates returns all voice states for a channel with user info
 func (s *Service) GetChannelVoiceStates(ctx context.Context, channelID uuid.UUID) ([]*models.VoiceStateWithUser, error) {
 	rows, err := s.db.Query(ctx,
-		`SELECT vs.id, vs.channel_id, vs.user_id, vs.is_muted, vs.is_deafened, vs.is_self_muted, vs.is_self_deafened, vs.is_screen_sharing, vs.joined_at,
+		`SELECT vs.id, vs.channel_id, vs.user_id, vs.is_muted, vs.is_deafened, vs.is_self_muted, vs.is_self_deafened, vs.is_screen_sharing,
+			vs.is_video, vs.streams, vs.joined_at,
 			u.id, u.username, u.display_name, u.avatar_url, u.status
 		FROM voice_states vs
 		JOIN users u ON u.id = vs.user_id
@@ -236,7 +276,7 @@ func (s *Service) GetChannelVoiceStates(ctx context.Context, channelID uuid.UUID
 		}
 		err := rows.Scan(
 			&vs.ID, &vs.ChannelID, &vs.UserID, &vs.IsMuted, &vs.IsDeafened,
-			&vs.IsSelfMuted, &vs.IsSelfDeaf, &vs.IsScreenSharing, &vs.JoinedAt,
+			&vs.IsSelfMuted, &vs.IsSelfDeaf, &vs.IsScreenSharing, &vs.IsVideo, &vs.Streams, &vs.JoinedAt,
 			&vs.User.ID, &vs.User.Username, &vs.User.DisplayName, &vs.User.AvatarURL, &vs.User.Status,
 		)
 		if err != nil {
@@ -251,15 +291,10 @@ func (s *Service) GetChannelVoiceStates(ctx context.Context, channelID uuid.UUID
 
 // GetUserVoiceState gets a user's voice state in a specific channel
 func (s *Service) GetUserVoiceState(ctx context.Context, channelID, userID uuid.UUID) (*models.VoiceState, error) {
-	state := &models.VoiceState{}
-	err := s.db.QueryRow(ctx,
-		`SELECT id, channel_id, user_id, is_muted, is_deafened, is_self_muted, is_self_deafened, is_screen_sharing, joined_at
-		FROM voice_states WHERE channel_id = $1 AND user_id = $2`,
+	state, err := scanVoiceState(s.db.QueryRow(ctx,
+		`SELECT `+voiceStateColumns+` FROM voice_states WHERE channel_id = $1 AND user_id = $2`,
 		channelID, userID,
-	).Scan(
-		&state.ID, &state.ChannelID, &state.UserID, &state.IsMuted, &state.IsDeafened,
-		&state.IsSelfMuted, &state.IsSelfDeaf, &state.IsScreenSharing, &state.JoinedAt,
-	)
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotInVoiceChannel
@@ -271,15 +306,10 @@ func (s *Service) GetUserVoiceState(ctx context.Context, channelID, userID uuid.
 
 // GetUserCurrentVoiceChannel gets which voice channel a user is currently in
 func (s *Service) GetUserCurrentVoiceChannel(ctx context.Context, userID uuid.UUID) (*models.VoiceState, error) {
-	state := &models.VoiceState{}
-	err := s.db.QueryRow(ctx,
-		`SELECT id, channel_id, user_id, is_muted, is_deafened, is_self_muted, is_self_deafened, is_screen_sharing, joined_at
-		FROM voice_states WHERE user_id = $1 LIMIT 1`,
+	state, err := scanVoiceState(s.db.QueryRow(ctx,
+		`SELECT `+voiceStateColumns+` FROM voice_states WHERE user_id = $1 LIMIT 1`,
 		userID,
-	).Scan(
-		&state.ID, &state.ChannelID, &state.UserID, &state.IsMuted, &state.IsDeafened,
-		&state.IsSelfMuted, &state.IsSelfDeaf, &state.IsScreenSharing, &state.JoinedAt,
-	)
+	))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrNotInVoiceChannel
@@ -288,3 +318,17 @@ func (s *Service) GetUserCurrentVoiceChannel(ctx context.Context, userID uuid.UU
 	}
 	return state, nil
 }
+
+const voiceStateColumns = `id, channel_id, user_id, is_muted, is_deafened, is_self_muted, is_self_deafened, is_screen_sharing, is_video, streams, joined_at`
+
+func scanVoiceState(row pgx.Row) (*models.VoiceState, error) {
+	state := &models.VoiceState{}
+	err := row.Scan(
+		&state.ID, &state.ChannelID, &state.UserID, &state.IsMuted, &state.IsDeafened,
+		&state.IsSelfMuted, &state.IsSelfDeaf, &state.IsScreenSharing, &state.IsVideo, &state.Streams, &state.JoinedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
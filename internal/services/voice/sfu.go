@@ -0,0 +1,406 @@
+package voice
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Events the voice service relays on its own, for changes that come from the
+// SFU or a moderator rather than from a gateway op
+const (
+	EventTypeVoiceState     = "VOICE_STATE_UPDATE"
+	EventTypeVoiceJoin      = "VOICE_JOIN"
+	EventTypeVoiceLeave     = "VOICE_LEAVE"
+	EventTypeVoiceMove      = "VOICE_MOVE"
+	EventTypeVoiceRecording = "VOICE_RECORDING_UPDATE"
+)
+
+// Ingress input types
+const (
+	IngressRTMP = "RTMP_INPUT"
+	IngressWHIP = "WHIP_INPUT"
+)
+
+const (
+	// Largest LiveKit webhook body accepted
+	MaxWebhookBytes = 1 << 20
+
+	// Identities of ingress participants, so they aren't mistaken for users
+	ingressIdentityPrefix = "ingress-"
+)
+
+var (
+	ErrInvalidWebhook      = errors.New("invalid SFU webhook signature")
+	ErrRecordingNotFound   = errors.New("recording not found")
+	ErrIngressNotFound     = errors.New("ingress not found")
+	ErrInvalidIngressInput = errors.New("input type must be RTMP_INPUT or WHIP_INPUT")
+)
+
+// SetSFU routes voice channels through a LiveKit SFU
+func (s *Service) SetSFU(cfg SFUConfig) error {
+	client, err := newLiveKitClient(cfg)
+	if err != nil {
+		return err
+	}
+	s.sfu = client
+	return nil
+}
+
+// SFUEnabled is whether clients get room tokens instead of signaling
+// peer-to-peer
+func (s *Service) SFUEnabled() bool {
+	return s.sfu != nil
+}
+
+// SFUCredentials mints a token for userID to connect to the room of the voice
+// channel they're in, able to publish if they have the Speak permission and
+// aren't server-muted. Returns nil without an SFU.
+func (s *Service) SFUCredentials(ctx context.Context, channelID, userID uuid.UUID) (*SFUCredentials, error) {
+	if s.sfu == nil {
+		return nil, nil
+	}
+	state, err := s.GetUserVoiceState(ctx, channelID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.channelService.CanConnectVoice(ctx, channelID, userID) {
+		return nil, ErrInsufficientPerms
+	}
+
+	canPublish := !state.IsMuted && !state.IsDeafened && s.channelService.CanSpeakVoice(ctx, channelID, userID)
+	return s.sfu.joinToken(channelID, userID, s.participantName(ctx, userID), canPublish, !state.IsDeafened)
+}
+
+// participantName is the name other participants see for userID in a room
+func (s *Service) participantName(ctx context.Context, userID uuid.UUID) string {
+	u, err := s.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return ""
+	}
+	if u.DisplayName != nil && *u.DisplayName != "" {
+		return *u.DisplayName
+	}
+	return u.Username
+}
+
+// kickFromSFU disconnects userID from the rooms of channels they left. It
+// runs in the background, as leaving happens on the gateway's hub loop; a
+// channel they've rejoined since is skipped so the new connection survives.
+func (s *Service) kickFromSFU(channelIDs []uuid.UUID, userID uuid.UUID) {
+	if s.sfu == nil || len(channelIDs) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sfuAPITimeout)
+		defer cancel()
+
+		for _, channelID := range channelIDs {
+			if _, err := s.GetUserVoiceState(ctx, channelID, userID); err == nil {
+				continue
+			}
+			if err := s.sfu.removeParticipant(ctx, sfuRoom(channelID), userID); err != nil {
+				log.Warn().Err(err).
+					Str("channelId", channelID.String()).
+					Str("userId", userID.String()).
+					Msg("Failed to remove participant from voice room")
+			}
+		}
+	}()
+}
+
+// RevalidateParticipant checks userID may still be in the voice channel
+// they're in, after they were kicked or banned or their permissions changed.
+// Someone who can no longer connect leaves the channel and its SFU room;
+// otherwise what they may publish is applied again, in case Speak changed.
+// Returns the channel they left, or uuid.Nil.
+func (s *Service) RevalidateParticipant(ctx context.Context, userID uuid.UUID) (uuid.UUID, error) {
+	state, err := s.GetUserCurrentVoiceChannel(ctx, userID)
+	if errors.Is(err, ErrNotInVoiceChannel) {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if s.channelService.CanConnectVoice(ctx, state.ChannelID, userID) {
+		s.applyServerVoice(ctx, state)
+		return uuid.Nil, nil
+	}
+	if err := s.LeaveChannel(ctx, state.ChannelID, userID); err != nil {
+		if errors.Is(err, ErrNotInVoiceChannel) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, err
+	}
+	return state.ChannelID, nil
+}
+
+// applyServerVoice stops or lets a connected participant publish and receive
+// according to their server mute and deafen
+func (s *Service) applyServerVoice(ctx context.Context, state *models.VoiceState) {
+	if s.sfu == nil {
+		return
+	}
+	canPublish := !state.IsMuted && !state.IsDeafened && s.channelService.CanSpeakVoice(ctx, state.ChannelID, state.UserID)
+	if err := s.sfu.setPermissions(ctx, state.ChannelID, state.UserID, canPublish, !state.IsDeafened); err != nil {
+		log.Warn().Err(err).
+			Str("channelId", state.ChannelID.String()).
+			Str("userId", state.UserID.String()).
+			Msg("Failed to apply server mute or deafen in voice room")
+	}
+}
+
+// checkModerator requires an SFU and a user who can moderate the channel
+func (s *Service) checkModerator(ctx context.Context, channelID, userID uuid.UUID) error {
+	if s.sfu == nil {
+		return ErrSFUNotConfigured
+	}
+	if !s.channelService.CanManageMessages(ctx, channelID, userID) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+// StartRecording records a voice channel's audio with LiveKit egress
+func (s *Service) StartRecording(ctx context.Context, channelID, actorID uuid.UUID) (*Egress, error) {
+	if err := s.checkModerator(ctx, channelID, actorID); err != nil {
+		return nil, err
+	}
+	egress, err := s.sfu.startRecording(ctx, channelID)
+	if err != nil {
+		return nil, err
+	}
+	s.broadcastRecording(ctx, channelID, egress)
+	return egress, nil
+}
+
+// ListRecordings returns a voice channel's active recordings
+func (s *Service) ListRecordings(ctx context.Context, channelID, actorID uuid.UUID) ([]Egress, error) {
+	if err := s.checkModerator(ctx, channelID, actorID); err != nil {
+		return nil, err
+	}
+	return s.sfu.recordings(ctx, channelID)
+}
+
+// StopRecording ends one of a voice channel's recordings
+func (s *Service) StopRecording(ctx context.Context, channelID, actorID uuid.UUID, egressID string) (*Egress, error) {
+	recordings, err := s.ListRecordings(ctx, channelID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, recording := range recordings {
+		found = found || recording.EgressID == egressID
+	}
+	if !found {
+		return nil, ErrRecordingNotFound
+	}
+
+	egress, err := s.sfu.stopRecording(ctx, egressID)
+	if err != nil {
+		return nil, err
+	}
+	s.broadcastRecording(ctx, channelID, egress)
+	return egress, nil
+}
+
+// CreateIngress opens an RTMP or WHIP input that streams into a voice
+// channel, e.g. from OBS
+func (s *Service) CreateIngress(ctx context.Context, channelID, actorID uuid.UUID, inputType, name string) (*Ingress, error) {
+	if err := s.checkModerator(ctx, channelID, actorID); err != nil {
+		return nil, err
+	}
+	if inputType != IngressRTMP && inputType != IngressWHIP {
+		return nil, ErrInvalidIngressInput
+	}
+	identity := ingressIdentityPrefix + uuid.NewString()
+	return s.sfu.createIngress(ctx, channelID, inputType, identity, name)
+}
+
+// ListIngresses returns the inputs streaming into a voice channel
+func (s *Service) ListIngresses(ctx context.Context, channelID, actorID uuid.UUID) ([]Ingress, error) {
+	if err := s.checkModerator(ctx, channelID, actorID); err != nil {
+		return nil, err
+	}
+	return s.sfu.ingresses(ctx, channelID)
+}
+
+// DeleteIngress removes one of a voice channel's inputs
+func (s *Service) DeleteIngress(ctx context.Context, channelID, actorID uuid.UUID, ingressID string) error {
+	ingresses, err := s.ListIngresses(ctx, channelID, actorID)
+	if err != nil {
+		return err
+	}
+	for _, ingress := range ingresses {
+		if ingress.IngressID == ingressID {
+			return s.sfu.deleteIngress(ctx, ingressID)
+		}
+	}
+	return ErrIngressNotFound
+}
+
+// sfuWebhookEvent is the part of a LiveKit webhook the voice service uses
+type sfuWebhookEvent struct {
+	Event string `json:"event"`
+	Room  *struct {
+		Name string `json:"name"`
+	} `json:"room"`
+	Participant *struct {
+		Identity         string `json:"identity"`
+		DisconnectReason string `json:"disconnectReason"`
+	} `json:"participant"`
+	Track *struct {
+		Source string `json:"source"`
+	} `json:"track"`
+	EgressInfo *Egress `json:"egressInfo"`
+}
+
+// VerifyWebhook checks a LiveKit webhook's Authorization header: a token
+// signed with the API secret carrying the body's SHA-256
+func (s *Service) VerifyWebhook(authorization string, body []byte) error {
+	if s.sfu == nil {
+		return ErrSFUNotConfigured
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(s.sfu.apiSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(s.sfu.apiKey))
+	if err != nil {
+		return ErrInvalidWebhook
+	}
+
+	sum := sha256.Sum256(body)
+	claimed, _ := claims["sha256"].(string)
+	if subtle.ConstantTimeCompare([]byte(claimed), []byte(base64.StdEncoding.EncodeToString(sum[:]))) != 1 {
+		return ErrInvalidWebhook
+	}
+	return nil
+}
+
+// HandleWebhook applies what happened in a voice room to voice states and
+// relays it to the channel: participants who dropped out of the room leave
+// the channel, cameras and screen shares update their state and recordings report
+// progress. body must have passed VerifyWebhook.
+func (s *Service) HandleWebhook(ctx context.Context, body []byte) error {
+	var event sfuWebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return err
+	}
+	var room string
+	switch {
+	case event.Room != nil:
+		room = event.Room.Name
+	case event.EgressInfo != nil:
+		room = event.EgressInfo.RoomName
+	}
+	channelID, ok := sfuChannel(room)
+	if !ok {
+		return nil
+	}
+
+	switch event.Event {
+	case "participant_left":
+		userID, ok := participantUser(event)
+		// A client that reconnects under the same identity replaces itself
+		if !ok || event.Participant.DisconnectReason == "DUPLICATE_IDENTITY" {
+			return nil
+		}
+		if err := s.LeaveChannel(ctx, channelID, userID); err != nil {
+			if errors.Is(err, ErrNotInVoiceChannel) {
+				return nil
+			}
+			return err
+		}
+		s.broadcast(ctx, channelID, EventTypeVoiceLeave, map[string]interface{}{
+			"channelId": channelID.String(),
+			"userId":    userID.String(),
+		})
+
+	case "track_published", "track_unpublished":
+		userID, ok := participantUser(event)
+		if !ok || event.Track == nil {
+			return nil
+		}
+		// The track is already up, so the channel's caps don't apply
+		on := event.Event == "track_published"
+		update := &StateUpdate{}
+		switch event.Track.Source {
+		case "SCREEN_SHARE":
+			update.IsScreenSharing = &on
+		case "CAMERA":
+			update.IsVideo = &on
+		default:
+			return nil
+		}
+		state, err := s.updateVoiceState(ctx, channelID, userID, update, false)
+		if err != nil {
+			if errors.Is(err, ErrNotInVoiceChannel) {
+				return nil
+			}
+			return err
+		}
+		s.broadcast(ctx, channelID, EventTypeVoiceState, map[string]interface{}{
+			"channelId": channelID.String(),
+			"userId":    userID.String(),
+			"state":     state,
+		})
+
+	case "egress_started", "egress_updated", "egress_ended":
+		if event.EgressInfo != nil {
+			s.broadcastRecording(ctx, channelID, event.EgressInfo)
+		}
+	}
+	return nil
+}
+
+// participantUser is the user a webhook's participant joined as, if it's one
+func participantUser(event sfuWebhookEvent) (uuid.UUID, bool) {
+	if event.Participant == nil {
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(event.Participant.Identity)
+	return userID, err == nil
+}
+
+func (s *Service) broadcastRecording(ctx context.Context, channelID uuid.UUID, egress *Egress) {
+	s.broadcast(ctx, channelID, EventTypeVoiceRecording, map[string]interface{}{
+		"channelId": channelID.String(),
+		"egressId":  egress.EgressID,
+		"status":    egress.Status,
+	})
+}
+
+func (s *Service) broadcast(ctx context.Context, channelID uuid.UUID, eventType string, data interface{}) {
+	s.publish(ctx, channelID.String(), eventType, data)
+}
+
+// publish relays an event to a broadcast stream, e.g. a user's
+func (s *Service) publish(ctx context.Context, stream, eventType string, data interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"channelId": stream,
+		"event": map[string]interface{}{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", eventType).Msg("Failed to marshal voice event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Str("event", eventType).Msg("Failed to publish voice event")
+	}
+}
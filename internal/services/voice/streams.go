@@ -0,0 +1,135 @@
+package voice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/zentra/server/internal/models"
+)
+
+const (
+	// Cameras and screen shares allowed at once in a voice channel unless its
+	// metadata sets maxVideoStreams or maxScreenShares
+	DefaultMaxVideoStreams = 25
+	DefaultMaxScreenShares = 5
+
+	// Streams one participant can describe, e.g. a camera and two screens
+	MaxStreamsPerUser = 4
+
+	maxStreamTrackID   = 64
+	maxStreamDimension = 7680
+	maxStreamFrameRate = 240
+)
+
+var (
+	ErrVideoLimitReached  = errors.New("too many cameras are on in this voice channel")
+	ErrScreenLimitReached = errors.New("too many screen shares are running in this voice channel")
+)
+
+// StateUpdate is a change to a participant's own voice state. Nil fields are
+// left alone; Streams replaces the stream list when set.
+type StateUpdate struct {
+	IsSelfMuted     *bool                `json:"isSelfMuted"`
+	IsSelfDeafened  *bool                `json:"isSelfDeafened"`
+	IsScreenSharing *bool                `json:"isScreenSharing"`
+	IsVideo         *bool                `json:"isVideo"`
+	Streams         []models.VoiceStream `json:"streams"`
+}
+
+// streamLimits reads a voice channel's caps from its metadata
+func streamLimits(metadata json.RawMessage) (video, screen int) {
+	var meta struct {
+		MaxVideoStreams *int `json:"maxVideoStreams"`
+		MaxScreenShares *int `json:"maxScreenShares"`
+	}
+	if len(metadata) > 0 {
+		_ = json.Unmarshal(metadata, &meta)
+	}
+	video, screen = DefaultMaxVideoStreams, DefaultMaxScreenShares
+	if meta.MaxVideoStreams != nil && *meta.MaxVideoStreams >= 0 {
+		video = *meta.MaxVideoStreams
+	}
+	if meta.MaxScreenShares != nil && *meta.MaxScreenShares >= 0 {
+		screen = *meta.MaxScreenShares
+	}
+	return video, screen
+}
+
+// checkStreamLimits makes sure there's room in the channel for the streams
+// state is about to start. It locks the channel's streams until tx ends, so
+// two participants can't both take the last slot.
+func (s *Service) checkStreamLimits(ctx context.Context, tx pgx.Tx, channelID, userID uuid.UUID, startingVideo, startingScreen bool) error {
+	if !startingVideo && !startingScreen {
+		return nil
+	}
+	ch, err := s.channelService.GetChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	maxVideo, maxScreen := streamLimits(ch.Metadata)
+
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "voice-streams:"+channelID.String()); err != nil {
+		return err
+	}
+	var videos, screens int
+	err = tx.QueryRow(ctx,
+		`SELECT COUNT(*) FILTER (WHERE is_video), COUNT(*) FILTER (WHERE is_screen_sharing)
+		FROM voice_states WHERE channel_id = $1 AND user_id <> $2`,
+		channelID, userID,
+	).Scan(&videos, &screens)
+	if err != nil {
+		return err
+	}
+
+	if startingVideo && videos >= maxVideo {
+		return ErrVideoLimitReached
+	}
+	if startingScreen && screens >= maxScreen {
+		return ErrScreenLimitReached
+	}
+	return nil
+}
+
+// normalizeStreams keeps the streams state actually has on, dropping unknown
+// kinds and clamping what clients report
+func normalizeStreams(state *models.VoiceState, streams []models.VoiceStream) []models.VoiceStream {
+	normalized := make([]models.VoiceStream, 0, len(streams))
+	for _, stream := range streams {
+		if len(normalized) == MaxStreamsPerUser {
+			break
+		}
+		switch stream.Kind {
+		case models.VoiceStreamCamera:
+			if !state.IsVideo {
+				continue
+			}
+		case models.VoiceStreamScreen:
+			if !state.IsScreenSharing {
+				continue
+			}
+		default:
+			continue
+		}
+		if len(stream.TrackID) > maxStreamTrackID {
+			stream.TrackID = ""
+		}
+		stream.Width = clamp(stream.Width, maxStreamDimension)
+		stream.Height = clamp(stream.Height, maxStreamDimension)
+		stream.FrameRate = clamp(stream.FrameRate, maxStreamFrameRate)
+		normalized = append(normalized, stream)
+	}
+	return normalized
+}
+
+func clamp(n, limit int) int {
+	if n < 0 {
+		return 0
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
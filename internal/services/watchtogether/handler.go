@@ -0,0 +1,173 @@
+package watchtogether
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/utils"
+)
+
+type Handler struct {
+	service *Service
+}
+
+func NewHandler(service *Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) Routes() chi.Router {
+	r := chi.NewRouter()
+
+	r.Route("/channels/{channelId}", func(r chi.Router) {
+		r.Get("/", h.GetSession)
+		r.Post("/", h.StartSession)
+		r.Delete("/", h.EndSession)
+		r.Patch("/playback", h.UpdatePlayback)
+		r.Post("/host", h.TransferHost)
+		r.Post("/host/claim", h.ClaimHost)
+	})
+
+	return r
+}
+
+// GetSession returns the current playback state for catching up
+func (h *Handler) GetSession(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := requireChannel(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := h.service.GetSession(r.Context(), channelID, userID)
+	if err != nil {
+		respondWatchError(w, err, "Failed to get watch session")
+		return
+	}
+
+	utils.RespondSuccess(w, session)
+}
+
+// StartSession opens a session with the caller as host
+func (h *Handler) StartSession(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := requireChannel(w, r)
+	if !ok {
+		return
+	}
+
+	var req StartSessionRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	session, err := h.service.StartSession(r.Context(), channelID, userID, &req)
+	if err != nil {
+		respondWatchError(w, err, "Failed to start watch session")
+		return
+	}
+
+	utils.RespondCreated(w, session)
+}
+
+// UpdatePlayback loads a video, plays, pauses, seeks or changes speed
+func (h *Handler) UpdatePlayback(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := requireChannel(w, r)
+	if !ok {
+		return
+	}
+
+	var req PlaybackRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	session, err := h.service.UpdatePlayback(r.Context(), channelID, userID, &req)
+	if err != nil {
+		respondWatchError(w, err, "Failed to update playback")
+		return
+	}
+
+	utils.RespondSuccess(w, session)
+}
+
+func (h *Handler) TransferHost(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := requireChannel(w, r)
+	if !ok {
+		return
+	}
+
+	var req TransferHostRequest
+	if !utils.BindJSON(w, r, &req) {
+		return
+	}
+
+	session, err := h.service.TransferHost(r.Context(), channelID, userID, &req)
+	if err != nil {
+		respondWatchError(w, err, "Failed to transfer host")
+		return
+	}
+
+	utils.RespondSuccess(w, session)
+}
+
+func (h *Handler) ClaimHost(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := requireChannel(w, r)
+	if !ok {
+		return
+	}
+
+	session, err := h.service.ClaimHost(r.Context(), channelID, userID)
+	if err != nil {
+		respondWatchError(w, err, "Failed to claim host")
+		return
+	}
+
+	utils.RespondSuccess(w, session)
+}
+
+func (h *Handler) EndSession(w http.ResponseWriter, r *http.Request) {
+	userID, channelID, ok := requireChannel(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.service.EndSession(r.Context(), channelID, userID); err != nil {
+		respondWatchError(w, err, "Failed to end watch session")
+		return
+	}
+
+	utils.RespondNoContent(w)
+}
+
+func requireChannel(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := middleware.RequireAuth(r.Context())
+	if err != nil {
+		utils.RespondError(w, http.StatusUnauthorized, "Unauthorized")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	channelID, err := uuid.Parse(chi.URLParam(r, "channelId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid channel ID")
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, channelID, true
+}
+
+func respondWatchError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, channel.ErrChannelNotFound), errors.Is(err, ErrNoSession):
+		utils.RespondError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrNotWatchChannel), errors.Is(err, ErrInvalidMediaURL), errors.Is(err, ErrInvalidTarget):
+		utils.RespondError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrInsufficientPerms), errors.Is(err, ErrNotHost):
+		utils.RespondError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, ErrSessionExists), errors.Is(err, ErrHostPresent), errors.Is(err, ErrStaleVersion):
+		utils.RespondError(w, http.StatusConflict, err.Error())
+	default:
+		utils.RespondError(w, http.StatusInternalServerError, fallback)
+	}
+}
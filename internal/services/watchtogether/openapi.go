@@ -0,0 +1,46 @@
+package watchtogether
+
+import (
+	"net/http"
+
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	channelID := []openapi.Param{openapi.ID("channelId")}
+
+	openapi.Describe((*Handler).GetSession, openapi.Operation{
+		Summary:  "Get a channel's watch session, to catch up on playback",
+		Path:     channelID,
+		Response: Session{},
+	})
+	openapi.Describe((*Handler).StartSession, openapi.Operation{
+		Summary:  "Start a watch session hosted by the current user",
+		Path:     channelID,
+		Body:     StartSessionRequest{},
+		Response: Session{},
+		Status:   http.StatusCreated,
+	})
+	openapi.Describe((*Handler).EndSession, openapi.Operation{
+		Summary: "End a channel's watch session",
+		Path:    channelID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).UpdatePlayback, openapi.Operation{
+		Summary:  "Load a video, play, pause, seek or change speed",
+		Path:     channelID,
+		Body:     PlaybackRequest{},
+		Response: Session{},
+	})
+	openapi.Describe((*Handler).TransferHost, openapi.Operation{
+		Summary:  "Hand the session over to another viewer",
+		Path:     channelID,
+		Body:     TransferHostRequest{},
+		Response: Session{},
+	})
+	openapi.Describe((*Handler).ClaimHost, openapi.Operation{
+		Summary:  "Take over a session whose host left",
+		Path:     channelID,
+		Response: Session{},
+	})
+}
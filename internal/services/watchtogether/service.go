@@ -0,0 +1,394 @@
+package watchtogether
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/presence"
+	"github.com/zentra/server/pkg/database"
+)
+
+// PluginSlug is the slug the watch together plugin is seeded under in the plugins table.
+const PluginSlug = "watch-together"
+
+// ChannelType is the channel type registered by the plugin (migration 000045)
+const ChannelType models.ChannelType = "watch_together"
+
+// Event types sent on the channel's stream
+const (
+	EventTypeWatchState = "WATCH_STATE_UPDATE"
+	EventTypeWatchEnd   = "WATCH_SESSION_END"
+)
+
+var (
+	ErrNotWatchChannel   = errors.New("channel is not a watch together channel")
+	ErrInsufficientPerms = errors.New("insufficient permissions")
+	ErrNoSession         = errors.New("no watch session in this channel")
+	ErrSessionExists     = errors.New("a watch session is already running in this channel")
+	ErrNotHost           = errors.New("only the host can control playback")
+	ErrHostPresent       = errors.New("the host is still online")
+	ErrInvalidMediaURL   = errors.New("media url must be an http or https url")
+	ErrInvalidTarget     = errors.New("new host can't access this channel")
+	ErrStaleVersion      = errors.New("playback state has changed since it was loaded")
+)
+
+// Session is the shared playback state of a channel. PositionMs is the
+// position at ServerTime; while Playing, clients advance it by
+// PlaybackRate times the time elapsed since.
+type Session struct {
+	ChannelID    uuid.UUID `json:"channelId"`
+	HostID       uuid.UUID `json:"hostId"`
+	MediaURL     string    `json:"mediaUrl"`
+	Title        *string   `json:"title,omitempty"`
+	Playing      bool      `json:"playing"`
+	PositionMs   int64     `json:"positionMs"`
+	PlaybackRate float64   `json:"playbackRate"`
+	Version      int64     `json:"version"`
+	ServerTime   time.Time `json:"serverTime"`
+	CreatedAt    time.Time `json:"createdAt"`
+
+	// stateChangedAt is when PositionMs was last set by a control
+	stateChangedAt time.Time
+}
+
+// advance moves PositionMs to where playback is at now
+func (s *Session) advance(now time.Time) {
+	if s.Playing && now.After(s.stateChangedAt) {
+		elapsed := now.Sub(s.stateChangedAt).Milliseconds()
+		s.PositionMs += int64(float64(elapsed) * s.PlaybackRate)
+	}
+	s.stateChangedAt = now
+	s.ServerTime = now
+}
+
+type StartSessionRequest struct {
+	MediaURL string  `json:"mediaUrl" validate:"required,max=2048"`
+	Title    *string `json:"title,omitempty" validate:"omitempty,max=256"`
+}
+
+// PlaybackRequest changes the session. Loading a new MediaURL starts it
+// paused from the beginning unless Playing or PositionMs say otherwise.
+// Version, when set, must match the state the client last saw.
+type PlaybackRequest struct {
+	MediaURL     *string  `json:"mediaUrl,omitempty" validate:"omitempty,max=2048"`
+	Title        *string  `json:"title,omitempty" validate:"omitempty,max=256"`
+	Playing      *bool    `json:"playing,omitempty"`
+	PositionMs   *int64   `json:"positionMs,omitempty" validate:"omitempty,min=0"`
+	PlaybackRate *float64 `json:"playbackRate,omitempty" validate:"omitempty,min=0.25,max=4"`
+	Version      *int64   `json:"version,omitempty"`
+}
+
+type TransferHostRequest struct {
+	UserID uuid.UUID `json:"userId" validate:"required"`
+}
+
+type Service struct {
+	db              *pgxpool.Pool
+	channelService  *channel.Service
+	presenceService *presence.Service
+}
+
+func NewService(db *pgxpool.Pool, channelService *channel.Service, presenceService *presence.Service) *Service {
+	return &Service{
+		db:              db,
+		channelService:  channelService,
+		presenceService: presenceService,
+	}
+}
+
+// GetSession returns the channel's session with the position brought up to
+// date, which is all a late joiner needs to catch up
+func (s *Service) GetSession(ctx context.Context, channelID, userID uuid.UUID) (*Session, error) {
+	if err := s.requireAccess(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+	return s.CurrentSession(ctx, channelID)
+}
+
+// CurrentSession loads the session without an access check, for callers
+// that have already done one
+func (s *Service) CurrentSession(ctx context.Context, channelID uuid.UUID) (*Session, error) {
+	session, err := s.load(ctx, s.db, channelID, false)
+	if err != nil {
+		return nil, err
+	}
+	session.advance(time.Now())
+	return session, nil
+}
+
+// StartSession opens a session with the caller as host. There is at most
+// one per channel; to change the video the host loads a new url instead.
+func (s *Service) StartSession(ctx context.Context, channelID, userID uuid.UUID, req *StartSessionRequest) (*Session, error) {
+	if err := s.requireAccess(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+	if !s.channelService.CanSendMessage(ctx, channelID, userID) {
+		return nil, ErrInsufficientPerms
+	}
+	mediaURL, err := normalizeMediaURL(req.MediaURL)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		ChannelID:    channelID,
+		HostID:       userID,
+		MediaURL:     mediaURL,
+		Title:        req.Title,
+		PlaybackRate: 1,
+	}
+	err = s.db.QueryRow(ctx,
+		`INSERT INTO watch_sessions (channel_id, host_id, media_url, title)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (channel_id) DO NOTHING
+		RETURNING version, state_changed_at, created_at`,
+		channelID, userID, mediaURL, req.Title,
+	).Scan(&session.Version, &session.stateChangedAt, &session.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrSessionExists
+		}
+		return nil, err
+	}
+	session.ServerTime = session.stateChangedAt
+
+	s.broadcast(ctx, channelID, EventTypeWatchState, session)
+	return session, nil
+}
+
+// UpdatePlayback applies a host control. Moderators can control playback
+// too, so a session can't be held hostage by its host.
+func (s *Service) UpdatePlayback(ctx context.Context, channelID, userID uuid.UUID, req *PlaybackRequest) (*Session, error) {
+	if err := s.requireAccess(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+
+	var mediaURL string
+	if req.MediaURL != nil {
+		var err error
+		if mediaURL, err = normalizeMediaURL(*req.MediaURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.update(ctx, channelID, func(session *Session) error {
+		if session.HostID != userID && !s.channelService.CanManageMessages(ctx, channelID, userID) {
+			return ErrNotHost
+		}
+		if req.Version != nil && *req.Version != session.Version {
+			return ErrStaleVersion
+		}
+
+		if req.MediaURL != nil && mediaURL != session.MediaURL {
+			session.MediaURL = mediaURL
+			session.Title = nil
+			session.Playing = false
+			session.PositionMs = 0
+		}
+		if req.Title != nil {
+			session.Title = req.Title
+			if strings.TrimSpace(*req.Title) == "" {
+				session.Title = nil
+			}
+		}
+		if req.Playing != nil {
+			session.Playing = *req.Playing
+		}
+		if req.PositionMs != nil {
+			session.PositionMs = *req.PositionMs
+		}
+		if req.PlaybackRate != nil {
+			session.PlaybackRate = *req.PlaybackRate
+		}
+		return nil
+	})
+}
+
+// TransferHost hands host controls to another member who can see the channel
+func (s *Service) TransferHost(ctx context.Context, channelID, userID uuid.UUID, req *TransferHostRequest) (*Session, error) {
+	if err := s.requireAccess(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+	if !s.channelService.CanAccessChannel(ctx, channelID, req.UserID) {
+		return nil, ErrInvalidTarget
+	}
+
+	return s.update(ctx, channelID, func(session *Session) error {
+		if session.HostID != userID && !s.channelService.CanManageMessages(ctx, channelID, userID) {
+			return ErrNotHost
+		}
+		session.HostID = req.UserID
+		return nil
+	})
+}
+
+// ClaimHost lets a member take over a session whose host has gone offline
+// or lost access to the channel
+func (s *Service) ClaimHost(ctx context.Context, channelID, userID uuid.UUID) (*Session, error) {
+	if err := s.requireAccess(ctx, channelID, userID); err != nil {
+		return nil, err
+	}
+
+	return s.update(ctx, channelID, func(session *Session) error {
+		if session.HostID == userID {
+			return nil
+		}
+		if s.presenceService.IsOnline(ctx, session.HostID) &&
+			s.channelService.CanAccessChannel(ctx, channelID, session.HostID) {
+			return ErrHostPresent
+		}
+		session.HostID = userID
+		return nil
+	})
+}
+
+// EndSession closes the channel's session. Only the host or a moderator can.
+func (s *Service) EndSession(ctx context.Context, channelID, userID uuid.UUID) error {
+	if err := s.requireAccess(ctx, channelID, userID); err != nil {
+		return err
+	}
+
+	session, err := s.load(ctx, s.db, channelID, false)
+	if err != nil {
+		return err
+	}
+	if session.HostID != userID && !s.channelService.CanManageMessages(ctx, channelID, userID) {
+		return ErrNotHost
+	}
+
+	tag, err := s.db.Exec(ctx, `DELETE FROM watch_sessions WHERE channel_id = $1`, channelID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNoSession
+	}
+
+	s.broadcast(ctx, channelID, EventTypeWatchEnd, map[string]interface{}{
+		"channelId": channelID,
+		"endedBy":   userID,
+	})
+	return nil
+}
+
+// update locks the session, brings its position up to now, applies change
+// and persists the result as a new version. Every control goes through
+// here so two hosts racing can't interleave their writes.
+func (s *Service) update(ctx context.Context, channelID uuid.UUID, change func(*Session) error) (*Session, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	session, err := s.load(ctx, tx, channelID, true)
+	if err != nil {
+		return nil, err
+	}
+	session.advance(time.Now())
+
+	if err := change(session); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx,
+		`UPDATE watch_sessions
+		SET host_id = $2, media_url = $3, title = $4, playing = $5, position_ms = $6,
+			playback_rate = $7, state_changed_at = $8, version = version + 1
+		WHERE channel_id = $1
+		RETURNING version`,
+		channelID, session.HostID, session.MediaURL, session.Title, session.Playing,
+		session.PositionMs, session.PlaybackRate, session.stateChangedAt,
+	).Scan(&session.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	s.broadcast(ctx, channelID, EventTypeWatchState, session)
+	return session, nil
+}
+
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func (s *Service) load(ctx context.Context, q querier, channelID uuid.UUID, forUpdate bool) (*Session, error) {
+	query := `SELECT channel_id, host_id, media_url, title, playing, position_ms, playback_rate,
+		state_changed_at, version, created_at
+		FROM watch_sessions WHERE channel_id = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	session := &Session{}
+	var rate float32
+	err := q.QueryRow(ctx, query, channelID).Scan(
+		&session.ChannelID, &session.HostID, &session.MediaURL, &session.Title, &session.Playing,
+		&session.PositionMs, &rate, &session.stateChangedAt, &session.Version, &session.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNoSession
+		}
+		return nil, err
+	}
+	session.PlaybackRate = float64(rate)
+	session.ServerTime = session.stateChangedAt
+	return session, nil
+}
+
+func (s *Service) requireAccess(ctx context.Context, channelID, userID uuid.UUID) error {
+	ch, err := s.channelService.GetChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	if ch.Type != ChannelType {
+		return ErrNotWatchChannel
+	}
+	if !s.channelService.CanAccessChannel(ctx, channelID, userID) {
+		return ErrInsufficientPerms
+	}
+	return nil
+}
+
+func (s *Service) broadcast(ctx context.Context, channelID uuid.UUID, eventType string, data interface{}) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"channelId": channelID.String(),
+		"event": map[string]interface{}{
+			"type": eventType,
+			"data": data,
+		},
+	})
+	if err != nil {
+		log.Error().Err(err).Str("event", eventType).Msg("Failed to marshal watch event")
+		return
+	}
+
+	if err := database.PublishBroadcast(ctx, payload); err != nil {
+		log.Warn().Err(err).Str("event", eventType).Msg("Failed to publish watch event")
+	}
+}
+
+// normalizeMediaURL only accepts absolute http(s) urls; clients embed or
+// play whatever is loaded, so other schemes are never passed along
+func normalizeMediaURL(raw string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.User != nil {
+		return "", ErrInvalidMediaURL
+	}
+	return u.String(), nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/zentra/server/internal/middleware"
 	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/message"
 	"github.com/zentra/server/internal/utils"
 )
 
@@ -64,8 +65,7 @@ func (h *Handler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req CreateWebhookRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -178,8 +178,7 @@ func (h *Handler) UpdateWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req UpdateWebhookRequest
-	if err := utils.DecodeJSON(r, &req); err != nil {
-		utils.RespondError(w, http.StatusBadRequest, "Invalid request body")
+	if !utils.BindJSON(w, r, &req) {
 		return
 	}
 
@@ -291,6 +290,12 @@ func (h *Handler) ExecuteWebhook(w http.ResponseWriter, r *http.Request) {
 			utils.RespondError(w, http.StatusNotFound, "Webhook not found")
 		case errors.Is(err, ErrWebhookInactive):
 			utils.RespondError(w, http.StatusGone, "Webhook is inactive")
+		case errors.Is(err, message.ErrChannelUnavailable):
+			utils.RespondError(w, http.StatusGone, "Webhook channel is archived")
+		case errors.Is(err, message.ErrBlockedByAutoMod):
+			utils.RespondError(w, http.StatusForbidden, "Message blocked by AutoMod")
+		case errors.Is(err, message.ErrRemovedByAutoMod):
+			utils.RespondError(w, http.StatusForbidden, "Message removed by AutoMod")
 		default:
 			utils.RespondError(w, http.StatusInternalServerError, "Failed to process webhook")
 		}
@@ -0,0 +1,64 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/openapi"
+)
+
+func init() {
+	channelID := []openapi.Param{openapi.ID("channelId")}
+	webhookID := []openapi.Param{openapi.ID("webhookId")}
+
+	openapi.Describe((*Handler).CreateWebhook, openapi.Operation{
+		Summary:     "Create a channel webhook",
+		Description: "The token and the URL holding it are only in this response.",
+		Path:        channelID,
+		Body:        CreateWebhookRequest{},
+		Response:    WebhookSecretResponse{},
+		Status:      http.StatusCreated,
+	})
+	openapi.Describe((*Handler).UploadWebhookAvatar, openapi.Operation{
+		Summary: "Upload an avatar for a channel's webhooks",
+		Path:    channelID,
+		Upload:  "avatar",
+		Response: struct {
+			URL string `json:"url"`
+		}{},
+	})
+	openapi.Describe((*Handler).ListChannelWebhooks, openapi.Operation{
+		Summary:  "List a channel's webhooks",
+		Path:     channelID,
+		Response: []*models.Webhook{},
+	})
+	openapi.Describe((*Handler).UpdateWebhook, openapi.Operation{
+		Summary:  "Update a webhook",
+		Path:     webhookID,
+		Body:     UpdateWebhookRequest{},
+		Response: models.Webhook{},
+	})
+	openapi.Describe((*Handler).RotateWebhookToken, openapi.Operation{
+		Summary:     "Replace a webhook's token",
+		Description: "The new token and URL are only in this response.",
+		Path:        webhookID,
+		Response:    WebhookSecretResponse{},
+	})
+	openapi.Describe((*Handler).DeleteWebhook, openapi.Operation{
+		Summary: "Delete a webhook",
+		Path:    webhookID,
+		Status:  http.StatusNoContent,
+	})
+	openapi.Describe((*Handler).ExecuteWebhook, openapi.Operation{
+		Summary:     "Post a message through a webhook",
+		Description: "Takes any JSON payload, GitHub events included, as well as form-encoded and plain text bodies.",
+		Path:        append(webhookID, openapi.Param{Name: "token", Required: true}),
+		Body:        json.RawMessage{},
+		Response: struct {
+			MessageID uuid.UUID `json:"messageId"`
+			ChannelID uuid.UUID `json:"channelId"`
+		}{},
+	})
+}
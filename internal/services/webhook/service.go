@@ -27,7 +27,6 @@ import (
 	"github.com/zentra/server/internal/services/media"
 	"github.com/zentra/server/internal/services/message"
 	"github.com/zentra/server/internal/services/messaging"
-	"github.com/zentra/server/pkg/auth"
 )
 
 const (
@@ -52,10 +51,15 @@ type AvatarUploader interface {
 	UploadAvatar(ctx context.Context, ownerID uuid.UUID, ownerType string, file multipart.File, header *multipart.FileHeader) (string, error)
 }
 
+// MessagePoster posts webhook payloads as the webhook's bot user
+type MessagePoster interface {
+	CreateBotMessage(ctx context.Context, m *message.BotMessage) (*message.MessageResponse, error)
+}
+
 type Service struct {
 	db             *pgxpool.Pool
 	redis          *redis.Client
-	cipher         messaging.ContentCipher
+	messages       MessagePoster
 	channelService ChannelServiceInterface
 	avatarUploader AvatarUploader
 }
@@ -74,11 +78,11 @@ type UpdateWebhookRequest struct {
 	IsActive     *bool   `json:"isActive"`
 }
 
-func NewService(db *pgxpool.Pool, redisClient *redis.Client, encryptionKey []byte, channelService ChannelServiceInterface, avatarUploader AvatarUploader) *Service {
+func NewService(db *pgxpool.Pool, redisClient *redis.Client, messages MessagePoster, channelService ChannelServiceInterface, avatarUploader AvatarUploader) *Service {
 	return &Service{
 		db:             db,
 		redis:          redisClient,
-		cipher:         messaging.NewChannelCipher(encryptionKey),
+		messages:       messages,
 		channelService: channelService,
 		avatarUploader: avatarUploader,
 	}
@@ -131,7 +135,7 @@ func (s *Service) CreateWebhook(ctx context.Context, channelID, userID uuid.UUID
 	}
 	defer tx.Rollback(ctx)
 
-	botUserID, err := s.createWebhookBotUser(ctx, tx, webhookID, name, avatarURL, now)
+	botUserID, err := messaging.CreateBotUser(ctx, tx, messaging.BotUserWebhook, webhookID, name, avatarURL)
 	if err != nil {
 		return nil, "", err
 	}
@@ -354,58 +358,29 @@ func (s *Service) ExecuteWebhook(ctx context.Context, webhookID uuid.UUID, token
 		return nil, ErrInvalidWebhookToken
 	}
 
+	// The payload's own previews are used instead of fetching the links in it
 	content, previews := buildWebhookMessage(webhook, headers, contentType, rawBody)
-	encryptedContent, _, err := s.cipher.Encrypt(content)
-	if err != nil {
-		return nil, fmt.Errorf("encrypt webhook message: %w", err)
-	}
-
-	linkPreviewJSON := messaging.EncodeLinkPreviews(previews)
-	messageID := uuid.New()
-	now := time.Now()
-
-	tx, err := s.db.Begin(ctx)
-	if err != nil {
-		return nil, err
-	}
-	defer tx.Rollback(ctx)
-
-	_, err = tx.Exec(ctx,
-		`INSERT INTO messages (id, channel_id, author_id, encrypted_content, link_previews, created_at, updated_at)
-		 VALUES ($1, $2, $3, $4, $5::jsonb, $6, $6)`,
-		messageID, webhook.ChannelID, webhook.BotUserID, encryptedContent, string(linkPreviewJSON), now,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("insert webhook message: %w", err)
-	}
-
-	_, err = tx.Exec(ctx,
-		`UPDATE channels SET last_message_at = $1 WHERE id = $2`,
-		now, webhook.ChannelID,
-	)
+	if previews == nil {
+		previews = []models.LinkPreview{}
+	}
+	resp, err := s.messages.CreateBotMessage(ctx, &message.BotMessage{
+		ChannelID:    webhook.ChannelID,
+		CommunityID:  webhook.CommunityID,
+		AuthorID:     webhook.BotUserID,
+		Content:      content,
+		LinkPreviews: previews,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = tx.Exec(ctx,
+	if _, err := s.db.Exec(ctx,
 		`UPDATE webhooks SET last_used_at = $2, updated_at = $2 WHERE id = $1`,
-		webhookID, now,
-	)
-	if err != nil {
-		return nil, err
+		webhookID, resp.CreatedAt,
+	); err != nil {
+		log.Warn().Err(err).Str("webhookId", webhookID.String()).Msg("Failed to record webhook use")
 	}
 
-	if err := tx.Commit(ctx); err != nil {
-		return nil, err
-	}
-
-	resp, err := s.getMessageResponse(ctx, messageID)
-	if err != nil {
-		return nil, err
-	}
-
-	s.broadcast(ctx, webhook.ChannelID.String(), "MESSAGE_CREATE", resp)
-
 	return resp, nil
 }
 
@@ -421,29 +396,6 @@ func (s *Service) getChannelCommunityID(ctx context.Context, channelID uuid.UUID
 	return communityID, nil
 }
 
-func (s *Service) createWebhookBotUser(ctx context.Context, tx pgx.Tx, webhookID uuid.UUID, displayName string, avatarURL *string, now time.Time) (uuid.UUID, error) {
-	botUserID := uuid.New()
-	compactID := strings.ReplaceAll(webhookID.String(), "-", "")
-	username := "wh" + compactID[:30]
-	email := fmt.Sprintf("%s@webhook.zentra.local", username)
-
-	passwordHash, err := auth.HashPassword(uuid.NewString() + ":webhook")
-	if err != nil {
-		return uuid.Nil, err
-	}
-
-	_, err = tx.Exec(ctx,
-		`INSERT INTO users (id, username, email, password_hash, display_name, avatar_url, status, email_verified, created_at, updated_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, TRUE, $8, $8)`,
-		botUserID, username, email, passwordHash, displayName, avatarURL, models.UserStatusOffline, now,
-	)
-	if err != nil {
-		return uuid.Nil, fmt.Errorf("create webhook bot user: %w", err)
-	}
-
-	return botUserID, nil
-}
-
 func (s *Service) getWebhook(ctx context.Context, webhookID uuid.UUID) (*models.Webhook, error) {
 	row := s.db.QueryRow(ctx,
 		`SELECT id, channel_id, community_id, created_by, bot_user_id, name, avatar_url, provider_hint,
@@ -487,90 +439,6 @@ func scanWebhook(scanner interface{ Scan(dest ...any) error }) (*models.Webhook,
 	return w, nil
 }
 
-func (s *Service) getMessageResponse(ctx context.Context, messageID uuid.UUID) (*message.MessageResponse, error) {
-	query := `
-		SELECT m.id, m.channel_id, m.author_id, m.encrypted_content, m.reply_to_id,
-		       m.link_previews, m.is_pinned, m.is_edited, m.reactions, m.created_at, m.updated_at,
-		       u.id, u.username, u.display_name, u.avatar_url, u.bio, u.status, u.custom_status, u.created_at
-		FROM messages m
-		JOIN users u ON u.id = m.author_id
-		WHERE m.id = $1 AND m.deleted_at IS NULL`
-
-	var msg models.Message
-	var encContent []byte
-	var linkPreviewRaw []byte
-	var author models.PublicUser
-
-	err := s.db.QueryRow(ctx, query, messageID).Scan(
-		&msg.ID, &msg.ChannelID, &msg.AuthorID, &encContent,
-		&msg.ReplyToID, &linkPreviewRaw, &msg.IsPinned, &msg.IsEdited, &msg.Reactions, &msg.CreatedAt, &msg.UpdatedAt,
-		&author.ID, &author.Username, &author.DisplayName, &author.AvatarURL, &author.Bio, &author.Status, &author.CustomStatus, &author.CreatedAt,
-	)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, message.ErrMessageNotFound
-		}
-		return nil, err
-	}
-
-	content, err := s.cipher.Decrypt(encContent, nil)
-	if err != nil {
-		fallback := "[Decryption Error]"
-		msg.Content = &fallback
-	} else {
-		msg.Content = &content
-	}
-	msg.LinkPreviews = messaging.DecodeLinkPreviews(linkPreviewRaw)
-
-	resp := &message.MessageResponse{
-		Message:   &msg,
-		Author:    &author,
-		Reactions: make([]message.ReactionSummary, 0),
-	}
-
-	for emoji, users := range msg.Reactions {
-		if len(users) == 0 {
-			continue
-		}
-		resp.Reactions = append(resp.Reactions, message.ReactionSummary{
-			Emoji:   emoji,
-			Count:   len(users),
-			Users:   users,
-			Reacted: false,
-		})
-	}
-
-	return resp, nil
-}
-
-func (s *Service) broadcast(ctx context.Context, channelID string, eventType string, data any) {
-	event := struct {
-		Type string `json:"type"`
-		Data any    `json:"data"`
-	}{
-		Type: eventType,
-		Data: data,
-	}
-
-	broadcast := struct {
-		ChannelID string `json:"channelId"`
-		Event     any    `json:"event"`
-	}{
-		ChannelID: channelID,
-		Event:     event,
-	}
-
-	jsonData, err := json.Marshal(broadcast)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal webhook broadcast event")
-		return
-	}
-
-	if err := s.redis.Publish(ctx, "websocket:broadcast", jsonData).Err(); err != nil {
-		log.Error().Err(err).Msg("Failed to publish webhook broadcast")
-	}
-}
-
 func buildWebhookMessage(webhook *models.Webhook, headers http.Header, contentType string, rawBody []byte) (string, []models.LinkPreview) {
 	payload, rawText := parseWebhookPayload(contentType, rawBody)
 	provider, event := detectProvider(headers, webhook.ProviderHint, payload)
@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+// BackpressurePolicy decides what happens to events for a connection whose
+// send queue is full, i.e. a client that reads slower than events arrive.
+type BackpressurePolicy string
+
+const (
+	// Queue events past the send buffer, up to the hub's maximum; a client
+	// that falls further behind is disconnected
+	BackpressureGrow BackpressurePolicy = "grow"
+
+	// Drop events until the queue drains, then send RESYNC_REQUIRED with the
+	// dropped sequence range so the client refetches or resumes
+	BackpressureResync BackpressurePolicy = "resync"
+
+	// Close the connection with CloseBufferOverrun; the session can still be
+	// resumed while the dropped events are in its replay buffer
+	BackpressureDisconnect BackpressurePolicy = "disconnect"
+)
+
+// CloseBufferOverrun is sent to a client that fell too far behind on events
+const CloseBufferOverrun = 4010
+
+const (
+	defaultBackpressure  = BackpressureResync
+	defaultMaxSendBuffer = 4096
+)
+
+var (
+	droppedEventsTotal = metrics.NewCounter("zentra_gateway_dropped_events_total",
+		"Outbound gateway events not queued because a connection fell behind, by backpressure policy.", "policy")
+	bufferOverrunsTotal = metrics.NewCounter("zentra_gateway_buffer_overruns_total",
+		"Times a gateway connection's send queue filled up, by the action taken.", "action")
+)
+
+// SetBackpressure picks what happens to events for connections that fall
+// behind and how many events the grow policy may queue per connection. Must be
+// called before clients connect.
+func (h *Hub) SetBackpressure(policy BackpressurePolicy, maxSendBuffer int) {
+	switch policy {
+	case BackpressureGrow, BackpressureResync, BackpressureDisconnect:
+		h.backpressure = policy
+	default:
+		log.Warn().Str("policy", string(policy)).Msg("Unknown gateway backpressure policy, keeping " + string(h.backpressure))
+	}
+	if maxSendBuffer > sendBufferSize {
+		h.maxSendBuffer = maxSendBuffer
+	}
+}
+
+// queue puts data on the attached connection's send queue, applying the
+// hub's backpressure policy when the queue is full. seq is zero for data
+// outside the event sequence. Called with sendMu held.
+func (c *Client) queue(data []byte, seq int64) {
+	if c.overrunClosing {
+		return
+	}
+	if c.overrunSeq > 0 {
+		// Nothing more goes out until the client has been told to resync
+		if seq > 0 {
+			droppedEventsTotal.Inc(string(BackpressureResync))
+		}
+		return
+	}
+	if len(c.overflow) == 0 {
+		select {
+		case c.Send <- data:
+			return
+		default:
+		}
+	}
+
+	policy := c.Hub.backpressure
+	switch {
+	case policy == BackpressureGrow && len(c.Send)+len(c.overflow) < c.Hub.maxSendBuffer:
+		if len(c.overflow) == 0 {
+			bufferOverrunsTotal.Inc("grow")
+		}
+		c.overflow = append(c.overflow, data)
+		return
+	case policy == BackpressureResync && seq == 0:
+		// Replies like heartbeat acks aren't worth a resync
+	case policy == BackpressureResync:
+		bufferOverrunsTotal.Inc("resync")
+		c.overrunSeq = seq
+		log.Warn().Str("clientId", c.ID.String()).Int64("seq", seq).Msg("Client send buffer full, dropping events until it drains")
+	default:
+		bufferOverrunsTotal.Inc("disconnect")
+		c.closeOverrun()
+	}
+	droppedEventsTotal.Inc(string(policy))
+}
+
+// closeOverrun closes the attached connection with CloseBufferOverrun.
+// Called with sendMu held.
+func (c *Client) closeOverrun() {
+	c.overrunClosing = true
+	c.overflow = nil
+
+	log.Warn().
+		Str("clientId", c.ID.String()).
+		Str("userId", c.UserID.String()).
+		Int64("seq", c.seq).
+		Msg("Closing gateway connection that fell behind on events")
+
+	if c.stream != nil {
+		c.stream.closeWith(CloseBufferOverrun, "buffer overrun")
+		return
+	}
+	conn := c.Conn
+	go func() {
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(CloseBufferOverrun, "buffer overrun"),
+			time.Now().Add(writeWait))
+		conn.Close()
+	}()
+}
+
+// drainQueue collects first and everything else queued for the connection
+// that owns send into one batch. Once the queue is empty, a client that had
+// events dropped is told which ones.
+func (c *Client) drainQueue(send chan []byte, first []byte) [][]byte {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	batch := [][]byte{first}
+	n := len(send)
+	for i := 0; i < n; i++ {
+		batch = append(batch, <-send)
+	}
+	// A resumed session has moved on to a new connection and queue
+	if c.Send != send {
+		return batch
+	}
+
+	batch = append(batch, c.overflow...)
+	c.overflow = nil
+
+	if c.overrunSeq > 0 {
+		p, err := newPayload(&Event{
+			Type: EventTypeResyncRequired,
+			Data: map[string]interface{}{
+				"fromSeq": c.overrunSeq,
+				"toSeq":   c.seq,
+			},
+		})
+		if err == nil {
+			if data := p.encode(c.encoding); data != nil {
+				batch = append(batch, data)
+			}
+		}
+		c.overrunSeq = 0
+	}
+	return batch
+}
+
+// resetOverrun forgets queue state that belonged to the previous connection.
+// Called with sendMu held.
+func (c *Client) resetOverrun() {
+	c.overflow = nil
+	c.overrunSeq = 0
+	c.overrunClosing = false
+}
@@ -3,56 +3,71 @@ package websocket
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/voice"
+	"github.com/zentra/server/internal/services/watchtogether"
 )
 
 const (
 	// Time allowed to write a message to the peer
 	writeWait = 10 * time.Second
 
-	// Time allowed to read the next pong message from the peer
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period (must be less than pongWait)
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer
-	maxMessageSize = 1024 * 1024
+	// Maximum message size allowed from peer; most ops are capped lower in
+	// opLimits
+	maxMessageSize = 64 * 1024
 )
 
-func NewClient(userID uuid.UUID, conn *websocket.Conn, hub *Hub) *Client {
+func NewClient(userID uuid.UUID, conn *websocket.Conn, encoder *frameEncoder, hub *Hub) *Client {
+	client := newClient(userID, encoder.encoding, hub)
+	client.Conn = conn
+	client.encoder = encoder
+	return client
+}
+
+func newClient(userID uuid.UUID, encoding string, hub *Hub) *Client {
 	return &Client{
-		ID:         uuid.New(),
-		UserID:     userID,
-		Conn:       conn,
-		Send:       make(chan []byte, 256),
-		Hub:        hub,
-		Subscribed: make(map[string]bool),
-		lastPing:   time.Now(),
+		ID:          uuid.New(),
+		UserID:      userID,
+		encoding:    encoding,
+		Send:        make(chan []byte, sendBufferSize),
+		Hub:         hub,
+		Subscribed:  make(map[string]bool),
+		memberLists: make(map[uuid.UUID]string),
+		limiter:     newOpLimiter(),
+		lastPing:    time.Now(),
+		lastActive:  time.Now(),
+		attached:    true,
 	}
 }
 
 // ReadPump pumps messages from the WebSocket connection to the hub
 func (c *Client) ReadPump() {
+	// A resumed session gets a new connection, so each pump sticks to the
+	// one it was started for
+	c.sendMu.Lock()
+	conn := c.Conn
+	c.sendMu.Unlock()
+
 	defer func() {
-		c.Hub.unregister <- c
-		c.Conn.Close()
+		c.Hub.unregister <- clientConn{client: c, conn: conn}
+		conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(maxMessageSize)
-	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(pongWait))
-		c.lastPing = time.Now()
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(c.Hub.heartbeatTimeout))
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(c.Hub.heartbeatTimeout))
+		c.handlePong(appData)
 		return nil
 	})
 
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		messageType, message, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Error().
@@ -63,63 +78,94 @@ func (c *Client) ReadPump() {
 			break
 		}
 
-		c.handleMessage(message)
+		message, err = decodeClientMessage(c.encoding, messageType, message)
+		if err != nil {
+			log.Warn().Err(err).Str("clientId", c.ID.String()).Msg("Failed to decode client message")
+			if abuse := c.limiter.violation(closeDecodeError); abuse != nil {
+				c.closeAbusive(conn, abuse)
+				break
+			}
+			continue
+		}
+		if abuse := c.handleMessage(message); abuse != nil {
+			c.closeAbusive(conn, abuse)
+			break
+		}
 	}
 }
 
 // WritePump pumps messages from the hub to the WebSocket connection
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	c.sendMu.Lock()
+	conn, send, encoder := c.Conn, c.Send, c.encoder
+	c.sendMu.Unlock()
+
+	ticker := time.NewTicker(c.Hub.heartbeatInterval)
 	defer func() {
 		ticker.Stop()
-		c.Conn.Close()
+		conn.Close()
+		encoder.close()
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		case message, ok := <-send:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// Hub closed the channel
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			w.Write(message)
 
 			// Add queued messages to the current WebSocket message
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
-			}
+			batch := c.drainQueue(send, message)
 
-			if err := w.Close(); err != nil {
+			if err := encoder.write(conn, batch); err != nil {
 				return
 			}
 
 		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, pingData(time.Now())); err != nil {
 				return
 			}
 		}
 	}
 }
 
-// handleMessage processes incoming messages from the client
-func (c *Client) handleMessage(message []byte) {
+// handleMessage processes incoming messages from the client. It returns a
+// close for a client that has broken the gateway's limits too often.
+func (c *Client) handleMessage(message []byte) *gatewayClose {
 	var msg ClientMessage
 	if err := json.Unmarshal(message, &msg); err != nil {
 		log.Error().
 			Err(err).
 			Str("clientId", c.ID.String()).
 			Msg("Failed to parse client message")
-		return
+		return c.limiter.violation(closeDecodeError)
+	}
+
+	if _, known := opLimits[msg.Type]; !known {
+		log.Warn().
+			Str("type", msg.Type).
+			Str("clientId", c.ID.String()).
+			Msg("Unknown message type")
+		opsTotal.Inc("unknown")
+		return c.limiter.violation(closeUnknownOp)
+	}
+	allowed, retryAfter, abuse := c.limiter.allow(msg.Type, len(message))
+	if abuse != nil {
+		return abuse
+	}
+	if !allowed {
+		c.sendRateLimited(msg.Type, retryAfter)
+		return nil
+	}
+
+	// Heartbeats are automatic and presence updates say for themselves
+	// whether the user is idle
+	if msg.Type != "HEARTBEAT" && msg.Type != "PRESENCE_UPDATE" {
+		c.setIdle(false)
 	}
 
 	switch msg.Type {
@@ -130,7 +176,7 @@ func (c *Client) handleMessage(message []byte) {
 	case "TYPING_START":
 		c.handleTypingStart(msg.Data)
 	case "HEARTBEAT":
-		c.handleHeartbeat()
+		c.handleHeartbeat(msg.Data)
 	case "PRESENCE_UPDATE":
 		c.handlePresenceUpdate(msg.Data)
 	case "VOICE_JOIN":
@@ -141,12 +187,10 @@ func (c *Client) handleMessage(message []byte) {
 		c.handleVoiceStateUpdate(msg.Data)
 	case "VOICE_SIGNAL":
 		c.handleVoiceSignal(msg.Data)
-	default:
-		log.Warn().
-			Str("type", msg.Type).
-			Str("clientId", c.ID.String()).
-			Msg("Unknown message type")
+	case "MEMBER_LIST_SUBSCRIBE":
+		c.handleMemberListSubscribe(msg.Data)
 	}
+	return nil
 }
 
 func (c *Client) handleSubscribe(data json.RawMessage) {
@@ -162,15 +206,28 @@ func (c *Client) handleSubscribe(data json.RawMessage) {
 		return
 	}
 
-	if !c.canAccessStream(context.Background(), channelID) {
+	if err := c.Hub.Subscribe(context.Background(), c, channelID); err != nil {
 		log.Warn().
 			Str("channelId", req.ChannelID).
 			Str("userId", c.UserID.String()).
 			Msg("User attempted to subscribe to unauthorized channel")
+		c.SendEvent(&Event{
+			Type: EventTypeUnsubscribed,
+			Data: map[string]interface{}{
+				"channelId": req.ChannelID,
+				"reason":    UnsubscribeReasonDenied,
+			},
+		})
 		return
 	}
 
-	c.Hub.Subscribe(c, req.ChannelID)
+	// Late joiners get the playback state straight away rather than waiting
+	// for the host's next control
+	if c.Hub.watchService != nil {
+		if session, err := c.Hub.watchService.CurrentSession(context.Background(), channelID); err == nil {
+			c.SendEvent(&Event{Type: watchtogether.EventTypeWatchState, Data: session})
+		}
+	}
 }
 
 func (c *Client) handleUnsubscribe(data json.RawMessage) {
@@ -197,65 +254,40 @@ func (c *Client) handleTypingStart(data json.RawMessage) {
 		return
 	}
 
-	if !c.canAccessStream(context.Background(), channelID) {
+	if !c.Hub.canAccessStream(context.Background(), channelID, c.UserID) {
 		return
 	}
 
 	c.Hub.SetTyping(context.Background(), req.ChannelID, c.UserID)
 }
 
-func (c *Client) canAccessStream(ctx context.Context, channelID uuid.UUID) bool {
-	if c.Hub.channelService != nil && c.Hub.channelService.CanAccessChannel(ctx, channelID, c.UserID) {
-		return true
-	}
-	if c.Hub.dmService != nil && c.Hub.dmService.CanAccessConversation(ctx, channelID, c.UserID) {
-		return true
-	}
-	return false
-}
-
-func (c *Client) handleHeartbeat() {
-	event := &Event{
-		Type: EventTypeHeartbeatAck,
-		Data: map[string]interface{}{
-			"timestamp": time.Now().UnixMilli(),
-		},
-	}
-
-	data, _ := json.Marshal(event)
-	select {
-	case c.Send <- data:
-	default:
-	}
-}
-
+// handlePresenceUpdate sets the user's status and/or whether this connection
+// is idle, e.g. because the app lost focus
 func (c *Client) handlePresenceUpdate(data json.RawMessage) {
 	var req struct {
 		Status string `json:"status"`
+		Idle   *bool  `json:"idle"`
 	}
 	if err := json.Unmarshal(data, &req); err != nil {
 		return
 	}
 
-	normalizedStatus, ok := normalizePresenceStatus(req.Status)
-	if !ok {
-		return
+	if req.Idle != nil {
+		c.setIdle(*req.Idle)
+	}
+	if req.Status != "" {
+		c.Hub.setUserPresence(context.Background(), c.UserID, req.Status)
 	}
-
-	c.Hub.setUserPresence(context.Background(), c.UserID, normalizedStatus)
 }
 
 // SendEvent sends an event directly to this client
 func (c *Client) SendEvent(event *Event) {
-	data, err := json.Marshal(event)
+	data, err := newPayload(event)
 	if err != nil {
 		return
 	}
 
-	select {
-	case c.Send <- data:
-	default:
-	}
+	c.dispatch(data)
 }
 
 // handleVoiceJoin handles a user joining a voice channel
@@ -304,12 +336,15 @@ func (c *Client) handleVoiceJoin(data json.RawMessage) {
 			Str("channelId", req.ChannelID).
 			Str("userId", c.UserID.String()).
 			Msg("Failed to join voice channel")
-		c.SendEvent(&Event{
-			Type: "VOICE_ERROR",
-			Data: map[string]interface{}{
-				"error": err.Error(),
-			},
-		})
+		voiceErr := map[string]interface{}{
+			"error": err.Error(),
+		}
+		var full *voice.ChannelFullError
+		if errors.As(err, &full) {
+			voiceErr["code"] = "CHANNEL_FULL"
+			voiceErr["details"] = full
+		}
+		c.SendEvent(&Event{Type: "VOICE_ERROR", Data: voiceErr})
 		return
 	}
 
@@ -319,17 +354,26 @@ func (c *Client) handleVoiceJoin(data json.RawMessage) {
 	// Get current participants for the joining user
 	states, _ := c.Hub.voiceService.GetChannelVoiceStates(context.Background(), channelID)
 
-	// Send current state to the joining user
-	c.SendEvent(&Event{
-		Type: EventTypeVoiceJoin,
-		Data: map[string]interface{}{
-			"channelId":    req.ChannelID,
-			"userId":       c.UserID.String(),
-			"state":        state,
-			"user":         u,
-			"participants": states,
-		},
-	})
+	// Send current state to the joining user, with a room token when voice
+	// goes through the SFU
+	joined := map[string]interface{}{
+		"channelId":    req.ChannelID,
+		"userId":       c.UserID.String(),
+		"state":        state,
+		"user":         u,
+		"participants": states,
+	}
+	sfu, err := c.Hub.voiceService.SFUCredentials(context.Background(), channelID, c.UserID)
+	if err != nil {
+		log.Error().Err(err).
+			Str("channelId", req.ChannelID).
+			Str("userId", c.UserID.String()).
+			Msg("Failed to create voice room token")
+	}
+	if sfu != nil {
+		joined["sfu"] = sfu
+	}
+	c.SendEvent(&Event{Type: EventTypeVoiceJoin, Data: joined})
 
 	// Broadcast to others in the channel
 	c.Hub.Broadcast(req.ChannelID, &Event{
@@ -376,13 +420,11 @@ func (c *Client) handleVoiceLeave(data json.RawMessage) {
 
 }
 
-// handleVoiceStateUpdate handles mute/deafen state updates
+// handleVoiceStateUpdate handles mute/deafen, camera and screen share updates
 func (c *Client) handleVoiceStateUpdate(data json.RawMessage) {
 	var req struct {
-		ChannelID       string `json:"channelId"`
-		IsSelfMuted     *bool  `json:"isSelfMuted"`
-		IsSelfDeafened  *bool  `json:"isSelfDeafened"`
-		IsScreenSharing *bool  `json:"isScreenSharing"`
+		ChannelID string `json:"channelId"`
+		voice.StateUpdate
 	}
 	if err := json.Unmarshal(data, &req); err != nil {
 		return
@@ -397,8 +439,17 @@ func (c *Client) handleVoiceStateUpdate(data json.RawMessage) {
 		return
 	}
 
-	state, err := c.Hub.voiceService.UpdateVoiceState(context.Background(), channelID, c.UserID, req.IsSelfMuted, req.IsSelfDeafened, req.IsScreenSharing)
+	state, err := c.Hub.voiceService.UpdateVoiceState(context.Background(), channelID, c.UserID, &req.StateUpdate)
 	if err != nil {
+		if errors.Is(err, voice.ErrVideoLimitReached) || errors.Is(err, voice.ErrScreenLimitReached) {
+			c.SendEvent(&Event{
+				Type: "VOICE_ERROR",
+				Data: map[string]interface{}{
+					"code":  "STREAM_LIMIT_REACHED",
+					"error": err.Error(),
+				},
+			})
+		}
 		return
 	}
 
@@ -0,0 +1,88 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/database"
+)
+
+// Community events waiting to be delivered; more are dropped
+const communityQueueSize = 1024
+
+// queueCommunityEvent hands a community stream event to the delivery loop.
+// Finding who may see it takes a query, so it stays off the broadcast path.
+func (h *Hub) queueCommunityEvent(msg *BroadcastMessage) {
+	select {
+	case h.communityEvents <- msg:
+	default:
+		log.Warn().Str("stream", msg.ChannelID).Msg("Community event queue full, dropping event")
+	}
+}
+
+// deliverCommunityEvents sends community events, in order, to the local users
+// allowed to see them
+func (h *Hub) deliverCommunityEvents(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-h.communityEvents:
+			h.deliverCommunityEvent(ctx, msg)
+		}
+	}
+}
+
+// deliverCommunityEvent sends an event on community:<id> to the community's
+// local members, and one on community:<id>:<channel> to those who can see
+// the channel
+func (h *Hub) deliverCommunityEvent(ctx context.Context, msg *BroadcastMessage) {
+	communityPart, channelPart, _ := strings.Cut(strings.TrimPrefix(msg.ChannelID, database.StreamPrefixCommunity), ":")
+	communityID, err := uuid.Parse(communityPart)
+	if err != nil || h.channelService == nil {
+		return
+	}
+
+	h.mu.RLock()
+	candidates := make([]uuid.UUID, 0, len(h.userClients))
+	for userID := range h.userClients {
+		candidates = append(candidates, userID)
+	}
+	h.mu.RUnlock()
+	if len(candidates) == 0 {
+		return
+	}
+
+	var audience []uuid.UUID
+	if channelPart == "" {
+		audience, err = h.channelService.CommunityMembers(ctx, communityID, candidates)
+	} else {
+		channelID, parseErr := uuid.Parse(channelPart)
+		if parseErr != nil {
+			return
+		}
+		audience, err = h.channelService.ChannelViewers(ctx, channelID, candidates)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("stream", msg.ChannelID).Msg("Failed to find community event audience")
+		return
+	}
+
+	data, err := newPayload(msg.Event)
+	if err != nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, userID := range audience {
+		for _, client := range h.userClients[userID] {
+			if msg.ExcludeClientID != nil && client.ID == *msg.ExcludeClientID {
+				continue
+			}
+			client.dispatch(data)
+		}
+	}
+}
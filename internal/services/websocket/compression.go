@@ -0,0 +1,162 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+// Compression modes a client can ask for with ?compress= when connecting
+const (
+	CompressionNone = "none"
+	// CompressionDeflate is permessage-deflate; it only takes effect when the
+	// client also offers the extension in its handshake
+	CompressionDeflate = "deflate"
+	// CompressionZstdStream sends binary frames that together form a single
+	// zstd stream, flushed after every frame. Clients keep one decoder for the
+	// whole connection.
+	CompressionZstdStream = "zstd-stream"
+)
+
+const (
+	// Frames smaller than this go out uncompressed with permessage-deflate;
+	// deflate without context takeover rarely wins on small events
+	deflateThreshold = 512
+
+	// zstd keeps its window per connection, so keep it small
+	zstdWindowSize = 1 << 18
+)
+
+var (
+	payloadBytesTotal = metrics.NewCounter("zentra_gateway_payload_bytes_total",
+		"Gateway event bytes before compression, by encoding and compression mode.", "encoding", "compression")
+	wireBytesTotal = metrics.NewCounter("zentra_gateway_wire_bytes_total",
+		"Gateway event bytes written to sockets including framing, by encoding and compression mode.", "encoding", "compression")
+	savedBytesTotal = metrics.NewCounter("zentra_gateway_compression_saved_bytes_total",
+		"Bytes compression kept off the wire, by encoding and compression mode.", "encoding", "compression")
+)
+
+// ValidCompression reports whether mode is a supported compression mode
+func ValidCompression(mode string) bool {
+	switch mode {
+	case CompressionNone, CompressionDeflate, CompressionZstdStream:
+		return true
+	}
+	return false
+}
+
+// frameEncoder writes batches of events to one connection in the
+// connection's encoding and compression mode and records how many bytes that
+// took
+type frameEncoder struct {
+	encoding string
+	mode     string
+	wire     *countingConn
+	zstd     *zstd.Encoder
+	buf      bytes.Buffer
+}
+
+func newFrameEncoder(encoding, mode string, conn *websocket.Conn, wire *countingConn) (*frameEncoder, error) {
+	e := &frameEncoder{encoding: encoding, mode: mode, wire: wire}
+	if mode == CompressionZstdStream {
+		enc, err := zstd.NewWriter(&e.buf,
+			zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(zstdWindowSize),
+			zstd.WithLowerEncoderMem(true),
+		)
+		if err != nil {
+			return nil, err
+		}
+		e.zstd = enc
+	}
+	conn.EnableWriteCompression(false)
+	return e, nil
+}
+
+// write sends messages as one frame. JSON events are newline separated;
+// MessagePack values are self-delimiting and simply follow each other.
+func (e *frameEncoder) write(conn *websocket.Conn, messages [][]byte) error {
+	messageType, separator := websocket.TextMessage, []byte{'\n'}
+	if e.encoding == EncodingMsgpack {
+		messageType, separator = websocket.BinaryMessage, nil
+	}
+	payload := bytes.Join(messages, separator)
+	before := e.wire.written()
+
+	switch e.mode {
+	case CompressionZstdStream:
+		e.buf.Reset()
+		if _, err := e.zstd.Write(payload); err != nil {
+			return err
+		}
+		if err := e.zstd.Flush(); err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, e.buf.Bytes()); err != nil {
+			return err
+		}
+	default:
+		conn.EnableWriteCompression(e.mode == CompressionDeflate && len(payload) >= deflateThreshold)
+		if err := conn.WriteMessage(messageType, payload); err != nil {
+			return err
+		}
+	}
+
+	wire := e.wire.written() - before
+	payloadBytesTotal.Add(float64(len(payload)), e.encoding, e.mode)
+	wireBytesTotal.Add(float64(wire), e.encoding, e.mode)
+	savedBytesTotal.Add(float64(int64(len(payload))-wire), e.encoding, e.mode)
+	return nil
+}
+
+func (e *frameEncoder) close() {
+	if e.zstd != nil {
+		e.zstd.Close()
+	}
+}
+
+// countingConn counts the bytes written to a hijacked connection
+type countingConn struct {
+	net.Conn
+	n atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) written() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.n.Load()
+}
+
+// countingResponseWriter hands the upgrader a counting connection when it
+// hijacks the request
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, brw, nil
+}
@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/msgpack"
+)
+
+// Encodings a client can ask for with ?encoding= when connecting. Events have
+// the same shape in both; MessagePack sessions get binary frames and may send
+// binary frames back.
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+var ErrEncodingMismatch = errors.New("session was opened with a different encoding")
+
+// ValidEncoding reports whether encoding is a supported event encoding
+func ValidEncoding(encoding string) bool {
+	return encoding == EncodingJSON || encoding == EncodingMsgpack
+}
+
+// payload is an event encoded once and shared by every recipient of a
+// broadcast. The MessagePack form is only built if a recipient wants it, from
+// the event itself rather than from its JSON; like the queued community and
+// presence events, an event isn't changed once it's sent.
+type payload struct {
+	event   *Event
+	json    []byte
+	once    sync.Once
+	msgpack []byte
+}
+
+func newPayload(event *Event) (*payload, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	eventsTotal.Inc(event.Type)
+	return &payload{event: event, json: data}, nil
+}
+
+// encode returns the event in encoding, or nil if it can't be encoded
+func (p *payload) encode(encoding string) []byte {
+	if encoding != EncodingMsgpack {
+		return p.json
+	}
+	p.once.Do(func() {
+		data, err := msgpack.Marshal(p.event)
+		if err != nil {
+			// Anything the encoder can't follow still has its JSON form
+			data, err = msgpack.FromJSON(p.json)
+		}
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to encode event as MessagePack")
+			return
+		}
+		p.msgpack = data
+	})
+	return p.msgpack
+}
+
+// withSeq adds the seq field to an encoded event object
+func withSeq(encoding string, data []byte, seq int64) []byte {
+	if encoding == EncodingMsgpack {
+		out, err := msgpack.WithInt(data, "seq", seq)
+		if err != nil {
+			return data
+		}
+		return out
+	}
+
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	prefix := `{"seq":` + strconv.FormatInt(seq, 10)
+	out := make([]byte, 0, len(prefix)+len(data)+1)
+	out = append(out, prefix...)
+	if data[1] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...)
+}
+
+// decodeClientMessage turns a frame from the client into JSON. Text frames
+// are always JSON; binary frames are only accepted from MessagePack sessions.
+func decodeClientMessage(encoding string, messageType int, data []byte) ([]byte, error) {
+	if messageType != websocket.BinaryMessage {
+		return data, nil
+	}
+	if encoding != EncodingMsgpack {
+		return nil, errors.New("binary frames need the msgpack encoding")
+	}
+	return msgpack.ToJSON(data)
+}
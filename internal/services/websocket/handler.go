@@ -2,12 +2,15 @@ package websocket
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/models"
 	"github.com/zentra/server/internal/utils"
 	"github.com/zentra/server/pkg/auth"
 )
@@ -15,6 +18,8 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// Negotiated when the client offers it; clients still opt in with ?compress=deflate
+	EnableCompression: true,
 	CheckOrigin: func(r *http.Request) bool {
 		// TODO: Implement proper origin checking in production
 		return true
@@ -33,16 +38,24 @@ func NewHandler(hub *Hub, jwtSecret string) *Handler {
 	}
 }
 
-func (h *Handler) Routes() chi.Router {
+// Routes serves the gateway. /stats is for operators, authenticated with
+// adminToken.
+func (h *Handler) Routes(adminToken string) chi.Router {
 	r := chi.NewRouter()
 
 	// WebSocket endpoint - prefers /ws but handles both /ws and /ws/
 	r.Get("/", h.HandleWebSocket)
 
+	// Server-Sent Events fallback for clients that can't hold a WebSocket
+	r.Get("/events", h.HandleEventStream)
+
+	r.With(middleware.AdminTokenMiddleware(adminToken)).Get("/stats", h.GetStats)
+
 	// REST endpoints for presence/typing (alternative to WebSocket)
 	// This should really be done via Websocket, but I am lazy.
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.AuthMiddleware(h.jwtSecret))
+		r.Post("/events/{sessionId}", h.PostEventStreamOp)
 		r.Get("/presence/{userId}", h.GetUserPresence)
 		r.Get("/channels/{channelId}/typing", h.GetTypingUsers)
 	})
@@ -50,8 +63,10 @@ func (h *Handler) Routes() chi.Router {
 	return r
 }
 
-func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// Get user ID from query parameter (token validation)
+// authenticate reads the access token from the token query parameter (browsers
+// can't set headers on WebSocket or EventSource requests) or the
+// Authorization header
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
 	token := r.URL.Query().Get("token")
 	if token == "" {
 		// Also check Authorization header
@@ -63,31 +78,114 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	if token == "" {
 		utils.RespondErrorWithCode(w, http.StatusUnauthorized, "AUTH_TOKEN_REQUIRED", "Unauthorized")
-		return
+		return uuid.Nil, false
 	}
 
 	// Validate JWT token
 	claims, err := auth.ValidateAccessToken(token, h.jwtSecret)
 	if err != nil {
 		utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid token")
-		return
+		return uuid.Nil, false
 	}
 
 	userID, err := uuid.Parse(claims.UserID)
 	if err != nil {
 		utils.RespondErrorWithCode(w, http.StatusUnauthorized, "INVALID_TOKEN_USER_ID", "Invalid user ID in token")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// parseResume parses the session and sequence number a reconnecting client
+// resumes from. A zero session ID means a fresh session.
+func parseResume(w http.ResponseWriter, resume, seq string) (uuid.UUID, int64, bool) {
+	if resume == "" {
+		return uuid.Nil, 0, true
+	}
+	resumeID, err := uuid.Parse(resume)
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid session ID")
+		return uuid.Nil, 0, false
+	}
+	lastSeq, err := strconv.ParseInt(seq, 10, 64)
+	if err != nil || lastSeq < 0 {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid sequence number")
+		return uuid.Nil, 0, false
+	}
+	return resumeID, lastSeq, true
+}
+
+func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = EncodingJSON
+	}
+	if !ValidEncoding(encoding) {
+		utils.RespondError(w, http.StatusBadRequest, "Unsupported encoding")
+		return
+	}
+
+	compression := r.URL.Query().Get("compress")
+	if compression == "" {
+		compression = CompressionNone
+	}
+	if !ValidCompression(compression) {
+		utils.RespondError(w, http.StatusBadRequest, "Unsupported compression")
+		return
+	}
+
+	// A reconnecting client passes the session to resume and the last seq it
+	// received; parse both before upgrading so bad values get a plain 400
+	resumeID, lastSeq, ok := parseResume(w, r.URL.Query().Get("resume"), r.URL.Query().Get("seq"))
+	if !ok {
 		return
 	}
 
-	// Upgrade connection
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Upgrade connection, counting what goes out for the compression metrics
+	counted := &countingResponseWriter{ResponseWriter: w}
+	conn, err := upgrader.Upgrade(counted, r, nil)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to upgrade WebSocket connection")
 		return
 	}
+	encoder, err := newFrameEncoder(encoding, compression, conn, counted.conn)
+	if err != nil {
+		log.Error().Err(err).Str("compression", compression).Msg("Failed to set up WebSocket compression")
+		conn.Close()
+		return
+	}
+
+	if resumeID != uuid.Nil {
+		client, err := h.hub.Resume(resumeID, userID, lastSeq, conn, encoder)
+		if err == nil {
+			go client.WritePump()
+			go client.ReadPump()
+			return
+		}
+		// The client starts over with a fresh session and re-fetches state
+		// instead of relying on replayed events
+		invalid, _ := newPayload(&Event{
+			Type: EventTypeInvalidSession,
+			Data: map[string]interface{}{
+				"sessionId": resumeID.String(),
+				"reason":    err.Error(),
+			},
+		})
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := encoder.write(conn, [][]byte{invalid.encode(encoding)}); err != nil {
+			conn.Close()
+			encoder.close()
+			return
+		}
+	}
 
 	// Create client
-	client := NewClient(userID, conn, h.hub)
+	client := NewClient(userID, conn, encoder, h.hub)
 
 	// Register client with hub
 	h.hub.register <- client
@@ -96,9 +194,10 @@ func (h *Handler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	client.SendEvent(&Event{
 		Type: EventTypeReady,
 		Data: map[string]interface{}{
-			"clientId":  client.ID.String(),
-			"userId":    userID.String(),
-			"sessionId": client.ID.String(),
+			"clientId":          client.ID.String(),
+			"userId":            userID.String(),
+			"sessionId":         client.ID.String(),
+			"heartbeatInterval": h.hub.heartbeatInterval.Milliseconds(),
 		},
 	})
 
@@ -115,11 +214,14 @@ func (h *Handler) GetUserPresence(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	status := h.hub.GetUserPresence(r.Context(), userID)
+	status := models.UserStatus(h.hub.GetUserPresence(r.Context(), userID))
+	if requesterID, ok := middleware.GetUserID(r.Context()); !ok || requesterID != userID {
+		status = status.Public()
+	}
 	utils.RespondSuccess(w, map[string]interface{}{
 		"userId": userID.String(),
 		"status": status,
-		"online": h.hub.IsUserOnline(userID),
+		"online": status != models.UserStatusOffline,
 	})
 }
 
@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+const (
+	// How often the server pings WebSocket connections and clients are asked
+	// to send HEARTBEAT
+	defaultHeartbeatInterval = 30 * time.Second
+
+	// A connection that hasn't answered a ping or sent HEARTBEAT for this long
+	// is closed; its session can still be resumed
+	defaultHeartbeatTimeout = 90 * time.Second
+)
+
+var (
+	latencySecondsSum = metrics.NewCounter("zentra_gateway_latency_seconds_sum",
+		"Sum of measured gateway round-trip times.")
+	latencySecondsCount = metrics.NewCounter("zentra_gateway_latency_seconds_count",
+		"Gateway round-trip times measured.")
+	reapedTotal = metrics.NewCounter("zentra_gateway_reaped_connections_total",
+		"Gateway connections closed for missing heartbeats, by transport.", "transport")
+)
+
+// SetHeartbeat sets how often connections are pinged and how long one may go
+// without answering before it's closed. Must be called before clients connect.
+func (h *Hub) SetHeartbeat(interval, timeout time.Duration) {
+	if interval <= 0 || timeout <= interval {
+		log.Warn().
+			Dur("interval", interval).
+			Dur("timeout", timeout).
+			Msg("Gateway heartbeat timeout must exceed a positive interval, keeping defaults")
+		return
+	}
+	h.heartbeatInterval = interval
+	h.heartbeatTimeout = timeout
+}
+
+// pingData is the payload of a WebSocket ping: when it was sent, so the pong
+// gives the round trip
+func pingData(now time.Time) []byte {
+	return []byte(strconv.FormatInt(now.UnixNano(), 10))
+}
+
+// handlePong records a WebSocket pong for a ping sent with pingData
+func (c *Client) handlePong(appData string) {
+	sent, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		c.recordHeartbeat(0)
+		return
+	}
+	c.recordHeartbeat(time.Since(time.Unix(0, sent)))
+}
+
+// recordHeartbeat notes the connection is alive and, when measured, its
+// round-trip time
+func (c *Client) recordHeartbeat(rtt time.Duration) {
+	c.mu.Lock()
+	c.lastPing = time.Now()
+	if rtt > 0 {
+		c.latency = rtt
+	}
+	c.mu.Unlock()
+
+	if rtt > 0 {
+		latencySecondsSum.Add(rtt.Seconds())
+		latencySecondsCount.Inc()
+	}
+}
+
+// handleHeartbeat acks a client's HEARTBEAT, echoing its timestamp so it can
+// time the round trip and sending the latency the server last measured.
+// WebSocket connections are timed with pings; clients on event streams,
+// which have none, report what they measured.
+func (c *Client) handleHeartbeat(data json.RawMessage) {
+	var req struct {
+		Timestamp *int64 `json:"timestamp"`
+		Latency   *int64 `json:"latency"`
+	}
+	if len(data) > 0 {
+		json.Unmarshal(data, &req)
+	}
+
+	c.sendMu.Lock()
+	sse := c.stream != nil
+	c.sendMu.Unlock()
+
+	var reported time.Duration
+	if sse && req.Latency != nil && *req.Latency > 0 {
+		reported = time.Duration(*req.Latency) * time.Millisecond
+	}
+	c.recordHeartbeat(reported)
+
+	c.mu.RLock()
+	latency := c.latency
+	c.mu.RUnlock()
+
+	ack := map[string]interface{}{
+		"timestamp": time.Now().UnixMilli(),
+		"latency":   latency.Milliseconds(),
+	}
+	if req.Timestamp != nil {
+		ack["clientTimestamp"] = *req.Timestamp
+	}
+
+	payload, err := newPayload(&Event{Type: EventTypeHeartbeatAck, Data: ack})
+	if err != nil {
+		return
+	}
+	c.sendUnsequenced(payload)
+}
+
+// reapStale closes attached connections that stopped answering, rather than
+// waiting for TCP to notice. The sessions detach and stay resumable.
+func (h *Hub) reapStale() {
+	now := time.Now()
+
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.mu.RLock()
+		silent := now.Sub(client.lastPing)
+		client.mu.RUnlock()
+		if silent < h.heartbeatTimeout {
+			continue
+		}
+
+		client.sendMu.Lock()
+		if client.attached {
+			transport := "websocket"
+			if client.stream != nil {
+				transport = "sse"
+			}
+			reapedTotal.Inc(transport)
+			log.Info().
+				Str("clientId", client.ID.String()).
+				Str("userId", client.UserID.String()).
+				Dur("silent", silent).
+				Msg("Closing gateway connection that stopped sending heartbeats")
+			client.transport().Close()
+		}
+		client.sendMu.Unlock()
+	}
+}
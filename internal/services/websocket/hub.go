@@ -3,7 +3,7 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"strings"
 	"sync"
 	"time"
@@ -14,9 +14,13 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/zentra/server/internal/models"
 	"github.com/zentra/server/internal/services/channel"
+	"github.com/zentra/server/internal/services/community"
 	"github.com/zentra/server/internal/services/dm"
+	"github.com/zentra/server/internal/services/presence"
 	"github.com/zentra/server/internal/services/user"
 	"github.com/zentra/server/internal/services/voice"
+	"github.com/zentra/server/internal/services/watchtogether"
+	"github.com/zentra/server/pkg/database"
 )
 
 // Event types
@@ -29,9 +33,17 @@ const (
 	EventTypeChannelCreate    = "CHANNEL_CREATE"
 	EventTypeChannelUpdate    = "CHANNEL_UPDATE"
 	EventTypeChannelDelete    = "CHANNEL_DELETE"
+	EventTypeCategoryCreate   = "CATEGORY_CREATE"
+	EventTypeCategoryUpdate   = "CATEGORY_UPDATE"
+	EventTypeCategoryDelete   = "CATEGORY_DELETE"
 	EventTypeMemberJoin       = "MEMBER_JOIN"
 	EventTypeMemberLeave      = "MEMBER_LEAVE"
 	EventTypeMemberUpdate     = "MEMBER_UPDATE"
+	EventTypeMemberChunk      = "MEMBER_CHUNK"
+	EventTypeMemberListUpdate = "MEMBER_LIST_UPDATE"
+	EventTypeRoleUpdate       = "ROLE_UPDATE"
+	EventTypeRoleDelete       = "ROLE_DELETE"
+	EventTypeVisibilityUpdate = "CHANNEL_VISIBILITY_UPDATE"
 	EventTypeReactionAdd      = "REACTION_ADD"
 	EventTypeReactionRemove   = "REACTION_REMOVE"
 	EventTypeVoiceState       = "VOICE_STATE_UPDATE"
@@ -46,38 +58,117 @@ const (
 	EventTypeDMReactionAdd    = "DM_REACTION_ADD"
 	EventTypeDMReactionRemove = "DM_REACTION_REMOVE"
 	EventTypeReady            = "READY"
+	EventTypeResumed          = "RESUMED"
+	EventTypeInvalidSession   = "INVALID_SESSION"
 	EventTypeHeartbeat        = "HEARTBEAT"
 	EventTypeHeartbeatAck     = "HEARTBEAT_ACK"
+	EventTypeRateLimited      = "RATE_LIMITED"
+	EventTypeResyncRequired   = "RESYNC_REQUIRED"
 	EventTypeNotification     = "NOTIFICATION"
 	EventTypeNotificationRead = "NOTIFICATION_READ"
+	EventTypeSettingsUpdate   = "USER_SETTINGS_UPDATE"
+	EventTypeSidebarUpdate    = "SIDEBAR_UPDATE"
+	EventTypeUnsubscribed     = "UNSUBSCRIBED"
+	EventTypeAttachmentUpdate = "ATTACHMENT_UPDATE"
 )
 
-// Client represents a WebSocket client connection
+// Reasons sent with UNSUBSCRIBED
+const (
+	UnsubscribeReasonDenied  = "ACCESS_DENIED"
+	UnsubscribeReasonRevoked = "ACCESS_REVOKED"
+)
+
+var ErrSubscriptionDenied = errors.New("no access to channel")
+
+// Client represents a gateway session. Its ID is the session ID clients
+// resume with; Conn (or stream, for SSE), Send and encoder belong to the
+// current connection and are replaced on resume.
 type Client struct {
 	ID         uuid.UUID
 	UserID     uuid.UUID
 	Conn       *websocket.Conn
+	stream     *eventStream
 	Send       chan []byte
 	Hub        *Hub
 	Subscribed map[string]bool // Channel/community subscriptions
-	mu         sync.RWMutex
-	lastPing   time.Time
+	// Community ID -> member list being watched, guarded by mu
+	memberLists map[uuid.UUID]string
+	mu          sync.RWMutex
+	encoding    string
+	limiter     *opLimiter
+	// Guarded by mu: when the client last did something and whether it went
+	// idle since
+	lastActive time.Time
+	idle       bool
+	// Guarded by mu: when the connection last answered a ping or sent
+	// HEARTBEAT, and the last round trip measured
+	lastPing time.Time
+	latency  time.Duration
+
+	// Guarded by sendMu: the event sequence, recent events for resuming and
+	// whether a connection is attached
+	sendMu      sync.Mutex
+	encoder     *frameEncoder
+	seq         int64
+	replay      []sequencedEvent
+	replayBytes int
+	attached    bool
+	detachedAt  time.Time
+	expired     bool
+	// Also guarded by sendMu: backpressure state of the attached connection
+	overflow       [][]byte
+	overrunSeq     int64
+	overrunClosing bool
 }
 
+const (
+	// Consumer groups of gateway instances on the broadcast stream
+	broadcastGroupPrefix = "gateway:"
+
+	// A group with no reads for this long belongs to an instance that's gone
+	broadcastGroupMaxIdle = 24 * time.Hour
+
+	broadcastPruneInterval = time.Hour
+)
+
 // Hub manages all WebSocket connections
 type Hub struct {
-	clients        map[uuid.UUID]*Client         // Client ID -> Client
-	userClients    map[uuid.UUID][]*Client       // User ID -> Clients (user can have multiple connections)
-	channels       map[string]map[uuid.UUID]bool // Channel ID -> Client IDs
-	register       chan *Client
-	unregister     chan *Client
-	broadcast      chan *BroadcastMessage
-	redis          *redis.Client
-	channelService *channel.Service
-	userService    *user.Service
-	dmService      *dm.Service
-	voiceService   *voice.Service
-	mu             sync.RWMutex
+	clients         map[uuid.UUID]*Client         // Client ID -> Client
+	userClients     map[uuid.UUID][]*Client       // User ID -> Clients (user can have multiple connections)
+	channels        map[string]map[uuid.UUID]bool // Channel ID -> Client IDs
+	register        chan *Client
+	unregister      chan clientConn
+	broadcast       chan *BroadcastMessage
+	redis           *redis.Client
+	channelService  *channel.Service
+	userService     *user.Service
+	dmService       *dm.Service
+	voiceService    *voice.Service
+	presenceService *presence.Service
+	watchService    *watchtogether.Service
+	mu              sync.RWMutex
+
+	communityService *community.Service
+	memberLists      map[string]*memberList
+	memberListsMu    sync.Mutex
+
+	presenceUpdates chan presenceUpdate
+	communityEvents chan *BroadcastMessage
+
+	// Names this instance's consumer group on the broadcast stream; keep it
+	// stable across restarts so missed events are replayed
+	instanceID string
+
+	backpressure  BackpressurePolicy
+	maxSendBuffer int
+	// Bytes of recent events each session keeps for resuming
+	replayBufferBytes int
+
+	startedAt time.Time
+	stats     hubStats
+
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
 }
 
 // BroadcastMessage represents a message to be broadcast
@@ -99,34 +190,98 @@ type ClientMessage struct {
 	Data json.RawMessage `json:"data"`
 }
 
-func NewHub(redisClient *redis.Client, channelService *channel.Service, userService *user.Service, dmService *dm.Service, voiceService *voice.Service) *Hub {
+func NewHub(redisClient *redis.Client, channelService *channel.Service, userService *user.Service, dmService *dm.Service, voiceService *voice.Service, presenceService *presence.Service) *Hub {
 	return &Hub{
-		clients:        make(map[uuid.UUID]*Client),
-		userClients:    make(map[uuid.UUID][]*Client),
-		channels:       make(map[string]map[uuid.UUID]bool),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
-		broadcast:      make(chan *BroadcastMessage, 256),
-		redis:          redisClient,
-		channelService: channelService,
-		userService:    userService,
-		dmService:      dmService,
-		voiceService:   voiceService,
+		clients:           make(map[uuid.UUID]*Client),
+		userClients:       make(map[uuid.UUID][]*Client),
+		channels:          make(map[string]map[uuid.UUID]bool),
+		register:          make(chan *Client),
+		unregister:        make(chan clientConn),
+		broadcast:         make(chan *BroadcastMessage, 256),
+		redis:             redisClient,
+		channelService:    channelService,
+		userService:       userService,
+		dmService:         dmService,
+		voiceService:      voiceService,
+		presenceService:   presenceService,
+		memberLists:       make(map[string]*memberList),
+		presenceUpdates:   make(chan presenceUpdate, presenceQueueSize),
+		communityEvents:   make(chan *BroadcastMessage, communityQueueSize),
+		backpressure:      defaultBackpressure,
+		maxSendBuffer:     defaultMaxSendBuffer,
+		replayBufferBytes: defaultReplayBufferBytes,
+		startedAt:         time.Now(),
+		heartbeatInterval: defaultHeartbeatInterval,
+		heartbeatTimeout:  defaultHeartbeatTimeout,
 	}
 }
 
+// SetInstanceID names this instance on the broadcast stream. Must be called
+// before Run. Events published while the instance was away are only replayed
+// if it comes back under the same ID.
+func (h *Hub) SetInstanceID(instanceID string) {
+	h.instanceID = instanceID
+}
+
+// SetWatchService lets subscribers to a watch together channel catch up on
+// its playback state
+func (h *Hub) SetWatchService(watchService *watchtogether.Service) {
+	h.watchService = watchService
+}
+
 func (h *Hub) Run(ctx context.Context) {
-	// Start Redis subscription for cross-server events
-	go h.subscribeToRedis(ctx)
+	// Read events from other instances and the API
+	go h.consumeBroadcasts(ctx)
+	go h.deliverPresence(ctx)
+	go h.deliverCommunityEvents(ctx)
+
+	// Events the API publishes while Redis is down still reach local clients
+	database.SetLocalBroadcast(h.instanceID, h.handleBroadcast)
+
+	// Connections made or dropped while Redis was down weren't recorded
+	database.OnRedisRecovered(func() {
+		h.presenceService.Resync(ctx, h.localConnections())
+	})
+
+	// Presence leases expire unless this instance keeps renewing them
+	leaseTicker := time.NewTicker(presence.RefreshInterval)
+	defer leaseTicker.Stop()
+
+	sweepTicker := time.NewTicker(sessionSweepInterval)
+	defer sweepTicker.Stop()
+
+	idleTicker := time.NewTicker(idleCheckInterval)
+	defer idleTicker.Stop()
+
+	pruneTicker := time.NewTicker(broadcastPruneInterval)
+	defer pruneTicker.Stop()
+
+	statsTicker := time.NewTicker(statsInterval)
+	defer statsTicker.Stop()
+
+	reapTicker := time.NewTicker(h.heartbeatInterval)
+	defer reapTicker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-leaseTicker.C:
+			go h.presenceService.Refresh(ctx, h.localConnections())
+		case <-sweepTicker.C:
+			h.sweepSessions()
+		case <-idleTicker.C:
+			go h.detectIdle(ctx)
+		case <-pruneTicker.C:
+			go h.pruneBroadcastGroups(ctx)
+		case <-statsTicker.C:
+			go h.sampleStats(ctx)
+		case <-reapTicker.C:
+			go h.reapStale()
 		case client := <-h.register:
 			h.registerClient(client)
-		case client := <-h.unregister:
-			h.unregisterClient(client)
+		case end := <-h.unregister:
+			h.unregisterClient(end)
 		case msg := <-h.broadcast:
 			h.broadcastToChannel(msg)
 		}
@@ -144,83 +299,68 @@ func (h *Hub) registerClient(client *Client) {
 		Str("userId", client.UserID.String()).
 		Msg("WebSocket client connected")
 
-	// Update presence
-	h.setUserPresence(context.Background(), client.UserID, "online")
+	h.presenceService.Connect(context.Background(), client.UserID, client.ID)
 }
 
-func (h *Hub) unregisterClient(client *Client) {
-	// Collect voice leave broadcasts to send after releasing the lock
-	var voiceLeaveBroadcasts []*BroadcastMessage
-	shouldSetOffline := false
-
-	h.mu.Lock()
-
-	if _, ok := h.clients[client.ID]; ok {
-		delete(h.clients, client.ID)
-		close(client.Send)
-
-		// Remove from user clients
-		clients := h.userClients[client.UserID]
-		for i, c := range clients {
-			if c.ID == client.ID {
-				h.userClients[client.UserID] = append(clients[:i], clients[i+1:]...)
-				break
-			}
-		}
-
-		// If no more connections for this user, set offline and disconnect voice
-		if len(h.userClients[client.UserID]) == 0 {
-			delete(h.userClients, client.UserID)
-			shouldSetOffline = true
-		}
+// localConnections snapshots user -> client IDs held by this instance.
+// Detached sessions waiting to be resumed don't count.
+func (h *Hub) localConnections() map[uuid.UUID][]uuid.UUID {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-		// Remove from channel subscriptions
-		for channelID := range client.Subscribed {
-			if clients, ok := h.channels[channelID]; ok {
-				delete(clients, client.ID)
-				if len(clients) == 0 {
-					delete(h.channels, channelID)
-				}
+	conns := make(map[uuid.UUID][]uuid.UUID, len(h.userClients))
+	for userID, clients := range h.userClients {
+		for _, c := range clients {
+			if c.isAttached() {
+				conns[userID] = append(conns[userID], c.ID)
 			}
 		}
+	}
+	return conns
+}
 
-		log.Info().
-			Str("clientId", client.ID.String()).
-			Str("userId", client.UserID.String()).
-			Msg("WebSocket client disconnected")
+// unregisterClient handles a connection ending. The session stays subscribed
+// and keeps buffering events until it is resumed or the sweep removes it.
+func (h *Hub) unregisterClient(end clientConn) {
+	client := end.client
+	if !client.detach(end.conn) {
+		return
 	}
 
-	h.mu.Unlock()
+	log.Info().
+		Str("clientId", client.ID.String()).
+		Str("userId", client.UserID.String()).
+		Msg("WebSocket client disconnected")
 
-	if shouldSetOffline {
-		h.setUserPresence(context.Background(), client.UserID, "offline")
-
-		// Disconnect from voice channels
-		if h.voiceService != nil {
-			channelIDs, _ := h.voiceService.DisconnectUser(context.Background(), client.UserID)
-			for _, channelID := range channelIDs {
-				voiceLeaveBroadcasts = append(voiceLeaveBroadcasts, &BroadcastMessage{
-					ChannelID: channelID.String(),
-					Event: &Event{
-						Type: EventTypeVoiceLeave,
-						Data: map[string]interface{}{
-							"channelId": channelID.String(),
-							"userId":    client.UserID.String(),
-						},
+	// If that was the user's last connection on any instance, disconnect voice
+	if h.presenceService.Disconnect(context.Background(), client.UserID, client.ID) && h.voiceService != nil {
+		channelIDs, _ := h.voiceService.DisconnectUser(context.Background(), client.UserID)
+		for _, channelID := range channelIDs {
+			h.broadcastToChannel(&BroadcastMessage{
+				ChannelID: channelID.String(),
+				Event: &Event{
+					Type: EventTypeVoiceLeave,
+					Data: map[string]interface{}{
+						"channelId": channelID.String(),
+						"userId":    client.UserID.String(),
 					},
-				})
-			}
+				},
+			})
 		}
 	}
-
-	// Send voice leave broadcasts now that the lock is released
-	for _, msg := range voiceLeaveBroadcasts {
-		h.broadcastToChannel(msg)
-	}
 }
 
 func (h *Hub) broadcastToChannel(msg *BroadcastMessage) {
-	data, err := json.Marshal(msg.Event)
+	if strings.HasPrefix(msg.ChannelID, database.StreamPrefixPresence) {
+		h.queuePresence(msg)
+		return
+	}
+	if strings.HasPrefix(msg.ChannelID, database.StreamPrefixCommunity) {
+		h.queueCommunityEvent(msg)
+		return
+	}
+
+	data, err := newPayload(msg.Event)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to marshal broadcast event")
 		return
@@ -235,11 +375,19 @@ func (h *Hub) broadcastToChannel(msg *BroadcastMessage) {
 			if msg.ExcludeClientID != nil && clientID == *msg.ExcludeClientID {
 				continue
 			}
-			select {
-			case client.Send <- data:
-			default:
-				log.Warn().Str("clientId", clientID.String()).Msg("Client send buffer full")
-			}
+			client.dispatch(data)
+		}
+		return
+	}
+
+	// User streams go to every local connection of that user
+	if strings.HasPrefix(msg.ChannelID, database.StreamPrefixUser) {
+		userID, err := uuid.Parse(strings.TrimPrefix(msg.ChannelID, database.StreamPrefixUser))
+		if err != nil {
+			return
+		}
+		for _, client := range h.userClients[userID] {
+			client.dispatch(data)
 		}
 		return
 	}
@@ -254,20 +402,19 @@ func (h *Hub) broadcastToChannel(msg *BroadcastMessage) {
 			continue
 		}
 		if client, ok := h.clients[clientID]; ok {
-			select {
-			case client.Send <- data:
-			default:
-				// Client send buffer full, skip
-				log.Warn().
-					Str("clientId", clientID.String()).
-					Msg("Client send buffer full")
-			}
+			client.dispatch(data)
 		}
 	}
 }
 
-// Subscribe adds a client to a channel's broadcast list
-func (h *Hub) Subscribe(client *Client, channelID string) {
+// Subscribe adds a client to the broadcast list of a channel or DM
+// conversation its user can access
+func (h *Hub) Subscribe(ctx context.Context, client *Client, streamID uuid.UUID) error {
+	if !h.canAccessStream(ctx, streamID, client.UserID) {
+		return ErrSubscriptionDenied
+	}
+	channelID := streamID.String()
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
@@ -284,6 +431,30 @@ func (h *Hub) Subscribe(client *Client, channelID string) {
 		Str("clientId", client.ID.String()).
 		Str("channelId", channelID).
 		Msg("Client subscribed to channel")
+	return nil
+}
+
+func (h *Hub) canAccessStream(ctx context.Context, streamID, userID uuid.UUID) bool {
+	if h.channelService != nil && h.channelService.CanAccessChannel(ctx, streamID, userID) {
+		return true
+	}
+	if h.dmService != nil && h.dmService.CanAccessConversation(ctx, streamID, userID) {
+		return true
+	}
+	return false
+}
+
+// revokeSubscription unsubscribes a client that lost access to a channel and
+// tells it so
+func (h *Hub) revokeSubscription(client *Client, channelID string) {
+	h.Unsubscribe(client, channelID)
+	h.SendToClient(client.ID, &Event{
+		Type: EventTypeUnsubscribed,
+		Data: map[string]interface{}{
+			"channelId": channelID,
+			"reason":    UnsubscribeReasonRevoked,
+		},
+	})
 }
 
 // Unsubscribe removes a client from a channel's broadcast list
@@ -321,16 +492,13 @@ func (h *Hub) SendToUser(userID uuid.UUID, event *Event) {
 	clients := h.userClients[userID]
 	h.mu.RUnlock()
 
-	data, err := json.Marshal(event)
+	data, err := newPayload(event)
 	if err != nil {
 		return
 	}
 
 	for _, client := range clients {
-		select {
-		case client.Send <- data:
-		default:
-		}
+		client.dispatch(data)
 	}
 }
 
@@ -349,47 +517,55 @@ func (h *Hub) SendToClient(clientID uuid.UUID, event *Event) {
 		return
 	}
 
-	data, err := json.Marshal(event)
+	data, err := newPayload(event)
 	if err != nil {
 		return
 	}
 
-	select {
-	case client.Send <- data:
-	default:
-	}
+	client.dispatch(data)
 }
 
-// GetOnlineUsers returns list of online users from a list of user IDs
+// GetOnlineUsers returns list of online users (on any instance) from a list of user IDs.
+// While Redis is down only this instance's connections are known.
 func (h *Hub) GetOnlineUsers(userIDs []uuid.UUID) []uuid.UUID {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-
-	online := make([]uuid.UUID, 0)
-	for _, userID := range userIDs {
-		if _, ok := h.userClients[userID]; ok {
-			online = append(online, userID)
+	if database.RedisDegraded() {
+		local := h.localConnections()
+		online := make([]uuid.UUID, 0)
+		for _, id := range userIDs {
+			if _, ok := local[id]; ok {
+				online = append(online, id)
+			}
 		}
+		return online
 	}
-	return online
+	return h.presenceService.OnlineUsers(context.Background(), userIDs)
 }
 
-// IsUserOnline checks if a user has any active connections
+// IsUserOnline checks if a user has an active connection on any instance
 func (h *Hub) IsUserOnline(userID uuid.UUID) bool {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	_, ok := h.userClients[userID]
-	return ok
+	if database.RedisDegraded() {
+		_, ok := h.localConnections()[userID]
+		return ok
+	}
+	return h.presenceService.IsOnline(context.Background(), userID)
 }
 
-// GetUserConnectionCount returns the number of active connections for a user
+// GetUserConnectionCount returns the number of active connections for a user on this instance
 func (h *Hub) GetUserConnectionCount(userID uuid.UUID) int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
-	return len(h.userClients[userID])
+
+	count := 0
+	for _, client := range h.userClients[userID] {
+		if client.isAttached() {
+			count++
+		}
+	}
+	return count
 }
 
-// Redis pub/sub for horizontal scaling
+// publishToRedis hands an event to the other gateway instances through the
+// broadcast stream
 func (h *Hub) publishToRedis(ctx context.Context, channelID string, event *Event) {
 	data := struct {
 		ChannelID string `json:"channelId"`
@@ -404,140 +580,314 @@ func (h *Hub) publishToRedis(ctx context.Context, channelID string, event *Event
 		return
 	}
 
-	h.redis.Publish(ctx, "websocket:broadcast", jsonData)
+	if err := database.PublishBroadcastFrom(ctx, h.instanceID, jsonData); err != nil {
+		log.Error().Err(err).Str("type", event.Type).Msg("Failed to publish broadcast")
+	}
 }
 
-func (h *Hub) subscribeToRedis(ctx context.Context) {
-	pubsub := h.redis.Subscribe(ctx, "websocket:broadcast")
-	defer pubsub.Close()
-
-	ch := pubsub.Channel()
-	for {
-		select {
-		case <-ctx.Done():
+// consumeBroadcasts reads the broadcast stream through this instance's
+// consumer group, delivering each event to local clients and keeping
+// subscriptions and member lists in step
+func (h *Hub) consumeBroadcasts(ctx context.Context) {
+	consumer := database.NewBroadcastConsumer(h.redis, broadcastGroupPrefix+h.instanceID, h.instanceID)
+	consumer.Run(ctx, func(entry database.BroadcastEntry) {
+		h.recordStreamDelay(entry.ID)
+
+		// Events this instance published were already delivered and handled
+		// here: its own hub events, and the API's while Redis was down
+		if entry.Origin == h.instanceID {
 			return
-		case msg := <-ch:
-			var data struct {
-				ChannelID string `json:"channelId"`
-				Event     *Event `json:"event"`
-			}
-			if err := json.Unmarshal([]byte(msg.Payload), &data); err != nil {
-				continue
-			}
-
-			// Broadcast to local clients only (don't republish to Redis)
-			h.broadcastToChannel(&BroadcastMessage{
-				ChannelID: data.ChannelID,
-				Event:     data.Event,
-			})
 		}
+		h.handleBroadcast(entry.Payload)
+	})
+}
+
+// handleBroadcast delivers an event from the broadcast stream to local
+// clients and keeps subscriptions and member lists in step. Called directly
+// for events the API publishes while Redis is down.
+func (h *Hub) handleBroadcast(payload []byte) {
+	var data struct {
+		ChannelID string `json:"channelId"`
+		Event     *Event `json:"event"`
+	}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return
 	}
+
+	h.broadcastToChannel(&BroadcastMessage{
+		ChannelID: data.ChannelID,
+		Event:     data.Event,
+	})
+	h.handleChannelEvent(data.Event)
+	h.handleRoleEvent(data.Event)
+	h.handleMemberListEvent(data.Event)
 }
 
-// Presence management
-func (h *Hub) setUserPresence(ctx context.Context, userID uuid.UUID, status string) {
-	normalizedStatus, ok := normalizePresenceStatus(status)
+// pruneBroadcastGroups drops the consumer groups of instances that are gone
+// for good, which would otherwise be kept forever
+func (h *Hub) pruneBroadcastGroups(ctx context.Context) {
+	removed, err := database.PruneBroadcastGroups(ctx, h.redis, broadcastGroupPrefix, broadcastGroupMaxIdle)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to prune broadcast consumer groups")
+		return
+	}
+	if removed > 0 {
+		log.Info().Int("groups", removed).Msg("Pruned broadcast consumer groups of departed instances")
+	}
+}
+
+// handleChannelEvent keeps subscriptions in step with channel changes: a
+// deleted channel loses all its subscribers, and after an update (including
+// permission overwrites) subscribers who can no longer see it are dropped.
+func (h *Hub) handleChannelEvent(event *Event) {
+	if event == nil || (event.Type != EventTypeChannelDelete && event.Type != EventTypeChannelUpdate) {
+		return
+	}
+	data, ok := event.Data.(map[string]interface{})
 	if !ok {
 		return
 	}
+	channelID, _ := data["id"].(string)
+	if _, err := uuid.Parse(channelID); err != nil {
+		return
+	}
 
-	if h.userService != nil {
-		if err := h.userService.UpdateStatus(ctx, userID, models.UserStatus(normalizedStatus)); err == nil {
-			return
-		}
+	if event.Type == EventTypeChannelDelete {
+		h.unsubscribeAll(channelID)
+		return
 	}
+	communityID, _ := data["communityId"].(string)
+	go h.dropRevokedSubscribers(context.Background(), channelID, communityID)
+}
 
-	// Fallback path keeps Redis + event behavior if user service update fails.
-	legacyKey := fmt.Sprintf("presence:%s", userID.String())
-	h.redis.Set(ctx, legacyKey, normalizedStatus, 5*time.Minute)
-	h.redis.Set(ctx, fmt.Sprintf("presence:user:%s", userID.String()), normalizedStatus, 0)
+// unsubscribeAll removes every local subscription to a channel
+func (h *Hub) unsubscribeAll(channelID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	h.publishToRedis(ctx, "", &Event{
-		Type: EventTypePresenceUpdate,
-		Data: map[string]interface{}{
-			"userId": userID.String(),
-			"status": normalizedStatus,
-		},
-	})
+	for clientID := range h.channels[channelID] {
+		if client, ok := h.clients[clientID]; ok {
+			client.mu.Lock()
+			delete(client.Subscribed, channelID)
+			client.mu.Unlock()
+		}
+	}
+	delete(h.channels, channelID)
 }
 
-func (h *Hub) GetUserPresence(ctx context.Context, userID uuid.UUID) string {
-	status, err := h.redis.Get(ctx, fmt.Sprintf("presence:user:%s", userID.String())).Result()
-	if err == nil {
-		if normalized, ok := normalizePresenceStatus(status); ok {
-			return normalized
-		}
+// dropRevokedSubscribers unsubscribes clients that lost access to a channel.
+// From their point of view the channel is gone, so they get a CHANNEL_DELETE.
+func (h *Hub) dropRevokedSubscribers(ctx context.Context, channelID, communityID string) {
+	id, err := uuid.Parse(channelID)
+	if err != nil || h.channelService == nil {
+		return
 	}
 
-	status, err = h.redis.Get(ctx, fmt.Sprintf("presence:%s", userID.String())).Result()
-	if err == nil {
-		if normalized, ok := normalizePresenceStatus(status); ok {
-			return normalized
+	h.mu.RLock()
+	var subscribers []*Client
+	for clientID := range h.channels[channelID] {
+		if client, ok := h.clients[clientID]; ok {
+			subscribers = append(subscribers, client)
 		}
 	}
+	h.mu.RUnlock()
 
-	return "offline"
-}
+	access := make(map[uuid.UUID]bool)
+	h.revalidateVoice(ctx, h.localVoiceParticipants(ctx, id))
 
-func normalizePresenceStatus(rawStatus string) (string, bool) {
-	status := strings.ToLower(strings.TrimSpace(rawStatus))
+	for _, client := range subscribers {
+		allowed, checked := access[client.UserID]
+		if !checked {
+			allowed = h.channelService.CanAccessChannel(ctx, id, client.UserID)
+			access[client.UserID] = allowed
+		}
+		if allowed {
+			continue
+		}
 
-	switch status {
-	case "online", "away", "busy", "invisible", "offline":
-		return status, true
-	case "idle":
-		return "away", true
-	case "dnd":
-		return "busy", true
-	default:
-		return "", false
+		h.revokeSubscription(client, channelID)
+		h.SendToClient(client.ID, &Event{
+			Type: EventTypeChannelDelete,
+			Data: map[string]interface{}{"id": channelID, "communityId": communityID},
+		})
 	}
 }
 
-// Typing indicators
-func (h *Hub) SetTyping(ctx context.Context, channelID string, userID uuid.UUID) {
-	key := fmt.Sprintf("typing:%s", channelID)
-	h.redis.ZAdd(ctx, key, redis.Z{
-		Score:  float64(time.Now().Unix()),
-		Member: userID.String(),
-	})
-	h.redis.Expire(ctx, key, 10*time.Second)
-
-	// Fetch user info for the typing event
-	u, err := h.userService.GetUserByID(ctx, userID)
+// handleRoleEvent revalidates subscriptions when membership, role
+// assignments or role permissions change. MEMBER_UPDATE and MEMBER_LEAVE only
+// affect that member; role changes affect every local member of the
+// community.
+func (h *Hub) handleRoleEvent(event *Event) {
+	if event == nil || h.channelService == nil {
+		return
+	}
+	switch event.Type {
+	case EventTypeMemberUpdate, EventTypeMemberLeave, EventTypeRoleUpdate, EventTypeRoleDelete:
+	default:
+		return
+	}
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	communityIDStr, _ := data["communityId"].(string)
+	communityID, err := uuid.Parse(communityIDStr)
 	if err != nil {
-		log.Error().Err(err).Str("userId", userID.String()).Msg("Failed to fetch user for typing event")
 		return
 	}
 
-	// Broadcast typing event
-	h.Broadcast(channelID, &Event{
-		Type: EventTypeTypingStart,
-		Data: map[string]interface{}{
-			"channelId": channelID,
-			"userId":    userID.String(),
-			"user":      u,
-		},
-	}, nil)
+	memberEvent := event.Type == EventTypeMemberUpdate || event.Type == EventTypeMemberLeave
+	var userIDs []uuid.UUID
+	if memberEvent {
+		// Detached sessions count too, or they'd resume into channels the
+		// user can no longer see
+		userIDStr, _ := data["userId"].(string)
+		userID, err := uuid.Parse(userIDStr)
+		if err != nil {
+			return
+		}
+		h.mu.RLock()
+		_, local := h.userClients[userID]
+		h.mu.RUnlock()
+		if !local {
+			return
+		}
+		userIDs = []uuid.UUID{userID}
+	} else {
+		h.mu.RLock()
+		for userID := range h.userClients {
+			userIDs = append(userIDs, userID)
+		}
+		h.mu.RUnlock()
+		if len(userIDs) == 0 {
+			return
+		}
+	}
+
+	go h.revalidateMembers(context.Background(), communityID, userIDs, !memberEvent)
 }
 
-func (h *Hub) GetTypingUsers(ctx context.Context, channelID string) []uuid.UUID {
-	key := fmt.Sprintf("typing:%s", channelID)
-	cutoff := float64(time.Now().Add(-5 * time.Second).Unix())
+// revalidateMembers recomputes which of the community's channels each user can
+// see, unsubscribes their connections from the ones they lost and sends them
+// the channels they can still see.
+func (h *Hub) revalidateMembers(ctx context.Context, communityID uuid.UUID, userIDs []uuid.UUID, filterMembers bool) {
+	if filterMembers {
+		members, err := h.channelService.CommunityMembers(ctx, communityID, userIDs)
+		if err != nil {
+			log.Warn().Err(err).Str("communityId", communityID.String()).Msg("Failed to load members for revalidation")
+			return
+		}
+		userIDs = members
+	}
+
+	h.revalidateVoice(ctx, userIDs)
+
+	for _, userID := range userIDs {
+		visible, hidden, err := h.channelService.VisibleChannels(ctx, communityID, userID)
+		if err != nil {
+			log.Warn().Err(err).
+				Str("communityId", communityID.String()).
+				Str("userId", userID.String()).
+				Msg("Failed to revalidate channel subscriptions")
+			continue
+		}
 
-	members, err := h.redis.ZRangeByScore(ctx, key, &redis.ZRangeBy{
-		Min: fmt.Sprintf("%f", cutoff),
-		Max: "+inf",
-	}).Result()
+		h.mu.RLock()
+		clients := append([]*Client(nil), h.userClients[userID]...)
+		h.mu.RUnlock()
+
+		for _, client := range clients {
+			for _, channelID := range hidden {
+				key := channelID.String()
+				client.mu.RLock()
+				subscribed := client.Subscribed[key]
+				client.mu.RUnlock()
+				if subscribed {
+					h.revokeSubscription(client, key)
+				}
+			}
+		}
+
+		if visible == nil {
+			visible = []uuid.UUID{}
+		}
+		h.SendToUser(userID, &Event{
+			Type: EventTypeVisibilityUpdate,
+			Data: map[string]interface{}{
+				"communityId": communityID,
+				"channelIds":  visible,
+			},
+		})
+	}
+}
+
+// localVoiceParticipants lists the users in a voice channel who are connected
+// to this instance, which handles their revalidation
+func (h *Hub) localVoiceParticipants(ctx context.Context, channelID uuid.UUID) []uuid.UUID {
+	if h.voiceService == nil {
+		return nil
+	}
+	states, err := h.voiceService.GetChannelVoiceStates(ctx, channelID)
 	if err != nil {
 		return nil
 	}
 
-	users := make([]uuid.UUID, 0, len(members))
-	for _, m := range members {
-		if id, err := uuid.Parse(m); err == nil {
-			users = append(users, id)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var userIDs []uuid.UUID
+	for _, state := range states {
+		if _, local := h.userClients[state.UserID]; local {
+			userIDs = append(userIDs, state.UserID)
+		}
+	}
+	return userIDs
+}
+
+// revalidateVoice takes users who may no longer connect to their voice
+// channel out of it and its SFU room, and tells the channel and them
+func (h *Hub) revalidateVoice(ctx context.Context, userIDs []uuid.UUID) {
+	if h.voiceService == nil {
+		return
+	}
+	for _, userID := range userIDs {
+		channelID, err := h.voiceService.RevalidateParticipant(ctx, userID)
+		if err != nil {
+			log.Warn().Err(err).Str("userId", userID.String()).Msg("Failed to revalidate voice participant")
+			continue
+		}
+		if channelID == uuid.Nil {
+			continue
 		}
+		event := &Event{
+			Type: EventTypeVoiceLeave,
+			Data: map[string]interface{}{
+				"channelId": channelID.String(),
+				"userId":    userID.String(),
+			},
+		}
+		h.Broadcast(channelID.String(), event, nil)
+		h.SendToUser(userID, event)
+	}
+}
+
+// Presence and typing state lives in the presence service (Redis) so every
+// instance sees the same thing.
+func (h *Hub) setUserPresence(ctx context.Context, userID uuid.UUID, status string) {
+	normalizedStatus, ok := presence.NormalizeStatus(status)
+	if !ok {
+		return
 	}
-	return users
+	h.presenceService.SetStatus(ctx, userID, models.UserStatus(normalizedStatus))
+}
+
+func (h *Hub) GetUserPresence(ctx context.Context, userID uuid.UUID) string {
+	return h.presenceService.GetStatus(ctx, userID)
+}
+
+func (h *Hub) SetTyping(ctx context.Context, channelID string, userID uuid.UUID) {
+	h.presenceService.StartTyping(ctx, channelID, userID)
+}
+
+func (h *Hub) GetTypingUsers(ctx context.Context, channelID string) []uuid.UUID {
+	return h.presenceService.TypingUsers(ctx, channelID)
 }
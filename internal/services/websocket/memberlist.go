@@ -0,0 +1,617 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/models"
+	"github.com/zentra/server/internal/services/community"
+)
+
+const (
+	// Most members a single requested range can cover
+	memberListRangeSize = 100
+
+	// Most ranges one client can watch in a list at a time
+	maxMemberListRanges = 3
+)
+
+// Member list update operations
+const (
+	MemberListOpInsert = "INSERT"
+	MemberListOpUpdate = "UPDATE"
+	MemberListOpDelete = "DELETE"
+)
+
+// memberRange is an inclusive range of list positions, sent as [start, end]
+type memberRange [2]int
+
+// memberListEntry is where a member sits in a list: online members come
+// first, then members are sorted by name
+type memberListEntry struct {
+	userID uuid.UUID
+	name   string
+	online bool
+}
+
+func (e memberListEntry) less(o memberListEntry) bool {
+	if e.online != o.online {
+		return e.online
+	}
+	if e.name != o.name {
+		return e.name < o.name
+	}
+	return e.userID.String() < o.userID.String()
+}
+
+// memberList is a sorted member list of a community, or of the members who
+// can see one of its channels. An instance keeps a list while any of its
+// clients watch part of it and updates it from member and user events.
+type memberList struct {
+	key         string
+	communityID uuid.UUID
+	channelID   *uuid.UUID
+
+	// Guarded by mu, which is also held while the list is loaded or changed
+	// so updates apply in order. A dropped list has left the hub and must not
+	// gain subscribers.
+	mu          sync.Mutex
+	loaded      bool
+	dropped     bool
+	entries     []memberListEntry
+	byUser      map[uuid.UUID]memberListEntry
+	online      int
+	subscribers map[*Client][]memberRange
+}
+
+// memberListOp is one change to a list as clients apply it
+type memberListOp struct {
+	Op     string                          `json:"op"`
+	Index  int                             `json:"index"`
+	Member *models.CommunityMemberWithUser `json:"member,omitempty"`
+}
+
+func memberListKey(communityID uuid.UUID, channelID *uuid.UUID) string {
+	if channelID == nil {
+		return communityID.String()
+	}
+	return communityID.String() + ":" + channelID.String()
+}
+
+func newMemberListEntry(summary community.MemberSummary) memberListEntry {
+	return memberListEntry{
+		userID: summary.UserID,
+		name:   strings.ToLower(summary.Name),
+		online: summary.Status != models.UserStatusOffline && summary.Status != models.UserStatusInvisible,
+	}
+}
+
+// SetCommunityService enables member lists over the gateway
+func (h *Hub) SetCommunityService(communityService *community.Service) {
+	h.communityService = communityService
+}
+
+// handleMemberListSubscribe watches ranges of a community's member list, or
+// of a channel's when channelId is set. Each client watches one list per
+// community; a new request replaces the ranges and an empty one stops
+// watching.
+func (c *Client) handleMemberListSubscribe(data json.RawMessage) {
+	var req struct {
+		CommunityID string        `json:"communityId"`
+		ChannelID   string        `json:"channelId"`
+		Ranges      []memberRange `json:"ranges"`
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return
+	}
+	if c.Hub.communityService == nil {
+		return
+	}
+
+	communityID, err := uuid.Parse(req.CommunityID)
+	if err != nil {
+		return
+	}
+	if len(req.Ranges) == 0 {
+		c.Hub.unwatchMemberList(c, communityID)
+		return
+	}
+	if len(req.Ranges) > maxMemberListRanges {
+		return
+	}
+	for _, r := range req.Ranges {
+		if r[0] < 0 || r[1] < r[0] || r[1]-r[0] >= memberListRangeSize {
+			return
+		}
+	}
+
+	ctx := context.Background()
+	var channelID *uuid.UUID
+	if req.ChannelID != "" {
+		id, err := uuid.Parse(req.ChannelID)
+		if err != nil || c.Hub.channelService == nil {
+			return
+		}
+		channel, err := c.Hub.channelService.GetChannel(ctx, id)
+		if err != nil || channel.CommunityID != communityID || !c.Hub.channelService.CanAccessChannel(ctx, id, c.UserID) {
+			return
+		}
+		channelID = &id
+	} else if !c.Hub.communityService.IsMember(ctx, communityID, c.UserID) {
+		log.Warn().
+			Str("communityId", req.CommunityID).
+			Str("userId", c.UserID.String()).
+			Msg("User requested the member list of a community they aren't in")
+		return
+	}
+
+	c.Hub.watchMemberList(ctx, c, communityID, channelID, req.Ranges)
+}
+
+// watchMemberList sets the client's ranges in a list, loading the list if
+// nobody on this instance watches it yet, and sends the members in them
+func (h *Hub) watchMemberList(ctx context.Context, client *Client, communityID uuid.UUID, channelID *uuid.UUID, ranges []memberRange) {
+	key := memberListKey(communityID, channelID)
+
+	client.mu.Lock()
+	previous, watching := client.memberLists[communityID]
+	client.memberLists[communityID] = key
+	client.mu.Unlock()
+	if watching && previous != key {
+		h.removeListSubscriber(previous, client)
+	}
+
+	var list *memberList
+	for {
+		h.memberListsMu.Lock()
+		list = h.memberLists[key]
+		if list == nil {
+			list = &memberList{
+				key:         key,
+				communityID: communityID,
+				channelID:   channelID,
+				subscribers: make(map[*Client][]memberRange),
+			}
+			h.memberLists[key] = list
+		}
+		h.memberListsMu.Unlock()
+
+		list.mu.Lock()
+		if !list.dropped {
+			break
+		}
+		list.mu.Unlock()
+	}
+	list.subscribers[client] = ranges
+
+	if !list.loaded {
+		if err := h.loadMemberList(ctx, list); err != nil {
+			log.Error().Err(err).Str("list", key).Msg("Failed to load member list")
+			list.dropSubscriber(client)
+			list.mu.Unlock()
+			h.pruneMemberList(list)
+			return
+		}
+	}
+	h.sendMemberChunk(ctx, list, client, ranges)
+	list.mu.Unlock()
+}
+
+// unwatchMemberList stops the client watching its list for a community
+func (h *Hub) unwatchMemberList(client *Client, communityID uuid.UUID) {
+	client.mu.Lock()
+	key, ok := client.memberLists[communityID]
+	delete(client.memberLists, communityID)
+	client.mu.Unlock()
+
+	if ok {
+		h.removeListSubscriber(key, client)
+	}
+}
+
+// leaveMemberLists stops a session watching any list, once it has expired
+func (h *Hub) leaveMemberLists(client *Client) {
+	client.mu.Lock()
+	keys := make([]string, 0, len(client.memberLists))
+	for _, key := range client.memberLists {
+		keys = append(keys, key)
+	}
+	client.memberLists = make(map[uuid.UUID]string)
+	client.mu.Unlock()
+
+	for _, key := range keys {
+		h.removeListSubscriber(key, client)
+	}
+}
+
+// removeListSubscriber drops a client from a list, and the list itself once
+// nobody on this instance watches it
+func (h *Hub) removeListSubscriber(key string, client *Client) {
+	h.memberListsMu.Lock()
+	list, ok := h.memberLists[key]
+	h.memberListsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	list.mu.Lock()
+	delete(list.subscribers, client)
+	list.mu.Unlock()
+	h.pruneMemberList(list)
+}
+
+// pruneMemberList drops a list that lost its last subscriber. The hub's lock
+// is never held while waiting on a list, which may be loading.
+func (h *Hub) pruneMemberList(list *memberList) {
+	list.mu.Lock()
+	if list.dropped || len(list.subscribers) > 0 {
+		list.mu.Unlock()
+		return
+	}
+	list.dropped = true
+	list.mu.Unlock()
+
+	h.memberListsMu.Lock()
+	if h.memberLists[list.key] == list {
+		delete(h.memberLists, list.key)
+	}
+	h.memberListsMu.Unlock()
+}
+
+// loadMemberList fills a list from the database. Called with list.mu held.
+func (h *Hub) loadMemberList(ctx context.Context, list *memberList) error {
+	summaries, err := h.communityService.MemberSummaries(ctx, list.communityID, nil)
+	if err != nil {
+		return err
+	}
+	if list.channelID != nil {
+		summaries, err = h.filterChannelViewers(ctx, *list.channelID, summaries)
+		if err != nil {
+			return err
+		}
+	}
+
+	list.entries = make([]memberListEntry, 0, len(summaries))
+	list.byUser = make(map[uuid.UUID]memberListEntry, len(summaries))
+	list.online = 0
+	for _, summary := range summaries {
+		entry := newMemberListEntry(summary)
+		list.entries = append(list.entries, entry)
+		list.byUser[entry.userID] = entry
+		if entry.online {
+			list.online++
+		}
+	}
+	sort.Slice(list.entries, func(i, j int) bool {
+		return list.entries[i].less(list.entries[j])
+	})
+	list.loaded = true
+	return nil
+}
+
+// filterChannelViewers keeps the summaries of members who can see the channel
+func (h *Hub) filterChannelViewers(ctx context.Context, channelID uuid.UUID, summaries []community.MemberSummary) ([]community.MemberSummary, error) {
+	userIDs := make([]uuid.UUID, 0, len(summaries))
+	for _, summary := range summaries {
+		userIDs = append(userIDs, summary.UserID)
+	}
+	viewers, err := h.channelService.ChannelViewers(ctx, channelID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[uuid.UUID]bool, len(viewers))
+	for _, id := range viewers {
+		allowed[id] = true
+	}
+	filtered := summaries[:0]
+	for _, summary := range summaries {
+		if allowed[summary.UserID] {
+			filtered = append(filtered, summary)
+		}
+	}
+	return filtered, nil
+}
+
+// sendMemberChunk sends the members in ranges. Called with list.mu held.
+func (h *Hub) sendMemberChunk(ctx context.Context, list *memberList, client *Client, ranges []memberRange) {
+	var userIDs []uuid.UUID
+	for _, r := range ranges {
+		for i := r[0]; i <= r[1] && i < len(list.entries); i++ {
+			userIDs = append(userIDs, list.entries[i].userID)
+		}
+	}
+
+	members, err := h.communityService.GetMembersByUserIDs(ctx, list.communityID, userIDs)
+	if err != nil {
+		log.Error().Err(err).Str("list", list.key).Msg("Failed to load member chunk")
+		return
+	}
+	byUser := make(map[uuid.UUID]*models.CommunityMemberWithUser, len(members))
+	for _, m := range members {
+		maskMemberStatus(m)
+		byUser[m.UserID] = m
+	}
+
+	chunks := make([]map[string]interface{}, 0, len(ranges))
+	for _, r := range ranges {
+		rangeMembers := make([]*models.CommunityMemberWithUser, 0, memberListRangeSize)
+		for i := r[0]; i <= r[1] && i < len(list.entries); i++ {
+			if m, ok := byUser[list.entries[i].userID]; ok {
+				rangeMembers = append(rangeMembers, m)
+			}
+		}
+		chunks = append(chunks, map[string]interface{}{
+			"range":   r,
+			"members": rangeMembers,
+		})
+	}
+
+	client.SendEvent(&Event{
+		Type: EventTypeMemberChunk,
+		Data: map[string]interface{}{
+			"communityId": list.communityID,
+			"channelId":   list.channelID,
+			"total":       len(list.entries),
+			"onlineCount": list.online,
+			"ranges":      chunks,
+		},
+	})
+}
+
+// handleMemberListEvent keeps local member lists in step with membership,
+// profile, presence, role and channel changes
+func (h *Hub) handleMemberListEvent(event *Event) {
+	if event == nil || h.communityService == nil {
+		return
+	}
+	data, ok := event.Data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	communityID, _ := uuid.Parse(stringField(data, "communityId"))
+
+	switch event.Type {
+	case EventTypeMemberJoin, EventTypeMemberLeave, EventTypeMemberUpdate:
+		userID, err := uuid.Parse(stringField(data, "userId"))
+		if err != nil || communityID == uuid.Nil {
+			return
+		}
+		lists := h.memberListsWhere(func(l *memberList) bool { return l.communityID == communityID })
+		go h.refreshListMember(context.Background(), lists, userID, false)
+
+	case EventTypeUserUpdate:
+		userID, err := uuid.Parse(stringField(data, "id"))
+		if err != nil {
+			return
+		}
+		// Name and presence changes move the user in every list they're in
+		lists := h.memberListsWhere(func(*memberList) bool { return true })
+		go h.refreshListMember(context.Background(), lists, userID, true)
+
+	case EventTypeRoleUpdate, EventTypeRoleDelete:
+		// Roles only decide who is in channel lists
+		if communityID == uuid.Nil {
+			return
+		}
+		lists := h.memberListsWhere(func(l *memberList) bool {
+			return l.communityID == communityID && l.channelID != nil
+		})
+		go h.resyncMemberLists(context.Background(), lists)
+
+	case EventTypeChannelUpdate:
+		channelID, err := uuid.Parse(stringField(data, "id"))
+		if err != nil {
+			return
+		}
+		lists := h.memberListsWhere(func(l *memberList) bool {
+			return l.channelID != nil && *l.channelID == channelID
+		})
+		go h.resyncMemberLists(context.Background(), lists)
+
+	case EventTypeChannelDelete:
+		channelID, err := uuid.Parse(stringField(data, "id"))
+		if err != nil {
+			return
+		}
+		for _, list := range h.memberListsWhere(func(l *memberList) bool {
+			return l.channelID != nil && *l.channelID == channelID
+		}) {
+			h.dropMemberList(list)
+		}
+	}
+}
+
+func (h *Hub) memberListsWhere(match func(*memberList) bool) []*memberList {
+	h.memberListsMu.Lock()
+	defer h.memberListsMu.Unlock()
+
+	var lists []*memberList
+	for _, list := range h.memberLists {
+		if match(list) {
+			lists = append(lists, list)
+		}
+	}
+	return lists
+}
+
+// refreshListMember reloads one member's place in each list and sends the
+// resulting operations to subscribers. With listedOnly, lists the user isn't
+// in are left alone.
+func (h *Hub) refreshListMember(ctx context.Context, lists []*memberList, userID uuid.UUID, listedOnly bool) {
+	for _, list := range lists {
+		list.mu.Lock()
+		_, listed := list.byUser[userID]
+		if list.loaded && (listed || !listedOnly) {
+			h.applyMemberChange(ctx, list, userID)
+		}
+		list.mu.Unlock()
+		h.pruneMemberList(list)
+	}
+}
+
+// applyMemberChange moves, adds or removes a member. Called with list.mu held.
+func (h *Hub) applyMemberChange(ctx context.Context, list *memberList, userID uuid.UUID) {
+	summaries, err := h.communityService.MemberSummaries(ctx, list.communityID, []uuid.UUID{userID})
+	if err == nil && list.channelID != nil {
+		summaries, err = h.filterChannelViewers(ctx, *list.channelID, summaries)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("list", list.key).Str("userId", userID.String()).Msg("Failed to refresh member list entry")
+		return
+	}
+
+	old, had := list.byUser[userID]
+	var ops []memberListOp
+	if had {
+		index := list.indexOf(old)
+		if len(summaries) == 1 && newMemberListEntry(summaries[0]) == old {
+			ops = append(ops, memberListOp{Op: MemberListOpUpdate, Index: index})
+		} else {
+			list.remove(index)
+			ops = append(ops, memberListOp{Op: MemberListOpDelete, Index: index})
+		}
+	}
+	if len(summaries) == 1 && (len(ops) == 0 || ops[0].Op == MemberListOpDelete) {
+		index := list.insert(newMemberListEntry(summaries[0]))
+		ops = append(ops, memberListOp{Op: MemberListOpInsert, Index: index})
+	}
+	if len(ops) == 0 {
+		return
+	}
+
+	if _, ok := list.byUser[userID]; ok {
+		members, err := h.communityService.GetMembersByUserIDs(ctx, list.communityID, []uuid.UUID{userID})
+		if err != nil || len(members) == 0 {
+			log.Warn().Err(err).Str("list", list.key).Str("userId", userID.String()).Msg("Failed to load member for list update")
+			return
+		}
+		maskMemberStatus(members[0])
+		last := &ops[len(ops)-1]
+		last.Member = members[0]
+	} else {
+		// Whoever left the list can't watch it any more
+		for client := range list.subscribers {
+			if client.UserID == userID {
+				list.dropSubscriber(client)
+			}
+		}
+	}
+
+	for client, ranges := range list.subscribers {
+		end := -1
+		for _, r := range ranges {
+			end = max(end, r[1])
+		}
+		// Changes past the watched ranges only move the counts
+		relevant := make([]memberListOp, 0, len(ops))
+		for _, op := range ops {
+			if op.Index <= end {
+				relevant = append(relevant, op)
+			}
+		}
+
+		client.SendEvent(&Event{
+			Type: EventTypeMemberListUpdate,
+			Data: map[string]interface{}{
+				"communityId": list.communityID,
+				"channelId":   list.channelID,
+				"total":       len(list.entries),
+				"onlineCount": list.online,
+				"ops":         relevant,
+			},
+		})
+	}
+}
+
+// resyncMemberLists reloads lists whose membership may have changed wholesale
+// and sends every subscriber its ranges again
+func (h *Hub) resyncMemberLists(ctx context.Context, lists []*memberList) {
+	for _, list := range lists {
+		list.mu.Lock()
+		if list.loaded {
+			if err := h.loadMemberList(ctx, list); err != nil {
+				log.Error().Err(err).Str("list", list.key).Msg("Failed to reload member list")
+			} else {
+				for client, ranges := range list.subscribers {
+					if _, ok := list.byUser[client.UserID]; !ok {
+						list.dropSubscriber(client)
+						continue
+					}
+					h.sendMemberChunk(ctx, list, client, ranges)
+				}
+			}
+		}
+		list.mu.Unlock()
+		h.pruneMemberList(list)
+	}
+}
+
+// dropMemberList forgets a list whose channel is gone
+func (h *Hub) dropMemberList(list *memberList) {
+	h.memberListsMu.Lock()
+	delete(h.memberLists, list.key)
+	h.memberListsMu.Unlock()
+
+	list.mu.Lock()
+	defer list.mu.Unlock()
+	list.dropped = true
+	for client := range list.subscribers {
+		list.dropSubscriber(client)
+	}
+}
+
+// dropSubscriber stops a client watching the list. Called with l.mu held.
+func (l *memberList) dropSubscriber(client *Client) {
+	delete(l.subscribers, client)
+
+	client.mu.Lock()
+	if client.memberLists[l.communityID] == l.key {
+		delete(client.memberLists, l.communityID)
+	}
+	client.mu.Unlock()
+}
+
+func (l *memberList) indexOf(entry memberListEntry) int {
+	return sort.Search(len(l.entries), func(i int) bool {
+		return !l.entries[i].less(entry)
+	})
+}
+
+func (l *memberList) insert(entry memberListEntry) int {
+	index := l.indexOf(entry)
+	l.entries = append(l.entries, memberListEntry{})
+	copy(l.entries[index+1:], l.entries[index:])
+	l.entries[index] = entry
+	l.byUser[entry.userID] = entry
+	if entry.online {
+		l.online++
+	}
+	return index
+}
+
+func (l *memberList) remove(index int) {
+	entry := l.entries[index]
+	l.entries = append(l.entries[:index], l.entries[index+1:]...)
+	delete(l.byUser, entry.userID)
+	if entry.online {
+		l.online--
+	}
+}
+
+// maskMemberStatus shows invisible members as offline
+func maskMemberStatus(m *models.CommunityMemberWithUser) {
+	if m.User != nil {
+		m.User.Status = m.User.Status.Public()
+	}
+}
+
+func stringField(data map[string]interface{}, key string) string {
+	s, _ := data[key].(string)
+	return s
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/database"
+)
+
+const (
+	// A connection without ops other than heartbeats for this long is idle
+	idleTimeout = 10 * time.Minute
+
+	// How often connections are checked for going idle
+	idleCheckInterval = time.Minute
+
+	// Presence changes waiting to be delivered; more are dropped
+	presenceQueueSize = 1024
+)
+
+type presenceUpdate struct {
+	userID uuid.UUID
+	event  *Event
+}
+
+// queuePresence hands a presence stream event to the delivery loop. Finding
+// who may see it takes a query, so it stays off the broadcast path.
+func (h *Hub) queuePresence(msg *BroadcastMessage) {
+	userID, err := uuid.Parse(strings.TrimPrefix(msg.ChannelID, database.StreamPrefixPresence))
+	if err != nil {
+		return
+	}
+
+	select {
+	case h.presenceUpdates <- presenceUpdate{userID: userID, event: msg.Event}:
+	default:
+		log.Warn().Str("userId", userID.String()).Msg("Presence delivery queue full, dropping update")
+	}
+}
+
+// deliverPresence sends presence changes, in order, to the local users who
+// share a community or DM conversation with the user they're about
+func (h *Hub) deliverPresence(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-h.presenceUpdates:
+			h.deliverPresenceUpdate(ctx, update)
+		}
+	}
+}
+
+func (h *Hub) deliverPresenceUpdate(ctx context.Context, update presenceUpdate) {
+	h.mu.RLock()
+	candidates := make([]uuid.UUID, 0, len(h.userClients))
+	for userID := range h.userClients {
+		if userID != update.userID {
+			candidates = append(candidates, userID)
+		}
+	}
+	h.mu.RUnlock()
+	if len(candidates) == 0 {
+		return
+	}
+
+	audience, err := h.userService.SharedUsers(ctx, update.userID, candidates)
+	if err != nil {
+		log.Error().Err(err).Str("userId", update.userID.String()).Msg("Failed to find presence audience")
+		return
+	}
+
+	data, err := newPayload(update.event)
+	if err != nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, userID := range audience {
+		for _, client := range h.userClients[userID] {
+			client.dispatch(data)
+		}
+	}
+}
+
+// setIdle records whether the client's user is idle, as reported by the
+// client or noticed by the hub
+func (c *Client) setIdle(idle bool) {
+	c.mu.Lock()
+	changed := c.idle != idle
+	c.idle = idle
+	if !idle {
+		c.lastActive = time.Now()
+	}
+	c.mu.Unlock()
+
+	if changed {
+		c.Hub.presenceService.SetIdle(context.Background(), c.UserID, c.ID, idle)
+	}
+}
+
+// detectIdle marks connections idle once they've gone quiet
+func (h *Hub) detectIdle(ctx context.Context) {
+	now := time.Now()
+
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		if !client.isAttached() {
+			continue
+		}
+
+		client.mu.Lock()
+		wentIdle := !client.idle && now.Sub(client.lastActive) >= idleTimeout
+		if wentIdle {
+			client.idle = true
+		}
+		client.mu.Unlock()
+
+		if wentIdle {
+			h.presenceService.SetIdle(ctx, client.UserID, client.ID, true)
+		}
+	}
+}
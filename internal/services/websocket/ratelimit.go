@@ -0,0 +1,203 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+// Close codes sent to clients that break the gateway's limits. Clients
+// should back off before reconnecting after any of them.
+const (
+	CloseUnknownOp       = 4001
+	CloseDecodeError     = 4002
+	CloseRateLimited     = 4008
+	ClosePayloadTooLarge = 4009
+)
+
+// opLimit is how often an op may be sent and how large it may be. Tokens
+// refill at rate per second up to burst.
+type opLimit struct {
+	rate     float64
+	burst    float64
+	maxBytes int
+}
+
+// opLimits covers every op the gateway accepts; anything else is unknown
+var opLimits = map[string]opLimit{
+	"SUBSCRIBE":             {rate: 10, burst: 200, maxBytes: 512},
+	"UNSUBSCRIBE":           {rate: 10, burst: 200, maxBytes: 512},
+	"TYPING_START":          {rate: 1, burst: 5, maxBytes: 512},
+	"HEARTBEAT":             {rate: 1, burst: 5, maxBytes: 512},
+	"PRESENCE_UPDATE":       {rate: 0.2, burst: 5, maxBytes: 512},
+	"VOICE_JOIN":            {rate: 1, burst: 5, maxBytes: 1 << 10},
+	"VOICE_LEAVE":           {rate: 1, burst: 5, maxBytes: 1 << 10},
+	"VOICE_STATE_UPDATE":    {rate: 2, burst: 10, maxBytes: 1 << 10},
+	"VOICE_SIGNAL":          {rate: 50, burst: 200, maxBytes: maxMessageSize},
+	"MEMBER_LIST_SUBSCRIBE": {rate: 2, burst: 10, maxBytes: 1 << 10},
+}
+
+var (
+	// Every op also draws from one bucket for the whole session
+	sessionLimit = opLimit{rate: 60, burst: 300}
+
+	// Dropped, unknown and undecodable messages draw from this bucket; the
+	// connection is closed once it runs dry
+	violationLimit = opLimit{rate: 0.2, burst: 10}
+)
+
+var (
+	opsTotal = metrics.NewCounter("zentra_gateway_ops_total",
+		"Ops received from gateway clients, by op.", "op")
+	rateLimitedOpsTotal = metrics.NewCounter("zentra_gateway_rate_limited_ops_total",
+		"Gateway ops dropped by rate limits, by op.", "op")
+	abuseClosesTotal = metrics.NewCounter("zentra_gateway_abuse_closes_total",
+		"Gateway connections closed for breaking limits, by close code.", "code")
+)
+
+// gatewayClose is a close frame for a client that broke the gateway's limits
+type gatewayClose struct {
+	code   int
+	reason string
+}
+
+var (
+	closeUnknownOp       = &gatewayClose{code: CloseUnknownOp, reason: "unknown op"}
+	closeDecodeError     = &gatewayClose{code: CloseDecodeError, reason: "invalid message"}
+	closeRateLimited     = &gatewayClose{code: CloseRateLimited, reason: "rate limited"}
+	closePayloadTooLarge = &gatewayClose{code: ClosePayloadTooLarge, reason: "payload too large"}
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit opLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{tokens: limit.burst, last: now}
+}
+
+func (b *tokenBucket) take(limit opLimit, now time.Time) bool {
+	b.tokens = min(limit.burst, b.tokens+now.Sub(b.last).Seconds()*limit.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// wait is how long until the bucket has a token again
+func (b *tokenBucket) wait(limit opLimit) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / limit.rate * float64(time.Second))
+}
+
+// opLimiter holds a session's buckets. It belongs to the session rather than
+// the connection, so reconnecting and resuming doesn't refill it.
+type opLimiter struct {
+	mu         sync.Mutex
+	session    *tokenBucket
+	violations *tokenBucket
+	ops        map[string]*tokenBucket
+}
+
+func newOpLimiter() *opLimiter {
+	now := time.Now()
+	return &opLimiter{
+		session:    newTokenBucket(sessionLimit, now),
+		violations: newTokenBucket(violationLimit, now),
+		ops:        make(map[string]*tokenBucket),
+	}
+}
+
+// allow reports whether a known op may be handled now. A dropped op comes
+// with how long to wait before retrying it, or with a close once the client
+// has run out of violations.
+func (l *opLimiter) allow(op string, size int) (bool, time.Duration, *gatewayClose) {
+	limit := opLimits[op]
+	opsTotal.Inc(op)
+	if size > limit.maxBytes {
+		return false, 0, closePayloadTooLarge
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.ops[op]
+	if !ok {
+		bucket = newTokenBucket(limit, now)
+		l.ops[op] = bucket
+	}
+	if bucket.take(limit, now) && l.session.take(sessionLimit, now) {
+		return true, 0, nil
+	}
+
+	rateLimitedOpsTotal.Inc(op)
+	if !l.violations.take(violationLimit, now) {
+		return false, 0, closeRateLimited
+	}
+	return false, max(bucket.wait(limit), l.session.wait(sessionLimit)), nil
+}
+
+// violation records a message that couldn't be handled at all
+func (l *opLimiter) violation(abuse *gatewayClose) *gatewayClose {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.violations.take(violationLimit, time.Now()) {
+		return nil
+	}
+	return abuse
+}
+
+// closeAbusive closes conn with the given close code. The session itself
+// stays resumable until it expires.
+func (c *Client) closeAbusive(conn *websocket.Conn, abuse *gatewayClose) {
+	log.Warn().
+		Str("clientId", c.ID.String()).
+		Str("userId", c.UserID.String()).
+		Int("code", abuse.code).
+		Msg("Closing abusive WebSocket connection")
+	abuseClosesTotal.Inc(closeCodeLabel(abuse.code))
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(abuse.code, abuse.reason),
+		time.Now().Add(writeWait))
+}
+
+// sendRateLimited tells the client an op was dropped and when to retry it
+func (c *Client) sendRateLimited(op string, retryAfter time.Duration) {
+	data, err := newPayload(&Event{
+		Type: EventTypeRateLimited,
+		Data: map[string]interface{}{
+			"op":         op,
+			"retryAfter": retryAfter.Milliseconds(),
+		},
+	})
+	if err != nil {
+		return
+	}
+	c.sendUnsequenced(data)
+}
+
+func closeCodeLabel(code int) string {
+	switch code {
+	case CloseUnknownOp:
+		return "unknown_op"
+	case CloseDecodeError:
+		return "decode_error"
+	case CloseRateLimited:
+		return "rate_limited"
+	case ClosePayloadTooLarge:
+		return "payload_too_large"
+	case CloseBufferOverrun:
+		return "buffer_overrun"
+	}
+	return "other"
+}
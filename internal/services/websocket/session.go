@@ -0,0 +1,300 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// How long a dropped session keeps buffering events and can be resumed
+	resumeWindow = 2 * time.Minute
+
+	// Outgoing events are kept for resuming for the resume window, plus a
+	// little for events sent just before a connection dropped, up to the
+	// hub's replay buffer size in bytes per session. Sessions share the
+	// encoded events, so a broadcast is held once however many keep it.
+	replayWindow             = resumeWindow + 30*time.Second
+	defaultReplayBufferBytes = 4 << 20
+
+	// How often sessions past their resume window are removed
+	sessionSweepInterval = 30 * time.Second
+
+	// Capacity of a connection's send queue
+	sendBufferSize = 256
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrResumeGap       = errors.New("events since the given sequence are no longer available")
+)
+
+// sequencedEvent is an event as this session numbered it. The payload is
+// shared with the event's other recipients; seq is added when it's sent.
+type sequencedEvent struct {
+	seq     int64
+	payload *payload
+	size    int
+	at      time.Time
+}
+
+// clientConn identifies a connection of a session. A session can outlive
+// several connections, so a connection that ends only detaches the session
+// if it is still the current one.
+type clientConn struct {
+	client *Client
+	conn   io.Closer
+}
+
+// transport is the current connection: a WebSocket or an SSE stream. Called
+// with sendMu held.
+func (c *Client) transport() io.Closer {
+	if c.stream != nil {
+		return c.stream
+	}
+	return c.Conn
+}
+
+// SetReplayBuffer sets how many bytes of recent events each session keeps
+// for resuming. Events older than the resume window are dropped whatever the
+// size. Must be called before clients connect.
+func (h *Hub) SetReplayBuffer(maxBytes int) {
+	if maxBytes > 0 {
+		h.replayBufferBytes = maxBytes
+	}
+}
+
+// dispatch numbers an event for this session, keeps it for resuming and
+// queues it on the connection if one is attached. An event dropped because
+// the queue is full is still replayable, so a client can reconnect and resume
+// past the gap.
+func (c *Client) dispatch(p *payload) {
+	data := p.encode(c.encoding)
+	if data == nil {
+		return
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.expired {
+		return
+	}
+	c.seq++
+	now := time.Now()
+	c.replay = append(c.replay, sequencedEvent{seq: c.seq, payload: p, size: len(data), at: now})
+	c.replayBytes += len(data)
+	c.trimReplay(now)
+
+	if !c.attached {
+		return
+	}
+	c.queue(withSeq(c.encoding, data, c.seq), c.seq)
+}
+
+// trimReplay drops the oldest events that are past the replay window or
+// don't fit in the replay buffer. The newest event is always kept. Called
+// with sendMu held.
+func (c *Client) trimReplay(now time.Time) {
+	drop := 0
+	for drop < len(c.replay)-1 &&
+		(now.Sub(c.replay[drop].at) > replayWindow || c.replayBytes > c.Hub.replayBufferBytes) {
+		c.replayBytes -= c.replay[drop].size
+		drop++
+	}
+	if drop > 0 {
+		clear(c.replay[:drop])
+		c.replay = c.replay[drop:]
+	}
+}
+
+// sendUnsequenced queues data outside the event sequence, for replies like
+// heartbeat acks that mean nothing after a reconnect
+func (c *Client) sendUnsequenced(p *payload) {
+	data := p.encode(c.encoding)
+	if data == nil {
+		return
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.attached {
+		return
+	}
+	c.queue(data, 0)
+}
+
+// detach ends conn's hold on the session. It reports false when conn was
+// already replaced by a resumed connection.
+func (c *Client) detach(conn io.Closer) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if !c.attached || c.transport() != conn {
+		return false
+	}
+	c.attached = false
+	c.detachedAt = time.Now()
+	c.resetOverrun()
+	close(c.Send)
+	return true
+}
+
+// expire reports whether a detached session has outlived its resume window,
+// and if so stops it buffering
+func (c *Client) expire(now time.Time) bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.attached || now.Sub(c.detachedAt) < resumeWindow {
+		return false
+	}
+	c.expired = true
+	c.replay = nil
+	c.replayBytes = 0
+	return true
+}
+
+func (c *Client) isAttached() bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return c.attached
+}
+
+// Resume moves a session onto a new WebSocket connection and queues the
+// events sent after lastSeq, followed by RESUMED. A session whose old
+// connection hasn't been noticed as dead yet is taken over.
+func (h *Hub) Resume(sessionID, userID uuid.UUID, lastSeq int64, conn *websocket.Conn, encoder *frameEncoder) (*Client, error) {
+	return h.resume(sessionID, userID, lastSeq, encoder.encoding, func(client *Client) {
+		client.Conn = conn
+		client.stream = nil
+		client.encoder = encoder
+	})
+}
+
+// ResumeStream is Resume for an SSE stream. Sessions opened over either
+// transport can be resumed over the other, as long as they use JSON.
+func (h *Hub) ResumeStream(sessionID, userID uuid.UUID, lastSeq int64, stream *eventStream) (*Client, error) {
+	return h.resume(sessionID, userID, lastSeq, EncodingJSON, func(client *Client) {
+		client.Conn = nil
+		client.stream = stream
+		client.encoder = nil
+	})
+}
+
+// resume checks a session can be resumed from lastSeq, calls attach with
+// sendMu held to swap in the new connection and queues the missed events
+func (h *Hub) resume(sessionID, userID uuid.UUID, lastSeq int64, encoding string, attach func(*Client)) (*Client, error) {
+	client, ok := h.session(sessionID, userID)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	// Buffered events are already encoded
+	if client.encoding != encoding {
+		return nil, ErrEncodingMismatch
+	}
+
+	// Counts as a heartbeat, so the new connection isn't reaped for the old
+	// one's silence
+	client.recordHeartbeat(0)
+
+	client.sendMu.Lock()
+	if client.expired {
+		client.sendMu.Unlock()
+		return nil, ErrSessionNotFound
+	}
+	if lastSeq > client.seq || (lastSeq < client.seq && (len(client.replay) == 0 || client.replay[0].seq > lastSeq+1)) {
+		client.sendMu.Unlock()
+		return nil, ErrResumeGap
+	}
+
+	reconnected := !client.attached
+	if client.attached {
+		client.transport().Close()
+		close(client.Send)
+	}
+
+	missed := make([][]byte, 0)
+	for _, event := range client.replay {
+		if event.seq > lastSeq {
+			missed = append(missed, withSeq(client.encoding, event.payload.encode(client.encoding), event.seq))
+		}
+	}
+	attach(client)
+	client.Send = make(chan []byte, sendBufferSize+len(missed)+1)
+	client.resetOverrun()
+	client.attached = true
+	client.detachedAt = time.Time{}
+	for _, data := range missed {
+		client.Send <- data
+	}
+	client.sendMu.Unlock()
+
+	if reconnected {
+		h.presenceService.Connect(context.Background(), client.UserID, client.ID)
+	}
+	client.setIdle(false)
+
+	log.Info().
+		Str("clientId", client.ID.String()).
+		Str("userId", client.UserID.String()).
+		Int("replayed", len(missed)).
+		Msg("WebSocket session resumed")
+
+	client.SendEvent(&Event{
+		Type: EventTypeResumed,
+		Data: map[string]interface{}{
+			"sessionId": client.ID.String(),
+			"replayed":  len(missed),
+		},
+	})
+	return client, nil
+}
+
+// sweepSessions removes sessions that stayed detached past the resume window
+func (h *Hub) sweepSessions() {
+	now := time.Now()
+	var expired []*Client
+
+	h.mu.Lock()
+	for id, client := range h.clients {
+		if !client.expire(now) {
+			continue
+		}
+		delete(h.clients, id)
+		expired = append(expired, client)
+
+		clients := h.userClients[client.UserID]
+		for i, c := range clients {
+			if c.ID == client.ID {
+				h.userClients[client.UserID] = append(clients[:i], clients[i+1:]...)
+				break
+			}
+		}
+		if len(h.userClients[client.UserID]) == 0 {
+			delete(h.userClients, client.UserID)
+		}
+
+		client.mu.RLock()
+		for channelID := range client.Subscribed {
+			if subscribers, ok := h.channels[channelID]; ok {
+				delete(subscribers, client.ID)
+				if len(subscribers) == 0 {
+					delete(h.channels, channelID)
+				}
+			}
+		}
+		client.mu.RUnlock()
+	}
+	h.mu.Unlock()
+
+	for _, client := range expired {
+		h.leaveMemberLists(client)
+	}
+}
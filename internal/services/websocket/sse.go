@@ -0,0 +1,273 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/middleware"
+	"github.com/zentra/server/internal/utils"
+)
+
+// eventStream is an SSE connection to a session. The client receives events
+// on it and sends ops with POST /ws/events/{sessionId}.
+type eventStream struct {
+	done chan struct{}
+	once sync.Once
+
+	// Set before done is closed: why the server ended the stream, sent to the
+	// client as a final close event
+	closeCode   int
+	closeReason string
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{done: make(chan struct{})}
+}
+
+func (s *eventStream) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return nil
+}
+
+// closeWith ends the stream telling the client why, like a WebSocket close
+// frame would
+func (s *eventStream) closeWith(code int, reason string) {
+	s.once.Do(func() {
+		s.closeCode = code
+		s.closeReason = reason
+		close(s.done)
+	})
+}
+
+// HandleEventStream serves gateway events over Server-Sent Events. Sessions
+// work as over the WebSocket: READY carries the session ID, events carry seq,
+// and a client resumes with ?resume=&seq= or, as EventSource does on its own
+// when reconnecting, the Last-Event-ID header. Events are always JSON.
+func (h *Handler) HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if encoding := r.URL.Query().Get("encoding"); encoding != "" && encoding != EncodingJSON {
+		utils.RespondError(w, http.StatusBadRequest, "Event streams only support JSON")
+		return
+	}
+
+	resume, seq := r.URL.Query().Get("resume"), r.URL.Query().Get("seq")
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		resume, seq, _ = strings.Cut(lastEventID, ":")
+	}
+	resumeID, lastSeq, ok := parseResume(w, resume, seq)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.RespondError(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Keep reverse proxies like nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	stream := newEventStream()
+	var client *Client
+	if resumeID != uuid.Nil {
+		resumed, err := h.hub.ResumeStream(resumeID, userID, lastSeq, stream)
+		if err == nil {
+			client = resumed
+		} else {
+			invalid, _ := newPayload(&Event{
+				Type: EventTypeInvalidSession,
+				Data: map[string]interface{}{
+					"sessionId": resumeID.String(),
+					"reason":    err.Error(),
+				},
+			})
+			writeStreamEvent(w, uuid.Nil, invalid.encode(EncodingJSON))
+			flusher.Flush()
+		}
+	}
+
+	if client == nil {
+		client = newClient(userID, EncodingJSON, h.hub)
+		client.stream = stream
+		h.hub.register <- client
+
+		client.SendEvent(&Event{
+			Type: EventTypeReady,
+			Data: map[string]interface{}{
+				"clientId":          client.ID.String(),
+				"userId":            userID.String(),
+				"sessionId":         client.ID.String(),
+				"heartbeatInterval": h.hub.heartbeatInterval.Milliseconds(),
+			},
+		})
+	}
+
+	client.streamPump(w, r, stream)
+}
+
+// streamPump writes queued events to the SSE response until the client goes
+// away or the stream is replaced or closed
+func (c *Client) streamPump(w http.ResponseWriter, r *http.Request, stream *eventStream) {
+	c.sendMu.Lock()
+	send := c.Send
+	c.sendMu.Unlock()
+
+	flusher := w.(http.Flusher)
+	control := http.NewResponseController(w)
+	ticker := time.NewTicker(c.Hub.heartbeatInterval)
+	defer func() {
+		ticker.Stop()
+		stream.Close()
+		c.Hub.unregister <- clientConn{client: c, conn: stream}
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case <-stream.done:
+			if stream.closeCode != 0 {
+				closing, _ := json.Marshal(map[string]interface{}{
+					"code":   stream.closeCode,
+					"reason": stream.closeReason,
+				})
+				control.SetWriteDeadline(time.Now().Add(writeWait))
+				io.WriteString(w, "event: close\ndata: "+string(closing)+"\n\n")
+				flusher.Flush()
+			}
+			return
+
+		case message, ok := <-send:
+			if !ok {
+				return
+			}
+			control.SetWriteDeadline(time.Now().Add(writeWait))
+			for _, data := range c.drainQueue(send, message) {
+				if err := writeStreamEvent(w, c.ID, data); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+
+		case <-ticker.C:
+			// Comments keep proxies from timing out an idle stream
+			control.SetWriteDeadline(time.Now().Add(writeWait))
+			if _, err := io.WriteString(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeStreamEvent writes one event. Sequenced events get an ID of
+// <sessionId>:<seq>, which EventSource sends back as Last-Event-ID when it
+// reconnects, resuming the session.
+func writeStreamEvent(w io.Writer, sessionID uuid.UUID, data []byte) error {
+	var frame bytes.Buffer
+	if seq, ok := eventSeq(data); ok && sessionID != uuid.Nil {
+		frame.WriteString("id: " + sessionID.String() + ":" + strconv.FormatInt(seq, 10) + "\n")
+	}
+	frame.WriteString("data: ")
+	frame.Write(data)
+	frame.WriteString("\n\n")
+	_, err := w.Write(frame.Bytes())
+	return err
+}
+
+// eventSeq reads the seq withSeq put at the start of a JSON event
+func eventSeq(data []byte) (int64, bool) {
+	const prefix = `{"seq":`
+	if !bytes.HasPrefix(data, []byte(prefix)) {
+		return 0, false
+	}
+	rest := data[len(prefix):]
+	end := bytes.IndexAny(rest, ",}")
+	if end < 0 {
+		return 0, false
+	}
+	seq, err := strconv.ParseInt(string(rest[:end]), 10, 64)
+	return seq, err == nil
+}
+
+// PostEventStreamOp takes an op ({type, data}, as sent over the WebSocket)
+// from a client on an event stream. Ops are rate limited the same way; a
+// client that breaks the limits has its stream closed. Sessions live on one
+// gateway instance, so load balancers must route these to the instance
+// holding the stream.
+func (h *Handler) PostEventStreamOp(w http.ResponseWriter, r *http.Request) {
+	userID, _ := middleware.GetUserID(r.Context())
+	sessionID, err := uuid.Parse(chi.URLParam(r, "sessionId"))
+	if err != nil {
+		utils.RespondError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	client, ok := h.hub.session(sessionID, userID)
+	if !ok {
+		utils.RespondErrorWithCode(w, http.StatusNotFound, "SESSION_NOT_FOUND", "Session not found")
+		return
+	}
+
+	message, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		abuseClosesTotal.Inc(closeCodeLabel(ClosePayloadTooLarge))
+		client.closeStream(closePayloadTooLarge)
+		utils.RespondErrorWithCode(w, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "Op too large")
+		return
+	}
+
+	if abuse := client.handleMessage(message); abuse != nil {
+		log.Warn().
+			Str("clientId", client.ID.String()).
+			Str("userId", client.UserID.String()).
+			Int("code", abuse.code).
+			Msg("Closing abusive event stream")
+		abuseClosesTotal.Inc(closeCodeLabel(abuse.code))
+		client.closeStream(abuse)
+		utils.RespondErrorWithCode(w, http.StatusTooManyRequests, "GATEWAY_CLOSED", abuse.reason)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// closeStream closes the session's event stream, if that's how it's
+// connected
+func (c *Client) closeStream(abuse *gatewayClose) {
+	c.sendMu.Lock()
+	stream := c.stream
+	c.sendMu.Unlock()
+
+	if stream != nil {
+		stream.closeWith(abuse.code, abuse.reason)
+	}
+}
+
+// session looks up one of userID's sessions on this instance
+func (h *Hub) session(sessionID, userID uuid.UUID) (*Client, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	client, ok := h.clients[sessionID]
+	if !ok || client.UserID != userID {
+		return nil, false
+	}
+	return client, true
+}
@@ -0,0 +1,210 @@
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/internal/services/community"
+	"github.com/zentra/server/internal/utils"
+	"github.com/zentra/server/pkg/database"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+const (
+	// How often gauges and event rates are refreshed
+	statsInterval = 10 * time.Second
+
+	// Communities listed in /ws/stats
+	statsTopCommunities = 20
+)
+
+var (
+	eventsTotal = metrics.NewCounter("zentra_gateway_events_total",
+		"Events built for gateway clients, by type.", "type")
+	sessionsGauge = metrics.NewGauge("zentra_gateway_sessions",
+		"Gateway sessions on this instance, by transport and whether a connection is attached.", "transport", "state")
+	usersGauge = metrics.NewGauge("zentra_gateway_connected_users",
+		"Users with at least one session on this instance.")
+	subscribedChannelsGauge = metrics.NewGauge("zentra_gateway_subscribed_channels",
+		"Channels and conversations with subscribers on this instance.")
+	memberListsGauge = metrics.NewGauge("zentra_gateway_member_lists",
+		"Member lists kept up to date on this instance.")
+	streamLagGauge = metrics.NewGauge("zentra_gateway_stream_lag_entries",
+		"Broadcast stream entries this instance hasn't read yet.")
+	streamPendingGauge = metrics.NewGauge("zentra_gateway_stream_pending_entries",
+		"Broadcast stream entries this instance read but hasn't acknowledged.")
+	streamDelayGauge = metrics.NewGauge("zentra_gateway_stream_delay_seconds",
+		"Time between publishing the last broadcast stream entry handled and handling it.")
+)
+
+// hubStats is what the last sample found, for /ws/stats
+type hubStats struct {
+	mu          sync.Mutex
+	sampledAt   time.Time
+	eventCounts map[string]float64
+	eventRates  map[string]float64
+	streamLag   int64
+	pending     int64
+	delay       time.Duration
+}
+
+type sessionCounts struct {
+	WebSocket int `json:"websocket"`
+	SSE       int `json:"sse"`
+	Detached  int `json:"detached"`
+}
+
+// countSessions tallies sessions by transport, plus connections per user
+func (h *Hub) countSessions() (sessionCounts, map[uuid.UUID]int) {
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	var counts sessionCounts
+	perUser := make(map[uuid.UUID]int)
+	for _, client := range clients {
+		client.sendMu.Lock()
+		attached, sse := client.attached, client.stream != nil
+		client.sendMu.Unlock()
+
+		switch {
+		case !attached:
+			counts.Detached++
+			continue
+		case sse:
+			counts.SSE++
+		default:
+			counts.WebSocket++
+		}
+		perUser[client.UserID]++
+	}
+	return counts, perUser
+}
+
+// sampleStats refreshes the gateway gauges and event rates
+func (h *Hub) sampleStats(ctx context.Context) {
+	counts, perUser := h.countSessions()
+	sessionsGauge.Set(float64(counts.WebSocket), "websocket", "attached")
+	sessionsGauge.Set(float64(counts.SSE), "sse", "attached")
+	sessionsGauge.Set(float64(counts.Detached), "any", "detached")
+	usersGauge.Set(float64(len(perUser)))
+
+	h.mu.RLock()
+	subscribedChannelsGauge.Set(float64(len(h.channels)))
+	h.mu.RUnlock()
+	h.memberListsMu.Lock()
+	memberListsGauge.Set(float64(len(h.memberLists)))
+	h.memberListsMu.Unlock()
+
+	var lag, pending int64
+	groups, err := h.redis.XInfoGroups(ctx, database.BroadcastStream).Result()
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to read broadcast stream groups")
+	}
+	for _, group := range groups {
+		if group.Name == broadcastGroupPrefix+h.instanceID {
+			lag, pending = group.Lag, group.Pending
+		}
+	}
+	streamLagGauge.Set(float64(lag))
+	streamPendingGauge.Set(float64(pending))
+
+	now := time.Now()
+	current := eventsTotal.Values()
+
+	h.stats.mu.Lock()
+	defer h.stats.mu.Unlock()
+	if !h.stats.sampledAt.IsZero() {
+		elapsed := now.Sub(h.stats.sampledAt).Seconds()
+		rates := make(map[string]float64, len(current))
+		for eventType, count := range current {
+			if delta := count - h.stats.eventCounts[eventType]; delta > 0 {
+				rates[eventType] = delta / elapsed
+			}
+		}
+		h.stats.eventRates = rates
+	}
+	h.stats.sampledAt = now
+	h.stats.eventCounts = current
+	h.stats.streamLag = lag
+	h.stats.pending = pending
+}
+
+// recordStreamDelay notes how long a broadcast stream entry took to reach
+// this instance, going by the publish time in its ID
+func (h *Hub) recordStreamDelay(entryID string) {
+	ms, _, _ := strings.Cut(entryID, "-")
+	published, err := strconv.ParseInt(ms, 10, 64)
+	if err != nil {
+		return
+	}
+	delay := time.Since(time.UnixMilli(published))
+	streamDelayGauge.Set(delay.Seconds())
+
+	h.stats.mu.Lock()
+	h.stats.delay = delay
+	h.stats.mu.Unlock()
+}
+
+// GetStats reports what this gateway instance is holding, for capacity
+// planning. Operators only; see Routes.
+func (h *Handler) GetStats(w http.ResponseWriter, r *http.Request) {
+	hub := h.hub
+	counts, perUser := hub.countSessions()
+
+	hub.mu.RLock()
+	channels := len(hub.channels)
+	hub.mu.RUnlock()
+	hub.memberListsMu.Lock()
+	memberLists := len(hub.memberLists)
+	hub.memberListsMu.Unlock()
+
+	var top []community.CommunityConnections
+	if hub.communityService != nil && len(perUser) > 0 {
+		var err error
+		top, err = hub.communityService.TopCommunitiesByConnections(r.Context(), perUser, statsTopCommunities)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to rank communities by connections")
+		}
+	}
+	if top == nil {
+		top = []community.CommunityConnections{}
+	}
+
+	hub.stats.mu.Lock()
+	rates := make(map[string]float64, len(hub.stats.eventRates))
+	for eventType, rate := range hub.stats.eventRates {
+		rates[eventType] = rate
+	}
+	stream := map[string]interface{}{
+		"lag":          hub.stats.streamLag,
+		"pending":      hub.stats.pending,
+		"delaySeconds": hub.stats.delay.Seconds(),
+	}
+	hub.stats.mu.Unlock()
+
+	utils.RespondSuccess(w, map[string]interface{}{
+		"instanceId":         hub.instanceID,
+		"uptimeSeconds":      int64(time.Since(hub.startedAt).Seconds()),
+		"sessions":           counts,
+		"users":              len(perUser),
+		"subscribedChannels": channels,
+		"memberLists":        memberLists,
+		"topCommunities":     top,
+		"eventsPerSecond":    rates,
+		"stream":             stream,
+		"backpressure": map[string]interface{}{
+			"policy":        hub.backpressure,
+			"droppedEvents": droppedEventsTotal.Value(string(hub.backpressure)),
+		},
+	})
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// List endpoints page with opaque cursors: a request takes ?cursor= and
+// ?limit=, and the response carries the cursor of the next page until there
+// are no more. Clients pass cursors back unchanged and must not build or
+// parse them; what's inside is up to each endpoint.
+
+// ErrInvalidCursor is returned for a cursor the endpoint didn't issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorResponse is a page of a cursor-paginated list
+type CursorResponse struct {
+	Data any `json:"data"`
+	// NextCursor fetches the page after this one; empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+	HasMore    bool   `json:"hasMore"`
+}
+
+// CursorParams is the page a client asked for
+type CursorParams struct {
+	Cursor string
+	Limit  int
+}
+
+// GetCursorParams reads ?cursor= and ?limit=. The limit falls back to
+// defaultLimit when missing or outside 1..maxLimit.
+func GetCursorParams(r *http.Request, defaultLimit, maxLimit int) CursorParams {
+	limit := GetQueryInt(r, "limit", defaultLimit)
+	if limit < 1 || limit > maxLimit {
+		limit = defaultLimit
+	}
+	return CursorParams{Cursor: r.URL.Query().Get("cursor"), Limit: limit}
+}
+
+// RespondCursorPage writes a page of a cursor-paginated list
+func RespondCursorPage(w http.ResponseWriter, data any, nextCursor string) {
+	RespondJSON(w, http.StatusOK, CursorResponse{Data: data, NextCursor: nextCursor, HasMore: nextCursor != ""})
+}
+
+// RespondInvalidCursor writes the 400 for ErrInvalidCursor
+func RespondInvalidCursor(w http.ResponseWriter) {
+	RespondErrorWithCode(w, http.StatusBadRequest, "INVALID_CURSOR", "Invalid cursor")
+}
+
+// EncodeCursor turns a position into an opaque cursor
+func EncodeCursor(position any) string {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor reads a cursor made by EncodeCursor into position
+func DecodeCursor(cursor string, position any) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, position); err != nil {
+		return ErrInvalidCursor
+	}
+	return nil
+}
+
+// KeysetCursor is the position after a row in a list ordered by a time and
+// then an ID, the shape most lists here page on
+type KeysetCursor struct {
+	Time time.Time `json:"t"`
+	ID   uuid.UUID `json:"id"`
+	// Sort is the order the cursor was issued for, for lists that have more
+	// than one; a cursor used with another order is rejected
+	Sort string `json:"s,omitempty"`
+}
+
+// DecodeKeysetCursor reads a KeysetCursor issued for sort. An empty cursor
+// means the first page and returns nil.
+func DecodeKeysetCursor(cursor, sort string) (*KeysetCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	var c KeysetCursor
+	if err := DecodeCursor(cursor, &c); err != nil {
+		return nil, err
+	}
+	if c.ID == uuid.Nil || c.Time.IsZero() || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// RankCursor is the position after a row in a list ordered by a number, such
+// as a count or a sequence number, and then an ID
+type RankCursor struct {
+	Rank int64     `json:"r"`
+	ID   uuid.UUID `json:"id"`
+}
+
+// DecodeRankCursor reads a RankCursor. An empty cursor means the first page
+// and returns nil.
+func DecodeRankCursor(cursor string) (*RankCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	var c RankCursor
+	if err := DecodeCursor(cursor, &c); err != nil {
+		return nil, err
+	}
+	if c.ID == uuid.Nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// MaxBatchIDs is how many IDs a batch-get request may ask for
+const MaxBatchIDs = 100
+
+// GetQueryIDs reads a comma-separated list of IDs, such as ?ids=a,b,c, for
+// batch endpoints. It fails on an invalid ID or more than max of them, and
+// drops duplicates.
+func GetQueryIDs(r *http.Request, key string, max int) ([]uuid.UUID, error) {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return nil, errors.New(key + " is required")
+	}
+
+	seen := make(map[uuid.UUID]bool)
+	var ids []uuid.UUID
+	start := 0
+	for i := 0; i <= len(raw); i++ {
+		if i < len(raw) && raw[i] != ',' {
+			continue
+		}
+		id, err := uuid.Parse(raw[start:i])
+		if err != nil {
+			return nil, errors.New("invalid ID in " + key + ": " + strconv.Quote(raw[start:i]))
+		}
+		start = i + 1
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+		if len(ids) > max {
+			return nil, errors.New("at most " + strconv.Itoa(max) + " IDs are allowed in " + key)
+		}
+	}
+	return ids, nil
+}
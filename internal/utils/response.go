@@ -2,8 +2,14 @@ package utils
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type ErrorResponse struct {
@@ -17,14 +23,6 @@ type SuccessResponse struct {
 	Message string `json:"message,omitempty"`
 }
 
-type PaginatedResponse struct {
-	Data       any   `json:"data"`
-	Total      int64 `json:"total"`
-	Page       int   `json:"page"`
-	PageSize   int   `json:"pageSize"`
-	TotalPages int   `json:"totalPages"`
-}
-
 // RespondJSON writes a JSON response
 func RespondJSON(w http.ResponseWriter, status int, data any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -81,6 +79,20 @@ func DefaultErrorCode(status int) string {
 	}
 }
 
+// RespondRateLimited writes a 429 with a Retry-After header and the wait in
+// the details, so clients can back off without parsing the header
+func RespondRateLimited(w http.ResponseWriter, code, message string, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	RespondJSON(w, http.StatusTooManyRequests, ErrorResponse{
+		Error:   message,
+		Code:    code,
+		Details: map[string]any{"retryAfterMs": retryAfter.Milliseconds()},
+	})
+}
+
 // RespondValidationError writes a validation error response
 func RespondValidationError(w http.ResponseWriter, details any) {
 	RespondJSON(w, http.StatusBadRequest, ErrorResponse{
@@ -105,22 +117,6 @@ func RespondNoContent(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// RespondPaginated writes a paginated response
-func RespondPaginated(w http.ResponseWriter, data any, total int64, page, pageSize int) {
-	totalPages := int(total) / pageSize
-	if int(total)%pageSize > 0 {
-		totalPages++
-	}
-
-	RespondJSON(w, http.StatusOK, PaginatedResponse{
-		Data:       data,
-		Total:      total,
-		Page:       page,
-		PageSize:   pageSize,
-		TotalPages: totalPages,
-	})
-}
-
 // DecodeJSON decodes a JSON request body
 func DecodeJSON(r *http.Request, v any) error {
 	decoder := json.NewDecoder(r.Body)
@@ -128,6 +124,83 @@ func DecodeJSON(r *http.Request, v any) error {
 	return decoder.Decode(v)
 }
 
+// BindJSON decodes the request body into v and validates its struct tags. On
+// failure it writes a 400 with field-level details and returns false, so
+// handlers only need to return.
+func BindJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	return bindJSON(w, r, v, false)
+}
+
+// BindOptionalJSON is BindJSON for endpoints whose body may be omitted. An
+// empty body leaves v at its zero value, which is still validated.
+func BindOptionalJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	return bindJSON(w, r, v, true)
+}
+
+func bindJSON(w http.ResponseWriter, r *http.Request, v any, optional bool) bool {
+	if err := DecodeJSON(r, v); err != nil && !(optional && errors.Is(err, io.EOF)) {
+		RespondJSON(w, http.StatusBadRequest, ErrorResponse{
+			Error:   "Invalid request body",
+			Code:    DefaultErrorCode(http.StatusBadRequest),
+			Details: decodeErrorDetails(err),
+		})
+		return false
+	}
+
+	if err := Validate(v); err != nil {
+		RespondValidationError(w, FormatValidationErrors(err))
+		return false
+	}
+	return true
+}
+
+// decodeErrorDetails explains a JSON decoding failure in the same shape as
+// validation errors where the failure can be pinned to a field.
+func decodeErrorDetails(err error) []FieldError {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var sizeErr *http.MaxBytesError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return []FieldError{{Rule: "required", Message: "Request body is required"}}
+	case errors.As(err, &typeErr):
+		return []FieldError{{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Param:   typeErr.Type.String(),
+			Message: fmt.Sprintf("Expected %s, got %s", jsonKind(typeErr.Type.String()), typeErr.Value),
+		}}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return []FieldError{{Rule: "syntax", Message: "Request body is not valid JSON"}}
+	case errors.As(err, &sizeErr):
+		return []FieldError{{Rule: "size", Message: "Request body is too large"}}
+	}
+
+	// encoding/json has no typed error for fields rejected by DisallowUnknownFields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return []FieldError{{Field: strings.Trim(field, `"`), Rule: "unknown", Message: "Unknown field"}}
+	}
+	return []FieldError{{Rule: "invalid", Message: "Request body could not be decoded"}}
+}
+
+// jsonKind names a Go type the way a client sending JSON would think of it
+func jsonKind(goType string) string {
+	switch {
+	case goType == "string", goType == "uuid.UUID", goType == "time.Time":
+		return "string"
+	case goType == "bool":
+		return "boolean"
+	case strings.HasPrefix(goType, "[]"):
+		return "array"
+	case strings.HasPrefix(goType, "map["):
+		return "object"
+	case strings.HasPrefix(goType, "int"), strings.HasPrefix(goType, "uint"), strings.HasPrefix(goType, "float"):
+		return "number"
+	}
+	return "object"
+}
+
 // GetQueryInt extracts an integer query parameter with a default value
 func GetQueryInt(r *http.Request, key string, defaultValue int) int {
 	val := r.URL.Query().Get(key)
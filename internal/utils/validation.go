@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"regexp"
 	"strings"
 	"unicode"
@@ -18,8 +21,25 @@ var (
 func init() {
 	validate = validator.New()
 
+	// Report fields by their JSON names so errors line up with request bodies
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return f.Name
+		}
+		return name
+	})
+
+	registerValidators(validate)
+}
+
+// registerValidators is the single place custom validation tags are defined
+func registerValidators(v *validator.Validate) {
 	// Custom validation for username
-	validate.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
 		username := fl.Field().String()
 		if len(username) < 3 || len(username) > 32 {
 			return false
@@ -28,7 +48,7 @@ func init() {
 	})
 
 	// Custom validation for channel names (lowercase, hyphens, numbers)
-	validate.RegisterValidation("channelname", func(fl validator.FieldLevel) bool {
+	v.RegisterValidation("channelname", func(fl validator.FieldLevel) bool {
 		name := fl.Field().String()
 		if len(name) < 1 || len(name) > 64 {
 			return false
@@ -37,7 +57,7 @@ func init() {
 	})
 
 	// Custom validation for password strength
-	validate.RegisterValidation("strongpassword", func(fl validator.FieldLevel) bool {
+	v.RegisterValidation("strongpassword", func(fl validator.FieldLevel) bool {
 		password := fl.Field().String()
 		if len(password) < 8 {
 			return false
@@ -57,40 +77,104 @@ func init() {
 	})
 }
 
+// FieldError describes why a single request field was rejected. Field is the
+// JSON path of the value, e.g. "attachments[0].filename".
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
 // Validate validates a struct using the validator
 func Validate(s any) error {
 	return validate.Struct(s)
 }
 
 // FormatValidationErrors formats validation errors for API response
-func FormatValidationErrors(err error) map[string]string {
-	errors := make(map[string]string)
-
-	if validationErrors, ok := err.(validator.ValidationErrors); ok {
-		for _, e := range validationErrors {
-			field := strings.ToLower(e.Field())
-			switch e.Tag() {
-			case "required":
-				errors[field] = "This field is required"
-			case "email":
-				errors[field] = "Invalid email format"
-			case "min":
-				errors[field] = "Value is too short"
-			case "max":
-				errors[field] = "Value is too long"
-			case "username":
-				errors[field] = "Username must be 3-32 characters and contain only letters, numbers, underscores, or hyphens"
-			case "channelname":
-				errors[field] = "Channel name must contain only lowercase letters, numbers, and hyphens"
-			case "strongpassword":
-				errors[field] = "Password must be at least 8 characters with uppercase, lowercase, and numbers"
-			default:
-				errors[field] = "Invalid value"
-			}
-		}
+func FormatValidationErrors(err error) []FieldError {
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return []FieldError{{Rule: "invalid", Message: "Invalid value"}}
+	}
+
+	fields := make([]FieldError, 0, len(validationErrors))
+	for _, e := range validationErrors {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(e.Namespace()),
+			Rule:    e.Tag(),
+			Param:   e.Param(),
+			Message: validationMessage(e),
+		})
 	}
+	return fields
+}
+
+// fieldPath drops the struct name the validator puts in front of a namespace
+func fieldPath(namespace string) string {
+	if _, path, ok := strings.Cut(namespace, "."); ok {
+		return path
+	}
+	return namespace
+}
 
-	return errors
+func validationMessage(e validator.FieldError) string {
+	isString := e.Kind() == reflect.String
+	isList := e.Kind() == reflect.Slice || e.Kind() == reflect.Array || e.Kind() == reflect.Map
+
+	switch e.Tag() {
+	case "required", "required_if", "required_unless", "required_with", "required_without":
+		return "This field is required"
+	case "excluded_with", "excluded_without":
+		return "This field is not allowed here"
+	case "email":
+		return "Invalid email format"
+	case "url", "http_url":
+		return "Invalid URL"
+	case "uuid", "uuid4":
+		return "Invalid ID"
+	case "hexcolor":
+		return "Invalid hex color"
+	case "datetime":
+		return "Invalid date, expected format " + e.Param()
+	case "oneof":
+		return "Must be one of: " + strings.Join(strings.Fields(e.Param()), ", ")
+	case "min", "gte":
+		switch {
+		case isString:
+			return fmt.Sprintf("Must be at least %s characters", e.Param())
+		case isList:
+			return fmt.Sprintf("Must contain at least %s items", e.Param())
+		}
+		return "Must be at least " + e.Param()
+	case "max", "lte":
+		switch {
+		case isString:
+			return fmt.Sprintf("Must be at most %s characters", e.Param())
+		case isList:
+			return fmt.Sprintf("Must contain at most %s items", e.Param())
+		}
+		return "Must be at most " + e.Param()
+	case "len":
+		if isString {
+			return fmt.Sprintf("Must be exactly %s characters", e.Param())
+		}
+		return fmt.Sprintf("Must contain exactly %s items", e.Param())
+	case "gt":
+		return "Must be greater than " + e.Param()
+	case "lt":
+		return "Must be less than " + e.Param()
+	case "unique":
+		return "Must not contain duplicates"
+	case "username":
+		return "Username must be 3-32 characters and contain only letters, numbers, underscores, or hyphens"
+	case "channelname":
+		return "Channel name must contain only lowercase letters, numbers, and hyphens"
+	case "strongpassword":
+		return "Password must be at least 8 characters with uppercase, lowercase, and numbers"
+	default:
+		return "Invalid value"
+	}
 }
 
 // SanitizeString removes potentially dangerous characters from a string
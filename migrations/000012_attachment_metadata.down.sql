@@ -0,0 +1,6 @@
+-- Migration: 000012_attachment_metadata
+-- Description: Remove spoiler flag and alt text from message attachments
+
+ALTER TABLE message_attachments
+DROP COLUMN IF EXISTS description,
+DROP COLUMN IF EXISTS is_spoiler;
@@ -0,0 +1,6 @@
+-- Migration: 000012_attachment_metadata
+-- Description: Add spoiler flag and alt text to message attachments
+
+ALTER TABLE message_attachments
+ADD COLUMN IF NOT EXISTS is_spoiler BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN IF NOT EXISTS description VARCHAR(1024);
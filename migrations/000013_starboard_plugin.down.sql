@@ -0,0 +1,14 @@
+-- Migration: 000013_starboard_plugin
+-- Description: Remove the starboard plugin and its highlight tracking
+
+DROP INDEX IF EXISTS idx_starboard_entries_highlight_channel;
+DROP INDEX IF EXISTS idx_starboard_entries_community;
+DROP TABLE IF EXISTS starboard_entries;
+
+DELETE FROM plugins WHERE slug = 'starboard';
+
+-- Highlights stay in the channel history, so the author row is left in place
+-- when messages still reference it.
+DELETE FROM users
+WHERE id = '5ba4b0a4-57a4-4b0a-8d00-000000000001'
+  AND NOT EXISTS (SELECT 1 FROM messages WHERE author_id = '5ba4b0a4-57a4-4b0a-8d00-000000000001');
@@ -0,0 +1,52 @@
+-- Migration: 000013_starboard_plugin
+-- Description: Seed the official starboard plugin and track highlighted messages
+
+-- System account the starboard posts highlights as. The password hash is not a
+-- valid bcrypt hash so nobody can ever log in with it.
+INSERT INTO users (id, username, email, password_hash, display_name, status, email_verified)
+VALUES (
+    '5ba4b0a4-57a4-4b0a-8d00-000000000001',
+    'sys_starboard',
+    'starboard@system.zentra.local',
+    '!',
+    'Starboard',
+    'offline',
+    TRUE
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO plugins (slug, name, description, author, version, requested_permissions, manifest, built_in, source, is_verified)
+VALUES (
+    'starboard',
+    'Starboard',
+    'Reposts messages that collect enough reactions into a highlight channel. Configure channelId, emoji and threshold after installing.',
+    'Zentra',
+    '1.0.0',
+    -- read messages | send messages
+    3,
+    '{
+        "channelTypes": [],
+        "commands": [],
+        "triggers": ["REACTION_ADD", "REACTION_REMOVE"],
+        "hooks": ["reaction.add", "reaction.remove"]
+    }'::JSONB,
+    FALSE,
+    'official',
+    TRUE
+) ON CONFLICT (slug) DO NOTHING;
+
+-- One row per message that made it onto a community's starboard
+CREATE TABLE IF NOT EXISTS starboard_entries (
+    source_message_id UUID PRIMARY KEY,
+    source_channel_id UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    highlight_channel_id UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    highlight_message_id UUID NOT NULL,
+    -- messages is partitioned, so updates need the created_at too
+    highlight_created_at TIMESTAMPTZ NOT NULL,
+    reaction_count INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_starboard_entries_community ON starboard_entries(community_id);
+CREATE INDEX IF NOT EXISTS idx_starboard_entries_highlight_channel ON starboard_entries(highlight_channel_id);
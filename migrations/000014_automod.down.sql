@@ -0,0 +1,8 @@
+-- Migration: 000014_automod
+-- Description: Remove AutoMod rules and trigger log
+
+DROP TRIGGER IF EXISTS update_automod_rules_updated_at ON automod_rules;
+DROP INDEX IF EXISTS idx_automod_log_community;
+DROP INDEX IF EXISTS idx_automod_rules_community;
+DROP TABLE IF EXISTS automod_log;
+DROP TABLE IF EXISTS automod_rules;
@@ -0,0 +1,43 @@
+-- Migration: 000014_automod
+-- Description: Add per-community AutoMod rules and a log of rule triggers
+
+CREATE TABLE IF NOT EXISTS automod_rules (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    trigger_type VARCHAR(32) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    keywords TEXT[] NOT NULL DEFAULT '{}',
+    patterns TEXT[] NOT NULL DEFAULT '{}',
+    mention_limit INTEGER NOT NULL DEFAULT 0,
+    exempt_role_ids UUID[] NOT NULL DEFAULT '{}',
+    exempt_channel_ids UUID[] NOT NULL DEFAULT '{}',
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by UUID NOT NULL REFERENCES users(id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_automod_rules_community ON automod_rules(community_id) WHERE enabled = TRUE;
+
+-- rule_name is copied so the log still reads well after a rule is deleted
+CREATE TABLE IF NOT EXISTS automod_log (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    rule_id UUID REFERENCES automod_rules(id) ON DELETE SET NULL,
+    rule_name VARCHAR(100) NOT NULL,
+    channel_id UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    message_id UUID,
+    trigger_type VARCHAR(32) NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    matched VARCHAR(256) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_automod_log_community ON automod_log(community_id, created_at DESC);
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_automod_rules_updated_at') THEN
+    CREATE TRIGGER update_automod_rules_updated_at BEFORE UPDATE ON automod_rules
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
@@ -0,0 +1,11 @@
+-- Migration: 000015_community_folders
+-- Description: Remove community folders and sidebar ordering
+
+DROP INDEX IF EXISTS idx_community_members_folder;
+
+ALTER TABLE community_members
+DROP COLUMN IF EXISTS sidebar_position,
+DROP COLUMN IF EXISTS folder_id;
+
+DROP TRIGGER IF EXISTS update_community_folders_updated_at ON community_folders;
+DROP TABLE IF EXISTS community_folders;
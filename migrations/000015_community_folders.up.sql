@@ -0,0 +1,27 @@
+-- Migration: 000015_community_folders
+-- Description: Per-user community folders and sidebar ordering
+
+CREATE TABLE IF NOT EXISTS community_folders (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    color VARCHAR(7),
+    -- position among the user's top-level sidebar items (folders and loose communities)
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_community_folders_user ON community_folders(user_id);
+
+-- sidebar_position is relative to the folder when folder_id is set, otherwise to the top level
+ALTER TABLE community_members
+ADD COLUMN IF NOT EXISTS folder_id UUID REFERENCES community_folders(id) ON DELETE SET NULL,
+ADD COLUMN IF NOT EXISTS sidebar_position INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_community_members_folder ON community_members(folder_id) WHERE folder_id IS NOT NULL;
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_community_folders_updated_at') THEN
+    CREATE TRIGGER update_community_folders_updated_at BEFORE UPDATE ON community_folders
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
@@ -0,0 +1,5 @@
+-- Migration: 000016_spam_protection
+-- Description: Remove spam and raid protection settings
+
+DROP TRIGGER IF EXISTS update_community_spam_settings_updated_at ON community_spam_settings;
+DROP TABLE IF EXISTS community_spam_settings;
@@ -0,0 +1,27 @@
+-- Migration: 000016_spam_protection
+-- Description: Add per-community spam and raid protection settings, verification levels and raid mode state
+
+CREATE TABLE IF NOT EXISTS community_spam_settings (
+    community_id UUID PRIMARY KEY REFERENCES communities(id) ON DELETE CASCADE,
+    verification_level SMALLINT NOT NULL DEFAULT 0,
+    burst_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    burst_messages INTEGER NOT NULL DEFAULT 5,
+    burst_window_seconds INTEGER NOT NULL DEFAULT 5,
+    duplicate_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    duplicate_threshold INTEGER NOT NULL DEFAULT 3,
+    duplicate_window_seconds INTEGER NOT NULL DEFAULT 60,
+    join_spike_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    join_spike_threshold INTEGER NOT NULL DEFAULT 10,
+    join_spike_window_seconds INTEGER NOT NULL DEFAULT 60,
+    raid_auto_enable BOOLEAN NOT NULL DEFAULT TRUE,
+    raid_verification_level SMALLINT NOT NULL DEFAULT 2,
+    raid_duration_minutes INTEGER NOT NULL DEFAULT 30,
+    raid_mode_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_community_spam_settings_updated_at') THEN
+    CREATE TRIGGER update_community_spam_settings_updated_at BEFORE UPDATE ON community_spam_settings
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
@@ -0,0 +1,6 @@
+-- Migration: 000017_read_states
+-- Description: Remove channel read state and interaction timestamps
+
+ALTER TABLE dm_participants DROP COLUMN IF EXISTS last_interaction_at;
+DROP INDEX IF EXISTS idx_channel_read_states_recent;
+DROP TABLE IF EXISTS channel_read_states;
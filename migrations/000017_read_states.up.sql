@@ -0,0 +1,18 @@
+-- Migration: 000017_read_states
+-- Description: Track per-user channel read state and "last interaction by me" for channels and DMs
+
+CREATE TABLE IF NOT EXISTS channel_read_states (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel_id UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    last_read_message_id UUID,
+    last_read_at TIMESTAMPTZ,
+    last_interaction_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, channel_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_channel_read_states_recent ON channel_read_states(user_id, last_interaction_at DESC);
+
+ALTER TABLE dm_participants ADD COLUMN IF NOT EXISTS last_interaction_at TIMESTAMPTZ;
+
+-- Reading a conversation was the only interaction tracked so far
+UPDATE dm_participants SET last_interaction_at = last_read_at WHERE last_interaction_at IS NULL;
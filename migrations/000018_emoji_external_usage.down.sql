@@ -0,0 +1,5 @@
+-- Migration: 000018_emoji_external_usage
+-- Description: Remove emoji external usage flag and autocomplete index
+
+DROP INDEX IF EXISTS idx_custom_emojis_name_prefix;
+ALTER TABLE custom_emojis DROP COLUMN IF EXISTS allow_external;
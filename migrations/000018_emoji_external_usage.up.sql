@@ -0,0 +1,6 @@
+-- Migration: 000018_emoji_external_usage
+-- Description: Let communities keep emojis from being used outside the community, and index names for autocomplete
+
+ALTER TABLE custom_emojis ADD COLUMN IF NOT EXISTS allow_external BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE INDEX IF NOT EXISTS idx_custom_emojis_name_prefix ON custom_emojis(LOWER(name) text_pattern_ops);
@@ -0,0 +1,7 @@
+-- Migration: 000019_moderation_cases
+-- Description: Remove moderation cases and member timeouts
+
+DROP TRIGGER IF EXISTS update_moderation_cases_updated_at ON moderation_cases;
+DROP TABLE IF EXISTS moderation_cases;
+ALTER TABLE community_members DROP COLUMN IF EXISTS timeout_until;
+ALTER TABLE communities DROP COLUMN IF EXISTS moderation_case_count;
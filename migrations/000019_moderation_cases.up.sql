@@ -0,0 +1,29 @@
+-- Migration: 000019_moderation_cases
+-- Description: Add numbered moderation cases (warn, timeout, kick, ban) and member timeouts
+
+ALTER TABLE communities ADD COLUMN IF NOT EXISTS moderation_case_count INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE community_members ADD COLUMN IF NOT EXISTS timeout_until TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS moderation_cases (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    case_number INTEGER NOT NULL,
+    action VARCHAR(16) NOT NULL CHECK (action IN ('warn', 'timeout', 'kick', 'ban')),
+    target_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    moderator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT,
+    evidence JSONB NOT NULL DEFAULT '[]',
+    duration_seconds INTEGER,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (community_id, case_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_cases_target ON moderation_cases(community_id, target_id, created_at DESC);
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_moderation_cases_updated_at') THEN
+    CREATE TRIGGER update_moderation_cases_updated_at BEFORE UPDATE ON moderation_cases
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
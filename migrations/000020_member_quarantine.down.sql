@@ -0,0 +1,8 @@
+-- Migration: 000020_member_quarantine
+-- Description: Remove member quarantine
+
+DROP INDEX IF EXISTS idx_community_members_quarantined;
+ALTER TABLE messages DROP COLUMN IF EXISTS is_quarantined;
+ALTER TABLE community_members DROP COLUMN IF EXISTS quarantine_reason;
+ALTER TABLE community_members DROP COLUMN IF EXISTS quarantined_by;
+ALTER TABLE community_members DROP COLUMN IF EXISTS quarantined_at;
@@ -0,0 +1,10 @@
+-- Migration: 000020_member_quarantine
+-- Description: Add per-community quarantine (shadow ban) for members and hide their messages from everyone but moderators
+
+ALTER TABLE community_members ADD COLUMN IF NOT EXISTS quarantined_at TIMESTAMPTZ;
+ALTER TABLE community_members ADD COLUMN IF NOT EXISTS quarantined_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE community_members ADD COLUMN IF NOT EXISTS quarantine_reason TEXT;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS is_quarantined BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_community_members_quarantined ON community_members(community_id) WHERE quarantined_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Migration: 000021_event_hooks
+-- Description: Remove outgoing event hooks
+
+DROP TABLE IF EXISTS event_hook_deliveries;
+DROP TABLE IF EXISTS event_hooks;
@@ -0,0 +1,41 @@
+-- Migration: 000021_event_hooks
+-- Description: Add outgoing event hooks that deliver community events to external HTTPS endpoints
+
+CREATE TABLE IF NOT EXISTS event_hooks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    encrypted_secret BYTEA NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_reason TEXT,
+    last_delivery_at TIMESTAMPTZ,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_hooks_community ON event_hooks(community_id) WHERE is_active;
+
+CREATE TABLE IF NOT EXISTS event_hook_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    hook_id UUID NOT NULL REFERENCES event_hooks(id) ON DELETE CASCADE,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_event_hook_deliveries_due ON event_hook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_event_hook_deliveries_hook ON event_hook_deliveries(hook_id, created_at DESC);
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_event_hooks_updated_at') THEN
+    CREATE TRIGGER update_event_hooks_updated_at BEFORE UPDATE ON event_hooks
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
@@ -0,0 +1,4 @@
+-- Migration: 000022_community_api_tokens
+-- Description: Remove community-scoped API tokens
+
+DROP TABLE IF EXISTS community_api_tokens;
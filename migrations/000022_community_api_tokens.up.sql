@@ -0,0 +1,28 @@
+-- Migration: 000022_community_api_tokens
+-- Description: Add community-scoped API tokens for automation without a full bot account
+
+CREATE TABLE IF NOT EXISTS community_api_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    bot_user_id UUID NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+    name VARCHAR(80) NOT NULL,
+    token_hash VARCHAR(128) NOT NULL,
+    token_preview VARCHAR(24) NOT NULL,
+    scopes BIGINT NOT NULL DEFAULT 0,
+    channel_ids UUID[] NOT NULL DEFAULT '{}',
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_community_api_tokens_hash ON community_api_tokens(token_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_community_api_tokens_bot_user_id ON community_api_tokens(bot_user_id);
+CREATE INDEX IF NOT EXISTS idx_community_api_tokens_community ON community_api_tokens(community_id) WHERE revoked_at IS NULL;
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_community_api_tokens_updated_at') THEN
+    CREATE TRIGGER update_community_api_tokens_updated_at BEFORE UPDATE ON community_api_tokens
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
@@ -0,0 +1,13 @@
+-- Migration: 000023_community_broadcasts
+-- Description: Remove community announcement DMs
+
+DROP TABLE IF EXISTS community_broadcast_recipients;
+DROP TABLE IF EXISTS community_broadcasts;
+
+DELETE FROM dm_conversations WHERE broadcast_sender_id IS NOT NULL;
+DROP INDEX IF EXISTS idx_dm_conversations_broadcast;
+ALTER TABLE dm_conversations DROP COLUMN IF EXISTS broadcast_sender_id;
+ALTER TABLE dm_conversations DROP COLUMN IF EXISTS community_id;
+
+ALTER TABLE communities DROP COLUMN IF EXISTS broadcast_user_id;
+ALTER TABLE community_members DROP COLUMN IF EXISTS announcement_dms;
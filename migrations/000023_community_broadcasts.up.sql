@@ -0,0 +1,46 @@
+-- Migration: 000023_community_broadcasts
+-- Description: Add one-way announcement DMs from a community to members who opted in
+
+ALTER TABLE community_members ADD COLUMN IF NOT EXISTS announcement_dms BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Bot user that announcement DMs of a community are sent as
+ALTER TABLE communities ADD COLUMN IF NOT EXISTS broadcast_user_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+-- Announcement conversations only accept messages from their broadcast sender
+ALTER TABLE dm_conversations ADD COLUMN IF NOT EXISTS community_id UUID REFERENCES communities(id) ON DELETE CASCADE;
+ALTER TABLE dm_conversations ADD COLUMN IF NOT EXISTS broadcast_sender_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_dm_conversations_broadcast ON dm_conversations(community_id, broadcast_sender_id) WHERE broadcast_sender_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS community_broadcasts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    author_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    encrypted_content BYTEA NOT NULL,
+    nonce BYTEA NOT NULL,
+    link_previews JSONB NOT NULL DEFAULT '[]'::jsonb,
+    status VARCHAR(16) NOT NULL DEFAULT 'sending' CHECK (status IN ('sending', 'completed', 'cancelled')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_community_broadcasts_community ON community_broadcasts(community_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS community_broadcast_recipients (
+    broadcast_id UUID NOT NULL REFERENCES community_broadcasts(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'sent', 'failed', 'skipped')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ,
+    PRIMARY KEY (broadcast_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_community_broadcast_recipients_due ON community_broadcast_recipients(next_attempt_at) WHERE status = 'pending';
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_community_broadcasts_updated_at') THEN
+    CREATE TRIGGER update_community_broadcasts_updated_at BEFORE UPDATE ON community_broadcasts
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
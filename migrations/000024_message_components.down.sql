@@ -0,0 +1,6 @@
+-- Migration: 000024_message_components
+-- Description: Remove message components and interactions
+
+DROP TABLE IF EXISTS message_interactions;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS components;
@@ -0,0 +1,25 @@
+-- Migration: 000024_message_components
+-- Description: Add buttons and select menus to bot messages and the interactions they create
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS components JSONB;
+
+CREATE TABLE IF NOT EXISTS message_interactions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    message_id UUID NOT NULL,
+    channel_id UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    api_token_id UUID NOT NULL REFERENCES community_api_tokens(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    custom_id VARCHAR(100) NOT NULL,
+    component_type VARCHAR(16) NOT NULL,
+    selected_values TEXT[] NOT NULL DEFAULT '{}',
+    token_hash VARCHAR(128),
+    delivered_at TIMESTAMPTZ,
+    responded_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_interactions_inbox ON message_interactions(api_token_id, created_at) WHERE delivered_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_message_interactions_token ON message_interactions(token_hash) WHERE token_hash IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_message_interactions_expires ON message_interactions(expires_at);
@@ -0,0 +1,7 @@
+-- Migration: 000025_oauth
+-- Description: Remove the OAuth2 authorization server
+
+DROP TABLE IF EXISTS oauth_tokens;
+DROP TABLE IF EXISTS oauth_codes;
+DROP TABLE IF EXISTS oauth_authorizations;
+DROP TABLE IF EXISTS oauth_apps;
@@ -0,0 +1,73 @@
+-- Migration: 000025_oauth
+-- Description: Add an OAuth2 authorization server so third-party apps can act on behalf of users
+
+CREATE TABLE IF NOT EXISTS oauth_apps (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(80) NOT NULL,
+    description TEXT,
+    icon_url TEXT,
+    website_url TEXT,
+    redirect_uris TEXT[] NOT NULL DEFAULT '{}',
+    is_public BOOLEAN NOT NULL DEFAULT FALSE,
+    client_secret_hash VARCHAR(128),
+    client_secret_preview VARCHAR(24),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_apps_owner ON oauth_apps(owner_id);
+
+-- Scopes a user has consented to per app
+CREATE TABLE IF NOT EXISTS oauth_authorizations (
+    app_id UUID NOT NULL REFERENCES oauth_apps(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (app_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_authorizations_user ON oauth_authorizations(user_id);
+
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    code_hash VARCHAR(128) PRIMARY KEY,
+    app_id UUID NOT NULL REFERENCES oauth_apps(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    code_challenge VARCHAR(128) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_oauth_codes_expires ON oauth_codes(expires_at);
+
+CREATE TABLE IF NOT EXISTS oauth_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    app_id UUID NOT NULL REFERENCES oauth_apps(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    access_token_hash VARCHAR(128) NOT NULL,
+    refresh_token_hash VARCHAR(128) NOT NULL,
+    scopes TEXT[] NOT NULL DEFAULT '{}',
+    access_expires_at TIMESTAMPTZ NOT NULL,
+    refresh_expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oauth_tokens_access ON oauth_tokens(access_token_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_oauth_tokens_refresh ON oauth_tokens(refresh_token_hash);
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_app_user ON oauth_tokens(app_id, user_id) WHERE revoked_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_oauth_tokens_refresh_expires ON oauth_tokens(refresh_expires_at);
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_oauth_apps_updated_at') THEN
+    CREATE TRIGGER update_oauth_apps_updated_at BEFORE UPDATE ON oauth_apps
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
+
+DO $$ BEGIN IF NOT EXISTS (SELECT 1 FROM pg_trigger WHERE tgname = 'update_oauth_authorizations_updated_at') THEN
+    CREATE TRIGGER update_oauth_authorizations_updated_at BEFORE UPDATE ON oauth_authorizations
+        FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+END IF; END $$;
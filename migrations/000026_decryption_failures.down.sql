@@ -0,0 +1,4 @@
+-- Migration: 000026_decryption_failures
+-- Description: Remove decryption failure tracking
+
+DROP TABLE IF EXISTS decryption_failures;
@@ -0,0 +1,19 @@
+-- Migration: 000026_decryption_failures
+-- Description: Track stored content that failed to decrypt so operators can find and repair it
+
+CREATE TABLE IF NOT EXISTS decryption_failures (
+    kind VARCHAR(16) NOT NULL,
+    content_id UUID NOT NULL,
+    container_id UUID NOT NULL,
+    key_version VARCHAR(32) NOT NULL,
+    error TEXT NOT NULL,
+    occurrences INTEGER NOT NULL DEFAULT 1,
+    unrecoverable BOOLEAN NOT NULL DEFAULT FALSE,
+    first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    repair_attempted_at TIMESTAMPTZ,
+    PRIMARY KEY (kind, content_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_decryption_failures_container ON decryption_failures(kind, container_id);
+CREATE INDEX IF NOT EXISTS idx_decryption_failures_pending ON decryption_failures(last_seen_at DESC) WHERE NOT unrecoverable;
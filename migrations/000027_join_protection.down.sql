@@ -0,0 +1,15 @@
+-- Migration: 000027_join_protection
+-- Description: Remove join velocity limits, IP clustering and the moderation alert queue
+
+DROP TABLE IF EXISTS moderation_alerts;
+
+ALTER TABLE community_spam_settings
+    DROP COLUMN IF EXISTS raid_pause_invites,
+    DROP COLUMN IF EXISTS join_limit_enabled,
+    DROP COLUMN IF EXISTS community_join_limit,
+    DROP COLUMN IF EXISTS community_join_window_seconds,
+    DROP COLUMN IF EXISTS invite_join_limit,
+    DROP COLUMN IF EXISTS invite_join_window_seconds,
+    DROP COLUMN IF EXISTS ip_cluster_enabled,
+    DROP COLUMN IF EXISTS ip_cluster_threshold,
+    DROP COLUMN IF EXISTS ip_cluster_window_seconds;
@@ -0,0 +1,31 @@
+-- Migration: 000027_join_protection
+-- Description: Add join velocity limits, IP clustering, invite pausing during raid mode and a moderation alert queue
+
+ALTER TABLE community_spam_settings
+    ADD COLUMN IF NOT EXISTS raid_pause_invites BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN IF NOT EXISTS join_limit_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN IF NOT EXISTS community_join_limit INTEGER NOT NULL DEFAULT 30,
+    ADD COLUMN IF NOT EXISTS community_join_window_seconds INTEGER NOT NULL DEFAULT 60,
+    ADD COLUMN IF NOT EXISTS invite_join_limit INTEGER NOT NULL DEFAULT 10,
+    ADD COLUMN IF NOT EXISTS invite_join_window_seconds INTEGER NOT NULL DEFAULT 60,
+    ADD COLUMN IF NOT EXISTS ip_cluster_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN IF NOT EXISTS ip_cluster_threshold INTEGER NOT NULL DEFAULT 3,
+    ADD COLUMN IF NOT EXISTS ip_cluster_window_seconds INTEGER NOT NULL DEFAULT 3600;
+
+-- Automated alerts moderators work through
+CREATE TABLE IF NOT EXISTS moderation_alerts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    kind VARCHAR(32) NOT NULL,
+    title VARCHAR(200) NOT NULL,
+    body TEXT NOT NULL,
+    channel_id UUID REFERENCES channels(id) ON DELETE SET NULL,
+    user_ids UUID[] NOT NULL DEFAULT '{}',
+    metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    status VARCHAR(16) NOT NULL DEFAULT 'open',
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    resolved_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_alerts_community ON moderation_alerts(community_id, status, created_at DESC);
@@ -0,0 +1,4 @@
+-- Migration: 000028_community_lockdown
+-- Description: Remove community lockdowns
+
+DROP TABLE IF EXISTS community_lockdowns;
@@ -0,0 +1,22 @@
+-- Migration: 000028_community_lockdown
+-- Description: Add the community lockdown (panic mode) state and the settings it restores
+
+CREATE TABLE IF NOT EXISTS community_lockdowns (
+    community_id UUID PRIMARY KEY REFERENCES communities(id) ON DELETE CASCADE,
+    state VARCHAR(16) NOT NULL DEFAULT 'inactive',
+    stop_joins BOOLEAN NOT NULL DEFAULT TRUE,
+    slowmode_seconds INTEGER NOT NULL DEFAULT 0,
+    min_role_position INTEGER,
+    silence_everyone BOOLEAN NOT NULL DEFAULT TRUE,
+    reason TEXT,
+    -- What the lockdown changed, so lifting it only undoes its own edits
+    snapshot JSONB NOT NULL DEFAULT '{}'::jsonb,
+    enabled_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    enabled_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    disabled_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    disabled_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_community_lockdowns_expiry ON community_lockdowns(expires_at) WHERE state = 'active';
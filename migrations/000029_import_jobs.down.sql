@@ -0,0 +1,4 @@
+-- Migration: 000029_import_jobs
+-- Description: Remove archive import jobs
+
+DROP TABLE IF EXISTS import_jobs;
@@ -0,0 +1,35 @@
+-- Migration: 000029_import_jobs
+-- Description: Add resumable Discord and Slack archive import jobs
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source VARCHAR(16) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'awaiting_upload',
+    community_name VARCHAR(100),
+    community_id UUID REFERENCES communities(id) ON DELETE SET NULL,
+    archive_object TEXT NOT NULL,
+    archive_size BIGINT,
+    -- Encrypted platform token used to download attachments the archive links to
+    credentials TEXT,
+    -- Checkpoint: channels finished and messages done in the current channel
+    channel_index INTEGER NOT NULL DEFAULT 0,
+    message_index INTEGER NOT NULL DEFAULT 0,
+    total_channels INTEGER NOT NULL DEFAULT 0,
+    total_messages INTEGER NOT NULL DEFAULT 0,
+    imported_messages INTEGER NOT NULL DEFAULT 0,
+    skipped_messages INTEGER NOT NULL DEFAULT 0,
+    imported_attachments INTEGER NOT NULL DEFAULT 0,
+    failed_attachments INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    lease_owner UUID,
+    lease_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_owner ON import_jobs(owner_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_import_jobs_pending ON import_jobs(created_at) WHERE status IN ('queued', 'running');
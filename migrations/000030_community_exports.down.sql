@@ -0,0 +1,4 @@
+-- Migration: 000030_community_exports
+-- Description: Remove community exports
+
+DROP TABLE IF EXISTS community_exports;
@@ -0,0 +1,30 @@
+-- Migration: 000030_community_exports
+-- Description: Add asynchronous community exports to a portable JSON and media archive
+
+CREATE TABLE IF NOT EXISTS community_exports (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    requested_by UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(16) NOT NULL DEFAULT 'queued',
+    include_media BOOLEAN NOT NULL DEFAULT TRUE,
+    archive_object TEXT NOT NULL,
+    archive_size BIGINT,
+    channel_count INTEGER NOT NULL DEFAULT 0,
+    message_count INTEGER NOT NULL DEFAULT 0,
+    attachment_count INTEGER NOT NULL DEFAULT 0,
+    failed_media_count INTEGER NOT NULL DEFAULT 0,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    lease_owner UUID,
+    lease_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    -- The archive is deleted after this
+    expires_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_community_exports_community ON community_exports(community_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_community_exports_pending ON community_exports(created_at) WHERE status IN ('queued', 'running');
+CREATE INDEX IF NOT EXISTS idx_community_exports_expires ON community_exports(expires_at) WHERE status = 'completed';
@@ -0,0 +1,6 @@
+-- Migration: 000031_plugin_managed_channels
+-- Description: Remove plugin-managed and archived channel markers
+
+DROP INDEX IF EXISTS idx_channels_managed_by_plugin;
+ALTER TABLE channels DROP COLUMN IF EXISTS archived_at;
+ALTER TABLE channels DROP COLUMN IF EXISTS managed_by_plugin;
@@ -0,0 +1,8 @@
+-- Migration: 000031_plugin_managed_channels
+-- Description: Mark channels created through a plugin and archive them when the plugin is uninstalled
+
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS managed_by_plugin UUID REFERENCES plugins(id) ON DELETE SET NULL;
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_channels_managed_by_plugin ON channels(community_id, managed_by_plugin)
+    WHERE managed_by_plugin IS NOT NULL;
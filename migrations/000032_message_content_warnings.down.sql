@@ -0,0 +1,5 @@
+-- Migration: 000032_message_content_warnings
+-- Description: Remove message content warnings
+
+ALTER TABLE direct_messages DROP COLUMN IF EXISTS content_warning;
+ALTER TABLE messages DROP COLUMN IF EXISTS content_warning;
@@ -0,0 +1,5 @@
+-- Migration: 000032_message_content_warnings
+-- Description: Add an optional content warning label to channel and direct messages
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS content_warning VARCHAR(100);
+ALTER TABLE direct_messages ADD COLUMN IF NOT EXISTS content_warning VARCHAR(100);
@@ -0,0 +1,15 @@
+-- Migration: 000033_feeds_plugin
+-- Description: Remove the feeds plugin and its feed tracking
+
+DROP INDEX IF EXISTS idx_feed_entries_last_seen;
+DROP TABLE IF EXISTS feed_entries;
+DROP INDEX IF EXISTS idx_feed_subscriptions_next_poll;
+DROP TABLE IF EXISTS feed_subscriptions;
+
+DELETE FROM plugins WHERE slug = 'feeds';
+
+-- Posted entries stay in the channel history, so the author row is left in
+-- place when messages still reference it.
+DELETE FROM users
+WHERE id = 'fee0d500-0000-4000-8000-000000000001'
+  AND NOT EXISTS (SELECT 1 FROM messages WHERE author_id = 'fee0d500-0000-4000-8000-000000000001');
@@ -0,0 +1,76 @@
+-- Migration: 000033_feeds_plugin
+-- Description: Seed the official RSS/Atom feeds plugin and track polled feeds and posted entries
+
+-- System account feed entries are posted as. The password hash is not a
+-- valid bcrypt hash so nobody can ever log in with it.
+INSERT INTO users (id, username, email, password_hash, display_name, status, email_verified)
+VALUES (
+    'fee0d500-0000-4000-8000-000000000001',
+    'sys_feeds',
+    'feeds@system.zentra.local',
+    '!',
+    'Feeds',
+    'offline',
+    TRUE
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO plugins (slug, name, description, author, version, requested_permissions, manifest, built_in, source, is_verified)
+VALUES (
+    'feeds',
+    'Feeds',
+    'Posts new entries from RSS and Atom feeds into a channel. Configure a list of feeds, each with a url, channelId and intervalMinutes, after installing.',
+    'Zentra',
+    '1.0.0',
+    -- send messages
+    2,
+    '{
+        "channelTypes": [],
+        "commands": [],
+        "triggers": [],
+        "hooks": []
+    }'::JSONB,
+    FALSE,
+    'official',
+    TRUE
+) ON CONFLICT (slug) DO NOTHING;
+
+-- One row per configured feed, kept in step with the plugin config
+CREATE TABLE IF NOT EXISTS feed_subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    channel_id UUID NOT NULL,
+    url TEXT NOT NULL,
+    interval_minutes INTEGER NOT NULL DEFAULT 30,
+    feed_title VARCHAR(256),
+    -- conditional request validators from the last successful poll
+    etag TEXT,
+    last_modified TEXT,
+    next_poll_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_polled_at TIMESTAMPTZ,
+    last_success_at TIMESTAMPTZ,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    -- set once a failure alert has been written, cleared on recovery
+    alerted BOOLEAN NOT NULL DEFAULT FALSE,
+    lease_owner UUID,
+    lease_until TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (community_id, channel_id, url)
+);
+
+CREATE INDEX IF NOT EXISTS idx_feed_subscriptions_next_poll ON feed_subscriptions(next_poll_at);
+
+-- Entries already seen in a feed, so each is posted once
+CREATE TABLE IF NOT EXISTS feed_entries (
+    subscription_id UUID NOT NULL REFERENCES feed_subscriptions(id) ON DELETE CASCADE,
+    -- sha256 of the entry's guid, id or link
+    entry_key CHAR(64) NOT NULL,
+    message_id UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- last poll the entry was still in the feed; old rows are pruned
+    last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (subscription_id, entry_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_feed_entries_last_seen ON feed_entries(last_seen_at);
@@ -0,0 +1,6 @@
+-- Migration: 000034_community_partners
+-- Description: Remove partner links between communities
+
+DROP INDEX IF EXISTS idx_community_partners_target;
+DROP INDEX IF EXISTS idx_community_partners_pair;
+DROP TABLE IF EXISTS community_partners;
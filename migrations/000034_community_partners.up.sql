@@ -0,0 +1,23 @@
+-- Migration: 000034_community_partners
+-- Description: Add mutually approved partner links between communities
+
+CREATE TABLE IF NOT EXISTS community_partners (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    -- The community that proposed the link and the one asked to accept it
+    requester_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    target_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    -- pending until the target accepts; a declined row blocks re-proposals
+    -- from the same requester for a while
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    proposed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    accepted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    accepted_at TIMESTAMPTZ,
+    declined_at TIMESTAMPTZ,
+    CHECK (requester_id <> target_id)
+);
+
+-- One link per pair regardless of which side proposed it
+CREATE UNIQUE INDEX IF NOT EXISTS idx_community_partners_pair
+    ON community_partners (LEAST(requester_id, target_id), GREATEST(requester_id, target_id));
+CREATE INDEX IF NOT EXISTS idx_community_partners_target ON community_partners(target_id);
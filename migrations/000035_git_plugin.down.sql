@@ -0,0 +1,14 @@
+-- Migration: 000035_git_plugin
+-- Description: Remove the GitHub/GitLab plugin and its webhook endpoints
+
+DROP INDEX IF EXISTS idx_git_deliveries_created;
+DROP TABLE IF EXISTS git_deliveries;
+DROP TABLE IF EXISTS git_endpoints;
+
+DELETE FROM plugins WHERE slug = 'git';
+
+-- Posted events stay in the channel history, so the author row is left in
+-- place when messages still reference it.
+DELETE FROM users
+WHERE id = 'c0de0000-0000-4000-8000-000000000001'
+  AND NOT EXISTS (SELECT 1 FROM messages WHERE author_id = 'c0de0000-0000-4000-8000-000000000001');
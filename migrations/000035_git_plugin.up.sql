@@ -0,0 +1,57 @@
+-- Migration: 000035_git_plugin
+-- Description: Seed the official GitHub/GitLab plugin and store its webhook endpoints
+
+-- System account repository events are posted as. The password hash is not a
+-- valid bcrypt hash so nobody can ever log in with it.
+INSERT INTO users (id, username, email, password_hash, display_name, status, email_verified)
+VALUES (
+    'c0de0000-0000-4000-8000-000000000001',
+    'sys_git',
+    'git@system.zentra.local',
+    '!',
+    'Git',
+    'offline',
+    TRUE
+) ON CONFLICT DO NOTHING;
+
+INSERT INTO plugins (slug, name, description, author, version, requested_permissions, manifest, built_in, source, is_verified)
+VALUES (
+    'git',
+    'GitHub & GitLab',
+    'Posts push, pull/merge request, issue and release events from GitHub and GitLab webhooks into channels. Configure routes, each with a channelId and optional provider, repositories and events, after installing.',
+    'Zentra',
+    '1.0.0',
+    -- send messages
+    2,
+    '{
+        "channelTypes": [],
+        "commands": [],
+        "triggers": [],
+        "hooks": []
+    }'::JSONB,
+    FALSE,
+    'official',
+    TRUE
+) ON CONFLICT (slug) DO NOTHING;
+
+-- One receiving endpoint per community. The secret is encrypted with the
+-- server key since GitHub signatures need it in the clear to verify.
+CREATE TABLE IF NOT EXISTS git_endpoints (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    community_id UUID NOT NULL UNIQUE REFERENCES communities(id) ON DELETE CASCADE,
+    encrypted_secret BYTEA NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    last_delivery_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    rotated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Delivery ids already handled, so a redelivered event is posted once
+CREATE TABLE IF NOT EXISTS git_deliveries (
+    endpoint_id UUID NOT NULL REFERENCES git_endpoints(id) ON DELETE CASCADE,
+    delivery_id VARCHAR(128) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (endpoint_id, delivery_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_git_deliveries_created ON git_deliveries(created_at);
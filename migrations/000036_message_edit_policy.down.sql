@@ -0,0 +1,4 @@
+-- Migration: 000036_message_edit_policy
+-- Description: Remove the per-community message edit policy
+
+DROP TABLE IF EXISTS community_edit_policies;
@@ -0,0 +1,11 @@
+-- Migration: 000036_message_edit_policy
+-- Description: Add a per-community policy limiting when messages can be edited
+
+CREATE TABLE IF NOT EXISTS community_edit_policies (
+    community_id UUID PRIMARY KEY REFERENCES communities(id) ON DELETE CASCADE,
+    -- NULL leaves messages editable forever
+    edit_window_minutes INTEGER CHECK (edit_window_minutes > 0),
+    lock_pinned BOOLEAN NOT NULL DEFAULT FALSE,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,8 @@
+-- Migration: 000037_email_gateway
+-- Description: Remove email digests and reply-by-email tokens
+
+DROP TABLE IF EXISTS email_inbound_messages;
+DROP TABLE IF EXISTS email_reply_tokens;
+DROP INDEX IF EXISTS idx_notifications_user_unemailed;
+ALTER TABLE notifications DROP COLUMN IF EXISTS emailed_at;
+DROP TABLE IF EXISTS email_preferences;
@@ -0,0 +1,43 @@
+-- Migration: 000037_email_gateway
+-- Description: Add email digests for missed notifications and reply-by-email tokens
+
+CREATE TABLE IF NOT EXISTS email_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    digest_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    digest_interval_minutes INTEGER NOT NULL DEFAULT 60 CHECK (digest_interval_minutes BETWEEN 15 AND 1440),
+    reply_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    last_digest_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_preferences_digest ON email_preferences(last_digest_at) WHERE digest_enabled = TRUE;
+
+-- Set once a notification has been included in a digest so it is only mailed once
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS emailed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_unemailed ON notifications(user_id, created_at)
+    WHERE is_read = FALSE AND emailed_at IS NULL;
+
+-- One row per conversation a digest offered a reply address for. The address
+-- carries the row ID and an HMAC of it, so it can't be guessed or altered.
+CREATE TABLE IF NOT EXISTS email_reply_tokens (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel_id UUID REFERENCES channels(id) ON DELETE CASCADE,
+    conversation_id UUID REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    reply_to_id UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    CHECK ((channel_id IS NULL) <> (conversation_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_reply_tokens_expires ON email_reply_tokens(expires_at);
+
+-- Message-IDs of accepted replies, so a redelivered email doesn't post twice
+CREATE TABLE IF NOT EXISTS email_inbound_messages (
+    message_id VARCHAR(998) PRIMARY KEY,
+    token_id UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_inbound_messages_created ON email_inbound_messages(created_at);
@@ -0,0 +1,5 @@
+-- Migration: 000038_web_push
+-- Description: Remove Web Push subscriptions and push preferences
+
+DROP TABLE IF EXISTS push_preferences;
+DROP TABLE IF EXISTS push_subscriptions;
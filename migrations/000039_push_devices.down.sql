@@ -0,0 +1,4 @@
+-- Migration: 000039_push_devices
+-- Description: Remove native mobile push device tokens
+
+DROP TABLE IF EXISTS push_devices;
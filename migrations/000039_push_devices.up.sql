@@ -0,0 +1,22 @@
+-- Migration: 000039_push_devices
+-- Description: Add native mobile push device tokens for FCM and APNs
+
+CREATE TABLE IF NOT EXISTS push_devices (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- 'fcm' or 'apns'
+    platform VARCHAR(10) NOT NULL,
+    token VARCHAR(512) NOT NULL,
+    -- APNs sandbox tokens only work against the development gateway
+    sandbox BOOLEAN NOT NULL DEFAULT FALSE,
+    device_name VARCHAR(100),
+    app_version VARCHAR(50),
+    failure_count INTEGER NOT NULL DEFAULT 0,
+    last_success_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (platform, token)
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_devices_user ON push_devices(user_id);
+CREATE INDEX IF NOT EXISTS idx_push_devices_updated ON push_devices(updated_at);
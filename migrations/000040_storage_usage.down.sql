@@ -0,0 +1,4 @@
+-- Migration: 000040_storage_usage
+-- Description: Remove storage usage samples
+
+DROP TABLE IF EXISTS storage_usage_samples;
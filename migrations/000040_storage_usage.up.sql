@@ -0,0 +1,12 @@
+-- Migration: 000040_storage_usage
+-- Description: Add periodic per-bucket storage usage samples for growth metrics
+
+CREATE TABLE IF NOT EXISTS storage_usage_samples (
+    id BIGSERIAL PRIMARY KEY,
+    bucket VARCHAR(63) NOT NULL,
+    object_count BIGINT NOT NULL,
+    total_bytes BIGINT NOT NULL,
+    sampled_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_storage_usage_samples_bucket ON storage_usage_samples(bucket, sampled_at DESC);
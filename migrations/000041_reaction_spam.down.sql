@@ -0,0 +1,10 @@
+-- Migration: 000041_reaction_spam
+-- Description: Remove reaction burst limits and mass-react raid detection
+
+ALTER TABLE community_spam_settings
+    DROP COLUMN IF EXISTS reaction_burst_enabled,
+    DROP COLUMN IF EXISTS reaction_burst_limit,
+    DROP COLUMN IF EXISTS reaction_burst_window_seconds,
+    DROP COLUMN IF EXISTS reaction_raid_enabled,
+    DROP COLUMN IF EXISTS reaction_raid_threshold,
+    DROP COLUMN IF EXISTS reaction_raid_window_seconds;
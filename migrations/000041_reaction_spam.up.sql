@@ -0,0 +1,10 @@
+-- Migration: 000041_reaction_spam
+-- Description: Add per-community reaction burst limits and mass-react raid detection
+
+ALTER TABLE community_spam_settings
+    ADD COLUMN IF NOT EXISTS reaction_burst_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN IF NOT EXISTS reaction_burst_limit INTEGER NOT NULL DEFAULT 10,
+    ADD COLUMN IF NOT EXISTS reaction_burst_window_seconds INTEGER NOT NULL DEFAULT 10,
+    ADD COLUMN IF NOT EXISTS reaction_raid_enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN IF NOT EXISTS reaction_raid_threshold INTEGER NOT NULL DEFAULT 8,
+    ADD COLUMN IF NOT EXISTS reaction_raid_window_seconds INTEGER NOT NULL DEFAULT 60;
@@ -0,0 +1,12 @@
+-- Migration: 000042_quiet_hours
+-- Description: Remove quiet hours
+
+DROP INDEX IF EXISTS idx_notifications_held;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS held_until;
+
+ALTER TABLE user_settings
+    DROP COLUMN IF EXISTS quiet_hours_enabled,
+    DROP COLUMN IF EXISTS quiet_hours_start,
+    DROP COLUMN IF EXISTS quiet_hours_end,
+    DROP COLUMN IF EXISTS quiet_hours_timezone;
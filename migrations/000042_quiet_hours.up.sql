@@ -0,0 +1,14 @@
+-- Migration: 000042_quiet_hours
+-- Description: Add per-user quiet hours and hold notifications created during them
+
+ALTER TABLE user_settings
+    ADD COLUMN IF NOT EXISTS quiet_hours_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS quiet_hours_start SMALLINT NOT NULL DEFAULT 1320,
+    ADD COLUMN IF NOT EXISTS quiet_hours_end SMALLINT NOT NULL DEFAULT 420,
+    ADD COLUMN IF NOT EXISTS quiet_hours_timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- Set while a notification waits for the recipient's quiet hours to end
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS held_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_held ON notifications(held_until)
+    WHERE held_until IS NOT NULL;
@@ -0,0 +1,10 @@
+-- Migration: 000043_notification_coalescing
+-- Description: Remove notification coalescing
+
+DROP INDEX IF EXISTS idx_notifications_coalesce;
+DROP INDEX IF EXISTS idx_notifications_user_updated;
+
+ALTER TABLE notifications
+    DROP COLUMN IF EXISTS coalesce_key,
+    DROP COLUMN IF EXISTS count,
+    DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,17 @@
+-- Migration: 000043_notification_coalescing
+-- Description: Merge bursts of notifications from the same channel or conversation
+
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS coalesce_key VARCHAR(128),
+    ADD COLUMN IF NOT EXISTS count INTEGER NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+
+UPDATE notifications SET updated_at = created_at WHERE updated_at IS NULL;
+
+ALTER TABLE notifications
+    ALTER COLUMN updated_at SET DEFAULT NOW(),
+    ALTER COLUMN updated_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_updated ON notifications(user_id, updated_at DESC);
+CREATE INDEX IF NOT EXISTS idx_notifications_coalesce ON notifications(user_id, coalesce_key, updated_at DESC)
+    WHERE is_read = FALSE AND coalesce_key IS NOT NULL;
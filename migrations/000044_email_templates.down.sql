@@ -0,0 +1,4 @@
+-- Migration: 000044_email_templates
+-- Description: Remove email template overrides
+
+DROP TABLE IF EXISTS email_templates;
@@ -0,0 +1,13 @@
+-- Migration: 000044_email_templates
+-- Description: Add per-instance overrides of the built-in email templates
+
+CREATE TABLE IF NOT EXISTS email_templates (
+    name VARCHAR(64) NOT NULL,
+    locale VARCHAR(16) NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (name, locale)
+);
@@ -0,0 +1,9 @@
+-- Migration: 000045_watch_together
+-- Description: Remove the watch together plugin, its channel type and sessions
+
+DROP TABLE IF EXISTS watch_sessions;
+
+-- Channels of the type are left in place and show up as unknown types
+DELETE FROM channel_type_definitions WHERE id = 'watch_together';
+
+DELETE FROM plugins WHERE slug = 'watch-together';
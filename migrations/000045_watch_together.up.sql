@@ -0,0 +1,46 @@
+-- Migration: 000045_watch_together
+-- Description: Seed the watch together plugin and channel type and persist
+-- the shared playback state of each channel's session
+
+INSERT INTO plugins (slug, name, description, author, version, requested_permissions, manifest, built_in, source, is_verified)
+VALUES (
+    'watch-together',
+    'Watch Together',
+    'Adds a channel type where members watch a video in sync. The host picks the video and controls playback; everyone else follows along and chats.',
+    'Zentra',
+    '1.0.0',
+    0,
+    '{
+        "channelTypes": ["watch_together"],
+        "commands": [],
+        "triggers": [],
+        "hooks": []
+    }'::JSONB,
+    FALSE,
+    'official',
+    TRUE
+) ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO channel_type_definitions (id, name, description, icon, capabilities, default_metadata, built_in, plugin_id)
+VALUES (
+    'watch_together', 'Watch Together', 'Watch videos in sync and chat alongside', 'tv',
+    1 | 32 | 128 | 256, '{}', false,
+    (SELECT id::TEXT FROM plugins WHERE slug = 'watch-together')
+) ON CONFLICT (id) DO NOTHING;
+
+-- One session per channel. position_ms is where playback was at
+-- state_changed_at; while playing the current position is extrapolated from
+-- it, so the row only changes when someone actually controls playback.
+CREATE TABLE IF NOT EXISTS watch_sessions (
+    channel_id UUID PRIMARY KEY REFERENCES channels(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    media_url TEXT NOT NULL,
+    title VARCHAR(256),
+    playing BOOLEAN NOT NULL DEFAULT FALSE,
+    position_ms BIGINT NOT NULL DEFAULT 0,
+    playback_rate REAL NOT NULL DEFAULT 1,
+    state_changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- bumped on every change so clients can drop stale updates
+    version BIGINT NOT NULL DEFAULT 1,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,4 @@
+-- Migration: 000046_unread_counts
+-- Description: Drop the mention index used for unread counts
+
+DROP INDEX IF EXISTS idx_message_mentions_channel_message;
@@ -0,0 +1,5 @@
+-- Migration: 000046_unread_counts
+-- Description: Index mentions by channel and message time so per channel
+-- mention counts since the last read position are a range scan
+
+CREATE INDEX IF NOT EXISTS idx_message_mentions_channel_message ON message_mentions(channel_id, message_created_at);
@@ -0,0 +1,14 @@
+-- Migration: 000047_game_lobbies
+-- Description: Remove the game lobbies plugin, its channel type and lobbies
+
+DROP TABLE IF EXISTS game_lobby_members;
+DROP INDEX IF EXISTS idx_game_lobbies_expires;
+DROP INDEX IF EXISTS idx_game_lobbies_channel;
+DROP TABLE IF EXISTS game_lobbies;
+
+-- Channels of the type are left in place and show up as unknown types
+DELETE FROM channel_type_definitions WHERE id = 'lobby';
+
+-- Spawned voice channels lose their plugin link (ON DELETE SET NULL) and
+-- become regular channels
+DELETE FROM plugins WHERE slug = 'lobbies';
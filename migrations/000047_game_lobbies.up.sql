@@ -0,0 +1,57 @@
+-- Migration: 000047_game_lobbies
+-- Description: Seed the game lobbies plugin and lobby channel type and track
+-- open lobbies and who has joined them
+
+INSERT INTO plugins (slug, name, description, author, version, requested_permissions, manifest, built_in, source, is_verified)
+VALUES (
+    'lobbies',
+    'Game Lobbies',
+    'Adds a lobby channel type where members open joinable game lobbies with a title, player limit and tags, optionally with a voice channel of their own.',
+    'Zentra',
+    '1.0.0',
+    -- manage channels, for the per-lobby voice channels
+    64,
+    '{
+        "channelTypes": ["lobby"],
+        "commands": [],
+        "triggers": [],
+        "hooks": []
+    }'::JSONB,
+    FALSE,
+    'official',
+    TRUE
+) ON CONFLICT (slug) DO NOTHING;
+
+INSERT INTO channel_type_definitions (id, name, description, icon, capabilities, default_metadata, built_in, plugin_id)
+VALUES (
+    'lobby', 'Lobby', 'Find players and open game lobbies', 'gamepad-2',
+    1 | 32 | 128 | 256, '{"defaultDurationMinutes": 120}', false,
+    (SELECT id::TEXT FROM plugins WHERE slug = 'lobbies')
+) ON CONFLICT (id) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS game_lobbies (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    channel_id UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    host_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    title VARCHAR(100) NOT NULL,
+    max_players SMALLINT NOT NULL,
+    tags TEXT[] NOT NULL DEFAULT '{}',
+    voice_channel_id UUID REFERENCES channels(id) ON DELETE SET NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_game_lobbies_channel ON game_lobbies(channel_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_game_lobbies_expires ON game_lobbies(expires_at);
+
+CREATE TABLE IF NOT EXISTS game_lobby_members (
+    lobby_id UUID NOT NULL REFERENCES game_lobbies(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- denormalised so one lobby per channel per user can be a constraint
+    channel_id UUID NOT NULL,
+    joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (lobby_id, user_id),
+    UNIQUE (channel_id, user_id)
+);
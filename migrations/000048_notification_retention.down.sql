@@ -0,0 +1,4 @@
+-- Migration: 000048_notification_retention
+-- Description: Drop the notification retention index
+
+DROP INDEX IF EXISTS idx_notifications_updated;
@@ -0,0 +1,5 @@
+-- Migration: 000048_notification_retention
+-- Description: Index notifications by last update so the retention job can
+-- find old ones without scanning every user's list
+
+CREATE INDEX IF NOT EXISTS idx_notifications_updated ON notifications(updated_at);
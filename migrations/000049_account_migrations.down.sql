@@ -0,0 +1,4 @@
+-- Migration: 000049_account_migrations
+-- Description: Drop imported account bundle records
+
+DROP TABLE IF EXISTS account_migrations;
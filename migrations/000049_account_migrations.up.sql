@@ -0,0 +1,19 @@
+-- Migration: 000049_account_migrations
+-- Description: Record account bundles imported from other instances. The
+-- bundle id is the primary key so a bundle can only be imported once.
+
+CREATE TABLE IF NOT EXISTS account_migrations (
+    bundle_id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    identity_id VARCHAR(64) NOT NULL,
+    source_instance TEXT NOT NULL,
+    source_user_id UUID NOT NULL,
+    friends_requested INT NOT NULL DEFAULT 0,
+    unresolved_friends JSONB NOT NULL DEFAULT '[]',
+    -- Imported DM history, encrypted like DM content
+    dm_history BYTEA,
+    dm_history_nonce BYTEA,
+    imported_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_migrations_user ON account_migrations(user_id, imported_at DESC);
@@ -0,0 +1,6 @@
+-- Migration: 000050_voice_video
+-- Description: Remove camera state and stream metadata from voice participants
+
+ALTER TABLE voice_states
+DROP COLUMN IF EXISTS streams,
+DROP COLUMN IF EXISTS is_video;
@@ -0,0 +1,7 @@
+-- Migration: 000050_voice_video
+-- Description: Track camera state and the metadata of each participant's
+-- outgoing streams, so clients can lay out video tiles
+
+ALTER TABLE voice_states
+ADD COLUMN IF NOT EXISTS is_video BOOLEAN NOT NULL DEFAULT FALSE,
+ADD COLUMN IF NOT EXISTS streams JSONB NOT NULL DEFAULT '[]';
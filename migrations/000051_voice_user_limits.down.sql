@@ -0,0 +1,7 @@
+-- Migration: 000051_voice_user_limits
+-- Description: Remove voice channel user limits. The voice permissions
+-- granted to default roles are left in place, as they can't be told apart
+-- from ones set by administrators.
+
+ALTER TABLE channels
+DROP COLUMN IF EXISTS user_limit;
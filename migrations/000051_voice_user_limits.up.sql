@@ -0,0 +1,12 @@
+-- Migration: 000051_voice_user_limits
+-- Description: Cap how many members can be connected to a voice channel at
+-- once (0 means no limit). Joining a voice channel now needs the Connect
+-- permission and publishing needs Speak, so default roles that predate them
+-- are granted both to keep members able to talk.
+
+ALTER TABLE channels
+ADD COLUMN IF NOT EXISTS user_limit INTEGER NOT NULL DEFAULT 0
+    CHECK (user_limit >= 0 AND user_limit <= 99);
+
+-- VoiceConnect (1 << 16) | VoiceSpeak (1 << 17)
+UPDATE roles SET permissions = COALESCE(permissions, 0) | 196608 WHERE is_default = TRUE;
@@ -0,0 +1,5 @@
+-- Migration: 000052_soundboard
+-- Description: Remove community soundboards
+
+DROP TABLE IF EXISTS soundboard_disabled_channels;
+DROP TABLE IF EXISTS soundboard_sounds;
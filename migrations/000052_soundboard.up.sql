@@ -0,0 +1,25 @@
+-- Migration: 000052_soundboard
+-- Description: Short sound clips a community uploads for members to play in
+-- its voice channels, and the voice channels that have the soundboard off
+
+CREATE TABLE IF NOT EXISTS soundboard_sounds (
+    id UUID PRIMARY KEY,
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    name VARCHAR(32) NOT NULL,
+    emoji VARCHAR(64),
+    url TEXT NOT NULL,
+    volume REAL NOT NULL DEFAULT 1 CHECK (volume >= 0 AND volume <= 1),
+    uploader_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_soundboard_sounds_community ON soundboard_sounds(community_id, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_soundboard_sounds_name ON soundboard_sounds(community_id, LOWER(name));
+
+-- The soundboard is on in every voice channel without a row here
+CREATE TABLE IF NOT EXISTS soundboard_disabled_channels (
+    channel_id UUID PRIMARY KEY REFERENCES channels(id) ON DELETE CASCADE,
+    disabled_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    disabled_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
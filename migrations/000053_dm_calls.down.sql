@@ -0,0 +1,5 @@
+-- Migration: 000053_dm_calls
+-- Description: Remove DM calls
+
+DROP TABLE IF EXISTS dm_call_participants;
+DROP TABLE IF EXISTS dm_calls;
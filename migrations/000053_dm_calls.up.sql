@@ -0,0 +1,37 @@
+-- Migration: 000053_dm_calls
+-- Description: Voice and video calls in DM conversations, and how each
+-- participant took part in them
+
+CREATE TABLE IF NOT EXISTS dm_calls (
+    id UUID PRIMARY KEY,
+    conversation_id UUID NOT NULL REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    initiator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    is_video BOOLEAN NOT NULL DEFAULT FALSE,
+    status VARCHAR(16) NOT NULL DEFAULT 'ringing'
+        CHECK (status IN ('ringing', 'active', 'ended', 'missed', 'declined')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    answered_at TIMESTAMPTZ,
+    ended_at TIMESTAMPTZ
+);
+
+-- A conversation has at most one call going at a time
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dm_calls_live ON dm_calls(conversation_id)
+    WHERE status IN ('ringing', 'active');
+CREATE INDEX IF NOT EXISTS idx_dm_calls_conversation ON dm_calls(conversation_id, created_at DESC);
+
+CREATE TABLE IF NOT EXISTS dm_call_participants (
+    call_id UUID NOT NULL REFERENCES dm_calls(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    state VARCHAR(16) NOT NULL
+        CHECK (state IN ('ringing', 'joined', 'left', 'declined', 'missed')),
+    joined_at TIMESTAMPTZ,
+    left_at TIMESTAMPTZ,
+    PRIMARY KEY (call_id, user_id)
+);
+
+-- Participants still being rung, for the missed call sweep, and the calls
+-- each user is in
+CREATE INDEX IF NOT EXISTS idx_dm_call_participants_ringing ON dm_call_participants(call_id)
+    WHERE state = 'ringing';
+CREATE INDEX IF NOT EXISTS idx_dm_call_participants_joined ON dm_call_participants(user_id)
+    WHERE state = 'joined';
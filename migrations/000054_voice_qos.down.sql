@@ -0,0 +1,4 @@
+-- Migration: 000054_voice_qos
+-- Description: Remove voice quality reports
+
+DROP TABLE IF EXISTS voice_qos_reports;
@@ -0,0 +1,20 @@
+-- Migration: 000054_voice_qos
+-- Description: Connection quality clients report while in a voice channel or
+-- DM call, kept for a week for operators to compare regions and channels
+
+CREATE TABLE IF NOT EXISTS voice_qos_reports (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel_id UUID REFERENCES channels(id) ON DELETE CASCADE,
+    call_id UUID REFERENCES dm_calls(id) ON DELETE CASCADE,
+    region VARCHAR(32) NOT NULL,
+    packet_loss REAL NOT NULL CHECK (packet_loss >= 0 AND packet_loss <= 100),
+    jitter_ms REAL NOT NULL CHECK (jitter_ms >= 0),
+    rtt_ms REAL NOT NULL CHECK (rtt_ms >= 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((channel_id IS NULL) <> (call_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_voice_qos_reports_created ON voice_qos_reports(created_at);
+CREATE INDEX IF NOT EXISTS idx_voice_qos_reports_region ON voice_qos_reports(region, created_at);
+CREATE INDEX IF NOT EXISTS idx_voice_qos_reports_user ON voice_qos_reports(user_id, created_at);
@@ -0,0 +1,8 @@
+-- Migration: 000055_plugin_deliveries
+-- Description: Remove plugin event deliveries
+
+DROP TABLE IF EXISTS plugin_deliveries;
+
+ALTER TABLE community_plugins DROP COLUMN IF EXISTS deliveries_paused;
+ALTER TABLE community_plugins DROP COLUMN IF EXISTS delivery_failures;
+ALTER TABLE community_plugins DROP COLUMN IF EXISTS encrypted_secret;
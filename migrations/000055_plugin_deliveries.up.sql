@@ -0,0 +1,24 @@
+-- Migration: 000055_plugin_deliveries
+-- Description: Deliver community events to the endpoints plugins declare in
+-- their manifest, signed with a secret per installation
+
+ALTER TABLE community_plugins ADD COLUMN IF NOT EXISTS encrypted_secret BYTEA;
+ALTER TABLE community_plugins ADD COLUMN IF NOT EXISTS delivery_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE community_plugins ADD COLUMN IF NOT EXISTS deliveries_paused BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS plugin_deliveries (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    installation_id UUID NOT NULL REFERENCES community_plugins(id) ON DELETE CASCADE,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_plugin_deliveries_due ON plugin_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_plugin_deliveries_installation ON plugin_deliveries(installation_id, created_at DESC);
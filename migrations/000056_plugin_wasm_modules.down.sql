@@ -0,0 +1,4 @@
+-- Migration: 000056_plugin_wasm_modules
+-- Description: Remove stored plugin WASM modules
+
+DROP TABLE IF EXISTS plugin_wasm_modules;
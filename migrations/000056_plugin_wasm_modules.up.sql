@@ -0,0 +1,11 @@
+-- Migration: 000056_plugin_wasm_modules
+-- Description: Store the WASM modules plugins run in the server sandbox,
+-- keyed by their SHA-256 digest so each is fetched once
+
+CREATE TABLE IF NOT EXISTS plugin_wasm_modules (
+    sha256 CHAR(64) PRIMARY KEY,
+    module BYTEA NOT NULL,
+    size INTEGER NOT NULL,
+    source_url TEXT NOT NULL,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
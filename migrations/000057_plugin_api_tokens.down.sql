@@ -0,0 +1,6 @@
+-- Migration: 000057_plugin_api_tokens
+-- Description: Remove plugin API tokens
+
+DROP TABLE IF EXISTS plugin_api_tokens;
+
+ALTER TABLE community_plugins DROP COLUMN IF EXISTS bot_user_id;
@@ -0,0 +1,17 @@
+-- Migration: 000057_plugin_api_tokens
+-- Description: API tokens plugins call the REST API with, one per installation,
+-- scoped to its community and granted permissions
+
+-- Messages a plugin sends are attributed to a bot user made for its installation
+ALTER TABLE community_plugins ADD COLUMN IF NOT EXISTS bot_user_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS plugin_api_tokens (
+    installation_id UUID PRIMARY KEY REFERENCES community_plugins(id) ON DELETE CASCADE,
+    token_hash VARCHAR(128) NOT NULL,
+    token_preview VARCHAR(24) NOT NULL,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_plugin_api_tokens_hash ON plugin_api_tokens(token_hash);
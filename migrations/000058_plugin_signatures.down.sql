@@ -0,0 +1,6 @@
+-- Migration: 000058_plugin_signatures
+-- Description: Remove plugin manifest signatures
+
+ALTER TABLE plugins DROP COLUMN IF EXISTS signed_by;
+
+ALTER TABLE plugin_sources DROP COLUMN IF EXISTS public_key;
@@ -0,0 +1,9 @@
+-- Migration: 000058_plugin_signatures
+-- Description: Sources sign the manifests they publish; the key is pinned when
+-- a source is added and plugins remember which key verified them
+
+-- Base64 Ed25519 public key the source published when it was added
+ALTER TABLE plugin_sources ADD COLUMN IF NOT EXISTS public_key TEXT;
+
+-- The key that verified the plugin's manifest; NULL when it came unsigned
+ALTER TABLE plugins ADD COLUMN IF NOT EXISTS signed_by TEXT;
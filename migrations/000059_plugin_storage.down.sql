@@ -0,0 +1,4 @@
+-- Migration: 000059_plugin_storage
+-- Description: Remove plugin key-value storage
+
+DROP TABLE IF EXISTS plugin_storage;
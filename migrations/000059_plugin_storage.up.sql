@@ -0,0 +1,13 @@
+-- Migration: 000059_plugin_storage
+-- Description: Key-value storage for plugins, one namespace per installation
+
+CREATE TABLE IF NOT EXISTS plugin_storage (
+    installation_id UUID NOT NULL REFERENCES community_plugins(id) ON DELETE CASCADE,
+    key VARCHAR(128) NOT NULL,
+    value JSONB NOT NULL,
+    -- Bytes of the value as the plugin sent it, counted against the quota
+    size INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (installation_id, key)
+);
@@ -0,0 +1,4 @@
+-- Migration: 000060_plugin_jobs
+-- Description: Remove plugin scheduled jobs
+
+DROP TABLE IF EXISTS plugin_jobs;
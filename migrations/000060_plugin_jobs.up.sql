@@ -0,0 +1,25 @@
+-- Migration: 000060_plugin_jobs
+-- Description: Scheduled jobs plugins declare in their manifest, one row per
+-- installation and schedule
+
+CREATE TABLE IF NOT EXISTS plugin_jobs (
+    installation_id UUID NOT NULL REFERENCES community_plugins(id) ON DELETE CASCADE,
+    name VARCHAR(64) NOT NULL,
+    cron VARCHAR(128) NOT NULL,
+    -- NULL until the scheduler works it out from cron
+    next_run_at TIMESTAMPTZ,
+    -- Set when cron doesn't parse; the job never runs until the manifest changes
+    invalid BOOLEAN NOT NULL DEFAULT FALSE,
+    -- The run in flight; the next one is skipped while it is still pending
+    delivery_id UUID REFERENCES plugin_deliveries(id) ON DELETE SET NULL,
+    last_run_at TIMESTAMPTZ,
+    -- Failed runs in a row; each one pushes the next run further out
+    failures INT NOT NULL DEFAULT 0,
+    skipped_runs INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (installation_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_plugin_jobs_next_run ON plugin_jobs(next_run_at) WHERE NOT invalid;
+CREATE INDEX IF NOT EXISTS idx_plugin_jobs_delivery ON plugin_jobs(delivery_id) WHERE delivery_id IS NOT NULL;
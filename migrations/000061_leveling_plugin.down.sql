@@ -0,0 +1,8 @@
+-- Migration: 000061_leveling_plugin
+-- Description: Remove the leveling plugin and members' XP
+
+DROP INDEX IF EXISTS idx_member_xp_user_id;
+DROP INDEX IF EXISTS idx_member_xp_leaderboard;
+DROP TABLE IF EXISTS member_xp;
+
+DELETE FROM plugins WHERE slug = 'leveling';
@@ -0,0 +1,40 @@
+-- Migration: 000061_leveling_plugin
+-- Description: Seed the official leveling plugin and track members' XP
+
+INSERT INTO plugins (slug, name, description, author, version, requested_permissions, manifest, built_in, source, is_verified)
+VALUES (
+    'leveling',
+    'Leveling',
+    'Awards XP for chatting and tracks each member''s level, with a leaderboard. Configure minXp, maxXp, cooldownSeconds, ignoredChannelIds and roleRewards (each a level and roleId) after installing.',
+    'Zentra',
+    '1.0.0',
+    -- read messages | read members | manage members (role rewards)
+    25,
+    '{
+        "channelTypes": [],
+        "commands": [],
+        "triggers": ["MESSAGE_CREATE"],
+        "hooks": ["message.create"]
+    }'::JSONB,
+    FALSE,
+    'official',
+    TRUE
+) ON CONFLICT (slug) DO NOTHING;
+
+-- One row per member who has chatted since leveling was installed. Rows are
+-- kept when the plugin is uninstalled so reinstalling doesn't reset progress.
+CREATE TABLE IF NOT EXISTS member_xp (
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    xp BIGINT NOT NULL DEFAULT 0,
+    level INTEGER NOT NULL DEFAULT 0,
+    message_count BIGINT NOT NULL DEFAULT 0,
+    -- messages within the cooldown after this earn no XP
+    last_awarded_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (community_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_member_xp_leaderboard ON member_xp(community_id, xp DESC);
+CREATE INDEX IF NOT EXISTS idx_member_xp_user_id ON member_xp(user_id);
@@ -0,0 +1,6 @@
+-- Migration: 000062_channel_items
+-- Description: Remove structured channel items
+
+DROP INDEX IF EXISTS idx_channel_items_slug;
+DROP INDEX IF EXISTS idx_channel_items_channel;
+DROP TABLE IF EXISTS channel_items;
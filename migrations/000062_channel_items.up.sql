@@ -0,0 +1,36 @@
+-- Migration: 000062_channel_items
+-- Description: Structured items for channel types that aren't message channels,
+-- and capability flags for them
+
+-- New capability bits on channel_type_definitions.capabilities:
+--  2048 = media board (board of media items)
+--  4096 = kanban (cards in columns)
+--  8192 = wiki (pages addressed by slug)
+
+-- Items in a channel whose type has one of the item capabilities. Which
+-- columns are used depends on the kind: cards use column_key, pages use slug,
+-- media uses media_url.
+CREATE TABLE IF NOT EXISTS channel_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    channel_id UUID NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    -- card, page or media
+    kind VARCHAR(16) NOT NULL,
+    title VARCHAR(200) NOT NULL DEFAULT '',
+    body TEXT,
+    slug VARCHAR(100),
+    column_key VARCHAR(64),
+    media_url TEXT,
+    position INTEGER NOT NULL DEFAULT 0,
+    -- free-form fields a plugin's frontend keeps on its items
+    data JSONB NOT NULL DEFAULT '{}',
+    -- bumped on every update so concurrent editors can detect each other
+    version INTEGER NOT NULL DEFAULT 1,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_channel_items_channel ON channel_items(channel_id, kind, position);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_channel_items_slug ON channel_items(channel_id, slug) WHERE slug IS NOT NULL;
@@ -0,0 +1,7 @@
+-- Migration: 000063_emoji_aliases_usage
+-- Description: Remove custom emoji aliases and usage counts
+
+DROP TABLE IF EXISTS custom_emoji_usage;
+DROP INDEX IF EXISTS idx_custom_emoji_aliases_prefix;
+DROP INDEX IF EXISTS idx_custom_emoji_aliases_community_alias;
+DROP TABLE IF EXISTS custom_emoji_aliases;
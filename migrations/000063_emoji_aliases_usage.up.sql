@@ -0,0 +1,22 @@
+-- Migration: 000063_emoji_aliases_usage
+-- Description: Extra names for custom emojis, and how often each one is used
+
+-- Aliases share the namespace of emoji names within a community
+CREATE TABLE IF NOT EXISTS custom_emoji_aliases (
+    emoji_id UUID NOT NULL REFERENCES custom_emojis(id) ON DELETE CASCADE,
+    community_id UUID NOT NULL REFERENCES communities(id) ON DELETE CASCADE,
+    alias VARCHAR(32) NOT NULL,
+    PRIMARY KEY (emoji_id, alias)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_emoji_aliases_community_alias ON custom_emoji_aliases(community_id, LOWER(alias));
+CREATE INDEX IF NOT EXISTS idx_custom_emoji_aliases_prefix ON custom_emoji_aliases(LOWER(alias) text_pattern_ops);
+
+-- Counted from messages and reactions that reference the emoji; rows appear
+-- on first use, so emojis without one have never been used
+CREATE TABLE IF NOT EXISTS custom_emoji_usage (
+    emoji_id UUID PRIMARY KEY REFERENCES custom_emojis(id) ON DELETE CASCADE,
+    message_count BIGINT NOT NULL DEFAULT 0,
+    reaction_count BIGINT NOT NULL DEFAULT 0,
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
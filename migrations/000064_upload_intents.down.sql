@@ -0,0 +1,4 @@
+-- Migration: 000064_upload_intents
+-- Description: Remove presigned upload intents
+
+DROP TABLE IF EXISTS upload_intents;
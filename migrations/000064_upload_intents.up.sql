@@ -0,0 +1,23 @@
+-- Migration: 000064_upload_intents
+-- Description: Attachments uploaded straight to object storage with a presigned URL
+
+-- An intent becomes a message_attachments row once the upload is confirmed;
+-- unconfirmed ones are removed with their object after they expire
+CREATE TABLE IF NOT EXISTS upload_intents (
+    id UUID PRIMARY KEY,
+    uploader_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel_id UUID REFERENCES channels(id) ON DELETE CASCADE,
+    dm_conversation_id UUID REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    object_name TEXT NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(128) NOT NULL,
+    file_size BIGINT NOT NULL,
+    is_spoiler BOOLEAN NOT NULL DEFAULT FALSE,
+    description TEXT,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((channel_id IS NULL) <> (dm_conversation_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_intents_uploader ON upload_intents(uploader_id);
+CREATE INDEX IF NOT EXISTS idx_upload_intents_expires ON upload_intents(expires_at);
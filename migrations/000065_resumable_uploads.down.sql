@@ -0,0 +1,4 @@
+-- Migration: 000065_resumable_uploads
+-- Description: Remove resumable uploads
+
+DROP TABLE IF EXISTS resumable_uploads;
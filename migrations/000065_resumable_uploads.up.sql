@@ -0,0 +1,29 @@
+-- Migration: 000065_resumable_uploads
+-- Description: Resumable attachment uploads assembled with an object storage multipart upload
+
+CREATE TABLE IF NOT EXISTS resumable_uploads (
+    id UUID PRIMARY KEY,
+    uploader_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    channel_id UUID REFERENCES channels(id) ON DELETE CASCADE,
+    dm_conversation_id UUID REFERENCES dm_conversations(id) ON DELETE CASCADE,
+    object_name TEXT NOT NULL,
+    multipart_id TEXT NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(128) NOT NULL,
+    upload_length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL DEFAULT 0,
+    -- Finished parts as [{"number":1,"etag":"..."}]; bytes past the last part
+    -- wait in a separate object until there's enough for another
+    parts JSONB NOT NULL DEFAULT '[]',
+    pending_size BIGINT NOT NULL DEFAULT 0,
+    is_spoiler BOOLEAN NOT NULL DEFAULT FALSE,
+    description TEXT,
+    -- Held while a chunk is being written so chunks can't interleave
+    locked_until TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((channel_id IS NULL) <> (dm_conversation_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_resumable_uploads_expires ON resumable_uploads(expires_at);
@@ -0,0 +1,10 @@
+-- Migration: 000066_attachment_processing
+-- Description: Remove attachment processing state and renditions
+
+DROP INDEX IF EXISTS idx_message_attachments_processing;
+
+ALTER TABLE message_attachments
+DROP COLUMN IF EXISTS processing_started_at,
+DROP COLUMN IF EXISTS processing_status,
+DROP COLUMN IF EXISTS blurhash,
+DROP COLUMN IF EXISTS medium_url;
@@ -0,0 +1,13 @@
+-- Migration: 000066_attachment_processing
+-- Description: Track background processing of attachments and the renditions it produces
+
+-- processing_status is NULL for files with nothing to process. A worker claims
+-- a 'processing' row by setting processing_started_at; a stale claim is retried.
+ALTER TABLE message_attachments
+ADD COLUMN IF NOT EXISTS medium_url TEXT,
+ADD COLUMN IF NOT EXISTS blurhash VARCHAR(64),
+ADD COLUMN IF NOT EXISTS processing_status VARCHAR(16),
+ADD COLUMN IF NOT EXISTS processing_started_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_message_attachments_processing
+    ON message_attachments(created_at) WHERE processing_status = 'processing';
@@ -0,0 +1,7 @@
+-- Migration: 000067_video_transcoding
+-- Description: Remove video renditions and poster frames
+
+ALTER TABLE message_attachments
+DROP COLUMN IF EXISTS duration_seconds,
+DROP COLUMN IF EXISTS poster_url,
+DROP COLUMN IF EXISTS video_url;
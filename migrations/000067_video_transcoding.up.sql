@@ -0,0 +1,7 @@
+-- Migration: 000067_video_transcoding
+-- Description: Web-friendly renditions and poster frames for video attachments
+
+ALTER TABLE message_attachments
+ADD COLUMN IF NOT EXISTS video_url TEXT,
+ADD COLUMN IF NOT EXISTS poster_url TEXT,
+ADD COLUMN IF NOT EXISTS duration_seconds DOUBLE PRECISION;
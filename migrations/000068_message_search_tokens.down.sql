@@ -0,0 +1,7 @@
+-- Migration: 000068_message_search_tokens
+-- Description: Remove message search tokens
+
+DROP INDEX IF EXISTS idx_messages_search_tokens;
+
+ALTER TABLE messages
+DROP COLUMN IF EXISTS search_tokens;
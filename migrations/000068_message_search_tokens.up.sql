@@ -0,0 +1,8 @@
+-- Migration: 000068_message_search_tokens
+-- Description: Keyed word tokens so encrypted messages can be searched
+
+-- NULL until the message has been indexed (see cmd/searchindex)
+ALTER TABLE messages
+ADD COLUMN IF NOT EXISTS search_tokens TEXT[];
+
+CREATE INDEX IF NOT EXISTS idx_messages_search_tokens ON messages USING GIN (search_tokens);
@@ -0,0 +1,8 @@
+-- Migration: 000069_message_keyset_order
+-- Description: Restore the created_at-only history indexes
+
+DROP INDEX IF EXISTS idx_direct_messages_history;
+CREATE INDEX IF NOT EXISTS idx_direct_messages_conversation_id ON direct_messages(conversation_id, created_at DESC);
+
+DROP INDEX IF EXISTS idx_messages_channel_history;
+CREATE INDEX IF NOT EXISTS idx_messages_channel_id ON messages(channel_id, created_at DESC);
@@ -0,0 +1,20 @@
+-- Migration: 000069_message_keyset_order
+-- Description: Order message history by (created_at, id)
+--
+-- New messages get UUIDv7 IDs whose leading bits are their created_at, so the
+-- ID alone gives their order. Existing messages keep their random UUIDs: no ID
+-- a client, reply, pin or read state refers to changes. History pages on
+-- (created_at, id), which orders both kinds and never ties, and a cursor with
+-- a sortable ID doesn't need its created_at looked up.
+--
+-- Postgres can't build an index on a partitioned table CONCURRENTLY, and a
+-- plain CREATE INDEX on messages blocks writes to every partition until it
+-- finishes. This only creates the index on the parent, which is instant and
+-- starts out invalid. The maintenance job (history_indexes) builds it on each
+-- partition CONCURRENTLY and attaches it there; once every partition has it,
+-- it becomes valid and the job drops idx_messages_channel_id. It also builds
+-- the direct_messages index CONCURRENTLY, since it can't be in a migration
+-- with other statements. Partitions created from now on get the index as they
+-- are created.
+
+CREATE INDEX IF NOT EXISTS idx_messages_channel_history ON ONLY messages(channel_id, created_at DESC, id DESC);
@@ -0,0 +1,6 @@
+-- Migration: 000070_message_partition_archive
+-- Description: Drop the archive schema for messages partitions
+--
+-- Fails while archived partitions are still in it; dump and drop them first.
+
+DROP SCHEMA IF EXISTS message_archive;
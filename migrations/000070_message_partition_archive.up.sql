@@ -0,0 +1,8 @@
+-- Migration: 000070_message_partition_archive
+-- Description: Schema for messages partitions past their retention
+--
+-- The maintenance job detaches month partitions older than
+-- MESSAGE_PARTITION_RETENTION and moves them here. They are no longer read or
+-- written by the server and can be dumped and dropped.
+
+CREATE SCHEMA IF NOT EXISTS message_archive;
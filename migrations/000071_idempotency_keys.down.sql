@@ -0,0 +1,4 @@
+-- Migration: 000071_idempotency_keys
+-- Description: Drop Idempotency-Key reservations
+
+DROP TABLE IF EXISTS idempotency_keys;
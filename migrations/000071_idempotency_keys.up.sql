@@ -0,0 +1,25 @@
+-- Migration: 000071_idempotency_keys
+-- Description: Idempotency-Key reservations and the responses they replay
+--
+-- A row is inserted when a POST with an Idempotency-Key starts and gets the
+-- response once it finishes, so a retry of the same request is answered from
+-- here instead of running again. Rows are kept for 24 hours. They live in
+-- Postgres rather than Redis so a retry can't double-post while Redis is down.
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key TEXT NOT NULL,
+    -- SHA-256 of the method, path and body the key was first used with
+    fingerprint BYTEA NOT NULL,
+    -- NULL while the first request is still running
+    status_code INT,
+    content_type TEXT,
+    location TEXT,
+    -- Sealed with the keyring; only message sends store responses
+    response_body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created_at ON idempotency_keys(created_at);
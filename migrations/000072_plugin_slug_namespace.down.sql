@@ -0,0 +1,12 @@
+-- Migration: 000072_plugin_slug_namespace
+-- Description: Go back to bare plugin slugs
+--
+-- Fails if two sources have a plugin with the same slug; uninstall and
+-- delete one of them before rolling back.
+
+UPDATE plugins
+SET slug = regexp_replace(slug, '^.*/', '')
+WHERE built_in = FALSE
+  AND position('/' in slug) > 0;
+
+ALTER TABLE plugins ALTER COLUMN slug TYPE VARCHAR(128);
@@ -0,0 +1,14 @@
+-- Migration: 000072_plugin_slug_namespace
+-- Description: Name plugins from sources <source>/<slug>
+--
+-- Slugs were global, so a source could take over another source's plugin by
+-- listing the same slug. Built-in plugins keep their bare slugs; a slug with
+-- a slash always comes from a source.
+
+ALTER TABLE plugins ALTER COLUMN slug TYPE VARCHAR(641);
+
+UPDATE plugins
+SET slug = regexp_replace(regexp_replace(source, '^https?://', ''), '/+$', '') || '/' || slug
+WHERE built_in = FALSE
+  AND source ~ '^https?://'
+  AND position('/' in slug) = 0;
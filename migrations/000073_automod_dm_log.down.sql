@@ -0,0 +1,2 @@
+DELETE FROM automod_log WHERE channel_id IS NULL;
+ALTER TABLE automod_log ALTER COLUMN channel_id SET NOT NULL;
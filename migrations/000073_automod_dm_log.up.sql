@@ -0,0 +1,4 @@
+-- Migration: 000073_automod_dm_log
+-- Description: Log AutoMod triggers on direct messages, which have no channel
+
+ALTER TABLE automod_log ALTER COLUMN channel_id DROP NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE community_plugins DROP COLUMN IF EXISTS config_updated_by;
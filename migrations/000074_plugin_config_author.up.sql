@@ -0,0 +1,4 @@
+-- Migration: 000074_plugin_config_author
+-- Description: Remember who last saved a plugin's config, so roles the plugin grants can be held to that member's role hierarchy
+
+ALTER TABLE community_plugins ADD COLUMN IF NOT EXISTS config_updated_by UUID REFERENCES users(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+-- Migration: 000075_message_id_order
+-- Description: Drop the ID order indexes of sortable message IDs
+
+DROP INDEX IF EXISTS idx_direct_messages_id_order;
+DROP INDEX IF EXISTS idx_messages_channel_id_order;
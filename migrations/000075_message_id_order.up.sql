@@ -0,0 +1,15 @@
+-- Migration: 000075_message_id_order
+-- Description: Page messages with sortable IDs on the ID alone
+--
+-- History pages over UUIDv7 messages compare and order by id, with a
+-- created_at bound taken from the cursor so old partitions are skipped.
+-- Messages from before sortable IDs keep paging on (created_at, id) through
+-- idx_messages_channel_history. These indexes only hold the UUIDv7 rows, so
+-- a page never walks past random IDs.
+--
+-- As in migration 000069, the index is only created on the messages parent
+-- here. The maintenance job (history_indexes) builds it on each partition
+-- CONCURRENTLY and attaches it, and builds the direct_messages index
+-- CONCURRENTLY.
+
+CREATE INDEX IF NOT EXISTS idx_messages_channel_id_order ON ONLY messages(channel_id, id DESC) WHERE substr(id::text, 15, 1) = '7';
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zentra/server/pkg/metrics"
+)
+
+// Broadcasts published while Redis is down wait in memory and are appended to
+// the stream, in order, once it is back. The queue is capped by payload size;
+// when it is full the oldest events are dropped, since clients that missed
+// them will refetch on their next resume anyway.
+const (
+	broadcastQueueMaxBytes = 32 << 20
+	broadcastRetryInterval = time.Second
+)
+
+var (
+	broadcastsQueued = metrics.NewGauge("zentra_broadcasts_queued",
+		"Realtime events waiting in memory for Redis to come back.")
+	broadcastsDroppedTotal = metrics.NewCounter("zentra_broadcasts_dropped_total",
+		"Realtime events dropped because the in-memory queue was full while Redis was down.")
+)
+
+type queuedBroadcast struct {
+	seq     uint64
+	origin  string
+	payload []byte
+}
+
+type pendingBroadcasts struct {
+	mu       sync.Mutex
+	entries  []queuedBroadcast
+	bytes    int
+	seq      uint64
+	dropped  int64
+	flushing bool
+	wake     chan struct{}
+}
+
+var broadcastQueue = &pendingBroadcasts{wake: make(chan struct{}, 1)}
+
+// push queues a broadcast, dropping the oldest ones to stay under the cap,
+// and starts the flusher if it isn't running
+func (q *pendingBroadcasts) push(origin string, payload []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.seq++
+	q.entries = append(q.entries, queuedBroadcast{seq: q.seq, origin: origin, payload: payload})
+	q.bytes += len(payload)
+	for q.bytes > broadcastQueueMaxBytes && len(q.entries) > 1 {
+		q.bytes -= len(q.entries[0].payload)
+		q.entries[0] = queuedBroadcast{}
+		q.entries = q.entries[1:]
+		q.dropped++
+		broadcastsDroppedTotal.Inc()
+	}
+	broadcastsQueued.Set(float64(len(q.entries)))
+
+	if !q.flushing {
+		q.flushing = true
+		go q.flush()
+	}
+}
+
+// pending reports whether any broadcast is still queued. New ones go behind
+// them so events keep their order.
+func (q *pendingBroadcasts) pending() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.flushing
+}
+
+func (q *pendingBroadcasts) stats() (int, int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries), q.dropped
+}
+
+// kick wakes the flusher early, e.g. when Redis just came back
+func (q *pendingBroadcasts) kick() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// flush appends queued broadcasts to the stream, oldest first, waiting while
+// Redis is down. It exits once the queue is empty.
+func (q *pendingBroadcasts) flush() {
+	ctx := context.Background()
+	for {
+		q.mu.Lock()
+		if len(q.entries) == 0 {
+			q.flushing = false
+			q.entries = nil
+			q.mu.Unlock()
+			broadcastsQueued.Set(0)
+			return
+		}
+		next := q.entries[0]
+		q.mu.Unlock()
+
+		if RedisDegraded() || addBroadcast(ctx, next.origin, next.payload) != nil {
+			select {
+			case <-q.wake:
+			case <-time.After(broadcastRetryInterval):
+			}
+			continue
+		}
+
+		q.mu.Lock()
+		// push may have dropped it meanwhile; only pop if it's still first
+		if len(q.entries) > 0 && q.entries[0].seq == next.seq {
+			q.bytes -= len(next.payload)
+			q.entries[0] = queuedBroadcast{}
+			q.entries = q.entries[1:]
+		}
+		broadcastsQueued.Set(float64(len(q.entries)))
+		q.mu.Unlock()
+	}
+}
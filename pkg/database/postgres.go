@@ -65,3 +65,24 @@ func WithTransaction(ctx context.Context, fn func(ctx context.Context, tx pgx.Tx
 
 	return nil
 }
+
+// EnsureMessagePartition creates the monthly messages partition createdAt
+// falls in, for inserts with historical timestamps such as imports
+func EnsureMessagePartition(ctx context.Context, tx pgx.Tx, createdAt time.Time) error {
+	createdAt = createdAt.UTC()
+	start := time.Date(createdAt.Year(), createdAt.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	partitionName := fmt.Sprintf("messages_%04d_%02d", start.Year(), int(start.Month()))
+
+	// DDL statements like CREATE TABLE PARTITION OF don't support parameters ($1, $2).
+	// We use the already-formatted/safe partition name and format the range values as literal strings.
+	query := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s PARTITION OF messages FOR VALUES FROM ('%s') TO ('%s')",
+		partitionName,
+		start.Format("2006-01-02 15:04:05Z07:00"),
+		end.Format("2006-01-02 15:04:05Z07:00"),
+	)
+
+	_, err := tx.Exec(ctx, query)
+	return err
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"sync"
+	"time"
+)
+
+// Keys tracked before expired windows are swept out, at most once a second.
+// Windows are short, so memory stays bounded by the request rate.
+const localRateLimitSweepAt = 50000
+
+type localWindow struct {
+	count   int64
+	expires time.Time
+}
+
+// localCounters is the in-memory fallback for IncrementRateLimit: fixed
+// windows counted on this instance only
+type localCounters struct {
+	mu        sync.Mutex
+	windows   map[string]*localWindow
+	lastSweep time.Time
+}
+
+var localRateLimits = &localCounters{windows: make(map[string]*localWindow)}
+
+func (c *localCounters) increment(key string, window time.Duration) int64 {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w, ok := c.windows[key]
+	if !ok || now.After(w.expires) {
+		if !ok && len(c.windows) >= localRateLimitSweepAt && now.Sub(c.lastSweep) >= time.Second {
+			c.sweep(now)
+		}
+		w = &localWindow{expires: now.Add(window)}
+		c.windows[key] = w
+	}
+	w.count++
+	return w.count
+}
+
+func (c *localCounters) sweep(now time.Time) {
+	c.lastSweep = now
+	for key, w := range c.windows {
+		if now.After(w.expires) {
+			delete(c.windows, key)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -27,6 +28,7 @@ func NewRedisClient(redisURL string) (*redis.Client, error) {
 	}
 
 	RedisClient = client
+	watchRedis(client)
 	log.Info().Msg("Connected to Redis")
 
 	return client, nil
@@ -52,6 +54,38 @@ const (
 	KeyPrefixMessageCache = "msgcache:"
 )
 
+// StreamPrefixUser marks realtime broadcasts aimed at every connection of one
+// user (e.g. settings sync) rather than a channel.
+const StreamPrefixUser = "user:"
+
+// UserStream is the broadcast channelId for events only userID should receive
+func UserStream(userID string) string {
+	return StreamPrefixUser + userID
+}
+
+// StreamPrefixPresence marks a user's presence changes, which gateways only
+// deliver to users who share a community or DM conversation with them.
+const StreamPrefixPresence = "presence:"
+
+// PresenceStream is the broadcast channelId for userID's presence changes
+func PresenceStream(userID string) string {
+	return StreamPrefixPresence + userID
+}
+
+// StreamPrefixCommunity marks community events such as channel changes, which
+// gateways only deliver to members of the community, or with a channel ID to
+// the members who can see that channel.
+const StreamPrefixCommunity = "community:"
+
+// CommunityStream is the broadcast channelId for events about a community, or
+// about one of its channels when channelID is set
+func CommunityStream(communityID, channelID string) string {
+	if channelID == "" {
+		return StreamPrefixCommunity + communityID
+	}
+	return StreamPrefixCommunity + communityID + ":" + channelID
+}
+
 // Session management
 func SetSession(ctx context.Context, sessionID string, userID string, expiry time.Duration) error {
 	return RedisClient.Set(ctx, KeyPrefixSession+sessionID, userID, expiry).Err()
@@ -74,24 +108,7 @@ func GetUserPresence(ctx context.Context, userID string) (string, error) {
 	return RedisClient.Get(ctx, KeyPrefixUserPresence+userID).Result()
 }
 
-// Typing indicators
-func SetTyping(ctx context.Context, channelID string, userID string) error {
-	key := KeyPrefixTyping + channelID
-	// Typing indicator expires after 10 seconds
-	err := RedisClient.SAdd(ctx, key, userID).Err()
-	if err != nil {
-		return err
-	}
-	return RedisClient.Expire(ctx, key, 10*time.Second).Err()
-}
-
-func GetTypingUsers(ctx context.Context, channelID string) ([]string, error) {
-	return RedisClient.SMembers(ctx, KeyPrefixTyping+channelID).Result()
-}
-
-func RemoveTyping(ctx context.Context, channelID string, userID string) error {
-	return RedisClient.SRem(ctx, KeyPrefixTyping+channelID, userID).Err()
-}
+// Typing indicators live in the presence service (sorted sets under KeyPrefixTyping)
 
 // Online users per community
 func AddOnlineUser(ctx context.Context, communityID string, userID string) error {
@@ -110,14 +127,22 @@ func CountOnlineUsers(ctx context.Context, communityID string) (int64, error) {
 	return RedisClient.SCard(ctx, KeyPrefixOnlineUsers+communityID).Result()
 }
 
-// Rate limiting
+// Rate limiting. While Redis is down, counts are kept per instance instead
+// (see localRateLimits), so limits still hold but are per gateway.
 func IncrementRateLimit(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if RedisDegraded() {
+		return localRateLimits.increment(key, window), nil
+	}
+
 	fullKey := KeyPrefixRateLimit + key
 	pipe := RedisClient.Pipeline()
 	incr := pipe.Incr(ctx, fullKey)
 	pipe.Expire(ctx, fullKey, window)
 	_, err := pipe.Exec(ctx)
 	if err != nil {
+		if errors.Is(err, ErrRedisUnavailable) || isConnectionError(ctx, err) {
+			return localRateLimits.increment(key, window), nil
+		}
 		return 0, err
 	}
 	return incr.Val(), nil
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/pkg/metrics"
+)
+
+// While Redis can't be reached the client is marked degraded. Commands then
+// fail at once with ErrRedisUnavailable instead of each waiting out the dial
+// and read timeouts, and the callers that can fall back do so: rate limits are
+// counted per instance, typing is kept in memory and broadcasts are queued
+// (see PublishBroadcast). A probe pings Redis in the background and clears the
+// degraded mark as soon as it answers.
+const (
+	// Consecutive connection failures before Redis is marked degraded. A
+	// single timeout doesn't trip it.
+	redisFailureThreshold = 3
+	redisProbeInterval    = time.Second
+	redisProbeTimeout     = 2 * time.Second
+)
+
+// ErrRedisUnavailable is returned for commands sent while Redis is degraded
+var ErrRedisUnavailable = errors.New("redis unavailable")
+
+var (
+	redisDegradedGauge = metrics.NewGauge("zentra_redis_degraded",
+		"1 while this instance can't reach Redis and runs on local fallbacks.")
+	redisOutagesTotal = metrics.NewCounter("zentra_redis_outages_total",
+		"Times this instance marked Redis degraded.")
+)
+
+// RedisHealth is what /ready reports about Redis
+type RedisHealth struct {
+	Degraded bool
+	// Since is when Redis last went down or came back
+	Since             time.Time
+	QueuedBroadcasts  int
+	DroppedBroadcasts int64
+}
+
+type redisState struct {
+	mu        sync.Mutex
+	client    *redis.Client
+	degraded  bool
+	since     time.Time
+	failures  int
+	recovered []func()
+}
+
+var redisHealth = &redisState{since: time.Now()}
+
+type probeKey struct{}
+
+// watchRedis tracks the health of client through a hook on every command
+func watchRedis(client *redis.Client) {
+	redisHealth.mu.Lock()
+	redisHealth.client = client
+	redisHealth.mu.Unlock()
+	client.AddHook(healthHook{})
+}
+
+// RedisDegraded reports whether Redis is currently marked unreachable
+func RedisDegraded() bool {
+	redisHealth.mu.Lock()
+	defer redisHealth.mu.Unlock()
+	return redisHealth.degraded
+}
+
+// GetRedisHealth returns the current Redis health of this instance
+func GetRedisHealth() RedisHealth {
+	redisHealth.mu.Lock()
+	h := RedisHealth{Degraded: redisHealth.degraded, Since: redisHealth.since}
+	redisHealth.mu.Unlock()
+	h.QueuedBroadcasts, h.DroppedBroadcasts = broadcastQueue.stats()
+	return h
+}
+
+// OnRedisRecovered calls fn, in its own goroutine, every time Redis comes
+// back after being degraded. Services use it to write back state they could
+// only keep locally meanwhile.
+func OnRedisRecovered(fn func()) {
+	redisHealth.mu.Lock()
+	defer redisHealth.mu.Unlock()
+	redisHealth.recovered = append(redisHealth.recovered, fn)
+}
+
+func (s *redisState) observe(ctx context.Context, err error) {
+	if !isConnectionError(ctx, err) {
+		if err == nil || errors.Is(err, redis.Nil) || isReplyError(err) {
+			s.mu.Lock()
+			s.failures = 0
+			s.mu.Unlock()
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures++
+	if s.degraded || s.failures < redisFailureThreshold || s.client == nil {
+		return
+	}
+	s.degraded = true
+	s.since = time.Now()
+	redisDegradedGauge.Set(1)
+	redisOutagesTotal.Inc()
+	log.Error().Err(err).Msg("Redis is unreachable; running on local fallbacks")
+	go s.probe()
+}
+
+// probe pings Redis until it answers, then clears the degraded mark
+func (s *redisState) probe() {
+	ticker := time.NewTicker(redisProbeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), probeKey{}, true), redisProbeTimeout)
+		err := s.client.Ping(ctx).Err()
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		down := time.Since(s.since)
+		s.degraded = false
+		s.failures = 0
+		s.since = time.Now()
+		recovered := append([]func(){}, s.recovered...)
+		s.mu.Unlock()
+
+		redisDegradedGauge.Set(0)
+		log.Info().Dur("down", down).Msg("Redis is reachable again")
+		broadcastQueue.kick()
+		for _, fn := range recovered {
+			go fn()
+		}
+		return
+	}
+}
+
+// isConnectionError reports whether err means Redis couldn't be reached, as
+// opposed to a reply error, a missing key or the caller giving up
+func isConnectionError(ctx context.Context, err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) || errors.Is(err, ErrRedisUnavailable) || isReplyError(err) {
+		return false
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, redis.ErrClosed) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, io.EOF) || err.Error() == "redis: connection pool timeout"
+}
+
+func isReplyError(err error) bool {
+	var replyErr redis.Error
+	return errors.As(err, &replyErr)
+}
+
+// healthHook fails commands fast while Redis is degraded and feeds every
+// result into the failure count otherwise. The probe's own pings go through.
+type healthHook struct{}
+
+func (healthHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (healthHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if ctx.Value(probeKey{}) == nil && RedisDegraded() {
+			cmd.SetErr(ErrRedisUnavailable)
+			return ErrRedisUnavailable
+		}
+		err := next(ctx, cmd)
+		redisHealth.observe(ctx, err)
+		return err
+	}
+}
+
+func (healthHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if RedisDegraded() {
+			for _, cmd := range cmds {
+				cmd.SetErr(ErrRedisUnavailable)
+			}
+			return ErrRedisUnavailable
+		}
+		err := next(ctx, cmds)
+		redisHealth.observe(ctx, err)
+		return err
+	}
+}
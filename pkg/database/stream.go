@@ -0,0 +1,276 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// Realtime events ({channelId, event} payloads) travel between instances on
+// one Redis stream. Readers use consumer groups: every gateway instance reads
+// through a group of its own so it sees every event, and acknowledges entries
+// once handled. An instance that restarts under the same ID resumes from its
+// group's position, so events published during a deploy are replayed rather
+// than dropped.
+const (
+	BroadcastStream = "websocket:events"
+
+	// Entries kept for readers to catch up on; older ones are trimmed
+	broadcastMaxLen = 100000
+
+	broadcastReadCount = 100
+	broadcastReadBlock = 5 * time.Second
+
+	// Entries another consumer in the group took but didn't acknowledge for
+	// this long are claimed, as that consumer most likely died
+	broadcastClaimIdle = time.Minute
+)
+
+// PublishBroadcast appends a realtime event payload to the broadcast stream
+func PublishBroadcast(ctx context.Context, payload []byte) error {
+	return PublishBroadcastFrom(ctx, "", payload)
+}
+
+// PublishBroadcastFrom is PublishBroadcast for gateway instances, which tag
+// entries with their ID so they can skip ones they already delivered locally.
+//
+// When Redis can't be reached the event is queued in memory and appended once
+// it is back, so this only fails for errors a retry won't fix. Events without
+// an origin are delivered on this instance first (see SetLocalBroadcast).
+func PublishBroadcastFrom(ctx context.Context, origin string, payload []byte) error {
+	if RedisDegraded() || broadcastQueue.pending() {
+		queueBroadcast(origin, payload)
+		return nil
+	}
+	err := addBroadcast(ctx, origin, payload)
+	if err != nil && (errors.Is(err, ErrRedisUnavailable) || isConnectionError(ctx, err)) {
+		log.Warn().Err(err).Msg("Failed to publish broadcast; queued until Redis answers")
+		queueBroadcast(origin, payload)
+		return nil
+	}
+	return err
+}
+
+var localBroadcast struct {
+	sync.RWMutex
+	origin  string
+	deliver func(payload []byte)
+}
+
+// SetLocalBroadcast has events published without an origin while Redis can't
+// be reached handed to deliver, so this instance's clients get them without
+// waiting for Redis. They are queued tagged with origin, the instance's ID,
+// so it skips them when they come back through the stream.
+func SetLocalBroadcast(origin string, deliver func(payload []byte)) {
+	localBroadcast.Lock()
+	defer localBroadcast.Unlock()
+	localBroadcast.origin, localBroadcast.deliver = origin, deliver
+}
+
+func queueBroadcast(origin string, payload []byte) {
+	if origin == "" {
+		localBroadcast.RLock()
+		deliver := localBroadcast.deliver
+		if deliver != nil {
+			origin = localBroadcast.origin
+		}
+		localBroadcast.RUnlock()
+		if deliver != nil {
+			deliver(payload)
+		}
+	}
+	broadcastQueue.push(origin, payload)
+}
+
+func addBroadcast(ctx context.Context, origin string, payload []byte) error {
+	if RedisClient == nil {
+		return ErrRedisUnavailable
+	}
+	return RedisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: BroadcastStream,
+		MaxLen: broadcastMaxLen,
+		Approx: true,
+		Values: map[string]interface{}{
+			"origin":  origin,
+			"payload": payload,
+		},
+	}).Err()
+}
+
+// BroadcastEntry is one event read from the broadcast stream
+type BroadcastEntry struct {
+	ID      string
+	Origin  string
+	Payload []byte
+}
+
+// BroadcastConsumer reads the broadcast stream as one consumer of a group
+type BroadcastConsumer struct {
+	client   *redis.Client
+	group    string
+	consumer string
+}
+
+func NewBroadcastConsumer(client *redis.Client, group, consumer string) *BroadcastConsumer {
+	return &BroadcastConsumer{client: client, group: group, consumer: consumer}
+}
+
+// Run hands entries to handle, in order, until ctx is done. Each entry is
+// acknowledged after handle returns; entries taken before a crash but never
+// acknowledged are handled again first. A group that doesn't exist yet starts
+// at the end of the stream.
+func (c *BroadcastConsumer) Run(ctx context.Context, handle func(BroadcastEntry)) {
+	c.ensureGroup(ctx)
+
+	// Start with whatever this consumer took last time and never acknowledged
+	start := "0"
+	lastClaim := time.Time{}
+	for ctx.Err() == nil {
+		if start == ">" && time.Since(lastClaim) >= broadcastClaimIdle {
+			c.claimStale(ctx, handle)
+			lastClaim = time.Now()
+		}
+
+		streams, err := c.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    c.group,
+			Consumer: c.consumer,
+			Streams:  []string{BroadcastStream, start},
+			Count:    broadcastReadCount,
+			Block:    broadcastReadBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				c.ensureGroup(ctx)
+				continue
+			}
+			if errors.Is(err, ErrRedisUnavailable) {
+				// Already logged when Redis went down; wait for the probe
+				sleepCtx(ctx, time.Second)
+				continue
+			}
+			log.Warn().Err(err).Str("group", c.group).Msg("Failed to read broadcast stream")
+			sleepCtx(ctx, time.Second)
+			continue
+		}
+
+		read := 0
+		for _, stream := range streams {
+			read += len(stream.Messages)
+			c.handleMessages(ctx, stream.Messages, handle)
+		}
+		if start == "0" && read == 0 {
+			start = ">"
+		}
+	}
+}
+
+func (c *BroadcastConsumer) ensureGroup(ctx context.Context) {
+	err := c.client.XGroupCreateMkStream(ctx, BroadcastStream, c.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		log.Warn().Err(err).Str("group", c.group).Msg("Failed to create broadcast consumer group")
+	}
+}
+
+// claimStale takes over entries other consumers in the group left pending
+func (c *BroadcastConsumer) claimStale(ctx context.Context, handle func(BroadcastEntry)) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := c.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   BroadcastStream,
+			Group:    c.group,
+			Consumer: c.consumer,
+			MinIdle:  broadcastClaimIdle,
+			Start:    start,
+			Count:    broadcastReadCount,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, redis.Nil) {
+				log.Warn().Err(err).Str("group", c.group).Msg("Failed to claim stale broadcast entries")
+			}
+			return
+		}
+		c.handleMessages(ctx, messages, handle)
+		if next == "0-0" || next == "" {
+			return
+		}
+		start = next
+	}
+}
+
+func (c *BroadcastConsumer) handleMessages(ctx context.Context, messages []redis.XMessage, handle func(BroadcastEntry)) {
+	if len(messages) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		ids = append(ids, msg.ID)
+		payload, _ := msg.Values["payload"].(string)
+		if payload == "" {
+			// Trimmed while pending; there's nothing left to handle
+			continue
+		}
+		origin, _ := msg.Values["origin"].(string)
+		handle(BroadcastEntry{ID: msg.ID, Origin: origin, Payload: []byte(payload)})
+	}
+
+	if err := c.client.XAck(ctx, BroadcastStream, c.group, ids...).Err(); err != nil {
+		log.Warn().Err(err).Str("group", c.group).Msg("Failed to acknowledge broadcast entries")
+	}
+}
+
+// PruneBroadcastGroups removes consumer groups whose consumers have all been
+// idle for longer than maxIdle, such as those of gateway instances that were
+// scaled away. Returns how many were removed.
+func PruneBroadcastGroups(ctx context.Context, client *redis.Client, prefix string, maxIdle time.Duration) (int, error) {
+	groups, err := client.XInfoGroups(ctx, BroadcastStream).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, group := range groups {
+		if !strings.HasPrefix(group.Name, prefix) {
+			continue
+		}
+		consumers, err := client.XInfoConsumers(ctx, BroadcastStream, group.Name).Result()
+		if err != nil {
+			return removed, err
+		}
+		stale := true
+		for _, consumer := range consumers {
+			if consumer.Idle < maxIdle {
+				stale = false
+				break
+			}
+		}
+		if !stale {
+			continue
+		}
+		if err := client.XGroupDestroy(ctx, BroadcastStream, group.Name).Err(); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -0,0 +1,79 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// AgeKeyProvider reads the master keys from a file encrypted with age
+// (https://age-encryption.org) to an identity in the identity file. The
+// decrypted file has one hex key per line, current first; blank lines and #
+// comments are ignored. Both files are read again on every refresh.
+type AgeKeyProvider struct {
+	file         string
+	identityFile string
+}
+
+func NewAgeKeyProvider(file, identityFile string) (*AgeKeyProvider, error) {
+	if file == "" || identityFile == "" {
+		return nil, fmt.Errorf("age key provider needs a key file and an identity file")
+	}
+	return &AgeKeyProvider{file: file, identityFile: identityFile}, nil
+}
+
+func (p *AgeKeyProvider) Name() string {
+	return "age"
+}
+
+func (p *AgeKeyProvider) Keys(context.Context) ([][]byte, error) {
+	identityData, err := os.ReadFile(p.identityFile)
+	if err != nil {
+		return nil, fmt.Errorf("read age identity: %w", err)
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(identityData))
+	if err != nil {
+		return nil, fmt.Errorf("parse age identity: %w", err)
+	}
+	encrypted, err := os.ReadFile(p.file)
+	if err != nil {
+		return nil, fmt.Errorf("read age key file: %w", err)
+	}
+	plaintext, err := decryptAge(encrypted, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(plaintext), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return ParseHexKeys(lines)
+}
+
+// ageMaxFileSize bounds how much of the key file is read
+const ageMaxFileSize = 1 << 20
+
+func decryptAge(data []byte, identities []age.Identity) ([]byte, error) {
+	if len(data) > ageMaxFileSize {
+		return nil, fmt.Errorf("age key file is too large")
+	}
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)) {
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	}
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt age key file: %w", err)
+	}
+	return io.ReadAll(io.LimitReader(r, ageMaxFileSize))
+}
@@ -0,0 +1,101 @@
+package encryption
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// KMSConfig locates the master keys as AWS KMS encrypted data keys. Region and
+// credentials left empty come from the default AWS chain: shared config and
+// credential files, SSO, or an instance or task role.
+type KMSConfig struct {
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Overrides the regional KMS endpoint
+	Endpoint string
+	// Base64 CiphertextBlobs from kms generate-data-key --key-spec AES_256,
+	// current first
+	Ciphertexts []string
+}
+
+// KMSKeyProvider decrypts the master keys with AWS KMS
+type KMSKeyProvider struct {
+	client      *kms.Client
+	ciphertexts [][]byte
+}
+
+func NewKMSKeyProvider(cfg KMSConfig) (*KMSKeyProvider, error) {
+	p := &KMSKeyProvider{}
+	for i, raw := range cfg.Ciphertexts {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, fmt.Errorf("kms ciphertext %d is not base64", i)
+		}
+		p.ciphertexts = append(p.ciphertexts, blob)
+	}
+	if len(p.ciphertexts) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{
+		awsconfig.WithHTTPClient(awshttp.NewBuildableClient().WithTimeout(providerTimeout)),
+	}
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	if cfg.AccessKeyID != "" || cfg.SecretAccessKey != "" {
+		if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+			return nil, fmt.Errorf("kms key provider needs both an access key ID and a secret access key")
+		}
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, cfg.SessionToken)))
+	}
+	// Loading reads the environment and shared config files; credentials are
+	// only resolved on the first request
+	awsCfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("kms config: %w", err)
+	}
+	if awsCfg.Region == "" {
+		return nil, fmt.Errorf("kms key provider needs a region")
+	}
+	p.client = kms.NewFromConfig(awsCfg, func(o *kms.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+	return p, nil
+}
+
+func (p *KMSKeyProvider) Name() string {
+	return "awskms"
+}
+
+func (p *KMSKeyProvider) Keys(ctx context.Context) ([][]byte, error) {
+	keys := make([][]byte, 0, len(p.ciphertexts))
+	for i, blob := range p.ciphertexts {
+		out, err := p.client.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: blob})
+		if err != nil {
+			return nil, fmt.Errorf("kms ciphertext %d: %w", i, err)
+		}
+		if len(out.Plaintext) != 32 {
+			return nil, fmt.Errorf("kms ciphertext %d: %w", i, ErrInvalidKeyLength)
+		}
+		keys = append(keys, out.Plaintext)
+	}
+	return keys, nil
+}
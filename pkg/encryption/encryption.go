@@ -4,7 +4,9 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -92,3 +94,10 @@ func DecryptString(encoded string, key []byte) (string, error) {
 	}
 	return string(plaintext), nil
 }
+
+// KeyFingerprint identifies a key without revealing it. It doubles as the key
+// version reported for decryption failures.
+func KeyFingerprint(key []byte) string {
+	sum := sha256.Sum256(append([]byte("zentra-key-fingerprint:"), key...))
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,332 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Envelopes start with envelopeMagic and the ID of the master key that wraps
+// their data key, followed by the wrapped data key and the content:
+//
+//	magic(4) | key id(8) | key nonce(12) | wrapped key(32+16) | nonce(12) | content+tag
+//
+// Only the data key depends on the master key, so rotating the master key
+// rewrites the wrapped key and leaves the content as it is. The content is
+// bound to the row it is stored in (see Row).
+var envelopeMagic = []byte("ZEK\x02")
+
+// unboundMagic starts envelopes written before they were bound to their row.
+// They still open, and re-encryption replaces them.
+var unboundMagic = []byte("ZEK\x01")
+
+const (
+	keyIDSize       = 8
+	dataKeySize     = 32
+	gcmNonceSize    = 12
+	gcmTagSize      = 16
+	envelopeHeader  = len("ZEK\x02") + keyIDSize
+	wrappedKeySize  = gcmNonceSize + dataKeySize + gcmTagSize
+	envelopeMinSize = envelopeHeader + wrappedKeySize + gcmNonceSize + gcmTagSize
+)
+
+var (
+	ErrUnknownKey  = errors.New("content is encrypted with a key that is not configured")
+	ErrNotEnvelope = errors.New("content is not envelope encrypted")
+)
+
+// UnknownKeyError names the missing master key of an envelope. It matches
+// ErrUnknownKey.
+type UnknownKeyError struct {
+	KeyID string
+}
+
+func (e *UnknownKeyError) Error() string {
+	return ErrUnknownKey.Error() + " (" + e.KeyID + ")"
+}
+
+func (e *UnknownKeyError) Is(target error) bool {
+	return target == ErrUnknownKey
+}
+
+// Row is where a ciphertext is stored. An envelope only opens for the row it
+// was sealed for, so content copied into another row or table is rejected.
+type Row struct {
+	Table string
+	ID    uuid.UUID
+}
+
+// additionalData is what the content of an envelope is authenticated with
+func (r Row) additionalData(magic []byte) []byte {
+	data := make([]byte, 0, len(magic)+len(r.Table)+1+len(r.ID))
+	data = append(append(data, magic...), r.Table...)
+	return append(append(data, 0), r.ID[:]...)
+}
+
+// Keyring holds the master key new content is encrypted under and the keys it
+// replaced. Each piece of content gets its own data key, wrapped by a master
+// key and stored with it, so content written under any configured key can be
+// read and moving to a new key only re-wraps data keys.
+type Keyring struct {
+	set atomic.Pointer[keySet]
+}
+
+// keySet is the keys a Keyring holds at one time. It is replaced whole when
+// the keys are refreshed.
+type keySet struct {
+	current []byte
+	// Every key, current first, then previous keys newest first
+	keys [][]byte
+	byID map[string][]byte
+}
+
+// NewKeyring builds a keyring from the current master key and any keys it
+// replaced, newest first. Keys must be 32 bytes.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Replace(current, previous...); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Replace swaps in a new set of keys, e.g. after the key provider reports a
+// rotation. Content sealed from then on uses the new current key.
+func (k *Keyring) Replace(current []byte, previous ...[]byte) error {
+	set := &keySet{current: current, byID: make(map[string][]byte)}
+	for _, key := range append([][]byte{current}, previous...) {
+		if len(key) != 32 {
+			return ErrInvalidKeyLength
+		}
+		id := KeyFingerprint(key)
+		if _, ok := set.byID[id]; ok {
+			continue
+		}
+		set.byID[id] = key
+		set.keys = append(set.keys, key)
+	}
+	k.set.Store(set)
+	return nil
+}
+
+// Merge makes current the key new content is sealed with and adds the
+// previous keys, keeping every key the keyring already held
+func (k *Keyring) Merge(current []byte, previous ...[]byte) error {
+	held := k.set.Load().keys
+	keys := make([][]byte, 0, len(previous)+len(held))
+	keys = append(append(keys, previous...), held...)
+	return k.Replace(current, keys...)
+}
+
+// CurrentID is the version of the key new content is wrapped with
+func (k *Keyring) CurrentID() string {
+	return KeyFingerprint(k.set.Load().current)
+}
+
+// PreviousIDs are the versions of the keys the current one replaced
+func (k *Keyring) PreviousIDs() []string {
+	set := k.set.Load()
+	ids := make([]string, 0, len(set.keys)-1)
+	for _, key := range set.keys[1:] {
+		ids = append(ids, KeyFingerprint(key))
+	}
+	return ids
+}
+
+// Keys returns every master key, current first. They are only needed for
+// content written before envelope encryption.
+func (k *Keyring) Keys() [][]byte {
+	return k.set.Load().keys
+}
+
+// CurrentPrefix is how envelopes wrapped with the current key start, for
+// finding ones that still need re-wrapping in SQL
+func (k *Keyring) CurrentPrefix() []byte {
+	return k.set.Load().prefix()
+}
+
+func (s *keySet) prefix() []byte {
+	id, _ := hex.DecodeString(KeyFingerprint(s.current))
+	return append(append([]byte{}, envelopeMagic...), id...)
+}
+
+// Seal encrypts plaintext for row under a new data key wrapped with the
+// current key
+func (k *Keyring) Seal(plaintext []byte, row Row) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	set := k.set.Load()
+	header := set.prefix()
+	out := append(make([]byte, 0, envelopeMinSize+len(plaintext)), header...)
+	out, err := seal(out, set.current, dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+	return seal(out, dataKey, plaintext, row.additionalData(envelopeMagic))
+}
+
+// Open decrypts an envelope sealed for row, or content encrypted directly with
+// one of the master keys by Encrypt before envelopes were introduced. Neither
+// unbound envelopes nor that older content are tied to a row.
+func (k *Keyring) Open(ciphertext []byte, row Row) ([]byte, error) {
+	if !IsEnvelope(ciphertext) {
+		return k.openLegacy(ciphertext)
+	}
+	plaintext, err := k.openEnvelope(ciphertext, row)
+	if err != nil {
+		// Older content starts with the envelope magic once in 2^32 times
+		if legacy, legacyErr := k.openLegacy(ciphertext); legacyErr == nil {
+			return legacy, nil
+		}
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+func (k *Keyring) openEnvelope(ciphertext []byte, row Row) ([]byte, error) {
+	dataKey, err := k.unwrap(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	additionalData := unboundMagic
+	if IsBound(ciphertext) {
+		additionalData = row.additionalData(envelopeMagic)
+	}
+	return open(dataKey, ciphertext[envelopeHeader+wrappedKeySize:], additionalData)
+}
+
+func (k *Keyring) openLegacy(ciphertext []byte) ([]byte, error) {
+	for _, key := range k.Keys() {
+		if plaintext, err := Decrypt(ciphertext, key); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, ErrDecryptionFailed
+}
+
+// Rewrap returns the envelope with its data key wrapped by the current key.
+// The content is not decrypted, so an unbound envelope stays unbound.
+func (k *Keyring) Rewrap(ciphertext []byte) ([]byte, error) {
+	if !IsEnvelope(ciphertext) {
+		return nil, ErrNotEnvelope
+	}
+	dataKey, err := k.unwrap(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	set := k.set.Load()
+	header := set.prefix()
+	copy(header, ciphertext[:len(envelopeMagic)])
+	out := append(make([]byte, 0, len(ciphertext)), header...)
+	out, err = seal(out, set.current, dataKey, header)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, ciphertext[envelopeHeader+wrappedKeySize:]...), nil
+}
+
+func (k *Keyring) unwrap(ciphertext []byte) ([]byte, error) {
+	id, _ := EnvelopeKeyID(ciphertext)
+	key, ok := k.set.Load().byID[id]
+	if !ok {
+		return nil, &UnknownKeyError{KeyID: id}
+	}
+	return open(key, ciphertext[envelopeHeader:envelopeHeader+wrappedKeySize], ciphertext[:envelopeHeader])
+}
+
+// IsEnvelope reports whether ciphertext was written by Keyring.Seal, bound to
+// its row or not
+func IsEnvelope(ciphertext []byte) bool {
+	return len(ciphertext) >= envelopeMinSize &&
+		(bytes.HasPrefix(ciphertext, envelopeMagic) || bytes.HasPrefix(ciphertext, unboundMagic))
+}
+
+// IsBound reports whether ciphertext is an envelope bound to its row
+func IsBound(ciphertext []byte) bool {
+	return len(ciphertext) >= envelopeMinSize && bytes.HasPrefix(ciphertext, envelopeMagic)
+}
+
+// EnvelopeKeyID returns the version of the master key an envelope's data key
+// is wrapped with
+func EnvelopeKeyID(ciphertext []byte) (string, bool) {
+	if !IsEnvelope(ciphertext) {
+		return "", false
+	}
+	return hex.EncodeToString(ciphertext[len(envelopeMagic):envelopeHeader]), true
+}
+
+// seal appends nonce and AES-256-GCM ciphertext of plaintext to dst
+func seal(dst, key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	dst = append(dst, nonce...)
+	return gcm.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, ErrInvalidKeyLength
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealString seals a string for row and returns the envelope base64-encoded
+func (k *Keyring) SealString(plaintext string, row Row) (string, error) {
+	ciphertext, err := k.Seal([]byte(plaintext), row)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// OpenString opens a base64-encoded envelope sealed for row, or a string
+// encrypted with EncryptString under one of the master keys
+func (k *Keyring) OpenString(encoded string, row Row) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode base64: %w", err)
+	}
+	plaintext, err := k.Open(ciphertext, row)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
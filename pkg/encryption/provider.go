@@ -0,0 +1,191 @@
+package encryption
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/zentra/server/config"
+)
+
+var ErrNoKeys = errors.New("key provider returned no keys")
+
+// KeyProvider supplies the master keys: the current key first, then the keys
+// it replaced, newest first
+type KeyProvider interface {
+	Name() string
+	Keys(ctx context.Context) ([][]byte, error)
+}
+
+// NewKeyProvider returns the provider ENCRYPTION_KEY_PROVIDER selects. Remote
+// providers are cached for half of ENCRYPTION_KEY_REFRESH, so every refresh
+// reaches the provider.
+func NewKeyProvider(cfg *config.Config) (KeyProvider, error) {
+	var provider KeyProvider
+	var err error
+	switch cfg.Encryption.Provider {
+	case "env", "":
+		return NewStaticKeyProvider(cfg.Encryption.Key, cfg.Encryption.PreviousKeys...)
+	case "vault":
+		provider, err = NewVaultKeyProvider(VaultConfig{
+			Addr:      cfg.Encryption.VaultAddr,
+			Token:     cfg.Encryption.VaultToken,
+			Namespace: cfg.Encryption.VaultNamespace,
+			Path:      cfg.Encryption.VaultPath,
+		})
+	case "awskms":
+		provider, err = NewKMSKeyProvider(KMSConfig{
+			Region:          cfg.Encryption.KMSRegion,
+			AccessKeyID:     cfg.Encryption.KMSAccessKeyID,
+			SecretAccessKey: cfg.Encryption.KMSSecretAccessKey,
+			SessionToken:    cfg.Encryption.KMSSessionToken,
+			Endpoint:        cfg.Encryption.KMSEndpoint,
+			Ciphertexts:     cfg.Encryption.KMSCiphertexts,
+		})
+	case "age":
+		provider, err = NewAgeKeyProvider(cfg.Encryption.AgeFile, cfg.Encryption.AgeIdentityFile)
+	default:
+		return nil, fmt.Errorf("unknown ENCRYPTION_KEY_PROVIDER %q", cfg.Encryption.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewCachedKeyProvider(provider, cfg.Encryption.RefreshInterval/2), nil
+}
+
+// StaticKeyProvider serves keys given as hex strings, e.g. ENCRYPTION_KEY and
+// ENCRYPTION_PREVIOUS_KEYS
+type StaticKeyProvider struct {
+	keys [][]byte
+}
+
+// NewStaticKeyProvider decodes hex keys, current first
+func NewStaticKeyProvider(current string, previous ...string) (*StaticKeyProvider, error) {
+	keys, err := ParseHexKeys(append([]string{current}, previous...))
+	if err != nil {
+		return nil, err
+	}
+	return &StaticKeyProvider{keys: keys}, nil
+}
+
+func (p *StaticKeyProvider) Name() string {
+	return "env"
+}
+
+func (p *StaticKeyProvider) Keys(context.Context) ([][]byte, error) {
+	return p.keys, nil
+}
+
+// ParseHexKeys decodes 64 hex character keys, skipping empty entries
+func ParseHexKeys(raw []string) ([][]byte, error) {
+	var keys [][]byte
+	for i, r := range raw {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		key, err := hex.DecodeString(r)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key %d must be 64 hex characters", i)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return keys, nil
+}
+
+// CachedKeyProvider remembers another provider's keys for ttl, so Vault or
+// KMS is not asked on every refresh. When a fetch fails the last keys are
+// kept.
+type CachedKeyProvider struct {
+	provider KeyProvider
+	ttl      time.Duration
+
+	mu        sync.Mutex
+	keys      [][]byte
+	fetchedAt time.Time
+}
+
+func NewCachedKeyProvider(provider KeyProvider, ttl time.Duration) *CachedKeyProvider {
+	return &CachedKeyProvider{provider: provider, ttl: ttl}
+}
+
+func (p *CachedKeyProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *CachedKeyProvider) Keys(ctx context.Context) ([][]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil && time.Since(p.fetchedAt) < p.ttl {
+		return p.keys, nil
+	}
+	keys, err := p.provider.Keys(ctx)
+	if err == nil && len(keys) == 0 {
+		err = ErrNoKeys
+	}
+	if err != nil {
+		if p.keys != nil {
+			log.Warn().Err(err).Str("provider", p.provider.Name()).Msg("Failed to refresh encryption keys, keeping the cached ones")
+			return p.keys, nil
+		}
+		return nil, err
+	}
+	p.keys, p.fetchedAt = keys, time.Now()
+	return keys, nil
+}
+
+// LoadKeyring builds a keyring from a provider's keys
+func LoadKeyring(ctx context.Context, provider KeyProvider) (*Keyring, error) {
+	keys, err := provider.Keys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load keys from %s: %w", provider.Name(), err)
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return NewKeyring(keys[0], keys[1:]...)
+}
+
+// Watch reloads the keyring from provider every interval until ctx is done,
+// so a key rotated in Vault, KMS or the key file is picked up without a
+// restart. Keys the provider stops returning are kept until the process
+// restarts, so content sealed under them stays readable while other
+// instances catch up.
+func (k *Keyring) Watch(ctx context.Context, provider KeyProvider, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		keys, err := provider.Keys(ctx)
+		if err == nil && len(keys) == 0 {
+			err = ErrNoKeys
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("provider", provider.Name()).Msg("Failed to refresh encryption keys")
+			continue
+		}
+		before := k.CurrentID()
+		if err := k.Merge(keys[0], keys[1:]...); err != nil {
+			log.Warn().Err(err).Str("provider", provider.Name()).Msg("Key provider returned invalid keys")
+			continue
+		}
+		if after := k.CurrentID(); after != before {
+			log.Info().Str("fromKeyVersion", before).Str("toKeyVersion", after).Msg("Encryption key rotated")
+		}
+	}
+}
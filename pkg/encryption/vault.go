@@ -0,0 +1,97 @@
+package encryption
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const providerTimeout = 10 * time.Second
+
+// VaultConfig locates the master keys in a HashiCorp Vault KV secret
+type VaultConfig struct {
+	Addr      string
+	Token     string
+	Namespace string
+	// API path of the secret, e.g. secret/data/zentra/encryption for KV v2
+	Path string
+}
+
+// VaultKeyProvider reads the master keys from a KV secret. Its "key" field
+// holds the current key and "previous_keys" the comma-separated keys it
+// replaced, both as hex.
+type VaultKeyProvider struct {
+	cfg    VaultConfig
+	client *http.Client
+}
+
+func NewVaultKeyProvider(cfg VaultConfig) (*VaultKeyProvider, error) {
+	if cfg.Addr == "" || cfg.Token == "" || cfg.Path == "" {
+		return nil, fmt.Errorf("vault key provider needs an address, token and secret path")
+	}
+	cfg.Addr = strings.TrimSuffix(cfg.Addr, "/")
+	cfg.Path = strings.Trim(cfg.Path, "/")
+	return &VaultKeyProvider{cfg: cfg, client: &http.Client{Timeout: providerTimeout}}, nil
+}
+
+func (p *VaultKeyProvider) Name() string {
+	return "vault"
+}
+
+func (p *VaultKeyProvider) Keys(ctx context.Context) ([][]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Addr+"/v1/"+p.cfg.Path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", p.cfg.Token)
+	if p.cfg.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.cfg.Namespace)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("vault response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %d", resp.StatusCode)
+	}
+
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("vault response: %w", err)
+	}
+	// KV v2 nests the secret's fields under data.data
+	fields := secret.Data
+	if nested, ok := secret.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return nil, fmt.Errorf("vault response: %w", err)
+		}
+	}
+
+	var current, previous string
+	if raw, ok := fields["key"]; ok {
+		if err := json.Unmarshal(raw, &current); err != nil {
+			return nil, fmt.Errorf("vault secret %s: key must be a string", p.cfg.Path)
+		}
+	}
+	if raw, ok := fields["previous_keys"]; ok {
+		if err := json.Unmarshal(raw, &previous); err != nil {
+			return nil, fmt.Errorf("vault secret %s: previous_keys must be a string", p.cfg.Path)
+		}
+	}
+	if current == "" {
+		return nil, fmt.Errorf("vault secret %s has no key field", p.cfg.Path)
+	}
+	return ParseHexKeys(append([]string{current}, strings.Split(previous, ",")...))
+}
@@ -0,0 +1,216 @@
+// Package metrics keeps process-wide counters and gauges and serves them in the
+// Prometheus text exposition format. It is deliberately tiny so services can
+// record numbers without pulling in a client library.
+package metrics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type kind string
+
+const (
+	kindCounter kind = "counter"
+	kindGauge   kind = "gauge"
+)
+
+type metric struct {
+	name       string
+	help       string
+	kind       kind
+	labelNames []string
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by label values joined with \xff
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]*metric{}
+)
+
+func register(name, help string, k kind, labelNames []string) *metric {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if existing, ok := registry[name]; ok {
+		if existing.kind != k || len(existing.labelNames) != len(labelNames) {
+			panic("metrics: " + name + " registered twice with different shapes")
+		}
+		return existing
+	}
+
+	m := &metric{name: name, help: help, kind: k, labelNames: labelNames, values: map[string]float64{}}
+	registry[name] = m
+	return m
+}
+
+func (m *metric) key(labelValues []string) string {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+	return strings.Join(labelValues, "\xff")
+}
+
+func (m *metric) add(delta float64, labelValues []string) {
+	k := m.key(labelValues)
+	m.mu.Lock()
+	m.values[k] += delta
+	m.mu.Unlock()
+}
+
+func (m *metric) set(value float64, labelValues []string) {
+	k := m.key(labelValues)
+	m.mu.Lock()
+	m.values[k] = value
+	m.mu.Unlock()
+}
+
+func (m *metric) value(labelValues []string) float64 {
+	k := m.key(labelValues)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[k]
+}
+
+// Counter only ever goes up.
+type Counter struct{ m *metric }
+
+// NewCounter registers a counter. Registering the same name again returns the
+// existing counter, so package-level vars in several services are fine.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return &Counter{m: register(name, help, kindCounter, labelNames)}
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.m.add(1, labelValues)
+}
+
+// Add increases the counter; negative deltas are ignored.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta <= 0 {
+		return
+	}
+	c.m.add(delta, labelValues)
+}
+
+// Value reads the counter for one set of label values.
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.m.value(labelValues)
+}
+
+// Values snapshots the counter for every label value seen. It's meant for
+// single-label counters; with several labels the key joins their values with
+// "\xff".
+func (c *Counter) Values() map[string]float64 {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+
+	values := make(map[string]float64, len(c.m.values))
+	for k, v := range c.m.values {
+		values[k] = v
+	}
+	return values
+}
+
+// Gauge can go up and down.
+type Gauge struct{ m *metric }
+
+func NewGauge(name, help string, labelNames ...string) *Gauge {
+	return &Gauge{m: register(name, help, kindGauge, labelNames)}
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.m.set(value, labelValues)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.m.add(delta, labelValues)
+}
+
+// Handler serves every registered metric. When token is set the scraper must
+// send it as a bearer token.
+func Handler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write([]byte(Render()))
+	})
+}
+
+// Render formats every registered metric, sorted by name and labels.
+func Render() string {
+	registryMu.RLock()
+	metrics := make([]*metric, 0, len(registry))
+	for _, m := range registry {
+		metrics = append(metrics, m)
+	}
+	registryMu.RUnlock()
+
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	var b strings.Builder
+	for _, m := range metrics {
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(&b, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+		fmt.Fprintf(&b, "# TYPE %s %s\n", m.name, m.kind)
+		for _, k := range keys {
+			b.WriteString(m.name)
+			if len(m.labelNames) > 0 {
+				b.WriteByte('{')
+				for i, value := range strings.Split(k, "\xff") {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(&b, "%s=\"%s\"", m.labelNames[i], escapeLabel(value))
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(formatValue(m.values[k]))
+			b.WriteByte('\n')
+		}
+		m.mu.Unlock()
+	}
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
@@ -0,0 +1,359 @@
+package msgpack
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	timeType          = reflect.TypeFor[time.Time]()
+	numberType        = reflect.TypeFor[json.Number]()
+)
+
+// Marshal encodes v as MessagePack without going through JSON. The result is
+// what FromJSON would make of json.Marshal(v): struct fields follow their json
+// tags, integral numbers are integers, []byte is a base64 string and values
+// with their own JSON encoding are transcoded from it.
+func Marshal(v any) ([]byte, error) {
+	return appendReflect(make([]byte, 0, 256), reflect.ValueOf(v), 0)
+}
+
+func appendReflect(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	if depth > maxDepth {
+		return nil, ErrMaxDepth
+	}
+	if !v.IsValid() {
+		return append(buf, 0xc0), nil
+	}
+
+	t := v.Type()
+	switch {
+	case (t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface) && v.IsNil():
+		return append(buf, 0xc0), nil
+	case t == timeType:
+		return appendString(buf, v.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+	case t == numberType:
+		return appendValue(buf, v.Interface())
+	case t.Implements(jsonMarshalerType):
+		data, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		return appendJSON(buf, data)
+	case t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(jsonMarshalerType) && v.CanAddr():
+		data, err := v.Addr().Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		return appendJSON(buf, data)
+	case t.Implements(textMarshalerType):
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		return appendString(buf, string(text)), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return appendInt(buf, v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return appendFloat(buf, float64(u)), nil
+		}
+		return appendInt(buf, int64(u)), nil
+	case reflect.Float32, reflect.Float64:
+		return appendNumber(buf, v.Float())
+	case reflect.String:
+		return appendString(buf, v.String()), nil
+	case reflect.Pointer, reflect.Interface:
+		return appendReflect(buf, v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.IsNil() {
+			return append(buf, 0xc0), nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 && !reflect.PointerTo(t.Elem()).Implements(jsonMarshalerType) &&
+			!reflect.PointerTo(t.Elem()).Implements(textMarshalerType) {
+			return appendString(buf, base64.StdEncoding.EncodeToString(v.Bytes())), nil
+		}
+		return appendArray(buf, v, depth)
+	case reflect.Array:
+		return appendArray(buf, v, depth)
+	case reflect.Map:
+		return appendMap(buf, v, depth)
+	case reflect.Struct:
+		return appendStruct(buf, v, depth)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupported, t)
+}
+
+// appendJSON transcodes a value's own JSON encoding
+func appendJSON(buf []byte, data []byte) ([]byte, error) {
+	encoded, err := FromJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, encoded...), nil
+}
+
+// appendNumber writes a float the way FromJSON reads its JSON form: whole
+// numbers that fit an int64 become integers
+func appendNumber(buf []byte, f float64) ([]byte, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupported, f)
+	}
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return appendInt(buf, int64(f)), nil
+	}
+	return appendFloat(buf, f), nil
+}
+
+func appendArray(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	n := v.Len()
+	buf = appendArrayHeader(buf, n)
+	for i := 0; i < n; i++ {
+		var err error
+		if buf, err = appendReflect(buf, v.Index(i), depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendMap(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	if v.IsNil() {
+		return append(buf, 0xc0), nil
+	}
+
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key := iter.Key()
+		var name string
+		switch {
+		case key.Kind() == reflect.String:
+			name = key.String()
+		case key.Type().Implements(textMarshalerType):
+			text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+			if err != nil {
+				return nil, err
+			}
+			name = string(text)
+		default:
+			return nil, ErrNonStringKey
+		}
+		entries = append(entries, entry{name, iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	buf = appendMapHeader(buf, len(entries))
+	for _, e := range entries {
+		buf = appendString(buf, e.key)
+		var err error
+		if buf, err = appendReflect(buf, e.value, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func appendStruct(buf []byte, v reflect.Value, depth int) ([]byte, error) {
+	fields := structFields(v.Type())
+
+	values := make([]reflect.Value, 0, len(fields))
+	names := make([]string, 0, len(fields))
+	for _, f := range fields {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+			continue
+		}
+		values = append(values, fv)
+		names = append(names, f.name)
+	}
+
+	buf = appendMapHeader(buf, len(values))
+	for i, fv := range values {
+		buf = appendString(buf, names[i])
+		var err error
+		if buf, err = appendReflect(buf, fv, depth+1); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// fieldByIndex is v.FieldByIndex, except that a field behind a nil embedded
+// pointer is missing rather than a panic
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue matches encoding/json's omitempty
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	}
+	return false
+}
+
+type field struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	tagged    bool
+}
+
+var fieldCache sync.Map // reflect.Type -> []field
+
+// structFields lists the fields encoding/json would write for t, embedded
+// structs' fields included, in order
+func structFields(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+
+	var all []field
+	collectFields(t, nil, &all, map[reflect.Type]bool{})
+
+	// A name at a shallower depth hides the same name further down. Among
+	// fields at the same depth a tagged one wins, and otherwise none is kept.
+	byName := map[string][]field{}
+	var names []string
+	for _, f := range all {
+		if _, ok := byName[f.name]; !ok {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+	var fields []field
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return lessIndex(fields[i].index, fields[j].index) })
+
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+func collectFields(t reflect.Type, parent []int, out *[]field, visiting map[reflect.Type]bool) {
+	if visiting[t] {
+		return
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		index := append(append([]int(nil), parent...), i)
+
+		if sf.Anonymous {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if !sf.IsExported() && (sf.Type.Kind() == reflect.Pointer || ft.Kind() != reflect.Struct) {
+				continue
+			}
+			if name == "" && ft.Kind() == reflect.Struct {
+				collectFields(ft, index, out, visiting)
+				continue
+			}
+		} else if !sf.IsExported() {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+		*out = append(*out, field{
+			name:      name,
+			index:     index,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+			tagged:    tagged,
+		})
+	}
+}
+
+func dominantField(candidates []field) (field, bool) {
+	depth := len(candidates[0].index)
+	for _, f := range candidates[1:] {
+		depth = min(depth, len(f.index))
+	}
+	var shallowest []field
+	for _, f := range candidates {
+		if len(f.index) == depth {
+			shallowest = append(shallowest, f)
+		}
+	}
+	if len(shallowest) == 1 {
+		return shallowest[0], true
+	}
+
+	var tagged []field
+	for _, f := range shallowest {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return field{}, false
+}
+
+func lessIndex(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,357 @@
+// Package msgpack converts between JSON and MessagePack. Gateway events are
+// defined by their JSON encoding, so Marshal follows json struct tags and
+// transcoding keeps both encodings on the same schema without a second set of
+// struct tags or a codec library.
+package msgpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// maxDepth bounds nesting when decoding untrusted input
+const maxDepth = 100
+
+var (
+	ErrTruncated     = errors.New("msgpack: truncated input")
+	ErrUnsupported   = errors.New("msgpack: unsupported type")
+	ErrNonStringKey  = errors.New("msgpack: map keys must be strings")
+	ErrNotMap        = errors.New("msgpack: not a map")
+	ErrMaxDepth      = errors.New("msgpack: nesting too deep")
+	ErrTrailingBytes = errors.New("msgpack: trailing bytes after value")
+)
+
+// FromJSON re-encodes a JSON document as MessagePack. Integers stay
+// integers; object keys are written in sorted order.
+func FromJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(data))
+	return appendValue(buf, value)
+}
+
+// ToJSON decodes a single MessagePack value and encodes it as JSON. Binary
+// values become base64 strings, as encoding/json does for []byte.
+func ToJSON(data []byte) ([]byte, error) {
+	d := &decoder{data: data}
+	value, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, ErrTrailingBytes
+	}
+	return json.Marshal(value)
+}
+
+// WithInt adds an integer field to the front of an encoded map
+func WithInt(data []byte, key string, value int64) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrTruncated
+	}
+
+	var n, headerLen int
+	switch b := data[0]; {
+	case b >= 0x80 && b <= 0x8f:
+		n, headerLen = int(b&0x0f), 1
+	case b == 0xde:
+		if len(data) < 3 {
+			return nil, ErrTruncated
+		}
+		n, headerLen = int(binary.BigEndian.Uint16(data[1:])), 3
+	case b == 0xdf:
+		if len(data) < 5 {
+			return nil, ErrTruncated
+		}
+		n, headerLen = int(binary.BigEndian.Uint32(data[1:])), 5
+	default:
+		return nil, ErrNotMap
+	}
+
+	out := make([]byte, 0, len(data)+len(key)+16)
+	out = appendMapHeader(out, n+1)
+	out = appendString(out, key)
+	out = appendInt(out, value)
+	return append(out, data[headerLen:]...), nil
+}
+
+func appendValue(buf []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(buf, 0xc0), nil
+	case bool:
+		if v {
+			return append(buf, 0xc3), nil
+		}
+		return append(buf, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendInt(buf, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		return appendFloat(buf, f), nil
+	case string:
+		return appendString(buf, v), nil
+	case []any:
+		buf = appendArrayHeader(buf, len(v))
+		for _, item := range v {
+			var err error
+			if buf, err = appendValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf = appendMapHeader(buf, len(v))
+		for _, key := range keys {
+			buf = appendString(buf, key)
+			var err error
+			if buf, err = appendValue(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupported, value)
+}
+
+func appendInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(buf, byte(i))
+	case i < 0 && i >= -32:
+		return append(buf, byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		return append(buf, 0xcc, byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xcd), uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xce), uint32(i))
+	case i >= 0:
+		return binary.BigEndian.AppendUint64(append(buf, 0xcf), uint64(i))
+	case i >= math.MinInt8:
+		return append(buf, 0xd0, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xd1), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, 0xd3), uint64(i))
+}
+
+func appendFloat(buf []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(append(buf, 0xcb), math.Float64bits(f))
+}
+
+func appendString(buf []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		buf = append(buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		buf = append(buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		buf = binary.BigEndian.AppendUint16(append(buf, 0xda), uint16(n))
+	default:
+		buf = binary.BigEndian.AppendUint32(append(buf, 0xdb), uint32(n))
+	}
+	return append(buf, s...)
+}
+
+func appendArrayHeader(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, 0xdd), uint32(n))
+}
+
+func appendMapHeader(buf []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, 0xdf), uint32(n))
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) take(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, ErrTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.take(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, ErrMaxDepth
+	}
+	head, err := d.take(1)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b := head[0]; {
+	case b <= 0x7f:
+		return int64(b), nil
+	case b >= 0xe0:
+		return int64(int8(b)), nil
+	case b >= 0x80 && b <= 0x8f:
+		return d.mapValue(int(b&0x0f), depth)
+	case b >= 0x90 && b <= 0x9f:
+		return d.arrayValue(int(b&0x0f), depth)
+	case b >= 0xa0 && b <= 0xbf:
+		return d.str(int(b & 0x1f))
+	case b == 0xc0:
+		return nil, nil
+	case b == 0xc2:
+		return false, nil
+	case b == 0xc3:
+		return true, nil
+	case b == 0xc4, b == 0xc5, b == 0xc6:
+		n, err := d.uint(1 << (b - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.take(int(n))
+	case b == 0xca:
+		n, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(n))), nil
+	case b == 0xcb:
+		n, err := d.uint(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(n), nil
+	case b >= 0xcc && b <= 0xcf:
+		return d.uint(1 << (b - 0xcc))
+	case b >= 0xd0 && b <= 0xd3:
+		size := 1 << (b - 0xd0)
+		n, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		switch size {
+		case 1:
+			return int64(int8(n)), nil
+		case 2:
+			return int64(int16(n)), nil
+		case 4:
+			return int64(int32(n)), nil
+		}
+		return int64(n), nil
+	case b >= 0xd9 && b <= 0xdb:
+		n, err := d.uint(1 << (b - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(int(n))
+	case b == 0xdc, b == 0xdd:
+		n, err := d.uint(2 << (b - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayValue(int(n), depth)
+	case b == 0xde, b == 0xdf:
+		n, err := d.uint(2 << (b - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapValue(int(n), depth)
+	}
+	return nil, fmt.Errorf("%w: 0x%02x", ErrUnsupported, head[0])
+}
+
+func (d *decoder) str(n int) (string, error) {
+	b, err := d.take(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *decoder) arrayValue(n, depth int) (any, error) {
+	// Every element takes at least a byte, which bounds n by the input
+	if n > len(d.data)-d.pos {
+		return nil, ErrTruncated
+	}
+	items := make([]any, 0, n)
+	for i := 0; i < n; i++ {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (d *decoder) mapValue(n, depth int) (any, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, ErrTruncated
+	}
+	m := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := key.(string)
+		if !ok {
+			return nil, ErrNonStringKey
+		}
+		value, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[s] = value
+	}
+	return m, nil
+}
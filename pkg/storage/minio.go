@@ -54,6 +54,15 @@ func ConnectMinIO(cfg *config.Config) (*minio.Client, error) {
 			log.Info().Str("bucket", bucket).Msg("Created MinIO bucket")
 		}
 
+		// Signed links are the only way to private attachments, so any public
+		// policy left from before is removed
+		if bucket == cfg.Storage.BucketAttachments && cfg.Storage.AttachmentURLSecret != "" {
+			if err := client.SetBucketPolicy(ctx, bucket, ""); err != nil {
+				log.Warn().Err(err).Str("bucket", bucket).Msg("Failed to remove public policy from bucket")
+			}
+			continue
+		}
+
 		// Set public-read policy for all buckets by default for CDN access
 		policy := fmt.Sprintf(`{
 			"Version": "2012-10-17",